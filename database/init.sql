@@ -13,6 +13,7 @@ CREATE TABLE IF NOT EXISTS simulations (
     description TEXT,
     config JSONB NOT NULL DEFAULT '{}',
     status VARCHAR(50) NOT NULL DEFAULT 'created',
+    project_id VARCHAR(255),
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     started_at TIMESTAMP WITH TIME ZONE,
     ended_at TIMESTAMP WITH TIME ZONE,
@@ -141,6 +142,665 @@ CREATE TABLE IF NOT EXISTS system_logs (
     simulation_id UUID REFERENCES simulations(id) ON DELETE SET NULL
 );
 
+-- Tabela de webhooks (assinaturas de eventos de agentes e simulações)
+CREATE TABLE IF NOT EXISTS webhooks (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    url TEXT NOT NULL,
+    secret VARCHAR(255) NOT NULL,
+    event_types TEXT[] NOT NULL DEFAULT '{}',
+    event_versions TEXT[] NOT NULL DEFAULT '{}',
+    project_id VARCHAR(255),
+    active BOOLEAN NOT NULL DEFAULT true,
+    consecutive_failures INTEGER NOT NULL DEFAULT 0,
+    disabled_reason TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Tentativas de entrega de webhooks (uma linha por tentativa)
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    webhook_id UUID NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+    delivery_id UUID NOT NULL,
+    event_type VARCHAR(100) NOT NULL,
+    payload JSONB NOT NULL,
+    attempt INTEGER NOT NULL,
+    status_code INTEGER,
+    error TEXT,
+    duration_ms INTEGER,
+    success BOOLEAN NOT NULL DEFAULT false,
+    redelivery_of UUID,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Configurações de notificação por projeto (eventos, canais, horário de silêncio)
+CREATE TABLE IF NOT EXISTS notification_settings (
+    project_id VARCHAR(255) PRIMARY KEY,
+    enabled BOOLEAN NOT NULL DEFAULT true,
+    event_types TEXT[] NOT NULL DEFAULT '{}',
+    channels JSONB NOT NULL DEFAULT '[]',
+    quiet_hours JSONB,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Regras de alerta sobre métricas de agentes e simulações
+CREATE TABLE IF NOT EXISTS alert_rules (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    name VARCHAR(255) NOT NULL,
+    description TEXT,
+    expression TEXT NOT NULL,
+    operator VARCHAR(2) NOT NULL,
+    threshold DOUBLE PRECISION NOT NULL,
+    resolve_threshold DOUBLE PRECISION NOT NULL,
+    for_seconds DOUBLE PRECISION NOT NULL DEFAULT 0,
+    resolve_for_seconds DOUBLE PRECISION NOT NULL DEFAULT 0,
+    severity VARCHAR(20) NOT NULL DEFAULT 'warning',
+    simulation_id UUID REFERENCES simulations(id) ON DELETE CASCADE,
+    project_id VARCHAR(255),
+    enabled BOOLEAN NOT NULL DEFAULT true,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Estado de avaliação de cada regra (inactive, pending, firing)
+CREATE TABLE IF NOT EXISTS alert_states (
+    rule_id UUID PRIMARY KEY REFERENCES alert_rules(id) ON DELETE CASCADE,
+    state VARCHAR(20) NOT NULL,
+    value DOUBLE PRECISION,
+    pending_since TIMESTAMP WITH TIME ZONE,
+    firing_since TIMESTAMP WITH TIME ZONE,
+    clear_since TIMESTAMP WITH TIME ZONE,
+    resolved_at TIMESTAMP WITH TIME ZONE,
+    evaluated_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+-- Chaves de API (só o hash SHA-256; o valor é mostrado uma vez na criação)
+CREATE TABLE IF NOT EXISTS api_keys (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    name VARCHAR(255) NOT NULL,
+    hint VARCHAR(20) NOT NULL,
+    key_hash CHAR(64) NOT NULL UNIQUE,
+    roles TEXT[] NOT NULL,
+    projects TEXT[] NOT NULL DEFAULT '{}',
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
+
+-- Simulações arquivadas no armazenamento de objetos; os dados saem das demais tabelas
+CREATE TABLE IF NOT EXISTS simulation_archives (
+    simulation_id UUID PRIMARY KEY,
+    project_id VARCHAR(255),
+    name VARCHAR(255) NOT NULL,
+    status VARCHAR(50) NOT NULL,
+    ended_at TIMESTAMP WITH TIME ZONE,
+    object_key TEXT NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    row_count BIGINT NOT NULL,
+    archived_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Bancos criados antes das cotas por projeto: as simulações e os arquivos
+-- que já existiam ficam sem projeto
+ALTER TABLE simulations ADD COLUMN IF NOT EXISTS project_id VARCHAR(255);
+ALTER TABLE simulation_archives ADD COLUMN IF NOT EXISTS project_id VARCHAR(255);
+
+-- Execuções de uma ação em lote sobre vários agentes
+CREATE TABLE IF NOT EXISTS action_batches (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    action VARCHAR(255) NOT NULL,
+    priority VARCHAR(10) NOT NULL DEFAULT 'normal',
+    params JSONB NOT NULL DEFAULT '{}',
+    filter JSONB,
+    total INTEGER NOT NULL,
+    created_by VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    cancelled_at TIMESTAMP WITH TIME ZONE,
+    finished_at TIMESTAMP WITH TIME ZONE
+);
+
+-- Resultado por agente de uma execução em lote (pending, running, succeeded, failed, cancelled)
+CREATE TABLE IF NOT EXISTS action_batch_items (
+    batch_id UUID NOT NULL REFERENCES action_batches(id) ON DELETE CASCADE,
+    agent_id UUID NOT NULL,
+    position INTEGER NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    action_id VARCHAR(255),
+    result JSONB,
+    error TEXT,
+    started_at TIMESTAMP WITH TIME ZONE,
+    finished_at TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (batch_id, agent_id)
+);
+
+-- Execuções assíncronas de ações demoradas (queued, running, succeeded, failed, cancelled, timed_out).
+-- Particionada por dia de created_at (agent_actions_pAAAAMMDD, em UTC): a
+-- retenção descarta partições inteiras e as consultas de histórico sempre
+-- filtram por created_at. As partições são criadas por
+-- create_agent_actions_partitions, abaixo.
+CREATE TABLE IF NOT EXISTS agent_actions (
+    id UUID NOT NULL DEFAULT uuid_generate_v4(),
+    agent_id UUID NOT NULL,
+    project_id VARCHAR(255),
+    action VARCHAR(255) NOT NULL,
+    params JSONB NOT NULL DEFAULT '{}',
+    status VARCHAR(20) NOT NULL DEFAULT 'queued',
+    priority VARCHAR(10) NOT NULL DEFAULT 'normal',
+    attempt INTEGER NOT NULL DEFAULT 1,
+    max_attempts INTEGER NOT NULL DEFAULT 1,
+    retry_backoff_ms BIGINT NOT NULL DEFAULT 0,
+    timeout_ms BIGINT NOT NULL,
+    result_id VARCHAR(255),
+    result JSONB,
+    error TEXT,
+    reason TEXT,
+    created_by VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMP WITH TIME ZONE,
+    finished_at TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (id, created_at)
+) PARTITION BY RANGE (created_at);
+
+-- Execuções assíncronas em andamento: a réplica que executa, o último
+-- checkpoint e o batimento. Sem batimento, a execução é retomada ou
+-- encerrada por outra réplica (internal/action/recovery.go)
+CREATE TABLE IF NOT EXISTS agent_action_executions (
+    action_id UUID PRIMARY KEY,
+    action_created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    agent_id UUID NOT NULL,
+    executor VARCHAR(255) NOT NULL,
+    instance_id VARCHAR(255),
+    checkpoint JSONB,
+    resumes INTEGER NOT NULL DEFAULT 0,
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    heartbeat_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_agent_action_executions_heartbeat ON agent_action_executions(heartbeat_at);
+
+-- Contadores por agente e ação das execuções assíncronas encerradas,
+-- mantidos por trigger; sobrevivem ao descarte das partições
+CREATE TABLE IF NOT EXISTS agent_action_stats (
+    agent_id UUID NOT NULL,
+    action VARCHAR(255) NOT NULL,
+    total BIGINT NOT NULL DEFAULT 0,
+    succeeded BIGINT NOT NULL DEFAULT 0,
+    failed BIGINT NOT NULL DEFAULT 0,
+    cancelled BIGINT NOT NULL DEFAULT 0,
+    timed_out BIGINT NOT NULL DEFAULT 0,
+    duration_ms_sum BIGINT NOT NULL DEFAULT 0,
+    duration_ms_max BIGINT NOT NULL DEFAULT 0,
+    latency_ms_sum BIGINT NOT NULL DEFAULT 0,
+    latency_ms_max BIGINT NOT NULL DEFAULT 0,
+    last_finished_at TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (agent_id, action)
+);
+
+-- Amostras de posição dos agentes (trajetórias), gravadas em lote pelo
+-- gravador de trajetórias. Particionada por dia de recorded_at
+-- (agent_positions_pAAAAMMDD, em UTC) e mantida pela mesma retenção de
+-- agent_actions. As partições são criadas por
+-- create_agent_positions_partitions, abaixo.
+CREATE TABLE IF NOT EXISTS agent_positions (
+    agent_id UUID NOT NULL,
+    simulation_id UUID,
+    tick BIGINT NOT NULL DEFAULT 0,
+    lat DOUBLE PRECISION NOT NULL,
+    lon DOUBLE PRECISION NOT NULL,
+    heading DOUBLE PRECISION NOT NULL DEFAULT 0,
+    speed DOUBLE PRECISION NOT NULL DEFAULT 0,
+    recorded_at TIMESTAMP WITH TIME ZONE NOT NULL
+) PARTITION BY RANGE (recorded_at);
+
+CREATE INDEX IF NOT EXISTS idx_agent_positions_agent_recorded ON agent_positions (agent_id, recorded_at);
+-- Posições de uma simulação num tick passado, para o mapa de calor.
+CREATE INDEX IF NOT EXISTS idx_agent_positions_simulation_tick ON agent_positions (simulation_id, tick);
+
+-- Amostras de agent_positions reamostradas pela compactação das
+-- trajetórias: os dias mais antigos que trajectories.compaction.age saem de
+-- agent_positions e ficam aqui numa resolução menor. Particionada por dia
+-- como agent_positions, com retenção própria; as partições são criadas por
+-- create_agent_positions_compacted_partitions, abaixo.
+CREATE TABLE IF NOT EXISTS agent_positions_compacted (
+    agent_id UUID NOT NULL,
+    simulation_id UUID,
+    tick BIGINT NOT NULL DEFAULT 0,
+    lat DOUBLE PRECISION NOT NULL,
+    lon DOUBLE PRECISION NOT NULL,
+    heading DOUBLE PRECISION NOT NULL DEFAULT 0,
+    speed DOUBLE PRECISION NOT NULL DEFAULT 0,
+    recorded_at TIMESTAMP WITH TIME ZONE NOT NULL
+) PARTITION BY RANGE (recorded_at);
+
+CREATE INDEX IF NOT EXISTS idx_agent_positions_compacted_agent_recorded ON agent_positions_compacted (agent_id, recorded_at);
+CREATE INDEX IF NOT EXISTS idx_agent_positions_compacted_simulation_tick ON agent_positions_compacted (simulation_id, tick);
+
+-- Progresso da compactação, um registro por dia (UTC) de agent_positions.
+-- last_agent_id é o cursor: os agentes até ele já foram compactados, e a
+-- compactação interrompida continua do seguinte. method e os parâmetros
+-- são os do início do dia, para que ele fique todo na mesma resolução.
+CREATE TABLE IF NOT EXISTS trajectory_compactions (
+    day DATE PRIMARY KEY,
+    method VARCHAR(20) NOT NULL CHECK (method IN ('interval', 'rdp')),
+    resolution_ms BIGINT NOT NULL DEFAULT 0,
+    tolerance_m DOUBLE PRECISION NOT NULL DEFAULT 0,
+    status VARCHAR(20) NOT NULL DEFAULT 'running' CHECK (status IN ('running', 'done')),
+    last_agent_id UUID,
+    agents BIGINT NOT NULL DEFAULT 0,
+    samples_in BIGINT NOT NULL DEFAULT 0,
+    samples_out BIGINT NOT NULL DEFAULT 0,
+    started_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP WITH TIME ZONE
+);
+
+-- Partições de agent_actions e agent_positions arquivadas no armazenamento
+-- de objetos antes de serem descartadas
+CREATE TABLE IF NOT EXISTS agent_action_archives (
+    partition_name VARCHAR(63) PRIMARY KEY,
+    day DATE NOT NULL,
+    object_key TEXT NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    row_count BIGINT NOT NULL,
+    archived_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Ações agendadas dos agentes: uma vez em run_at ou recorrentes por cron.
+-- next_run_at é a próxima ocorrência ainda não disparada; nula quando não
+-- há mais nenhuma. created_by_roles e created_by_scopes são os do autor,
+-- conferidos contra a política das ações a cada disparo
+CREATE TABLE IF NOT EXISTS scheduled_actions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    agent_id UUID NOT NULL REFERENCES agents(id) ON DELETE CASCADE,
+    action VARCHAR(255) NOT NULL,
+    params JSONB NOT NULL DEFAULT '{}',
+    priority VARCHAR(10) NOT NULL DEFAULT 'normal',
+    run_at TIMESTAMP WITH TIME ZONE,
+    cron VARCHAR(255),
+    timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    next_run_at TIMESTAMP WITH TIME ZONE,
+    last_run_at TIMESTAMP WITH TIME ZONE,
+    last_action_id VARCHAR(255),
+    last_status VARCHAR(20),
+    last_error TEXT,
+    created_by VARCHAR(255),
+    created_by_roles TEXT[] NOT NULL DEFAULT '{}',
+    created_by_scopes TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CHECK ((run_at IS NULL) <> (cron IS NULL))
+);
+
+-- Bancos criados antes da política das ações. Os agendamentos que já
+-- existiam ficam sem os papéis do autor: os de ações restritas falham no
+-- disparo até serem recriados
+ALTER TABLE scheduled_actions ADD COLUMN IF NOT EXISTS created_by_roles TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE scheduled_actions ADD COLUMN IF NOT EXISTS created_by_scopes TEXT[] NOT NULL DEFAULT '{}';
+
+-- Amostras das métricas de desempenho dos agentes, declaradas por tipo
+-- de agente (agent_types.definitions) ou avulsas
+CREATE TABLE IF NOT EXISTS agent_metric_samples (
+    agent_id UUID NOT NULL REFERENCES agents(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    value DOUBLE PRECISION NOT NULL,
+    recorded_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+-- Dependências entre agentes: agent_id depende de depends_on. O grafo não
+-- tem ciclos; a API os recusa na escrita
+CREATE TABLE IF NOT EXISTS agent_dependencies (
+    agent_id UUID NOT NULL REFERENCES agents(id) ON DELETE CASCADE,
+    depends_on UUID NOT NULL REFERENCES agents(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (agent_id, depends_on),
+    CHECK (agent_id <> depends_on)
+);
+
+-- Agentes com um status de falha (dependencies.failure_statuses), as causas
+-- raiz dos prejuízos
+CREATE TABLE IF NOT EXISTS agent_failures (
+    agent_id UUID PRIMARY KEY REFERENCES agents(id) ON DELETE CASCADE,
+    status VARCHAR(50) NOT NULL,
+    since TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Agentes prejudicados por uma dependência com falha. path vai do agente
+-- até a causa raiz; root_cause_id não tem chave estrangeira para que a
+-- remoção da causa ainda encontre os prejuízos a recalcular
+CREATE TABLE IF NOT EXISTS agent_impairments (
+    agent_id UUID PRIMARY KEY REFERENCES agents(id) ON DELETE CASCADE,
+    root_cause_id UUID NOT NULL,
+    root_cause_status VARCHAR(50) NOT NULL,
+    path UUID[] NOT NULL,
+    since TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+-- Comportamento de cada agente (registro em internal/behavior), chamado a
+-- cada tick da simulação
+CREATE TABLE IF NOT EXISTS agent_behaviors (
+    agent_id UUID PRIMARY KEY REFERENCES agents(id) ON DELETE CASCADE,
+    behavior VARCHAR(100) NOT NULL,
+    params JSONB NOT NULL DEFAULT '{}',
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Capacidades declaradas de cada agente; sem linha, valem as padrão do tipo
+CREATE TABLE IF NOT EXISTS agent_capabilities (
+    agent_id UUID PRIMARY KEY REFERENCES agents(id) ON DELETE CASCADE,
+    capabilities TEXT[] NOT NULL DEFAULT '{}',
+    updated_by VARCHAR(255),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Limite próprio de reportes de telemetria por janela de cada agente; sem
+-- linha, vale o do tipo
+CREATE TABLE IF NOT EXISTS agent_ingestion_limits (
+    agent_id UUID PRIMARY KEY REFERENCES agents(id) ON DELETE CASCADE,
+    report_limit INTEGER NOT NULL CHECK (report_limit >= 0),
+    updated_by VARCHAR(255),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Registro dos tipos de agente; a criação de agentes só aceita tipos daqui
+CREATE TABLE IF NOT EXISTS agent_types (
+    name VARCHAR(100) PRIMARY KEY,
+    display_name VARCHAR(255) NOT NULL,
+    metrics JSONB NOT NULL DEFAULT '[]',
+    allowed_actions TEXT[] NOT NULL DEFAULT '{}',
+    config_schema TEXT,
+    default_behavior VARCHAR(100),
+    icon TEXT,
+    metadata JSONB NOT NULL DEFAULT '{}',
+    created_by VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Nomes antigos dos tipos renomeados; os agentes que ainda usam o nome
+-- antigo resolvem por aqui
+CREATE TABLE IF NOT EXISTS agent_type_aliases (
+    alias VARCHAR(100) PRIMARY KEY,
+    type_name VARCHAR(100) NOT NULL REFERENCES agent_types(name) ON UPDATE CASCADE ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_agent_type_aliases_type ON agent_type_aliases(type_name);
+
+-- Grupos de agentes de um projeto, com operações sobre todos os membros
+CREATE TABLE IF NOT EXISTS groups (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    project_id VARCHAR(255) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    description TEXT,
+    created_by VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (project_id, name)
+);
+
+-- Membros de cada grupo; um agente pode estar em vários grupos
+CREATE TABLE IF NOT EXISTS group_memberships (
+    group_id UUID NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    agent_id UUID NOT NULL REFERENCES agents(id) ON DELETE CASCADE,
+    added_by VARCHAR(255),
+    added_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (group_id, agent_id)
+);
+
+-- Consumo de energia das simulações por tipo de agente, em faixas de tempo
+CREATE TABLE IF NOT EXISTS simulation_consumption (
+    simulation_id UUID NOT NULL REFERENCES simulations(id) ON DELETE CASCADE,
+    agent_type VARCHAR(100) NOT NULL,
+    bucket TIMESTAMP WITH TIME ZONE NOT NULL,
+    modeled_wh DOUBLE PRECISION NOT NULL DEFAULT 0,
+    correction_wh DOUBLE PRECISION NOT NULL DEFAULT 0,
+    distance_km DOUBLE PRECISION NOT NULL DEFAULT 0,
+    PRIMARY KEY (simulation_id, agent_type, bucket)
+);
+
+-- Consumo acumulado de cada agente
+CREATE TABLE IF NOT EXISTS agent_consumption (
+    agent_id UUID PRIMARY KEY REFERENCES agents(id) ON DELETE CASCADE,
+    simulation_id UUID NOT NULL,
+    agent_type VARCHAR(100) NOT NULL,
+    modeled_wh DOUBLE PRECISION NOT NULL DEFAULT 0,
+    correction_wh DOUBLE PRECISION NOT NULL DEFAULT 0,
+    distance_km DOUBLE PRECISION NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Gêmeo digital de cada agente: o último estado reportado pelo gateway e o
+-- estado desejado pelos operadores, cada um com a sua versão
+CREATE TABLE IF NOT EXISTS agent_twins (
+    agent_id UUID PRIMARY KEY REFERENCES agents(id) ON DELETE CASCADE,
+    reported JSONB NOT NULL DEFAULT '{}',
+    reported_version BIGINT NOT NULL DEFAULT 0,
+    reported_at TIMESTAMP WITH TIME ZONE,
+    desired JSONB NOT NULL DEFAULT '{}',
+    desired_version BIGINT NOT NULL DEFAULT 0,
+    desired_at TIMESTAMP WITH TIME ZONE,
+    desired_by VARCHAR(255)
+);
+
+-- Revisões da configuração de cada agente (nome, metadados e tags), com o
+-- documento inteiro; rollback_of é a revisão reaplicada por um rollback
+CREATE TABLE IF NOT EXISTS agent_config_revisions (
+    agent_id UUID NOT NULL REFERENCES agents(id) ON DELETE CASCADE,
+    revision BIGINT NOT NULL,
+    document JSONB NOT NULL,
+    author VARCHAR(255),
+    summary TEXT NOT NULL DEFAULT '',
+    rollback_of BIGINT,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (agent_id, revision)
+);
+
+-- Estado inicial de cada agente (do início da simulação ou da criação),
+-- aplicado por POST /simulations/:id/agents/reset
+CREATE TABLE IF NOT EXISTS agent_initial_states (
+    agent_id UUID PRIMARY KEY REFERENCES agents(id) ON DELETE CASCADE,
+    status VARCHAR(50) NOT NULL,
+    lat DOUBLE PRECISION NOT NULL DEFAULT 0,
+    lon DOUBLE PRECISION NOT NULL DEFAULT 0,
+    heading DOUBLE PRECISION NOT NULL DEFAULT 0,
+    speed DOUBLE PRECISION NOT NULL DEFAULT 0,
+    state JSONB NOT NULL DEFAULT '{}',
+    captured_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Última atualização de cada view materializada dos agregados do painel,
+-- gravada pelo agent-service a cada REFRESH
+CREATE TABLE IF NOT EXISTS rollup_refreshes (
+    view_name VARCHAR(100) PRIMARY KEY,
+    refreshed_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    duration_ms INTEGER NOT NULL
+);
+
+-- Uso de armazenamento por projeto, mantido pelo agent-service a partir
+-- dos eventos e recalculado pela reconciliação periódica; exceeded são os
+-- recursos com estouro de cota já registrado
+CREATE TABLE IF NOT EXISTS project_usage (
+    project_id VARCHAR(255) PRIMARY KEY,
+    agents BIGINT NOT NULL DEFAULT 0,
+    event_rows BIGINT NOT NULL DEFAULT 0,
+    checkpoint_bytes BIGINT NOT NULL DEFAULT 0,
+    archive_bytes BIGINT NOT NULL DEFAULT 0,
+    exceeded TEXT[] NOT NULL DEFAULT '{}',
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    reconciled_at TIMESTAMP WITH TIME ZONE
+);
+
+-- Cotas próprias dos projetos; colunas nulas usam quotas.defaults e 0 é
+-- sem limite
+CREATE TABLE IF NOT EXISTS project_quotas (
+    project_id VARCHAR(255) PRIMARY KEY,
+    agents BIGINT,
+    event_rows BIGINT,
+    checkpoint_bytes BIGINT,
+    archive_bytes BIGINT,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_by VARCHAR(255)
+);
+
+-- Limites próprios dos projetos (internal/projectsettings); colunas nulas
+-- usam project_limits.defaults e 0 é sem limite. allowed_origins nulo
+-- também usa o padrão; vazio aceita as origens de server.cors.
+-- action_roles restringe ações a papéis: {"emergency_stop": ["admin"]}
+CREATE TABLE IF NOT EXISTS project_settings (
+    project_id VARCHAR(255) PRIMARY KEY,
+    requests_per_minute INTEGER,
+    request_timeout_ms BIGINT,
+    export_max_bytes BIGINT,
+    allowed_origins TEXT[],
+    action_roles JSONB,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_by VARCHAR(255)
+);
+
+-- Bancos criados antes da política das ações
+ALTER TABLE project_settings ADD COLUMN IF NOT EXISTS action_roles JSONB;
+
+-- Backups dos projetos; o arquivo fica no armazenamento de objetos em
+-- object_key e counts traz as linhas de cada tipo de entidade
+CREATE TABLE IF NOT EXISTS project_backups (
+    id UUID PRIMARY KEY,
+    project_id VARCHAR(255) NOT NULL,
+    object_key TEXT NOT NULL,
+    format_version INTEGER NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    counts JSONB NOT NULL DEFAULT '{}',
+    created_by VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Hooks síncronos dos projetos, consultados antes de criar ou remover
+-- agentes; fail_mode diz se um hook inacessível libera (open) ou recusa
+-- (closed) a operação
+CREATE TABLE IF NOT EXISTS sync_hooks (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    project_id VARCHAR(255) NOT NULL,
+    url TEXT NOT NULL,
+    secret VARCHAR(255) NOT NULL,
+    operations TEXT[] NOT NULL DEFAULT '{}',
+    timeout_ms INTEGER NOT NULL DEFAULT 0,
+    fail_mode VARCHAR(10) NOT NULL DEFAULT 'closed' CHECK (fail_mode IN ('open', 'closed')),
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Falhas injetadas nas simulações (internal/fault); affected guarda os
+-- agentes postos offline com o status de antes, nulo até a escolha deles
+CREATE TABLE IF NOT EXISTS simulation_faults (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    simulation_id UUID NOT NULL REFERENCES simulations(id) ON DELETE CASCADE,
+    kind VARCHAR(30) NOT NULL,
+    percent DOUBLE PRECISION NOT NULL DEFAULT 0,
+    latency_ms INTEGER NOT NULL DEFAULT 0,
+    agents INTEGER NOT NULL DEFAULT 0,
+    jitter_m DOUBLE PRECISION NOT NULL DEFAULT 0,
+    affected JSONB,
+    status VARCHAR(20) NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'expired', 'cancelled')),
+    created_by VARCHAR(255),
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    ended_at TIMESTAMP WITH TIME ZONE
+);
+
+-- Valores dos feature flags (internal/featureflag) que substituem o padrão
+-- do código: do ambiente inteiro com project_id vazio, ou de um projeto.
+-- roles vazio libera a todos; senão, só aos principais com um dos papéis
+CREATE TABLE IF NOT EXISTS feature_flag_overrides (
+    flag VARCHAR(100) NOT NULL,
+    project_id VARCHAR(255) NOT NULL DEFAULT '',
+    enabled BOOLEAN NOT NULL,
+    roles TEXT[] NOT NULL DEFAULT '{}',
+    updated_by VARCHAR(255),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (flag, project_id)
+);
+
+-- KPIs das simulações (internal/kpi): expressão sobre métricas de agentes e
+-- eventos, meta e direção. Os valores e o histórico ficam no Redis
+CREATE TABLE IF NOT EXISTS simulation_kpis (
+    simulation_id UUID NOT NULL REFERENCES simulations(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    expression TEXT NOT NULL,
+    target DOUBLE PRECISION NOT NULL,
+    direction VARCHAR(10) NOT NULL DEFAULT 'maximize' CHECK (direction IN ('maximize', 'minimize')),
+    created_by VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (simulation_id, name)
+);
+
+-- Exclusões de simulações em segundo plano (internal/deletion). progress
+-- traz, por etapa, as linhas apagadas e as encontradas no início dela; o
+-- job interrompido continua da primeira etapa não concluída. simulation_id
+-- não referencia simulations: o job fica depois que a simulação sai
+CREATE TABLE IF NOT EXISTS simulation_deletions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    simulation_id UUID NOT NULL,
+    project_id VARCHAR(255),
+    status VARCHAR(20) NOT NULL DEFAULT 'running' CHECK (status IN ('running', 'succeeded')),
+    progress JSONB NOT NULL DEFAULT '[]',
+    deleted_rows BIGINT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    requested_by VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP WITH TIME ZONE
+);
+
+-- Um job em andamento por simulação; repetir o pedido retorna o mesmo
+CREATE UNIQUE INDEX IF NOT EXISTS idx_simulation_deletions_active ON simulation_deletions (simulation_id) WHERE status = 'running';
+
+-- Transferências de agentes entre projetos (internal/transfer). A pendente
+-- espera a aprovação de um administrador do projeto de destino até
+-- expires_at; a aprovação move o agente e a linha fica como registro dos
+-- dois projetos. Sem chave estrangeira para agents: o registro fica depois
+-- que o agente sai
+CREATE TABLE IF NOT EXISTS agent_project_transfers (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    agent_id UUID NOT NULL,
+    agent_type VARCHAR(100) NOT NULL,
+    source_project_id VARCHAR(255) NOT NULL,
+    target_project_id VARCHAR(255) NOT NULL,
+    source_simulation_id UUID NOT NULL,
+    target_simulation_id UUID NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'rejected', 'expired')),
+    force BOOLEAN NOT NULL DEFAULT FALSE,
+    paused BOOLEAN NOT NULL DEFAULT FALSE,
+    requested_by VARCHAR(255),
+    decided_by VARCHAR(255),
+    reason TEXT,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    decided_at TIMESTAMP WITH TIME ZONE
+);
+
+-- Uma transferência pendente por agente
+CREATE UNIQUE INDEX IF NOT EXISTS idx_agent_project_transfers_pending ON agent_project_transfers (agent_id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_agent_project_transfers_agent ON agent_project_transfers (agent_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_agent_project_transfers_source ON agent_project_transfers (source_project_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_agent_project_transfers_target ON agent_project_transfers (target_project_id, created_at DESC);
+
+-- Relatório de falha de cada simulação (internal/failure), o da última
+-- falha. report traz o relatório em JSON; o maior que
+-- failures.max_report_bytes fica no armazenamento de objetos, em object_key
+CREATE TABLE IF NOT EXISTS simulation_failures (
+    simulation_id UUID PRIMARY KEY REFERENCES simulations(id) ON DELETE CASCADE,
+    project_id VARCHAR(255),
+    summary JSONB NOT NULL,
+    report JSONB,
+    object_key TEXT,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CHECK ((report IS NULL) <> (object_key IS NULL))
+);
+
 -- Índices para performance
 CREATE INDEX IF NOT EXISTS idx_simulations_status ON simulations(status);
 CREATE INDEX IF NOT EXISTS idx_simulations_created_at ON simulations(created_at);
@@ -160,6 +820,28 @@ CREATE INDEX IF NOT EXISTS idx_collective_learning_timestamp ON collective_learn
 CREATE INDEX IF NOT EXISTS idx_system_logs_timestamp ON system_logs(timestamp);
 CREATE INDEX IF NOT EXISTS idx_system_logs_level ON system_logs(level);
 
+CREATE INDEX IF NOT EXISTS idx_webhooks_active ON webhooks(active);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook_id ON webhook_deliveries(webhook_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_delivery_id ON webhook_deliveries(delivery_id);
+CREATE INDEX IF NOT EXISTS idx_alert_rules_project_id ON alert_rules(project_id);
+CREATE INDEX IF NOT EXISTS idx_metrics_simulation_name_timestamp ON metrics(simulation_id, metric_name, timestamp DESC);
+CREATE INDEX IF NOT EXISTS idx_simulations_ended_at ON simulations(ended_at);
+CREATE INDEX IF NOT EXISTS idx_simulations_project_id ON simulations(project_id);
+CREATE INDEX IF NOT EXISTS idx_simulation_archives_project_id ON simulation_archives(project_id);
+CREATE INDEX IF NOT EXISTS idx_project_backups_project_id ON project_backups(project_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_sync_hooks_project_id ON sync_hooks(project_id);
+CREATE INDEX IF NOT EXISTS idx_simulation_faults_simulation_id ON simulation_faults(simulation_id, started_at DESC);
+CREATE INDEX IF NOT EXISTS idx_simulation_faults_active ON simulation_faults(started_at) WHERE status = 'active';
+CREATE INDEX IF NOT EXISTS idx_action_batches_created_at ON action_batches(created_at);
+CREATE INDEX IF NOT EXISTS idx_agent_actions_agent_id ON agent_actions(agent_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_agent_actions_status ON agent_actions(status, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_scheduled_actions_agent_id ON scheduled_actions(agent_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_scheduled_actions_due ON scheduled_actions(next_run_at) WHERE enabled;
+CREATE INDEX IF NOT EXISTS idx_agent_metric_samples_agent ON agent_metric_samples(agent_id, name, recorded_at DESC);
+CREATE INDEX IF NOT EXISTS idx_agent_dependencies_depends_on ON agent_dependencies(depends_on);
+CREATE INDEX IF NOT EXISTS idx_group_memberships_agent_id ON group_memberships(agent_id);
+CREATE INDEX IF NOT EXISTS idx_agent_impairments_path ON agent_impairments USING GIN(path);
+
 -- Índices GIN para busca em JSONB
 CREATE INDEX IF NOT EXISTS idx_simulations_config_gin ON simulations USING GIN(config);
 CREATE INDEX IF NOT EXISTS idx_agents_state_gin ON agents USING GIN(state);
@@ -182,6 +864,121 @@ CREATE TRIGGER update_agents_updated_at
     FOR EACH ROW 
     EXECUTE FUNCTION update_updated_at_column();
 
+CREATE TRIGGER update_webhooks_updated_at
+    BEFORE UPDATE ON webhooks
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+CREATE TRIGGER update_notification_settings_updated_at
+    BEFORE UPDATE ON notification_settings
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+CREATE TRIGGER update_alert_rules_updated_at
+    BEFORE UPDATE ON alert_rules
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+-- Cria as partições diárias de agent_actions de first_day em diante, se
+-- ainda não existirem. Os limites são meia-noite UTC.
+CREATE OR REPLACE FUNCTION create_agent_actions_partitions(first_day DATE, days INTEGER)
+RETURNS void AS $$
+DECLARE
+    d DATE;
+BEGIN
+    FOR i IN 0..days - 1 LOOP
+        d := first_day + i;
+        EXECUTE format(
+            'CREATE TABLE IF NOT EXISTS %I PARTITION OF agent_actions FOR VALUES FROM (%L) TO (%L)',
+            'agent_actions_p' || to_char(d, 'YYYYMMDD'),
+            d::timestamp AT TIME ZONE 'UTC',
+            (d + 1)::timestamp AT TIME ZONE 'UTC');
+    END LOOP;
+END;
+$$ LANGUAGE plpgsql;
+
+SELECT create_agent_actions_partitions((CURRENT_TIMESTAMP AT TIME ZONE 'UTC')::date, 8);
+
+-- Cria as partições diárias de agent_positions, como
+-- create_agent_actions_partitions.
+CREATE OR REPLACE FUNCTION create_agent_positions_partitions(first_day DATE, days INTEGER)
+RETURNS void AS $$
+DECLARE
+    d DATE;
+BEGIN
+    FOR i IN 0..days - 1 LOOP
+        d := first_day + i;
+        EXECUTE format(
+            'CREATE TABLE IF NOT EXISTS %I PARTITION OF agent_positions FOR VALUES FROM (%L) TO (%L)',
+            'agent_positions_p' || to_char(d, 'YYYYMMDD'),
+            d::timestamp AT TIME ZONE 'UTC',
+            (d + 1)::timestamp AT TIME ZONE 'UTC');
+    END LOOP;
+END;
+$$ LANGUAGE plpgsql;
+
+SELECT create_agent_positions_partitions((CURRENT_TIMESTAMP AT TIME ZONE 'UTC')::date, 8);
+
+-- Cria as partições diárias de agent_positions_compacted, como
+-- create_agent_actions_partitions. A compactação cria a do dia que
+-- compacta, que pode ser anterior às criadas pela retenção.
+CREATE OR REPLACE FUNCTION create_agent_positions_compacted_partitions(first_day DATE, days INTEGER)
+RETURNS void AS $$
+DECLARE
+    d DATE;
+BEGIN
+    FOR i IN 0..days - 1 LOOP
+        d := first_day + i;
+        EXECUTE format(
+            'CREATE TABLE IF NOT EXISTS %I PARTITION OF agent_positions_compacted FOR VALUES FROM (%L) TO (%L)',
+            'agent_positions_compacted_p' || to_char(d, 'YYYYMMDD'),
+            d::timestamp AT TIME ZONE 'UTC',
+            (d + 1)::timestamp AT TIME ZONE 'UTC');
+    END LOOP;
+END;
+$$ LANGUAGE plpgsql;
+
+SELECT create_agent_positions_compacted_partitions((CURRENT_TIMESTAMP AT TIME ZONE 'UTC')::date, 8);
+
+-- Atualiza agent_action_stats quando uma execução chega a um estado final
+CREATE OR REPLACE FUNCTION record_agent_action_stats()
+RETURNS TRIGGER AS $$
+DECLARE
+    duration_ms BIGINT;
+    latency_ms BIGINT;
+BEGIN
+    IF NEW.status NOT IN ('succeeded', 'failed', 'cancelled', 'timed_out')
+        OR (TG_OP = 'UPDATE' AND OLD.status IN ('succeeded', 'failed', 'cancelled', 'timed_out')) THEN
+        RETURN NULL;
+    END IF;
+    duration_ms := COALESCE(EXTRACT(EPOCH FROM (NEW.finished_at - NEW.started_at)) * 1000, 0)::BIGINT;
+    latency_ms := COALESCE(EXTRACT(EPOCH FROM (NEW.finished_at - NEW.created_at)) * 1000, 0)::BIGINT;
+    INSERT INTO agent_action_stats AS s (agent_id, action, total, succeeded, failed, cancelled, timed_out,
+        duration_ms_sum, duration_ms_max, latency_ms_sum, latency_ms_max, last_finished_at)
+    VALUES (NEW.agent_id, NEW.action, 1,
+        (NEW.status = 'succeeded')::int, (NEW.status = 'failed')::int,
+        (NEW.status = 'cancelled')::int, (NEW.status = 'timed_out')::int,
+        duration_ms, duration_ms, latency_ms, latency_ms, NEW.finished_at)
+    ON CONFLICT (agent_id, action) DO UPDATE SET
+        total = s.total + 1,
+        succeeded = s.succeeded + EXCLUDED.succeeded,
+        failed = s.failed + EXCLUDED.failed,
+        cancelled = s.cancelled + EXCLUDED.cancelled,
+        timed_out = s.timed_out + EXCLUDED.timed_out,
+        duration_ms_sum = s.duration_ms_sum + EXCLUDED.duration_ms_sum,
+        duration_ms_max = GREATEST(s.duration_ms_max, EXCLUDED.duration_ms_max),
+        latency_ms_sum = s.latency_ms_sum + EXCLUDED.latency_ms_sum,
+        latency_ms_max = GREATEST(s.latency_ms_max, EXCLUDED.latency_ms_max),
+        last_finished_at = GREATEST(s.last_finished_at, EXCLUDED.last_finished_at);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER record_agent_action_stats
+    AFTER INSERT OR UPDATE OF status ON agent_actions
+    FOR EACH ROW
+    EXECUTE FUNCTION record_agent_action_stats();
+
 -- Função para limpeza automática de dados antigos
 CREATE OR REPLACE FUNCTION cleanup_old_data()
 RETURNS void AS $$
@@ -201,6 +998,21 @@ BEGIN
     -- Remover interações antigas (mais de 60 dias)
     DELETE FROM interactions 
     WHERE timestamp < CURRENT_TIMESTAMP - INTERVAL '60 days';
+
+    -- Remover tentativas de entrega de webhooks antigas (mais de 30 dias)
+    DELETE FROM webhook_deliveries
+    WHERE created_at < CURRENT_TIMESTAMP - INTERVAL '30 days';
+
+    -- Remover execuções em lote concluídas (mais de 30 dias)
+    DELETE FROM action_batches
+    WHERE finished_at < CURRENT_TIMESTAMP - INTERVAL '30 days';
+
+    -- Remover amostras de métricas de agentes antigas (mais de 30 dias)
+    DELETE FROM agent_metric_samples
+    WHERE recorded_at < CURRENT_TIMESTAMP - INTERVAL '30 days';
+
+    -- agent_actions não entra aqui: a retenção do agent-service descarta
+    -- partições inteiras (actions.retention.*)
 END;
 $$ LANGUAGE plpgsql;
 
@@ -247,6 +1059,60 @@ FROM agents a
 LEFT JOIN interactions i ON a.id = i.agent_from OR a.id = i.agent_to
 GROUP BY a.id, a.simulation_id, a.agent_type, a.name, a.energy, a.performance_metrics;
 
+-- Agregados do painel. As views *_live calculam na hora; as materializadas
+-- guardam o último cálculo e são atualizadas pelo agent-service com REFRESH
+-- MATERIALIZED VIEW CONCURRENTLY (rollups.refresh_interval), que exige o
+-- índice único de cada uma.
+
+-- Agentes por distrito (state->>'district') e tipo
+CREATE OR REPLACE VIEW agents_by_district_type_live AS
+SELECT
+    a.simulation_id,
+    COALESCE(NULLIF(a.state->>'district', ''), 'unassigned') as district,
+    a.agent_type,
+    COUNT(*) as total_agents,
+    COUNT(*) FILTER (WHERE a.is_active) as active_agents,
+    AVG(a.energy)::float8 as avg_energy
+FROM agents a
+GROUP BY 1, 2, 3;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS agents_by_district_type AS
+SELECT * FROM agents_by_district_type_live;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_agents_by_district_type_key
+    ON agents_by_district_type(simulation_id, district, agent_type);
+
+-- Resumo diário (UTC) de cada simulação
+CREATE OR REPLACE VIEW simulation_daily_summary_live AS
+SELECT
+    simulation_id,
+    day,
+    SUM(events)::bigint as total_events,
+    SUM(interactions)::bigint as total_interactions,
+    SUM(successful_interactions)::bigint as successful_interactions,
+    (SUM(metric_sum) / NULLIF(SUM(metric_count), 0))::float8 as avg_metric_value
+FROM (
+    SELECT simulation_id, (timestamp AT TIME ZONE 'UTC')::date as day,
+        COUNT(*) as events, 0 as interactions, 0 as successful_interactions,
+        0 as metric_sum, 0 as metric_count
+    FROM events WHERE timestamp IS NOT NULL GROUP BY 1, 2
+    UNION ALL
+    SELECT simulation_id, (timestamp AT TIME ZONE 'UTC')::date,
+        0, COUNT(*), COUNT(*) FILTER (WHERE success), 0, 0
+    FROM interactions WHERE timestamp IS NOT NULL GROUP BY 1, 2
+    UNION ALL
+    SELECT simulation_id, (timestamp AT TIME ZONE 'UTC')::date,
+        0, 0, 0, SUM(value), COUNT(*)
+    FROM metrics WHERE timestamp IS NOT NULL GROUP BY 1, 2
+) d
+GROUP BY simulation_id, day;
+
+CREATE MATERIALIZED VIEW IF NOT EXISTS simulation_daily_summary AS
+SELECT * FROM simulation_daily_summary_live;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_simulation_daily_summary_key
+    ON simulation_daily_summary(simulation_id, day);
+
 -- Inserir dados iniciais
 INSERT INTO scenarios (name, description, scenario_type, config) VALUES
 ('Crise Energética', 'Simula uma crise de energia na cidade', 'crisis', '{"energy_shortage": 0.5, "duration": 3600}'),
@@ -0,0 +1,662 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/agenttype"
+	"smart-city-microservices/internal/apikey"
+	"smart-city-microservices/internal/archive"
+	"smart-city-microservices/internal/buildinfo"
+	"smart-city-microservices/internal/checkpoint"
+	"smart-city-microservices/internal/config"
+	"smart-city-microservices/internal/conformance"
+	"smart-city-microservices/internal/database"
+	"smart-city-microservices/internal/instrument"
+	"smart-city-microservices/internal/loadtest"
+	"smart-city-microservices/internal/logging"
+	"smart-city-microservices/internal/outbound"
+	"smart-city-microservices/internal/quota"
+	"smart-city-microservices/internal/redis"
+	"smart-city-microservices/internal/secrets"
+	"smart-city-microservices/internal/seed"
+)
+
+// newRootCommand monta a CLI. Sem subcomando o binário sobe o servidor,
+// como antes; os subcomandos administrativos usam a mesma configuração
+// (--config, flags e SMARTCITY_*) sem abrir nenhum listener.
+func newRootCommand() *cobra.Command {
+	flags := config.NewFlagSet("agent-service")
+	runServe := func(*cobra.Command, []string) error { return serve(flags) }
+
+	root := &cobra.Command{
+		Use:           "agent-service",
+		Short:         "Serviço de agentes da simulação de cidade inteligente",
+		Version:       buildinfo.Version,
+		Args:          cobra.NoArgs,
+		RunE:          runServe,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	root.PersistentFlags().AddFlagSet(flags)
+	root.AddCommand(
+		&cobra.Command{
+			Use:   "serve",
+			Short: "Inicia o servidor (padrão sem subcomando)",
+			Args:  cobra.NoArgs,
+			RunE:  runServe,
+		},
+		migrateCommand(flags),
+		apikeyCommand(flags),
+		simulationCommand(flags),
+		agentCommand(flags),
+		seedCommand(flags),
+		checkpointCommand(flags),
+		loadtestCommand(),
+		conformanceCommand(),
+	)
+	return root
+}
+
+// loadConfig carrega a configuração com a precedência do servidor (flag >
+// env SMARTCITY_* > arquivo > padrão), resolve os segredos e valida tudo,
+// reportando todos os problemas de uma vez.
+func loadConfig(flags *pflag.FlagSet) (*config.Config, *secrets.Manager, *outbound.Pool, error) {
+	config.Setup(viper.GetViper(), flags)
+	if err := viper.ReadInConfig(); err != nil {
+		logrus.Warn("Arquivo de configuração não encontrado, usando padrões")
+	}
+
+	// Resolver segredos (*_file e vault:) antes de qualquer conexão
+	secretManager, pool, err := setupSecrets(context.Background())
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("falha ao carregar segredos: %w", err)
+	}
+	cfg, err := config.Parse(viper.GetViper())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := logging.Configure(cfg.Log.Level, cfg.Log.Format); err != nil {
+		return nil, nil, nil, fmt.Errorf("falha ao configurar logging: %w", err)
+	}
+	return cfg, secretManager, pool, nil
+}
+
+// outboundPool cria o pool das chamadas HTTP de saída.
+func outboundPool(cfg config.OutboundConfig) (*outbound.Pool, error) {
+	return outbound.NewPool(outbound.Config{
+		MaxIdleConns:          cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       cfg.MaxConnsPerHost,
+		IdleConnTimeout:       cfg.IdleConnTimeout,
+		DialTimeout:           cfg.DialTimeout,
+		TLSHandshakeTimeout:   cfg.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: cfg.ResponseHeaderTimeout,
+		ProxyURL:              cfg.ProxyURL,
+		MaxAttempts:           cfg.Retry.MaxAttempts,
+		RetryBackoff:          cfg.Retry.InitialBackoff,
+		RetryMaxBackoff:       cfg.Retry.MaxBackoff,
+		BreakerFailures:       cfg.Breaker.FailureThreshold,
+		BreakerCooldown:       cfg.Breaker.Cooldown,
+	})
+}
+
+// databaseConfig converte a configuração tipada na do pacote database.
+func databaseConfig(cfg config.DatabaseConfig) database.Config {
+	return database.Config{
+		Host:        cfg.Host,
+		Port:        cfg.Port,
+		User:        cfg.User,
+		Password:    cfg.Password,
+		DBName:      cfg.Name,
+		SSLMode:     cfg.SSLMode,
+		SSLRootCert: cfg.SSLRootCert,
+		SSLCert:     cfg.SSLCert,
+		SSLKey:      cfg.SSLKey,
+	}
+}
+
+// connectRedis conecta ao Redis configurado, com TLS se habilitado, e conta
+// os comandos de cada requisição (instrument.Stats).
+func connectRedis(cfg config.RedisConfig) (*goredis.Client, error) {
+	tlsConfig, err := clientTLS(cfg.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("falha ao configurar TLS do Redis: %w", err)
+	}
+	client, err := redis.Connect(redis.Config{
+		Host:      cfg.Host,
+		Port:      cfg.Port,
+		Password:  cfg.Password,
+		TLSConfig: tlsConfig,
+	})
+	if err != nil {
+		return nil, err
+	}
+	client.AddHook(instrument.RedisHook{})
+	return client, nil
+}
+
+// withDB carrega a configuração, conecta ao banco e executa fn. O contexto
+// é cancelado em SIGINT/SIGTERM.
+func withDB(flags *pflag.FlagSet, fn func(ctx context.Context, cfg *config.Config, db *sql.DB) error) error {
+	cfg, _, _, err := loadConfig(flags)
+	if err != nil {
+		return err
+	}
+	db, err := database.Connect(databaseConfig(cfg.Database))
+	if err != nil {
+		return fmt.Errorf("falha ao conectar ao banco de dados: %w", err)
+	}
+	defer db.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	return fn(ctx, cfg, db)
+}
+
+func migrateCommand(flags *pflag.FlagSet) *cobra.Command {
+	return &cobra.Command{
+		Use:   "migrate",
+		Short: "Aplica as migrações pendentes do banco e encerra",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return withDB(flags, func(_ context.Context, _ *config.Config, db *sql.DB) error {
+				if err := database.RunMigrations(db); err != nil {
+					return fmt.Errorf("falha ao executar migrações: %w", err)
+				}
+				fmt.Fprintln(cmd.OutOrStdout(), "migrações aplicadas")
+				return nil
+			})
+		},
+	}
+}
+
+func apikeyCommand(flags *pflag.FlagSet) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "apikey",
+		Short: "Cria e revoga chaves de API",
+	}
+
+	var name string
+	var roles, projects, scopes []string
+	var expiresIn time.Duration
+	create := &cobra.Command{
+		Use:   "create",
+		Short: "Cria uma chave de API e mostra o valor, que não é guardado",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if expiresIn < 0 {
+				return errors.New("--expires-in não pode ser negativo")
+			}
+			return withDB(flags, func(ctx context.Context, _ *config.Config, db *sql.DB) error {
+				k := &apikey.Key{Name: name, Roles: roles, Projects: projects, Scopes: scopes}
+				if expiresIn > 0 {
+					t := time.Now().Add(expiresIn).UTC()
+					k.ExpiresAt = &t
+				}
+				secret, err := apikey.NewRepository(db).Create(ctx, k)
+				if err != nil {
+					return fmt.Errorf("falha ao criar a chave: %w", err)
+				}
+				out := cmd.OutOrStdout()
+				fmt.Fprintf(out, "id:    %s\n", k.ID)
+				fmt.Fprintf(out, "chave: %s\n", secret)
+				fmt.Fprintln(cmd.ErrOrStderr(), "Guarde a chave agora: ela não pode ser consultada depois.")
+				return nil
+			})
+		},
+	}
+	create.Flags().StringVar(&name, "name", "", "nome da chave (obrigatório)")
+	create.Flags().StringSliceVar(&roles, "role", []string{"viewer"}, "papéis da chave (admin, operator, viewer)")
+	create.Flags().StringSliceVar(&projects, "project", nil, "projetos a que a chave se restringe")
+	create.Flags().StringSliceVar(&scopes, "scope", nil, "escopos da chave de serviço, ex.: broadcast")
+	create.Flags().DurationVar(&expiresIn, "expires-in", 0, "validade da chave, ex.: 720h (0 não expira)")
+	create.MarkFlagRequired("name")
+
+	revoke := &cobra.Command{
+		Use:   "revoke <id>",
+		Short: "Revoga uma chave de API",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withDB(flags, func(ctx context.Context, _ *config.Config, db *sql.DB) error {
+				err := apikey.NewRepository(db).Revoke(ctx, args[0])
+				if errors.Is(err, apikey.ErrNotFound) {
+					return fmt.Errorf("chave %s não encontrada ou já revogada", args[0])
+				}
+				if err != nil {
+					return fmt.Errorf("falha ao revogar a chave: %w", err)
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "chave %s revogada\n", args[0])
+				return nil
+			})
+		},
+	}
+
+	cmd.AddCommand(create, revoke)
+	return cmd
+}
+
+func simulationCommand(flags *pflag.FlagSet) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "simulation",
+		Short: "Operações sobre simulações",
+	}
+
+	var olderThan time.Duration
+	var dryRun bool
+	archiveCmd := &cobra.Command{
+		Use:   "archive",
+		Short: "Move simulações encerradas para o armazenamento de objetos",
+		Long: `Grava cada simulação encerrada há mais de --older-than, com as linhas de
+todas as tabelas que a referenciam, em archives/simulations/<id>.jsonl.gz no
+armazenamento configurado (storage.*) e então a remove do banco, deixando o
+registro em simulation_archives.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if olderThan <= 0 {
+				return errors.New("--older-than deve ser positivo, ex.: 720h")
+			}
+			return withDB(flags, func(ctx context.Context, cfg *config.Config, db *sql.DB) error {
+				store, err := objectStorage(cfg.Storage)
+				if err != nil {
+					return fmt.Errorf("falha ao configurar o armazenamento de objetos: %w", err)
+				}
+				archiver := archive.New(db, store)
+				usage := quota.NewRepository(db)
+				sims, err := archiver.Candidates(ctx, time.Now().Add(-olderThan))
+				if err != nil {
+					return fmt.Errorf("falha ao listar simulações: %w", err)
+				}
+
+				out := cmd.OutOrStdout()
+				failed := 0
+				for _, sim := range sims {
+					if dryRun {
+						fmt.Fprintf(out, "%s\t%s\t%s\t%s\n", sim.ID, sim.Status, sim.EndedAt.Format(time.RFC3339), sim.Name)
+						continue
+					}
+					res, err := archiver.Archive(ctx, sim)
+					if err != nil {
+						failed++
+						logrus.WithError(err).WithField("simulation_id", sim.ID).Error("Falha ao arquivar simulação")
+						if ctx.Err() != nil {
+							break
+						}
+						continue
+					}
+					fmt.Fprintf(out, "%s arquivada em %s (%d linhas, %d bytes)\n", sim.ID, res.Key, res.Rows, res.Size)
+					// As linhas saem do banco e os bytes passam a contar no projeto;
+					// a reconciliação corrige o uso se esta gravação falhar.
+					if cfg.Quotas.Enabled && sim.ProjectID != "" {
+						if err := usage.Add(ctx, sim.ProjectID, quota.Amounts{
+							Agents:       -res.Tables["agents"],
+							EventRows:    -res.Tables["events"],
+							ArchiveBytes: res.Size,
+						}); err != nil {
+							logrus.WithError(err).WithField("project_id", sim.ProjectID).Warn("Falha ao atualizar o uso do projeto após o arquivamento")
+						}
+					}
+				}
+				if failed > 0 {
+					return fmt.Errorf("%d de %d simulações não foram arquivadas", failed, len(sims))
+				}
+				if len(sims) == 0 {
+					fmt.Fprintln(cmd.ErrOrStderr(), "nenhuma simulação a arquivar")
+				}
+				return nil
+			})
+		},
+	}
+	archiveCmd.Flags().DurationVar(&olderThan, "older-than", 0, "idade mínima desde o fim da simulação, ex.: 720h (obrigatório)")
+	archiveCmd.Flags().BoolVar(&dryRun, "dry-run", false, "apenas lista as simulações que seriam arquivadas")
+	archiveCmd.MarkFlagRequired("older-than")
+
+	cmd.AddCommand(archiveCmd)
+	return cmd
+}
+
+func agentCommand(flags *pflag.FlagSet) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "agent",
+		Short: "Operações sobre agentes",
+	}
+
+	var filter agent.Filter
+	var output string
+	export := &cobra.Command{
+		Use:   "export",
+		Short: "Exporta agentes em JSON Lines, um agente por linha",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return withDB(flags, func(ctx context.Context, cfg *config.Config, db *sql.DB) error {
+				redisClient, err := connectRedis(cfg.Redis)
+				if err != nil {
+					return fmt.Errorf("falha ao conectar ao Redis: %w", err)
+				}
+				defer redisClient.Close()
+				service := agent.NewService(agent.NewRepository(db), redisClient)
+
+				var w io.Writer = cmd.OutOrStdout()
+				var file *os.File
+				if output != "" && output != "-" {
+					if file, err = os.Create(output); err != nil {
+						return err
+					}
+					w = file
+				}
+				n, err := exportAgents(ctx, service, filter, w)
+				if file != nil {
+					if closeErr := file.Close(); err == nil {
+						err = closeErr
+					}
+					if err != nil {
+						os.Remove(output)
+					}
+				}
+				if err != nil {
+					return fmt.Errorf("falha ao exportar agentes: %w", err)
+				}
+				fmt.Fprintf(cmd.ErrOrStderr(), "%d agentes exportados\n", n)
+				return nil
+			})
+		},
+	}
+	export.Flags().StringVar(&filter.SimulationID, "simulation", "", "apenas agentes da simulação")
+	export.Flags().StringVar(&filter.ProjectID, "project", "", "apenas agentes do projeto")
+	export.Flags().StringVar(&filter.Type, "type", "", "apenas agentes do tipo")
+	export.Flags().StringVar(&filter.Status, "status", "", "apenas agentes com o status")
+	export.Flags().StringSliceVar(&filter.Tags, "tag", nil, "apenas agentes com as tags")
+	export.Flags().StringVarP(&output, "output", "o", "", "arquivo de saída (padrão: stdout)")
+
+	cmd.AddCommand(export)
+	return cmd
+}
+
+func seedCommand(flags *pflag.FlagSet) *cobra.Command {
+	var agentsPerType int
+	var types []string
+	var projectID string
+	var history time.Duration
+	cmd := &cobra.Command{
+		Use:   "seed",
+		Short: "Grava dados de demonstração no banco",
+		Long: `Registra os tipos de agente da configuração e grava, para cada tipo, agentes
+com posições dentro de seed.bbox, dois cenários, uma simulação concluída com
+histórico de eventos e métricas e uma simulação em execução. Os identificadores
+são determinísticos: repetir o comando atualiza os mesmos registros.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return withDB(flags, func(ctx context.Context, cfg *config.Config, db *sql.DB) error {
+				opts := seedOptions(cfg)
+				if cmd.Flags().Changed("agents-per-type") {
+					opts.AgentsPerType = agentsPerType
+				}
+				if opts.AgentsPerType < 1 {
+					return errors.New("--agents-per-type deve ser positivo")
+				}
+				if projectID != "" {
+					opts.ProjectID = projectID
+				}
+				if history > 0 {
+					opts.History = history
+				}
+				opts.Types = types
+
+				repo := agenttype.NewRepository(db)
+				if _, err := repo.Seed(ctx, configAgentTypes(cfg)); err != nil {
+					return fmt.Errorf("falha ao registrar os tipos de agente: %w", err)
+				}
+				res, err := seed.New(db, repo).Run(ctx, opts)
+				if err != nil {
+					return fmt.Errorf("falha ao gravar os dados de demonstração: %w", err)
+				}
+				out := cmd.OutOrStdout()
+				names := make([]string, 0, len(res.Agents))
+				for name := range res.Agents {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+				for _, name := range names {
+					fmt.Fprintf(out, "%s\t%d agentes\n", name, res.Agents[name])
+				}
+				fmt.Fprintf(out, "projeto %s: simulação concluída %s, em execução %s\n",
+					res.ProjectID, res.CompletedSimulation, res.RunningSimulation)
+				fmt.Fprintf(out, "%d eventos, %d amostras de métricas, %d métricas da simulação em %dms\n",
+					res.Events, res.MetricSamples, res.Metrics, res.DurationMs)
+				return nil
+			})
+		},
+	}
+	cmd.Flags().IntVar(&agentsPerType, "agents-per-type", 0, "agentes por tipo (padrão: seed.agents_per_type)")
+	cmd.Flags().StringSliceVar(&types, "type", nil, "apenas os tipos informados (padrão: todos do registro)")
+	cmd.Flags().StringVar(&projectID, "project", "", "projeto dos dados (padrão: seed.project_id)")
+	cmd.Flags().DurationVar(&history, "history", 0, "duração da simulação concluída, ex.: 24h (padrão: seed.history)")
+	return cmd
+}
+
+func checkpointCommand(flags *pflag.FlagSet) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "checkpoint",
+		Short: "Operações sobre checkpoints de simulações",
+	}
+
+	var key string
+	var asJSON bool
+	inspect := &cobra.Command{
+		Use:   "inspect [arquivo]",
+		Short: "Mostra a versão, as contagens e a compatibilidade de um checkpoint sem restaurá-lo",
+		Long: `Lê um checkpoint de simulação de um arquivo local ("-" para stdin) ou, com
+--key, do armazenamento configurado (storage.*) e mostra a versão do formato,
+o serviço que o gravou, o número de agentes e de mensagens e se esta versão
+do serviço o restaura, com as migrações que aplicaria. Sai com erro se o
+checkpoint não puder ser restaurado.`,
+		Example: `  agent-service checkpoint inspect checkpoint.json.gz
+  agent-service checkpoint inspect --key checkpoints/simulations/<id>/1200.json.gz`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if (key == "") == (len(args) == 0) {
+				return errors.New("informe um arquivo ou --key")
+			}
+			var r io.ReadCloser
+			switch {
+			case key != "":
+				cfg, _, _, err := loadConfig(flags)
+				if err != nil {
+					return err
+				}
+				store, err := objectStorage(cfg.Storage)
+				if err != nil {
+					return fmt.Errorf("falha ao configurar o armazenamento de objetos: %w", err)
+				}
+				if r, _, err = store.Get(cmd.Context(), key); err != nil {
+					return fmt.Errorf("falha ao ler %s: %w", key, err)
+				}
+			case args[0] == "-":
+				r = io.NopCloser(cmd.InOrStdin())
+			default:
+				f, err := os.Open(args[0])
+				if err != nil {
+					return err
+				}
+				r = f
+			}
+			defer r.Close()
+
+			info, err := checkpoint.Inspect(r)
+			if err != nil {
+				return fmt.Errorf("checkpoint inválido: %w", err)
+			}
+			out := cmd.OutOrStdout()
+			if asJSON {
+				enc := json.NewEncoder(out)
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(info); err != nil {
+					return err
+				}
+			} else {
+				writeCheckpointInfo(out, info)
+			}
+			if !info.Compatible {
+				return errors.New("checkpoint não pode ser restaurado por esta versão do serviço")
+			}
+			return nil
+		},
+	}
+	inspect.Flags().StringVar(&key, "key", "", "chave do checkpoint no armazenamento de objetos")
+	inspect.Flags().BoolVar(&asJSON, "json", false, "mostra o resultado em JSON")
+
+	cmd.AddCommand(inspect)
+	return cmd
+}
+
+// writeCheckpointInfo escreve a descrição do checkpoint para leitura.
+func writeCheckpointInfo(w io.Writer, info *checkpoint.Info) {
+	fmt.Fprintf(w, "formato:    %s\n", info.Format)
+	fmt.Fprintf(w, "versão:     %d (este serviço lê até %d)\n", info.Version, info.CurrentVersion)
+	if info.ServiceVersion != "" {
+		fmt.Fprintf(w, "serviço:    agent-service %s\n", info.ServiceVersion)
+	}
+	fmt.Fprintf(w, "simulação:  %s\n", info.SimulationID)
+	fmt.Fprintf(w, "tick:       %d\n", info.Tick)
+	if !info.CreatedAt.IsZero() {
+		fmt.Fprintf(w, "gravado em: %s\n", info.CreatedAt.Format(time.RFC3339))
+	}
+	fmt.Fprintf(w, "agentes:    %d\n", info.Agents)
+	fmt.Fprintf(w, "mensagens:  %d\n", info.Messages)
+	switch {
+	case !info.Compatible:
+		fmt.Fprintf(w, "compatível: não: %s\n", info.Reason)
+	case len(info.Migrations) > 0:
+		fmt.Fprintf(w, "compatível: sim, migrando %s\n", strings.Join(info.Migrations, ", "))
+	default:
+		fmt.Fprintln(w, "compatível: sim")
+	}
+}
+
+func loadtestCommand() *cobra.Command {
+	cfg := loadtest.Config{}
+	cmd := &cobra.Command{
+		Use:   "loadtest",
+		Short: "Gera carga contra um serviço em execução e mostra latências e erros",
+		Long: `Faz leituras (listagem e busca por id) e escritas (PUT /api/v1/agents/:id)
+em paralelo, na proporção de --reads e --writes, sobre os agentes da primeira
+página do alvo, e mantém --subscribers conexões em /ws. Ao fim mostra, por
+operação, as requisições por segundo, a taxa de erros e os percentis de
+latência. As escritas acrescentam state.loadtest_seq ao estado lido no início
+e sobrescrevem mudanças feitas depois dele: use fora de produção.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			ctx, stop := signal.NotifyContext(cmd.Context(), syscall.SIGINT, syscall.SIGTERM)
+			defer stop()
+			fmt.Fprintf(cmd.ErrOrStderr(), "carga contra %s por %s com %d conexões...\n", cfg.Target, cfg.Duration, cfg.Concurrency)
+			report, err := loadtest.Run(ctx, cfg)
+			if err != nil {
+				return err
+			}
+			return report.Write(cmd.OutOrStdout())
+		},
+	}
+	f := cmd.Flags()
+	f.StringVar(&cfg.Target, "target", "http://localhost:8080", "URL base do serviço")
+	f.StringVar(&cfg.APIKey, "api-key", "", "chave de API enviada em X-API-Key")
+	f.DurationVar(&cfg.Duration, "duration", 30*time.Second, "duração da carga")
+	f.IntVar(&cfg.Concurrency, "concurrency", 10, "requisições simultâneas")
+	f.IntVar(&cfg.Reads, "reads", 8, "peso das leituras")
+	f.IntVar(&cfg.Writes, "writes", 2, "peso das escritas")
+	f.IntVar(&cfg.Subscribers, "subscribers", 0, "conexões websocket mantidas durante a carga")
+	f.StringVar(&cfg.SimulationID, "simulation", "", "apenas agentes da simulação")
+	f.DurationVar(&cfg.Timeout, "timeout", 10*time.Second, "prazo de cada requisição")
+	return cmd
+}
+
+func conformanceCommand() *cobra.Command {
+	var scenarios []string
+	var timeout time.Duration
+	cmd := &cobra.Command{
+		Use:   "conformance [-- comando do cliente...]",
+		Short: "Verifica um cliente do streaming de posições contra o servidor real",
+		Long: `Roda os cenários do protocolo do streaming de posições (snapshot inicial,
+deltas, buraco de sequência com resync, queda e reconexão, descarte por
+lentidão e servidores antigos) contra um cliente e mostra o resultado de cada
+um. Cada cenário sobe o servidor numa porta local, sem banco nem Redis.
+
+Sem argumentos verifica o cliente Go de pkg/client. Com um comando, ele roda a
+cada cenário com o alvo em CONFORMANCE_URL, CONFORMANCE_BASE_URL e
+CONFORMANCE_SIMULATION_ID e escreve no stdout uma linha JSON por quadro
+aplicado com a visão inteira: {"seq":N,"agents":[{"id":...,"status":...,
+"lat":...,"lon":...,"heading":...,"speed":...}]}. Sai com erro se algum
+cenário falhar.`,
+		Example: `  agent-service conformance
+  agent-service conformance --scenario gap-resync -- node ./conformance-client.js`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, stop := signal.NotifyContext(cmd.Context(), syscall.SIGINT, syscall.SIGTERM)
+			defer stop()
+			gin.SetMode(gin.ReleaseMode)
+			var client conformance.Client = conformance.GoClient{}
+			if len(args) > 0 {
+				client = conformance.Command{Path: args[0], Args: args[1:], Stderr: cmd.ErrOrStderr()}
+			}
+			report, err := conformance.Run(ctx, client, conformance.Options{Scenarios: scenarios, Timeout: timeout})
+			if err != nil {
+				return err
+			}
+			if err := report.Write(cmd.OutOrStdout()); err != nil {
+				return err
+			}
+			if n := report.Failed(); n > 0 {
+				return fmt.Errorf("%d cenários falharam", n)
+			}
+			return nil
+		},
+	}
+	f := cmd.Flags()
+	f.StringSliceVar(&scenarios, "scenario", nil, fmt.Sprintf("apenas os cenários informados (%v)", conformance.Scenarios()))
+	f.DurationVar(&timeout, "timeout", conformance.DefaultTimeout, "prazo de cada convergência da visão do cliente")
+	return cmd
+}
+
+// exportPageSize é o tamanho das páginas lidas do serviço na exportação.
+const exportPageSize = 500
+
+// exportAgents escreve os agentes do filtro em w, página a página.
+func exportAgents(ctx context.Context, service *agent.Service, f agent.Filter, w io.Writer) (int, error) {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	f.PageSize = exportPageSize
+	n := 0
+	for f.Page = 1; ; f.Page++ {
+		agents, total, err := service.ListAgents(ctx, f)
+		if err != nil {
+			return n, err
+		}
+		for i := range agents {
+			if err := enc.Encode(&agents[i]); err != nil {
+				return n, err
+			}
+		}
+		n += len(agents)
+		if len(agents) < f.PageSize || n >= total {
+			break
+		}
+	}
+	return n, bw.Flush()
+}
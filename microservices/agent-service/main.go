@@ -2,7 +2,13 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -10,183 +16,2012 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/gin-contrib/cors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 	"github.com/redis/go-redis/v9"
 	"github.com/lib/pq"
 	"github.com/google/uuid"
 
+	"smart-city-microservices/internal/action"
+	"smart-city-microservices/internal/actionbatch"
+	"smart-city-microservices/internal/admin"
 	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/agenthealth"
+	"smart-city-microservices/internal/agenttype"
+	"smart-city-microservices/internal/agentlist"
+	"smart-city-microservices/internal/agentmetric"
+	"smart-city-microservices/internal/agentmsg"
+	"smart-city-microservices/internal/agentreset"
+	"smart-city-microservices/internal/alert"
+	"smart-city-microservices/internal/anomaly"
+	"smart-city-microservices/internal/apiinfo"
+	"smart-city-microservices/internal/apikey"
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/backup"
+	"smart-city-microservices/internal/behavior"
+	"smart-city-microservices/internal/broadcast"
+	"smart-city-microservices/internal/buildinfo"
+	"smart-city-microservices/internal/capability"
+	"smart-city-microservices/internal/changefeed"
+	"smart-city-microservices/internal/checkpoint"
+	"smart-city-microservices/internal/coalesce"
+	"smart-city-microservices/internal/config"
+	"smart-city-microservices/internal/consumption"
 	"smart-city-microservices/internal/database"
-	"smart-city-microservices/internal/redis"
+	"smart-city-microservices/internal/deadletter"
+	"smart-city-microservices/internal/debug"
+	"smart-city-microservices/internal/deletion"
+	"smart-city-microservices/internal/dryrun"
+	"smart-city-microservices/internal/dependency"
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/events/kafkasink"
+	"smart-city-microservices/internal/events/natssink"
+	"smart-city-microservices/internal/events/outbox"
+	"smart-city-microservices/internal/failure"
+	"smart-city-microservices/internal/fault"
+	"smart-city-microservices/internal/featureflag"
+	"smart-city-microservices/internal/geo"
+	"smart-city-microservices/internal/graph"
+	"smart-city-microservices/internal/group"
+	"smart-city-microservices/internal/grpcapi"
+	"smart-city-microservices/internal/heatmap"
+	"smart-city-microservices/internal/httpcors"
+	"smart-city-microservices/internal/httpcache"
+	"smart-city-microservices/internal/hubrelay"
+	"smart-city-microservices/internal/i18n"
+	"smart-city-microservices/internal/ingestlimit"
+	"smart-city-microservices/internal/instance"
+	"smart-city-microservices/internal/instrument"
+	"smart-city-microservices/internal/kpi"
+	"smart-city-microservices/internal/listener"
+	"smart-city-microservices/internal/logging"
+	"smart-city-microservices/internal/longpoll"
+	"smart-city-microservices/internal/maintenance"
+	"smart-city-microservices/internal/mqttbridge"
+	"smart-city-microservices/internal/negotiate"
+	"smart-city-microservices/internal/notification"
+	"smart-city-microservices/internal/oidc"
+	"smart-city-microservices/internal/openapi"
+	"smart-city-microservices/internal/outbound"
+	"smart-city-microservices/internal/positions"
+	"smart-city-microservices/internal/presence"
+	"smart-city-microservices/internal/projectsettings"
+	"smart-city-microservices/internal/proximity"
+	"smart-city-microservices/internal/querybudget"
+	"smart-city-microservices/internal/quota"
+	"smart-city-microservices/internal/readiness"
+	"smart-city-microservices/internal/revision"
+	"smart-city-microservices/internal/rollup"
+	"smart-city-microservices/internal/schedule"
+	"smart-city-microservices/internal/secrets"
+	"smart-city-microservices/internal/seed"
+	"smart-city-microservices/internal/simmetrics"
+	"smart-city-microservices/internal/snapshot"
+	"smart-city-microservices/internal/storage"
+	"smart-city-microservices/internal/supervisor"
+	"smart-city-microservices/internal/synchook"
+	"smart-city-microservices/internal/tiles"
+	"smart-city-microservices/internal/tlsutil"
+	"smart-city-microservices/internal/trajectory"
+	"smart-city-microservices/internal/transfer"
+	"smart-city-microservices/internal/twin"
+	"smart-city-microservices/internal/warmup"
+	"smart-city-microservices/internal/webhook"
 	"smart-city-microservices/internal/websocket"
 	"smart-city-microservices/internal/middleware"
 )
 
 func main() {
-	// Configurar logging
+	// Configurar logging (reconfigurado após carregar as configurações)
 	logrus.SetFormatter(&logrus.JSONFormatter{})
 	logrus.SetLevel(logrus.InfoLevel)
 
-	// Carregar configurações
-	viper.SetConfigName("config")
-	viper.SetConfigType("yaml")
-	viper.AddConfigPath(".")
-	viper.AddConfigPath("./configs")
-	
-	viper.SetDefault("server.port", "8080")
-	viper.SetDefault("server.host", "0.0.0.0")
-	viper.SetDefault("database.host", "localhost")
-	viper.SetDefault("database.port", 5432)
-	viper.SetDefault("database.name", "smart_city")
-	viper.SetDefault("database.user", "postgres")
-	viper.SetDefault("database.password", "password")
-	viper.SetDefault("redis.host", "localhost")
-	viper.SetDefault("redis.port", 6379)
-	viper.SetDefault("redis.password", "")
-
-	if err := viper.ReadInConfig(); err != nil {
-		logrus.Warn("Arquivo de configuração não encontrado, usando padrões")
+	if err := newRootCommand().Execute(); err != nil {
+		fmt.Fprintln(os.Stderr, "erro:", err)
+		os.Exit(1)
 	}
+}
 
-	// Conectar ao banco de dados
-	dbConfig := database.Config{
-		Host:     viper.GetString("database.host"),
-		Port:     viper.GetInt("database.port"),
-		User:     viper.GetString("database.user"),
-		Password: viper.GetString("database.password"),
-		DBName:   viper.GetString("database.name"),
-		SSLMode:  viper.GetString("database.sslmode"),
+// serve inicia o servidor HTTP e os demais componentes e bloqueia até o
+// sinal de encerramento.
+func serve(flags *pflag.FlagSet) error {
+	cfg, secretManager, outboundClients, err := loadConfig(flags)
+	if validateOnly, _ := flags.GetBool("validate-config"); validateOnly && err == nil {
+		fmt.Println("configuração válida")
+		return nil
 	}
+	if err != nil {
+		return err
+	}
+
+	logLevels := logging.NewLevelController()
+	config.LogEffective(viper.GetViper())
+	instrument.SetThresholds(cfg.Observability.SlowQueryThreshold, cfg.Observability.SlowRequestThreshold)
+	corsPolicy := httpcors.New(cfg.Server.CORS.AllowedOrigins)
+
+	// Barramento de eventos internos (entregues ao hub websocket)
+	eventBus := events.NewBus()
+	eventBus.SetValidation(cfg.Events.SchemaValidation)
+
+	// Componentes que aceitam recarga de configuração sem reinício
+	configRegistry := config.NewRegistry(viper.GetViper(), flags, eventBus)
+	configRegistry.Register(config.Component{
+		Name: "logging",
+		Validate: func(v *viper.Viper) error {
+			if _, err := logrus.ParseLevel(v.GetString("log.level")); err != nil {
+				return err
+			}
+			if f := v.GetString("log.format"); f != logging.FormatJSON && f != logging.FormatText {
+				return fmt.Errorf("log.format inválido: %q", f)
+			}
+			return nil
+		},
+		Apply: func(v *viper.Viper) {
+			level, _ := logrus.ParseLevel(v.GetString("log.level"))
+			logging.SetFormat(v.GetString("log.format"))
+			logLevels.SetDefault(level)
+		},
+	})
+	configRegistry.Register(config.Component{
+		Name: "slow_thresholds",
+		Validate: func(v *viper.Viper) error {
+			if v.GetDuration("observability.slow_query_threshold") <= 0 || v.GetDuration("observability.slow_request_threshold") <= 0 {
+				return fmt.Errorf("limites de lentidão devem ser positivos")
+			}
+			return nil
+		},
+		Apply: func(v *viper.Viper) {
+			instrument.SetThresholds(
+				v.GetDuration("observability.slow_query_threshold"),
+				v.GetDuration("observability.slow_request_threshold"),
+			)
+		},
+	})
+	configRegistry.Register(config.Component{
+		Name: "cors",
+		Validate: func(v *viper.Viper) error {
+			if len(v.GetStringSlice("server.cors.allowed_origins")) == 0 {
+				return fmt.Errorf("server.cors.allowed_origins não pode ser vazio")
+			}
+			return nil
+		},
+		Apply: func(v *viper.Viper) {
+			corsPolicy.Update(v.GetStringSlice("server.cors.allowed_origins"))
+		},
+	})
+	configRegistry.SetResolver(secretManager.Resolve)
+	configRegistry.Watch()
+	secretManager.OnChange(func(ctx context.Context, key string) {
+		eventBus.Publish(ctx, events.New(events.TopicAdmin, "secret.rotated", events.SecretRotatedV1{Key: key}))
+	})
+
+	// Fases de inicialização acompanhadas pelo probe de prontidão; são
+	// encerradas na ordem inversa do registro.
+	ready := readiness.NewRegistry()
+	// Fecha as conexões ociosas das chamadas de saída depois de todos os
+	// componentes que as usam.
+	ready.Register("outbound", func(context.Context) error {
+		outboundClients.CloseIdle()
+		return nil
+	}).SetReady()
 
-	db, err := database.Connect(dbConfig)
+	// Componentes de fundo, reiniciados se falharem. Registrado antes deles para
+	// ser o último a parar: encerra o que ainda não tiver sido encerrado.
+	sup := supervisor.New(supervisor.Config{
+		InitialBackoff: cfg.Supervisor.InitialBackoff,
+		MaxBackoff:     cfg.Supervisor.MaxBackoff,
+	})
+	ready.Register("supervisor", sup.Stop).SetReady()
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	checkInterval := cfg.Health.CheckInterval
+
+	// Conectar ao banco de dados
+	db, err := database.Connect(databaseConfig(cfg.Database))
 	if err != nil {
 		logrus.Fatal("Erro ao conectar ao banco de dados:", err)
 	}
-	defer db.Close()
+	dbReady := ready.Register("database", func(context.Context) error { return db.Close() })
 
 	// Executar migrações
 	if err := database.RunMigrations(db); err != nil {
 		logrus.Fatal("Erro ao executar migrações:", err)
 	}
+	dbReady.SetReady()
+	secretManager.Start(watchCtx, viper.GetViper(), cfg.Secrets.RefreshInterval)
+	ready.Register("database_check", sup.Go("database_check", watch(dbReady, checkInterval, db.PingContext))).SetReady()
 
 	// Conectar ao Redis
-	redisConfig := redis.Config{
-		Host:     viper.GetString("redis.host"),
-		Port:     viper.GetInt("redis.port"),
-		Password: viper.GetString("redis.password"),
-		DB:       0,
+	redisClient, err := connectRedis(cfg.Redis)
+	if err != nil {
+		logrus.Fatal("Erro ao conectar ao Redis:", err)
 	}
+	redisReady := ready.Register("redis", func(context.Context) error { return redisClient.Close() })
+	redisReady.SetReady()
+	ready.Register("redis_check", sup.Go("redis_check", watch(redisReady, checkInterval, func(ctx context.Context) error {
+		return redisClient.Ping(ctx).Err()
+	}))).SetReady()
 
-	redisClient, err := redis.Connect(redisConfig)
+	// Armazenamento de objetos (checkpoints, arquivos de simulações, exportações)
+	objectStore, err := objectStorage(cfg.Storage)
 	if err != nil {
-		logrus.Fatal("Erro ao conectar ao Redis:", err)
+		logrus.Fatal("Erro ao configurar o armazenamento de objetos:", err)
 	}
-	defer redisClient.Close()
+	storageReady := ready.Register("storage", nil)
+	storageReady.SetReady()
+	ready.Register("storage_check", sup.Go("storage_check", watch(storageReady, checkInterval, objectStore.Ping))).SetReady()
+
+	// Registro desta instância para descoberta pelo gateway
+	heartbeat := instance.NewHeartbeat(redisClient, instance.Config{
+		Interval: cfg.Registry.HeartbeatInterval,
+		TTL:      cfg.Registry.TTL,
+	}, instance.NewInfo(cfg.Server.Port, buildinfo.Version, protocols(cfg)), eventBus)
+	eventBus.SetProducer(heartbeat.ID())
 
 	// Inicializar serviços
 	agentRepo := agent.NewRepository(db)
 	agentService := agent.NewService(agentRepo, redisClient)
 	agentHandler := agent.NewHandler(agentService)
+	geoHandler := geo.NewHandler(agentService)
+	// Mensagens do hub websocket guardadas para GET /api/v1/events/poll
+	pollBuffer := longpoll.NewBuffer(cfg.Events.LongPoll.BufferSize)
+	pollHandler := longpoll.NewHandler(pollBuffer, longpoll.Config{
+		MaxEvents:       cfg.Events.LongPoll.MaxEvents,
+		DefaultTimeout:  cfg.Events.LongPoll.DefaultTimeout,
+		MaxTimeout:      cfg.Events.LongPoll.MaxTimeout,
+		MaxPerPrincipal: cfg.Events.LongPoll.MaxPerPrincipal,
+	})
+	// WebSocket para comunicação em tempo real
+	// O hub não recebe contexto: Run retorna quando o hub é fechado pelo
+	// Shutdown de websocket_drain, que para antes dos componentes daqui.
+	wsHub := websocket.NewHub()
+	ready.Register("websocket_hub", sup.Go("websocket_hub", func(context.Context) error {
+		wsHub.Run()
+		return nil
+	})).SetReady()
+	// Eventos publicados por outros serviços para os clientes do hub
+	broadcastHandler := broadcast.NewHandler(eventBus, redisClient, []broadcast.Counter{wsHub, pollBuffer}, broadcast.Config{
+		AllowedTypes: cfg.Events.Broadcast.AllowedTypes,
+		RateLimit:    cfg.Events.Broadcast.RateLimit,
+		RateWindow:   cfg.Events.Broadcast.RateWindow,
+	})
+
+	// Eventos recentes do barramento, servidos em GET /api/v1/events e Query.events
+	recentEvents := events.NewRecent(cfg.GraphQL.RecentEvents)
+	eventBus.Subscribe(recentEvents.Handle)
+	negotiateHandler := negotiate.NewHandler(agentService, recentEvents, negotiate.Config{
+		BatchGetMax: cfg.Agents.BatchGetMax,
+	})
+	adminHandler := admin.NewHandler(logLevels, configRegistry)
+	// Modo de manutenção: lido do Redis na partida e a cada
+	// refresh_interval, para que todas as réplicas convirjam
+	maintenanceSwitch := maintenance.New(redisClient, maintenance.Config{
+		RefreshInterval: cfg.Maintenance.RefreshInterval,
+		RetryAfter:      cfg.Maintenance.RetryAfter,
+	})
+	if err := maintenanceSwitch.Refresh(context.Background()); err != nil {
+		logrus.WithError(err).Warn("Falha ao ler o modo de manutenção; iniciando em off")
+	}
+	ready.Register("maintenance", sup.Go("maintenance", maintenanceSwitch.Run)).SetReady()
+	maintenanceHandler := maintenance.NewHandler(maintenanceSwitch, eventBus)
+	// Feature flags das rotas arriscadas: valores gravados lidos na partida
+	// e relidos a cada alteração avisada pelo Redis
+	featureFlags := featureflag.New(featureflag.NewRepository(db), redisClient, featureflag.Config{
+		Channel:         cfg.FeatureFlags.Channel,
+		RefreshInterval: cfg.FeatureFlags.RefreshInterval,
+		Defaults:        cfg.FeatureFlags.Defaults,
+	})
+	if err := featureFlags.Refresh(context.Background()); err != nil {
+		logrus.WithError(err).Warn("Falha ao ler os feature flags; valendo os padrões")
+	}
+	ready.Register("feature_flags", sup.Go("feature_flags", featureFlags.Run)).SetReady()
+	featureFlagHandler := featureflag.NewHandler(featureFlags)
+	// Limites próprios dos projetos (requisições por minuto, timeout,
+	// tamanho das exportações, origens): padrões da configuração, com
+	// recarga, sob os valores gravados, relidos a cada alteração avisada
+	// pelo Redis
+	projectSettings := projectsettings.New(projectsettings.NewRepository(db), redisClient, projectsettings.Config{
+		Channel:         cfg.ProjectLimits.Channel,
+		RefreshInterval: cfg.ProjectLimits.RefreshInterval,
+		Defaults:        projectLimitDefaults(viper.GetViper()),
+	})
+	if err := projectSettings.Refresh(context.Background()); err != nil {
+		logrus.WithError(err).Warn("Falha ao ler os limites dos projetos; valendo os padrões")
+	}
+	ready.Register("project_settings", sup.Go("project_settings", projectSettings.Run)).SetReady()
+	configRegistry.Register(config.Component{
+		Name: "project_limits",
+		Validate: func(v *viper.Viper) error {
+			if d := projectLimitDefaults(v); d.RequestsPerMinute < 0 || d.RequestTimeoutMS < 0 || d.ExportMaxBytes < 0 {
+				return fmt.Errorf("project_limits.defaults não aceita valores negativos")
+			}
+			return nil
+		},
+		Apply: func(v *viper.Viper) {
+			projectSettings.SetDefaults(projectLimitDefaults(v))
+		},
+	})
+	projectSettingsHandler := projectsettings.NewHandler(projectSettings)
+	apiInfo := apiinfo.New(featureFlags, events.Schemas)
+	instanceHandler := instance.NewHandler(heartbeat)
+	webhookRepo := webhook.NewRepository(db)
+	webhookDispatcher := webhook.NewDispatcher(webhookRepo, webhook.Config{
+		Workers:              cfg.Webhooks.Workers,
+		QueueSize:            cfg.Webhooks.QueueSize,
+		MaxAttempts:          cfg.Webhooks.MaxAttempts,
+		InitialBackoff:       cfg.Webhooks.InitialBackoff,
+		MaxBackoff:           cfg.Webhooks.MaxBackoff,
+		Timeout:              cfg.Webhooks.Timeout,
+		DisableAfter:         cfg.Webhooks.DisableAfter,
+		AllowPrivateNetworks: cfg.Webhooks.AllowPrivateNetworks,
+	}, outboundClients, eventBus)
+	webhookHandler := webhook.NewHandler(webhookRepo, webhookDispatcher)
+	// Dead-letter das entregas que esgotaram as tentativas, com
+	// reprocessamento pela administração
+	var deadLetters *deadletter.Queue
+	var deadLetterHandler *deadletter.Handler
+	if cfg.DeadLetter.Enabled {
+		deadLetters = deadletter.New(redisClient, deadletter.Config{
+			Stream:    cfg.DeadLetter.Stream,
+			MaxLen:    cfg.DeadLetter.MaxLen,
+			Retention: cfg.DeadLetter.Retention,
+			Interval:  cfg.DeadLetter.Interval,
+		})
+		if cfg.Webhooks.Enabled {
+			webhookDispatcher.SetDeadLetter(deadLetters)
+			deadLetters.Register(webhook.DeadLetterConsumer, webhookDispatcher)
+		}
+		ready.Register("dead_letter", sup.Go("dead_letter", deadLetters.Run)).SetReady()
+		deadLetterHandler = deadletter.NewHandler(deadLetters)
+	}
+	notifiers, err := notificationChannels(cfg.Notifications, outboundClients)
+	if err != nil {
+		logrus.Fatal("Erro ao configurar notificações:", err)
+	}
+	notificationRepo := notification.NewRepository(db)
+	notificationDispatcher := notification.NewDispatcher(notificationRepo, notification.Config{
+		Workers:        cfg.Notifications.Workers,
+		QueueSize:      cfg.Notifications.QueueSize,
+		MaxAttempts:    cfg.Notifications.MaxAttempts,
+		InitialBackoff: cfg.Notifications.InitialBackoff,
+		MaxBackoff:     cfg.Notifications.MaxBackoff,
+		Timeout:        cfg.Notifications.Timeout,
+	}, notifiers)
+	notificationHandler := notification.NewHandler(notificationRepo, notificationDispatcher)
+	alertRepo := alert.NewRepository(db)
+	alertHandler := alert.NewHandler(alertRepo, agentService)
+
+	// Último reporte de cada agente: telemetria MQTT, amostras de métricas e
+	// PUT /agents/:id contam como reporte
+	presenceTracker := presence.NewTracker(redisClient, agentService, eventBus, cfg.Presence.OfflineStatus)
+
+	// Telemetria frequente (MQTT e PUT /agents/:id) vai para o estado ao vivo
+	// no Redis e é gravada no banco em lotes
+	var telemetryAgents coalesce.AgentService = agentService
+	updateHandlers := []gin.HandlerFunc{presenceTracker.Middleware(), agentHandler.UpdateAgent}
+	var liveHandler *coalesce.Handler
+	var liveFlusher snapshot.Flusher
+	if cfg.Coalescing.Enabled {
+		stateCoalescer := coalesce.New(agentService, redisClient, eventBus, coalesce.Config{
+			FlushInterval:      cfg.Coalescing.FlushInterval,
+			PositionThresholdM: cfg.Coalescing.PositionThresholdM,
+			LiveTTL:            cfg.Coalescing.LiveTTL,
+		}, heartbeat.ID())
+		ready.Register("state_coalescer", sup.Go("state_coalescer", stateCoalescer.Run)).SetReady()
+		eventBus.Subscribe(stateCoalescer.Handle)
+		telemetryAgents = stateCoalescer.Agents(agentService)
+		liveHandler = coalesce.NewHandler(stateCoalescer, agentService)
+		liveFlusher = stateCoalescer
+		updateHandlers = []gin.HandlerFunc{presenceTracker.Middleware(), liveHandler.UpdateAgent}
+	}
+
+	// Falhas injetadas nas simulações para testes de resiliência: descarte
+	// de telemetria nos reportes, atraso e ruído na entrega dos eventos e
+	// agentes postos offline nos ticks
+	var faultInjector *fault.Injector
+	var faultHandler *fault.Handler
+	var reportLimit []gin.HandlerFunc
+	deliver := func(h events.Handler) events.Handler { return h }
+	if cfg.Faults.Enabled {
+		faultRepo := fault.NewRepository(db)
+		faultInjector = fault.NewInjector(faultRepo, agentService, eventBus, fault.Config{
+			RefreshInterval: cfg.Faults.RefreshInterval,
+			OfflineStatus:   cfg.Presence.OfflineStatus,
+		})
+		ready.Register("fault_injector", sup.Go("fault_injector", faultInjector.Run)).SetReady()
+		faultHandler = fault.NewHandler(faultInjector, faultRepo, agentService, fault.Limits{
+			MaxDuration: cfg.Faults.MaxDuration,
+			MaxLatency:  cfg.Faults.MaxLatency,
+			MaxAgents:   cfg.Faults.MaxAgents,
+			MaxJitterM:  cfg.Faults.MaxJitterM,
+		})
+		reportLimit = append(reportLimit, faultInjector.Middleware())
+		deliver = faultInjector.Deliver
+	}
+
+	// Ponte MQTT dos sensores de campo: telemetria → estado dos agentes e
+	// ações em agentes sensores → comandos no broker
+	var mqttBridge *mqttbridge.Bridge
+	var onAction func(context.Context, string, agent.ActionRequest)
+	actionHandlers := []gin.HandlerFunc{agentHandler.ExecuteAction}
+	mqttRegistry := mqttbridge.NewRegistry(redisClient)
+	if cfg.MQTT.Enabled {
+		bridgeConfig, err := mqttConfig(cfg.MQTT)
+		if err != nil {
+			logrus.Fatal("Erro ao configurar ponte MQTT:", err)
+		}
+		var mqttAgents mqttbridge.AgentService = presenceTracker.Reporting(telemetryAgents)
+		if faultInjector != nil {
+			mqttAgents = faultInjector.Telemetry(mqttAgents)
+		}
+		mqttBridge, err = mqttbridge.New(bridgeConfig, mqttAgents, mqttRegistry, redisClient)
+		if err != nil {
+			logrus.Fatal("Erro ao configurar ponte MQTT:", err)
+		}
+		if err := mqttBridge.Start(); err != nil {
+			logrus.Fatal("Erro ao conectar ao broker MQTT:", err)
+		}
+		ready.Register("mqtt", mqttBridge.Stop).SetReady()
+		onAction = mqttBridge.OnAction
+		actionHandlers = append([]gin.HandlerFunc{mqttBridge.ActionMiddleware()}, actionHandlers...)
+	}
+
+	// Registro de ações: schema do payload, tipos de agente que aceitam
+	// cada ação e quem pode pedi-la, com as restrições de cada projeto.
+	// gRPC e lotes executam pelo serviço com checagem; a API REST checa no
+	// middleware de ações
+	actionDefs := make([]action.Definition, 0, len(cfg.Actions.Definitions))
+	for _, d := range cfg.Actions.Definitions {
+		schema, err := action.ParseSchema(d.Schema)
+		if err != nil {
+			logrus.Fatalf("Erro no schema da ação %s: %v", d.Name, err)
+		}
+		actionDefs = append(actionDefs, action.Definition{
+			Name:         d.Name,
+			Description:  d.Description,
+			AgentTypes:   d.AgentTypes,
+			Capabilities: d.Capabilities,
+			Schema:       schema,
+			LongRunning:  d.LongRunning,
+			Timeout:      d.Timeout,
+			Retry:        action.RetryPolicy{MaxAttempts: d.Retry.MaxAttempts, Backoff: d.Retry.Backoff},
+			Roles:        d.Roles,
+			Scopes:       d.Scopes,
+		})
+	}
+	// Capacidades dos agentes, permitidas e padrão por tipo, que as ações
+	// podem exigir além do tipo
+	capabilityTypes := make([]capability.TypeDefinition, 0, len(cfg.AgentTypes.Definitions))
+	for _, t := range cfg.AgentTypes.Definitions {
+		capabilityTypes = append(capabilityTypes, capability.TypeDefinition{
+			Name: t.Name, Allowed: t.Capabilities.Allowed, Default: t.Capabilities.Default,
+		})
+	}
+	capabilityRegistry := capability.NewRegistry(capabilityTypes)
+	capabilityRepo := capability.NewRepository(db)
+	capabilityStore := capability.NewStore(capabilityRegistry, capabilityRepo)
+	capabilityHandler := capability.NewHandler(capabilityRegistry, capabilityRepo, agentService, eventBus)
+	actionRegistry := action.NewRegistry(actionDefs, action.Options{
+		DefaultTimeout:    cfg.Actions.DefaultTimeout,
+		AllowUnregistered: cfg.Actions.AllowUnregistered,
+		Capabilities:      capabilityStore,
+		Projects:          projectSettings,
+	})
+	checkedAgents := action.NewCheckedService(agentService, actionRegistry)
+
+	// Ações em lote: executadas em segundo plano por um pool limitado, com o
+	// mesmo hook das ações individuais
+	actionBatchRepo := actionbatch.NewRepository(db)
+	actionBatchRunner := actionbatch.NewRunner(actionBatchRepo, checkedAgents, actionbatch.Config{
+		Workers:   cfg.ActionBatches.Workers,
+		MaxAgents: cfg.ActionBatches.MaxAgents,
+		Timeout:   cfg.ActionBatches.Timeout,
+	}, onAction)
+	ready.Register("action_batches", sup.Go("action_batches", actionBatchRunner.Run)).SetReady()
+	actionBatchHandler := actionbatch.NewHandler(actionBatchRepo, actionBatchRunner)
+
+	// Ações demoradas: respondidas com 202 e executadas em segundo plano,
+	// com timeout e retry declarados no registro de ações. A fila fica no
+	// Redis, por prioridade, e é consumida pelos workers de todas as réplicas
+	actionRepo := action.NewRepository(db)
+	actionQueue := action.NewQueue(redisClient, cfg.Actions.QueueSize, cfg.Actions.PriorityAging)
+	actionRunner := action.NewRunner(actionRepo, actionQueue, agentService, action.Config{
+		Workers:            cfg.Actions.Workers,
+		CancelPollInterval: cfg.Actions.CancelPollInterval,
+		Instance:           heartbeat.ID(),
+		HeartbeatInterval:  cfg.Actions.Recovery.HeartbeatInterval,
+		StaleAfter:         cfg.Actions.Recovery.StaleAfter,
+		RecoveryInterval:   cfg.Actions.Recovery.Interval,
+		MaxResumes:         cfg.Actions.Recovery.MaxResumes,
+	}, eventBus, onAction)
+	ready.Register("async_actions", sup.Go("async_actions", actionRunner.Run)).SetReady()
+	actionHandler := action.NewHandler(actionRegistry, actionRepo, actionRunner, agentService, action.HistoryConfig{
+		DefaultWindow: cfg.Actions.History.DefaultWindow,
+		MaxWindow:     cfg.Actions.History.MaxWindow,
+	})
+	actionHandlers = append([]gin.HandlerFunc{actionHandler.Middleware()}, actionHandlers...)
+
+	// Métricas de desempenho declaradas por tipo de agente
+	// e a nota de saúde calculada a partir delas
+	metricTypes := make([]agentmetric.TypeDefinition, 0, len(cfg.AgentTypes.Definitions))
+	var healthPolicies []agenthealth.Policy
+	for _, t := range cfg.AgentTypes.Definitions {
+		mt := agentmetric.TypeDefinition{Name: t.Name, AllowAdHoc: t.AllowAdHocMetrics}
+		for _, m := range t.Metrics {
+			mt.Metrics = append(mt.Metrics, agentmetric.Definition{
+				Name: m.Name, Description: m.Description, Unit: m.Unit, Aggregation: m.Aggregation, Anomaly: metricAnomaly(m.Anomaly),
+			})
+		}
+		metricTypes = append(metricTypes, mt)
+		if len(t.Health.Components) == 0 {
+			continue
+		}
+		hp := agenthealth.Policy{
+			AgentType:  t.Name,
+			Degraded:   t.Health.Degraded,
+			Critical:   t.Health.Critical,
+			Hysteresis: t.Health.Hysteresis,
+		}
+		if cfg.Ingestion.Enabled {
+			hp.RateLimitPenalty = cfg.Ingestion.HealthPenalty
+		}
+		if cfg.Anomalies.Enabled {
+			hp.AnomalyPenalty = cfg.Anomalies.HealthPenalty
+		}
+		for _, hc := range t.Health.Components {
+			hp.Components = append(hp.Components, agenthealth.Component{
+				Metric: hc.Metric, Weight: hc.Weight, Good: hc.Good, Bad: hc.Bad,
+			})
+		}
+		healthPolicies = append(healthPolicies, hp)
+	}
+	healthTracker := agenthealth.NewTracker(redisClient, eventBus, healthPolicies)
+
+	// Limite de reportes de telemetria por agente: recusa com 429 ou, nos
+	// tipos que toleram perdas, amostra os reportes acima do limite. Os
+	// reportes descartados por uma falha injetada não chegam a contar
+	var ingestLimitHandler *ingestlimit.Handler
+	if cfg.Ingestion.Enabled {
+		limits := make([]ingestlimit.TypeLimit, 0, len(cfg.AgentTypes.Definitions))
+		for _, t := range cfg.AgentTypes.Definitions {
+			limits = append(limits, ingestlimit.TypeLimit{AgentType: t.Name, Limit: t.Ingestion.Limit, LossyTolerant: t.Ingestion.LossyTolerant})
+		}
+		ingestLimiter := ingestlimit.New(redisClient, ingestlimit.NewRepository(db), agentService, eventBus, healthTracker, limits, ingestlimit.Config{
+			Window:       cfg.Ingestion.Window,
+			DefaultLimit: cfg.Ingestion.DefaultLimit,
+			SampleOneIn:  cfg.Ingestion.SampleOneIn,
+		})
+		reportLimit = append(reportLimit, ingestLimiter.Middleware())
+		ingestLimitHandler = ingestlimit.NewHandler(ingestLimiter)
+	}
+
+	// Mensagens entre agentes: entregues no tick seguinte ao envio, com os
+	// ticks das simulações em execução avançados por uma réplica por vez
+	messageBus := agentmsg.NewBus(redisClient, agentService, eventBus, agentmsg.Config{
+		InboxSize:  cfg.Messages.InboxSize,
+		InboxTTL:   cfg.Messages.InboxTTL,
+		MaxPending: cfg.Messages.MaxPending,
+	})
+	eventBus.Subscribe(messageBus.Handle)
+	simulationClock := agentmsg.NewClock(messageBus, agentService, redisClient, cfg.Messages.TickInterval, heartbeat.ID())
+	// Aquecimento das simulações: as amostras dos primeiros warmup_ticks
+	// ficam fora dos KPIs e das métricas por simulação
+	warmupTracker := warmup.NewTracker(agentService, messageBus, redisClient, eventBus)
+
+	// Exportação consistente de uma simulação em execução: ticks pausados,
+	// estado ao vivo gravado e um zip lido de um único snapshot do banco
+	var exportHandler *snapshot.Handler
+	if cfg.Exports.Enabled {
+		exporter := snapshot.New(db, objectStore, simulationClock, messageBus, liveFlusher, redisClient, snapshot.Config{
+			QuiesceTimeout: cfg.Exports.QuiesceTimeout,
+			Timeout:        cfg.Exports.Timeout,
+			LinkExpiry:     cfg.Storage.PresignExpiry,
+		})
+		exporter.LimitSize(projectSettings)
+		exportHandler = snapshot.NewHandler(exporter, agentService)
+	}
+
+	// Métricas por simulação em /metrics: ticks, eventos, mensagens à
+	// espera, agentes ativos e as métricas de agentes escolhidas
+	var simExporter *simmetrics.Exporter
+	if cfg.SimMetrics.Enabled {
+		simExporter = simmetrics.NewExporter(agentService, messageBus, simmetrics.Config{
+			MaxSimulations:  cfg.SimMetrics.MaxSimulations,
+			RefreshInterval: cfg.SimMetrics.RefreshInterval,
+			ActiveStatuses:  cfg.SimMetrics.ActiveStatuses,
+			AgentMetrics:    cfg.SimMetrics.AgentMetrics,
+		})
+		simExporter.SetWarmup(warmupTracker)
+		prometheus.MustRegister(simExporter)
+		eventBus.Subscribe(simExporter.Handle)
+		ready.Register("simulation_metrics", sup.Go("simulation_metrics", simExporter.Run)).SetReady()
+		if rw := cfg.SimMetrics.RemoteWrite; rw.Enabled {
+			// Só as séries de simulação vão por remote write; instance
+			// separa as réplicas, cada uma com os próprios contadores
+			simRegistry := prometheus.NewRegistry()
+			simRegistry.MustRegister(simExporter)
+			labels, _ := simmetrics.ParseLabels(rw.Labels)
+			if _, ok := labels["instance"]; !ok {
+				labels["instance"] = heartbeat.ID()
+			}
+			pusher := simmetrics.NewPusher(simRegistry, outboundClients.Client(outbound.Options{
+				Name:                 "simulation_metrics_remote_write",
+				Timeout:              rw.Timeout,
+				AllowPrivateNetworks: rw.AllowPrivateNetworks,
+			}), simmetrics.RemoteWriteConfig{
+				URL:         rw.URL,
+				Interval:    rw.Interval,
+				Username:    rw.Username,
+				Password:    rw.Password,
+				BearerToken: rw.BearerToken,
+				Labels:      labels,
+			})
+			ready.Register("simulation_metrics_remote_write", sup.Go("simulation_metrics_remote_write", pusher.Run)).SetReady()
+		}
+	}
+
+	// Consumo de energia dos agentes: acumulado a cada tick pelo modelo do
+	// tipo e corrigido pelas leituras dos medidores
+	consumptionRepo := consumption.NewRepository(db)
+	metricObservers := agentmetric.Observers{healthTracker, presenceTracker}
+	var consumptionSource agentmetric.ConsumptionSource
+	var consumptionMeter *consumption.Meter
+	if cfg.Consumption.Enabled {
+		models := map[string]consumption.Model{}
+		for _, t := range cfg.AgentTypes.Definitions {
+			if cm := t.Consumption; cm.WhPerKm > 0 || cm.BaselineW > 0 || cm.MeterMetric != "" {
+				models[t.Name] = consumption.Model{WhPerKm: cm.WhPerKm, BaselineW: cm.BaselineW, MeterMetric: cm.MeterMetric}
+			}
+		}
+		consumptionMeter = consumption.NewMeter(redisClient, agentService, consumptionRepo, models, consumption.MeterConfig{
+			TickInterval:     cfg.Messages.TickInterval,
+			Bucket:           cfg.Consumption.Bucket,
+			InactiveStatuses: cfg.Consumption.InactiveStatuses,
+		})
+		metricObservers = append(metricObservers, consumptionMeter)
+		consumptionSource = consumptionMeter
+	}
+	if simExporter != nil {
+		metricObservers = append(metricObservers, simExporter)
+	}
+	// Anomalias nas métricas: linha de base por agente e métrica, com a
+	// sensibilidade declarada no registro de tipos
+	agentTypeRepo := agenttype.NewRepository(db)
+	agentTypeRegistry := agenttype.NewRegistry(agentTypeRepo)
+	if cfg.Anomalies.Enabled {
+		metricObservers = append(metricObservers, anomaly.NewDetector(redisClient, agentTypeRegistry, healthTracker, eventBus))
+	}
+	// KPIs das simulações: cada réplica soma as referências dos KPIs e uma
+	// por vez, eleita no Redis, os avalia
+	metricRegistry := agentmetric.NewRegistry(metricTypes)
+	kpiRepo := kpi.NewRepository(db)
+	var kpiHandler *kpi.Handler
+	if cfg.KPIs.Enabled {
+		kpiEngine := kpi.NewEngine(kpiRepo, redisClient, eventBus, kpi.NewCatalog(metricRegistry, events.Schemas), kpi.Config{
+			Interval:      cfg.KPIs.Interval,
+			HistoryPoints: cfg.KPIs.HistoryPoints,
+			Retention:     cfg.KPIs.Retention,
+			BufferSize:    cfg.KPIs.BufferSize,
+		}, heartbeat.ID())
+		kpiEngine.SetWarmup(warmupTracker)
+		metricObservers = append(metricObservers, kpiEngine)
+		eventBus.Subscribe(kpiEngine.Handle)
+		ready.Register("kpi_engine", sup.Go("kpi_engine", kpiEngine.Run)).SetReady()
+		kpiHandler = kpi.NewHandler(kpiEngine, kpiRepo, agentService)
+	}
+	consumptionHandler := consumption.NewHandler(consumptionRepo, agentService, consumption.HandlerConfig{
+		Bucket:        cfg.Consumption.Bucket,
+		DefaultBucket: cfg.Consumption.DefaultBucket,
+		MaxBuckets:    cfg.Consumption.MaxBuckets,
+	})
+	// Agregados do painel, lidos das views materializadas enquanto estão
+	// atualizadas
+	rollupRepo := rollup.NewRepository(db)
+	var maxStaleness time.Duration
+	if cfg.Rollups.Enabled {
+		maxStaleness = cfg.Rollups.MaxStaleness
+	}
+	rollupHandler := rollup.NewHandler(rollupRepo, agentService, rollup.HandlerConfig{MaxStaleness: maxStaleness})
+	metricHandler := agentmetric.NewHandler(metricRegistry, agentmetric.NewRepository(db),
+		agentService, metricObservers, consumptionSource, cfg.AgentTypes.MaxSamples)
+
+	// Dependências entre agentes: a falha de um prejudica os que dependem
+	// dele, acompanhando o status pelos eventos de agentes do barramento
+	dependencyRepo := dependency.NewRepository(db, cfg.Dependencies.MaxDepth)
+	dependencyPropagator := dependency.NewPropagator(dependencyRepo, agentService, eventBus,
+		cfg.Dependencies.FailureStatuses, cfg.Dependencies.QueueSize)
+	ready.Register("dependency_propagator", sup.Go("dependency_propagator", dependencyPropagator.Run)).SetReady()
+	eventBus.Subscribe(dependencyPropagator.Handle)
+	dependencyHandler := dependency.NewHandler(dependencyRepo, dependencyPropagator, agentService, dependency.Config{
+		MaxPerAgent: cfg.Dependencies.MaxPerAgent,
+		GraphDepth:  cfg.Dependencies.GraphDepth,
+		MaxDepth:    cfg.Dependencies.MaxDepth,
+	})
+	agentListHandler := agentlist.NewHandler(agentService, healthTracker, dependencyRepo, capabilityStore, presenceTracker)
+	// Orçamento das listagens caras: recusadas com 422 antes de executar
+	var queryBudget *querybudget.Guard
+	if cfg.QueryBudget.Enabled {
+		queryBudget = querybudget.New(db, querybudget.Config{
+			MaxRows:   cfg.QueryBudget.MaxRows,
+			MaxOffset: cfg.QueryBudget.MaxOffset,
+		})
+	}
+
+	messageHandler := agentmsg.NewHandler(messageBus, agentService, cfg.Messages.InboxSize, cfg.Messages.MaxPayloadBytes)
+
+	// Ações agendadas: submetidas ao runner na hora marcada, com a mesma
+	// checagem do registro da API
+	actionSubmitter := action.NewSubmitter(actionRegistry, actionRunner, agentService)
+	scheduleRepo := schedule.NewRepository(db)
+	scheduleHandler := schedule.NewHandler(scheduleRepo, actionSubmitter)
+
+	// Comportamentos de agentes: a decisão de cada agente a cada tick, com
+	// as ações que não são do próprio runner submetidas como as agendadas
+	behaviorRegistry := behavior.NewRegistry(append(behavior.Builtins(), behavior.Scripted(behavior.ScriptLimits{
+		MaxInstructions: cfg.Behaviors.Script.MaxInstructions,
+		Timeout:         cfg.Behaviors.Script.Timeout,
+		MaxMemoryBytes:  cfg.Behaviors.Script.MaxMemoryBytes,
+		MaxScriptBytes:  cfg.Behaviors.Script.MaxScriptBytes,
+	})))
+	behaviorRepo := behavior.NewRepository(db)
+	behaviorHandler := behavior.NewHandler(behaviorRegistry, behaviorRepo, agentService)
+
+	// Trajetórias: amostras de posição gravadas em lote a partir das
+	// alterações dos agentes
+	trajectoryRepo := trajectory.NewRepository(db)
+	if cfg.Trajectories.Enabled {
+		trajectoryRecorder := trajectory.NewRecorder(trajectoryRepo, messageBus, trajectory.RecorderConfig{
+			SampleInterval: cfg.Trajectories.SampleInterval,
+			BatchSize:      cfg.Trajectories.BatchSize,
+			FlushInterval:  cfg.Trajectories.FlushInterval,
+			QueueSize:      cfg.Trajectories.QueueSize,
+		})
+		ready.Register("trajectory_recorder", sup.Go("trajectory_recorder", trajectoryRecorder.Run)).SetReady()
+		eventBus.Subscribe(trajectoryRecorder.Handle)
+	}
+	trajectoryHandler := trajectory.NewHandler(trajectoryRepo, agentService, trajectory.HandlerConfig{
+		DefaultWindow: cfg.Trajectories.DefaultWindow,
+		MaxWindow:     cfg.Trajectories.MaxWindow,
+		MaxPoints:     cfg.Trajectories.MaxPoints,
+	}, queryBudget)
+	// Mapas de calor: as posições agregadas numa grade, do estado atual ou
+	// de um tick passado pelas trajetórias
+	heatmapHandler := heatmap.NewHandler(agentService, trajectoryRepo, messageBus, redisClient, heatmap.Config{
+		MaxCells:        cfg.Heatmaps.MaxCells,
+		DefaultCellSize: cfg.Heatmaps.DefaultCellSize,
+		LiveTTL:         cfg.Heatmaps.LiveTTL,
+		HistoryTTL:      cfg.Heatmaps.HistoryTTL,
+	})
+	// Compactação dos dias antigos: as amostras saem da resolução de
+	// sample_interval para a de trajectories.compaction
+	var compactionHandler *trajectory.CompactionHandler
+	if cc := cfg.Trajectories.Compaction; cc.Enabled {
+		compactor := trajectory.NewCompactor(trajectoryRepo, redisClient, trajectory.CompactionConfig{
+			Interval:       cc.Interval,
+			Age:            cc.Age,
+			Method:         cc.Method,
+			Resolution:     cc.Resolution,
+			ToleranceM:     cc.ToleranceM,
+			ChunkAgents:    cc.ChunkAgents,
+			Retention:      cc.Retention,
+			SampleInterval: cfg.Trajectories.SampleInterval,
+		}, heartbeat.ID())
+		ready.Register("trajectory_compaction", sup.Go("trajectory_compaction", compactor.Run)).SetReady()
+		compactionHandler = trajectory.NewCompactionHandler(compactor)
+	}
+
+	// Exclusão das simulações em segundo plano: as tabelas filhas saem em
+	// lotes, com pausas, e a simulação só no fim
+	var deletionHandler *deletion.Handler
+	if dc := cfg.Deletions; dc.Enabled {
+		deleter := deletion.New(deletion.NewRepository(db), redisClient, deletion.Config{
+			Interval:  dc.Interval,
+			BatchSize: dc.BatchSize,
+			Pause:     dc.Pause,
+		}, heartbeat.ID())
+		ready.Register("simulation_deletion", sup.Go("simulation_deletion", deleter.Run)).SetReady()
+		deletionHandler = deletion.NewHandler(deleter, agentService)
+	}
+
+	// Streaming das posições por simulação: snapshot na inscrição, depois
+	// só os agentes alterados e keyframes periódicos
+	var positionStreamer *positions.Streamer
+	if cfg.Positions.Enabled {
+		positionStreamer = positions.NewStreamer(agentService, positions.Config{
+			DistanceThreshold: cfg.Positions.DistanceThreshold,
+			HeadingThreshold:  cfg.Positions.HeadingThreshold,
+			FlushInterval:     cfg.Positions.FlushInterval,
+			KeyframeInterval:  cfg.Positions.KeyframeInterval,
+			QueueSize:         cfg.Positions.QueueSize,
+			SendBuffer:        cfg.Positions.SendBuffer,
+		})
+		ready.Register("position_stream", sup.Go("position_stream", positionStreamer.Run)).SetReady()
+		eventBus.Subscribe(deliver(positionStreamer.Handle))
+	}
+
+	// Vector tiles dos agentes, cacheados no Redis e invalidados pelos
+	// eventos de agentes nos tiles afetados
+	var tileHandler *tiles.Handler
+	if cfg.Tiles.Enabled {
+		tileConfig := tiles.Config{
+			MaxZoom:       cfg.Tiles.MaxZoom,
+			CacheMaxZoom:  cfg.Tiles.CacheMaxZoom,
+			ThinBelowZoom: cfg.Tiles.ThinBelowZoom,
+			Thinning:      cfg.Tiles.Thinning,
+			ClusterCellPx: cfg.Tiles.ClusterCellPx,
+			SampleLimit:   cfg.Tiles.SampleLimit,
+			MaxFeatures:   cfg.Tiles.MaxFeatures,
+			Attributes:    cfg.Tiles.Attributes,
+			CacheTTL:      cfg.Tiles.CacheTTL,
+			FlushInterval: cfg.Tiles.FlushInterval,
+			QueueSize:     cfg.Tiles.QueueSize,
+		}
+		tileHandler = tiles.NewHandler(tiles.NewRepository(db), agentService, redisClient, tileConfig)
+		tileInvalidator := tiles.NewInvalidator(redisClient, tileConfig)
+		ready.Register("tile_invalidation", sup.Go("tile_invalidation", tileInvalidator.Run)).SetReady()
+		eventBus.Subscribe(deliver(tileInvalidator.Handle))
+	}
+
+	// Cache das respostas das leituras que mudam pouco, por rota, limpo
+	// pelas chaves das entidades alteradas
+	responseCache := httpcache.New(redisClient, httpcache.Config{
+		Enabled:       cfg.HTTPCache.Enabled,
+		DefaultTTL:    cfg.HTTPCache.DefaultTTL,
+		DefaultMaxAge: cfg.HTTPCache.DefaultMaxAge,
+		MaxBodyBytes:  cfg.HTTPCache.MaxBodyBytes,
+	})
+	eventBus.Subscribe(responseCache.Handle)
+	simulationResults := responseCache.Route(httpcache.Route{Keys: []string{httpcache.SimulationKey("{id}"), rollup.CacheKey}})
+	purgeSimulation := responseCache.Purge(httpcache.SimulationKey("{id}"))
+
+	// Relatórios de falha das simulações: um pânico no tick encerra a
+	// simulação com o relatório, sem derrubar o processo
+	failureConfig := failure.Config{
+		RecentEvents:   cfg.Failures.RecentEvents,
+		MaxEventBytes:  cfg.Failures.MaxEventBytes,
+		MaxStackBytes:  cfg.Failures.MaxStackBytes,
+		MaxReportBytes: cfg.Failures.MaxReportBytes,
+		LinkExpiry:     cfg.Failures.LinkExpiry,
+	}
+	failureRepo := failure.NewRepository(db)
+	failureRecorder := failure.NewRecorder(failureRepo, objectStore, eventBus, heartbeat.ID(), failureConfig)
+	eventBus.Subscribe(failureRecorder.Handle)
+	simulationClock.OnPanic(failureRecorder.Panic)
+	failureHandler := failure.NewHandler(failureRepo, agentService, objectStore, failureConfig)
+
+	// Registro de tipos de agente: a criação de agentes só aceita tipos do
+	// registro, que recebe na partida os tipos citados na configuração
+	if n, err := agentTypeRepo.Seed(context.Background(), configAgentTypes(cfg)); err != nil {
+		logrus.WithError(err).Warn("Falha ao registrar os tipos de agente da configuração")
+	} else if n > 0 {
+		logrus.WithField("agent_types", n).Info("Tipos de agente da configuração registrados")
+	}
+	agentTypeHandler := agenttype.NewHandler(agentTypeRegistry, agentTypeRepo, actionRegistry, behaviorRegistry)
+	createAgentMiddleware := []gin.HandlerFunc{agentTypeRegistry.CreateAgent()}
+	createSimulationMiddleware := []gin.HandlerFunc{warmup.CreateSimulation()}
+
+	// Uso de armazenamento e cotas por projeto: com uma cota atingida, as
+	// criações de simulações e agentes do projeto respondem 403
+	var deleteAgentMiddleware []gin.HandlerFunc
+	var quotaHandler *quota.Handler
+	if cfg.Quotas.Enabled {
+		quotaRepo := quota.NewRepository(db)
+		quotaEnforcer := quota.NewEnforcer(quotaRepo, quota.Amounts{
+			Agents:          cfg.Quotas.Defaults.Agents,
+			EventRows:       cfg.Quotas.Defaults.EventRows,
+			CheckpointBytes: cfg.Quotas.Defaults.CheckpointBytes,
+			ArchiveBytes:    cfg.Quotas.Defaults.ArchiveBytes,
+		}, eventBus)
+		quotaTracker := quota.NewTracker(quotaRepo, quotaEnforcer, redisClient, quota.TrackerConfig{
+			FlushInterval:     cfg.Quotas.FlushInterval,
+			QueueSize:         cfg.Quotas.QueueSize,
+			ReconcileInterval: cfg.Quotas.ReconcileInterval,
+		}, heartbeat.ID())
+		ready.Register("quota_tracker", sup.Go("quota_tracker", quotaTracker.Run)).SetReady()
+		eventBus.Subscribe(quotaTracker.Handle)
+		quotaHandler = quota.NewHandler(quotaEnforcer, agentService, eventBus)
+		createAgentMiddleware = append(createAgentMiddleware, quotaHandler.CreateAgent())
+		createSimulationMiddleware = append(createSimulationMiddleware, quotaHandler.CreateSimulation())
+	}
+
+	// Feed de mudanças dos agentes, lido por cursor em GET /agents/changes
+	var changeFeedHandler *changefeed.Handler
+	if cfg.ChangeFeed.Enabled {
+		changeFeed := changefeed.New(redisClient, changefeed.Config{
+			Stream:    cfg.ChangeFeed.Stream,
+			Retention: cfg.ChangeFeed.Retention,
+		})
+		if err := changeFeed.Start(context.Background()); err != nil {
+			logrus.WithError(err).Warn("Falha ao iniciar o feed de mudanças de agentes; a marca inicial fica para a primeira leitura")
+		}
+		eventBus.Subscribe(changeFeed.Handle)
+		changeFeedHandler = changefeed.NewHandler(changeFeed, agentService, changefeed.HandlerConfig{
+			DefaultLimit: cfg.ChangeFeed.DefaultLimit,
+			MaxLimit:     cfg.ChangeFeed.MaxLimit,
+		})
+	}
+
+	// Hooks síncronos: os sistemas externos do projeto aprovam ou vetam a
+	// criação e a remoção de agentes, depois da verificação de cotas
+	var syncHookHandler *synchook.Handler
+	if cfg.SyncHooks.Enabled {
+		syncHookRepo := synchook.NewRepository(db)
+		syncHookInvoker := synchook.NewInvoker(syncHookRepo, synchook.Config{
+			DefaultTimeout:       cfg.SyncHooks.DefaultTimeout,
+			MaxTimeout:           cfg.SyncHooks.MaxTimeout,
+			AllowPrivateNetworks: cfg.SyncHooks.AllowPrivateNetworks,
+		}, outboundClients)
+		syncHookHandler = synchook.NewHandler(syncHookRepo, syncHookInvoker, agentService)
+		createAgentMiddleware = append(createAgentMiddleware, syncHookHandler.CreateAgent())
+		deleteAgentMiddleware = append(deleteAgentMiddleware, syncHookHandler.DeleteAgent())
+	}
+
+	// Grupos de agentes: as ações em grupo viram lotes de ações e os eventos
+	// vão para o tópico group:<id> do hub
+	groupRepo := group.NewRepository(db)
+	groupHandler := group.NewHandler(groupRepo, agentService, actionBatchRunner, eventBus, group.Config{
+		MaxMembers:  cfg.Groups.MaxMembers,
+		StartStatus: cfg.Groups.StartStatus,
+		StopStatus:  cfg.Groups.StopStatus,
+	})
+
+	// Transferência de agentes entre simulações do mesmo projeto e, com a
+	// aprovação do destino, entre projetos
+	transferHandler := transfer.NewHandler(transfer.NewRepository(db), agentService, messageBus, eventBus, transfer.Config{
+		RunningStatuses:  cfg.Transfers.RunningStatuses,
+		PauseStatus:      cfg.Transfers.PauseStatus,
+		DefaultMaxAgents: cfg.Transfers.DefaultMaxAgents,
+		ProjectExpiry:    cfg.Transfers.ProjectExpiry,
+	})
+
+	// Gêmeos digitais: estado reportado pelos gateways e desejado pelos operadores
+	twinHandler := twin.NewHandler(twin.NewRepository(db), agentService, eventBus, twin.Config{
+		MaxDocumentBytes: cfg.Twins.MaxDocumentBytes,
+		MaxDepth:         cfg.Twins.MaxDepth,
+	})
+
+	// Histórico da configuração dos agentes, gravado a partir dos eventos
+	revisionRepo := revision.NewRepository(db)
+	eventBus.Subscribe(revision.NewRecorder(revisionRepo, redisClient, cfg.Revisions.MaxPerAgent).Handle)
+	revisionHandler := revision.NewHandler(revisionRepo, agentService)
+
+	// Reinício de agentes de uma simulação em execução ao estado inicial,
+	// gravado a partir dos eventos
+	resetRepo := agentreset.NewRepository(db)
+	eventBus.Subscribe(agentreset.NewRecorder(resetRepo, agentService).Handle)
+	agentResetter := agentreset.NewResetter(resetRepo, agentService, simulationClock, messageBus, liveFlusher,
+		dependencyPropagator, behaviorRepo, eventBus, redisClient, agentreset.Config{
+			MaxAgents:      cfg.Resets.MaxAgents,
+			QuiesceTimeout: cfg.Resets.QuiesceTimeout,
+		})
+	resetHandler := agentreset.NewHandler(agentResetter, agentService)
+	// Restauração de simulações paradas a partir dos checkpoints, migrados
+	// da versão em que foram gravados
+	checkpointHandler := checkpoint.NewHandler(checkpoint.NewRestorer(objectStore, agentService, messageBus), agentService)
+
+	// Login por OpenID Connect: o serviço emite o próprio JWT após o login e
+	// aceita também os JWTs do provedor emitidos para o client
+	var oidcVerifier *oidc.Verifier
+	var oidcHandler *oidc.Handler
+	if cfg.OIDC.Enabled {
+		oidcClient := outboundClients.Client(outbound.Options{
+			Name:                 "oidc",
+			Timeout:              10 * time.Second,
+			AllowPrivateNetworks: true,
+		})
+		oidcMappings := make([]oidc.RoleMapping, 0, len(cfg.OIDC.RoleMappings))
+		for _, m := range cfg.OIDC.RoleMappings {
+			oidcMappings = append(oidcMappings, oidc.RoleMapping{Group: m.Group, Role: m.Role})
+		}
+		oidcVerifier = oidc.NewVerifier(oidc.Config{
+			IssuerURL:     cfg.OIDC.IssuerURL,
+			ClientID:      cfg.OIDC.ClientID,
+			ClientSecret:  cfg.OIDC.ClientSecret,
+			RedirectURL:   cfg.OIDC.RedirectURL,
+			Scopes:        cfg.OIDC.Scopes,
+			SubjectClaim:  cfg.OIDC.SubjectClaim,
+			GroupsClaim:   cfg.OIDC.GroupsClaim,
+			ProjectsClaim: cfg.OIDC.ProjectsClaim,
+			RoleMappings:  oidcMappings,
+			DefaultRoles:  cfg.OIDC.DefaultRoles,
+			FrontendURL:   cfg.OIDC.FrontendURL,
+			SigningKey:    []byte(cfg.OIDC.Session.SigningKey),
+			SessionTTL:    cfg.OIDC.Session.TTL,
+			RefreshTTL:    cfg.OIDC.Session.RefreshTTL,
+		}, oidc.NewProvider(cfg.OIDC.IssuerURL, oidcClient))
+		oidcHandler = oidc.NewHandler(oidcVerifier, redisClient, oidcClient)
+	}
 
 	// Configurar Gin
-	if viper.GetString("gin.mode") == "release" {
+	if cfg.Gin.Mode == "release" {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
+	i18n.UseJSONFieldNames()
 	router := gin.New()
 	router.Use(gin.Logger())
 	router.Use(gin.Recovery())
 
 	// CORS
-	router.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"http://localhost:3000", "http://localhost:5000"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
-		AllowCredentials: true,
-		MaxAge:           12 * time.Hour,
-	}))
+	router.Use(corsPolicy.Handler())
 
 	// Middleware customizado
 	router.Use(middleware.RequestID())
+	router.Use(logging.Middleware())
+	router.Use(instrument.Middleware())
+	router.Use(i18n.Middleware(cfg.I18n.DefaultLocale))
+	router.Use(auth.StaticToken(cfg.Admin.Token))
+	router.Use(apikey.Middleware(apikey.NewRepository(db)))
+	if oidcVerifier != nil {
+		router.Use(oidc.Middleware(oidcVerifier))
+	}
+	if cfg.Server.TLS.Enabled && cfg.Server.TLS.ClientAuth != tlsutil.ClientAuthNone {
+		router.Use(auth.ClientCertificate(cfg.Server.TLS.ClientRoles))
+	}
+	// Origens, requisições por minuto e timeout do projeto da requisição
+	router.Use(projectSettings.Middleware())
 	router.Use(middleware.Logger())
 	router.Use(middleware.Recovery())
+	// O login fica isento para que um admin possa entrar e encerrar a
+	// manutenção
+	router.Use(maintenanceSwitch.Middleware("/api/v1/admin/maintenance", "/api/v1/me", "/auth/login", "/auth/callback", "/auth/refresh"))
+	// X-Dry-Run: true ensaia as operações destrutivas numa transação
+	// desfeita; as rotas fora da lista respondem 501
+	router.Use(dryrun.Middleware(db,
+		"POST /api/v1/agents/actions/batch",
+		"DELETE /api/v1/groups/:id",
+		"POST /api/v1/groups/:id/members",
+		"DELETE /api/v1/groups/:id/members/:agent_id",
+		"POST /api/v1/groups/:id/start",
+		"POST /api/v1/groups/:id/stop",
+		"POST /api/v1/groups/:id/actions",
+		"POST /api/v1/projects/:id/restore",
+	))
 
 	// Health check
 	router.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{
-			"status":    "ok",
-			"service":   "agent-service",
-			"version":   "1.1.0",
-			"timestamp": time.Now().UTC(),
-		})
+		resp := gin.H{
+			"status":      "ok",
+			"service":     "agent-service",
+			"version":     buildinfo.Version,
+			"timestamp":   time.Now().UTC(),
+			"maintenance": maintenanceSwitch.State(),
+		}
+		if c.Query("verbose") == "true" {
+			resp["log_level"] = logLevels.State()
+			resp["build"] = apiInfo.Environment()
+		}
+		c.JSON(http.StatusOK, resp)
 	})
+	router.GET("/health/ready", ready.Handler())
+
+	// Métricas Prometheus, também em OpenMetrics quando o Accept pede
+	router.GET("/metrics", gin.WrapH(promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}))))
+
+	// Login OIDC
+	if oidcHandler != nil {
+		router.GET("/auth/login", oidcHandler.Login)
+		router.GET("/auth/callback", oidcHandler.Callback)
+		router.POST("/auth/refresh", oidcHandler.Refresh)
+	}
 
 	// Rotas da API
 	v1 := router.Group("/api/v1")
 	{
 		agents := v1.Group("/agents")
 		{
-			agents.GET("", agentHandler.GetAgents)
-			agents.GET("/:id", agentHandler.GetAgent)
-			agents.POST("", agentHandler.CreateAgent)
-			agents.PUT("/:id", agentHandler.UpdateAgent)
-			agents.DELETE("/:id", agentHandler.DeleteAgent)
-			agents.POST("/:id/actions", agentHandler.ExecuteAction)
-			agents.GET("/:id/performance", agentHandler.GetPerformance)
+			agents.GET("", negotiateHandler.GetAgentsByID, queryBudget.Agents(), geoHandler.ListAgents, negotiateHandler.ListAgents, agentListHandler.ListAgents)
+			agents.GET("/nearby", geoHandler.Nearby)
+			agents.POST("/batch-get", negotiateHandler.BatchGet)
+			if changeFeedHandler != nil {
+				agents.GET("/changes", changeFeedHandler.Changes)
+				agents.GET("/changes/snapshot", changeFeedHandler.Snapshot)
+			}
+			agents.GET("/:id", negotiateHandler.GetAgent, agentHandler.GetAgent)
+			agents.POST("", append(createAgentMiddleware, agentHandler.CreateAgent)...)
+			agents.PUT("/:id", append(reportLimit, updateHandlers...)...)
+			agents.DELETE("/:id", append(deleteAgentMiddleware, agentHandler.DeleteAgent)...)
+			agents.POST("/:id/actions", actionHandlers...)
+			agents.GET("/:id/actions/summary", actionHandler.Summary)
+			agents.GET("/:id/actions/:action_id", actionHandler.Get)
+			agents.POST("/:id/actions/:action_id/cancel", actionHandler.Cancel)
+			agents.POST("/actions/batch", auth.RequireRole(auth.RoleOperator), actionBatchHandler.Create)
+			agents.GET("/:id/scheduled-actions", scheduleHandler.List)
+			agents.POST("/:id/scheduled-actions", auth.RequireRole(auth.RoleOperator), scheduleHandler.Create)
+			agents.GET("/:id/scheduled-actions/:schedule_id", scheduleHandler.Get)
+			agents.PUT("/:id/scheduled-actions/:schedule_id", auth.RequireRole(auth.RoleOperator), scheduleHandler.Update)
+			agents.DELETE("/:id/scheduled-actions/:schedule_id", auth.RequireRole(auth.RoleOperator), scheduleHandler.Delete)
+			agents.GET("/:id/performance", metricHandler.Performance, agentHandler.GetPerformance)
+			agents.POST("/:id/metrics", append(reportLimit, metricHandler.Ingest)...)
+			agents.GET("/:id/dependencies", dependencyHandler.Get)
+			agents.PUT("/:id/dependencies", auth.RequireRole(auth.RoleOperator), dependencyHandler.Put)
+			agents.GET("/:id/dependency-graph", dependencyHandler.Graph)
+			agents.POST("/:id/messages", messageHandler.Send)
+			agents.GET("/:id/messages", messageHandler.Inbox)
+			agents.GET("/:id/behavior", behaviorHandler.Get)
+			agents.PUT("/:id/behavior", auth.RequireRole(auth.RoleOperator), behaviorHandler.Set)
+			agents.DELETE("/:id/behavior", auth.RequireRole(auth.RoleOperator), behaviorHandler.Delete)
+			agents.GET("/:id/trajectory", trajectoryHandler.Get)
+			agents.GET("/:id/capabilities", capabilityHandler.Get)
+			agents.PUT("/:id/capabilities", auth.RequireRole(auth.RoleOperator), capabilityHandler.Set)
+			agents.DELETE("/:id/capabilities", auth.RequireRole(auth.RoleOperator), capabilityHandler.Reset)
+			if ingestLimitHandler != nil {
+				agents.GET("/:id/ingestion-limit", ingestLimitHandler.Get)
+				agents.PUT("/:id/ingestion-limit", auth.RequireRole(auth.RoleOperator), ingestLimitHandler.Set)
+				agents.DELETE("/:id/ingestion-limit", auth.RequireRole(auth.RoleOperator), ingestLimitHandler.Reset)
+			}
+			agents.POST("/:id/transfer", auth.RequireRole(auth.RoleOperator), featureFlags.Guard(featureflag.AgentTransfer), transferHandler.Transfer)
+			agents.POST("/:id/transfer-project", auth.RequireRole(auth.RoleOperator), featureFlags.Guard(featureflag.AgentTransfer), transferHandler.RequestProject)
+			agents.GET("/:id/transfer-project", featureFlags.Guard(featureflag.AgentTransfer), transferHandler.GetProject)
+			agents.PUT("/:id/transfer-project/approve", auth.RequireRole(auth.RoleAdmin), featureFlags.Guard(featureflag.AgentTransfer), transferHandler.Approve)
+			agents.PUT("/:id/transfer-project/reject", auth.RequireRole(auth.RoleAdmin), featureFlags.Guard(featureflag.AgentTransfer), transferHandler.Reject)
+			agents.GET("/:id/revisions", revisionHandler.List)
+			agents.GET("/:id/revisions/:n/diff", revisionHandler.Diff)
+			agents.POST("/:id/revisions/:n/rollback", auth.RequireRole(auth.RoleOperator), revisionHandler.Rollback)
+			agents.GET("/:id/twin", twinHandler.Get)
+			if liveHandler != nil {
+				agents.GET("/:id/live", liveHandler.Live)
+			}
+			agents.PUT("/:id/twin", append(reportLimit, presenceTracker.Middleware(), twinHandler.Report)...)
+			agents.PATCH("/:id/twin/desired", auth.RequireRole(auth.RoleOperator), twinHandler.PatchDesired)
 		}
 
 		simulations := v1.Group("/simulations")
 		{
 			simulations.GET("", agentHandler.GetSimulations)
-			simulations.POST("", agentHandler.CreateSimulation)
+			simulations.POST("", append(createSimulationMiddleware, agentHandler.CreateSimulation)...)
 			simulations.GET("/:id", agentHandler.GetSimulation)
-			simulations.PUT("/:id/start", agentHandler.StartSimulation)
-			simulations.PUT("/:id/stop", agentHandler.StopSimulation)
+			if deletionHandler != nil {
+				simulations.DELETE("/:id", auth.RequireRole(auth.RoleOperator), purgeSimulation, deletionHandler.Delete)
+			}
+			simulations.GET("/:id/agents", agentListHandler.SimulationAgents)
+			simulations.GET("/:id/agents.geojson", geoHandler.SimulationAgents)
+			simulations.GET("/:id/heatmap", heatmapHandler.Get)
+			simulations.GET("/:id/consumption", consumptionHandler.Get)
+			simulations.GET("/:id/failure", failureHandler.Get)
+			simulations.GET("/:id/stats/districts", simulationResults, rollupHandler.Districts)
+			simulations.GET("/:id/stats/daily", simulationResults, rollupHandler.Daily)
+			if kpiHandler != nil {
+				simulations.GET("/:id/kpis", kpiHandler.List)
+				simulations.PUT("/:id/kpis/:name", auth.RequireRole(auth.RoleOperator), kpiHandler.Put)
+				simulations.DELETE("/:id/kpis/:name", auth.RequireRole(auth.RoleOperator), kpiHandler.Delete)
+			}
+			if positionStreamer != nil {
+				simulations.GET("/:id/positions/stream", positionStreamer.Stream)
+			}
+			simulations.PUT("/:id/start", purgeSimulation, agentHandler.StartSimulation)
+			simulations.PUT("/:id/stop", purgeSimulation, agentHandler.StopSimulation)
+			if faultHandler != nil {
+				simulations.GET("/:id/faults", faultHandler.List)
+				simulations.POST("/:id/faults", auth.RequireRole(auth.RoleOperator), faultHandler.Create)
+				simulations.GET("/:id/faults/:fault_id", faultHandler.Get)
+				simulations.DELETE("/:id/faults/:fault_id", auth.RequireRole(auth.RoleOperator), faultHandler.Cancel)
+			}
+			simulations.POST("/:id/agents/reset", auth.RequireRole(auth.RoleOperator), resetHandler.Reset)
+			simulations.POST("/:id/checkpoints/:tick/restore", auth.RequireRole(auth.RoleOperator), checkpointHandler.Restore)
+			if exportHandler != nil {
+				simulations.POST("/:id/export", auth.RequireRole(auth.RoleOperator), featureFlags.Guard(featureflag.SimulationExport), exportHandler.Export)
+			}
+		}
+
+		if cfg.Webhooks.Enabled {
+			webhooks := v1.Group("/webhooks", auth.RequireRole(auth.RoleOperator))
+			{
+				webhooks.GET("", webhookHandler.List)
+				webhooks.POST("", webhookHandler.Create)
+				webhooks.GET("/:id", webhookHandler.Get)
+				webhooks.PUT("/:id", webhookHandler.Update)
+				webhooks.DELETE("/:id", webhookHandler.Delete)
+				webhooks.GET("/:id/deliveries", webhookHandler.ListDeliveries)
+				webhooks.POST("/:id/deliveries/:delivery_id/redeliver", webhookHandler.Redeliver)
+			}
+		}
+
+		if cfg.Notifications.Enabled {
+			notifications := v1.Group("/notifications", auth.RequireRole(auth.RoleOperator))
+			{
+				notifications.GET("/settings", notificationHandler.List)
+				notifications.GET("/settings/:project_id", notificationHandler.Get)
+				notifications.PUT("/settings/:project_id", notificationHandler.Put)
+				notifications.DELETE("/settings/:project_id", notificationHandler.Delete)
+				notifications.POST("/test", notificationHandler.Test)
+			}
+		}
+
+		alertRules := v1.Group("/alert-rules", auth.RequireRole(auth.RoleOperator))
+		{
+			alertRules.GET("", alertHandler.List)
+			alertRules.POST("", alertHandler.Create)
+			alertRules.GET("/:id", alertHandler.Get)
+			alertRules.PUT("/:id", alertHandler.Update)
+			alertRules.DELETE("/:id", alertHandler.Delete)
+		}
+		v1.GET("/alerts", alertHandler.ListAlerts)
+
+		agentTypes := v1.Group("/agent-types")
+		{
+			purgeAgentTypes := responseCache.Purge(agenttype.ListCacheKey)
+			agentTypes.GET("", responseCache.Route(httpcache.Route{Keys: []string{agenttype.ListCacheKey}}), agentTypeHandler.List)
+			agentTypes.POST("", auth.RequireRole(auth.RoleAdmin), purgeAgentTypes, agentTypeHandler.Create)
+			agentTypes.GET("/:type", responseCache.Route(httpcache.Route{}), agentTypeHandler.Get)
+			agentTypes.PUT("/:type", auth.RequireRole(auth.RoleAdmin), purgeAgentTypes, agentTypeHandler.Update)
+			agentTypes.DELETE("/:type", auth.RequireRole(auth.RoleAdmin), purgeAgentTypes, agentTypeHandler.Delete)
+		}
+		v1.GET("/agent-types/:type/actions", actionHandler.ListByAgentType)
+		v1.GET("/agent-types/:type/metrics", metricHandler.ListByAgentType)
+		v1.GET("/agent-types/:type/capabilities", capabilityHandler.ListByAgentType)
+		v1.GET("/actions", actionHandler.List)
+		// O registro de comportamentos só muda com uma nova versão
+		v1.GET("/behaviors", responseCache.Route(httpcache.Route{TTL: time.Hour, Keys: []string{"behaviors"}}), behaviorHandler.List)
+		if tileHandler != nil {
+			v1.GET("/tiles/agents/:z/:x/:y", tileHandler.Agents)
+		}
+
+		groups := v1.Group("/groups")
+		{
+			groups.GET("", groupHandler.List)
+			groups.POST("", auth.RequireRole(auth.RoleOperator), groupHandler.Create)
+			groups.GET("/:id", groupHandler.Get)
+			groups.PUT("/:id", auth.RequireRole(auth.RoleOperator), groupHandler.Update)
+			groups.DELETE("/:id", auth.RequireRole(auth.RoleOperator), groupHandler.Delete)
+			groups.GET("/:id/members", groupHandler.Members)
+			groups.POST("/:id/members", auth.RequireRole(auth.RoleOperator), groupHandler.AddMembers)
+			groups.DELETE("/:id/members/:agent_id", auth.RequireRole(auth.RoleOperator), groupHandler.RemoveMember)
+			groups.POST("/:id/start", auth.RequireRole(auth.RoleOperator), groupHandler.Start)
+			groups.POST("/:id/stop", auth.RequireRole(auth.RoleOperator), groupHandler.Stop)
+			groups.POST("/:id/actions", auth.RequireRole(auth.RoleOperator), groupHandler.Action)
+		}
+
+		actionBatches := v1.Group("/action-batches", auth.RequireRole(auth.RoleOperator))
+		{
+			actionBatches.GET("/:id", actionBatchHandler.Get)
+			actionBatches.POST("/:id/cancel", actionBatchHandler.Cancel)
+		}
+
+		if syncHookHandler != nil {
+			syncHooks := v1.Group("/sync-hooks", auth.RequireRole(auth.RoleOperator))
+			{
+				syncHooks.GET("", syncHookHandler.List)
+				syncHooks.POST("", syncHookHandler.Create)
+				syncHooks.GET("/:id", syncHookHandler.Get)
+				syncHooks.PUT("/:id", syncHookHandler.Update)
+				syncHooks.DELETE("/:id", syncHookHandler.Delete)
+			}
+		}
+
+		if quotaHandler != nil {
+			v1.GET("/projects/:id/usage", quotaHandler.Usage)
+		}
+		v1.GET("/projects/:id/settings/effective", projectSettingsHandler.Effective)
+		v1.PUT("/projects/:id/settings", auth.RequireRole(auth.RoleAdmin), projectSettingsHandler.Put)
+		v1.GET("/projects/:id/agent-transfers", featureFlags.Guard(featureflag.AgentTransfer), transferHandler.ListProject)
+		if cfg.Backups.Enabled {
+			backupHandler := backup.NewHandler(backup.New(db, objectStore), eventBus)
+			v1.GET("/projects/:id/backups", backupHandler.List)
+			v1.POST("/projects/:id/backups", auth.RequireRole(auth.RoleOperator), backupHandler.Create)
+			v1.POST("/projects/:id/restore", auth.RequireRole(auth.RoleOperator), backupHandler.Restore)
+		}
+
+		v1.GET("/events", negotiateHandler.ListEvents)
+		v1.GET("/events/poll", pollHandler.Poll)
+		if cfg.Events.Broadcast.Enabled {
+			v1.POST("/internal/broadcast", auth.RequireScope(auth.ScopeBroadcast), broadcastHandler.Broadcast)
+		}
+		v1.GET("/events/schemas", events.ListSchemas)
+		v1.GET("/events/schemas/:event_type", events.GetSchema)
+
+		// GraphQL para o dashboard; Query.events lê os mesmos eventos recentes
+		if cfg.GraphQL.Enabled {
+			graphqlHandler := graph.Handler(graph.Options{
+				Agents:        agentService,
+				Events:        recentEvents,
+				MaxDepth:      cfg.GraphQL.MaxDepth,
+				MaxComplexity: cfg.GraphQL.MaxComplexity,
+				Introspection: cfg.GraphQL.Introspection,
+			})
+			v1.GET("/graphql", graphqlHandler)
+			v1.POST("/graphql", graphqlHandler)
+		}
+
+		v1.GET("/openapi.json", openapi.Handler())
+		v1.GET("/me", auth.Me)
+		v1.GET("/version", apiInfo.Version)
+		if deletionHandler != nil {
+			v1.GET("/jobs/:id", deletionHandler.Job)
+		}
+
+		adminRoutes := v1.Group("/admin", auth.RequireRole(auth.RoleAdmin))
+		{
+			adminRoutes.GET("/log-level", adminHandler.GetLogLevel)
+			adminRoutes.PUT("/log-level", adminHandler.SetLogLevel)
+			adminRoutes.GET("/instances", instanceHandler.ListInstances)
+			adminRoutes.GET("/config", adminHandler.GetConfig)
+			adminRoutes.POST("/config/reload", adminHandler.ReloadConfig)
+			adminRoutes.POST("/refresh-views", responseCache.Purge(rollup.CacheKey), rollupHandler.Refresh)
+			adminRoutes.GET("/components", sup.Handler())
+			adminRoutes.GET("/maintenance", maintenanceHandler.Get)
+			adminRoutes.PUT("/maintenance", maintenanceHandler.Set)
+			adminRoutes.GET("/flags", featureFlagHandler.List)
+			adminRoutes.PUT("/flags/:name", featureFlagHandler.Set)
+			adminRoutes.DELETE("/flags/:name", featureFlagHandler.Reset)
+			if cfg.HTTPCache.Enabled {
+				adminRoutes.POST("/cache/purge", responseCache.PurgeHandler)
+			}
+			if deadLetterHandler != nil {
+				adminRoutes.GET("/dlq", deadLetterHandler.List)
+				adminRoutes.POST("/dlq/retry", deadLetterHandler.RetryAll)
+				adminRoutes.POST("/dlq/:id/retry", deadLetterHandler.Retry)
+			}
+			if quotaHandler != nil {
+				adminRoutes.PUT("/projects/:id/quota", quotaHandler.PutQuota)
+				adminRoutes.DELETE("/projects/:id/quota", quotaHandler.DeleteQuota)
+				adminRoutes.POST("/quotas/reconcile", quotaHandler.Reconcile)
+			}
+			if cfg.MQTT.Enabled {
+				mqttHandler := mqttbridge.NewHandler(mqttRegistry)
+				adminRoutes.GET("/mqtt/devices", mqttHandler.ListDevices)
+				adminRoutes.PUT("/mqtt/devices/:device_id", mqttHandler.RegisterDevice)
+				adminRoutes.DELETE("/mqtt/devices/:device_id", mqttHandler.UnregisterDevice)
+			}
+			if compactionHandler != nil {
+				adminRoutes.GET("/jobs/compaction", compactionHandler.Get)
+			}
+			if cfg.Seed.Enabled {
+				seedHandler := seed.NewHandler(seed.New(db, agentTypeRepo), seedOptions(cfg))
+				adminRoutes.POST("/seed", seedHandler.Seed)
+			}
 		}
 	}
 
-	// WebSocket para comunicação em tempo real
-	wsHub := websocket.NewHub()
-	go wsHub.Run()
+	// O buffer do long-poll recebe tudo o que vai ao hub. Com o repasse, os
+	// clientes de cada réplica também recebem os eventos produzidos nas
+	// outras.
+	hub := pollBuffer.Tee(wsHub)
+	toHub := func(topic string, body []byte) { hub.BroadcastToTopic(topic, json.RawMessage(body)) }
+	if cfg.Events.Relay.Enabled {
+		relay := hubrelay.New(redisClient, hub, heartbeat, hubrelay.Config{
+			Channel:     cfg.Events.Relay.Channel,
+			QueueSize:   cfg.Events.Relay.QueueSize,
+			PeerRefresh: cfg.Events.Relay.PeerRefresh,
+			MaxAttempts: cfg.Events.Relay.MaxAttempts,
+		}, heartbeat.ID())
+		if deadLetters != nil {
+			relay.SetDeadLetter(deadLetters)
+			deadLetters.Register(hubrelay.DeadLetterConsumer, relay)
+		}
+		ready.Register("hub_relay", sup.Go("hub_relay", relay.Run)).SetReady()
+		toHub = relay.Broadcast
+	}
+	websocketPins, err := events.Schemas.ParsePins(cfg.Events.WebsocketVersions)
+	if err != nil {
+		logrus.Fatal("Erro em events.websocket_versions:", err)
+	}
+	// O envelope é serializado uma vez por evento e repassado pronto ao hub,
+	// em vez de ser codificado para cada cliente inscrito no tópico.
+	eventBus.Subscribe(events.Schemas.Pinned(websocketPins, deliver(func(ctx context.Context, e events.Event) {
+		body, err := events.EncodeEnvelope("", e)
+		if err != nil {
+			logging.FromContext(ctx).WithError(err).WithField("event_type", e.VersionedType()).Error("Falha ao serializar evento para o websocket")
+			return
+		}
+		toHub(e.Topic, body)
+	})))
+
+	// Entrega de webhooks a partir do mesmo feed de eventos
+	if cfg.Webhooks.Enabled {
+		ready.Register("webhook_dispatcher", sup.Go("webhook_dispatcher", webhookDispatcher.Run)).SetReady()
+		eventBus.Subscribe(deliver(webhookDispatcher.Handle))
+	}
+
+	// Avaliação das regras de alerta; as transições vão para o hub e as notificações
+	if cfg.Alerts.Enabled {
+		alertEvaluator := alert.NewEvaluator(alertRepo, agentService, presenceTracker, redisClient, eventBus, cfg.Alerts.Interval, heartbeat.ID())
+		ready.Register("alert_evaluator", sup.Go("alert_evaluator", alertEvaluator.Run)).SetReady()
+	}
+
+	// Atualização das views materializadas dos agregados; uma réplica por
+	// vez, eleita no Redis
+	if cfg.Rollups.Enabled {
+		rollupRefresher := rollup.NewRefresher(rollupRepo, redisClient, cfg.Rollups.RefreshInterval, heartbeat.ID())
+		ready.Register("rollup_refresher", sup.Go("rollup_refresher", rollupRefresher.Run)).SetReady()
+	}
+
+	// Detecção de agentes offline por silêncio; uma réplica por vez, eleita
+	// no Redis
+	if cfg.Presence.Enabled {
+		offlineAfter := map[string]time.Duration{}
+		for _, t := range cfg.AgentTypes.Definitions {
+			if t.OfflineAfter > 0 {
+				offlineAfter[t.Name] = t.OfflineAfter
+			}
+		}
+		presenceReaper := presence.NewReaper(redisClient, agentService, eventBus, presence.ReaperConfig{
+			OfflineAfter:  offlineAfter,
+			OfflineStatus: cfg.Presence.OfflineStatus,
+			Interval:      cfg.Presence.Interval,
+			MaxPerCycle:   cfg.Presence.MaxPerCycle,
+		}, heartbeat.ID())
+		ready.Register("presence_reaper", sup.Go("presence_reaper", presenceReaper.Run)).SetReady()
+	}
+
+	// Disparo das ações agendadas; uma réplica por vez, eleita no Redis
+	if cfg.Schedules.Enabled {
+		scheduler := schedule.NewScheduler(scheduleRepo, actionSubmitter, redisClient, eventBus, schedule.Config{
+			Interval: cfg.Schedules.Interval,
+			Grace:    cfg.Schedules.Grace,
+		}, heartbeat.ID())
+		scheduler.PauseWhen(maintenanceSwitch.Paused)
+		ready.Register("action_scheduler", sup.Go("action_scheduler", scheduler.Run)).SetReady()
+	}
+
+	// Ticks das simulações em execução: entregam as mensagens e, em seguida,
+	// rodam os comportamentos dos agentes, a detecção de proximidade e o
+	// consumo de energia
+	simulationClock.PauseWhen(maintenanceSwitch.Paused)
+	if faultInjector != nil {
+		simulationClock.OnTick(faultInjector.Tick)
+	}
+	if simExporter != nil {
+		simulationClock.ObserveTicks(simExporter.ObserveTick)
+	}
+	simulationClock.ObserveTicks(warmupTracker.ObserveTick)
+	if cfg.Behaviors.Enabled {
+		behaviorRunner := behavior.NewRunner(behaviorRegistry, behaviorRepo, agentService, actionSubmitter, messageBus, behavior.RunnerConfig{
+			TickInterval: cfg.Messages.TickInterval,
+			MaxActions:   cfg.Behaviors.MaxActions,
+			InboxSize:    cfg.Messages.InboxSize,
+			Workers:      cfg.Behaviors.Workers,
+			DecideBudget: cfg.Behaviors.DecideBudget,
+		})
+		simulationClock.OnTick(behaviorRunner.Tick)
+	}
+	if cfg.Proximity.Enabled {
+		thresholds := map[string]float64{}
+		for _, t := range cfg.AgentTypes.Definitions {
+			if t.ProximityThreshold > 0 {
+				thresholds[t.Name] = t.ProximityThreshold
+			}
+		}
+		proximityDetector := proximity.NewDetector(agentService, eventBus, proximity.Config{
+			Thresholds: thresholds,
+			MaxEvents:  cfg.Proximity.MaxEventsPerTick,
+		})
+		simulationClock.OnTick(proximityDetector.Tick)
+	}
+	if consumptionMeter != nil {
+		simulationClock.OnTick(consumptionMeter.Tick)
+		// Registrado antes do relógio, para gravar o consumo dos últimos
+		// ticks depois que ele parar
+		consumptionFlusher := consumption.NewFlusher(redisClient, consumptionRepo, cfg.Consumption.FlushInterval, heartbeat.ID())
+		ready.Register("consumption_flusher", sup.Go("consumption_flusher", consumptionFlusher.Run)).SetReady()
+	}
+	// Por último: libera os dependentes dos agentes reiniciados só depois de
+	// um tick em que os demais ganchos terminaram
+	simulationClock.OnTick(agentResetter.Tick)
+	ready.Register("simulation_clock", sup.Go("simulation_clock", simulationClock.Run)).SetReady()
+
+	// Partições do histórico de ações e das trajetórias: cria as dos
+	// próximos dias e descarta, arquivando se configurado, as que saíram da
+	// retenção
+	retentionTables := []action.Table{
+		action.ActionsTable(cfg.Actions.Retention.Window, cfg.Actions.Retention.Archive),
+		{
+			Name:    "agent_positions",
+			Window:  cfg.Trajectories.Retention.Window,
+			Archive: cfg.Trajectories.Retention.Archive,
+			OrderBy: "recorded_at, agent_id, tick",
+		},
+	}
+	if cfg.Trajectories.Compaction.Enabled {
+		retentionTables = append(retentionTables, action.Table{
+			Name:    "agent_positions_compacted",
+			Window:  cfg.Trajectories.Compaction.Retention,
+			Archive: cfg.Trajectories.Retention.Archive,
+			OrderBy: "recorded_at, agent_id, tick",
+		})
+	}
+	actionRetention := action.NewRetention(db, objectStore, redisClient, action.RetentionConfig{
+		Interval:        cfg.Actions.Retention.Interval,
+		PartitionsAhead: cfg.Actions.Retention.PartitionsAhead,
+		Tables:          retentionTables,
+	}, heartbeat.ID())
+	ready.Register("action_retention", sup.Go("action_retention", actionRetention.Run)).SetReady()
+
+	// Notificações do ciclo de vida das simulações e dos alertas
+	if cfg.Notifications.Enabled {
+		ready.Register("notification_dispatcher", sup.Go("notification_dispatcher", notificationDispatcher.Run)).SetReady()
+		eventBus.Subscribe(notificationDispatcher.Handle)
+	}
+
+	// Exportação de eventos para Kafka ou NATS: o outbox grava os eventos num
+	// stream Redis e o relay os repassa ao sink. Desativada, nada se inscreve
+	// no barramento.
+	if cfg.EventExport.Enabled {
+		sink, err := eventSink(cfg.EventExport)
+		if err != nil {
+			logrus.Fatal("Erro ao configurar exportação de eventos:", err)
+		}
+		routes := make([]events.Route, len(cfg.EventExport.Routes))
+		for i, r := range cfg.EventExport.Routes {
+			routes[i] = events.Route{EventType: r.EventType, Subject: r.Subject}
+		}
+		host, _ := os.Hostname()
+		relay := outbox.NewRelay(redisClient, sink, events.NewNamer(cfg.EventExport.SubjectTemplate, routes), outbox.RelayConfig{
+			Stream:       cfg.EventExport.Outbox.Stream,
+			Group:        cfg.EventExport.Outbox.Group,
+			Consumer:     host,
+			BatchSize:    cfg.EventExport.BatchSize,
+			BatchTimeout: cfg.EventExport.BatchTimeout,
+			ClaimIdle:    cfg.EventExport.Outbox.ClaimIdle,
+			RetryBackoff: cfg.EventExport.RetryBackoff,
+			MaxBackoff:   cfg.EventExport.MaxBackoff,
+		})
+		relay.PauseWhen(maintenanceSwitch.Paused)
+		// Registrado antes do outbox para parar depois dele: o outbox grava o
+		// que restou no buffer e o relay ainda tem chance de repassar.
+		stopRelay := sup.Go("event_relay", relay.Run)
+		ready.Register("event_relay", func(ctx context.Context) error {
+			return errors.Join(stopRelay(ctx), relay.Close())
+		}).SetReady()
+
+		eventOutbox := outbox.New(redisClient, outbox.Config{
+			Stream:     cfg.EventExport.Outbox.Stream,
+			MaxLen:     cfg.EventExport.Outbox.MaxLen,
+			BufferSize: cfg.EventExport.Outbox.BufferSize,
+			Topics:     cfg.EventExport.Topics,
+			Sink:       sink.Name(),
+		})
+		ready.Register("event_outbox", sup.Go("event_outbox", eventOutbox.Run)).SetReady()
+		exportPins, err := events.Schemas.ParsePins(cfg.EventExport.Versions)
+		if err != nil {
+			logrus.Fatal("Erro em event_export.versions:", err)
+		}
+		eventBus.Subscribe(events.Schemas.Pinned(exportPins, eventOutbox.Handle))
+	}
 
 	router.GET("/ws", func(c *gin.Context) {
 		websocket.HandleWebSocket(wsHub, c)
 	})
 
+	// Documentação da API; rotas fora da especificação geram aviso no startup
+	router.GET("/docs", auth.RequireRole(auth.RoleViewer), openapi.UIHandler("/api/v1/openapi.json"))
+
+	// Links assinados do armazenamento local; a assinatura dispensa autenticação
+	if fileStore, ok := objectStore.(*storage.FileStore); ok {
+		router.GET("/storage/*key", fileStore.Handler())
+	}
+	if missing, err := openapi.Undocumented(router.Routes()); err != nil {
+		logrus.Error("Erro ao carregar a especificação OpenAPI:", err)
+	} else if len(missing) > 0 {
+		logrus.WithField("routes", missing).Warn("Rotas sem documentação na especificação OpenAPI")
+	}
+
 	// Configurar servidor
 	server := &http.Server{
-		Addr:         viper.GetString("server.host") + ":" + viper.GetString("server.port"),
+		Addr:         net.JoinHostPort(cfg.Server.Host, cfg.Server.Port),
 		Handler:      router,
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
 
-	// Iniciar servidor em goroutine
+	// Iniciar servidor em goroutine; só fica pronto depois que o socket está
+	// aberto. Os sockets podem ser herdados (systemd ou handoff via SIGUSR2);
+	// handoff guarda todos os abertos, para o novo processo não disputar as
+	// portas com este.
+	handoff := map[string]net.Listener{}
+	rawListener, inherited, err := listener.Listen(listener.Options{Name: listener.HTTP, Addr: server.Addr, ReusePort: cfg.Server.ReusePort})
+	if err != nil {
+		logrus.Fatal("Erro ao iniciar servidor:", err)
+	}
+	if inherited {
+		logrus.Info("Listener herdado do processo anterior")
+	}
+	handoff[listener.HTTP] = rawListener
+	ln := rawListener
+	var tlsConfig *tls.Config
+	if cfg.Server.TLS.Enabled {
+		var certReloader *tlsutil.CertReloader
+		tlsConfig, certReloader, err = tlsutil.ServerConfig(tlsutil.ServerOptions{
+			CertFile:     cfg.Server.TLS.CertFile,
+			KeyFile:      cfg.Server.TLS.KeyFile,
+			ClientCAFile: cfg.Server.TLS.ClientCAFile,
+			ClientAuth:   cfg.Server.TLS.ClientAuth,
+		})
+		if err != nil {
+			logrus.Fatal("Erro ao configurar TLS:", err)
+		}
+		if err := certReloader.Watch(); err != nil {
+			logrus.Warn("Recarga automática de certificado indisponível:", err)
+		}
+		ready.Register("tls_cert_reloader", func(context.Context) error { return certReloader.Close() })
+		server.TLSConfig = tlsConfig
+		ln = tls.NewListener(rawListener, tlsConfig)
+	}
+	httpReady := ready.Register("http", server.Shutdown)
 	go func() {
-		logrus.Infof("Servidor de agentes iniciado em %s", server.Addr)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		info := apiInfo.Environment()
+		logrus.WithFields(logrus.Fields{
+			"addr":          server.Addr,
+			"version":       info.Version,
+			"commit":        info.Commit,
+			"build_time":    info.BuildTime,
+			"go_version":    info.GoVersion,
+			"api_version":   info.APIVersion,
+			"feature_flags": info.FeatureFlags,
+			"event_schemas": info.EventSchemas,
+			"content_types": info.ContentTypes,
+			"pagination":    info.Pagination,
+		}).Info("Servidor de agentes iniciado")
+		if err := server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			httpReady.SetNotReady(err)
 			logrus.Fatal("Erro ao iniciar servidor:", err)
 		}
 	}()
+	httpReady.SetReady()
 
-	// Aguardar sinal de interrupção
+	// gRPC em porta própria, com o mesmo serviço de agentes, o mesmo TLS e o
+	// mesmo feed de eventos do websocket
+	if cfg.GRPC.Enabled {
+		grpcAuth := auth.GRPCOptions{Token: cfg.Admin.Token}
+		if tlsConfig != nil && cfg.Server.TLS.ClientAuth != tlsutil.ClientAuthNone {
+			grpcAuth.CertRoles = cfg.Server.TLS.ClientRoles
+		}
+		grpcServer := grpcapi.NewServer(grpcapi.Options{
+			Agents:      checkedAgents,
+			Simulations: agentService,
+			Events:      eventBus,
+			Auth:        grpcAuth,
+			TLSConfig:   tlsConfig,
+			Reflection:  cfg.GRPC.Reflection,
+			OnAction:    onAction,
+			Maintenance: maintenanceSwitch,
+		})
+		grpcListener, _, err := listener.Listen(listener.Options{Name: listener.GRPC, Addr: net.JoinHostPort(cfg.GRPC.Host, cfg.GRPC.Port)})
+		if err != nil {
+			logrus.Fatal("Erro ao iniciar servidor gRPC:", err)
+		}
+		handoff[listener.GRPC] = grpcListener
+		grpcReady := ready.Register("grpc", grpcServer.Shutdown)
+		go func() {
+			logrus.Infof("Servidor gRPC iniciado em %s", grpcListener.Addr())
+			if err := grpcServer.Serve(grpcListener); err != nil {
+				grpcReady.SetNotReady(err)
+				logrus.Error("Erro no servidor gRPC:", err)
+			}
+		}()
+		grpcReady.SetReady()
+	}
+
+	// Registrado depois do HTTP para que, no shutdown, os websockets recebam
+	// close frames antes do servidor parar (Shutdown não espera conexões sequestradas).
+	ready.Register("websocket_drain", wsHub.Shutdown)
+
+	// Heartbeat só começa com o listener aberto; é o primeiro a parar no shutdown
+	if err := heartbeat.Start(context.Background()); err != nil {
+		logrus.Fatal("Erro ao registrar instância:", err)
+	}
+	ready.Register("instance_registry", heartbeat.Stop).SetReady()
+
+	// Listener administrativo de diagnóstico (pprof, expvar, goroutines)
+	if cfg.Debug.Enabled {
+		debugServer, err := debug.NewServer(debug.Config{
+			Host:  cfg.Debug.Host,
+			Port:  cfg.Debug.Port,
+			Token: cfg.Debug.Token,
+		})
+		if err != nil {
+			logrus.Fatal("Erro ao configurar servidor de diagnóstico:", err)
+		}
+		debugListener, _, err := listener.Listen(listener.Options{Name: listener.Debug, Addr: debugServer.Addr})
+		if err != nil {
+			logrus.Fatal("Erro ao iniciar servidor de diagnóstico:", err)
+		}
+		handoff[listener.Debug] = debugListener
+		ready.Register("debug", debugServer.Shutdown).SetReady()
+		go func() {
+			logrus.Infof("Servidor de diagnóstico iniciado em %s", debugListener.Addr())
+			if err := debugServer.Serve(debugListener); err != nil && err != http.ErrServerClosed {
+				logrus.Error("Erro no servidor de diagnóstico:", err)
+			}
+		}()
+	}
+
+	// Aguardar sinal de interrupção; SIGUSR2 entrega os sockets a um novo processo antes de sair
 	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, syscall.SIGUSR2)
+	if sig := <-quit; sig == syscall.SIGUSR2 {
+		proc, err := listener.Handoff(handoff)
+		if err != nil {
+			logrus.Error("Erro no handoff do listener; encerrando sem substituto:", err)
+		} else {
+			logrus.Infof("Listeners entregues ao processo %d", proc.Pid)
+		}
+	}
 
 	logrus.Info("Encerrando servidor...")
 
-	// Graceful shutdown
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	// Drenagem: o probe de prontidão falha primeiro e esperamos o load
+	// balancer tirar esta instância de rotação antes de fechar conexões.
+	ready.Drain()
+	time.Sleep(cfg.Server.Shutdown.DrainDelay)
+
+	// Graceful shutdown: componentes encerrados na ordem inversa da inicialização
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.Shutdown.Timeout)
 	defer cancel()
 
-	if err := server.Shutdown(ctx); err != nil {
+	stopWatch()
+	if err := ready.Shutdown(ctx); err != nil {
 		logrus.Fatal("Erro ao encerrar servidor:", err)
 	}
 
 	logrus.Info("Servidor encerrado")
+	return nil
+}
+
+// watch é o corpo de um componente do supervisor que confere a prontidão
+// de c a cada interval até o encerramento.
+func watch(c *readiness.Component, interval time.Duration, check func(ctx context.Context) error) supervisor.RunFunc {
+	return func(ctx context.Context) error {
+		c.Watch(ctx, interval, check)
+		return nil
+	}
+}
+
+// projectLimitDefaults são os limites de projeto de project_limits.defaults,
+// usados para os projetos sem configuração própria; é relido a cada recarga
+// da configuração.
+func projectLimitDefaults(v *viper.Viper) projectsettings.Values {
+	return projectsettings.Values{
+		RequestsPerMinute: v.GetInt("project_limits.defaults.requests_per_minute"),
+		RequestTimeoutMS:  v.GetDuration("project_limits.defaults.request_timeout").Milliseconds(),
+		ExportMaxBytes:    v.GetInt64("project_limits.defaults.export_max_bytes"),
+		AllowedOrigins:    v.GetStringSlice("project_limits.defaults.allowed_origins"),
+	}
+}
+
+// protocols lista os protocolos anunciados no registro de instâncias.
+func protocols(cfg *config.Config) []string {
+	p := []string{"rest", "websocket"}
+	if cfg.GRPC.Enabled {
+		p = append(p, "grpc")
+	}
+	return p
+}
+
+// configAgentTypes lista os tipos de agente citados na configuração, com
+// as métricas declaradas e as ações que os aceitam, para semear o registro.
+func configAgentTypes(cfg *config.Config) []agenttype.Type {
+	var types []agenttype.Type
+	index := map[string]int{}
+	add := func(name string) *agenttype.Type {
+		if i, ok := index[name]; ok {
+			return &types[i]
+		}
+		index[name] = len(types)
+		types = append(types, agenttype.Type{Name: name, DisplayName: name})
+		return &types[len(types)-1]
+	}
+	for _, d := range cfg.AgentTypes.Definitions {
+		t := add(d.Name)
+		for _, m := range d.Metrics {
+			t.Metrics = append(t.Metrics, agentmetric.Definition{
+				Name: m.Name, Description: m.Description, Unit: m.Unit, Aggregation: m.Aggregation, Anomaly: metricAnomaly(m.Anomaly),
+			})
+		}
+	}
+	for _, a := range cfg.Actions.Definitions {
+		for _, name := range a.AgentTypes {
+			t := add(name)
+			t.AllowedActions = append(t.AllowedActions, a.Name)
+		}
+	}
+	for _, name := range cfg.MQTT.SensorAgentTypes {
+		add(name)
+	}
+	return types
+}
+
+// metricAnomaly converte a sensibilidade de anomalias de uma métrica da
+// configuração; nil sem detecção.
+func metricAnomaly(a *config.AgentMetricAnomalyConfig) *agentmetric.Anomaly {
+	if a == nil {
+		return nil
+	}
+	return &agentmetric.Anomaly{K: a.K, Window: a.Window, MinSamples: a.MinSamples, Baseline: a.Baseline}
+}
+
+// seedOptions monta as opções de carga de demonstração a partir de seed.*.
+func seedOptions(cfg *config.Config) seed.Options {
+	b := cfg.Seed.BBox
+	return seed.Options{
+		AgentsPerType: cfg.Seed.AgentsPerType,
+		ProjectID:     cfg.Seed.ProjectID,
+		History:       cfg.Seed.History,
+		BBox:          seed.BBox{MinLat: b.MinLat, MinLon: b.MinLon, MaxLat: b.MaxLat, MaxLon: b.MaxLon},
+	}
+}
+
+// objectStorage cria o armazenamento de objetos do backend configurado.
+func objectStorage(cfg config.StorageConfig) (storage.Store, error) {
+	switch cfg.Backend {
+	case "s3":
+		tlsConfig, err := clientTLS(cfg.S3.TLS)
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		return storage.NewS3Store(ctx, storage.S3Config{
+			Endpoint:     cfg.S3.Endpoint,
+			Region:       cfg.S3.Region,
+			Bucket:       cfg.S3.Bucket,
+			AccessKey:    cfg.S3.AccessKey,
+			SecretKey:    cfg.S3.SecretKey,
+			PathStyle:    cfg.S3.PathStyle,
+			CreateBucket: cfg.S3.CreateBucket,
+			TLSConfig:    tlsConfig,
+		})
+	case "filesystem":
+		key := []byte(cfg.Filesystem.SigningKey)
+		if len(key) == 0 {
+			key = make([]byte, 32)
+			if _, err := rand.Read(key); err != nil {
+				return nil, err
+			}
+			logrus.Warn("storage.filesystem.signing_key vazia: links de download gerados valem só nesta instância e até reiniciar")
+		}
+		return storage.NewFileStore(storage.FileConfig{
+			Root:       cfg.Filesystem.Root,
+			SigningKey: key,
+			BaseURL:    cfg.Filesystem.PublicURL,
+		})
+	}
+	return nil, fmt.Errorf("storage.backend desconhecido: %q", cfg.Backend)
+}
+
+// notificationChannels cria os notificadores por tipo de canal. O e-mail só existe
+// com notifications.smtp.host configurado.
+func notificationChannels(cfg config.NotificationsConfig, pool *outbound.Pool) (map[string]notification.Notifier, error) {
+	n := map[string]notification.Notifier{
+		notification.ChannelSlack: notification.NewSlack(pool, cfg.Timeout, cfg.AllowPrivateNetworks),
+	}
+	if cfg.SMTP.Host == "" {
+		return n, nil
+	}
+	email, err := notification.NewEmail(notification.SMTPConfig{
+		Host:     cfg.SMTP.Host,
+		Port:     cfg.SMTP.Port,
+		Username: cfg.SMTP.Username,
+		Password: cfg.SMTP.Password,
+		From:     cfg.SMTP.From,
+		TLS:      cfg.SMTP.Security,
+		Timeout:  cfg.Timeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	n[notification.ChannelEmail] = email
+	return n, nil
+}
+
+// mqttConfig converte a configuração tipada na configuração da ponte MQTT.
+func mqttConfig(cfg config.MQTTConfig) (mqttbridge.Config, error) {
+	clientID := cfg.ClientID
+	if clientID == "" {
+		host, _ := os.Hostname()
+		clientID = "agent-service-" + host
+	}
+
+	tlsConfig, err := clientTLS(cfg.TLS)
+	if err != nil {
+		return mqttbridge.Config{}, err
+	}
+
+	return mqttbridge.Config{
+		Brokers:              cfg.Brokers,
+		ClientID:             clientID,
+		Username:             cfg.Username,
+		Password:             cfg.Password,
+		QoS:                  byte(cfg.QoS),
+		TelemetryTopic:       cfg.TelemetryTopic,
+		SharedGroup:          cfg.SharedGroup,
+		CommandTopic:         cfg.CommandTopic,
+		SensorAgentTypes:     cfg.SensorAgentTypes,
+		Workers:              cfg.Workers,
+		CleanSession:         cfg.CleanSession,
+		KeepAlive:            cfg.KeepAlive,
+		ConnectTimeout:       cfg.ConnectTimeout,
+		AutoReconnect:        cfg.Reconnect.Enabled,
+		ConnectRetryInterval: cfg.Reconnect.RetryInterval,
+		MaxReconnectInterval: cfg.Reconnect.MaxInterval,
+		DeadLetterStream:     cfg.DeadLetter.Stream,
+		DeadLetterMaxLen:     cfg.DeadLetter.MaxLen,
+		TLSConfig:            tlsConfig,
+	}, nil
+}
+
+// eventSink cria o sink de exportação de eventos configurado.
+func eventSink(cfg config.EventExportConfig) (events.EventSink, error) {
+	switch cfg.Sink {
+	case "kafka":
+		tlsConfig, err := clientTLS(cfg.Kafka.TLS)
+		if err != nil {
+			return nil, err
+		}
+		return kafkasink.New(kafkasink.Config{
+			Brokers:                cfg.Kafka.Brokers,
+			RequiredAcks:           cfg.Kafka.RequiredAcks,
+			Compression:            cfg.Kafka.Compression,
+			BatchSize:              cfg.BatchSize,
+			WriteTimeout:           cfg.Kafka.WriteTimeout,
+			AllowAutoTopicCreation: cfg.Kafka.AllowAutoTopicCreation,
+			TLSConfig:              tlsConfig,
+		})
+	case "nats":
+		tlsConfig, err := clientTLS(cfg.NATS.TLS)
+		if err != nil {
+			return nil, err
+		}
+		return natssink.New(natssink.Config{
+			URL:             cfg.NATS.URL,
+			Name:            "agent-service",
+			CredentialsFile: cfg.NATS.CredentialsFile,
+			AckTimeout:      cfg.NATS.AckTimeout,
+			TLSConfig:       tlsConfig,
+		})
+	}
+	return nil, fmt.Errorf("event_export.sink desconhecido: %q", cfg.Sink)
+}
+
+// clientTLS monta a configuração TLS de uma conexão de saída; nil se desativado.
+func clientTLS(cfg config.ClientTLSConfig) (*tls.Config, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	return tlsutil.ClientConfig(tlsutil.ClientOptions{
+		CAFile:             cfg.CAFile,
+		CertFile:           cfg.CertFile,
+		KeyFile:            cfg.KeyFile,
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	})
+}
+
+// setupSecrets resolve primeiro os segredos em arquivo (incluindo o token do
+// Vault, se vier de arquivo) e depois os do provider externo configurado. O
+// pool das chamadas de saída é criado aqui, antes da validação completa, para
+// que o Vault já o use; chaves inválidas de outbound ainda são recusadas por
+// config.Parse.
+func setupSecrets(ctx context.Context) (*secrets.Manager, *outbound.Pool, error) {
+	if err := secrets.NewManager(nil).Resolve(ctx, viper.GetViper()); err != nil {
+		return nil, nil, err
+	}
+	var outboundCfg config.OutboundConfig
+	if err := viper.UnmarshalKey("outbound", &outboundCfg); err != nil {
+		return nil, nil, fmt.Errorf("outbound: %w", err)
+	}
+	pool, err := outboundPool(outboundCfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("outbound: %w", err)
+	}
+
+	var vault secrets.Provider
+	switch provider := viper.GetString("secrets.provider"); provider {
+	case "":
+	case "vault":
+		vault = secrets.NewVaultProvider(secrets.VaultConfig{
+			Address: viper.GetString("secrets.vault.address"),
+			Token:   viper.GetString("secrets.vault.token"),
+			Mount:   viper.GetString("secrets.vault.mount"),
+		}, pool)
+	default:
+		return nil, nil, fmt.Errorf("secrets.provider desconhecido: %q", provider)
+	}
+
+	manager := secrets.NewManager(vault)
+	return manager, pool, manager.Resolve(ctx, viper.GetViper())
 }
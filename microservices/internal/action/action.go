@@ -0,0 +1,109 @@
+package action
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotFound indica que a execução não existe para o agente informado.
+var ErrNotFound = errors.New("action not found")
+
+// Estados de uma execução. Uma falha com tentativas restantes volta para
+// queued com attempt incrementado; timed_out é o timeout da última
+// tentativa.
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+	StatusTimedOut  = "timed_out"
+)
+
+// Prioridades de uma execução, da mais alta para a mais baixa. A fila
+// entrega as mais altas primeiro; normal é o padrão.
+const (
+	PriorityCritical = "critical"
+	PriorityHigh     = "high"
+	PriorityNormal   = "normal"
+	PriorityLow      = "low"
+)
+
+// priorityLevels ordena as prioridades; critical nunca é alcançada pelo
+// envelhecimento das demais.
+var priorityLevels = map[string]int{PriorityLow: 0, PriorityNormal: 1, PriorityHigh: 2, PriorityCritical: 3}
+
+// priorityNames é o inverso de priorityLevels.
+var priorityNames = []string{PriorityLow, PriorityNormal, PriorityHigh, PriorityCritical}
+
+// ValidPriority informa se p é uma prioridade conhecida; vazio vale normal.
+func ValidPriority(p string) bool {
+	_, ok := priorityLevels[p]
+	return ok || p == ""
+}
+
+// Tipos dos eventos publicados a cada transição, no tópico agents.
+const (
+	EventQueued    = "agent.action.queued"
+	EventRunning   = "agent.action.running"
+	EventSucceeded = "agent.action.succeeded"
+	EventFailed    = "agent.action.failed"
+	EventCancelled = "agent.action.cancelled"
+	EventTimedOut  = "agent.action.timed_out"
+)
+
+// Action é uma execução assíncrona de uma ação em um agente.
+type Action struct {
+	ID        string                 `json:"id"`
+	AgentID   string                 `json:"agent_id"`
+	ProjectID string                 `json:"project_id,omitempty"`
+	Action    string                 `json:"action"`
+	Params    map[string]interface{} `json:"params"`
+	Status    string                 `json:"status"`
+	// Priority é a prioridade pedida. Na fila, EffectivePriority é a
+	// prioridade já com o envelhecimento e QueuePosition a posição na
+	// ordem de entrega, a partir de 1; os dois só aparecem em queued.
+	Priority          string `json:"priority"`
+	EffectivePriority string `json:"effective_priority,omitempty"`
+	QueuePosition     int64  `json:"queue_position,omitempty"`
+	Attempt           int    `json:"attempt"`
+	// MaxAttempts e RetryBackoffMs vêm da política de retry da ação.
+	MaxAttempts    int   `json:"max_attempts"`
+	RetryBackoffMs int64 `json:"retry_backoff_ms"`
+	// TimeoutMs limita cada tentativa.
+	TimeoutMs int64 `json:"timeout_ms"`
+	// ResultID é o action_id devolvido pelo serviço de agentes.
+	ResultID string                 `json:"result_id,omitempty"`
+	Result   map[string]interface{} `json:"result,omitempty"`
+	Error    string                 `json:"error,omitempty"`
+	// Reason explica o cancelamento ou o timeout; Result guarda então o
+	// resultado parcial, se o executor devolveu algum.
+	Reason     string     `json:"reason,omitempty"`
+	CreatedBy  string     `json:"created_by,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// Finished informa se a execução chegou a um estado final.
+func (a *Action) Finished() bool {
+	switch a.Status {
+	case StatusSucceeded, StatusFailed, StatusCancelled, StatusTimedOut:
+		return true
+	}
+	return false
+}
+
+func (a *Action) timeout() time.Duration {
+	return time.Duration(a.TimeoutMs) * time.Millisecond
+}
+
+// backoff retorna a espera antes da próxima tentativa: RetryBackoffMs
+// dobrando a cada tentativa já feita.
+func (a *Action) backoff() time.Duration {
+	delay := time.Duration(a.RetryBackoffMs) * time.Millisecond
+	for i := 1; i < a.Attempt; i++ {
+		delay *= 2
+	}
+	return delay
+}
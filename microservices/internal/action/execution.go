@@ -0,0 +1,129 @@
+package action
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Execution é o estado de uma execução em andamento, gravado em
+// agent_action_executions enquanto um worker a executa: quem executa, o
+// último checkpoint e o batimento que mostra que o worker está vivo.
+type Execution struct {
+	ActionID        string
+	ActionCreatedAt time.Time
+	AgentID         string
+	// Executor é o executor que começou a execução; só ele a retoma.
+	Executor   string
+	Instance   string
+	Checkpoint map[string]interface{}
+	// Resumes conta as retomadas depois de um reinício.
+	Resumes     int
+	HeartbeatAt time.Time
+}
+
+func scanExecution(row interface{ Scan(...interface{}) error }) (*Execution, error) {
+	var x Execution
+	var checkpoint []byte
+	err := row.Scan(&x.ActionID, &x.ActionCreatedAt, &x.AgentID, &x.Executor, &x.Instance, &checkpoint, &x.Resumes, &x.HeartbeatAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if checkpoint != nil {
+		if err := json.Unmarshal(checkpoint, &x.Checkpoint); err != nil {
+			return nil, err
+		}
+	}
+	return &x, nil
+}
+
+const executionColumns = `action_id, action_created_at, agent_id, executor, COALESCE(instance_id, ''), checkpoint, resumes, heartbeat_at`
+
+// StartExecution grava que a instância começou a executar a ação. Se já
+// havia um registro, a ação foi devolvida à fila por uma retomada: ele é
+// assumido com o checkpoint e Resumes incrementado.
+func (r *Repository) StartExecution(ctx context.Context, a *Action, executor, instance string) (*Execution, error) {
+	return scanExecution(r.db.QueryRow(ctx, "action.start_execution", `
+		INSERT INTO agent_action_executions (action_id, action_created_at, agent_id, executor, instance_id)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (action_id) DO UPDATE SET
+			executor = EXCLUDED.executor, instance_id = EXCLUDED.instance_id,
+			resumes = agent_action_executions.resumes + 1, heartbeat_at = CURRENT_TIMESTAMP
+		RETURNING `+executionColumns,
+		a.ID, a.CreatedAt, a.AgentID, executor, instance))
+}
+
+// Heartbeat renova o batimento da execução. Retorna false se outra
+// instância a assumiu.
+func (r *Repository) Heartbeat(ctx context.Context, actionID, instance string) (bool, error) {
+	res, err := r.db.Exec(ctx, "action.heartbeat", `
+		UPDATE agent_action_executions SET heartbeat_at = CURRENT_TIMESTAMP
+		WHERE action_id = $1 AND instance_id = $2`, actionID, instance)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// SaveCheckpoint grava o checkpoint e renova o batimento. Retorna false se
+// outra instância assumiu a execução.
+func (r *Repository) SaveCheckpoint(ctx context.Context, actionID, instance string, state map[string]interface{}) (bool, error) {
+	raw, err := jsonOrNull(state)
+	if err != nil {
+		return false, err
+	}
+	res, err := r.db.Exec(ctx, "action.save_checkpoint", `
+		UPDATE agent_action_executions SET checkpoint = $3, heartbeat_at = CURRENT_TIMESTAMP
+		WHERE action_id = $1 AND instance_id = $2`, actionID, instance, raw)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// EndExecution apaga o registro da execução; com instance, só se ela
+// ainda for da instância.
+func (r *Repository) EndExecution(ctx context.Context, actionID, instance string) error {
+	_, err := r.db.Exec(ctx, "action.end_execution", `
+		DELETE FROM agent_action_executions WHERE action_id = $1 AND ($2 = '' OR instance_id = $2)`,
+		actionID, instance)
+	return err
+}
+
+// StaleExecutions lista as execuções sem batimento há mais de staleAfter,
+// das mais antigas para as mais novas.
+func (r *Repository) StaleExecutions(ctx context.Context, staleAfter time.Duration, limit int) ([]*Execution, error) {
+	rows, err := r.db.Query(ctx, "action.stale_executions", `
+		SELECT `+executionColumns+` FROM agent_action_executions
+		WHERE heartbeat_at < CURRENT_TIMESTAMP - $1 * interval '1 millisecond'
+		ORDER BY heartbeat_at LIMIT $2`, staleAfter.Milliseconds(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []*Execution
+	for rows.Next() {
+		x, err := scanExecution(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, x)
+	}
+	return out, rows.Err()
+}
+
+// ClaimExecution assume uma execução ainda sem batimento, para que só uma
+// réplica a recupere. Retorna ErrNotFound se outra chegou antes.
+func (r *Repository) ClaimExecution(ctx context.Context, actionID, instance string, staleAfter time.Duration) (*Execution, error) {
+	return scanExecution(r.db.QueryRow(ctx, "action.claim_execution", `
+		UPDATE agent_action_executions SET instance_id = $2, heartbeat_at = CURRENT_TIMESTAMP
+		WHERE action_id = $1 AND heartbeat_at < CURRENT_TIMESTAMP - $3 * interval '1 millisecond'
+		RETURNING `+executionColumns, actionID, instance, staleAfter.Milliseconds()))
+}
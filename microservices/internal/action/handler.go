@@ -0,0 +1,387 @@
+package action
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/audit"
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/i18n"
+	"smart-city-microservices/internal/logging"
+)
+
+// AgentGetter é o subconjunto de agent.Service usado pelo handler.
+type AgentGetter interface {
+	GetAgent(ctx context.Context, id string) (*agent.Agent, error)
+}
+
+// Limites de GET /actions.
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 500
+)
+
+// HistoryConfig limita o intervalo de tempo das consultas ao histórico.
+type HistoryConfig struct {
+	// DefaultWindow é o intervalo até to quando from não é informado.
+	DefaultWindow time.Duration
+	MaxWindow     time.Duration
+}
+
+// Handler confere as ações contra o registro, desvia as demoradas para o
+// runner e expõe o acompanhamento, o histórico e o registro.
+type Handler struct {
+	registry *Registry
+	repo     *Repository
+	runner   *Runner
+	agents   AgentGetter
+	history  HistoryConfig
+}
+
+// NewHandler cria o handler de ações.
+func NewHandler(registry *Registry, repo *Repository, runner *Runner, agents AgentGetter, history HistoryConfig) *Handler {
+	return &Handler{registry: registry, repo: repo, runner: runner, agents: agents, history: history}
+}
+
+// Middleware deve ser o primeiro de POST /agents/:id/actions. Confere a
+// ação contra o registro e responde 422 com as ações aceitas pelo tipo do
+// agente quando ela não é aceita ou params não segue o schema, e 403 com
+// os papéis ou escopos exigidos quando o principal não pode executá-la.
+// Ações demoradas são gravadas com a prioridade do campo priority e
+// respondidas com 202 sem chegar ao handler síncrono; as demais seguem
+// adiante, com o timeout da definição, se houver.
+func (h *Handler) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.Next()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		var req agent.ActionRequest
+		if err := json.Unmarshal(body, &req); err != nil || req.Action == "" {
+			c.Next()
+			return
+		}
+		var opts struct {
+			Priority string `json:"priority"`
+		}
+		_ = json.Unmarshal(body, &opts)
+		if !ValidPriority(opts.Priority) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "invalid priority: " + opts.Priority + " (use critical, high, normal or low)",
+			})
+			return
+		}
+		ag, err := h.agents.GetAgent(c.Request.Context(), c.Param("id"))
+		if errors.Is(err, agent.ErrNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "agent not found"})
+			return
+		}
+		if err != nil {
+			h.internalError(c, err)
+			c.Abort()
+			return
+		}
+		if !h.check(c, ag, req) {
+			c.Abort()
+			return
+		}
+		def, ok := h.registry.Lookup(req.Action)
+		if !ok {
+			c.Next()
+			return
+		}
+		if !def.LongRunning {
+			if def.Timeout > 0 {
+				ctx, cancel := context.WithTimeout(c.Request.Context(), def.Timeout)
+				defer cancel()
+				c.Request = c.Request.WithContext(ctx)
+			}
+			c.Next()
+			return
+		}
+		h.submit(c, def, ag, req, opts.Priority)
+		c.Abort()
+	}
+}
+
+// check responde 422, ou 403 pela política das ações, e retorna false se o
+// registro recusa a ação.
+func (h *Handler) check(c *gin.Context, ag *agent.Agent, req agent.ActionRequest) bool {
+	err := h.registry.CheckAgent(c.Request.Context(), ag, req)
+	var unsupported *UnsupportedError
+	var invalid *InvalidParamsError
+	var missing *MissingCapabilitiesError
+	var forbidden *ForbiddenError
+	switch {
+	case err == nil:
+		return true
+	case errors.As(err, &forbidden):
+		c.JSON(http.StatusForbidden, ForbiddenBody(forbidden))
+	case errors.As(err, &unsupported):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":             err.Error(),
+			"agent_type":        unsupported.AgentType,
+			"supported_actions": unsupported.Supported,
+		})
+	case errors.As(err, &invalid):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	case errors.As(err, &missing):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "missing_capabilities": missing.Missing})
+	default:
+		h.internalError(c, err)
+	}
+	return false
+}
+
+// ForbiddenBody é o corpo do 403 de uma ação recusada pela política, com
+// os papéis e escopos exigidos; os handlers de agendamentos e lotes o
+// reutilizam.
+func ForbiddenBody(e *ForbiddenError) gin.H {
+	body := gin.H{"error": e.Error(), "action": e.Action}
+	if len(e.Roles) > 0 {
+		body["required_roles"] = e.Roles
+	}
+	if len(e.Scopes) > 0 {
+		body["required_scopes"] = e.Scopes
+	}
+	if e.ProjectID != "" {
+		body["project_id"] = e.ProjectID
+	}
+	return body
+}
+
+func (h *Handler) submit(c *gin.Context, def Definition, ag *agent.Agent, req agent.ActionRequest, priority string) {
+	ctx := c.Request.Context()
+	a := newAction(def, ag, req, priority)
+	if p := auth.FromContext(ctx); p != nil {
+		a.CreatedBy = p.Subject
+	}
+	a, err := h.runner.Submit(ctx, a)
+	if errors.Is(err, ErrQueueFull) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	audit.Record(ctx, "agent_action.queued", logrus.Fields{
+		"action_id": a.ID, "agent_id": a.AgentID, "action": a.Action, "priority": a.Priority,
+	})
+	c.Header("Location", "/api/v1/agents/"+a.AgentID+"/actions/"+a.ID)
+	c.JSON(http.StatusAccepted, a)
+}
+
+// ActionInfo descreve uma ação do registro para a interface montar o
+// formulário.
+type ActionInfo struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	AgentTypes  []string `json:"agent_types,omitempty"`
+	// Capabilities são as capacidades exigidas do agente.
+	Capabilities []string `json:"capabilities,omitempty"`
+	// Roles e Scopes são os papéis e escopos que liberam a ação, sem as
+	// restrições dos projetos.
+	Roles       []string `json:"roles,omitempty"`
+	Scopes      []string `json:"scopes,omitempty"`
+	LongRunning bool     `json:"long_running"`
+	TimeoutMs   int64    `json:"timeout_ms,omitempty"`
+	Retry       struct {
+		MaxAttempts int   `json:"max_attempts"`
+		BackoffMs   int64 `json:"backoff_ms"`
+	} `json:"retry"`
+	// Schema é o JSON Schema de params.
+	Schema map[string]interface{} `json:"schema"`
+}
+
+// ListByAgentType lista as ações aceitas pelo tipo de agente, com o schema
+// de cada uma.
+func (h *Handler) ListByAgentType(c *gin.Context) {
+	defs := h.registry.ForAgentType(c.Param("type"))
+	out := make([]ActionInfo, 0, len(defs))
+	for _, d := range defs {
+		info := ActionInfo{
+			Name:         d.Name,
+			Description:  d.Description,
+			AgentTypes:   d.AgentTypes,
+			Capabilities: d.Capabilities,
+			Roles:        d.Roles,
+			Scopes:       d.Scopes,
+			LongRunning:  d.LongRunning,
+			TimeoutMs:    d.Timeout.Milliseconds(),
+			Schema:       d.Schema,
+		}
+		info.Retry.MaxAttempts, info.Retry.BackoffMs = d.Retry.MaxAttempts, d.Retry.Backoff.Milliseconds()
+		if info.Schema == nil {
+			info.Schema = map[string]interface{}{"type": "object"}
+		}
+		out = append(out, info)
+	}
+	c.JSON(http.StatusOK, gin.H{"data": out})
+}
+
+// Get retorna o estado e, ao terminar, o resultado de uma execução. Na
+// fila, inclui a prioridade efetiva e a posição.
+func (h *Handler) Get(c *gin.Context) {
+	ctx := c.Request.Context()
+	a, err := h.repo.Get(ctx, c.Param("id"), c.Param("action_id"))
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	if err := h.runner.Describe(ctx, a); err != nil {
+		logging.FromContext(ctx).WithError(err).Warn("Falha ao consultar a posição da ação na fila")
+	}
+	c.JSON(http.StatusOK, a)
+}
+
+// Cancel cancela a execução se ela ainda não terminou, com o motivo
+// opcional do corpo. Execuções já terminadas respondem 409.
+func (h *Handler) Cancel(c *gin.Context) {
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		i18n.BindError(c, err)
+		return
+	}
+	if req.Reason == "" {
+		req.Reason = "cancelled by request"
+	}
+	ctx := c.Request.Context()
+	a, err := h.runner.Cancel(ctx, c.Param("id"), c.Param("action_id"), req.Reason)
+	var finished *FinishedError
+	switch {
+	case errors.As(err, &finished):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		h.internalError(c, err)
+		return
+	}
+	audit.Record(ctx, "agent_action.cancelled", logrus.Fields{
+		"action_id": a.ID, "agent_id": a.AgentID, "action": a.Action, "reason": a.Reason,
+	})
+	c.JSON(http.StatusOK, a)
+}
+
+// List responde GET /actions com o histórico de execuções, das mais
+// recentes para as mais antigas, filtrado por ?agent_id=, ?status=, ?from=
+// e ?to= (RFC 3339). O intervalo é sempre aplicado: sem from, vale o
+// intervalo padrão até to (padrão agora); intervalos maiores que o máximo
+// são recusados. A próxima página vem de ?cursor=next_cursor.
+func (h *Handler) List(c *gin.Context) {
+	f := HistoryFilter{AgentID: c.Query("agent_id"), Status: c.Query("status"), Limit: defaultHistoryLimit}
+	if f.AgentID != "" {
+		if _, err := uuid.Parse(f.AgentID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid agent_id: " + f.AgentID})
+			return
+		}
+	}
+	if f.Status != "" && !knownStatus(f.Status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid status: " + f.Status})
+		return
+	}
+	var ok bool
+	if f.To, ok = h.timeParam(c, "to", time.Now()); !ok {
+		return
+	}
+	if f.From, ok = h.timeParam(c, "from", f.To.Add(-h.history.DefaultWindow)); !ok {
+		return
+	}
+	switch {
+	case !f.From.Before(f.To):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return
+	case f.To.Sub(f.From) > h.history.MaxWindow:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "time range exceeds " + h.history.MaxWindow.String()})
+		return
+	}
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit: " + v})
+			return
+		}
+		f.Limit = min(n, maxHistoryLimit)
+	}
+	if v := c.Query("cursor"); v != "" {
+		cursor, err := ParseCursor(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+			return
+		}
+		f.After = cursor
+	}
+
+	list, next, err := h.repo.History(c.Request.Context(), f)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	resp := gin.H{"data": list}
+	if next != nil {
+		resp["next_cursor"] = next.Encode()
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// timeParam lê um parâmetro RFC 3339 da query, ou retorna def se ausente.
+// Responde 400 e retorna false se o valor é inválido.
+func (h *Handler) timeParam(c *gin.Context, name string, def time.Time) (time.Time, bool) {
+	v := c.Query(name)
+	if v == "" {
+		return def, true
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + name + ": expected RFC 3339 timestamp"})
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+func knownStatus(s string) bool {
+	switch s {
+	case StatusQueued, StatusRunning, StatusSucceeded, StatusFailed, StatusCancelled, StatusTimedOut:
+		return true
+	}
+	return false
+}
+
+// Summary retorna, por ação, as contagens por estado final e a duração e
+// latência médias e máximas das execuções encerradas do agente. Os
+// contadores cobrem também o histórico já descartado pela retenção.
+func (h *Handler) Summary(c *gin.Context) {
+	stats, err := h.repo.Summary(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": stats})
+}
+
+func (h *Handler) internalError(c *gin.Context, err error) {
+	logging.FromContext(c.Request.Context()).WithError(err).Error("Erro no handler de ações")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
@@ -0,0 +1,154 @@
+package action
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// HistoryFilter filtra o histórico de execuções. From e To são sempre
+// aplicados a created_at, para que o PostgreSQL só leia as partições do
+// intervalo.
+type HistoryFilter struct {
+	AgentID string
+	Status  string
+	From    time.Time
+	To      time.Time
+	Limit   int
+	// After continua a listagem depois da última execução da página
+	// anterior.
+	After *Cursor
+}
+
+// Cursor é a posição de uma execução na ordem do histórico (created_at e
+// id, decrescentes).
+type Cursor struct {
+	CreatedAt time.Time
+	ID        string
+}
+
+// Encode serializa o cursor para next_cursor.
+func (c Cursor) Encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + c.ID))
+}
+
+// ParseCursor lê um cursor gerado por Encode.
+func ParseCursor(s string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	ts, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return nil, errors.New("cursor sem separador")
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, err
+	}
+	return &Cursor{CreatedAt: createdAt, ID: id}, nil
+}
+
+// History lista as execuções do filtro, das mais recentes para as mais
+// antigas. Retorna o cursor da próxima página, ou nil na última.
+func (r *Repository) History(ctx context.Context, f HistoryFilter) ([]Action, *Cursor, error) {
+	// nil, e não "", para que o filtro ausente não vire comparação.
+	var agentID, status, afterAt, afterID interface{}
+	if f.AgentID != "" {
+		agentID = f.AgentID
+	}
+	if f.Status != "" {
+		status = f.Status
+	}
+	if f.After != nil {
+		afterAt, afterID = f.After.CreatedAt, f.After.ID
+	}
+	rows, err := r.db.Query(ctx, "action.history", `
+		SELECT `+actionColumns+` FROM agent_actions
+		WHERE created_at >= $1 AND created_at < $2
+			AND ($3::uuid IS NULL OR agent_id = $3::uuid)
+			AND ($4::text IS NULL OR status = $4::text)
+			AND ($5::timestamptz IS NULL OR (created_at, id) < ($5::timestamptz, $6::uuid))
+		ORDER BY created_at DESC, id DESC
+		LIMIT $7`,
+		f.From, f.To, agentID, status, afterAt, afterID, f.Limit+1)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	out := []Action{}
+	for rows.Next() {
+		a, err := scanAction(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		out = append(out, *a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(out) <= f.Limit {
+		return out, nil, nil
+	}
+	out = out[:f.Limit]
+	last := out[len(out)-1]
+	return out, &Cursor{CreatedAt: last.CreatedAt, ID: last.ID}, nil
+}
+
+// Stats resume as execuções encerradas de um agente e ação, a partir dos
+// contadores de agent_action_stats, sem ler agent_actions.
+type Stats struct {
+	Action         string     `json:"action"`
+	Total          int64      `json:"total"`
+	Succeeded      int64      `json:"succeeded"`
+	Failed         int64      `json:"failed"`
+	Cancelled      int64      `json:"cancelled"`
+	TimedOut       int64      `json:"timed_out"`
+	AvgDurationMs  int64      `json:"avg_duration_ms"`
+	MaxDurationMs  int64      `json:"max_duration_ms"`
+	AvgLatencyMs   int64      `json:"avg_latency_ms"`
+	MaxLatencyMs   int64      `json:"max_latency_ms"`
+	LastFinishedAt *time.Time `json:"last_finished_at,omitempty"`
+}
+
+// Summary retorna os contadores do agente por ação. A duração vai do
+// início da execução ao fim; a latência, da criação ao fim.
+func (r *Repository) Summary(ctx context.Context, agentID string) ([]Stats, error) {
+	if _, err := uuid.Parse(agentID); err != nil {
+		return []Stats{}, nil
+	}
+	rows, err := r.db.Query(ctx, "action.summary", `
+		SELECT action, total, succeeded, failed, cancelled, timed_out,
+			duration_ms_sum / GREATEST(total, 1), duration_ms_max,
+			latency_ms_sum / GREATEST(total, 1), latency_ms_max, last_finished_at
+		FROM agent_action_stats WHERE agent_id = $1
+		ORDER BY action`, agentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []Stats{}
+	for rows.Next() {
+		var s Stats
+		var last sql.NullTime
+		if err := rows.Scan(&s.Action, &s.Total, &s.Succeeded, &s.Failed, &s.Cancelled, &s.TimedOut,
+			&s.AvgDurationMs, &s.MaxDurationMs, &s.AvgLatencyMs, &s.MaxLatencyMs, &last); err != nil {
+			return nil, err
+		}
+		if last.Valid {
+			s.LastFinishedAt = &last.Time
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,109 @@
+package action
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/auth"
+)
+
+// ErrForbidden é satisfeito pelos erros de ForbiddenError.
+var ErrForbidden = errors.New("action not allowed")
+
+// ProjectPolicy fornece as restrições de cada projeto às ações;
+// *projectsettings.Settings a implementa.
+type ProjectPolicy interface {
+	// ActionRoles retorna os papéis aos quais o projeto restringe a ação;
+	// vazio não restringe.
+	ActionRoles(projectID, action string) []string
+}
+
+type serviceKey struct{}
+
+// AsService marca ctx como uma execução do próprio serviço, como as ações
+// decididas pelos comportamentos, que não passa pela política das ações.
+// Sem a marca, um contexto sem principal não tem papel nem escopo algum.
+func AsService(ctx context.Context) context.Context {
+	return context.WithValue(ctx, serviceKey{}, true)
+}
+
+func isService(ctx context.Context) bool {
+	v, _ := ctx.Value(serviceKey{}).(bool)
+	return v
+}
+
+// allowed indica se o principal tem um dos papéis ou um dos escopos;
+// listas vazias não exigem nada.
+func allowed(p *auth.Principal, roles, scopes []string) bool {
+	if len(roles) == 0 && len(scopes) == 0 {
+		return true
+	}
+	for _, r := range roles {
+		if p.HasRole(r) {
+			return true
+		}
+	}
+	for _, s := range scopes {
+		if p.HasScope(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Permit confere só a exigência da definição da ação, sem o agente: é o
+// que um lote confere antes de ser aceito. Retorna um *ForbiddenError.
+func (r *Registry) Permit(ctx context.Context, name string) error {
+	d, ok := r.defs[name]
+	if !ok || isService(ctx) {
+		return nil
+	}
+	if p := auth.FromContext(ctx); !allowed(p, d.Roles, d.Scopes) {
+		return &ForbiddenError{Action: name, Roles: d.Roles, Scopes: d.Scopes}
+	}
+	return nil
+}
+
+// Authorize confere se o principal de ctx pode executar a ação no agente:
+// precisa atender à exigência da definição e, se o projeto do agente
+// restringe a ação, ter um dos papéis do projeto. Retorna um
+// *ForbiddenError.
+func (r *Registry) Authorize(ctx context.Context, ag *agent.Agent, name string) error {
+	if err := r.Permit(ctx, name); err != nil || isService(ctx) || r.opts.Projects == nil {
+		return err
+	}
+	roles := r.opts.Projects.ActionRoles(ag.ProjectID, name)
+	if p := auth.FromContext(ctx); !allowed(p, roles, nil) {
+		return &ForbiddenError{Action: name, ProjectID: ag.ProjectID, Roles: roles}
+	}
+	return nil
+}
+
+// ForbiddenError indica que o principal não tem nenhum dos papéis ou
+// escopos exigidos pela ação. ProjectID é preenchido quando a exigência
+// vem da restrição do projeto.
+type ForbiddenError struct {
+	Action    string
+	ProjectID string
+	Roles     []string
+	Scopes    []string
+}
+
+func (e *ForbiddenError) Error() string {
+	var need []string
+	if len(e.Roles) > 0 {
+		need = append(need, "role "+strings.Join(e.Roles, " or "))
+	}
+	if len(e.Scopes) > 0 {
+		need = append(need, "scope "+strings.Join(e.Scopes, " or "))
+	}
+	if e.ProjectID != "" {
+		return fmt.Sprintf("action %q requires %s in project %s", e.Action, strings.Join(need, " or "), e.ProjectID)
+	}
+	return fmt.Sprintf("action %q requires %s", e.Action, strings.Join(need, " or "))
+}
+
+func (e *ForbiddenError) Unwrap() error { return ErrForbidden }
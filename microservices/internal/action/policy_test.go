@@ -0,0 +1,164 @@
+package action
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/auth"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// testProjects restringe ações por projeto: projeto, ação e papéis.
+type testProjects map[string]map[string][]string
+
+func (p testProjects) ActionRoles(projectID, action string) []string {
+	return p[projectID][action]
+}
+
+// policyRegistry tem uma ação só de administradores, uma de operadores ou
+// da chave com o escopo maintenance e uma livre, que o projeto
+// proj-restrito restringe a operadores.
+func policyRegistry() *Registry {
+	return NewRegistry([]Definition{
+		{Name: "emergency_stop", Roles: []string{auth.RoleAdmin}},
+		{Name: "calibrate_sensor", Roles: []string{auth.RoleOperator}, Scopes: []string{"maintenance"}},
+		{Name: "reroute"},
+	}, Options{Projects: testProjects{"proj-restrito": {"reroute": {auth.RoleOperator}}}})
+}
+
+// fakeAgents conhece bus-1, de proj-centro, e bus-2, de proj-restrito, e
+// conta as ações executadas.
+type fakeAgents struct {
+	executed []string
+}
+
+func (f *fakeAgents) GetAgent(_ context.Context, id string) (*agent.Agent, error) {
+	switch id {
+	case "bus-1":
+		return &agent.Agent{ID: id, Type: "bus", ProjectID: "proj-centro"}, nil
+	case "bus-2":
+		return &agent.Agent{ID: id, Type: "bus", ProjectID: "proj-restrito"}, nil
+	}
+	return nil, agent.ErrNotFound
+}
+
+func (f *fakeAgents) ExecuteAction(_ context.Context, id string, req agent.ActionRequest) (*agent.ActionResult, error) {
+	f.executed = append(f.executed, id+":"+req.Action)
+	return &agent.ActionResult{ActionID: "act-1", Status: "completed"}, nil
+}
+
+func (f *fakeAgents) ListAgents(context.Context, agent.Filter) ([]agent.Agent, int, error) {
+	return nil, 0, nil
+}
+
+func (f *fakeAgents) CreateAgent(context.Context, agent.CreateAgentRequest) (*agent.Agent, error) {
+	return nil, nil
+}
+
+func (f *fakeAgents) UpdateAgent(context.Context, string, agent.UpdateAgentRequest) (*agent.Agent, error) {
+	return nil, nil
+}
+
+func (f *fakeAgents) DeleteAgent(context.Context, string) error { return nil }
+
+func (f *fakeAgents) GetPerformance(context.Context, string) (*agent.Performance, error) {
+	return nil, nil
+}
+
+var (
+	viewer      = &auth.Principal{Subject: "oidc:viewer", Roles: []string{auth.RoleViewer}}
+	operator    = &auth.Principal{Subject: "oidc:operator", Roles: []string{auth.RoleOperator}}
+	admin       = &auth.Principal{Subject: "oidc:admin", Roles: []string{auth.RoleAdmin}}
+	maintenance = &auth.Principal{Subject: "apikey:k1", Scopes: []string{"maintenance"}}
+)
+
+// policyCases são os pedidos conferidos em cada entrada: status 200 é a
+// ação executada; 403 traz os papéis, escopos e projeto exigidos.
+var policyCases = []struct {
+	name      string
+	principal *auth.Principal
+	agentID   string
+	action    string
+	status    int
+	roles     []string
+	scopes    []string
+	projectID string
+}{
+	{name: "free action", principal: viewer, agentID: "bus-1", action: "reroute", status: http.StatusOK},
+	{name: "admin action by viewer", principal: viewer, agentID: "bus-1", action: "emergency_stop", status: http.StatusForbidden, roles: []string{auth.RoleAdmin}},
+	{name: "admin action by operator", principal: operator, agentID: "bus-1", action: "emergency_stop", status: http.StatusForbidden, roles: []string{auth.RoleAdmin}},
+	{name: "admin action by admin", principal: admin, agentID: "bus-1", action: "emergency_stop", status: http.StatusOK},
+	{name: "admin action without principal", agentID: "bus-1", action: "emergency_stop", status: http.StatusForbidden, roles: []string{auth.RoleAdmin}},
+	{
+		name: "role or scope action by viewer", principal: viewer, agentID: "bus-1", action: "calibrate_sensor", status: http.StatusForbidden,
+		roles: []string{auth.RoleOperator}, scopes: []string{"maintenance"},
+	},
+	{name: "role or scope action by operator", principal: operator, agentID: "bus-1", action: "calibrate_sensor", status: http.StatusOK},
+	{name: "role or scope action by scoped key", principal: maintenance, agentID: "bus-1", action: "calibrate_sensor", status: http.StatusOK},
+	{
+		name: "project restriction by viewer", principal: viewer, agentID: "bus-2", action: "reroute", status: http.StatusForbidden,
+		roles: []string{auth.RoleOperator}, projectID: "proj-restrito",
+	},
+	{
+		name: "project restriction by scoped key", principal: maintenance, agentID: "bus-2", action: "reroute", status: http.StatusForbidden,
+		roles: []string{auth.RoleOperator}, projectID: "proj-restrito",
+	},
+	{name: "project restriction by operator", principal: operator, agentID: "bus-2", action: "reroute", status: http.StatusOK},
+	{name: "project restriction by admin", principal: admin, agentID: "bus-2", action: "reroute", status: http.StatusOK},
+}
+
+// TestMiddlewarePolicy confere a política em POST /agents/:id/actions.
+func TestMiddlewarePolicy(t *testing.T) {
+	for _, tt := range policyCases {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHandler(policyRegistry(), nil, nil, &fakeAgents{}, HistoryConfig{})
+			executed := false
+			r := gin.New()
+			r.POST("/agents/:id/actions", func(c *gin.Context) {
+				if tt.principal != nil {
+					auth.SetPrincipal(c, tt.principal)
+				}
+			}, h.Middleware(), func(c *gin.Context) {
+				executed = true
+				c.JSON(http.StatusOK, gin.H{"status": "completed"})
+			})
+			w := httptest.NewRecorder()
+			body := `{"action":"` + tt.action + `","params":{}}`
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/agents/"+tt.agentID+"/actions", strings.NewReader(body)))
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			if executed != (tt.status == http.StatusOK) {
+				t.Errorf("executada = %v", executed)
+			}
+			if tt.status != http.StatusForbidden {
+				return
+			}
+			var got struct {
+				Error          string   `json:"error"`
+				Action         string   `json:"action"`
+				RequiredRoles  []string `json:"required_roles"`
+				RequiredScopes []string `json:"required_scopes"`
+				ProjectID      string   `json:"project_id"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Action != tt.action || !reflect.DeepEqual(got.RequiredRoles, tt.roles) ||
+				!reflect.DeepEqual(got.RequiredScopes, tt.scopes) || got.ProjectID != tt.projectID || got.Error == "" {
+				t.Errorf("corpo %+v", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package action
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/logging"
+)
+
+// Chaves da fila: o sorted set com os ids na ordem de entrega e o hash com
+// o que o worker precisa para retomar cada execução.
+const (
+	queueKey     = "agent-service:actions:queue"
+	queueJobsKey = "agent-service:actions:queue:jobs"
+)
+
+// strictStep separa os níveis de prioridade quando não há envelhecimento e
+// coloca critical à frente de qualquer espera: ~31 anos, em milissegundos.
+const strictStep = float64(1e12)
+
+// Queue é a fila de execuções compartilhada pelas réplicas, num sorted set
+// do Redis consumido pelo menor score. O score é o instante de entrada
+// adiantado pela prioridade: cada nível acima de low vale Aging de espera,
+// de modo que uma execução low que esperou Aging a mais passa à frente de
+// uma normal recém-chegada e nenhuma prioridade fica parada para sempre.
+// critical fica sempre à frente das demais. Com Aging zero a ordem é
+// estritamente por prioridade.
+type Queue struct {
+	redis redis.UniversalClient
+	size  int
+	aging time.Duration
+}
+
+// NewQueue cria a fila. size limita as execuções aguardando, somando todas
+// as réplicas.
+func NewQueue(client redis.UniversalClient, size int, aging time.Duration) *Queue {
+	return &Queue{redis: client, size: size, aging: aging}
+}
+
+// queueEntry leva ao worker, que pode estar em outra réplica, a partição
+// da execução e o principal e a correlação de quem a pediu.
+type queueEntry struct {
+	CreatedAt  time.Time       `json:"created_at"`
+	Priority   string          `json:"priority"`
+	EnqueuedAt time.Time       `json:"enqueued_at"`
+	RequestID  string          `json:"request_id,omitempty"`
+	TraceID    string          `json:"trace_id,omitempty"`
+	Principal  *auth.Principal `json:"principal,omitempty"`
+}
+
+// context recria o contexto do pedido no worker.
+func (e queueEntry) context() context.Context {
+	ctx := logging.WithCorrelation(context.Background(), e.RequestID, e.TraceID)
+	if e.Principal != nil {
+		ctx = auth.WithPrincipal(ctx, e.Principal)
+	}
+	return ctx
+}
+
+func (q *Queue) score(priority string, enqueued time.Time) float64 {
+	level := priorityLevels[priority]
+	step := strictStep
+	if priority != PriorityCritical && q.aging > 0 {
+		step = float64(q.aging.Milliseconds())
+	}
+	return float64(enqueued.UnixMilli()) - float64(level)*step
+}
+
+// Push coloca a execução na fila. Retorna ErrQueueFull se a fila já tem
+// size execuções.
+func (q *Queue) Push(ctx context.Context, a *Action) error {
+	n, err := q.redis.ZCard(ctx, queueKey).Result()
+	if err != nil {
+		return err
+	}
+	if n >= int64(q.size) {
+		return ErrQueueFull
+	}
+	e := queueEntry{
+		CreatedAt:  a.CreatedAt,
+		Priority:   a.Priority,
+		EnqueuedAt: time.Now(),
+		RequestID:  logging.RequestID(ctx),
+		TraceID:    logging.TraceID(ctx),
+		Principal:  auth.FromContext(ctx),
+	}
+	raw, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = q.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, queueJobsKey, a.ID, raw)
+		pipe.ZAdd(ctx, queueKey, redis.Z{Score: q.score(a.Priority, e.EnqueuedAt), Member: a.ID})
+		return nil
+	})
+	if err == nil {
+		queued.Set(float64(n + 1))
+	}
+	return err
+}
+
+// Pop retira a próxima execução, esperando até wait por uma. Retorna
+// redis.Nil se a fila continuou vazia.
+func (q *Queue) Pop(ctx context.Context, wait time.Duration) (string, queueEntry, error) {
+	var e queueEntry
+	z, err := q.redis.BZPopMin(ctx, wait, queueKey).Result()
+	if err != nil {
+		return "", e, err
+	}
+	id, _ := z.Member.(string)
+	var get *redis.StringCmd
+	var card *redis.IntCmd
+	_, err = q.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		get = pipe.HGet(ctx, queueJobsKey, id)
+		pipe.HDel(ctx, queueJobsKey, id)
+		card = pipe.ZCard(ctx, queueKey)
+		return nil
+	})
+	if err != nil {
+		return id, e, err
+	}
+	queued.Set(float64(card.Val()))
+	err = json.Unmarshal([]byte(get.Val()), &e)
+	return id, e, err
+}
+
+// Remove tira da fila uma execução cancelada.
+func (q *Queue) Remove(ctx context.Context, id string) error {
+	_, err := q.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRem(ctx, queueKey, id)
+		pipe.HDel(ctx, queueJobsKey, id)
+		return nil
+	})
+	return err
+}
+
+// Describe preenche EffectivePriority e QueuePosition de uma execução na
+// fila. Execuções fora dela, em execução ou aguardando o backoff de uma
+// nova tentativa, ficam como estão.
+func (q *Queue) Describe(ctx context.Context, a *Action) error {
+	rank, err := q.redis.ZRank(ctx, queueKey, a.ID).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	raw, err := q.redis.HGet(ctx, queueJobsKey, a.ID).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var e queueEntry
+	if err := json.Unmarshal([]byte(raw), &e); err != nil {
+		return err
+	}
+	a.QueuePosition = rank + 1
+	a.EffectivePriority = q.effective(e.Priority, time.Since(e.EnqueuedAt))
+	return nil
+}
+
+// effective é a prioridade com o envelhecimento: um nível a cada Aging de
+// espera, até high.
+func (q *Queue) effective(priority string, waited time.Duration) string {
+	level := priorityLevels[priority]
+	if priority == PriorityCritical || q.aging <= 0 {
+		return priority
+	}
+	level = min(level+int(waited/q.aging), priorityLevels[PriorityHigh])
+	return priorityNames[level]
+}
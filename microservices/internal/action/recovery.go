@@ -0,0 +1,167 @@
+package action
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/logging"
+)
+
+// ErrReclaimed indica que outra réplica assumiu a execução, que parou de
+// dar sinal de vida nesta.
+var ErrReclaimed = errors.New("action execution reclaimed by another instance")
+
+// recoverBatch limita as execuções recuperadas por varredura.
+const recoverBatch = 100
+
+var recovered = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "agent_service",
+	Name:      "async_actions_recovered_total",
+	Help:      "Ações assíncronas órfãs encontradas depois de um reinício, por resultado (resumed, failed, finished).",
+}, []string{"result"})
+
+// Resumable é implementado pelos executores que sabem retomar uma
+// execução interrompida por um reinício. ResumeAction recebe o último
+// checkpoint gravado com Checkpoint (nil se nenhum foi) e segue as mesmas
+// regras de ExecuteAction. Executores sem ResumeAction têm as execuções
+// interrompidas encerradas como failed.
+type Resumable interface {
+	Executor
+	ResumeAction(ctx context.Context, id string, req agent.ActionRequest, checkpoint map[string]interface{}) (*agent.ActionResult, error)
+}
+
+type checkpointKey struct{}
+
+// checkpointer liga o contexto de uma execução à instância que a executa.
+type checkpointer struct {
+	runner   *Runner
+	actionID string
+}
+
+// Checkpoint grava o estado de uma execução em andamento, a partir do
+// qual um executor Resumable a retoma depois de um reinício. ctx é o
+// recebido por ExecuteAction ou ResumeAction; fora de uma execução
+// assíncrona não faz nada. Retorna ErrReclaimed se outra réplica assumiu
+// a execução, que então deve parar.
+func Checkpoint(ctx context.Context, state map[string]interface{}) error {
+	c, ok := ctx.Value(checkpointKey{}).(checkpointer)
+	if !ok {
+		return nil
+	}
+	store, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	owned, err := c.runner.repo.SaveCheckpoint(store, c.actionID, c.runner.cfg.Instance, state)
+	if err == nil && !owned {
+		return ErrReclaimed
+	}
+	return err
+}
+
+// executorName identifica o executor gravado com a execução; uma
+// execução só é retomada pelo mesmo tipo de executor que a começou.
+func executorName(e Executor) string {
+	return fmt.Sprintf("%T", e)
+}
+
+// recovery procura, ao iniciar e a cada RecoveryInterval, as execuções
+// cujo worker parou de dar sinal de vida há mais de StaleAfter, em geral
+// por um deploy ou uma queda da réplica.
+func (r *Runner) recovery(stop context.Context) error {
+	ctx := logging.Background(context.WithoutCancel(stop), "async-actions-recovery")
+	ticker := time.NewTicker(r.cfg.RecoveryInterval)
+	defer ticker.Stop()
+	for {
+		r.recoverStale(ctx)
+		select {
+		case <-stop.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Runner) recoverStale(ctx context.Context) {
+	log := logging.FromContext(ctx)
+	stale, err := r.repo.StaleExecutions(ctx, r.cfg.StaleAfter, recoverBatch)
+	if err != nil {
+		log.WithError(err).Warn("Falha ao procurar ações assíncronas órfãs")
+		return
+	}
+	for _, x := range stale {
+		claimed, err := r.repo.ClaimExecution(ctx, x.ActionID, r.cfg.Instance, r.cfg.StaleAfter)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			log.WithError(err).WithField("action_id", x.ActionID).Warn("Falha ao assumir ação assíncrona órfã")
+			continue
+		}
+		r.recoverOne(ctx, claimed)
+	}
+}
+
+// recoverOne devolve à fila a execução que o executor sabe retomar e
+// encerra as demais como failed, com o motivo. Uma execução já de volta à
+// fila, esperando um worker, fica como está.
+func (r *Runner) recoverOne(ctx context.Context, x *Execution) {
+	log := logging.FromContext(ctx).WithFields(logrus.Fields{"action_id": x.ActionID, "agent_id": x.AgentID})
+	a, err := r.repo.Load(ctx, x.ActionID, x.ActionCreatedAt)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		log.WithError(err).Warn("Falha ao carregar ação assíncrona órfã")
+		return
+	}
+	if err != nil || a.Finished() {
+		// Encerrada enquanto esperava na fila, ou descartada pela retenção.
+		recovered.WithLabelValues("finished").Inc()
+		if err := r.repo.EndExecution(ctx, x.ActionID, r.cfg.Instance); err != nil {
+			log.WithError(err).Warn("Falha ao apagar o estado de execução da ação")
+		}
+		return
+	}
+	if a.Status != StatusRunning {
+		return
+	}
+
+	j := job{ctx: ctx, action: a}
+	reason := r.unresumable(x)
+	if reason == "" {
+		a.Status = StatusQueued
+		if r.transition(ctx, j) {
+			recovered.WithLabelValues("resumed").Inc()
+			log.WithField("resumes", x.Resumes).Warn("Ação assíncrona interrompida por um reinício devolvida à fila para ser retomada")
+			r.enqueue(j)
+		}
+		return
+	}
+	a.Status, a.Error, a.Reason = StatusFailed, "action interrupted by restart", reason
+	if r.transition(ctx, j) {
+		recovered.WithLabelValues("failed").Inc()
+		executions.WithLabelValues(a.Action, StatusFailed).Inc()
+		log.WithField("reason", reason).Warn("Ação assíncrona interrompida por um reinício encerrada")
+	}
+	if err := r.repo.EndExecution(ctx, x.ActionID, r.cfg.Instance); err != nil {
+		log.WithError(err).Warn("Falha ao apagar o estado de execução da ação")
+	}
+}
+
+// unresumable explica por que a execução não pode ser retomada; vazio se
+// pode.
+func (r *Runner) unresumable(x *Execution) string {
+	if _, ok := r.executor.(Resumable); !ok {
+		return fmt.Sprintf("the instance running the action stopped responding and executor %s cannot resume it", r.executorName)
+	}
+	if x.Executor != r.executorName {
+		return fmt.Sprintf("the instance running the action stopped responding and it was started by executor %s, which is no longer available", x.Executor)
+	}
+	if x.Resumes >= r.cfg.MaxResumes {
+		return fmt.Sprintf("the action was interrupted by a restart %d times without finishing", x.Resumes+1)
+	}
+	return ""
+}
@@ -0,0 +1,289 @@
+// Package action mantém o registro das ações de agentes: o schema do
+// payload, os tipos de agente que aceitam cada ação e como ela executa.
+// Ações declaradas como demoradas (recalcular rota, recalibrar sensor)
+// executam em segundo plano: em vez de manter a requisição aberta até o
+// timeout de escrita, POST /agents/:id/actions responde 202 com o id da
+// execução, que é acompanhada por GET /agents/:id/actions/:action_id e
+// pelos eventos agent.action.*.
+//
+// Cada ação pode exigir papéis ou escopos de quem a pede, e cada projeto
+// pode restringir ações a papéis nomeados (project_settings.action_roles).
+// A política é conferida em CheckAgent, por onde passam a API REST, os
+// lotes, os agendamentos e o gRPC; outra entrada que execute ações, como um
+// comando do websocket, deve executá-las por CheckedService.
+package action
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"smart-city-microservices/internal/agent"
+)
+
+// RetryPolicy define as novas tentativas de uma ação que falhou por erro
+// transitório (timeout ou erro interno). Erros de validação e agente
+// inexistente não são repetidos.
+type RetryPolicy struct {
+	// MaxAttempts conta a primeira tentativa; 1 desativa as repetições.
+	MaxAttempts int
+	// Backoff é a espera antes da segunda tentativa, dobrando a cada nova.
+	Backoff time.Duration
+}
+
+// Definition descreve uma ação conhecida.
+type Definition struct {
+	Name        string
+	Description string
+	// AgentTypes lista os tipos de agente que aceitam a ação; vazio aceita
+	// todos.
+	AgentTypes []string
+	// Capabilities são as capacidades que o agente precisa ter, além do
+	// tipo.
+	Capabilities []string
+	// Schema é o JSON Schema de params, já conferido por ParseSchema; nil
+	// aceita qualquer objeto.
+	Schema map[string]interface{}
+	// LongRunning faz a ação executar em segundo plano.
+	LongRunning bool
+	// Timeout limita cada tentativa; zero usa o padrão do registro para
+	// ações demoradas e não limita as demais.
+	Timeout time.Duration
+	Retry   RetryPolicy
+	// Roles e Scopes restringem quem executa a ação: o principal precisa
+	// ter um dos papéis ou um dos escopos. Vazios liberam a todos.
+	Roles  []string
+	Scopes []string
+}
+
+// Supports informa se o tipo de agente aceita a ação.
+func (d Definition) Supports(agentType string) bool {
+	if len(d.AgentTypes) == 0 {
+		return true
+	}
+	for _, t := range d.AgentTypes {
+		if t == agentType {
+			return true
+		}
+	}
+	return false
+}
+
+// Options configura o registro.
+type Options struct {
+	// DefaultTimeout vale para ações demoradas sem timeout próprio.
+	DefaultTimeout time.Duration
+	// AllowUnregistered aceita ações sem definição, sem checar payload nem
+	// tipo de agente, como antes do registro.
+	AllowUnregistered bool
+	// Capabilities fornece as capacidades dos agentes; sem ela, nenhum
+	// agente aceita ações que exigem capacidades.
+	Capabilities CapabilitySource
+	// Projects fornece as restrições de cada projeto às ações; sem ela,
+	// vale só a exigência das definições.
+	Projects ProjectPolicy
+}
+
+// CapabilitySource fornece as capacidades efetivas de um agente.
+type CapabilitySource interface {
+	Capabilities(ctx context.Context, ag *agent.Agent) ([]string, error)
+}
+
+// Registry guarda as definições das ações: o schema do payload, os tipos
+// de agente que as aceitam e como executam.
+type Registry struct {
+	defs  map[string]Definition
+	names []string
+	opts  Options
+}
+
+// NewRegistry cria o registro.
+func NewRegistry(defs []Definition, opts Options) *Registry {
+	r := &Registry{defs: make(map[string]Definition, len(defs)), opts: opts}
+	for _, d := range defs {
+		if d.LongRunning && d.Timeout == 0 {
+			d.Timeout = opts.DefaultTimeout
+		}
+		if d.Retry.MaxAttempts < 1 {
+			d.Retry.MaxAttempts = 1
+		}
+		if _, dup := r.defs[d.Name]; !dup {
+			r.names = append(r.names, d.Name)
+		}
+		r.defs[d.Name] = d
+	}
+	sort.Strings(r.names)
+	return r
+}
+
+// Lookup retorna a definição da ação.
+func (r *Registry) Lookup(name string) (Definition, bool) {
+	d, ok := r.defs[name]
+	return d, ok
+}
+
+// ForAgentType retorna, em ordem de nome, as ações aceitas pelo tipo de
+// agente.
+func (r *Registry) ForAgentType(agentType string) []Definition {
+	out := []Definition{}
+	for _, name := range r.names {
+		if d := r.defs[name]; d.Supports(agentType) {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// Check confere se o tipo de agente aceita a ação e se params segue o
+// schema dela. Os erros retornados satisfazem errors.Is(err,
+// agent.ErrValidation).
+func (r *Registry) Check(agentType string, req agent.ActionRequest) error {
+	d, ok := r.defs[req.Action]
+	if !ok && r.opts.AllowUnregistered {
+		return nil
+	}
+	if !ok || !d.Supports(agentType) {
+		supported := []string{}
+		for _, s := range r.ForAgentType(agentType) {
+			supported = append(supported, s.Name)
+		}
+		return &UnsupportedError{AgentType: agentType, Action: req.Action, Supported: supported}
+	}
+	if d.Schema == nil {
+		return nil
+	}
+	params, err := normalize(req.Params)
+	if err != nil {
+		return &InvalidParamsError{Action: req.Action, Err: err}
+	}
+	if err := validate(d.Schema, params, "params"); err != nil {
+		return &InvalidParamsError{Action: req.Action, Err: err}
+	}
+	return nil
+}
+
+// CheckAgent faz a checagem de Check, a de Authorize com o principal de
+// ctx e confere se o agente tem as capacidades exigidas pela ação. Erros
+// de validação satisfazem errors.Is(err, agent.ErrValidation) e os de
+// autorização errors.Is(err, ErrForbidden); os demais vêm da consulta das
+// capacidades.
+func (r *Registry) CheckAgent(ctx context.Context, ag *agent.Agent, req agent.ActionRequest) error {
+	if err := r.Check(ag.Type, req); err != nil {
+		return err
+	}
+	if err := r.Authorize(ctx, ag, req.Action); err != nil {
+		return err
+	}
+	d, ok := r.defs[req.Action]
+	if !ok || len(d.Capabilities) == 0 {
+		return nil
+	}
+	var have []string
+	if r.opts.Capabilities != nil {
+		var err error
+		if have, err = r.opts.Capabilities.Capabilities(ctx, ag); err != nil {
+			return err
+		}
+	}
+	set := make(map[string]bool, len(have))
+	for _, c := range have {
+		set[c] = true
+	}
+	var missing []string
+	for _, c := range d.Capabilities {
+		if !set[c] {
+			missing = append(missing, c)
+		}
+	}
+	if len(missing) > 0 {
+		return &MissingCapabilitiesError{AgentID: ag.ID, Action: req.Action, Missing: missing}
+	}
+	return nil
+}
+
+// UnsupportedError indica uma ação desconhecida ou não aceita pelo tipo do
+// agente.
+type UnsupportedError struct {
+	AgentType string
+	Action    string
+	// Supported lista as ações aceitas pelo tipo do agente.
+	Supported []string
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("action %q is not supported by agent type %q", e.Action, e.AgentType)
+}
+
+func (e *UnsupportedError) Unwrap() error { return agent.ErrValidation }
+
+// InvalidParamsError indica params fora do schema da ação.
+type InvalidParamsError struct {
+	Action string
+	Err    error
+}
+
+func (e *InvalidParamsError) Error() string {
+	return fmt.Sprintf("invalid params for action %q: %v", e.Action, e.Err)
+}
+
+func (e *InvalidParamsError) Unwrap() error { return agent.ErrValidation }
+
+// MissingCapabilitiesError indica que o agente não tem as capacidades
+// exigidas pela ação.
+type MissingCapabilitiesError struct {
+	AgentID string
+	Action  string
+	Missing []string
+}
+
+func (e *MissingCapabilitiesError) Error() string {
+	return fmt.Sprintf("action %q requires capabilities %q that agent %s does not have", e.Action, e.Missing, e.AgentID)
+}
+
+func (e *MissingCapabilitiesError) Unwrap() error { return agent.ErrValidation }
+
+// AgentService é o subconjunto de agent.Service que CheckedService
+// envolve.
+type AgentService interface {
+	ListAgents(ctx context.Context, f agent.Filter) ([]agent.Agent, int, error)
+	GetAgent(ctx context.Context, id string) (*agent.Agent, error)
+	CreateAgent(ctx context.Context, req agent.CreateAgentRequest) (*agent.Agent, error)
+	UpdateAgent(ctx context.Context, id string, req agent.UpdateAgentRequest) (*agent.Agent, error)
+	DeleteAgent(ctx context.Context, id string) error
+	ExecuteAction(ctx context.Context, id string, req agent.ActionRequest) (*agent.ActionResult, error)
+	GetPerformance(ctx context.Context, id string) (*agent.Performance, error)
+}
+
+// CheckedService envolve o serviço de agentes para que ExecuteAction
+// passe pelo registro antes de executar. É o que gRPC e lotes recebem; a
+// API REST faz a mesma checagem no Middleware para responder 422 com as
+// ações aceitas, ou 403 se o principal não pode executá-la.
+type CheckedService struct {
+	AgentService
+	registry *Registry
+}
+
+// NewCheckedService cria o serviço com checagem.
+func NewCheckedService(svc AgentService, registry *Registry) *CheckedService {
+	return &CheckedService{AgentService: svc, registry: registry}
+}
+
+// PermitAction confere a exigência da definição da ação contra o
+// principal de ctx, antes de haver um agente; ver Registry.Permit.
+func (s *CheckedService) PermitAction(ctx context.Context, name string) error {
+	return s.registry.Permit(ctx, name)
+}
+
+// ExecuteAction checa a ação contra o tipo e as capacidades do agente e
+// contra a política das ações, e executa.
+func (s *CheckedService) ExecuteAction(ctx context.Context, id string, req agent.ActionRequest) (*agent.ActionResult, error) {
+	a, err := s.AgentService.GetAgent(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.registry.CheckAgent(ctx, a, req); err != nil {
+		return nil, err
+	}
+	return s.AgentService.ExecuteAction(ctx, id, req)
+}
@@ -0,0 +1,189 @@
+package action
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+
+	"smart-city-microservices/internal/instrument"
+)
+
+// Repository persiste as execuções no PostgreSQL, de onde qualquer réplica
+// responde ao acompanhamento. agent_actions é particionada por created_at:
+// as escritas do runner levam created_at para tocar uma só partição.
+type Repository struct {
+	db *instrument.DB
+}
+
+// NewRepository cria o repositório.
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: instrument.NewDB(db)}
+}
+
+const actionColumns = `id, agent_id, COALESCE(project_id, ''), action, params, status, priority, attempt, max_attempts,
+	retry_backoff_ms, timeout_ms, COALESCE(result_id, ''), result, COALESCE(error, ''), COALESCE(reason, ''), COALESCE(created_by, ''),
+	created_at, started_at, finished_at`
+
+func scanAction(row interface{ Scan(...interface{}) error }) (*Action, error) {
+	var a Action
+	var params, result []byte
+	var started, finished sql.NullTime
+	err := row.Scan(&a.ID, &a.AgentID, &a.ProjectID, &a.Action, &params, &a.Status, &a.Priority, &a.Attempt, &a.MaxAttempts,
+		&a.RetryBackoffMs, &a.TimeoutMs, &a.ResultID, &result, &a.Error, &a.Reason, &a.CreatedBy,
+		&a.CreatedAt, &started, &finished)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(params, &a.Params); err != nil {
+		return nil, err
+	}
+	if result != nil {
+		if err := json.Unmarshal(result, &a.Result); err != nil {
+			return nil, err
+		}
+	}
+	if started.Valid {
+		a.StartedAt = &started.Time
+	}
+	if finished.Valid {
+		a.FinishedAt = &finished.Time
+	}
+	return &a, nil
+}
+
+// Create grava a execução como queued e preenche id e created_at.
+func (r *Repository) Create(ctx context.Context, a *Action) error {
+	params, err := json.Marshal(a.Params)
+	if err != nil {
+		return err
+	}
+	return r.db.QueryRow(ctx, "action.create", `
+		INSERT INTO agent_actions (agent_id, project_id, action, params, status, priority, attempt, max_attempts,
+			retry_backoff_ms, timeout_ms, created_by)
+		VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6, $7, $8, $9, $10, NULLIF($11, ''))
+		RETURNING id, created_at`,
+		a.AgentID, a.ProjectID, a.Action, params, a.Status, a.Priority, a.Attempt, a.MaxAttempts,
+		a.RetryBackoffMs, a.TimeoutMs, a.CreatedBy,
+	).Scan(&a.ID, &a.CreatedAt)
+}
+
+// Get busca a execução de um agente.
+func (r *Repository) Get(ctx context.Context, agentID, id string) (*Action, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrNotFound
+	}
+	return scanAction(r.db.QueryRow(ctx, "action.get",
+		`SELECT `+actionColumns+` FROM agent_actions WHERE id = $1 AND agent_id::text = $2`, id, agentID))
+}
+
+// Load busca a execução pela partição, para o worker que a retirou da fila.
+func (r *Repository) Load(ctx context.Context, id string, createdAt time.Time) (*Action, error) {
+	return scanAction(r.db.QueryRow(ctx, "action.load",
+		`SELECT `+actionColumns+` FROM agent_actions WHERE id = $1 AND created_at = $2`, id, createdAt))
+}
+
+// terminal lista os estados finais; uma execução neles não muda mais.
+const terminal = `('succeeded', 'failed', 'cancelled', 'timed_out')`
+
+// Update grava o estado atual da execução. started_at é preenchido na
+// primeira vez em running e finished_at ao terminar. Retorna ErrNotFound
+// se a execução já está num estado final, em geral porque foi cancelada.
+func (r *Repository) Update(ctx context.Context, a *Action) error {
+	result, err := jsonOrNull(a.Result)
+	if err != nil {
+		return err
+	}
+	var started, finished sql.NullTime
+	err = r.db.QueryRow(ctx, "action.update", `
+		UPDATE agent_actions SET status = $2, attempt = $3, result_id = NULLIF($4, ''), result = $5,
+			error = NULLIF($6, ''), reason = NULLIF($7, ''),
+			started_at = CASE WHEN $2 = 'running' THEN COALESCE(started_at, CURRENT_TIMESTAMP) ELSE started_at END,
+			finished_at = CASE WHEN $2 IN `+terminal+` THEN CURRENT_TIMESTAMP END
+		WHERE id = $1 AND created_at = $8 AND status NOT IN `+terminal+`
+		RETURNING started_at, finished_at`,
+		a.ID, a.Status, a.Attempt, a.ResultID, result, a.Error, a.Reason, a.CreatedAt,
+	).Scan(&started, &finished)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	a.StartedAt, a.FinishedAt = nil, nil
+	if started.Valid {
+		a.StartedAt = &started.Time
+	}
+	if finished.Valid {
+		a.FinishedAt = &finished.Time
+	}
+	return nil
+}
+
+// Cancel marca a execução como cancelada se ela ainda está na fila ou em
+// execução. Retorna o estado anterior e se o cancelamento foi aplicado;
+// com false, a execução já tinha terminado.
+func (r *Repository) Cancel(ctx context.Context, agentID, id, reason string) (string, bool, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return "", false, ErrNotFound
+	}
+	var prev string
+	var cancelled int
+	err := r.db.QueryRow(ctx, "action.cancel", `
+		WITH prev AS (
+			SELECT id, created_at, status FROM agent_actions WHERE id = $1 AND agent_id::text = $2 FOR UPDATE
+		), cancelled AS (
+			UPDATE agent_actions a SET status = 'cancelled', reason = NULLIF($3, ''), finished_at = CURRENT_TIMESTAMP
+			FROM prev WHERE a.id = prev.id AND a.created_at = prev.created_at AND prev.status IN ('queued', 'running')
+			RETURNING a.id
+		)
+		SELECT prev.status, (SELECT COUNT(*) FROM cancelled) FROM prev`, id, agentID, reason,
+	).Scan(&prev, &cancelled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, ErrNotFound
+	}
+	return prev, cancelled == 1, err
+}
+
+// RecordCancelled grava o resultado parcial de uma execução cancelada
+// enquanto rodava e retorna o estado gravado.
+func (r *Repository) RecordCancelled(ctx context.Context, a *Action) (*Action, error) {
+	result, err := jsonOrNull(a.Result)
+	if err != nil {
+		return nil, err
+	}
+	return scanAction(r.db.QueryRow(ctx, "action.record_cancelled", `
+		UPDATE agent_actions SET result_id = COALESCE(NULLIF($2, ''), result_id), result = COALESCE($3, result)
+		WHERE id = $1 AND created_at = $4 AND status = 'cancelled'
+		RETURNING `+actionColumns, a.ID, a.ResultID, result, a.CreatedAt))
+}
+
+// Status retorna o estado gravado da execução.
+func (r *Repository) Status(ctx context.Context, a *Action) (string, error) {
+	var status string
+	err := r.db.QueryRow(ctx, "action.status",
+		`SELECT status FROM agent_actions WHERE id = $1 AND created_at = $2`, a.ID, a.CreatedAt).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	return status, err
+}
+
+// jsonOrNull serializa m, ou retorna nil, e não um []byte vazio, para
+// gravar NULL.
+func jsonOrNull(m map[string]interface{}) (interface{}, error) {
+	if m == nil {
+		return nil, nil
+	}
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
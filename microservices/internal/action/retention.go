@@ -0,0 +1,281 @@
+package action
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/archive"
+	"smart-city-microservices/internal/instrument"
+	"smart-city-microservices/internal/lease"
+	"smart-city-microservices/internal/logging"
+	"smart-city-microservices/internal/storage"
+	"smart-city-microservices/internal/supervisor"
+)
+
+// retentionLeaderKey guarda a réplica que mantém as partições. Só uma
+// cria e descarta por vez, para que duas não arquivem o mesmo dia.
+const retentionLeaderKey = "agent-service:actions:retention"
+
+var partitionOps = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "agent_service",
+	Name:      "action_partitions_total",
+	Help:      "Partições das tabelas particionadas por dia (agent_actions e as demais da retenção) por operação (dropped, archived, error).",
+}, []string{"result"})
+
+// Table é uma tabela particionada por dia em UTC. As partições se chamam
+// <Name>_pAAAAMMDD e são criadas pela função create_<Name>_partitions
+// (first_day, days) do banco.
+type Table struct {
+	Name string
+	// Window é por quanto tempo as linhas são mantidas; zero mantém tudo.
+	Window time.Duration
+	// Archive grava cada partição no armazenamento de objetos antes de
+	// descartá-la.
+	Archive bool
+	// OrderBy ordena as linhas no arquivo.
+	OrderBy string
+}
+
+// ActionsTable é agent_actions, particionada por created_at.
+func ActionsTable(window time.Duration, archive bool) Table {
+	return Table{Name: "agent_actions", Window: window, Archive: archive, OrderBy: "created_at, id"}
+}
+
+// RetentionConfig configura a manutenção das partições.
+type RetentionConfig struct {
+	// Interval é o intervalo entre os ciclos.
+	Interval time.Duration
+	// PartitionsAhead é quantos dias de partições criar à frente.
+	PartitionsAhead int
+	// Tables são as tabelas mantidas, na ordem em que cada ciclo passa
+	// por elas.
+	Tables []Table
+}
+
+// Retention cria as partições dos próximos dias e descarta as que saíram
+// da janela de retenção, arquivando-as antes se configurado. Além de
+// agent_actions, mantém as outras tabelas particionadas por dia.
+type Retention struct {
+	db     *instrument.DB
+	store  storage.Store
+	leader *lease.Lease
+	cfg    RetentionConfig
+}
+
+// NewRetention cria a manutenção de partições. id identifica a réplica na
+// disputa pela manutenção; o ciclo roda em Run.
+func NewRetention(db *sql.DB, store storage.Store, client redis.UniversalClient, cfg RetentionConfig, id string) *Retention {
+	return &Retention{
+		db:     instrument.NewDB(db),
+		store:  store,
+		leader: lease.New(client, retentionLeaderKey, id),
+		cfg:    cfg,
+	}
+}
+
+// Run mantém as partições a cada intervalo até ctx ser cancelado e, ao
+// terminar, libera a manutenção para outra réplica. O primeiro ciclo roda
+// logo, para que as partições dos próximos dias existam antes das primeiras
+// execuções.
+func (r *Retention) Run(ctx context.Context) error {
+	work := logging.Background(context.WithoutCancel(ctx), "action-retention")
+	defer r.release(work)
+	r.cycle(work)
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			r.cycle(work)
+		}
+	}
+}
+
+// release remove a chave só se ainda for desta réplica.
+func (r *Retention) release(ctx context.Context) {
+	ctx, cancel := supervisor.Cleanup(ctx)
+	defer cancel()
+	r.leader.Release(ctx)
+}
+
+func (r *Retention) cycle(ctx context.Context) {
+	log := logging.FromContext(ctx)
+	leader, err := r.lead(ctx)
+	if err != nil {
+		log.WithError(err).Warn("Falha ao disputar a retenção das partições")
+		return
+	}
+	if !leader {
+		return
+	}
+
+	now := time.Now().UTC()
+	for _, t := range r.cfg.Tables {
+		r.maintain(ctx, t, now)
+	}
+}
+
+// maintain cria as partições dos próximos dias da tabela e descarta as que
+// saíram da janela.
+func (r *Retention) maintain(ctx context.Context, t Table, now time.Time) {
+	log := logging.FromContext(ctx).WithField("table", t.Name)
+	if _, err := r.db.Exec(ctx, "action.create_partitions", `SELECT `+pq.QuoteIdentifier("create_"+t.Name+"_partitions")+`($1::date, $2)`,
+		now.Format("2006-01-02"), r.cfg.PartitionsAhead+1); err != nil {
+		partitionOps.WithLabelValues("error").Inc()
+		log.WithError(err).Error("Falha ao criar partições")
+	}
+	if t.Window <= 0 {
+		return
+	}
+
+	partitions, err := r.partitions(ctx, t.Name)
+	if err != nil {
+		log.WithError(err).Error("Falha ao listar partições")
+		return
+	}
+	cutoff := now.Add(-t.Window)
+	for _, p := range partitions {
+		// A partição cobre o dia inteiro; só sai quando o fim do dia está
+		// fora da janela.
+		if p.day.AddDate(0, 0, 1).After(cutoff) {
+			continue
+		}
+		plog := log.WithFields(logrus.Fields{"partition": p.name, "day": p.day.Format("2006-01-02")})
+		if t.Archive {
+			res, err := r.archive(ctx, t, p)
+			if err != nil {
+				partitionOps.WithLabelValues("error").Inc()
+				plog.WithError(err).Error("Falha ao arquivar partição; ela será mantida")
+				continue
+			}
+			partitionOps.WithLabelValues("archived").Inc()
+			plog.WithFields(logrus.Fields{"key": res.Key, "rows": res.Rows, "size": res.Size}).Info("Partição arquivada")
+		}
+		if _, err := r.db.Exec(ctx, "action.drop_partition", `DROP TABLE IF EXISTS `+pq.QuoteIdentifier(p.name)); err != nil {
+			partitionOps.WithLabelValues("error").Inc()
+			plog.WithError(err).Error("Falha ao descartar partição")
+			continue
+		}
+		partitionOps.WithLabelValues("dropped").Inc()
+		plog.Info("Partição descartada")
+	}
+}
+
+// lead disputa a manutenção. A chave expira em três intervalos, para que
+// outra réplica assuma se esta cair.
+func (r *Retention) lead(ctx context.Context) (bool, error) {
+	return r.leader.Acquire(ctx, 3*r.cfg.Interval)
+}
+
+type partition struct {
+	name string
+	day  time.Time
+}
+
+// partitions lista as partições diárias da tabela, da mais antiga para a
+// mais nova. Tabelas anexadas com outro nome são ignoradas.
+func (r *Retention) partitions(ctx context.Context, table string) ([]partition, error) {
+	prefix := table + "_p"
+	rows, err := r.db.Query(ctx, "action.partitions", `
+		SELECT c.relname FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = $1::regclass
+		ORDER BY c.relname`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []partition
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		day, err := time.Parse("20060102", strings.TrimPrefix(name, prefix))
+		if err != nil {
+			continue
+		}
+		out = append(out, partition{name: name, day: day})
+	}
+	return out, rows.Err()
+}
+
+// ArchiveKey é a chave do arquivo de uma partição da tabela no
+// armazenamento.
+func ArchiveKey(table, partitionName string) string {
+	return "archives/" + table + "/" + partitionName + ".jsonl.gz"
+}
+
+// archive grava as linhas da partição como JSONL gzipado, no formato de
+// archive.Line, e registra o arquivo em agent_action_archives.
+func (r *Retention) archive(ctx context.Context, t Table, p partition) (archive.Result, error) {
+	res := archive.Result{Key: ArchiveKey(t.Name, p.name)}
+	pr, pw := io.Pipe()
+	written := make(chan int64, 1)
+	go func() {
+		n, err := r.write(ctx, pw, t, p.name)
+		written <- n
+		pw.CloseWithError(err)
+	}()
+	if err := r.store.Put(ctx, res.Key, pr, -1, "application/gzip"); err != nil {
+		pr.CloseWithError(err)
+		return res, fmt.Errorf("falha ao gravar %s: %w", res.Key, err)
+	}
+	res.Rows = <-written
+	obj, err := r.store.Stat(ctx, res.Key)
+	if err != nil {
+		return res, err
+	}
+	res.Size = obj.Size
+
+	_, err = r.db.Exec(ctx, "action.record_archive", `
+		INSERT INTO agent_action_archives (partition_name, day, object_key, size_bytes, row_count)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (partition_name) DO UPDATE SET object_key = EXCLUDED.object_key,
+			size_bytes = EXCLUDED.size_bytes, row_count = EXCLUDED.row_count, archived_at = CURRENT_TIMESTAMP`,
+		p.name, p.day, res.Key, res.Size, res.Rows)
+	return res, err
+}
+
+func (r *Retention) write(ctx context.Context, w io.Writer, t Table, name string) (int64, error) {
+	gz := gzip.NewWriter(w)
+	enc := json.NewEncoder(gz)
+	rows, err := r.db.Query(ctx, "action.dump_partition",
+		`SELECT row_to_json(t) FROM `+pq.QuoteIdentifier(name)+` t ORDER BY `+t.OrderBy)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	var n int64
+	for rows.Next() {
+		var row []byte
+		if err := rows.Scan(&row); err != nil {
+			return n, err
+		}
+		if err := enc.Encode(archive.Line{Table: t.Name, Row: row}); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+	return n, gz.Close()
+}
@@ -0,0 +1,535 @@
+package action
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/logging"
+	"smart-city-microservices/internal/supervisor"
+)
+
+// ErrQueueFull indica que a fila de execuções está cheia.
+var ErrQueueFull = errors.New("action queue is full")
+
+// errCancelled é a causa do contexto de uma execução cancelada, para
+// distinguir o cancelamento do timeout.
+var errCancelled = errors.New("action cancelled")
+
+// FinishedError indica que a execução já terminou e não pode mais ser
+// cancelada.
+type FinishedError struct {
+	Status string
+}
+
+func (e *FinishedError) Error() string { return "action already " + e.Status }
+
+var (
+	executions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "async_actions_total",
+		Help:      "Tentativas de ações assíncronas por ação e resultado (succeeded, retry, failed, cancelled, timed_out).",
+	}, []string{"action", "result"})
+
+	queued = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "agent_service",
+		Name:      "async_actions_queued",
+		Help:      "Ações assíncronas aguardando um worker na fila compartilhada, na última leitura desta instância.",
+	})
+)
+
+// Executor é o subconjunto de agent.Service usado pelo runner. Executores
+// que implementam Resumable têm as execuções interrompidas por um
+// reinício retomadas do último checkpoint.
+//
+// ExecuteAction precisa observar ctx: ele é cancelado quando a execução é
+// cancelada e expira no timeout da ação. A checagem é cooperativa; o
+// runner não interrompe a execução, só deixa de esperar por ela depois
+// que ExecuteAction retorna. Ao ver ctx.Done(), a implementação deve
+// retornar o quanto antes com ctx.Err() e, se já tiver feito parte do
+// trabalho, o resultado parcial em ActionResult, que é gravado junto do
+// motivo.
+type Executor interface {
+	ExecuteAction(ctx context.Context, id string, req agent.ActionRequest) (*agent.ActionResult, error)
+}
+
+// popWait é quanto cada worker espera por uma execução antes de conferir
+// se o runner foi parado.
+const popWait = time.Second
+
+// Config configura o runner.
+type Config struct {
+	Workers int
+	// CancelPollInterval é o intervalo com que uma execução em andamento
+	// confere se foi cancelada por outra réplica.
+	CancelPollInterval time.Duration
+	// Instance identifica a réplica nos registros das execuções em
+	// andamento.
+	Instance string
+	// HeartbeatInterval é o intervalo do batimento de cada execução em
+	// andamento; a que fica StaleAfter sem batimento é recuperada por
+	// qualquer réplica, procurando a cada RecoveryInterval.
+	HeartbeatInterval time.Duration
+	StaleAfter        time.Duration
+	RecoveryInterval  time.Duration
+	// MaxResumes limita as retomadas de uma execução; a que volta a ser
+	// interrompida depois disso falha.
+	MaxResumes int
+}
+
+// Runner executa as ações assíncronas da fila com um pool limitado de
+// workers, grava cada transição e a publica no barramento. Os workers de
+// todas as réplicas consomem a mesma fila.
+type Runner struct {
+	repo         *Repository
+	queue        *Queue
+	executor     Executor
+	executorName string
+	cfg          Config
+	publisher    events.Publisher
+	onAction     func(context.Context, string, agent.ActionRequest)
+
+	// done fecha quando Run termina de vez.
+	done chan struct{}
+
+	// retries guarda as novas tentativas agendadas, devolvidas à fila quando
+	// Run termina de vez; running, o cancelamento das execuções em andamento
+	// nesta réplica.
+	mu      sync.Mutex
+	retries map[string]retry
+	running map[string]context.CancelCauseFunc
+}
+
+type job struct {
+	ctx    context.Context
+	action *Action
+}
+
+type retry struct {
+	timer *time.Timer
+	job   job
+}
+
+// NewRunner cria o runner. onAction, se informado, é chamado após cada
+// execução bem-sucedida, como nas ações síncronas. Os workers rodam em Run.
+func NewRunner(repo *Repository, queue *Queue, executor Executor, cfg Config, publisher events.Publisher, onAction func(context.Context, string, agent.ActionRequest)) *Runner {
+	return &Runner{
+		repo:         repo,
+		queue:        queue,
+		executor:     executor,
+		executorName: executorName(executor),
+		cfg:          cfg,
+		publisher:    publisher,
+		onAction:     onAction,
+		done:         make(chan struct{}),
+		retries:      map[string]retry{},
+		running:      map[string]context.CancelCauseFunc{},
+	}
+}
+
+// Run roda os workers até ctx ser cancelado. Ao terminar, espera as
+// execuções em andamento e devolve à fila, sem esperar o backoff, as que
+// aguardavam nova tentativa nesta réplica; as que estão na fila ficam para
+// as demais réplicas ou para o próximo início. Num reinício após falha, as
+// novas tentativas agendadas continuam de pé. As execuções que ficaram sem
+// batimento, desta ou de outra réplica, são recuperadas (ver Resumable).
+func (r *Runner) Run(ctx context.Context) error {
+	g, _ := supervisor.NewGroup(ctx)
+	for i := 0; i < r.cfg.Workers; i++ {
+		g.Go(r.work)
+	}
+	g.Go(r.recovery)
+	err := g.Wait()
+	if ctx.Err() == nil {
+		return err
+	}
+
+	close(r.done)
+	r.mu.Lock()
+	pending := make([]job, 0, len(r.retries))
+	for id, rt := range r.retries {
+		rt.timer.Stop()
+		pending = append(pending, rt.job)
+		delete(r.retries, id)
+	}
+	r.mu.Unlock()
+	for _, j := range pending {
+		r.enqueue(j)
+	}
+	return err
+}
+
+// Submit grava a execução como queued, a coloca na fila e retorna o estado
+// gravado; a partir daí a execução pertence aos workers, desta ou de outra
+// réplica. As execuções herdam o principal e a correlação de ctx, mas não
+// o seu cancelamento. Sem prioridade, vale normal.
+func (r *Runner) Submit(ctx context.Context, a Action) (Action, error) {
+	a.Status, a.Attempt = StatusQueued, 1
+	if a.Priority == "" {
+		a.Priority = PriorityNormal
+	}
+	if err := r.repo.Create(ctx, &a); err != nil {
+		return a, err
+	}
+	owned := a
+	j := job{ctx: context.WithoutCancel(ctx), action: &owned}
+	r.publish(j.ctx, &owned)
+	err := r.queue.Push(j.ctx, &owned)
+	if err == nil {
+		if err := r.queue.Describe(j.ctx, &a); err != nil {
+			logging.FromContext(ctx).WithError(err).Warn("Falha ao consultar a posição da ação na fila")
+		}
+		return a, nil
+	}
+	owned.Status, owned.Error = StatusFailed, ErrQueueFull.Error()
+	if !errors.Is(err, ErrQueueFull) {
+		owned.Error = "action queue unavailable"
+	}
+	r.transition(j.ctx, j)
+	return owned, err
+}
+
+// Cancel cancela a execução se ela ainda não terminou. Na fila, ela fica
+// cancelada aqui mesmo; em andamento, o contexto é cancelado (nesta réplica
+// na hora, nas demais na próxima consulta) e o worker grava o resultado
+// parcial e publica o evento. Retorna *FinishedError se ela já terminou.
+func (r *Runner) Cancel(ctx context.Context, agentID, id, reason string) (*Action, error) {
+	prev, cancelled, err := r.repo.Cancel(ctx, agentID, id, reason)
+	if err != nil {
+		return nil, err
+	}
+	a, err := r.repo.Get(ctx, agentID, id)
+	if err != nil {
+		return nil, err
+	}
+	if !cancelled {
+		return a, &FinishedError{Status: a.Status}
+	}
+
+	r.mu.Lock()
+	if rt, ok := r.retries[id]; ok {
+		rt.timer.Stop()
+		delete(r.retries, id)
+	}
+	if stop, ok := r.running[id]; ok {
+		stop(errCancelled)
+	}
+	r.mu.Unlock()
+	if prev == StatusQueued {
+		// O worker que a retirasse também a descartaria; remover só mantém
+		// as posições certas.
+		if err := r.queue.Remove(ctx, id); err != nil {
+			logging.FromContext(ctx).WithError(err).WithField("action_id", id).
+				Warn("Falha ao remover ação cancelada da fila")
+		}
+		executions.WithLabelValues(a.Action, StatusCancelled).Inc()
+		r.publish(ctx, a)
+	}
+	return a, nil
+}
+
+// Describe preenche a prioridade efetiva e a posição na fila de uma
+// execução em queued.
+func (r *Runner) Describe(ctx context.Context, a *Action) error {
+	if a.Status != StatusQueued {
+		return nil
+	}
+	return r.queue.Describe(ctx, a)
+}
+
+func (r *Runner) work(stop context.Context) error {
+	ctx := logging.Background(context.WithoutCancel(stop), "async-actions")
+	for {
+		select {
+		case <-stop.Done():
+			return nil
+		default:
+		}
+		id, e, err := r.queue.Pop(ctx, popWait)
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			logging.FromContext(ctx).WithError(err).WithField("action_id", id).Error("Falha ao ler a fila de ações assíncronas")
+			select {
+			case <-stop.Done():
+				return nil
+			case <-time.After(popWait):
+			}
+			continue
+		}
+		a, err := r.repo.Load(ctx, id, e.CreatedAt)
+		if err != nil {
+			// Cancelada e já descartada pela retenção, ou o banco falhou:
+			// nos dois casos não há o que executar.
+			logging.FromContext(ctx).WithError(err).WithField("action_id", id).Error("Falha ao carregar ação assíncrona da fila")
+			continue
+		}
+		if a.Finished() {
+			continue
+		}
+		r.execute(job{ctx: e.context(), action: a})
+	}
+}
+
+// execute roda uma tentativa e decide entre sucesso, nova tentativa e falha.
+func (r *Runner) execute(j job) {
+	a := j.action
+	log := logging.FromContext(j.ctx).WithFields(logrus.Fields{
+		"action_id": a.ID, "agent_id": a.AgentID, "action": a.Action, "attempt": a.Attempt,
+	})
+	a.Status, a.Error = StatusRunning, ""
+	if !r.transition(j.ctx, j) {
+		log.Debug("Ação assíncrona cancelada antes de executar")
+		return
+	}
+
+	// Sem o registro a execução segue, mas não é recuperada se esta
+	// réplica parar no meio.
+	x, err := r.repo.StartExecution(j.ctx, a, r.executorName, r.cfg.Instance)
+	if err != nil {
+		log.WithError(err).Error("Falha ao gravar o estado de execução da ação assíncrona")
+	}
+	ctx, stop := context.WithCancelCause(j.ctx)
+	r.mu.Lock()
+	r.running[a.ID] = stop
+	r.mu.Unlock()
+	go r.watch(ctx, *a, stop, x != nil)
+	attempt, cancel := context.WithTimeout(context.WithValue(ctx, checkpointKey{}, checkpointer{runner: r, actionID: a.ID}), a.timeout())
+	req := agent.ActionRequest{Action: a.Action, Params: a.Params}
+	var result *agent.ActionResult
+	if resumable, ok := r.executor.(Resumable); ok && x != nil && x.Resumes > 0 {
+		log.WithField("resumes", x.Resumes).Info("Retomando ação assíncrona do último checkpoint")
+		result, err = resumable.ResumeAction(attempt, a.AgentID, req, x.Checkpoint)
+	} else {
+		result, err = r.executor.ExecuteAction(attempt, a.AgentID, req)
+	}
+	timedOut := errors.Is(attempt.Err(), context.DeadlineExceeded)
+	cancel()
+	cancelled := errors.Is(context.Cause(ctx), errCancelled)
+	reclaimed := errors.Is(context.Cause(ctx), ErrReclaimed)
+	r.mu.Lock()
+	delete(r.running, a.ID)
+	r.mu.Unlock()
+	stop(nil)
+	if reclaimed {
+		// Outra réplica achou a execução sem batimento e já cuida dela.
+		log.Warn("Ação assíncrona assumida por outra réplica; resultado descartado")
+		return
+	}
+	if x != nil {
+		store, cancelStore := context.WithTimeout(context.WithoutCancel(j.ctx), 5*time.Second)
+		if err := r.repo.EndExecution(store, a.ID, r.cfg.Instance); err != nil {
+			log.WithError(err).Warn("Falha ao apagar o estado de execução da ação assíncrona")
+		}
+		cancelStore()
+	}
+
+	if result != nil {
+		a.ResultID, a.Result = result.ActionID, result.Result
+	}
+	switch {
+	case cancelled:
+		r.finishCancelled(j)
+		return
+	case err == nil:
+		a.Status = StatusSucceeded
+		if !r.transition(j.ctx, j) {
+			r.finishCancelled(j)
+			return
+		}
+		executions.WithLabelValues(a.Action, StatusSucceeded).Inc()
+		if r.onAction != nil {
+			r.onAction(j.ctx, a.AgentID, req)
+		}
+		return
+	case errors.Is(err, agent.ErrNotFound):
+		a.Error = "agent not found"
+	case errors.Is(err, agent.ErrValidation):
+		a.Error = err.Error()
+	case timedOut || errors.Is(err, context.DeadlineExceeded):
+		if a.Attempt < a.MaxAttempts {
+			a.Error = "action timed out"
+			r.retry(j, log)
+			return
+		}
+		a.Status, a.Reason = StatusTimedOut, "action timed out after "+a.timeout().String()
+		if !r.transition(j.ctx, j) {
+			r.finishCancelled(j)
+			return
+		}
+		executions.WithLabelValues(a.Action, StatusTimedOut).Inc()
+		return
+	default:
+		log.WithError(err).Error("Falha ao executar ação assíncrona")
+		a.Error = "internal error"
+		if a.Attempt < a.MaxAttempts {
+			r.retry(j, log)
+			return
+		}
+	}
+	a.Status = StatusFailed
+	if !r.transition(j.ctx, j) {
+		r.finishCancelled(j)
+		return
+	}
+	executions.WithLabelValues(a.Action, StatusFailed).Inc()
+}
+
+// watch consulta o estado gravado enquanto a execução roda, para que o
+// cancelamento feito em outra réplica chegue ao contexto, e renova o
+// batimento da execução com heartbeat.
+func (r *Runner) watch(ctx context.Context, a Action, stop context.CancelCauseFunc, heartbeat bool) {
+	ticker := time.NewTicker(r.cfg.CancelPollInterval)
+	defer ticker.Stop()
+	var beat <-chan time.Time
+	if heartbeat {
+		t := time.NewTicker(r.cfg.HeartbeatInterval)
+		defer t.Stop()
+		beat = t.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if status, err := r.repo.Status(ctx, &a); err == nil && status == StatusCancelled {
+				stop(errCancelled)
+				return
+			}
+		case <-beat:
+			owned, err := r.repo.Heartbeat(ctx, a.ID, r.cfg.Instance)
+			if err != nil {
+				logging.FromContext(ctx).WithError(err).WithField("action_id", a.ID).Warn("Falha ao renovar o batimento da ação assíncrona")
+			} else if !owned {
+				stop(ErrReclaimed)
+				return
+			}
+		}
+	}
+}
+
+// finishCancelled grava o resultado parcial de uma execução cancelada
+// enquanto rodava e publica o cancelamento.
+func (r *Runner) finishCancelled(j job) {
+	a := j.action
+	store, cancel := context.WithTimeout(context.WithoutCancel(j.ctx), 5*time.Second)
+	defer cancel()
+	stored, err := r.repo.RecordCancelled(store, a)
+	if err != nil {
+		logging.FromContext(j.ctx).WithError(err).WithField("action_id", a.ID).
+			Error("Falha ao gravar resultado parcial da ação cancelada")
+		return
+	}
+	*a = *stored
+	executions.WithLabelValues(a.Action, StatusCancelled).Inc()
+	r.publish(j.ctx, a)
+}
+
+// retry volta a execução para queued e a reenfileira após o backoff.
+func (r *Runner) retry(j job, log *logrus.Entry) {
+	a := j.action
+	executions.WithLabelValues(a.Action, "retry").Inc()
+	delay := a.backoff()
+	a.Attempt++
+	a.Status = StatusQueued
+	log.WithField("retry_in", delay.String()).WithField("error", a.Error).Warn("Ação assíncrona falhou; nova tentativa agendada")
+	if !r.transition(j.ctx, j) {
+		return
+	}
+
+	r.mu.Lock()
+	select {
+	case <-r.done:
+		r.mu.Unlock()
+		r.enqueue(j)
+		return
+	default:
+	}
+	r.retries[a.ID] = retry{job: j, timer: time.AfterFunc(delay, func() {
+		r.mu.Lock()
+		select {
+		case <-r.done:
+			// Run devolve a execução à fila ao terminar.
+			r.mu.Unlock()
+			return
+		default:
+		}
+		delete(r.retries, a.ID)
+		r.mu.Unlock()
+		r.enqueue(j)
+	})}
+	r.mu.Unlock()
+}
+
+// enqueue devolve uma nova tentativa à fila, com a prioridade original.
+// Se a fila recusa, a execução falha.
+func (r *Runner) enqueue(j job) {
+	store, cancel := context.WithTimeout(context.WithoutCancel(j.ctx), 5*time.Second)
+	defer cancel()
+	err := r.queue.Push(store, j.action)
+	if err == nil {
+		return
+	}
+	logging.FromContext(j.ctx).WithError(err).WithField("action_id", j.action.ID).
+		Error("Falha ao devolver ação assíncrona à fila")
+	j.action.Status, j.action.Error = StatusFailed, ErrQueueFull.Error()
+	if !errors.Is(err, ErrQueueFull) {
+		j.action.Error = "action queue unavailable"
+	}
+	r.transition(j.ctx, j)
+}
+
+// transition grava o estado da execução e publica o evento correspondente.
+// Retorna false, sem publicar, se a execução já estava num estado final,
+// isto é, se foi cancelada no meio do caminho.
+func (r *Runner) transition(ctx context.Context, j job) bool {
+	store, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	err := r.repo.Update(store, j.action)
+	if errors.Is(err, ErrNotFound) {
+		return false
+	}
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).WithField("action_id", j.action.ID).
+			Error("Falha ao gravar estado da ação assíncrona")
+	}
+	r.publish(ctx, j.action)
+	return true
+}
+
+func (r *Runner) publish(ctx context.Context, a *Action) {
+	eventType := map[string]string{
+		StatusQueued:    EventQueued,
+		StatusRunning:   EventRunning,
+		StatusSucceeded: EventSucceeded,
+		StatusFailed:    EventFailed,
+		StatusCancelled: EventCancelled,
+		StatusTimedOut:  EventTimedOut,
+	}[a.Status]
+	r.publisher.Publish(ctx, events.New(events.TopicAgents, eventType, events.AgentActionV1{
+		ID:          a.ID,
+		AgentID:     a.AgentID,
+		ProjectID:   a.ProjectID,
+		Action:      a.Action,
+		Status:      a.Status,
+		Priority:    a.Priority,
+		Attempt:     a.Attempt,
+		MaxAttempts: a.MaxAttempts,
+		ResultID:    a.ResultID,
+		Result:      a.Result,
+		Error:       a.Error,
+		Reason:      a.Reason,
+		CreatedAt:   a.CreatedAt,
+		StartedAt:   a.StartedAt,
+		FinishedAt:  a.FinishedAt,
+	}))
+}
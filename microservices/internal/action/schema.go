@@ -0,0 +1,303 @@
+package action
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// annotations são palavras-chave de JSON Schema aceitas e ignoradas na
+// validação; servem à interface que monta o formulário.
+var annotations = map[string]bool{
+	"$schema": true, "$id": true, "title": true, "description": true,
+	"default": true, "examples": true, "format": true,
+}
+
+var jsonTypes = map[string]bool{
+	"null": true, "boolean": true, "integer": true, "number": true,
+	"string": true, "array": true, "object": true,
+}
+
+// ParseSchema decodifica o JSON Schema de params de uma ação e confere que
+// ele só usa o subconjunto suportado: type, enum, properties, required,
+// additionalProperties, items, minimum, maximum, minLength, maxLength,
+// minItems e maxItems. Palavras-chave fora dele são recusadas em vez de
+// ignoradas, para que o schema nunca aceite mais do que declara. Vazio
+// retorna nil, que aceita qualquer objeto.
+func ParseSchema(raw string) (map[string]interface{}, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &schema); err != nil {
+		return nil, fmt.Errorf("schema não é um objeto JSON: %w", err)
+	}
+	if err := checkSchema(schema, "schema"); err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+func checkSchema(schema map[string]interface{}, path string) error {
+	keys := make([]string, 0, len(schema))
+	for k := range schema {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := schema[k]
+		switch {
+		case annotations[k]:
+		case k == "type":
+			types, ok := typeList(v)
+			if !ok || len(types) == 0 {
+				return fmt.Errorf("%s.type deve ser um tipo JSON ou uma lista deles", path)
+			}
+			for _, t := range types {
+				if !jsonTypes[t] {
+					return fmt.Errorf("%s.type: tipo desconhecido %q", path, t)
+				}
+			}
+		case k == "enum":
+			if list, ok := v.([]interface{}); !ok || len(list) == 0 {
+				return fmt.Errorf("%s.enum deve ser uma lista não vazia", path)
+			}
+		case k == "properties":
+			props, ok := v.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("%s.properties deve ser um objeto", path)
+			}
+			for name, p := range props {
+				sub, ok := p.(map[string]interface{})
+				if !ok {
+					return fmt.Errorf("%s.properties.%s deve ser um schema", path, name)
+				}
+				if err := checkSchema(sub, path+".properties."+name); err != nil {
+					return err
+				}
+			}
+		case k == "required":
+			list, ok := v.([]interface{})
+			if !ok {
+				return fmt.Errorf("%s.required deve ser uma lista de nomes", path)
+			}
+			for _, name := range list {
+				if _, ok := name.(string); !ok {
+					return fmt.Errorf("%s.required deve ser uma lista de nomes", path)
+				}
+			}
+		case k == "additionalProperties" || k == "items":
+			if _, ok := v.(bool); ok && k == "additionalProperties" {
+				continue
+			}
+			sub, ok := v.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("%s.%s deve ser um schema", path, k)
+			}
+			if err := checkSchema(sub, path+"."+k); err != nil {
+				return err
+			}
+		case k == "minimum" || k == "maximum":
+			if _, ok := v.(float64); !ok {
+				return fmt.Errorf("%s.%s deve ser um número", path, k)
+			}
+		case k == "minLength" || k == "maxLength" || k == "minItems" || k == "maxItems":
+			if n, ok := v.(float64); !ok || n < 0 || n != math.Trunc(n) {
+				return fmt.Errorf("%s.%s deve ser um inteiro não negativo", path, k)
+			}
+		default:
+			return fmt.Errorf("%s: palavra-chave %q não suportada", path, k)
+		}
+	}
+	return nil
+}
+
+func typeList(v interface{}) ([]string, bool) {
+	switch v := v.(type) {
+	case string:
+		return []string{v}, true
+	case []interface{}:
+		out := make([]string, 0, len(v))
+		for _, t := range v {
+			s, ok := t.(string)
+			if !ok {
+				return nil, false
+			}
+			out = append(out, s)
+		}
+		return out, true
+	}
+	return nil, false
+}
+
+// normalize leva params à forma decodificada de JSON (números float64,
+// listas []interface{}), que é a que validate entende, independente de
+// params ter vindo de JSON, gRPC ou código Go.
+func normalize(params map[string]interface{}) (interface{}, error) {
+	if params == nil {
+		return map[string]interface{}{}, nil
+	}
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	err = json.Unmarshal(raw, &v)
+	return v, err
+}
+
+// ValidateParams valida params contra um schema conferido por ParseSchema,
+// para quem declara parâmetros como as ações, como os comportamentos de
+// agentes. path prefixa os campos nas mensagens, que vão para o cliente.
+func ValidateParams(schema, params map[string]interface{}, path string) error {
+	if schema == nil {
+		return nil
+	}
+	v, err := normalize(params)
+	if err != nil {
+		return err
+	}
+	return validate(schema, v, path)
+}
+
+// validate valida um valor decodificado de JSON contra um schema conferido
+// por ParseSchema. As mensagens vão para o cliente.
+func validate(schema map[string]interface{}, v interface{}, path string) error {
+	if t, ok := schema["type"]; ok {
+		types, _ := typeList(t)
+		matched := false
+		for _, typ := range types {
+			if jsonTypeMatches(typ, v) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s: expected %s, got %s", path, strings.Join(types, " or "), jsonTypeOf(v))
+		}
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			if reflect.DeepEqual(allowed, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: value is not one of the allowed values", path)
+		}
+	}
+
+	switch v := v.(type) {
+	case float64:
+		if min, ok := schema["minimum"].(float64); ok && v < min {
+			return fmt.Errorf("%s: must be >= %v", path, min)
+		}
+		if max, ok := schema["maximum"].(float64); ok && v > max {
+			return fmt.Errorf("%s: must be <= %v", path, max)
+		}
+	case string:
+		n := float64(utf8.RuneCountInString(v))
+		if min, ok := schema["minLength"].(float64); ok && n < min {
+			return fmt.Errorf("%s: must have at least %v characters", path, min)
+		}
+		if max, ok := schema["maxLength"].(float64); ok && n > max {
+			return fmt.Errorf("%s: must have at most %v characters", path, max)
+		}
+	case []interface{}:
+		n := float64(len(v))
+		if min, ok := schema["minItems"].(float64); ok && n < min {
+			return fmt.Errorf("%s: must have at least %v items", path, min)
+		}
+		if max, ok := schema["maxItems"].(float64); ok && n > max {
+			return fmt.Errorf("%s: must have at most %v items", path, max)
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				if err := validate(items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case map[string]interface{}:
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				if _, ok := v[name.(string)]; !ok {
+					return fmt.Errorf("%s: missing required field %q", path, name)
+				}
+			}
+		}
+		props, _ := schema["properties"].(map[string]interface{})
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if p, ok := props[k].(map[string]interface{}); ok {
+				if err := validate(p, v[k], path+"."+k); err != nil {
+					return err
+				}
+				continue
+			}
+			switch extra := schema["additionalProperties"].(type) {
+			case bool:
+				if !extra {
+					return fmt.Errorf("%s: unknown field %q", path, k)
+				}
+			case map[string]interface{}:
+				if err := validate(extra, v[k], path+"."+k); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func jsonTypeMatches(typ string, v interface{}) bool {
+	switch typ {
+	case "null":
+		return v == nil
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	case "integer":
+		f, ok := v.(float64)
+		return ok && f == math.Trunc(f)
+	case "array":
+		_, ok := v.([]interface{})
+		return ok
+	case "object":
+		_, ok := v.(map[string]interface{})
+		return ok
+	}
+	return false
+}
+
+func jsonTypeOf(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case []interface{}:
+		return "array"
+	}
+	return "object"
+}
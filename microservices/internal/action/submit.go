@@ -0,0 +1,75 @@
+package action
+
+import (
+	"context"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/auth"
+)
+
+// newAction monta a execução de req no agente com a política da definição.
+func newAction(def Definition, ag *agent.Agent, req agent.ActionRequest, priority string) Action {
+	a := Action{
+		AgentID:        ag.ID,
+		ProjectID:      ag.ProjectID,
+		Action:         req.Action,
+		Params:         req.Params,
+		Priority:       priority,
+		MaxAttempts:    def.Retry.MaxAttempts,
+		RetryBackoffMs: def.Retry.Backoff.Milliseconds(),
+		TimeoutMs:      def.Timeout.Milliseconds(),
+	}
+	if a.Params == nil {
+		a.Params = map[string]interface{}{}
+	}
+	return a
+}
+
+// Submitter submete ações de quem não espera a resposta, como os
+// agendamentos. Toda ação vai para a fila do runner, demorada ou não,
+// depois da mesma checagem do registro que a API REST faz. Sem timeout na
+// definição, vale o padrão; sem definição (com allow_unregistered), também
+// uma só tentativa.
+type Submitter struct {
+	registry *Registry
+	runner   *Runner
+	agents   AgentGetter
+}
+
+// NewSubmitter cria o submetedor.
+func NewSubmitter(registry *Registry, runner *Runner, agents AgentGetter) *Submitter {
+	return &Submitter{registry: registry, runner: runner, agents: agents}
+}
+
+// Check confere se o agente existe e aceita req. Retorna agent.ErrNotFound
+// ou um erro de Registry.CheckAgent.
+func (s *Submitter) Check(ctx context.Context, agentID string, req agent.ActionRequest) (*agent.Agent, error) {
+	ag, err := s.agents.GetAgent(ctx, agentID)
+	if err != nil {
+		return nil, err
+	}
+	return ag, s.registry.CheckAgent(ctx, ag, req)
+}
+
+// Submit confere req e a coloca na fila com a prioridade informada. O
+// autor é o principal de ctx, se houver.
+func (s *Submitter) Submit(ctx context.Context, agentID string, req agent.ActionRequest, priority string) (Action, error) {
+	ag, err := s.Check(ctx, agentID, req)
+	if err != nil {
+		return Action{}, err
+	}
+	def, ok := s.registry.Lookup(req.Action)
+	if !ok {
+		def = Definition{Name: req.Action, Retry: RetryPolicy{MaxAttempts: 1}}
+	}
+	// Na fila, toda tentativa precisa de limite, inclusive a das ações
+	// que não são demoradas.
+	if def.Timeout == 0 {
+		def.Timeout = s.registry.opts.DefaultTimeout
+	}
+	a := newAction(def, ag, req, priority)
+	if p := auth.FromContext(ctx); p != nil {
+		a.CreatedBy = p.Subject
+	}
+	return s.runner.Submit(ctx, a)
+}
@@ -0,0 +1,128 @@
+// Package actionbatch executa uma mesma ação em muitos agentes de uma vez,
+// ex.: "reroute" para todos os ônibus de um bairro. O lote é aceito na hora
+// e executado em segundo plano por um pool limitado de workers; o resultado
+// de cada agente fica no banco para acompanhamento.
+package actionbatch
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"smart-city-microservices/internal/action"
+)
+
+// ErrNotFound indica que o lote não existe.
+var ErrNotFound = errors.New("action batch not found")
+
+// Estados de um lote.
+const (
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+	StatusCancelled = "cancelled"
+)
+
+// Estados da execução em um agente.
+const (
+	ItemPending   = "pending"
+	ItemRunning   = "running"
+	ItemSucceeded = "succeeded"
+	ItemFailed    = "failed"
+	ItemCancelled = "cancelled"
+)
+
+// Filter seleciona os agentes do lote como em GET /agents.
+type Filter struct {
+	Type         string   `json:"type,omitempty"`
+	Tags         []string `json:"tags,omitempty"`
+	SimulationID string   `json:"simulation_id,omitempty"`
+}
+
+func (f *Filter) empty() bool {
+	return f.Type == "" && len(f.Tags) == 0 && f.SimulationID == ""
+}
+
+// Counts resume o andamento do lote por estado dos itens.
+type Counts struct {
+	Pending   int `json:"pending"`
+	Running   int `json:"running"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+	Cancelled int `json:"cancelled"`
+}
+
+// Batch é uma execução em lote.
+type Batch struct {
+	ID          string                 `json:"id"`
+	Action      string                 `json:"action"`
+	Priority    string                 `json:"priority"`
+	Params      map[string]interface{} `json:"params"`
+	Filter      *Filter                `json:"filter,omitempty"`
+	Status      string                 `json:"status"`
+	Total       int                    `json:"total"`
+	Counts      Counts                 `json:"counts"`
+	CreatedBy   string                 `json:"created_by,omitempty"`
+	CreatedAt   time.Time              `json:"created_at"`
+	CancelledAt *time.Time             `json:"cancelled_at,omitempty"`
+	FinishedAt  *time.Time             `json:"finished_at,omitempty"`
+	Items       []Item                 `json:"items,omitempty"`
+}
+
+// Plan é o resultado do ensaio de um lote: o que seria executado e em
+// quais agentes.
+type Plan struct {
+	Action   string                 `json:"action"`
+	Priority string                 `json:"priority"`
+	Params   map[string]interface{} `json:"params"`
+	Filter   *Filter                `json:"filter,omitempty"`
+	Total    int                    `json:"total"`
+	AgentIDs []string               `json:"agent_ids"`
+}
+
+// Item é o resultado da ação em um agente.
+type Item struct {
+	AgentID    string                 `json:"agent_id"`
+	Status     string                 `json:"status"`
+	ActionID   string                 `json:"action_id,omitempty"`
+	Result     map[string]interface{} `json:"result,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+	StartedAt  *time.Time             `json:"started_at,omitempty"`
+	FinishedAt *time.Time             `json:"finished_at,omitempty"`
+}
+
+// Request é o corpo de POST /agents/actions/batch: agent_ids ou filter,
+// nunca os dois, e a ação executada em cada agente. Lotes critical não
+// passam pelo limite de workers.
+type Request struct {
+	AgentIDs []string               `json:"agent_ids"`
+	Filter   *Filter                `json:"filter"`
+	Action   string                 `json:"action" binding:"required"`
+	Params   map[string]interface{} `json:"params"`
+	Priority string                 `json:"priority"`
+}
+
+// Validate confere a forma do pedido; os agentes do filtro são resolvidos
+// depois.
+func (r *Request) Validate() error {
+	if strings.TrimSpace(r.Action) == "" {
+		return errors.New("action is required")
+	}
+	hasFilter := r.Filter != nil && !r.Filter.empty()
+	switch {
+	case len(r.AgentIDs) > 0 && hasFilter:
+		return errors.New("use either agent_ids or filter, not both")
+	case len(r.AgentIDs) == 0 && !hasFilter:
+		return errors.New("agent_ids or filter is required")
+	}
+	if !action.ValidPriority(r.Priority) {
+		return errors.New("invalid priority: " + r.Priority + " (use critical, high, normal or low)")
+	}
+	for _, id := range r.AgentIDs {
+		if _, err := uuid.Parse(id); err != nil {
+			return errors.New("invalid agent id: " + id)
+		}
+	}
+	return nil
+}
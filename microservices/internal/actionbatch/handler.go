@@ -0,0 +1,142 @@
+package actionbatch
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/action"
+	"smart-city-microservices/internal/audit"
+	"smart-city-microservices/internal/dryrun"
+	"smart-city-microservices/internal/i18n"
+	"smart-city-microservices/internal/logging"
+)
+
+// Handler expõe a criação, o andamento e o cancelamento de lotes.
+type Handler struct {
+	repo   *Repository
+	runner *Runner
+}
+
+// NewHandler cria o handler de lotes.
+func NewHandler(repo *Repository, runner *Runner) *Handler {
+	return &Handler{repo: repo, runner: runner}
+}
+
+// Create aceita o lote e responde 202 com o id; a execução segue em
+// segundo plano. 403 se o principal não pode executar a ação. Com
+// X-Dry-Run: true, responde o plano do lote.
+func (h *Handler) Create(c *gin.Context) {
+	var req Request
+	if err := c.ShouldBindJSON(&req); err != nil {
+		i18n.BindError(c, err)
+		return
+	}
+	if dryrun.Enabled(c.Request.Context()) {
+		h.plan(c, req)
+		return
+	}
+	b, err := h.runner.Submit(c.Request.Context(), req)
+	var invalid *ValidationError
+	var forbidden *action.ForbiddenError
+	switch {
+	case errors.As(err, &invalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.As(err, &forbidden):
+		c.JSON(http.StatusForbidden, action.ForbiddenBody(forbidden))
+		return
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	audit.Record(c.Request.Context(), "action_batch.created", logrus.Fields{
+		"batch_id": b.ID, "action": b.Action, "priority": b.Priority, "total": b.Total,
+	})
+	c.Header("Location", "/api/v1/action-batches/"+b.ID)
+	c.JSON(http.StatusAccepted, b)
+}
+
+// plan responde o ensaio de Create: o lote que seria criado e os agentes.
+func (h *Handler) plan(c *gin.Context, req Request) {
+	p, err := h.runner.Plan(c.Request.Context(), req)
+	var invalid *ValidationError
+	var forbidden *action.ForbiddenError
+	switch {
+	case errors.As(err, &invalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.As(err, &forbidden):
+		c.JSON(http.StatusForbidden, action.ForbiddenBody(forbidden))
+		return
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	dryrun.JSON(c, p)
+}
+
+// Get retorna o andamento do lote e o resultado por agente, filtrado por
+// ?status= (pending, running, succeeded, failed, cancelled).
+func (h *Handler) Get(c *gin.Context) {
+	status := c.Query("status")
+	switch status {
+	case "", ItemPending, ItemRunning, ItemSucceeded, ItemFailed, ItemCancelled:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid status: " + status})
+		return
+	}
+	b, err := h.repo.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.serviceError(c, err)
+		return
+	}
+	if b.Items, err = h.repo.Items(c.Request.Context(), b.ID, status); err != nil {
+		h.internalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, b)
+}
+
+// Cancel cancela os itens que ainda não começaram; os em execução terminam.
+func (h *Handler) Cancel(c *gin.Context) {
+	ctx := c.Request.Context()
+	b, err := h.repo.Get(ctx, c.Param("id"))
+	if err != nil {
+		h.serviceError(c, err)
+		return
+	}
+	if b.Status != StatusRunning {
+		c.JSON(http.StatusConflict, gin.H{"error": "action batch already " + b.Status})
+		return
+	}
+	h.runner.Cancel(b.ID)
+	cancelled, err := h.repo.Cancel(ctx, b.ID, "")
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	audit.Record(ctx, "action_batch.cancelled", logrus.Fields{"batch_id": b.ID, "cancelled": cancelled})
+	if b, err = h.repo.Get(ctx, b.ID); err != nil {
+		h.serviceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, b)
+}
+
+func (h *Handler) serviceError(c *gin.Context, err error) {
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	h.internalError(c, err)
+}
+
+func (h *Handler) internalError(c *gin.Context, err error) {
+	logging.FromContext(c.Request.Context()).WithError(err).Error("Erro no handler de lotes de ações")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
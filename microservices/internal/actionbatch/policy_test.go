@@ -0,0 +1,253 @@
+package actionbatch
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"smart-city-microservices/internal/action"
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/auth"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+	sql.Register("actionbatchtest", batchDriver{})
+}
+
+// finished são os resultados gravados por DSN: agente e status, com o erro
+// do item falho.
+var (
+	finishedMu sync.Mutex
+	finished   = map[string]map[string]string{}
+)
+
+// batchDriver grava qualquer lote com o id b-1 e anota os itens encerrados.
+type batchDriver struct{}
+
+func (batchDriver) Open(dsn string) (driver.Conn, error) { return batchConn{dsn}, nil }
+
+type batchConn struct{ dsn string }
+
+func (c batchConn) Prepare(query string) (driver.Stmt, error) {
+	return batchStmt{c.dsn, query}, nil
+}
+func (batchConn) Close() error              { return nil }
+func (batchConn) Begin() (driver.Tx, error) { return nil, errors.New("sem transações") }
+
+type batchStmt struct {
+	dsn   string
+	query string
+}
+
+func (batchStmt) Close() error  { return nil }
+func (batchStmt) NumInput() int { return -1 }
+
+func (s batchStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if strings.Contains(s.query, "SET status = $3") {
+		item := fmt.Sprint(args[2])
+		if args[5] != nil && args[5] != "" {
+			item += ": " + fmt.Sprint(args[5])
+		}
+		finishedMu.Lock()
+		finished[s.dsn][fmt.Sprint(args[1])] = item
+		finishedMu.Unlock()
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s batchStmt) Query([]driver.Value) (driver.Rows, error) {
+	return &batchRows{}, nil
+}
+
+type batchRows struct{ done bool }
+
+func (*batchRows) Columns() []string { return []string{"id", "created_at"} }
+func (*batchRows) Close() error      { return nil }
+func (r *batchRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0], dest[1] = "b-1", time.Now()
+	return nil
+}
+
+// testProjects restringe ações por projeto: projeto, ação e papéis.
+type testProjects map[string]map[string][]string
+
+func (p testProjects) ActionRoles(projectID, action string) []string {
+	return p[projectID][action]
+}
+
+// Os agentes do lote: bus1 é de proj-centro e bus2 de proj-restrito.
+const (
+	bus1 = "5b1f0c3e-8a2d-4c6b-9e7f-1a3c5e7f9b2d"
+	bus2 = "9c4e2a7b-3d5f-4e8a-b1c6-7d9f2b4e6a8c"
+)
+
+// fakeAgents conhece bus1 e bus2 e executa qualquer ação.
+type fakeAgents struct{}
+
+func (fakeAgents) GetAgent(_ context.Context, id string) (*agent.Agent, error) {
+	switch id {
+	case bus1:
+		return &agent.Agent{ID: id, Type: "bus", ProjectID: "proj-centro"}, nil
+	case bus2:
+		return &agent.Agent{ID: id, Type: "bus", ProjectID: "proj-restrito"}, nil
+	}
+	return nil, agent.ErrNotFound
+}
+
+func (fakeAgents) ExecuteAction(context.Context, string, agent.ActionRequest) (*agent.ActionResult, error) {
+	return &agent.ActionResult{ActionID: "act-1", Status: "completed"}, nil
+}
+
+func (fakeAgents) ListAgents(context.Context, agent.Filter) ([]agent.Agent, int, error) {
+	return nil, 0, nil
+}
+
+func (fakeAgents) CreateAgent(context.Context, agent.CreateAgentRequest) (*agent.Agent, error) {
+	return nil, nil
+}
+
+func (fakeAgents) UpdateAgent(context.Context, string, agent.UpdateAgentRequest) (*agent.Agent, error) {
+	return nil, nil
+}
+
+func (fakeAgents) DeleteAgent(context.Context, string) error { return nil }
+
+func (fakeAgents) GetPerformance(context.Context, string) (*agent.Performance, error) {
+	return nil, nil
+}
+
+// newBatchRouter monta POST /agents/actions/batch com o runner rodando
+// sobre o serviço com checagem, como em main.go.
+func newBatchRouter(t *testing.T, p *auth.Principal) *gin.Engine {
+	t.Helper()
+	finishedMu.Lock()
+	finished[t.Name()] = map[string]string{}
+	finishedMu.Unlock()
+	db, err := sql.Open("actionbatchtest", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := action.NewRegistry([]action.Definition{
+		{Name: "emergency_stop", Roles: []string{auth.RoleAdmin}},
+		{Name: "calibrate_sensor", Roles: []string{auth.RoleOperator}, Scopes: []string{"maintenance"}},
+		{Name: "reroute"},
+	}, action.Options{Projects: testProjects{"proj-restrito": {"reroute": {auth.RoleOperator}}}})
+	repo := NewRepository(db)
+	runner := NewRunner(repo, action.NewCheckedService(fakeAgents{}, registry), Config{Workers: 1, MaxAgents: 10, Timeout: time.Second}, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		runner.Run(ctx)
+		close(stopped)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+		db.Close()
+	})
+	r := gin.New()
+	r.POST("/agents/actions/batch", func(c *gin.Context) {
+		if p != nil {
+			auth.SetPrincipal(c, p)
+		}
+	}, NewHandler(repo, runner).Create)
+	return r
+}
+
+// TestCreatePolicy confere a política nos lotes: a exigência da definição
+// antes de aceitar o lote (403) e a restrição do projeto em cada item,
+// que falha com o motivo.
+func TestCreatePolicy(t *testing.T) {
+	var (
+		viewer      = &auth.Principal{Subject: "oidc:viewer", Roles: []string{auth.RoleViewer}}
+		operator    = &auth.Principal{Subject: "oidc:operator", Roles: []string{auth.RoleOperator}}
+		admin       = &auth.Principal{Subject: "oidc:admin", Roles: []string{auth.RoleAdmin}}
+		maintenance = &auth.Principal{Subject: "apikey:k1", Scopes: []string{"maintenance"}}
+	)
+	const restricted = `failed: action "reroute" requires role operator in project proj-restrito`
+	tests := []struct {
+		name      string
+		principal *auth.Principal
+		action    string
+		status    int
+		roles     []string
+		scopes    []string
+		// items é o resultado de cada agente dos lotes aceitos.
+		items map[string]string
+	}{
+		{name: "free action", principal: viewer, action: "reroute", status: http.StatusAccepted, items: map[string]string{bus1: "succeeded", bus2: restricted}},
+		{name: "project restriction by scoped key", principal: maintenance, action: "reroute", status: http.StatusAccepted, items: map[string]string{bus1: "succeeded", bus2: restricted}},
+		{name: "project restriction by operator", principal: operator, action: "reroute", status: http.StatusAccepted, items: map[string]string{bus1: "succeeded", bus2: "succeeded"}},
+		{name: "admin action by viewer", principal: viewer, action: "emergency_stop", status: http.StatusForbidden, roles: []string{auth.RoleAdmin}},
+		{name: "admin action by operator", principal: operator, action: "emergency_stop", status: http.StatusForbidden, roles: []string{auth.RoleAdmin}},
+		{name: "admin action without principal", action: "emergency_stop", status: http.StatusForbidden, roles: []string{auth.RoleAdmin}},
+		{name: "admin action by admin", principal: admin, action: "emergency_stop", status: http.StatusAccepted, items: map[string]string{bus1: "succeeded", bus2: "succeeded"}},
+		{
+			name: "role or scope action by viewer", principal: viewer, action: "calibrate_sensor", status: http.StatusForbidden,
+			roles: []string{auth.RoleOperator}, scopes: []string{"maintenance"},
+		},
+		{name: "role or scope action by scoped key", principal: maintenance, action: "calibrate_sensor", status: http.StatusAccepted, items: map[string]string{bus1: "succeeded", bus2: "succeeded"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newBatchRouter(t, tt.principal)
+			w := httptest.NewRecorder()
+			body := `{"agent_ids":["` + bus1 + `","` + bus2 + `"],"action":"` + tt.action + `"}`
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/agents/actions/batch", strings.NewReader(body)))
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			if tt.status == http.StatusForbidden {
+				var got struct {
+					Action         string   `json:"action"`
+					RequiredRoles  []string `json:"required_roles"`
+					RequiredScopes []string `json:"required_scopes"`
+				}
+				if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+					t.Fatal(err)
+				}
+				if got.Action != tt.action || !reflect.DeepEqual(got.RequiredRoles, tt.roles) || !reflect.DeepEqual(got.RequiredScopes, tt.scopes) {
+					t.Errorf("corpo %s", w.Body.String())
+				}
+			}
+
+			want := tt.items
+			if want == nil {
+				want = map[string]string{}
+			}
+			var got map[string]string
+			for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+				finishedMu.Lock()
+				got = make(map[string]string, len(finished[t.Name()]))
+				for id, item := range finished[t.Name()] {
+					got[id] = item
+				}
+				finishedMu.Unlock()
+				if len(got) >= len(want) || time.Now().After(deadline) {
+					break
+				}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("itens %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package actionbatch
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"smart-city-microservices/internal/instrument"
+)
+
+// Repository persiste os lotes e o resultado por agente no PostgreSQL. O
+// estado fica no banco para que qualquer réplica responda ao andamento e
+// ao cancelamento, mesmo que o lote execute em outra.
+type Repository struct {
+	db *instrument.DB
+}
+
+// NewRepository cria o repositório.
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: instrument.NewDB(db)}
+}
+
+// Create grava o lote e um item pendente por agente, na ordem informada.
+func (r *Repository) Create(ctx context.Context, b *Batch, agentIDs []string) error {
+	params, err := json.Marshal(b.Params)
+	if err != nil {
+		return err
+	}
+	// nil, e não um []byte vazio, para gravar NULL.
+	var filter interface{}
+	if b.Filter != nil {
+		raw, err := json.Marshal(b.Filter)
+		if err != nil {
+			return err
+		}
+		filter = raw
+	}
+	b.Total = len(agentIDs)
+	return r.db.QueryRow(ctx, "actionbatch.create", `
+		WITH batch AS (
+			INSERT INTO action_batches (action, priority, params, filter, total, created_by)
+			VALUES ($1, $7, $2, $3, $4, NULLIF($5, ''))
+			RETURNING id, created_at
+		), items AS (
+			INSERT INTO action_batch_items (batch_id, agent_id, position)
+			SELECT batch.id, a.agent_id::uuid, a.position FROM batch,
+				unnest($6::text[]) WITH ORDINALITY AS a(agent_id, position)
+		)
+		SELECT id, created_at FROM batch`,
+		b.Action, params, filter, b.Total, b.CreatedBy, pq.Array(agentIDs), b.Priority,
+	).Scan(&b.ID, &b.CreatedAt)
+}
+
+// Get busca o lote com a contagem de itens por estado.
+func (r *Repository) Get(ctx context.Context, id string) (*Batch, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrNotFound
+	}
+	var b Batch
+	var params, filter []byte
+	var createdBy sql.NullString
+	var cancelled, finished sql.NullTime
+	err := r.db.QueryRow(ctx, "actionbatch.get", `
+		SELECT b.id, b.action, b.priority, b.params, b.filter, b.total, b.created_by, b.created_at, b.cancelled_at, b.finished_at,
+			COUNT(*) FILTER (WHERE i.status = 'pending'),
+			COUNT(*) FILTER (WHERE i.status = 'running'),
+			COUNT(*) FILTER (WHERE i.status = 'succeeded'),
+			COUNT(*) FILTER (WHERE i.status = 'failed'),
+			COUNT(*) FILTER (WHERE i.status = 'cancelled')
+		FROM action_batches b LEFT JOIN action_batch_items i ON i.batch_id = b.id
+		WHERE b.id = $1
+		GROUP BY b.id`, id,
+	).Scan(&b.ID, &b.Action, &b.Priority, &params, &filter, &b.Total, &createdBy, &b.CreatedAt, &cancelled, &finished,
+		&b.Counts.Pending, &b.Counts.Running, &b.Counts.Succeeded, &b.Counts.Failed, &b.Counts.Cancelled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(params, &b.Params); err != nil {
+		return nil, err
+	}
+	if filter != nil {
+		b.Filter = &Filter{}
+		if err := json.Unmarshal(filter, b.Filter); err != nil {
+			return nil, err
+		}
+	}
+	b.CreatedBy = createdBy.String
+	if cancelled.Valid {
+		b.CancelledAt = &cancelled.Time
+	}
+	if finished.Valid {
+		b.FinishedAt = &finished.Time
+	}
+	switch {
+	case b.FinishedAt == nil:
+		b.Status = StatusRunning
+	case b.CancelledAt != nil:
+		b.Status = StatusCancelled
+	default:
+		b.Status = StatusCompleted
+	}
+	return &b, nil
+}
+
+// Items retorna os itens do lote na ordem do pedido, opcionalmente só os
+// de um estado.
+func (r *Repository) Items(ctx context.Context, batchID, status string) ([]Item, error) {
+	rows, err := r.db.Query(ctx, "actionbatch.items", `
+		SELECT agent_id, status, COALESCE(action_id, ''), result, COALESCE(error, ''), started_at, finished_at
+		FROM action_batch_items
+		WHERE batch_id = $1 AND ($2 = '' OR status = $2)
+		ORDER BY position`, batchID, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []Item{}
+	for rows.Next() {
+		var it Item
+		var result []byte
+		var started, finished sql.NullTime
+		if err := rows.Scan(&it.AgentID, &it.Status, &it.ActionID, &result, &it.Error, &started, &finished); err != nil {
+			return nil, err
+		}
+		if result != nil {
+			if err := json.Unmarshal(result, &it.Result); err != nil {
+				return nil, err
+			}
+		}
+		if started.Valid {
+			it.StartedAt = &started.Time
+		}
+		if finished.Valid {
+			it.FinishedAt = &finished.Time
+		}
+		out = append(out, it)
+	}
+	return out, rows.Err()
+}
+
+// Claim marca o item como em execução. Retorna false se ele não está mais
+// pendente, ou seja, se o lote foi cancelado antes.
+func (r *Repository) Claim(ctx context.Context, batchID, agentID string) (bool, error) {
+	res, err := r.db.Exec(ctx, "actionbatch.claim", `
+		UPDATE action_batch_items SET status = 'running', started_at = CURRENT_TIMESTAMP
+		WHERE batch_id = $1 AND agent_id = $2 AND status = 'pending'`, batchID, agentID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// Finish grava o resultado do item e encerra o lote quando não restam itens
+// pendentes ou em execução.
+func (r *Repository) Finish(ctx context.Context, batchID string, it Item) error {
+	var result interface{}
+	if it.Result != nil {
+		raw, err := json.Marshal(it.Result)
+		if err != nil {
+			return err
+		}
+		result = raw
+	}
+	_, err := r.db.Exec(ctx, "actionbatch.finish", `
+		UPDATE action_batch_items SET status = $3, action_id = NULLIF($4, ''), result = $5,
+			error = NULLIF($6, ''), finished_at = CURRENT_TIMESTAMP
+		WHERE batch_id = $1 AND agent_id = $2`,
+		batchID, it.AgentID, it.Status, it.ActionID, result, it.Error)
+	if err != nil {
+		return err
+	}
+	return r.close(ctx, batchID)
+}
+
+// Cancel cancela os itens ainda pendentes e retorna quantos foram
+// cancelados. Itens em execução terminam normalmente.
+func (r *Repository) Cancel(ctx context.Context, batchID, reason string) (int64, error) {
+	res, err := r.db.Exec(ctx, "actionbatch.cancel", `
+		WITH batch AS (
+			UPDATE action_batches SET cancelled_at = COALESCE(cancelled_at, CURRENT_TIMESTAMP)
+			WHERE id = $1 AND finished_at IS NULL
+			RETURNING id
+		)
+		UPDATE action_batch_items SET status = 'cancelled', error = NULLIF($2, ''), finished_at = CURRENT_TIMESTAMP
+		WHERE batch_id IN (SELECT id FROM batch) AND status = 'pending'`, batchID, reason)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return n, r.close(ctx, batchID)
+}
+
+// close marca o lote como encerrado se todos os itens terminaram.
+func (r *Repository) close(ctx context.Context, batchID string) error {
+	_, err := r.db.Exec(ctx, "actionbatch.close", `
+		UPDATE action_batches SET finished_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND finished_at IS NULL AND NOT EXISTS (
+			SELECT 1 FROM action_batch_items WHERE batch_id = $1 AND status IN ('pending', 'running'))`, batchID)
+	return err
+}
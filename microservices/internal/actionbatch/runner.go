@@ -0,0 +1,358 @@
+package actionbatch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/action"
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/instrument"
+	"smart-city-microservices/internal/logging"
+	"smart-city-microservices/internal/supervisor"
+)
+
+// resolvePageSize é o tamanho da página ao resolver o filtro em agentes.
+const resolvePageSize = 500
+
+// shutdownReason fica nos itens que não chegaram a executar porque a
+// instância foi encerrada.
+const shutdownReason = "instance shutting down"
+
+var executions = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "agent_service",
+	Name:      "action_batch_items_total",
+	Help:      "Execuções de ações em lote por agente, por resultado (succeeded, failed).",
+}, []string{"status"})
+
+// ValidationError indica um pedido de lote inválido; a mensagem vai ao
+// cliente.
+type ValidationError struct{ msg string }
+
+func (e *ValidationError) Error() string { return e.msg }
+
+func invalid(format string, args ...interface{}) error {
+	return &ValidationError{msg: fmt.Sprintf(format, args...)}
+}
+
+// AgentService é o subconjunto de agent.Service usado nos lotes;
+// *action.CheckedService o implementa, com a política das ações.
+type AgentService interface {
+	ListAgents(ctx context.Context, f agent.Filter) ([]agent.Agent, int, error)
+	ExecuteAction(ctx context.Context, id string, req agent.ActionRequest) (*agent.ActionResult, error)
+	PermitAction(ctx context.Context, name string) error
+}
+
+// Config configura o runner.
+type Config struct {
+	// Workers limita as ações em execução ao mesmo tempo, somando todos os
+	// lotes desta instância, exceto os critical.
+	Workers int
+	// MaxAgents limita o tamanho de um lote.
+	MaxAgents int
+	// Timeout limita cada execução.
+	Timeout time.Duration
+}
+
+// Runner aceita lotes e os executa com um pool limitado de workers.
+type Runner struct {
+	repo     *Repository
+	agents   AgentService
+	cfg      Config
+	onAction func(context.Context, string, agent.ActionRequest)
+
+	jobs chan job
+	// done fecha quando Run termina de vez; feeders e critical são as
+	// goroutines dos lotes, esperadas nessa hora.
+	done     chan struct{}
+	feeders  sync.WaitGroup
+	critical sync.WaitGroup
+
+	mu      sync.Mutex
+	cancels map[string]context.CancelFunc
+}
+
+type job struct {
+	ctx     context.Context
+	batchID string
+	agentID string
+	req     agent.ActionRequest
+}
+
+// NewRunner cria o runner. onAction, se informado, é chamado após cada
+// execução bem-sucedida, como em POST /agents/:id/actions. Os workers rodam
+// em Run.
+func NewRunner(repo *Repository, agents AgentService, cfg Config, onAction func(context.Context, string, agent.ActionRequest)) *Runner {
+	return &Runner{
+		repo:     repo,
+		agents:   agents,
+		cfg:      cfg,
+		onAction: onAction,
+		jobs:     make(chan job),
+		done:     make(chan struct{}),
+		cancels:  map[string]context.CancelFunc{},
+	}
+}
+
+// Run roda os workers até ctx ser cancelado. Ao terminar de vez,
+// interrompe os lotes desta instância: execuções em andamento terminam e os
+// itens ainda pendentes são cancelados.
+func (r *Runner) Run(ctx context.Context) error {
+	g, _ := supervisor.NewGroup(ctx)
+	for i := 0; i < r.cfg.Workers; i++ {
+		g.Go(r.work)
+	}
+	err := g.Wait()
+	if ctx.Err() == nil {
+		return err
+	}
+	close(r.done)
+	r.feeders.Wait()
+	r.critical.Wait()
+	return err
+}
+
+// Submit resolve os agentes do pedido, grava o lote e inicia a execução em
+// segundo plano. As execuções herdam o principal e a correlação de ctx, mas
+// não o seu cancelamento.
+func (r *Runner) Submit(ctx context.Context, req Request) (*Batch, error) {
+	b, ids, err := r.prepare(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	feedCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	r.mu.Lock()
+	r.cancels[b.ID] = cancel
+	r.mu.Unlock()
+	r.feeders.Add(1)
+	go r.feed(feedCtx, b.ID, ids, agent.ActionRequest{Action: req.Action, Params: b.Params}, b.Priority == action.PriorityCritical)
+	return b, nil
+}
+
+// Plan ensaia Submit na transação de instrument.WithTx, que quem chama
+// desfaz depois: valida o pedido, resolve os agentes e grava o lote, mas
+// não executa nada.
+func (r *Runner) Plan(ctx context.Context, req Request) (*Plan, error) {
+	if instrument.TxFrom(ctx) == nil {
+		return nil, errors.New("actionbatch: ensaio do lote sem transação")
+	}
+	b, ids, err := r.prepare(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return &Plan{Action: b.Action, Priority: b.Priority, Params: b.Params, Filter: b.Filter, Total: b.Total, AgentIDs: ids}, nil
+}
+
+// prepare valida o pedido, confere se o principal de ctx pode executar a
+// ação, resolve os agentes e grava o lote. As restrições dos projetos
+// dependem de cada agente e são conferidas na execução de cada item.
+func (r *Runner) prepare(ctx context.Context, req Request) (*Batch, []string, error) {
+	if err := req.Validate(); err != nil {
+		return nil, nil, invalid("%s", err.Error())
+	}
+	if err := r.agents.PermitAction(ctx, req.Action); err != nil {
+		return nil, nil, err
+	}
+	ids := dedupe(req.AgentIDs)
+	if req.Filter != nil && len(ids) == 0 {
+		var err error
+		if ids, err = r.resolve(ctx, *req.Filter); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil, invalid("no agents match the filter")
+	}
+	if len(ids) > r.cfg.MaxAgents {
+		return nil, nil, invalid("batch has %d agents, the limit is %d", len(ids), r.cfg.MaxAgents)
+	}
+	if req.Params == nil {
+		req.Params = map[string]interface{}{}
+	}
+
+	if req.Priority == "" {
+		req.Priority = action.PriorityNormal
+	}
+
+	b := &Batch{Action: req.Action, Priority: req.Priority, Params: req.Params, Filter: req.Filter, Status: StatusRunning}
+	if len(req.AgentIDs) > 0 {
+		b.Filter = nil
+	}
+	if p := auth.FromContext(ctx); p != nil {
+		b.CreatedBy = p.Subject
+	}
+	if err := r.repo.Create(ctx, b, ids); err != nil {
+		return nil, nil, err
+	}
+	b.Counts.Pending = b.Total
+	return b, ids, nil
+}
+
+// Cancel para de despachar os itens do lote nesta instância. Os itens
+// pendentes são cancelados no banco por quem chama, o que vale também para
+// lotes em execução em outra réplica.
+func (r *Runner) Cancel(batchID string) {
+	r.mu.Lock()
+	cancel := r.cancels[batchID]
+	r.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// resolve lista os agentes que atendem ao filtro, até MaxAgents.
+func (r *Runner) resolve(ctx context.Context, f Filter) ([]string, error) {
+	var ids []string
+	for page := 1; ; page++ {
+		agents, total, err := r.agents.ListAgents(ctx, agent.Filter{
+			Type:         f.Type,
+			Tags:         f.Tags,
+			SimulationID: f.SimulationID,
+			Page:         page,
+			PageSize:     resolvePageSize,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if total > r.cfg.MaxAgents {
+			return nil, invalid("filter matches %d agents, the limit is %d", total, r.cfg.MaxAgents)
+		}
+		for _, a := range agents {
+			ids = append(ids, a.ID)
+		}
+		if len(agents) < resolvePageSize || len(ids) >= total {
+			return dedupe(ids), nil
+		}
+	}
+}
+
+// feed entrega os itens do lote aos workers, na ordem, até o fim, o
+// cancelamento ou o encerramento da instância. Num lote critical, cada item
+// executa na hora, sem esperar worker livre: uma parada de emergência não
+// fica atrás de lotes de rotina.
+func (r *Runner) feed(ctx context.Context, batchID string, ids []string, req agent.ActionRequest, critical bool) {
+	defer r.feeders.Done()
+	defer func() {
+		r.mu.Lock()
+		if cancel := r.cancels[batchID]; cancel != nil {
+			cancel()
+			delete(r.cancels, batchID)
+		}
+		r.mu.Unlock()
+	}()
+	for _, id := range ids {
+		j := job{ctx: ctx, batchID: batchID, agentID: id, req: req}
+		if critical {
+			select {
+			case <-ctx.Done():
+				return
+			case <-r.done:
+				r.cancelPending(ctx, batchID)
+				return
+			default:
+			}
+			r.critical.Add(1)
+			go func() {
+				defer r.critical.Done()
+				r.execute(j)
+			}()
+			continue
+		}
+		select {
+		case r.jobs <- j:
+		case <-ctx.Done():
+			return
+		case <-r.done:
+			r.cancelPending(ctx, batchID)
+			return
+		}
+	}
+}
+
+// cancelPending cancela os itens que o encerramento deixou sem executar.
+func (r *Runner) cancelPending(ctx context.Context, batchID string) {
+	cleanup, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	if _, err := r.repo.Cancel(cleanup, batchID, shutdownReason); err != nil {
+		logging.FromContext(ctx).WithError(err).WithField("batch_id", batchID).
+			Error("Falha ao cancelar itens pendentes do lote no encerramento")
+	}
+}
+
+func (r *Runner) work(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case j := <-r.jobs:
+			r.execute(j)
+		}
+	}
+}
+
+// execute roda a ação em um agente e grava o resultado. O item só executa
+// se ainda estiver pendente no banco, o que respeita cancelamentos feitos
+// em qualquer réplica.
+func (r *Runner) execute(j job) {
+	log := logging.FromContext(j.ctx).WithFields(logrus.Fields{"batch_id": j.batchID, "agent_id": j.agentID})
+	store, cancelStore := context.WithTimeout(context.WithoutCancel(j.ctx), 5*time.Second)
+	defer cancelStore()
+	ok, err := r.repo.Claim(store, j.batchID, j.agentID)
+	if err != nil {
+		log.WithError(err).Error("Falha ao iniciar item do lote")
+		return
+	}
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(j.ctx), r.cfg.Timeout)
+	result, err := r.agents.ExecuteAction(ctx, j.agentID, j.req)
+	cancel()
+	it := Item{AgentID: j.agentID, Status: ItemSucceeded}
+	switch {
+	case err == nil:
+		if result != nil {
+			it.ActionID, it.Result = result.ActionID, result.Result
+		}
+		if r.onAction != nil {
+			r.onAction(j.ctx, j.agentID, j.req)
+		}
+	case errors.Is(err, agent.ErrNotFound):
+		it.Status, it.Error = ItemFailed, "agent not found"
+	case errors.Is(err, agent.ErrValidation), errors.Is(err, action.ErrForbidden):
+		it.Status, it.Error = ItemFailed, err.Error()
+	case errors.Is(err, context.DeadlineExceeded):
+		it.Status, it.Error = ItemFailed, "action timed out"
+	default:
+		log.WithError(err).Error("Falha ao executar ação do lote")
+		it.Status, it.Error = ItemFailed, "internal error"
+	}
+	executions.WithLabelValues(it.Status).Inc()
+
+	store, cancelFinish := context.WithTimeout(context.WithoutCancel(j.ctx), 5*time.Second)
+	defer cancelFinish()
+	if err := r.repo.Finish(store, j.batchID, it); err != nil {
+		log.WithError(err).Error("Falha ao gravar resultado do item do lote")
+	}
+}
+
+func dedupe(ids []string) []string {
+	seen := make(map[string]struct{}, len(ids))
+	out := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if _, dup := seen[id]; dup {
+			continue
+		}
+		seen[id] = struct{}{}
+		out = append(out, id)
+	}
+	return out
+}
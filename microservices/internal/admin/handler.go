@@ -0,0 +1,89 @@
+// Package admin agrupa os endpoints administrativos do serviço.
+package admin
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	"smart-city-microservices/internal/audit"
+	"smart-city-microservices/internal/config"
+	"smart-city-microservices/internal/i18n"
+	"smart-city-microservices/internal/logging"
+)
+
+// Handler expõe as operações administrativas. Todas as rotas devem ser
+// registradas atrás de auth.RequireRole(auth.RoleAdmin).
+type Handler struct {
+	levels *logging.LevelController
+	config *config.Registry
+}
+
+// NewHandler cria o handler administrativo.
+func NewHandler(levels *logging.LevelController, cfg *config.Registry) *Handler {
+	return &Handler{levels: levels, config: cfg}
+}
+
+// SetLogLevelRequest é o corpo de PUT /admin/log-level.
+type SetLogLevelRequest struct {
+	Level    string `json:"level" binding:"required"`
+	Duration string `json:"duration"`
+}
+
+// SetLogLevel altera o nível de log em tempo de execução.
+func (h *Handler) SetLogLevel(c *gin.Context) {
+	var req SetLogLevelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		i18n.BindError(c, err)
+		return
+	}
+
+	level, err := logrus.ParseLevel(req.Level)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid level: " + req.Level})
+		return
+	}
+
+	var duration time.Duration
+	if req.Duration != "" {
+		duration, err = time.ParseDuration(req.Duration)
+		if err != nil || duration < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid duration: " + req.Duration})
+			return
+		}
+	}
+
+	previous := h.levels.State()
+	state := h.levels.Set(level, duration)
+	audit.Record(c.Request.Context(), "log_level.changed", logrus.Fields{
+		"from":     previous.Level,
+		"to":       state.Level,
+		"duration": duration.String(),
+	})
+
+	c.JSON(http.StatusOK, state)
+}
+
+// GetLogLevel retorna o nível de log ativo.
+func (h *Handler) GetLogLevel(c *gin.Context) {
+	c.JSON(http.StatusOK, h.levels.State())
+}
+
+// ReloadConfig relê o arquivo de configuração e aplica as mudanças válidas.
+func (h *Handler) ReloadConfig(c *gin.Context) {
+	changes, err := h.config.Reload(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	audit.Record(c.Request.Context(), "config.reloaded", logrus.Fields{"changes": len(changes)})
+	c.JSON(http.StatusOK, gin.H{"changes": changes})
+}
+
+// GetConfig retorna a configuração efetiva com valores sensíveis mascarados.
+func (h *Handler) GetConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"config": config.Effective(viper.GetViper())})
+}
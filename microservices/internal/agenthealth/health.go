@@ -0,0 +1,108 @@
+// Package agenthealth resume as métricas de um agente numa nota de saúde
+// de 0 a 100, recalculada a cada amostra recebida e guardada no Redis, e
+// classifica o agente em healthy, degraded ou critical. A classificação tem
+// histerese, para não oscilar quando a nota fica perto de um limiar.
+package agenthealth
+
+import (
+	"math"
+	"time"
+)
+
+// Classificações de saúde. StatusUnknown vale para agentes sem nota: tipo
+// sem pontuação ou nenhuma amostra das métricas que a compõem.
+const (
+	StatusHealthy  = "healthy"
+	StatusDegraded = "degraded"
+	StatusCritical = "critical"
+	StatusUnknown  = "unknown"
+)
+
+// EventChanged é publicado em events.TopicAgents quando o agente muda de
+// classificação.
+const EventChanged = "agent.health_changed"
+
+// Component é uma métrica que compõe a nota. O valor é normalizado de
+// forma linear entre Bad (nota 0) e Good (nota 100), e Good pode ser
+// menor que Bad, como num atraso.
+type Component struct {
+	Metric string
+	Weight float64
+	Good   float64
+	Bad    float64
+}
+
+// normalize leva o valor para a faixa de 0 a 100.
+func (c Component) normalize(v float64) float64 {
+	n := (v - c.Bad) / (c.Good - c.Bad)
+	return 100 * math.Max(0, math.Min(1, n))
+}
+
+// Policy é a pontuação de um tipo de agente. A nota abaixo de Degraded
+// (ou de Critical) rebaixa o agente; para voltar, ela precisa chegar ao
+// limiar mais Hysteresis. RateLimitPenalty são os pontos tirados da nota
+// enquanto o agente está acima do limite de reportes de telemetria;
+// AnomalyPenalty, os tirados por métrica anômala.
+type Policy struct {
+	AgentType        string
+	Components       []Component
+	Degraded         float64
+	Critical         float64
+	Hysteresis       float64
+	RateLimitPenalty float64
+	AnomalyPenalty   float64
+}
+
+// component retorna o componente da métrica.
+func (p Policy) component(metric string) (Component, bool) {
+	for _, c := range p.Components {
+		if c.Metric == metric {
+			return c, true
+		}
+	}
+	return Component{}, false
+}
+
+// Score é a média ponderada dos componentes com valor, arredondada a uma
+// casa. Sem nenhum valor, retorna false.
+func (p Policy) Score(values map[string]float64) (float64, bool) {
+	var sum, weights float64
+	for _, c := range p.Components {
+		v, ok := values[c.Metric]
+		if !ok {
+			continue
+		}
+		sum += c.Weight * c.normalize(v)
+		weights += c.Weight
+	}
+	if weights == 0 {
+		return 0, false
+	}
+	return math.Round(10*sum/weights) / 10, true
+}
+
+// Status classifica a nota a partir da classificação anterior.
+func (p Policy) Status(prev string, score float64) string {
+	switch {
+	case score < p.Critical:
+		return StatusCritical
+	case prev == StatusCritical && score < p.Critical+p.Hysteresis:
+		return StatusCritical
+	case score < p.Degraded:
+		return StatusDegraded
+	case (prev == StatusDegraded || prev == StatusCritical) && score < p.Degraded+p.Hysteresis:
+		return StatusDegraded
+	}
+	return StatusHealthy
+}
+
+// Health é a saúde atual de um agente, como em GET /agents. RateLimited
+// indica que a nota está penalizada por excesso de reportes; Anomalies,
+// pelas métricas anômalas.
+type Health struct {
+	Score       float64   `json:"score"`
+	Status      string    `json:"status"`
+	RateLimited bool      `json:"rate_limited,omitempty"`
+	Anomalies   int       `json:"anomalies,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
@@ -0,0 +1,272 @@
+package agenthealth
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/agentmetric"
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/logging"
+)
+
+// A saúde de cada agente fica num hash ao lado do seu estado ao vivo:
+// score, status e updated_at, o último valor de cada componente em
+// value:<métrica> e at:<métrica> (instante da amostra, em nanossegundos),
+// depois de passar do limite de reportes, rate_limited_until (também em
+// nanossegundos) e, com métricas anômalas, anomalies (quantas são).
+const keyPrefix = "agent-service:agents:"
+
+// stateTTL descarta a saúde de agentes que pararam de enviar amostras (ou
+// foram removidos); cada amostra renova o prazo.
+const stateTTL = 7 * 24 * time.Hour
+
+// maxTxRetries limita as novas tentativas quando outro envio do mesmo
+// agente altera o hash durante o cálculo.
+const maxTxRetries = 5
+
+var changes = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "agent_service",
+	Name:      "agent_health_changes_total",
+	Help:      "Mudanças de classificação de saúde de agentes, pela nova classificação.",
+}, []string{"status"})
+
+func healthKey(agentID string) string {
+	return keyPrefix + agentID + ":health"
+}
+
+// Tracker recalcula a saúde dos agentes a cada envio de amostras.
+type Tracker struct {
+	redis     redis.UniversalClient
+	publisher events.Publisher
+	policies  map[string]Policy
+}
+
+// NewTracker cria o rastreador com as pontuações por tipo de agente.
+func NewTracker(client redis.UniversalClient, publisher events.Publisher, policies []Policy) *Tracker {
+	t := &Tracker{redis: client, publisher: publisher, policies: make(map[string]Policy, len(policies))}
+	for _, p := range policies {
+		t.policies[p.AgentType] = p
+	}
+	return t
+}
+
+// Observe aplica as amostras aceitas à saúde do agente e publica
+// EventChanged se a classificação mudou. Falhas só são registradas: as
+// amostras já foram gravadas.
+func (t *Tracker) Observe(ctx context.Context, ag *agent.Agent, samples []agentmetric.Sample) {
+	p, ok := t.policies[ag.Type]
+	if !ok {
+		return
+	}
+	latest := map[string]agentmetric.Sample{}
+	for _, s := range samples {
+		if _, ok := p.component(s.Name); !ok {
+			continue
+		}
+		if cur, ok := latest[s.Name]; !ok || s.At.After(cur.At) {
+			latest[s.Name] = s
+		}
+	}
+	if len(latest) == 0 {
+		return
+	}
+	t.update(ctx, p, ag, latest, time.Time{}, -1)
+}
+
+// RateLimited penaliza a nota do agente em Policy.RateLimitPenalty até
+// until, por ele ter passado do limite de reportes de telemetria. A nota é
+// recalculada na hora com os valores guardados; sem nenhum, só o prazo é
+// guardado, para valer a partir da primeira amostra.
+func (t *Tracker) RateLimited(ctx context.Context, ag *agent.Agent, until time.Time) {
+	p, ok := t.policies[ag.Type]
+	if !ok || p.RateLimitPenalty <= 0 {
+		return
+	}
+	t.update(ctx, p, ag, nil, until, -1)
+}
+
+// Anomalies penaliza a nota do agente em Policy.AnomalyPenalty por métrica
+// anômala; n é quantas o agente tem agora, e 0 tira a penalidade. Como em
+// RateLimited, a nota é recalculada com os valores guardados.
+func (t *Tracker) Anomalies(ctx context.Context, ag *agent.Agent, n int) {
+	p, ok := t.policies[ag.Type]
+	if !ok || p.AnomalyPenalty <= 0 {
+		return
+	}
+	t.update(ctx, p, ag, nil, time.Time{}, max(n, 0))
+}
+
+// update aplica as amostras, o prazo da penalidade e as métricas anômalas
+// (negativo mantém as guardadas) e publica EventChanged se a classificação
+// mudou.
+func (t *Tracker) update(ctx context.Context, p Policy, ag *agent.Agent, latest map[string]agentmetric.Sample, limitedUntil time.Time, anomalies int) {
+	var prev, next Health
+	var err error
+	for i := 0; i < maxTxRetries; i++ {
+		prev, next, err = t.apply(ctx, p, ag.ID, latest, limitedUntil, anomalies)
+		if !errors.Is(err, redis.TxFailedErr) {
+			break
+		}
+	}
+	log := logging.FromContext(ctx).WithField("agent_id", ag.ID)
+	if err != nil {
+		log.WithError(err).Warn("Falha ao atualizar a saúde do agente")
+		return
+	}
+	if next.Status == prev.Status || (prev.Status == StatusUnknown && next.Status == StatusHealthy) {
+		return
+	}
+
+	changes.WithLabelValues(next.Status).Inc()
+	log.WithFields(logrus.Fields{"from": prev.Status, "to": next.Status, "score": next.Score}).Info("Saúde do agente mudou")
+	t.publisher.Publish(ctx, events.New(events.TopicAgents, EventChanged, events.AgentHealthChangedV1{
+		AgentID:        ag.ID,
+		AgentType:      ag.Type,
+		ProjectID:      ag.ProjectID,
+		Status:         next.Status,
+		PreviousStatus: prev.Status,
+		Score:          next.Score,
+	}))
+}
+
+// apply grava os valores mais novos que os guardados, limitedUntil se for
+// além do prazo guardado e anomalies se não for negativo, e recalcula a
+// nota numa transação otimista sobre o hash do agente. Sem nada novo, next
+// repete prev.
+func (t *Tracker) apply(ctx context.Context, p Policy, agentID string, latest map[string]agentmetric.Sample, limitedUntil time.Time, anomalies int) (prev, next Health, err error) {
+	key := healthKey(agentID)
+	err = t.redis.Watch(ctx, func(tx *redis.Tx) error {
+		stored, err := tx.HGetAll(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		prev = decode(stored)
+		next = prev
+
+		values := map[string]float64{}
+		for _, c := range p.Components {
+			if v, err := strconv.ParseFloat(stored["value:"+c.Metric], 64); err == nil {
+				values[c.Metric] = v
+			}
+		}
+		fields := map[string]interface{}{}
+		for name, s := range latest {
+			at, err := strconv.ParseInt(stored["at:"+name], 10, 64)
+			if err == nil && !s.At.After(time.Unix(0, at)) {
+				continue
+			}
+			values[name] = s.Value
+			fields["value:"+name] = strconv.FormatFloat(s.Value, 'g', -1, 64)
+			fields["at:"+name] = strconv.FormatInt(s.At.UnixNano(), 10)
+		}
+		until := limitedUntilOf(stored)
+		if limitedUntil.After(until) {
+			until = limitedUntil
+			fields["rate_limited_until"] = strconv.FormatInt(until.UnixNano(), 10)
+		}
+		anomalous := anomaliesOf(stored)
+		if anomalies >= 0 && anomalies != anomalous {
+			anomalous = anomalies
+			fields["anomalies"] = strconv.Itoa(anomalous)
+		}
+		if len(fields) == 0 {
+			return nil
+		}
+
+		now := time.Now().UTC()
+		if score, ok := p.Score(values); ok {
+			if now.Before(until) {
+				score = math.Max(0, score-p.RateLimitPenalty)
+			}
+			score = math.Max(0, score-p.AnomalyPenalty*float64(anomalous))
+			next = Health{Score: score, Status: p.Status(prev.Status, score), RateLimited: now.Before(until), Anomalies: anomalous, UpdatedAt: now}
+			fields["score"] = strconv.FormatFloat(next.Score, 'f', -1, 64)
+			fields["status"] = next.Status
+			fields["updated_at"] = next.UpdatedAt.Format(time.RFC3339Nano)
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, key, fields)
+			pipe.Expire(ctx, key, stateTTL)
+			return nil
+		})
+		return err
+	}, key)
+	return prev, next, err
+}
+
+// Get retorna a saúde de cada agente que tem uma, num só pipeline.
+func (t *Tracker) Get(ctx context.Context, agentIDs []string) (map[string]Health, error) {
+	if len(agentIDs) == 0 {
+		return map[string]Health{}, nil
+	}
+	cmds := make([]*redis.SliceCmd, len(agentIDs))
+	_, err := t.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range agentIDs {
+			cmds[i] = pipe.HMGet(ctx, healthKey(id), healthFields...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]Health, len(agentIDs))
+	for i, cmd := range cmds {
+		vals := cmd.Val()
+		stored := map[string]string{}
+		for j, f := range healthFields {
+			if s, ok := vals[j].(string); ok {
+				stored[f] = s
+			}
+		}
+		if h := decode(stored); h.Status != StatusUnknown {
+			out[agentIDs[i]] = h
+		}
+	}
+	return out, nil
+}
+
+// healthFields são os campos do hash lidos por Get.
+var healthFields = []string{"score", "status", "updated_at", "rate_limited_until", "anomalies"}
+
+// limitedUntilOf lê o prazo da penalidade por limite de reportes; zero sem
+// penalidade.
+func limitedUntilOf(stored map[string]string) time.Time {
+	ns, err := strconv.ParseInt(stored["rate_limited_until"], 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+// anomaliesOf lê quantas métricas do agente estão anômalas.
+func anomaliesOf(stored map[string]string) int {
+	n, _ := strconv.Atoi(stored["anomalies"])
+	return n
+}
+
+// decode lê a saúde do hash; sem nota, a classificação é StatusUnknown.
+func decode(stored map[string]string) Health {
+	score, err := strconv.ParseFloat(stored["score"], 64)
+	if err != nil {
+		return Health{Status: StatusUnknown}
+	}
+	h := Health{Score: score, Status: stored["status"]}
+	switch h.Status {
+	case StatusHealthy, StatusDegraded, StatusCritical:
+	default:
+		return Health{Status: StatusUnknown}
+	}
+	h.UpdatedAt, _ = time.Parse(time.RFC3339Nano, stored["updated_at"])
+	h.RateLimited = time.Now().Before(limitedUntilOf(stored))
+	h.Anomalies = anomaliesOf(stored)
+	return h
+}
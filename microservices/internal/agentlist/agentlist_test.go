@@ -0,0 +1,141 @@
+package agentlist
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/agenthealth"
+	"smart-city-microservices/internal/dependency"
+	"smart-city-microservices/internal/presence"
+)
+
+func init() { gin.SetMode(gin.TestMode) }
+
+// fakeAgents pagina uma fatia de agentes como o repositório.
+type fakeAgents struct{ agents []agent.Agent }
+
+func (f *fakeAgents) ListAgents(_ context.Context, filter agent.Filter) ([]agent.Agent, int, error) {
+	start := min((filter.Page-1)*filter.PageSize, len(f.agents))
+	end := min(start+filter.PageSize, len(f.agents))
+	return f.agents[start:end], len(f.agents), nil
+}
+
+func (f *fakeAgents) GetSimulation(_ context.Context, id string) (*agent.Simulation, error) {
+	return &agent.Simulation{ID: id, Status: "running"}, nil
+}
+
+// annotations dá saúde a um agente em cada três e capacidades a todos.
+type annotations struct{}
+
+func (annotations) Get(_ context.Context, ids []string) (map[string]agenthealth.Health, error) {
+	out := map[string]agenthealth.Health{}
+	for i, id := range ids {
+		if i%3 == 0 {
+			out[id] = agenthealth.Health{Score: 0.9, Status: agenthealth.StatusHealthy}
+		}
+	}
+	return out, nil
+}
+
+func (annotations) Impairments(context.Context, []string) (map[string]*dependency.Impairment, error) {
+	return nil, nil
+}
+
+type capabilities struct{}
+
+func (capabilities) ForAgents(_ context.Context, agents []agent.Agent) (map[string][]string, error) {
+	out := make(map[string][]string, len(agents))
+	for _, a := range agents {
+		out[a.ID] = []string{"gps", "telemetry"}
+	}
+	return out, nil
+}
+
+type noPresence struct{}
+
+func (noPresence) ForAgents(context.Context, []agent.Agent) (map[string]presence.Info, error) {
+	return nil, nil
+}
+
+func newHandler(n int) *Handler {
+	agents := make([]agent.Agent, n)
+	at := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	for i := range agents {
+		agents[i] = agent.Agent{
+			ID: fmt.Sprintf("agent-%05d", i), SimulationID: "sim-1", ProjectID: "proj-1",
+			Type: "vehicle", Name: fmt.Sprintf("Ônibus %d", i), Status: "active",
+			Position:  agent.Position{Lat: -23.55, Lon: -46.63, Heading: 90, Speed: 12.5},
+			Energy:    87.5,
+			State:     map[string]interface{}{"route": "101"},
+			Metadata:  map[string]interface{}{"plate": "ABC1D23"},
+			Tags:      []string{"bus"},
+			CreatedAt: at, UpdatedAt: at,
+		}
+	}
+	return NewHandler(&fakeAgents{agents: agents}, annotations{}, annotations{}, capabilities{}, noPresence{})
+}
+
+func newRouter(h *Handler) *gin.Engine {
+	r := gin.New()
+	r.GET("/agents", h.ListAgents)
+	r.GET("/simulations/:id/agents", h.SimulationAgents)
+	return r
+}
+
+func TestSimulationAgentsStreamsAll(t *testing.T) {
+	r := newRouter(newHandler(1234))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/simulations/sim-1/agents?health=healthy", nil))
+	var doc struct {
+		SimulationID string        `json:"simulation_id"`
+		Data         []listedAgent `json:"data"`
+		Count        int           `json:"count"`
+		Complete     bool          `json:"complete"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	// Um agente em cada três tem saúde, e 1234/3 arredonda para cima.
+	if doc.SimulationID != "sim-1" || !doc.Complete || doc.Count != 412 || len(doc.Data) != 412 {
+		t.Errorf("simulation_id %q, complete %v, count %d, %d itens", doc.SimulationID, doc.Complete, doc.Count, len(doc.Data))
+	}
+}
+
+// discard é uma resposta que descarta o corpo, para o benchmark medir a
+// serialização e não o corpo acumulado pelo httptest.ResponseRecorder.
+type discard struct{ header http.Header }
+
+func (d *discard) Header() http.Header         { return d.header }
+func (d *discard) WriteHeader(int)             {}
+func (d *discard) Write(p []byte) (int, error) { return io.Discard.Write(p) }
+func (d *discard) Flush()                      {}
+
+func serve(b *testing.B, r http.Handler, path string) {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.ServeHTTP(&discard{header: http.Header{}}, req)
+	}
+}
+
+// BenchmarkListAgents é GET /agents com a maior página da API, anotada e
+// serializada de uma vez.
+func BenchmarkListAgents(b *testing.B) {
+	serve(b, newRouter(newHandler(10000)), fmt.Sprintf("/agents?page_size=%d", maxPageSize))
+}
+
+// BenchmarkSimulationAgents10k é GET /simulations/:id/agents com 10 mil
+// agentes, escritos à medida que as páginas são lidas.
+func BenchmarkSimulationAgents10k(b *testing.B) {
+	serve(b, newRouter(newHandler(10000)), "/simulations/sim-1/agents")
+}
@@ -0,0 +1,412 @@
+// Package agentlist responde a listagem JSON de agentes com o que o
+// repositório de agentes não guarda: a saúde calculada das métricas, o
+// prejuízo por dependências com falha, as capacidades e o último reporte.
+package agentlist
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/agenthealth"
+	"smart-city-microservices/internal/dependency"
+	"smart-city-microservices/internal/jsonstream"
+	"smart-city-microservices/internal/logging"
+	"smart-city-microservices/internal/presence"
+)
+
+// Limites das listagens, os mesmos da API REST.
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// Limites da varredura quando a listagem filtra por saúde ou prejuízo, que
+// o repositório de agentes não conhece.
+const (
+	scanPageSize = 500
+	maxScan      = 50000
+)
+
+// AgentService é o subconjunto de agent.Service usado aqui.
+type AgentService interface {
+	ListAgents(ctx context.Context, f agent.Filter) ([]agent.Agent, int, error)
+	GetSimulation(ctx context.Context, id string) (*agent.Simulation, error)
+}
+
+// HealthSource fornece a saúde dos agentes que têm uma.
+type HealthSource interface {
+	Get(ctx context.Context, agentIDs []string) (map[string]agenthealth.Health, error)
+}
+
+// ImpairmentSource fornece o prejuízo dos agentes prejudicados.
+type ImpairmentSource interface {
+	Impairments(ctx context.Context, agentIDs []string) (map[string]*dependency.Impairment, error)
+}
+
+// CapabilitySource fornece as capacidades efetivas dos agentes.
+type CapabilitySource interface {
+	ForAgents(ctx context.Context, agents []agent.Agent) (map[string][]string, error)
+}
+
+// PresenceSource fornece o último reporte dos agentes que já reportaram.
+type PresenceSource interface {
+	ForAgents(ctx context.Context, agents []agent.Agent) (map[string]presence.Info, error)
+}
+
+// Handler responde a listagem JSON de agentes.
+type Handler struct {
+	agents       AgentService
+	health       HealthSource
+	impairments  ImpairmentSource
+	capabilities CapabilitySource
+	presence     PresenceSource
+}
+
+// NewHandler cria o handler da listagem.
+func NewHandler(agents AgentService, health HealthSource, impairments ImpairmentSource, capabilities CapabilitySource, presence PresenceSource) *Handler {
+	return &Handler{agents: agents, health: health, impairments: impairments, capabilities: capabilities, presence: presence}
+}
+
+// listedAgent é um item de GET /agents: o agente, sua saúde (nula quando não
+// há nota), seu prejuízo (nulo quando nenhuma dependência falhou), suas
+// capacidades e sua presença (nulas quando ele nunca reportou).
+type listedAgent struct {
+	agent.Agent
+	Health       *agenthealth.Health    `json:"health"`
+	Impairment   *dependency.Impairment `json:"impairment"`
+	Capabilities []string               `json:"capabilities"`
+	presence.Info
+}
+
+// ListAgents responde GET /agents em JSON, com os filtros e a paginação de
+// sempre mais ?health= (healthy, degraded, critical ou unknown, separados
+// por vírgula), ?impaired= (true ou false), ?capability= (separadas por
+// vírgula, o agente precisa ter todas) e ?offline_for= (offline por
+// silêncio há pelo menos essa duração). Com esses filtros, as páginas
+// do repositório são percorridas até maxScan agentes e a resposta traz
+// "truncated".
+func (h *Handler) ListAgents(c *gin.Context) {
+	f, err := listFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	match, err := liveFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx := c.Request.Context()
+	if match == nil {
+		agents, total, err := h.agents.ListAgents(ctx, f)
+		if err != nil {
+			h.serviceError(c, err)
+			return
+		}
+		list, err := h.annotate(ctx, agents)
+		if err != nil {
+			h.internalError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": list, "total": total, "page": f.Page, "page_size": f.PageSize})
+		return
+	}
+
+	// Só os agentes da página pedida ficam em memória; os demais que
+	// passam no filtro só são contados.
+	page, pageSize := f.Page, f.PageSize
+	skip := (page - 1) * pageSize
+	found := []listedAgent{}
+	total, truncated := 0, false
+	it := newPages(h.agents, f)
+	for agents, ok := it.next(ctx); ok; agents, ok = it.next(ctx) {
+		list, err := h.annotate(ctx, agents)
+		if err != nil {
+			h.internalError(c, err)
+			return
+		}
+		for _, a := range list {
+			if !match(a) {
+				continue
+			}
+			if total >= skip && len(found) < pageSize {
+				found = append(found, a)
+			}
+			total++
+		}
+		if it.read >= maxScan && !it.done {
+			truncated = true
+			break
+		}
+	}
+	if it.err != nil {
+		h.serviceError(c, it.err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": found, "total": total, "page": page, "page_size": pageSize, "truncated": truncated})
+}
+
+// SimulationAgents responde GET /simulations/:id/agents com todos os
+// agentes da simulação, anotados como em GET /agents e com os mesmos
+// filtros, mas sem paginação nem o limite de maxScan: as páginas do
+// repositório são lidas à medida que a resposta é escrita, de modo que a
+// memória não cresce com a simulação. Uma falha depois do início fecha o
+// documento com "complete": false.
+func (h *Handler) SimulationAgents(c *gin.Context) {
+	f, err := listFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	match, err := liveFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx := c.Request.Context()
+	sim, err := h.agents.GetSimulation(ctx, c.Param("id"))
+	if errors.Is(err, agent.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "simulation not found"})
+		return
+	}
+	if err != nil {
+		h.serviceError(c, err)
+		return
+	}
+	f.SimulationID = sim.ID
+
+	// A primeira página é lida antes do cabeçalho, para que um filtro
+	// inválido ainda receba 400.
+	it := newPages(h.agents, f)
+	first, ok := it.next(ctx)
+	if it.err != nil {
+		h.serviceError(c, it.err)
+		return
+	}
+	sw := jsonstream.Start(c, "application/json; charset=utf-8", "data",
+		jsonstream.Member{Key: "simulation_id", Value: sim.ID},
+		jsonstream.Member{Key: "generated_at", Value: time.Now().UTC()},
+	)
+	for agents := first; ok && sw.Err() == nil; agents, ok = it.next(ctx) {
+		list, err := h.annotate(ctx, agents)
+		if err != nil {
+			it.err = err
+			break
+		}
+		for _, a := range list {
+			if match == nil || match(a) {
+				sw.Add(a)
+			}
+		}
+	}
+	if it.err != nil {
+		logging.FromContext(ctx).WithError(it.err).WithField("simulation_id", sim.ID).Error("Erro ao listar agentes da simulação")
+		err = sw.Abort("internal error")
+	} else {
+		err = sw.Close()
+	}
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).WithField("agents", sw.Count()).Warn("Listagem de agentes da simulação interrompida")
+	}
+}
+
+// pages percorre as páginas do repositório de agentes uma por vez, para
+// que só uma fique em memória.
+type pages struct {
+	agents AgentService
+	f      agent.Filter
+	read   int
+	done   bool
+	err    error
+}
+
+func newPages(agents AgentService, f agent.Filter) *pages {
+	f.Page, f.PageSize = 0, scanPageSize
+	return &pages{agents: agents, f: f}
+}
+
+// next retorna a próxima página; ok é falso no fim ou após um erro, que
+// fica em err.
+func (p *pages) next(ctx context.Context) (agents []agent.Agent, ok bool) {
+	if p.done {
+		return nil, false
+	}
+	p.f.Page++
+	agents, total, err := p.agents.ListAgents(ctx, p.f)
+	if err != nil {
+		p.err, p.done = err, true
+		return nil, false
+	}
+	p.read += len(agents)
+	if len(agents) < p.f.PageSize || p.read >= total {
+		p.done = true
+	}
+	return agents, len(agents) > 0
+}
+
+// annotate junta a saúde, o prejuízo, as capacidades e a presença de cada
+// agente.
+func (h *Handler) annotate(ctx context.Context, agents []agent.Agent) ([]listedAgent, error) {
+	ids := make([]string, len(agents))
+	for i, a := range agents {
+		ids[i] = a.ID
+	}
+	out := make([]listedAgent, len(agents))
+	if len(agents) == 0 {
+		return out, nil
+	}
+	health, err := h.health.Get(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	impairments, err := h.impairments.Impairments(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	capabilities, err := h.capabilities.ForAgents(ctx, agents)
+	if err != nil {
+		return nil, err
+	}
+	seen, err := h.presence.ForAgents(ctx, agents)
+	if err != nil {
+		return nil, err
+	}
+	for i, a := range agents {
+		out[i].Agent = a
+		if hl, ok := health[a.ID]; ok {
+			out[i].Health = &hl
+		}
+		out[i].Impairment = impairments[a.ID]
+		out[i].Capabilities = capabilities[a.ID]
+		if out[i].Capabilities == nil {
+			out[i].Capabilities = []string{}
+		}
+		out[i].Info = seen[a.ID]
+	}
+	return out, nil
+}
+
+// liveFilter lê ?health=, ?impaired=, ?capability= e ?offline_for=; sem
+// nenhum deles, retorna nil.
+func liveFilter(c *gin.Context) (func(listedAgent) bool, error) {
+	var statuses map[string]bool
+	if v := c.Query("health"); v != "" {
+		statuses = map[string]bool{}
+		for _, s := range strings.Split(v, ",") {
+			switch s = strings.TrimSpace(s); s {
+			case agenthealth.StatusHealthy, agenthealth.StatusDegraded, agenthealth.StatusCritical, agenthealth.StatusUnknown:
+				statuses[s] = true
+			default:
+				return nil, errors.New("invalid health " + strconv.Quote(s) + " (use healthy, degraded, critical, unknown)")
+			}
+		}
+	}
+	var impaired *bool
+	if v := c.Query("impaired"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, errors.New("invalid impaired: " + v)
+		}
+		impaired = &b
+	}
+	var required []string
+	if v := c.Query("capability"); v != "" {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				required = append(required, name)
+			}
+		}
+	}
+	var offlineSince *time.Time
+	if v := c.Query("offline_for"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, errors.New("invalid offline_for: " + v)
+		}
+		t := time.Now().Add(-d)
+		offlineSince = &t
+	}
+	if statuses == nil && impaired == nil && required == nil && offlineSince == nil {
+		return nil, nil
+	}
+	return func(a listedAgent) bool {
+		if statuses != nil {
+			s := agenthealth.StatusUnknown
+			if a.Health != nil {
+				s = a.Health.Status
+			}
+			if !statuses[s] {
+				return false
+			}
+		}
+		if impaired != nil && *impaired != (a.Impairment != nil) {
+			return false
+		}
+		for _, name := range required {
+			if !slices.Contains(a.Capabilities, name) {
+				return false
+			}
+		}
+		if offlineSince != nil && (a.OfflineSince == nil || a.OfflineSince.After(*offlineSince)) {
+			return false
+		}
+		return true
+	}, nil
+}
+
+// listFilter lê os filtros e a paginação da listagem de agentes.
+func listFilter(c *gin.Context) (agent.Filter, error) {
+	f := agent.Filter{
+		Type:         c.Query("type"),
+		Status:       c.Query("status"),
+		SimulationID: c.Query("simulation_id"),
+		ProjectID:    c.Query("project_id"),
+		Page:         1,
+		PageSize:     defaultPageSize,
+	}
+	if tags := c.Query("tags"); tags != "" {
+		for _, t := range strings.Split(tags, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				f.Tags = append(f.Tags, t)
+			}
+		}
+	}
+	for _, p := range []struct {
+		name  string
+		dst   *int
+		limit int
+	}{{"page", &f.Page, math.MaxInt32}, {"page_size", &f.PageSize, maxPageSize}} {
+		v := c.Query(p.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return f, errors.New("invalid " + p.name + ": " + v)
+		}
+		*p.dst = min(n, p.limit)
+	}
+	return f, nil
+}
+
+func (h *Handler) serviceError(c *gin.Context, err error) {
+	if errors.Is(err, agent.ErrValidation) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	h.internalError(c, err)
+}
+
+func (h *Handler) internalError(c *gin.Context, err error) {
+	logging.FromContext(c.Request.Context()).WithError(err).Error("Erro na listagem de agentes")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
@@ -0,0 +1,206 @@
+package agentmetric
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/i18n"
+	"smart-city-microservices/internal/logging"
+)
+
+// maxClockSkew é o quanto uma amostra pode estar no futuro, para tolerar
+// relógios de simuladores um pouco adiantados.
+const maxClockSkew = 5 * time.Minute
+
+var ingested = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "agent_service",
+	Name:      "agent_metric_samples_total",
+	Help:      "Amostras de métricas de agentes recebidas, por resultado (accepted, rejected).",
+}, []string{"result"})
+
+// AgentGetter é o subconjunto de agent.Service usado pelo handler.
+type AgentGetter interface {
+	GetAgent(ctx context.Context, id string) (*agent.Agent, error)
+}
+
+// Observer recebe as amostras aceitas de um agente, depois de gravadas.
+type Observer interface {
+	Observe(ctx context.Context, ag *agent.Agent, samples []Sample)
+}
+
+// Observers repassa cada lote a todos os observadores, em ordem.
+type Observers []Observer
+
+// Observe implementa Observer.
+func (o Observers) Observe(ctx context.Context, ag *agent.Agent, samples []Sample) {
+	for _, obs := range o {
+		obs.Observe(ctx, ag, samples)
+	}
+}
+
+// ConsumptionSource dá o consumo de energia do agente para o desempenho;
+// nil se o tipo não tem modelo de consumo.
+type ConsumptionSource interface {
+	AgentConsumption(ctx context.Context, ag *agent.Agent) (interface{}, error)
+}
+
+// Handler recebe amostras e resume as métricas declaradas.
+type Handler struct {
+	registry    *Registry
+	repo        *Repository
+	agents      AgentGetter
+	observer    Observer
+	consumption ConsumptionSource
+	maxSamples  int
+}
+
+// NewHandler cria o handler de métricas. observer, se não for nil, recebe
+// cada lote aceito; consumption, se não for nil, acrescenta o consumo de
+// energia ao desempenho; maxSamples limita as amostras de um envio.
+func NewHandler(registry *Registry, repo *Repository, agents AgentGetter, observer Observer, consumption ConsumptionSource, maxSamples int) *Handler {
+	return &Handler{registry: registry, repo: repo, agents: agents, observer: observer, consumption: consumption, maxSamples: maxSamples}
+}
+
+// IngestRequest é o corpo de POST /agents/:id/metrics. timestamp ausente
+// vale o instante do recebimento.
+type IngestRequest struct {
+	Samples []struct {
+		Name      string     `json:"name" binding:"required"`
+		Value     *float64   `json:"value" binding:"required"`
+		Timestamp *time.Time `json:"timestamp"`
+	} `json:"samples" binding:"required,dive"`
+}
+
+// Ingest grava um lote de amostras do agente. O lote é aceito ou recusado
+// inteiro: métrica não declarada para o tipo do agente (sem métricas
+// avulsas) responde 422 com as declaradas.
+func (h *Handler) Ingest(c *gin.Context) {
+	var req IngestRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		i18n.BindError(c, err)
+		return
+	}
+	switch n := len(req.Samples); {
+	case n == 0:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "samples must not be empty"})
+		return
+	case n > h.maxSamples:
+		ingested.WithLabelValues("rejected").Add(float64(n))
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("at most %d samples per request", h.maxSamples)})
+		return
+	}
+	ctx := c.Request.Context()
+	ag, ok := h.agent(c)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	samples := make([]Sample, len(req.Samples))
+	for i, s := range req.Samples {
+		if err := h.registry.Check(ag.Type, s.Name); err != nil {
+			ingested.WithLabelValues("rejected").Add(float64(len(req.Samples)))
+			resp := gin.H{"error": fmt.Sprintf("samples[%d]: %v", i, err)}
+			var unknown *UnknownMetricError
+			if !errors.As(err, &unknown) {
+				c.JSON(http.StatusBadRequest, resp)
+				return
+			}
+			resp["agent_type"], resp["declared_metrics"] = ag.Type, unknown.Declared
+			if unknown.Declared == nil {
+				resp["declared_metrics"] = []string{}
+			}
+			c.JSON(http.StatusUnprocessableEntity, resp)
+			return
+		}
+		at := now
+		if s.Timestamp != nil {
+			if s.Timestamp.After(now.Add(maxClockSkew)) {
+				ingested.WithLabelValues("rejected").Add(float64(len(req.Samples)))
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("samples[%d]: timestamp is in the future", i)})
+				return
+			}
+			at = *s.Timestamp
+		}
+		samples[i] = Sample{Name: s.Name, Value: *s.Value, At: at}
+	}
+	if err := h.repo.Insert(ctx, ag.ID, samples); err != nil {
+		h.internalError(c, err)
+		return
+	}
+	ingested.WithLabelValues("accepted").Add(float64(len(samples)))
+	if h.observer != nil {
+		h.observer.Observe(ctx, ag, samples)
+	}
+	c.JSON(http.StatusOK, gin.H{"accepted": len(samples)})
+}
+
+// Performance deve vir antes do handler de desempenho do agente em GET
+// /agents/:id/performance. Para tipos com métricas declaradas, responde
+// com o resumo de cada uma e o consumo de energia; os demais seguem para o
+// desempenho fixo.
+func (h *Handler) Performance(c *gin.Context) {
+	ag, ok := h.agent(c)
+	if !ok {
+		c.Abort()
+		return
+	}
+	t, ok := h.registry.ForAgentType(ag.Type)
+	if !ok {
+		c.Next()
+		return
+	}
+	c.Abort()
+	ctx := c.Request.Context()
+	metrics, err := Summarize(ctx, h.repo, t, ag.ID, time.Now())
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	perf := Performance{AgentID: ag.ID, AgentType: ag.Type, Window: Window.String(), Metrics: metrics}
+	if h.consumption != nil {
+		// Sem o consumo, o desempenho ainda responde com as métricas.
+		if perf.Consumption, err = h.consumption.AgentConsumption(ctx, ag); err != nil {
+			logging.FromContext(ctx).WithError(err).WithField("agent_id", ag.ID).Warn("Falha ao ler o consumo de energia do agente")
+		}
+	}
+	c.JSON(http.StatusOK, perf)
+}
+
+// ListByAgentType lista as métricas declaradas pelo tipo de agente.
+func (h *Handler) ListByAgentType(c *gin.Context) {
+	t, _ := h.registry.ForAgentType(c.Param("type"))
+	metrics := t.Metrics
+	if metrics == nil {
+		metrics = []Definition{}
+	}
+	c.JSON(http.StatusOK, gin.H{"data": metrics, "allow_ad_hoc": t.AllowAdHoc})
+}
+
+// agent busca o agente de :id. Responde ao cliente e retorna false se ele
+// não existe ou a busca falhou.
+func (h *Handler) agent(c *gin.Context) (*agent.Agent, bool) {
+	ag, err := h.agents.GetAgent(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, agent.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "agent not found"})
+		return nil, false
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return nil, false
+	}
+	return ag, true
+}
+
+func (h *Handler) internalError(c *gin.Context, err error) {
+	logging.FromContext(c.Request.Context()).WithError(err).Error("Erro no handler de métricas de agentes")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
@@ -0,0 +1,122 @@
+package agentmetric
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"time"
+)
+
+// Tendências de uma métrica entre a janela anterior e a atual.
+const (
+	TrendUp   = "up"
+	TrendDown = "down"
+	TrendFlat = "flat"
+)
+
+// flatThreshold é a variação relativa da média abaixo da qual a métrica é
+// considerada estável.
+const flatThreshold = 0.01
+
+// Summary resume uma métrica do agente na janela da última hora.
+type Summary struct {
+	Definition
+	// Declared é falso para métricas avulsas, que não estão no registro.
+	Declared bool       `json:"declared"`
+	Latest   *float64   `json:"latest"`
+	LatestAt *time.Time `json:"latest_at,omitempty"`
+	Avg1h    *float64   `json:"avg_1h"`
+	// Value1h é a janela agregada com a agregação da métrica.
+	Value1h   *float64 `json:"value_1h"`
+	Samples1h int64    `json:"samples_1h"`
+	// Trend compara a média da janela com a da hora anterior; ausente sem
+	// amostras nas duas. Change é a diferença entre as médias.
+	Trend  string   `json:"trend,omitempty"`
+	Change *float64 `json:"change,omitempty"`
+}
+
+// Performance é a resposta de GET /agents/:id/performance para tipos com
+// métricas declaradas.
+type Performance struct {
+	AgentID   string    `json:"agent_id"`
+	AgentType string    `json:"agent_type"`
+	Window    string    `json:"window"`
+	Metrics   []Summary `json:"metrics"`
+	// Consumption é o consumo de energia do agente, se o tipo tem modelo.
+	Consumption interface{} `json:"consumption,omitempty"`
+}
+
+// Summarize resume as métricas declaradas do tipo e, se ele aceita
+// avulsas, as que tiveram amostras na janela.
+func Summarize(ctx context.Context, repo *Repository, t TypeDefinition, agentID string, now time.Time) ([]Summary, error) {
+	defs := append([]Definition(nil), t.Metrics...)
+	if t.AllowAdHoc {
+		names, err := repo.Names(ctx, agentID, now)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range names {
+			if _, ok := t.lookup(n); !ok {
+				defs = append(defs, Definition{Name: n, Aggregation: AggregationAvg})
+			}
+		}
+	}
+	names := make([]string, len(defs))
+	for i, d := range defs {
+		names[i] = d.Name
+	}
+	st, err := repo.Stats(ctx, agentID, names, now)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]Summary, 0, len(defs))
+	for i, d := range defs {
+		s := st[d.Name]
+		sum := Summary{
+			Definition: d,
+			Declared:   i < len(t.Metrics),
+			Latest:     nullFloat(s.Latest),
+			Avg1h:      nullFloat(s.Avg),
+			Samples1h:  s.Count,
+		}
+		if s.LatestAt.Valid {
+			sum.LatestAt = &s.LatestAt.Time
+		}
+		switch d.Aggregation {
+		case AggregationSum:
+			sum.Value1h = nullFloat(s.Sum)
+		case AggregationMin:
+			sum.Value1h = nullFloat(s.Min)
+		case AggregationMax:
+			sum.Value1h = nullFloat(s.Max)
+		case AggregationLast:
+			sum.Value1h = nullFloat(s.Last)
+		default:
+			sum.Value1h = sum.Avg1h
+		}
+		if s.Avg.Valid && s.PrevAvg.Valid {
+			sum.Trend, sum.Change = trend(s.PrevAvg.Float64, s.Avg.Float64)
+		}
+		out = append(out, sum)
+	}
+	return out, nil
+}
+
+func trend(prev, cur float64) (string, *float64) {
+	change := cur - prev
+	switch {
+	case math.Abs(change) <= flatThreshold*math.Abs(prev):
+		return TrendFlat, &change
+	case change > 0:
+		return TrendUp, &change
+	}
+	return TrendDown, &change
+}
+
+func nullFloat(v sql.NullFloat64) *float64 {
+	if !v.Valid {
+		return nil
+	}
+	return &v.Float64
+}
@@ -0,0 +1,182 @@
+// Package agentmetric mantém as métricas de desempenho declaradas por tipo
+// de agente (um caminhão de coleta acompanha a conclusão da rota; um
+// sensor, o tempo no ar), recebe as amostras enviadas pelos agentes e
+// simuladores e resume cada métrica em GET /agents/:id/performance.
+package agentmetric
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// Agregações aceitas para o valor da janela de uma métrica.
+const (
+	AggregationAvg  = "avg"
+	AggregationSum  = "sum"
+	AggregationMin  = "min"
+	AggregationMax  = "max"
+	AggregationLast = "last"
+)
+
+// ValidAggregation informa se a agregação é conhecida.
+func ValidAggregation(a string) bool {
+	switch a {
+	case AggregationAvg, AggregationSum, AggregationMin, AggregationMax, AggregationLast:
+		return true
+	}
+	return false
+}
+
+// namePattern é o formato dos nomes de métrica, declaradas ou não.
+var namePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,99}$`)
+
+// ValidName informa se o nome serve como métrica.
+func ValidName(name string) bool {
+	return namePattern.MatchString(name)
+}
+
+// Definition descreve uma métrica de um tipo de agente.
+type Definition struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Unit        string `json:"unit,omitempty"`
+	// Aggregation é como as amostras da janela viram o valor da janela.
+	Aggregation string `json:"aggregation"`
+	// Anomaly liga a detecção de anomalias da métrica.
+	Anomaly *Anomaly `json:"anomaly,omitempty"`
+}
+
+// DefaultBaseline é o Baseline de uma Anomaly que não o informa.
+const DefaultBaseline = 100
+
+// Anomaly é a sensibilidade da detecção de anomalias de uma métrica: uma
+// amostra a mais de K desvios-padrão da linha de base é anômala, e Window
+// amostras anômalas seguidas tornam a métrica anômala. A detecção só
+// começa depois de MinSamples amostras. Baseline é o número aproximado de
+// amostras recentes que formam a linha de base; 0 usa DefaultBaseline.
+type Anomaly struct {
+	K          float64 `json:"k"`
+	Window     int     `json:"window"`
+	MinSamples int     `json:"min_samples"`
+	Baseline   int     `json:"baseline,omitempty"`
+}
+
+// Validate verifica a sensibilidade.
+func (a Anomaly) Validate() error {
+	switch {
+	case a.K <= 0:
+		return fmt.Errorf("anomaly k must be positive")
+	case a.Window < 1:
+		return fmt.Errorf("anomaly window must be at least 1 sample")
+	case a.MinSamples < 2:
+		return fmt.Errorf("anomaly min_samples must be at least 2")
+	case a.Baseline < 0:
+		return fmt.Errorf("anomaly baseline cannot be negative")
+	}
+	return nil
+}
+
+// TypeDefinition é o conjunto de métricas de um tipo de agente.
+type TypeDefinition struct {
+	Name    string
+	Metrics []Definition
+	// AllowAdHoc aceita amostras de métricas que não estão em Metrics.
+	AllowAdHoc bool
+}
+
+// lookup retorna a métrica declarada.
+func (t TypeDefinition) lookup(name string) (Definition, bool) {
+	for _, d := range t.Metrics {
+		if d.Name == name {
+			return d, true
+		}
+	}
+	return Definition{}, false
+}
+
+// Registry guarda as métricas declaradas por tipo de agente.
+type Registry struct {
+	types map[string]TypeDefinition
+}
+
+// NewRegistry cria o registro. As métricas de cada tipo ficam em ordem de
+// nome.
+func NewRegistry(types []TypeDefinition) *Registry {
+	r := &Registry{types: make(map[string]TypeDefinition, len(types))}
+	for _, t := range types {
+		t.Metrics = append([]Definition(nil), t.Metrics...)
+		sort.Slice(t.Metrics, func(i, j int) bool { return t.Metrics[i].Name < t.Metrics[j].Name })
+		r.types[t.Name] = t
+	}
+	return r
+}
+
+// ForAgentType retorna as métricas do tipo de agente, ou false se o tipo
+// não declara nenhuma.
+func (r *Registry) ForAgentType(agentType string) (TypeDefinition, bool) {
+	t, ok := r.types[agentType]
+	return t, ok
+}
+
+// Known informa se algum tipo de agente aceita amostras da métrica: se a
+// declara ou aceita métricas avulsas.
+func (r *Registry) Known(name string) bool {
+	if !ValidName(name) {
+		return false
+	}
+	for _, t := range r.types {
+		if _, ok := t.lookup(name); ok || t.AllowAdHoc {
+			return true
+		}
+	}
+	return false
+}
+
+// Names retorna, em ordem, as métricas declaradas por algum tipo.
+func (r *Registry) Names() []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, t := range r.types {
+		for _, d := range t.Metrics {
+			if !seen[d.Name] {
+				seen[d.Name] = true
+				out = append(out, d.Name)
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Check confere se o tipo de agente aceita amostras da métrica.
+func (r *Registry) Check(agentType, name string) error {
+	if !ValidName(name) {
+		return fmt.Errorf("invalid metric name %q (use lowercase letters, digits and _)", name)
+	}
+	t, ok := r.types[agentType]
+	if !ok {
+		return &UnknownMetricError{AgentType: agentType, Metric: name}
+	}
+	if _, ok := t.lookup(name); ok || t.AllowAdHoc {
+		return nil
+	}
+	e := &UnknownMetricError{AgentType: agentType, Metric: name}
+	for _, d := range t.Metrics {
+		e.Declared = append(e.Declared, d.Name)
+	}
+	return e
+}
+
+// UnknownMetricError indica uma métrica não declarada para um tipo de
+// agente que não aceita métricas avulsas.
+type UnknownMetricError struct {
+	AgentType string
+	Metric    string
+	// Declared lista as métricas declaradas pelo tipo.
+	Declared []string
+}
+
+func (e *UnknownMetricError) Error() string {
+	return fmt.Sprintf("metric %q is not declared for agent type %q", e.Metric, e.AgentType)
+}
@@ -0,0 +1,122 @@
+package agentmetric
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+
+	"smart-city-microservices/internal/instrument"
+)
+
+// Window é a janela do resumo de cada métrica; a tendência compara com a
+// janela anterior, de mesmo tamanho.
+const Window = time.Hour
+
+// Sample é uma amostra de uma métrica de um agente.
+type Sample struct {
+	Name  string    `json:"name"`
+	Value float64   `json:"value"`
+	At    time.Time `json:"timestamp"`
+}
+
+// Repository persiste as amostras no PostgreSQL.
+type Repository struct {
+	db *instrument.DB
+}
+
+// NewRepository cria o repositório.
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: instrument.NewDB(db)}
+}
+
+// Insert grava as amostras do agente num só comando.
+func (r *Repository) Insert(ctx context.Context, agentID string, samples []Sample) error {
+	names := make([]string, len(samples))
+	values := make([]float64, len(samples))
+	ats := make([]string, len(samples))
+	for i, s := range samples {
+		names[i], values[i], ats[i] = s.Name, s.Value, s.At.UTC().Format(time.RFC3339Nano)
+	}
+	_, err := r.db.Exec(ctx, "agentmetric.insert", `
+		INSERT INTO agent_metric_samples (agent_id, name, value, recorded_at)
+		SELECT $1::uuid, s.name, s.value, s.recorded_at
+		FROM unnest($2::text[], $3::float8[], $4::timestamptz[]) AS s(name, value, recorded_at)`,
+		agentID, pq.StringArray(names), pq.Float64Array(values), pq.StringArray(ats))
+	return err
+}
+
+// stats é o que o resumo de uma métrica precisa do banco: a última
+// amostra e as estatísticas da janela até now e da anterior.
+type stats struct {
+	Latest             sql.NullFloat64
+	LatestAt           sql.NullTime
+	Count              int64
+	Avg, Sum, Min, Max sql.NullFloat64
+	Last               sql.NullFloat64
+	PrevAvg            sql.NullFloat64
+}
+
+// Stats retorna as estatísticas de cada nome até now.
+func (r *Repository) Stats(ctx context.Context, agentID string, names []string, now time.Time) (map[string]stats, error) {
+	rows, err := r.db.Query(ctx, "agentmetric.stats", `
+		SELECT n.name, l.value, l.recorded_at, w.count, w.avg, w.sum, w.min, w.max, w.last, p.avg
+		FROM unnest($2::text[]) AS n(name)
+		LEFT JOIN LATERAL (
+			SELECT value, recorded_at FROM agent_metric_samples
+			WHERE agent_id = $1 AND name = n.name AND recorded_at <= $3
+			ORDER BY recorded_at DESC LIMIT 1
+		) l ON true
+		LEFT JOIN LATERAL (
+			SELECT count(*) AS count, avg(value) AS avg, sum(value) AS sum, min(value) AS min, max(value) AS max,
+				(array_agg(value ORDER BY recorded_at DESC))[1] AS last
+			FROM agent_metric_samples
+			WHERE agent_id = $1 AND name = n.name AND recorded_at > $3 - $4 * interval '1 second' AND recorded_at <= $3
+		) w ON true
+		LEFT JOIN LATERAL (
+			SELECT avg(value) AS avg FROM agent_metric_samples
+			WHERE agent_id = $1 AND name = n.name
+				AND recorded_at > $3 - 2 * $4 * interval '1 second' AND recorded_at <= $3 - $4 * interval '1 second'
+		) p ON true`,
+		agentID, pq.StringArray(names), now, Window.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[string]stats, len(names))
+	for rows.Next() {
+		var name string
+		var s stats
+		if err := rows.Scan(&name, &s.Latest, &s.LatestAt, &s.Count, &s.Avg, &s.Sum, &s.Min, &s.Max, &s.Last,
+			&s.PrevAvg); err != nil {
+			return nil, err
+		}
+		out[name] = s
+	}
+	return out, rows.Err()
+}
+
+// Names retorna, em ordem, os nomes com amostras do agente na janela
+// até now.
+func (r *Repository) Names(ctx context.Context, agentID string, now time.Time) ([]string, error) {
+	rows, err := r.db.Query(ctx, "agentmetric.names", `
+		SELECT DISTINCT name FROM agent_metric_samples
+		WHERE agent_id = $1 AND recorded_at > $2 - $3 * interval '1 second' AND recorded_at <= $2
+		ORDER BY name`, agentID, now, Window.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		out = append(out, name)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,36 @@
+// Package agentmsg troca mensagens entre os agentes de uma simulação (um
+// veículo pede onda verde aos semáforos) sem chaves compartilhadas no Redis.
+// As simulações em execução avançam em ticks; uma mensagem enviada num tick
+// é entregue no seguinte, na ordem de envio, o que torna a troca
+// determinística e reproduzível pelo log de eventos da simulação.
+package agentmsg
+
+import (
+	"errors"
+	"time"
+)
+
+// EventDelivered é publicado em events.TopicSimulations a cada mensagem
+// entregue, com o tick e os destinatários, para o replay da simulação.
+const EventDelivered = "simulation.message_delivered"
+
+// ErrQueueFull indica que a simulação já tem o máximo de mensagens à espera
+// do próximo tick.
+var ErrQueueFull = errors.New("message queue full")
+
+// Message é uma mensagem entre agentes. To endereça um agente; ToType, todos
+// os agentes do tipo na simulação, menos o remetente.
+type Message struct {
+	ID           string                 `json:"id"`
+	SimulationID string                 `json:"simulation_id"`
+	From         string                 `json:"from"`
+	To           string                 `json:"to,omitempty"`
+	ToType       string                 `json:"to_type,omitempty"`
+	Payload      map[string]interface{} `json:"payload"`
+	SentAt       time.Time              `json:"sent_at"`
+	// SentTick é o tick em que a mensagem foi enviada e DeliveredTick, o
+	// seguinte, em que chegou às caixas. Os dois são definidos na entrega:
+	// na resposta do envio, ainda valem zero.
+	SentTick      int64 `json:"sent_tick"`
+	DeliveredTick int64 `json:"delivered_tick"`
+}
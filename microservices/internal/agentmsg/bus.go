@@ -0,0 +1,311 @@
+package agentmsg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/logging"
+)
+
+// Cada simulação tem o tick atual em <prefixo><id>:tick e as mensagens à
+// espera do próximo em <prefixo><id>:messages; a caixa de cada agente fica
+// ao lado do seu estado ao vivo, em agent-service:agents:<id>:inbox.
+// runningKey mapeia as simulações em execução para o projeto delas.
+const (
+	simulationPrefix = "agent-service:simulations:"
+	runningKey       = "agent-service:simulations:running"
+	agentPrefix      = "agent-service:agents:"
+)
+
+// listPageSize é a página usada ao buscar os destinatários de um envio por
+// tipo.
+const listPageSize = 100
+
+var (
+	delivered = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "agent_messages_delivered_total",
+		Help:      "Mensagens entre agentes entregues, por destinatário.",
+	})
+
+	dropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "agent_messages_dropped_total",
+		Help:      "Mensagens entre agentes descartadas, por motivo (inbox_full, delivery_failed).",
+	}, []string{"reason"})
+)
+
+func tickKey(simulationID string) string    { return simulationPrefix + simulationID + ":tick" }
+func pendingKey(simulationID string) string { return simulationPrefix + simulationID + ":messages" }
+func inboxKey(agentID string) string        { return agentPrefix + agentID + ":inbox" }
+
+// AgentLister é o subconjunto de agent.Service usado para os envios por
+// tipo.
+type AgentLister interface {
+	ListAgents(ctx context.Context, f agent.Filter) ([]agent.Agent, int, error)
+}
+
+// Config limita as caixas e as mensagens à espera.
+type Config struct {
+	// InboxSize limita as mensagens de uma caixa; a mais antiga sai quando
+	// chega uma nova. InboxTTL descarta caixas sem entregas novas.
+	InboxSize int
+	InboxTTL  time.Duration
+	// MaxPending limita as mensagens de uma simulação à espera do tick.
+	MaxPending int
+}
+
+// Bus guarda as mensagens no Redis e as entrega a cada tick.
+type Bus struct {
+	redis     redis.UniversalClient
+	agents    AgentLister
+	publisher events.Publisher
+	cfg       Config
+}
+
+// NewBus cria o barramento de mensagens.
+func NewBus(client redis.UniversalClient, agents AgentLister, publisher events.Publisher, cfg Config) *Bus {
+	return &Bus{redis: client, agents: agents, publisher: publisher, cfg: cfg}
+}
+
+// SendMessage coloca a mensagem na fila da simulação para o próximo tick e
+// a retorna com ID e SentAt. Os destinatários não são conferidos aqui: um
+// envio por tipo alcança os agentes do tipo na hora da entrega. Retorna
+// ErrQueueFull se a simulação já tem MaxPending mensagens à espera.
+func (b *Bus) SendMessage(ctx context.Context, m Message) (Message, error) {
+	m.ID = uuid.New().String()
+	m.SentAt = time.Now().UTC()
+	m.SentTick, m.DeliveredTick = 0, 0
+	key := pendingKey(m.SimulationID)
+	n, err := b.redis.LLen(ctx, key).Result()
+	if err != nil {
+		return m, err
+	}
+	if n >= int64(b.cfg.MaxPending) {
+		return m, ErrQueueFull
+	}
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return m, err
+	}
+	return m, b.redis.RPush(ctx, key, raw).Err()
+}
+
+// Inbox retorna as limit mensagens mais recentes da caixa do agente, da mais
+// antiga para a mais nova, sem retirá-las.
+func (b *Bus) Inbox(ctx context.Context, agentID string, limit int) ([]Message, error) {
+	raws, err := b.redis.LRange(ctx, inboxKey(agentID), int64(-limit), -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Message, 0, len(raws))
+	for _, raw := range raws {
+		var m Message
+		if err := json.Unmarshal([]byte(raw), &m); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+// ClearInbox descarta as mensagens da caixa do agente.
+func (b *Bus) ClearInbox(ctx context.Context, agentID string) error {
+	return b.redis.Del(ctx, inboxKey(agentID)).Err()
+}
+
+// CurrentTick retorna o tick atual da simulação; zero antes do primeiro.
+func (b *Bus) CurrentTick(ctx context.Context, simulationID string) (int64, error) {
+	n, err := b.redis.Get(ctx, tickKey(simulationID)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return n, err
+}
+
+// Pending retorna quantas mensagens da simulação esperam o próximo tick.
+func (b *Bus) Pending(ctx context.Context, simulationID string) (int64, error) {
+	return b.redis.LLen(ctx, pendingKey(simulationID)).Result()
+}
+
+// Tick avança a simulação um tick e entrega, na ordem de envio, as
+// mensagens enviadas no tick anterior. O avanço e a retirada da fila são
+// atômicos, para que cada mensagem pertença a um só tick; uma mensagem que
+// não pôde ser entregue é descartada e contada, sem atrasar as outras.
+func (b *Bus) Tick(ctx context.Context, simulationID, projectID string) (int64, error) {
+	var tick *redis.IntCmd
+	var pending *redis.StringSliceCmd
+	_, err := b.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		tick = pipe.Incr(ctx, tickKey(simulationID))
+		pending = pipe.LRange(ctx, pendingKey(simulationID), 0, -1)
+		pipe.Del(ctx, pendingKey(simulationID))
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	log := logging.FromContext(ctx).WithFields(logrus.Fields{"simulation_id": simulationID, "tick": tick.Val()})
+	byType := map[string][]string{}
+	for _, raw := range pending.Val() {
+		var m Message
+		if err := json.Unmarshal([]byte(raw), &m); err != nil {
+			dropped.WithLabelValues("delivery_failed").Inc()
+			log.WithError(err).Warn("Mensagem de agente ilegível descartada")
+			continue
+		}
+		m.SentTick, m.DeliveredTick = tick.Val()-1, tick.Val()
+		if err := b.deliver(ctx, &m, projectID, byType); err != nil {
+			dropped.WithLabelValues("delivery_failed").Inc()
+			log.WithError(err).WithField("message_id", m.ID).Warn("Falha ao entregar mensagem de agente")
+		}
+	}
+	return tick.Val(), nil
+}
+
+// deliver coloca a mensagem nas caixas dos destinatários e publica
+// EventDelivered. byType guarda, durante um tick, os agentes de cada tipo.
+func (b *Bus) deliver(ctx context.Context, m *Message, projectID string, byType map[string][]string) error {
+	recipients := []string{m.To}
+	if m.ToType != "" {
+		ids, ok := byType[m.ToType]
+		if !ok {
+			var err error
+			if ids, err = b.ofType(ctx, m.SimulationID, m.ToType); err != nil {
+				return err
+			}
+			byType[m.ToType] = ids
+		}
+		recipients = make([]string, 0, len(ids))
+		for _, id := range ids {
+			if id != m.From {
+				recipients = append(recipients, id)
+			}
+		}
+	}
+
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	pushes := make([]*redis.IntCmd, len(recipients))
+	_, err = b.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range recipients {
+			key := inboxKey(id)
+			pushes[i] = pipe.RPush(ctx, key, raw)
+			pipe.LTrim(ctx, key, int64(-b.cfg.InboxSize), -1)
+			pipe.Expire(ctx, key, b.cfg.InboxTTL)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, cmd := range pushes {
+		if over := cmd.Val() - int64(b.cfg.InboxSize); over > 0 {
+			dropped.WithLabelValues("inbox_full").Add(float64(over))
+		}
+	}
+	delivered.Add(float64(len(recipients)))
+
+	b.publisher.Publish(ctx, events.New(events.TopicSimulations, EventDelivered, events.SimulationMessageV1{
+		ID:            m.ID,
+		SimulationID:  m.SimulationID,
+		ProjectID:     projectID,
+		From:          m.From,
+		To:            m.To,
+		ToType:        m.ToType,
+		Recipients:    recipients,
+		Payload:       m.Payload,
+		SentAt:        m.SentAt,
+		SentTick:      m.SentTick,
+		DeliveredTick: m.DeliveredTick,
+	}))
+	return nil
+}
+
+// ofType retorna os agentes do tipo na simulação, ordenados pelo id para que
+// a entrega não dependa da ordem do repositório.
+func (b *Bus) ofType(ctx context.Context, simulationID, agentType string) ([]string, error) {
+	var ids []string
+	f := agent.Filter{SimulationID: simulationID, Type: agentType, PageSize: listPageSize}
+	for f.Page = 1; ; f.Page++ {
+		agents, total, err := b.agents.ListAgents(ctx, f)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range agents {
+			ids = append(ids, a.ID)
+		}
+		if len(agents) < f.PageSize || len(ids) >= total {
+			break
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// Handle acompanha pelo barramento de eventos as simulações que começam e
+// terminam, para que o relógio só avance as que estão em execução.
+func (b *Bus) Handle(ctx context.Context, e events.Event) {
+	var running bool
+	switch e.Type {
+	case "simulation.started":
+		running = true
+	case "simulation.stopped", "simulation.completed", "simulation.failed", "simulation.auto_stopped":
+	default:
+		return
+	}
+	var sim struct {
+		ID        string `json:"id"`
+		ProjectID string `json:"project_id"`
+	}
+	err := e.Decode(&sim)
+	if err != nil || sim.ID == "" {
+		return
+	}
+	if running {
+		err = b.redis.HSet(ctx, runningKey, sim.ID, sim.ProjectID).Err()
+	} else {
+		err = b.redis.HDel(ctx, runningKey, sim.ID).Err()
+	}
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).WithField("simulation_id", sim.ID).Warn("Falha ao registrar simulação para os ticks de mensagens")
+	}
+}
+
+// running retorna as simulações em execução e o projeto de cada uma.
+func (b *Bus) running(ctx context.Context) (map[string]string, error) {
+	return b.redis.HGetAll(ctx, runningKey).Result()
+}
+
+// setRunning substitui as simulações em execução.
+func (b *Bus) setRunning(ctx context.Context, sims map[string]string) error {
+	current, err := b.running(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = b.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for id := range current {
+			if _, ok := sims[id]; !ok {
+				pipe.HDel(ctx, runningKey, id)
+			}
+		}
+		if len(sims) > 0 {
+			pipe.HSet(ctx, runningKey, sims)
+		}
+		return nil
+	})
+	return err
+}
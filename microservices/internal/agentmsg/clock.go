@@ -0,0 +1,380 @@
+package agentmsg
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/lease"
+	"smart-city-microservices/internal/logging"
+	"smart-city-microservices/internal/supervisor"
+)
+
+// clockLeaderKey guarda a réplica que avança os ticks. Só uma avança por
+// vez, para que cada tick entregue as mensagens uma única vez.
+const clockLeaderKey = "agent-service:simulations:clock"
+
+// ErrNotQuiesced indica que o relógio não confirmou a pausa da simulação
+// dentro do prazo.
+var ErrNotQuiesced = errors.New("simulation clock did not pause in time; try again")
+
+// As pausas pedidas por Quiesce ficam em <prefixo><id>:hold, com o token de
+// quem pediu, e a confirmação do relógio em <prefixo><id>:hold-ack.
+func holdKey(simulationID string) string    { return simulationPrefix + simulationID + ":hold" }
+func holdAckKey(simulationID string) string { return simulationPrefix + simulationID + ":hold-ack" }
+
+// releaseHold desfaz a pausa só se ela ainda for do token: uma pausa que
+// expirou e foi refeita por outro pedido fica.
+var releaseHold = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1], KEYS[2])
+end
+return 0`)
+
+// resyncInterval é o intervalo entre as conferências das simulações em
+// execução com o repositório, que cobrem as iniciadas antes desta versão e
+// eventos perdidos.
+const resyncInterval = time.Minute
+
+// SimulationLister é o subconjunto de agent.Service usado pelo relógio.
+type SimulationLister interface {
+	ListSimulations(ctx context.Context, page, pageSize int) ([]agent.Simulation, int, error)
+}
+
+// TickFunc roda a cada tick de uma simulação, depois da entrega das
+// mensagens enviadas no tick anterior.
+type TickFunc func(ctx context.Context, simulationID string, tick int64)
+
+// HookMessages é a etapa do tick que entrega as mensagens, em TickPanic.
+const HookMessages = "messages"
+
+// TickPanic é um pânico durante o tick de uma simulação.
+type TickPanic struct {
+	SimulationID string
+	ProjectID    string
+	// Tick é o tick em andamento; num pânico da entrega das mensagens, o
+	// último registrado, ou -1 se não foi possível lê-lo.
+	Tick int64
+	// Hook é a etapa: HookMessages ou o nome da TickFunc, como
+	// "behavior.(*Runner).Tick".
+	Hook  string
+	Value interface{}
+	Stack []byte
+}
+
+// PanicHandler recebe os pânicos dos ticks, já recuperados.
+type PanicHandler func(ctx context.Context, p TickPanic)
+
+// tickHook é uma TickFunc com o nome que a identifica nos pânicos.
+type tickHook struct {
+	name string
+	f    TickFunc
+}
+
+// TickObserver recebe, depois de cada tick, quanto ele levou: a entrega das
+// mensagens e as TickFunc registradas.
+type TickObserver func(simulationID, projectID string, tick int64, took time.Duration)
+
+// Clock avança um tick das simulações em execução a cada intervalo.
+type Clock struct {
+	bus         *Bus
+	simulations SimulationLister
+	redis       redis.UniversalClient
+	leader      *lease.Lease
+	interval    time.Duration
+	resynced    time.Time
+	onTick      []tickHook
+	observers   []TickObserver
+	onPanic     []PanicHandler
+	paused      func() bool
+}
+
+// NewClock cria o relógio. id identifica a réplica na disputa pelos ticks;
+// os ticks rodam em Run.
+func NewClock(bus *Bus, simulations SimulationLister, client redis.UniversalClient, interval time.Duration, id string) *Clock {
+	return &Clock{
+		bus:         bus,
+		simulations: simulations,
+		redis:       client,
+		leader:      lease.New(client, clockLeaderKey, id),
+		interval:    interval,
+	}
+}
+
+// OnTick registra f para rodar a cada tick. Precisa ser chamado antes de
+// Run.
+func (c *Clock) OnTick(f TickFunc) {
+	c.onTick = append(c.onTick, tickHook{name: funcName(f), f: f})
+}
+
+// OnPanic registra h para receber os pânicos dos ticks. Com ou sem h, o
+// pânico encerra só o tick da simulação em que ocorreu: as demais seguem.
+// Precisa ser chamado antes de Run.
+func (c *Clock) OnPanic(h PanicHandler) {
+	c.onPanic = append(c.onPanic, h)
+}
+
+// funcName é o nome de f sem o caminho do módulo, como
+// "behavior.(*Runner).Tick".
+func funcName(f interface{}) string {
+	fn := runtime.FuncForPC(reflect.ValueOf(f).Pointer())
+	if fn == nil {
+		return "unknown"
+	}
+	name := strings.TrimSuffix(fn.Name(), "-fm")
+	if i := strings.LastIndexByte(name, '/'); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+// ObserveTicks registra o para receber a duração de cada tick. Precisa ser
+// chamado antes de Run.
+func (c *Clock) ObserveTicks(o TickObserver) {
+	c.observers = append(c.observers, o)
+}
+
+// PauseWhen faz o relógio parar de avançar enquanto paused retornar true
+// (modo de manutenção). Precisa ser chamado antes de Run.
+func (c *Clock) PauseWhen(paused func() bool) {
+	c.paused = paused
+}
+
+// Run avança os ticks a cada intervalo até ctx ser cancelado e, ao
+// terminar, libera o relógio para outra réplica.
+func (c *Clock) Run(ctx context.Context) error {
+	work := logging.Background(context.WithoutCancel(ctx), "simulation-clock")
+	defer c.release(work)
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			c.cycle(work)
+		}
+	}
+}
+
+// release remove a chave só se ainda for desta réplica.
+func (c *Clock) release(ctx context.Context) {
+	ctx, cancel := supervisor.Cleanup(ctx)
+	defer cancel()
+	c.leader.Release(ctx)
+}
+
+func (c *Clock) cycle(ctx context.Context) {
+	if c.paused != nil && c.paused() {
+		return
+	}
+	log := logging.FromContext(ctx)
+	leader, err := c.lead(ctx)
+	if err != nil {
+		log.WithError(err).Warn("Falha ao disputar o relógio das simulações")
+		return
+	}
+	if !leader {
+		// Quem voltar a liderar confere as simulações antes do primeiro tick.
+		c.resynced = time.Time{}
+		return
+	}
+	if time.Since(c.resynced) >= resyncInterval {
+		if err := c.resync(ctx); err != nil {
+			log.WithError(err).Warn("Falha ao conferir as simulações em execução")
+		} else {
+			c.resynced = time.Now()
+		}
+	}
+
+	running, err := c.bus.running(ctx)
+	if err != nil {
+		log.WithError(err).Error("Falha ao listar simulações em execução")
+		return
+	}
+	ids := make([]string, 0, len(running))
+	for id := range running {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	held, err := c.held(ctx, ids)
+	if err != nil {
+		log.WithError(err).Error("Falha ao ler as pausas das simulações")
+		return
+	}
+	for _, id := range ids {
+		if held[id] {
+			continue
+		}
+		start := time.Now()
+		tick, ok := c.tick(ctx, id, running[id])
+		if !ok {
+			continue
+		}
+		took := time.Since(start)
+		for _, o := range c.observers {
+			o(id, running[id], tick, took)
+		}
+	}
+}
+
+// tick avança um tick da simulação e roda as TickFunc. Um pânico encerra o
+// tick, vai para os PanicHandler e retorna false.
+func (c *Clock) tick(ctx context.Context, simulationID, projectID string) (tick int64, ok bool) {
+	hook := HookMessages
+	tick = -1
+	defer func() {
+		if r := recover(); r != nil {
+			ok = false
+			p := TickPanic{SimulationID: simulationID, ProjectID: projectID, Tick: tick, Hook: hook, Value: r, Stack: debug.Stack()}
+			if p.Tick < 0 {
+				if current, err := c.bus.CurrentTick(ctx, simulationID); err == nil {
+					p.Tick = current
+				}
+			}
+			c.panicked(ctx, p)
+		}
+	}()
+	tick, err := c.bus.Tick(ctx, simulationID, projectID)
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).WithField("simulation_id", simulationID).Error("Falha ao avançar o tick da simulação")
+		return tick, false
+	}
+	for _, h := range c.onTick {
+		hook = h.name
+		h.f(ctx, simulationID, tick)
+	}
+	return tick, true
+}
+
+func (c *Clock) panicked(ctx context.Context, p TickPanic) {
+	logging.FromContext(ctx).WithFields(logrus.Fields{
+		"simulation_id": p.SimulationID,
+		"tick":          p.Tick,
+		"hook":          p.Hook,
+		"stack":         string(p.Stack),
+	}).Errorf("Pânico no tick da simulação: %v", p.Value)
+	for _, h := range c.onPanic {
+		h(ctx, p)
+	}
+}
+
+// resync regrava as simulações em execução a partir do repositório.
+func (c *Clock) resync(ctx context.Context) error {
+	sims := map[string]string{}
+	scanned := 0
+	for page := 1; ; page++ {
+		list, total, err := c.simulations.ListSimulations(ctx, page, listPageSize)
+		if err != nil {
+			return err
+		}
+		for _, s := range list {
+			if s.Status == "running" {
+				sims[s.ID] = s.ProjectID
+			}
+		}
+		scanned += len(list)
+		if len(list) < listPageSize || scanned >= total {
+			break
+		}
+	}
+	return c.bus.setRunning(ctx, sims)
+}
+
+// held lê as pausas pedidas para as simulações e confirma cada uma. A
+// confirmação é gravada antes de qualquer tick do ciclo; como os ciclos
+// não se sobrepõem, quem a lê sabe que nenhum tick da simulação está em
+// andamento nem vai começar enquanto a pausa durar.
+func (c *Clock) held(ctx context.Context, ids []string) (map[string]bool, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = holdKey(id)
+	}
+	tokens, err := c.redis.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	held := map[string]bool{}
+	_, err = c.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, v := range tokens {
+			if token, ok := v.(string); ok {
+				held[ids[i]] = true
+				pipe.Set(ctx, holdAckKey(ids[i]), token, 3*c.interval)
+			}
+		}
+		return nil
+	})
+	return held, err
+}
+
+// Quiesce pausa os ticks da simulação e espera o relógio confirmar que
+// nenhum está em andamento; resume os libera. A pausa vale em todas as
+// réplicas e expira sozinha depois de timeout, para que uma réplica que
+// cai no meio não deixe a simulação parada. Sem confirmação em timeout, a
+// pausa é desfeita e o erro é ErrNotQuiesced. Uma simulação que não está
+// em execução, ou sem réplica no relógio, não tem ticks a esperar.
+func (c *Clock) Quiesce(ctx context.Context, simulationID string, timeout time.Duration) (resume func(), err error) {
+	token := uuid.NewString()
+	if err := c.redis.Set(ctx, holdKey(simulationID), token, timeout).Err(); err != nil {
+		return nil, err
+	}
+	resume = func() {
+		ctx, cancel := supervisor.Cleanup(context.WithoutCancel(ctx))
+		defer cancel()
+		releaseHold.Run(ctx, c.redis, []string{holdKey(simulationID), holdAckKey(simulationID)}, token)
+	}
+
+	running, err := c.redis.HExists(ctx, runningKey, simulationID).Result()
+	var leaders int64
+	if err == nil && running {
+		leaders, err = c.redis.Exists(ctx, clockLeaderKey).Result()
+	}
+	if err != nil {
+		resume()
+		return nil, err
+	}
+	if leaders == 0 {
+		return resume, nil
+	}
+	deadline := time.Now().Add(timeout)
+	poll := min(c.interval/4, 100*time.Millisecond)
+	for {
+		ack, err := c.redis.Get(ctx, holdAckKey(simulationID)).Result()
+		if err == nil && ack == token {
+			return resume, nil
+		}
+		if err != nil && !errors.Is(err, redis.Nil) {
+			resume()
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			resume()
+			return nil, ErrNotQuiesced
+		}
+		select {
+		case <-ctx.Done():
+			resume()
+			return nil, ctx.Err()
+		case <-time.After(poll):
+		}
+	}
+}
+
+// lead disputa o relógio. A chave expira em três intervalos, para que outra
+// réplica assuma se esta cair.
+func (c *Clock) lead(ctx context.Context) (bool, error) {
+	return c.leader.Acquire(ctx, 3*c.interval)
+}
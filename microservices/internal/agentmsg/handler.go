@@ -0,0 +1,177 @@
+package agentmsg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/audit"
+	"smart-city-microservices/internal/i18n"
+	"smart-city-microservices/internal/logging"
+)
+
+// defaultInboxLimit é quantas mensagens GET /agents/:id/messages retorna sem
+// ?limit=.
+const defaultInboxLimit = 50
+
+// AgentGetter é o subconjunto de agent.Service usado pelo handler.
+type AgentGetter interface {
+	GetAgent(ctx context.Context, id string) (*agent.Agent, error)
+}
+
+// Handler expõe o envio de mensagens e a caixa de cada agente.
+type Handler struct {
+	bus             *Bus
+	agents          AgentGetter
+	inboxSize       int
+	maxPayloadBytes int
+}
+
+// NewHandler cria o handler de mensagens. inboxSize é o limite de ?limit= e
+// maxPayloadBytes, o do payload em JSON.
+func NewHandler(bus *Bus, agents AgentGetter, inboxSize, maxPayloadBytes int) *Handler {
+	return &Handler{bus: bus, agents: agents, inboxSize: inboxSize, maxPayloadBytes: maxPayloadBytes}
+}
+
+// SendRequest é o corpo de POST /agents/:id/messages: uma mensagem do agente
+// :id para o agente to ou para todos os agentes do tipo to_type na mesma
+// simulação.
+type SendRequest struct {
+	To      string                 `json:"to"`
+	ToType  string                 `json:"to_type"`
+	Payload map[string]interface{} `json:"payload" binding:"required"`
+}
+
+// Send envia uma mensagem em nome do agente, para injeção externa pelos
+// simuladores. Responde 202: a entrega acontece no próximo tick da
+// simulação.
+func (h *Handler) Send(c *gin.Context) {
+	var req SendRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		i18n.BindError(c, err)
+		return
+	}
+	if (req.To == "") == (req.ToType == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "exactly one of to and to_type is required"})
+		return
+	}
+	if raw, err := json.Marshal(req.Payload); err != nil || len(raw) > h.maxPayloadBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("payload must be at most %d bytes", h.maxPayloadBytes)})
+		return
+	}
+	from, ok := h.agent(c)
+	if !ok {
+		return
+	}
+	if from.SimulationID == "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "agent is not in a simulation"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if req.To != "" {
+		u, err := uuid.Parse(req.To)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid agent id %q", req.To)})
+			return
+		}
+		req.To = u.String()
+		if req.To == from.ID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "an agent cannot message itself"})
+			return
+		}
+		to, err := h.agents.GetAgent(ctx, req.To)
+		switch {
+		case errors.Is(err, agent.ErrNotFound):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("agent %s not found", req.To)})
+			return
+		case err != nil:
+			h.internalError(c, err)
+			return
+		case to.SimulationID != from.SimulationID:
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("agent %s is not in simulation %s", req.To, from.SimulationID)})
+			return
+		}
+	}
+
+	m, err := h.bus.SendMessage(ctx, Message{
+		SimulationID: from.SimulationID,
+		From:         from.ID,
+		To:           req.To,
+		ToType:       req.ToType,
+		Payload:      req.Payload,
+	})
+	if errors.Is(err, ErrQueueFull) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	audit.Record(ctx, "agent.message.sent", logrus.Fields{
+		"message_id": m.ID, "agent_id": from.ID, "to": m.To, "to_type": m.ToType, "simulation_id": m.SimulationID,
+	})
+	c.JSON(http.StatusAccepted, m)
+}
+
+// Inbox responde GET /agents/:id/messages: as mensagens mais recentes da
+// caixa do agente, sem retirá-las, e o tick atual da simulação. Serve para
+// depuração.
+func (h *Handler) Inbox(c *gin.Context) {
+	limit := defaultInboxLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit: " + v})
+			return
+		}
+		limit = min(n, h.inboxSize)
+	}
+	ag, ok := h.agent(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	list, err := h.bus.Inbox(ctx, ag.ID, limit)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	var tick int64
+	if ag.SimulationID != "" {
+		if tick, err = h.bus.CurrentTick(ctx, ag.SimulationID); err != nil {
+			h.internalError(c, err)
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"data": list, "tick": tick})
+}
+
+// agent busca o agente de :id. Responde ao cliente e retorna false se ele
+// não existe ou a busca falhou.
+func (h *Handler) agent(c *gin.Context) (*agent.Agent, bool) {
+	ag, err := h.agents.GetAgent(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, agent.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "agent not found"})
+		return nil, false
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return nil, false
+	}
+	return ag, true
+}
+
+func (h *Handler) internalError(c *gin.Context, err error) {
+	logging.FromContext(c.Request.Context()).WithError(err).Error("Erro no handler de mensagens de agentes")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
@@ -0,0 +1,95 @@
+package agentreset
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/agentmsg"
+	"smart-city-microservices/internal/audit"
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/i18n"
+	"smart-city-microservices/internal/logging"
+)
+
+// SimulationGetter é o subconjunto de agent.Service usado pelo handler.
+type SimulationGetter interface {
+	GetSimulation(ctx context.Context, id string) (*agent.Simulation, error)
+}
+
+// Handler expõe o reinício de agentes.
+type Handler struct {
+	resetter    *Resetter
+	simulations SimulationGetter
+}
+
+// NewHandler cria o handler de reinícios.
+func NewHandler(resetter *Resetter, simulations SimulationGetter) *Handler {
+	return &Handler{resetter: resetter, simulations: simulations}
+}
+
+// Reset responde POST /simulations/:id/agents/reset. 409 se a simulação não
+// está em execução; 422 com os agent_ids que não são dela; 503 se os ticks
+// não pausaram a tempo.
+func (h *Handler) Reset(c *gin.Context) {
+	var req Request
+	if err := c.ShouldBindJSON(&req); err != nil {
+		i18n.BindError(c, err)
+		return
+	}
+	ctx := c.Request.Context()
+	p := auth.FromGin(c)
+	sim, err := h.simulations.GetSimulation(ctx, c.Param("id"))
+	if errors.Is(err, agent.ErrNotFound) || (err == nil && !p.InProject(sim.ProjectID)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": ErrNotFound.Error()})
+		return
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	var by string
+	if p != nil {
+		by = p.Subject
+	}
+
+	res, err := h.resetter.Reset(ctx, sim, req, by)
+	var missing *MissingError
+	switch {
+	case errors.As(err, &missing):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "missing": missing.AgentIDs})
+		return
+	case errors.Is(err, ErrInvalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, ErrNotRunning):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, agentmsg.ErrNotQuiesced):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	if res != nil && len(res.Reset) > 0 {
+		ids := make([]string, len(res.Reset))
+		for i, rs := range res.Reset {
+			ids[i] = rs.AgentID
+		}
+		audit.Record(ctx, "simulation.agents_reset", logrus.Fields{
+			"simulation_id": sim.ID, "tick": res.Tick, "agent_ids": ids,
+		})
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) internalError(c *gin.Context, err error) {
+	logging.FromContext(c.Request.Context()).WithError(err).Error("Erro no handler de reinício de agentes")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
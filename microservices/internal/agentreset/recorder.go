@@ -0,0 +1,94 @@
+package agentreset
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/logging"
+)
+
+// listPageSize é o tamanho das páginas lidas ao gravar os agentes de uma
+// simulação iniciada.
+const listPageSize = 500
+
+// AgentLister é o subconjunto de agent.Service usado pelo Recorder.
+type AgentLister interface {
+	ListAgents(ctx context.Context, f agent.Filter) ([]agent.Agent, int, error)
+}
+
+// Recorder grava o estado inicial dos agentes a partir dos eventos.
+type Recorder struct {
+	repo   *Repository
+	agents AgentLister
+}
+
+// NewRecorder cria o gravador.
+func NewRecorder(repo *Repository, agents AgentLister) *Recorder {
+	return &Recorder{repo: repo, agents: agents}
+}
+
+// Handle grava o estado de criação em agent.created, sem substituir um já
+// gravado, e, em simulation.started, o estado de todos os agentes da
+// simulação, que passa a ser o inicial. A simulação é lida em segundo
+// plano, para não segurar o Publish.
+func (r *Recorder) Handle(ctx context.Context, e events.Event) {
+	switch e.Type {
+	case "agent.created":
+		var a events.AgentV1
+		if err := e.Decode(&a); err != nil || a.ID == "" {
+			return
+		}
+		in := Initial{AgentID: a.ID, Status: a.Status, Position: a.Position, State: a.State}
+		if err := r.repo.Save(ctx, in, false); err != nil {
+			logging.FromContext(ctx).WithError(err).WithField("agent_id", a.ID).Error("Falha ao gravar o estado inicial do agente")
+		}
+	case "simulation.started":
+		var s events.SimulationV1
+		if err := e.Decode(&s); err != nil || s.ID == "" {
+			return
+		}
+		go r.captureSimulation(logging.Background(context.WithoutCancel(ctx), "agent-reset-recorder"), s.ID)
+	}
+}
+
+// captureSimulation grava o estado atual dos agentes da simulação como o
+// inicial.
+func (r *Recorder) captureSimulation(ctx context.Context, simulationID string) {
+	log := logging.FromContext(ctx).WithField("simulation_id", simulationID)
+	saved := 0
+	for page := 1; ; page++ {
+		list, total, err := r.agents.ListAgents(ctx, agent.Filter{SimulationID: simulationID, Page: page, PageSize: listPageSize})
+		if err != nil {
+			log.WithError(err).Error("Falha ao listar os agentes da simulação iniciada")
+			return
+		}
+		for _, a := range list {
+			if err := r.repo.Save(ctx, initialOf(a), true); err != nil {
+				log.WithError(err).WithField("agent_id", a.ID).Error("Falha ao gravar o estado inicial do agente")
+				continue
+			}
+			saved++
+		}
+		if len(list) < listPageSize || page*listPageSize >= total {
+			break
+		}
+	}
+	log.WithFields(logrus.Fields{"agents": saved}).Debug("Estado inicial dos agentes da simulação gravado")
+}
+
+func initialOf(a agent.Agent) Initial {
+	return Initial{
+		AgentID: a.ID,
+		Status:  a.Status,
+		Position: events.PositionV1{
+			Lat:     a.Position.Lat,
+			Lon:     a.Position.Lon,
+			Heading: a.Position.Heading,
+			Speed:   a.Position.Speed,
+		},
+		State: a.State,
+	}
+}
@@ -0,0 +1,73 @@
+package agentreset
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/lib/pq"
+
+	"smart-city-microservices/internal/instrument"
+)
+
+// Repository grava o estado inicial dos agentes no PostgreSQL.
+type Repository struct {
+	db *instrument.DB
+}
+
+// NewRepository cria o repositório.
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: instrument.NewDB(db)}
+}
+
+// Save grava o estado inicial do agente. Com replace, substitui o gravado;
+// sem, mantém o que já existe.
+func (r *Repository) Save(ctx context.Context, in Initial, replace bool) error {
+	state, err := json.Marshal(in.State)
+	if err != nil {
+		return err
+	}
+	query := `
+		INSERT INTO agent_initial_states (agent_id, status, lat, lon, heading, speed, state)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (agent_id) DO NOTHING`
+	if replace {
+		query = `
+		INSERT INTO agent_initial_states (agent_id, status, lat, lon, heading, speed, state)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (agent_id) DO UPDATE SET status = EXCLUDED.status, lat = EXCLUDED.lat,
+			lon = EXCLUDED.lon, heading = EXCLUDED.heading, speed = EXCLUDED.speed,
+			state = EXCLUDED.state, captured_at = CURRENT_TIMESTAMP`
+	}
+	_, err = r.db.Exec(ctx, "agentreset.save", query,
+		in.AgentID, in.Status, in.Position.Lat, in.Position.Lon, in.Position.Heading, in.Position.Speed, string(state))
+	return err
+}
+
+// ForAgents retorna o estado inicial dos agentes que têm um.
+func (r *Repository) ForAgents(ctx context.Context, agentIDs []string) (map[string]*Initial, error) {
+	rows, err := r.db.Query(ctx, "agentreset.for_agents", `
+		SELECT agent_id::text, status, lat, lon, heading, speed, state, captured_at
+		FROM agent_initial_states WHERE agent_id = ANY($1::uuid[])`,
+		pq.StringArray(agentIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := map[string]*Initial{}
+	for rows.Next() {
+		var in Initial
+		var state []byte
+		err := rows.Scan(&in.AgentID, &in.Status, &in.Position.Lat, &in.Position.Lon,
+			&in.Position.Heading, &in.Position.Speed, &state, &in.CapturedAt)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(state, &in.State); err != nil {
+			return nil, err
+		}
+		out[in.AgentID] = &in
+	}
+	return out, rows.Err()
+}
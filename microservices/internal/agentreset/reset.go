@@ -0,0 +1,137 @@
+// Package agentreset reinicia agentes de uma simulação em execução sem
+// parar o resto dela: POST /simulations/:id/agents/reset escolhe agentes
+// por id ou por filtro e, entre dois ticks, devolve cada um ao estado
+// inicial (status, posição e estado do comportamento, com os contadores do
+// runner), descarta a caixa de mensagens dele e força o comportamento a ser
+// reconstruído. Cada reinício publica agent.reset.
+//
+// O estado inicial é o do agente no início da simulação, gravado em
+// agent_initial_states a partir de simulation.started, ou o da criação,
+// para agentes criados com a simulação já em execução. Um agente sem estado
+// gravado volta ao modelo: status active, estado vazio e a posição atual.
+//
+// Um agente reiniciado fica com a falha dependency.StatusReset até o
+// próximo tick completo da simulação, e os que dependem dele ficam
+// prejudicados nesse intervalo.
+package agentreset
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"smart-city-microservices/internal/events"
+)
+
+// EventReset é publicado para cada agente reiniciado.
+const EventReset = "agent.reset"
+
+// Origem do estado aplicado no reinício.
+const (
+	// SourceInitial é o estado gravado no início da simulação ou na criação.
+	SourceInitial = "initial"
+	// SourceTemplate é o modelo, para agentes sem estado gravado.
+	SourceTemplate = "template"
+)
+
+// templateStatus é o status do modelo, o mesmo de um agente recém-criado.
+const templateStatus = "active"
+
+var (
+	// ErrNotFound indica uma simulação inexistente ou de outro projeto.
+	ErrNotFound = errors.New("simulation not found")
+	// ErrNotRunning indica uma simulação que não está em execução.
+	ErrNotRunning = errors.New("simulation is not running")
+	// ErrInvalid indica um pedido inválido.
+	ErrInvalid = errors.New("invalid reset request")
+)
+
+// MissingError lista os agent_ids que não são agentes da simulação.
+type MissingError struct {
+	AgentIDs []string
+}
+
+func (e *MissingError) Error() string {
+	return fmt.Sprintf("%d agents are not in the simulation", len(e.AgentIDs))
+}
+
+func invalid(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalid, fmt.Sprintf(format, args...))
+}
+
+// Filter seleciona os agentes da simulação como em GET /agents.
+type Filter struct {
+	Type   string   `json:"type,omitempty"`
+	Status string   `json:"status,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+}
+
+func (f *Filter) empty() bool {
+	return f.Type == "" && f.Status == "" && len(f.Tags) == 0
+}
+
+// Request é o corpo de POST /simulations/:id/agents/reset: agent_ids ou
+// filter, nunca os dois.
+type Request struct {
+	AgentIDs []string `json:"agent_ids"`
+	Filter   *Filter  `json:"filter"`
+}
+
+// Validate confere a forma do pedido; os agentes do filtro são resolvidos
+// depois.
+func (r *Request) Validate(maxAgents int) error {
+	hasFilter := r.Filter != nil && !r.Filter.empty()
+	switch {
+	case len(r.AgentIDs) > 0 && hasFilter:
+		return invalid("use either agent_ids or filter, not both")
+	case len(r.AgentIDs) == 0 && !hasFilter:
+		return invalid("agent_ids or filter is required")
+	case len(r.AgentIDs) > maxAgents:
+		return invalid("%d agent_ids, the limit is %d", len(r.AgentIDs), maxAgents)
+	}
+	for _, id := range r.AgentIDs {
+		if _, err := uuid.Parse(id); err != nil {
+			return invalid("invalid agent id: %s", id)
+		}
+	}
+	return nil
+}
+
+// Initial é o estado inicial gravado de um agente.
+type Initial struct {
+	AgentID    string                 `json:"agent_id"`
+	Status     string                 `json:"status"`
+	Position   events.PositionV1      `json:"position"`
+	State      map[string]interface{} `json:"state"`
+	CapturedAt time.Time              `json:"captured_at"`
+}
+
+// Reset é o reinício de um agente: a origem do estado aplicado e os agentes
+// prejudicados por ele até o próximo tick.
+type Reset struct {
+	AgentID    string   `json:"agent_id"`
+	Source     string   `json:"source"`
+	Dependents []string `json:"dependents"`
+}
+
+// Result é a resposta de POST /simulations/:id/agents/reset. Tick é o
+// último tick completo antes do reinício.
+type Result struct {
+	SimulationID string  `json:"simulation_id"`
+	Tick         int64   `json:"tick"`
+	Reset        []Reset `json:"reset"`
+}
+
+func dedupe(ids []string) []string {
+	seen := make(map[string]bool, len(ids))
+	out := ids[:0]
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out
+}
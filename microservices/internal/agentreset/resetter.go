@@ -0,0 +1,291 @@
+package agentreset
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/logging"
+)
+
+var resets = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "agent_service",
+	Name:      "agent_resets_total",
+	Help:      "Agentes reiniciados em simulações em execução, pela origem do estado aplicado.",
+}, []string{"source"})
+
+// pendingKeyPrefix guarda, por simulação, os agentes reiniciados que
+// esperam o próximo tick completo para deixar de prejudicar os dependentes.
+const pendingKeyPrefix = "agent-service:simulations:"
+
+func pendingKey(simulationID string) string { return pendingKeyPrefix + simulationID + ":resets" }
+
+// simulationRunning é o status de uma simulação em execução.
+const simulationRunning = "running"
+
+// AgentService é o subconjunto de agent.Service usado no reinício.
+type AgentService interface {
+	GetAgent(ctx context.Context, id string) (*agent.Agent, error)
+	ListAgents(ctx context.Context, f agent.Filter) ([]agent.Agent, int, error)
+	UpdateAgent(ctx context.Context, id string, req agent.UpdateAgentRequest) (*agent.Agent, error)
+}
+
+// Quiescer pausa os ticks de uma simulação; *agentmsg.Clock o implementa.
+type Quiescer interface {
+	Quiesce(ctx context.Context, simulationID string, timeout time.Duration) (resume func(), err error)
+}
+
+// Ticks lê o tick da simulação e descarta caixas de mensagens;
+// *agentmsg.Bus o implementa.
+type Ticks interface {
+	CurrentTick(ctx context.Context, simulationID string) (int64, error)
+	ClearInbox(ctx context.Context, agentID string) error
+}
+
+// Flusher grava o estado ao vivo pendente dos agentes da simulação;
+// *coalesce.Coalescer o implementa.
+type Flusher interface {
+	FlushSimulation(ctx context.Context, simulationID string) error
+}
+
+// Impairments marca e desmarca a falha de reinício dos agentes;
+// *dependency.Propagator o implementa.
+type Impairments interface {
+	MarkReset(ctx context.Context, ids []string) (map[string][]string, error)
+	ClearReset(ctx context.Context, ids []string) error
+}
+
+// Behaviors força a reconstrução dos comportamentos dos agentes;
+// *behavior.Repository o implementa.
+type Behaviors interface {
+	Touch(ctx context.Context, agentIDs []string) error
+}
+
+// Config configura o Resetter.
+type Config struct {
+	// MaxAgents limita os agentes reiniciados por pedido.
+	MaxAgents int
+	// QuiesceTimeout é a espera máxima pela pausa dos ticks.
+	QuiesceTimeout time.Duration
+}
+
+// Resetter reinicia os agentes e, no tick seguinte, libera os dependentes.
+type Resetter struct {
+	repo        *Repository
+	agents      AgentService
+	clock       Quiescer
+	ticks       Ticks
+	flusher     Flusher
+	impairments Impairments
+	behaviors   Behaviors
+	publisher   events.Publisher
+	redis       redis.UniversalClient
+	cfg         Config
+}
+
+// NewResetter cria o Resetter. flusher pode ser nil, sem a gravação em
+// lotes da telemetria.
+func NewResetter(repo *Repository, agents AgentService, clock Quiescer, ticks Ticks, flusher Flusher,
+	impairments Impairments, behaviors Behaviors, publisher events.Publisher, client redis.UniversalClient, cfg Config) *Resetter {
+	return &Resetter{
+		repo:        repo,
+		agents:      agents,
+		clock:       clock,
+		ticks:       ticks,
+		flusher:     flusher,
+		impairments: impairments,
+		behaviors:   behaviors,
+		publisher:   publisher,
+		redis:       client,
+		cfg:         cfg,
+	}
+}
+
+// Reset reinicia os agentes do pedido na simulação, com os ticks pausados.
+// by é o principal que pediu o reinício.
+func (r *Resetter) Reset(ctx context.Context, sim *agent.Simulation, req Request, by string) (*Result, error) {
+	if err := req.Validate(r.cfg.MaxAgents); err != nil {
+		return nil, err
+	}
+	if sim.Status != simulationRunning {
+		return nil, ErrNotRunning
+	}
+	ids, err := r.resolve(ctx, sim.ID, req)
+	if err != nil {
+		return nil, err
+	}
+	res := &Result{SimulationID: sim.ID, Reset: []Reset{}}
+	if len(ids) == 0 {
+		return res, nil
+	}
+	initial, err := r.repo.ForAgents(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	resume, err := r.clock.Quiesce(ctx, sim.ID, r.cfg.QuiesceTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer resume()
+	if r.flusher != nil {
+		if err := r.flusher.FlushSimulation(ctx, sim.ID); err != nil {
+			return nil, fmt.Errorf("agentreset: falha ao gravar o estado ao vivo: %w", err)
+		}
+	}
+	if res.Tick, err = r.ticks.CurrentTick(ctx, sim.ID); err != nil {
+		return nil, err
+	}
+
+	// Um erro interrompe os reinícios, mas os já feitos seguem para a
+	// marcação dos dependentes e os eventos.
+	var applyErr error
+	done := make([]string, 0, len(ids))
+	for _, id := range ids {
+		source, err := r.apply(ctx, id, initial[id])
+		if errors.Is(err, agent.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			applyErr = err
+			break
+		}
+		res.Reset = append(res.Reset, Reset{AgentID: id, Source: source, Dependents: []string{}})
+		done = append(done, id)
+	}
+	if len(done) == 0 {
+		return res, applyErr
+	}
+
+	log := logging.FromContext(ctx).WithField("simulation_id", sim.ID)
+	if err := r.behaviors.Touch(ctx, done); err != nil {
+		log.WithError(err).Warn("Falha ao renovar os comportamentos dos agentes reiniciados")
+	}
+	if err := r.redis.SAdd(ctx, pendingKey(sim.ID), done).Err(); err != nil {
+		return res, err
+	}
+	dependents, err := r.impairments.MarkReset(ctx, done)
+	if err != nil {
+		return res, err
+	}
+	for i := range res.Reset {
+		rs := &res.Reset[i]
+		if d := dependents[rs.AgentID]; len(d) > 0 {
+			rs.Dependents = d
+		}
+		resets.WithLabelValues(rs.Source).Inc()
+		r.publisher.Publish(ctx, events.New(events.TopicAgents, EventReset, events.AgentResetV1{
+			AgentID:      rs.AgentID,
+			SimulationID: sim.ID,
+			ProjectID:    sim.ProjectID,
+			Tick:         res.Tick,
+			Source:       rs.Source,
+			Dependents:   rs.Dependents,
+			ResetBy:      by,
+		}))
+	}
+	log.WithFields(logrus.Fields{"agents": len(done), "tick": res.Tick}).Info("Agentes da simulação reiniciados")
+	return res, applyErr
+}
+
+// apply devolve o agente ao estado inicial, ou ao modelo sem ele, e
+// descarta a caixa de mensagens. Retorna a origem do estado aplicado.
+func (r *Resetter) apply(ctx context.Context, id string, in *Initial) (string, error) {
+	update := agent.UpdateAgentRequest{State: map[string]interface{}{}}
+	source := SourceTemplate
+	status := templateStatus
+	if in != nil {
+		source, status = SourceInitial, in.Status
+		update.Position = &agent.Position{Lat: in.Position.Lat, Lon: in.Position.Lon, Heading: in.Position.Heading, Speed: in.Position.Speed}
+		if in.State != nil {
+			update.State = in.State
+		}
+	}
+	update.Status = &status
+	if _, err := r.agents.UpdateAgent(ctx, id, update); err != nil {
+		return "", err
+	}
+	if err := r.ticks.ClearInbox(ctx, id); err != nil {
+		logging.FromContext(ctx).WithError(err).WithField("agent_id", id).Warn("Falha ao descartar a caixa de mensagens do agente reiniciado")
+	}
+	return source, nil
+}
+
+// resolve retorna os agentes do pedido. Com agent_ids, os que não são da
+// simulação viram um MissingError; com filtro, passar de MaxAgents é
+// inválido.
+func (r *Resetter) resolve(ctx context.Context, simulationID string, req Request) ([]string, error) {
+	if len(req.AgentIDs) > 0 {
+		ids := dedupe(append([]string(nil), req.AgentIDs...))
+		var missing []string
+		for _, id := range ids {
+			a, err := r.agents.GetAgent(ctx, id)
+			if errors.Is(err, agent.ErrNotFound) || (err == nil && a.SimulationID != simulationID) {
+				missing = append(missing, id)
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		if len(missing) > 0 {
+			return nil, &MissingError{AgentIDs: missing}
+		}
+		return ids, nil
+	}
+
+	var ids []string
+	for page := 1; ; page++ {
+		list, total, err := r.agents.ListAgents(ctx, agent.Filter{
+			SimulationID: simulationID,
+			Type:         req.Filter.Type,
+			Status:       req.Filter.Status,
+			Tags:         req.Filter.Tags,
+			Page:         page,
+			PageSize:     listPageSize,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if total > r.cfg.MaxAgents {
+			return nil, invalid("filter matches %d agents, the limit is %d", total, r.cfg.MaxAgents)
+		}
+		for _, a := range list {
+			ids = append(ids, a.ID)
+		}
+		if len(list) < listPageSize || len(ids) >= total {
+			return dedupe(ids), nil
+		}
+	}
+}
+
+// Tick libera os dependentes dos agentes reiniciados antes deste tick.
+// Registrado por último em Clock.OnTick: só roda depois de um tick em que
+// os demais ganchos terminaram.
+func (r *Resetter) Tick(ctx context.Context, simulationID string, tick int64) {
+	key := pendingKey(simulationID)
+	ids, err := r.redis.SMembers(ctx, key).Result()
+	if err != nil || len(ids) == 0 {
+		return
+	}
+	log := logging.FromContext(ctx).WithFields(logrus.Fields{"simulation_id": simulationID, "tick": tick})
+	if err := r.impairments.ClearReset(ctx, ids); err != nil {
+		log.WithError(err).Error("Falha ao liberar os dependentes dos agentes reiniciados")
+		return
+	}
+	members := make([]interface{}, len(ids))
+	for i, id := range ids {
+		members[i] = id
+	}
+	if err := r.redis.SRem(ctx, key, members...).Err(); err != nil {
+		log.WithError(err).Warn("Falha ao remover os agentes reiniciados pendentes")
+	}
+}
@@ -0,0 +1,115 @@
+// Package agenttype mantém o registro dos tipos de agente (agent_types):
+// nome, nome de exibição, métricas, ações permitidas, referência ao schema
+// de configuração, comportamento padrão, ícone e metadados. A criação de
+// agentes só aceita tipos do registro.
+//
+// Os tipos citados na configuração (agent_types.definitions, os tipos das
+// ações e os do MQTT) entram no registro na partida, se ainda não estão
+// nele. Renomear um tipo guarda o nome antigo como alias: os agentes
+// existentes continuam com o nome antigo, que resolve para o tipo, e as
+// criações com o alias recebem o nome novo. Um tipo com agentes, pelo nome
+// ou por um alias, não pode ser removido.
+package agenttype
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"smart-city-microservices/internal/agentmetric"
+)
+
+var (
+	// ErrNotFound indica que o tipo não existe.
+	ErrNotFound = errors.New("agent type not found")
+	// ErrNameTaken indica que o nome já é de um tipo ou de um alias.
+	ErrNameTaken = errors.New("agent type name already in use")
+)
+
+// InUseError indica que o tipo ainda tem agentes e não pode ser removido.
+type InUseError struct {
+	Name   string
+	Agents int
+}
+
+func (e *InUseError) Error() string {
+	return fmt.Sprintf("agent type %s is used by %d agents", e.Name, e.Agents)
+}
+
+var namePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,99}$`)
+
+// ValidName informa se o nome serve como tipo de agente.
+func ValidName(name string) bool {
+	return namePattern.MatchString(name)
+}
+
+// Type é um tipo de agente do registro.
+type Type struct {
+	Name        string                   `json:"name"`
+	DisplayName string                   `json:"display_name"`
+	Metrics     []agentmetric.Definition `json:"metrics"`
+	// AllowedActions são as ações que o tipo aceita; vazio não restringe
+	// além do registro de ações.
+	AllowedActions []string `json:"allowed_actions"`
+	// ConfigSchema referencia o schema da configuração dos agentes do tipo
+	// (URL ou $ref).
+	ConfigSchema string `json:"config_schema,omitempty"`
+	// DefaultBehavior é o comportamento sugerido para os agentes do tipo.
+	DefaultBehavior string                 `json:"default_behavior,omitempty"`
+	Icon            string                 `json:"icon,omitempty"`
+	Metadata        map[string]interface{} `json:"metadata"`
+	// Aliases são os nomes antigos do tipo, de renomeações.
+	Aliases   []string  `json:"aliases"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// CreateRequest é o corpo de POST /agent-types.
+type CreateRequest struct {
+	Name            string                   `json:"name" binding:"required"`
+	DisplayName     string                   `json:"display_name"`
+	Metrics         []agentmetric.Definition `json:"metrics"`
+	AllowedActions  []string                 `json:"allowed_actions"`
+	ConfigSchema    string                   `json:"config_schema"`
+	DefaultBehavior string                   `json:"default_behavior"`
+	Icon            string                   `json:"icon"`
+	Metadata        map[string]interface{}   `json:"metadata"`
+}
+
+// UpdateRequest é o corpo de PUT /agent-types/:type; campos ausentes não
+// mudam. Name diferente do atual renomeia o tipo.
+type UpdateRequest struct {
+	Name            *string                   `json:"name"`
+	DisplayName     *string                   `json:"display_name"`
+	Metrics         *[]agentmetric.Definition `json:"metrics"`
+	AllowedActions  *[]string                 `json:"allowed_actions"`
+	ConfigSchema    *string                   `json:"config_schema"`
+	DefaultBehavior *string                   `json:"default_behavior"`
+	Icon            *string                   `json:"icon"`
+	Metadata        map[string]interface{}    `json:"metadata"`
+}
+
+// validateMetrics confere nomes, agregações e repetições das métricas.
+func validateMetrics(metrics []agentmetric.Definition) error {
+	seen := make(map[string]bool, len(metrics))
+	for _, m := range metrics {
+		if !agentmetric.ValidName(m.Name) {
+			return fmt.Errorf("invalid metric name %q", m.Name)
+		}
+		if seen[m.Name] {
+			return fmt.Errorf("metric %q is declared twice", m.Name)
+		}
+		seen[m.Name] = true
+		if !agentmetric.ValidAggregation(m.Aggregation) {
+			return fmt.Errorf("metric %q has unknown aggregation %q", m.Name, m.Aggregation)
+		}
+		if m.Anomaly != nil {
+			if err := m.Anomaly.Validate(); err != nil {
+				return fmt.Errorf("metric %q: %w", m.Name, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+package agenttype
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/action"
+	"smart-city-microservices/internal/audit"
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/behavior"
+	"smart-city-microservices/internal/httpcache"
+	"smart-city-microservices/internal/i18n"
+	"smart-city-microservices/internal/logging"
+)
+
+// ListCacheKey é a chave do cache de respostas da lista dos tipos, que
+// toda alteração de tipo limpa.
+const ListCacheKey = "agent-types"
+
+// CacheKey é a chave do cache de respostas de um tipo, pelo nome, não pelo
+// alias.
+func CacheKey(name string) string {
+	return "agent-type:" + name
+}
+
+// ActionLookup é o subconjunto de *action.Registry usado para conferir as
+// ações permitidas.
+type ActionLookup interface {
+	Lookup(name string) (action.Definition, bool)
+}
+
+// BehaviorLister é o subconjunto de *behavior.Registry usado para conferir
+// o comportamento padrão.
+type BehaviorLister interface {
+	List() []behavior.Definition
+}
+
+// Handler expõe o CRUD do registro de tipos de agente.
+type Handler struct {
+	registry  *Registry
+	repo      *Repository
+	actions   ActionLookup
+	behaviors BehaviorLister
+}
+
+// NewHandler cria o handler de tipos de agente.
+func NewHandler(registry *Registry, repo *Repository, actions ActionLookup, behaviors BehaviorLister) *Handler {
+	return &Handler{registry: registry, repo: repo, actions: actions, behaviors: behaviors}
+}
+
+// List responde GET /agent-types.
+func (h *Handler) List(c *gin.Context) {
+	list, err := h.repo.List(c.Request.Context())
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": list})
+}
+
+// Get responde GET /agent-types/:type; um alias resolve para o tipo, e a
+// resposta leva a chave do cache do nome resolvido.
+func (h *Handler) Get(c *gin.Context) {
+	t, err := h.repo.Get(c.Request.Context(), c.Param("type"))
+	if err != nil {
+		h.serviceError(c, err)
+		return
+	}
+	httpcache.Tag(c, CacheKey(t.Name))
+	c.JSON(http.StatusOK, t)
+}
+
+// Create responde POST /agent-types.
+func (h *Handler) Create(c *gin.Context) {
+	var req CreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		i18n.BindError(c, err)
+		return
+	}
+	t := &Type{
+		Name:            req.Name,
+		DisplayName:     req.DisplayName,
+		Metrics:         req.Metrics,
+		AllowedActions:  req.AllowedActions,
+		ConfigSchema:    req.ConfigSchema,
+		DefaultBehavior: req.DefaultBehavior,
+		Icon:            req.Icon,
+		Metadata:        req.Metadata,
+	}
+	if t.DisplayName == "" {
+		t.DisplayName = t.Name
+	}
+	if err := h.validate(t, true); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if p := auth.FromGin(c); p != nil {
+		t.CreatedBy = p.Subject
+	}
+	if err := h.repo.Create(c.Request.Context(), t); err != nil {
+		h.serviceError(c, err)
+		return
+	}
+	h.registry.invalidate()
+	audit.Record(c.Request.Context(), "agent_type.created", logrus.Fields{"agent_type": t.Name})
+	c.Header("Location", "/api/v1/agent-types/"+t.Name)
+	c.JSON(http.StatusCreated, t)
+}
+
+// Update responde PUT /agent-types/:type. Um name diferente renomeia o
+// tipo e guarda o nome antigo como alias.
+func (h *Handler) Update(c *gin.Context) {
+	var req UpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		i18n.BindError(c, err)
+		return
+	}
+	ctx := c.Request.Context()
+	t, err := h.repo.Get(ctx, c.Param("type"))
+	if err != nil {
+		h.serviceError(c, err)
+		return
+	}
+	name := t.Name
+	if req.Name != nil {
+		t.Name = *req.Name
+	}
+	if req.DisplayName != nil {
+		t.DisplayName = *req.DisplayName
+	}
+	if req.Metrics != nil {
+		t.Metrics = *req.Metrics
+	}
+	if req.AllowedActions != nil {
+		t.AllowedActions = *req.AllowedActions
+	}
+	if req.ConfigSchema != nil {
+		t.ConfigSchema = *req.ConfigSchema
+	}
+	if req.DefaultBehavior != nil {
+		t.DefaultBehavior = *req.DefaultBehavior
+	}
+	if req.Icon != nil {
+		t.Icon = *req.Icon
+	}
+	if req.Metadata != nil {
+		t.Metadata = req.Metadata
+	}
+	if err := h.validate(t, t.Name != name); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.repo.Update(ctx, name, t); err != nil {
+		h.serviceError(c, err)
+		return
+	}
+	h.registry.invalidate()
+	httpcache.Tag(c, CacheKey(name))
+	if t.Name != name {
+		audit.Record(ctx, "agent_type.renamed", logrus.Fields{"agent_type": t.Name, "previous_name": name})
+	}
+	audit.Record(ctx, "agent_type.updated", logrus.Fields{"agent_type": t.Name})
+	c.JSON(http.StatusOK, t)
+}
+
+// Delete responde DELETE /agent-types/:type; 409 se algum agente usa o
+// tipo ou um alias dele.
+func (h *Handler) Delete(c *gin.Context) {
+	ctx := c.Request.Context()
+	t, err := h.repo.Get(ctx, c.Param("type"))
+	if err != nil {
+		h.serviceError(c, err)
+		return
+	}
+	if err := h.repo.Delete(ctx, t.Name); err != nil {
+		h.serviceError(c, err)
+		return
+	}
+	h.registry.invalidate()
+	httpcache.Tag(c, CacheKey(t.Name))
+	audit.Record(ctx, "agent_type.deleted", logrus.Fields{"agent_type": t.Name, "aliases": t.Aliases})
+	c.Status(http.StatusNoContent)
+}
+
+// validate confere o tipo antes de gravá-lo. O nome só é conferido quando
+// novo: os tipos vindos da configuração podem não seguir o formato.
+func (h *Handler) validate(t *Type, newName bool) error {
+	if newName && !ValidName(t.Name) {
+		return fmt.Errorf("invalid agent type name %q: use lowercase letters, digits and underscores, starting with a letter", t.Name)
+	}
+	if t.DisplayName == "" || len(t.DisplayName) > 255 {
+		return errors.New("display_name must have between 1 and 255 characters")
+	}
+	if err := validateMetrics(t.Metrics); err != nil {
+		return err
+	}
+	for _, a := range t.AllowedActions {
+		if _, ok := h.actions.Lookup(a); !ok {
+			return fmt.Errorf("unknown action %q in allowed_actions", a)
+		}
+	}
+	if t.DefaultBehavior != "" {
+		known := false
+		for _, b := range h.behaviors.List() {
+			known = known || b.Name == t.DefaultBehavior
+		}
+		if !known {
+			return fmt.Errorf("unknown default_behavior %q", t.DefaultBehavior)
+		}
+	}
+	return nil
+}
+
+func (h *Handler) serviceError(c *gin.Context, err error) {
+	var inUse *InUseError
+	switch {
+	case errors.Is(err, ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrNameTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.As(err, &inUse):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "agents": inUse.Agents})
+	default:
+		h.internalError(c, err)
+	}
+}
+
+func (h *Handler) internalError(c *gin.Context, err error) {
+	logging.FromContext(c.Request.Context()).WithError(err).Error("Erro no handler de tipos de agente")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
@@ -0,0 +1,135 @@
+package agenttype
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/logging"
+)
+
+// cacheTTL limita por quanto tempo o registro lido do banco é
+// reaproveitado.
+const cacheTTL = 30 * time.Second
+
+// Registry resolve nomes e aliases para os tipos do registro, com os tipos
+// relidos do banco a cada cacheTTL ou depois de uma alteração nesta
+// réplica.
+type Registry struct {
+	repo *Repository
+
+	mu       sync.Mutex
+	types    map[string]*Type
+	aliases  map[string]string
+	cachedAt time.Time
+}
+
+// NewRegistry cria o registro.
+func NewRegistry(repo *Repository) *Registry {
+	return &Registry{repo: repo}
+}
+
+// Resolve retorna o tipo com o nome ou o alias name; ok é falso se não há
+// nenhum.
+func (r *Registry) Resolve(ctx context.Context, name string) (t *Type, ok bool, err error) {
+	types, aliases, err := r.load(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	if canonical, isAlias := aliases[name]; isAlias {
+		name = canonical
+	}
+	t, ok = types[name]
+	return t, ok, nil
+}
+
+// Names retorna os nomes dos tipos em ordem, sem os aliases.
+func (r *Registry) Names(ctx context.Context) ([]string, error) {
+	types, _, err := r.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(types))
+	for name := range types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// CreateAgent é o middleware de POST /agents: recusa com 400 um tipo fora
+// do registro e troca um alias pelo nome atual do tipo antes do handler.
+// Um corpo ilegível ou sem tipo fica para o handler recusar.
+func (r *Registry) CreateAgent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.Next()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		var req map[string]json.RawMessage
+		var name string
+		if json.Unmarshal(body, &req) != nil || json.Unmarshal(req["type"], &name) != nil || name == "" {
+			c.Next()
+			return
+		}
+		ctx := c.Request.Context()
+		t, ok, err := r.Resolve(ctx, name)
+		if err != nil {
+			logging.FromContext(ctx).WithError(err).Error("Erro ao consultar o registro de tipos de agente")
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+			return
+		}
+		if !ok {
+			known, _ := r.Names(ctx)
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unknown agent type: " + name, "agent_types": known})
+			return
+		}
+		if t.Name != name {
+			req["type"], _ = json.Marshal(t.Name)
+			if body, err = json.Marshal(req); err == nil {
+				c.Request.Body = io.NopCloser(bytes.NewReader(body))
+				c.Request.ContentLength = int64(len(body))
+				logging.FromContext(ctx).WithFields(logrus.Fields{"alias": name, "agent_type": t.Name}).Debug("Tipo de agente criado pelo alias")
+			}
+		}
+		c.Next()
+	}
+}
+
+func (r *Registry) load(ctx context.Context) (map[string]*Type, map[string]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.types != nil && time.Since(r.cachedAt) < cacheTTL {
+		return r.types, r.aliases, nil
+	}
+	list, err := r.repo.List(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	types := make(map[string]*Type, len(list))
+	aliases := map[string]string{}
+	for _, t := range list {
+		types[t.Name] = t
+		for _, a := range t.Aliases {
+			aliases[a] = t.Name
+		}
+	}
+	r.types, r.aliases, r.cachedAt = types, aliases, time.Now()
+	return types, aliases, nil
+}
+
+func (r *Registry) invalidate() {
+	r.mu.Lock()
+	r.types = nil
+	r.mu.Unlock()
+}
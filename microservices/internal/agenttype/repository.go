@@ -0,0 +1,256 @@
+package agenttype
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/lib/pq"
+
+	"smart-city-microservices/internal/agentmetric"
+	"smart-city-microservices/internal/instrument"
+)
+
+// Repository persiste os tipos de agente e os aliases no PostgreSQL.
+type Repository struct {
+	db *instrument.DB
+}
+
+// NewRepository cria o repositório.
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: instrument.NewDB(db)}
+}
+
+const typeColumns = `t.name, t.display_name, t.metrics, t.allowed_actions, COALESCE(t.config_schema, ''),
+	COALESCE(t.default_behavior, ''), COALESCE(t.icon, ''), t.metadata, COALESCE(t.created_by, ''),
+	t.created_at, t.updated_at,
+	COALESCE((SELECT array_agg(a.alias ORDER BY a.alias) FROM agent_type_aliases a WHERE a.type_name = t.name), '{}')`
+
+func scanType(row interface{ Scan(...interface{}) error }) (*Type, error) {
+	var t Type
+	var metrics, metadata []byte
+	err := row.Scan(&t.Name, &t.DisplayName, &metrics, (*pq.StringArray)(&t.AllowedActions), &t.ConfigSchema,
+		&t.DefaultBehavior, &t.Icon, &metadata, &t.CreatedBy, &t.CreatedAt, &t.UpdatedAt, (*pq.StringArray)(&t.Aliases))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(metrics, &t.Metrics); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(metadata, &t.Metadata); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// List retorna todos os tipos em ordem de nome, com os aliases.
+func (r *Repository) List(ctx context.Context) ([]*Type, error) {
+	rows, err := r.db.Query(ctx, "agenttype.list",
+		`SELECT `+typeColumns+` FROM agent_types t ORDER BY t.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []*Type{}
+	for rows.Next() {
+		t, err := scanType(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// Get busca um tipo pelo nome ou por um alias.
+func (r *Repository) Get(ctx context.Context, name string) (*Type, error) {
+	return scanType(r.db.QueryRow(ctx, "agenttype.get", `
+		SELECT `+typeColumns+` FROM agent_types t
+		WHERE t.name = $1
+			OR t.name = (SELECT type_name FROM agent_type_aliases WHERE alias = $1)`, name))
+}
+
+// Create insere o tipo e preenche as datas. Um nome já usado por um tipo
+// ou alias retorna ErrNameTaken.
+func (r *Repository) Create(ctx context.Context, t *Type) error {
+	metrics, metadata, err := encode(t)
+	if err != nil {
+		return err
+	}
+	err = r.db.QueryRow(ctx, "agenttype.create", `
+		INSERT INTO agent_types (name, display_name, metrics, allowed_actions, config_schema,
+			default_behavior, icon, metadata, created_by)
+		SELECT $1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), NULLIF($7, ''), $8, NULLIF($9, '')
+		WHERE NOT EXISTS (SELECT 1 FROM agent_type_aliases WHERE alias = $1)
+		RETURNING created_at, updated_at`,
+		t.Name, t.DisplayName, metrics, pq.Array(t.AllowedActions), t.ConfigSchema,
+		t.DefaultBehavior, t.Icon, metadata, t.CreatedBy,
+	).Scan(&t.CreatedAt, &t.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNameTaken
+	}
+	return nameTaken(err)
+}
+
+// Update grava o tipo registrado como name. Se t.Name é outro, renomeia o
+// tipo e guarda name como alias dele; um alias igual ao nome novo deixa de
+// existir, e um nome já usado por outro tipo ou alias retorna ErrNameTaken.
+func (r *Repository) Update(ctx context.Context, name string, t *Type) (err error) {
+	metrics, metadata, err := encode(t)
+	if err != nil {
+		return err
+	}
+	span := instrument.StartQuery(ctx, "agenttype.update")
+	defer func() { span.End(1, err) }()
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if t.Name != name {
+		var taken bool
+		err = tx.QueryRowContext(ctx,
+			`SELECT EXISTS (SELECT 1 FROM agent_type_aliases WHERE alias = $1 AND type_name <> $2)`,
+			t.Name, name).Scan(&taken)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return ErrNameTaken
+		}
+		if _, err = tx.ExecContext(ctx,
+			`DELETE FROM agent_type_aliases WHERE alias = $1 AND type_name = $2`, t.Name, name); err != nil {
+			return err
+		}
+	}
+	// Os aliases acompanham o nome novo pelo ON UPDATE CASCADE.
+	err = tx.QueryRowContext(ctx, `
+		UPDATE agent_types SET name = $2, display_name = $3, metrics = $4, allowed_actions = $5,
+			config_schema = NULLIF($6, ''), default_behavior = NULLIF($7, ''), icon = NULLIF($8, ''),
+			metadata = $9, updated_at = CURRENT_TIMESTAMP
+		WHERE name = $1
+		RETURNING created_at, updated_at`,
+		name, t.Name, t.DisplayName, metrics, pq.Array(t.AllowedActions), t.ConfigSchema,
+		t.DefaultBehavior, t.Icon, metadata,
+	).Scan(&t.CreatedAt, &t.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return nameTaken(err)
+	}
+	if t.Name != name {
+		if _, err = tx.ExecContext(ctx,
+			`INSERT INTO agent_type_aliases (alias, type_name) VALUES ($1, $2)`, name, t.Name); err != nil {
+			return err
+		}
+	}
+	err = tx.QueryRowContext(ctx,
+		`SELECT COALESCE(array_agg(alias ORDER BY alias), '{}') FROM agent_type_aliases WHERE type_name = $1`,
+		t.Name).Scan((*pq.StringArray)(&t.Aliases))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Delete remove o tipo e os aliases dele. Se algum agente usa o nome ou um
+// alias, nada é removido e o erro é um *InUseError.
+func (r *Repository) Delete(ctx context.Context, name string) (err error) {
+	span := instrument.StartQuery(ctx, "agenttype.delete")
+	defer func() { span.End(1, err) }()
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// O bloqueio da linha segura renomeações concorrentes até o fim.
+	var names []string
+	err = tx.QueryRowContext(ctx, `
+		SELECT array_prepend(t.name, COALESCE((SELECT array_agg(alias) FROM agent_type_aliases WHERE type_name = t.name), '{}'))
+		FROM agent_types t WHERE t.name = $1 FOR UPDATE`, name).Scan((*pq.StringArray)(&names))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	var inUse int
+	err = tx.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM agents WHERE agent_type = ANY($1)`, pq.Array(names)).Scan(&inUse)
+	if err != nil {
+		return err
+	}
+	if inUse > 0 {
+		return &InUseError{Name: name, Agents: inUse}
+	}
+	if _, err = tx.ExecContext(ctx, `DELETE FROM agent_types WHERE name = $1`, name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Seed insere os tipos que ainda não estão no registro, nem como alias, e
+// retorna quantos entraram.
+func (r *Repository) Seed(ctx context.Context, types []Type) (int, error) {
+	seeded := 0
+	for i := range types {
+		t := &types[i]
+		metrics, metadata, err := encode(t)
+		if err != nil {
+			return seeded, err
+		}
+		res, err := r.db.Exec(ctx, "agenttype.seed", `
+			INSERT INTO agent_types (name, display_name, metrics, allowed_actions, metadata, created_by)
+			SELECT $1, $2, $3, $4, $5, 'config'
+			WHERE NOT EXISTS (SELECT 1 FROM agent_type_aliases WHERE alias = $1)
+			ON CONFLICT (name) DO NOTHING`,
+			t.Name, t.DisplayName, metrics, pq.Array(t.AllowedActions), metadata)
+		if err != nil {
+			return seeded, err
+		}
+		if n, err := res.RowsAffected(); err == nil {
+			seeded += int(n)
+		}
+	}
+	return seeded, nil
+}
+
+// encode serializa as colunas JSONB do tipo, com listas e objetos vazios
+// no lugar de nil.
+func encode(t *Type) (metrics, metadata []byte, err error) {
+	if t.Metrics == nil {
+		t.Metrics = []agentmetric.Definition{}
+	}
+	if t.AllowedActions == nil {
+		t.AllowedActions = []string{}
+	}
+	if t.Metadata == nil {
+		t.Metadata = map[string]interface{}{}
+	}
+	if t.Aliases == nil {
+		t.Aliases = []string{}
+	}
+	if metrics, err = json.Marshal(t.Metrics); err != nil {
+		return nil, nil, err
+	}
+	metadata, err = json.Marshal(t.Metadata)
+	return metrics, metadata, err
+}
+
+func nameTaken(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrNameTaken
+	}
+	return err
+}
@@ -0,0 +1,218 @@
+// Package alert avalia regras de alerta sobre métricas de agentes e
+// simulações e acompanha o estado de cada regra (pending → firing →
+// resolved), publicando as transições no barramento de eventos.
+package alert
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrNotFound indica que a regra não existe.
+var ErrNotFound = errors.New("alert rule not found")
+
+// Severidades aceitas.
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// Estados de uma regra. Resolved não é um estado guardado: a regra volta a
+// inactive e ResolvedAt registra quando.
+const (
+	StateInactive = "inactive"
+	StatePending  = "pending"
+	StateFiring   = "firing"
+)
+
+// Eventos publicados em events.TopicAlerts.
+const (
+	EventFiring   = "alert.firing"
+	EventResolved = "alert.resolved"
+)
+
+// Duration é um time.Duration lido e escrito em JSON como "5m".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return errors.New("duration must be a string like \"5m\"")
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil || v < 0 {
+		return fmt.Errorf("invalid duration: %s", s)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Rule é uma regra de alerta: a expressão comparada com Threshold por
+// Operator, por pelo menos For, dispara o alerta.
+//
+// A histerese evita alertas intermitentes: disparado, o alerta só se
+// resolve quando a expressão deixa de cumprir ResolveThreshold (que fica
+// do lado "saudável" de Threshold) e assim permanece por ResolveFor.
+type Rule struct {
+	ID               string    `json:"id"`
+	Name             string    `json:"name"`
+	Description      string    `json:"description,omitempty"`
+	Expression       string    `json:"expression"`
+	Operator         string    `json:"operator"`
+	Threshold        float64   `json:"threshold"`
+	ResolveThreshold float64   `json:"resolve_threshold"`
+	For              Duration  `json:"for"`
+	ResolveFor       Duration  `json:"resolve_for"`
+	Severity         string    `json:"severity"`
+	SimulationID     string    `json:"simulation_id,omitempty"`
+	ProjectID        string    `json:"project_id,omitempty"`
+	Enabled          bool      `json:"enabled"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// Validate confere expressão, operador, severidade e histerese.
+func (r *Rule) Validate() error {
+	if r.Name == "" {
+		return errors.New("name is required")
+	}
+	e, err := ParseExpr(r.Expression)
+	if err != nil {
+		return err
+	}
+	if r.SimulationID != "" {
+		if _, err := uuid.Parse(r.SimulationID); err != nil {
+			return fmt.Errorf("invalid simulation_id %q", r.SimulationID)
+		}
+	}
+	if e.Source == SourceMetric && r.SimulationID == "" {
+		return errors.New("metric expressions need simulation_id")
+	}
+	if math.IsNaN(r.Threshold) || math.IsInf(r.Threshold, 0) || math.IsNaN(r.ResolveThreshold) || math.IsInf(r.ResolveThreshold, 0) {
+		return errors.New("thresholds must be finite numbers")
+	}
+	switch r.Operator {
+	case ">", ">=":
+		if r.ResolveThreshold > r.Threshold {
+			return fmt.Errorf("resolve_threshold must be <= threshold with operator %s", r.Operator)
+		}
+	case "<", "<=":
+		if r.ResolveThreshold < r.Threshold {
+			return fmt.Errorf("resolve_threshold must be >= threshold with operator %s", r.Operator)
+		}
+	default:
+		return fmt.Errorf("invalid operator %q (use >, >=, <, <=)", r.Operator)
+	}
+	switch r.Severity {
+	case SeverityInfo, SeverityWarning, SeverityCritical:
+	default:
+		return fmt.Errorf("invalid severity %q (use info, warning, critical)", r.Severity)
+	}
+	return nil
+}
+
+// breaches indica se o valor cumpre a condição de disparo.
+func (r *Rule) breaches(v float64) bool {
+	return compare(r.Operator, v, r.Threshold)
+}
+
+// cleared indica se o valor saiu da faixa de histerese.
+func (r *Rule) cleared(v float64) bool {
+	return !compare(r.Operator, v, r.ResolveThreshold)
+}
+
+func compare(op string, v, threshold float64) bool {
+	switch op {
+	case ">":
+		return v > threshold
+	case ">=":
+		return v >= threshold
+	case "<":
+		return v < threshold
+	case "<=":
+		return v <= threshold
+	}
+	return false
+}
+
+// State é o estado de avaliação de uma regra.
+type State struct {
+	RuleID string `json:"rule_id"`
+	State  string `json:"state"`
+	// Value é o último valor avaliado; nil sem dados.
+	Value        *float64   `json:"value"`
+	PendingSince *time.Time `json:"pending_since,omitempty"`
+	FiringSince  *time.Time `json:"firing_since,omitempty"`
+	// ClearSince marca quando um alerta disparado saiu da faixa de
+	// histerese; ele se resolve após ResolveFor.
+	ClearSince *time.Time `json:"clear_since,omitempty"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+	// EvaluatedAt é nil em regras ainda não avaliadas.
+	EvaluatedAt *time.Time `json:"evaluated_at"`
+}
+
+// Alert é uma regra com seu estado atual, como em GET /alerts.
+type Alert struct {
+	Rule  *Rule `json:"rule"`
+	State State `json:"state"`
+}
+
+// Step aplica uma avaliação ao estado e retorna o evento a publicar
+// (EventFiring, EventResolved ou ""). value nil (sem dados) mantém o
+// estado como está.
+func Step(r *Rule, s State, value *float64, now time.Time) (State, string) {
+	s.RuleID = r.ID
+	s.Value = value
+	s.EvaluatedAt = &now
+	if s.State == "" {
+		s.State = StateInactive
+	}
+	if value == nil {
+		return s, ""
+	}
+	v := *value
+
+	switch s.State {
+	case StateInactive, StatePending:
+		if !r.breaches(v) {
+			s.State, s.PendingSince = StateInactive, nil
+			return s, ""
+		}
+		if s.PendingSince == nil {
+			s.PendingSince = &now
+		}
+		if now.Sub(*s.PendingSince) < time.Duration(r.For) {
+			s.State = StatePending
+			return s, ""
+		}
+		s.State, s.FiringSince, s.ClearSince = StateFiring, &now, nil
+		return s, EventFiring
+	case StateFiring:
+		if !r.cleared(v) {
+			s.ClearSince = nil
+			return s, ""
+		}
+		if s.ClearSince == nil {
+			s.ClearSince = &now
+		}
+		if now.Sub(*s.ClearSince) < time.Duration(r.ResolveFor) {
+			return s, ""
+		}
+		s.State, s.ResolvedAt = StateInactive, &now
+		s.PendingSince, s.FiringSince, s.ClearSince = nil, nil, nil
+		return s, EventResolved
+	}
+	// Estado desconhecido (gravado por outra versão): recomeça.
+	s.State, s.PendingSince, s.FiringSince, s.ClearSince = StateInactive, nil, nil, nil
+	return s, ""
+}
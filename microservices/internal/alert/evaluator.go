@@ -0,0 +1,285 @@
+package alert
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/lease"
+	"smart-city-microservices/internal/logging"
+	"smart-city-microservices/internal/supervisor"
+)
+
+// leaderKey guarda a réplica que avalia as regras. Só uma avalia por vez,
+// para que cada transição seja publicada uma única vez.
+const leaderKey = "agent-service:alerts:evaluator"
+
+// Limites da varredura de agentes para avg, min, max e sum.
+const (
+	scanPageSize = 500
+	maxScan      = 50000
+)
+
+var (
+	evaluations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "alert_evaluations_total",
+		Help:      "Avaliações de regras de alerta por resultado (ok, no_data, error).",
+	}, []string{"result"})
+
+	transitions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "alert_transitions_total",
+		Help:      "Alertas disparados e resolvidos, por severidade.",
+	}, []string{"event", "severity"})
+
+	firing = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "agent_service",
+		Name:      "alerts_firing",
+		Help:      "Alertas disparados no último ciclo, por severidade. Só a réplica avaliadora publica.",
+	}, []string{"severity"})
+)
+
+// AgentService é o subconjunto de agent.Service usado nas expressões.
+type AgentService interface {
+	ListAgents(ctx context.Context, f agent.Filter) ([]agent.Agent, int, error)
+}
+
+// OfflineCounter conta os agentes offline por silêncio, para offline_for;
+// *presence.Tracker o implementa.
+type OfflineCounter interface {
+	CountOffline(ctx context.Context, agentType, simulationID, projectID string, longerThan time.Duration) (int, error)
+}
+
+// Evaluator avalia as regras habilitadas a cada Interval.
+type Evaluator struct {
+	repo      *Repository
+	agents    AgentService
+	offline   OfflineCounter
+	leader    *lease.Lease
+	publisher events.Publisher
+	interval  time.Duration
+}
+
+// NewEvaluator cria o avaliador. id identifica a réplica na disputa pela
+// avaliação; o ciclo roda em Run.
+func NewEvaluator(repo *Repository, agents AgentService, offline OfflineCounter, client redis.UniversalClient, publisher events.Publisher, interval time.Duration, id string) *Evaluator {
+	return &Evaluator{
+		repo:      repo,
+		agents:    agents,
+		offline:   offline,
+		leader:    lease.New(client, leaderKey, id),
+		publisher: publisher,
+		interval:  interval,
+	}
+}
+
+// Run avalia as regras a cada intervalo até ctx ser cancelado e, ao
+// terminar, libera a avaliação para outra réplica.
+func (e *Evaluator) Run(ctx context.Context) error {
+	work := logging.Background(context.WithoutCancel(ctx), "alert-evaluator")
+	defer e.release(work)
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			e.cycle(work)
+		}
+	}
+}
+
+// release remove a chave só se ainda for desta réplica.
+func (e *Evaluator) release(ctx context.Context) {
+	ctx, cancel := supervisor.Cleanup(ctx)
+	defer cancel()
+	e.leader.Release(ctx)
+}
+
+func (e *Evaluator) cycle(ctx context.Context) {
+	log := logging.FromContext(ctx)
+	leader, err := e.lead(ctx)
+	if err != nil {
+		log.WithError(err).Warn("Falha ao disputar a avaliação de alertas")
+		return
+	}
+	if !leader {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.interval)
+	defer cancel()
+	rules, err := e.repo.ListEnabled(ctx)
+	if err != nil {
+		log.WithError(err).Error("Falha ao carregar regras de alerta")
+		return
+	}
+	states, err := e.repo.States(ctx)
+	if err != nil {
+		log.WithError(err).Error("Falha ao carregar estados de alerta")
+		return
+	}
+
+	counts := map[string]float64{SeverityInfo: 0, SeverityWarning: 0, SeverityCritical: 0}
+	now := time.Now().UTC()
+	for _, r := range rules {
+		s := e.evaluate(ctx, r, states[r.ID], now)
+		if s.State == StateFiring {
+			counts[r.Severity]++
+		}
+	}
+	for severity, n := range counts {
+		firing.WithLabelValues(severity).Set(n)
+	}
+}
+
+// lead obtém ou renova a vez desta réplica. A chave expira em três ciclos,
+// para que outra réplica assuma se esta parar sem liberá-la.
+func (e *Evaluator) lead(ctx context.Context) (bool, error) {
+	return e.leader.Acquire(ctx, 3*e.interval)
+}
+
+func (e *Evaluator) evaluate(ctx context.Context, r *Rule, prev State, now time.Time) State {
+	log := logging.FromContext(ctx).WithFields(logrus.Fields{"rule_id": r.ID, "rule_name": r.Name})
+	value, err := e.value(ctx, r)
+	switch {
+	case err != nil:
+		evaluations.WithLabelValues("error").Inc()
+		log.WithError(err).Warn("Falha ao avaliar regra de alerta")
+		return prev
+	case value == nil:
+		evaluations.WithLabelValues("no_data").Inc()
+	default:
+		evaluations.WithLabelValues("ok").Inc()
+	}
+
+	next, event := Step(r, prev, value, now)
+	if err := e.repo.SaveState(ctx, next); err != nil {
+		log.WithError(err).Warn("Falha ao gravar estado de alerta")
+		return prev
+	}
+	if event == "" {
+		return next
+	}
+
+	transitions.WithLabelValues(event, r.Severity).Inc()
+	data := events.AlertV1{
+		RuleID:       r.ID,
+		RuleName:     r.Name,
+		Severity:     r.Severity,
+		Expression:   r.Expression,
+		Operator:     r.Operator,
+		Threshold:    r.Threshold,
+		Value:        *value,
+		ProjectID:    r.ProjectID,
+		SimulationID: r.SimulationID,
+	}
+	if event == EventResolved {
+		data.FiringSince = prev.FiringSince
+	}
+	log.WithFields(logrus.Fields{"event": event, "value": *value}).Info("Transição de alerta")
+	e.publisher.Publish(ctx, events.New(events.TopicAlerts, event, data))
+	return next
+}
+
+// value calcula a expressão da regra; nil indica ausência de dados.
+func (e *Evaluator) value(ctx context.Context, r *Rule) (*float64, error) {
+	expr, err := ParseExpr(r.Expression)
+	if err != nil {
+		return nil, err
+	}
+	if expr.Source == SourceMetric {
+		return e.repo.LatestMetric(ctx, r.SimulationID, expr.Label("name"))
+	}
+	if v := expr.Label("offline_for"); v != "" {
+		d, _ := time.ParseDuration(v)
+		n, err := e.offline.CountOffline(ctx, expr.Label("type"), r.SimulationID, r.ProjectID, d)
+		if err != nil {
+			return nil, err
+		}
+		count := float64(n)
+		return &count, nil
+	}
+
+	f := agent.Filter{
+		Type:         expr.Label("type"),
+		Status:       expr.Label("status"),
+		SimulationID: r.SimulationID,
+		ProjectID:    r.ProjectID,
+		Tags:         expr.Labels["tag"],
+		Page:         1,
+		PageSize:     1,
+	}
+	switch expr.Func {
+	case FuncCount:
+		_, total, err := e.agents.ListAgents(ctx, f)
+		if err != nil {
+			return nil, err
+		}
+		v := float64(total)
+		return &v, nil
+	case FuncRatio:
+		_, matching, err := e.agents.ListAgents(ctx, f)
+		if err != nil {
+			return nil, err
+		}
+		f.Status = ""
+		_, all, err := e.agents.ListAgents(ctx, f)
+		if err != nil || all == 0 {
+			return nil, err
+		}
+		v := float64(matching) / float64(all)
+		return &v, nil
+	}
+	return e.aggregate(ctx, f, expr)
+}
+
+// aggregate varre os agentes do seletor, até maxScan, e agrega o campo.
+func (e *Evaluator) aggregate(ctx context.Context, f agent.Filter, expr *Expr) (*float64, error) {
+	f.PageSize = scanPageSize
+	var sum, n float64
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for seen := 0; seen < maxScan; f.Page++ {
+		agents, total, err := e.agents.ListAgents(ctx, f)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range agents {
+			v := a.Energy
+			if expr.Field == "speed" {
+				v = a.Position.Speed
+			}
+			sum += v
+			n++
+			lo, hi = math.Min(lo, v), math.Max(hi, v)
+		}
+		seen += len(agents)
+		if len(agents) < f.PageSize || seen >= total {
+			break
+		}
+	}
+
+	var v float64
+	switch expr.Func {
+	case FuncSum:
+		v = sum
+	case FuncAvg, FuncMin, FuncMax:
+		if n == 0 {
+			return nil, nil
+		}
+		v = map[string]float64{FuncAvg: sum / n, FuncMin: lo, FuncMax: hi}[expr.Func]
+	default:
+		return nil, fmt.Errorf("alert: função %s não suportada", expr.Func)
+	}
+	return &v, nil
+}
@@ -0,0 +1,279 @@
+package alert
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Funções das expressões.
+const (
+	FuncCount = "count"
+	FuncRatio = "ratio"
+	FuncAvg   = "avg"
+	FuncMin   = "min"
+	FuncMax   = "max"
+	FuncSum   = "sum"
+	FuncLast  = "last"
+)
+
+// Fontes das expressões.
+const (
+	SourceAgents = "agents"
+	SourceMetric = "metric"
+)
+
+// Expr é uma expressão de métrica já interpretada. A sintaxe é
+//
+//	count(agents{type="bus",status="idle"})   agentes que casam com o seletor
+//	count(agents{offline_for="10m"})          agentes offline por silêncio há
+//	                                           pelo menos 10m
+//	ratio(agents{type="bus",status="idle"})   a mesma contagem dividida pelos
+//	                                           agentes do seletor sem status
+//	avg(agents{type="bus"}.energy)            avg, min, max ou sum de energy
+//	                                           ou speed dos agentes
+//	last(metric{name="throughput"})           último valor da métrica na
+//	                                           tabela metrics da simulação
+//
+// Os rótulos de agents são type, status, tag (repetível) e offline_for, que
+// só combina com count e type.
+type Expr struct {
+	Func   string
+	Source string
+	// Labels mapeia o rótulo para seus valores; só tag aceita mais de um.
+	Labels map[string][]string
+	Field  string
+}
+
+var agentLabels = map[string]bool{"type": true, "status": true, "tag": true, "offline_for": true}
+
+var agentFields = map[string]bool{"energy": true, "speed": true}
+
+// ParseExpr interpreta e valida uma expressão.
+func ParseExpr(s string) (*Expr, error) {
+	p := &parser{s: s}
+	e, err := p.parse()
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", s, err)
+	}
+	if err := e.check(); err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", s, err)
+	}
+	return e, nil
+}
+
+func (e *Expr) check() error {
+	switch e.Source {
+	case SourceAgents:
+		for k, v := range e.Labels {
+			if !agentLabels[k] {
+				return fmt.Errorf("unknown agents label %q (use type, status, tag, offline_for)", k)
+			}
+			if k != "tag" && len(v) > 1 {
+				return fmt.Errorf("label %q repeated", k)
+			}
+		}
+		if v := e.Label("offline_for"); v != "" {
+			if d, err := time.ParseDuration(v); err != nil || d < 0 {
+				return fmt.Errorf("offline_for must be a duration like \"10m\"")
+			}
+			if e.Func != FuncCount || len(e.Labels["status"]) > 0 || len(e.Labels["tag"]) > 0 {
+				return fmt.Errorf("offline_for only works with count and the type label")
+			}
+		}
+		switch e.Func {
+		case FuncCount, FuncRatio:
+			if e.Field != "" {
+				return fmt.Errorf("%s does not take a field", e.Func)
+			}
+			if e.Func == FuncRatio && len(e.Labels["status"]) == 0 {
+				return fmt.Errorf("ratio needs a status label")
+			}
+		case FuncAvg, FuncMin, FuncMax, FuncSum:
+			if !agentFields[e.Field] {
+				return fmt.Errorf("%s needs a field: .energy or .speed", e.Func)
+			}
+		default:
+			return fmt.Errorf("function %s does not apply to agents", e.Func)
+		}
+	case SourceMetric:
+		if e.Func != FuncLast {
+			return fmt.Errorf("metric only supports last")
+		}
+		if e.Field != "" {
+			return fmt.Errorf("metric does not take a field")
+		}
+		for k := range e.Labels {
+			if k != "name" {
+				return fmt.Errorf("unknown metric label %q (use name)", k)
+			}
+		}
+		if len(e.Labels["name"]) != 1 {
+			return fmt.Errorf("metric needs exactly one name label")
+		}
+	default:
+		return fmt.Errorf("unknown source %q (use agents or metric)", e.Source)
+	}
+	return nil
+}
+
+// Label retorna o primeiro valor do rótulo.
+func (e *Expr) Label(name string) string {
+	if v := e.Labels[name]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// String devolve a expressão na forma canônica, com os rótulos em ordem.
+func (e *Expr) String() string {
+	var b strings.Builder
+	b.WriteString(e.Func)
+	b.WriteString("(")
+	b.WriteString(e.Source)
+	if len(e.Labels) > 0 {
+		keys := make([]string, 0, len(e.Labels))
+		for k := range e.Labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var pairs []string
+		for _, k := range keys {
+			for _, v := range e.Labels[k] {
+				pairs = append(pairs, fmt.Sprintf("%s=%q", k, v))
+			}
+		}
+		b.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+	if e.Field != "" {
+		b.WriteString("." + e.Field)
+	}
+	b.WriteString(")")
+	return b.String()
+}
+
+// parser é um descendente recursivo mínimo para a gramática de Expr.
+type parser struct {
+	s   string
+	pos int
+}
+
+func (p *parser) parse() (*Expr, error) {
+	e := &Expr{Labels: map[string][]string{}}
+	var err error
+	if e.Func, err = p.ident(); err != nil {
+		return nil, err
+	}
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+	if e.Source, err = p.ident(); err != nil {
+		return nil, err
+	}
+	if p.peek() == '{' {
+		p.pos++
+		for p.peek() != '}' {
+			k, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect('='); err != nil {
+				return nil, err
+			}
+			v, err := p.quoted()
+			if err != nil {
+				return nil, err
+			}
+			e.Labels[k] = append(e.Labels[k], v)
+			if p.peek() == ',' {
+				p.pos++
+				continue
+			}
+			if p.peek() != '}' {
+				return nil, p.errorf("expected , or }")
+			}
+		}
+		p.pos++
+	}
+	if p.peek() == '.' {
+		p.pos++
+		if e.Field, err = p.ident(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expect(')'); err != nil {
+		return nil, err
+	}
+	if p.skipSpace(); p.pos != len(p.s) {
+		return nil, p.errorf("unexpected trailing input")
+	}
+	return e, nil
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.s) && unicode.IsSpace(rune(p.s[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *parser) peek() byte {
+	p.skipSpace()
+	if p.pos >= len(p.s) {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *parser) expect(c byte) error {
+	if p.peek() != c {
+		return p.errorf("expected %q", c)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *parser) ident() (string, error) {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		if c != '_' && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (p.pos == start || c < '0' || c > '9') {
+			break
+		}
+		p.pos++
+	}
+	if p.pos == start {
+		return "", p.errorf("expected identifier")
+	}
+	return p.s[start:p.pos], nil
+}
+
+// quoted lê um valor entre aspas com os escapes de Go, os mesmos que
+// String produz.
+func (p *parser) quoted() (string, error) {
+	if p.peek() != '"' {
+		return "", p.errorf("expected quoted value")
+	}
+	start := p.pos
+	for p.pos++; p.pos < len(p.s); p.pos++ {
+		switch p.s[p.pos] {
+		case '\\':
+			p.pos++
+		case '"':
+			p.pos++
+			v, err := strconv.Unquote(p.s[start:p.pos])
+			if err != nil {
+				return "", p.errorf("invalid quoted value")
+			}
+			return v, nil
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("at offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
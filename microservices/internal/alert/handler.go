@@ -0,0 +1,278 @@
+package alert
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/audit"
+	"smart-city-microservices/internal/i18n"
+	"smart-city-microservices/internal/logging"
+)
+
+// SimulationService resolve o projeto de uma simulação.
+type SimulationService interface {
+	GetSimulation(ctx context.Context, id string) (*agent.Simulation, error)
+}
+
+// Handler expõe o CRUD de regras e o estado atual dos alertas.
+type Handler struct {
+	repo        *Repository
+	simulations SimulationService
+}
+
+// NewHandler cria o handler de alertas.
+func NewHandler(repo *Repository, simulations SimulationService) *Handler {
+	return &Handler{repo: repo, simulations: simulations}
+}
+
+// CreateRequest é o corpo de POST /alert-rules. resolve_threshold e
+// resolve_for ausentes repetem threshold e for; severity padrão é warning.
+// Com simulation_id e sem project_id, o projeto é o da simulação.
+type CreateRequest struct {
+	Name             string    `json:"name" binding:"required"`
+	Description      string    `json:"description"`
+	Expression       string    `json:"expression" binding:"required"`
+	Operator         string    `json:"operator" binding:"required"`
+	Threshold        *float64  `json:"threshold" binding:"required"`
+	ResolveThreshold *float64  `json:"resolve_threshold"`
+	For              Duration  `json:"for"`
+	ResolveFor       *Duration `json:"resolve_for"`
+	Severity         string    `json:"severity"`
+	SimulationID     string    `json:"simulation_id"`
+	ProjectID        string    `json:"project_id"`
+	Enabled          *bool     `json:"enabled"`
+}
+
+// UpdateRequest é o corpo de PUT /alert-rules/:id; campos ausentes não
+// mudam. Qualquer alteração zera o estado do alerta.
+type UpdateRequest struct {
+	Name             *string   `json:"name"`
+	Description      *string   `json:"description"`
+	Expression       *string   `json:"expression"`
+	Operator         *string   `json:"operator"`
+	Threshold        *float64  `json:"threshold"`
+	ResolveThreshold *float64  `json:"resolve_threshold"`
+	For              *Duration `json:"for"`
+	ResolveFor       *Duration `json:"resolve_for"`
+	Severity         *string   `json:"severity"`
+	SimulationID     *string   `json:"simulation_id"`
+	ProjectID        *string   `json:"project_id"`
+	Enabled          *bool     `json:"enabled"`
+}
+
+// List retorna as regras, opcionalmente filtradas por ?project_id=.
+func (h *Handler) List(c *gin.Context) {
+	rules, err := h.repo.List(c.Request.Context(), c.Query("project_id"))
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	if rules == nil {
+		rules = []*Rule{}
+	}
+	c.JSON(http.StatusOK, gin.H{"data": rules})
+}
+
+// Get retorna uma regra.
+func (h *Handler) Get(c *gin.Context) {
+	r, err := h.repo.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.serviceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, r)
+}
+
+// Create cadastra uma regra.
+func (h *Handler) Create(c *gin.Context) {
+	var req CreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		i18n.BindError(c, err)
+		return
+	}
+	r := &Rule{
+		Name:             req.Name,
+		Description:      req.Description,
+		Expression:       req.Expression,
+		Operator:         req.Operator,
+		Threshold:        *req.Threshold,
+		ResolveThreshold: *req.Threshold,
+		For:              req.For,
+		ResolveFor:       req.For,
+		Severity:         req.Severity,
+		SimulationID:     req.SimulationID,
+		ProjectID:        req.ProjectID,
+		Enabled:          req.Enabled == nil || *req.Enabled,
+	}
+	if req.ResolveThreshold != nil {
+		r.ResolveThreshold = *req.ResolveThreshold
+	}
+	if req.ResolveFor != nil {
+		r.ResolveFor = *req.ResolveFor
+	}
+	if r.Severity == "" {
+		r.Severity = SeverityWarning
+	}
+	if !h.prepare(c, r) {
+		return
+	}
+	if err := h.repo.Create(c.Request.Context(), r); err != nil {
+		h.internalError(c, err)
+		return
+	}
+	audit.Record(c.Request.Context(), "alert_rule.created", logrus.Fields{"rule_id": r.ID, "name": r.Name})
+	c.JSON(http.StatusCreated, r)
+}
+
+// Update altera os campos informados da regra.
+func (h *Handler) Update(c *gin.Context) {
+	var req UpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		i18n.BindError(c, err)
+		return
+	}
+	r, err := h.repo.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.serviceError(c, err)
+		return
+	}
+
+	for _, f := range []struct {
+		src *string
+		dst *string
+	}{
+		{req.Name, &r.Name}, {req.Description, &r.Description}, {req.Expression, &r.Expression},
+		{req.Operator, &r.Operator}, {req.Severity, &r.Severity},
+		{req.SimulationID, &r.SimulationID}, {req.ProjectID, &r.ProjectID},
+	} {
+		if f.src != nil {
+			*f.dst = *f.src
+		}
+	}
+	if req.SimulationID != nil && req.ProjectID == nil {
+		r.ProjectID = ""
+	}
+	if req.Threshold != nil {
+		r.Threshold = *req.Threshold
+	}
+	if req.ResolveThreshold != nil {
+		r.ResolveThreshold = *req.ResolveThreshold
+	}
+	if req.For != nil {
+		r.For = *req.For
+	}
+	if req.ResolveFor != nil {
+		r.ResolveFor = *req.ResolveFor
+	}
+	if req.Enabled != nil {
+		r.Enabled = *req.Enabled
+	}
+	if !h.prepare(c, r) {
+		return
+	}
+
+	if err := h.repo.Update(c.Request.Context(), r); err != nil {
+		h.serviceError(c, err)
+		return
+	}
+	audit.Record(c.Request.Context(), "alert_rule.updated", logrus.Fields{"rule_id": r.ID, "enabled": r.Enabled})
+	updated, err := h.repo.Get(c.Request.Context(), r.ID)
+	if err != nil {
+		h.serviceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+// Delete remove a regra e seu estado.
+func (h *Handler) Delete(c *gin.Context) {
+	id := c.Param("id")
+	if err := h.repo.Delete(c.Request.Context(), id); err != nil {
+		h.serviceError(c, err)
+		return
+	}
+	audit.Record(c.Request.Context(), "alert_rule.deleted", logrus.Fields{"rule_id": id})
+	c.Status(http.StatusNoContent)
+}
+
+// ListAlerts retorna as regras habilitadas com o estado atual, filtradas
+// por ?state= (inactive, pending, firing), ?severity= e ?project_id=.
+// Regras ainda não avaliadas aparecem como inactive.
+func (h *Handler) ListAlerts(c *gin.Context) {
+	ctx := c.Request.Context()
+	state, severity, project := c.Query("state"), c.Query("severity"), c.Query("project_id")
+	switch state {
+	case "", StateInactive, StatePending, StateFiring:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid state: " + state})
+		return
+	}
+
+	rules, err := h.repo.ListEnabled(ctx)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	states, err := h.repo.States(ctx)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	out := []Alert{}
+	for _, r := range rules {
+		s, ok := states[r.ID]
+		if !ok {
+			s = State{RuleID: r.ID, State: StateInactive}
+		}
+		if (state != "" && s.State != state) || (severity != "" && r.Severity != severity) ||
+			(project != "" && r.ProjectID != project) {
+			continue
+		}
+		out = append(out, Alert{Rule: r, State: s})
+	}
+	c.JSON(http.StatusOK, gin.H{"data": out})
+}
+
+// prepare valida a regra e completa o projeto a partir da simulação.
+// Responde ao cliente e retorna false quando a regra é inválida.
+func (h *Handler) prepare(c *gin.Context, r *Rule) bool {
+	if err := r.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	if expr, _ := ParseExpr(r.Expression); expr != nil {
+		r.Expression = expr.String()
+	}
+	if r.SimulationID == "" || r.ProjectID != "" {
+		return true
+	}
+	sim, err := h.simulations.GetSimulation(c.Request.Context(), r.SimulationID)
+	if errors.Is(err, agent.ErrNotFound) || (err == nil && sim == nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown simulation_id: " + r.SimulationID})
+		return false
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return false
+	}
+	r.ProjectID = sim.ProjectID
+	return true
+}
+
+func (h *Handler) serviceError(c *gin.Context, err error) {
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	h.internalError(c, err)
+}
+
+func (h *Handler) internalError(c *gin.Context, err error) {
+	logging.FromContext(c.Request.Context()).WithError(err).Error("Erro no handler de alertas")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
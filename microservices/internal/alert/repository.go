@@ -0,0 +1,197 @@
+package alert
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"smart-city-microservices/internal/instrument"
+)
+
+// Repository persiste regras e estados de alerta no PostgreSQL. O estado
+// fica no banco para sobreviver a reinícios e ser o mesmo em todas as
+// réplicas.
+type Repository struct {
+	db *instrument.DB
+}
+
+// NewRepository cria o repositório.
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: instrument.NewDB(db)}
+}
+
+const ruleColumns = `id, name, COALESCE(description, ''), expression, operator, threshold, resolve_threshold,
+	for_seconds, resolve_for_seconds, severity, COALESCE(simulation_id::text, ''), COALESCE(project_id, ''),
+	enabled, created_at, updated_at`
+
+func scanRule(row interface{ Scan(...interface{}) error }) (*Rule, error) {
+	var r Rule
+	var forSeconds, resolveSeconds float64
+	err := row.Scan(&r.ID, &r.Name, &r.Description, &r.Expression, &r.Operator, &r.Threshold, &r.ResolveThreshold,
+		&forSeconds, &resolveSeconds, &r.Severity, &r.SimulationID, &r.ProjectID, &r.Enabled, &r.CreatedAt, &r.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	r.For = Duration(time.Duration(forSeconds * float64(time.Second)))
+	r.ResolveFor = Duration(time.Duration(resolveSeconds * float64(time.Second)))
+	return &r, err
+}
+
+// Create insere a regra e preenche id e datas.
+func (r *Repository) Create(ctx context.Context, rule *Rule) error {
+	return r.db.QueryRow(ctx, "alert.create_rule", `
+		INSERT INTO alert_rules (name, description, expression, operator, threshold, resolve_threshold,
+			for_seconds, resolve_for_seconds, severity, simulation_id, project_id, enabled)
+		VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6, $7, $8, $9, NULLIF($10, '')::uuid, NULLIF($11, ''), $12)
+		RETURNING id, created_at, updated_at`,
+		rule.Name, rule.Description, rule.Expression, rule.Operator, rule.Threshold, rule.ResolveThreshold,
+		time.Duration(rule.For).Seconds(), time.Duration(rule.ResolveFor).Seconds(), rule.Severity,
+		rule.SimulationID, rule.ProjectID, rule.Enabled,
+	).Scan(&rule.ID, &rule.CreatedAt, &rule.UpdatedAt)
+}
+
+// Get busca uma regra pelo id.
+func (r *Repository) Get(ctx context.Context, id string) (*Rule, error) {
+	return scanRule(r.db.QueryRow(ctx, "alert.get_rule",
+		`SELECT `+ruleColumns+` FROM alert_rules WHERE id = $1`, id))
+}
+
+// List retorna as regras, filtrando por projeto quando informado.
+func (r *Repository) List(ctx context.Context, projectID string) ([]*Rule, error) {
+	return r.list(ctx, "alert.list_rules", `
+		SELECT `+ruleColumns+` FROM alert_rules
+		WHERE $1 = '' OR project_id = $1
+		ORDER BY created_at`, projectID)
+}
+
+// ListEnabled retorna as regras habilitadas, usadas pelo avaliador.
+func (r *Repository) ListEnabled(ctx context.Context) ([]*Rule, error) {
+	return r.list(ctx, "alert.list_enabled_rules",
+		`SELECT `+ruleColumns+` FROM alert_rules WHERE enabled ORDER BY created_at`)
+}
+
+func (r *Repository) list(ctx context.Context, name, query string, args ...interface{}) ([]*Rule, error) {
+	rows, err := r.db.Query(ctx, name, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*Rule
+	for rows.Next() {
+		rule, err := scanRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, rule)
+	}
+	return out, rows.Err()
+}
+
+// Update grava a regra inteira. Mudar a regra zera o estado: a condição
+// antiga não diz nada sobre a nova.
+func (r *Repository) Update(ctx context.Context, rule *Rule) error {
+	res, err := r.db.Exec(ctx, "alert.update_rule", `
+		UPDATE alert_rules SET name = $2, description = NULLIF($3, ''), expression = $4, operator = $5,
+			threshold = $6, resolve_threshold = $7, for_seconds = $8, resolve_for_seconds = $9, severity = $10,
+			simulation_id = NULLIF($11, '')::uuid, project_id = NULLIF($12, ''), enabled = $13
+		WHERE id = $1`,
+		rule.ID, rule.Name, rule.Description, rule.Expression, rule.Operator, rule.Threshold, rule.ResolveThreshold,
+		time.Duration(rule.For).Seconds(), time.Duration(rule.ResolveFor).Seconds(), rule.Severity,
+		rule.SimulationID, rule.ProjectID, rule.Enabled)
+	if err := affected(res, err); err != nil {
+		return err
+	}
+	_, err = r.db.Exec(ctx, "alert.reset_state", `DELETE FROM alert_states WHERE rule_id = $1`, rule.ID)
+	return err
+}
+
+// Delete remove a regra e seu estado.
+func (r *Repository) Delete(ctx context.Context, id string) error {
+	res, err := r.db.Exec(ctx, "alert.delete_rule", `DELETE FROM alert_rules WHERE id = $1`, id)
+	return affected(res, err)
+}
+
+const stateColumns = `rule_id, state, value, pending_since, firing_since, clear_since, resolved_at, evaluated_at`
+
+func scanState(row interface{ Scan(...interface{}) error }) (State, error) {
+	var s State
+	var value sql.NullFloat64
+	var pending, firing, clear, resolved, evaluated sql.NullTime
+	err := row.Scan(&s.RuleID, &s.State, &value, &pending, &firing, &clear, &resolved, &evaluated)
+	if value.Valid {
+		s.Value = &value.Float64
+	}
+	s.PendingSince, s.FiringSince = timePtr(pending), timePtr(firing)
+	s.ClearSince, s.ResolvedAt = timePtr(clear), timePtr(resolved)
+	s.EvaluatedAt = timePtr(evaluated)
+	return s, err
+}
+
+// States retorna o estado de cada regra avaliada, por id da regra.
+func (r *Repository) States(ctx context.Context) (map[string]State, error) {
+	rows, err := r.db.Query(ctx, "alert.list_states", `SELECT `+stateColumns+` FROM alert_states`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := map[string]State{}
+	for rows.Next() {
+		s, err := scanState(rows)
+		if err != nil {
+			return nil, err
+		}
+		out[s.RuleID] = s
+	}
+	return out, rows.Err()
+}
+
+// SaveState grava o estado após uma avaliação. Uma regra removida durante
+// a avaliação não é recriada: a chave estrangeira recusa a linha.
+func (r *Repository) SaveState(ctx context.Context, s State) error {
+	_, err := r.db.Exec(ctx, "alert.save_state", `
+		INSERT INTO alert_states (`+stateColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (rule_id) DO UPDATE SET state = EXCLUDED.state, value = EXCLUDED.value,
+			pending_since = EXCLUDED.pending_since, firing_since = EXCLUDED.firing_since,
+			clear_since = EXCLUDED.clear_since, resolved_at = EXCLUDED.resolved_at,
+			evaluated_at = EXCLUDED.evaluated_at`,
+		s.RuleID, s.State, s.Value, s.PendingSince, s.FiringSince, s.ClearSince, s.ResolvedAt, s.EvaluatedAt)
+	return err
+}
+
+// LatestMetric retorna o último valor registrado da métrica na simulação.
+func (r *Repository) LatestMetric(ctx context.Context, simulationID, name string) (*float64, error) {
+	var v float64
+	err := r.db.QueryRow(ctx, "alert.latest_metric", `
+		SELECT value FROM metrics
+		WHERE simulation_id = $1 AND metric_name = $2
+		ORDER BY timestamp DESC
+		LIMIT 1`, simulationID, name).Scan(&v)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func timePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+func affected(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+// Package anomaly detecta anomalias nas métricas dos agentes, junto da
+// ingestão das amostras (POST /agents/:id/metrics).
+//
+// Cada métrica com anomaly no registro de tipos tem, por agente, uma linha
+// de base: média e variância móveis exponenciais, guardadas num hash do
+// Redis ao lado do estado ao vivo. Depois de min_samples amostras, uma
+// amostra a mais de k desvios-padrão da média é anômala; window amostras
+// anômalas seguidas tornam a métrica anômala (agent.anomaly), e a primeira
+// amostra de volta à faixa esperada a libera (agent.anomaly_cleared). As
+// amostras anômalas entram na linha de base limitadas à faixa esperada:
+// um pico isolado pouco a desloca, e uma mudança duradoura de patamar
+// vira, aos poucos, o novo normal.
+//
+// Cada lote custa uma só chamada ao Redis, um script que atualiza as
+// métricas do lote; a nota de saúde só é recalculada quando o número de
+// métricas anômalas do agente muda.
+package anomaly
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/agentmetric"
+	"smart-city-microservices/internal/agenttype"
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/logging"
+)
+
+// Eventos publicados em events.TopicAgents.
+const (
+	EventAnomaly = "agent.anomaly"
+	EventCleared = "agent.anomaly_cleared"
+)
+
+var transitions = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "agent_service",
+	Name:      "agent_metric_anomalies_total",
+	Help:      "Métricas de agentes que ficaram anômalas (anomaly) ou voltaram à faixa esperada (cleared).",
+}, []string{"event"})
+
+// stateTTL descarta as linhas de base de agentes que pararam de enviar
+// amostras; cada lote renova o prazo, como na saúde.
+const stateTTL = 7 * 24 * time.Hour
+
+func baselineKey(agentID string) string { return "agent-service:agents:" + agentID + ":anomaly" }
+
+// observe atualiza as linhas de base no hash KEYS[1]. ARGV[1] é o TTL em
+// ms; seguem grupos de sete (métrica, valor, instante em µs, k, window,
+// min_samples, alpha). Por métrica, o hash guarda <m>:n, <m>:mean, <m>:var,
+// <m>:run (amostras anômalas seguidas), <m>:flag e <m>:at; flagged conta
+// as métricas anômalas. Amostras mais antigas que a última aplicada são
+// ignoradas. Retorna flagged e, para cada mudança, (métrica, evento,
+// valor, média, desvio, instante) — os números como texto, que o Redis
+// trunca os do Lua.
+var observe = redis.NewScript(`
+local key = KEYS[1]
+local out = {0}
+for i = 2, #ARGV, 7 do
+	local m = ARGV[i]
+	local x, at = tonumber(ARGV[i + 1]), tonumber(ARGV[i + 2])
+	local k, window, warm, alpha = tonumber(ARGV[i + 3]), tonumber(ARGV[i + 4]), tonumber(ARGV[i + 5]), tonumber(ARGV[i + 6])
+	local s = redis.call("HMGET", key, m .. ":n", m .. ":mean", m .. ":var", m .. ":run", m .. ":flag", m .. ":at")
+	local n, mean, var = tonumber(s[1]) or 0, tonumber(s[2]) or 0, tonumber(s[3]) or 0
+	local run, flag, last = tonumber(s[4]) or 0, tonumber(s[5]) or 0, tonumber(s[6])
+	if not last or at >= last then
+		local std = math.sqrt(var)
+		local y = x
+		if n >= warm then
+			if math.abs(x - mean) > k * std then run = run + 1 else run = 0 end
+			if std > 0 then y = math.min(math.max(x, mean - k * std), mean + k * std) end
+			if flag == 0 and run >= window then
+				flag = 1
+				redis.call("HINCRBY", key, "flagged", 1)
+				table.insert(out, {m, "anomaly", tostring(x), tostring(mean), tostring(std), ARGV[i + 2]})
+			elseif flag == 1 and run == 0 then
+				flag = 0
+				redis.call("HINCRBY", key, "flagged", -1)
+				table.insert(out, {m, "cleared", tostring(x), tostring(mean), tostring(std), ARGV[i + 2]})
+			end
+		end
+		if n == 0 then
+			mean, var = x, 0
+		else
+			-- Nas primeiras amostras, a média é a simples, para a primeira não pesar demais.
+			local a = math.max(alpha, 1 / (n + 1))
+			local d = y - mean
+			mean = mean + a * d
+			var = (1 - a) * (var + a * d * d)
+		end
+		redis.call("HSET", key, m .. ":n", n + 1, m .. ":mean", tostring(mean), m .. ":var", tostring(var),
+			m .. ":run", run, m .. ":flag", flag, m .. ":at", ARGV[i + 2])
+	end
+end
+out[1] = tonumber(redis.call("HGET", key, "flagged")) or 0
+redis.call("PEXPIRE", key, ARGV[1])
+return out
+`)
+
+// TypeResolver lê as métricas do tipo do agente; *agenttype.Registry o
+// implementa.
+type TypeResolver interface {
+	Resolve(ctx context.Context, name string) (*agenttype.Type, bool, error)
+}
+
+// Health recebe quantas métricas do agente estão anômalas;
+// *agenthealth.Tracker o implementa.
+type Health interface {
+	Anomalies(ctx context.Context, ag *agent.Agent, n int)
+}
+
+// Detector é o agentmetric.Observer que detecta as anomalias.
+type Detector struct {
+	redis     redis.UniversalClient
+	types     TypeResolver
+	health    Health
+	publisher events.Publisher
+}
+
+// NewDetector cria o detector. health pode ser nil, sem efeito na nota.
+func NewDetector(client redis.UniversalClient, types TypeResolver, health Health, publisher events.Publisher) *Detector {
+	return &Detector{redis: client, types: types, health: health, publisher: publisher}
+}
+
+// Observe aplica as amostras das métricas com detecção às linhas de base
+// do agente, em ordem de instante, e publica as mudanças. Falhas só são
+// registradas: as amostras já foram gravadas.
+func (d *Detector) Observe(ctx context.Context, ag *agent.Agent, samples []agentmetric.Sample) {
+	log := logging.FromContext(ctx).WithField("agent_id", ag.ID)
+	t, ok, err := d.types.Resolve(ctx, ag.Type)
+	if err != nil {
+		log.WithError(err).Warn("Falha ao ler as métricas do tipo para a detecção de anomalias")
+		return
+	}
+	if !ok {
+		return
+	}
+	policies := map[string]*agentmetric.Anomaly{}
+	for _, m := range t.Metrics {
+		if m.Anomaly != nil {
+			policies[m.Name] = m.Anomaly
+		}
+	}
+	if len(policies) == 0 {
+		return
+	}
+	watched := make([]agentmetric.Sample, 0, len(samples))
+	for _, s := range samples {
+		if policies[s.Name] != nil && !math.IsNaN(s.Value) && !math.IsInf(s.Value, 0) {
+			watched = append(watched, s)
+		}
+	}
+	if len(watched) == 0 {
+		return
+	}
+	sort.SliceStable(watched, func(i, j int) bool { return watched[i].At.Before(watched[j].At) })
+
+	args := make([]interface{}, 0, 1+7*len(watched))
+	args = append(args, stateTTL.Milliseconds())
+	for _, s := range watched {
+		p := policies[s.Name]
+		baseline := p.Baseline
+		if baseline == 0 {
+			baseline = agentmetric.DefaultBaseline
+		}
+		args = append(args, s.Name, strconv.FormatFloat(s.Value, 'g', -1, 64), s.At.UnixMicro(),
+			strconv.FormatFloat(p.K, 'g', -1, 64), p.Window, p.MinSamples,
+			strconv.FormatFloat(2/float64(baseline+1), 'g', -1, 64))
+	}
+	res, err := observe.Run(ctx, d.redis, []string{baselineKey(ag.ID)}, args...).Slice()
+	if err != nil {
+		log.WithError(err).Warn("Falha ao atualizar as linhas de base de anomalias do agente")
+		return
+	}
+	if len(res) < 2 {
+		return
+	}
+
+	flagged, _ := res[0].(int64)
+	for _, raw := range res[1:] {
+		c, ok := raw.([]interface{})
+		if !ok || len(c) != 6 {
+			continue
+		}
+		metric, _ := c[0].(string)
+		event, _ := c[1].(string)
+		observed, mean, stddev := parseFloat(c[2]), parseFloat(c[3]), parseFloat(c[4])
+		at, _ := c[5].(string)
+		us, _ := strconv.ParseInt(at, 10, 64)
+		p := policies[metric]
+		payload := events.AgentAnomalyV1{
+			AgentID:   ag.ID,
+			AgentType: ag.Type,
+			ProjectID: ag.ProjectID,
+			Metric:    metric,
+			Observed:  observed,
+			Expected:  events.ExpectedRange{Low: mean - p.K*stddev, High: mean + p.K*stddev},
+			Mean:      mean,
+			StdDev:    stddev,
+			K:         p.K,
+			Window:    p.Window,
+			At:        time.UnixMicro(us).UTC(),
+		}
+		if stddev > 0 {
+			sigmas := math.Abs(observed-mean) / stddev
+			payload.Sigmas = &sigmas
+		}
+		name := EventAnomaly
+		if event == "cleared" {
+			name = EventCleared
+		}
+		transitions.WithLabelValues(event).Inc()
+		log.WithFields(logrus.Fields{"metric": metric, "event": event, "observed": observed, "mean": mean, "stddev": stddev}).Info("Anomalia de métrica do agente mudou")
+		d.publisher.Publish(ctx, events.New(events.TopicAgents, name, payload))
+	}
+	if d.health != nil {
+		d.health.Anomalies(ctx, ag, int(flagged))
+	}
+}
+
+func parseFloat(v interface{}) float64 {
+	s, _ := v.(string)
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
@@ -0,0 +1,90 @@
+// Package apiinfo descreve o que esta implantação da API suporta: versão e
+// build, feature flags ligados, versões dos schemas de eventos, tipos de
+// conteúdo e a paginação de cada listagem. É o corpo de GET /api/v1/version,
+// que os clientes leem uma vez para se adaptar, e o mesmo conteúdo vai no
+// log de partida e em /health?verbose=true.
+package apiinfo
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"smart-city-microservices/internal/buildinfo"
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/negotiate"
+)
+
+// APIVersion é a versão dos caminhos da API.
+const APIVersion = "v1"
+
+// Modos de paginação das listagens.
+const (
+	PaginationOffset = "offset"
+	PaginationCursor = "cursor"
+)
+
+// pagination é a paginação das listagens que os clientes percorrem.
+var pagination = map[string]string{
+	"agents":        PaginationOffset,
+	"agent_actions": PaginationCursor,
+	"agent_changes": PaginationCursor,
+}
+
+// Info é o corpo de GET /api/v1/version.
+type Info struct {
+	buildinfo.Info
+	APIVersion string `json:"api_version"`
+	// FeatureFlags são os flags ligados para quem pergunta; no log de
+	// partida e em /health, os ligados no ambiente.
+	FeatureFlags []string `json:"feature_flags"`
+	// EventSchemas são as versões de payload de cada tipo de evento, em
+	// ordem crescente; a última é a emitida.
+	EventSchemas map[string][]int  `json:"event_schemas"`
+	ContentTypes []string          `json:"content_types"`
+	Pagination   map[string]string `json:"pagination"`
+}
+
+// Flags lista os feature flags ligados; *featureflag.Flags o implementa.
+type Flags interface {
+	EnabledFor(c *gin.Context) []string
+	EnabledInEnvironment() []string
+}
+
+// Handler monta a descrição da API.
+type Handler struct {
+	flags   Flags
+	schemas *events.Registry
+}
+
+// New cria o handler. schemas é o registro dos eventos publicados,
+// normalmente events.Schemas.
+func New(flags Flags, schemas *events.Registry) *Handler {
+	return &Handler{flags: flags, schemas: schemas}
+}
+
+// Environment é a descrição com os flags ligados no ambiente, sem o
+// contexto de uma requisição.
+func (h *Handler) Environment() Info {
+	return h.info(h.flags.EnabledInEnvironment())
+}
+
+// Version responde GET /api/v1/version.
+func (h *Handler) Version(c *gin.Context) {
+	c.JSON(http.StatusOK, h.info(h.flags.EnabledFor(c)))
+}
+
+func (h *Handler) info(flags []string) Info {
+	schemas := map[string][]int{}
+	for _, s := range h.schemas.List() {
+		schemas[s.Type] = append(schemas[s.Type], s.Version)
+	}
+	return Info{
+		Info:         buildinfo.Get(),
+		APIVersion:   APIVersion,
+		FeatureFlags: flags,
+		EventSchemas: schemas,
+		ContentTypes: negotiate.MediaTypes,
+		Pagination:   pagination,
+	}
+}
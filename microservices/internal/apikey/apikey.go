@@ -0,0 +1,183 @@
+// Package apikey gerencia chaves de API de longa duração para integrações e
+// scripts. Só o hash SHA-256 da chave fica no banco; o valor aparece uma
+// única vez, na criação.
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/instrument"
+	"smart-city-microservices/internal/logging"
+)
+
+// Prefix inicia toda chave gerada, o que permite reconhecê-la sem consultar
+// o banco e distingui-la do token estático.
+const Prefix = "sck_"
+
+// ErrNotFound indica que a chave não existe ou já foi revogada.
+var ErrNotFound = errors.New("api key not found")
+
+// Key descreve uma chave sem o seu valor.
+type Key struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Hint são os primeiros caracteres da chave, para identificá-la em listas.
+	Hint      string     `json:"hint"`
+	Roles     []string   `json:"roles"`
+	Projects  []string   `json:"projects,omitempty"`
+	Scopes    []string   `json:"scopes,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// scopePattern é o formato dos escopos, ex.: broadcast.
+var scopePattern = regexp.MustCompile(`^[a-z][a-z0-9_.:-]{0,63}$`)
+
+// Validate confere nome, papéis e escopos.
+func (k *Key) Validate() error {
+	if strings.TrimSpace(k.Name) == "" {
+		return errors.New("name is required")
+	}
+	if len(k.Roles) == 0 {
+		return errors.New("at least one role is required")
+	}
+	for _, r := range k.Roles {
+		switch r {
+		case auth.RoleAdmin, auth.RoleOperator, auth.RoleViewer:
+		default:
+			return fmt.Errorf("invalid role %q (use admin, operator, viewer)", r)
+		}
+	}
+	for _, s := range k.Scopes {
+		if !scopePattern.MatchString(s) {
+			return fmt.Errorf("invalid scope %q (use lowercase letters, digits and _ . : -)", s)
+		}
+	}
+	return nil
+}
+
+// Repository persiste as chaves no PostgreSQL.
+type Repository struct {
+	db *instrument.DB
+}
+
+// NewRepository cria o repositório.
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: instrument.NewDB(db)}
+}
+
+// Create gera a chave, grava seu hash e retorna o valor, que não pode ser
+// recuperado depois.
+func (r *Repository) Create(ctx context.Context, k *Key) (string, error) {
+	if err := k.Validate(); err != nil {
+		return "", err
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	secret := Prefix + hex.EncodeToString(b)
+	k.Hint = secret[:len(Prefix)+8]
+	if k.Projects == nil {
+		k.Projects = []string{}
+	}
+	if k.Scopes == nil {
+		k.Scopes = []string{}
+	}
+	err := r.db.QueryRow(ctx, "apikey.create", `
+		INSERT INTO api_keys (name, hint, key_hash, roles, projects, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at`,
+		k.Name, k.Hint, hash(secret), pq.Array(k.Roles), pq.Array(k.Projects), pq.Array(k.Scopes), k.ExpiresAt,
+	).Scan(&k.ID, &k.CreatedAt)
+	if err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// Revoke revoga a chave; requisições com ela deixam de ser autenticadas.
+func (r *Repository) Revoke(ctx context.Context, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrNotFound
+	}
+	res, err := r.db.Exec(ctx, "apikey.revoke",
+		`UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND revoked_at IS NULL`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Lookup retorna a chave válida (não revogada nem expirada) com o valor
+// informado.
+func (r *Repository) Lookup(ctx context.Context, secret string) (*Key, error) {
+	var k Key
+	var expires sql.NullTime
+	err := r.db.QueryRow(ctx, "apikey.lookup", `
+		SELECT id, name, hint, roles, projects, scopes, created_at, expires_at FROM api_keys
+		WHERE key_hash = $1 AND revoked_at IS NULL
+			AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)`, hash(secret),
+	).Scan(&k.ID, &k.Name, &k.Hint, pq.Array(&k.Roles), pq.Array(&k.Projects), pq.Array(&k.Scopes), &k.CreatedAt, &expires)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if expires.Valid {
+		k.ExpiresAt = &expires.Time
+	}
+	return &k, nil
+}
+
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Middleware autentica requisições que apresentam uma chave de API
+// (X-API-Key ou Authorization: Bearer sck_...). Como em auth.StaticToken,
+// requisições sem chave seguem sem principal; com chave inválida recebem 401.
+func Middleware(repo *Repository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		secret := c.GetHeader("X-API-Key")
+		if secret == "" {
+			secret = strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		}
+		if !strings.HasPrefix(secret, Prefix) || auth.FromGin(c) != nil {
+			c.Next()
+			return
+		}
+		k, err := repo.Lookup(c.Request.Context(), secret)
+		if errors.Is(err, ErrNotFound) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid api key"})
+			return
+		}
+		if err != nil {
+			logging.FromContext(c.Request.Context()).WithError(err).Error("Erro ao validar chave de API")
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+			return
+		}
+		auth.SetPrincipal(c, &auth.Principal{Subject: "apikey:" + k.ID, Roles: k.Roles, Projects: k.Projects, Scopes: k.Scopes})
+		c.Next()
+	}
+}
@@ -0,0 +1,128 @@
+// Package apitest monta a API HTTP do serviço sobre os substitutos de
+// internal/fake, para testar as rotas de ponta a ponta sem Postgres nem
+// Redis. As rotas, os middlewares e a ordem dos handlers são os do main;
+// os componentes que só existem com o banco ficam de fora.
+package apitest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/agenthealth"
+	"smart-city-microservices/internal/agentlist"
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/dependency"
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/fake"
+	"smart-city-microservices/internal/maintenance"
+	"smart-city-microservices/internal/negotiate"
+	"smart-city-microservices/internal/presence"
+)
+
+// AdminToken é o token estático do servidor de teste, que autentica como
+// admin.
+const AdminToken = "test-admin-token"
+
+// DefaultCapabilities são as capacidades de todos os agentes no servidor
+// de teste, que não tem o repositório de capacidades declaradas.
+var DefaultCapabilities = []string{"telemetry"}
+
+// Server é a API em execução e os substitutos por trás dela, para montar o
+// estado de cada teste.
+type Server struct {
+	*httptest.Server
+	Router      *gin.Engine
+	Agents      *fake.Agents
+	Redis       redis.UniversalClient
+	Miniredis   *miniredis.Miniredis
+	Events      *events.Bus
+	Recent      *events.Recent
+	Health      *agenthealth.Tracker
+	Presence    *presence.Tracker
+	Maintenance *maintenance.Switch
+}
+
+// NewTestServer sobe a API sobre um repositório de agentes vazio e um Redis
+// em memória; tudo é encerrado no fim do teste.
+func NewTestServer(tb testing.TB) *Server {
+	tb.Helper()
+	gin.SetMode(gin.TestMode)
+	client, mr := fake.Redis(tb)
+	s := &Server{
+		Agents:    fake.NewAgents(),
+		Redis:     client,
+		Miniredis: mr,
+		Events:    events.NewBus(),
+		Recent:    events.NewRecent(100),
+	}
+	s.Events.Subscribe(s.Recent.Handle)
+	s.Health = agenthealth.NewTracker(client, s.Events, nil)
+	s.Presence = presence.NewTracker(client, s.Agents, s.Events, "offline")
+	s.Maintenance = maintenance.New(client, maintenance.Config{RefreshInterval: time.Second, RetryAfter: 30 * time.Second})
+
+	negotiateHandler := negotiate.NewHandler(s.Agents, s.Recent, negotiate.Config{BatchGetMax: 100})
+	agentListHandler := agentlist.NewHandler(s.Agents, s.Health, noImpairments{}, defaultCapabilities{}, s.Presence)
+	maintenanceHandler := maintenance.NewHandler(s.Maintenance, s.Events)
+
+	router := gin.New()
+	router.Use(gin.Recovery())
+	router.Use(auth.StaticToken(AdminToken))
+	router.Use(s.Maintenance.Middleware("/api/v1/admin/maintenance"))
+	v1 := router.Group("/api/v1")
+	{
+		agents := v1.Group("/agents")
+		{
+			agents.GET("", negotiateHandler.GetAgentsByID, negotiateHandler.ListAgents, agentListHandler.ListAgents)
+			agents.POST("/batch-get", negotiateHandler.BatchGet)
+		}
+		v1.GET("/simulations/:id/agents", agentListHandler.SimulationAgents)
+		v1.GET("/events", negotiateHandler.ListEvents)
+
+		adminRoutes := v1.Group("/admin", auth.RequireRole(auth.RoleAdmin))
+		{
+			adminRoutes.GET("/maintenance", maintenanceHandler.Get)
+			adminRoutes.PUT("/maintenance", maintenanceHandler.Set)
+		}
+	}
+	s.Router = router
+	s.Server = httptest.NewServer(router)
+	tb.Cleanup(s.Server.Close)
+	return s
+}
+
+// Do envia a requisição ao roteador e retorna a resposta gravada, com o
+// token de admin quando admin é true.
+func (s *Server) Do(req *http.Request, admin bool) *httptest.ResponseRecorder {
+	if admin {
+		req.Header.Set("Authorization", "Bearer "+AdminToken)
+	}
+	w := httptest.NewRecorder()
+	s.Router.ServeHTTP(w, req)
+	return w
+}
+
+// noImpairments é a fonte de prejuízos sem dependências com falha.
+type noImpairments struct{}
+
+func (noImpairments) Impairments(context.Context, []string) (map[string]*dependency.Impairment, error) {
+	return nil, nil
+}
+
+// defaultCapabilities dá DefaultCapabilities a todos os agentes.
+type defaultCapabilities struct{}
+
+func (defaultCapabilities) ForAgents(_ context.Context, agents []agent.Agent) (map[string][]string, error) {
+	out := make(map[string][]string, len(agents))
+	for _, a := range agents {
+		out[a.ID] = DefaultCapabilities
+	}
+	return out, nil
+}
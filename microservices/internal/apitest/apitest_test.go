@@ -0,0 +1,271 @@
+package apitest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/fake"
+	"smart-city-microservices/internal/maintenance"
+)
+
+// id é o UUID do agente de nome name nos testes.
+func id(name string) string {
+	return uuid.NewSHA1(uuid.NameSpaceURL, []byte("apitest/"+name)).String()
+}
+
+// seed grava duas simulações e cinco agentes, criados um segundo depois do
+// outro para que a ordem da listagem seja a de criação.
+func seed(s *Server) {
+	at := time.Date(2026, 3, 4, 5, 0, 0, 0, time.UTC)
+	s.Agents.PutSimulation(agent.Simulation{ID: "sim-a", ProjectID: "proj-1", Name: "Centro", Status: "running"})
+	s.Agents.PutSimulation(agent.Simulation{ID: "sim-b", ProjectID: "proj-2", Name: "Porto"})
+	for i, a := range []agent.Agent{
+		{Name: "bus-1", SimulationID: "sim-a", ProjectID: "proj-1", Type: "bus", Tags: []string{"linha-101"}},
+		{Name: "bus-2", SimulationID: "sim-a", ProjectID: "proj-1", Type: "bus", Status: "idle"},
+		{Name: "bus-3", SimulationID: "sim-a", ProjectID: "proj-1", Type: "bus", Tags: []string{"linha-101", "noturno"}},
+		{Name: "sensor-1", SimulationID: "sim-b", ProjectID: "proj-2", Type: "sensor"},
+		{Name: "sensor-2", SimulationID: "sim-b", ProjectID: "proj-2", Type: "sensor", Status: "idle"},
+	} {
+		a.ID = id(a.Name)
+		a.CreatedAt = at.Add(time.Duration(i) * time.Second)
+		s.Agents.Put(a)
+	}
+}
+
+// page é o corpo JSON das listagens e da busca em lote.
+type page struct {
+	Data []struct {
+		Name         string     `json:"name"`
+		Capabilities []string   `json:"capabilities"`
+		LastSeenAt   *time.Time `json:"last_seen_at"`
+	} `json:"data"`
+	Total    int      `json:"total"`
+	Page     int      `json:"page"`
+	Count    int      `json:"count"`
+	Complete bool     `json:"complete"`
+	NotFound []string `json:"not_found"`
+	Error    string   `json:"error"`
+}
+
+// names são os nomes dos agentes da resposta, na ordem.
+func (p page) names() string {
+	names := make([]string, len(p.Data))
+	for i, a := range p.Data {
+		names[i] = a.Name
+	}
+	return strings.Join(names, ",")
+}
+
+func TestAgentRoutes(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		accept string
+		admin  bool
+		setup  func(t *testing.T, s *Server)
+		status int
+		check  func(t *testing.T, w *httptest.ResponseRecorder, p page)
+	}{
+		{
+			name: "list all", path: "/api/v1/agents", status: http.StatusOK,
+			check: func(t *testing.T, _ *httptest.ResponseRecorder, p page) {
+				if p.Total != 5 || p.names() != "bus-1,bus-2,bus-3,sensor-1,sensor-2" {
+					t.Errorf("total %d, names %s", p.Total, p.names())
+				}
+				if caps := p.Data[0].Capabilities; len(caps) != 1 || caps[0] != "telemetry" {
+					t.Errorf("capabilities = %v", caps)
+				}
+			},
+		},
+		{
+			name: "filter by type and status", path: "/api/v1/agents?type=bus&status=active", status: http.StatusOK,
+			check: func(t *testing.T, _ *httptest.ResponseRecorder, p page) {
+				if p.Total != 2 || p.names() != "bus-1,bus-3" {
+					t.Errorf("total %d, names %s", p.Total, p.names())
+				}
+			},
+		},
+		{
+			name: "filter by all tags", path: "/api/v1/agents?tags=linha-101,noturno", status: http.StatusOK,
+			check: func(t *testing.T, _ *httptest.ResponseRecorder, p page) {
+				if p.names() != "bus-3" {
+					t.Errorf("names %s", p.names())
+				}
+			},
+		},
+		{
+			name: "second page", path: "/api/v1/agents?page=2&page_size=2", status: http.StatusOK,
+			check: func(t *testing.T, _ *httptest.ResponseRecorder, p page) {
+				if p.Total != 5 || p.Page != 2 || p.names() != "bus-3,sensor-1" {
+					t.Errorf("total %d, page %d, names %s", p.Total, p.Page, p.names())
+				}
+			},
+		},
+		{name: "invalid page size", path: "/api/v1/agents?page_size=0", status: http.StatusBadRequest},
+		{name: "invalid health", path: "/api/v1/agents?health=fine", status: http.StatusBadRequest},
+		{
+			name: "capability filter", path: "/api/v1/agents?capability=gps", status: http.StatusOK,
+			check: func(t *testing.T, _ *httptest.ResponseRecorder, p page) {
+				if p.Total != 0 || len(p.Data) != 0 {
+					t.Errorf("total %d, names %s", p.Total, p.names())
+				}
+			},
+		},
+		{
+			name: "presence", path: "/api/v1/agents?simulation_id=sim-b", status: http.StatusOK,
+			setup: func(t *testing.T, s *Server) {
+				a, _ := s.Agents.GetAgent(context.Background(), id("sensor-2"))
+				if err := s.Presence.Seen(context.Background(), a); err != nil {
+					t.Fatal(err)
+				}
+			},
+			check: func(t *testing.T, _ *httptest.ResponseRecorder, p page) {
+				if p.Data[0].LastSeenAt != nil || p.Data[1].LastSeenAt == nil {
+					t.Errorf("last_seen_at = %v, %v; want só o de sensor-2", p.Data[0].LastSeenAt, p.Data[1].LastSeenAt)
+				}
+			},
+		},
+		{
+			name: "msgpack", path: "/api/v1/agents", accept: "application/msgpack", status: http.StatusOK,
+			check: func(t *testing.T, w *httptest.ResponseRecorder, _ page) {
+				if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/msgpack") {
+					t.Errorf("Content-Type = %q", ct)
+				}
+			},
+		},
+		{
+			name: "ids", path: "/api/v1/agents?ids=" + id("sensor-1") + "," + id("missing") + "," + id("bus-2"), status: http.StatusOK,
+			check: func(t *testing.T, _ *httptest.ResponseRecorder, p page) {
+				if p.names() != "sensor-1,bus-2" || len(p.NotFound) != 1 || p.NotFound[0] != id("missing") {
+					t.Errorf("names %s, not_found %v", p.names(), p.NotFound)
+				}
+			},
+		},
+		{
+			name: "batch get", method: http.MethodPost, path: "/api/v1/agents/batch-get", body: `{"ids":["` + id("bus-3") + `","` + id("bus-1") + `"]}`, status: http.StatusOK,
+			check: func(t *testing.T, _ *httptest.ResponseRecorder, p page) {
+				if p.names() != "bus-3,bus-1" || len(p.NotFound) != 0 {
+					t.Errorf("names %s, not_found %v", p.names(), p.NotFound)
+				}
+			},
+		},
+		{name: "batch get without ids", method: http.MethodPost, path: "/api/v1/agents/batch-get", body: `{}`, status: http.StatusBadRequest},
+		{
+			name: "simulation agents", path: "/api/v1/simulations/sim-a/agents?status=active", status: http.StatusOK,
+			check: func(t *testing.T, _ *httptest.ResponseRecorder, p page) {
+				if !p.Complete || p.Count != 2 || p.names() != "bus-1,bus-3" {
+					t.Errorf("complete %v, count %d, names %s", p.Complete, p.Count, p.names())
+				}
+			},
+		},
+		{
+			name: "database unavailable", path: "/api/v1/agents", status: http.StatusInternalServerError,
+			setup: func(t *testing.T, s *Server) { s.Agents.Err = fake.ErrUnavailable },
+			check: func(t *testing.T, _ *httptest.ResponseRecorder, p page) {
+				if p.Error != "internal error" {
+					t.Errorf("error = %q", p.Error)
+				}
+			},
+		},
+		{name: "admin events without admin", path: "/api/v1/events?topic=admin", status: http.StatusForbidden},
+		{name: "admin events", path: "/api/v1/events?topic=admin", admin: true, status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewTestServer(t)
+			seed(s)
+			if tt.setup != nil {
+				tt.setup(t, s)
+			}
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, tt.path, strings.NewReader(tt.body))
+			if tt.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			w := s.Do(req, tt.admin)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			if tt.check == nil {
+				return
+			}
+			var p page
+			if strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+				if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+					t.Fatalf("JSON inválido: %v\n%s", err, w.Body.String())
+				}
+			}
+			tt.check(t, w, p)
+		})
+	}
+}
+
+// TestMaintenanceReadOnly liga o modo read_only pela API e confere o que
+// passa: leituras e o próprio interruptor sim, escritas não.
+func TestMaintenanceReadOnly(t *testing.T) {
+	s := NewTestServer(t)
+	seed(s)
+	set := func(mode string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/api/v1/admin/maintenance", strings.NewReader(`{"mode":"`+mode+`","retry_after_seconds":12}`))
+		req.Header.Set("Content-Type", "application/json")
+		return s.Do(req, true)
+	}
+	if w := set("read_only"); w.Code != http.StatusOK {
+		t.Fatalf("PUT maintenance: %d %s", w.Code, w.Body.String())
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+	}{
+		{"read", http.MethodGet, "/api/v1/agents", http.StatusOK},
+		{"write", http.MethodPost, "/api/v1/agents/batch-get", http.StatusServiceUnavailable},
+		{"switch", http.MethodGet, "/api/v1/admin/maintenance", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(`{"ids":["`+id("bus-1")+`"]}`))
+			req.Header.Set("Content-Type", "application/json")
+			w := s.Do(req, true)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d", w.Code, tt.status)
+			}
+			if tt.status == http.StatusServiceUnavailable && w.Header().Get("Retry-After") != "12" {
+				t.Errorf("Retry-After = %q", w.Header().Get("Retry-After"))
+			}
+		})
+	}
+
+	// Outra réplica, no mesmo Redis, vê o modo na próxima leitura.
+	replica := maintenance.New(s.Redis, maintenance.Config{})
+	if err := replica.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if replica.State().Mode != maintenance.ModeReadOnly {
+		t.Error("modo read_only não lido do Redis")
+	}
+	if w := set("off"); w.Code != http.StatusOK {
+		t.Fatalf("PUT maintenance off: %d", w.Code)
+	}
+	if len(s.Recent.List("admin", "maintenance.changed", 10)) != 2 {
+		t.Error("mudanças de modo não publicadas")
+	}
+}
@@ -0,0 +1,316 @@
+package apitest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"smart-city-microservices/internal/openapi"
+)
+
+// operation é uma rota do servidor de teste com os parâmetros e o corpo
+// descritos em openapi.yaml, com as referências já resolvidas.
+type operation struct {
+	method string
+	// path está no formato do OpenAPI: /api/v1/simulations/{id}/agents.
+	path   string
+	params []parameter
+	// body é o schema do corpo JSON; nil sem corpo.
+	body map[string]interface{}
+}
+
+type parameter struct {
+	name     string
+	in       string
+	required bool
+	schema   map[string]interface{}
+}
+
+var ginParam = regexp.MustCompile(`[:*]([A-Za-z_]+)`)
+
+// operations lê de openapi.yaml as operações das rotas de s, em ordem, e
+// retorna também a especificação, para resolver as referências dos
+// schemas. Uma rota fora da especificação falha o teste: o harness só gera
+// requisições a partir dela.
+func operations(tb testing.TB, s *Server) ([]operation, map[string]interface{}) {
+	tb.Helper()
+	raw, err := openapi.JSON()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var spec map[string]interface{}
+	if err := json.Unmarshal(raw, &spec); err != nil {
+		tb.Fatal(err)
+	}
+	paths, _ := spec["paths"].(map[string]interface{})
+	var out []operation
+	for _, r := range s.Router.Routes() {
+		path := ginParam.ReplaceAllString(r.Path, "{$1}")
+		item, _ := paths[path].(map[string]interface{})
+		op, ok := item[strings.ToLower(r.Method)].(map[string]interface{})
+		if !ok {
+			tb.Fatalf("%s %s não está em openapi.yaml", r.Method, path)
+		}
+		o := operation{method: r.Method, path: path}
+		params, _ := item["parameters"].([]interface{})
+		params = append(params[:len(params):len(params)], sliceOf(op["parameters"])...)
+		for _, p := range params {
+			p := resolve(spec, p)
+			schema := resolve(spec, p["schema"])
+			name, _ := p["name"].(string)
+			in, _ := p["in"].(string)
+			required, _ := p["required"].(bool)
+			o.params = append(o.params, parameter{name: name, in: in, required: required, schema: schema})
+		}
+		if rb := resolve(spec, op["requestBody"]); rb != nil {
+			content, _ := rb["content"].(map[string]interface{})
+			media, _ := content["application/json"].(map[string]interface{})
+			o.body = resolve(spec, media["schema"])
+		}
+		out = append(out, o)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].path+" "+out[i].method < out[j].path+" "+out[j].method
+	})
+	return out, spec
+}
+
+func sliceOf(v interface{}) []interface{} {
+	s, _ := v.([]interface{})
+	return s
+}
+
+// resolve segue o $ref de v, se houver, dentro de spec.
+func resolve(spec map[string]interface{}, v interface{}) map[string]interface{} {
+	m, _ := v.(map[string]interface{})
+	for i := 0; m != nil && i < 10; i++ {
+		ref, ok := m["$ref"].(string)
+		if !ok {
+			return m
+		}
+		var node interface{} = spec
+		for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+			parent, _ := node.(map[string]interface{})
+			node = parent[part]
+		}
+		m, _ = node.(map[string]interface{})
+	}
+	return m
+}
+
+// generator tira as escolhas da geração dos bytes do fuzzer; esgotados, as
+// escolhas são sempre a primeira opção.
+type generator struct {
+	spec map[string]interface{}
+	data []byte
+}
+
+func (g *generator) byte() byte {
+	if len(g.data) == 0 {
+		return 0
+	}
+	b := g.data[0]
+	g.data = g.data[1:]
+	return b
+}
+
+func (g *generator) intn(n int) int {
+	return int(g.byte()) % n
+}
+
+// weird são os valores que já derrubaram handlers em outros serviços: ids
+// nulos, números enormes, texto fora do ASCII e tipos trocados.
+var weird = []interface{}{
+	nil, "", " ", "null", "🚌🚦 ônibus", strings.Repeat("x", 5000), "../../etc/passwd", "%00", "\x00",
+	-1, 0, math.MaxInt64, math.MinInt64, 1e308, -1e308, "9223372036854775808", "-0", "NaN", "1e400",
+	true, []interface{}{}, []interface{}{nil}, map[string]interface{}{}, map[string]interface{}{"": nil},
+	"00000000-0000-0000-0000-000000000000", "2026-13-45T99:99:99Z",
+}
+
+// value gera um valor para schema: quase sempre do tipo pedido, às vezes um
+// dos valores de weird.
+func (g *generator) value(schema map[string]interface{}, depth int) interface{} {
+	schema = resolve(g.spec, schema)
+	if schema == nil || depth > 4 {
+		return nil
+	}
+	if g.intn(6) == 0 {
+		return weird[g.intn(len(weird))]
+	}
+	for _, key := range []string{"oneOf", "anyOf", "allOf"} {
+		if alts := sliceOf(schema[key]); len(alts) > 0 {
+			alt, _ := alts[g.intn(len(alts))].(map[string]interface{})
+			return g.value(alt, depth+1)
+		}
+	}
+	if enum := sliceOf(schema["enum"]); len(enum) > 0 {
+		return enum[g.intn(len(enum))]
+	}
+	switch schema["type"] {
+	case "integer":
+		return []interface{}{0, 1, 2, -1, 20, 100, 101, 1000, math.MaxInt32, math.MaxInt64}[g.intn(10)]
+	case "number":
+		return []interface{}{0.0, 0.5, -23.55, -46.63, 90.0001, 180.5, 1e-9, 1e300}[g.intn(8)]
+	case "boolean":
+		return g.intn(2) == 0
+	case "array":
+		items, _ := schema["items"].(map[string]interface{})
+		out := []interface{}{}
+		for n := g.intn(4); n > 0; n-- {
+			out = append(out, g.value(items, depth+1))
+		}
+		return out
+	case "object":
+		out := map[string]interface{}{}
+		props, _ := schema["properties"].(map[string]interface{})
+		names := make([]string, 0, len(props))
+		for name := range props {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if g.intn(4) != 0 {
+				prop, _ := props[name].(map[string]interface{})
+				out[name] = g.value(prop, depth+1)
+			}
+		}
+		if g.intn(8) == 0 {
+			out["unexpected"] = weird[g.intn(len(weird))]
+		}
+		return out
+	}
+	return g.text(schema)
+}
+
+// text gera uma string: um id do seed, um valor do formato ou os próprios
+// bytes do fuzzer.
+func (g *generator) text(schema map[string]interface{}) string {
+	switch g.intn(4) {
+	case 0:
+		return id([]string{"bus-1", "bus-2", "sensor-1", "missing"}[g.intn(4)])
+	case 1:
+		return []string{"sim-a", "sim-b", "proj-1", "linha-101,noturno", "bus"}[g.intn(5)]
+	case 2:
+		switch schema["format"] {
+		case "uuid":
+			return id(fmt.Sprint(g.byte()))
+		case "date-time":
+			return "2026-03-04T05:00:00Z"
+		}
+	}
+	n := g.intn(32)
+	if n > len(g.data) {
+		n = len(g.data)
+	}
+	s := string(g.data[:n])
+	g.data = g.data[n:]
+	return s
+}
+
+// param converte o valor gerado para a query ou o caminho; ok é falso para
+// omitir o parâmetro.
+func param(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case nil:
+		return "", false
+	case string:
+		return v, true
+	case []interface{}:
+		parts := make([]string, len(v))
+		for i, x := range v {
+			parts[i], _ = param(x)
+		}
+		return strings.Join(parts, ","), true
+	case map[string]interface{}:
+		raw, _ := json.Marshal(v)
+		return string(raw), true
+	}
+	return fmt.Sprint(v), true
+}
+
+// request gera uma requisição para op.
+func (g *generator) request(op operation) *http.Request {
+	path := op.path
+	query := url.Values{}
+	header := http.Header{}
+	for _, p := range op.params {
+		v, ok := param(g.value(p.schema, 0))
+		if !ok && p.in == "path" {
+			v, ok = "", true
+		}
+		if !ok || (!p.required && g.intn(3) == 0) {
+			continue
+		}
+		switch p.in {
+		case "path":
+			path = strings.ReplaceAll(path, "{"+p.name+"}", url.PathEscape(v))
+		case "query":
+			query.Set(p.name, v)
+		case "header":
+			header.Set(p.name, strings.Map(func(r rune) rune {
+				if r < ' ' || r == 0x7f {
+					return -1
+				}
+				return r
+			}, v))
+		}
+	}
+	var body []byte
+	if op.body != nil {
+		switch g.intn(8) {
+		case 0:
+			body = []byte(`{"ids":`)
+		case 1:
+			body = []byte(`null`)
+		default:
+			body, _ = json.Marshal(g.value(op.body, 0))
+		}
+	}
+	target := path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req := httptest.NewRequest(op.method, target, bytes.NewReader(body))
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", []string{"", "application/json", "application/msgpack", "application/x-protobuf", "text/csv", "*/*"}[g.intn(6)])
+	return req
+}
+
+// FuzzAPI gera, a partir de openapi.yaml, requisições às rotas do servidor
+// de teste, com parâmetros e corpos do tipo documentado ou fora dele, e
+// confere que nenhuma responde 500: uma entrada inválida é um 4xx, e um
+// pânico vira 500 no gin.Recovery. O primeiro byte escolhe a operação; o
+// corpus inicial passa por todas, também em go test sem -fuzz.
+func FuzzAPI(f *testing.F) {
+	ops, spec := operations(f, NewTestServer(f))
+	for i := range ops {
+		for _, tail := range [][]byte{nil, {1, 1, 1, 1, 1, 1, 1, 1}, {5, 2, 3, 7, 0, 4, 9, 1, 6, 2}, bytes.Repeat([]byte{0xff}, 16)} {
+			f.Add(append([]byte{byte(i)}, tail...), i%2 == 0)
+		}
+	}
+	f.Fuzz(func(t *testing.T, data []byte, admin bool) {
+		s := NewTestServer(t)
+		seed(s)
+		g := &generator{spec: spec, data: data}
+		op := ops[g.intn(len(ops))]
+		req := g.request(op)
+		target := req.URL.String()
+		w := s.Do(req, admin)
+		if w.Code >= http.StatusInternalServerError {
+			t.Fatalf("%s %s: status %d: %s", op.method, target, w.Code, w.Body.String())
+		}
+	})
+}
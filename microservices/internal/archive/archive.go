@@ -0,0 +1,191 @@
+// Package archive move simulações encerradas para o armazenamento de
+// objetos: as linhas da simulação e de todas as tabelas que a referenciam
+// viram um JSONL gzipado, e no banco fica só o registro em
+// simulation_archives.
+package archive
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/lib/pq"
+
+	"smart-city-microservices/internal/instrument"
+	"smart-city-microservices/internal/storage"
+)
+
+// Simulation é uma simulação encerrada, candidata a arquivamento.
+type Simulation struct {
+	ID        string    `json:"id"`
+	ProjectID string    `json:"project_id,omitempty"`
+	Name      string    `json:"name"`
+	Status    string    `json:"status"`
+	EndedAt   time.Time `json:"ended_at"`
+}
+
+// Result descreve um arquivamento concluído.
+type Result struct {
+	Key  string `json:"key"`
+	Size int64  `json:"size"`
+	Rows int64  `json:"rows"`
+	// Tables são as linhas arquivadas de cada tabela.
+	Tables map[string]int64 `json:"tables,omitempty"`
+}
+
+// Line é uma linha do arquivo: a tabela de origem e a linha como JSON.
+type Line struct {
+	Table string          `json:"table"`
+	Row   json.RawMessage `json:"row"`
+}
+
+// Archiver arquiva simulações.
+type Archiver struct {
+	db    *instrument.DB
+	store storage.Store
+}
+
+// New cria o arquivador.
+func New(db *sql.DB, store storage.Store) *Archiver {
+	return &Archiver{db: instrument.NewDB(db), store: store}
+}
+
+// Key é a chave do arquivo de uma simulação no armazenamento.
+func Key(simulationID string) string {
+	return "archives/simulations/" + simulationID + ".jsonl.gz"
+}
+
+// Candidates retorna as simulações encerradas antes de before.
+func (a *Archiver) Candidates(ctx context.Context, before time.Time) ([]Simulation, error) {
+	rows, err := a.db.Query(ctx, "archive.candidates", `
+		SELECT id, COALESCE(project_id, ''), name, status, ended_at FROM simulations
+		WHERE ended_at IS NOT NULL AND ended_at < $1 AND status NOT IN ('created', 'running')
+		ORDER BY ended_at`, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Simulation
+	for rows.Next() {
+		var s Simulation
+		if err := rows.Scan(&s.ID, &s.ProjectID, &s.Name, &s.Status, &s.EndedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// Archive grava o arquivo da simulação e, só depois de ele estar no
+// armazenamento, registra o arquivamento e remove a simulação (as tabelas
+// dependentes caem em cascata) numa única instrução.
+func (a *Archiver) Archive(ctx context.Context, sim Simulation) (Result, error) {
+	res := Result{Key: Key(sim.ID), Tables: map[string]int64{}}
+	refs, err := a.references(ctx)
+	if err != nil {
+		return res, err
+	}
+
+	pr, pw := io.Pipe()
+	written := make(chan int64, 1)
+	go func() {
+		n, err := a.write(ctx, pw, sim.ID, refs, res.Tables)
+		written <- n
+		pw.CloseWithError(err)
+	}()
+	if err := a.store.Put(ctx, res.Key, pr, -1, "application/gzip"); err != nil {
+		pr.CloseWithError(err)
+		return res, fmt.Errorf("archive: falha ao gravar %s: %w", res.Key, err)
+	}
+	res.Rows = <-written
+	obj, err := a.store.Stat(ctx, res.Key)
+	if err != nil {
+		return res, err
+	}
+	res.Size = obj.Size
+
+	_, err = a.db.Exec(ctx, "archive.record", `
+		WITH archived AS (
+			INSERT INTO simulation_archives (simulation_id, project_id, name, status, ended_at, object_key, size_bytes, row_count)
+			VALUES ($1, NULLIF($8, ''), $2, $3, $4, $5, $6, $7)
+			RETURNING simulation_id
+		)
+		DELETE FROM simulations WHERE id IN (SELECT simulation_id FROM archived)`,
+		sim.ID, sim.Name, sim.Status, sim.EndedAt, res.Key, res.Size, res.Rows, sim.ProjectID)
+	return res, err
+}
+
+// reference é uma coluna que aponta para simulations(id).
+type reference struct {
+	table, column string
+}
+
+// references descobre as tabelas que referenciam simulations, para que
+// tabelas novas entrem no arquivo sem mudar este pacote.
+func (a *Archiver) references(ctx context.Context) ([]reference, error) {
+	rows, err := a.db.Query(ctx, "archive.references", `
+		SELECT t.relname, a.attname
+		FROM pg_constraint c
+		JOIN pg_class t ON t.oid = c.conrelid
+		JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = c.conkey[1]
+		WHERE c.contype = 'f' AND c.confrelid = 'simulations'::regclass AND cardinality(c.conkey) = 1
+		ORDER BY t.relname, a.attname`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []reference
+	for rows.Next() {
+		var r reference
+		if err := rows.Scan(&r.table, &r.column); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// write escreve a simulação e as linhas que a referenciam em w, gzipado,
+// contando as linhas de cada tabela em tables.
+func (a *Archiver) write(ctx context.Context, w io.Writer, simulationID string, refs []reference, tables map[string]int64) (int64, error) {
+	gz := gzip.NewWriter(w)
+	enc := json.NewEncoder(gz)
+	var n int64
+	dump := func(table, column string) error {
+		rows, err := a.db.Query(ctx, "archive.dump_"+table, fmt.Sprintf(
+			`SELECT row_to_json(t) FROM %s t WHERE %s = $1`, pq.QuoteIdentifier(table), pq.QuoteIdentifier(column)),
+			simulationID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var row []byte
+			if err := rows.Scan(&row); err != nil {
+				return err
+			}
+			if err := enc.Encode(Line{Table: table, Row: row}); err != nil {
+				return err
+			}
+			n++
+			tables[table]++
+		}
+		return rows.Err()
+	}
+
+	if err := dump("simulations", "id"); err != nil {
+		return n, err
+	}
+	for _, r := range refs {
+		if err := dump(r.table, r.column); err != nil {
+			return n, fmt.Errorf("archive: falha ao ler %s: %w", r.table, err)
+		}
+	}
+	return n, gz.Close()
+}
@@ -0,0 +1,24 @@
+// Package audit registra ações administrativas em um log de auditoria estruturado.
+package audit
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/logging"
+)
+
+// Record registra uma ação administrativa com o principal que a executou.
+// As entradas carregam audit=true para que possam ser roteadas separadamente.
+func Record(ctx context.Context, action string, fields logrus.Fields) {
+	entry := logging.FromContext(ctx).WithFields(logrus.Fields{
+		"audit":  true,
+		"action": action,
+	})
+	if p := auth.FromContext(ctx); p != nil {
+		entry = entry.WithField("actor", p.Subject)
+	}
+	entry.WithFields(fields).Info("Ação administrativa registrada")
+}
@@ -0,0 +1,19 @@
+package auth
+
+import "github.com/gin-gonic/gin"
+
+// ClientCertificate autentica requisições que chegaram com um certificado de
+// cliente verificado (mTLS), usando o CN como subject e os papéis informados.
+func ClientCertificate(roles []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if FromGin(c) == nil && c.Request.TLS != nil && len(c.Request.TLS.VerifiedChains) > 0 {
+			leaf := c.Request.TLS.VerifiedChains[0][0]
+			subject := leaf.Subject.CommonName
+			if subject == "" && len(leaf.DNSNames) > 0 {
+				subject = leaf.DNSNames[0]
+			}
+			SetPrincipal(c, &Principal{Subject: "cert:" + subject, Roles: roles})
+		}
+		c.Next()
+	}
+}
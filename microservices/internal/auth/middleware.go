@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StaticTokenSubject identifica o principal autenticado pelo token estático.
+const StaticTokenSubject = "static-token"
+
+// StaticToken autentica como admin requisições que apresentam o token
+// estático (Authorization: Bearer ou X-Admin-Token). Requisições sem o token
+// seguem adiante sem principal para que outros autenticadores possam agir.
+func StaticToken(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		AuthenticateStaticToken(c, token)
+		c.Next()
+	}
+}
+
+// AuthenticateStaticToken associa um principal admin à requisição quando ela
+// apresenta o token estático e ainda não está autenticada.
+func AuthenticateStaticToken(c *gin.Context, token string) bool {
+	if token == "" || FromGin(c) != nil {
+		return false
+	}
+	given := c.GetHeader("X-Admin-Token")
+	if given == "" {
+		given = strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	}
+	if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+		return false
+	}
+	SetPrincipal(c, &Principal{Subject: StaticTokenSubject, Roles: []string{RoleAdmin}})
+	return true
+}
+
+// RequireRole exige um principal autenticado com o papel informado.
+func RequireRole(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p := FromGin(c)
+		if p == nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+			return
+		}
+		if !p.HasRole(role) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "role " + role + " required"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireScope exige um principal autenticado com o escopo informado.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p := FromGin(c)
+		if p == nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+			return
+		}
+		if !p.HasScope(scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "scope " + scope + " required"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// Me responde GET /me com o principal resolvido para a requisição, para que
+// o frontend saiba quais papéis e projetos o usuário tem.
+func Me(c *gin.Context) {
+	p := FromGin(c)
+	if p == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	c.JSON(http.StatusOK, p)
+}
@@ -0,0 +1,124 @@
+// Package auth define o principal autenticado e helpers de autorização.
+package auth
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Papéis conhecidos pelo serviço.
+const (
+	RoleAdmin    = "admin"
+	RoleOperator = "operator"
+	RoleViewer   = "viewer"
+)
+
+// Escopos concedidos a chaves de API de serviços, além dos papéis.
+// ScopeBroadcast libera POST /api/v1/internal/broadcast.
+const (
+	ScopeBroadcast = "broadcast"
+)
+
+const principalKey = "auth.principal"
+
+type ctxKey struct{}
+
+// Principal identifica quem está fazendo a requisição.
+type Principal struct {
+	Subject  string   `json:"subject"`
+	Roles    []string `json:"roles"`
+	Projects []string `json:"projects,omitempty"`
+	// Scopes são as permissões pontuais de uma chave de API, fora dos
+	// papéis.
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// HasRole indica se o principal possui o papel informado.
+// Administradores possuem implicitamente todos os papéis.
+func (p *Principal) HasRole(role string) bool {
+	if p == nil {
+		return false
+	}
+	for _, r := range p.Roles {
+		if r == role || r == RoleAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope indica se o principal possui o escopo informado. Diferente dos
+// papéis, o admin não tem escopos implícitos: cada um é concedido à chave.
+func (p *Principal) HasScope(scope string) bool {
+	if p == nil {
+		return false
+	}
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// InProject indica se o principal pode agir sobre os recursos do projeto.
+// Administradores e principais sem projetos, como as requisições sem
+// autenticação, não têm restrição de projeto.
+func (p *Principal) InProject(projectID string) bool {
+	if p == nil || len(p.Projects) == 0 || p.HasRole(RoleAdmin) {
+		return true
+	}
+	for _, id := range p.Projects {
+		if id == projectID {
+			return true
+		}
+	}
+	return false
+}
+
+// HasProjectRole indica se o principal possui o papel no projeto. Diferente
+// de InProject, o administrador com projetos não tem o papel nos projetos
+// que não são seus; só o principal sem projetos, como o token estático, o
+// tem em todos.
+func (p *Principal) HasProjectRole(projectID, role string) bool {
+	if !p.HasRole(role) {
+		return false
+	}
+	if len(p.Projects) == 0 {
+		return true
+	}
+	for _, id := range p.Projects {
+		if id == projectID {
+			return true
+		}
+	}
+	return false
+}
+
+// SetPrincipal associa o principal ao contexto do Gin e ao context.Context da requisição.
+func SetPrincipal(c *gin.Context, p *Principal) {
+	c.Set(principalKey, p)
+	c.Request = c.Request.WithContext(WithPrincipal(c.Request.Context(), p))
+}
+
+// WithPrincipal retorna um contexto carregando o principal.
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, ctxKey{}, p)
+}
+
+// FromContext retorna o principal do contexto, se houver.
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(ctxKey{}).(*Principal)
+	return p
+}
+
+// FromGin retorna o principal associado à requisição, se houver.
+func FromGin(c *gin.Context) *Principal {
+	if v, ok := c.Get(principalKey); ok {
+		if p, ok := v.(*Principal); ok {
+			return p
+		}
+	}
+	return FromContext(c.Request.Context())
+}
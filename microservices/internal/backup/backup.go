@@ -0,0 +1,148 @@
+// Package backup exporta os dados de um projeto (simulações, agentes,
+// cenários e agendamentos; não os eventos) para um arquivo versionado no
+// armazenamento de objetos e os reimporta no mesmo projeto ou em outro.
+//
+// O arquivo é um JSONL gzipado: a primeira linha é o Header e as demais,
+// uma linha de tabela por Line, na ordem de Kinds, que é a ordem em que a
+// restauração as grava. Cada tipo é restaurado numa transação própria.
+package backup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Format identifica os arquivos de backup; Version é a versão gravada
+// hoje. A restauração aceita as versões até a atual.
+const (
+	Format  = "smartcity.project-backup"
+	Version = 1
+)
+
+// Eventos publicados em events.TopicAdmin.
+const (
+	EventCreated  = "project.backup_created"
+	EventRestored = "project.restored"
+)
+
+// ErrNotFound indica que o backup não existe.
+var ErrNotFound = errors.New("backup not found")
+
+// Tipos de entidade do backup.
+const (
+	KindSimulations = "simulations"
+	KindAgents      = "agents"
+	KindScenarios   = "scenarios"
+	KindSchedules   = "schedules"
+)
+
+// Kinds são os tipos de entidade na ordem de gravação: cada um só
+// referencia os anteriores.
+var Kinds = []string{KindSimulations, KindAgents, KindScenarios, KindSchedules}
+
+// tables são as tabelas de cada tipo.
+var tables = map[string]string{
+	KindSimulations: "simulations",
+	KindAgents:      "agents",
+	KindScenarios:   "scenarios",
+	KindSchedules:   "scheduled_actions",
+}
+
+// Backup é um backup registrado em project_backups.
+type Backup struct {
+	ID            string           `json:"id"`
+	ProjectID     string           `json:"project_id"`
+	Key           string           `json:"key"`
+	FormatVersion int              `json:"format_version"`
+	Size          int64            `json:"size"`
+	Counts        map[string]int64 `json:"counts"`
+	CreatedBy     string           `json:"created_by,omitempty"`
+	CreatedAt     time.Time        `json:"created_at"`
+}
+
+// Key é a chave do arquivo de um backup no armazenamento.
+func Key(projectID, backupID string) string {
+	return "backups/projects/" + projectID + "/" + backupID + ".jsonl.gz"
+}
+
+// Header é a primeira linha do arquivo.
+type Header struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	BackupID  string    `json:"backup_id"`
+	ProjectID string    `json:"project_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// check recusa arquivos de outro formato ou de uma versão mais nova.
+func (h Header) check() error {
+	if h.Format != Format {
+		return &FormatError{Reason: fmt.Sprintf("unknown backup format %q", h.Format)}
+	}
+	if h.Version < 1 || h.Version > Version {
+		return &FormatError{Reason: fmt.Sprintf("unsupported backup version %d (this service reads up to %d)", h.Version, Version)}
+	}
+	return nil
+}
+
+// FormatError é um arquivo de backup que não pode ser lido.
+type FormatError struct {
+	Reason string
+}
+
+func (e *FormatError) Error() string { return e.Reason }
+
+// Line é uma linha de entidade do arquivo.
+type Line struct {
+	Kind string                     `json:"kind"`
+	Row  map[string]json.RawMessage `json:"row"`
+}
+
+// Counts resume a restauração de um tipo de entidade. Skipped são as
+// linhas que não foram gravadas: cenários que já existem, na restauração
+// em outro projeto, e linhas cujas referências não estão no backup.
+type Counts struct {
+	Created int64 `json:"created"`
+	Updated int64 `json:"updated"`
+	Skipped int64 `json:"skipped"`
+}
+
+// Summary é o resultado de uma restauração. Com Failed preenchido, os
+// tipos anteriores foram gravados e os seguintes não foram tentados.
+type Summary struct {
+	BackupID        string            `json:"backup_id"`
+	SourceProjectID string            `json:"source_project_id"`
+	ProjectID       string            `json:"project_id"`
+	Remapped        bool              `json:"remapped"`
+	Entities        map[string]Counts `json:"entities"`
+	Failed          string            `json:"failed,omitempty"`
+	// Changes só vem de Plan: os ids de destino criados e atualizados de
+	// cada tipo.
+	Changes map[string]Changes `json:"changes,omitempty"`
+}
+
+// Changes são os ids afetados de um tipo no ensaio da restauração.
+type Changes struct {
+	Created []string `json:"created"`
+	Updated []string `json:"updated"`
+}
+
+// remapper traduz os ids das entidades do projeto de origem para o de
+// destino. Os ids novos derivam do projeto de destino e do id original,
+// de modo que restaurar de novo o mesmo backup no mesmo destino atualiza
+// as entidades em vez de duplicá-las. Sem remap, os ids são os originais.
+type remapper struct {
+	target string
+	remap  bool
+}
+
+func (m remapper) id(old string) string {
+	if !m.remap {
+		return old
+	}
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte(m.target+"/"+old)).String()
+}
@@ -0,0 +1,190 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/audit"
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/dryrun"
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/logging"
+	"smart-city-microservices/internal/storage"
+)
+
+// Handler expõe o backup e a restauração dos projetos.
+type Handler struct {
+	manager   *Manager
+	publisher events.Publisher
+}
+
+// NewHandler cria o handler de backups.
+func NewHandler(manager *Manager, publisher events.Publisher) *Handler {
+	return &Handler{manager: manager, publisher: publisher}
+}
+
+// Create responde POST /projects/:id/backups: grava o backup do projeto e
+// responde 201 com o registro.
+func (h *Handler) Create(c *gin.Context) {
+	projectID := c.Param("id")
+	if !h.accessible(c, projectID) {
+		return
+	}
+	ctx := c.Request.Context()
+	var by string
+	if p := auth.FromGin(c); p != nil {
+		by = p.Subject
+	}
+	b, err := h.manager.Create(ctx, projectID, by)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	audit.Record(ctx, EventCreated, logrus.Fields{
+		"project_id": projectID,
+		"backup_id":  b.ID,
+		"size":       b.Size,
+		"counts":     b.Counts,
+	})
+	h.publisher.Publish(ctx, events.New(events.TopicAdmin, EventCreated, events.ProjectBackupCreatedV1{
+		BackupID:  b.ID,
+		ProjectID: projectID,
+		Size:      b.Size,
+		Counts:    b.Counts,
+		CreatedBy: by,
+	}))
+	c.JSON(http.StatusCreated, b)
+}
+
+// List responde GET /projects/:id/backups com os backups do projeto, do
+// mais recente ao mais antigo.
+func (h *Handler) List(c *gin.Context) {
+	projectID := c.Param("id")
+	if !h.accessible(c, projectID) {
+		return
+	}
+	list, err := h.manager.List(c.Request.Context(), projectID)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": list})
+}
+
+// Restore responde POST /projects/:id/restore?backup_id=: reimporta o
+// backup, deste ou de outro projeto, no projeto :id e responde o Summary.
+// Com simulações do projeto em execução a restauração é recusada (409),
+// salvo com force=true. Com X-Dry-Run: true, a restauração é ensaiada
+// (Manager.Plan) e a resposta traz também os ids afetados.
+func (h *Handler) Restore(c *gin.Context) {
+	target := c.Param("id")
+	backupID := c.Query("backup_id")
+	if backupID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "backup_id is required"})
+		return
+	}
+	force := false
+	if v := c.Query("force"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid force: must be true or false"})
+			return
+		}
+		force = b
+	}
+	if !h.accessible(c, target) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	b, err := h.manager.Get(ctx, backupID)
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	if !h.accessible(c, b.ProjectID) {
+		return
+	}
+	active, err := h.manager.ActiveSimulations(ctx, target)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	if active > 0 && !force {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":              fmt.Sprintf("project %s has %d active simulations; stop them or set force", target, active),
+			"active_simulations": active,
+		})
+		return
+	}
+
+	restore := h.manager.Restore
+	if dryrun.Enabled(ctx) {
+		restore = h.manager.Plan
+	}
+	s, err := restore(ctx, b, target)
+	var fe *FormatError
+	switch {
+	case errors.As(err, &fe):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fe.Error()})
+		return
+	case errors.Is(err, storage.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "backup file not found"})
+		return
+	case err != nil && s != nil:
+		logging.FromContext(ctx).WithError(err).WithField("backup_id", b.ID).Error("Restauração do projeto interrompida")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "restore failed at " + s.Failed, "summary": s})
+		return
+	case err != nil:
+		h.internalError(c, err)
+		return
+	}
+	if dryrun.Enabled(ctx) {
+		dryrun.JSON(c, s)
+		return
+	}
+
+	entities := map[string]events.RestoreCountsV1{}
+	for kind, n := range s.Entities {
+		entities[kind] = events.RestoreCountsV1{Created: n.Created, Updated: n.Updated, Skipped: n.Skipped}
+	}
+	audit.Record(ctx, EventRestored, logrus.Fields{
+		"project_id":        target,
+		"source_project_id": b.ProjectID,
+		"backup_id":         b.ID,
+		"forced":            force && active > 0,
+		"entities":          s.Entities,
+	})
+	h.publisher.Publish(ctx, events.New(events.TopicAdmin, EventRestored, events.ProjectRestoredV1{
+		BackupID:        b.ID,
+		SourceProjectID: b.ProjectID,
+		ProjectID:       target,
+		Remapped:        s.Remapped,
+		Forced:          force && active > 0,
+		Entities:        entities,
+	}))
+	c.JSON(http.StatusOK, s)
+}
+
+// accessible responde 403 se o projeto não é acessível à credencial.
+func (h *Handler) accessible(c *gin.Context, projectID string) bool {
+	if auth.FromGin(c).InProject(projectID) {
+		return true
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "project " + projectID + " is not accessible"})
+	return false
+}
+
+func (h *Handler) internalError(c *gin.Context, err error) {
+	logging.FromContext(c.Request.Context()).WithError(err).Error("Erro no handler de backups")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
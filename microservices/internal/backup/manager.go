@@ -0,0 +1,182 @@
+package backup
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/google/uuid"
+
+	"smart-city-microservices/internal/instrument"
+	"smart-city-microservices/internal/storage"
+)
+
+// Manager cria, lista e restaura os backups dos projetos.
+type Manager struct {
+	db    *instrument.DB
+	store storage.Store
+}
+
+// New cria o gerenciador de backups.
+func New(db *sql.DB, store storage.Store) *Manager {
+	return &Manager{db: instrument.NewDB(db), store: store}
+}
+
+// dumps são as consultas que leem as linhas de cada tipo do projeto $1.
+// Agentes e agendamentos são do projeto pela simulação; cenários não têm
+// projeto e entram os executados em alguma simulação dele.
+var dumps = map[string]string{
+	KindSimulations: `SELECT row_to_json(s) FROM simulations s WHERE s.project_id = $1 ORDER BY s.created_at, s.id`,
+	KindAgents: `SELECT row_to_json(a) FROM agents a JOIN simulations s ON s.id = a.simulation_id
+		WHERE s.project_id = $1 ORDER BY a.created_at, a.id`,
+	KindScenarios: `SELECT row_to_json(sc) FROM scenarios sc WHERE sc.id IN (
+		SELECT e.scenario_id FROM scenario_executions e JOIN simulations s ON s.id = e.simulation_id
+		WHERE s.project_id = $1) ORDER BY sc.created_at, sc.id`,
+	KindSchedules: `SELECT row_to_json(sa) FROM scheduled_actions sa JOIN agents a ON a.id = sa.agent_id
+		JOIN simulations s ON s.id = a.simulation_id WHERE s.project_id = $1 ORDER BY sa.created_at, sa.id`,
+}
+
+// Create grava o backup do projeto no armazenamento e, só depois de ele
+// estar lá, o registra em project_backups.
+func (m *Manager) Create(ctx context.Context, projectID, createdBy string) (*Backup, error) {
+	b := &Backup{
+		ID:            uuid.NewString(),
+		ProjectID:     projectID,
+		FormatVersion: Version,
+		Counts:        map[string]int64{},
+		CreatedBy:     createdBy,
+		CreatedAt:     time.Now().UTC(),
+	}
+	b.Key = Key(projectID, b.ID)
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(m.write(ctx, pw, b))
+	}()
+	if err := m.store.Put(ctx, b.Key, pr, -1, "application/gzip"); err != nil {
+		pr.CloseWithError(err)
+		return nil, fmt.Errorf("backup: falha ao gravar %s: %w", b.Key, err)
+	}
+	obj, err := m.store.Stat(ctx, b.Key)
+	if err != nil {
+		return nil, err
+	}
+	b.Size = obj.Size
+
+	counts, err := json.Marshal(b.Counts)
+	if err != nil {
+		return nil, err
+	}
+	_, err = m.db.Exec(ctx, "backup.record", `
+		INSERT INTO project_backups (id, project_id, object_key, format_version, size_bytes, counts, created_by, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), $8)`,
+		b.ID, b.ProjectID, b.Key, b.FormatVersion, b.Size, counts, b.CreatedBy, b.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// write escreve o Header e as linhas de cada tipo em w, gzipado, contando
+// as linhas em b.Counts.
+func (m *Manager) write(ctx context.Context, w io.Writer, b *Backup) error {
+	gz := gzip.NewWriter(w)
+	enc := json.NewEncoder(gz)
+	if err := enc.Encode(Header{
+		Format:    Format,
+		Version:   Version,
+		BackupID:  b.ID,
+		ProjectID: b.ProjectID,
+		CreatedAt: b.CreatedAt,
+	}); err != nil {
+		return err
+	}
+	for _, kind := range Kinds {
+		if err := m.dump(ctx, enc, kind, b); err != nil {
+			return fmt.Errorf("backup: falha ao ler %s: %w", kind, err)
+		}
+	}
+	return gz.Close()
+}
+
+func (m *Manager) dump(ctx context.Context, enc *json.Encoder, kind string, b *Backup) error {
+	rows, err := m.db.Query(ctx, "backup.dump_"+kind, dumps[kind], b.ProjectID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	b.Counts[kind] = 0
+	for rows.Next() {
+		var raw []byte
+		if err := rows.Scan(&raw); err != nil {
+			return err
+		}
+		if err := enc.Encode(struct {
+			Kind string          `json:"kind"`
+			Row  json.RawMessage `json:"row"`
+		}{kind, raw}); err != nil {
+			return err
+		}
+		b.Counts[kind]++
+	}
+	return rows.Err()
+}
+
+const backupColumns = `id, project_id, object_key, format_version, size_bytes, counts, COALESCE(created_by, ''), created_at`
+
+func scanBackup(row interface{ Scan(...interface{}) error }) (*Backup, error) {
+	var b Backup
+	var counts []byte
+	err := row.Scan(&b.ID, &b.ProjectID, &b.Key, &b.FormatVersion, &b.Size, &counts, &b.CreatedBy, &b.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(counts, &b.Counts); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// List retorna os backups do projeto, do mais recente ao mais antigo.
+func (m *Manager) List(ctx context.Context, projectID string) ([]*Backup, error) {
+	rows, err := m.db.Query(ctx, "backup.list",
+		`SELECT `+backupColumns+` FROM project_backups WHERE project_id = $1 ORDER BY created_at DESC`, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []*Backup{}
+	for rows.Next() {
+		b, err := scanBackup(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, b)
+	}
+	return out, rows.Err()
+}
+
+// Get retorna o backup pelo id, de qualquer projeto.
+func (m *Manager) Get(ctx context.Context, id string) (*Backup, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrNotFound
+	}
+	return scanBackup(m.db.QueryRow(ctx, "backup.get",
+		`SELECT `+backupColumns+` FROM project_backups WHERE id = $1`, id))
+}
+
+// ActiveSimulations conta as simulações do projeto em execução.
+func (m *Manager) ActiveSimulations(ctx context.Context, projectID string) (int, error) {
+	var n int
+	err := m.db.QueryRow(ctx, "backup.active_simulations",
+		`SELECT count(*) FROM simulations WHERE project_id = $1 AND status = 'running'`, projectID).Scan(&n)
+	return n, err
+}
@@ -0,0 +1,273 @@
+package backup
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/lib/pq"
+
+	"smart-city-microservices/internal/instrument"
+)
+
+// Restore reimporta o backup b no projeto target, um tipo de entidade por
+// transação, na ordem de Kinds. No projeto de origem as entidades mantêm
+// os ids e as existentes são sobrescritas pelo backup; em outro projeto
+// recebem ids novos (ver remapper) e as referências entre elas são
+// traduzidas. Cenários não têm projeto: mantêm o id e, em outro projeto,
+// os que já existem ficam como estão. Na primeira falha, o Summary traz os
+// tipos já gravados e Failed.
+func (m *Manager) Restore(ctx context.Context, b *Backup, target string) (*Summary, error) {
+	return m.run(ctx, b, target, false)
+}
+
+// Plan ensaia Restore na transação de instrument.WithTx, que quem chama
+// desfaz depois: as gravações acontecem, e com elas as validações do banco,
+// mas nada fica. O Summary traz também os ids afetados em Changes.
+func (m *Manager) Plan(ctx context.Context, b *Backup, target string) (*Summary, error) {
+	if instrument.TxFrom(ctx) == nil {
+		return nil, errors.New("backup: ensaio da restauração sem transação")
+	}
+	return m.run(ctx, b, target, true)
+}
+
+func (m *Manager) run(ctx context.Context, b *Backup, target string, plan bool) (*Summary, error) {
+	rows, err := m.read(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+	mp := remapper{target: target, remap: target != b.ProjectID}
+	s := &Summary{
+		BackupID:        b.ID,
+		SourceProjectID: b.ProjectID,
+		ProjectID:       target,
+		Remapped:        mp.remap,
+		Entities:        map[string]Counts{},
+	}
+	if plan {
+		s.Changes = map[string]Changes{}
+	}
+	ids := map[string]map[string]string{}
+	for _, kind := range Kinds {
+		ids[kind] = map[string]string{}
+		var ch *Changes
+		if plan {
+			ch = &Changes{Created: []string{}, Updated: []string{}}
+		}
+		c, err := m.restore(ctx, kind, rows[kind], mp, ids, ch)
+		if err != nil {
+			s.Failed = kind
+			return s, fmt.Errorf("backup: falha ao restaurar %s: %w", kind, err)
+		}
+		s.Entities[kind] = c
+		if plan {
+			s.Changes[kind] = *ch
+		}
+	}
+	return s, nil
+}
+
+// read lê o arquivo do backup e agrupa as linhas por tipo.
+func (m *Manager) read(ctx context.Context, b *Backup) (map[string][]map[string]json.RawMessage, error) {
+	r, _, err := m.store.Get(ctx, b.Key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return decode(r)
+}
+
+// decode lê um arquivo de backup de r e agrupa as linhas por tipo. Tipos
+// desconhecidos são ignorados; um arquivo ilegível é um *FormatError.
+func decode(r io.Reader) (map[string][]map[string]json.RawMessage, error) {
+	gz, err := gzip.NewReader(bufio.NewReader(r))
+	if err != nil {
+		return nil, &FormatError{Reason: "backup file is not gzip: " + err.Error()}
+	}
+	dec := json.NewDecoder(gz)
+	var h Header
+	if err := dec.Decode(&h); err != nil {
+		return nil, &FormatError{Reason: "backup file has no header: " + err.Error()}
+	}
+	if err := h.check(); err != nil {
+		return nil, err
+	}
+
+	out := map[string][]map[string]json.RawMessage{}
+	for {
+		var l Line
+		err := dec.Decode(&l)
+		if errors.Is(err, io.EOF) {
+			return out, nil
+		}
+		if err != nil {
+			return nil, &FormatError{Reason: "corrupt backup file: " + err.Error()}
+		}
+		if _, ok := tables[l.Kind]; ok && l.Row != nil {
+			out[l.Kind] = append(out[l.Kind], l.Row)
+		}
+	}
+}
+
+// restore grava as linhas de um tipo numa transação e registra em ids o id
+// de destino de cada uma, e em ch, se não for nil, os criados e os
+// atualizados. Só as colunas que a tabela ainda tem são
+// gravadas, para que backups de versões anteriores do schema sigam
+// restauráveis.
+func (m *Manager) restore(ctx context.Context, kind string, rows []map[string]json.RawMessage, mp remapper, ids map[string]map[string]string, ch *Changes) (c Counts, err error) {
+	if len(rows) == 0 {
+		return c, nil
+	}
+	span := instrument.StartQuery(ctx, "backup.restore_"+kind)
+	defer func() { span.End(c.Created+c.Updated, err) }()
+
+	table := tables[kind]
+	tx, err := m.db.Begin(ctx)
+	if err != nil {
+		return c, err
+	}
+	defer tx.Rollback()
+
+	cols, err := columns(ctx, tx.Tx, table, rows[0])
+	if err != nil {
+		return c, err
+	}
+	stmt, err := tx.PrepareContext(ctx, upsert(table, cols, kind == KindScenarios && mp.remap))
+	if err != nil {
+		return c, err
+	}
+	defer stmt.Close()
+
+	for _, row := range rows {
+		old, ok := mp.translate(kind, row, ids)
+		if !ok {
+			c.Skipped++
+			continue
+		}
+		data, err := json.Marshal(row)
+		if err != nil {
+			return c, err
+		}
+		var inserted bool
+		err = stmt.QueryRowContext(ctx, data).Scan(&inserted)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			c.Skipped++
+			continue
+		case err != nil:
+			return c, err
+		case inserted:
+			c.Created++
+		default:
+			c.Updated++
+		}
+		id := text(row["id"])
+		ids[kind][old] = id
+		if ch != nil && inserted {
+			ch.Created = append(ch.Created, id)
+		} else if ch != nil {
+			ch.Updated = append(ch.Updated, id)
+		}
+	}
+	return c, tx.Commit()
+}
+
+// columns retorna, na ordem da tabela, as colunas dela presentes em row.
+func columns(ctx context.Context, tx *sql.Tx, table string, row map[string]json.RawMessage) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT column_name FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = $1 ORDER BY ordinal_position`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var col string
+		if err := rows.Scan(&col); err != nil {
+			return nil, err
+		}
+		if _, ok := row[col]; ok {
+			out = append(out, col)
+		}
+	}
+	return out, rows.Err()
+}
+
+// upsert monta o INSERT de uma linha JSON em table, que retorna se a linha
+// é nova. Com keep, uma linha existente fica como está e nada é retornado.
+func upsert(table string, cols []string, keep bool) string {
+	quoted := make([]string, len(cols))
+	var set []string
+	for i, col := range cols {
+		quoted[i] = pq.QuoteIdentifier(col)
+		if col != "id" {
+			set = append(set, quoted[i]+" = EXCLUDED."+quoted[i])
+		}
+	}
+	conflict := "DO NOTHING"
+	if !keep && len(set) > 0 {
+		conflict = "DO UPDATE SET " + strings.Join(set, ", ")
+	}
+	list := strings.Join(quoted, ", ")
+	t := pq.QuoteIdentifier(table)
+	return fmt.Sprintf(`INSERT INTO %s (%s) SELECT %s FROM json_populate_record(NULL::%s, $1::json)
+		ON CONFLICT (id) %s RETURNING (xmax = 0)`, t, list, list, t, conflict)
+}
+
+// translate leva a linha ao projeto de destino: troca o id, o projeto e as
+// referências às entidades já restauradas. Retorna o id original, ou falso
+// se a linha não tem id ou referencia uma entidade que não foi restaurada.
+func (mp remapper) translate(kind string, row map[string]json.RawMessage, ids map[string]map[string]string) (string, bool) {
+	old := text(row["id"])
+	if old == "" {
+		return "", false
+	}
+	switch kind {
+	case KindSimulations:
+		row["project_id"] = quote(mp.target)
+	case KindAgents:
+		sim, ok := ids[KindSimulations][text(row["simulation_id"])]
+		if !ok {
+			return old, false
+		}
+		row["simulation_id"] = quote(sim)
+		if _, ok := row["project_id"]; ok {
+			row["project_id"] = quote(mp.target)
+		}
+	case KindScenarios:
+		// Cenários são compartilhados entre projetos e mantêm o id.
+		return old, true
+	case KindSchedules:
+		ag, ok := ids[KindAgents][text(row["agent_id"])]
+		if !ok {
+			return old, false
+		}
+		row["agent_id"] = quote(ag)
+		if mp.remap {
+			// A última execução é uma ação do projeto de origem.
+			row["last_action_id"] = json.RawMessage("null")
+		}
+	}
+	row["id"] = quote(mp.id(old))
+	return old, true
+}
+
+func text(raw json.RawMessage) string {
+	var s string
+	if json.Unmarshal(raw, &s) != nil {
+		return ""
+	}
+	return s
+}
+
+func quote(s string) json.RawMessage {
+	b, _ := json.Marshal(s)
+	return b
+}
@@ -0,0 +1,69 @@
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// FuzzDecode lê arquivos de backup arbitrários, que trazem os cenários, as
+// simulações e os agentes de um projeto: data é o JSONL antes do gzip e,
+// com raw, o próprio arquivo. Um arquivo ilegível tem de ser um
+// *FormatError, que a API responde com 422, e as linhas lidas têm de
+// passar pela tradução para outro projeto sem derrubar a restauração.
+func FuzzDecode(f *testing.F) {
+	header := `{"format":"smartcity.project-backup","version":1,"backup_id":"b-1","project_id":"proj-1","created_at":"2026-03-04T05:00:00Z"}` + "\n"
+	for _, body := range []string{
+		header,
+		header + `{"kind":"simulations","row":{"id":"sim-1","project_id":"proj-1","name":"centro"}}` + "\n" +
+			`{"kind":"agents","row":{"id":"bus-1","simulation_id":"sim-1","project_id":"proj-1"}}` + "\n" +
+			`{"kind":"scenarios","row":{"id":"rush-hour","name":"Hora do rush","config":{"agents":[{"type":"bus","count":10}]}}}` + "\n" +
+			`{"kind":"schedules","row":{"id":"s-1","agent_id":"bus-1","last_action_id":"a-1"}}` + "\n",
+		header + `{"kind":"agents","row":{"id":"bus-1","simulation_id":"missing"}}` + "\n",
+		header + `{"kind":"scenarios","row":{"id":7,"config":null}}` + "\n",
+		header + `{"kind":"scenarios","row":null}` + "\n" + `{"kind":"events","row":{"id":"e-1"}}`,
+		header + `{"kind":"scenarios","row":{"id":"x"`,
+		`{"format":"smartcity.project-backup","version":2}`,
+		`{"format":"outro","version":1}`,
+		``,
+	} {
+		f.Add([]byte(body), false)
+	}
+	f.Add([]byte("not gzip"), true)
+
+	f.Fuzz(func(t *testing.T, data []byte, raw bool) {
+		file := data
+		if !raw {
+			var buf bytes.Buffer
+			gz := gzip.NewWriter(&buf)
+			gz.Write(data)
+			gz.Close()
+			file = buf.Bytes()
+		}
+		rows, err := decode(bytes.NewReader(file))
+		if err != nil {
+			var fe *FormatError
+			if !errors.As(err, &fe) {
+				t.Fatalf("erro %v (%T), want *FormatError", err, err)
+			}
+			return
+		}
+		mp := remapper{target: "proj-2", remap: true}
+		ids := map[string]map[string]string{}
+		for _, kind := range Kinds {
+			ids[kind] = map[string]string{}
+			for _, row := range rows[kind] {
+				old, ok := mp.translate(kind, row, ids)
+				if !ok {
+					continue
+				}
+				ids[kind][old] = text(row["id"])
+				if _, err := json.Marshal(row); err != nil {
+					t.Fatalf("linha de %s traduzida não serializa: %v", kind, err)
+				}
+			}
+		}
+	})
+}
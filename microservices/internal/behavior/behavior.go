@@ -0,0 +1,173 @@
+// Package behavior decide o que cada agente faz a cada tick da simulação.
+// Em vez de uma função de decisão única para todos os agentes, cada agente
+// escolhe um comportamento do registro (random-walk, rule-based,
+// waypoint-follower, scripted) com parâmetros próprios, conferidos pelo
+// schema do comportamento. O runner chama o comportamento escolhido a cada
+// tick e aplica as ações que ele retorna.
+package behavior
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"smart-city-microservices/internal/action"
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/agentmsg"
+)
+
+// ErrNotAssigned indica que o agente não tem comportamento.
+var ErrNotAssigned = errors.New("agent has no behavior")
+
+// Ações aplicadas pelo próprio runner; as demais são submetidas à fila de
+// ações, com a checagem do registro de ações.
+const (
+	// ActionMove move o agente: params lat, lon, heading e speed.
+	ActionMove = "move"
+	// ActionSetState grava as chaves de params no estado do agente.
+	ActionSetState = "set_state"
+	// ActionSendMessage envia uma mensagem a outro agente: params to ou
+	// to_type, e payload.
+	ActionSendMessage = "send_message"
+)
+
+// Action é uma ação decidida por um comportamento.
+type Action struct {
+	Name   string                 `json:"name"`
+	Params map[string]interface{} `json:"params,omitempty"`
+}
+
+// Environment é o que o comportamento sabe da simulação no tick.
+type Environment struct {
+	SimulationID string
+	Tick         int64
+	// TickInterval é a duração de um tick, para converter velocidades em
+	// deslocamentos.
+	TickInterval time.Duration
+	// Messages são as mensagens entregues ao agente neste tick.
+	Messages []agentmsg.Message
+}
+
+// Behavior decide as ações de um agente num tick. Decide não deve guardar
+// estado entre chamadas: o que precisa durar vai para o estado do agente
+// com ActionSetState, para que o resultado dependa só da entrada.
+type Behavior interface {
+	Decide(ctx context.Context, state agent.Agent, env Environment) []Action
+}
+
+// FallibleBehavior é um Behavior cuja decisão pode falhar, como um script
+// com erro. O runner chama DecideErr no lugar de Decide; um erro marca o
+// agente como failed.
+type FallibleBehavior interface {
+	Behavior
+	DecideErr(ctx context.Context, state agent.Agent, env Environment) ([]Action, error)
+}
+
+// Definition descreve um comportamento do registro.
+type Definition struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Schema é o JSON Schema dos parâmetros, já conferido por
+	// action.ParseSchema; nil aceita qualquer objeto.
+	Schema map[string]interface{} `json:"schema,omitempty"`
+	// New cria o comportamento com parâmetros já conferidos pelo schema.
+	New func(params map[string]interface{}) (Behavior, error) `json:"-"`
+}
+
+// Registry guarda os comportamentos conhecidos.
+type Registry struct {
+	defs  map[string]Definition
+	names []string
+}
+
+// NewRegistry cria o registro com as definições.
+func NewRegistry(defs []Definition) *Registry {
+	r := &Registry{defs: make(map[string]Definition, len(defs))}
+	for _, d := range defs {
+		if _, dup := r.defs[d.Name]; !dup {
+			r.names = append(r.names, d.Name)
+		}
+		r.defs[d.Name] = d
+	}
+	sort.Strings(r.names)
+	return r
+}
+
+// List retorna as definições em ordem de nome.
+func (r *Registry) List() []Definition {
+	out := make([]Definition, 0, len(r.names))
+	for _, name := range r.names {
+		out = append(out, r.defs[name])
+	}
+	return out
+}
+
+// Build confere os parâmetros contra o schema do comportamento e o cria. Os
+// erros retornados satisfazem errors.Is(err, agent.ErrValidation).
+func (r *Registry) Build(name string, params map[string]interface{}) (Behavior, error) {
+	d, ok := r.defs[name]
+	if !ok {
+		return nil, &UnknownError{Name: name, Available: r.names}
+	}
+	if err := action.ValidateParams(d.Schema, params, "params"); err != nil {
+		return nil, &InvalidParamsError{Behavior: name, Err: err}
+	}
+	b, err := d.New(params)
+	if err != nil {
+		return nil, &InvalidParamsError{Behavior: name, Err: err}
+	}
+	return b, nil
+}
+
+// dryRunTickInterval é a duração de tick usada no ensaio de Check.
+const dryRunTickInterval = time.Second
+
+// Check cria o comportamento, como Build, e o ensaia uma vez contra sample,
+// sem aplicar as ações, para recusar ao salvar um comportamento que
+// falharia já no primeiro tick.
+func (r *Registry) Check(ctx context.Context, name string, params map[string]interface{}, sample agent.Agent) error {
+	b, err := r.Build(name, params)
+	if err != nil {
+		return err
+	}
+	env := Environment{SimulationID: sample.SimulationID, TickInterval: dryRunTickInterval}
+	if _, _, err := decide(ctx, b, sample, env); err != nil {
+		return &InvalidParamsError{Behavior: name, Err: fmt.Errorf("dry run: %w", err)}
+	}
+	return nil
+}
+
+// UnknownError indica um comportamento fora do registro.
+type UnknownError struct {
+	Name string
+	// Available lista os comportamentos do registro.
+	Available []string
+}
+
+func (e *UnknownError) Error() string {
+	return fmt.Sprintf("unknown behavior %q", e.Name)
+}
+
+func (e *UnknownError) Unwrap() error { return agent.ErrValidation }
+
+// InvalidParamsError indica parâmetros recusados pelo comportamento.
+type InvalidParamsError struct {
+	Behavior string
+	Err      error
+}
+
+func (e *InvalidParamsError) Error() string {
+	return fmt.Sprintf("invalid params for behavior %q: %v", e.Behavior, e.Err)
+}
+
+func (e *InvalidParamsError) Unwrap() error { return agent.ErrValidation }
+
+// Assignment é o comportamento escolhido para um agente.
+type Assignment struct {
+	AgentID   string                 `json:"agent_id"`
+	Behavior  string                 `json:"behavior"`
+	Params    map[string]interface{} `json:"params"`
+	UpdatedAt time.Time              `json:"updated_at"`
+}
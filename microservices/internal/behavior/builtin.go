@@ -0,0 +1,275 @@
+package behavior
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"strings"
+
+	"smart-city-microservices/internal/action"
+	"smart-city-microservices/internal/agent"
+)
+
+// Builtins retorna os comportamentos embutidos.
+func Builtins() []Definition {
+	return []Definition{
+		{
+			Name:        "random-walk",
+			Description: "Anda step metros por tick, virando até turn graus para cada lado; o sorteio depende só do agente, do tick e de seed",
+			Schema:      mustSchema(`{"type": "object", "additionalProperties": false, "properties": {"step": {"type": "number", "minimum": 0}, "turn": {"type": "number", "minimum": 0, "maximum": 180}, "seed": {"type": "integer"}}}`),
+			New:         newRandomWalk,
+		},
+		{
+			Name:        "rule-based",
+			Description: "Aplica a ação da primeira regra (ou de todas, com all) cuja condição sobre o agente vale",
+			Schema:      mustSchema(`{"type": "object", "required": ["rules"], "additionalProperties": false, "properties": {"all": {"type": "boolean"}, "rules": {"type": "array", "minItems": 1, "items": {"type": "object", "required": ["field", "op", "value", "action"], "additionalProperties": false, "properties": {"field": {"type": "string", "minLength": 1}, "op": {"enum": ["eq", "ne", "lt", "lte", "gt", "gte"]}, "value": {"type": ["number", "string", "boolean"]}, "action": {"type": "string", "minLength": 1}, "params": {"type": "object"}}}}}}`),
+			New:         newRuleBased,
+		},
+		{
+			Name:        "waypoint-follower",
+			Description: "Segue os waypoints em ordem a speed m/s, guardando o próximo em state.waypoint_index; com loop, recomeça do primeiro",
+			Schema:      mustSchema(`{"type": "object", "required": ["waypoints"], "additionalProperties": false, "properties": {"waypoints": {"type": "array", "minItems": 1, "items": {"type": "object", "required": ["lat", "lon"], "additionalProperties": false, "properties": {"lat": {"type": "number", "minimum": -90, "maximum": 90}, "lon": {"type": "number", "minimum": -180, "maximum": 180}}}}, "speed": {"type": "number", "minimum": 0}, "loop": {"type": "boolean"}, "arrival_radius": {"type": "number", "minimum": 0}}}`),
+			New:         newWaypointFollower,
+		},
+	}
+}
+
+func mustSchema(raw string) map[string]interface{} {
+	s, err := action.ParseSchema(raw)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// decode copia params conferidos pelo schema para dst, que já traz os
+// valores padrão.
+func decode(params map[string]interface{}, dst interface{}) error {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, dst)
+}
+
+func move(lat, lon, heading, speed float64) Action {
+	return Action{Name: ActionMove, Params: map[string]interface{}{
+		"lat": lat, "lon": lon, "heading": heading, "speed": speed,
+	}}
+}
+
+type randomWalk struct {
+	Step float64 `json:"step"`
+	Turn float64 `json:"turn"`
+	Seed int64   `json:"seed"`
+}
+
+func newRandomWalk(params map[string]interface{}) (Behavior, error) {
+	b := &randomWalk{Step: 10, Turn: 45}
+	return b, decode(params, b)
+}
+
+func (b *randomWalk) Decide(_ context.Context, s agent.Agent, env Environment) []Action {
+	h := fnv.New64a()
+	h.Write([]byte(s.ID))
+	rng := rand.New(rand.NewSource(int64(h.Sum64()) ^ env.Tick ^ b.Seed))
+	heading := math.Mod(s.Position.Heading+(rng.Float64()*2-1)*b.Turn+360, 360)
+	lat, lon := offset(s.Position.Lat, s.Position.Lon, heading, b.Step)
+	return []Action{move(lat, lon, heading, b.Step/env.TickInterval.Seconds())}
+}
+
+type rule struct {
+	Field  string                 `json:"field"`
+	Op     string                 `json:"op"`
+	Value  interface{}            `json:"value"`
+	Action string                 `json:"action"`
+	Params map[string]interface{} `json:"params"`
+}
+
+type ruleBased struct {
+	All   bool   `json:"all"`
+	Rules []rule `json:"rules"`
+}
+
+func newRuleBased(params map[string]interface{}) (Behavior, error) {
+	b := &ruleBased{}
+	if err := decode(params, b); err != nil {
+		return nil, err
+	}
+	for i, r := range b.Rules {
+		switch {
+		case r.Field == "energy", r.Field == "status", r.Field == "speed", r.Field == "heading",
+			r.Field == "lat", r.Field == "lon", strings.HasPrefix(r.Field, "state.") && len(r.Field) > len("state."):
+		default:
+			return nil, fmt.Errorf("rules[%d].field: unknown field %q (use energy, status, speed, heading, lat, lon or state.<key>)", i, r.Field)
+		}
+		if _, ok := r.Value.(float64); !ok && r.Op != "eq" && r.Op != "ne" {
+			return nil, fmt.Errorf("rules[%d].value: %s needs a number", i, r.Op)
+		}
+	}
+	return b, nil
+}
+
+func (b *ruleBased) Decide(_ context.Context, s agent.Agent, _ Environment) []Action {
+	var out []Action
+	for _, r := range b.Rules {
+		if !r.matches(s) {
+			continue
+		}
+		out = append(out, Action{Name: r.Action, Params: r.Params})
+		if !b.All {
+			break
+		}
+	}
+	return out
+}
+
+// matches compara o campo do agente com o valor da regra. Campos ausentes
+// não satisfazem nenhuma condição.
+func (r rule) matches(s agent.Agent) bool {
+	var v interface{}
+	switch r.Field {
+	case "energy":
+		v = s.Energy
+	case "status":
+		v = s.Status
+	case "speed":
+		v = s.Position.Speed
+	case "heading":
+		v = s.Position.Heading
+	case "lat":
+		v = s.Position.Lat
+	case "lon":
+		v = s.Position.Lon
+	default:
+		var ok bool
+		if v, ok = s.State[strings.TrimPrefix(r.Field, "state.")]; !ok {
+			return false
+		}
+	}
+	if n, ok := number(v); ok {
+		want, ok := r.Value.(float64)
+		if !ok {
+			return false
+		}
+		switch r.Op {
+		case "eq":
+			return n == want
+		case "ne":
+			return n != want
+		case "lt":
+			return n < want
+		case "lte":
+			return n <= want
+		case "gt":
+			return n > want
+		case "gte":
+			return n >= want
+		}
+		return false
+	}
+	switch v.(type) {
+	case string, bool:
+	default:
+		// Listas e objetos do estado não se comparam.
+		return false
+	}
+	switch r.Op {
+	case "eq":
+		return v == r.Value
+	case "ne":
+		return v != r.Value
+	}
+	return false
+}
+
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+type waypoint struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+type waypointFollower struct {
+	Waypoints     []waypoint `json:"waypoints"`
+	Speed         float64    `json:"speed"`
+	Loop          bool       `json:"loop"`
+	ArrivalRadius float64    `json:"arrival_radius"`
+}
+
+func newWaypointFollower(params map[string]interface{}) (Behavior, error) {
+	b := &waypointFollower{Speed: 10, ArrivalRadius: 5}
+	return b, decode(params, b)
+}
+
+func (b *waypointFollower) Decide(_ context.Context, s agent.Agent, env Environment) []Action {
+	i := 0
+	if n, ok := number(s.State["waypoint_index"]); ok && n >= 0 {
+		i = int(n)
+	}
+	if i >= len(b.Waypoints) {
+		if !b.Loop {
+			return nil
+		}
+		i = 0
+	}
+	target := b.Waypoints[i]
+	step := b.Speed * env.TickInterval.Seconds()
+	dist := distance(s.Position.Lat, s.Position.Lon, target.Lat, target.Lon)
+	heading := bearing(s.Position.Lat, s.Position.Lon, target.Lat, target.Lon)
+	if dist > max(step, b.ArrivalRadius) {
+		lat, lon := offset(s.Position.Lat, s.Position.Lon, heading, step)
+		return []Action{move(lat, lon, heading, b.Speed)}
+	}
+	next := i + 1
+	if next == len(b.Waypoints) && b.Loop {
+		next = 0
+	}
+	return []Action{
+		move(target.Lat, target.Lon, heading, b.Speed),
+		{Name: ActionSetState, Params: map[string]interface{}{"waypoint_index": next}},
+	}
+}
+
+const earthRadius = 6371000.0
+
+// distance é a distância de grande círculo (haversine) entre dois pontos,
+// em metros.
+func distance(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+// bearing é o rumo inicial de um ponto ao outro, em graus a partir do norte.
+func bearing(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	y := math.Sin((lon2-lon1)*rad) * math.Cos(lat2*rad)
+	x := math.Cos(lat1*rad)*math.Sin(lat2*rad) - math.Sin(lat1*rad)*math.Cos(lat2*rad)*math.Cos((lon2-lon1)*rad)
+	return math.Mod(math.Atan2(y, x)/rad+360, 360)
+}
+
+// offset é o ponto a meters metros do ponto no rumo heading.
+func offset(lat, lon, heading, meters float64) (float64, float64) {
+	rad := math.Pi / 180
+	d := meters / earthRadius
+	lat1, lon1, h := lat*rad, lon*rad, heading*rad
+	lat2 := math.Asin(math.Sin(lat1)*math.Cos(d) + math.Cos(lat1)*math.Sin(d)*math.Cos(h))
+	lon2 := lon1 + math.Atan2(math.Sin(h)*math.Sin(d)*math.Cos(lat1), math.Cos(d)-math.Sin(lat1)*math.Sin(lat2))
+	return lat2 / rad, math.Mod(lon2/rad+540, 360) - 180
+}
@@ -0,0 +1,137 @@
+package behavior
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/audit"
+	"smart-city-microservices/internal/i18n"
+	"smart-city-microservices/internal/logging"
+)
+
+// AgentGetter é o subconjunto de agent.Service usado pelo handler.
+type AgentGetter interface {
+	GetAgent(ctx context.Context, id string) (*agent.Agent, error)
+}
+
+// Handler expõe o registro de comportamentos e o comportamento de cada
+// agente.
+type Handler struct {
+	registry *Registry
+	repo     *Repository
+	agents   AgentGetter
+}
+
+// NewHandler cria o handler de comportamentos.
+func NewHandler(registry *Registry, repo *Repository, agents AgentGetter) *Handler {
+	return &Handler{registry: registry, repo: repo, agents: agents}
+}
+
+// SetRequest é o corpo de PUT /agents/:id/behavior.
+type SetRequest struct {
+	Behavior string                 `json:"behavior" binding:"required"`
+	Params   map[string]interface{} `json:"params"`
+}
+
+// List responde GET /behaviors com os comportamentos e o schema dos seus
+// parâmetros.
+func (h *Handler) List(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"data": h.registry.List()})
+}
+
+// Get retorna o comportamento do agente.
+func (h *Handler) Get(c *gin.Context) {
+	ag, ok := h.agent(c)
+	if !ok {
+		return
+	}
+	a, err := h.repo.Get(c.Request.Context(), ag.ID)
+	if errors.Is(err, ErrNotAssigned) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, a)
+}
+
+// Set escolhe o comportamento do agente, que passa a valer no próximo tick.
+// Comportamento fora do registro, parâmetros fora do schema ou um ensaio
+// contra o estado atual do agente que falha respondem 422.
+func (h *Handler) Set(c *gin.Context) {
+	var req SetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		i18n.BindError(c, err)
+		return
+	}
+	if req.Params == nil {
+		req.Params = map[string]interface{}{}
+	}
+	ag, ok := h.agent(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	var unknown *UnknownError
+	if err := h.registry.Check(ctx, req.Behavior, req.Params, *ag); errors.As(err, &unknown) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "available": unknown.Available})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	a := &Assignment{AgentID: ag.ID, Behavior: req.Behavior, Params: req.Params}
+	if err := h.repo.Set(ctx, a); err != nil {
+		h.internalError(c, err)
+		return
+	}
+	audit.Record(ctx, "agent.behavior.updated", logrus.Fields{"agent_id": ag.ID, "behavior": a.Behavior})
+	c.JSON(http.StatusOK, a)
+}
+
+// Delete remove o comportamento do agente, que deixa de decidir nos ticks.
+func (h *Handler) Delete(c *gin.Context) {
+	ag, ok := h.agent(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	err := h.repo.Delete(ctx, ag.ID)
+	if errors.Is(err, ErrNotAssigned) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	audit.Record(ctx, "agent.behavior.deleted", logrus.Fields{"agent_id": ag.ID})
+	c.Status(http.StatusNoContent)
+}
+
+// agent busca o agente de :id. Responde ao cliente e retorna false se ele
+// não existe ou a busca falhou.
+func (h *Handler) agent(c *gin.Context) (*agent.Agent, bool) {
+	ag, err := h.agents.GetAgent(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, agent.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "agent not found"})
+		return nil, false
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return nil, false
+	}
+	return ag, true
+}
+
+func (h *Handler) internalError(c *gin.Context, err error) {
+	logging.FromContext(c.Request.Context()).WithError(err).Error("Erro no handler de comportamentos de agentes")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
@@ -0,0 +1,103 @@
+package behavior
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"smart-city-microservices/internal/agent"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// oneAgent conhece só o agente que guarda.
+type oneAgent struct{ agent.Agent }
+
+func (o oneAgent) GetAgent(_ context.Context, id string) (*agent.Agent, error) {
+	if id != o.ID {
+		return nil, agent.ErrNotFound
+	}
+	a := o.Agent
+	return &a, nil
+}
+
+var fuzzAgent = agent.Agent{
+	ID:           "00000000-0000-4000-8000-000000000001",
+	SimulationID: "sim-1",
+	Type:         "bus",
+	Status:       "active",
+	Energy:       80,
+	Position:     agent.Position{Lat: -23.55, Lon: -46.63, Heading: 90, Speed: 10},
+	State:        map[string]interface{}{"waypoint_index": 1.0, "line": "101"},
+}
+
+// FuzzSet manda corpos arbitrários a PUT /agents/:id/behavior, a
+// configuração do comportamento do agente. Nenhum corpo pode responder 500
+// ou derrubar o handler, e o que é aceito tem de servir ao runner: os
+// parâmetros, relidos do banco em JSON, criam o comportamento de novo, e
+// as ações decididas com eles podem ser serializadas na atualização do
+// agente.
+func FuzzSet(f *testing.F) {
+	for _, body := range []string{
+		`{"behavior":"random-walk","params":{"step":12,"turn":30,"seed":7}}`,
+		`{"behavior":"random-walk","params":{"step":1e308,"turn":180}}`,
+		`{"behavior":"rule-based","params":{"rules":[{"field":"energy","op":"lt","value":20,"action":"recharge"}]}}`,
+		`{"behavior":"rule-based","params":{"all":true,"rules":[{"field":"state.line","op":"eq","value":"101","action":"reroute","params":{"to":"🚌"}}]}}`,
+		`{"behavior":"waypoint-follower","params":{"waypoints":[{"lat":-23.56,"lon":-46.64},{"lat":90,"lon":180}],"loop":true,"speed":1e300}}`,
+		`{"behavior":"waypoint-follower","params":{"waypoints":[]}}`,
+		`{"behavior":"scripted"}`,
+		`{"behavior":null,"params":null}`,
+		`{"behavior":"random-walk","params":[]}`,
+		`{"behavior":"random-walk","params":{"seed":9223372036854775808}}`,
+		`null`, `[]`, ``,
+	} {
+		f.Add(body)
+	}
+	db, err := sql.Open("behaviortest", "")
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Cleanup(func() { db.Close() })
+	registry := NewRegistry(Builtins())
+	h := NewHandler(registry, NewRepository(db), oneAgent{fuzzAgent})
+	r := gin.New()
+	r.PUT("/agents/:id/behavior", h.Set)
+
+	f.Fuzz(func(t *testing.T, body string) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, "/agents/"+fuzzAgent.ID+"/behavior", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		switch w.Code {
+		case http.StatusBadRequest, http.StatusUnprocessableEntity:
+			return
+		case http.StatusOK:
+		default:
+			t.Fatalf("status %d: %s", w.Code, w.Body.String())
+		}
+
+		var saved Assignment
+		if err := json.Unmarshal(w.Body.Bytes(), &saved); err != nil {
+			t.Fatalf("resposta %s: %v", w.Body.String(), err)
+		}
+		b, err := registry.Build(saved.Behavior, saved.Params)
+		if err != nil {
+			t.Fatalf("parâmetros aceitos não criam o comportamento de novo: %v", err)
+		}
+		actions, _, err := decide(context.Background(), b, fuzzAgent, Environment{SimulationID: "sim-1", Tick: 1, TickInterval: dryRunTickInterval})
+		if err != nil {
+			t.Fatalf("comportamento aceito falha no tick: %v", err)
+		}
+		if _, err := json.Marshal(actions); err != nil {
+			t.Fatalf("ações %+v não serializam: %v", actions, err)
+		}
+	})
+}
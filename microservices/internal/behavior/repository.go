@@ -0,0 +1,104 @@
+package behavior
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/lib/pq"
+
+	"smart-city-microservices/internal/instrument"
+)
+
+// Repository persiste o comportamento escolhido para cada agente.
+type Repository struct {
+	db *instrument.DB
+}
+
+// NewRepository cria o repositório.
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: instrument.NewDB(db)}
+}
+
+// Get retorna o comportamento do agente, ou ErrNotAssigned.
+func (r *Repository) Get(ctx context.Context, agentID string) (*Assignment, error) {
+	a := Assignment{AgentID: agentID}
+	var params []byte
+	err := r.db.QueryRow(ctx, "behavior.get",
+		`SELECT behavior, params, updated_at FROM agent_behaviors WHERE agent_id = $1`, agentID,
+	).Scan(&a.Behavior, &params, &a.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotAssigned
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(params, &a.Params); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// ForAgents retorna os comportamentos dos agentes que têm um.
+func (r *Repository) ForAgents(ctx context.Context, agentIDs []string) (map[string]*Assignment, error) {
+	rows, err := r.db.Query(ctx, "behavior.for_agents",
+		`SELECT agent_id::text, behavior, params, updated_at FROM agent_behaviors WHERE agent_id = ANY($1::uuid[])`,
+		pq.StringArray(agentIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := map[string]*Assignment{}
+	for rows.Next() {
+		var a Assignment
+		var params []byte
+		if err := rows.Scan(&a.AgentID, &a.Behavior, &params, &a.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(params, &a.Params); err != nil {
+			return nil, err
+		}
+		out[a.AgentID] = &a
+	}
+	return out, rows.Err()
+}
+
+// Set grava o comportamento do agente e preenche UpdatedAt.
+func (r *Repository) Set(ctx context.Context, a *Assignment) error {
+	params, err := json.Marshal(a.Params)
+	if err != nil {
+		return err
+	}
+	return r.db.QueryRow(ctx, "behavior.set", `
+		INSERT INTO agent_behaviors (agent_id, behavior, params) VALUES ($1, $2, $3)
+		ON CONFLICT (agent_id) DO UPDATE SET behavior = EXCLUDED.behavior, params = EXCLUDED.params,
+			updated_at = CURRENT_TIMESTAMP
+		RETURNING updated_at`, a.AgentID, a.Behavior, params).Scan(&a.UpdatedAt)
+}
+
+// Touch renova UpdatedAt dos comportamentos dos agentes sem mudá-los, o
+// que faz o Runner descartar a instância em cache e construir outra, sem o
+// estado em memória da anterior.
+func (r *Repository) Touch(ctx context.Context, agentIDs []string) error {
+	_, err := r.db.Exec(ctx, "behavior.touch",
+		`UPDATE agent_behaviors SET updated_at = CURRENT_TIMESTAMP WHERE agent_id = ANY($1::uuid[])`,
+		pq.StringArray(agentIDs))
+	return err
+}
+
+// Delete remove o comportamento do agente. Retorna ErrNotAssigned se ele
+// não tinha um.
+func (r *Repository) Delete(ctx context.Context, agentID string) error {
+	res, err := r.db.Exec(ctx, "behavior.delete", `DELETE FROM agent_behaviors WHERE agent_id = $1`, agentID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotAssigned
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package behavior
+
+import (
+	"context"
+	"testing"
+
+	"smart-city-microservices/internal/containers"
+)
+
+// BenchmarkForAgents10k mede a busca das atribuições de 10 mil agentes no
+// Postgres, nas páginas de listPageSize de um tick do runner.
+func BenchmarkForAgents10k(b *testing.B) {
+	db := containers.Postgres(b)
+	ctx := context.Background()
+	var simulationID string
+	if err := db.QueryRowContext(ctx, `INSERT INTO simulations (name) VALUES ('bench behavior') RETURNING id`).Scan(&simulationID); err != nil {
+		b.Fatal(err)
+	}
+	// Os agentes e as atribuições saem junto com a simulação.
+	b.Cleanup(func() { db.Exec(`DELETE FROM simulations WHERE id = $1`, simulationID) })
+	rows, err := db.QueryContext(ctx, `
+		INSERT INTO agents (simulation_id, agent_type) SELECT $1, 'car' FROM generate_series(1, 10000)
+		RETURNING id::text`, simulationID)
+	if err != nil {
+		b.Fatal(err)
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			b.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		b.Fatal(err)
+	}
+	if _, err := db.ExecContext(ctx, `
+		INSERT INTO agent_behaviors (agent_id, behavior, params)
+		SELECT id, 'random-walk', '{"step": 12}' FROM agents WHERE simulation_id = $1`, simulationID); err != nil {
+		b.Fatal(err)
+	}
+
+	repo := NewRepository(db)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		found := 0
+		for start := 0; start < len(ids); start += listPageSize {
+			page, err := repo.ForAgents(ctx, ids[start:min(start+listPageSize, len(ids))])
+			if err != nil {
+				b.Fatal(err)
+			}
+			found += len(page)
+		}
+		if found != len(ids) {
+			b.Fatalf("%d atribuições, want %d", found, len(ids))
+		}
+	}
+}
@@ -0,0 +1,519 @@
+package behavior
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/action"
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/agentmsg"
+	"smart-city-microservices/internal/logging"
+)
+
+// StatusFailed é o status dado a um agente cujo comportamento entrou em
+// pânico ou falhou; o runner deixa de chamá-lo até que o status mude.
+const StatusFailed = "failed"
+
+// StateError é a chave do estado do agente que guarda a falha que o marcou
+// como failed: message e tick.
+const StateError = "behavior_error"
+
+// StateTickSkipped é a chave do estado do agente que conta os ticks em que
+// o seu comportamento foi pulado por passar do tempo.
+const StateTickSkipped = "tick_skipped"
+
+// Motivos de agent_behavior_tick_skipped_total.
+const (
+	// skipBudget: a decisão passou de RunnerConfig.DecideBudget.
+	skipBudget = "budget"
+	// skipDeadline: o tick acabou antes da vez do agente ou durante a
+	// decisão.
+	skipDeadline = "tick_deadline"
+)
+
+// listPageSize é a página usada ao percorrer os agentes de uma simulação.
+const listPageSize = 100
+
+// builtTTL é quanto um comportamento criado fica guardado no shard sem ser
+// usado, ex.: depois que a simulação do agente termina.
+const builtTTL = 10 * time.Minute
+
+var (
+	decideDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "agent_service",
+		Name:      "agent_behavior_decide_seconds",
+		Help:      "Duração de Decide por comportamento.",
+		Buckets:   []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1},
+	}, []string{"behavior"})
+
+	panics = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "agent_behavior_panics_total",
+		Help:      "Pânicos em Decide por comportamento; cada um marca o agente como failed.",
+	}, []string{"behavior"})
+
+	failures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "agent_behavior_errors_total",
+		Help:      "Erros retornados por comportamentos (scripts, por exemplo); cada um marca o agente como failed.",
+	}, []string{"behavior"})
+
+	decided = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "agent_behavior_actions_total",
+		Help:      "Ações decididas pelos comportamentos, por resultado (applied, submitted, failed, dropped).",
+	}, []string{"result"})
+
+	tickSkipped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "agent_behavior_tick_skipped_total",
+		Help:      "Agentes pulados num tick por comportamento e motivo (budget, tick_deadline).",
+	}, []string{"behavior", "reason"})
+
+	shardDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "agent_service",
+		Name:      "agent_behavior_shard_seconds",
+		Help:      "Duração de cada fase do tick (decide, apply) por shard de agentes.",
+		Buckets:   []float64{.001, .005, .01, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"phase", "shard"})
+)
+
+// AgentService é o subconjunto de agent.Service usado pelo runner.
+type AgentService interface {
+	ListAgents(ctx context.Context, f agent.Filter) ([]agent.Agent, int, error)
+	UpdateAgent(ctx context.Context, id string, req agent.UpdateAgentRequest) (*agent.Agent, error)
+}
+
+// Submitter coloca uma ação na fila do runner de ações; *action.Submitter
+// o implementa.
+type Submitter interface {
+	Submit(ctx context.Context, agentID string, req agent.ActionRequest, priority string) (action.Action, error)
+}
+
+// RunnerConfig configura o runner.
+type RunnerConfig struct {
+	// TickInterval é a duração de um tick das simulações.
+	TickInterval time.Duration
+	// MaxActions limita as ações aplicadas por agente num tick; as
+	// excedentes são descartadas e contadas.
+	MaxActions int
+	// InboxSize é quantas mensagens da caixa são lidas para achar as
+	// entregues no tick.
+	InboxSize int
+	// Workers é o número de shards em que os agentes são divididos e
+	// processados em paralelo.
+	Workers int
+	// DecideBudget limita a decisão de cada agente; passado dele, o agente é
+	// pulado no tick.
+	DecideBudget time.Duration
+}
+
+// Runner chama, a cada tick de uma simulação, o comportamento de cada um
+// dos seus agentes e aplica as ações decididas.
+type Runner struct {
+	registry  *Registry
+	repo      *Repository
+	agents    AgentService
+	submitter Submitter
+	messages  *agentmsg.Bus
+	cfg       RunnerConfig
+	shards    []*shard
+}
+
+// shard guarda os comportamentos já criados dos seus agentes. Um agente cai
+// sempre no mesmo shard, pelo hash do id, para que o comportamento não
+// precise ser criado de novo a cada tick.
+type shard struct {
+	mu    sync.Mutex
+	built map[string]*built
+	swept time.Time
+}
+
+type built struct {
+	assignment Assignment
+	behavior   Behavior
+	used       time.Time
+}
+
+// NewRunner cria o runner; Tick é registrado no relógio das simulações.
+func NewRunner(registry *Registry, repo *Repository, agents AgentService, submitter Submitter, messages *agentmsg.Bus, cfg RunnerConfig) *Runner {
+	shards := make([]*shard, max(cfg.Workers, 1))
+	for i := range shards {
+		shards[i] = &shard{built: map[string]*built{}, swept: time.Now()}
+	}
+	return &Runner{registry: registry, repo: repo, agents: agents, submitter: submitter, messages: messages, cfg: cfg, shards: shards}
+}
+
+// decision é o resultado da fase de decisão para um agente.
+type decision struct {
+	agent    agent.Agent
+	behavior string
+	actions  []Action
+	// err é a falha do comportamento, que marca o agente como failed.
+	err error
+	// skipped é o motivo do agente ter sido pulado.
+	skipped string
+	// ignored indica que não há nada a aplicar, ex.: comportamento inválido.
+	ignored bool
+}
+
+// Tick roda os comportamentos dos agentes da simulação (menos os com status
+// failed) em duas fases, cada uma em paralelo pelos shards: todos decidem e
+// só então as ações são aplicadas. Assim cada decisão vê os agentes como
+// estavam no começo do tick, qualquer que seja a ordem dos shards; dentro
+// de um shard os agentes seguem a ordem de id. O tick tem o prazo de
+// TickInterval: o agente cuja decisão passa de DecideBudget, ou que não
+// chega a decidir no prazo, é pulado e tem StateTickSkipped incrementado.
+func (r *Runner) Tick(ctx context.Context, simulationID string, tick int64) {
+	log := logging.FromContext(ctx).WithFields(logrus.Fields{"simulation_id": simulationID, "tick": tick})
+	var agents []agent.Agent
+	assignments := map[string]*Assignment{}
+	f := agent.Filter{SimulationID: simulationID, PageSize: listPageSize}
+	for f.Page = 1; ; f.Page++ {
+		page, total, err := r.agents.ListAgents(ctx, f)
+		if err != nil {
+			log.WithError(err).Error("Falha ao listar agentes para os comportamentos")
+			return
+		}
+		ids := make([]string, len(page))
+		for i, a := range page {
+			ids[i] = a.ID
+		}
+		found, err := r.repo.ForAgents(ctx, ids)
+		if err != nil {
+			log.WithError(err).Error("Falha ao buscar comportamentos de agentes")
+			return
+		}
+		for _, a := range page {
+			if found[a.ID] != nil && a.Status != StatusFailed {
+				agents = append(agents, a)
+				assignments[a.ID] = found[a.ID]
+			}
+		}
+		if len(page) < f.PageSize || f.Page*f.PageSize >= total {
+			break
+		}
+	}
+	if len(agents) == 0 {
+		return
+	}
+	sort.Slice(agents, func(i, j int) bool { return agents[i].ID < agents[j].ID })
+
+	groups := make([][]int, len(r.shards))
+	for i, a := range agents {
+		s := r.shardOf(a.ID)
+		groups[s] = append(groups[s], i)
+	}
+	decisions := make([]decision, len(agents))
+	tickCtx, cancel := context.WithTimeout(ctx, r.cfg.TickInterval)
+	defer cancel()
+	r.phase("decide", groups, func(s *shard, i int) {
+		decisions[i] = r.decide(tickCtx, s, agents[i], assignments[agents[i].ID], tick)
+	})
+	// As escritas não usam o prazo do tick: o que foi decidido é aplicado.
+	r.phase("apply", groups, func(_ *shard, i int) {
+		r.settle(ctx, decisions[i], tick)
+	})
+
+	skips := map[string]int{}
+	for _, d := range decisions {
+		if d.skipped != "" {
+			skips[d.skipped]++
+		}
+	}
+	if len(skips) > 0 {
+		log.WithFields(logrus.Fields{"budget": skips[skipBudget], "tick_deadline": skips[skipDeadline], "agents": len(agents)}).
+			Warn("Agentes pulados no tick por passarem do tempo dos comportamentos")
+	}
+}
+
+func (r *Runner) shardOf(agentID string) int {
+	h := fnv.New32a()
+	h.Write([]byte(agentID))
+	return int(h.Sum32() % uint32(len(r.shards)))
+}
+
+// phase roda f para os agentes de cada shard, um goroutine por shard, e
+// espera todos terminarem.
+func (r *Runner) phase(name string, groups [][]int, f func(s *shard, i int)) {
+	var wg sync.WaitGroup
+	for s, idx := range groups {
+		if len(idx) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(s int, idx []int) {
+			defer wg.Done()
+			start := time.Now()
+			for _, i := range idx {
+				f(r.shards[s], i)
+			}
+			shardDuration.WithLabelValues(name, strconv.Itoa(s)).Observe(time.Since(start).Seconds())
+		}(s, idx)
+	}
+	wg.Wait()
+}
+
+// decide chama o comportamento do agente dentro de DecideBudget, sem
+// aplicar o resultado.
+func (r *Runner) decide(ctx context.Context, s *shard, a agent.Agent, as *Assignment, tick int64) decision {
+	d := decision{agent: a, behavior: as.Behavior}
+	if ctx.Err() != nil {
+		d.skipped = skipDeadline
+		return d
+	}
+	log := logging.FromContext(ctx).WithFields(logrus.Fields{"agent_id": a.ID, "behavior": as.Behavior})
+	b, err := s.build(r.registry, as)
+	if err != nil {
+		// Um comportamento gravado pode sair do registro numa atualização.
+		log.WithError(err).Warn("Comportamento do agente inválido; agente ignorado no tick")
+		d.ignored = true
+		return d
+	}
+	env := Environment{SimulationID: a.SimulationID, Tick: tick, TickInterval: r.cfg.TickInterval}
+	inbox, err := r.messages.Inbox(ctx, a.ID, r.cfg.InboxSize)
+	if err != nil {
+		log.WithError(err).Warn("Falha ao ler a caixa de mensagens do agente")
+	}
+	for _, m := range inbox {
+		if m.DeliveredTick == tick {
+			env.Messages = append(env.Messages, m)
+		}
+	}
+
+	bctx, cancel := context.WithTimeout(ctx, r.cfg.DecideBudget)
+	defer cancel()
+	start := time.Now()
+	o, done := decideWithin(bctx, b, a, env)
+	decideDuration.WithLabelValues(as.Behavior).Observe(time.Since(start).Seconds())
+	if !done || (o.err != nil && o.stack == nil && bctx.Err() != nil) {
+		// Um erro depois do prazo (ex.: o script interrompido pelo contexto)
+		// é o prazo, não uma falha do comportamento.
+		d.skipped = skipBudget
+		if ctx.Err() != nil {
+			d.skipped = skipDeadline
+		}
+		return d
+	}
+	if o.err != nil {
+		if o.stack != nil {
+			panics.WithLabelValues(as.Behavior).Inc()
+			log.WithFields(logrus.Fields{"panic": o.err, "stack": string(o.stack)}).Error("Pânico no comportamento do agente; agente marcado como failed")
+		} else {
+			failures.WithLabelValues(as.Behavior).Inc()
+			log.WithError(o.err).Error("Erro no comportamento do agente; agente marcado como failed")
+		}
+		d.err = o.err
+		return d
+	}
+	if over := len(o.actions) - r.cfg.MaxActions; over > 0 {
+		decided.WithLabelValues("dropped").Add(float64(over))
+		o.actions = o.actions[:r.cfg.MaxActions]
+	}
+	d.actions = o.actions
+	return d
+}
+
+// settle aplica a decisão do agente.
+func (r *Runner) settle(ctx context.Context, d decision, tick int64) {
+	switch {
+	case d.ignored:
+	case d.skipped != "":
+		tickSkipped.WithLabelValues(d.behavior, d.skipped).Inc()
+		r.skip(ctx, d.agent)
+	case d.err != nil:
+		r.fail(ctx, d.agent, tick, d.err)
+	default:
+		r.apply(ctx, d.agent, d.actions)
+	}
+}
+
+// build retorna o comportamento do agente, criando-o de novo só se a
+// atribuição mudou. De tempos em tempos descarta os que ficaram sem uso.
+func (s *shard) build(registry *Registry, as *Assignment) (Behavior, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if now.Sub(s.swept) >= builtTTL {
+		for id, b := range s.built {
+			if now.Sub(b.used) >= builtTTL {
+				delete(s.built, id)
+			}
+		}
+		s.swept = now
+	}
+	if b, ok := s.built[as.AgentID]; ok && b.assignment.Behavior == as.Behavior && b.assignment.UpdatedAt.Equal(as.UpdatedAt) {
+		b.used = now
+		return b.behavior, nil
+	}
+	b, err := registry.Build(as.Behavior, as.Params)
+	if err != nil {
+		delete(s.built, as.AgentID)
+		return nil, err
+	}
+	s.built[as.AgentID] = &built{assignment: *as, behavior: b, used: now}
+	return b, nil
+}
+
+// skip incrementa StateTickSkipped no estado do agente.
+func (r *Runner) skip(ctx context.Context, a agent.Agent) {
+	state := make(map[string]interface{}, len(a.State)+1)
+	for k, v := range a.State {
+		state[k] = v
+	}
+	var n int64
+	switch v := a.State[StateTickSkipped].(type) {
+	case float64:
+		n = int64(v)
+	case int64:
+		n = v
+	case int:
+		n = int64(v)
+	}
+	state[StateTickSkipped] = n + 1
+	if _, err := r.agents.UpdateAgent(ctx, a.ID, agent.UpdateAgentRequest{State: state}); err != nil {
+		logging.FromContext(ctx).WithError(err).WithField("agent_id", a.ID).Warn("Falha ao registrar o tick pulado no agente")
+	}
+}
+
+// fail marca o agente como failed, guardando err no estado.
+func (r *Runner) fail(ctx context.Context, a agent.Agent, tick int64, err error) {
+	status := StatusFailed
+	state := make(map[string]interface{}, len(a.State)+1)
+	for k, v := range a.State {
+		state[k] = v
+	}
+	state[StateError] = map[string]interface{}{"message": err.Error(), "tick": tick}
+	if _, err := r.agents.UpdateAgent(ctx, a.ID, agent.UpdateAgentRequest{Status: &status, State: state}); err != nil {
+		logging.FromContext(ctx).WithError(err).WithField("agent_id", a.ID).Error("Falha ao marcar agente como failed")
+	}
+}
+
+// decideWithin roda decide num goroutine e espera até o fim de ctx; done é
+// falso se o prazo acabou antes. O comportamento abandonado termina
+// sozinho e o resultado é descartado; o contexto cancelado avisa os que o
+// consultam, como o scripted.
+func decideWithin(ctx context.Context, b Behavior, a agent.Agent, env Environment) (outcome, bool) {
+	ch := make(chan outcome, 1)
+	go func() {
+		var o outcome
+		o.actions, o.stack, o.err = decide(ctx, b, a, env)
+		ch <- o
+	}()
+	select {
+	case o := <-ch:
+		return o, true
+	case <-ctx.Done():
+		return outcome{}, false
+	}
+}
+
+// outcome é o retorno de decide.
+type outcome struct {
+	actions []Action
+	stack   []byte
+	err     error
+}
+
+// decide chama o comportamento, recuperando um pânico (e a pilha dele) para
+// que o tick continue com os outros agentes. stack só vem com pânico.
+func decide(ctx context.Context, b Behavior, a agent.Agent, env Environment) (actions []Action, stack []byte, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			actions, stack, err = nil, debug.Stack(), fmt.Errorf("panic: %v", p)
+		}
+	}()
+	if f, ok := b.(FallibleBehavior); ok {
+		actions, err = f.DecideErr(ctx, a, env)
+		return actions, nil, err
+	}
+	return b.Decide(ctx, a, env), nil, nil
+}
+
+// apply aplica as ações na ordem decidida. move e set_state viram uma só
+// atualização do agente, feita depois das demais ações do tick.
+func (r *Runner) apply(ctx context.Context, a agent.Agent, actions []Action) {
+	log := logging.FromContext(ctx).WithField("agent_id", a.ID)
+	var update agent.UpdateAgentRequest
+	updates := 0
+	for _, act := range actions {
+		var err error
+		result := "applied"
+		switch act.Name {
+		case ActionMove:
+			var pos agent.Position
+			if err = decode(act.Params, &pos); err == nil {
+				update.Position = &pos
+				updates++
+			}
+		case ActionSetState:
+			if update.State == nil {
+				update.State = make(map[string]interface{}, len(a.State)+len(act.Params))
+				for k, v := range a.State {
+					update.State[k] = v
+				}
+			}
+			for k, v := range act.Params {
+				update.State[k] = v
+			}
+			updates++
+		case ActionSendMessage:
+			err = r.send(ctx, a, act.Params)
+		default:
+			// O comportamento foi ligado por um operador e age pelo serviço,
+			// sem principal: não passa pela política das ações.
+			result = "submitted"
+			_, err = r.submitter.Submit(action.AsService(ctx), a.ID, agent.ActionRequest{Action: act.Name, Params: act.Params}, action.PriorityNormal)
+		}
+		if err != nil {
+			decided.WithLabelValues("failed").Inc()
+			log.WithError(err).WithField("action", act.Name).Warn("Falha ao aplicar ação decidida pelo comportamento")
+			continue
+		}
+		if act.Name != ActionMove && act.Name != ActionSetState {
+			decided.WithLabelValues(result).Inc()
+		}
+	}
+	if updates == 0 {
+		return
+	}
+	if _, err := r.agents.UpdateAgent(ctx, a.ID, update); err != nil {
+		decided.WithLabelValues("failed").Add(float64(updates))
+		log.WithError(err).Warn("Falha ao atualizar agente com as ações do comportamento")
+		return
+	}
+	decided.WithLabelValues("applied").Add(float64(updates))
+}
+
+// send envia a mensagem de uma ação send_message, entregue no próximo tick.
+func (r *Runner) send(ctx context.Context, a agent.Agent, params map[string]interface{}) error {
+	var p struct {
+		To      string                 `json:"to"`
+		ToType  string                 `json:"to_type"`
+		Payload map[string]interface{} `json:"payload"`
+	}
+	if err := decode(params, &p); err != nil {
+		return err
+	}
+	if (p.To == "") == (p.ToType == "") {
+		return fmt.Errorf("send_message precisa de to ou to_type, e só de um deles")
+	}
+	_, err := r.messages.SendMessage(ctx, agentmsg.Message{
+		SimulationID: a.SimulationID,
+		From:         a.ID,
+		To:           p.To,
+		ToType:       p.ToType,
+		Payload:      p.Payload,
+	})
+	return err
+}
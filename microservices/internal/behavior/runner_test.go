@@ -0,0 +1,197 @@
+package behavior
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/agentmsg"
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/fake"
+)
+
+func init() {
+	sql.Register("behaviortest", walkDriver{})
+}
+
+// walkDriver responde a behavior.for_agents dando random-walk a todo agente
+// pedido, sem Postgres: os benchmarks medem o runner, não a consulta. A
+// gravação de behavior.set é aceita e retorna walkUpdated.
+type walkDriver struct{}
+
+func (walkDriver) Open(string) (driver.Conn, error) { return walkConn{}, nil }
+
+type walkConn struct{}
+
+func (walkConn) Prepare(query string) (driver.Stmt, error) { return walkStmt{query}, nil }
+func (walkConn) Close() error                              { return nil }
+func (walkConn) Begin() (driver.Tx, error)                 { return nil, errors.New("sem transações") }
+
+type walkStmt struct{ query string }
+
+func (walkStmt) Close() error  { return nil }
+func (walkStmt) NumInput() int { return -1 }
+
+func (walkStmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, errors.New("só consultas")
+}
+
+func (s walkStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if strings.Contains(s.query, "INSERT INTO agent_behaviors") {
+		return &setRows{}, nil
+	}
+	if !strings.Contains(s.query, "FROM agent_behaviors WHERE agent_id = ANY") {
+		return nil, fmt.Errorf("consulta inesperada: %s", s.query)
+	}
+	// pq.StringArray chega como o literal do array: {"id1","id2",...}.
+	list := strings.Trim(fmt.Sprint(args[0]), "{}")
+	var ids []string
+	if list != "" {
+		for _, id := range strings.Split(list, ",") {
+			ids = append(ids, strings.Trim(id, `"`))
+		}
+	}
+	return &walkRows{ids: ids}, nil
+}
+
+// walkUpdated é o updated_at das atribuições: fixo, para o runner reusar o
+// comportamento criado no primeiro tick.
+var walkUpdated = time.Date(2026, 3, 4, 5, 0, 0, 0, time.UTC)
+
+type walkRows struct {
+	ids []string
+	i   int
+}
+
+func (*walkRows) Columns() []string { return []string{"agent_id", "behavior", "params", "updated_at"} }
+func (*walkRows) Close() error      { return nil }
+
+func (r *walkRows) Next(dest []driver.Value) error {
+	if r.i == len(r.ids) {
+		return io.EOF
+	}
+	dest[0], dest[1], dest[2], dest[3] = r.ids[r.i], "random-walk", []byte(`{"step":12,"turn":30}`), walkUpdated
+	r.i++
+	return nil
+}
+
+// setRows é o RETURNING updated_at de behavior.set.
+type setRows struct{ done bool }
+
+func (*setRows) Columns() []string { return []string{"updated_at"} }
+func (*setRows) Close() error      { return nil }
+
+func (r *setRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	dest[0], r.done = walkUpdated, true
+	return nil
+}
+
+// simulationAgents lista em páginas e atualiza os agentes de uma simulação,
+// como agent.Service, sem a ordenação a cada página de fake.Agents, que
+// dominaria a medida com 10 mil agentes.
+type simulationAgents struct {
+	mu     sync.Mutex
+	agents []agent.Agent
+	index  map[string]int
+}
+
+func newSimulationAgents(n int) *simulationAgents {
+	r := rand.New(rand.NewSource(1))
+	s := &simulationAgents{agents: make([]agent.Agent, n), index: make(map[string]int, n)}
+	for i := range s.agents {
+		s.agents[i] = agent.Agent{
+			ID:           fmt.Sprintf("00000000-0000-4000-8000-%012d", i),
+			SimulationID: "sim-1",
+			Type:         "car",
+			Status:       "active",
+			Position: agent.Position{
+				Lat:     -23.55 + (r.Float64()-0.5)*0.09,
+				Lon:     -46.63 + (r.Float64()-0.5)*0.1,
+				Heading: r.Float64() * 360,
+			},
+		}
+		s.index[s.agents[i].ID] = i
+	}
+	return s
+}
+
+func (s *simulationAgents) ListAgents(_ context.Context, f agent.Filter) ([]agent.Agent, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	from := (f.Page - 1) * f.PageSize
+	if from >= len(s.agents) {
+		return nil, len(s.agents), nil
+	}
+	to := min(from+f.PageSize, len(s.agents))
+	return append([]agent.Agent(nil), s.agents[from:to]...), len(s.agents), nil
+}
+
+func (s *simulationAgents) UpdateAgent(_ context.Context, id string, req agent.UpdateAgentRequest) (*agent.Agent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, ok := s.index[id]
+	if !ok {
+		return nil, agent.ErrNotFound
+	}
+	if req.Position != nil {
+		s.agents[i].Position = *req.Position
+	}
+	if req.Status != nil {
+		s.agents[i].Status = *req.Status
+	}
+	a := s.agents[i]
+	return &a, nil
+}
+
+type nopPublisher struct{}
+
+func (nopPublisher) Publish(context.Context, events.Event) {}
+
+// BenchmarkRunnerTick10kAgents mede um tick do runner com 10 mil agentes em
+// random-walk: a listagem em páginas com a busca das atribuições, a decisão
+// de cada agente com a leitura da caixa de mensagens (no miniredis) e a
+// atualização das posições, em um shard por CPU.
+func BenchmarkRunnerTick10kAgents(b *testing.B) {
+	db, err := sql.Open("behaviortest", "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { db.Close() })
+	client, _ := fake.Redis(b)
+	agents := newSimulationAgents(10000)
+	messages := agentmsg.NewBus(client, agents, nopPublisher{}, agentmsg.Config{InboxSize: 10, InboxTTL: time.Hour, MaxPending: 1000})
+	r := NewRunner(NewRegistry(Builtins()), NewRepository(db), agents, nil, messages, RunnerConfig{
+		TickInterval: time.Minute,
+		MaxActions:   10,
+		InboxSize:    10,
+		Workers:      runtime.GOMAXPROCS(0),
+		DecideBudget: time.Second,
+	})
+	ctx := context.Background()
+	first := agents.agents[0].Position
+	// O primeiro tick cria os comportamentos nos shards; os seguintes os
+	// reusam, como num tick de regime.
+	r.Tick(ctx, "sim-1", 0)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Tick(ctx, "sim-1", int64(i+1))
+	}
+	b.StopTimer()
+	if agents.agents[0].Position == first {
+		b.Fatal("o agente não se moveu")
+	}
+}
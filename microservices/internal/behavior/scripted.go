@@ -0,0 +1,541 @@
+package behavior
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"runtime/metrics"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/logging"
+)
+
+// ScriptLimits limita cada execução de um script, isto é, um agente num
+// tick.
+type ScriptLimits struct {
+	// MaxInstructions limita as instruções da VM Lua.
+	MaxInstructions int
+	// Timeout limita o tempo de relógio, que cobre também as funções da
+	// biblioteca (string.find com um padrão ruim, por exemplo).
+	Timeout time.Duration
+	// MaxMemoryBytes limita os bytes alocados durante a execução. A conta
+	// usa as alocações do processo inteiro, então é um teto folgado.
+	MaxMemoryBytes int
+	// MaxScriptBytes limita o tamanho do script salvo.
+	MaxScriptBytes int
+}
+
+const (
+	// maxScriptActions limita as chamadas de emit num tick.
+	maxScriptActions = 100
+	// maxScriptLogs limita as chamadas de log num tick; as excedentes são
+	// descartadas.
+	maxScriptLogs = 10
+	// maxLogBytes trunca cada linha de log.
+	maxLogBytes = 1024
+	// maxValueDepth limita o aninhamento das tabelas passadas a emit, o que
+	// também recusa tabelas que contêm a si mesmas.
+	maxValueDepth = 32
+	// memoryCheckEvery é o intervalo, em instruções, entre as conferências
+	// de memória.
+	memoryCheckEvery = 4
+	// maxCachedScripts limita os scripts compilados em cache; cheio, o cache
+	// é esvaziado.
+	maxCachedScripts = 1024
+)
+
+var (
+	errInstructionLimit = errors.New("instruction limit exceeded")
+	errMemoryLimit      = errors.New("memory limit exceeded")
+	errTimeLimit        = errors.New("time limit exceeded")
+	closedDone          = func() chan struct{} { c := make(chan struct{}); close(c); return c }()
+)
+
+// Scripted retorna o comportamento "scripted": um script Lua, guardado nos
+// parâmetros do agente, que roda inteiro a cada tick num interpretador
+// isolado. O script lê o agente na global agent e a simulação em env,
+// decide com emit(name, params) e registra com log(...).
+func Scripted(limits ScriptLimits) Definition {
+	c := &scriptCompiler{limits: limits, protos: map[[32]byte]*lua.FunctionProto{}}
+	return Definition{
+		Name:        "scripted",
+		Description: "Roda o script Lua de script a cada tick; o script lê agent e env e decide com emit(name, params)",
+		Schema:      mustSchema(`{"type": "object", "required": ["script"], "additionalProperties": false, "properties": {"script": {"type": "string", "minLength": 1}}}`),
+		New:         c.new,
+	}
+}
+
+// scriptCompiler compila os scripts, guardando o resultado para que o
+// runner não recompile o mesmo script a cada tick.
+type scriptCompiler struct {
+	limits ScriptLimits
+
+	mu     sync.Mutex
+	protos map[[32]byte]*lua.FunctionProto
+}
+
+func (c *scriptCompiler) new(params map[string]interface{}) (Behavior, error) {
+	src, _ := params["script"].(string)
+	if len(src) > c.limits.MaxScriptBytes {
+		return nil, fmt.Errorf("script: must have at most %d bytes", c.limits.MaxScriptBytes)
+	}
+	proto, err := c.compile(src)
+	if err != nil {
+		return nil, fmt.Errorf("script: %v", err)
+	}
+	return &scripted{proto: proto, limits: c.limits}, nil
+}
+
+func (c *scriptCompiler) compile(src string) (*lua.FunctionProto, error) {
+	key := sha256.Sum256([]byte(src))
+	c.mu.Lock()
+	proto, ok := c.protos[key]
+	c.mu.Unlock()
+	if ok {
+		return proto, nil
+	}
+	chunk, err := parse.Parse(strings.NewReader(src), "script")
+	if err != nil {
+		return nil, err
+	}
+	// O protótipo compilado não muda e pode ser usado por vários
+	// interpretadores.
+	if proto, err = lua.Compile(chunk, "script"); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	if len(c.protos) >= maxCachedScripts {
+		c.protos = map[[32]byte]*lua.FunctionProto{}
+	}
+	c.protos[key] = proto
+	c.mu.Unlock()
+	return proto, nil
+}
+
+type scripted struct {
+	proto  *lua.FunctionProto
+	limits ScriptLimits
+}
+
+// Decide descarta o erro do script; o runner usa DecideErr.
+func (b *scripted) Decide(ctx context.Context, s agent.Agent, env Environment) []Action {
+	actions, _ := b.DecideErr(ctx, s, env)
+	return actions
+}
+
+// DecideErr roda o script num interpretador novo, com só as bibliotecas
+// base, string, table e math (sem load, require, print nem coroutine).
+// Estourar um limite, ou um erro do script, retorna erro.
+func (b *scripted) DecideErr(ctx context.Context, s agent.Agent, env Environment) ([]Action, error) {
+	L := lua.NewState(lua.Options{
+		SkipOpenLibs:        true,
+		CallStackSize:       200,
+		RegistrySize:        1024,
+		RegistryMaxSize:     256 * 1024,
+		MinimizeStackMemory: true,
+	})
+	run := &scriptRun{
+		log: logging.FromContext(ctx).WithFields(logrus.Fields{"agent_id": s.ID, "tick": env.Tick}),
+	}
+	b.open(L, run, s, env)
+
+	// O script roda numa goroutine para que uma função da biblioteca presa
+	// não segure o tick: passado o timeout, ela é abandonada e termina
+	// sozinha, já que o contexto cancelado a para na próxima instrução.
+	ctx, cancel := context.WithTimeout(ctx, b.limits.Timeout)
+	defer cancel()
+	bud := newBudget(ctx, b.limits)
+	done := make(chan error, 1)
+	go func() {
+		defer L.Close()
+		L.SetContext(bud)
+		L.Push(L.NewFunctionFromProto(b.proto))
+		done <- L.PCall(0, 0, nil)
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			return run.actions, nil
+		}
+		if berr := bud.Err(); berr != nil {
+			return nil, fmt.Errorf("script: %w", berr)
+		}
+		var apiErr *lua.ApiError
+		if errors.As(err, &apiErr) {
+			return nil, fmt.Errorf("script: %s", apiErr.Object.String())
+		}
+		return nil, fmt.Errorf("script: %w", err)
+	case <-ctx.Done():
+		return nil, fmt.Errorf("script: %w (%s)", errTimeLimit, b.limits.Timeout)
+	}
+}
+
+// open abre as bibliotecas permitidas e define as globais do script.
+func (b *scripted) open(L *lua.LState, run *scriptRun, s agent.Agent, env Environment) {
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range []string{"collectgarbage", "dofile", "load", "loadfile", "loadstring", "module", "newproxy", "print", "require", "_printregs", "coroutine"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+
+	limit := b.limits.MaxMemoryBytes
+	str := L.GetGlobal("string").(*lua.LTable)
+	str.RawSetString("rep", L.NewFunction(boundedRep(limit)))
+	str.RawSetString("format", L.NewFunction(boundedFormat(str.RawGetString("format").(*lua.LFunction).GFunction)))
+	str.RawSetString("gsub", L.NewFunction(boundedGsub(limit, str.RawGetString("gsub").(*lua.LFunction).GFunction)))
+	tbl := L.GetGlobal("table").(*lua.LTable)
+	tbl.RawSetString("concat", L.NewFunction(boundedConcat(limit, tbl.RawGetString("concat").(*lua.LFunction).GFunction)))
+
+	// math.random sorteia a partir do agente e do tick, como random-walk,
+	// para que a mesma entrada dê as mesmas ações.
+	h := fnv.New64a()
+	h.Write([]byte(s.ID))
+	rng := rand.New(rand.NewSource(int64(h.Sum64()) ^ env.Tick))
+	m := L.GetGlobal("math").(*lua.LTable)
+	m.RawSetString("random", L.NewFunction(mathRandom(rng)))
+	m.RawSetString("randomseed", lua.LNil)
+
+	L.SetGlobal("agent", toLua(L, map[string]interface{}{
+		"id":      s.ID,
+		"type":    s.Type,
+		"name":    s.Name,
+		"status":  s.Status,
+		"energy":  s.Energy,
+		"lat":     s.Position.Lat,
+		"lon":     s.Position.Lon,
+		"heading": s.Position.Heading,
+		"speed":   s.Position.Speed,
+		"state":   s.State,
+		"tags":    s.Tags,
+	}))
+	messages := make([]interface{}, len(env.Messages))
+	for i, msg := range env.Messages {
+		messages[i] = map[string]interface{}{
+			"id":        msg.ID,
+			"from":      msg.From,
+			"payload":   msg.Payload,
+			"sent_tick": msg.SentTick,
+		}
+	}
+	L.SetGlobal("env", toLua(L, map[string]interface{}{
+		"simulation_id": env.SimulationID,
+		"tick":          env.Tick,
+		"tick_interval": env.TickInterval.Seconds(),
+		"messages":      messages,
+	}))
+	L.SetGlobal("emit", L.NewFunction(run.emit))
+	L.SetGlobal("log", L.NewFunction(run.logf))
+}
+
+// scriptRun junta o que o script produz num tick.
+type scriptRun struct {
+	actions []Action
+	logs    int
+	log     *logrus.Entry
+}
+
+// emit(name, params) decide uma ação; params é uma tabela com chaves
+// string, opcional.
+func (r *scriptRun) emit(L *lua.LState) int {
+	name := L.CheckString(1)
+	if name == "" {
+		L.ArgError(1, "action name is empty")
+	}
+	var params map[string]interface{}
+	if L.Get(2) != lua.LNil {
+		v, err := fromLua(L.CheckTable(2), 0)
+		if err != nil {
+			L.ArgError(2, err.Error())
+		}
+		if params, _ = v.(map[string]interface{}); params == nil {
+			L.ArgError(2, "params must be a table with string keys")
+		}
+	}
+	if len(r.actions) >= maxScriptActions {
+		L.RaiseError("more than %d actions in one tick", maxScriptActions)
+	}
+	r.actions = append(r.actions, Action{Name: name, Params: params})
+	return 0
+}
+
+// logf(...) registra os argumentos, separados por espaço, no log do
+// serviço.
+func (r *scriptRun) logf(L *lua.LState) int {
+	r.logs++
+	if r.logs > maxScriptLogs {
+		return 0
+	}
+	parts := make([]string, L.GetTop())
+	for i := range parts {
+		parts[i] = L.ToStringMeta(L.Get(i + 1)).String()
+	}
+	msg := strings.Join(parts, " ")
+	if len(msg) > maxLogBytes {
+		msg = msg[:maxLogBytes]
+	}
+	r.log.WithField("message", msg).Info("Log do script do agente")
+	return 0
+}
+
+// budget é o contexto do interpretador. A VM chama Done a cada instrução,
+// o que permite contar as instruções e conferir a memória sem ganchos na
+// VM. Só a goroutine do interpretador a usa.
+type budget struct {
+	context.Context
+	left     int
+	memLimit uint64
+	start    uint64
+	sample   []metrics.Sample
+	err      error
+}
+
+func newBudget(ctx context.Context, limits ScriptLimits) *budget {
+	b := &budget{
+		Context:  ctx,
+		left:     limits.MaxInstructions,
+		memLimit: uint64(limits.MaxMemoryBytes),
+		sample:   []metrics.Sample{{Name: "/gc/heap/allocs:bytes"}},
+	}
+	b.start = b.allocated()
+	return b
+}
+
+func (b *budget) Done() <-chan struct{} {
+	if b.err != nil {
+		return closedDone
+	}
+	b.left--
+	if b.left < 0 {
+		b.err = errInstructionLimit
+		return closedDone
+	}
+	if b.left%memoryCheckEvery == 0 && b.allocated()-b.start > b.memLimit {
+		b.err = errMemoryLimit
+		return closedDone
+	}
+	return b.Context.Done()
+}
+
+func (b *budget) Err() error {
+	if b.err != nil {
+		return b.err
+	}
+	if err := b.Context.Err(); errors.Is(err, context.DeadlineExceeded) {
+		return errTimeLimit
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (b *budget) allocated() uint64 {
+	metrics.Read(b.sample)
+	return b.sample[0].Value.Uint64()
+}
+
+// As funções abaixo substituem as da biblioteca que alocam muito numa só
+// chamada, fora do alcance da conferência de memória entre instruções.
+
+func boundedRep(limit int) lua.LGFunction {
+	return func(L *lua.LState) int {
+		s, n := L.CheckString(1), L.CheckInt(2)
+		if n <= 0 {
+			L.Push(lua.LString(""))
+			return 1
+		}
+		if len(s) > 0 && n > limit/len(s) {
+			L.RaiseError("string.rep: result too large")
+		}
+		L.Push(lua.LString(strings.Repeat(s, n)))
+		return 1
+	}
+}
+
+// boundedFormat recusa larguras e precisões de mais de dois dígitos, como
+// o Lua 5.1.
+func boundedFormat(format lua.LGFunction) lua.LGFunction {
+	return func(L *lua.LState) int {
+		f := L.CheckString(1)
+		for i := 0; i < len(f); i++ {
+			if f[i] != '%' {
+				continue
+			}
+			digits := 0
+			for i++; i < len(f) && strings.IndexByte("-+ #0123456789.", f[i]) >= 0; i++ {
+				if f[i] >= '0' && f[i] <= '9' {
+					if digits++; digits > 2 {
+						L.RaiseError("string.format: invalid format (width or precision too long)")
+					}
+				} else if f[i] == '.' {
+					digits = 0
+				}
+			}
+		}
+		return format(L)
+	}
+}
+
+// boundedGsub aceita só substituição string e limita o tamanho do
+// resultado.
+func boundedGsub(limit int, gsub lua.LGFunction) lua.LGFunction {
+	return func(L *lua.LState) int {
+		s := L.CheckString(1)
+		repl := L.CheckString(3)
+		if (len(s)+1)*(len(repl)+1) > limit {
+			L.RaiseError("string.gsub: result too large")
+		}
+		return gsub(L)
+	}
+}
+
+func boundedConcat(limit int, concat lua.LGFunction) lua.LGFunction {
+	return func(L *lua.LState) int {
+		t := L.CheckTable(1)
+		sep := len(L.OptString(2, ""))
+		size := 0
+		for i := 1; i <= t.Len(); i++ {
+			if s, ok := t.RawGetInt(i).(lua.LString); ok {
+				size += len(s) + sep
+			} else {
+				size += 24 + sep
+			}
+			if size > limit {
+				L.RaiseError("table.concat: result too large")
+			}
+		}
+		return concat(L)
+	}
+}
+
+func mathRandom(rng *rand.Rand) lua.LGFunction {
+	return func(L *lua.LState) int {
+		switch L.GetTop() {
+		case 0:
+			L.Push(lua.LNumber(rng.Float64()))
+		case 1:
+			n := L.CheckInt(1)
+			if n < 1 {
+				L.ArgError(1, "interval is empty")
+			}
+			L.Push(lua.LNumber(rng.Intn(n) + 1))
+		default:
+			lo, hi := L.CheckInt(1), L.CheckInt(2)
+			if lo > hi {
+				L.ArgError(2, "interval is empty")
+			}
+			L.Push(lua.LNumber(rng.Intn(hi-lo+1) + lo))
+		}
+		return 1
+	}
+}
+
+// toLua converte um valor JSON para Lua.
+func toLua(L *lua.LState, v interface{}) lua.LValue {
+	switch v := v.(type) {
+	case nil:
+		return lua.LNil
+	case bool:
+		return lua.LBool(v)
+	case string:
+		return lua.LString(v)
+	case map[string]interface{}:
+		t := L.CreateTable(0, len(v))
+		for k, x := range v {
+			t.RawSetString(k, toLua(L, x))
+		}
+		return t
+	case []interface{}:
+		t := L.CreateTable(len(v), 0)
+		for i, x := range v {
+			t.RawSetInt(i+1, toLua(L, x))
+		}
+		return t
+	case []string:
+		t := L.CreateTable(len(v), 0)
+		for i, x := range v {
+			t.RawSetInt(i+1, lua.LString(x))
+		}
+		return t
+	}
+	if n, ok := number(v); ok {
+		return lua.LNumber(n)
+	}
+	return lua.LNil
+}
+
+// fromLua converte um valor Lua para JSON. Uma tabela com as chaves 1..n
+// vira lista; as demais precisam de chaves string.
+func fromLua(v lua.LValue, depth int) (interface{}, error) {
+	switch v := v.(type) {
+	case *lua.LNilType:
+		return nil, nil
+	case lua.LBool:
+		return bool(v), nil
+	case lua.LString:
+		return string(v), nil
+	case lua.LNumber:
+		f := float64(v)
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, errors.New("numbers must be finite")
+		}
+		return f, nil
+	case *lua.LTable:
+		if depth >= maxValueDepth {
+			return nil, errors.New("tables nested too deeply")
+		}
+		keys := 0
+		v.ForEach(func(lua.LValue, lua.LValue) { keys++ })
+		if n := v.Len(); n > 0 && n == keys {
+			out := make([]interface{}, n)
+			for i := range out {
+				x, err := fromLua(v.RawGetInt(i+1), depth+1)
+				if err != nil {
+					return nil, err
+				}
+				out[i] = x
+			}
+			return out, nil
+		}
+		out := make(map[string]interface{}, keys)
+		var err error
+		v.ForEach(func(k, x lua.LValue) {
+			if err != nil {
+				return
+			}
+			key, ok := k.(lua.LString)
+			if !ok {
+				err = fmt.Errorf("table keys must be strings, got %s", k.Type())
+				return
+			}
+			out[string(key)], err = fromLua(x, depth+1)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("%s values are not supported", v.Type())
+}
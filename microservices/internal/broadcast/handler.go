@@ -0,0 +1,166 @@
+// Package broadcast deixa outros serviços publicarem eventos para os
+// clientes do hub websocket (POST /api/v1/internal/broadcast), como o
+// serviço de clima com um aviso para a cidade inteira, sem conhecer o
+// modelo de eventos do agent-service.
+//
+// O serviço informa tópico, tipo e payload; o tipo precisa estar entre os
+// liberados em events.broadcast.allowed_types e o payload conferir com o
+// schema do registro. O evento vai ao barramento como os produzidos aqui:
+// chega ao hub de cada réplica pelo repasse (hubrelay), ao long-poll e aos
+// demais assinantes. Só chaves de API com o escopo broadcast publicam, e
+// cada chave tem um limite de publicações por janela, contado no Redis
+// para valer entre as réplicas.
+package broadcast
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/audit"
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/i18n"
+	"smart-city-microservices/internal/logging"
+)
+
+var published = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "agent_service",
+	Name:      "broadcasts_total",
+	Help:      "Eventos publicados por outros serviços, por resultado (published, invalid, rate_limited).",
+}, []string{"result"})
+
+const keyPrefix = "agent-service:broadcast:"
+
+// Counter conta as conexões desta réplica inscritas num tópico;
+// *websocket.Hub e *longpoll.Buffer o implementam.
+type Counter interface {
+	Subscribers(topic string) int
+}
+
+// Config configura o Handler.
+type Config struct {
+	AllowedTypes []string
+	RateLimit    int
+	RateWindow   time.Duration
+}
+
+// Request é o corpo de POST /internal/broadcast.
+type Request struct {
+	Topic   string          `json:"topic" binding:"required"`
+	Type    string          `json:"type" binding:"required"`
+	Payload json.RawMessage `json:"payload" binding:"required"`
+}
+
+// Result é a resposta de uma publicação. LocalConnections são as conexões
+// desta réplica inscritas no tópico na hora da publicação; as das demais
+// réplicas não são contadas.
+type Result struct {
+	Topic            string    `json:"topic"`
+	EventType        string    `json:"event_type"`
+	LocalConnections int       `json:"local_connections"`
+	PublishedAt      time.Time `json:"published_at"`
+}
+
+// Handler publica os eventos dos serviços.
+type Handler struct {
+	publisher events.Publisher
+	redis     redis.UniversalClient
+	counters  []Counter
+	allowed   map[string]bool
+	cfg       Config
+}
+
+// NewHandler cria o handler; counters são somados em LocalConnections.
+func NewHandler(publisher events.Publisher, client redis.UniversalClient, counters []Counter, cfg Config) *Handler {
+	allowed := make(map[string]bool, len(cfg.AllowedTypes))
+	for _, t := range cfg.AllowedTypes {
+		allowed[t] = true
+	}
+	return &Handler{publisher: publisher, redis: client, counters: counters, allowed: allowed, cfg: cfg}
+}
+
+// Broadcast responde POST /internal/broadcast com 202 e o Result. 400 para
+// tópico admin, tipo fora de allowed_types ou payload fora do schema; 429
+// com Retry-After acima do limite da chave.
+func (h *Handler) Broadcast(c *gin.Context) {
+	var req Request
+	if err := c.ShouldBindJSON(&req); err != nil {
+		i18n.BindError(c, err)
+		return
+	}
+	if req.Topic == events.TopicAdmin {
+		published.WithLabelValues("invalid").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "topic " + events.TopicAdmin + " is reserved"})
+		return
+	}
+	if !h.allowed[req.Type] {
+		published.WithLabelValues("invalid").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "event type not allowed: " + req.Type, "allowed_types": h.cfg.AllowedTypes})
+		return
+	}
+	e := events.New(req.Topic, req.Type, req.Payload)
+	e.Version = events.Schemas.Current(req.Type)
+	if err := events.Schemas.Validate(e); err != nil {
+		published.WithLabelValues("invalid").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload: " + err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	p := auth.FromGin(c)
+	retryAfter, err := h.take(ctx, p.Subject)
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).Error("Erro ao contar as publicações do serviço")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+	if retryAfter > 0 {
+		published.WithLabelValues("rate_limited").Inc()
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "broadcast rate limit exceeded (limit " + strconv.Itoa(h.cfg.RateLimit) + " per " + h.cfg.RateWindow.String() + ")"})
+		return
+	}
+
+	res := Result{Topic: req.Topic, EventType: e.VersionedType(), PublishedAt: e.OccurredAt}
+	for _, counter := range h.counters {
+		res.LocalConnections += counter.Subscribers(req.Topic)
+	}
+	h.publisher.Publish(ctx, e)
+	published.WithLabelValues("published").Inc()
+	audit.Record(ctx, "events.broadcast", logrus.Fields{
+		"topic": req.Topic, "event_type": res.EventType, "local_connections": res.LocalConnections,
+	})
+	c.JSON(http.StatusAccepted, res)
+}
+
+// take conta uma publicação da chave na janela atual e, acima do limite,
+// retorna o que falta para a janela acabar.
+func (h *Handler) take(ctx context.Context, subject string) (time.Duration, error) {
+	now := time.Now()
+	w := now.UnixNano() / int64(h.cfg.RateWindow)
+	end := time.Unix(0, (w+1)*int64(h.cfg.RateWindow))
+	key := keyPrefix + subject + ":" + strconv.FormatInt(w, 10)
+	var incr *redis.IntCmd
+	_, err := h.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, key)
+		pipe.ExpireAt(ctx, key, end.Add(time.Second))
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if incr.Val() <= int64(h.cfg.RateLimit) {
+		return 0, nil
+	}
+	return end.Sub(now), nil
+}
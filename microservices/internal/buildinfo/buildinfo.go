@@ -0,0 +1,37 @@
+// Package buildinfo expõe metadados de build injetados via ldflags.
+//
+// Exemplo:
+//
+//	go build -ldflags "\
+//	  -X smart-city-microservices/internal/buildinfo.Version=1.2.0 \
+//	  -X smart-city-microservices/internal/buildinfo.Commit=$(git rev-parse --short HEAD) \
+//	  -X smart-city-microservices/internal/buildinfo.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)" \
+//	  ./agent-service
+package buildinfo
+
+import "runtime"
+
+// Valores sobrescritos em tempo de build.
+var (
+	Version   = "1.1.0"
+	Commit    = "unknown"
+	BuildTime = "unknown"
+)
+
+// Info descreve o binário em execução.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get retorna as informações de build do binário atual.
+func Get() Info {
+	return Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+	}
+}
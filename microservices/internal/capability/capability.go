@@ -0,0 +1,172 @@
+// Package capability mantém as capacidades de cada agente, ex.: um sensor
+// que passou a medir a qualidade do ar com um firmware novo. Cada tipo de
+// agente declara as capacidades permitidas e as padrão; um agente que nunca
+// declarou as suas tem as padrão do tipo. As ações podem exigir
+// capacidades além do tipo (action.Definition.Capabilities).
+package capability
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"smart-city-microservices/internal/agent"
+)
+
+// EventChanged é publicado quando as capacidades efetivas de um agente
+// mudam.
+const EventChanged = "agent.capabilities_changed"
+
+var namePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,99}$`)
+
+// ValidName informa se o nome serve como capacidade.
+func ValidName(name string) bool {
+	return namePattern.MatchString(name)
+}
+
+// TypeDefinition são as capacidades de um tipo de agente.
+type TypeDefinition struct {
+	Name    string
+	Allowed []string
+	Default []string
+}
+
+// Registry guarda as capacidades permitidas e padrão de cada tipo.
+type Registry struct {
+	types map[string]TypeDefinition
+}
+
+// NewRegistry cria o registro. Tipos fora dele não têm capacidades.
+func NewRegistry(defs []TypeDefinition) *Registry {
+	r := &Registry{types: make(map[string]TypeDefinition, len(defs))}
+	for _, d := range defs {
+		d.Allowed, d.Default = normalize(d.Allowed), normalize(d.Default)
+		r.types[d.Name] = d
+	}
+	return r
+}
+
+// ForAgentType retorna as capacidades do tipo.
+func (r *Registry) ForAgentType(agentType string) TypeDefinition {
+	d, ok := r.types[agentType]
+	if !ok {
+		return TypeDefinition{Name: agentType, Allowed: []string{}, Default: []string{}}
+	}
+	return d
+}
+
+// Check confere se o tipo permite todas as capacidades. O erro retornado
+// satisfaz errors.Is(err, agent.ErrValidation).
+func (r *Registry) Check(agentType string, capabilities []string) error {
+	d := r.ForAgentType(agentType)
+	allowed := make(map[string]bool, len(d.Allowed))
+	for _, c := range d.Allowed {
+		allowed[c] = true
+	}
+	var rejected []string
+	for _, c := range capabilities {
+		if !allowed[c] {
+			rejected = append(rejected, c)
+		}
+	}
+	if len(rejected) > 0 {
+		return &NotAllowedError{AgentType: agentType, Rejected: rejected, Allowed: d.Allowed}
+	}
+	return nil
+}
+
+// NotAllowedError indica capacidades que o tipo do agente não permite.
+type NotAllowedError struct {
+	AgentType string
+	Rejected  []string
+	Allowed   []string
+}
+
+func (e *NotAllowedError) Error() string {
+	return fmt.Sprintf("capabilities %q are not allowed for agent type %q", e.Rejected, e.AgentType)
+}
+
+func (e *NotAllowedError) Unwrap() error { return agent.ErrValidation }
+
+// Store dá as capacidades efetivas dos agentes: as declaradas ou, sem
+// declaração, as padrão do tipo.
+type Store struct {
+	registry *Registry
+	repo     *Repository
+}
+
+// NewStore cria o acesso às capacidades.
+func NewStore(registry *Registry, repo *Repository) *Store {
+	return &Store{registry: registry, repo: repo}
+}
+
+// Capabilities retorna as capacidades efetivas do agente. Implementa
+// action.CapabilitySource.
+func (s *Store) Capabilities(ctx context.Context, ag *agent.Agent) ([]string, error) {
+	declared, ok, err := s.repo.Get(ctx, ag.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return s.registry.ForAgentType(ag.Type).Default, nil
+	}
+	return declared, nil
+}
+
+// ForAgents retorna as capacidades efetivas de cada agente, numa só
+// consulta.
+func (s *Store) ForAgents(ctx context.Context, agents []agent.Agent) (map[string][]string, error) {
+	ids := make([]string, len(agents))
+	for i, a := range agents {
+		ids[i] = a.ID
+	}
+	declared, err := s.repo.Many(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string][]string, len(agents))
+	for _, a := range agents {
+		if caps, ok := declared[a.ID]; ok {
+			out[a.ID] = caps
+		} else {
+			out[a.ID] = s.registry.ForAgentType(a.Type).Default
+		}
+	}
+	return out, nil
+}
+
+// normalize remove as repetidas e ordena; nunca retorna nil.
+func normalize(capabilities []string) []string {
+	seen := make(map[string]bool, len(capabilities))
+	out := make([]string, 0, len(capabilities))
+	for _, c := range capabilities {
+		if !seen[c] {
+			seen[c] = true
+			out = append(out, c)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// diff retorna as capacidades de next que não estão em prev e as de prev
+// que não estão em next.
+func diff(prev, next []string) (added, removed []string) {
+	in := func(list []string, c string) bool {
+		i := sort.SearchStrings(list, c)
+		return i < len(list) && list[i] == c
+	}
+	added, removed = []string{}, []string{}
+	for _, c := range next {
+		if !in(prev, c) {
+			added = append(added, c)
+		}
+	}
+	for _, c := range prev {
+		if !in(next, c) {
+			removed = append(removed, c)
+		}
+	}
+	return added, removed
+}
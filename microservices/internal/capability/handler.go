@@ -0,0 +1,180 @@
+package capability
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/audit"
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/i18n"
+	"smart-city-microservices/internal/logging"
+)
+
+// AgentGetter é o subconjunto de agent.Service usado pelo handler.
+type AgentGetter interface {
+	GetAgent(ctx context.Context, id string) (*agent.Agent, error)
+}
+
+// Handler expõe as capacidades dos agentes.
+type Handler struct {
+	registry  *Registry
+	repo      *Repository
+	agents    AgentGetter
+	publisher events.Publisher
+}
+
+// NewHandler cria o handler de capacidades.
+func NewHandler(registry *Registry, repo *Repository, agents AgentGetter, publisher events.Publisher) *Handler {
+	return &Handler{registry: registry, repo: repo, agents: agents, publisher: publisher}
+}
+
+// SetRequest é o corpo de PUT /agents/:id/capabilities.
+type SetRequest struct {
+	Capabilities []string `json:"capabilities" binding:"required"`
+}
+
+// View é a resposta das rotas de capacidades. Default indica que o agente
+// não declarou as suas e tem as padrão do tipo.
+type View struct {
+	AgentID      string   `json:"agent_id"`
+	AgentType    string   `json:"agent_type"`
+	Capabilities []string `json:"capabilities"`
+	Default      bool     `json:"default"`
+	Allowed      []string `json:"allowed"`
+}
+
+// Get responde GET /agents/:id/capabilities.
+func (h *Handler) Get(c *gin.Context) {
+	ag, ok := h.agent(c)
+	if !ok {
+		return
+	}
+	caps, declared, err := h.repo.Get(c.Request.Context(), ag.ID)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, h.view(ag, caps, declared))
+}
+
+// Set responde PUT /agents/:id/capabilities: grava as capacidades
+// declaradas do agente, que precisam ser permitidas pelo tipo (senão 422
+// com as permitidas). Se as efetivas mudaram, registra na auditoria e
+// publica EventChanged.
+func (h *Handler) Set(c *gin.Context) {
+	var req SetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		i18n.BindError(c, err)
+		return
+	}
+	caps := normalize(req.Capabilities)
+	ag, ok := h.agent(c)
+	if !ok {
+		return
+	}
+	var notAllowed *NotAllowedError
+	if err := h.registry.Check(ag.Type, caps); errors.As(err, &notAllowed) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":      err.Error(),
+			"agent_type": ag.Type,
+			"rejected":   notAllowed.Rejected,
+			"allowed":    notAllowed.Allowed,
+		})
+		return
+	}
+
+	var by string
+	if p := auth.FromGin(c); p != nil {
+		by = p.Subject
+	}
+	prev, declared, err := h.repo.Set(c.Request.Context(), ag.ID, caps, by)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	h.changed(c.Request.Context(), ag, h.effective(ag, prev, declared), caps)
+	c.JSON(http.StatusOK, h.view(ag, caps, true))
+}
+
+// Reset responde DELETE /agents/:id/capabilities: remove a declaração do
+// agente, que volta às capacidades padrão do tipo.
+func (h *Handler) Reset(c *gin.Context) {
+	ag, ok := h.agent(c)
+	if !ok {
+		return
+	}
+	prev, declared, err := h.repo.Delete(c.Request.Context(), ag.ID)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	h.changed(c.Request.Context(), ag, h.effective(ag, prev, declared), h.registry.ForAgentType(ag.Type).Default)
+	c.JSON(http.StatusOK, h.view(ag, nil, false))
+}
+
+// ListByAgentType responde GET /agent-types/:type/capabilities com as
+// capacidades permitidas e as padrão do tipo.
+func (h *Handler) ListByAgentType(c *gin.Context) {
+	d := h.registry.ForAgentType(c.Param("type"))
+	c.JSON(http.StatusOK, gin.H{"agent_type": d.Name, "allowed": d.Allowed, "default": d.Default})
+}
+
+// changed registra e publica a mudança das capacidades efetivas, se houve.
+func (h *Handler) changed(ctx context.Context, ag *agent.Agent, prev, next []string) {
+	added, removed := diff(prev, next)
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+	audit.Record(ctx, "agent.capabilities_changed", logrus.Fields{"agent_id": ag.ID, "added": added, "removed": removed})
+	h.publisher.Publish(ctx, events.New(events.TopicAgents, EventChanged, events.AgentCapabilitiesChangedV1{
+		AgentID:      ag.ID,
+		AgentType:    ag.Type,
+		ProjectID:    ag.ProjectID,
+		Capabilities: next,
+		Added:        added,
+		Removed:      removed,
+	}))
+}
+
+func (h *Handler) effective(ag *agent.Agent, caps []string, declared bool) []string {
+	if !declared {
+		return h.registry.ForAgentType(ag.Type).Default
+	}
+	return caps
+}
+
+func (h *Handler) view(ag *agent.Agent, caps []string, declared bool) View {
+	return View{
+		AgentID:      ag.ID,
+		AgentType:    ag.Type,
+		Capabilities: h.effective(ag, caps, declared),
+		Default:      !declared,
+		Allowed:      h.registry.ForAgentType(ag.Type).Allowed,
+	}
+}
+
+// agent busca o agente de :id. Responde ao cliente e retorna false se ele
+// não existe ou a busca falhou.
+func (h *Handler) agent(c *gin.Context) (*agent.Agent, bool) {
+	ag, err := h.agents.GetAgent(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, agent.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "agent not found"})
+		return nil, false
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return nil, false
+	}
+	return ag, true
+}
+
+func (h *Handler) internalError(c *gin.Context, err error) {
+	logging.FromContext(c.Request.Context()).WithError(err).Error("Erro no handler de capacidades")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
@@ -0,0 +1,100 @@
+package capability
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+
+	"smart-city-microservices/internal/instrument"
+)
+
+// Repository persiste as capacidades declaradas dos agentes no PostgreSQL.
+type Repository struct {
+	db *instrument.DB
+}
+
+// NewRepository cria o repositório.
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: instrument.NewDB(db)}
+}
+
+// Get retorna as capacidades declaradas do agente; ok é falso se ele nunca
+// declarou nenhuma.
+func (r *Repository) Get(ctx context.Context, agentID string) (capabilities []string, ok bool, err error) {
+	err = r.db.QueryRow(ctx, "capability.get",
+		`SELECT capabilities FROM agent_capabilities WHERE agent_id = $1`, agentID,
+	).Scan((*pq.StringArray)(&capabilities))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return normalize(capabilities), true, nil
+}
+
+// Many retorna as capacidades declaradas dos agentes que têm uma
+// declaração.
+func (r *Repository) Many(ctx context.Context, agentIDs []string) (map[string][]string, error) {
+	out := make(map[string][]string, len(agentIDs))
+	if len(agentIDs) == 0 {
+		return out, nil
+	}
+	rows, err := r.db.Query(ctx, "capability.many", `
+		SELECT agent_id, capabilities FROM agent_capabilities
+		WHERE agent_id = ANY($1::uuid[])`, pq.Array(agentIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var caps []string
+		if err := rows.Scan(&id, (*pq.StringArray)(&caps)); err != nil {
+			return nil, err
+		}
+		out[id] = normalize(caps)
+	}
+	return out, rows.Err()
+}
+
+// Set grava as capacidades declaradas do agente e retorna as anteriores;
+// ok é falso se ele não tinha declaração.
+func (r *Repository) Set(ctx context.Context, agentID string, capabilities []string, updatedBy string) (previous []string, ok bool, err error) {
+	var had bool
+	err = r.db.QueryRow(ctx, "capability.set", `
+		WITH prev AS (
+			SELECT capabilities FROM agent_capabilities WHERE agent_id = $1
+		), upsert AS (
+			INSERT INTO agent_capabilities (agent_id, capabilities, updated_by)
+			VALUES ($1, $2, NULLIF($3, ''))
+			ON CONFLICT (agent_id) DO UPDATE SET
+				capabilities = EXCLUDED.capabilities,
+				updated_by = EXCLUDED.updated_by,
+				updated_at = CURRENT_TIMESTAMP
+		)
+		SELECT EXISTS (SELECT 1 FROM prev), COALESCE((SELECT capabilities FROM prev), '{}')`,
+		agentID, pq.Array(capabilities), updatedBy,
+	).Scan(&had, (*pq.StringArray)(&previous))
+	if err != nil {
+		return nil, false, err
+	}
+	return normalize(previous), had, nil
+}
+
+// Delete remove a declaração do agente, que volta às capacidades padrão do
+// tipo, e retorna a anterior; ok é falso se ele não tinha declaração.
+func (r *Repository) Delete(ctx context.Context, agentID string) (previous []string, ok bool, err error) {
+	err = r.db.QueryRow(ctx, "capability.delete",
+		`DELETE FROM agent_capabilities WHERE agent_id = $1 RETURNING capabilities`, agentID,
+	).Scan((*pq.StringArray)(&previous))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return normalize(previous), true, nil
+}
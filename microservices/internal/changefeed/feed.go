@@ -0,0 +1,385 @@
+// Package changefeed mantém o feed de mudanças dos agentes: um stream Redis
+// só de acréscimos, com as entradas no formato do outbox, lido por cursor
+// (o id da entrada no stream) para que sistemas externos espelhem a tabela
+// de agentes sem baixá-la inteira.
+//
+// O feed não tem lacunas para cursores dentro da janela de retenção. Um
+// cursor anterior a uma entrada já cortada do stream, ou a uma gravação que
+// falhou, exige uma nova carga completa (ErrResyncRequired).
+package changefeed
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/events/outbox"
+	"smart-city-microservices/internal/logging"
+)
+
+// Operações de uma mudança.
+const (
+	OpCreate = "create"
+	OpUpdate = "update"
+	OpDelete = "delete"
+)
+
+// ops mapeia os eventos gravados no feed para a operação.
+var ops = map[string]string{
+	"agent.created": OpCreate,
+	"agent.updated": OpUpdate,
+	"agent.deleted": OpDelete,
+}
+
+// writeTimeout limita a gravação de uma mudança, feita durante o Publish.
+const writeTimeout = 2 * time.Second
+
+// scanCount é quantas entradas cada XRANGE de Deleted lê.
+const scanCount = 1000
+
+// ErrResyncRequired indica que o cursor saiu da janela de retenção.
+var ErrResyncRequired = errors.New("cursor is outside the retention window; full resync required")
+
+// ErrInvalidCursor indica um cursor malformado.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+var (
+	written = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "agent_change_feed_writes_total",
+		Help:      "Mudanças de agentes gravadas no feed, por resultado (written, failed). Cada falha invalida os cursores anteriores.",
+	}, []string{"result"})
+
+	resyncs = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "agent_change_feed_resyncs_total",
+		Help:      "Leituras do feed recusadas com 410 por cursor fora da janela de retenção.",
+	})
+)
+
+// markType é o tipo das entradas de marca, ignoradas na leitura.
+const markType = "changefeed.mark"
+
+// markScript grava uma entrada de marca no stream (KEYS[2]) e guarda seu id
+// como o menor cursor válido (KEYS[1]). Com ARGV[1] = "init", só grava se
+// ainda não houver um. Como o id vem do próprio stream, toda entrada
+// posterior tem id maior que a marca, e todo cursor já entregue, menor.
+var markScript = redis.NewScript(`
+if ARGV[1] == 'init' then
+	local cur = redis.call('GET', KEYS[1])
+	if cur then
+		return cur
+	end
+end
+local id = redis.call('XADD', KEYS[2], '*', 'type', ARGV[2])
+redis.call('SET', KEYS[1], id)
+return id
+`)
+
+// Config configura o feed.
+type Config struct {
+	Stream string
+	// Retention é a janela de retenção: as entradas mais antigas são
+	// cortadas (aproximadamente) a cada gravação.
+	Retention time.Duration
+}
+
+// Change é uma mudança de um agente.
+type Change struct {
+	Cursor     string    `json:"cursor"`
+	Op         string    `json:"op"`
+	AgentID    string    `json:"agent_id"`
+	ProjectID  string    `json:"project_id,omitempty"`
+	OccurredAt time.Time `json:"occurred_at"`
+	// Agent é o estado completo do agente em create e update.
+	Agent json.RawMessage `json:"agent,omitempty"`
+}
+
+// Feed grava as mudanças dos agentes recebidas do barramento e as lê por
+// cursor.
+type Feed struct {
+	redis redis.UniversalClient
+	cfg   Config
+
+	mu sync.Mutex
+	// lost indica uma gravação perdida ainda não marcada; enquanto houver
+	// uma, nada é gravado, para que nenhum cursor posterior a ela valha.
+	lost bool
+}
+
+// New cria o feed.
+func New(client redis.UniversalClient, cfg Config) *Feed {
+	return &Feed{redis: client, cfg: cfg}
+}
+
+func (f *Feed) validFromKey() string { return f.cfg.Stream + ":valid_from" }
+
+// Handle grava no stream as mudanças de agentes. A gravação é síncrona, para
+// que nenhuma mudança seja descartada por buffer cheio; se falhar, os
+// cursores anteriores à falha passam a exigir nova carga completa.
+func (f *Feed) Handle(ctx context.Context, e events.Event) {
+	if e.Topic != events.TopicAgents {
+		return
+	}
+	if _, ok := ops[e.Type]; !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), writeTimeout)
+	defer cancel()
+	log := logging.FromContext(ctx).WithField("event_type", e.Type)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.lost {
+		if _, err := f.mark(ctx, "bump"); err != nil {
+			written.WithLabelValues("failed").Inc()
+			log.WithError(err).Error("Falha ao marcar a lacuna do feed de mudanças; mudança descartada")
+			return
+		}
+		f.lost = false
+	}
+	err := f.write(ctx, e)
+	if err == nil {
+		written.WithLabelValues("written").Inc()
+		return
+	}
+	written.WithLabelValues("failed").Inc()
+	log.WithError(err).Error("Falha ao gravar mudança no feed de agentes; os cursores anteriores exigirão nova carga")
+	if _, err := f.mark(ctx, "bump"); err != nil {
+		f.lost = true
+	}
+}
+
+func (f *Feed) write(ctx context.Context, e events.Event) error {
+	values, err := outbox.Entry(e)
+	if err != nil {
+		return err
+	}
+	args := &redis.XAddArgs{Stream: f.cfg.Stream, Values: values}
+	if f.cfg.Retention > 0 {
+		args.MinID = strconv.FormatInt(time.Now().Add(-f.cfg.Retention).UnixMilli(), 10)
+		args.Approx = true
+	}
+	return f.redis.XAdd(ctx, args).Err()
+}
+
+// mark grava uma marca (markScript) e retorna o menor cursor válido.
+func (f *Feed) mark(ctx context.Context, mode string) (string, error) {
+	return markScript.Run(ctx, f.redis, []string{f.validFromKey(), f.cfg.Stream}, mode, markType).Text()
+}
+
+// validFrom retorna o menor cursor válido, criando a marca na primeira
+// leitura ou depois de uma perda dos dados do Redis, o que invalida os
+// cursores anteriores.
+func (f *Feed) validFrom(ctx context.Context) (cursor, error) {
+	s, err := f.redis.Get(ctx, f.validFromKey()).Result()
+	if errors.Is(err, redis.Nil) {
+		s, err = f.mark(ctx, "init")
+	}
+	if err != nil {
+		return cursor{}, err
+	}
+	return parseCursor(s)
+}
+
+// Start prepara o feed e deve ser chamado antes de assinar o barramento:
+// os cursores só valem a partir da primeira inicialização.
+func (f *Feed) Start(ctx context.Context) error {
+	_, err := f.validFrom(ctx)
+	return err
+}
+
+// Cursor retorna o cursor atual, a partir do qual uma carga completa feita
+// em seguida deve ser acompanhada.
+func (f *Feed) Cursor(ctx context.Context) (string, error) {
+	from, err := f.validFrom(ctx)
+	if err != nil {
+		return "", err
+	}
+	last, err := f.redis.XRevRangeN(ctx, f.cfg.Stream, "+", "-", 1).Result()
+	if err != nil {
+		return "", err
+	}
+	if len(last) > 0 {
+		if c, err := parseCursor(last[0].ID); err == nil && !c.less(from) {
+			return last[0].ID, nil
+		}
+	}
+	return from.String(), nil
+}
+
+// Page é uma leitura do feed.
+type Page struct {
+	Changes []Change `json:"changes"`
+	// Cursor é o cursor da próxima leitura; passa também pelas mudanças
+	// filtradas.
+	Cursor string `json:"cursor"`
+	// HasMore indica que há mais mudanças depois de Cursor.
+	HasMore bool `json:"has_more"`
+}
+
+// Since lê até limit mudanças posteriores a since, em ordem. keep, se não
+// for nil, filtra as mudanças pelo projeto.
+func (f *Feed) Since(ctx context.Context, since string, limit int, keep func(projectID string) bool) (Page, error) {
+	c, err := parseCursor(since)
+	if err != nil {
+		return Page{}, err
+	}
+	if err := f.check(ctx, c); err != nil {
+		return Page{}, err
+	}
+	entries, err := f.redis.XRangeN(ctx, f.cfg.Stream, "("+c.String(), "+", int64(limit)+1).Result()
+	if err != nil {
+		return Page{}, err
+	}
+	page := Page{Changes: make([]Change, 0, min(len(entries), limit)), Cursor: since}
+	if len(entries) > limit {
+		entries, page.HasMore = entries[:limit], true
+	}
+	for _, entry := range entries {
+		page.Cursor = entry.ID
+		if entry.Values["type"] == markType {
+			continue
+		}
+		change, ok := decode(entry)
+		if !ok {
+			logging.FromContext(ctx).WithField("cursor", entry.ID).Warn("Entrada ilegível no feed de mudanças ignorada")
+			continue
+		}
+		if keep == nil || keep(change.ProjectID) {
+			page.Changes = append(page.Changes, change)
+		}
+	}
+	// A verificação é refeita depois da leitura: um corte feito no meio
+	// dela poderia ter levado entradas que a leitura esperava.
+	if err := f.check(ctx, c); err != nil {
+		return Page{}, err
+	}
+	return page, nil
+}
+
+// Deleted indica se algum agente aceito por keep foi removido depois de
+// since. A carga completa usa Deleted para detectar uma remoção no meio da
+// paginação, que desloca as páginas seguintes e pula um agente.
+func (f *Feed) Deleted(ctx context.Context, since string, keep func(projectID string) bool) (bool, error) {
+	start := "(" + since
+	for {
+		entries, err := f.redis.XRangeN(ctx, f.cfg.Stream, start, "+", scanCount).Result()
+		if err != nil {
+			return false, err
+		}
+		for _, entry := range entries {
+			if change, ok := decode(entry); ok && change.Op == OpDelete && (keep == nil || keep(change.ProjectID)) {
+				return true, nil
+			}
+		}
+		if len(entries) < scanCount {
+			return false, nil
+		}
+		start = "(" + entries[len(entries)-1].ID
+	}
+}
+
+// check retorna ErrResyncRequired se alguma entrada posterior a c pode ter
+// sido perdida.
+func (f *Feed) check(ctx context.Context, c cursor) error {
+	from, err := f.validFrom(ctx)
+	if err != nil {
+		return err
+	}
+	if c.less(from) {
+		resyncs.Inc()
+		return ErrResyncRequired
+	}
+	info, err := f.redis.XInfoStream(ctx, f.cfg.Stream).Result()
+	if err != nil {
+		if strings.Contains(err.Error(), "no such key") {
+			return nil
+		}
+		return err
+	}
+	// max-deleted-entry-id (Redis 7) é a maior entrada já cortada. Antes
+	// do Redis 7 vale a janela de retenção: o corte só leva entradas mais
+	// antigas que ela, então um cursor mais antigo é recusado mesmo que as
+	// entradas seguintes ainda existam.
+	var trimmed cursor
+	if info.MaxDeletedEntryID != "" {
+		if trimmed, err = parseCursor(info.MaxDeletedEntryID); err != nil {
+			return err
+		}
+	} else if f.cfg.Retention > 0 {
+		trimmed = cursor{ms: uint64(time.Now().Add(-f.cfg.Retention).UnixMilli())}
+	}
+	if c.less(trimmed) {
+		resyncs.Inc()
+		return ErrResyncRequired
+	}
+	return nil
+}
+
+func decode(entry redis.XMessage) (Change, bool) {
+	msg, ok := outbox.Decode(entry)
+	if !ok {
+		return Change{}, false
+	}
+	var env struct {
+		Data json.RawMessage `json:"data"`
+	}
+	var agent struct {
+		ID        string `json:"id"`
+		ProjectID string `json:"project_id"`
+	}
+	if json.Unmarshal(msg.Payload, &env) != nil || json.Unmarshal(env.Data, &agent) != nil || agent.ID == "" {
+		return Change{}, false
+	}
+	change := Change{
+		Cursor:     entry.ID,
+		Op:         ops[msg.Type],
+		AgentID:    agent.ID,
+		ProjectID:  agent.ProjectID,
+		OccurredAt: msg.OccurredAt,
+	}
+	if change.Op == "" {
+		return Change{}, false
+	}
+	if change.Op != OpDelete {
+		change.Agent = env.Data
+	}
+	return change, true
+}
+
+// cursor é um id de entrada de stream, <ms>-<seq>.
+type cursor struct {
+	ms, seq uint64
+}
+
+func parseCursor(s string) (cursor, error) {
+	msPart, seqPart, ok := strings.Cut(s, "-")
+	if !ok {
+		return cursor{}, ErrInvalidCursor
+	}
+	ms, err1 := strconv.ParseUint(msPart, 10, 64)
+	seq, err2 := strconv.ParseUint(seqPart, 10, 64)
+	if err1 != nil || err2 != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+	return cursor{ms: ms, seq: seq}, nil
+}
+
+func (c cursor) less(o cursor) bool {
+	return c.ms < o.ms || c.ms == o.ms && c.seq < o.seq
+}
+
+func (c cursor) String() string {
+	return fmt.Sprintf("%d-%d", c.ms, c.seq)
+}
@@ -0,0 +1,165 @@
+package changefeed
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/jsonstream"
+	"smart-city-microservices/internal/logging"
+)
+
+// snapshotPageSize é o tamanho das páginas lidas do repositório na carga
+// completa.
+const snapshotPageSize = 500
+
+// AgentLister é o subconjunto de agent.Service usado na carga completa.
+type AgentLister interface {
+	ListAgents(ctx context.Context, f agent.Filter) ([]agent.Agent, int, error)
+}
+
+// HandlerConfig configura os limites da leitura do feed.
+type HandlerConfig struct {
+	DefaultLimit int
+	MaxLimit     int
+}
+
+// Handler responde a sincronização incremental dos agentes.
+type Handler struct {
+	feed   *Feed
+	agents AgentLister
+	cfg    HandlerConfig
+}
+
+// NewHandler cria o handler do feed.
+func NewHandler(feed *Feed, agents AgentLister, cfg HandlerConfig) *Handler {
+	return &Handler{feed: feed, agents: agents, cfg: cfg}
+}
+
+// Changes responde GET /agents/changes?since=<cursor>&limit=&project_id=
+// com as mudanças posteriores ao cursor, em ordem, e o cursor da próxima
+// leitura (Page). Um cursor fora da janela de retenção recebe 410 com
+// resync_required: o cliente deve refazer a carga completa por
+// GET /agents/changes/snapshot.
+func (h *Handler) Changes(c *gin.Context) {
+	since := c.Query("since")
+	if since == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "since is required (use the cursor from /agents/changes/snapshot)"})
+		return
+	}
+	limit := h.cfg.DefaultLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit: " + v})
+			return
+		}
+		limit = min(n, h.cfg.MaxLimit)
+	}
+	keep, ok := projectFilter(c)
+	if !ok {
+		return
+	}
+	page, err := h.feed.Since(c.Request.Context(), since, limit, keep)
+	switch {
+	case errors.Is(err, ErrInvalidCursor):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor: " + since})
+		return
+	case errors.Is(err, ErrResyncRequired):
+		c.JSON(http.StatusGone, gin.H{"error": ErrResyncRequired.Error(), "resync_required": true})
+		return
+	case err != nil:
+		h.internalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// Snapshot responde GET /agents/changes/snapshot?project_id= com todos os
+// agentes visíveis ao principal e o cursor a partir do qual acompanhar o
+// feed. O cursor é tomado antes da leitura, então mudanças feitas durante
+// ela aparecem de novo no feed e devem ser aplicadas como upsert. Uma
+// remoção no meio da paginação, que pode ter pulado um agente, fecha o
+// documento com "complete": false e o cliente deve repetir a carga.
+func (h *Handler) Snapshot(c *gin.Context) {
+	keep, ok := projectFilter(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	cursor, err := h.feed.Cursor(ctx)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	f := agent.Filter{ProjectID: c.Query("project_id"), Page: 1, PageSize: snapshotPageSize}
+	agents, total, err := h.agents.ListAgents(ctx, f)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	sw := jsonstream.Start(c, "application/json; charset=utf-8", "data",
+		jsonstream.Member{Key: "cursor", Value: cursor},
+		jsonstream.Member{Key: "generated_at", Value: time.Now().UTC()},
+	)
+	read := 0
+	for sw.Err() == nil {
+		for _, a := range agents {
+			if keep == nil || keep(a.ProjectID) {
+				sw.Add(a)
+			}
+		}
+		read += len(agents)
+		if len(agents) < f.PageSize || read >= total {
+			break
+		}
+		f.Page++
+		if agents, total, err = h.agents.ListAgents(ctx, f); err != nil {
+			break
+		}
+	}
+	deleted := false
+	if err == nil && sw.Err() == nil {
+		deleted, err = h.feed.Deleted(ctx, cursor, keep)
+	}
+	switch {
+	case err != nil:
+		logging.FromContext(ctx).WithError(err).Error("Erro na carga completa de agentes")
+		err = sw.Abort("internal error")
+	case deleted:
+		err = sw.Abort("snapshot changed during paging; retry")
+	default:
+		err = sw.Close()
+	}
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).WithField("agents", sw.Count()).Warn("Carga completa de agentes interrompida")
+	}
+}
+
+// projectFilter restringe as mudanças aos projetos do principal ou a
+// ?project_id=; nil aceita todas. ok é falso depois de responder 403.
+func projectFilter(c *gin.Context) (keep func(projectID string) bool, ok bool) {
+	p := auth.FromGin(c)
+	if id := c.Query("project_id"); id != "" {
+		if !p.InProject(id) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "project " + id + " is not accessible"})
+			return nil, false
+		}
+		return func(projectID string) bool { return projectID == id }, true
+	}
+	if p != nil && len(p.Projects) > 0 && !p.HasRole(auth.RoleAdmin) {
+		return p.InProject, true
+	}
+	return nil, true
+}
+
+func (h *Handler) internalError(c *gin.Context, err error) {
+	logging.FromContext(c.Request.Context()).WithError(err).Error("Erro no handler do feed de mudanças")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
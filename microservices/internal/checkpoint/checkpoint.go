@@ -0,0 +1,223 @@
+// Package checkpoint define o arquivo de checkpoint de uma simulação: o
+// estado dos agentes e as mensagens à espera num tick, gravado no
+// armazenamento de objetos para retomar a simulação depois.
+//
+// O arquivo é um documento JSON gzipado com Format e Version. Version é a
+// versão do formato, não a do serviço: muda quando o conteúdo muda de
+// forma, e cada mudança traz uma migração da versão anterior (migrate.go).
+// Decode aplica as migrações em ordem até a versão atual, de modo que um
+// checkpoint gravado por um serviço mais antigo é restaurado pelo atual; um
+// checkpoint de versão mais nova que a deste serviço é recusado com
+// *FormatError, em vez de restaurado pela metade. O Restorer (restore.go)
+// é a restauração no serviço: lê o checkpoint do armazenamento por Decode e
+// o aplica aos agentes da simulação.
+package checkpoint
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Format identifica os arquivos de checkpoint; Version é a versão gravada
+// hoje. A restauração aceita as versões até a atual.
+const (
+	Format  = "smartcity.simulation-checkpoint"
+	Version = 2
+)
+
+// Key é a chave do checkpoint de um tick no armazenamento.
+func Key(simulationID string, tick int64) string {
+	return "checkpoints/simulations/" + simulationID + "/" + strconv.FormatInt(tick, 10) + ".json.gz"
+}
+
+// Checkpoint é o conteúdo do arquivo na versão atual.
+type Checkpoint struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+	// ServiceVersion é a versão do agent-service que gravou o arquivo
+	// (buildinfo.Version), só para diagnóstico.
+	ServiceVersion string    `json:"service_version,omitempty"`
+	SimulationID   string    `json:"simulation_id"`
+	ProjectID      string    `json:"project_id,omitempty"`
+	Tick           int64     `json:"tick"`
+	CreatedAt      time.Time `json:"created_at"`
+	Agents         []Agent   `json:"agents"`
+	Messages       []Message `json:"messages"`
+}
+
+// Agent é o estado de um agente no checkpoint.
+type Agent struct {
+	ID       string                 `json:"id"`
+	Type     string                 `json:"type"`
+	Name     string                 `json:"name"`
+	Status   string                 `json:"status"`
+	Position Position               `json:"position"`
+	Energy   float64                `json:"energy"`
+	State    map[string]interface{} `json:"state,omitempty"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+	Tags     []string               `json:"tags,omitempty"`
+}
+
+// Position é a posição de um agente.
+type Position struct {
+	Lat     float64 `json:"lat"`
+	Lon     float64 `json:"lon"`
+	Heading float64 `json:"heading"`
+	Speed   float64 `json:"speed"`
+}
+
+// Message é uma mensagem entre agentes à espera de entrega.
+type Message struct {
+	From    string          `json:"from"`
+	To      string          `json:"to"`
+	Kind    string          `json:"kind"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	// DeliverAt é o tick em que a mensagem é entregue.
+	DeliverAt int64 `json:"deliver_at"`
+}
+
+// FormatError é um arquivo de checkpoint que não pode ser restaurado.
+type FormatError struct {
+	Reason string
+}
+
+func (e *FormatError) Error() string { return e.Reason }
+
+// header são os campos lidos antes de migrar o documento.
+type header struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+}
+
+// check recusa arquivos de outro formato ou de uma versão que este serviço
+// não conhece.
+func (h header) check() error {
+	if h.Format != Format {
+		return &FormatError{Reason: fmt.Sprintf("unknown checkpoint format %q", h.Format)}
+	}
+	if h.Version > Version {
+		return &FormatError{Reason: fmt.Sprintf("checkpoint version %d is newer than this service understands (it reads up to %d); restore it with an agent-service release that writes version %d or later", h.Version, Version, h.Version)}
+	}
+	if h.Version < 1 {
+		return &FormatError{Reason: fmt.Sprintf("invalid checkpoint version %d", h.Version)}
+	}
+	return nil
+}
+
+// Encode grava o checkpoint em w na versão atual, gzipado.
+func Encode(w io.Writer, c *Checkpoint) error {
+	c.Format, c.Version = Format, Version
+	gz := gzip.NewWriter(w)
+	if err := json.NewEncoder(gz).Encode(c); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Decode lê um checkpoint de qualquer versão até a atual e o migra para
+// ela. Aceita o arquivo gzipado ou o JSON puro.
+func Decode(r io.Reader) (*Checkpoint, error) {
+	doc, h, err := read(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.check(); err != nil {
+		return nil, err
+	}
+	if err := migrate(doc, h.Version); err != nil {
+		return nil, err
+	}
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var c Checkpoint
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return nil, &FormatError{Reason: "invalid checkpoint: " + err.Error()}
+	}
+	return &c, nil
+}
+
+// read lê o documento, ainda na versão em que foi gravado, e o cabeçalho.
+func read(r io.Reader) (document, header, error) {
+	br := bufio.NewReader(r)
+	var src io.Reader = br
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, header{}, &FormatError{Reason: "invalid gzip in checkpoint: " + err.Error()}
+		}
+		defer gz.Close()
+		src = gz
+	}
+	var doc document
+	if err := json.NewDecoder(src).Decode(&doc); err != nil {
+		return nil, header{}, &FormatError{Reason: "checkpoint is not a JSON document: " + err.Error()}
+	}
+	var h header
+	if err := doc.get("format", &h.Format); err != nil {
+		return nil, header{}, err
+	}
+	if err := doc.get("version", &h.Version); err != nil {
+		return nil, header{}, err
+	}
+	return doc, h, nil
+}
+
+// Info descreve um checkpoint sem restaurá-lo.
+type Info struct {
+	Format         string    `json:"format"`
+	Version        int       `json:"version"`
+	CurrentVersion int       `json:"current_version"`
+	ServiceVersion string    `json:"service_version,omitempty"`
+	SimulationID   string    `json:"simulation_id"`
+	Tick           int64     `json:"tick"`
+	CreatedAt      time.Time `json:"created_at"`
+	Agents         int       `json:"agents"`
+	Messages       int       `json:"messages"`
+	// Compatible indica se Decode o restaura; Migrations são as migrações
+	// que ele aplicaria, como "1->2", e Reason, o motivo da recusa.
+	Compatible bool     `json:"compatible"`
+	Migrations []string `json:"migrations,omitempty"`
+	Reason     string   `json:"reason,omitempty"`
+}
+
+// Inspect lê o cabeçalho e as contagens de um checkpoint de qualquer
+// versão, inclusive das mais novas que a atual, e diz se ele pode ser
+// restaurado. Só os erros de leitura (arquivo que não é um checkpoint) são
+// retornados; a incompatibilidade fica em Info.
+func Inspect(r io.Reader) (*Info, error) {
+	doc, h, err := read(r)
+	if err != nil {
+		return nil, err
+	}
+	if h.Format != Format {
+		return nil, h.check()
+	}
+	info := &Info{Format: h.Format, Version: h.Version, CurrentVersion: Version}
+	// Estes campos não mudaram de forma em nenhuma versão; numa versão
+	// desconhecida, os que não forem lidos ficam vazios.
+	_ = doc.get("service_version", &info.ServiceVersion)
+	_ = doc.get("simulation_id", &info.SimulationID)
+	_ = doc.get("tick", &info.Tick)
+	_ = doc.get("created_at", &info.CreatedAt)
+	var agents, messages []json.RawMessage
+	_ = doc.get("agents", &agents)
+	_ = doc.get("messages", &messages)
+	info.Agents, info.Messages = len(agents), len(messages)
+	if err := h.check(); err != nil {
+		info.Reason = err.Error()
+		return info, nil
+	}
+	for v := h.Version; v < Version; v++ {
+		info.Migrations = append(info.Migrations, fmt.Sprintf("%d->%d", v, v+1))
+	}
+	info.Compatible = true
+	return info, nil
+}
@@ -0,0 +1,166 @@
+package checkpoint
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// v1 é o checkpoint gravado pelo agent-service 1.1.0, o último a usar a
+// versão 1 do formato.
+func v1(t *testing.T) []byte {
+	t.Helper()
+	raw, err := os.ReadFile(filepath.Join("testdata", "v1.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func gzipped(t *testing.T, raw []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(raw)
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// TestMigrationsCoverVersions confere que cada versão anterior à atual tem
+// a sua migração.
+func TestMigrationsCoverVersions(t *testing.T) {
+	if len(migrations) != Version-1 {
+		t.Fatalf("%d migrações para a versão %d", len(migrations), Version)
+	}
+}
+
+// TestRestoreV1 restaura o checkpoint v1 no código atual, gzipado como a
+// 1.1.0 o gravava e em JSON puro, e confere o resultado da migração e a
+// volta pelo formato atual.
+func TestRestoreV1(t *testing.T) {
+	for name, raw := range map[string][]byte{"gzip": gzipped(t, v1(t)), "json": v1(t)} {
+		t.Run(name, func(t *testing.T) {
+			c, err := Decode(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Version != Version || c.ServiceVersion != "1.1.0" || c.Tick != 1200 || c.ProjectID != "proj-centro" ||
+				!c.CreatedAt.Equal(time.Date(2026, 2, 10, 14, 30, 0, 0, time.UTC)) {
+				t.Errorf("cabeçalho %+v", c)
+			}
+			if len(c.Agents) != 3 || len(c.Messages) != 2 {
+				t.Fatalf("%d agentes, %d mensagens", len(c.Agents), len(c.Messages))
+			}
+			bus := c.Agents[0]
+			if bus.Name != "Ônibus 101" || bus.Status != "moving" || bus.Position != (Position{Lat: -23.5505, Lon: -46.6333}) || bus.Energy != 0.82 {
+				t.Errorf("ônibus %+v", bus)
+			}
+			if bus.State["passengers"] != float64(31) || bus.Tags != nil || bus.Metadata != nil {
+				t.Errorf("estado %v, tags %v, metadata %v", bus.State, bus.Tags, bus.Metadata)
+			}
+			if c.Agents[2].Status != "active" {
+				t.Errorf("status vazio migrado para %q", c.Agents[2].Status)
+			}
+			if m := c.Messages[1]; m.Kind != "congestion" || m.DeliverAt != 1202 || string(m.Payload) != `{"level":3}` {
+				t.Errorf("mensagem %+v", m)
+			}
+
+			var buf bytes.Buffer
+			if err := Encode(&buf, c); err != nil {
+				t.Fatal(err)
+			}
+			again, err := Decode(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(again, c) {
+				t.Errorf("ida e volta mudou o checkpoint:\n%+v\n%+v", again, c)
+			}
+		})
+	}
+}
+
+func TestDecodeRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		err  string
+	}{
+		{"newer version", `{"format":"smartcity.simulation-checkpoint","version":3,"agents":[]}`, "checkpoint version 3 is newer than this service understands (it reads up to 2)"},
+		{"version zero", `{"format":"smartcity.simulation-checkpoint","version":0}`, "invalid checkpoint version 0"},
+		{"without version", `{"format":"smartcity.simulation-checkpoint"}`, "invalid checkpoint version 0"},
+		{"other format", `{"format":"smartcity.project-backup","version":1}`, `unknown checkpoint format "smartcity.project-backup"`},
+		{"not json", `PK\x03\x04`, "checkpoint is not a JSON document"},
+		{"bad version type", `{"format":"smartcity.simulation-checkpoint","version":"2"}`, `invalid checkpoint member "version"`},
+		{"v1 agent without id", `{"format":"smartcity.simulation-checkpoint","version":1,"agents":[{"name":"x"}]}`, "migrate checkpoint from version 1 to 2: agent 0 without id"},
+		{"v2 bad agents", `{"format":"smartcity.simulation-checkpoint","version":2,"agents":{}}`, "invalid checkpoint"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Decode(strings.NewReader(tt.doc))
+			var fe *FormatError
+			if !errors.As(err, &fe) || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("erro %v, want *FormatError com %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestInspect(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  []byte
+		want Info
+	}{
+		{
+			name: "v1",
+			raw:  gzipped(t, v1(t)),
+			want: Info{
+				Format: Format, Version: 1, CurrentVersion: Version, ServiceVersion: "1.1.0",
+				SimulationID: "3f6c1a52-9d1e-4b7a-8c2f-5e0d4a9b7c61", Tick: 1200, CreatedAt: time.Date(2026, 2, 10, 14, 30, 0, 0, time.UTC),
+				Agents: 3, Messages: 2, Compatible: true, Migrations: []string{"1->2"},
+			},
+		},
+		{
+			name: "newer",
+			raw:  []byte(`{"format":"smartcity.simulation-checkpoint","version":3,"service_version":"2.0.0","tick":7,"agents":[{},{}],"messages":[],"layers":{}}`),
+			want: Info{
+				Format: Format, Version: 3, CurrentVersion: Version, ServiceVersion: "2.0.0", Tick: 7, Agents: 2,
+				Reason: "checkpoint version 3 is newer than this service understands (it reads up to 2); restore it with an agent-service release that writes version 3 or later",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Inspect(bytes.NewReader(tt.raw))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("Inspect =\n%+v\nwant\n%+v", *got, tt.want)
+			}
+		})
+	}
+
+	// O atual, gravado por Encode, não precisa de migração.
+	var buf bytes.Buffer
+	if err := Encode(&buf, &Checkpoint{SimulationID: "sim-1", Agents: []Agent{{ID: "a1"}}}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := Inspect(&buf)
+	if err != nil || !info.Compatible || info.Version != Version || len(info.Migrations) != 0 || info.Agents != 1 {
+		t.Errorf("Inspect = %+v, %v", info, err)
+	}
+
+	if _, err := Inspect(strings.NewReader(`{"format":"other","version":1}`)); err == nil {
+		t.Error("formato desconhecido aceito")
+	}
+}
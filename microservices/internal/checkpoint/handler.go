@@ -0,0 +1,84 @@
+package checkpoint
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/audit"
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/logging"
+	"smart-city-microservices/internal/storage"
+)
+
+// SimulationGetter é o subconjunto de agent.Service usado pelo handler.
+type SimulationGetter interface {
+	GetSimulation(ctx context.Context, id string) (*agent.Simulation, error)
+}
+
+// Handler expõe a restauração de checkpoints.
+type Handler struct {
+	restorer    *Restorer
+	simulations SimulationGetter
+}
+
+// NewHandler cria o handler de checkpoints.
+func NewHandler(restorer *Restorer, simulations SimulationGetter) *Handler {
+	return &Handler{restorer: restorer, simulations: simulations}
+}
+
+// Restore responde POST /simulations/:id/checkpoints/:tick/restore com o
+// Result. 404 sem a simulação ou o checkpoint; 409 com a simulação em
+// execução; 422 com um checkpoint que esta versão não restaura, com o
+// motivo do *FormatError.
+func (h *Handler) Restore(c *gin.Context) {
+	tick, err := strconv.ParseInt(c.Param("tick"), 10, 64)
+	if err != nil || tick < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tick: must be a non-negative integer"})
+		return
+	}
+	ctx := c.Request.Context()
+	sim, err := h.simulations.GetSimulation(ctx, c.Param("id"))
+	if errors.Is(err, agent.ErrNotFound) || (err == nil && !auth.FromGin(c).InProject(sim.ProjectID)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "simulation not found"})
+		return
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+
+	res, err := h.restorer.Restore(ctx, sim, tick)
+	var fe *FormatError
+	switch {
+	case errors.As(err, &fe):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fe.Error()})
+		return
+	case errors.Is(err, storage.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "checkpoint not found"})
+		return
+	case errors.Is(err, ErrRunning):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if res != nil && (res.Agents > 0 || res.Messages > 0) {
+		audit.Record(ctx, "simulation.checkpoint_restored", logrus.Fields{
+			"simulation_id": sim.ID, "tick": tick, "agents": res.Agents, "messages": res.Messages,
+		})
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) internalError(c *gin.Context, err error) {
+	logging.FromContext(c.Request.Context()).WithError(err).Error("Erro no handler de checkpoints")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
@@ -0,0 +1,93 @@
+package checkpoint
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// document é um checkpoint como gravado, membro a membro, para as
+// migrações reescreverem só o que mudou de forma.
+type document map[string]json.RawMessage
+
+// get decodifica o membro name em v; um membro ausente deixa v como está.
+func (d document) get(name string, v interface{}) error {
+	raw, ok := d[name]
+	if !ok {
+		return nil
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return &FormatError{Reason: fmt.Sprintf("invalid checkpoint member %q: %v", name, err)}
+	}
+	return nil
+}
+
+func (d document) set(name string, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	d[name] = raw
+	return nil
+}
+
+// migrations[i] leva o documento da versão i+1 para a i+2. Uma nova versão
+// do formato acrescenta aqui a migração da anterior e incrementa Version;
+// as existentes não mudam, pois há checkpoints gravados com elas.
+var migrations = []func(document) error{
+	migrateV1,
+}
+
+// migrate aplica as migrações da versão from até Version.
+func migrate(doc document, from int) error {
+	for v := from; v < Version; v++ {
+		if err := migrations[v-1](doc); err != nil {
+			return &FormatError{Reason: fmt.Sprintf("migrate checkpoint from version %d to %d: %v", v, v+1, err)}
+		}
+	}
+	return doc.set("version", Version)
+}
+
+// agentV1 é um agente na versão 1 (agent-service 1.1.x): a posição em
+// lat e lon soltos, sem rumo nem velocidade, e sem tags nem metadados.
+type agentV1 struct {
+	ID     string                 `json:"id"`
+	Type   string                 `json:"type"`
+	Name   string                 `json:"name"`
+	Status string                 `json:"status"`
+	Lat    float64                `json:"lat"`
+	Lon    float64                `json:"lon"`
+	Energy float64                `json:"energy"`
+	State  map[string]interface{} `json:"state,omitempty"`
+}
+
+// migrateV1 leva a versão 1 para a 2 (agent-service 1.2.0), que guarda a
+// posição completa e as tags e os metadados dos agentes. Os agentes de um
+// checkpoint v1 voltam parados (rumo e velocidade zero) e sem tags nem
+// metadados; o status vazio, que a 1.1 gravava para agentes nunca
+// atualizados, vira active.
+func migrateV1(doc document) error {
+	var old []agentV1
+	if err := doc.get("agents", &old); err != nil {
+		return err
+	}
+	agents := make([]Agent, len(old))
+	for i, a := range old {
+		if a.ID == "" {
+			return fmt.Errorf("agent %d without id", i)
+		}
+		status := a.Status
+		if status == "" {
+			status = "active"
+		}
+		agents[i] = Agent{
+			ID:       a.ID,
+			Type:     a.Type,
+			Name:     a.Name,
+			Status:   status,
+			Position: Position{Lat: a.Lat, Lon: a.Lon},
+			Energy:   a.Energy,
+			State:    a.State,
+		}
+	}
+	return doc.set("agents", agents)
+}
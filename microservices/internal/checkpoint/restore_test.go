@@ -0,0 +1,129 @@
+package checkpoint
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/agentmsg"
+	"smart-city-microservices/internal/storage"
+)
+
+// v1Simulation é a simulação do checkpoint de testdata/v1.json.
+const v1Simulation = "3f6c1a52-9d1e-4b7a-8c2f-5e0d4a9b7c61"
+
+// fakeAgents guarda as atualizações; os agentes de gone não existem mais.
+type fakeAgents struct {
+	updates map[string]agent.UpdateAgentRequest
+	gone    map[string]bool
+}
+
+func (f *fakeAgents) UpdateAgent(_ context.Context, id string, req agent.UpdateAgentRequest) (*agent.Agent, error) {
+	if f.gone[id] {
+		return nil, agent.ErrNotFound
+	}
+	f.updates[id] = req
+	return &agent.Agent{ID: id}, nil
+}
+
+type fakeMessages []agentmsg.Message
+
+func (f *fakeMessages) SendMessage(_ context.Context, m agentmsg.Message) (agentmsg.Message, error) {
+	*f = append(*f, m)
+	return m, nil
+}
+
+func fileStore(t *testing.T) storage.Store {
+	t.Helper()
+	store, err := storage.NewFileStore(storage.FileConfig{Root: t.TempDir(), SigningKey: []byte("test")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func put(t *testing.T, store storage.Store, key string, raw []byte) {
+	t.Helper()
+	if err := store.Put(context.Background(), key, bytes.NewReader(raw), int64(len(raw)), "application/gzip"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestRestorerV1 restaura pelo Restorer o checkpoint v1, como a 1.1.0 o
+// gravava no armazenamento, e confere o que chega aos agentes e à fila de
+// mensagens depois da migração.
+func TestRestorerV1(t *testing.T) {
+	store := fileStore(t)
+	put(t, store, Key(v1Simulation, 1200), gzipped(t, v1(t)))
+	agents := &fakeAgents{updates: map[string]agent.UpdateAgentRequest{}, gone: map[string]bool{"1c9f5e2d-3a4b-4f6c-8d7e-8b9c0d1e2f3a": true}}
+	var messages fakeMessages
+	r := NewRestorer(store, agents, &messages)
+
+	res, err := r.Restore(context.Background(), &agent.Simulation{ID: v1Simulation, Status: "stopped"}, 1200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Result{SimulationID: v1Simulation, Tick: 1200, Agents: 2, Messages: 2, Missing: []string{"1c9f5e2d-3a4b-4f6c-8d7e-8b9c0d1e2f3a"}}
+	if !reflect.DeepEqual(res, want) {
+		t.Errorf("Restore = %+v, want %+v", res, want)
+	}
+
+	bus := agents.updates["0b8e4d1c-2f3a-4e5b-9c6d-7a8b9c0d1e2f"]
+	if bus.Name == nil || *bus.Name != "Ônibus 101" || bus.Status == nil || *bus.Status != "moving" ||
+		bus.Position == nil || *bus.Position != (agent.Position{Lat: -23.5505, Lon: -46.6333}) || bus.State["passengers"] != float64(31) {
+		t.Errorf("ônibus restaurado com %+v", bus)
+	}
+	if sensor := agents.updates["2d0a6f3e-4b5c-4a7d-9e8f-9c0d1e2f3a4b"]; sensor.Status == nil || *sensor.Status != "active" {
+		t.Errorf("status vazio da v1 restaurado como %v", sensor.Status)
+	}
+
+	if len(messages) != 2 {
+		t.Fatalf("%d mensagens reenfileiradas", len(messages))
+	}
+	m := messages[1]
+	if m.SimulationID != v1Simulation || m.From != "2d0a6f3e-4b5c-4a7d-9e8f-9c0d1e2f3a4b" ||
+		!reflect.DeepEqual(m.Payload, map[string]interface{}{"kind": "congestion", "level": float64(3)}) {
+		t.Errorf("mensagem %+v", m)
+	}
+}
+
+func TestRestorerRejects(t *testing.T) {
+	store := fileStore(t)
+	put(t, store, Key("sim-1", 3), []byte(`{"format":"smartcity.simulation-checkpoint","version":3,"simulation_id":"sim-1","agents":[]}`))
+	put(t, store, Key("sim-1", 4), gzipped(t, v1(t)))
+	tests := []struct {
+		name   string
+		status string
+		tick   int64
+		err    string
+		// format indica um *FormatError, que a API responde com 422.
+		format bool
+	}{
+		{"newer version", "stopped", 3, "checkpoint version 3 is newer than this service understands", true},
+		{"other simulation", "stopped", 4, "checkpoint belongs to simulation " + v1Simulation, true},
+		{"running", "running", 4, ErrRunning.Error(), false},
+		{"missing", "stopped", 5, storage.ErrNotFound.Error(), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agents := &fakeAgents{updates: map[string]agent.UpdateAgentRequest{}}
+			var messages fakeMessages
+			r := NewRestorer(store, agents, &messages)
+			_, err := r.Restore(context.Background(), &agent.Simulation{ID: "sim-1", Status: tt.status}, tt.tick)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("erro %v, want %q", err, tt.err)
+			}
+			var fe *FormatError
+			if errors.As(err, &fe) != tt.format {
+				t.Errorf("erro %T, *FormatError %v", err, tt.format)
+			}
+			if len(agents.updates) > 0 || len(messages) > 0 {
+				t.Errorf("gravou %d agentes e %d mensagens", len(agents.updates), len(messages))
+			}
+		})
+	}
+}
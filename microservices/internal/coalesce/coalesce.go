@@ -0,0 +1,505 @@
+// Package coalesce agrupa as atualizações frequentes de telemetria dos
+// agentes antes de gravá-las no PostgreSQL. Cada atualização vai na hora
+// para o Redis, onde fica o estado ao vivo do agente (GET /agents/:id/live
+// e o evento agent.updated do websocket), e as pendências de cada agente são
+// combinadas e gravadas uma vez por ciclo. Mudanças relevantes (status ou
+// um deslocamento acima do limiar) e alterações de nome, metadados ou tags
+// são gravadas na hora, junto com o que estava pendente.
+//
+// As pendências ficam no Redis, de modo que qualquer réplica atualiza o
+// mesmo estado ao vivo e uma réplica que cai não as perde; os ciclos
+// periódicos são feitos por uma réplica por vez.
+package coalesce
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/instrument"
+	"smart-city-microservices/internal/lease"
+	"smart-city-microservices/internal/logging"
+	"smart-city-microservices/internal/supervisor"
+)
+
+const (
+	liveKeyPrefix  = "agent-service:live:"
+	dirtyKey       = "agent-service:live-dirty"
+	flushLeaderKey = "agent-service:live-flush-leader"
+)
+
+// watchAttempts é quantas vezes uma atualização disputa o registro do
+// agente com outra simultânea antes de ir direto para o banco.
+const watchAttempts = 3
+
+// Motivos de gravação em writes.
+const (
+	reasonInterval    = "interval"
+	reasonSignificant = "significant"
+	reasonFields      = "fields"
+	reasonSimulation  = "simulation"
+	reasonShutdown    = "shutdown"
+	reasonContention  = "contention"
+)
+
+var (
+	updates = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "agent_state_updates_total",
+		Help:      "Atualizações de telemetria recebidas, por destino (coalesced: só no Redis; written: gravada na hora).",
+	}, []string{"outcome"})
+	writes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "agent_state_writes_total",
+		Help:      "Gravações do estado de agentes no PostgreSQL, por motivo (interval, significant, fields, simulation, shutdown, contention).",
+	}, []string{"reason"})
+	ratio = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "agent_service",
+		Name:      "agent_state_coalescing_ratio",
+		Help:      "Atualizações recebidas por gravação no PostgreSQL desde o início do processo.",
+	})
+	flushErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "agent_state_flush_errors_total",
+		Help:      "Falhas ao gravar as pendências de um agente; elas ficam para o próximo ciclo.",
+	})
+)
+
+// AgentService é o subconjunto de agent.Service usado aqui.
+type AgentService interface {
+	GetAgent(ctx context.Context, id string) (*agent.Agent, error)
+	UpdateAgent(ctx context.Context, id string, req agent.UpdateAgentRequest) (*agent.Agent, error)
+}
+
+// Config configura o Coalescer.
+type Config struct {
+	// FlushInterval é o ciclo de gravação das pendências.
+	FlushInterval time.Duration
+	// PositionThresholdM é o deslocamento, em metros desde a última
+	// posição gravada, que faz a atualização ser gravada na hora.
+	PositionThresholdM float64
+	// LiveTTL é por quanto tempo o estado ao vivo fica no Redis depois de
+	// gravado; enquanto há pendências ele não expira.
+	LiveTTL time.Duration
+}
+
+// record é o estado ao vivo de um agente no Redis.
+type record struct {
+	// Agent é o agente com as pendências aplicadas.
+	Agent agent.Agent `json:"agent"`
+	// Pending combina as atualizações ainda não gravadas.
+	Pending *agent.UpdateAgentRequest `json:"pending,omitempty"`
+	// Seq conta as atualizações; a gravação só limpa Pending se nenhuma
+	// chegou enquanto ela acontecia.
+	Seq int64 `json:"seq"`
+	// StoredStatus e StoredPosition são os últimos gravados, a base de
+	// significant.
+	StoredStatus   string         `json:"stored_status"`
+	StoredPosition agent.Position `json:"stored_position"`
+}
+
+// Coalescer aplica as atualizações de telemetria ao estado ao vivo e as
+// grava no PostgreSQL em lotes.
+type Coalescer struct {
+	agents    AgentService
+	redis     redis.UniversalClient
+	leader    *lease.Lease
+	publisher events.Publisher
+	cfg       Config
+
+	received atomic.Int64
+	written  atomic.Int64
+
+	simulations chan string
+}
+
+// New cria o Coalescer. agents é o serviço que grava no banco; id
+// identifica a réplica na disputa pelos ciclos; os ciclos rodam em Run.
+func New(agents AgentService, client redis.UniversalClient, publisher events.Publisher, cfg Config, id string) *Coalescer {
+	return &Coalescer{
+		agents:      agents,
+		redis:       client,
+		leader:      lease.New(client, flushLeaderKey, id),
+		publisher:   publisher,
+		cfg:         cfg,
+		simulations: make(chan string, 64),
+	}
+}
+
+// UpdateAgent aplica req ao estado ao vivo do agente e retorna o agente
+// atualizado. A gravação no banco fica para o próximo ciclo, a menos que a
+// mudança seja relevante. O estado e os metadados pendentes se combinam
+// chave a chave, como atualizações sucessivas.
+func (c *Coalescer) UpdateAgent(ctx context.Context, id string, req agent.UpdateAgentRequest) (*agent.Agent, error) {
+	c.count(1, 0)
+	if req.Name != nil || req.Metadata != nil || req.Tags != nil {
+		return c.writeThrough(ctx, id, req, reasonFields)
+	}
+	key := liveKey(id)
+	for attempt := 0; attempt < watchAttempts; attempt++ {
+		var out *agent.Agent
+		var significant bool
+		err := c.redis.Watch(ctx, func(tx *redis.Tx) error {
+			rec, err := c.load(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if significant = c.significant(rec, req); significant {
+				return nil
+			}
+			rec.apply(req)
+			raw, err := json.Marshal(rec)
+			if err != nil {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(p redis.Pipeliner) error {
+				p.Set(ctx, key, raw, 0)
+				p.SAdd(ctx, dirtyKey, id)
+				return nil
+			})
+			out = &rec.Agent
+			return err
+		}, key)
+		switch {
+		case errors.Is(err, redis.TxFailedErr):
+			continue
+		case err != nil:
+			return nil, err
+		case significant:
+			return c.writeThrough(ctx, id, req, reasonSignificant)
+		}
+		updates.WithLabelValues("coalesced").Inc()
+		c.publisher.Publish(ctx, events.New(events.TopicAgents, "agent.updated", *out))
+		return out, nil
+	}
+	return c.writeThrough(ctx, id, req, reasonContention)
+}
+
+// Live retorna o estado ao vivo do agente, ou nil se ele não está no Redis.
+func (c *Coalescer) Live(ctx context.Context, id string) (*agent.Agent, error) {
+	rec, err := c.get(ctx, c.redis, id)
+	if err != nil || rec == nil {
+		return nil, err
+	}
+	return &rec.Agent, nil
+}
+
+// Flush grava as pendências de todos os agentes. Serve a quem precisa do
+// banco em dia, como o encerramento do serviço.
+func (c *Coalescer) Flush(ctx context.Context) error {
+	return c.flush(ctx, "", reasonShutdown)
+}
+
+// FlushSimulation grava as pendências dos agentes da simulação.
+func (c *Coalescer) FlushSimulation(ctx context.Context, simulationID string) error {
+	return c.flush(ctx, simulationID, reasonSimulation)
+}
+
+// Handle grava as pendências de uma simulação que parou, para que o banco
+// tenha o estado final dos seus agentes. A gravação sai do barramento e é
+// feita no ciclo.
+func (c *Coalescer) Handle(ctx context.Context, e events.Event) {
+	switch e.Type {
+	case "simulation.stopped", "simulation.completed", "simulation.failed", "simulation.auto_stopped":
+	default:
+		return
+	}
+	var sim struct {
+		ID string `json:"id"`
+	}
+	if err := e.Decode(&sim); err != nil || sim.ID == "" {
+		return
+	}
+	select {
+	case c.simulations <- sim.ID:
+	default:
+		logging.FromContext(ctx).WithField("simulation_id", sim.ID).Warn("Fila de gravação por simulação cheia; as pendências saem no próximo ciclo")
+	}
+}
+
+// Run grava as pendências a cada intervalo, e as das simulações que
+// pararam, até ctx ser cancelado. Ao terminar, grava todas as pendências:
+// todas as réplicas gravam ao parar, e gravar duas vezes as mesmas
+// pendências não muda o resultado.
+func (c *Coalescer) Run(ctx context.Context) error {
+	work := logging.Background(context.WithoutCancel(ctx), "state-coalescer")
+	defer c.release(work)
+	ticker := time.NewTicker(c.cfg.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case id := <-c.simulations:
+			if err := c.FlushSimulation(work, id); err != nil {
+				logging.FromContext(work).WithError(err).WithField("simulation_id", id).Warn("Falha ao gravar as pendências da simulação")
+			}
+		case <-ticker.C:
+			c.cycle(work)
+		}
+	}
+}
+
+// release grava as pendências e remove a chave se ainda for desta réplica.
+func (c *Coalescer) release(ctx context.Context) {
+	ctx, cancel := supervisor.Cleanup(ctx)
+	defer cancel()
+	if err := c.Flush(ctx); err != nil {
+		logging.FromContext(ctx).WithError(err).Warn("Falha ao gravar as últimas pendências de telemetria")
+	}
+	c.leader.Release(ctx)
+}
+
+func (c *Coalescer) cycle(ctx context.Context) {
+	log := logging.FromContext(ctx)
+	leader, err := c.lead(ctx)
+	if err != nil {
+		log.WithError(err).Warn("Falha ao disputar a gravação da telemetria")
+		return
+	}
+	if !leader {
+		return
+	}
+	if err := c.flush(ctx, "", reasonInterval); err != nil {
+		log.WithError(err).Warn("Falha ao gravar as pendências de telemetria")
+	}
+}
+
+// lead disputa os ciclos. A chave expira em três intervalos, para que
+// outra réplica assuma se esta cair.
+func (c *Coalescer) lead(ctx context.Context) (bool, error) {
+	return c.leader.Acquire(ctx, 3*c.cfg.FlushInterval)
+}
+
+// flush grava as pendências dos agentes marcados, só os de simulationID se
+// não vazio. Uma falha num agente não interrompe os demais; o erro da
+// leitura da lista é retornado.
+func (c *Coalescer) flush(ctx context.Context, simulationID, reason string) error {
+	ids, err := c.redis.SMembers(ctx, dirtyKey).Result()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		rec, err := c.get(ctx, c.redis, id)
+		if err != nil {
+			flushErrors.Inc()
+			logging.FromContext(ctx).WithError(err).WithField("agent_id", id).Warn("Falha ao ler as pendências do agente")
+			continue
+		}
+		if rec == nil || rec.Pending == nil {
+			c.redis.SRem(ctx, dirtyKey, id)
+			continue
+		}
+		if simulationID != "" && rec.Agent.SimulationID != simulationID {
+			continue
+		}
+		if _, err := c.persist(ctx, id, rec, rec.Seq, reason); err != nil {
+			flushErrors.Inc()
+			logging.FromContext(ctx).WithError(err).WithField("agent_id", id).Warn("Falha ao gravar as pendências do agente")
+		}
+	}
+	return nil
+}
+
+// writeThrough grava req junto com as pendências do agente.
+func (c *Coalescer) writeThrough(ctx context.Context, id string, req agent.UpdateAgentRequest, reason string) (*agent.Agent, error) {
+	rec, err := c.get(ctx, c.redis, id)
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil {
+		rec = &record{}
+	}
+	base := rec.Seq
+	rec.apply(req)
+	updates.WithLabelValues("written").Inc()
+	return c.persist(ctx, id, rec, base, reason)
+}
+
+// persist grava as pendências de rec e atualiza o estado ao vivo com o
+// agente gravado. base é o Seq do registro no Redis quando rec foi lido; se
+// outra atualização chegou desde então, as pendências continuam lá
+// (incluindo as já gravadas) para o próximo ciclo.
+func (c *Coalescer) persist(ctx context.Context, id string, rec *record, base int64, reason string) (*agent.Agent, error) {
+	saved, err := c.agents.UpdateAgent(ctx, id, *rec.Pending)
+	if errors.Is(err, agent.ErrNotFound) || errors.Is(err, agent.ErrValidation) {
+		// Um agente removido ou pendências que o serviço recusa não são
+		// gravados em ciclo nenhum.
+		c.redis.Del(ctx, liveKey(id))
+		c.redis.SRem(ctx, dirtyKey, id)
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	writes.WithLabelValues(reason).Inc()
+	c.count(0, 1)
+
+	key := liveKey(id)
+	err = c.redis.Watch(ctx, func(tx *redis.Tx) error {
+		cur, err := c.get(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		next := &record{Agent: *saved, StoredStatus: saved.Status, StoredPosition: saved.Position}
+		ttl := c.cfg.LiveTTL
+		if cur != nil && cur.Seq != base {
+			cur.StoredStatus, cur.StoredPosition = saved.Status, saved.Position
+			next, ttl = cur, 0
+		}
+		raw, err := json.Marshal(next)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(p redis.Pipeliner) error {
+			p.Set(ctx, key, raw, ttl)
+			if ttl > 0 {
+				p.SRem(ctx, dirtyKey, id)
+			}
+			return nil
+		})
+		return err
+	}, key)
+	if err != nil && !errors.Is(err, redis.TxFailedErr) {
+		// O banco já está gravado; o registro volta a ser lido no próximo
+		// ciclo, que regrava as mesmas pendências.
+		logging.FromContext(ctx).WithError(err).WithField("agent_id", id).Warn("Falha ao atualizar o estado ao vivo do agente")
+	}
+	return saved, nil
+}
+
+// load lê o registro do agente, montando-o a partir do banco se ele não
+// está no Redis.
+func (c *Coalescer) load(ctx context.Context, r redis.Cmdable, id string) (*record, error) {
+	rec, err := c.get(ctx, r, id)
+	if err != nil || rec != nil {
+		if rec != nil {
+			instrument.CacheHit(ctx)
+		}
+		return rec, err
+	}
+	instrument.CacheMiss(ctx)
+	a, err := c.agents.GetAgent(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if a == nil {
+		return nil, agent.ErrNotFound
+	}
+	return &record{Agent: *a, StoredStatus: a.Status, StoredPosition: a.Position}, nil
+}
+
+func (c *Coalescer) get(ctx context.Context, r redis.Cmdable, id string) (*record, error) {
+	raw, err := r.Get(ctx, liveKey(id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rec record
+	if err := json.Unmarshal(raw, &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// significant diz se req deve ser gravada na hora: muda o status gravado
+// ou afasta o agente mais de PositionThresholdM da posição gravada.
+func (c *Coalescer) significant(rec *record, req agent.UpdateAgentRequest) bool {
+	if req.Status != nil && *req.Status != rec.StoredStatus {
+		return true
+	}
+	if req.Position != nil {
+		p := rec.StoredPosition
+		return distanceMeters(p.Lat, p.Lon, req.Position.Lat, req.Position.Lon) >= c.cfg.PositionThresholdM
+	}
+	return false
+}
+
+func (c *Coalescer) count(received, written int64) {
+	r, w := c.received.Add(received), c.written.Add(written)
+	if w > 0 {
+		ratio.Set(float64(r) / float64(w))
+	}
+}
+
+// apply aplica req ao agente ao vivo e às pendências.
+func (rec *record) apply(req agent.UpdateAgentRequest) {
+	rec.Seq++
+	if rec.Pending == nil {
+		rec.Pending = &agent.UpdateAgentRequest{}
+	}
+	a, p := &rec.Agent, rec.Pending
+	if req.Name != nil {
+		a.Name, p.Name = *req.Name, req.Name
+	}
+	if req.Status != nil {
+		a.Status, p.Status = *req.Status, req.Status
+	}
+	if req.Position != nil {
+		a.Position, p.Position = *req.Position, req.Position
+	}
+	if req.State != nil {
+		a.State, p.State = mergeMap(a.State, req.State), mergeMap(p.State, req.State)
+	}
+	if req.Metadata != nil {
+		a.Metadata, p.Metadata = mergeMap(a.Metadata, req.Metadata), mergeMap(p.Metadata, req.Metadata)
+	}
+	if req.Tags != nil {
+		a.Tags, p.Tags = req.Tags, req.Tags
+	}
+	a.UpdatedAt = time.Now().UTC()
+}
+
+func mergeMap(dst, src map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(dst)+len(src))
+	for k, v := range dst {
+		out[k] = v
+	}
+	for k, v := range src {
+		out[k] = v
+	}
+	return out
+}
+
+func liveKey(id string) string {
+	return liveKeyPrefix + id
+}
+
+// distanceMeters é a distância de grande círculo (haversine) entre dois pontos.
+func distanceMeters(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadius = 6371000.0
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// Agents envolve agents de modo que UpdateAgent passe pelo Coalescer, para
+// quem recebe telemetria, como a ponte MQTT.
+func (c *Coalescer) Agents(agents AgentService) AgentService {
+	return coalesced{AgentService: agents, coalescer: c}
+}
+
+type coalesced struct {
+	AgentService
+	coalescer *Coalescer
+}
+
+func (a coalesced) UpdateAgent(ctx context.Context, id string, req agent.UpdateAgentRequest) (*agent.Agent, error) {
+	return a.coalescer.UpdateAgent(ctx, id, req)
+}
@@ -0,0 +1,71 @@
+package coalesce
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/i18n"
+	"smart-city-microservices/internal/logging"
+)
+
+// Handler expõe o estado ao vivo e recebe as atualizações REST de agentes.
+type Handler struct {
+	coalescer *Coalescer
+	agents    AgentService
+}
+
+// NewHandler cria o handler do estado ao vivo.
+func NewHandler(coalescer *Coalescer, agents AgentService) *Handler {
+	return &Handler{coalescer: coalescer, agents: agents}
+}
+
+// Live responde GET /agents/:id/live: o agente com as atualizações ainda não
+// gravadas no banco, ou o do banco se não há estado ao vivo.
+func (h *Handler) Live(c *gin.Context) {
+	ctx := c.Request.Context()
+	a, err := h.coalescer.Live(ctx, c.Param("id"))
+	if err == nil && a == nil {
+		a, err = h.agents.GetAgent(ctx, c.Param("id"))
+	}
+	if err != nil {
+		h.serviceError(c, err)
+		return
+	}
+	if a == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "agent not found"})
+		return
+	}
+	c.JSON(http.StatusOK, a)
+}
+
+// UpdateAgent responde PUT /agents/:id pelo Coalescer, no lugar do handler
+// de agentes.
+func (h *Handler) UpdateAgent(c *gin.Context) {
+	c.Abort()
+	var req agent.UpdateAgentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		i18n.BindError(c, err)
+		return
+	}
+	a, err := h.coalescer.UpdateAgent(c.Request.Context(), c.Param("id"), req)
+	if err != nil {
+		h.serviceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, a)
+}
+
+func (h *Handler) serviceError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, agent.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, agent.ErrValidation):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		logging.FromContext(c.Request.Context()).WithError(err).Error("Erro no handler de estado ao vivo")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+	}
+}
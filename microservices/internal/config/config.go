@@ -0,0 +1,558 @@
+// Package config carrega as configurações do serviço via viper e permite
+// recarregá-las em tempo de execução.
+package config
+
+import (
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+
+	"smart-city-microservices/internal/featureflag"
+)
+
+// Setup define nome, formato e caminhos do arquivo de configuração, os
+// valores padrão, as variáveis de ambiente e as flags em v.
+func Setup(v *viper.Viper, fs *pflag.FlagSet) {
+	v.SetConfigName("config")
+	v.SetConfigType("yaml")
+	v.AddConfigPath(".")
+	v.AddConfigPath("./configs")
+
+	SetDefaults(v)
+	BindEnv(v)
+	BindFlags(v, fs)
+}
+
+// SetDefaults registra o valor padrão de todas as chaves conhecidas.
+func SetDefaults(v *viper.Viper) {
+	v.SetDefault("server.port", "8080")
+	v.SetDefault("server.host", "0.0.0.0")
+	v.SetDefault("server.reuse_port", false)
+	v.SetDefault("server.shutdown.drain_delay", 5*time.Second)
+	v.SetDefault("server.shutdown.timeout", 30*time.Second)
+	v.SetDefault("server.cors.allowed_origins", []string{"http://localhost:3000", "http://localhost:5000"})
+	v.SetDefault("server.tls.enabled", false)
+	v.SetDefault("server.tls.cert_file", "")
+	v.SetDefault("server.tls.key_file", "")
+	v.SetDefault("server.tls.client_ca_file", "")
+	v.SetDefault("server.tls.client_auth", "none")
+	v.SetDefault("server.tls.client_roles", []string{"viewer"})
+	v.SetDefault("database.host", "localhost")
+	v.SetDefault("database.port", 5432)
+	v.SetDefault("database.name", "smart_city")
+	v.SetDefault("database.user", "postgres")
+	v.SetDefault("database.password", "password")
+	v.SetDefault("database.password_file", "")
+	v.SetDefault("database.sslmode", "disable")
+	v.SetDefault("database.sslrootcert", "")
+	v.SetDefault("database.sslcert", "")
+	v.SetDefault("database.sslkey", "")
+	v.SetDefault("redis.host", "localhost")
+	v.SetDefault("redis.port", 6379)
+	v.SetDefault("redis.password", "")
+	v.SetDefault("redis.password_file", "")
+	v.SetDefault("redis.tls.enabled", false)
+	v.SetDefault("redis.tls.ca_file", "")
+	v.SetDefault("redis.tls.cert_file", "")
+	v.SetDefault("redis.tls.key_file", "")
+	v.SetDefault("redis.tls.server_name", "")
+	v.SetDefault("redis.tls.insecure_skip_verify", false)
+	v.SetDefault("secrets.provider", "")
+	v.SetDefault("secrets.refresh_interval", 5*time.Minute)
+	v.SetDefault("secrets.vault.address", "http://127.0.0.1:8200")
+	v.SetDefault("secrets.vault.token", "")
+	v.SetDefault("secrets.vault.token_file", "")
+	v.SetDefault("secrets.vault.mount", "secret")
+	v.SetDefault("grpc.enabled", true)
+	v.SetDefault("grpc.host", "0.0.0.0")
+	v.SetDefault("grpc.port", "50051")
+	v.SetDefault("grpc.reflection", false)
+	v.SetDefault("graphql.enabled", true)
+	v.SetDefault("graphql.max_depth", 8)
+	v.SetDefault("graphql.max_complexity", 5000)
+	v.SetDefault("graphql.introspection", false)
+	v.SetDefault("graphql.recent_events", 500)
+	v.SetDefault("events.schema_validation", "off")
+	v.SetDefault("events.websocket_versions", []string{})
+	v.SetDefault("events.relay.enabled", true)
+	v.SetDefault("events.relay.channel", "agent-service:hub")
+	v.SetDefault("events.relay.queue_size", 4096)
+	v.SetDefault("events.relay.peer_refresh", 5*time.Second)
+	v.SetDefault("events.relay.max_attempts", 3)
+	v.SetDefault("events.long_poll.buffer_size", 1000)
+	v.SetDefault("events.long_poll.max_events", 100)
+	v.SetDefault("events.long_poll.default_timeout", 25*time.Second)
+	v.SetDefault("events.long_poll.max_timeout", 60*time.Second)
+	v.SetDefault("events.long_poll.max_per_principal", 4)
+	v.SetDefault("events.broadcast.enabled", true)
+	v.SetDefault("events.broadcast.allowed_types", []string{"city.advisory"})
+	v.SetDefault("events.broadcast.rate_limit", 60)
+	v.SetDefault("events.broadcast.rate_window", time.Minute)
+	v.SetDefault("webhooks.enabled", true)
+	v.SetDefault("webhooks.workers", 4)
+	v.SetDefault("webhooks.queue_size", 1000)
+	v.SetDefault("webhooks.max_attempts", 6)
+	v.SetDefault("webhooks.initial_backoff", 2*time.Second)
+	v.SetDefault("webhooks.max_backoff", 5*time.Minute)
+	v.SetDefault("webhooks.timeout", 10*time.Second)
+	v.SetDefault("webhooks.disable_after", 10)
+	v.SetDefault("webhooks.allow_private_networks", false)
+	v.SetDefault("dead_letter.enabled", true)
+	v.SetDefault("dead_letter.stream", "agent-service:dead-letter")
+	v.SetDefault("dead_letter.max_len", 100000)
+	v.SetDefault("dead_letter.retention", 7*24*time.Hour)
+	v.SetDefault("dead_letter.interval", time.Minute)
+	v.SetDefault("notifications.enabled", true)
+	v.SetDefault("notifications.workers", 2)
+	v.SetDefault("notifications.queue_size", 1000)
+	v.SetDefault("notifications.max_attempts", 5)
+	v.SetDefault("notifications.initial_backoff", 5*time.Second)
+	v.SetDefault("notifications.max_backoff", 10*time.Minute)
+	v.SetDefault("notifications.timeout", 10*time.Second)
+	v.SetDefault("notifications.allow_private_networks", false)
+	v.SetDefault("notifications.smtp.host", "")
+	v.SetDefault("notifications.smtp.port", 587)
+	v.SetDefault("notifications.smtp.username", "")
+	v.SetDefault("notifications.smtp.password", "")
+	v.SetDefault("notifications.smtp.password_file", "")
+	v.SetDefault("notifications.smtp.from", "")
+	v.SetDefault("notifications.smtp.security", "starttls")
+	v.SetDefault("outbound.max_idle_conns", 100)
+	v.SetDefault("outbound.max_idle_conns_per_host", 10)
+	v.SetDefault("outbound.max_conns_per_host", 0)
+	v.SetDefault("outbound.idle_conn_timeout", 90*time.Second)
+	v.SetDefault("outbound.dial_timeout", 5*time.Second)
+	v.SetDefault("outbound.tls_handshake_timeout", 5*time.Second)
+	v.SetDefault("outbound.response_header_timeout", 10*time.Second)
+	v.SetDefault("outbound.proxy_url", "")
+	v.SetDefault("outbound.retry.max_attempts", 3)
+	v.SetDefault("outbound.retry.initial_backoff", 200*time.Millisecond)
+	v.SetDefault("outbound.retry.max_backoff", 2*time.Second)
+	v.SetDefault("outbound.breaker.failure_threshold", 5)
+	v.SetDefault("outbound.breaker.cooldown", 30*time.Second)
+	v.SetDefault("alerts.enabled", true)
+	v.SetDefault("alerts.interval", 15*time.Second)
+	v.SetDefault("schedules.enabled", true)
+	v.SetDefault("schedules.interval", 10*time.Second)
+	v.SetDefault("schedules.grace", 5*time.Minute)
+	v.SetDefault("action_batches.workers", 16)
+	v.SetDefault("action_batches.max_agents", 5000)
+	v.SetDefault("action_batches.timeout", 30*time.Second)
+	v.SetDefault("actions.workers", 8)
+	v.SetDefault("actions.queue_size", 1000)
+	v.SetDefault("actions.default_timeout", 5*time.Minute)
+	v.SetDefault("actions.cancel_poll_interval", 2*time.Second)
+	v.SetDefault("actions.priority_aging", 30*time.Second)
+	v.SetDefault("actions.recovery.heartbeat_interval", 10*time.Second)
+	v.SetDefault("actions.recovery.stale_after", time.Minute)
+	v.SetDefault("actions.recovery.interval", 30*time.Second)
+	v.SetDefault("actions.recovery.max_resumes", 3)
+	v.SetDefault("actions.allow_unregistered", false)
+	v.SetDefault("actions.retention.window", 720*time.Hour)
+	v.SetDefault("actions.retention.interval", time.Hour)
+	v.SetDefault("actions.retention.partitions_ahead", 7)
+	v.SetDefault("actions.retention.archive", false)
+	v.SetDefault("actions.history.default_window", 24*time.Hour)
+	v.SetDefault("actions.history.max_window", 31*24*time.Hour)
+	v.SetDefault("actions.definitions", []map[string]interface{}{
+		{
+			"name":         "recalculate_route",
+			"description":  "Recalcula a rota do veículo até o destino",
+			"agent_types":  []string{"vehicle", "bus"},
+			"schema":       `{"type": "object", "additionalProperties": false, "properties": {"destination": {"type": "object", "required": ["lat", "lon"], "additionalProperties": false, "properties": {"lat": {"type": "number", "minimum": -90, "maximum": 90}, "lon": {"type": "number", "minimum": -180, "maximum": 180}}}, "avoid_congestion": {"type": "boolean"}}}`,
+			"long_running": true,
+			"retry":        map[string]interface{}{"max_attempts": 3, "backoff": "5s"},
+		},
+		{
+			"name":         "recalibrate_sensor",
+			"description":  "Recalibra os sensores do agente contra um valor de referência",
+			"agent_types":  []string{"sensor"},
+			"schema":       `{"type": "object", "additionalProperties": false, "properties": {"reference": {"type": "number"}, "sensors": {"type": "array", "items": {"type": "string"}}}}`,
+			"long_running": true,
+			"retry":        map[string]interface{}{"max_attempts": 3, "backoff": "5s"},
+		},
+		{
+			"name":         "report_air_quality",
+			"description":  "Pede ao sensor uma leitura imediata da qualidade do ar",
+			"agent_types":  []string{"sensor"},
+			"capabilities": []string{"air_quality"},
+			"schema":       `{"type": "object", "additionalProperties": false, "properties": {"pollutants": {"type": "array", "items": {"type": "string", "enum": ["pm25", "pm10", "no2", "o3", "co"]}}}}`,
+		},
+		{
+			"name":        "emergency_stop",
+			"description": "Para o veículo imediatamente, fora da rota",
+			"agent_types": []string{"vehicle", "bus"},
+			"roles":       []string{"admin"},
+			"schema":      `{"type": "object", "additionalProperties": false, "required": ["reason"], "properties": {"reason": {"type": "string", "minLength": 1}}}`,
+		},
+	})
+	v.SetDefault("agent_types.max_samples", 1000)
+	v.SetDefault("agent_types.definitions", []map[string]interface{}{
+		{
+			"name":                "vehicle",
+			"proximity_threshold": 5,
+			"offline_after":       "5m",
+			"consumption":         map[string]interface{}{"wh_per_km": 180, "meter_metric": "energy_wh"},
+			"capabilities": map[string]interface{}{
+				"allowed": []string{"navigation", "v2x", "autonomous_driving"},
+				"default": []string{"navigation"},
+			},
+			"metrics": []map[string]interface{}{
+				{"name": "route_completion", "description": "Parcela da rota concluída", "unit": "%", "aggregation": "last"},
+				{"name": "speed", "unit": "km/h", "aggregation": "avg"},
+				{"name": "energy_wh", "description": "Leitura acumulada do medidor de energia", "unit": "Wh", "aggregation": "last"},
+			},
+		},
+		{
+			"name":                "bus",
+			"proximity_threshold": 10,
+			"offline_after":       "5m",
+			"consumption":         map[string]interface{}{"wh_per_km": 1200},
+			"capabilities": map[string]interface{}{
+				"allowed": []string{"navigation", "v2x", "passenger_counting"},
+				"default": []string{"navigation", "passenger_counting"},
+			},
+			"metrics": []map[string]interface{}{
+				{"name": "route_completion", "description": "Parcela da rota concluída", "unit": "%", "aggregation": "last"},
+				{"name": "passengers", "description": "Passageiros embarcados", "aggregation": "sum"},
+				{"name": "delay", "description": "Atraso em relação ao horário", "unit": "s", "aggregation": "avg"},
+			},
+			"health": map[string]interface{}{
+				"degraded": 70, "critical": 40, "hysteresis": 5,
+				"components": []map[string]interface{}{
+					{"metric": "delay", "weight": 1, "good": 60, "bad": 900},
+				},
+			},
+		},
+		{
+			"name":          "sensor",
+			"offline_after": "15m",
+			"consumption":   map[string]interface{}{"baseline_w": 2},
+			"capabilities": map[string]interface{}{
+				"allowed": []string{"temperature", "humidity", "noise", "air_quality"},
+				"default": []string{"temperature"},
+			},
+			"metrics": []map[string]interface{}{
+				{"name": "uptime", "description": "Tempo no ar", "unit": "%", "aggregation": "avg"},
+				{"name": "readings", "description": "Leituras enviadas", "aggregation": "sum"},
+			},
+			"health": map[string]interface{}{
+				"degraded": 70, "critical": 40, "hysteresis": 5,
+				"components": []map[string]interface{}{
+					{"metric": "uptime", "weight": 1, "good": 99, "bad": 80},
+				},
+			},
+		},
+	})
+	v.SetDefault("dependencies.failure_statuses", []string{"offline", "failed"})
+	v.SetDefault("dependencies.max_per_agent", 50)
+	v.SetDefault("dependencies.graph_depth", 3)
+	v.SetDefault("dependencies.max_depth", 10)
+	v.SetDefault("dependencies.queue_size", 1000)
+	v.SetDefault("messages.tick_interval", time.Second)
+	v.SetDefault("messages.inbox_size", 100)
+	v.SetDefault("messages.inbox_ttl", 24*time.Hour)
+	v.SetDefault("messages.max_pending", 10000)
+	v.SetDefault("messages.max_payload_bytes", 16384)
+	v.SetDefault("behaviors.enabled", true)
+	v.SetDefault("behaviors.max_actions", 10)
+	v.SetDefault("behaviors.workers", 8)
+	v.SetDefault("behaviors.decide_budget", 250*time.Millisecond)
+	v.SetDefault("behaviors.script.max_instructions", 100000)
+	v.SetDefault("behaviors.script.timeout", 100*time.Millisecond)
+	v.SetDefault("behaviors.script.max_memory_bytes", 16<<20)
+	v.SetDefault("behaviors.script.max_script_bytes", 64<<10)
+	v.SetDefault("trajectories.enabled", true)
+	v.SetDefault("trajectories.sample_interval", 5*time.Second)
+	v.SetDefault("trajectories.batch_size", 500)
+	v.SetDefault("trajectories.flush_interval", time.Second)
+	v.SetDefault("trajectories.queue_size", 10000)
+	v.SetDefault("trajectories.default_window", time.Hour)
+	v.SetDefault("trajectories.max_window", 7*24*time.Hour)
+	v.SetDefault("trajectories.max_points", 100000)
+	v.SetDefault("trajectories.retention.window", 7*24*time.Hour)
+	v.SetDefault("trajectories.retention.archive", false)
+	v.SetDefault("trajectories.compaction.enabled", false)
+	v.SetDefault("trajectories.compaction.interval", 10*time.Minute)
+	v.SetDefault("trajectories.compaction.age", 48*time.Hour)
+	v.SetDefault("trajectories.compaction.method", "interval")
+	v.SetDefault("trajectories.compaction.resolution", time.Minute)
+	v.SetDefault("trajectories.compaction.tolerance_m", 5.0)
+	v.SetDefault("trajectories.compaction.chunk_agents", 100)
+	v.SetDefault("trajectories.compaction.retention", 90*24*time.Hour)
+	v.SetDefault("positions.enabled", true)
+	v.SetDefault("positions.distance_threshold", 1.0)
+	v.SetDefault("positions.heading_threshold", 5.0)
+	v.SetDefault("positions.flush_interval", 250*time.Millisecond)
+	v.SetDefault("positions.keyframe_interval", 15*time.Second)
+	v.SetDefault("positions.queue_size", 10000)
+	v.SetDefault("positions.send_buffer", 64)
+	v.SetDefault("heatmaps.max_cells", 10000)
+	v.SetDefault("heatmaps.default_cell_size", 500.0)
+	v.SetDefault("heatmaps.live_ttl", 5*time.Second)
+	v.SetDefault("heatmaps.history_ttl", 10*time.Minute)
+	v.SetDefault("tiles.enabled", true)
+	v.SetDefault("tiles.max_zoom", 22)
+	v.SetDefault("tiles.cache_max_zoom", 14)
+	v.SetDefault("tiles.thin_below_zoom", 12)
+	v.SetDefault("tiles.thinning", "cluster")
+	v.SetDefault("tiles.cluster_cell_px", 40)
+	v.SetDefault("tiles.sample_limit", 2000)
+	v.SetDefault("tiles.max_features", 20000)
+	v.SetDefault("tiles.attributes", []string{"id", "type", "status"})
+	v.SetDefault("tiles.cache_ttl", 10*time.Minute)
+	v.SetDefault("tiles.flush_interval", time.Second)
+	v.SetDefault("tiles.queue_size", 10000)
+	v.SetDefault("http_cache.enabled", true)
+	v.SetDefault("http_cache.default_ttl", 5*time.Minute)
+	v.SetDefault("http_cache.default_max_age", time.Minute)
+	v.SetDefault("http_cache.max_body_bytes", 1<<20)
+	v.SetDefault("failures.recent_events", 100)
+	v.SetDefault("failures.max_event_bytes", 4096)
+	v.SetDefault("failures.max_stack_bytes", 16<<10)
+	v.SetDefault("failures.max_report_bytes", 256<<10)
+	v.SetDefault("failures.link_expiry", 15*time.Minute)
+	v.SetDefault("quotas.enabled", true)
+	v.SetDefault("quotas.defaults.agents", 0)
+	v.SetDefault("quotas.defaults.event_rows", 0)
+	v.SetDefault("quotas.defaults.checkpoint_bytes", 0)
+	v.SetDefault("quotas.defaults.archive_bytes", 0)
+	v.SetDefault("quotas.flush_interval", 5*time.Second)
+	v.SetDefault("quotas.queue_size", 10000)
+	v.SetDefault("quotas.reconcile_interval", 24*time.Hour)
+	v.SetDefault("backups.enabled", true)
+	v.SetDefault("sync_hooks.enabled", false)
+	v.SetDefault("sync_hooks.default_timeout", 2*time.Second)
+	v.SetDefault("sync_hooks.max_timeout", 5*time.Second)
+	v.SetDefault("sync_hooks.allow_private_networks", false)
+	v.SetDefault("maintenance.refresh_interval", 2*time.Second)
+	v.SetDefault("maintenance.retry_after", time.Minute)
+	v.SetDefault("i18n.default_locale", "en")
+	v.SetDefault("oidc.enabled", false)
+	v.SetDefault("oidc.issuer_url", "")
+	v.SetDefault("oidc.client_id", "")
+	v.SetDefault("oidc.client_secret", "")
+	v.SetDefault("oidc.redirect_url", "")
+	v.SetDefault("oidc.scopes", []string{"openid", "profile", "email"})
+	v.SetDefault("oidc.subject_claim", "sub")
+	v.SetDefault("oidc.groups_claim", "groups")
+	v.SetDefault("oidc.projects_claim", "")
+	v.SetDefault("oidc.role_mappings", []map[string]string{})
+	v.SetDefault("oidc.default_roles", []string{})
+	v.SetDefault("oidc.frontend_url", "")
+	v.SetDefault("oidc.session.signing_key", "")
+	v.SetDefault("oidc.session.ttl", 15*time.Minute)
+	v.SetDefault("oidc.session.refresh_ttl", 12*time.Hour)
+	v.SetDefault("simulation_metrics.enabled", false)
+	v.SetDefault("simulation_metrics.max_simulations", 50)
+	v.SetDefault("simulation_metrics.refresh_interval", 15*time.Second)
+	v.SetDefault("simulation_metrics.active_statuses", []string{"active"})
+	v.SetDefault("simulation_metrics.agent_metrics", []string{})
+	v.SetDefault("simulation_metrics.remote_write.enabled", false)
+	v.SetDefault("simulation_metrics.remote_write.url", "")
+	v.SetDefault("simulation_metrics.remote_write.interval", 30*time.Second)
+	v.SetDefault("simulation_metrics.remote_write.timeout", 10*time.Second)
+	v.SetDefault("simulation_metrics.remote_write.username", "")
+	v.SetDefault("simulation_metrics.remote_write.password", "")
+	v.SetDefault("simulation_metrics.remote_write.bearer_token", "")
+	v.SetDefault("simulation_metrics.remote_write.labels", []string{})
+	v.SetDefault("simulation_metrics.remote_write.allow_private_networks", false)
+	v.SetDefault("kpis.enabled", true)
+	v.SetDefault("kpis.interval", 15*time.Second)
+	v.SetDefault("kpis.history_points", 120)
+	v.SetDefault("kpis.retention", 7*24*time.Hour)
+	v.SetDefault("kpis.buffer_size", 4096)
+	v.SetDefault("change_feed.enabled", false)
+	v.SetDefault("change_feed.stream", "agent-service:changes:agents")
+	v.SetDefault("change_feed.retention", 72*time.Hour)
+	v.SetDefault("change_feed.default_limit", 100)
+	v.SetDefault("change_feed.max_limit", 1000)
+	v.SetDefault("query_budget.enabled", true)
+	v.SetDefault("query_budget.max_rows", 20000)
+	v.SetDefault("query_budget.max_offset", 10000)
+	v.SetDefault("ingestion_limits.enabled", false)
+	v.SetDefault("ingestion_limits.window", time.Minute)
+	v.SetDefault("ingestion_limits.default_limit", 0)
+	v.SetDefault("ingestion_limits.sample_one_in", 10)
+	v.SetDefault("ingestion_limits.health_penalty", 25.0)
+	v.SetDefault("seed.enabled", false)
+	v.SetDefault("seed.agents_per_type", 100)
+	v.SetDefault("seed.project_id", "demo")
+	v.SetDefault("seed.history", 24*time.Hour)
+	v.SetDefault("seed.bbox.min_lat", -23.68)
+	v.SetDefault("seed.bbox.min_lon", -46.80)
+	v.SetDefault("seed.bbox.max_lat", -23.45)
+	v.SetDefault("seed.bbox.max_lon", -46.45)
+	v.SetDefault("faults.enabled", true)
+	v.SetDefault("faults.refresh_interval", 5*time.Second)
+	v.SetDefault("faults.max_duration", time.Hour)
+	v.SetDefault("faults.max_latency", 30*time.Second)
+	v.SetDefault("faults.max_agents", 1000)
+	v.SetDefault("faults.max_jitter_m", 500.0)
+	v.SetDefault("exports.enabled", true)
+	v.SetDefault("feature_flags.channel", "agent-service:flags")
+	v.SetDefault("feature_flags.refresh_interval", time.Minute)
+	for _, d := range featureflag.Definitions {
+		v.SetDefault("feature_flags.defaults."+d.Name, d.Default)
+	}
+	v.SetDefault("project_limits.channel", "agent-service:project-settings")
+	v.SetDefault("project_limits.refresh_interval", time.Minute)
+	v.SetDefault("project_limits.defaults.requests_per_minute", 0)
+	v.SetDefault("project_limits.defaults.request_timeout", time.Duration(0))
+	v.SetDefault("project_limits.defaults.export_max_bytes", 0)
+	v.SetDefault("project_limits.defaults.allowed_origins", []string{})
+	v.SetDefault("exports.quiesce_timeout", 10*time.Second)
+	v.SetDefault("exports.timeout", 10*time.Minute)
+	v.SetDefault("deletions.enabled", true)
+	v.SetDefault("deletions.interval", 10*time.Second)
+	v.SetDefault("deletions.batch_size", 5000)
+	v.SetDefault("deletions.pause", 100*time.Millisecond)
+	v.SetDefault("agents.batch_get_max", 500)
+	v.SetDefault("groups.max_members", 1000)
+	v.SetDefault("groups.start_status", "active")
+	v.SetDefault("groups.stop_status", "idle")
+	v.SetDefault("presence.enabled", true)
+	v.SetDefault("presence.interval", 30*time.Second)
+	v.SetDefault("presence.offline_status", "offline")
+	v.SetDefault("presence.max_per_cycle", 500)
+	v.SetDefault("coalescing.enabled", false)
+	v.SetDefault("coalescing.flush_interval", 10*time.Second)
+	v.SetDefault("coalescing.position_threshold_m", 50.0)
+	v.SetDefault("coalescing.live_ttl", 5*time.Minute)
+	v.SetDefault("rollups.enabled", true)
+	v.SetDefault("rollups.refresh_interval", 5*time.Minute)
+	v.SetDefault("rollups.max_staleness", 15*time.Minute)
+	v.SetDefault("supervisor.initial_backoff", time.Second)
+	v.SetDefault("supervisor.max_backoff", time.Minute)
+	v.SetDefault("twins.max_document_bytes", 64*1024)
+	v.SetDefault("twins.max_depth", 16)
+	v.SetDefault("revisions.max_per_agent", 50)
+	v.SetDefault("resets.max_agents", 500)
+	v.SetDefault("resets.quiesce_timeout", 10*time.Second)
+	v.SetDefault("anomalies.enabled", true)
+	v.SetDefault("anomalies.health_penalty", 15.0)
+	v.SetDefault("transfers.running_statuses", []string{"active"})
+	v.SetDefault("transfers.pause_status", "paused")
+	v.SetDefault("transfers.default_max_agents", 0)
+	v.SetDefault("transfers.project_expiry", "72h")
+	v.SetDefault("proximity.enabled", true)
+	v.SetDefault("proximity.max_events_per_tick", 1000)
+	v.SetDefault("consumption.enabled", true)
+	v.SetDefault("consumption.bucket", 15*time.Minute)
+	v.SetDefault("consumption.flush_interval", 10*time.Second)
+	v.SetDefault("consumption.inactive_statuses", []string{"offline", "failed"})
+	v.SetDefault("consumption.default_bucket", time.Hour)
+	v.SetDefault("consumption.max_buckets", 1000)
+	v.SetDefault("mqtt.enabled", false)
+	v.SetDefault("mqtt.brokers", []string{"tcp://localhost:1883"})
+	v.SetDefault("mqtt.client_id", "")
+	v.SetDefault("mqtt.username", "")
+	v.SetDefault("mqtt.password", "")
+	v.SetDefault("mqtt.password_file", "")
+	v.SetDefault("mqtt.qos", 1)
+	v.SetDefault("mqtt.telemetry_topic", "city/sensors/+/telemetry")
+	v.SetDefault("mqtt.shared_group", "agent-service")
+	v.SetDefault("mqtt.command_topic", "city/agents/{agent_id}/commands")
+	v.SetDefault("mqtt.sensor_agent_types", []string{"sensor"})
+	v.SetDefault("mqtt.workers", 4)
+	v.SetDefault("mqtt.clean_session", false)
+	v.SetDefault("mqtt.keep_alive", 30*time.Second)
+	v.SetDefault("mqtt.connect_timeout", 10*time.Second)
+	v.SetDefault("mqtt.reconnect.enabled", true)
+	v.SetDefault("mqtt.reconnect.retry_interval", 5*time.Second)
+	v.SetDefault("mqtt.reconnect.max_interval", 2*time.Minute)
+	v.SetDefault("mqtt.dead_letter.stream", "agent-service:mqtt:dead-letter")
+	v.SetDefault("mqtt.dead_letter.max_len", 10000)
+	v.SetDefault("mqtt.tls.enabled", false)
+	v.SetDefault("mqtt.tls.ca_file", "")
+	v.SetDefault("mqtt.tls.cert_file", "")
+	v.SetDefault("mqtt.tls.key_file", "")
+	v.SetDefault("mqtt.tls.server_name", "")
+	v.SetDefault("mqtt.tls.insecure_skip_verify", false)
+	v.SetDefault("event_export.enabled", false)
+	v.SetDefault("event_export.sink", "kafka")
+	v.SetDefault("event_export.topics", []string{"agents", "simulations"})
+	v.SetDefault("event_export.subject_template", "smartcity.{topic}.{type}")
+	v.SetDefault("event_export.routes", []map[string]string{})
+	v.SetDefault("event_export.versions", []string{})
+	v.SetDefault("event_export.batch_size", 100)
+	v.SetDefault("event_export.batch_timeout", time.Second)
+	v.SetDefault("event_export.retry_backoff", time.Second)
+	v.SetDefault("event_export.max_backoff", time.Minute)
+	v.SetDefault("event_export.outbox.stream", "agent-service:events:outbox")
+	v.SetDefault("event_export.outbox.max_len", 1000000)
+	v.SetDefault("event_export.outbox.group", "event-export")
+	v.SetDefault("event_export.outbox.buffer_size", 10000)
+	v.SetDefault("event_export.outbox.claim_idle", time.Minute)
+	v.SetDefault("event_export.kafka.brokers", []string{"localhost:9092"})
+	v.SetDefault("event_export.kafka.required_acks", "all")
+	v.SetDefault("event_export.kafka.compression", "snappy")
+	v.SetDefault("event_export.kafka.write_timeout", 10*time.Second)
+	v.SetDefault("event_export.kafka.allow_auto_topic_creation", false)
+	v.SetDefault("event_export.kafka.tls.enabled", false)
+	v.SetDefault("event_export.kafka.tls.ca_file", "")
+	v.SetDefault("event_export.kafka.tls.cert_file", "")
+	v.SetDefault("event_export.kafka.tls.key_file", "")
+	v.SetDefault("event_export.kafka.tls.server_name", "")
+	v.SetDefault("event_export.kafka.tls.insecure_skip_verify", false)
+	v.SetDefault("event_export.nats.url", "nats://localhost:4222")
+	v.SetDefault("event_export.nats.credentials_file", "")
+	v.SetDefault("event_export.nats.ack_timeout", 5*time.Second)
+	v.SetDefault("event_export.nats.tls.enabled", false)
+	v.SetDefault("event_export.nats.tls.ca_file", "")
+	v.SetDefault("event_export.nats.tls.cert_file", "")
+	v.SetDefault("event_export.nats.tls.key_file", "")
+	v.SetDefault("event_export.nats.tls.server_name", "")
+	v.SetDefault("event_export.nats.tls.insecure_skip_verify", false)
+	v.SetDefault("storage.backend", "filesystem")
+	v.SetDefault("storage.presign_threshold", 8<<20)
+	v.SetDefault("storage.presign_expiry", 15*time.Minute)
+	v.SetDefault("storage.filesystem.root", "data/objects")
+	v.SetDefault("storage.filesystem.signing_key", "")
+	v.SetDefault("storage.filesystem.signing_key_file", "")
+	v.SetDefault("storage.filesystem.public_url", "/storage")
+	v.SetDefault("storage.s3.endpoint", "localhost:9000")
+	v.SetDefault("storage.s3.region", "us-east-1")
+	v.SetDefault("storage.s3.bucket", "smart-city")
+	v.SetDefault("storage.s3.access_key", "")
+	v.SetDefault("storage.s3.secret_key", "")
+	v.SetDefault("storage.s3.secret_key_file", "")
+	v.SetDefault("storage.s3.path_style", true)
+	v.SetDefault("storage.s3.create_bucket", false)
+	v.SetDefault("storage.s3.tls.enabled", false)
+	v.SetDefault("storage.s3.tls.ca_file", "")
+	v.SetDefault("storage.s3.tls.cert_file", "")
+	v.SetDefault("storage.s3.tls.key_file", "")
+	v.SetDefault("storage.s3.tls.server_name", "")
+	v.SetDefault("storage.s3.tls.insecure_skip_verify", false)
+	v.SetDefault("debug.enabled", false)
+	v.SetDefault("debug.host", "127.0.0.1")
+	v.SetDefault("debug.port", "6060")
+	v.SetDefault("debug.token", "")
+	v.SetDefault("admin.token", "")
+	v.SetDefault("log.level", "info")
+	v.SetDefault("log.format", "json")
+	v.SetDefault("gin.mode", "debug")
+	v.SetDefault("observability.slow_query_threshold", 200*time.Millisecond)
+	v.SetDefault("observability.slow_request_threshold", 2*time.Second)
+	v.SetDefault("health.check_interval", 10*time.Second)
+	v.SetDefault("registry.heartbeat_interval", 5*time.Second)
+	v.SetDefault("registry.ttl", 15*time.Second)
+}
+
+// Load cria uma instância viper nova com a mesma precedência da instância
+// global, sem tocá-la. É usada para validar recargas.
+func Load(configFile string, fs *pflag.FlagSet) (*viper.Viper, error) {
+	v := viper.New()
+	Setup(v, fs)
+	if configFile != "" {
+		v.SetConfigFile(configFile)
+	}
+	if err := v.ReadInConfig(); err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+			return nil, err
+		}
+	}
+	return v, nil
+}
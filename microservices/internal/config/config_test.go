@@ -0,0 +1,110 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeConfig(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeConfig(t, "server:\n  port: \"1111\"\ndatabase:\n  host: file-db\n")
+	tests := []struct {
+		name     string
+		file     string
+		env      map[string]string
+		args     []string
+		wantPort string
+		wantHost string
+	}{
+		{name: "default", wantPort: "8080", wantHost: "localhost"},
+		{name: "file over default", file: file, wantPort: "1111", wantHost: "file-db"},
+		{
+			name: "env over file", file: file,
+			env:      map[string]string{"SMARTCITY_SERVER_PORT": "2222", "SMARTCITY_DATABASE_HOST": "env-db"},
+			wantPort: "2222", wantHost: "env-db",
+		},
+		{
+			name: "flag over env", file: file,
+			env:      map[string]string{"SMARTCITY_SERVER_PORT": "2222", "SMARTCITY_DATABASE_HOST": "env-db"},
+			args:     []string{"--port=3333", "--db-host=flag-db"},
+			wantPort: "3333", wantHost: "flag-db",
+		},
+		{
+			name: "unset flag keeps env", file: file,
+			env:      map[string]string{"SMARTCITY_SERVER_PORT": "2222"},
+			args:     []string{"--db-host=flag-db"},
+			wantPort: "2222", wantHost: "flag-db",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			fs := NewFlagSet("test")
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			v, err := Load(tt.file, fs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := v.GetString("server.port"); got != tt.wantPort {
+				t.Errorf("server.port = %q, want %q", got, tt.wantPort)
+			}
+			if got := v.GetString("database.host"); got != tt.wantHost {
+				t.Errorf("database.host = %q, want %q", got, tt.wantHost)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFlag(t *testing.T) {
+	file := writeConfig(t, "server:\n  port: \"4444\"\n")
+	fs := NewFlagSet("test")
+	if err := fs.Parse([]string{"--config=" + file}); err != nil {
+		t.Fatal(err)
+	}
+	v, err := Load("", fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := v.GetString("server.port"); got != "4444" {
+		t.Errorf("server.port = %q, want 4444 from --config", got)
+	}
+}
+
+func TestLegacyLogEnv(t *testing.T) {
+	t.Setenv("LOG_LEVEL", "debug")
+	v, err := Load("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := v.GetString("log.level"); got != "debug" {
+		t.Errorf("log.level = %q, want debug from LOG_LEVEL", got)
+	}
+}
+
+func TestEffectiveRedactsSecrets(t *testing.T) {
+	t.Setenv("SMARTCITY_DATABASE_PASSWORD", "hunter2")
+	v, err := Load("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eff := Effective(v)
+	if got := eff["database.password"]; got != Redacted {
+		t.Errorf("database.password = %v, want redacted", got)
+	}
+	if got := eff["database.host"]; got != "localhost" {
+		t.Errorf("database.host = %v, want localhost", got)
+	}
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// EnvPrefix é o prefixo das variáveis de ambiente que sobrescrevem qualquer
+// chave: database.host → SMARTCITY_DATABASE_HOST.
+const EnvPrefix = "SMARTCITY"
+
+// BindEnv habilita a leitura automática de variáveis de ambiente.
+func BindEnv(v *viper.Viper) {
+	v.SetEnvPrefix(EnvPrefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+	// Nomes legados aceitos além do nome com prefixo.
+	v.BindEnv("log.level", EnvPrefix+"_LOG_LEVEL", "LOG_LEVEL")
+	v.BindEnv("log.format", EnvPrefix+"_LOG_FORMAT", "LOG_FORMAT")
+}
+
+// flagKeys mapeia as flags de linha de comando para as chaves de configuração.
+var flagKeys = map[string]string{
+	"host":          "server.host",
+	"port":          "server.port",
+	"db-host":       "database.host",
+	"db-port":       "database.port",
+	"db-name":       "database.name",
+	"db-user":       "database.user",
+	"redis-host":    "redis.host",
+	"redis-port":    "redis.port",
+	"log-level":     "log.level",
+	"log-format":    "log.format",
+	"grpc-port":     "grpc.port",
+	"debug-enabled": "debug.enabled",
+	"gin-mode":      "gin.mode",
+}
+
+// NewFlagSet define as flags das configurações mais comuns. A precedência
+// final é flag > variável de ambiente > arquivo > padrão.
+func NewFlagSet(name string) *pflag.FlagSet {
+	fs := pflag.NewFlagSet(name, pflag.ContinueOnError)
+	fs.String("config", "", "caminho do arquivo de configuração")
+	fs.Bool("validate-config", false, "apenas valida a configuração e encerra (uso em CI)")
+	fs.String("host", "", "endereço de escuta do servidor HTTP")
+	fs.String("port", "", "porta do servidor HTTP")
+	fs.String("db-host", "", "host do PostgreSQL")
+	fs.Int("db-port", 0, "porta do PostgreSQL")
+	fs.String("db-name", "", "nome do banco de dados")
+	fs.String("db-user", "", "usuário do banco de dados")
+	fs.String("redis-host", "", "host do Redis")
+	fs.Int("redis-port", 0, "porta do Redis")
+	fs.String("log-level", "", "nível de log (debug, info, warn, error)")
+	fs.String("log-format", "", "formato de log (json, text)")
+	fs.String("grpc-port", "", "porta do servidor gRPC")
+	fs.Bool("debug-enabled", false, "habilita o listener de diagnóstico")
+	fs.String("gin-mode", "", "modo do Gin (debug, release)")
+	return fs
+}
+
+// BindFlags associa as flags às chaves de configuração. Só flags passadas
+// explicitamente sobrescrevem os demais níveis.
+func BindFlags(v *viper.Viper, fs *pflag.FlagSet) {
+	if fs == nil {
+		return
+	}
+	for flag, key := range flagKeys {
+		if f := fs.Lookup(flag); f != nil {
+			v.BindPFlag(key, f)
+		}
+	}
+	if f := fs.Lookup("config"); f != nil && f.Changed {
+		v.SetConfigFile(f.Value.String())
+	}
+}
+
+// Effective retorna a configuração efetiva achatada, com valores sensíveis mascarados.
+func Effective(v *viper.Viper) map[string]interface{} {
+	keys := v.AllKeys()
+	sort.Strings(keys)
+	effective := make(map[string]interface{}, len(keys))
+	for _, k := range keys {
+		effective[k] = RedactValue(k, v.Get(k))
+	}
+	return effective
+}
+
+// LogEffective registra a configuração efetiva com valores sensíveis mascarados.
+func LogEffective(v *viper.Viper) {
+	logrus.WithField("config", Effective(v)).Info("Configuração efetiva")
+}
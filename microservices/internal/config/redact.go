@@ -0,0 +1,27 @@
+package config
+
+import "strings"
+
+// Redacted substitui valores sensíveis em logs e respostas.
+const Redacted = "********"
+
+var secretMarkers = []string{"password", "secret", "token", "key", "credential"}
+
+// IsSecret indica se a chave guarda um valor sensível.
+func IsSecret(key string) bool {
+	key = strings.ToLower(key)
+	for _, marker := range secretMarkers {
+		if strings.Contains(key, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactValue retorna value ou o marcador de redação se a chave for sensível.
+func RedactValue(key string, value interface{}) interface{} {
+	if IsSecret(key) {
+		return Redacted
+	}
+	return value
+}
@@ -0,0 +1,172 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/logging"
+)
+
+// EventConfigReloaded é emitido no tópico admin após uma recarga aplicada.
+const EventConfigReloaded = "config.reloaded"
+
+// Component é um consumidor de configuração recarregável. Validate recebe a
+// configuração candidata e deve rejeitá-la sem efeitos colaterais; Apply só é
+// chamado depois que todos os componentes a validaram.
+type Component struct {
+	Name     string
+	Validate func(v *viper.Viper) error
+	Apply    func(v *viper.Viper)
+}
+
+// KeyChange descreve a alteração de uma chave (valores sensíveis redigidos).
+type KeyChange struct {
+	Key string      `json:"key"`
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+// Registry coordena recargas de configuração entre os componentes.
+type Registry struct {
+	live       *viper.Viper
+	flags      *pflag.FlagSet
+	publisher  events.Publisher
+	resolver   Resolver
+	mu         sync.Mutex
+	components []Component
+}
+
+// Resolver substitui referências indiretas (segredos) numa candidata antes da validação.
+type Resolver func(ctx context.Context, v *viper.Viper) error
+
+// NewRegistry cria o registro sobre a instância viper usada pelo serviço.
+// flags são reaplicadas às candidatas para manter a precedência.
+func NewRegistry(live *viper.Viper, flags *pflag.FlagSet, publisher events.Publisher) *Registry {
+	return &Registry{live: live, flags: flags, publisher: publisher}
+}
+
+// SetResolver define o resolvedor aplicado às candidatas de recarga.
+func (r *Registry) SetResolver(fn Resolver) {
+	r.mu.Lock()
+	r.resolver = fn
+	r.mu.Unlock()
+}
+
+// Register adiciona um componente recarregável.
+func (r *Registry) Register(c Component) {
+	r.mu.Lock()
+	r.components = append(r.components, c)
+	r.mu.Unlock()
+}
+
+// Reload relê o arquivo de configuração, valida com todos os componentes e,
+// se tudo for aceito, aplica as mudanças. Em caso de erro a configuração
+// ativa permanece intacta.
+func (r *Registry) Reload(ctx context.Context) ([]KeyChange, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	log := logging.FromContext(ctx)
+
+	candidate, err := Load(r.live.ConfigFileUsed(), r.flags)
+	if err != nil {
+		return nil, fmt.Errorf("ler configuração: %w", err)
+	}
+	if r.resolver != nil {
+		if err := r.resolver(ctx, candidate); err != nil {
+			return nil, fmt.Errorf("resolver segredos: %w", err)
+		}
+	}
+
+	var errs []error
+	if _, err := Parse(candidate); err != nil {
+		errs = append(errs, err)
+	}
+	for _, c := range r.components {
+		if c.Validate == nil {
+			continue
+		}
+		if err := c.Validate(candidate); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", c.Name, err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		log.WithError(err).Warn("Recarga de configuração rejeitada; mantendo valores atuais")
+		return nil, err
+	}
+
+	changes := diff(r.live, candidate)
+	if len(changes) == 0 {
+		return changes, nil
+	}
+	for _, ch := range changes {
+		r.live.Set(ch.Key, candidate.Get(ch.Key))
+	}
+	for _, c := range r.components {
+		if c.Apply != nil {
+			c.Apply(r.live)
+		}
+	}
+
+	redacted := make([]KeyChange, len(changes))
+	keys := make([]string, len(changes))
+	for i, ch := range changes {
+		redacted[i] = KeyChange{Key: ch.Key, Old: RedactValue(ch.Key, ch.Old), New: RedactValue(ch.Key, ch.New)}
+		keys[i] = ch.Key
+	}
+	log.WithFields(logrus.Fields{"changed_keys": keys, "changes": redacted}).Info("Configuração recarregada")
+	if r.publisher != nil {
+		payload := events.ConfigReloadedV1{Changes: make([]events.ConfigChangeV1, len(redacted))}
+		for i, ch := range redacted {
+			payload.Changes[i] = events.ConfigChangeV1(ch)
+		}
+		r.publisher.Publish(ctx, events.New(events.TopicAdmin, EventConfigReloaded, payload))
+	}
+	return redacted, nil
+}
+
+// Watch observa o arquivo de configuração e dispara Reload a cada mudança.
+func (r *Registry) Watch() {
+	path := r.live.ConfigFileUsed()
+	if path == "" {
+		return
+	}
+	watcher := viper.New()
+	watcher.SetConfigFile(path)
+	watcher.OnConfigChange(func(e fsnotify.Event) {
+		ctx := logging.Background(context.Background(), "config-watcher")
+		if _, err := r.Reload(ctx); err != nil {
+			logging.FromContext(ctx).WithError(err).WithField("file", e.Name).Warn("Falha ao recarregar configuração alterada")
+		}
+	})
+	watcher.WatchConfig()
+}
+
+func diff(oldV, newV *viper.Viper) []KeyChange {
+	keys := map[string]struct{}{}
+	for _, k := range oldV.AllKeys() {
+		keys[k] = struct{}{}
+	}
+	for _, k := range newV.AllKeys() {
+		keys[k] = struct{}{}
+	}
+
+	var changes []KeyChange
+	for k := range keys {
+		o, n := oldV.Get(k), newV.Get(k)
+		if fmt.Sprint(o) != fmt.Sprint(n) {
+			changes = append(changes, KeyChange{Key: k, Old: o, New: n})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes
+}
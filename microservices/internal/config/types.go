@@ -0,0 +1,1321 @@
+package config
+
+import "time"
+
+// Config é a configuração tipada completa do serviço. Toda chave aceita no
+// arquivo, em variáveis de ambiente ou flags precisa existir aqui; chaves
+// desconhecidas são rejeitadas na validação.
+type Config struct {
+	Server        ServerConfig        `mapstructure:"server"`
+	Database      DatabaseConfig      `mapstructure:"database"`
+	Redis         RedisConfig         `mapstructure:"redis"`
+	Secrets       SecretsConfig       `mapstructure:"secrets"`
+	GRPC          GRPCConfig          `mapstructure:"grpc"`
+	GraphQL       GraphQLConfig       `mapstructure:"graphql"`
+	Events        EventsConfig        `mapstructure:"events"`
+	Webhooks      WebhooksConfig      `mapstructure:"webhooks"`
+	DeadLetter    DeadLetterConfig    `mapstructure:"dead_letter"`
+	Notifications NotificationsConfig `mapstructure:"notifications"`
+	Outbound      OutboundConfig      `mapstructure:"outbound"`
+	Alerts        AlertsConfig        `mapstructure:"alerts"`
+	ActionBatches ActionBatchesConfig `mapstructure:"action_batches"`
+	Actions       ActionsConfig       `mapstructure:"actions"`
+	Schedules     SchedulesConfig     `mapstructure:"schedules"`
+	AgentTypes    AgentTypesConfig    `mapstructure:"agent_types"`
+	Dependencies  DependenciesConfig  `mapstructure:"dependencies"`
+	Messages      MessagesConfig      `mapstructure:"messages"`
+	Behaviors     BehaviorsConfig     `mapstructure:"behaviors"`
+	Trajectories  TrajectoriesConfig  `mapstructure:"trajectories"`
+	Positions     PositionsConfig     `mapstructure:"positions"`
+	Heatmaps      HeatmapsConfig      `mapstructure:"heatmaps"`
+	Tiles         TilesConfig         `mapstructure:"tiles"`
+	HTTPCache     HTTPCacheConfig     `mapstructure:"http_cache"`
+	Failures      FailuresConfig      `mapstructure:"failures"`
+	Quotas        QuotasConfig        `mapstructure:"quotas"`
+	Backups       BackupsConfig       `mapstructure:"backups"`
+	SyncHooks     SyncHooksConfig     `mapstructure:"sync_hooks"`
+	Maintenance   MaintenanceConfig   `mapstructure:"maintenance"`
+	I18n          I18nConfig          `mapstructure:"i18n"`
+	OIDC          OIDCConfig          `mapstructure:"oidc"`
+	SimMetrics    SimMetricsConfig    `mapstructure:"simulation_metrics"`
+	KPIs          KPIsConfig          `mapstructure:"kpis"`
+	ChangeFeed    ChangeFeedConfig    `mapstructure:"change_feed"`
+	QueryBudget   QueryBudgetConfig   `mapstructure:"query_budget"`
+	Ingestion     IngestionConfig     `mapstructure:"ingestion_limits"`
+	Seed          SeedConfig          `mapstructure:"seed"`
+	Faults        FaultsConfig        `mapstructure:"faults"`
+	Exports       ExportsConfig       `mapstructure:"exports"`
+	Deletions     DeletionsConfig     `mapstructure:"deletions"`
+	FeatureFlags  FeatureFlagsConfig  `mapstructure:"feature_flags"`
+	ProjectLimits ProjectLimitsConfig `mapstructure:"project_limits"`
+	Proximity     ProximityConfig     `mapstructure:"proximity"`
+	Consumption   ConsumptionConfig   `mapstructure:"consumption"`
+	Agents        AgentsConfig        `mapstructure:"agents"`
+	Groups        GroupsConfig        `mapstructure:"groups"`
+	Transfers     TransfersConfig     `mapstructure:"transfers"`
+	Presence      PresenceConfig      `mapstructure:"presence"`
+	Twins         TwinsConfig         `mapstructure:"twins"`
+	Revisions     RevisionsConfig     `mapstructure:"revisions"`
+	Resets        ResetsConfig        `mapstructure:"resets"`
+	Anomalies     AnomaliesConfig     `mapstructure:"anomalies"`
+	Coalescing    CoalescingConfig    `mapstructure:"coalescing"`
+	Rollups       RollupsConfig       `mapstructure:"rollups"`
+	Supervisor    SupervisorConfig    `mapstructure:"supervisor"`
+	MQTT          MQTTConfig          `mapstructure:"mqtt"`
+	EventExport   EventExportConfig   `mapstructure:"event_export"`
+	Storage       StorageConfig       `mapstructure:"storage"`
+	Debug         DebugConfig         `mapstructure:"debug"`
+	Admin         AdminConfig         `mapstructure:"admin"`
+	Log           LogConfig           `mapstructure:"log"`
+	Gin           GinConfig           `mapstructure:"gin"`
+	Observability ObservabilityConfig `mapstructure:"observability"`
+	Health        HealthConfig        `mapstructure:"health"`
+	Registry      RegistryConfig      `mapstructure:"registry"`
+}
+
+// ServerConfig configura o servidor HTTP da API.
+type ServerConfig struct {
+	Host      string          `mapstructure:"host"`
+	Port      string          `mapstructure:"port"`
+	ReusePort bool            `mapstructure:"reuse_port"`
+	CORS      CORSConfig      `mapstructure:"cors"`
+	TLS       ServerTLSConfig `mapstructure:"tls"`
+	Shutdown  ShutdownConfig  `mapstructure:"shutdown"`
+}
+
+// ShutdownConfig controla a drenagem antes do encerramento.
+type ShutdownConfig struct {
+	// DrainDelay é quanto esperar, com o probe de prontidão falhando, antes
+	// de começar a encerrar, para o load balancer parar de enviar tráfego.
+	DrainDelay time.Duration `mapstructure:"drain_delay"`
+	Timeout    time.Duration `mapstructure:"timeout"`
+}
+
+// ServerTLSConfig configura HTTPS e mTLS opcional no servidor.
+type ServerTLSConfig struct {
+	Enabled      bool     `mapstructure:"enabled"`
+	CertFile     string   `mapstructure:"cert_file"`
+	KeyFile      string   `mapstructure:"key_file"`
+	ClientCAFile string   `mapstructure:"client_ca_file"`
+	ClientAuth   string   `mapstructure:"client_auth"`
+	ClientRoles  []string `mapstructure:"client_roles"`
+}
+
+// CORSConfig configura a política CORS.
+type CORSConfig struct {
+	AllowedOrigins []string `mapstructure:"allowed_origins"`
+}
+
+// DatabaseConfig configura a conexão com o PostgreSQL.
+type DatabaseConfig struct {
+	Host         string `mapstructure:"host"`
+	Port         int    `mapstructure:"port"`
+	Name         string `mapstructure:"name"`
+	User         string `mapstructure:"user"`
+	Password     string `mapstructure:"password"`
+	PasswordFile string `mapstructure:"password_file"`
+	SSLMode      string `mapstructure:"sslmode"`
+	SSLRootCert  string `mapstructure:"sslrootcert"`
+	SSLCert      string `mapstructure:"sslcert"`
+	SSLKey       string `mapstructure:"sslkey"`
+}
+
+// RedisConfig configura a conexão com o Redis.
+type RedisConfig struct {
+	Host         string          `mapstructure:"host"`
+	Port         int             `mapstructure:"port"`
+	Password     string          `mapstructure:"password"`
+	PasswordFile string          `mapstructure:"password_file"`
+	TLS          ClientTLSConfig `mapstructure:"tls"`
+}
+
+// ClientTLSConfig configura TLS numa conexão de saída (Redis, broker MQTT).
+type ClientTLSConfig struct {
+	Enabled            bool   `mapstructure:"enabled"`
+	CAFile             string `mapstructure:"ca_file"`
+	CertFile           string `mapstructure:"cert_file"`
+	KeyFile            string `mapstructure:"key_file"`
+	ServerName         string `mapstructure:"server_name"`
+	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify"`
+}
+
+// SecretsConfig configura a resolução de segredos.
+type SecretsConfig struct {
+	Provider        string        `mapstructure:"provider"`
+	RefreshInterval time.Duration `mapstructure:"refresh_interval"`
+	Vault           VaultConfig   `mapstructure:"vault"`
+}
+
+// VaultConfig configura o provider HashiCorp Vault.
+type VaultConfig struct {
+	Address   string `mapstructure:"address"`
+	Token     string `mapstructure:"token"`
+	TokenFile string `mapstructure:"token_file"`
+	Mount     string `mapstructure:"mount"`
+}
+
+// GRPCConfig configura o servidor gRPC, exposto em porta própria.
+type GRPCConfig struct {
+	Enabled    bool   `mapstructure:"enabled"`
+	Host       string `mapstructure:"host"`
+	Port       string `mapstructure:"port"`
+	Reflection bool   `mapstructure:"reflection"`
+}
+
+// GraphQLConfig configura o endpoint GraphQL em /api/v1/graphql.
+type GraphQLConfig struct {
+	Enabled       bool `mapstructure:"enabled"`
+	MaxDepth      int  `mapstructure:"max_depth"`
+	MaxComplexity int  `mapstructure:"max_complexity"`
+	Introspection bool `mapstructure:"introspection"`
+	// RecentEvents é quantos eventos do barramento ficam disponíveis em
+	// Query.events e em GET /api/v1/events, mesmo com o GraphQL desabilitado.
+	RecentEvents int `mapstructure:"recent_events"`
+}
+
+// EventsConfig configura o barramento de eventos.
+type EventsConfig struct {
+	// SchemaValidation valida os payloads publicados contra o registro de
+	// schemas: off, warn (registra e entrega) ou reject (registra e descarta).
+	SchemaValidation string `mapstructure:"schema_validation"`
+	// WebsocketVersions fixa versões de payload entregues pelo websocket,
+	// ex.: ["agent.updated.v1"]; tipos ausentes vão na versão atual.
+	WebsocketVersions []string `mapstructure:"websocket_versions"`
+	// Relay repassa os eventos do hub websocket entre as réplicas.
+	Relay HubRelayConfig `mapstructure:"relay"`
+	// LongPoll configura GET /api/v1/events/poll, a alternativa ao
+	// websocket para clientes sem websocket nem SSE.
+	LongPoll LongPollConfig `mapstructure:"long_poll"`
+	// Broadcast configura POST /api/v1/internal/broadcast, pelo qual outros
+	// serviços publicam eventos para os clientes do hub.
+	Broadcast BroadcastConfig `mapstructure:"broadcast"`
+}
+
+// BroadcastConfig configura a publicação de eventos por outros serviços.
+type BroadcastConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// AllowedTypes são os tipos de evento, do registro de schemas, que os
+	// serviços podem publicar.
+	AllowedTypes []string `mapstructure:"allowed_types"`
+	// RateLimit limita as publicações de cada chave por RateWindow.
+	RateLimit  int           `mapstructure:"rate_limit"`
+	RateWindow time.Duration `mapstructure:"rate_window"`
+}
+
+// LongPollConfig configura a entrega de eventos por long-poll.
+type LongPollConfig struct {
+	// BufferSize é quantas mensagens do hub ficam guardadas para os polls.
+	BufferSize int `mapstructure:"buffer_size"`
+	// MaxEvents limita os eventos de uma resposta.
+	MaxEvents int `mapstructure:"max_events"`
+	// DefaultTimeout é a espera de um poll sem ?timeout=; MaxTimeout, o
+	// maior ?timeout= aceito.
+	DefaultTimeout time.Duration `mapstructure:"default_timeout"`
+	MaxTimeout     time.Duration `mapstructure:"max_timeout"`
+	// MaxPerPrincipal limita os polls simultâneos de um mesmo principal em
+	// cada instância.
+	MaxPerPrincipal int `mapstructure:"max_per_principal"`
+}
+
+// HubRelayConfig configura o repasse, pelo Redis, dos eventos do hub
+// websocket entre as réplicas.
+type HubRelayConfig struct {
+	Enabled   bool   `mapstructure:"enabled"`
+	Channel   string `mapstructure:"channel"`
+	QueueSize int    `mapstructure:"queue_size"`
+	// PeerRefresh é o intervalo da releitura das instâncias registradas;
+	// sem outras, nada é publicado.
+	PeerRefresh time.Duration `mapstructure:"peer_refresh"`
+	// MaxAttempts é o número de tentativas de publicar um lote; esgotadas,
+	// as mensagens vão para o dead-letter.
+	MaxAttempts int `mapstructure:"max_attempts"`
+}
+
+// WebhooksConfig configura a entrega de webhooks.
+type WebhooksConfig struct {
+	Enabled              bool          `mapstructure:"enabled"`
+	Workers              int           `mapstructure:"workers"`
+	QueueSize            int           `mapstructure:"queue_size"`
+	MaxAttempts          int           `mapstructure:"max_attempts"`
+	InitialBackoff       time.Duration `mapstructure:"initial_backoff"`
+	MaxBackoff           time.Duration `mapstructure:"max_backoff"`
+	Timeout              time.Duration `mapstructure:"timeout"`
+	DisableAfter         int           `mapstructure:"disable_after"`
+	AllowPrivateNetworks bool          `mapstructure:"allow_private_networks"`
+}
+
+// DeadLetterConfig configura o dead-letter dos eventos que os webhooks e o
+// repasse do hub websocket não conseguiram entregar.
+type DeadLetterConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Stream  string `mapstructure:"stream"`
+	MaxLen  int64  `mapstructure:"max_len"`
+	// Retention é a idade a partir da qual as entradas são removidas.
+	Retention time.Duration `mapstructure:"retention"`
+	// Interval é o intervalo da expiração e da métrica de profundidade.
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+// NotificationsConfig configura o envio de notificações por Slack e e-mail.
+type NotificationsConfig struct {
+	Enabled              bool          `mapstructure:"enabled"`
+	Workers              int           `mapstructure:"workers"`
+	QueueSize            int           `mapstructure:"queue_size"`
+	MaxAttempts          int           `mapstructure:"max_attempts"`
+	InitialBackoff       time.Duration `mapstructure:"initial_backoff"`
+	MaxBackoff           time.Duration `mapstructure:"max_backoff"`
+	Timeout              time.Duration `mapstructure:"timeout"`
+	AllowPrivateNetworks bool          `mapstructure:"allow_private_networks"`
+	SMTP                 SMTPConfig    `mapstructure:"smtp"`
+}
+
+// OutboundConfig configura o cliente HTTP compartilhado pelas chamadas de
+// saída (webhooks, Slack, Vault).
+type OutboundConfig struct {
+	MaxIdleConns        int `mapstructure:"max_idle_conns"`
+	MaxIdleConnsPerHost int `mapstructure:"max_idle_conns_per_host"`
+	// MaxConnsPerHost limita as conexões abertas por destino; 0 não limita.
+	MaxConnsPerHost       int           `mapstructure:"max_conns_per_host"`
+	IdleConnTimeout       time.Duration `mapstructure:"idle_conn_timeout"`
+	DialTimeout           time.Duration `mapstructure:"dial_timeout"`
+	TLSHandshakeTimeout   time.Duration `mapstructure:"tls_handshake_timeout"`
+	ResponseHeaderTimeout time.Duration `mapstructure:"response_header_timeout"`
+	// ProxyURL encaminha as chamadas pelo proxy; vazio conecta direto. Com
+	// proxy, allow_private_networks dos webhooks e notificações vale para o
+	// endereço do proxy.
+	ProxyURL string                `mapstructure:"proxy_url"`
+	Retry    OutboundRetryConfig   `mapstructure:"retry"`
+	Breaker  OutboundBreakerConfig `mapstructure:"breaker"`
+}
+
+// OutboundRetryConfig configura as novas tentativas das chamadas
+// idempotentes (GET, PUT, DELETE ou com Idempotency-Key).
+type OutboundRetryConfig struct {
+	// MaxAttempts conta a primeira tentativa; 1 desliga as novas tentativas.
+	MaxAttempts    int           `mapstructure:"max_attempts"`
+	InitialBackoff time.Duration `mapstructure:"initial_backoff"`
+	MaxBackoff     time.Duration `mapstructure:"max_backoff"`
+}
+
+// OutboundBreakerConfig configura o circuit breaker por destino.
+type OutboundBreakerConfig struct {
+	// FailureThreshold é o número de falhas seguidas que abre o circuito; 0
+	// desliga o breaker.
+	FailureThreshold int           `mapstructure:"failure_threshold"`
+	Cooldown         time.Duration `mapstructure:"cooldown"`
+}
+
+// SMTPConfig configura o servidor de e-mail. Sem host, o canal de e-mail
+// fica indisponível.
+type SMTPConfig struct {
+	Host         string `mapstructure:"host"`
+	Port         int    `mapstructure:"port"`
+	Username     string `mapstructure:"username"`
+	Password     string `mapstructure:"password"`
+	PasswordFile string `mapstructure:"password_file"`
+	From         string `mapstructure:"from"`
+	// Security é starttls (porta 587), tls (465) ou none.
+	Security string `mapstructure:"security"`
+}
+
+// AlertsConfig configura a avaliação das regras de alerta.
+type AlertsConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Interval é o período entre avaliações; "for" e "resolve_for" das
+	// regras têm esta granularidade.
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+// SchedulesConfig configura o disparo das ações agendadas.
+type SchedulesConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Interval é o período entre varreduras; os disparos atrasam até isso.
+	Interval time.Duration `mapstructure:"interval"`
+	// Grace é o atraso máximo com que uma ocorrência perdida ainda é
+	// disparada; além dele ela é pulada.
+	Grace time.Duration `mapstructure:"grace"`
+}
+
+// DependenciesConfig configura as dependências entre agentes e a
+// propagação das falhas.
+type DependenciesConfig struct {
+	// FailureStatuses são os status de agente que prejudicam os dependentes.
+	FailureStatuses []string `mapstructure:"failure_statuses"`
+	// MaxPerAgent limita as dependências diretas de um agente.
+	MaxPerAgent int `mapstructure:"max_per_agent"`
+	// GraphDepth é a profundidade padrão do grafo de dependências; MaxDepth,
+	// a maior aceita e o alcance da propagação de uma falha.
+	GraphDepth int `mapstructure:"graph_depth"`
+	MaxDepth   int `mapstructure:"max_depth"`
+	// QueueSize limita os eventos de agentes à espera da propagação.
+	QueueSize int `mapstructure:"queue_size"`
+}
+
+// MessagesConfig configura as mensagens entre agentes de uma simulação.
+type MessagesConfig struct {
+	// TickInterval é a duração de um tick das simulações em execução: as
+	// mensagens enviadas num tick são entregues no seguinte.
+	TickInterval time.Duration `mapstructure:"tick_interval"`
+	// InboxSize limita as mensagens na caixa de um agente; as mais antigas
+	// saem primeiro. InboxTTL descarta caixas sem entregas novas.
+	InboxSize int           `mapstructure:"inbox_size"`
+	InboxTTL  time.Duration `mapstructure:"inbox_ttl"`
+	// MaxPending limita as mensagens de uma simulação à espera do próximo
+	// tick.
+	MaxPending int `mapstructure:"max_pending"`
+	// MaxPayloadBytes limita o payload de uma mensagem, em JSON.
+	MaxPayloadBytes int `mapstructure:"max_payload_bytes"`
+}
+
+// BehaviorsConfig configura os comportamentos dos agentes, chamados a cada
+// tick das simulações (messages.tick_interval).
+type BehaviorsConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// MaxActions limita as ações aplicadas por agente num tick.
+	MaxActions int `mapstructure:"max_actions"`
+	// Workers é o número de shards em que os agentes de uma simulação são
+	// processados em paralelo a cada tick.
+	Workers int `mapstructure:"workers"`
+	// DecideBudget limita a decisão de cada agente num tick; o agente que
+	// passa dele é pulado no tick.
+	DecideBudget time.Duration `mapstructure:"decide_budget"`
+	// Script limita cada execução do comportamento scripted.
+	Script ScriptConfig `mapstructure:"script"`
+}
+
+// ScriptConfig limita os scripts Lua do comportamento scripted, por agente
+// e por tick.
+type ScriptConfig struct {
+	MaxInstructions int           `mapstructure:"max_instructions"`
+	Timeout         time.Duration `mapstructure:"timeout"`
+	// MaxMemoryBytes limita o que o script aloca num tick.
+	MaxMemoryBytes int `mapstructure:"max_memory_bytes"`
+	MaxScriptBytes int `mapstructure:"max_script_bytes"`
+}
+
+// TrajectoriesConfig configura a gravação das posições dos agentes e as
+// consultas de trajetória.
+type TrajectoriesConfig struct {
+	// Enabled liga o gravador; as consultas respondem mesmo desligado.
+	Enabled bool `mapstructure:"enabled"`
+	// SampleInterval é o intervalo mínimo entre duas amostras do mesmo
+	// agente.
+	SampleInterval time.Duration `mapstructure:"sample_interval"`
+	BatchSize      int           `mapstructure:"batch_size"`
+	FlushInterval  time.Duration `mapstructure:"flush_interval"`
+	QueueSize      int           `mapstructure:"queue_size"`
+	// DefaultWindow é o intervalo consultado quando from não é informado.
+	DefaultWindow time.Duration `mapstructure:"default_window"`
+	MaxWindow     time.Duration `mapstructure:"max_window"`
+	// MaxPoints limita as amostras de uma consulta, antes da simplificação.
+	MaxPoints  int                        `mapstructure:"max_points"`
+	Retention  TrajectoryRetentionConfig  `mapstructure:"retention"`
+	Compaction TrajectoryCompactionConfig `mapstructure:"compaction"`
+}
+
+// TrajectoryRetentionConfig configura o descarte das partições diárias de
+// agent_positions, feito pela retenção de agent_actions
+// (actions.retention.interval e partitions_ahead valem para as duas).
+type TrajectoryRetentionConfig struct {
+	// Window é por quanto tempo as amostras são mantidas; 0 mantém tudo.
+	Window time.Duration `mapstructure:"window"`
+	// Archive grava cada partição no armazenamento de objetos
+	// (archives/agent_positions/) antes de descartá-la.
+	Archive bool `mapstructure:"archive"`
+}
+
+// TrajectoryCompactionConfig configura a compactação das amostras de
+// posição antigas: os dias mais antigos que Age saem de agent_positions e
+// vão, reamostrados, para agent_positions_compacted.
+type TrajectoryCompactionConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Interval é o intervalo entre os ciclos da compactação.
+	Interval time.Duration `mapstructure:"interval"`
+	Age      time.Duration `mapstructure:"age"`
+	// Method é interval (uma amostra a cada Resolution) ou rdp
+	// (Douglas-Peucker, com erro de até ToleranceM metros).
+	Method     string        `mapstructure:"method"`
+	Resolution time.Duration `mapstructure:"resolution"`
+	ToleranceM float64       `mapstructure:"tolerance_m"`
+	// ChunkAgents são os agentes compactados por transação.
+	ChunkAgents int `mapstructure:"chunk_agents"`
+	// Retention é por quanto tempo as amostras compactadas são mantidas;
+	// 0 mantém tudo.
+	Retention time.Duration `mapstructure:"retention"`
+}
+
+// PositionsConfig configura o streaming de posições por simulação em
+// GET /simulations/:id/positions/stream (snapshot, deltas e keyframes).
+type PositionsConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// DistanceThreshold é o deslocamento, em metros, e HeadingThreshold a
+	// mudança de rumo, em graus, a partir dos quais o agente entra num
+	// delta; trocas de status sempre entram.
+	DistanceThreshold float64 `mapstructure:"distance_threshold"`
+	HeadingThreshold  float64 `mapstructure:"heading_threshold"`
+	// FlushInterval é o intervalo entre deltas; KeyframeInterval, entre os
+	// keyframes que releem os agentes e ressincronizam os clientes.
+	FlushInterval    time.Duration `mapstructure:"flush_interval"`
+	KeyframeInterval time.Duration `mapstructure:"keyframe_interval"`
+	QueueSize        int           `mapstructure:"queue_size"`
+	// SendBuffer limita os quadros à espera de cada cliente; o cliente
+	// lento perde o quadro e recebe um snapshot.
+	SendBuffer int `mapstructure:"send_buffer"`
+}
+
+// HeatmapsConfig configura os mapas de calor de GET
+// /simulations/:id/heatmap.
+type HeatmapsConfig struct {
+	// MaxCells limita as células de uma grade; pedidos acima respondem 400.
+	MaxCells int `mapstructure:"max_cells"`
+	// DefaultCellSize é o lado da célula, em metros, sem cell_size.
+	DefaultCellSize float64 `mapstructure:"default_cell_size"`
+	// LiveTTL é a validade no cache das grades do estado atual;
+	// HistoryTTL, a das de um tick passado.
+	LiveTTL    time.Duration `mapstructure:"live_ttl"`
+	HistoryTTL time.Duration `mapstructure:"history_ttl"`
+}
+
+// TilesConfig configura os vector tiles de GET
+// /tiles/agents/:z/:x/:y.mvt.
+type TilesConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// MaxZoom é o maior zoom servido; CacheMaxZoom, o maior guardado no
+	// Redis.
+	MaxZoom      int `mapstructure:"max_zoom"`
+	CacheMaxZoom int `mapstructure:"cache_max_zoom"`
+	// ThinBelowZoom é o zoom abaixo do qual os agentes são agrupados
+	// (Thinning cluster, em células de ClusterCellPx pixels) ou amostrados
+	// (sample, até SampleLimit agentes).
+	ThinBelowZoom int    `mapstructure:"thin_below_zoom"`
+	Thinning      string `mapstructure:"thinning"`
+	ClusterCellPx int    `mapstructure:"cluster_cell_px"`
+	SampleLimit   int    `mapstructure:"sample_limit"`
+	// MaxFeatures limita os agentes lidos por tile.
+	MaxFeatures int `mapstructure:"max_features"`
+	// Attributes são os atributos dos agentes nos tiles: id, type, name,
+	// status, energy e simulation_id.
+	Attributes []string      `mapstructure:"attributes"`
+	CacheTTL   time.Duration `mapstructure:"cache_ttl"`
+	// FlushInterval é o intervalo em que os eventos de agentes invalidam os
+	// tiles; QueueSize limita os eventos à espera.
+	FlushInterval time.Duration `mapstructure:"flush_interval"`
+	QueueSize     int           `mapstructure:"queue_size"`
+}
+
+// HTTPCacheConfig configura o cache de respostas das rotas de leitura que
+// mudam pouco.
+type HTTPCacheConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// DefaultTTL é a validade das respostas no Redis, até 24h;
+	// DefaultMaxAge, o max-age do Cache-Control para os clientes.
+	DefaultTTL    time.Duration `mapstructure:"default_ttl"`
+	DefaultMaxAge time.Duration `mapstructure:"default_max_age"`
+	// MaxBodyBytes limita o corpo guardado; respostas maiores não entram.
+	MaxBodyBytes int `mapstructure:"max_body_bytes"`
+}
+
+// FailuresConfig configura os relatórios de falha das simulações, de GET
+// /simulations/:id/failure.
+type FailuresConfig struct {
+	// RecentEvents são os últimos eventos de cada simulação no relatório; 0
+	// não guarda nenhum. Dados de um evento acima de MaxEventBytes ficam de
+	// fora.
+	RecentEvents  int `mapstructure:"recent_events"`
+	MaxEventBytes int `mapstructure:"max_event_bytes"`
+	// MaxStackBytes corta a pilha dos pânicos.
+	MaxStackBytes int `mapstructure:"max_stack_bytes"`
+	// MaxReportBytes é o maior relatório gravado no PostgreSQL; os maiores
+	// vão para o armazenamento de objetos, com links válidos por
+	// LinkExpiry.
+	MaxReportBytes int           `mapstructure:"max_report_bytes"`
+	LinkExpiry     time.Duration `mapstructure:"link_expiry"`
+}
+
+// QuotasConfig configura o uso de armazenamento por projeto e as cotas. Com
+// uma cota atingida, criar simulações (linhas de eventos, checkpoints e
+// arquivos) ou agentes responde 403; as leituras continuam.
+type QuotasConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Defaults são os limites dos projetos sem cota própria; 0 é sem
+	// limite.
+	Defaults QuotaLimitsConfig `mapstructure:"defaults"`
+	// FlushInterval grava os acréscimos de uso contados nos eventos;
+	// QueueSize limita os eventos à espera.
+	FlushInterval time.Duration `mapstructure:"flush_interval"`
+	QueueSize     int           `mapstructure:"queue_size"`
+	// ReconcileInterval é a janela entre duas reconciliações do uso a
+	// partir das tabelas, feitas por uma réplica só; 0 desliga.
+	ReconcileInterval time.Duration `mapstructure:"reconcile_interval"`
+}
+
+// BackupsConfig configura o backup e a restauração dos projetos. Os
+// arquivos vão para o armazenamento de objetos (storage.*).
+type BackupsConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+}
+
+// SyncHooksConfig configura os hooks síncronos dos projetos, consultados
+// antes de criar ou remover agentes.
+type SyncHooksConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// DefaultTimeout vale para os hooks sem timeout próprio; MaxTimeout
+	// limita o de qualquer hook e, com as chamadas em paralelo, a espera
+	// de cada operação.
+	DefaultTimeout       time.Duration `mapstructure:"default_timeout"`
+	MaxTimeout           time.Duration `mapstructure:"max_timeout"`
+	AllowPrivateNetworks bool          `mapstructure:"allow_private_networks"`
+}
+
+// MaintenanceConfig configura o modo de manutenção, guardado no Redis e
+// lido por todas as réplicas.
+type MaintenanceConfig struct {
+	// RefreshInterval é o intervalo entre as leituras do modo no Redis.
+	RefreshInterval time.Duration `mapstructure:"refresh_interval"`
+	// RetryAfter é o Retry-After das requisições bloqueadas quando a
+	// mudança de modo não informa um.
+	RetryAfter time.Duration `mapstructure:"retry_after"`
+}
+
+// I18nConfig configura o idioma das mensagens de erro da API.
+type I18nConfig struct {
+	// DefaultLocale (en ou pt-BR) vale quando o Accept-Language não traz
+	// um idioma suportado.
+	DefaultLocale string `mapstructure:"default_locale"`
+}
+
+// OIDCConfig configura o login por OpenID Connect em /auth e a aceitação
+// dos JWTs do provedor e do serviço.
+type OIDCConfig struct {
+	Enabled      bool   `mapstructure:"enabled"`
+	IssuerURL    string `mapstructure:"issuer_url"`
+	ClientID     string `mapstructure:"client_id"`
+	ClientSecret string `mapstructure:"client_secret"`
+	// RedirectURL é a URL pública de /auth/callback registrada no provedor.
+	RedirectURL string   `mapstructure:"redirect_url"`
+	Scopes      []string `mapstructure:"scopes"`
+	// GroupsClaim e ProjectsClaim aceitam caminhos com ponto
+	// (realm_access.roles); ProjectsClaim vazio não restringe projetos e,
+	// configurado, recusa tokens sem projetos que não sejam de admin.
+	SubjectClaim  string `mapstructure:"subject_claim"`
+	GroupsClaim   string `mapstructure:"groups_claim"`
+	ProjectsClaim string `mapstructure:"projects_claim"`
+	// RoleMappings é uma lista (e não um mapa) porque o viper converte as
+	// chaves de mapas para minúsculas, e nomes de grupos diferenciam caixa.
+	RoleMappings []OIDCRoleMapping `mapstructure:"role_mappings"`
+	DefaultRoles []string          `mapstructure:"default_roles"`
+	// FrontendURL recebe o redirecionamento do callback com os tokens no
+	// fragmento; vazio, o callback responde JSON.
+	FrontendURL string            `mapstructure:"frontend_url"`
+	Session     OIDCSessionConfig `mapstructure:"session"`
+}
+
+// OIDCRoleMapping associa um grupo do provedor a um papel do serviço.
+type OIDCRoleMapping struct {
+	Group string `mapstructure:"group"`
+	Role  string `mapstructure:"role"`
+}
+
+// OIDCSessionConfig configura os JWTs emitidos pelo serviço após o login.
+type OIDCSessionConfig struct {
+	// SigningKey assina os JWTs (HS256) e deve ser igual em todas as
+	// réplicas.
+	SigningKey string        `mapstructure:"signing_key"`
+	TTL        time.Duration `mapstructure:"ttl"`
+	RefreshTTL time.Duration `mapstructure:"refresh_ttl"`
+}
+
+// SimMetricsConfig configura as séries por simulação em /metrics e o envio
+// opcional por remote write.
+type SimMetricsConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// MaxSimulations limita as simulações com séries próprias; as demais
+	// são somadas em simulation="_other".
+	MaxSimulations  int           `mapstructure:"max_simulations"`
+	RefreshInterval time.Duration `mapstructure:"refresh_interval"`
+	// ActiveStatuses são os status de agente contados como ativos.
+	ActiveStatuses []string `mapstructure:"active_statuses"`
+	// AgentMetrics são as métricas de agentes somadas por simulação; cada
+	// nome é um valor do rótulo metric.
+	AgentMetrics []string                    `mapstructure:"agent_metrics"`
+	RemoteWrite  SimMetricsRemoteWriteConfig `mapstructure:"remote_write"`
+}
+
+// SimMetricsRemoteWriteConfig configura o envio das séries de simulação por
+// Prometheus remote write.
+type SimMetricsRemoteWriteConfig struct {
+	Enabled     bool          `mapstructure:"enabled"`
+	URL         string        `mapstructure:"url"`
+	Interval    time.Duration `mapstructure:"interval"`
+	Timeout     time.Duration `mapstructure:"timeout"`
+	Username    string        `mapstructure:"username"`
+	Password    string        `mapstructure:"password"`
+	BearerToken string        `mapstructure:"bearer_token"`
+	// Labels vão em todas as séries enviadas, no formato nome=valor.
+	Labels               []string `mapstructure:"labels"`
+	AllowPrivateNetworks bool     `mapstructure:"allow_private_networks"`
+}
+
+// KPIsConfig configura a avaliação dos KPIs das simulações.
+type KPIsConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Interval é o intervalo da avaliação e da releitura das definições.
+	Interval time.Duration `mapstructure:"interval"`
+	// HistoryPoints é o tamanho do histórico de cada KPI.
+	HistoryPoints int `mapstructure:"history_points"`
+	// Retention é por quanto tempo os agregados e o histórico de uma
+	// simulação parada ficam no Redis.
+	Retention time.Duration `mapstructure:"retention"`
+	// BufferSize limita as amostras à espera de serem somadas; as que
+	// excedem são descartadas.
+	BufferSize int `mapstructure:"buffer_size"`
+}
+
+// ChangeFeedConfig configura o feed de mudanças dos agentes, lido por
+// GET /agents/changes.
+type ChangeFeedConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Stream  string `mapstructure:"stream"`
+	// Retention é a janela em que um cursor continua valendo; cursores mais
+	// antigos recebem 410 e exigem nova carga completa.
+	Retention    time.Duration `mapstructure:"retention"`
+	DefaultLimit int           `mapstructure:"default_limit"`
+	MaxLimit     int           `mapstructure:"max_limit"`
+}
+
+// QueryBudgetConfig configura o orçamento das listagens caras (GET /agents
+// e trajetórias): acima dele a consulta é recusada com 422 antes de
+// executar, salvo X-Query-Budget-Override de um administrador.
+type QueryBudgetConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// MaxRows limita as linhas lidas, pela estimativa do planejador.
+	MaxRows int64 `mapstructure:"max_rows"`
+	// MaxOffset limita as linhas puladas na paginação por página.
+	MaxOffset int64 `mapstructure:"max_offset"`
+}
+
+// IngestionConfig configura o limite de reportes de telemetria por agente
+// (PUT /agents/:id, métricas e gêmeo digital). O limite de cada agente vem
+// de agent_types.definitions[].ingestion.limit, ou de DefaultLimit, e pode
+// ser trocado em PUT /agents/:id/ingestion-limit.
+type IngestionConfig struct {
+	Enabled bool          `mapstructure:"enabled"`
+	Window  time.Duration `mapstructure:"window"`
+	// DefaultLimit são os reportes por janela dos tipos sem limite próprio;
+	// 0 não limita.
+	DefaultLimit int `mapstructure:"default_limit"`
+	// SampleOneIn é o N da amostragem dos tipos lossy_tolerant: acima do
+	// limite, passa 1 reporte a cada N.
+	SampleOneIn int `mapstructure:"sample_one_in"`
+	// HealthPenalty são os pontos tirados da nota de saúde até o fim da
+	// janela em que o agente passou do limite.
+	HealthPenalty float64 `mapstructure:"health_penalty"`
+}
+
+// SeedConfig configura os dados de demonstração do subcomando seed e de
+// POST /api/v1/admin/seed. Enabled só libera a rota; o subcomando roda
+// sempre.
+type SeedConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// AgentsPerType são os agentes gerados de cada tipo do registro.
+	AgentsPerType int    `mapstructure:"agents_per_type"`
+	ProjectID     string `mapstructure:"project_id"`
+	// History é a duração da simulação concluída, com o histórico de
+	// eventos e métricas.
+	History time.Duration `mapstructure:"history"`
+	// BBox é a área em que os agentes são posicionados.
+	BBox SeedBBoxConfig `mapstructure:"bbox"`
+}
+
+// SeedBBoxConfig é um retângulo em graus.
+type SeedBBoxConfig struct {
+	MinLat float64 `mapstructure:"min_lat"`
+	MinLon float64 `mapstructure:"min_lon"`
+	MaxLat float64 `mapstructure:"max_lat"`
+	MaxLon float64 `mapstructure:"max_lon"`
+}
+
+// FaultsConfig configura a injeção de falhas nas simulações
+// (POST /api/v1/simulations/:id/faults) e os limites dos parâmetros.
+type FaultsConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// RefreshInterval é o intervalo em que cada réplica relê as falhas
+	// ativas; as criadas em outra réplica passam a valer nela depois disso.
+	RefreshInterval time.Duration `mapstructure:"refresh_interval"`
+	MaxDuration     time.Duration `mapstructure:"max_duration"`
+	MaxLatency      time.Duration `mapstructure:"max_latency"`
+	MaxAgents       int           `mapstructure:"max_agents"`
+	MaxJitterM      float64       `mapstructure:"max_jitter_m"`
+}
+
+// ExportsConfig configura a exportação de simulações
+// (POST /api/v1/simulations/:id/export). O link de download vale
+// storage.presign_expiry.
+type ExportsConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// QuiesceTimeout é a espera máxima pela pausa dos ticks da simulação.
+	QuiesceTimeout time.Duration `mapstructure:"quiesce_timeout"`
+	// Timeout limita uma exportação inteira, da pausa ao zip gravado.
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+// DeletionsConfig configura a exclusão das simulações em segundo plano
+// (DELETE /api/v1/simulations/:id, internal/deletion).
+type DeletionsConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Interval é o intervalo em que os jobs pendentes são procurados.
+	Interval time.Duration `mapstructure:"interval"`
+	// BatchSize limita as linhas apagadas por instrução.
+	BatchSize int `mapstructure:"batch_size"`
+	// Pause é a espera entre dois lotes, para limitar o atraso das réplicas
+	// do banco.
+	Pause time.Duration `mapstructure:"pause"`
+}
+
+// FeatureFlagsConfig configura os feature flags das rotas
+// (internal/featureflag). Defaults é o valor de cada flag neste ambiente
+// quando não há valor gravado em /admin/flags.
+type FeatureFlagsConfig struct {
+	Channel         string          `mapstructure:"channel"`
+	RefreshInterval time.Duration   `mapstructure:"refresh_interval"`
+	Defaults        map[string]bool `mapstructure:"defaults"`
+}
+
+// ProjectLimitsConfig configura os limites das requisições de cada projeto
+// (internal/projectsettings). Defaults valem para os projetos sem valores
+// gravados em PUT /projects/:id/settings; 0 é sem limite.
+type ProjectLimitsConfig struct {
+	Channel         string                      `mapstructure:"channel"`
+	RefreshInterval time.Duration               `mapstructure:"refresh_interval"`
+	Defaults        ProjectLimitsDefaultsConfig `mapstructure:"defaults"`
+}
+
+// ProjectLimitsDefaultsConfig são os limites padrão de um projeto.
+// AllowedOrigins vazio aceita as origens de server.cors.
+type ProjectLimitsDefaultsConfig struct {
+	RequestsPerMinute int           `mapstructure:"requests_per_minute"`
+	RequestTimeout    time.Duration `mapstructure:"request_timeout"`
+	ExportMaxBytes    int64         `mapstructure:"export_max_bytes"`
+	AllowedOrigins    []string      `mapstructure:"allowed_origins"`
+}
+
+// QuotaLimitsConfig são os limites por recurso.
+type QuotaLimitsConfig struct {
+	Agents          int64 `mapstructure:"agents"`
+	EventRows       int64 `mapstructure:"event_rows"`
+	CheckpointBytes int64 `mapstructure:"checkpoint_bytes"`
+	ArchiveBytes    int64 `mapstructure:"archive_bytes"`
+}
+
+// AgentsConfig configura a API de agentes.
+type AgentsConfig struct {
+	// BatchGetMax limita os ids de GET /agents?ids= e POST /agents/batch-get.
+	BatchGetMax int `mapstructure:"batch_get_max"`
+}
+
+// GroupsConfig configura os grupos de agentes. As ações em grupo passam
+// pelos lotes de ações e respeitam action_batches.max_agents.
+type GroupsConfig struct {
+	MaxMembers int `mapstructure:"max_members"`
+	// StartStatus e StopStatus são os status dados aos membros por
+	// POST /groups/:id/start e /stop.
+	StartStatus string `mapstructure:"start_status"`
+	StopStatus  string `mapstructure:"stop_status"`
+}
+
+// PresenceConfig configura a detecção de agentes offline: a cada Interval,
+// uma réplica eleita passa a OfflineStatus os agentes sem telemetria há mais
+// que o offline_after do tipo (agent_types.definitions), no máximo
+// MaxPerCycle por ciclo. O próximo reporte devolve o status anterior.
+type PresenceConfig struct {
+	Enabled       bool          `mapstructure:"enabled"`
+	Interval      time.Duration `mapstructure:"interval"`
+	OfflineStatus string        `mapstructure:"offline_status"`
+	MaxPerCycle   int           `mapstructure:"max_per_cycle"`
+}
+
+// TwinsConfig configura os gêmeos digitais dos agentes (PUT
+// /agents/:id/twin e PATCH /agents/:id/twin/desired).
+type TwinsConfig struct {
+	// MaxDocumentBytes limita o documento reported ou desired, em JSON.
+	MaxDocumentBytes int `mapstructure:"max_document_bytes"`
+	// MaxDepth limita o aninhamento de objetos do documento.
+	MaxDepth int `mapstructure:"max_depth"`
+}
+
+// RevisionsConfig configura o histórico da configuração dos agentes (GET
+// /agents/:id/revisions).
+type RevisionsConfig struct {
+	// MaxPerAgent é quantas revisões cada agente guarda; as mais antigas
+	// são removidas.
+	MaxPerAgent int `mapstructure:"max_per_agent"`
+}
+
+// ResetsConfig configura o reinício de agentes de uma simulação em
+// execução (POST /api/v1/simulations/:id/agents/reset).
+type ResetsConfig struct {
+	// MaxAgents limita os agentes reiniciados por pedido.
+	MaxAgents int `mapstructure:"max_agents"`
+	// QuiesceTimeout é a espera máxima pela pausa dos ticks da simulação.
+	QuiesceTimeout time.Duration `mapstructure:"quiesce_timeout"`
+}
+
+// AnomaliesConfig configura a detecção de anomalias nas métricas dos
+// agentes, ligada por métrica em agent_types.definitions[].metrics[].anomaly
+// ou no registro de tipos.
+type AnomaliesConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// HealthPenalty são os pontos tirados da nota de saúde por métrica
+	// anômala do agente.
+	HealthPenalty float64 `mapstructure:"health_penalty"`
+}
+
+// CoalescingConfig configura a gravação em lotes da telemetria dos agentes
+// (ponte MQTT e PUT /agents/:id): cada atualização vai para o estado ao vivo
+// no Redis (GET /agents/:id/live) e as pendências são gravadas a cada
+// FlushInterval, ou na hora se o status muda ou o agente se afasta mais de
+// PositionThresholdM metros da posição gravada.
+type CoalescingConfig struct {
+	Enabled            bool          `mapstructure:"enabled"`
+	FlushInterval      time.Duration `mapstructure:"flush_interval"`
+	PositionThresholdM float64       `mapstructure:"position_threshold_m"`
+	// LiveTTL é por quanto tempo o estado ao vivo fica no Redis depois de
+	// gravado.
+	LiveTTL time.Duration `mapstructure:"live_ttl"`
+}
+
+// RollupsConfig configura os agregados do painel (GET
+// /simulations/:id/stats/*), lidos de views materializadas atualizadas a
+// cada RefreshInterval por uma réplica, ou sob demanda em POST
+// /admin/refresh-views.
+type RollupsConfig struct {
+	Enabled         bool          `mapstructure:"enabled"`
+	RefreshInterval time.Duration `mapstructure:"refresh_interval"`
+	// MaxStaleness é a idade máxima de uma view; acima dela, ou sem
+	// atualização registrada, os agregados são calculados na hora.
+	MaxStaleness time.Duration `mapstructure:"max_staleness"`
+}
+
+// SupervisorConfig configura o reinício dos componentes de fundo que falham
+// (GET /admin/components).
+type SupervisorConfig struct {
+	// InitialBackoff é a espera antes do primeiro reinício; dobra a cada
+	// falha seguida até MaxBackoff.
+	InitialBackoff time.Duration `mapstructure:"initial_backoff"`
+	MaxBackoff     time.Duration `mapstructure:"max_backoff"`
+}
+
+// TransfersConfig configura a transferência de agentes entre simulações
+// (POST /agents/:id/transfer).
+type TransfersConfig struct {
+	// RunningStatuses são os status em que o agente precisa ser pausado
+	// antes da transferência; PauseStatus é o status dado com force.
+	RunningStatuses []string `mapstructure:"running_statuses"`
+	PauseStatus     string   `mapstructure:"pause_status"`
+	// DefaultMaxAgents limita os agentes da simulação de destino que não
+	// declara config.max_agents; 0 não limita.
+	DefaultMaxAgents int `mapstructure:"default_max_agents"`
+	// ProjectExpiry é o prazo para a aprovação de uma transferência entre
+	// projetos (POST /agents/:id/transfer-project); depois dele o pedido
+	// vence.
+	ProjectExpiry time.Duration `mapstructure:"project_expiry"`
+}
+
+// ProximityConfig configura a detecção de proximidade entre os agentes de
+// uma simulação, feita a cada tick (messages.tick_interval). Os limiares são
+// os proximity_threshold de agent_types.definitions.
+type ProximityConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// MaxEventsPerTick limita os eventos agent.proximity de uma simulação
+	// num tick; os excedentes são descartados e contados.
+	MaxEventsPerTick int `mapstructure:"max_events_per_tick"`
+}
+
+// ConsumptionConfig configura a contabilidade do consumo de energia dos
+// agentes, acumulado a cada tick (messages.tick_interval) com os modelos de
+// agent_types.definitions. Os contadores ficam no Redis e são gravados no
+// PostgreSQL a cada FlushInterval, em faixas de Bucket.
+type ConsumptionConfig struct {
+	Enabled       bool          `mapstructure:"enabled"`
+	Bucket        time.Duration `mapstructure:"bucket"`
+	FlushInterval time.Duration `mapstructure:"flush_interval"`
+	// InactiveStatuses são os status em que o agente não consome.
+	InactiveStatuses []string `mapstructure:"inactive_statuses"`
+	// DefaultBucket é a faixa de GET /simulations/:id/consumption sem
+	// bucket; MaxBuckets limita as faixas de uma consulta.
+	DefaultBucket time.Duration `mapstructure:"default_bucket"`
+	MaxBuckets    int           `mapstructure:"max_buckets"`
+}
+
+// ActionBatchesConfig configura a execução de ações em lote.
+type ActionBatchesConfig struct {
+	// Workers limita as ações em lote executando ao mesmo tempo nesta
+	// instância, somando todos os lotes.
+	Workers   int           `mapstructure:"workers"`
+	MaxAgents int           `mapstructure:"max_agents"`
+	Timeout   time.Duration `mapstructure:"timeout"`
+}
+
+// ActionsConfig configura o registro de ações e a execução em segundo
+// plano das ações demoradas.
+type ActionsConfig struct {
+	Workers int `mapstructure:"workers"`
+	// QueueSize limita as ações demoradas na fila, somando as réplicas.
+	QueueSize int `mapstructure:"queue_size"`
+	// PriorityAging é a espera que vale um nível de prioridade na fila:
+	// uma ação low que esperou isso a mais passa à frente de uma normal.
+	// critical fica sempre à frente; 0 ordena estritamente por prioridade.
+	PriorityAging time.Duration `mapstructure:"priority_aging"`
+	// DefaultTimeout vale para ações demoradas sem timeout próprio.
+	DefaultTimeout time.Duration `mapstructure:"default_timeout"`
+	// CancelPollInterval é o intervalo com que uma execução em andamento
+	// confere se foi cancelada por outra réplica.
+	CancelPollInterval time.Duration `mapstructure:"cancel_poll_interval"`
+	// AllowUnregistered aceita ações sem definição, sem checagem, como
+	// antes do registro; serve à migração de clientes.
+	AllowUnregistered bool                  `mapstructure:"allow_unregistered"`
+	Definitions       []ActionDefinition    `mapstructure:"definitions"`
+	Retention         ActionRetentionConfig `mapstructure:"retention"`
+	History           ActionHistoryConfig   `mapstructure:"history"`
+	Recovery          ActionRecoveryConfig  `mapstructure:"recovery"`
+}
+
+// ActionRecoveryConfig configura a recuperação das ações demoradas
+// interrompidas por um reinício.
+type ActionRecoveryConfig struct {
+	// HeartbeatInterval é o intervalo do batimento de cada execução em
+	// andamento.
+	HeartbeatInterval time.Duration `mapstructure:"heartbeat_interval"`
+	// StaleAfter é o tempo sem batimento a partir do qual a execução é
+	// dada como órfã e recuperada por outra réplica.
+	StaleAfter time.Duration `mapstructure:"stale_after"`
+	// Interval é o intervalo da procura por execuções órfãs.
+	Interval time.Duration `mapstructure:"interval"`
+	// MaxResumes limita as retomadas de uma mesma execução.
+	MaxResumes int `mapstructure:"max_resumes"`
+}
+
+// ActionRetentionConfig configura o descarte das partições diárias de
+// agent_actions.
+type ActionRetentionConfig struct {
+	// Window é por quanto tempo o histórico é mantido; 0 mantém tudo.
+	Window   time.Duration `mapstructure:"window"`
+	Interval time.Duration `mapstructure:"interval"`
+	// PartitionsAhead é quantos dias de partições criar à frente de hoje.
+	PartitionsAhead int `mapstructure:"partitions_ahead"`
+	// Archive grava cada partição no armazenamento de objetos
+	// (archives/agent_actions/) antes de descartá-la.
+	Archive bool `mapstructure:"archive"`
+}
+
+// ActionHistoryConfig limita o intervalo de GET /api/v1/actions.
+type ActionHistoryConfig struct {
+	// DefaultWindow é o intervalo consultado quando from não é informado.
+	DefaultWindow time.Duration `mapstructure:"default_window"`
+	MaxWindow     time.Duration `mapstructure:"max_window"`
+}
+
+// ActionDefinition declara uma ação do registro.
+type ActionDefinition struct {
+	Name        string `mapstructure:"name"`
+	Description string `mapstructure:"description"`
+	// AgentTypes lista os tipos de agente que aceitam a ação; vazio aceita
+	// todos.
+	AgentTypes []string `mapstructure:"agent_types"`
+	// Capabilities são as capacidades que o agente precisa ter para aceitar
+	// a ação, além do tipo.
+	Capabilities []string `mapstructure:"capabilities"`
+	// Schema é o JSON Schema de params como texto JSON: o viper passaria
+	// as chaves de um mapa YAML para minúsculas (additionalProperties).
+	Schema      string            `mapstructure:"schema"`
+	LongRunning bool              `mapstructure:"long_running"`
+	Timeout     time.Duration     `mapstructure:"timeout"`
+	Retry       ActionRetryPolicy `mapstructure:"retry"`
+	// Roles e Scopes restringem quem executa a ação: um dos papéis ou um
+	// dos escopos de chave de API. Vazios liberam a todos.
+	Roles  []string `mapstructure:"roles"`
+	Scopes []string `mapstructure:"scopes"`
+}
+
+// ActionRetryPolicy define as novas tentativas após timeout ou erro interno.
+type ActionRetryPolicy struct {
+	// MaxAttempts inclui a primeira tentativa; 0 ou 1 não repete.
+	MaxAttempts int           `mapstructure:"max_attempts"`
+	Backoff     time.Duration `mapstructure:"backoff"`
+}
+
+// AgentTypesConfig declara as métricas de desempenho de cada tipo de
+// agente.
+type AgentTypesConfig struct {
+	// MaxSamples limita as amostras de um POST /agents/:id/metrics.
+	MaxSamples  int                   `mapstructure:"max_samples"`
+	Definitions []AgentTypeDefinition `mapstructure:"definitions"`
+}
+
+// AgentTypeDefinition declara as métricas de um tipo de agente. Tipos sem
+// definição mantêm o desempenho fixo e não aceitam amostras.
+type AgentTypeDefinition struct {
+	Name string `mapstructure:"name"`
+	// AllowAdHocMetrics aceita amostras de métricas não declaradas.
+	AllowAdHocMetrics bool                    `mapstructure:"allow_ad_hoc_metrics"`
+	Metrics           []AgentMetricDefinition `mapstructure:"metrics"`
+	// Health calcula a nota de saúde; sem componentes, o tipo não tem nota.
+	Health AgentHealthConfig `mapstructure:"health"`
+	// ProximityThreshold é a distância, em metros, abaixo da qual um agente
+	// do tipo fica próximo de outro; 0 deixa o tipo fora da detecção.
+	ProximityThreshold float64 `mapstructure:"proximity_threshold"`
+	// OfflineAfter é o silêncio depois do qual um agente do tipo que já
+	// reportou telemetria passa a offline; 0 deixa o tipo fora da detecção.
+	OfflineAfter time.Duration `mapstructure:"offline_after"`
+	// Consumption é o modelo de consumo de energia do tipo.
+	Consumption AgentConsumptionConfig `mapstructure:"consumption"`
+	// Capabilities são as capacidades que os agentes do tipo podem ter.
+	Capabilities AgentCapabilitiesConfig `mapstructure:"capabilities"`
+	// Ingestion é o limite de reportes de telemetria do tipo.
+	Ingestion AgentIngestionConfig `mapstructure:"ingestion"`
+}
+
+// AgentIngestionConfig é o limite de reportes de um tipo de agente. Limit
+// são os reportes por janela de ingestion_limits.window; 0 usa
+// ingestion_limits.default_limit. Com LossyTolerant, os reportes acima do
+// limite são amostrados em vez de recusados com 429.
+type AgentIngestionConfig struct {
+	Limit         int  `mapstructure:"limit"`
+	LossyTolerant bool `mapstructure:"lossy_tolerant"`
+}
+
+// AgentCapabilitiesConfig declara as capacidades de um tipo de agente, ex.:
+// air_quality para sensores com firmware novo. Allowed são as que um agente
+// do tipo pode declarar; Default, as de quem nunca declarou nenhuma, o que
+// serve para ligar uma capacidade em todos os agentes do tipo de uma vez.
+type AgentCapabilitiesConfig struct {
+	Allowed []string `mapstructure:"allowed"`
+	Default []string `mapstructure:"default"`
+}
+
+// AgentConsumptionConfig é o modelo de consumo de um tipo de agente: a cada
+// tick, WhPerKm pela distância percorrida mais BaselineW pela duração do
+// tick. MeterMetric, se informada, é uma métrica declarada do tipo com a
+// leitura acumulada do medidor, em Wh, que corrige o valor modelado.
+type AgentConsumptionConfig struct {
+	WhPerKm     float64 `mapstructure:"wh_per_km"`
+	BaselineW   float64 `mapstructure:"baseline_w"`
+	MeterMetric string  `mapstructure:"meter_metric"`
+}
+
+// AgentHealthConfig é a nota de saúde de um tipo de agente: a média
+// ponderada dos componentes, de 0 a 100. Abaixo de Degraded o agente fica
+// degraded e abaixo de Critical, critical; para voltar, a nota precisa
+// superar o limiar em Hysteresis pontos.
+type AgentHealthConfig struct {
+	Degraded   float64                `mapstructure:"degraded"`
+	Critical   float64                `mapstructure:"critical"`
+	Hysteresis float64                `mapstructure:"hysteresis"`
+	Components []AgentHealthComponent `mapstructure:"components"`
+}
+
+// AgentHealthComponent é uma métrica declarada do tipo na nota de saúde. O
+// último valor vale 0 em Bad e 100 em Good, com interpolação linear entre
+// os dois.
+type AgentHealthComponent struct {
+	Metric string  `mapstructure:"metric"`
+	Weight float64 `mapstructure:"weight"`
+	Good   float64 `mapstructure:"good"`
+	Bad    float64 `mapstructure:"bad"`
+}
+
+// AgentMetricDefinition declara uma métrica de um tipo de agente.
+type AgentMetricDefinition struct {
+	Name        string `mapstructure:"name"`
+	Description string `mapstructure:"description"`
+	Unit        string `mapstructure:"unit"`
+	// Aggregation é avg, sum, min, max ou last.
+	Aggregation string `mapstructure:"aggregation"`
+	// Anomaly liga a detecção de anomalias da métrica; sem ela, a métrica
+	// fica fora da detecção.
+	Anomaly *AgentMetricAnomalyConfig `mapstructure:"anomaly"`
+}
+
+// AgentMetricAnomalyConfig é a sensibilidade da detecção de anomalias de
+// uma métrica: uma amostra a mais de K desvios-padrão da linha de base é
+// anômala, e Window amostras anômalas seguidas tornam a métrica anômala. A
+// detecção só começa depois de MinSamples amostras. Baseline é o número
+// aproximado de amostras recentes que formam a linha de base; 0 usa 100.
+// Como o resto do tipo, só entra no registro de tipos quando ele é criado
+// a partir da configuração; depois, muda por PUT /api/v1/agent-types/:type.
+type AgentMetricAnomalyConfig struct {
+	K          float64 `mapstructure:"k"`
+	Window     int     `mapstructure:"window"`
+	MinSamples int     `mapstructure:"min_samples"`
+	Baseline   int     `mapstructure:"baseline"`
+}
+
+// MQTTConfig configura a ponte MQTT dos sensores de campo.
+type MQTTConfig struct {
+	Enabled          bool            `mapstructure:"enabled"`
+	Brokers          []string        `mapstructure:"brokers"`
+	ClientID         string          `mapstructure:"client_id"`
+	Username         string          `mapstructure:"username"`
+	Password         string          `mapstructure:"password"`
+	PasswordFile     string          `mapstructure:"password_file"`
+	QoS              int             `mapstructure:"qos"`
+	TelemetryTopic   string          `mapstructure:"telemetry_topic"`
+	SharedGroup      string          `mapstructure:"shared_group"`
+	CommandTopic     string          `mapstructure:"command_topic"`
+	SensorAgentTypes []string        `mapstructure:"sensor_agent_types"`
+	Workers          int             `mapstructure:"workers"`
+	CleanSession     bool            `mapstructure:"clean_session"`
+	KeepAlive        time.Duration   `mapstructure:"keep_alive"`
+	ConnectTimeout   time.Duration   `mapstructure:"connect_timeout"`
+	Reconnect        MQTTReconnect   `mapstructure:"reconnect"`
+	DeadLetter       MQTTDeadLetter  `mapstructure:"dead_letter"`
+	TLS              ClientTLSConfig `mapstructure:"tls"`
+}
+
+// MQTTReconnect controla a reconexão automática ao broker.
+type MQTTReconnect struct {
+	Enabled       bool          `mapstructure:"enabled"`
+	RetryInterval time.Duration `mapstructure:"retry_interval"`
+	MaxInterval   time.Duration `mapstructure:"max_interval"`
+}
+
+// MQTTDeadLetter configura o stream Redis de mensagens rejeitadas.
+type MQTTDeadLetter struct {
+	Stream string `mapstructure:"stream"`
+	MaxLen int64  `mapstructure:"max_len"`
+}
+
+// EventExportConfig configura a exportação dos eventos para Kafka ou NATS
+// JetStream, alimentada pelo outbox em stream Redis.
+type EventExportConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Sink    string `mapstructure:"sink"`
+	// Topics são os tópicos do barramento exportados.
+	Topics []string `mapstructure:"topics"`
+	// SubjectTemplate nomeia o tópico Kafka ou subject NATS de cada evento;
+	// aceita {topic} e {type}. Routes sobrepõe o nome por tipo de evento.
+	SubjectTemplate string       `mapstructure:"subject_template"`
+	Routes          []EventRoute `mapstructure:"routes"`
+	// Versions fixa versões de payload exportadas, como em
+	// events.websocket_versions.
+	Versions     []string        `mapstructure:"versions"`
+	BatchSize    int             `mapstructure:"batch_size"`
+	BatchTimeout time.Duration   `mapstructure:"batch_timeout"`
+	RetryBackoff time.Duration   `mapstructure:"retry_backoff"`
+	MaxBackoff   time.Duration   `mapstructure:"max_backoff"`
+	Outbox       EventOutbox     `mapstructure:"outbox"`
+	Kafka        KafkaSinkConfig `mapstructure:"kafka"`
+	NATS         NATSSinkConfig  `mapstructure:"nats"`
+}
+
+// EventRoute define o destino dos eventos de um tipo ("agent.created") ou
+// prefixo ("simulation.*").
+type EventRoute struct {
+	EventType string `mapstructure:"event_type"`
+	Subject   string `mapstructure:"subject"`
+}
+
+// EventOutbox configura o stream Redis que guarda os eventos até a exportação.
+type EventOutbox struct {
+	Stream     string        `mapstructure:"stream"`
+	MaxLen     int64         `mapstructure:"max_len"`
+	Group      string        `mapstructure:"group"`
+	BufferSize int           `mapstructure:"buffer_size"`
+	ClaimIdle  time.Duration `mapstructure:"claim_idle"`
+}
+
+// KafkaSinkConfig configura o sink Kafka.
+type KafkaSinkConfig struct {
+	Brokers                []string        `mapstructure:"brokers"`
+	RequiredAcks           string          `mapstructure:"required_acks"`
+	Compression            string          `mapstructure:"compression"`
+	WriteTimeout           time.Duration   `mapstructure:"write_timeout"`
+	AllowAutoTopicCreation bool            `mapstructure:"allow_auto_topic_creation"`
+	TLS                    ClientTLSConfig `mapstructure:"tls"`
+}
+
+// NATSSinkConfig configura o sink NATS JetStream. Os subjects precisam
+// pertencer a um stream existente.
+type NATSSinkConfig struct {
+	URL             string          `mapstructure:"url"`
+	CredentialsFile string          `mapstructure:"credentials_file"`
+	AckTimeout      time.Duration   `mapstructure:"ack_timeout"`
+	TLS             ClientTLSConfig `mapstructure:"tls"`
+}
+
+// StorageConfig configura o armazenamento de objetos grandes (checkpoints,
+// arquivos de simulações, exportações).
+type StorageConfig struct {
+	Backend string `mapstructure:"backend"`
+	// PresignThreshold é o tamanho, em bytes, acima do qual os downloads
+	// recebem uma URL assinada em vez de passar pela API.
+	PresignThreshold int64             `mapstructure:"presign_threshold"`
+	PresignExpiry    time.Duration     `mapstructure:"presign_expiry"`
+	Filesystem       FileStorageConfig `mapstructure:"filesystem"`
+	S3               S3StorageConfig   `mapstructure:"s3"`
+}
+
+// FileStorageConfig configura o armazenamento em disco local.
+type FileStorageConfig struct {
+	Root string `mapstructure:"root"`
+	// SigningKey assina os links de download. Vazia, uma chave aleatória é
+	// gerada a cada início e os links não valem entre instâncias.
+	SigningKey     string `mapstructure:"signing_key"`
+	SigningKeyFile string `mapstructure:"signing_key_file"`
+	// PublicURL é o prefixo dos links de download, absoluto ou relativo.
+	PublicURL string `mapstructure:"public_url"`
+}
+
+// S3StorageConfig configura o armazenamento S3 compatível (AWS, MinIO).
+type S3StorageConfig struct {
+	Endpoint      string          `mapstructure:"endpoint"`
+	Region        string          `mapstructure:"region"`
+	Bucket        string          `mapstructure:"bucket"`
+	AccessKey     string          `mapstructure:"access_key"`
+	SecretKey     string          `mapstructure:"secret_key"`
+	SecretKeyFile string          `mapstructure:"secret_key_file"`
+	PathStyle     bool            `mapstructure:"path_style"`
+	CreateBucket  bool            `mapstructure:"create_bucket"`
+	TLS           ClientTLSConfig `mapstructure:"tls"`
+}
+
+// DebugConfig configura o listener de diagnóstico.
+type DebugConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Host    string `mapstructure:"host"`
+	Port    string `mapstructure:"port"`
+	Token   string `mapstructure:"token"`
+}
+
+// AdminConfig configura o acesso administrativo.
+type AdminConfig struct {
+	Token string `mapstructure:"token"`
+}
+
+// LogConfig configura o logging.
+type LogConfig struct {
+	Level  string `mapstructure:"level"`
+	Format string `mapstructure:"format"`
+}
+
+// GinConfig configura o framework HTTP.
+type GinConfig struct {
+	Mode string `mapstructure:"mode"`
+}
+
+// ObservabilityConfig configura os limites de lentidão.
+type ObservabilityConfig struct {
+	SlowQueryThreshold   time.Duration `mapstructure:"slow_query_threshold"`
+	SlowRequestThreshold time.Duration `mapstructure:"slow_request_threshold"`
+}
+
+// HealthConfig configura as verificações periódicas de saúde.
+type HealthConfig struct {
+	CheckInterval time.Duration `mapstructure:"check_interval"`
+}
+
+// RegistryConfig configura o heartbeat de registro da instância.
+type RegistryConfig struct {
+	HeartbeatInterval time.Duration `mapstructure:"heartbeat_interval"`
+	TTL               time.Duration `mapstructure:"ttl"`
+}
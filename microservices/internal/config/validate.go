@@ -0,0 +1,982 @@
+package config
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+
+	"smart-city-microservices/internal/action"
+	"smart-city-microservices/internal/agentmetric"
+	"smart-city-microservices/internal/capability"
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/featureflag"
+	"smart-city-microservices/internal/simmetrics"
+	"smart-city-microservices/internal/tiles"
+	"smart-city-microservices/internal/trajectory"
+)
+
+// ValidationError agrega todos os problemas encontrados na configuração.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "configuração inválida:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+type problems []string
+
+func (p *problems) addf(format string, args ...interface{}) {
+	*p = append(*p, fmt.Sprintf(format, args...))
+}
+
+// Parse decodifica v no Config tipado e valida o resultado, reportando
+// todos os problemas de uma vez (chaves desconhecidas, tipos, faixas e enums).
+func Parse(v *viper.Viper) (*Config, error) {
+	var errs problems
+
+	known := knownKeys(reflect.TypeOf(Config{}), "")
+	for _, key := range v.AllKeys() {
+		if _, ok := known[key]; ok {
+			continue
+		}
+		if suggestion := closestKey(key, known); suggestion != "" {
+			errs.addf("chave desconhecida %q (você quis dizer %q?)", key, suggestion)
+		} else {
+			errs.addf("chave desconhecida %q", key)
+		}
+	}
+
+	var cfg Config
+	decodeErr := v.Unmarshal(&cfg, func(dc *mapstructure.DecoderConfig) {
+		dc.DecodeHook = mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToTimeDurationHookFunc(),
+			mapstructure.StringToSliceHookFunc(","),
+		)
+	})
+	if decodeErr != nil {
+		if me, ok := decodeErr.(*mapstructure.Error); ok {
+			errs = append(errs, me.Errors...)
+		} else {
+			errs.addf("%v", decodeErr)
+		}
+	}
+
+	cfg.validate(&errs)
+
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return &cfg, &ValidationError{Problems: errs}
+	}
+	return &cfg, nil
+}
+
+func (c *Config) validate(errs *problems) {
+	requireString(errs, "server.host", c.Server.Host)
+	requirePort(errs, "server.port", c.Server.Port)
+	requireNonNegative(errs, "server.shutdown.drain_delay", c.Server.Shutdown.DrainDelay)
+	requirePositive(errs, "server.shutdown.timeout", c.Server.Shutdown.Timeout)
+	if len(c.Server.CORS.AllowedOrigins) == 0 {
+		errs.addf("server.cors.allowed_origins deve ter ao menos uma origem")
+	}
+
+	if c.Server.TLS.Enabled {
+		requireFile(errs, "server.tls.cert_file", c.Server.TLS.CertFile)
+		requireFile(errs, "server.tls.key_file", c.Server.TLS.KeyFile)
+		requireEnum(errs, "server.tls.client_auth", c.Server.TLS.ClientAuth, "none", "request", "require")
+		if c.Server.TLS.ClientAuth == "request" || c.Server.TLS.ClientAuth == "require" {
+			requireFile(errs, "server.tls.client_ca_file", c.Server.TLS.ClientCAFile)
+		}
+	}
+
+	requireString(errs, "database.host", c.Database.Host)
+	requirePortInt(errs, "database.port", c.Database.Port)
+	requireString(errs, "database.name", c.Database.Name)
+	requireString(errs, "database.user", c.Database.User)
+	requireEnum(errs, "database.sslmode", c.Database.SSLMode, "disable", "allow", "prefer", "require", "verify-ca", "verify-full")
+	if c.Database.SSLMode == "verify-ca" || c.Database.SSLMode == "verify-full" {
+		requireFile(errs, "database.sslrootcert", c.Database.SSLRootCert)
+	}
+
+	requireString(errs, "redis.host", c.Redis.Host)
+	requirePortInt(errs, "redis.port", c.Redis.Port)
+	if c.Redis.TLS.Enabled && c.Redis.TLS.CAFile != "" {
+		requireFile(errs, "redis.tls.ca_file", c.Redis.TLS.CAFile)
+	}
+
+	requireEnum(errs, "secrets.provider", c.Secrets.Provider, "", "vault")
+	if c.Secrets.Provider == "vault" {
+		requireString(errs, "secrets.vault.address", c.Secrets.Vault.Address)
+		if c.Secrets.Vault.Token == "" && c.Secrets.Vault.TokenFile == "" {
+			errs.addf("secrets.vault.token ou secrets.vault.token_file é obrigatório com secrets.provider=vault")
+		}
+	}
+	requireNonNegative(errs, "secrets.refresh_interval", c.Secrets.RefreshInterval)
+
+	if c.GRPC.Enabled {
+		requirePort(errs, "grpc.port", c.GRPC.Port)
+		if c.GRPC.Port == c.Server.Port && c.GRPC.Host == c.Server.Host {
+			errs.addf("grpc.port (%s) deve ser diferente de server.port", c.GRPC.Port)
+		}
+	}
+
+	// O buffer de eventos recentes também atende GET /api/v1/events.
+	requirePositiveInt(errs, "graphql.recent_events", c.GraphQL.RecentEvents)
+	if c.GraphQL.Enabled {
+		requirePositiveInt(errs, "graphql.max_depth", c.GraphQL.MaxDepth)
+		requirePositiveInt(errs, "graphql.max_complexity", c.GraphQL.MaxComplexity)
+	}
+
+	requireEnum(errs, "events.schema_validation", c.Events.SchemaValidation,
+		events.ValidationOff, events.ValidationWarn, events.ValidationReject)
+	requirePins(errs, "events.websocket_versions", c.Events.WebsocketVersions)
+	if c.Events.Relay.Enabled {
+		requireString(errs, "events.relay.channel", c.Events.Relay.Channel)
+		requirePositiveInt(errs, "events.relay.queue_size", c.Events.Relay.QueueSize)
+		requirePositive(errs, "events.relay.peer_refresh", c.Events.Relay.PeerRefresh)
+		requirePositiveInt(errs, "events.relay.max_attempts", c.Events.Relay.MaxAttempts)
+	}
+	requirePositiveInt(errs, "events.long_poll.buffer_size", c.Events.LongPoll.BufferSize)
+	requirePositiveInt(errs, "events.long_poll.max_events", c.Events.LongPoll.MaxEvents)
+	requirePositive(errs, "events.long_poll.default_timeout", c.Events.LongPoll.DefaultTimeout)
+	requirePositive(errs, "events.long_poll.max_timeout", c.Events.LongPoll.MaxTimeout)
+	requirePositiveInt(errs, "events.long_poll.max_per_principal", c.Events.LongPoll.MaxPerPrincipal)
+	if c.Events.LongPoll.DefaultTimeout > c.Events.LongPoll.MaxTimeout {
+		errs.addf("events.long_poll.default_timeout (%s) não pode passar de events.long_poll.max_timeout (%s)",
+			c.Events.LongPoll.DefaultTimeout, c.Events.LongPoll.MaxTimeout)
+	}
+	if c.Events.Broadcast.Enabled {
+		for i, t := range c.Events.Broadcast.AllowedTypes {
+			if events.Schemas.Current(t) == 0 {
+				errs.addf("events.broadcast.allowed_types[%d]: tipo %q sem schema registrado", i, t)
+			}
+		}
+		requirePositiveInt(errs, "events.broadcast.rate_limit", c.Events.Broadcast.RateLimit)
+		if c.Events.Broadcast.RateWindow < time.Second {
+			errs.addf("events.broadcast.rate_window deve ser de ao menos 1s, recebido %s", c.Events.Broadcast.RateWindow)
+		}
+	}
+
+	if c.Webhooks.Enabled {
+		requirePositiveInt(errs, "webhooks.workers", c.Webhooks.Workers)
+		requirePositiveInt(errs, "webhooks.queue_size", c.Webhooks.QueueSize)
+		requirePositiveInt(errs, "webhooks.max_attempts", c.Webhooks.MaxAttempts)
+		requirePositive(errs, "webhooks.initial_backoff", c.Webhooks.InitialBackoff)
+		requirePositive(errs, "webhooks.timeout", c.Webhooks.Timeout)
+		if c.Webhooks.MaxBackoff < c.Webhooks.InitialBackoff {
+			errs.addf("webhooks.max_backoff (%s) deve ser maior ou igual a webhooks.initial_backoff (%s)", c.Webhooks.MaxBackoff, c.Webhooks.InitialBackoff)
+		}
+		if c.Webhooks.DisableAfter < 0 {
+			errs.addf("webhooks.disable_after não pode ser negativo, recebido %d (0 desativa)", c.Webhooks.DisableAfter)
+		}
+	}
+
+	if c.DeadLetter.Enabled {
+		requireString(errs, "dead_letter.stream", c.DeadLetter.Stream)
+		requirePositiveInt(errs, "dead_letter.max_len", int(c.DeadLetter.MaxLen))
+		requirePositive(errs, "dead_letter.retention", c.DeadLetter.Retention)
+		requirePositive(errs, "dead_letter.interval", c.DeadLetter.Interval)
+	}
+
+	if c.Notifications.Enabled {
+		n := c.Notifications
+		requirePositiveInt(errs, "notifications.workers", n.Workers)
+		requirePositiveInt(errs, "notifications.queue_size", n.QueueSize)
+		requirePositiveInt(errs, "notifications.max_attempts", n.MaxAttempts)
+		requirePositive(errs, "notifications.initial_backoff", n.InitialBackoff)
+		requirePositive(errs, "notifications.timeout", n.Timeout)
+		if n.MaxBackoff < n.InitialBackoff {
+			errs.addf("notifications.max_backoff (%s) deve ser maior ou igual a notifications.initial_backoff (%s)", n.MaxBackoff, n.InitialBackoff)
+		}
+		if n.SMTP.Host != "" {
+			requirePortInt(errs, "notifications.smtp.port", n.SMTP.Port)
+			requireEnum(errs, "notifications.smtp.security", n.SMTP.Security, "starttls", "tls", "none")
+			if _, err := mail.ParseAddress(n.SMTP.From); err != nil {
+				errs.addf("notifications.smtp.from inválido %q: %v", n.SMTP.From, err)
+			}
+		}
+	}
+
+	o := c.Outbound
+	requirePositiveInt(errs, "outbound.max_idle_conns", o.MaxIdleConns)
+	requirePositiveInt(errs, "outbound.max_idle_conns_per_host", o.MaxIdleConnsPerHost)
+	if o.MaxConnsPerHost < 0 {
+		errs.addf("outbound.max_conns_per_host não pode ser negativo, recebido %d", o.MaxConnsPerHost)
+	}
+	requirePositive(errs, "outbound.idle_conn_timeout", o.IdleConnTimeout)
+	requirePositive(errs, "outbound.dial_timeout", o.DialTimeout)
+	requirePositive(errs, "outbound.tls_handshake_timeout", o.TLSHandshakeTimeout)
+	requirePositive(errs, "outbound.response_header_timeout", o.ResponseHeaderTimeout)
+	if o.ProxyURL != "" {
+		if u, err := url.Parse(o.ProxyURL); err != nil || u.Scheme == "" || u.Host == "" {
+			errs.addf("outbound.proxy_url inválido %q: use uma URL absoluta", o.ProxyURL)
+		}
+	}
+	requirePositiveInt(errs, "outbound.retry.max_attempts", o.Retry.MaxAttempts)
+	if o.Retry.MaxAttempts > 1 {
+		requirePositive(errs, "outbound.retry.initial_backoff", o.Retry.InitialBackoff)
+		if o.Retry.MaxBackoff < o.Retry.InitialBackoff {
+			errs.addf("outbound.retry.max_backoff (%s) deve ser maior ou igual a outbound.retry.initial_backoff (%s)", o.Retry.MaxBackoff, o.Retry.InitialBackoff)
+		}
+	}
+	if o.Breaker.FailureThreshold < 0 {
+		errs.addf("outbound.breaker.failure_threshold não pode ser negativo, recebido %d", o.Breaker.FailureThreshold)
+	}
+	if o.Breaker.FailureThreshold > 0 {
+		requirePositive(errs, "outbound.breaker.cooldown", o.Breaker.Cooldown)
+	}
+
+	if c.Alerts.Enabled {
+		requirePositive(errs, "alerts.interval", c.Alerts.Interval)
+	}
+	if c.Schedules.Enabled {
+		requirePositive(errs, "schedules.interval", c.Schedules.Interval)
+		if c.Schedules.Grace < c.Schedules.Interval {
+			errs.addf("schedules.grace (%s) não pode ser menor que schedules.interval (%s)", c.Schedules.Grace, c.Schedules.Interval)
+		}
+	}
+	requirePositiveInt(errs, "action_batches.workers", c.ActionBatches.Workers)
+	requirePositiveInt(errs, "action_batches.max_agents", c.ActionBatches.MaxAgents)
+	requirePositive(errs, "action_batches.timeout", c.ActionBatches.Timeout)
+	requirePositiveInt(errs, "actions.workers", c.Actions.Workers)
+	requirePositiveInt(errs, "actions.queue_size", c.Actions.QueueSize)
+	requirePositive(errs, "actions.default_timeout", c.Actions.DefaultTimeout)
+	requirePositive(errs, "actions.cancel_poll_interval", c.Actions.CancelPollInterval)
+	requireNonNegative(errs, "actions.priority_aging", c.Actions.PriorityAging)
+	if c.Actions.PriorityAging > 24*time.Hour {
+		errs.addf("actions.priority_aging não pode passar de 24h, recebido %s", c.Actions.PriorityAging)
+	}
+	requireNonNegative(errs, "actions.retention.window", c.Actions.Retention.Window)
+	if w := c.Actions.Retention.Window; w > 0 && w < 24*time.Hour {
+		errs.addf("actions.retention.window deve ser 0 ou ao menos 24h (as partições são diárias), recebido %s", w)
+	}
+	requirePositive(errs, "actions.retention.interval", c.Actions.Retention.Interval)
+	requirePositiveInt(errs, "actions.retention.partitions_ahead", c.Actions.Retention.PartitionsAhead)
+	requirePositive(errs, "actions.history.default_window", c.Actions.History.DefaultWindow)
+	requirePositive(errs, "actions.history.max_window", c.Actions.History.MaxWindow)
+	requirePositive(errs, "actions.recovery.heartbeat_interval", c.Actions.Recovery.HeartbeatInterval)
+	requirePositive(errs, "actions.recovery.stale_after", c.Actions.Recovery.StaleAfter)
+	requirePositive(errs, "actions.recovery.interval", c.Actions.Recovery.Interval)
+	requirePositiveInt(errs, "actions.recovery.max_resumes", c.Actions.Recovery.MaxResumes)
+	if r := c.Actions.Recovery; r.StaleAfter < 3*r.HeartbeatInterval {
+		errs.addf("actions.recovery.stale_after (%s) deve ser ao menos 3x actions.recovery.heartbeat_interval (%s)", r.StaleAfter, r.HeartbeatInterval)
+	}
+	if c.Actions.History.DefaultWindow > c.Actions.History.MaxWindow {
+		errs.addf("actions.history.default_window (%s) não pode passar de actions.history.max_window (%s)",
+			c.Actions.History.DefaultWindow, c.Actions.History.MaxWindow)
+	}
+	actionNames := map[string]bool{}
+	for i, d := range c.Actions.Definitions {
+		switch {
+		case d.Name == "":
+			errs.addf("actions.definitions[%d] precisa de name", i)
+		case actionNames[d.Name]:
+			errs.addf("actions.definitions[%d]: ação %q declarada mais de uma vez", i, d.Name)
+		}
+		actionNames[d.Name] = true
+		if _, err := action.ParseSchema(d.Schema); err != nil {
+			errs.addf("actions.definitions[%d].schema: %v", i, err)
+		}
+		requireNonNegative(errs, fmt.Sprintf("actions.definitions[%d].timeout", i), d.Timeout)
+		requireNonNegative(errs, fmt.Sprintf("actions.definitions[%d].retry.backoff", i), d.Retry.Backoff)
+		if d.Retry.MaxAttempts < 0 {
+			errs.addf("actions.definitions[%d].retry.max_attempts não pode ser negativo, recebido %d", i, d.Retry.MaxAttempts)
+		}
+		for j, role := range d.Roles {
+			requireEnum(errs, fmt.Sprintf("actions.definitions[%d].roles[%d]", i, j), role, "admin", "operator", "viewer")
+		}
+		for j, scope := range d.Scopes {
+			requireString(errs, fmt.Sprintf("actions.definitions[%d].scopes[%d]", i, j), scope)
+		}
+	}
+
+	requirePositiveInt(errs, "agent_types.max_samples", c.AgentTypes.MaxSamples)
+	typeNames := map[string]bool{}
+	capabilities := map[string]map[string]bool{}
+	for i, t := range c.AgentTypes.Definitions {
+		switch {
+		case t.Name == "":
+			errs.addf("agent_types.definitions[%d] precisa de name", i)
+		case typeNames[t.Name]:
+			errs.addf("agent_types.definitions[%d]: tipo %q declarado mais de uma vez", i, t.Name)
+		}
+		typeNames[t.Name] = true
+		if t.ProximityThreshold < 0 {
+			errs.addf("agent_types.definitions[%d].proximity_threshold não pode ser negativo", i)
+		}
+		requireNonNegative(errs, fmt.Sprintf("agent_types.definitions[%d].offline_after", i), t.OfflineAfter)
+		metricNames := map[string]bool{}
+		for j, m := range t.Metrics {
+			switch {
+			case !agentmetric.ValidName(m.Name):
+				errs.addf("agent_types.definitions[%d].metrics[%d]: nome inválido %q (letras minúsculas, dígitos e _)", i, j, m.Name)
+			case metricNames[m.Name]:
+				errs.addf("agent_types.definitions[%d].metrics[%d]: métrica %q declarada mais de uma vez", i, j, m.Name)
+			}
+			metricNames[m.Name] = true
+			if !agentmetric.ValidAggregation(m.Aggregation) {
+				errs.addf("agent_types.definitions[%d].metrics[%d].aggregation deve ser avg, sum, min, max ou last, recebido %q", i, j, m.Aggregation)
+			}
+			if a := m.Anomaly; a != nil {
+				if a.K <= 0 {
+					errs.addf("agent_types.definitions[%d].metrics[%d].anomaly.k deve ser positivo", i, j)
+				}
+				if a.Window < 1 {
+					errs.addf("agent_types.definitions[%d].metrics[%d].anomaly.window deve ser de ao menos 1 amostra", i, j)
+				}
+				if a.MinSamples < 2 {
+					errs.addf("agent_types.definitions[%d].metrics[%d].anomaly.min_samples deve ser de ao menos 2 amostras", i, j)
+				}
+				if a.Baseline < 0 {
+					errs.addf("agent_types.definitions[%d].metrics[%d].anomaly.baseline não pode ser negativo", i, j)
+				}
+			}
+		}
+		allowed := map[string]bool{}
+		for j, name := range t.Capabilities.Allowed {
+			if !capability.ValidName(name) {
+				errs.addf("agent_types.definitions[%d].capabilities.allowed[%d]: nome inválido %q (letras minúsculas, dígitos e _)", i, j, name)
+			}
+			allowed[name] = true
+		}
+		capabilities[t.Name] = allowed
+		for j, name := range t.Capabilities.Default {
+			if !allowed[name] {
+				errs.addf("agent_types.definitions[%d].capabilities.default[%d]: capacidade %q fora de allowed", i, j, name)
+			}
+		}
+		cm := t.Consumption
+		if cm.WhPerKm < 0 || cm.BaselineW < 0 {
+			errs.addf("agent_types.definitions[%d].consumption: wh_per_km e baseline_w não podem ser negativos", i)
+		}
+		if cm.MeterMetric != "" && !metricNames[cm.MeterMetric] {
+			errs.addf("agent_types.definitions[%d].consumption.meter_metric: métrica %q não declarada no tipo", i, cm.MeterMetric)
+		}
+		if t.Ingestion.Limit < 0 {
+			errs.addf("agent_types.definitions[%d].ingestion.limit não pode ser negativo", i)
+		}
+		hc := t.Health
+		if len(hc.Components) > 0 {
+			if hc.Critical < 0 || hc.Critical > hc.Degraded || hc.Degraded > 100 {
+				errs.addf("agent_types.definitions[%d].health: precisa de 0 <= critical <= degraded <= 100, recebido critical %v e degraded %v", i, hc.Critical, hc.Degraded)
+			}
+			if hc.Hysteresis < 0 {
+				errs.addf("agent_types.definitions[%d].health.hysteresis não pode ser negativo", i)
+			}
+		}
+		healthMetrics := map[string]bool{}
+		for j, hm := range hc.Components {
+			switch {
+			case !metricNames[hm.Metric]:
+				errs.addf("agent_types.definitions[%d].health.components[%d]: métrica %q não declarada no tipo", i, j, hm.Metric)
+			case healthMetrics[hm.Metric]:
+				errs.addf("agent_types.definitions[%d].health.components[%d]: métrica %q usada mais de uma vez", i, j, hm.Metric)
+			}
+			healthMetrics[hm.Metric] = true
+			if hm.Weight <= 0 {
+				errs.addf("agent_types.definitions[%d].health.components[%d].weight deve ser positivo", i, j)
+			}
+			if hm.Good == hm.Bad {
+				errs.addf("agent_types.definitions[%d].health.components[%d]: good e bad precisam ser diferentes", i, j)
+			}
+		}
+	}
+
+	// As capacidades exigidas por uma ação precisam existir em algum dos
+	// tipos que a aceitam, senão nenhum agente poderia executá-la.
+	for i, d := range c.Actions.Definitions {
+		for j, name := range d.Capabilities {
+			types := d.AgentTypes
+			if len(types) == 0 {
+				types = make([]string, 0, len(capabilities))
+				for t := range capabilities {
+					types = append(types, t)
+				}
+			}
+			found := false
+			for _, t := range types {
+				found = found || capabilities[t][name]
+			}
+			if !found {
+				errs.addf("actions.definitions[%d].capabilities[%d]: capacidade %q não é permitida em nenhum dos tipos da ação", i, j, name)
+			}
+		}
+	}
+
+	if len(c.Dependencies.FailureStatuses) == 0 {
+		errs.addf("dependencies.failure_statuses deve ter ao menos um status")
+	}
+	requirePositiveInt(errs, "dependencies.max_per_agent", c.Dependencies.MaxPerAgent)
+	requirePositiveInt(errs, "dependencies.max_depth", c.Dependencies.MaxDepth)
+	requirePositiveInt(errs, "dependencies.queue_size", c.Dependencies.QueueSize)
+	if c.Dependencies.GraphDepth < 1 || c.Dependencies.GraphDepth > c.Dependencies.MaxDepth {
+		errs.addf("dependencies.graph_depth deve estar entre 1 e dependencies.max_depth (%d), recebido %d", c.Dependencies.MaxDepth, c.Dependencies.GraphDepth)
+	}
+
+	requirePositive(errs, "messages.tick_interval", c.Messages.TickInterval)
+	requirePositiveInt(errs, "messages.inbox_size", c.Messages.InboxSize)
+	requirePositive(errs, "messages.inbox_ttl", c.Messages.InboxTTL)
+	requirePositiveInt(errs, "messages.max_pending", c.Messages.MaxPending)
+	requirePositiveInt(errs, "messages.max_payload_bytes", c.Messages.MaxPayloadBytes)
+	if c.Behaviors.Enabled {
+		requirePositiveInt(errs, "behaviors.max_actions", c.Behaviors.MaxActions)
+		requirePositiveInt(errs, "behaviors.workers", c.Behaviors.Workers)
+		requirePositive(errs, "behaviors.decide_budget", c.Behaviors.DecideBudget)
+		// Senão um script dentro do seu timeout seria pulado pelo budget.
+		if c.Behaviors.DecideBudget > 0 && c.Behaviors.DecideBudget < c.Behaviors.Script.Timeout {
+			errs.addf("behaviors.decide_budget (%s) não pode ser menor que behaviors.script.timeout (%s)", c.Behaviors.DecideBudget, c.Behaviors.Script.Timeout)
+		}
+		if c.Behaviors.DecideBudget > c.Messages.TickInterval {
+			errs.addf("behaviors.decide_budget (%s) não pode passar de messages.tick_interval (%s)", c.Behaviors.DecideBudget, c.Messages.TickInterval)
+		}
+	}
+	requirePositiveInt(errs, "behaviors.script.max_instructions", c.Behaviors.Script.MaxInstructions)
+	requirePositive(errs, "behaviors.script.timeout", c.Behaviors.Script.Timeout)
+	requirePositiveInt(errs, "behaviors.script.max_memory_bytes", c.Behaviors.Script.MaxMemoryBytes)
+	requirePositiveInt(errs, "behaviors.script.max_script_bytes", c.Behaviors.Script.MaxScriptBytes)
+
+	if c.Trajectories.Enabled {
+		requirePositive(errs, "trajectories.sample_interval", c.Trajectories.SampleInterval)
+		requirePositiveInt(errs, "trajectories.batch_size", c.Trajectories.BatchSize)
+		requirePositive(errs, "trajectories.flush_interval", c.Trajectories.FlushInterval)
+		requirePositiveInt(errs, "trajectories.queue_size", c.Trajectories.QueueSize)
+	}
+	requirePositive(errs, "trajectories.default_window", c.Trajectories.DefaultWindow)
+	requirePositive(errs, "trajectories.max_window", c.Trajectories.MaxWindow)
+	if c.Trajectories.DefaultWindow > c.Trajectories.MaxWindow {
+		errs.addf("trajectories.default_window (%s) não pode passar de trajectories.max_window (%s)",
+			c.Trajectories.DefaultWindow, c.Trajectories.MaxWindow)
+	}
+	requirePositiveInt(errs, "trajectories.max_points", c.Trajectories.MaxPoints)
+	requireNonNegative(errs, "trajectories.retention.window", c.Trajectories.Retention.Window)
+	if w := c.Trajectories.Retention.Window; w > 0 && w < 24*time.Hour {
+		errs.addf("trajectories.retention.window deve ser 0 ou ao menos 24h (as partições são diárias), recebido %s", w)
+	}
+	if cc := c.Trajectories.Compaction; cc.Enabled {
+		requirePositive(errs, "trajectories.compaction.interval", cc.Interval)
+		requirePositive(errs, "trajectories.compaction.age", cc.Age)
+		requireEnum(errs, "trajectories.compaction.method", cc.Method, trajectory.MethodInterval, trajectory.MethodRDP)
+		switch cc.Method {
+		case trajectory.MethodInterval:
+			requirePositive(errs, "trajectories.compaction.resolution", cc.Resolution)
+		case trajectory.MethodRDP:
+			if cc.ToleranceM <= 0 {
+				errs.addf("trajectories.compaction.tolerance_m deve ser positivo, recebido %g", cc.ToleranceM)
+			}
+		}
+		requirePositiveInt(errs, "trajectories.compaction.chunk_agents", cc.ChunkAgents)
+		if cc.Retention != 0 && cc.Retention < 24*time.Hour {
+			errs.addf("trajectories.compaction.retention deve ser 0 ou ao menos 24h (as partições são diárias), recebido %s", cc.Retention)
+		}
+		// Um dia só é compactado quando inteiro passou de age; a retenção
+		// não pode descartá-lo antes disso.
+		if w := c.Trajectories.Retention.Window; w > 0 && cc.Age+24*time.Hour >= w {
+			errs.addf("trajectories.compaction.age (%s) deve ficar mais de 24h abaixo de trajectories.retention.window (%s)", cc.Age, w)
+		}
+	}
+	if c.Positions.Enabled {
+		if c.Positions.DistanceThreshold < 0 {
+			errs.addf("positions.distance_threshold não pode ser negativo")
+		}
+		if c.Positions.HeadingThreshold < 0 {
+			errs.addf("positions.heading_threshold não pode ser negativo")
+		}
+		requirePositive(errs, "positions.flush_interval", c.Positions.FlushInterval)
+		requirePositive(errs, "positions.keyframe_interval", c.Positions.KeyframeInterval)
+		if c.Positions.KeyframeInterval < c.Positions.FlushInterval {
+			errs.addf("positions.keyframe_interval (%s) não pode ser menor que positions.flush_interval (%s)",
+				c.Positions.KeyframeInterval, c.Positions.FlushInterval)
+		}
+		requirePositiveInt(errs, "positions.queue_size", c.Positions.QueueSize)
+		requirePositiveInt(errs, "positions.send_buffer", c.Positions.SendBuffer)
+	}
+	requirePositiveInt(errs, "heatmaps.max_cells", c.Heatmaps.MaxCells)
+	if c.Heatmaps.DefaultCellSize <= 0 {
+		errs.addf("heatmaps.default_cell_size deve ser positivo")
+	}
+	requirePositive(errs, "heatmaps.live_ttl", c.Heatmaps.LiveTTL)
+	requirePositive(errs, "heatmaps.history_ttl", c.Heatmaps.HistoryTTL)
+	if c.Tiles.Enabled {
+		t := c.Tiles
+		if t.MaxZoom < 0 || t.MaxZoom > 30 {
+			errs.addf("tiles.max_zoom deve estar entre 0 e 30")
+		}
+		if t.CacheMaxZoom < 0 || t.CacheMaxZoom > t.MaxZoom {
+			errs.addf("tiles.cache_max_zoom (%d) deve estar entre 0 e tiles.max_zoom (%d)", t.CacheMaxZoom, t.MaxZoom)
+		}
+		if t.ThinBelowZoom < 0 {
+			errs.addf("tiles.thin_below_zoom não pode ser negativo")
+		}
+		requireEnum(errs, "tiles.thinning", t.Thinning, tiles.ThinCluster, tiles.ThinSample)
+		requirePositiveInt(errs, "tiles.cluster_cell_px", t.ClusterCellPx)
+		requirePositiveInt(errs, "tiles.sample_limit", t.SampleLimit)
+		requirePositiveInt(errs, "tiles.max_features", t.MaxFeatures)
+		for i, a := range t.Attributes {
+			requireEnum(errs, fmt.Sprintf("tiles.attributes[%d]", i), a, tiles.AttributeNames...)
+		}
+		requirePositive(errs, "tiles.cache_ttl", t.CacheTTL)
+		requirePositive(errs, "tiles.flush_interval", t.FlushInterval)
+		requirePositiveInt(errs, "tiles.queue_size", t.QueueSize)
+	}
+	if c.HTTPCache.Enabled {
+		requirePositive(errs, "http_cache.default_ttl", c.HTTPCache.DefaultTTL)
+		if c.HTTPCache.DefaultTTL > 24*time.Hour {
+			errs.addf("http_cache.default_ttl (%s) não pode passar de 24h", c.HTTPCache.DefaultTTL)
+		}
+		requirePositive(errs, "http_cache.default_max_age", c.HTTPCache.DefaultMaxAge)
+		requirePositiveInt(errs, "http_cache.max_body_bytes", c.HTTPCache.MaxBodyBytes)
+	}
+	if c.Failures.RecentEvents < 0 || c.Failures.RecentEvents > 10000 {
+		errs.addf("failures.recent_events deve estar entre 0 e 10000")
+	}
+	requirePositiveInt(errs, "failures.max_event_bytes", c.Failures.MaxEventBytes)
+	requirePositiveInt(errs, "failures.max_stack_bytes", c.Failures.MaxStackBytes)
+	requirePositiveInt(errs, "failures.max_report_bytes", c.Failures.MaxReportBytes)
+	requirePositive(errs, "failures.link_expiry", c.Failures.LinkExpiry)
+	if c.Quotas.Enabled {
+		d := c.Quotas.Defaults
+		for _, l := range []struct {
+			key string
+			n   int64
+		}{
+			{"quotas.defaults.agents", d.Agents},
+			{"quotas.defaults.event_rows", d.EventRows},
+			{"quotas.defaults.checkpoint_bytes", d.CheckpointBytes},
+			{"quotas.defaults.archive_bytes", d.ArchiveBytes},
+		} {
+			if l.n < 0 {
+				errs.addf("%s não pode ser negativo (0 é sem limite)", l.key)
+			}
+		}
+		requirePositive(errs, "quotas.flush_interval", c.Quotas.FlushInterval)
+		requirePositiveInt(errs, "quotas.queue_size", c.Quotas.QueueSize)
+		requireNonNegative(errs, "quotas.reconcile_interval", c.Quotas.ReconcileInterval)
+	}
+	if c.SyncHooks.Enabled {
+		requirePositive(errs, "sync_hooks.default_timeout", c.SyncHooks.DefaultTimeout)
+		requirePositive(errs, "sync_hooks.max_timeout", c.SyncHooks.MaxTimeout)
+		if c.SyncHooks.MaxTimeout < c.SyncHooks.DefaultTimeout {
+			errs.addf("sync_hooks.max_timeout (%s) deve ser maior ou igual a sync_hooks.default_timeout (%s)",
+				c.SyncHooks.MaxTimeout, c.SyncHooks.DefaultTimeout)
+		}
+	}
+	requirePositive(errs, "maintenance.refresh_interval", c.Maintenance.RefreshInterval)
+	requirePositive(errs, "maintenance.retry_after", c.Maintenance.RetryAfter)
+	requireEnum(errs, "i18n.default_locale", c.I18n.DefaultLocale, "en", "pt-BR")
+	if c.OIDC.Enabled {
+		requireString(errs, "oidc.issuer_url", c.OIDC.IssuerURL)
+		requireString(errs, "oidc.client_id", c.OIDC.ClientID)
+		requireString(errs, "oidc.redirect_url", c.OIDC.RedirectURL)
+		requireString(errs, "oidc.subject_claim", c.OIDC.SubjectClaim)
+		if len(c.OIDC.Session.SigningKey) < 32 {
+			errs.addf("oidc.session.signing_key deve ter ao menos 32 caracteres")
+		}
+		requirePositive(errs, "oidc.session.ttl", c.OIDC.Session.TTL)
+		requirePositive(errs, "oidc.session.refresh_ttl", c.OIDC.Session.RefreshTTL)
+		for i, m := range c.OIDC.RoleMappings {
+			requireString(errs, fmt.Sprintf("oidc.role_mappings[%d].group", i), m.Group)
+			requireEnum(errs, fmt.Sprintf("oidc.role_mappings[%d].role", i), m.Role, "admin", "operator", "viewer")
+		}
+		for i, role := range c.OIDC.DefaultRoles {
+			requireEnum(errs, fmt.Sprintf("oidc.default_roles[%d]", i), role, "admin", "operator", "viewer")
+		}
+		if len(c.OIDC.RoleMappings) == 0 && len(c.OIDC.DefaultRoles) == 0 {
+			errs.addf("oidc.role_mappings ou oidc.default_roles deve ter ao menos um item")
+		}
+	}
+	if c.SimMetrics.Enabled {
+		requirePositiveInt(errs, "simulation_metrics.max_simulations", c.SimMetrics.MaxSimulations)
+		requirePositive(errs, "simulation_metrics.refresh_interval", c.SimMetrics.RefreshInterval)
+		for _, name := range c.SimMetrics.AgentMetrics {
+			if !agentmetric.ValidName(name) {
+				errs.addf("simulation_metrics.agent_metrics: nome de métrica inválido %q", name)
+			}
+		}
+		if c.SimMetrics.RemoteWrite.Enabled {
+			requireString(errs, "simulation_metrics.remote_write.url", c.SimMetrics.RemoteWrite.URL)
+			requirePositive(errs, "simulation_metrics.remote_write.interval", c.SimMetrics.RemoteWrite.Interval)
+			requirePositive(errs, "simulation_metrics.remote_write.timeout", c.SimMetrics.RemoteWrite.Timeout)
+			if _, err := simmetrics.ParseLabels(c.SimMetrics.RemoteWrite.Labels); err != nil {
+				errs.addf("simulation_metrics.remote_write.labels: %v", err)
+			}
+		}
+	}
+	if c.KPIs.Enabled {
+		requirePositive(errs, "kpis.interval", c.KPIs.Interval)
+		requirePositiveInt(errs, "kpis.history_points", c.KPIs.HistoryPoints)
+		requirePositive(errs, "kpis.retention", c.KPIs.Retention)
+		requirePositiveInt(errs, "kpis.buffer_size", c.KPIs.BufferSize)
+	}
+	if c.ChangeFeed.Enabled {
+		requireString(errs, "change_feed.stream", c.ChangeFeed.Stream)
+		requirePositive(errs, "change_feed.retention", c.ChangeFeed.Retention)
+		requirePositiveInt(errs, "change_feed.default_limit", c.ChangeFeed.DefaultLimit)
+		requirePositiveInt(errs, "change_feed.max_limit", c.ChangeFeed.MaxLimit)
+		if c.ChangeFeed.DefaultLimit > c.ChangeFeed.MaxLimit {
+			errs.addf("change_feed.default_limit não pode ser maior que change_feed.max_limit")
+		}
+		if c.ChangeFeed.Stream == c.EventExport.Outbox.Stream {
+			errs.addf("change_feed.stream não pode ser o stream do outbox (event_export.outbox.stream)")
+		}
+	}
+	if c.QueryBudget.Enabled {
+		requirePositiveInt(errs, "query_budget.max_rows", int(c.QueryBudget.MaxRows))
+		requirePositiveInt(errs, "query_budget.max_offset", int(c.QueryBudget.MaxOffset))
+	}
+	if c.Ingestion.Enabled {
+		if c.Ingestion.Window < time.Second {
+			errs.addf("ingestion_limits.window deve ser de ao menos 1s, recebido %s", c.Ingestion.Window)
+		}
+		requirePositiveInt(errs, "ingestion_limits.sample_one_in", c.Ingestion.SampleOneIn)
+		if c.Ingestion.DefaultLimit < 0 {
+			errs.addf("ingestion_limits.default_limit não pode ser negativo")
+		}
+		if c.Ingestion.HealthPenalty < 0 || c.Ingestion.HealthPenalty > 100 {
+			errs.addf("ingestion_limits.health_penalty deve estar entre 0 e 100, recebido %v", c.Ingestion.HealthPenalty)
+		}
+	}
+	requirePositiveInt(errs, "seed.agents_per_type", c.Seed.AgentsPerType)
+	requireString(errs, "seed.project_id", c.Seed.ProjectID)
+	requirePositive(errs, "seed.history", c.Seed.History)
+	if b := c.Seed.BBox; b.MinLat < -90 || b.MaxLat > 90 || b.MinLon < -180 || b.MaxLon > 180 || b.MinLat >= b.MaxLat || b.MinLon >= b.MaxLon {
+		errs.addf("seed.bbox deve ter min_lat < max_lat em [-90, 90] e min_lon < max_lon em [-180, 180]")
+	}
+	if c.Faults.Enabled {
+		requirePositive(errs, "faults.refresh_interval", c.Faults.RefreshInterval)
+		requirePositive(errs, "faults.max_duration", c.Faults.MaxDuration)
+		requirePositive(errs, "faults.max_latency", c.Faults.MaxLatency)
+		requirePositiveInt(errs, "faults.max_agents", c.Faults.MaxAgents)
+		if c.Faults.MaxJitterM <= 0 {
+			errs.addf("faults.max_jitter_m deve ser positivo, recebido %v", c.Faults.MaxJitterM)
+		}
+	}
+	if c.Exports.Enabled {
+		requirePositive(errs, "exports.quiesce_timeout", c.Exports.QuiesceTimeout)
+		requirePositive(errs, "exports.timeout", c.Exports.Timeout)
+		if c.Exports.QuiesceTimeout >= c.Exports.Timeout {
+			errs.addf("exports.quiesce_timeout (%v) deve ser menor que exports.timeout (%v)", c.Exports.QuiesceTimeout, c.Exports.Timeout)
+		}
+	}
+	if c.Deletions.Enabled {
+		requirePositive(errs, "deletions.interval", c.Deletions.Interval)
+		requirePositiveInt(errs, "deletions.batch_size", c.Deletions.BatchSize)
+		if c.Deletions.Pause < 0 {
+			errs.addf("deletions.pause não pode ser negativo, recebido %s", c.Deletions.Pause)
+		}
+	}
+	requireString(errs, "feature_flags.channel", c.FeatureFlags.Channel)
+	requirePositive(errs, "feature_flags.refresh_interval", c.FeatureFlags.RefreshInterval)
+	for name := range c.FeatureFlags.Defaults {
+		if _, ok := featureflag.Lookup(name); !ok {
+			errs.addf("feature_flags.defaults.%s: flag desconhecido", name)
+		}
+	}
+	requireString(errs, "project_limits.channel", c.ProjectLimits.Channel)
+	requirePositive(errs, "project_limits.refresh_interval", c.ProjectLimits.RefreshInterval)
+	if d := c.ProjectLimits.Defaults; d.RequestsPerMinute < 0 || d.RequestTimeout < 0 || d.ExportMaxBytes < 0 {
+		errs.addf("project_limits.defaults não aceita valores negativos")
+	}
+	requirePositiveInt(errs, "agents.batch_get_max", c.Agents.BatchGetMax)
+	requirePositiveInt(errs, "groups.max_members", c.Groups.MaxMembers)
+	requireString(errs, "groups.start_status", c.Groups.StartStatus)
+	requireString(errs, "groups.stop_status", c.Groups.StopStatus)
+	if c.Presence.Enabled {
+		requirePositive(errs, "presence.interval", c.Presence.Interval)
+		requireString(errs, "presence.offline_status", c.Presence.OfflineStatus)
+		requirePositiveInt(errs, "presence.max_per_cycle", c.Presence.MaxPerCycle)
+	}
+	if c.Coalescing.Enabled {
+		requirePositive(errs, "coalescing.flush_interval", c.Coalescing.FlushInterval)
+		requirePositive(errs, "coalescing.live_ttl", c.Coalescing.LiveTTL)
+		if c.Coalescing.PositionThresholdM <= 0 {
+			errs.addf("coalescing.position_threshold_m deve ser maior que zero, recebido %v", c.Coalescing.PositionThresholdM)
+		}
+	}
+	if c.Rollups.Enabled {
+		requirePositive(errs, "rollups.refresh_interval", c.Rollups.RefreshInterval)
+		if c.Rollups.MaxStaleness < c.Rollups.RefreshInterval {
+			errs.addf("rollups.max_staleness (%s) não pode ser menor que rollups.refresh_interval (%s)", c.Rollups.MaxStaleness, c.Rollups.RefreshInterval)
+		}
+	}
+	requirePositive(errs, "supervisor.initial_backoff", c.Supervisor.InitialBackoff)
+	if c.Supervisor.MaxBackoff < c.Supervisor.InitialBackoff {
+		errs.addf("supervisor.max_backoff (%s) não pode ser menor que supervisor.initial_backoff (%s)", c.Supervisor.MaxBackoff, c.Supervisor.InitialBackoff)
+	}
+	requirePositiveInt(errs, "twins.max_document_bytes", c.Twins.MaxDocumentBytes)
+	requirePositiveInt(errs, "twins.max_depth", c.Twins.MaxDepth)
+	requirePositiveInt(errs, "revisions.max_per_agent", c.Revisions.MaxPerAgent)
+	requirePositiveInt(errs, "resets.max_agents", c.Resets.MaxAgents)
+	requirePositive(errs, "resets.quiesce_timeout", c.Resets.QuiesceTimeout)
+	if c.Anomalies.HealthPenalty < 0 || c.Anomalies.HealthPenalty > 100 {
+		errs.addf("anomalies.health_penalty deve estar entre 0 e 100, recebido %v", c.Anomalies.HealthPenalty)
+	}
+	requireString(errs, "transfers.pause_status", c.Transfers.PauseStatus)
+	for _, s := range c.Transfers.RunningStatuses {
+		if s == c.Transfers.PauseStatus {
+			errs.addf("transfers.pause_status (%q) não pode estar em transfers.running_statuses", s)
+		}
+	}
+	if c.Transfers.DefaultMaxAgents < 0 {
+		errs.addf("transfers.default_max_agents não pode ser negativo, recebido %d", c.Transfers.DefaultMaxAgents)
+	}
+	requirePositive(errs, "transfers.project_expiry", c.Transfers.ProjectExpiry)
+	if c.Proximity.Enabled {
+		requirePositiveInt(errs, "proximity.max_events_per_tick", c.Proximity.MaxEventsPerTick)
+	}
+	requirePositive(errs, "consumption.bucket", c.Consumption.Bucket)
+	if b := c.Consumption.Bucket; b > 0 && (b%time.Second != 0 || (24*time.Hour)%b != 0) {
+		errs.addf("consumption.bucket deve ser de segundos inteiros e dividir 24h, recebido %s", b)
+	}
+	if c.Consumption.Enabled {
+		requirePositive(errs, "consumption.flush_interval", c.Consumption.FlushInterval)
+	}
+	requirePositive(errs, "consumption.default_bucket", c.Consumption.DefaultBucket)
+	if b, d := c.Consumption.Bucket, c.Consumption.DefaultBucket; b > 0 && d%b != 0 {
+		errs.addf("consumption.default_bucket (%s) deve ser múltiplo de consumption.bucket (%s)", d, b)
+	}
+	requirePositiveInt(errs, "consumption.max_buckets", c.Consumption.MaxBuckets)
+
+	if c.MQTT.Enabled {
+		if len(c.MQTT.Brokers) == 0 {
+			errs.addf("mqtt.brokers deve ter ao menos um broker")
+		}
+		if c.MQTT.QoS < 0 || c.MQTT.QoS > 2 {
+			errs.addf("mqtt.qos inválido %d (use 0, 1 ou 2)", c.MQTT.QoS)
+		}
+		if strings.Count(c.MQTT.TelemetryTopic, "+") != 1 || strings.Contains(c.MQTT.TelemetryTopic, "#") {
+			errs.addf("mqtt.telemetry_topic %q deve ter exatamente um curinga + (o id do dispositivo) e nenhum #", c.MQTT.TelemetryTopic)
+		}
+		if !strings.Contains(c.MQTT.CommandTopic, "{agent_id}") {
+			errs.addf("mqtt.command_topic %q deve conter {agent_id}", c.MQTT.CommandTopic)
+		}
+		requirePositiveInt(errs, "mqtt.workers", c.MQTT.Workers)
+		requirePositive(errs, "mqtt.connect_timeout", c.MQTT.ConnectTimeout)
+		requireString(errs, "mqtt.dead_letter.stream", c.MQTT.DeadLetter.Stream)
+		if c.MQTT.Reconnect.Enabled {
+			requirePositive(errs, "mqtt.reconnect.retry_interval", c.MQTT.Reconnect.RetryInterval)
+			requirePositive(errs, "mqtt.reconnect.max_interval", c.MQTT.Reconnect.MaxInterval)
+		}
+		if c.MQTT.TLS.Enabled && c.MQTT.TLS.CAFile != "" {
+			requireFile(errs, "mqtt.tls.ca_file", c.MQTT.TLS.CAFile)
+		}
+	}
+
+	if c.EventExport.Enabled {
+		e := c.EventExport
+		requireEnum(errs, "event_export.sink", e.Sink, "kafka", "nats")
+		if len(e.Topics) == 0 {
+			errs.addf("event_export.topics deve ter ao menos um tópico")
+		}
+		requireString(errs, "event_export.subject_template", e.SubjectTemplate)
+		for i, r := range e.Routes {
+			if r.EventType == "" || r.Subject == "" {
+				errs.addf("event_export.routes[%d] precisa de event_type e subject", i)
+			}
+		}
+		requirePins(errs, "event_export.versions", e.Versions)
+		requirePositiveInt(errs, "event_export.batch_size", e.BatchSize)
+		requirePositive(errs, "event_export.batch_timeout", e.BatchTimeout)
+		requirePositive(errs, "event_export.retry_backoff", e.RetryBackoff)
+		if e.MaxBackoff < e.RetryBackoff {
+			errs.addf("event_export.max_backoff (%s) deve ser maior ou igual a event_export.retry_backoff (%s)", e.MaxBackoff, e.RetryBackoff)
+		}
+		requireString(errs, "event_export.outbox.stream", e.Outbox.Stream)
+		requireString(errs, "event_export.outbox.group", e.Outbox.Group)
+		requirePositiveInt(errs, "event_export.outbox.buffer_size", e.Outbox.BufferSize)
+		requirePositive(errs, "event_export.outbox.claim_idle", e.Outbox.ClaimIdle)
+		switch e.Sink {
+		case "kafka":
+			if len(e.Kafka.Brokers) == 0 {
+				errs.addf("event_export.kafka.brokers deve ter ao menos um broker")
+			}
+			requireEnum(errs, "event_export.kafka.required_acks", e.Kafka.RequiredAcks, "all", "one", "none")
+			requireEnum(errs, "event_export.kafka.compression", e.Kafka.Compression, "none", "gzip", "snappy", "lz4", "zstd")
+			requirePositive(errs, "event_export.kafka.write_timeout", e.Kafka.WriteTimeout)
+			if e.Kafka.TLS.Enabled && e.Kafka.TLS.CAFile != "" {
+				requireFile(errs, "event_export.kafka.tls.ca_file", e.Kafka.TLS.CAFile)
+			}
+		case "nats":
+			requireString(errs, "event_export.nats.url", e.NATS.URL)
+			requirePositive(errs, "event_export.nats.ack_timeout", e.NATS.AckTimeout)
+			if e.NATS.CredentialsFile != "" {
+				requireFile(errs, "event_export.nats.credentials_file", e.NATS.CredentialsFile)
+			}
+			if e.NATS.TLS.Enabled && e.NATS.TLS.CAFile != "" {
+				requireFile(errs, "event_export.nats.tls.ca_file", e.NATS.TLS.CAFile)
+			}
+		}
+	}
+
+	requireEnum(errs, "storage.backend", c.Storage.Backend, "filesystem", "s3")
+	if c.Storage.PresignThreshold < 0 {
+		errs.addf("storage.presign_threshold não pode ser negativo, recebido %d", c.Storage.PresignThreshold)
+	}
+	requirePositive(errs, "storage.presign_expiry", c.Storage.PresignExpiry)
+	// URLs assinadas do S3 valem no máximo sete dias.
+	if c.Storage.PresignExpiry > 7*24*time.Hour {
+		errs.addf("storage.presign_expiry (%s) deve ser no máximo 168h", c.Storage.PresignExpiry)
+	}
+	switch c.Storage.Backend {
+	case "filesystem":
+		requireString(errs, "storage.filesystem.root", c.Storage.Filesystem.Root)
+		requireString(errs, "storage.filesystem.public_url", c.Storage.Filesystem.PublicURL)
+	case "s3":
+		s3 := c.Storage.S3
+		requireString(errs, "storage.s3.endpoint", s3.Endpoint)
+		if strings.Contains(s3.Endpoint, "://") {
+			errs.addf("storage.s3.endpoint %q deve ser host[:porta], sem esquema (use storage.s3.tls.enabled para HTTPS)", s3.Endpoint)
+		}
+		requireString(errs, "storage.s3.bucket", s3.Bucket)
+		requireString(errs, "storage.s3.access_key", s3.AccessKey)
+		if s3.SecretKey == "" && s3.SecretKeyFile == "" {
+			errs.addf("storage.s3.secret_key ou storage.s3.secret_key_file é obrigatório com storage.backend=s3")
+		}
+		if s3.TLS.Enabled && s3.TLS.CAFile != "" {
+			requireFile(errs, "storage.s3.tls.ca_file", s3.TLS.CAFile)
+		}
+	}
+
+	if c.Debug.Enabled {
+		requirePort(errs, "debug.port", c.Debug.Port)
+	}
+
+	if _, err := logrus.ParseLevel(c.Log.Level); err != nil {
+		errs.addf("log.level inválido %q (use trace, debug, info, warn, error)", c.Log.Level)
+	}
+	requireEnum(errs, "log.format", c.Log.Format, "json", "text")
+	requireEnum(errs, "gin.mode", c.Gin.Mode, "debug", "release", "test")
+
+	requirePositive(errs, "observability.slow_query_threshold", c.Observability.SlowQueryThreshold)
+	requirePositive(errs, "observability.slow_request_threshold", c.Observability.SlowRequestThreshold)
+	requirePositive(errs, "health.check_interval", c.Health.CheckInterval)
+	requirePositive(errs, "registry.heartbeat_interval", c.Registry.HeartbeatInterval)
+	if c.Registry.TTL <= c.Registry.HeartbeatInterval {
+		errs.addf("registry.ttl (%s) deve ser maior que registry.heartbeat_interval (%s)", c.Registry.TTL, c.Registry.HeartbeatInterval)
+	}
+}
+
+func requireString(errs *problems, key, value string) {
+	if strings.TrimSpace(value) == "" {
+		errs.addf("%s é obrigatório", key)
+	}
+}
+
+func requireFile(errs *problems, key, path string) {
+	if strings.TrimSpace(path) == "" {
+		errs.addf("%s é obrigatório", key)
+		return
+	}
+	if _, err := os.Stat(path); err != nil {
+		errs.addf("%s: arquivo %q inacessível: %v", key, path, err)
+	}
+}
+
+func requirePort(errs *problems, key, value string) {
+	port, err := strconv.Atoi(value)
+	if err != nil {
+		errs.addf("%s deve ser numérico, recebido %q", key, value)
+		return
+	}
+	requirePortInt(errs, key, port)
+}
+
+func requirePortInt(errs *problems, key string, port int) {
+	if port < 1 || port > 65535 {
+		errs.addf("%s fora da faixa 1-65535: %d", key, port)
+	}
+}
+
+func requireEnum(errs *problems, key, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	errs.addf("%s inválido %q (valores aceitos: %s)", key, value, strings.Join(allowed, ", "))
+}
+
+func requirePositive(errs *problems, key string, d time.Duration) {
+	if d <= 0 {
+		errs.addf("%s deve ser uma duração positiva, recebido %s", key, d)
+	}
+}
+
+func requireNonNegative(errs *problems, key string, d time.Duration) {
+	if d < 0 {
+		errs.addf("%s não pode ser negativo, recebido %s", key, d)
+	}
+}
+
+func requirePositiveInt(errs *problems, key string, n int) {
+	if n <= 0 {
+		errs.addf("%s deve ser maior que zero, recebido %d", key, n)
+	}
+}
+
+func requirePins(errs *problems, key string, list []string) {
+	if _, err := events.Schemas.ParsePins(list); err != nil {
+		errs.addf("%s: %v", key, err)
+	}
+}
+
+// knownKeys lista as chaves achatadas aceitas pelo Config.
+func knownKeys(t reflect.Type, prefix string) map[string]struct{} {
+	keys := map[string]struct{}{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := f.Tag.Get("mapstructure")
+		if name == "" || name == "-" {
+			continue
+		}
+		key := prefix + name
+		if f.Type.Kind() == reflect.Struct && f.Type != reflect.TypeOf(time.Duration(0)) {
+			for k := range knownKeys(f.Type, key+".") {
+				keys[k] = struct{}{}
+			}
+			continue
+		}
+		keys[key] = struct{}{}
+	}
+	return keys
+}
+
+// closestKey sugere a chave conhecida mais próxima (distância de edição <= 2).
+func closestKey(key string, known map[string]struct{}) string {
+	best, bestDist := "", 3
+	for k := range known {
+		if d := levenshtein(key, k); d < bestDist || (d == bestDist && k < best) {
+			best, bestDist = k, d
+		}
+	}
+	return best
+}
+
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,112 @@
+package conformance
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+
+	"smart-city-microservices/pkg/client"
+)
+
+// GoClient é o cliente de referência: PositionStream de pkg/client.
+type GoClient struct{}
+
+// Run implementa Client.
+func (GoClient) Run(ctx context.Context, t Target, report func(View)) error {
+	c, err := client.NewClient(t.BaseURL, client.Options{})
+	if err != nil {
+		return err
+	}
+	st, err := c.Simulations.Positions(ctx, t.SimulationID, client.PositionsOptions{MaxReconnectWait: 500 * time.Millisecond})
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+	for {
+		if _, err := st.Next(); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		view := st.View()
+		v := View{Seq: st.Seq(), Agents: make([]Agent, 0, len(view))}
+		for _, p := range view {
+			v.Agents = append(v.Agents, Agent(p))
+		}
+		report(v)
+	}
+}
+
+// Variáveis de ambiente com o alvo entregue a Command.
+const (
+	EnvURL          = "CONFORMANCE_URL"
+	EnvBaseURL      = "CONFORMANCE_BASE_URL"
+	EnvSimulationID = "CONFORMANCE_SIMULATION_ID"
+)
+
+// Command roda um cliente externo a cada cenário. O programa recebe o alvo
+// em CONFORMANCE_URL (o websocket), CONFORMANCE_BASE_URL e
+// CONFORMANCE_SIMULATION_ID, e escreve no stdout uma linha JSON por quadro
+// aplicado com a visão inteira, no formato de View:
+//
+//	{"seq":42,"agents":[{"id":"agent-001","status":"active","lat":-23.54,"lon":-46.64,"heading":45,"speed":1.5}]}
+//
+// O programa é encerrado ao fim do cenário; sair antes disso reprova o
+// cenário.
+type Command struct {
+	Path string
+	Args []string
+	// Stderr recebe o stderr do programa; nil descarta.
+	Stderr io.Writer
+}
+
+// Run implementa Client.
+func (c Command) Run(ctx context.Context, t Target, report func(View)) error {
+	cmd := exec.CommandContext(ctx, c.Path, c.Args...)
+	cmd.Env = append(os.Environ(),
+		EnvURL+"="+t.URL,
+		EnvBaseURL+"="+t.BaseURL,
+		EnvSimulationID+"="+t.SimulationID,
+	)
+	cmd.Stderr = c.Stderr
+	cmd.WaitDelay = time.Second
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+	var decodeErr error
+	for scanner.Scan() {
+		var v View
+		if err := json.Unmarshal(scanner.Bytes(), &v); err != nil {
+			decodeErr = fmt.Errorf("linha inválida no stdout do cliente: %w", err)
+			break
+		}
+		report(v)
+	}
+	if decodeErr != nil {
+		_ = cmd.Process.Kill()
+	}
+	err = cmd.Wait()
+	if ctx.Err() != nil {
+		return nil
+	}
+	if decodeErr != nil {
+		return decodeErr
+	}
+	if err != nil {
+		return err
+	}
+	return errors.New("o cliente saiu antes do fim do cenário")
+}
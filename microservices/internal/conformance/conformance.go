@@ -0,0 +1,147 @@
+// Package conformance verifica clientes do streaming de posições
+// (/api/v1/simulations/:id/positions/stream, ver internal/positions) contra
+// o servidor real. Cada cenário sobe um positions.Streamer numa porta
+// local, com uma simulação em memória, atrás de um proxy websocket que
+// perde quadros, derruba conexões ou se passa por um servidor antigo, e
+// roda uma sequência de mudanças nos agentes. O cliente passa no cenário
+// se a visão que ele reporta converge para os agentes da simulação no
+// prazo e se ele seguiu o protocolo no caminho: pediu resync no buraco de
+// sequência, reconectou depois da queda, aceitou o snapshot depois do
+// descarte por lentidão e falou a versão 1 com o servidor antigo.
+//
+// O cliente é qualquer implementação de Client. Command roda um programa
+// externo (o cliente de outra equipe num runtime qualquer) que recebe o
+// alvo em variáveis de ambiente e escreve a visão em linhas JSON; GoClient
+// é a referência, em pkg/client.
+package conformance
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// DefaultTimeout é o prazo padrão de cada convergência de um cenário.
+const DefaultTimeout = 5 * time.Second
+
+// Target é o alvo entregue ao cliente num cenário.
+type Target struct {
+	// BaseURL é a URL base do serviço, ex.: http://127.0.0.1:4123.
+	BaseURL string
+	// URL é o websocket do streaming de posições da simulação.
+	URL          string
+	SimulationID string
+}
+
+// Agent é um agente na visão do cliente.
+type Agent struct {
+	ID      string  `json:"id"`
+	Status  string  `json:"status"`
+	Lat     float64 `json:"lat"`
+	Lon     float64 `json:"lon"`
+	Heading float64 `json:"heading"`
+	Speed   float64 `json:"speed"`
+}
+
+// View é a visão do cliente depois de aplicar um quadro: a sequência do
+// quadro e todos os agentes conhecidos.
+type View struct {
+	Seq    uint64  `json:"seq"`
+	Agents []Agent `json:"agents"`
+}
+
+// Client é uma implementação do protocolo sob teste.
+type Client interface {
+	// Run conecta ao streaming de t e chama report com a visão a cada
+	// quadro aplicado, até ctx ser cancelado. Retorna nil no
+	// cancelamento; um erro antes dele reprova o cenário.
+	Run(ctx context.Context, t Target, report func(View)) error
+}
+
+// Options configura Run.
+type Options struct {
+	// Scenarios são os nomes dos cenários a rodar; vazio roda todos.
+	Scenarios []string
+	// Timeout é o prazo de cada convergência; zero usa DefaultTimeout.
+	Timeout time.Duration
+}
+
+// Run roda os cenários contra client e retorna o relatório. O erro é só
+// para opções inválidas; as falhas do cliente ficam no relatório.
+func Run(ctx context.Context, client Client, opts Options) (*Report, error) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	selected := scenarios
+	if len(opts.Scenarios) > 0 {
+		selected = nil
+		for _, name := range opts.Scenarios {
+			sc, ok := scenarioByName(name)
+			if !ok {
+				return nil, fmt.Errorf("cenário desconhecido %q (disponíveis: %v)", name, Scenarios())
+			}
+			selected = append(selected, sc)
+		}
+	}
+	report := &Report{}
+	for _, sc := range selected {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		report.Results = append(report.Results, runScenario(ctx, client, sc, opts.Timeout))
+	}
+	return report, nil
+}
+
+// Scenarios são os nomes dos cenários, na ordem em que rodam.
+func Scenarios() []string {
+	names := make([]string, len(scenarios))
+	for i, sc := range scenarios {
+		names[i] = sc.name
+	}
+	return names
+}
+
+func scenarioByName(name string) (scenario, bool) {
+	for _, sc := range scenarios {
+		if sc.name == name {
+			return sc, true
+		}
+	}
+	return scenario{}, false
+}
+
+// runScenario sobe o servidor do cenário, roda o cliente e o roteiro, e
+// derruba tudo ao fim.
+func runScenario(ctx context.Context, client Client, sc scenario, timeout time.Duration) Result {
+	start := time.Now()
+	result := Result{Scenario: sc.name}
+	h, err := newHarness(ctx, timeout)
+	if err != nil {
+		result.Error = "subir o servidor: " + err.Error()
+		return result
+	}
+	defer h.close()
+	if sc.setup != nil {
+		sc.setup(h)
+	}
+
+	stop := h.start(ctx, client)
+	err = sc.run(h)
+	stop()
+	result.Duration = time.Since(start)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Passed = true
+	return result
+}
+
+// sortedAgents ordena os agentes por id, para comparar visões.
+func sortedAgents(agents []Agent) []Agent {
+	out := append([]Agent(nil), agents...)
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
@@ -0,0 +1,250 @@
+package conformance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"smart-city-microservices/internal/positions"
+)
+
+// Configuração do Streamer dos cenários: todo movimento entra nos deltas,
+// o flush é rápido para os cenários durarem pouco, o keyframe periódico
+// não interfere (a recuperação tem de vir do cliente) e a fila de cada
+// inscrito é curta, para o cenário de lentidão estourá-la.
+var streamConfig = positions.Config{
+	FlushInterval:    20 * time.Millisecond,
+	KeyframeInterval: time.Hour,
+	QueueSize:        1024,
+	SendBuffer:       4,
+}
+
+// worldSize é o número de agentes no início de cada cenário.
+const worldSize = 20
+
+// harness é o ambiente de um cenário: a simulação, o servidor real atrás
+// de um gate e o proxy a que o cliente se conecta.
+type harness struct {
+	timeout time.Duration
+	world   *world
+	gate    *gate
+	proxy   *proxy
+
+	cancel context.CancelFunc
+	server *http.Server
+
+	mu         sync.Mutex
+	view       *View
+	viewChange chan struct{}
+	clientErr  error
+	clientDone chan struct{}
+}
+
+func newHarness(ctx context.Context, timeout time.Duration) (*harness, error) {
+	w := newWorld(worldSize)
+	streamer := positions.NewStreamer(w, streamConfig)
+	w.publish = streamer.Handle
+
+	router := gin.New()
+	router.GET("/api/v1/simulations/:id/positions/stream", streamer.Stream)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	g := &gate{}
+	server := &http.Server{Handler: router, ReadHeaderTimeout: 5 * time.Second}
+	go server.Serve(&gatedListener{Listener: ln, gate: g})
+
+	p, err := newProxy("ws://" + ln.Addr().String())
+	if err != nil {
+		server.Close()
+		return nil, err
+	}
+	runCtx, cancel := context.WithCancel(ctx)
+	go streamer.Run(runCtx)
+	return &harness{
+		timeout:    timeout,
+		world:      w,
+		gate:       g,
+		proxy:      p,
+		cancel:     cancel,
+		server:     server,
+		viewChange: make(chan struct{}),
+	}, nil
+}
+
+func (h *harness) close() {
+	h.gate.resume()
+	h.proxy.close()
+	h.cancel()
+	h.server.Close()
+}
+
+func (h *harness) target() Target {
+	return Target{
+		BaseURL:      "http://" + h.proxy.addr(),
+		URL:          streamURL(h.proxy.addr()),
+		SimulationID: simulationID,
+	}
+}
+
+// start roda o cliente até a função retornada ser chamada.
+func (h *harness) start(ctx context.Context, client Client) (stop func()) {
+	clientCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	h.clientDone = done
+	go func() {
+		err := client.Run(clientCtx, h.target(), h.report)
+		h.mu.Lock()
+		h.clientErr = err
+		h.mu.Unlock()
+		close(done)
+	}()
+	return func() {
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(h.timeout):
+		}
+	}
+}
+
+// report guarda a visão reportada pelo cliente e acorda quem espera.
+func (h *harness) report(v View) {
+	v.Agents = sortedAgents(v.Agents)
+	h.mu.Lock()
+	h.view = &v
+	close(h.viewChange)
+	h.viewChange = make(chan struct{})
+	h.mu.Unlock()
+}
+
+// converge espera a visão do cliente igualar os agentes da simulação;
+// step descreve o momento do cenário nas mensagens de erro.
+func (h *harness) converge(step string) error {
+	deadline := time.NewTimer(h.timeout)
+	defer deadline.Stop()
+	poll := time.NewTicker(streamConfig.FlushInterval)
+	defer poll.Stop()
+	for {
+		want := h.world.truth()
+		h.mu.Lock()
+		view, changed := h.view, h.viewChange
+		h.mu.Unlock()
+		mismatch := compare(view, want)
+		if mismatch == "" {
+			return nil
+		}
+		select {
+		case <-h.clientDone:
+			h.mu.Lock()
+			err := h.clientErr
+			h.mu.Unlock()
+			if err == nil {
+				err = errors.New("sem erro")
+			}
+			return fmt.Errorf("%s: o cliente terminou antes de convergir: %w", step, err)
+		case <-deadline.C:
+			return fmt.Errorf("%s: a visão não convergiu em %s: %s", step, h.timeout, mismatch)
+		case <-changed:
+		case <-poll.C:
+		}
+	}
+}
+
+// compare descreve a primeira diferença entre a visão e os agentes
+// esperados; vazio se são iguais.
+func compare(view *View, want []Agent) string {
+	if view == nil {
+		return "nenhuma visão reportada"
+	}
+	got := view.Agents
+	byID := make(map[string]Agent, len(got))
+	for _, a := range got {
+		byID[a.ID] = a
+	}
+	for _, w := range want {
+		g, ok := byID[w.ID]
+		if !ok {
+			return fmt.Sprintf("seq %d: falta o agente %s (%d agentes, esperados %d)", view.Seq, w.ID, len(got), len(want))
+		}
+		if g != w {
+			return fmt.Sprintf("seq %d: agente %s é %+v, esperado %+v", view.Seq, w.ID, g, w)
+		}
+		delete(byID, w.ID)
+	}
+	for id := range byID {
+		return fmt.Sprintf("seq %d: o agente %s foi removido e continua na visão", view.Seq, id)
+	}
+	return ""
+}
+
+// sleepFlushes espera n intervalos de flush, o bastante para o servidor
+// mandar os deltas das mudanças até aqui.
+func (h *harness) sleepFlushes(n int) {
+	time.Sleep(time.Duration(n) * streamConfig.FlushInterval)
+}
+
+// gate segura as escritas do servidor enquanto parado, como uma rede
+// lenta ou um cliente que não lê.
+type gate struct {
+	mu      sync.Mutex
+	release chan struct{}
+}
+
+func (g *gate) stall() {
+	g.mu.Lock()
+	if g.release == nil {
+		g.release = make(chan struct{})
+	}
+	g.mu.Unlock()
+}
+
+func (g *gate) resume() {
+	g.mu.Lock()
+	if g.release != nil {
+		close(g.release)
+		g.release = nil
+	}
+	g.mu.Unlock()
+}
+
+func (g *gate) wait() {
+	g.mu.Lock()
+	release := g.release
+	g.mu.Unlock()
+	if release != nil {
+		<-release
+	}
+}
+
+// gatedListener entrega conexões cujas escritas passam pelo gate. O
+// websocket do servidor escreve na conexão sequestrada, que é esta.
+type gatedListener struct {
+	net.Listener
+	gate *gate
+}
+
+func (l *gatedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &gatedConn{Conn: conn, gate: l.gate}, nil
+}
+
+type gatedConn struct {
+	net.Conn
+	gate *gate
+}
+
+func (c *gatedConn) Write(b []byte) (int, error) {
+	c.gate.wait()
+	return c.Conn.Write(b)
+}
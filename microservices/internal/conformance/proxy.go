@@ -0,0 +1,319 @@
+package conformance
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// serverMode é o servidor que o proxy imita diante do cliente.
+type serverMode int
+
+const (
+	// modeCurrent repassa tudo: o cliente fala com o servidor atual.
+	modeCurrent serverMode = iota
+	// modeV1 imita um servidor que negocia mas só suporta a versão 1:
+	// recusa ?protocol=2 e hellos sem a 1 com o fechamento 1002 e, nos
+	// demais, negocia a 1 com o servidor real.
+	modeV1
+	// modeLegacy imita um servidor anterior à negociação: ignora o hello e
+	// ?protocol, e tudo segue na versão 1.
+	modeLegacy
+)
+
+const closeWait = time.Second
+
+var proxyUpgrader = websocket.Upgrader{
+	CheckOrigin: func(*http.Request) bool { return true },
+}
+
+// frameInfo é um quadro entregue ao cliente: o tipo, a versão da forma (v
+// nos quadros da 2) e a versão anunciada, nos welcomes.
+type frameInfo struct {
+	Type    string `json:"type"`
+	V       int    `json:"v"`
+	Version int    `json:"version"`
+}
+
+// connRecord é o que o proxy viu numa conexão do cliente.
+type connRecord struct {
+	// Sent são os tipos das mensagens de controle do cliente (hello,
+	// resync, subscribe).
+	Sent []string
+	// Frames são os quadros entregues ao cliente, na ordem.
+	Frames []frameInfo
+	// Dropped são os deltas descartados pelo proxy.
+	Dropped int
+	// Rejected indica que o proxy recusou a versão do cliente.
+	Rejected bool
+}
+
+// proxy fica entre o cliente e o servidor real e aplica as falhas dos
+// cenários: deltas perdidos, quedas de conexão e servidores antigos.
+type proxy struct {
+	backend string
+	ln      net.Listener
+	server  *http.Server
+
+	mu         sync.Mutex
+	mode       serverMode
+	dropDeltas int
+	conns      []*proxyConn
+}
+
+// proxyConn é uma conexão do cliente e a sua contraparte no servidor.
+type proxyConn struct {
+	client *websocket.Conn
+	server *websocket.Conn
+
+	mu     sync.Mutex
+	record connRecord
+}
+
+func newProxy(backend string) (*proxy, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	p := &proxy{backend: backend, ln: ln}
+	p.server = &http.Server{Handler: p, ReadHeaderTimeout: 5 * time.Second}
+	go p.server.Serve(ln)
+	return p, nil
+}
+
+func (p *proxy) addr() string { return p.ln.Addr().String() }
+
+// setMode troca o servidor imitado nas próximas conexões.
+func (p *proxy) setMode(m serverMode) {
+	p.mu.Lock()
+	p.mode = m
+	p.mu.Unlock()
+}
+
+// dropNextDelta descarta o próximo delta a caminho do cliente, abrindo um
+// buraco na sequência.
+func (p *proxy) dropNextDelta() {
+	p.mu.Lock()
+	p.dropDeltas++
+	p.mu.Unlock()
+}
+
+func (p *proxy) takeDrop() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.dropDeltas == 0 {
+		return false
+	}
+	p.dropDeltas--
+	return true
+}
+
+// pendingDrops são os descartes pedidos que ainda não aconteceram.
+func (p *proxy) pendingDrops() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.dropDeltas
+}
+
+// disconnect derruba as conexões abertas sem o fechamento do websocket,
+// como uma queda de rede.
+func (p *proxy) disconnect() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, pc := range p.conns {
+		pc.client.UnderlyingConn().Close()
+		if pc.server != nil {
+			pc.server.Close()
+		}
+	}
+}
+
+func (p *proxy) close() {
+	p.disconnect()
+	p.server.Close()
+}
+
+// connections retorna o registro de cada conexão, na ordem de abertura.
+func (p *proxy) connections() []connRecord {
+	p.mu.Lock()
+	conns := append([]*proxyConn(nil), p.conns...)
+	p.mu.Unlock()
+	out := make([]connRecord, len(conns))
+	for i, pc := range conns {
+		pc.mu.Lock()
+		out[i] = connRecord{
+			Sent:     append([]string(nil), pc.record.Sent...),
+			Frames:   append([]frameInfo(nil), pc.record.Frames...),
+			Dropped:  pc.record.Dropped,
+			Rejected: pc.record.Rejected,
+		}
+		pc.mu.Unlock()
+	}
+	return out
+}
+
+func (p *proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	client, err := proxyUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	pc := &proxyConn{client: client}
+	p.mu.Lock()
+	mode := p.mode
+	p.conns = append(p.conns, pc)
+	p.mu.Unlock()
+
+	query := r.URL.Query()
+	switch mode {
+	case modeLegacy:
+		query.Del("protocol")
+		query.Del("features")
+	case modeV1:
+		if v := query.Get("protocol"); v != "" && v != "1" {
+			pc.reject(fmt.Sprintf("unsupported protocol version %q (supported versions 1-1)", v))
+			return
+		}
+	}
+	target := p.backend + r.URL.Path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	server, _, err := websocket.DefaultDialer.Dial(target, nil)
+	if err != nil {
+		_ = client.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "backend unavailable"), time.Now().Add(closeWait))
+		client.Close()
+		return
+	}
+	p.mu.Lock()
+	pc.server = server
+	p.mu.Unlock()
+	go p.toClient(pc, mode)
+	p.toServer(pc, mode)
+}
+
+// toClient repassa os quadros do servidor ao cliente.
+func (p *proxy) toClient(pc *proxyConn, mode serverMode) {
+	for {
+		messageType, msg, err := pc.server.ReadMessage()
+		if err != nil {
+			forwardClose(pc.client, err)
+			pc.client.Close()
+			return
+		}
+		info := frameInfo{Type: "binary"}
+		if messageType == websocket.TextMessage {
+			_ = json.Unmarshal(msg, &info)
+		}
+		if info.Type == "delta" && p.takeDrop() {
+			pc.mu.Lock()
+			pc.record.Dropped++
+			pc.mu.Unlock()
+			continue
+		}
+		if info.Type == "welcome" && mode == modeV1 {
+			msg = rewriteSupported(msg)
+		}
+		pc.mu.Lock()
+		pc.record.Frames = append(pc.record.Frames, info)
+		pc.mu.Unlock()
+		if err := pc.client.WriteMessage(messageType, msg); err != nil {
+			pc.server.Close()
+			return
+		}
+	}
+}
+
+// toServer repassa as mensagens de controle do cliente ao servidor,
+// tratando o hello conforme o servidor imitado.
+func (p *proxy) toServer(pc *proxyConn, mode serverMode) {
+	for {
+		messageType, msg, err := pc.client.ReadMessage()
+		if err != nil {
+			forwardClose(pc.server, err)
+			pc.server.Close()
+			return
+		}
+		var m struct {
+			Type     string   `json:"type"`
+			Action   string   `json:"action"`
+			Versions []int    `json:"versions"`
+			Features []string `json:"features"`
+		}
+		_ = json.Unmarshal(msg, &m)
+		kind := m.Type
+		if kind == "" {
+			kind = m.Action
+		}
+		pc.mu.Lock()
+		pc.record.Sent = append(pc.record.Sent, kind)
+		pc.mu.Unlock()
+		if m.Type == "hello" {
+			switch mode {
+			case modeLegacy:
+				continue
+			case modeV1:
+				if !slices.Contains(m.Versions, 1) {
+					pc.reject(fmt.Sprintf("no supported protocol version offered in %v (supported versions 1-1)", m.Versions))
+					pc.server.Close()
+					return
+				}
+				msg, _ = json.Marshal(map[string]interface{}{"type": "hello", "versions": []int{1}, "features": m.Features})
+			}
+		}
+		if err := pc.server.WriteMessage(messageType, msg); err != nil {
+			pc.client.Close()
+			return
+		}
+	}
+}
+
+// reject fecha a conexão do cliente com 1002, como o servidor faz com uma
+// versão que não suporta.
+func (pc *proxyConn) reject(reason string) {
+	pc.mu.Lock()
+	pc.record.Rejected = true
+	pc.mu.Unlock()
+	_ = pc.client.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseProtocolError, reason), time.Now().Add(closeWait))
+	pc.client.Close()
+}
+
+// forwardClose repassa o fechamento recebido de um lado ao outro; uma
+// queda sem fechamento continua sendo uma queda.
+func forwardClose(conn *websocket.Conn, err error) {
+	closeErr, ok := err.(*websocket.CloseError)
+	if !ok || closeErr.Code == websocket.CloseNoStatusReceived || closeErr.Code == websocket.CloseAbnormalClosure {
+		return
+	}
+	_ = conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(closeErr.Code, closeErr.Text), time.Now().Add(closeWait))
+}
+
+// rewriteSupported troca as versões suportadas do welcome pelas de um
+// servidor que só tem a 1.
+func rewriteSupported(msg []byte) []byte {
+	var welcome map[string]interface{}
+	if json.Unmarshal(msg, &welcome) != nil {
+		return msg
+	}
+	welcome["supported"] = []int{1, 1}
+	out, err := json.Marshal(welcome)
+	if err != nil {
+		return msg
+	}
+	return out
+}
+
+// streamURL é a URL do streaming de posições num proxy.
+func streamURL(addr string) string {
+	u := url.URL{Scheme: "ws", Host: addr, Path: "/api/v1/simulations/" + simulationID + "/positions/stream"}
+	return u.String()
+}
@@ -0,0 +1,50 @@
+package conformance
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// Result é o resultado de um cenário; Error explica a reprovação.
+type Result struct {
+	Scenario string
+	Passed   bool
+	Duration time.Duration
+	Error    string
+}
+
+// Report é o resultado de uma execução, um Result por cenário.
+type Report struct {
+	Results []Result
+}
+
+// Failed é o número de cenários reprovados.
+func (r *Report) Failed() int {
+	n := 0
+	for _, res := range r.Results {
+		if !res.Passed {
+			n++
+		}
+	}
+	return n
+}
+
+// Write escreve o relatório em colunas.
+func (r *Report) Write(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "cenário\tresultado\tduração\tdetalhe\n")
+	for _, res := range r.Results {
+		status := "ok"
+		if !res.Passed {
+			status = "FALHOU"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", res.Scenario, status, res.Duration.Round(time.Millisecond), res.Error)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "\n%d de %d cenários passaram\n", len(r.Results)-r.Failed(), len(r.Results))
+	return nil
+}
@@ -0,0 +1,188 @@
+package conformance
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// scenario é um roteiro contra o cliente. setup roda antes do cliente
+// conectar; run dirige a simulação e o proxy e verifica o cliente.
+type scenario struct {
+	name  string
+	setup func(*harness)
+	run   func(*harness) error
+}
+
+var scenarios = []scenario{
+	{name: "initial-snapshot", run: runInitialSnapshot},
+	{name: "deltas", run: runDeltas},
+	{name: "gap-resync", run: runGapResync},
+	{name: "disconnect-reconnect", run: runDisconnect},
+	{name: "slow-consumer", run: runSlowConsumer},
+	{name: "protocol-downgrade", setup: func(h *harness) { h.proxy.setMode(modeV1) }, run: runOldServer},
+	{name: "legacy-server", setup: func(h *harness) { h.proxy.setMode(modeLegacy) }, run: runOldServer},
+}
+
+// maxDropAttempts limita as mudanças feitas à espera do delta descartado.
+const maxDropAttempts = 50
+
+// runInitialSnapshot: o cliente monta a visão pelo snapshot da conexão.
+func runInitialSnapshot(h *harness) error {
+	return h.converge("snapshot inicial")
+}
+
+// runDeltas: movimentos, criações e remoções chegam por deltas, aplicados
+// em sequência sem resync nem reconexão.
+func runDeltas(h *harness) error {
+	if err := h.converge("snapshot inicial"); err != nil {
+		return err
+	}
+	for round := 1; round <= 3; round++ {
+		h.world.move(5)
+		h.world.add()
+		h.world.remove()
+		if err := h.converge(fmt.Sprintf("rodada %d de deltas", round)); err != nil {
+			return err
+		}
+	}
+	conns := h.proxy.connections()
+	if len(conns) != 1 {
+		return fmt.Errorf("o cliente abriu %d conexões sem nenhuma queda", len(conns))
+	}
+	if countFrames(conns[0], "delta") == 0 {
+		return errors.New("o servidor não mandou deltas; o cenário não exercitou o cliente")
+	}
+	if slices.Contains(conns[0].Sent, "resync") {
+		return errors.New("o cliente pediu resync sem buraco na sequência")
+	}
+	return nil
+}
+
+// runGapResync: o proxy perde um delta; o cliente tem de perceber o buraco
+// no delta seguinte, pedir {"type":"resync"} na mesma conexão e
+// reconstruir a visão pelo snapshot.
+func runGapResync(h *harness) error {
+	if err := h.converge("snapshot inicial"); err != nil {
+		return err
+	}
+	h.proxy.dropNextDelta()
+	for i := 0; h.proxy.pendingDrops() > 0; i++ {
+		if i == maxDropAttempts {
+			return errors.New("o servidor não mandou o delta a descartar")
+		}
+		h.world.move(2)
+		h.sleepFlushes(2)
+	}
+	// O próximo delta chega com o buraco e toca outros agentes: só o
+	// snapshot corrige os do delta perdido.
+	h.world.move(3)
+	if err := h.converge("depois do delta perdido"); err != nil {
+		return err
+	}
+	conns := h.proxy.connections()
+	if len(conns) != 1 {
+		return fmt.Errorf("o cliente reconectou (%d conexões) em vez de pedir resync", len(conns))
+	}
+	if !slices.Contains(conns[0].Sent, "resync") {
+		return errors.New("o cliente não pediu resync depois do buraco na sequência")
+	}
+	return nil
+}
+
+// runDisconnect: a conexão cai sem fechamento enquanto a simulação muda;
+// o cliente reconecta, parte do snapshot novo e segue com os deltas.
+func runDisconnect(h *harness) error {
+	if err := h.converge("snapshot inicial"); err != nil {
+		return err
+	}
+	h.proxy.disconnect()
+	h.world.move(5)
+	h.world.add()
+	h.world.remove()
+	if err := h.converge("depois da queda da conexão"); err != nil {
+		return err
+	}
+	h.world.move(3)
+	if err := h.converge("deltas depois da reconexão"); err != nil {
+		return err
+	}
+	if n := len(h.proxy.connections()); n < 2 {
+		return fmt.Errorf("o cliente não reconectou (%d conexão)", n)
+	}
+	return nil
+}
+
+// runSlowConsumer: as escritas do servidor param até a fila do inscrito
+// estourar; o servidor descarta os quadros e, quando a escrita volta,
+// manda um snapshot depois dos deltas enfileirados. O cliente tem de
+// trocar a visão pelo snapshot sem tratar o salto de sequência como erro.
+func runSlowConsumer(h *harness) error {
+	if err := h.converge("snapshot inicial"); err != nil {
+		return err
+	}
+	h.gate.stall()
+	for i := 0; i < streamConfig.SendBuffer+4; i++ {
+		h.world.move(2)
+		h.sleepFlushes(2)
+	}
+	h.gate.resume()
+	if err := h.converge("depois do descarte por lentidão"); err != nil {
+		return err
+	}
+	h.world.move(3)
+	if err := h.converge("deltas depois do descarte"); err != nil {
+		return err
+	}
+	conns := h.proxy.connections()
+	snapshots := 0
+	for _, c := range conns {
+		snapshots += countFrames(c, "snapshot")
+	}
+	if snapshots < 2 {
+		return errors.New("o servidor não descartou quadros; o cenário não exercitou o cliente")
+	}
+	return nil
+}
+
+// runOldServer: o proxy se passa por um servidor antigo (setup); o
+// cliente tem de cair para a versão 1 e ficar nela, sem reconectar em
+// laço.
+func runOldServer(h *harness) error {
+	if err := h.converge("snapshot inicial"); err != nil {
+		return err
+	}
+	h.world.move(5)
+	h.world.add()
+	h.world.remove()
+	if err := h.converge("deltas na versão 1"); err != nil {
+		return err
+	}
+	before := h.proxy.connections()
+	h.sleepFlushes(10)
+	after := h.proxy.connections()
+	if len(after) != len(before) {
+		return fmt.Errorf("o cliente continua reconectando (%d conexões)", len(after))
+	}
+	if last := after[len(after)-1]; last.Rejected {
+		return errors.New("a última conexão foi recusada pela versão")
+	}
+	for _, c := range after {
+		for _, f := range c.Frames {
+			if f.V > 1 || (f.Type == "welcome" && f.Version > 1) {
+				return errors.New("o servidor antigo entregou um quadro da versão 2")
+			}
+		}
+	}
+	return nil
+}
+
+func countFrames(c connRecord, kind string) int {
+	n := 0
+	for _, f := range c.Frames {
+		if f.Type == kind {
+			n++
+		}
+	}
+	return n
+}
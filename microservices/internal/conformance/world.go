@@ -0,0 +1,176 @@
+package conformance
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/events"
+)
+
+// simulationID é a simulação de todos os cenários.
+const simulationID = "conformance"
+
+// world é a simulação em memória de um cenário. Faz o papel de
+// agent.Service para o Streamer e publica nele os eventos de cada
+// mudança, como o barramento faria.
+type world struct {
+	mu     sync.Mutex
+	agents map[string]agent.Agent
+	// step torna as mudanças determinísticas entre execuções.
+	step    int
+	nextID  int
+	publish func(context.Context, events.Event)
+}
+
+func newWorld(size int) *world {
+	w := &world{agents: map[string]agent.Agent{}, publish: func(context.Context, events.Event) {}}
+	for i := 0; i < size; i++ {
+		w.addLocked()
+	}
+	return w
+}
+
+// ListAgents pagina os agentes da simulação por id, como o serviço.
+func (w *world) ListAgents(_ context.Context, f agent.Filter) ([]agent.Agent, int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var all []agent.Agent
+	for _, a := range w.agents {
+		if f.SimulationID == "" || a.SimulationID == f.SimulationID {
+			all = append(all, a)
+		}
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].ID < all[j].ID })
+	total := len(all)
+	if f.PageSize > 0 {
+		from := min(max(f.Page-1, 0)*f.PageSize, total)
+		all = all[from:min(from+f.PageSize, total)]
+	}
+	return all, total, nil
+}
+
+// GetSimulation só conhece a simulação dos cenários.
+func (w *world) GetSimulation(_ context.Context, id string) (*agent.Simulation, error) {
+	if id != simulationID {
+		return nil, agent.ErrNotFound
+	}
+	return &agent.Simulation{ID: id, Status: "running"}, nil
+}
+
+// truth é o estado que a visão do cliente precisa alcançar.
+func (w *world) truth() []Agent {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	out := make([]Agent, 0, len(w.agents))
+	for _, a := range w.agents {
+		out = append(out, Agent{
+			ID:      a.ID,
+			Status:  a.Status,
+			Lat:     a.Position.Lat,
+			Lon:     a.Position.Lon,
+			Heading: a.Position.Heading,
+			Speed:   a.Position.Speed,
+		})
+	}
+	return sortedAgents(out)
+}
+
+// move desloca n agentes e troca o status de um deles.
+func (w *world) move(n int) {
+	w.mu.Lock()
+	ids := make([]string, 0, len(w.agents))
+	for id := range w.agents {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	var changed []agent.Agent
+	for i := 0; i < n && len(ids) > 0; i++ {
+		w.step++
+		a := w.agents[ids[w.step%len(ids)]]
+		a.Position.Lat += 0.001
+		a.Position.Lon -= 0.0005
+		a.Position.Heading = float64((w.step * 37) % 360)
+		a.Position.Speed = float64(w.step%20) / 2
+		if i == 0 {
+			a.Status = []string{"active", "idle", "moving"}[w.step%3]
+		}
+		a.UpdatedAt = time.Now()
+		w.agents[a.ID] = a
+		changed = append(changed, a)
+	}
+	w.mu.Unlock()
+	for _, a := range changed {
+		w.publish(context.Background(), agentEvent("agent.updated", a))
+	}
+}
+
+// add cria um agente.
+func (w *world) add() {
+	w.mu.Lock()
+	a := w.addLocked()
+	w.mu.Unlock()
+	w.publish(context.Background(), agentEvent("agent.created", a))
+}
+
+func (w *world) addLocked() agent.Agent {
+	w.nextID++
+	now := time.Now()
+	a := agent.Agent{
+		ID:           fmt.Sprintf("agent-%03d", w.nextID),
+		SimulationID: simulationID,
+		Type:         "vehicle",
+		Name:         fmt.Sprintf("Agente %d", w.nextID),
+		Status:       "active",
+		Position: agent.Position{
+			Lat:     -23.55 + float64(w.nextID)*0.01,
+			Lon:     -46.63 - float64(w.nextID)*0.01,
+			Heading: float64((w.nextID * 45) % 360),
+			Speed:   1.5,
+		},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	w.agents[a.ID] = a
+	return a
+}
+
+// remove apaga o agente mais antigo.
+func (w *world) remove() {
+	w.mu.Lock()
+	ids := make([]string, 0, len(w.agents))
+	for id := range w.agents {
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		w.mu.Unlock()
+		return
+	}
+	sort.Strings(ids)
+	id := ids[0]
+	delete(w.agents, id)
+	w.mu.Unlock()
+	w.publish(context.Background(), events.New(events.TopicAgents, "agent.deleted",
+		events.AgentDeletedV1{ID: id, SimulationID: simulationID}))
+}
+
+func agentEvent(eventType string, a agent.Agent) events.Event {
+	return events.New(events.TopicAgents, eventType, events.AgentV1{
+		ID:           a.ID,
+		SimulationID: a.SimulationID,
+		Type:         a.Type,
+		Name:         a.Name,
+		Status:       a.Status,
+		Position: events.PositionV1{
+			Lat:     a.Position.Lat,
+			Lon:     a.Position.Lon,
+			Heading: a.Position.Heading,
+			Speed:   a.Position.Speed,
+		},
+		CreatedAt: a.CreatedAt,
+		UpdatedAt: a.UpdatedAt,
+	})
+}
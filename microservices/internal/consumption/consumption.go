@@ -0,0 +1,160 @@
+// Package consumption contabiliza o consumo de energia dos agentes. A cada
+// tick de uma simulação, o modelo do tipo do agente (Wh por km percorrido e
+// potência de base) dá o consumo do tick, somado a contadores no Redis: o
+// total de cada agente e, num hash de pendências, as parcelas por
+// simulação, tipo e faixa de tempo ainda não gravadas no PostgreSQL. O
+// Flusher grava as pendências periodicamente.
+//
+// Medidores externos corrigem o valor modelado pelas métricas de agentes:
+// a amostra da métrica meter_metric do tipo é a leitura acumulada do
+// medidor, e a diferença para o total do agente entra como correção, no
+// agente e na faixa da amostra.
+package consumption
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// O total de cada agente fica num hash ao lado do seu estado ao vivo, com
+// simulation_id, agent_type, modeled_wh, correction_wh, distance_km e
+// meter_at (instante da última leitura do medidor, em nanossegundos).
+const agentKeyPrefix = "agent-service:agents:"
+
+// As parcelas ainda não gravadas ficam em pendingKey; o Flusher renomeia o
+// hash para flushingKey antes de gravá-lo, e os ticks seguintes recriam
+// pendingKey.
+const (
+	pendingKey     = "agent-service:consumption:pending"
+	flushingKey    = "agent-service:consumption:flushing"
+	flushLeaderKey = "agent-service:consumption:flush-leader"
+)
+
+// stateTTL descarta o total de agentes que pararam de consumir; o valor
+// continua no PostgreSQL e volta ao Redis no próximo consumo.
+const stateTTL = 7 * 24 * time.Hour
+
+const earthRadius = 6371000.0
+
+var energy = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "agent_service",
+	Name:      "agent_energy_consumed_wh_total",
+	Help:      "Energia consumida pelos agentes segundo o modelo do tipo, em Wh.",
+}, []string{"agent_type"})
+
+// Model é o modelo de consumo de um tipo de agente.
+type Model struct {
+	WhPerKm   float64 `json:"wh_per_km"`
+	BaselineW float64 `json:"baseline_w"`
+	// MeterMetric é a métrica do tipo com a leitura acumulada do medidor,
+	// em Wh; vazia, o consumo é só o modelado.
+	MeterMetric string `json:"meter_metric,omitempty"`
+}
+
+// Energy é o consumo, em Wh, de km percorridos em d.
+func (m Model) Energy(km float64, d time.Duration) float64 {
+	return m.WhPerKm*km + m.BaselineW*d.Hours()
+}
+
+// Totals é um consumo acumulado. EnergyWh é o modelado mais as correções
+// dos medidores.
+type Totals struct {
+	EnergyWh     float64 `json:"energy_wh"`
+	ModeledWh    float64 `json:"modeled_wh"`
+	CorrectionWh float64 `json:"correction_wh"`
+	DistanceKm   float64 `json:"distance_km"`
+}
+
+func (t *Totals) add(o Totals) {
+	t.ModeledWh += o.ModeledWh
+	t.CorrectionWh += o.CorrectionWh
+	t.DistanceKm += o.DistanceKm
+	t.EnergyWh = t.ModeledWh + t.CorrectionWh
+}
+
+// AgentConsumption é o consumo de um agente, em GET /agents/:id/performance.
+type AgentConsumption struct {
+	SimulationID string `json:"simulation_id,omitempty"`
+	Totals
+	Model Model `json:"model"`
+}
+
+func agentKey(agentID string) string {
+	return agentKeyPrefix + agentID + ":consumption"
+}
+
+// Campos do hash de pendências: t|<simulação>|<tipo>|<faixa>|<valor> para
+// as faixas e a|<agente>|<simulação>|<tipo>|<valor> para os agentes, com a
+// faixa em segundos Unix e o valor m (modelado), c (correção) ou d
+// (distância).
+const (
+	fieldModeled    = "m"
+	fieldCorrection = "c"
+	fieldDistance   = "d"
+)
+
+func bucketField(simulationID, agentType string, bucket time.Time, value string) string {
+	return "t|" + simulationID + "|" + agentType + "|" + strconv.FormatInt(bucket.Unix(), 10) + "|" + value
+}
+
+func agentField(agentID, simulationID, agentType, value string) string {
+	return "a|" + agentID + "|" + simulationID + "|" + agentType + "|" + value
+}
+
+// pendingField é um campo do hash de pendências decodificado.
+type pendingField struct {
+	kind        string
+	id, sim, tp string
+	bucket      time.Time
+	value       string
+}
+
+func parseField(f string) (pendingField, bool) {
+	parts := strings.Split(f, "|")
+	if len(parts) != 5 {
+		return pendingField{}, false
+	}
+	p := pendingField{kind: parts[0], value: parts[4]}
+	switch p.kind {
+	case "t":
+		secs, err := strconv.ParseInt(parts[3], 10, 64)
+		if err != nil {
+			return pendingField{}, false
+		}
+		p.sim, p.tp, p.bucket = parts[1], parts[2], time.Unix(secs, 0).UTC()
+	case "a":
+		p.id, p.sim, p.tp = parts[1], parts[2], parts[3]
+	default:
+		return pendingField{}, false
+	}
+	return p, true
+}
+
+// addField soma v ao valor do campo do hash de pendências.
+func (t *Totals) addField(value string, v float64) {
+	switch value {
+	case fieldModeled:
+		t.ModeledWh += v
+	case fieldCorrection:
+		t.CorrectionWh += v
+	case fieldDistance:
+		t.DistanceKm += v
+	}
+	t.EnergyWh = t.ModeledWh + t.CorrectionWh
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	const rad = math.Pi / 180
+	dLat, dLon := (lat2-lat1)*rad, (lon2-lon1)*rad
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
@@ -0,0 +1,181 @@
+package consumption
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+
+	"smart-city-microservices/internal/lease"
+	"smart-city-microservices/internal/logging"
+	"smart-city-microservices/internal/supervisor"
+)
+
+var flushes = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "agent_service",
+	Name:      "agent_consumption_flushes_total",
+	Help:      "Gravações das pendências de consumo no PostgreSQL, por resultado (ok, error).",
+}, []string{"result"})
+
+// Flusher grava periodicamente as pendências de consumo no PostgreSQL. Só
+// uma réplica grava por vez.
+type Flusher struct {
+	redis    redis.UniversalClient
+	leader   *lease.Lease
+	repo     *Repository
+	interval time.Duration
+}
+
+// NewFlusher cria o gravador. id identifica a réplica na disputa pela
+// gravação; o ciclo roda em Run.
+func NewFlusher(client redis.UniversalClient, repo *Repository, interval time.Duration, id string) *Flusher {
+	return &Flusher{
+		redis:    client,
+		leader:   lease.New(client, flushLeaderKey, id),
+		repo:     repo,
+		interval: interval,
+	}
+}
+
+// Run grava as pendências a cada intervalo até ctx ser cancelado. Ao
+// terminar, grava as últimas pendências se esta réplica é a que grava e
+// libera a gravação para outra réplica.
+func (f *Flusher) Run(ctx context.Context) error {
+	work := logging.Background(context.WithoutCancel(ctx), "consumption-flusher")
+	defer f.release(work)
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			f.cycle(work)
+		}
+	}
+}
+
+// release grava e remove a chave só se ainda for desta réplica.
+func (f *Flusher) release(ctx context.Context) {
+	ctx, cancel := supervisor.Cleanup(ctx)
+	defer cancel()
+	if held, err := f.leader.Held(ctx); err == nil && held {
+		if err := f.flush(ctx); err != nil {
+			logging.FromContext(ctx).WithError(err).Warn("Falha ao gravar as últimas pendências de consumo")
+		}
+		f.leader.Release(ctx)
+	}
+}
+
+func (f *Flusher) cycle(ctx context.Context) {
+	log := logging.FromContext(ctx)
+	leader, err := f.lead(ctx)
+	if err != nil {
+		log.WithError(err).Warn("Falha ao disputar a gravação do consumo")
+		return
+	}
+	if !leader {
+		return
+	}
+	if err := f.flush(ctx); err != nil {
+		flushes.WithLabelValues("error").Inc()
+		log.WithError(err).Error("Falha ao gravar as pendências de consumo; elas ficam para o próximo ciclo")
+		return
+	}
+	flushes.WithLabelValues("ok").Inc()
+}
+
+// lead disputa a gravação. A chave expira em três intervalos, para que
+// outra réplica assuma se esta cair.
+func (f *Flusher) lead(ctx context.Context) (bool, error) {
+	return f.leader.Acquire(ctx, 3*f.interval)
+}
+
+// flush move as pendências para flushingKey, grava-as e remove a chave.
+// Se uma gravação anterior falhou, flushingKey ainda existe e é gravada
+// antes de novas pendências; uma falha entre a gravação e a remoção da
+// chave repete a gravação no ciclo seguinte.
+func (f *Flusher) flush(ctx context.Context) error {
+	n, err := f.redis.Exists(ctx, flushingKey).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		if n, err = f.redis.Exists(ctx, pendingKey).Result(); err != nil || n == 0 {
+			return err
+		}
+		if err := f.redis.Rename(ctx, pendingKey, flushingKey).Err(); err != nil {
+			return err
+		}
+	}
+	fields, err := f.redis.HGetAll(ctx, flushingKey).Result()
+	if err != nil {
+		return err
+	}
+	buckets, agents := collect(fields)
+	if len(buckets) > 0 || len(agents) > 0 {
+		if err := f.repo.Apply(ctx, buckets, agents); err != nil {
+			return err
+		}
+	}
+	return f.redis.Del(ctx, flushingKey).Err()
+}
+
+// collect agrega os campos do hash de pendências em parcelas por faixa e
+// por agente. Campos inválidos são descartados, para não travar a gravação.
+func collect(fields map[string]string) ([]BucketDelta, []AgentDelta) {
+	type bucketKey struct {
+		sim, tp string
+		start   int64
+	}
+	buckets := map[bucketKey]*BucketDelta{}
+	agents := map[string]*AgentDelta{}
+	for name, raw := range fields {
+		p, ok := parseField(name)
+		if !ok {
+			continue
+		}
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil || v == 0 {
+			continue
+		}
+		if _, err := uuid.Parse(p.sim); err != nil {
+			continue
+		}
+		switch p.kind {
+		case "t":
+			k := bucketKey{p.sim, p.tp, p.bucket.Unix()}
+			b := buckets[k]
+			if b == nil {
+				b = &BucketDelta{SimulationID: p.sim, AgentType: p.tp, Bucket: p.bucket}
+				buckets[k] = b
+			}
+			b.addField(p.value, v)
+		case "a":
+			if _, err := uuid.Parse(p.id); err != nil {
+				continue
+			}
+			// Um agente que mudou de simulação ou de tipo dentro do ciclo
+			// fica com uma delas; o total é o mesmo.
+			a := agents[p.id]
+			if a == nil {
+				a = &AgentDelta{AgentID: p.id, SimulationID: p.sim, AgentType: p.tp}
+				agents[p.id] = a
+			}
+			a.addField(p.value, v)
+		}
+	}
+	outB := make([]BucketDelta, 0, len(buckets))
+	for _, b := range buckets {
+		outB = append(outB, *b)
+	}
+	outA := make([]AgentDelta, 0, len(agents))
+	for _, a := range agents {
+		outA = append(outA, *a)
+	}
+	return outB, outA
+}
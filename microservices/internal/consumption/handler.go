@@ -0,0 +1,194 @@
+package consumption
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/logging"
+)
+
+// SimulationGetter é o subconjunto de agent.Service usado pelo handler.
+type SimulationGetter interface {
+	GetSimulation(ctx context.Context, id string) (*agent.Simulation, error)
+}
+
+// HandlerConfig limita as consultas de consumo.
+type HandlerConfig struct {
+	// Bucket é a faixa gravada; as faixas da consulta são múltiplos dela.
+	Bucket time.Duration
+	// DefaultBucket é a faixa da consulta sem ?bucket.
+	DefaultBucket time.Duration
+	MaxBuckets    int
+}
+
+// Handler expõe o consumo das simulações.
+type Handler struct {
+	repo        *Repository
+	simulations SimulationGetter
+	cfg         HandlerConfig
+}
+
+// NewHandler cria o handler de consumo.
+func NewHandler(repo *Repository, simulations SimulationGetter, cfg HandlerConfig) *Handler {
+	return &Handler{repo: repo, simulations: simulations, cfg: cfg}
+}
+
+// TypeTotals é o consumo de um tipo de agente.
+type TypeTotals struct {
+	AgentType string `json:"agent_type"`
+	Totals
+}
+
+// BucketTotals é o consumo de uma faixa da consulta.
+type BucketTotals struct {
+	Start time.Time `json:"start"`
+	Totals
+	ByAgentType map[string]Totals `json:"by_agent_type"`
+}
+
+// Get responde GET /simulations/:id/consumption com o consumo da
+// simulação em [from, to) (RFC 3339; por padrão, do início da simulação
+// até o fim ou agora), no total, por tipo de agente e em faixas de
+// ?bucket, alinhadas ao início da época Unix. ?agent_type restringe a um
+// tipo. O consumo dos últimos segundos, ainda não gravado, fica de fora.
+func (h *Handler) Get(c *gin.Context) {
+	step := h.cfg.DefaultBucket
+	if v := c.Query("bucket"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < h.cfg.Bucket || d%h.cfg.Bucket != 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid bucket: must be a multiple of " + h.cfg.Bucket.String()})
+			return
+		}
+		step = d
+	}
+
+	ctx := c.Request.Context()
+	sim, err := h.simulations.GetSimulation(ctx, c.Param("id"))
+	if errors.Is(err, agent.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "simulation not found"})
+		return
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+
+	defTo, defFrom := time.Now(), sim.CreatedAt
+	if sim.EndedAt != nil {
+		defTo = *sim.EndedAt
+	}
+	if sim.StartedAt != nil {
+		defFrom = *sim.StartedAt
+	}
+	var ok bool
+	var from, to time.Time
+	if to, ok = timeParam(c, "to", defTo); !ok {
+		return
+	}
+	if from, ok = timeParam(c, "from", defFrom); !ok {
+		return
+	}
+	if !from.Before(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return
+	}
+	from, to = floor(from, step), ceil(to, step)
+	n := int(to.Sub(from) / step)
+	if n > h.cfg.MaxBuckets {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "time range has more than " + strconv.Itoa(h.cfg.MaxBuckets) + " buckets; use a larger bucket"})
+		return
+	}
+
+	agentType := c.Query("agent_type")
+	rows, err := h.repo.Buckets(ctx, sim.ID, from, to, step, agentType)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+
+	buckets := make([]BucketTotals, n)
+	for i := range buckets {
+		buckets[i] = BucketTotals{Start: from.Add(time.Duration(i) * step).UTC(), ByAgentType: map[string]Totals{}}
+	}
+	var total Totals
+	byType := map[string]*TypeTotals{}
+	for _, r := range rows {
+		total.add(r.Totals)
+		tt := byType[r.AgentType]
+		if tt == nil {
+			tt = &TypeTotals{AgentType: r.AgentType}
+			byType[r.AgentType] = tt
+		}
+		tt.add(r.Totals)
+		if i := int(r.Start.Sub(from) / step); i >= 0 && i < n {
+			b := &buckets[i]
+			b.add(r.Totals)
+			t := b.ByAgentType[r.AgentType]
+			t.add(r.Totals)
+			b.ByAgentType[r.AgentType] = t
+		}
+	}
+	types := make([]TypeTotals, 0, len(byType))
+	for _, t := range byType {
+		types = append(types, *t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i].AgentType < types[j].AgentType })
+
+	resp := gin.H{
+		"simulation_id": sim.ID,
+		"from":          from.UTC(),
+		"to":            to.UTC(),
+		"bucket":        step.String(),
+		"total":         total,
+		"by_agent_type": types,
+		"buckets":       buckets,
+	}
+	if agentType != "" {
+		resp["agent_type"] = agentType
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// floor alinha t ao início da faixa de step que o contém, contando da
+// época Unix, como as faixas da consulta.
+func floor(t time.Time, step time.Duration) time.Time {
+	s := int64(step / time.Second)
+	u := t.Unix()
+	u -= ((u % s) + s) % s
+	return time.Unix(u, 0)
+}
+
+// ceil alinha t ao fim da faixa de step que o contém; t já alinhado fica.
+func ceil(t time.Time, step time.Duration) time.Time {
+	if f := floor(t, step); f.Before(t) {
+		return f.Add(step)
+	}
+	return t
+}
+
+// timeParam lê um parâmetro RFC 3339 da query, ou retorna def se ausente.
+// Responde 400 e retorna false se o valor é inválido.
+func timeParam(c *gin.Context, name string, def time.Time) (time.Time, bool) {
+	v := c.Query(name)
+	if v == "" {
+		return def, true
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + name + ": expected RFC 3339 timestamp"})
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+func (h *Handler) internalError(c *gin.Context, err error) {
+	logging.FromContext(c.Request.Context()).WithError(err).Error("Erro no handler de consumo")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
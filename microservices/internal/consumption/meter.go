@@ -0,0 +1,345 @@
+package consumption
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/agentmetric"
+	"smart-city-microservices/internal/logging"
+)
+
+const (
+	listPageSize = 500
+	// evictAfter descarta as posições de uma simulação sem ticks nesse
+	// tempo.
+	evictAfter = 5 * time.Minute
+	// maxTxRetries limita as novas tentativas quando um tick altera o total
+	// do agente durante a aplicação de uma leitura do medidor.
+	maxTxRetries = 5
+)
+
+// AgentLister é o subconjunto de agent.Service usado pelo medidor.
+type AgentLister interface {
+	ListAgents(ctx context.Context, f agent.Filter) ([]agent.Agent, int, error)
+}
+
+// MeterConfig configura o medidor.
+type MeterConfig struct {
+	// TickInterval é a duração de um tick, que multiplica a potência de
+	// base.
+	TickInterval time.Duration
+	// Bucket é a faixa de tempo em que o consumo é agregado.
+	Bucket time.Duration
+	// InactiveStatuses são os status em que o agente não consome.
+	InactiveStatuses []string
+}
+
+// Meter acumula o consumo dos agentes a cada tick e aplica as leituras dos
+// medidores. Tick roda no relógio das simulações, de uma goroutine só;
+// Observe e AgentConsumption podem ser chamados de qualquer goroutine.
+type Meter struct {
+	redis    redis.UniversalClient
+	agents   AgentLister
+	repo     *Repository
+	models   map[string]Model
+	cfg      MeterConfig
+	inactive map[string]bool
+	sims     map[string]*simulation
+}
+
+// simulation guarda a última posição de cada agente da simulação, para a
+// distância percorrida no tick seguinte.
+type simulation struct {
+	lastTick  time.Time
+	positions map[string]position
+}
+
+type position struct{ lat, lon float64 }
+
+// usage é o consumo de um agente num tick.
+type usage struct {
+	agentID, agentType string
+	wh, km             float64
+	// fresh indica que o agente ainda não tinha sido visto por este
+	// medidor, e o total pode precisar voltar do PostgreSQL ao Redis.
+	fresh bool
+}
+
+// NewMeter cria o medidor com os modelos por tipo de agente; tipos sem
+// modelo não consomem. Tick precisa ser registrado no relógio das
+// simulações, e Observe nas métricas dos agentes.
+func NewMeter(client redis.UniversalClient, agents AgentLister, repo *Repository, models map[string]Model, cfg MeterConfig) *Meter {
+	m := &Meter{
+		redis:    client,
+		agents:   agents,
+		repo:     repo,
+		models:   models,
+		cfg:      cfg,
+		inactive: make(map[string]bool, len(cfg.InactiveStatuses)),
+		sims:     map[string]*simulation{},
+	}
+	for _, s := range cfg.InactiveStatuses {
+		m.inactive[s] = true
+	}
+	return m
+}
+
+// Tick soma o consumo do tick de cada agente da simulação: a distância
+// desde a posição do tick anterior e a potência de base pela duração do
+// tick.
+func (m *Meter) Tick(ctx context.Context, simulationID string, tick int64) {
+	log := logging.FromContext(ctx).WithFields(logrus.Fields{"simulation_id": simulationID, "tick": tick})
+	now := time.Now()
+	m.evict(now)
+	s := m.sims[simulationID]
+	if s == nil {
+		s = &simulation{}
+		m.sims[simulationID] = s
+	}
+	s.lastTick = now
+
+	next := make(map[string]position, len(s.positions))
+	var used []usage
+	f := agent.Filter{SimulationID: simulationID, PageSize: listPageSize}
+	scanned := 0
+	for f.Page = 1; ; f.Page++ {
+		page, total, err := m.agents.ListAgents(ctx, f)
+		if err != nil {
+			// As posições do tick anterior ficam, e o próximo tick conta a
+			// distância desde elas.
+			log.WithError(err).Error("Falha ao listar agentes para o consumo de energia")
+			return
+		}
+		for _, a := range page {
+			model, ok := m.models[a.Type]
+			if !ok {
+				continue
+			}
+			p := position{a.Position.Lat, a.Position.Lon}
+			prev, seen := s.positions[a.ID]
+			next[a.ID] = p
+			if m.inactive[a.Status] {
+				continue
+			}
+			u := usage{agentID: a.ID, agentType: a.Type, fresh: !seen}
+			if seen {
+				u.km = haversine(prev.lat, prev.lon, p.lat, p.lon) / 1000
+			}
+			if u.wh = model.Energy(u.km, m.cfg.TickInterval); u.wh != 0 || u.km != 0 {
+				used = append(used, u)
+			}
+		}
+		scanned += len(page)
+		if len(page) == 0 || scanned >= total {
+			break
+		}
+	}
+	s.positions = next
+	if len(used) == 0 {
+		return
+	}
+	if err := m.record(ctx, simulationID, now, used); err != nil {
+		log.WithError(err).Error("Falha ao registrar o consumo de energia do tick")
+	}
+}
+
+// record soma o consumo do tick aos totais dos agentes e às pendências,
+// num só pipeline.
+func (m *Meter) record(ctx context.Context, simulationID string, now time.Time, used []usage) error {
+	if err := m.restore(ctx, used); err != nil {
+		return err
+	}
+	bucket := now.Truncate(m.cfg.Bucket)
+	byType := map[string]float64{}
+	_, err := m.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, u := range used {
+			key := agentKey(u.agentID)
+			pipe.HSet(ctx, key, "simulation_id", simulationID, "agent_type", u.agentType)
+			pipe.HIncrByFloat(ctx, key, "modeled_wh", u.wh)
+			pipe.HIncrByFloat(ctx, pendingKey, bucketField(simulationID, u.agentType, bucket, fieldModeled), u.wh)
+			pipe.HIncrByFloat(ctx, pendingKey, agentField(u.agentID, simulationID, u.agentType, fieldModeled), u.wh)
+			if u.km > 0 {
+				pipe.HIncrByFloat(ctx, key, "distance_km", u.km)
+				pipe.HIncrByFloat(ctx, pendingKey, bucketField(simulationID, u.agentType, bucket, fieldDistance), u.km)
+				pipe.HIncrByFloat(ctx, pendingKey, agentField(u.agentID, simulationID, u.agentType, fieldDistance), u.km)
+			}
+			pipe.Expire(ctx, key, stateTTL)
+			byType[u.agentType] += u.wh
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for t, wh := range byType {
+		energy.WithLabelValues(t).Add(wh)
+	}
+	return nil
+}
+
+// restore devolve ao Redis o total gravado dos agentes vistos pela
+// primeira vez cujo hash expirou ou nunca existiu.
+func (m *Meter) restore(ctx context.Context, used []usage) error {
+	var fresh []string
+	for _, u := range used {
+		if u.fresh {
+			fresh = append(fresh, u.agentID)
+		}
+	}
+	if len(fresh) == 0 {
+		return nil
+	}
+	cmds := make([]*redis.BoolCmd, len(fresh))
+	_, err := m.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range fresh {
+			cmds[i] = pipe.HExists(ctx, agentKey(id), "modeled_wh")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	var missing []string
+	for i, cmd := range cmds {
+		if !cmd.Val() {
+			missing = append(missing, fresh[i])
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	stored, err := m.repo.Agents(ctx, missing)
+	if err != nil || len(stored) == 0 {
+		return err
+	}
+	// HSetNX não sobrescreve um total criado por uma leitura do medidor
+	// nesse meio tempo.
+	_, err = m.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for id, t := range stored {
+			key := agentKey(id)
+			pipe.HSetNX(ctx, key, "modeled_wh", formatFloat(t.ModeledWh))
+			pipe.HSetNX(ctx, key, "correction_wh", formatFloat(t.CorrectionWh))
+			pipe.HSetNX(ctx, key, "distance_km", formatFloat(t.DistanceKm))
+		}
+		return nil
+	})
+	return err
+}
+
+// Observe aplica a leitura mais nova do medidor do agente: a diferença
+// entre ela e o total atual entra como correção, no agente e na faixa da
+// amostra. Leituras mais antigas que a última aplicada são ignoradas, e
+// falhas só são registradas: as amostras já foram gravadas.
+func (m *Meter) Observe(ctx context.Context, ag *agent.Agent, samples []agentmetric.Sample) {
+	model, ok := m.models[ag.Type]
+	if !ok || model.MeterMetric == "" || ag.SimulationID == "" {
+		return
+	}
+	var reading *agentmetric.Sample
+	for i, s := range samples {
+		if s.Name == model.MeterMetric && (reading == nil || s.At.After(reading.At)) {
+			reading = &samples[i]
+		}
+	}
+	if reading == nil {
+		return
+	}
+
+	var err error
+	for i := 0; i < maxTxRetries; i++ {
+		err = m.correct(ctx, ag, *reading)
+		if !errors.Is(err, redis.TxFailedErr) {
+			break
+		}
+	}
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).WithField("agent_id", ag.ID).Warn("Falha ao aplicar a leitura do medidor de energia")
+	}
+}
+
+// correct aplica a leitura numa transação otimista sobre o total do agente.
+func (m *Meter) correct(ctx context.Context, ag *agent.Agent, reading agentmetric.Sample) error {
+	key := agentKey(ag.ID)
+	return m.redis.Watch(ctx, func(tx *redis.Tx) error {
+		stored, err := tx.HGetAll(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		if at, err := strconv.ParseInt(stored["meter_at"], 10, 64); err == nil && !reading.At.After(time.Unix(0, at)) {
+			return nil
+		}
+		cur, ok := decode(stored)
+		if !ok {
+			if cur, err = m.repo.Agent(ctx, ag.ID); err != nil {
+				return err
+			}
+		}
+		delta := reading.Value - cur.EnergyWh
+		bucket := reading.At.Truncate(m.cfg.Bucket)
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, key,
+				"simulation_id", ag.SimulationID,
+				"agent_type", ag.Type,
+				"modeled_wh", formatFloat(cur.ModeledWh),
+				"correction_wh", formatFloat(cur.CorrectionWh+delta),
+				"distance_km", formatFloat(cur.DistanceKm),
+				"meter_at", strconv.FormatInt(reading.At.UnixNano(), 10),
+			)
+			pipe.Expire(ctx, key, stateTTL)
+			if delta != 0 {
+				pipe.HIncrByFloat(ctx, pendingKey, bucketField(ag.SimulationID, ag.Type, bucket, fieldCorrection), delta)
+				pipe.HIncrByFloat(ctx, pendingKey, agentField(ag.ID, ag.SimulationID, ag.Type, fieldCorrection), delta)
+			}
+			return nil
+		})
+		return err
+	}, key)
+}
+
+// AgentConsumption retorna o consumo do agente, ou nil se o tipo não tem
+// modelo. Implementa agentmetric.ConsumptionSource.
+func (m *Meter) AgentConsumption(ctx context.Context, ag *agent.Agent) (interface{}, error) {
+	model, ok := m.models[ag.Type]
+	if !ok {
+		return nil, nil
+	}
+	stored, err := m.redis.HGetAll(ctx, agentKey(ag.ID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	t, ok := decode(stored)
+	if !ok {
+		if t, err = m.repo.Agent(ctx, ag.ID); err != nil {
+			return nil, err
+		}
+	}
+	return &AgentConsumption{SimulationID: ag.SimulationID, Totals: t, Model: model}, nil
+}
+
+// decode lê o total do hash do agente; ok é falso se o hash não tem total.
+func decode(stored map[string]string) (Totals, bool) {
+	var t Totals
+	var err error
+	if t.ModeledWh, err = strconv.ParseFloat(stored["modeled_wh"], 64); err != nil {
+		return Totals{}, false
+	}
+	t.CorrectionWh, _ = strconv.ParseFloat(stored["correction_wh"], 64)
+	t.DistanceKm, _ = strconv.ParseFloat(stored["distance_km"], 64)
+	t.EnergyWh = t.ModeledWh + t.CorrectionWh
+	return t, true
+}
+
+// evict descarta as posições das simulações que pararam de receber ticks.
+func (m *Meter) evict(now time.Time) {
+	for id, s := range m.sims {
+		if now.Sub(s.lastTick) > evictAfter {
+			delete(m.sims, id)
+		}
+	}
+}
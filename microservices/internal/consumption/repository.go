@@ -0,0 +1,158 @@
+package consumption
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+
+	"smart-city-microservices/internal/instrument"
+)
+
+// Repository persiste o consumo no PostgreSQL: as faixas de cada simulação
+// por tipo de agente em simulation_consumption e o total de cada agente em
+// agent_consumption.
+type Repository struct {
+	db *instrument.DB
+}
+
+// NewRepository cria o repositório.
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: instrument.NewDB(db)}
+}
+
+// BucketDelta é uma parcela de consumo de uma faixa de simulação e tipo.
+type BucketDelta struct {
+	SimulationID string
+	AgentType    string
+	Bucket       time.Time
+	Totals
+}
+
+// AgentDelta é uma parcela do consumo de um agente.
+type AgentDelta struct {
+	AgentID      string
+	SimulationID string
+	AgentType    string
+	Totals
+}
+
+// Apply soma as parcelas às faixas e aos totais dos agentes num só comando.
+// Parcelas de simulações ou agentes que não existem mais são descartadas.
+func (r *Repository) Apply(ctx context.Context, buckets []BucketDelta, agents []AgentDelta) error {
+	bSims, bTypes := make([]string, len(buckets)), make([]string, len(buckets))
+	bStarts := make([]int64, len(buckets))
+	bModeled, bCorrection, bDistance := make([]float64, len(buckets)), make([]float64, len(buckets)), make([]float64, len(buckets))
+	for i, b := range buckets {
+		bSims[i], bTypes[i], bStarts[i] = b.SimulationID, b.AgentType, b.Bucket.Unix()
+		bModeled[i], bCorrection[i], bDistance[i] = b.ModeledWh, b.CorrectionWh, b.DistanceKm
+	}
+	aIDs, aSims, aTypes := make([]string, len(agents)), make([]string, len(agents)), make([]string, len(agents))
+	aModeled, aCorrection, aDistance := make([]float64, len(agents)), make([]float64, len(agents)), make([]float64, len(agents))
+	for i, a := range agents {
+		aIDs[i], aSims[i], aTypes[i] = a.AgentID, a.SimulationID, a.AgentType
+		aModeled[i], aCorrection[i], aDistance[i] = a.ModeledWh, a.CorrectionWh, a.DistanceKm
+	}
+
+	_, err := r.db.Exec(ctx, "consumption.apply", `
+		WITH buckets AS (
+			INSERT INTO simulation_consumption (simulation_id, agent_type, bucket, modeled_wh, correction_wh, distance_km)
+			SELECT s.id, b.agent_type, to_timestamp(b.bucket), b.modeled, b.correction, b.distance
+			FROM unnest($1::uuid[], $2::text[], $3::bigint[], $4::float8[], $5::float8[], $6::float8[])
+				AS b(simulation_id, agent_type, bucket, modeled, correction, distance)
+			JOIN simulations s ON s.id = b.simulation_id
+			ON CONFLICT (simulation_id, agent_type, bucket) DO UPDATE SET
+				modeled_wh = simulation_consumption.modeled_wh + EXCLUDED.modeled_wh,
+				correction_wh = simulation_consumption.correction_wh + EXCLUDED.correction_wh,
+				distance_km = simulation_consumption.distance_km + EXCLUDED.distance_km
+		)
+		INSERT INTO agent_consumption (agent_id, simulation_id, agent_type, modeled_wh, correction_wh, distance_km)
+		SELECT ag.id, a.simulation_id, a.agent_type, a.modeled, a.correction, a.distance
+		FROM unnest($7::uuid[], $8::uuid[], $9::text[], $10::float8[], $11::float8[], $12::float8[])
+			AS a(agent_id, simulation_id, agent_type, modeled, correction, distance)
+		JOIN agents ag ON ag.id = a.agent_id
+		ON CONFLICT (agent_id) DO UPDATE SET
+			simulation_id = EXCLUDED.simulation_id,
+			agent_type = EXCLUDED.agent_type,
+			modeled_wh = agent_consumption.modeled_wh + EXCLUDED.modeled_wh,
+			correction_wh = agent_consumption.correction_wh + EXCLUDED.correction_wh,
+			distance_km = agent_consumption.distance_km + EXCLUDED.distance_km,
+			updated_at = CURRENT_TIMESTAMP`,
+		pq.Array(bSims), pq.Array(bTypes), pq.Array(bStarts), pq.Array(bModeled), pq.Array(bCorrection), pq.Array(bDistance),
+		pq.Array(aIDs), pq.Array(aSims), pq.Array(aTypes), pq.Array(aModeled), pq.Array(aCorrection), pq.Array(aDistance),
+	)
+	return err
+}
+
+// Agents retorna o total gravado de cada agente que tem um.
+func (r *Repository) Agents(ctx context.Context, agentIDs []string) (map[string]Totals, error) {
+	rows, err := r.db.Query(ctx, "consumption.agents", `
+		SELECT agent_id, modeled_wh, correction_wh, distance_km FROM agent_consumption
+		WHERE agent_id = ANY($1::uuid[])`, pq.Array(agentIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[string]Totals, len(agentIDs))
+	for rows.Next() {
+		var id string
+		var t Totals
+		if err := rows.Scan(&id, &t.ModeledWh, &t.CorrectionWh, &t.DistanceKm); err != nil {
+			return nil, err
+		}
+		t.EnergyWh = t.ModeledWh + t.CorrectionWh
+		out[id] = t
+	}
+	return out, rows.Err()
+}
+
+// Agent retorna o total gravado do agente; sem registro, zero.
+func (r *Repository) Agent(ctx context.Context, agentID string) (Totals, error) {
+	var t Totals
+	err := r.db.QueryRow(ctx, "consumption.agent", `
+		SELECT modeled_wh, correction_wh, distance_km FROM agent_consumption WHERE agent_id = $1`, agentID,
+	).Scan(&t.ModeledWh, &t.CorrectionWh, &t.DistanceKm)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Totals{}, nil
+	}
+	t.EnergyWh = t.ModeledWh + t.CorrectionWh
+	return t, err
+}
+
+// Bucket é o consumo de um tipo de agente numa faixa da consulta.
+type Bucket struct {
+	Start     time.Time
+	AgentType string
+	Totals
+}
+
+// Buckets agrupa as faixas gravadas da simulação em [from, to) em faixas de
+// step, alinhadas ao início da época Unix, por tipo de agente. agentType
+// vazio inclui todos os tipos.
+func (r *Repository) Buckets(ctx context.Context, simulationID string, from, to time.Time, step time.Duration, agentType string) ([]Bucket, error) {
+	rows, err := r.db.Query(ctx, "consumption.buckets", `
+		SELECT to_timestamp(floor(extract(epoch FROM bucket) / $4) * $4) AS start, agent_type,
+			SUM(modeled_wh), SUM(correction_wh), SUM(distance_km)
+		FROM simulation_consumption
+		WHERE simulation_id = $1 AND bucket >= $2 AND bucket < $3 AND ($5 = '' OR agent_type = $5)
+		GROUP BY 1, 2
+		ORDER BY 1, 2`, simulationID, from, to, step.Seconds(), agentType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Bucket
+	for rows.Next() {
+		var b Bucket
+		if err := rows.Scan(&b.Start, &b.AgentType, &b.ModeledWh, &b.CorrectionWh, &b.DistanceKm); err != nil {
+			return nil, err
+		}
+		b.EnergyWh = b.ModeledWh + b.CorrectionWh
+		out = append(out, b)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,108 @@
+// Package containers sobe em contêineres as dependências de verdade dos
+// testes e benchmarks que medem o banco: um Postgres igual ao do
+// docker-compose.yml, com database/init.sql aplicado. Sem Docker, quem
+// pede o banco é pulado. Para usar um Postgres já de pé, com o schema
+// aplicado, defina SMARTCITY_TEST_DATABASE_URL.
+package containers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// EnvDatabaseURL aponta para um Postgres com database/init.sql aplicado,
+// usado no lugar do contêiner.
+const EnvDatabaseURL = "SMARTCITY_TEST_DATABASE_URL"
+
+// postgresImage é a imagem do serviço postgres do docker-compose.yml.
+const postgresImage = "postgres:15-alpine"
+
+// O contêiner é um só por binário de teste: um benchmark roda várias vezes
+// até estabilizar b.N, e subir o banco a cada rodada mediria o Docker. O
+// Ryuk do testcontainers o remove quando o processo termina.
+var (
+	postgresOnce sync.Once
+	postgresURL  string
+	postgresErr  error
+	postgresSkip string
+)
+
+// Postgres retorna uma conexão com o banco de teste, fechada no fim de tb.
+// Os dados gravados ficam para os próximos testes do binário: quem grava
+// usa uma transação desfeita ou IDs próprios.
+func Postgres(tb testing.TB) *sql.DB {
+	tb.Helper()
+	url := os.Getenv(EnvDatabaseURL)
+	if url == "" {
+		postgresOnce.Do(startPostgres)
+		if postgresSkip != "" {
+			tb.Skip(postgresSkip)
+		}
+		if postgresErr != nil {
+			tb.Fatalf("Falha ao subir o Postgres de teste: %v", postgresErr)
+		}
+		url = postgresURL
+	}
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { db.Close() })
+	return db
+}
+
+func startPostgres() {
+	if reason := dockerUnavailable(); reason != "" {
+		postgresSkip = fmt.Sprintf("Docker indisponível e %s não definido: %s", EnvDatabaseURL, reason)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	c, err := postgres.RunContainer(ctx,
+		testcontainers.WithImage(postgresImage),
+		postgres.WithDatabase("smart_city"),
+		postgres.WithUsername("postgres"),
+		postgres.WithPassword("password"),
+		postgres.WithInitScripts(initScript()),
+		// O Postgres reinicia depois dos scripts de inicialização: a
+		// primeira mensagem de pronto ainda é do servidor temporário.
+		testcontainers.WithWaitStrategy(wait.ForLog("database system is ready to accept connections").
+			WithOccurrence(2).WithStartupTimeout(time.Minute)),
+	)
+	if err != nil {
+		postgresErr = err
+		return
+	}
+	postgresURL, postgresErr = c.ConnectionString(ctx, "sslmode=disable")
+}
+
+// dockerUnavailable retorna por que o Docker não responde, ou "".
+func dockerUnavailable() string {
+	provider, err := testcontainers.ProviderDocker.GetProvider()
+	if err != nil {
+		return err.Error()
+	}
+	defer provider.Close()
+	if err := provider.Health(context.Background()); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// initScript é o caminho de database/init.sql, na raiz do repositório.
+func initScript() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "..", "..", "..", "database", "init.sql")
+}
@@ -0,0 +1,422 @@
+// Package deadletter guarda os eventos que um consumidor do barramento não
+// conseguiu processar depois de esgotar as tentativas, com o erro da
+// última, num stream Redis compartilhado pelas réplicas. As entradas podem
+// ser inspecionadas e reprocessadas pela API de administração e expiram
+// depois da retenção configurada.
+//
+// Cada consumidor que grava entradas registra um Replayer, que recebe a
+// entrada de volta no reprocessamento: o dispatcher de webhooks a reenvia
+// ao webhook, o repasse do hub a republica para as outras réplicas.
+//
+// A profundidade da fila por consumidor é a métrica
+// agent_service_dead_letter_depth, recalculada a cada intervalo. Exemplo de
+// regra de alerta do Prometheus:
+//
+//   - alert: DeadLetterGrowing
+//     expr: max by (consumer) (agent_service_dead_letter_depth) > 100
+//     for: 15m
+//     labels:
+//     severity: warning
+//     annotations:
+//     summary: "{{ $labels.consumer }} com {{ $value }} eventos no dead-letter"
+package deadletter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+
+	"smart-city-microservices/internal/logging"
+)
+
+// Campos de cada entrada do stream.
+const (
+	fieldConsumer  = "consumer"
+	fieldTarget    = "target"
+	fieldEventType = "event_type"
+	fieldTopic     = "topic"
+	fieldPayload   = "payload"
+	fieldError     = "error"
+	fieldAttempts  = "attempts"
+	fieldRetries   = "retries"
+	fieldFailedAt  = "failed_at"
+	fieldMeta      = "meta"
+)
+
+// scanBatch é o número de entradas lidas do stream por XREVRANGE.
+const scanBatch = 500
+
+var (
+	deadLettered = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "dead_letter_events_total",
+		Help:      "Eventos gravados no dead-letter por consumidor.",
+	}, []string{"consumer"})
+
+	depth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "agent_service",
+		Name:      "dead_letter_depth",
+		Help:      "Entradas no dead-letter por consumidor, na última contagem.",
+	}, []string{"consumer"})
+
+	retries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "dead_letter_retries_total",
+		Help:      "Reprocessamentos de entradas do dead-letter por consumidor e resultado (delivered, failed).",
+	}, []string{"consumer", "result"})
+)
+
+var (
+	// ErrNotFound indica uma entrada inexistente, expirada ou já
+	// reprocessada.
+	ErrNotFound = errors.New("dead-letter entry not found")
+	// ErrNoReplayer indica um consumidor sem Replayer nesta réplica, por
+	// exemplo com a funcionalidade desligada.
+	ErrNoReplayer = errors.New("dead-letter consumer cannot be replayed here")
+)
+
+// Entry é um evento que um consumidor não processou.
+type Entry struct {
+	// ID é o id da entrada no stream; muda a cada reprocessamento que falha.
+	ID string `json:"id"`
+	// Consumer é quem falhou (webhook, hub_relay); Target, o destino dentro
+	// dele, como o id do webhook.
+	Consumer  string `json:"consumer"`
+	Target    string `json:"target,omitempty"`
+	EventType string `json:"event_type"`
+	Topic     string `json:"topic,omitempty"`
+	// Payload é o que o consumidor tentou entregar, normalmente o envelope
+	// do evento.
+	Payload json.RawMessage `json:"payload"`
+	Error   string          `json:"error"`
+	// Attempts são as tentativas feitas antes da entrada; Retries, os
+	// reprocessamentos que falharam desde então.
+	Attempts int               `json:"attempts"`
+	Retries  int               `json:"retries"`
+	FailedAt time.Time         `json:"failed_at"`
+	Meta     map[string]string `json:"meta,omitempty"`
+}
+
+// Filter seleciona as entradas de List e RetryAll; campos vazios aceitam
+// todas.
+type Filter struct {
+	Consumer  string
+	EventType string
+	// Before pagina List: só entradas mais antigas que o id informado.
+	Before string
+	Limit  int
+}
+
+func (f Filter) match(e Entry) bool {
+	return (f.Consumer == "" || e.Consumer == f.Consumer) && (f.EventType == "" || e.EventType == f.EventType)
+}
+
+// Replayer reprocessa as entradas de um consumidor. Um erro mantém a
+// entrada na fila, com o novo erro.
+type Replayer interface {
+	Replay(ctx context.Context, e Entry) error
+}
+
+// Config configura a fila.
+type Config struct {
+	Stream string
+	// MaxLen limita o stream (aproximadamente), além da retenção.
+	MaxLen int64
+	// Retention é a idade a partir da qual as entradas são removidas.
+	Retention time.Duration
+	// Interval é o intervalo da expiração e da contagem da profundidade.
+	Interval time.Duration
+}
+
+// Queue é o dead-letter. É seguro para uso concorrente.
+type Queue struct {
+	redis redis.UniversalClient
+	cfg   Config
+
+	mu        sync.RWMutex
+	replayers map[string]Replayer
+}
+
+// New cria a fila; a expiração e a métrica de profundidade rodam em Run.
+func New(client redis.UniversalClient, cfg Config) *Queue {
+	return &Queue{redis: client, cfg: cfg, replayers: map[string]Replayer{}}
+}
+
+// Register associa o Replayer às entradas de consumer.
+func (q *Queue) Register(consumer string, r Replayer) {
+	q.mu.Lock()
+	q.replayers[consumer] = r
+	q.mu.Unlock()
+	depth.WithLabelValues(consumer)
+}
+
+func (q *Queue) replayer(consumer string) (Replayer, bool) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	r, ok := q.replayers[consumer]
+	return r, ok
+}
+
+// Add grava a entrada e preenche ID e, se vazio, FailedAt.
+func (q *Queue) Add(ctx context.Context, e *Entry) error {
+	if e.FailedAt.IsZero() {
+		e.FailedAt = time.Now().UTC()
+	}
+	meta, err := json.Marshal(e.Meta)
+	if err != nil {
+		return err
+	}
+	id, err := q.redis.XAdd(ctx, &redis.XAddArgs{
+		Stream: q.cfg.Stream,
+		MaxLen: q.cfg.MaxLen,
+		Approx: true,
+		Values: map[string]interface{}{
+			fieldConsumer:  e.Consumer,
+			fieldTarget:    e.Target,
+			fieldEventType: e.EventType,
+			fieldTopic:     e.Topic,
+			fieldPayload:   []byte(e.Payload),
+			fieldError:     e.Error,
+			fieldAttempts:  e.Attempts,
+			fieldRetries:   e.Retries,
+			fieldFailedAt:  e.FailedAt.Format(time.RFC3339Nano),
+			fieldMeta:      meta,
+		},
+	}).Result()
+	if err != nil {
+		return fmt.Errorf("deadletter: gravar entrada: %w", err)
+	}
+	e.ID = id
+	deadLettered.WithLabelValues(e.Consumer).Inc()
+	return nil
+}
+
+// List retorna as entradas que passam no filtro, da mais nova para a mais
+// antiga, e o cursor da página seguinte (vazio na última).
+func (q *Queue) List(ctx context.Context, f Filter) ([]Entry, string, error) {
+	out := []Entry{}
+	err := q.scan(ctx, f.Before, func(e Entry) bool {
+		if f.match(e) {
+			out = append(out, e)
+		}
+		return len(out) < f.Limit
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	next := ""
+	if len(out) == f.Limit {
+		next = out[len(out)-1].ID
+	}
+	return out, next, nil
+}
+
+// scan percorre o stream do id before (exclusivo; vazio é o fim) para trás
+// até fn retornar false.
+func (q *Queue) scan(ctx context.Context, before string, fn func(Entry) bool) error {
+	start := "+"
+	if before != "" {
+		start = "(" + before
+	}
+	for {
+		msgs, err := q.redis.XRevRangeN(ctx, q.cfg.Stream, start, "-", scanBatch).Result()
+		if err != nil {
+			return err
+		}
+		for _, m := range msgs {
+			if !fn(decode(m)) {
+				return nil
+			}
+		}
+		if len(msgs) < scanBatch {
+			return nil
+		}
+		start = "(" + msgs[len(msgs)-1].ID
+	}
+}
+
+// ValidID indica se id tem o formato de um id de stream (ms-seq).
+func ValidID(id string) bool {
+	ms, seq, ok := strings.Cut(id, "-")
+	if !ok {
+		return false
+	}
+	_, err1 := strconv.ParseUint(ms, 10, 64)
+	_, err2 := strconv.ParseUint(seq, 10, 64)
+	return err1 == nil && err2 == nil
+}
+
+// Get busca uma entrada pelo id.
+func (q *Queue) Get(ctx context.Context, id string) (Entry, error) {
+	if !ValidID(id) {
+		return Entry{}, ErrNotFound
+	}
+	msgs, err := q.redis.XRangeN(ctx, q.cfg.Stream, id, id, 1).Result()
+	if err != nil {
+		return Entry{}, err
+	}
+	if len(msgs) == 0 {
+		return Entry{}, ErrNotFound
+	}
+	return decode(msgs[0]), nil
+}
+
+// RetryResult é o resultado do reprocessamento de uma entrada.
+type RetryResult struct {
+	ID string `json:"id"`
+	// Status é delivered ou failed; com failed, a entrada volta à fila com
+	// o id NewID.
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	NewID  string `json:"new_id,omitempty"`
+}
+
+// Retry reprocessa a entrada id. A entrada é removida antes do
+// reprocessamento, o que impede que duas chamadas simultâneas a entreguem
+// duas vezes; se ele falha, ela volta à fila com o novo erro.
+func (q *Queue) Retry(ctx context.Context, id string) (RetryResult, error) {
+	e, err := q.Get(ctx, id)
+	if err != nil {
+		return RetryResult{}, err
+	}
+	return q.retry(ctx, e)
+}
+
+func (q *Queue) retry(ctx context.Context, e Entry) (RetryResult, error) {
+	r, ok := q.replayer(e.Consumer)
+	if !ok {
+		return RetryResult{}, fmt.Errorf("%w: %s", ErrNoReplayer, e.Consumer)
+	}
+	n, err := q.redis.XDel(ctx, q.cfg.Stream, e.ID).Result()
+	if err != nil {
+		return RetryResult{}, err
+	}
+	if n == 0 {
+		return RetryResult{}, ErrNotFound
+	}
+	res := RetryResult{ID: e.ID, Status: "delivered"}
+	cause := r.Replay(ctx, e)
+	if cause == nil {
+		retries.WithLabelValues(e.Consumer, "delivered").Inc()
+		return res, nil
+	}
+	retries.WithLabelValues(e.Consumer, "failed").Inc()
+	res.Status, res.Error = "failed", cause.Error()
+	e.Error, e.Retries, e.FailedAt = cause.Error(), e.Retries+1, time.Time{}
+	if err := q.Add(ctx, &e); err != nil {
+		return res, err
+	}
+	res.NewID = e.ID
+	return res, nil
+}
+
+// RetryAll reprocessa até f.Limit entradas que passam no filtro, das mais
+// antigas para as mais novas. Entradas de consumidores sem Replayer nesta
+// réplica são ignoradas.
+func (q *Queue) RetryAll(ctx context.Context, f Filter) ([]RetryResult, error) {
+	var batch []Entry
+	err := q.scan(ctx, "", func(e Entry) bool {
+		if _, ok := q.replayer(e.Consumer); ok && f.match(e) {
+			batch = append(batch, e)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	// scan vai do mais novo ao mais antigo; o reprocessamento segue a ordem
+	// das falhas.
+	if len(batch) > f.Limit {
+		batch = batch[len(batch)-f.Limit:]
+	}
+	out := make([]RetryResult, 0, len(batch))
+	for i := len(batch) - 1; i >= 0; i-- {
+		res, err := q.retry(ctx, batch[i])
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return out, err
+		}
+		out = append(out, res)
+	}
+	return out, nil
+}
+
+// Run remove as entradas mais velhas que a retenção e recalcula a
+// profundidade a cada Interval, até ctx ser cancelado. Todas as réplicas
+// fazem o mesmo, sem conflito.
+func (q *Queue) Run(ctx context.Context) error {
+	ctx = logging.Background(ctx, "dead-letter")
+	log := logging.FromContext(ctx)
+	ticker := time.NewTicker(q.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		if err := q.maintain(ctx); err != nil && ctx.Err() == nil {
+			log.WithError(err).Warn("Falha na manutenção do dead-letter")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (q *Queue) maintain(ctx context.Context) error {
+	minID := strconv.FormatInt(time.Now().Add(-q.cfg.Retention).UnixMilli(), 10)
+	if err := q.redis.XTrimMinID(ctx, q.cfg.Stream, minID).Err(); err != nil {
+		return err
+	}
+	counts := map[string]int{}
+	q.mu.RLock()
+	for consumer := range q.replayers {
+		counts[consumer] = 0
+	}
+	q.mu.RUnlock()
+	if err := q.scan(ctx, "", func(e Entry) bool {
+		counts[e.Consumer]++
+		return true
+	}); err != nil {
+		return err
+	}
+	for consumer, n := range counts {
+		depth.WithLabelValues(consumer).Set(float64(n))
+	}
+	return nil
+}
+
+func decode(m redis.XMessage) Entry {
+	str := func(k string) string {
+		s, _ := m.Values[k].(string)
+		return s
+	}
+	e := Entry{
+		ID:        m.ID,
+		Consumer:  str(fieldConsumer),
+		Target:    str(fieldTarget),
+		EventType: str(fieldEventType),
+		Topic:     str(fieldTopic),
+		Payload:   json.RawMessage(str(fieldPayload)),
+		Error:     str(fieldError),
+	}
+	e.Attempts, _ = strconv.Atoi(str(fieldAttempts))
+	e.Retries, _ = strconv.Atoi(str(fieldRetries))
+	e.FailedAt, _ = time.Parse(time.RFC3339Nano, str(fieldFailedAt))
+	_ = json.Unmarshal([]byte(str(fieldMeta)), &e.Meta)
+	if !json.Valid(e.Payload) {
+		// Payload que não é JSON vai como string, para a listagem continuar
+		// serializável.
+		e.Payload, _ = json.Marshal(str(fieldPayload))
+	}
+	return e
+}
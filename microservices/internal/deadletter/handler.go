@@ -0,0 +1,142 @@
+package deadletter
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/audit"
+	"smart-city-microservices/internal/i18n"
+	"smart-city-microservices/internal/logging"
+)
+
+// Limites das listagens e do reprocessamento em lote.
+const (
+	defaultListLimit  = 50
+	maxListLimit      = 500
+	defaultRetryLimit = 100
+	maxRetryLimit     = 1000
+)
+
+// Handler expõe o dead-letter em /admin/dlq. As rotas devem ser
+// registradas atrás de auth.RequireRole(auth.RoleAdmin).
+type Handler struct {
+	queue *Queue
+}
+
+// NewHandler cria o handler do dead-letter.
+func NewHandler(queue *Queue) *Handler {
+	return &Handler{queue: queue}
+}
+
+// RetryAllRequest é o corpo de POST /admin/dlq/retry: reprocessa as
+// entradas do filtro, das mais antigas para as mais novas.
+type RetryAllRequest struct {
+	Consumer  string `json:"consumer"`
+	EventType string `json:"event_type"`
+	Limit     int    `json:"limit"`
+}
+
+// List responde GET /admin/dlq?consumer=&event_type=&limit=&before=.
+func (h *Handler) List(c *gin.Context) {
+	limit := defaultListLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit: " + v})
+			return
+		}
+		limit = min(n, maxListLimit)
+	}
+	before := c.Query("before")
+	if before != "" && !ValidID(before) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid before: " + before})
+		return
+	}
+	entries, next, err := h.queue.List(c.Request.Context(), Filter{
+		Consumer:  c.Query("consumer"),
+		EventType: c.Query("event_type"),
+		Before:    before,
+		Limit:     limit,
+	})
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	resp := gin.H{"data": entries}
+	if next != "" {
+		resp["next_cursor"] = next
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// Retry responde POST /admin/dlq/:id/retry. Um reprocessamento que falha
+// responde 200 com status failed e o id com que a entrada voltou à fila.
+func (h *Handler) Retry(c *gin.Context) {
+	ctx := c.Request.Context()
+	res, err := h.queue.Retry(ctx, c.Param("id"))
+	if err != nil {
+		h.serviceError(c, err)
+		return
+	}
+	audit.Record(ctx, "dead_letter.retried", logrus.Fields{"entry_id": res.ID, "status": res.Status})
+	c.JSON(http.StatusOK, res)
+}
+
+// RetryAll responde POST /admin/dlq/retry.
+func (h *Handler) RetryAll(c *gin.Context) {
+	var req RetryAllRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		i18n.BindError(c, err)
+		return
+	}
+	if req.Limit < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit: " + strconv.Itoa(req.Limit)})
+		return
+	}
+	if req.Limit == 0 {
+		req.Limit = defaultRetryLimit
+	}
+	req.Limit = min(req.Limit, maxRetryLimit)
+	ctx := c.Request.Context()
+	results, err := h.queue.RetryAll(ctx, Filter{Consumer: req.Consumer, EventType: req.EventType, Limit: req.Limit})
+	if err != nil && len(results) == 0 {
+		h.internalError(c, err)
+		return
+	}
+	delivered := 0
+	for _, r := range results {
+		if r.Status == "delivered" {
+			delivered++
+		}
+	}
+	audit.Record(ctx, "dead_letter.bulk_retried", logrus.Fields{
+		"consumer": req.Consumer, "event_type": req.EventType, "retried": len(results), "delivered": delivered,
+	})
+	resp := gin.H{"data": results, "retried": len(results), "delivered": delivered, "failed": len(results) - delivered}
+	if err != nil {
+		// Parou no meio: o que foi feito vai na resposta.
+		logging.FromContext(ctx).WithError(err).Warn("Reprocessamento em lote do dead-letter interrompido")
+		resp["error"] = "bulk retry interrupted"
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (h *Handler) serviceError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrNoReplayer):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		h.internalError(c, err)
+	}
+}
+
+func (h *Handler) internalError(c *gin.Context, err error) {
+	logging.FromContext(c.Request.Context()).WithError(err).Error("Erro no handler do dead-letter")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
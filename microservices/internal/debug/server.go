@@ -0,0 +1,140 @@
+// Package debug expõe pprof, expvar e diagnósticos de runtime em um listener
+// administrativo separado do tráfego da API.
+package debug
+
+import (
+	"bufio"
+	"bytes"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"time"
+
+	ginpprof "github.com/gin-contrib/pprof"
+	"github.com/gin-gonic/gin"
+
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/buildinfo"
+)
+
+// Config configura o listener de diagnóstico.
+type Config struct {
+	Host  string
+	Port  string
+	Token string
+}
+
+// Addr retorna o endereço de escuta.
+func (c Config) Addr() string {
+	return net.JoinHostPort(c.Host, c.Port)
+}
+
+// NewServer cria o servidor HTTP de diagnóstico. Sem token configurado, o
+// listener só é aceito em endereços de loopback.
+func NewServer(cfg Config) (*http.Server, error) {
+	if cfg.Token == "" && !isLoopback(cfg.Host) {
+		return nil, fmt.Errorf("debug.token é obrigatório para escutar em %s", cfg.Host)
+	}
+
+	router := gin.New()
+	router.Use(gin.Recovery())
+
+	group := router.Group("/debug", requireAdmin(cfg.Token))
+	ginpprof.RouteRegister(group, "pprof")
+	group.GET("/vars", gin.WrapH(expvar.Handler()))
+	group.GET("/goroutines", goroutines)
+	group.GET("/buildinfo", func(c *gin.Context) {
+		c.JSON(http.StatusOK, buildinfo.Get())
+	})
+
+	return &http.Server{
+		Addr:              cfg.Addr(),
+		Handler:           router,
+		ReadHeaderTimeout: 5 * time.Second,
+	}, nil
+}
+
+// requireAdmin aceita o token estático ou um principal admin. Sem token
+// configurado, chamadas de loopback são aceitas.
+func requireAdmin(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		auth.AuthenticateStaticToken(c, token)
+		if auth.FromGin(c).HasRole(auth.RoleAdmin) {
+			c.Next()
+			return
+		}
+		if token == "" && isLoopback(clientHost(c.Request.RemoteAddr)) {
+			c.Next()
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "admin credentials required"})
+	}
+}
+
+type goroutineSite struct {
+	Site  string `json:"site"`
+	Count int    `json:"count"`
+}
+
+// goroutines agrupa as goroutines vivas pelo ponto de criação.
+func goroutines(c *gin.Context) {
+	var buf bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, 2); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	counts := map[string]int{}
+	scanner := bufio.NewScanner(&buf)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "created by ") {
+			continue
+		}
+		site := strings.TrimPrefix(line, "created by ")
+		if i := strings.Index(site, " in goroutine "); i >= 0 {
+			site = site[:i]
+		}
+		if scanner.Scan() {
+			loc := strings.TrimSpace(scanner.Text())
+			if i := strings.LastIndex(loc, " +0x"); i >= 0 {
+				loc = loc[:i]
+			}
+			site += " (" + loc + ")"
+		}
+		counts[site]++
+	}
+
+	sites := make([]goroutineSite, 0, len(counts))
+	for site, n := range counts {
+		sites = append(sites, goroutineSite{Site: site, Count: n})
+	}
+	sort.Slice(sites, func(i, j int) bool { return sites[i].Count > sites[j].Count })
+
+	c.JSON(http.StatusOK, gin.H{
+		"total": runtime.NumGoroutine(),
+		"sites": sites,
+	})
+}
+
+func clientHost(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
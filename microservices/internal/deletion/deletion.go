@@ -0,0 +1,305 @@
+// Package deletion apaga simulações em segundo plano. Uma simulação com
+// dezenas de milhões de eventos não cabe numa única instrução: o cascade
+// estoura o timeout e a réplica atrasa. Aqui cada tabela filha sai em lotes
+// limitados, com uma pausa entre eles, e a linha da simulação só sai no
+// fim, derrubando em cascata o que restou nas tabelas pequenas.
+//
+// O pedido (DELETE /api/v1/simulations/:id) grava o job em
+// simulation_deletions e marca a simulação como deleting; uma réplica por
+// vez, eleita no Redis, executa os jobs. O progresso de cada etapa fica no
+// job depois de cada lote, e um job interrompido continua da etapa em que
+// parou, na mesma réplica ou em outra.
+package deletion
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/lease"
+	"smart-city-microservices/internal/logging"
+	"smart-city-microservices/internal/supervisor"
+)
+
+// leaderKey guarda a réplica que executa as exclusões.
+const leaderKey = "agent-service:simulation-deletions:leader"
+
+// TypeSimulationDelete é o tipo dos jobs deste pacote em GET /jobs/:id.
+const TypeSimulationDelete = "simulation.delete"
+
+// StatusDeleting é o status da simulação enquanto o job a apaga.
+const StatusDeleting = "deleting"
+
+// Situações de um job.
+const (
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+)
+
+// Situações de uma etapa.
+const (
+	StepPending = "pending"
+	StepRunning = "running"
+	StepDone    = "done"
+)
+
+var deletedRows = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "agent_service",
+	Name:      "simulation_deletion_rows_total",
+	Help:      "Linhas apagadas pelas exclusões de simulações, por etapa.",
+}, []string{"step"})
+
+// step é uma etapa da exclusão: as linhas de table que pertencem à
+// simulação ($1), identificadas por key.
+type step struct {
+	name, table, key, where string
+}
+
+// byAgent filtra as tabelas sem simulation_id pelos agentes da simulação.
+const byAgent = "agent_id IN (SELECT id FROM agents WHERE simulation_id = $1)"
+
+// steps são as etapas, na ordem: as tabelas grandes primeiro, os agentes
+// (com as tabelas pequenas que caem em cascata com eles) e por fim a
+// simulação. As tabelas particionadas sem chave usam tableoid e ctid.
+var steps = []step{
+	{name: "events", table: "events", key: "id", where: "simulation_id = $1"},
+	{name: "interactions", table: "interactions", key: "id", where: "simulation_id = $1"},
+	{name: "metrics", table: "metrics", key: "id", where: "simulation_id = $1"},
+	{name: "agent_metric_samples", table: "agent_metric_samples", key: "ctid", where: byAgent},
+	{name: "agent_actions", table: "agent_actions", key: "id, created_at", where: byAgent},
+	{name: "agent_positions", table: "agent_positions", key: "tableoid, ctid", where: "simulation_id = $1"},
+	{name: "agent_positions_compacted", table: "agent_positions_compacted", key: "tableoid, ctid", where: "simulation_id = $1"},
+	{name: "collective_learning", table: "collective_learning", key: "id", where: "simulation_id = $1"},
+	{name: "optimizations", table: "optimizations", key: "id", where: "simulation_id = $1"},
+	{name: "agents", table: "agents", key: "id", where: "simulation_id = $1"},
+	{name: "simulation", table: "simulations", key: "id", where: "id = $1"},
+}
+
+// Step é o progresso de uma etapa. Total são as linhas encontradas no
+// início dela; ausente enquanto pendente.
+type Step struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Deleted int64  `json:"deleted"`
+	Total   *int64 `json:"total,omitempty"`
+}
+
+// Job é uma exclusão de simulação, para GET /api/v1/jobs/:id.
+type Job struct {
+	ID           string `json:"id"`
+	Type         string `json:"type"`
+	SimulationID string `json:"simulation_id"`
+	ProjectID    string `json:"project_id,omitempty"`
+	Status       string `json:"status"`
+	// Step é a etapa em andamento, ausente no fim.
+	Step        string `json:"step,omitempty"`
+	Steps       []Step `json:"steps"`
+	DeletedRows int64  `json:"deleted_rows"`
+	// LastError é a última falha; o job é tentado de novo no próximo ciclo.
+	LastError   string     `json:"last_error,omitempty"`
+	RequestedBy string     `json:"requested_by,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+}
+
+// newSteps é o progresso de um job novo.
+func newSteps() []Step {
+	out := make([]Step, len(steps))
+	for i, s := range steps {
+		out[i] = Step{Name: s.name, Status: StepPending}
+	}
+	return out
+}
+
+// current atualiza Step com a primeira etapa não concluída.
+func (j *Job) current() {
+	j.Step = ""
+	for _, s := range j.Steps {
+		if s.Status != StepDone {
+			j.Step = s.Name
+			return
+		}
+	}
+}
+
+// Config configura as exclusões.
+type Config struct {
+	// Interval é o intervalo em que os jobs pendentes são procurados; os
+	// pedidos feitos na réplica que executa começam na hora.
+	Interval time.Duration
+	// BatchSize limita as linhas apagadas por instrução.
+	BatchSize int
+	// Pause é a espera entre dois lotes, para a réplica acompanhar.
+	Pause time.Duration
+}
+
+// Deleter executa as exclusões.
+type Deleter struct {
+	repo   *Repository
+	leader *lease.Lease
+	cfg    Config
+	wake   chan struct{}
+}
+
+// New cria as exclusões; id identifica a réplica na disputa pela execução,
+// que roda em Run.
+func New(repo *Repository, client redis.UniversalClient, cfg Config, id string) *Deleter {
+	return &Deleter{repo: repo, leader: lease.New(client, leaderKey, id), cfg: cfg, wake: make(chan struct{}, 1)}
+}
+
+// Request pede a exclusão da simulação ou retorna o job que já a apaga;
+// created indica um job novo. ErrRunning recusa a simulação em execução.
+func (d *Deleter) Request(ctx context.Context, simulationID, projectID, status, requestedBy string) (job *Job, created bool, err error) {
+	if job, err = d.repo.Active(ctx, simulationID); err != nil || job != nil {
+		return job, false, err
+	}
+	if status == "running" {
+		return nil, false, ErrRunning
+	}
+	job, err = d.repo.Create(ctx, simulationID, projectID, requestedBy, newSteps())
+	if errors.Is(err, errConflict) {
+		// Outro pedido criou o job antes deste.
+		job, err = d.repo.Active(ctx, simulationID)
+		return job, false, err
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+	return job, true, nil
+}
+
+// Get retorna o job, ou ErrNotFound.
+func (d *Deleter) Get(ctx context.Context, id string) (*Job, error) {
+	return d.repo.Get(ctx, id)
+}
+
+// Run executa os jobs pendentes a cada intervalo, ou logo depois de um
+// pedido nesta réplica, até ctx ser cancelado. O cancelamento interrompe o
+// job entre dois lotes.
+func (d *Deleter) Run(ctx context.Context) error {
+	work := logging.Background(context.WithoutCancel(ctx), "simulation-deletion")
+	defer d.release(work)
+	ticker := time.NewTicker(d.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		d.cycle(ctx, work)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// lead disputa a execução, ou a renova se já é desta réplica.
+func (d *Deleter) lead(ctx context.Context) (bool, error) {
+	return d.leader.Acquire(ctx, 3*d.cfg.Interval)
+}
+
+// release remove a chave só se ainda for desta réplica.
+func (d *Deleter) release(ctx context.Context) {
+	ctx, cancel := supervisor.Cleanup(ctx)
+	defer cancel()
+	d.leader.Release(ctx)
+}
+
+func (d *Deleter) cycle(ctx, work context.Context) {
+	log := logging.FromContext(work)
+	if leader, err := d.lead(work); err != nil {
+		log.WithError(err).Warn("Falha ao disputar a exclusão de simulações")
+		return
+	} else if !leader {
+		return
+	}
+	jobs, err := d.repo.Running(work)
+	if err != nil {
+		log.WithError(err).Error("Falha ao listar as exclusões de simulações pendentes")
+		return
+	}
+	for _, job := range jobs {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := d.execute(ctx, work, job); err != nil {
+			log.WithError(err).WithFields(logrus.Fields{"job_id": job.ID, "simulation_id": job.SimulationID, "step": job.Step}).
+				Error("Falha ao apagar a simulação; o job continua no próximo ciclo")
+			job.LastError = err.Error()
+			if err := d.repo.Save(work, job); err != nil {
+				log.WithError(err).WithField("job_id", job.ID).Warn("Falha ao gravar o erro da exclusão")
+			}
+		}
+	}
+}
+
+// execute apaga as etapas não concluídas do job, um lote por vez, gravando
+// o progresso depois de cada um.
+func (d *Deleter) execute(ctx, work context.Context, job *Job) error {
+	log := logging.FromContext(work).WithFields(logrus.Fields{"job_id": job.ID, "simulation_id": job.SimulationID})
+	if job.DeletedRows > 0 || job.LastError != "" {
+		log.WithField("step", job.Step).Info("Retomando a exclusão da simulação")
+	}
+	for i, s := range steps {
+		st := &job.Steps[i]
+		if st.Status == StepDone {
+			continue
+		}
+		if st.Total == nil {
+			n, err := d.repo.Count(work, s, job.SimulationID)
+			if err != nil {
+				return err
+			}
+			st.Total, st.Status = &n, StepRunning
+			job.current()
+			if err := d.repo.Save(work, job); err != nil {
+				return err
+			}
+		}
+		for {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if leader, err := d.lead(work); err != nil || !leader {
+				return err
+			}
+			n, err := d.repo.DeleteBatch(work, s, job.SimulationID, d.cfg.BatchSize)
+			if err != nil {
+				return err
+			}
+			deletedRows.WithLabelValues(s.name).Add(float64(n))
+			st.Deleted += n
+			job.DeletedRows += n
+			job.LastError = ""
+			if n == 0 {
+				st.Status = StepDone
+			}
+			job.current()
+			if err := d.repo.Save(work, job); err != nil {
+				return err
+			}
+			if n == 0 {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(d.cfg.Pause):
+			}
+		}
+	}
+	if err := d.repo.Finish(work, job); err != nil {
+		return err
+	}
+	log.WithField("deleted_rows", job.DeletedRows).Info("Simulação apagada")
+	return nil
+}
@@ -0,0 +1,89 @@
+package deletion
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/audit"
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/logging"
+)
+
+// SimulationGetter é o subconjunto de agent.Service usado pelo handler.
+type SimulationGetter interface {
+	GetSimulation(ctx context.Context, id string) (*agent.Simulation, error)
+}
+
+// Handler expõe a exclusão das simulações e o progresso dos jobs.
+type Handler struct {
+	deleter     *Deleter
+	simulations SimulationGetter
+}
+
+// NewHandler cria o handler das exclusões.
+func NewHandler(deleter *Deleter, simulations SimulationGetter) *Handler {
+	return &Handler{deleter: deleter, simulations: simulations}
+}
+
+// Delete responde DELETE /simulations/:id com 202 e o job da exclusão, que
+// segue em segundo plano. Repetir o pedido enquanto o job anda responde o
+// mesmo job; uma simulação em execução responde 409.
+func (h *Handler) Delete(c *gin.Context) {
+	ctx := c.Request.Context()
+	sim, err := h.simulations.GetSimulation(ctx, c.Param("id"))
+	if errors.Is(err, agent.ErrNotFound) || (err == nil && !auth.FromGin(c).InProject(sim.ProjectID)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "simulation not found"})
+		return
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	var requestedBy string
+	if p := auth.FromGin(c); p != nil {
+		requestedBy = p.Subject
+	}
+	job, created, err := h.deleter.Request(ctx, sim.ID, sim.ProjectID, sim.Status, requestedBy)
+	if errors.Is(err, ErrRunning) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	if job == nil {
+		// A simulação saiu entre a leitura e o pedido.
+		c.JSON(http.StatusNotFound, gin.H{"error": "simulation not found"})
+		return
+	}
+	if created {
+		audit.Record(ctx, "simulation.deletion_requested", logrus.Fields{"simulation_id": sim.ID, "job_id": job.ID})
+	}
+	c.Header("Location", "/api/v1/jobs/"+job.ID)
+	c.JSON(http.StatusAccepted, job)
+}
+
+// Job responde GET /jobs/:id com o progresso do job.
+func (h *Handler) Job(c *gin.Context) {
+	job, err := h.deleter.Get(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, ErrNotFound) || (err == nil && !auth.FromGin(c).InProject(job.ProjectID)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "job not found"})
+		return
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+func (h *Handler) internalError(c *gin.Context, err error) {
+	logging.FromContext(c.Request.Context()).WithError(err).Error("Erro no handler de exclusão de simulações")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
@@ -0,0 +1,161 @@
+package deletion
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"smart-city-microservices/internal/instrument"
+)
+
+// ErrNotFound indica um job inexistente.
+var ErrNotFound = errors.New("job not found")
+
+// ErrRunning recusa a exclusão de uma simulação em execução.
+var ErrRunning = errors.New("simulation is running: stop it before deleting it")
+
+// errConflict indica que a simulação já tem um job em andamento.
+var errConflict = errors.New("deletion: job em andamento")
+
+// Repository persiste os jobs em simulation_deletions e apaga os lotes.
+type Repository struct {
+	db *instrument.DB
+}
+
+// NewRepository cria o repositório das exclusões.
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: instrument.NewDB(db)}
+}
+
+const jobColumns = `id, simulation_id, COALESCE(project_id, ''), status, progress, deleted_rows,
+	COALESCE(last_error, ''), COALESCE(requested_by, ''), created_at, updated_at, finished_at`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanJob(row scanner) (*Job, error) {
+	j := Job{Type: TypeSimulationDelete}
+	var progress []byte
+	var finished sql.NullTime
+	err := row.Scan(&j.ID, &j.SimulationID, &j.ProjectID, &j.Status, &progress, &j.DeletedRows,
+		&j.LastError, &j.RequestedBy, &j.CreatedAt, &j.UpdatedAt, &finished)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(progress, &j.Steps); err != nil {
+		return nil, fmt.Errorf("deletion: progresso ilegível no job %s: %w", j.ID, err)
+	}
+	// As etapas acrescentadas depois da criação do job entram pendentes.
+	for i := len(j.Steps); i < len(steps); i++ {
+		j.Steps = append(j.Steps, Step{Name: steps[i].name, Status: StepPending})
+	}
+	if finished.Valid {
+		j.FinishedAt = &finished.Time
+	}
+	j.current()
+	return &j, nil
+}
+
+// Create grava o job e marca a simulação como StatusDeleting, numa única
+// instrução. errConflict indica que a simulação já tem um job em
+// andamento.
+func (r *Repository) Create(ctx context.Context, simulationID, projectID, requestedBy string, progress []Step) (*Job, error) {
+	raw, err := json.Marshal(progress)
+	if err != nil {
+		return nil, err
+	}
+	job, err := scanJob(r.db.QueryRow(ctx, "deletion.create", `
+		WITH marked AS (
+			UPDATE simulations SET status = $4 WHERE id = $1 RETURNING id
+		)
+		INSERT INTO simulation_deletions (simulation_id, project_id, requested_by, progress)
+		SELECT id, NULLIF($2, ''), NULLIF($3, ''), $5 FROM marked
+		ON CONFLICT (simulation_id) WHERE status = 'running' DO NOTHING
+		RETURNING `+jobColumns, simulationID, projectID, requestedBy, StatusDeleting, raw))
+	if errors.Is(err, ErrNotFound) {
+		return nil, errConflict
+	}
+	return job, err
+}
+
+// Get retorna o job, ou ErrNotFound.
+func (r *Repository) Get(ctx context.Context, id string) (*Job, error) {
+	return scanJob(r.db.QueryRow(ctx, "deletion.get",
+		`SELECT `+jobColumns+` FROM simulation_deletions WHERE id::text = $1`, id))
+}
+
+// Active retorna o job em andamento da simulação, ou nil.
+func (r *Repository) Active(ctx context.Context, simulationID string) (*Job, error) {
+	job, err := scanJob(r.db.QueryRow(ctx, "deletion.active",
+		`SELECT `+jobColumns+` FROM simulation_deletions WHERE simulation_id = $1 AND status = 'running'`, simulationID))
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	return job, err
+}
+
+// Running retorna os jobs em andamento, do mais antigo para o mais novo.
+func (r *Repository) Running(ctx context.Context) ([]*Job, error) {
+	rows, err := r.db.Query(ctx, "deletion.running",
+		`SELECT `+jobColumns+` FROM simulation_deletions WHERE status = 'running' ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []*Job
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, j)
+	}
+	return out, rows.Err()
+}
+
+// Save grava o progresso do job.
+func (r *Repository) Save(ctx context.Context, j *Job) error {
+	raw, err := json.Marshal(j.Steps)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.Exec(ctx, "deletion.save", `
+		UPDATE simulation_deletions
+		SET progress = $2, deleted_rows = $3, last_error = NULLIF($4, ''), updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1`, j.ID, raw, j.DeletedRows, j.LastError)
+	return err
+}
+
+// Finish encerra o job.
+func (r *Repository) Finish(ctx context.Context, j *Job) error {
+	return r.db.QueryRow(ctx, "deletion.finish", `
+		UPDATE simulation_deletions
+		SET status = $2, last_error = NULL, updated_at = CURRENT_TIMESTAMP, finished_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING status, updated_at, finished_at`, j.ID, StatusSucceeded).Scan(&j.Status, &j.UpdatedAt, &j.FinishedAt)
+}
+
+// Count conta as linhas da etapa.
+func (r *Repository) Count(ctx context.Context, s step, simulationID string) (int64, error) {
+	var n int64
+	err := r.db.QueryRow(ctx, "deletion.count_"+s.name,
+		`SELECT count(*) FROM `+s.table+` WHERE `+s.where, simulationID).Scan(&n)
+	return n, err
+}
+
+// DeleteBatch apaga até limit linhas da etapa e retorna quantas saíram.
+func (r *Repository) DeleteBatch(ctx context.Context, s step, simulationID string, limit int) (int64, error) {
+	res, err := r.db.Exec(ctx, "deletion.delete_"+s.name, fmt.Sprintf(
+		`DELETE FROM %[1]s WHERE (%[2]s) IN (SELECT %[2]s FROM %[1]s WHERE %[3]s LIMIT $2)`, s.table, s.key, s.where),
+		simulationID, limit)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,83 @@
+// Package dependency guarda as dependências entre agentes (um controlador
+// de semáforo depende dos sensores do cruzamento) e propaga as falhas: quando
+// um agente fica com um status de falha, os que dependem dele, direta ou
+// indiretamente, ficam prejudicados (impaired) com a causa raiz registrada,
+// até que ela se recupere.
+package dependency
+
+import (
+	"strings"
+	"time"
+)
+
+// Eventos publicados em events.TopicAgents.
+const (
+	EventImpaired          = "agent.impaired"
+	EventImpairmentCleared = "agent.impairment_cleared"
+)
+
+// StatusReset é a falha registrada para um agente reiniciado (ver
+// internal/agentreset) até o próximo tick completo da simulação: os que
+// dependem dele ficam prejudicados com essa causa raiz nesse intervalo. Não
+// é um status de agente, e as mudanças de status não a removem.
+const StatusReset = "reset"
+
+// Impairment é o prejuízo de um agente por uma dependência com falha.
+type Impairment struct {
+	AgentID string `json:"-"`
+	// RootCauseID é o agente com falha mais próximo no grafo, e
+	// RootCauseStatus, o status que o fez falhar.
+	RootCauseID     string `json:"root_cause_id"`
+	RootCauseStatus string `json:"root_cause_status"`
+	// Path vai do agente até a causa raiz, os dois incluídos.
+	Path  []string  `json:"path"`
+	Since time.Time `json:"since"`
+}
+
+// same informa se os dois prejuízos têm a mesma causa raiz.
+func (i *Impairment) same(o *Impairment) bool {
+	return i != nil && o != nil && i.RootCauseID == o.RootCauseID && i.RootCauseStatus == o.RootCauseStatus
+}
+
+// Dependencies são as arestas de um agente nos dois sentidos.
+type Dependencies struct {
+	AgentID    string   `json:"agent_id"`
+	DependsOn  []string `json:"depends_on"`
+	Dependents []string `json:"dependents"`
+}
+
+// CycleError indica que as dependências fechariam um ciclo.
+type CycleError struct {
+	// Path vai do agente alterado até ele mesmo.
+	Path []string
+}
+
+func (e *CycleError) Error() string {
+	return "dependency cycle: " + strings.Join(e.Path, " -> ")
+}
+
+// Node é um agente no grafo de dependências. Distance é negativa para os
+// agentes de que ele depende (upstream), positiva para os que dependem dele
+// (downstream) e zero para o próprio agente.
+type Node struct {
+	ID         string      `json:"id"`
+	Name       string      `json:"name"`
+	Type       string      `json:"type"`
+	Status     string      `json:"status"`
+	Distance   int         `json:"distance"`
+	Impairment *Impairment `json:"impairment"`
+}
+
+// Edge indica que AgentID depende de DependsOn.
+type Edge struct {
+	AgentID   string `json:"agent_id"`
+	DependsOn string `json:"depends_on"`
+}
+
+// Graph é a vizinhança de um agente até Depth arestas em cada sentido.
+type Graph struct {
+	AgentID string `json:"agent_id"`
+	Depth   int    `json:"depth"`
+	Nodes   []Node `json:"nodes"`
+	Edges   []Edge `json:"edges"`
+}
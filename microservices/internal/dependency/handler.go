@@ -0,0 +1,209 @@
+package dependency
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/audit"
+	"smart-city-microservices/internal/i18n"
+	"smart-city-microservices/internal/logging"
+)
+
+// Handler expõe as dependências de um agente e o grafo em volta dele.
+type Handler struct {
+	repo       *Repository
+	propagator *Propagator
+	agents     AgentGetter
+	cfg        Config
+}
+
+// Config limita as dependências e o grafo.
+type Config struct {
+	// MaxPerAgent limita as dependências diretas de um agente.
+	MaxPerAgent int
+	// GraphDepth é a profundidade padrão de GET /agents/:id/dependency-graph
+	// e MaxDepth, a maior aceita; MaxDepth também limita a propagação.
+	GraphDepth int
+	MaxDepth   int
+}
+
+// NewHandler cria o handler de dependências.
+func NewHandler(repo *Repository, propagator *Propagator, agents AgentGetter, cfg Config) *Handler {
+	return &Handler{repo: repo, propagator: propagator, agents: agents, cfg: cfg}
+}
+
+// PutRequest é o corpo de PUT /agents/:id/dependencies: a lista completa dos
+// agentes de que ele depende; vazia remove todas.
+type PutRequest struct {
+	DependsOn []string `json:"depends_on" binding:"required"`
+}
+
+// Get retorna as dependências do agente e os que dependem dele.
+func (h *Handler) Get(c *gin.Context) {
+	ag, ok := h.agent(c)
+	if !ok {
+		return
+	}
+	d, err := h.repo.Get(c.Request.Context(), ag.ID)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, d)
+}
+
+// Put troca as dependências do agente. Um ciclo responde 409 com o caminho
+// que o fecharia. Os prejuízos do agente e de quem depende dele são
+// recalculados em seguida.
+func (h *Handler) Put(c *gin.Context) {
+	var req PutRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		i18n.BindError(c, err)
+		return
+	}
+	if len(req.DependsOn) > h.cfg.MaxPerAgent {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("at most %d dependencies per agent", h.cfg.MaxPerAgent)})
+		return
+	}
+	ag, ok := h.agent(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	seen := map[string]bool{}
+	for i, id := range req.DependsOn {
+		u, err := uuid.Parse(id)
+		switch {
+		case err != nil:
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("depends_on[%d]: invalid agent id %q", i, id)})
+			return
+		case u.String() == ag.ID:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "an agent cannot depend on itself"})
+			return
+		case seen[u.String()]:
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("depends_on[%d]: duplicate agent id %q", i, id)})
+			return
+		}
+		seen[u.String()] = true
+		req.DependsOn[i] = u.String()
+		if _, err := h.agents.GetAgent(ctx, req.DependsOn[i]); errors.Is(err, agent.ErrNotFound) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("depends_on[%d]: agent %s not found", i, id)})
+			return
+		} else if err != nil {
+			h.internalError(c, err)
+			return
+		}
+	}
+
+	var cycle *CycleError
+	if err := h.repo.Replace(ctx, ag.ID, req.DependsOn); errors.As(err, &cycle) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "cycle": cycle.Path})
+		return
+	} else if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	audit.Record(ctx, "agent.dependencies.updated", logrus.Fields{"agent_id": ag.ID, "depends_on": req.DependsOn})
+
+	// As arestas já estão gravadas: uma falha aqui só atrasa os prejuízos
+	// até a próxima mudança de status.
+	affected, err := h.repo.Downstream(ctx, []string{ag.ID})
+	if err == nil {
+		err = h.propagator.Reconcile(ctx, append(affected, ag.ID))
+	}
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).WithField("agent_id", ag.ID).Warn("Falha ao recalcular prejuízos após alterar dependências")
+	}
+
+	d, err := h.repo.Get(ctx, ag.ID)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, d)
+}
+
+// Graph responde GET /agents/:id/dependency-graph: os agentes a até ?depth=
+// arestas a montante e a jusante, com as arestas entre eles.
+func (h *Handler) Graph(c *gin.Context) {
+	depth := h.cfg.GraphDepth
+	if v := c.Query("depth"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > h.cfg.MaxDepth {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("depth must be between 1 and %d", h.cfg.MaxDepth)})
+			return
+		}
+		depth = n
+	}
+	ag, ok := h.agent(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	edges, distances, err := h.repo.Graph(ctx, ag.ID, depth)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	ids := make([]string, 0, len(distances))
+	for id := range distances {
+		ids = append(ids, id)
+	}
+	impairments, err := h.repo.Impairments(ctx, ids)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+
+	g := Graph{AgentID: ag.ID, Depth: depth, Nodes: make([]Node, 0, len(ids)), Edges: edges}
+	for _, id := range ids {
+		n := ag
+		if id != ag.ID {
+			if n, err = h.agents.GetAgent(ctx, id); errors.Is(err, agent.ErrNotFound) {
+				continue
+			} else if err != nil {
+				h.internalError(c, err)
+				return
+			}
+		}
+		g.Nodes = append(g.Nodes, Node{
+			ID: n.ID, Name: n.Name, Type: n.Type, Status: n.Status,
+			Distance: distances[id], Impairment: impairments[id],
+		})
+	}
+	sort.Slice(g.Nodes, func(i, j int) bool {
+		if g.Nodes[i].Distance != g.Nodes[j].Distance {
+			return g.Nodes[i].Distance < g.Nodes[j].Distance
+		}
+		return g.Nodes[i].ID < g.Nodes[j].ID
+	})
+	c.JSON(http.StatusOK, g)
+}
+
+// agent busca o agente de :id. Responde ao cliente e retorna false se ele
+// não existe ou a busca falhou.
+func (h *Handler) agent(c *gin.Context) (*agent.Agent, bool) {
+	ag, err := h.agents.GetAgent(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, agent.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "agent not found"})
+		return nil, false
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return nil, false
+	}
+	return ag, true
+}
+
+func (h *Handler) internalError(c *gin.Context, err error) {
+	logging.FromContext(c.Request.Context()).WithError(err).Error("Erro no handler de dependências de agentes")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
@@ -0,0 +1,241 @@
+package dependency
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/logging"
+)
+
+var (
+	droppedEvents = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "dependency_events_dropped_total",
+		Help:      "Eventos de agentes descartados pela propagação de falhas por fila cheia.",
+	})
+
+	impairmentChanges = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "agent_impairment_changes_total",
+		Help:      "Agentes que ficaram prejudicados ou deixaram de ficar, por evento.",
+	}, []string{"event"})
+)
+
+// AgentGetter é o subconjunto de agent.Service usado aqui.
+type AgentGetter interface {
+	GetAgent(ctx context.Context, id string) (*agent.Agent, error)
+}
+
+// Propagator acompanha o status dos agentes pelo barramento e atualiza os
+// prejuízos dos dependentes.
+type Propagator struct {
+	repo      *Repository
+	agents    AgentGetter
+	publisher events.Publisher
+	failing   map[string]bool
+
+	events chan events.Event
+}
+
+// NewPropagator cria o propagador. failureStatuses são os status de agente
+// que contam como falha; queueSize limita os eventos à espera. O consumo
+// roda em Run.
+func NewPropagator(repo *Repository, agents AgentGetter, publisher events.Publisher, failureStatuses []string, queueSize int) *Propagator {
+	p := &Propagator{
+		repo:      repo,
+		agents:    agents,
+		publisher: publisher,
+		failing:   make(map[string]bool, len(failureStatuses)),
+		events:    make(chan events.Event, queueSize),
+	}
+	for _, s := range failureStatuses {
+		p.failing[s] = true
+	}
+	return p
+}
+
+// Handle recebe eventos do barramento sem bloquear o Publish; com a fila
+// cheia o evento é descartado e contado.
+func (p *Propagator) Handle(_ context.Context, e events.Event) {
+	switch e.Type {
+	case "agent.created", "agent.updated", "agent.deleted":
+	default:
+		return
+	}
+	select {
+	case p.events <- e:
+	default:
+		droppedEvents.Inc()
+	}
+}
+
+// Run consome os eventos até ctx ser cancelado; eventos ainda na fila são
+// descartados.
+func (p *Propagator) Run(ctx context.Context) error {
+	work := logging.Background(context.WithoutCancel(ctx), "dependency-propagator")
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e := <-p.events:
+			if err := p.apply(work, e); err != nil {
+				logging.FromContext(work).WithError(err).WithField("event_type", e.Type).Warn("Falha ao propagar status de agente às dependências")
+			}
+		}
+	}
+}
+
+// apply registra a falha ou a recuperação do agente do evento e recalcula os
+// prejuízos afetados.
+func (p *Propagator) apply(ctx context.Context, e events.Event) error {
+	var data struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+	}
+	err := e.Decode(&data)
+	if err != nil || data.ID == "" {
+		return err
+	}
+
+	// Um agente removido leva as próprias arestas; os prejuízos que passavam
+	// por ele são recalculados mesmo que ele não estivesse com falha.
+	deleted := e.Type == "agent.deleted"
+	var changed bool
+	if !deleted && p.failing[data.Status] {
+		changed, err = p.repo.Fail(ctx, data.ID, data.Status)
+	} else {
+		changed, err = p.repo.Recover(ctx, data.ID, deleted)
+	}
+	if err != nil {
+		return err
+	}
+	if !changed && !deleted {
+		return nil
+	}
+
+	affected, err := p.repo.Downstream(ctx, []string{data.ID})
+	if err != nil {
+		return err
+	}
+	through, err := p.repo.ImpairedThrough(ctx, data.ID)
+	if err != nil {
+		return err
+	}
+	return p.Reconcile(ctx, append(affected, through...))
+}
+
+// MarkReset registra a falha StatusReset dos agentes reiniciados e
+// prejudica os que dependem deles. Retorna os dependentes de cada agente,
+// a até maxDepth arestas.
+func (p *Propagator) MarkReset(ctx context.Context, ids []string) (map[string][]string, error) {
+	dependents := make(map[string][]string, len(ids))
+	var affected []string
+	for _, id := range ids {
+		if _, err := p.repo.Fail(ctx, id, StatusReset); err != nil {
+			return nil, err
+		}
+		down, err := p.repo.Downstream(ctx, []string{id})
+		if err != nil {
+			return nil, err
+		}
+		dependents[id] = down
+		affected = append(affected, down...)
+	}
+	return dependents, p.Reconcile(ctx, affected)
+}
+
+// ClearReset remove a falha StatusReset dos agentes, depois de um tick
+// completo, e recalcula os prejuízos que passavam por eles. Um agente que
+// voltou a falhar nesse tick fica com a falha nova.
+func (p *Propagator) ClearReset(ctx context.Context, ids []string) error {
+	cleared, err := p.repo.ClearReset(ctx, ids)
+	if err != nil || len(cleared) == 0 {
+		return err
+	}
+	affected, err := p.repo.Downstream(ctx, cleared)
+	if err != nil {
+		return err
+	}
+	for _, id := range cleared {
+		through, err := p.repo.ImpairedThrough(ctx, id)
+		if err != nil {
+			return err
+		}
+		affected = append(affected, through...)
+	}
+	return p.Reconcile(ctx, affected)
+}
+
+// Reconcile recalcula o prejuízo dos agentes, grava o que mudou e publica
+// EventImpaired (nova causa raiz) ou EventImpairmentCleared.
+func (p *Propagator) Reconcile(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	current, err := p.repo.Impairments(ctx, ids)
+	if err != nil {
+		return err
+	}
+	resolved, err := p.repo.Resolve(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	seen := map[string]bool{}
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		prev, next := current[id], resolved[id]
+		switch {
+		case next != nil:
+			if next.same(prev) && slices.Equal(next.Path, prev.Path) {
+				continue
+			}
+			if err := p.repo.SaveImpairment(ctx, next); err != nil {
+				return err
+			}
+			if !next.same(prev) {
+				p.publish(ctx, EventImpaired, next)
+			}
+		case prev != nil:
+			if err := p.repo.ClearImpairment(ctx, id); err != nil {
+				return err
+			}
+			p.publish(ctx, EventImpairmentCleared, prev)
+		}
+	}
+	return nil
+}
+
+func (p *Propagator) publish(ctx context.Context, eventType string, i *Impairment) {
+	impairmentChanges.WithLabelValues(eventType).Inc()
+	data := events.AgentImpairmentV1{
+		AgentID:         i.AgentID,
+		RootCauseID:     i.RootCauseID,
+		RootCauseStatus: i.RootCauseStatus,
+		Path:            i.Path,
+		Since:           i.Since,
+	}
+	// O projeto acompanha o evento para os filtros por projeto dos
+	// consumidores; um agente removido nesse meio-tempo sai sem ele.
+	ag, err := p.agents.GetAgent(ctx, i.AgentID)
+	switch {
+	case err == nil:
+		data.ProjectID = ag.ProjectID
+	case !errors.Is(err, agent.ErrNotFound):
+		logging.FromContext(ctx).WithError(err).WithField("agent_id", i.AgentID).Warn("Falha ao buscar agente prejudicado")
+	}
+	logging.FromContext(ctx).WithFields(logrus.Fields{
+		"agent_id": i.AgentID, "root_cause_id": i.RootCauseID, "event": eventType,
+	}).Info("Prejuízo por dependência mudou")
+	p.publisher.Publish(ctx, events.New(events.TopicAgents, eventType, data))
+}
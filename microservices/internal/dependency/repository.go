@@ -0,0 +1,299 @@
+package dependency
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+
+	"smart-city-microservices/internal/instrument"
+)
+
+// Repository persiste dependências, falhas e prejuízos no PostgreSQL.
+type Repository struct {
+	db *instrument.DB
+	// maxDepth limita quantas arestas uma falha atravessa.
+	maxDepth int
+}
+
+// NewRepository cria o repositório. maxDepth limita a propagação das falhas
+// e o grafo retornado por Graph.
+func NewRepository(db *sql.DB, maxDepth int) *Repository {
+	return &Repository{db: instrument.NewDB(db), maxDepth: maxDepth}
+}
+
+// Get retorna as arestas do agente nos dois sentidos.
+func (r *Repository) Get(ctx context.Context, agentID string) (*Dependencies, error) {
+	rows, err := r.db.Query(ctx, "dependency.get", `
+		SELECT depends_on::text, true FROM agent_dependencies WHERE agent_id = $1
+		UNION ALL
+		SELECT agent_id::text, false FROM agent_dependencies WHERE depends_on = $1
+		ORDER BY 2 DESC, 1`, agentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	d := &Dependencies{AgentID: agentID, DependsOn: []string{}, Dependents: []string{}}
+	for rows.Next() {
+		var id string
+		var upstream bool
+		if err := rows.Scan(&id, &upstream); err != nil {
+			return nil, err
+		}
+		if upstream {
+			d.DependsOn = append(d.DependsOn, id)
+		} else {
+			d.Dependents = append(d.Dependents, id)
+		}
+	}
+	return d, rows.Err()
+}
+
+// Replace troca as dependências do agente por dependsOn. Retorna
+// *CycleError se algum deles já depende, direta ou indiretamente, do
+// agente. As escritas são serializadas, para que duas alterações
+// simultâneas não fechem um ciclo entre si.
+func (r *Repository) Replace(ctx context.Context, agentID string, dependsOn []string) (err error) {
+	span := instrument.StartQuery(ctx, "dependency.replace")
+	defer func() { span.End(-1, err) }()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('agent_dependencies'))`); err != nil {
+		return err
+	}
+
+	var path pq.StringArray
+	err = tx.QueryRowContext(ctx, `
+		WITH RECURSIVE up(node, path) AS (
+			SELECT d, ARRAY[$1::uuid, d] FROM unnest($2::uuid[]) AS s(d)
+			UNION ALL
+			SELECT e.depends_on, up.path || e.depends_on
+			FROM up JOIN agent_dependencies e ON e.agent_id = up.node
+			WHERE up.node <> $1::uuid AND NOT e.depends_on = ANY(up.path[2:])
+		)
+		SELECT path::text[] FROM up WHERE node = $1::uuid ORDER BY cardinality(path) LIMIT 1`,
+		agentID, pq.StringArray(dependsOn)).Scan(&path)
+	switch {
+	case err == nil:
+		return &CycleError{Path: path}
+	case err != sql.ErrNoRows:
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM agent_dependencies WHERE agent_id = $1`, agentID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO agent_dependencies (agent_id, depends_on)
+		SELECT $1::uuid, d FROM unnest($2::uuid[]) AS s(d)`, agentID, pq.StringArray(dependsOn)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Downstream retorna os agentes que dependem, direta ou indiretamente, de
+// algum dos ids, até maxDepth arestas.
+func (r *Repository) Downstream(ctx context.Context, ids []string) ([]string, error) {
+	return r.ids(ctx, "dependency.downstream", `
+		WITH RECURSIVE down(node, depth) AS (
+			SELECT e.agent_id, 1 FROM agent_dependencies e WHERE e.depends_on = ANY($1::uuid[])
+			UNION
+			SELECT e.agent_id, down.depth + 1
+			FROM down JOIN agent_dependencies e ON e.depends_on = down.node
+			WHERE down.depth < $2
+		)
+		SELECT DISTINCT node::text FROM down`, pq.StringArray(ids), r.maxDepth)
+}
+
+// Fail registra a falha do agente com status. Retorna false se ele já
+// estava com falha pelo mesmo status.
+func (r *Repository) Fail(ctx context.Context, agentID, status string) (bool, error) {
+	var changed bool
+	err := r.db.QueryRow(ctx, "dependency.fail", `
+		INSERT INTO agent_failures (agent_id, status) VALUES ($1, $2)
+		ON CONFLICT (agent_id) DO UPDATE SET status = EXCLUDED.status
+		WHERE agent_failures.status <> EXCLUDED.status
+		RETURNING true`, agentID, status).Scan(&changed)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return changed, err
+}
+
+// Recover remove a falha do agente. Retorna true se ele estava com falha.
+// A falha de um reinício (StatusReset) fica, salvo com all; ela sai em
+// ClearReset.
+func (r *Repository) Recover(ctx context.Context, agentID string, all bool) (bool, error) {
+	res, err := r.db.Exec(ctx, "dependency.recover",
+		`DELETE FROM agent_failures WHERE agent_id = $1 AND ($2 OR status <> $3)`, agentID, all, StatusReset)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// ClearReset remove as falhas StatusReset dos agentes e retorna os que a
+// tinham.
+func (r *Repository) ClearReset(ctx context.Context, ids []string) ([]string, error) {
+	return r.ids(ctx, "dependency.clear_reset",
+		`DELETE FROM agent_failures WHERE agent_id = ANY($1::uuid[]) AND status = $2 RETURNING agent_id::text`,
+		pq.StringArray(ids), StatusReset)
+}
+
+// ImpairedThrough retorna os agentes cujo prejuízo passa pelo agente,
+// inclusive como causa raiz.
+func (r *Repository) ImpairedThrough(ctx context.Context, agentID string) ([]string, error) {
+	return r.ids(ctx, "dependency.impaired_through",
+		`SELECT agent_id::text FROM agent_impairments WHERE $1::uuid = ANY(path)`, agentID)
+}
+
+// Resolve calcula o prejuízo atual de cada agente: a falha mais próxima
+// entre os agentes de que ele depende, até maxDepth arestas; empates vão
+// para a falha mais antiga. Agentes sem prejuízo ficam fora do mapa.
+func (r *Repository) Resolve(ctx context.Context, ids []string) (map[string]*Impairment, error) {
+	rows, err := r.db.Query(ctx, "dependency.resolve", `
+		WITH RECURSIVE up(agent_id, node, path) AS (
+			SELECT s.id, e.depends_on, ARRAY[s.id, e.depends_on]
+			FROM unnest($1::uuid[]) AS s(id) JOIN agent_dependencies e ON e.agent_id = s.id
+			UNION ALL
+			SELECT up.agent_id, e.depends_on, up.path || e.depends_on
+			FROM up JOIN agent_dependencies e ON e.agent_id = up.node
+			WHERE cardinality(up.path) <= $2 AND NOT e.depends_on = ANY(up.path)
+		)
+		SELECT DISTINCT ON (up.agent_id) up.agent_id::text, f.agent_id::text, f.status, up.path::text[]
+		FROM up JOIN agent_failures f ON f.agent_id = up.node
+		ORDER BY up.agent_id, cardinality(up.path), f.since`, pq.StringArray(ids), r.maxDepth)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := map[string]*Impairment{}
+	for rows.Next() {
+		var i Impairment
+		var path pq.StringArray
+		if err := rows.Scan(&i.AgentID, &i.RootCauseID, &i.RootCauseStatus, &path); err != nil {
+			return nil, err
+		}
+		i.Path = path
+		out[i.AgentID] = &i
+	}
+	return out, rows.Err()
+}
+
+// Impairments retorna os prejuízos gravados dos agentes.
+func (r *Repository) Impairments(ctx context.Context, ids []string) (map[string]*Impairment, error) {
+	rows, err := r.db.Query(ctx, "dependency.impairments", `
+		SELECT agent_id::text, root_cause_id::text, root_cause_status, path::text[], since
+		FROM agent_impairments WHERE agent_id = ANY($1::uuid[])`, pq.StringArray(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := map[string]*Impairment{}
+	for rows.Next() {
+		var i Impairment
+		var path pq.StringArray
+		if err := rows.Scan(&i.AgentID, &i.RootCauseID, &i.RootCauseStatus, &path, &i.Since); err != nil {
+			return nil, err
+		}
+		i.Path = path
+		out[i.AgentID] = &i
+	}
+	return out, rows.Err()
+}
+
+// SaveImpairment grava o prejuízo do agente. since só muda quando a causa
+// raiz muda.
+func (r *Repository) SaveImpairment(ctx context.Context, i *Impairment) error {
+	return r.db.QueryRow(ctx, "dependency.save_impairment", `
+		INSERT INTO agent_impairments (agent_id, root_cause_id, root_cause_status, path, since)
+		VALUES ($1, $2, $3, $4::uuid[], $5)
+		ON CONFLICT (agent_id) DO UPDATE SET
+			since = CASE WHEN agent_impairments.root_cause_id = EXCLUDED.root_cause_id
+				THEN agent_impairments.since ELSE EXCLUDED.since END,
+			root_cause_id = EXCLUDED.root_cause_id,
+			root_cause_status = EXCLUDED.root_cause_status,
+			path = EXCLUDED.path
+		RETURNING since`,
+		i.AgentID, i.RootCauseID, i.RootCauseStatus, pq.StringArray(i.Path), time.Now().UTC()).Scan(&i.Since)
+}
+
+// ClearImpairment remove o prejuízo do agente.
+func (r *Repository) ClearImpairment(ctx context.Context, agentID string) error {
+	_, err := r.db.Exec(ctx, "dependency.clear_impairment", `DELETE FROM agent_impairments WHERE agent_id = $1`, agentID)
+	return err
+}
+
+// Graph retorna as arestas a até depth passos do agente em cada sentido,
+// com a distância de cada agente alcançado (negativa a montante).
+func (r *Repository) Graph(ctx context.Context, agentID string, depth int) ([]Edge, map[string]int, error) {
+	rows, err := r.db.Query(ctx, "dependency.graph", `
+		WITH RECURSIVE up(agent_id, depends_on, depth) AS (
+			SELECT agent_id, depends_on, 1 FROM agent_dependencies WHERE agent_id = $1
+			UNION
+			SELECT e.agent_id, e.depends_on, up.depth + 1
+			FROM up JOIN agent_dependencies e ON e.agent_id = up.depends_on
+			WHERE up.depth < $2
+		), down(agent_id, depends_on, depth) AS (
+			SELECT agent_id, depends_on, 1 FROM agent_dependencies WHERE depends_on = $1
+			UNION
+			SELECT e.agent_id, e.depends_on, down.depth + 1
+			FROM down JOIN agent_dependencies e ON e.depends_on = down.agent_id
+			WHERE down.depth < $2
+		)
+		SELECT agent_id::text, depends_on::text, min(depth), true FROM up GROUP BY 1, 2
+		UNION ALL
+		SELECT agent_id::text, depends_on::text, min(depth), false FROM down GROUP BY 1, 2
+		ORDER BY 4 DESC, 3, 1, 2`, agentID, depth)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	edges := []Edge{}
+	distances := map[string]int{agentID: 0}
+	for rows.Next() {
+		var e Edge
+		var d int
+		var upstream bool
+		if err := rows.Scan(&e.AgentID, &e.DependsOn, &d, &upstream); err != nil {
+			return nil, nil, err
+		}
+		edges = append(edges, e)
+		if upstream {
+			if _, ok := distances[e.DependsOn]; !ok {
+				distances[e.DependsOn] = -d
+			}
+		} else if _, ok := distances[e.AgentID]; !ok {
+			distances[e.AgentID] = d
+		}
+	}
+	return edges, distances, rows.Err()
+}
+
+func (r *Repository) ids(ctx context.Context, name, query string, args ...interface{}) ([]string, error) {
+	rows, err := r.db.Query(ctx, name, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		out = append(out, id)
+	}
+	return out, rows.Err()
+}
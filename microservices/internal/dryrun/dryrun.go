@@ -0,0 +1,101 @@
+// Package dryrun implementa o modo de ensaio das operações destrutivas: com
+// o cabeçalho X-Dry-Run: true, a operação valida e planeja como de costume
+// e responde 200 com o que mudaria e "dry_run": true, sem gravar nada.
+//
+// A garantia não depende de cada handler: o middleware abre uma transação,
+// todos os statements dos repositórios (instrument.DB) rodam nela e ela é
+// sempre desfeita. Os handlers que aceitam o ensaio só deixam de produzir
+// os efeitos de fora do banco (eventos, auditoria, chamadas ao serviço de
+// agentes, trabalho em segundo plano). As rotas que ainda não aceitam
+// respondem 501.
+package dryrun
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"smart-city-microservices/internal/instrument"
+	"smart-city-microservices/internal/logging"
+)
+
+// Header é o cabeçalho que pede o ensaio.
+const Header = "X-Dry-Run"
+
+type key struct{}
+
+// Enabled indica se a requisição de ctx é um ensaio.
+func Enabled(ctx context.Context) bool {
+	on, _ := ctx.Value(key{}).(bool)
+	return on
+}
+
+// Middleware atende o cabeçalho X-Dry-Run. supported são as rotas que
+// aceitam o ensaio, como "POST /api/v1/groups/:id/start" (método e
+// c.FullPath()); nas demais, X-Dry-Run: true responde 501. Nos ensaios,
+// a requisição segue com uma transação de db em instrument.WithTx, desfeita
+// no fim.
+func Middleware(db *sql.DB, supported ...string) gin.HandlerFunc {
+	routes := make(map[string]bool, len(supported))
+	for _, r := range supported {
+		routes[r] = true
+	}
+	return func(c *gin.Context) {
+		v := c.GetHeader(Header)
+		if v == "" {
+			c.Next()
+			return
+		}
+		on, err := strconv.ParseBool(v)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid X-Dry-Run header: must be true or false"})
+			return
+		}
+		if !on {
+			c.Next()
+			return
+		}
+		if !routes[c.Request.Method+" "+c.FullPath()] {
+			c.AbortWithStatusJSON(http.StatusNotImplemented, gin.H{"error": "dry run is not supported for this operation"})
+			return
+		}
+		ctx := c.Request.Context()
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			logging.FromContext(ctx).WithError(err).Error("Falha ao abrir a transação do ensaio")
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+			return
+		}
+		defer func() {
+			if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+				logging.FromContext(ctx).WithError(err).Warn("Falha ao desfazer a transação do ensaio")
+			}
+		}()
+		ctx = instrument.WithTx(context.WithValue(ctx, key{}, true), tx)
+		c.Request = c.Request.WithContext(ctx)
+		c.Header(Header, "true")
+		c.Next()
+	}
+}
+
+// JSON responde 200 com o resultado do ensaio, v, acrescido de
+// "dry_run": true. v precisa serializar como um objeto JSON.
+func JSON(c *gin.Context, v interface{}) {
+	body := map[string]json.RawMessage{}
+	raw, err := json.Marshal(v)
+	if err == nil {
+		err = json.Unmarshal(raw, &body)
+	}
+	if err != nil {
+		logging.FromContext(c.Request.Context()).WithError(err).Error("Falha ao montar a resposta do ensaio")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+	body["dry_run"] = json.RawMessage("true")
+	c.JSON(http.StatusOK, body)
+}
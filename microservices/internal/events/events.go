@@ -0,0 +1,338 @@
+// Package events define os eventos internos do serviço e um barramento
+// em processo que os distribui para o hub websocket e outros consumidores.
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/logging"
+)
+
+// Tópicos usados pelos eventos administrativos e de sistema. Em
+// TopicAlerts as regras de alerta publicam eventos "alert.*".
+const (
+	TopicAdmin       = "admin"
+	TopicAgents      = "agents"
+	TopicSimulations = "simulations"
+	TopicAlerts      = "alerts"
+)
+
+// GroupTopic é o tópico dos eventos "group.*" de um grupo de agentes,
+// "group:<id>".
+func GroupTopic(groupID string) string {
+	return "group:" + groupID
+}
+
+var invalidEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "agent_service",
+	Name:      "events_invalid_total",
+	Help:      "Eventos cujo payload não confere com o schema registrado, por tipo versionado.",
+}, []string{"event_type"})
+
+// Event é um evento emitido por um componente do serviço. Type é o tipo sem
+// versão ("agent.updated"), usado nos filtros; Version é a versão do payload
+// em Data, preenchida pelo barramento com a versão atual do registro.
+type Event struct {
+	Type       string      `json:"type"`
+	Version    int         `json:"version,omitempty"`
+	Topic      string      `json:"topic"`
+	Data       interface{} `json:"data"`
+	OccurredAt time.Time   `json:"occurred_at"`
+	// Producer é o id da instância que publicou o evento.
+	Producer string `json:"producer,omitempty"`
+	Trace    *Trace `json:"trace,omitempty"`
+
+	// enc guarda as serializações do evento, preenchido por Bus.Publish.
+	// As cópias do evento entregues a cada handler o compartilham.
+	enc *encoding
+}
+
+// Trace é o contexto de correlação da requisição ou job que originou o evento.
+type Trace struct {
+	TraceID   string `json:"trace_id,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// New cria um evento com o horário atual.
+func New(topic, eventType string, data interface{}) Event {
+	return Event{Type: eventType, Topic: topic, Data: data, OccurredAt: time.Now().UTC()}
+}
+
+// VersionedType é o tipo com a versão, ex.: "agent.updated.v1".
+func (e Event) VersionedType() string {
+	return VersionedType(e.Type, e.Version)
+}
+
+// Envelope é a forma de um evento enviada a consumidores externos
+// (websocket, webhooks, exportação). type continua trazendo o tipo sem
+// versão para os consumidores anteriores ao versionamento.
+type Envelope struct {
+	ID         string      `json:"id,omitempty"`
+	EventType  string      `json:"event_type"`
+	Type       string      `json:"type"`
+	Version    int         `json:"version"`
+	Topic      string      `json:"topic"`
+	OccurredAt time.Time   `json:"occurred_at"`
+	Producer   string      `json:"producer,omitempty"`
+	Trace      *Trace      `json:"trace,omitempty"`
+	Data       interface{} `json:"data"`
+}
+
+// NewEnvelope monta o envelope do evento; id pode ser vazio.
+func NewEnvelope(id string, e Event) Envelope {
+	return Envelope{
+		ID:         id,
+		EventType:  e.VersionedType(),
+		Type:       e.Type,
+		Version:    e.Version,
+		Topic:      e.Topic,
+		OccurredAt: e.OccurredAt,
+		Producer:   e.Producer,
+		Trace:      e.Trace,
+		Data:       e.Data,
+	}
+}
+
+// ProjectID extrai project_id dos dados do evento, se houver.
+func (e Event) ProjectID() string {
+	if m, ok := e.Data.(map[string]interface{}); ok {
+		p, _ := m["project_id"].(string)
+		return p
+	}
+	var v struct {
+		ProjectID string `json:"project_id"`
+	}
+	if e.Decode(&v) != nil {
+		return ""
+	}
+	return v.ProjectID
+}
+
+// SimulationID extrai simulation_id dos dados do evento, se houver; nos
+// eventos do ciclo de vida de simulações, que não o têm, o id da própria
+// simulação.
+func (e Event) SimulationID() string {
+	var v struct {
+		SimulationID string `json:"simulation_id"`
+		ID           string `json:"id"`
+	}
+	if m, ok := e.Data.(map[string]interface{}); ok {
+		v.SimulationID, _ = m["simulation_id"].(string)
+		v.ID, _ = m["id"].(string)
+	} else if e.Decode(&v) != nil {
+		return ""
+	}
+	if v.SimulationID == "" && e.Topic == TopicSimulations {
+		return v.ID
+	}
+	return v.SimulationID
+}
+
+// DataJSON retorna Data em JSON. Num evento entregue pelo barramento a
+// serialização é feita na primeira chamada e compartilhada por todos os
+// handlers, que não devem alterar o resultado.
+func (e Event) DataJSON() ([]byte, error) {
+	if e.enc == nil {
+		return marshal(e.Data)
+	}
+	e.enc.dataOnce.Do(func() { e.enc.data, e.enc.dataErr = marshal(e.Data) })
+	return e.enc.data, e.enc.dataErr
+}
+
+// Decode lê Data em v a partir de DataJSON; serve aos consumidores que
+// recebem o payload sem conhecer o seu tipo Go.
+func (e Event) Decode(v interface{}) error {
+	raw, err := e.DataJSON()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// EncodeEnvelope serializa o envelope do evento com id. Sem id, o envelope
+// de um evento entregue pelo barramento é serializado uma única vez, por
+// maior que seja o número de assinantes que o repassam; com id, só o
+// payload é reaproveitado.
+func EncodeEnvelope(id string, e Event) ([]byte, error) {
+	if id != "" || e.enc == nil {
+		return encodeEnvelope(id, e)
+	}
+	e.enc.envOnce.Do(func() { e.enc.envelope, e.enc.envErr = encodeEnvelope("", e) })
+	return e.enc.envelope, e.enc.envErr
+}
+
+// encoding é o cache de serialização de um evento publicado.
+type encoding struct {
+	dataOnce sync.Once
+	data     []byte
+	dataErr  error
+
+	envOnce  sync.Once
+	envelope []byte
+	envErr   error
+}
+
+// maxPooledBuffer é o maior buffer devolvido ao pool; um payload
+// excepcional não fica preso na memória.
+const maxPooledBuffer = 64 << 10
+
+// encoder é um buffer de serialização reaproveitado entre eventos.
+type encoder struct {
+	buf bytes.Buffer
+	enc *json.Encoder
+}
+
+var (
+	encoders = sync.Pool{New: func() interface{} {
+		e := &encoder{}
+		e.enc = json.NewEncoder(&e.buf)
+		return e
+	}}
+	envelopes = sync.Pool{New: func() interface{} { return new(Envelope) }}
+)
+
+// marshal serializa v como json.Marshal, num buffer do pool. O resultado é
+// uma cópia, que pode ser guardada.
+func marshal(v interface{}) ([]byte, error) {
+	e := encoders.Get().(*encoder)
+	defer func() {
+		if e.buf.Cap() <= maxPooledBuffer {
+			e.buf.Reset()
+			encoders.Put(e)
+		}
+	}()
+	if err := e.enc.Encode(v); err != nil {
+		return nil, err
+	}
+	// Encode termina com uma quebra de linha, que json.Marshal não põe.
+	out := e.buf.Bytes()
+	return append([]byte(nil), out[:len(out)-1]...), nil
+}
+
+func encodeEnvelope(id string, e Event) ([]byte, error) {
+	data, err := e.DataJSON()
+	if err != nil {
+		return nil, err
+	}
+	env := envelopes.Get().(*Envelope)
+	defer func() {
+		*env = Envelope{}
+		envelopes.Put(env)
+	}()
+	*env = NewEnvelope(id, e)
+	env.Data = json.RawMessage(data)
+	return marshal(env)
+}
+
+// Publisher publica eventos.
+type Publisher interface {
+	Publish(ctx context.Context, e Event)
+}
+
+// Handler consome eventos publicados no barramento.
+type Handler func(ctx context.Context, e Event)
+
+// Bus distribui eventos de forma síncrona para os handlers inscritos.
+// Handlers devem ser rápidos; trabalho pesado deve ir para uma fila própria.
+type Bus struct {
+	mu         sync.RWMutex
+	nextID     uint64
+	handlers   []subscription
+	producer   string
+	validation string
+}
+
+type subscription struct {
+	id uint64
+	h  Handler
+}
+
+// NewBus cria um barramento sem inscritos.
+func NewBus() *Bus {
+	return &Bus{validation: ValidationOff}
+}
+
+// SetProducer define o id da instância gravado nos eventos publicados.
+func (b *Bus) SetProducer(id string) {
+	b.mu.Lock()
+	b.producer = id
+	b.mu.Unlock()
+}
+
+// SetValidation define se os payloads publicados são validados contra
+// Schemas (ValidationOff, ValidationWarn ou ValidationReject).
+func (b *Bus) SetValidation(mode string) {
+	b.mu.Lock()
+	b.validation = mode
+	b.mu.Unlock()
+}
+
+// Subscribe registra um handler para todos os eventos e retorna a função
+// que cancela a inscrição. Cancelar mais de uma vez não tem efeito.
+func (b *Bus) Subscribe(h Handler) (unsubscribe func()) {
+	b.mu.Lock()
+	b.nextID++
+	id := b.nextID
+	b.handlers = append(b.handlers, subscription{id: id, h: h})
+	b.mu.Unlock()
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		for i, s := range b.handlers {
+			if s.id == id {
+				// Copia para não alterar a fatia que um Publish em andamento percorre.
+				handlers := make([]subscription, 0, len(b.handlers)-1)
+				handlers = append(handlers, b.handlers[:i]...)
+				b.handlers = append(handlers, b.handlers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Publish completa o envelope do evento (versão, produtor e contexto de
+// correlação) e o entrega a todos os handlers.
+func (b *Bus) Publish(ctx context.Context, e Event) {
+	if e.OccurredAt.IsZero() {
+		e.OccurredAt = time.Now().UTC()
+	}
+	if e.Version == 0 {
+		e.Version = Schemas.Current(e.Type)
+	}
+	e.enc = &encoding{}
+	if e.Trace == nil {
+		if trace, request := logging.TraceID(ctx), logging.RequestID(ctx); trace != "" || request != "" {
+			e.Trace = &Trace{TraceID: trace, RequestID: request}
+		}
+	}
+	b.mu.RLock()
+	handlers, validation := b.handlers, b.validation
+	if e.Producer == "" {
+		e.Producer = b.producer
+	}
+	b.mu.RUnlock()
+
+	if validation != ValidationOff {
+		if err := Schemas.Validate(e); err != nil {
+			invalidEvents.WithLabelValues(e.VersionedType()).Inc()
+			log := logging.FromContext(ctx).WithError(err).WithFields(logrus.Fields{"event_type": e.VersionedType(), "topic": e.Topic})
+			if validation == ValidationReject {
+				log.Error("Evento descartado: payload fora do schema")
+				return
+			}
+			log.Warn("Evento com payload fora do schema")
+		}
+	}
+	for _, s := range handlers {
+		s.h(ctx, e)
+	}
+}
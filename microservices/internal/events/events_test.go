@@ -0,0 +1,159 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func sampleEvent() Event {
+	at := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	return Event{
+		Type:  "agent.updated",
+		Topic: TopicAgents,
+		Data: AgentV1{
+			ID: "agent-1", SimulationID: "sim-1", ProjectID: "proj-1",
+			Type: "vehicle", Name: "Ônibus 1", Status: "active",
+			Position:  PositionV1{Lat: -23.55, Lon: -46.63, Heading: 90, Speed: 12.5},
+			Energy:    87.5,
+			State:     map[string]interface{}{"route": "101"},
+			Metadata:  map[string]interface{}{"plate": "ABC1D23"},
+			Tags:      []string{"bus"},
+			CreatedAt: at, UpdatedAt: at,
+		},
+		OccurredAt: at,
+	}
+}
+
+// published retorna o evento como os handlers o recebem do barramento,
+// com o cache de serialização.
+func published(t testing.TB, e Event) Event {
+	t.Helper()
+	bus := NewBus()
+	bus.SetProducer("instance-a")
+	var got Event
+	bus.Subscribe(func(_ context.Context, e Event) { got = e })
+	bus.Publish(context.Background(), e)
+	if got.enc == nil {
+		t.Fatal("evento entregue sem o cache de serialização")
+	}
+	return got
+}
+
+func TestEncodeEnvelopeMatchesJSON(t *testing.T) {
+	e := published(t, sampleEvent())
+	for _, id := range []string{"", "evt-1"} {
+		got, err := EncodeEnvelope(id, e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := json.Marshal(NewEnvelope(id, e))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("id %q:\n got %s\nwant %s", id, got, want)
+		}
+	}
+}
+
+func TestEncodeEnvelopeCached(t *testing.T) {
+	e := published(t, sampleEvent())
+	first, err := EncodeEnvelope("", e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A cópia entregue a outro handler compartilha o cache.
+	copied := e
+	second, _ := EncodeEnvelope("", copied)
+	if &first[0] != &second[0] {
+		t.Error("envelope serializado de novo para o segundo assinante")
+	}
+	data, _ := e.DataJSON()
+	again, _ := copied.DataJSON()
+	if &data[0] != &again[0] {
+		t.Error("payload serializado de novo")
+	}
+}
+
+// TestEncodeEnvelopeCacheHitAllocs garante que, depois do primeiro
+// assinante, repassar o evento não aloca nada.
+func TestEncodeEnvelopeCacheHitAllocs(t *testing.T) {
+	e := published(t, sampleEvent())
+	if _, err := EncodeEnvelope("", e); err != nil {
+		t.Fatal(err)
+	}
+	if allocs := testing.AllocsPerRun(1000, func() { EncodeEnvelope("", e) }); allocs != 0 {
+		t.Errorf("EncodeEnvelope com cache aloca %.0f vezes, want 0", allocs)
+	}
+	if allocs := testing.AllocsPerRun(1000, func() { e.DataJSON() }); allocs != 0 {
+		t.Errorf("DataJSON com cache aloca %.0f vezes, want 0", allocs)
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	for _, v := range []interface{}{nil, "texto <b>", 42, map[string]interface{}{"a": []int{1, 2}}, sampleEvent().Data} {
+		got, err := marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, _ := json.Marshal(v)
+		if !bytes.Equal(got, want) {
+			t.Errorf("marshal(%v) = %s, want %s", v, got, want)
+		}
+	}
+	if _, err := marshal(func() {}); err == nil {
+		t.Error("marshal de uma função não falhou")
+	}
+}
+
+// BenchmarkEncodeEnvelope compara a serialização de um evento sem o cache
+// (um evento fora do barramento) e com ele.
+func BenchmarkEncodeEnvelope(b *testing.B) {
+	b.Run("uncached", func(b *testing.B) {
+		e := sampleEvent()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := EncodeEnvelope("", e); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		e := published(b, sampleEvent())
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := EncodeEnvelope("", e); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkEncodeBroadcast publica eventos para 1000 assinantes que
+// serializam o envelope, como as conexões do hub websocket; perSubscriber
+// é o que cada assinante fazia antes do cache.
+func BenchmarkEncodeBroadcast(b *testing.B) {
+	const subscribers = 1000
+	run := func(b *testing.B, encode func(Event)) {
+		bus := NewBus()
+		for i := 0; i < subscribers; i++ {
+			bus.Subscribe(func(_ context.Context, e Event) { encode(e) })
+		}
+		e := sampleEvent()
+		ctx := context.Background()
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			bus.Publish(ctx, e)
+		}
+	}
+	b.Run("perSubscriber", func(b *testing.B) {
+		run(b, func(e Event) { json.Marshal(NewEnvelope("", e)) })
+	})
+	b.Run("cached", func(b *testing.B) {
+		run(b, func(e Event) { EncodeEnvelope("", e) })
+	})
+}
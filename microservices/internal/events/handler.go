@@ -0,0 +1,55 @@
+package events
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SchemaInfo descreve um schema em GET /api/v1/events/schemas.
+type SchemaInfo struct {
+	EventType   string                 `json:"event_type"`
+	Type        string                 `json:"type"`
+	Version     int                    `json:"version"`
+	Topic       string                 `json:"topic"`
+	Description string                 `json:"description,omitempty"`
+	Current     bool                   `json:"current"`
+	Schema      map[string]interface{} `json:"schema"`
+}
+
+// ListSchemas lista os schemas registrados; ?type= filtra pelo tipo sem versão.
+func ListSchemas(c *gin.Context) {
+	out := []SchemaInfo{}
+	for _, s := range Schemas.List() {
+		if t := c.Query("type"); t != "" && s.Type != t {
+			continue
+		}
+		out = append(out, SchemaInfo{
+			EventType:   s.Name(),
+			Type:        s.Type,
+			Version:     s.Version,
+			Topic:       s.Topic,
+			Description: s.Description,
+			Current:     Schemas.Current(s.Type) == s.Version,
+			Schema:      s.JSONSchema(),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"data": out})
+}
+
+// GetSchema retorna o JSON Schema de um tipo versionado, ex.:
+// /api/v1/events/schemas/agent.updated.v1.
+func GetSchema(c *gin.Context) {
+	t, v, err := ParseVersionedType(c.Param("event_type"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	s, ok := Schemas.Lookup(t, v)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "event schema not found"})
+		return
+	}
+	c.Header("Content-Type", "application/schema+json")
+	c.JSON(http.StatusOK, s.JSONSchema())
+}
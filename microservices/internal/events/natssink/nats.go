@@ -0,0 +1,104 @@
+// Package natssink implementa events.EventSink para NATS JetStream.
+package natssink
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"smart-city-microservices/internal/events"
+)
+
+// Cabeçalhos gravados em cada mensagem, além de Nats-Msg-Id, que o JetStream
+// usa para descartar duplicatas dentro da janela de deduplicação do stream.
+const (
+	HeaderEventType = "Event-Type"
+	HeaderTopic     = "Event-Topic"
+)
+
+// Config configura a conexão com o NATS.
+type Config struct {
+	URL             string
+	Name            string
+	CredentialsFile string
+	// AckTimeout limita a espera pela confirmação de cada lote.
+	AckTimeout time.Duration
+	TLSConfig  *tls.Config
+}
+
+// Sink publica cada evento no subject indicado em Message.Subject. Os
+// subjects precisam pertencer a um stream JetStream existente.
+type Sink struct {
+	conn *nats.Conn
+	js   jetstream.JetStream
+	cfg  Config
+}
+
+// New conecta ao NATS. Com o servidor indisponível a conexão continua sendo
+// tentada em segundo plano e os envios falham até ela se estabelecer.
+func New(cfg Config) (*Sink, error) {
+	opts := []nats.Option{
+		nats.Name(cfg.Name),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+	}
+	if cfg.CredentialsFile != "" {
+		opts = append(opts, nats.UserCredentials(cfg.CredentialsFile))
+	}
+	if cfg.TLSConfig != nil {
+		opts = append(opts, nats.Secure(cfg.TLSConfig))
+	}
+	conn, err := nats.Connect(cfg.URL, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("nats: %w", err)
+	}
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("nats: %w", err)
+	}
+	return &Sink{conn: conn, js: js, cfg: cfg}, nil
+}
+
+// Name implementa events.EventSink.
+func (s *Sink) Name() string { return "nats" }
+
+// Send publica o lote de forma assíncrona e espera a confirmação de todas as
+// mensagens. Duplicatas reconhecidas pelo servidor contam como sucesso.
+func (s *Sink) Send(ctx context.Context, batch []events.Message) error {
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.AckTimeout)
+	defer cancel()
+
+	futures := make([]jetstream.PubAckFuture, 0, len(batch))
+	for _, m := range batch {
+		msg := nats.NewMsg(m.Subject)
+		msg.Data = m.Payload
+		msg.Header.Set(HeaderEventType, m.Type)
+		msg.Header.Set(HeaderTopic, m.Topic)
+		f, err := s.js.PublishMsgAsync(msg, jetstream.WithMsgID(m.ID))
+		if err != nil {
+			return fmt.Errorf("nats: publicar %s: %w", m.Subject, err)
+		}
+		futures = append(futures, f)
+	}
+
+	for _, f := range futures {
+		select {
+		case <-f.Ok():
+		case err := <-f.Err():
+			return fmt.Errorf("nats: confirmação de %s: %w", f.Msg().Subject, err)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Close implementa events.EventSink.
+func (s *Sink) Close() error {
+	return s.conn.Drain()
+}
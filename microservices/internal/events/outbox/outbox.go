@@ -0,0 +1,224 @@
+// Package outbox guarda os eventos do barramento num stream Redis e os
+// repassa a um events.EventSink com entrega at-least-once: uma entrada só é
+// confirmada (XACK) depois que o sink aceitou o lote, e entradas pendentes
+// de instâncias que caíram são reassumidas após um tempo ocioso.
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/logging"
+	"smart-city-microservices/internal/supervisor"
+)
+
+// Campos de cada entrada do stream.
+const (
+	fieldID         = "id"
+	fieldTopic      = "topic"
+	fieldType       = "type"
+	fieldOccurredAt = "occurred_at"
+	fieldPayload    = "payload"
+)
+
+// writeBatch limita quantos eventos vão num único pipeline de XADD.
+const writeBatch = 100
+
+var (
+	droppedEvents = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "event_outbox_dropped_total",
+		Help:      "Eventos descartados porque o buffer do outbox estava cheio.",
+	})
+
+	exportErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "event_export_errors_total",
+		Help:      "Erros da exportação de eventos por sink e etapa (outbox, read, decode, send, ack).",
+	}, []string{"sink", "stage"})
+
+	exportedEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "event_export_messages_total",
+		Help:      "Eventos aceitos pelo sink de exportação.",
+	}, []string{"sink"})
+
+	exportLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "agent_service",
+		Name:      "event_export_lag_seconds",
+		Help:      "Idade do evento mais antigo ainda não confirmado pelo sink; zero sem atraso.",
+	}, []string{"sink"})
+)
+
+// Payload é o corpo JSON exportado, o mesmo envelope entregue aos webhooks.
+type Payload = events.Envelope
+
+// Config configura a escrita no outbox.
+type Config struct {
+	Stream string
+	// MaxLen limita o stream (aproximadamente). Entradas ainda não exportadas
+	// também são cortadas se o sink ficar fora por tempo demais.
+	MaxLen     int64
+	BufferSize int
+	// Topics são os tópicos do barramento gravados; os demais são ignorados.
+	Topics []string
+	// Sink identifica o sink nas métricas.
+	Sink string
+}
+
+// Outbox grava no stream os eventos recebidos do barramento. Handle não
+// bloqueia o Publish: os eventos passam por um buffer e são gravados em lote
+// por uma goroutine própria.
+type Outbox struct {
+	client redis.UniversalClient
+	cfg    Config
+	topics map[string]struct{}
+
+	events chan events.Event
+}
+
+// New cria o outbox; a gravação roda em Run.
+func New(client redis.UniversalClient, cfg Config) *Outbox {
+	topics := make(map[string]struct{}, len(cfg.Topics))
+	for _, t := range cfg.Topics {
+		topics[t] = struct{}{}
+	}
+	return &Outbox{
+		client: client,
+		cfg:    cfg,
+		topics: topics,
+		events: make(chan events.Event, cfg.BufferSize),
+	}
+}
+
+// Handle recebe eventos do barramento; com o buffer cheio o evento é
+// descartado e contado.
+func (o *Outbox) Handle(_ context.Context, e events.Event) {
+	if _, ok := o.topics[e.Topic]; !ok {
+		return
+	}
+	select {
+	case o.events <- e:
+	default:
+		droppedEvents.Inc()
+	}
+}
+
+// Run grava os eventos no stream até ctx ser cancelado e, ao terminar,
+// grava o que ainda estiver no buffer.
+func (o *Outbox) Run(ctx context.Context) error {
+	work := logging.Background(context.WithoutCancel(ctx), "event-outbox")
+
+	batch := make([]events.Event, 0, writeBatch)
+	for {
+		select {
+		case <-ctx.Done():
+			// Esvazia o buffer antes de sair.
+			work, cancel := supervisor.Cleanup(work)
+			defer cancel()
+			for {
+				batch = o.fill(batch[:0])
+				if len(batch) == 0 {
+					return nil
+				}
+				o.write(work, batch)
+			}
+		case e := <-o.events:
+			batch = o.fill(append(batch[:0], e))
+			o.write(work, batch)
+		}
+	}
+}
+
+// fill completa o lote com o que já estiver no buffer, sem esperar.
+func (o *Outbox) fill(batch []events.Event) []events.Event {
+	for len(batch) < writeBatch {
+		select {
+		case e := <-o.events:
+			batch = append(batch, e)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+// Entry monta os campos da entrada do stream para o evento, com um id novo.
+// Outros streams com o mesmo formato (o feed de mudanças de agentes) usam
+// Entry e Decode para que as entradas sejam lidas do mesmo jeito.
+func Entry(e events.Event) (map[string]interface{}, error) {
+	id := uuid.NewString()
+	payload, err := events.EncodeEnvelope(id, e)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		fieldID:         id,
+		fieldTopic:      e.Topic,
+		fieldType:       e.Type,
+		fieldOccurredAt: e.OccurredAt.UTC().Format(time.RFC3339Nano),
+		fieldPayload:    payload,
+	}, nil
+}
+
+// Decode lê uma entrada gravada com Entry; ok é false para entradas
+// incompletas. Subject fica vazio.
+func Decode(entry redis.XMessage) (msg events.Message, ok bool) {
+	get := func(field string) string {
+		s, _ := entry.Values[field].(string)
+		return s
+	}
+	msg = events.Message{
+		ID:      get(fieldID),
+		Topic:   get(fieldTopic),
+		Type:    get(fieldType),
+		Payload: []byte(get(fieldPayload)),
+	}
+	occurredAt, err := time.Parse(time.RFC3339Nano, get(fieldOccurredAt))
+	if err != nil || msg.ID == "" || msg.Type == "" || len(msg.Payload) == 0 {
+		return events.Message{}, false
+	}
+	msg.OccurredAt = occurredAt
+	return msg, true
+}
+
+func (o *Outbox) write(ctx context.Context, batch []events.Event) {
+	if len(batch) == 0 {
+		return
+	}
+	log := logging.FromContext(ctx)
+
+	pipe := o.client.Pipeline()
+	queued := 0
+	for _, e := range batch {
+		values, err := Entry(e)
+		if err != nil {
+			exportErrors.WithLabelValues(o.cfg.Sink, "outbox").Inc()
+			log.WithError(err).WithField("event_type", e.Type).Error("Falha ao serializar evento para o outbox")
+			continue
+		}
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: o.cfg.Stream,
+			MaxLen: o.cfg.MaxLen,
+			Approx: true,
+			Values: values,
+		})
+		queued++
+	}
+	if queued == 0 {
+		return
+	}
+
+	writeCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if _, err := pipe.Exec(writeCtx); err != nil {
+		exportErrors.WithLabelValues(o.cfg.Sink, "outbox").Add(float64(queued))
+		log.WithError(err).WithField("events", queued).Error("Falha ao gravar eventos no outbox; eventos perdidos")
+	}
+}
@@ -0,0 +1,217 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/logging"
+)
+
+// RelayConfig configura a leitura do outbox e o repasse ao sink.
+type RelayConfig struct {
+	Stream string
+	Group  string
+	// Consumer identifica esta instância no consumer group.
+	Consumer     string
+	BatchSize    int
+	BatchTimeout time.Duration
+	// ClaimIdle é o tempo após o qual entradas entregues a outro consumidor e
+	// não confirmadas são reassumidas por esta instância.
+	ClaimIdle    time.Duration
+	RetryBackoff time.Duration
+	MaxBackoff   time.Duration
+}
+
+// Relay lê o outbox via consumer group e repassa os eventos ao sink em lotes.
+// Um lote que falha é reenviado com backoff até ser aceito; só então as
+// entradas são confirmadas.
+type Relay struct {
+	client redis.UniversalClient
+	sink   events.EventSink
+	namer  *events.Namer
+	cfg    RelayConfig
+	paused func() bool
+}
+
+// NewRelay cria o relay; o repasse roda em Run.
+func NewRelay(client redis.UniversalClient, sink events.EventSink, namer *events.Namer, cfg RelayConfig) *Relay {
+	return &Relay{client: client, sink: sink, namer: namer, cfg: cfg}
+}
+
+// PauseWhen suspende o repasse enquanto paused retornar true (modo de
+// manutenção); os eventos se acumulam no outbox e seguem ao retomar.
+// Precisa ser chamado antes de Run.
+func (r *Relay) PauseWhen(paused func() bool) {
+	r.paused = paused
+}
+
+// Run cria o consumer group, se necessário, e repassa os eventos até ctx
+// ser cancelado. Um lote em andamento é abandonado sem confirmação e será
+// reenviado por outra instância ou no próximo início.
+func (r *Relay) Run(ctx context.Context) error {
+	ctx = logging.Background(ctx, "event-relay")
+	err := r.client.XGroupCreateMkStream(ctx, r.cfg.Stream, r.cfg.Group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	r.run(ctx)
+	return nil
+}
+
+// Close fecha o sink; chamado depois que Run termina de vez.
+func (r *Relay) Close() error {
+	return r.sink.Close()
+}
+
+func (r *Relay) run(ctx context.Context) {
+	log := logging.FromContext(ctx).WithField("sink", r.sink.Name())
+	lag := exportLag.WithLabelValues(r.sink.Name())
+
+	// claimCursor percorre as entradas pendentes de outros consumidores; volta
+	// a "0-0" quando a varredura termina.
+	claimCursor := "0-0"
+	var lastClaim time.Time
+
+	for ctx.Err() == nil {
+		if r.paused != nil && r.paused() {
+			sleep(ctx, r.cfg.BatchTimeout)
+			continue
+		}
+		var entries []redis.XMessage
+		if time.Since(lastClaim) >= r.cfg.ClaimIdle {
+			claimed, next, err := r.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+				Stream:   r.cfg.Stream,
+				Group:    r.cfg.Group,
+				Consumer: r.cfg.Consumer,
+				MinIdle:  r.cfg.ClaimIdle,
+				Start:    claimCursor,
+				Count:    int64(r.cfg.BatchSize),
+			}).Result()
+			if err != nil && ctx.Err() == nil {
+				exportErrors.WithLabelValues(r.sink.Name(), "read").Inc()
+				log.WithError(err).Warn("Falha ao reassumir entradas pendentes do outbox")
+			}
+			if err != nil || next == "0-0" {
+				claimCursor, lastClaim = "0-0", time.Now()
+			} else {
+				claimCursor = next
+			}
+			entries = claimed
+		}
+
+		if len(entries) == 0 {
+			streams, err := r.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+				Group:    r.cfg.Group,
+				Consumer: r.cfg.Consumer,
+				Streams:  []string{r.cfg.Stream, ">"},
+				Count:    int64(r.cfg.BatchSize),
+				Block:    r.cfg.BatchTimeout,
+			}).Result()
+			switch {
+			case errors.Is(err, redis.Nil):
+				lag.Set(0)
+				continue
+			case err != nil:
+				if ctx.Err() == nil {
+					exportErrors.WithLabelValues(r.sink.Name(), "read").Inc()
+					log.WithError(err).Warn("Falha ao ler o outbox")
+					sleep(ctx, r.cfg.RetryBackoff)
+				}
+				continue
+			}
+			for _, s := range streams {
+				entries = append(entries, s.Messages...)
+			}
+		}
+
+		r.deliver(ctx, log, entries)
+	}
+}
+
+// deliver repassa um lote ao sink, insistindo até ser aceito ou o relay parar,
+// e confirma as entradas.
+func (r *Relay) deliver(ctx context.Context, log *logrus.Entry, entries []redis.XMessage) {
+	name := r.sink.Name()
+	batch := make([]events.Message, 0, len(entries))
+	ids := make([]string, 0, len(entries))
+	var malformed []string
+	for _, entry := range entries {
+		msg, ok := r.decode(entry)
+		if !ok {
+			exportErrors.WithLabelValues(name, "decode").Inc()
+			log.WithField("entry_id", entry.ID).Error("Entrada inválida no outbox; descartada")
+			malformed = append(malformed, entry.ID)
+			continue
+		}
+		batch = append(batch, msg)
+		ids = append(ids, entry.ID)
+	}
+	if len(malformed) > 0 {
+		r.ack(ctx, log, malformed)
+	}
+	if len(batch) == 0 {
+		return
+	}
+
+	lag := exportLag.WithLabelValues(name)
+	backoff := r.cfg.RetryBackoff
+	for attempt := 1; ; attempt++ {
+		lag.Set(time.Since(batch[0].OccurredAt).Seconds())
+		err := r.sink.Send(ctx, batch)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		exportErrors.WithLabelValues(name, "send").Inc()
+		log.WithError(err).WithFields(logrus.Fields{
+			"attempt": attempt,
+			"events":  len(batch),
+		}).Warn("Falha ao exportar eventos; nova tentativa")
+		if !sleep(ctx, backoff) {
+			return
+		}
+		backoff = min(backoff*2, r.cfg.MaxBackoff)
+	}
+
+	exportedEvents.WithLabelValues(name).Add(float64(len(batch)))
+	lag.Set(time.Since(batch[len(batch)-1].OccurredAt).Seconds())
+	r.ack(ctx, log, ids)
+}
+
+// ack confirma as entradas. Se falhar, elas serão reassumidas e reenviadas:
+// o destino recebe duplicatas, que deduplica pelo id do evento.
+func (r *Relay) ack(ctx context.Context, log *logrus.Entry, ids []string) {
+	if err := r.client.XAck(ctx, r.cfg.Stream, r.cfg.Group, ids...).Err(); err != nil {
+		exportErrors.WithLabelValues(r.sink.Name(), "ack").Inc()
+		log.WithError(err).WithField("events", len(ids)).Warn("Falha ao confirmar entradas do outbox")
+	}
+}
+
+func (r *Relay) decode(entry redis.XMessage) (events.Message, bool) {
+	msg, ok := Decode(entry)
+	if !ok {
+		return msg, false
+	}
+	msg.Subject = r.namer.Subject(msg.Topic, msg.Type)
+	return msg, true
+}
+
+// sleep espera d ou até o relay parar; retorna false se parou.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
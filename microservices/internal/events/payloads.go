@@ -0,0 +1,550 @@
+package events
+
+import "time"
+
+// Payloads versionados dos eventos. Mudanças incompatíveis (renomear ou
+// remover campo, trocar tipo) pedem uma nova versão: um tipo XxxV2 novo,
+// registrado com Downgrade para a v1, enquanto a v1 continua registrada
+// para quem a fixou. Campos novos opcionais (omitempty) não mudam a versão.
+
+// PositionV1 é a posição de um agente.
+type PositionV1 struct {
+	Lat     float64 `json:"lat"`
+	Lon     float64 `json:"lon"`
+	Heading float64 `json:"heading"`
+	Speed   float64 `json:"speed"`
+}
+
+// AgentV1 é o payload de agent.created.v1 e agent.updated.v1: o agente
+// como em GET /api/v1/agents/{id}.
+type AgentV1 struct {
+	ID           string                 `json:"id"`
+	SimulationID string                 `json:"simulation_id"`
+	ProjectID    string                 `json:"project_id"`
+	Type         string                 `json:"type"`
+	Name         string                 `json:"name"`
+	Status       string                 `json:"status"`
+	Position     PositionV1             `json:"position"`
+	Energy       float64                `json:"energy"`
+	State        map[string]interface{} `json:"state"`
+	Metadata     map[string]interface{} `json:"metadata"`
+	Tags         []string               `json:"tags"`
+	CreatedAt    time.Time              `json:"created_at"`
+	UpdatedAt    time.Time              `json:"updated_at"`
+}
+
+// AgentDeletedV1 é o payload de agent.deleted.v1.
+type AgentDeletedV1 struct {
+	ID           string `json:"id"`
+	SimulationID string `json:"simulation_id,omitempty"`
+	ProjectID    string `json:"project_id,omitempty"`
+}
+
+// AgentActionV1 é o payload dos eventos agent.action.* v1 (queued, running,
+// succeeded, failed, cancelled e timed_out): o estado de uma ação assíncrona
+// após a transição.
+type AgentActionV1 struct {
+	ID          string                 `json:"id"`
+	AgentID     string                 `json:"agent_id"`
+	ProjectID   string                 `json:"project_id,omitempty"`
+	Action      string                 `json:"action"`
+	Status      string                 `json:"status"`
+	Priority    string                 `json:"priority,omitempty"`
+	Attempt     int                    `json:"attempt"`
+	MaxAttempts int                    `json:"max_attempts"`
+	ResultID    string                 `json:"result_id,omitempty"`
+	Result      map[string]interface{} `json:"result,omitempty"`
+	Error       string                 `json:"error,omitempty"`
+	// Reason explica cancelled e timed_out; Result traz então o resultado
+	// parcial, se houver.
+	Reason     string     `json:"reason,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// ScheduledActionV1 é o payload de agent.scheduled_action.fired.v1 e
+// agent.scheduled_action.skipped.v1: a ocorrência de um agendamento e, no
+// disparo, a execução criada.
+type ScheduledActionV1 struct {
+	ID           string    `json:"id"`
+	AgentID      string    `json:"agent_id"`
+	Action       string    `json:"action"`
+	ScheduledFor time.Time `json:"scheduled_for"`
+	ActionID     string    `json:"action_id,omitempty"`
+	// Reason explica por que a ocorrência foi pulada.
+	Reason string `json:"reason,omitempty"`
+}
+
+// AgentHealthChangedV1 é o payload de agent.health_changed.v1: a nova
+// classificação de saúde do agente, a anterior (unknown quando ele ainda
+// não tinha nota) e a nota que provocou a mudança.
+type AgentHealthChangedV1 struct {
+	AgentID        string  `json:"agent_id"`
+	AgentType      string  `json:"agent_type"`
+	ProjectID      string  `json:"project_id,omitempty"`
+	Status         string  `json:"status"`
+	PreviousStatus string  `json:"previous_status"`
+	Score          float64 `json:"score"`
+}
+
+// AgentAnomalyV1 é o payload de agent.anomaly.v1 e
+// agent.anomaly_cleared.v1: a métrica do agente que ficou (ou deixou de
+// ficar) a mais de K desvios-padrão da linha de base, o valor observado
+// que provocou a mudança e a faixa esperada, Mean ± K·StdDev. Sigmas é a
+// distância do valor à média em desvios-padrão; sem desvio, fica de fora.
+type AgentAnomalyV1 struct {
+	AgentID   string        `json:"agent_id"`
+	AgentType string        `json:"agent_type"`
+	ProjectID string        `json:"project_id,omitempty"`
+	Metric    string        `json:"metric"`
+	Observed  float64       `json:"observed"`
+	Expected  ExpectedRange `json:"expected"`
+	Mean      float64       `json:"mean"`
+	StdDev    float64       `json:"stddev"`
+	Sigmas    *float64      `json:"sigmas,omitempty"`
+	K         float64       `json:"k"`
+	Window    int           `json:"window"`
+	At        time.Time     `json:"at"`
+}
+
+// ExpectedRange é a faixa de valores esperada de uma métrica.
+type ExpectedRange struct {
+	Low  float64 `json:"low"`
+	High float64 `json:"high"`
+}
+
+// CityAdvisoryV1 é o payload de city.advisory.v1: um aviso para a cidade
+// inteira publicado por outro serviço (ex.: o de clima) em
+// POST /api/v1/internal/broadcast. Severity é info, warning ou critical.
+type CityAdvisoryV1 struct {
+	Title     string     `json:"title"`
+	Message   string     `json:"message"`
+	Severity  string     `json:"severity"`
+	Area      string     `json:"area,omitempty"`
+	Source    string     `json:"source,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// AgentRateLimitedV1 é o payload de agent.rate_limited.v1: o agente passou
+// do limite de reportes de telemetria da janela, que vai até Until. Mode
+// diz se os reportes seguintes são recusados (reject) ou amostrados
+// (sample); Source, de onde vem o limite (agent, type ou default).
+type AgentRateLimitedV1 struct {
+	AgentID       string    `json:"agent_id"`
+	AgentType     string    `json:"agent_type"`
+	ProjectID     string    `json:"project_id,omitempty"`
+	Limit         int       `json:"limit"`
+	WindowSeconds int       `json:"window_seconds"`
+	Mode          string    `json:"mode"`
+	Source        string    `json:"source"`
+	Until         time.Time `json:"until"`
+}
+
+// AgentCapabilitiesChangedV1 é o payload de agent.capabilities_changed.v1:
+// as capacidades efetivas do agente depois da mudança e o que entrou e saiu.
+type AgentCapabilitiesChangedV1 struct {
+	AgentID      string   `json:"agent_id"`
+	AgentType    string   `json:"agent_type"`
+	ProjectID    string   `json:"project_id,omitempty"`
+	Capabilities []string `json:"capabilities"`
+	Added        []string `json:"added"`
+	Removed      []string `json:"removed"`
+}
+
+// AgentPresenceV1 é o payload de agent.offline.v1 e agent.online.v1: o
+// status do agente depois da transição e o de antes, o último reporte e
+// desde quando ele estava offline. SilenceSeconds vem em agent.offline;
+// OfflineSeconds, em agent.online.
+type AgentPresenceV1 struct {
+	AgentID        string    `json:"agent_id"`
+	AgentType      string    `json:"agent_type"`
+	SimulationID   string    `json:"simulation_id,omitempty"`
+	ProjectID      string    `json:"project_id,omitempty"`
+	Status         string    `json:"status"`
+	PreviousStatus string    `json:"previous_status,omitempty"`
+	LastSeenAt     time.Time `json:"last_seen_at"`
+	OfflineSince   time.Time `json:"offline_since"`
+	SilenceSeconds float64   `json:"silence_seconds,omitempty"`
+	OfflineSeconds float64   `json:"offline_seconds,omitempty"`
+}
+
+// AgentTransferredV1 é o payload de agent.transferred.v1: a simulação de
+// origem e a de destino do agente e se ele foi pausado pela transferência.
+type AgentTransferredV1 struct {
+	AgentID            string `json:"agent_id"`
+	AgentType          string `json:"agent_type"`
+	ProjectID          string `json:"project_id,omitempty"`
+	SourceSimulationID string `json:"source_simulation_id"`
+	TargetSimulationID string `json:"target_simulation_id"`
+	Status             string `json:"status"`
+	Paused             bool   `json:"paused"`
+	TransferredBy      string `json:"transferred_by,omitempty"`
+}
+
+// AgentProjectTransferV1 é o payload de agent.project_transfer_requested.v1,
+// agent.project_transferred.v1 e agent.project_transfer_rejected.v1: os
+// dois projetos e as duas simulações de uma transferência entre projetos.
+// Paused e DecidedBy só valem depois da decisão; Reason, na recusa.
+type AgentProjectTransferV1 struct {
+	TransferID         string    `json:"transfer_id"`
+	AgentID            string    `json:"agent_id"`
+	AgentType          string    `json:"agent_type"`
+	SourceProjectID    string    `json:"source_project_id"`
+	TargetProjectID    string    `json:"target_project_id"`
+	SourceSimulationID string    `json:"source_simulation_id"`
+	TargetSimulationID string    `json:"target_simulation_id"`
+	Status             string    `json:"status"`
+	Paused             bool      `json:"paused"`
+	RequestedBy        string    `json:"requested_by,omitempty"`
+	DecidedBy          string    `json:"decided_by,omitempty"`
+	Reason             string    `json:"reason,omitempty"`
+	ExpiresAt          time.Time `json:"expires_at"`
+}
+
+// AgentTwinV1 é o payload de agent.twin_reported.v1 e
+// agent.twin_desired_changed.v1: a nova versão do lado alterado do gêmeo
+// digital e só os caminhos que mudaram. Desired e UpdatedBy só vêm em
+// agent.twin_desired_changed.
+type AgentTwinV1 struct {
+	AgentID      string                 `json:"agent_id"`
+	AgentType    string                 `json:"agent_type"`
+	SimulationID string                 `json:"simulation_id,omitempty"`
+	ProjectID    string                 `json:"project_id,omitempty"`
+	Version      int64                  `json:"version"`
+	Changes      []TwinChangeV1         `json:"changes"`
+	Desired      map[string]interface{} `json:"desired,omitempty"`
+	UpdatedBy    string                 `json:"updated_by,omitempty"`
+}
+
+// TwinChangeV1 é um caminho alterado de AgentTwinV1, como JSON Pointer. Op
+// é added, removed ou changed.
+type TwinChangeV1 struct {
+	Path string      `json:"path"`
+	Op   string      `json:"op"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+// AgentImpairmentV1 é o payload de agent.impaired.v1 e
+// agent.impairment_cleared.v1: a causa raiz do prejuízo do agente (a
+// anterior, quando ele deixa de estar prejudicado) e o caminho de
+// dependências do agente até ela.
+type AgentImpairmentV1 struct {
+	AgentID         string    `json:"agent_id"`
+	ProjectID       string    `json:"project_id,omitempty"`
+	RootCauseID     string    `json:"root_cause_id"`
+	RootCauseStatus string    `json:"root_cause_status"`
+	Path            []string  `json:"path"`
+	Since           time.Time `json:"since"`
+}
+
+// AgentResetV1 é o payload de agent.reset.v1: o agente reiniciado numa
+// simulação em execução, o último tick antes do reinício, a origem do
+// estado aplicado (initial ou template) e os agentes prejudicados até o
+// próximo tick.
+type AgentResetV1 struct {
+	AgentID      string   `json:"agent_id"`
+	SimulationID string   `json:"simulation_id"`
+	ProjectID    string   `json:"project_id,omitempty"`
+	Tick         int64    `json:"tick"`
+	Source       string   `json:"source"`
+	Dependents   []string `json:"dependents"`
+	ResetBy      string   `json:"reset_by,omitempty"`
+}
+
+// AgentProximityV1 é o payload de agent.proximity.v1: dois agentes de uma
+// simulação que ficaram a menos do limiar um do outro no tick.
+type AgentProximityV1 struct {
+	SimulationID string              `json:"simulation_id"`
+	ProjectID    string              `json:"project_id,omitempty"`
+	Tick         int64               `json:"tick"`
+	Agents       [2]ProximityAgentV1 `json:"agents"`
+	DistanceM    float64             `json:"distance_m"`
+	ThresholdM   float64             `json:"threshold_m"`
+}
+
+// ProximityAgentV1 é um dos agentes de AgentProximityV1.
+type ProximityAgentV1 struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+}
+
+// GroupMembersV1 é o payload de group.members_added.v1 e
+// group.members_removed.v1: os agentes que de fato entraram ou saíram.
+type GroupMembersV1 struct {
+	GroupID   string   `json:"group_id"`
+	ProjectID string   `json:"project_id"`
+	AgentIDs  []string `json:"agent_ids"`
+}
+
+// GroupActionV1 é o payload de group.action_dispatched.v1. Operation é
+// start ou stop, com o status dado aos membros, ou action, com a ação e o
+// lote que a executa.
+type GroupActionV1 struct {
+	GroupID   string `json:"group_id"`
+	ProjectID string `json:"project_id"`
+	Operation string `json:"operation"`
+	Status    string `json:"status,omitempty"`
+	Action    string `json:"action,omitempty"`
+	BatchID   string `json:"batch_id,omitempty"`
+	Agents    int    `json:"agents"`
+}
+
+// SimulationV1 é o payload dos eventos do ciclo de vida de simulações.
+type SimulationV1 struct {
+	ID        string     `json:"id"`
+	ProjectID string     `json:"project_id,omitempty"`
+	Name      string     `json:"name"`
+	Status    string     `json:"status"`
+	StartedAt *time.Time `json:"started_at,omitempty"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+	// Reason explica falhas e paradas automáticas.
+	Reason string `json:"reason,omitempty"`
+	// Failure resume o relatório de falha em simulation.failed.
+	Failure *SimulationFailureV1 `json:"failure,omitempty"`
+}
+
+// SimulationFailureV1 é o resumo do relatório de falha de uma simulação;
+// o relatório inteiro fica em report_url.
+type SimulationFailureV1 struct {
+	Error    string `json:"error"`
+	Tick     *int64 `json:"tick,omitempty"`
+	AgentID  string `json:"agent_id,omitempty"`
+	Behavior string `json:"behavior,omitempty"`
+	Hook     string `json:"hook,omitempty"`
+	// Panic indica que a falha foi um pânico recuperado no tick.
+	Panic     bool   `json:"panic"`
+	ReportURL string `json:"report_url"`
+}
+
+// SimulationMessageV1 é o payload de simulation.message_delivered.v1: uma
+// mensagem entre agentes, o tick em que foi enviada, o tick em que chegou e
+// os agentes que a receberam (To, ou os do tipo ToType menos o remetente).
+type SimulationMessageV1 struct {
+	ID            string                 `json:"id"`
+	SimulationID  string                 `json:"simulation_id"`
+	ProjectID     string                 `json:"project_id,omitempty"`
+	From          string                 `json:"from"`
+	To            string                 `json:"to,omitempty"`
+	ToType        string                 `json:"to_type,omitempty"`
+	Recipients    []string               `json:"recipients"`
+	Payload       map[string]interface{} `json:"payload"`
+	SentAt        time.Time              `json:"sent_at"`
+	SentTick      int64                  `json:"sent_tick"`
+	DeliveredTick int64                  `json:"delivered_tick"`
+}
+
+// SimulationFaultV1 é o payload de simulation.fault_injected.v1 e
+// simulation.fault_ended.v1: a falha injetada na simulação e, no fim, o
+// motivo (expired ou cancelled) e os agentes que ela pôs offline.
+type SimulationFaultV1 struct {
+	ID             string    `json:"id"`
+	SimulationID   string    `json:"simulation_id"`
+	ProjectID      string    `json:"project_id,omitempty"`
+	Kind           string    `json:"kind"`
+	Percent        float64   `json:"percent,omitempty"`
+	LatencyMs      int       `json:"latency_ms,omitempty"`
+	Agents         int       `json:"agents,omitempty"`
+	JitterM        float64   `json:"jitter_m,omitempty"`
+	AffectedAgents []string  `json:"affected_agents,omitempty"`
+	StartedAt      time.Time `json:"started_at"`
+	ExpiresAt      time.Time `json:"expires_at"`
+	CreatedBy      string    `json:"created_by,omitempty"`
+	Reason         string    `json:"reason,omitempty"`
+}
+
+// AlertV1 é o payload de alert.firing.v1 e alert.resolved.v1.
+type AlertV1 struct {
+	RuleID       string  `json:"rule_id"`
+	RuleName     string  `json:"rule_name"`
+	Severity     string  `json:"severity"`
+	Expression   string  `json:"expression"`
+	Operator     string  `json:"operator"`
+	Threshold    float64 `json:"threshold"`
+	Value        float64 `json:"value"`
+	ProjectID    string  `json:"project_id,omitempty"`
+	SimulationID string  `json:"simulation_id,omitempty"`
+	// FiringSince só vem em alert.resolved.
+	FiringSince *time.Time `json:"firing_since,omitempty"`
+}
+
+// WebhookDisabledV1 é o payload de webhook.disabled.v1.
+type WebhookDisabledV1 struct {
+	WebhookID string `json:"webhook_id"`
+	URL       string `json:"url"`
+	Reason    string `json:"reason"`
+}
+
+// ConfigChangeV1 é uma chave alterada numa recarga, com valores sensíveis
+// mascarados.
+type ConfigChangeV1 struct {
+	Key string      `json:"key"`
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+// ConfigReloadedV1 é o payload de config.reloaded.v1.
+type ConfigReloadedV1 struct {
+	Changes []ConfigChangeV1 `json:"changes"`
+}
+
+// InstanceLostV1 é o payload de instance.lost.v1.
+type InstanceLostV1 struct {
+	ID string `json:"id"`
+}
+
+// SecretRotatedV1 é o payload de secret.rotated.v1.
+type SecretRotatedV1 struct {
+	Key string `json:"key"`
+}
+
+// QuotaLimitsV1 são os limites de um projeto por recurso; 0 é sem limite.
+type QuotaLimitsV1 struct {
+	Agents          int64 `json:"agents"`
+	EventRows       int64 `json:"event_rows"`
+	CheckpointBytes int64 `json:"checkpoint_bytes"`
+	ArchiveBytes    int64 `json:"archive_bytes"`
+}
+
+// QuotaUpdatedV1 é o payload de project.quota_updated.v1: os limites
+// efetivos depois da mudança; Override é falso quando o projeto voltou à
+// cota padrão.
+type QuotaUpdatedV1 struct {
+	ProjectID string        `json:"project_id"`
+	Override  bool          `json:"override"`
+	Limits    QuotaLimitsV1 `json:"limits"`
+}
+
+// QuotaExceededV1 é o payload de project.quota_exceeded.v1.
+type QuotaExceededV1 struct {
+	ProjectID string `json:"project_id"`
+	Resource  string `json:"resource"`
+	Limit     int64  `json:"limit"`
+	Used      int64  `json:"used"`
+}
+
+// ProjectBackupCreatedV1 é o payload de project.backup_created.v1; Counts
+// são as linhas de cada tipo de entidade no backup.
+type ProjectBackupCreatedV1 struct {
+	BackupID  string           `json:"backup_id"`
+	ProjectID string           `json:"project_id"`
+	Size      int64            `json:"size"`
+	Counts    map[string]int64 `json:"counts"`
+	CreatedBy string           `json:"created_by,omitempty"`
+}
+
+// RestoreCountsV1 resume a restauração de um tipo de entidade.
+type RestoreCountsV1 struct {
+	Created int64 `json:"created"`
+	Updated int64 `json:"updated"`
+	Skipped int64 `json:"skipped"`
+}
+
+// ProjectRestoredV1 é o payload de project.restored.v1. Remapped indica a
+// restauração em outro projeto, com ids novos; Forced, que havia
+// simulações em execução no destino.
+type ProjectRestoredV1 struct {
+	BackupID        string                     `json:"backup_id"`
+	SourceProjectID string                     `json:"source_project_id"`
+	ProjectID       string                     `json:"project_id"`
+	Remapped        bool                       `json:"remapped"`
+	Forced          bool                       `json:"forced"`
+	Entities        map[string]RestoreCountsV1 `json:"entities"`
+}
+
+// MaintenanceChangedV1 é o payload de maintenance.changed.v1.
+type MaintenanceChangedV1 struct {
+	From              string `json:"from"`
+	To                string `json:"to"`
+	Reason            string `json:"reason,omitempty"`
+	SetBy             string `json:"set_by,omitempty"`
+	RetryAfterSeconds int    `json:"retry_after_seconds"`
+}
+
+// SimulationWarmupCompletedV1 é o payload de
+// simulation.warmup_completed.v1: a simulação passou dos warmup_ticks de
+// aquecimento e Tick é o primeiro tick cujas amostras entram nos resumos.
+type SimulationWarmupCompletedV1 struct {
+	SimulationID string    `json:"simulation_id"`
+	ProjectID    string    `json:"project_id,omitempty"`
+	WarmupTicks  int64     `json:"warmup_ticks"`
+	Tick         int64     `json:"tick"`
+	CompletedAt  time.Time `json:"completed_at"`
+}
+
+// KPIUpdatedV1 é o payload de kpi.updated.v1. Attainment fica ausente
+// quando a meta ou o valor é zero.
+type KPIUpdatedV1 struct {
+	SimulationID string    `json:"simulation_id"`
+	ProjectID    string    `json:"project_id,omitempty"`
+	Name         string    `json:"name"`
+	Expression   string    `json:"expression"`
+	Value        float64   `json:"value"`
+	Target       float64   `json:"target"`
+	Direction    string    `json:"direction"`
+	Attainment   *float64  `json:"attainment,omitempty"`
+	Met          bool      `json:"met"`
+	EvaluatedAt  time.Time `json:"evaluated_at"`
+}
+
+func init() {
+	for _, s := range []Schema{
+		{Type: "agent.created", Version: 1, Topic: TopicAgents, Payload: AgentV1{}, Description: "Agente criado."},
+		{Type: "agent.updated", Version: 1, Topic: TopicAgents, Payload: AgentV1{}, Description: "Agente alterado; traz o estado completo."},
+		{Type: "agent.deleted", Version: 1, Topic: TopicAgents, Payload: AgentDeletedV1{}, Description: "Agente removido."},
+		{Type: "agent.action.queued", Version: 1, Topic: TopicAgents, Payload: AgentActionV1{}, Description: "Ação assíncrona na fila; também após uma falha com nova tentativa."},
+		{Type: "agent.action.running", Version: 1, Topic: TopicAgents, Payload: AgentActionV1{}, Description: "Ação assíncrona em execução."},
+		{Type: "agent.action.succeeded", Version: 1, Topic: TopicAgents, Payload: AgentActionV1{}, Description: "Ação assíncrona concluída; result traz o resultado."},
+		{Type: "agent.action.failed", Version: 1, Topic: TopicAgents, Payload: AgentActionV1{}, Description: "Ação assíncrona falhou sem tentativas restantes."},
+		{Type: "agent.action.cancelled", Version: 1, Topic: TopicAgents, Payload: AgentActionV1{}, Description: "Ação assíncrona cancelada; reason traz o motivo e result o resultado parcial."},
+		{Type: "agent.action.timed_out", Version: 1, Topic: TopicAgents, Payload: AgentActionV1{}, Description: "Última tentativa da ação assíncrona expirou; result traz o resultado parcial."},
+		{Type: "agent.scheduled_action.fired", Version: 1, Topic: TopicAgents, Payload: ScheduledActionV1{}, Description: "Agendamento disparado; action_id é a execução colocada na fila."},
+		{Type: "agent.scheduled_action.skipped", Version: 1, Topic: TopicAgents, Payload: ScheduledActionV1{}, Description: "Ocorrência perdida de um agendamento pulada por exceder a tolerância."},
+		{Type: "agent.health_changed", Version: 1, Topic: TopicAgents, Payload: AgentHealthChangedV1{}, Description: "Agente mudou de classificação de saúde (healthy, degraded, critical)."},
+		{Type: "agent.anomaly", Version: 1, Topic: TopicAgents, Payload: AgentAnomalyV1{}, Description: "Métrica do agente ficou fora da faixa esperada por window amostras seguidas."},
+		{Type: "agent.anomaly_cleared", Version: 1, Topic: TopicAgents, Payload: AgentAnomalyV1{}, Description: "Métrica anômala do agente voltou à faixa esperada."},
+		{Type: "agent.rate_limited", Version: 1, Topic: TopicAgents, Payload: AgentRateLimitedV1{}, Description: "Agente passou do limite de reportes de telemetria; uma vez por janela."},
+		{Type: "agent.capabilities_changed", Version: 1, Topic: TopicAgents, Payload: AgentCapabilitiesChangedV1{}, Description: "Capacidades efetivas do agente mudaram, por declaração ou volta às padrão do tipo."},
+		{Type: "agent.offline", Version: 1, Topic: TopicAgents, Payload: AgentPresenceV1{}, Description: "Agente passou a offline por ficar sem reportes além do offline_after do tipo."},
+		{Type: "agent.online", Version: 1, Topic: TopicAgents, Payload: AgentPresenceV1{}, Description: "Agente offline voltou a reportar e recebeu o status de antes."},
+		{Type: "agent.transferred", Version: 1, Topic: TopicAgents, Payload: AgentTransferredV1{}, Description: "Agente transferido de uma simulação para outra."},
+		{Type: "agent.project_transfer_requested", Version: 1, Topic: TopicAgents, Payload: AgentProjectTransferV1{}, Description: "Pedida a transferência de um agente para outro projeto; aguarda a aprovação do destino."},
+		{Type: "agent.project_transferred", Version: 1, Topic: TopicAgents, Payload: AgentProjectTransferV1{}, Description: "Transferência entre projetos aprovada; o agente está na simulação de destino."},
+		{Type: "agent.project_transfer_rejected", Version: 1, Topic: TopicAgents, Payload: AgentProjectTransferV1{}, Description: "Transferência entre projetos recusada pelo projeto de destino."},
+		{Type: "agent.twin_reported", Version: 1, Topic: TopicAgents, Payload: AgentTwinV1{}, Description: "Estado reportado do gêmeo digital mudou; traz só os caminhos alterados."},
+		{Type: "agent.twin_desired_changed", Version: 1, Topic: TopicAgents, Payload: AgentTwinV1{}, Description: "Operador alterou o estado desejado do gêmeo digital."},
+		{Type: "agent.impaired", Version: 1, Topic: TopicAgents, Payload: AgentImpairmentV1{}, Description: "Agente prejudicado por uma dependência com falha, ou com nova causa raiz."},
+		{Type: "agent.impairment_cleared", Version: 1, Topic: TopicAgents, Payload: AgentImpairmentV1{}, Description: "Agente deixou de estar prejudicado; traz a última causa raiz."},
+		{Type: "agent.reset", Version: 1, Topic: TopicAgents, Payload: AgentResetV1{}, Description: "Agente reiniciado ao estado inicial numa simulação em execução; os dependentes ficam prejudicados até o próximo tick."},
+		{Type: "agent.proximity", Version: 1, Topic: TopicAgents, Payload: AgentProximityV1{}, Description: "Dois agentes de uma simulação ficaram a menos do limiar de proximidade um do outro."},
+		{Type: "group.members_added", Version: 1, Topic: "group:<id>", Payload: GroupMembersV1{}, Description: "Agentes incluídos no grupo."},
+		{Type: "group.members_removed", Version: 1, Topic: "group:<id>", Payload: GroupMembersV1{}, Description: "Agentes retirados do grupo."},
+		{Type: "group.action_dispatched", Version: 1, Topic: "group:<id>", Payload: GroupActionV1{}, Description: "Operação disparada em todos os membros do grupo (start, stop ou action)."},
+		{Type: "city.advisory", Version: 1, Topic: "<tópico do pedido>", Payload: CityAdvisoryV1{}, Description: "Aviso para a cidade publicado por outro serviço em POST /api/v1/internal/broadcast."},
+		{Type: "simulation.created", Version: 1, Topic: TopicSimulations, Payload: SimulationV1{}, Description: "Simulação criada."},
+		{Type: "simulation.started", Version: 1, Topic: TopicSimulations, Payload: SimulationV1{}, Description: "Simulação iniciada."},
+		{Type: "simulation.stopped", Version: 1, Topic: TopicSimulations, Payload: SimulationV1{}, Description: "Simulação parada por um operador."},
+		{Type: "simulation.completed", Version: 1, Topic: TopicSimulations, Payload: SimulationV1{}, Description: "Simulação concluída."},
+		{Type: "simulation.failed", Version: 1, Topic: TopicSimulations, Payload: SimulationV1{}, Description: "Simulação encerrada com erro; reason traz o motivo e failure, o resumo do relatório de falha."},
+		{Type: "simulation.auto_stopped", Version: 1, Topic: TopicSimulations, Payload: SimulationV1{}, Description: "Simulação parada automaticamente; reason traz o motivo."},
+		{Type: "simulation.message_delivered", Version: 1, Topic: TopicSimulations, Payload: SimulationMessageV1{}, Description: "Mensagem entre agentes entregue num tick da simulação."},
+		{Type: "simulation.fault_injected", Version: 1, Topic: TopicSimulations, Payload: SimulationFaultV1{}, Description: "Falha injetada na simulação para testes de resiliência."},
+		{Type: "simulation.fault_ended", Version: 1, Topic: TopicSimulations, Payload: SimulationFaultV1{}, Description: "Falha injetada expirou ou foi cancelada; reason traz o motivo."},
+		{Type: "simulation.warmup_completed", Version: 1, Topic: TopicSimulations, Payload: SimulationWarmupCompletedV1{}, Description: "Simulação saiu do aquecimento; as amostras seguintes entram nos resumos."},
+		{Type: "kpi.updated", Version: 1, Topic: TopicSimulations, Payload: KPIUpdatedV1{}, Description: "Valor de um KPI da simulação mudou na avaliação."},
+		{Type: "alert.firing", Version: 1, Topic: TopicAlerts, Payload: AlertV1{}, Description: "Regra de alerta disparou."},
+		{Type: "alert.resolved", Version: 1, Topic: TopicAlerts, Payload: AlertV1{}, Description: "Alerta resolvido após a histerese."},
+		{Type: "webhook.disabled", Version: 1, Topic: TopicAdmin, Payload: WebhookDisabledV1{}, Description: "Webhook desativado após falhas consecutivas."},
+		{Type: "config.reloaded", Version: 1, Topic: TopicAdmin, Payload: ConfigReloadedV1{}, Description: "Configuração recarregada sem reinício."},
+		{Type: "instance.lost", Version: 1, Topic: TopicAdmin, Payload: InstanceLostV1{}, Description: "Réplica deixou de enviar heartbeat."},
+		{Type: "secret.rotated", Version: 1, Topic: TopicAdmin, Payload: SecretRotatedV1{}, Description: "Segredo rotacionado no provider."},
+		{Type: "project.quota_updated", Version: 1, Topic: TopicAdmin, Payload: QuotaUpdatedV1{}, Description: "Cota de armazenamento do projeto alterada ou de volta ao padrão."},
+		{Type: "project.quota_exceeded", Version: 1, Topic: TopicAdmin, Payload: QuotaExceededV1{}, Description: "Projeto atingiu a cota de um recurso; as criações que o usam passam a ser recusadas."},
+		{Type: "project.backup_created", Version: 1, Topic: TopicAdmin, Payload: ProjectBackupCreatedV1{}, Description: "Backup do projeto gravado no armazenamento de objetos."},
+		{Type: "project.restored", Version: 1, Topic: TopicAdmin, Payload: ProjectRestoredV1{}, Description: "Backup restaurado no projeto; entities traz o resumo por tipo de entidade."},
+		{Type: "maintenance.changed", Version: 1, Topic: TopicAdmin, Payload: MaintenanceChangedV1{}, Description: "Modo de manutenção alterado (off, read_only ou full)."},
+	} {
+		Schemas.Register(s)
+	}
+}
@@ -0,0 +1,60 @@
+package events
+
+import (
+	"context"
+	"sync"
+)
+
+// Recent guarda os últimos eventos publicados no barramento, para consultas
+// sem armazenamento externo. O histórico é desta instância e se perde ao
+// reiniciar.
+type Recent struct {
+	mu   sync.Mutex
+	buf  []Event
+	next int
+	full bool
+}
+
+// NewRecent cria o buffer com capacidade para size eventos.
+func NewRecent(size int) *Recent {
+	return &Recent{buf: make([]Event, size)}
+}
+
+// Handle guarda o evento, descartando o mais antigo com o buffer cheio.
+func (r *Recent) Handle(_ context.Context, e Event) {
+	r.mu.Lock()
+	r.buf[r.next] = e
+	r.next = (r.next + 1) % len(r.buf)
+	if r.next == 0 {
+		r.full = true
+	}
+	r.mu.Unlock()
+}
+
+// List retorna até limit eventos, do mais novo ao mais antigo. topic e
+// eventType, se não vazios, filtram o resultado.
+func (r *Recent) List(topic, eventType string, limit int) []Event {
+	return r.ListFunc(func(e Event) bool {
+		return (topic == "" || e.Topic == topic) && (eventType == "" || e.Type == eventType)
+	}, limit)
+}
+
+// ListFunc retorna até limit eventos aceitos por match, do mais novo ao mais
+// antigo.
+func (r *Recent) ListFunc(match func(Event) bool, limit int) []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := r.next
+	if r.full {
+		n = len(r.buf)
+	}
+	out := make([]Event, 0, min(limit, n))
+	for i := 1; i <= n && len(out) < limit; i++ {
+		e := r.buf[(r.next-i+len(r.buf))%len(r.buf)]
+		if match(e) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
@@ -0,0 +1,447 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"smart-city-microservices/internal/logging"
+)
+
+// Modos de validação dos payloads publicados no barramento.
+const (
+	// ValidationOff não valida (padrão em produção).
+	ValidationOff = "off"
+	// ValidationWarn registra o evento inválido e o entrega mesmo assim.
+	ValidationWarn = "warn"
+	// ValidationReject registra e descarta o evento inválido; para dev e testes.
+	ValidationReject = "reject"
+)
+
+// Schema descreve uma versão do payload de um tipo de evento. O JSON Schema
+// é gerado do tipo Go de Payload: campos sem omitempty são obrigatórios e
+// campos desconhecidos são recusados, de modo que renomear um campo quebra a
+// validação em vez de quebrar os consumidores.
+type Schema struct {
+	Type        string
+	Version     int
+	Topic       string
+	Description string
+	// Payload é um valor do tipo Go do payload, ex.: AgentV1{}.
+	Payload interface{}
+	// Downgrade converte um payload desta versão no da versão anterior.
+	// Obrigatório a partir da v2: é o que permite continuar entregando a
+	// versão antiga a quem a fixou.
+	Downgrade func(data json.RawMessage) (interface{}, error)
+
+	jsonSchema map[string]interface{}
+}
+
+// Name é o tipo versionado, ex.: "agent.updated.v1".
+func (s *Schema) Name() string {
+	return VersionedType(s.Type, s.Version)
+}
+
+// JSONSchema retorna a definição JSON Schema do payload.
+func (s *Schema) JSONSchema() map[string]interface{} {
+	return s.jsonSchema
+}
+
+// VersionedType monta o nome versionado de um tipo de evento.
+func VersionedType(eventType string, version int) string {
+	if version <= 0 {
+		return eventType
+	}
+	return eventType + ".v" + strconv.Itoa(version)
+}
+
+// ParseVersionedType separa "agent.updated.v1" em tipo e versão.
+func ParseVersionedType(s string) (string, int, error) {
+	i := strings.LastIndex(s, ".v")
+	if i <= 0 {
+		return "", 0, fmt.Errorf("event type %q has no version (use e.g. agent.updated.v1)", s)
+	}
+	v, err := strconv.Atoi(s[i+2:])
+	if err != nil || v <= 0 {
+		return "", 0, fmt.Errorf("invalid version in event type %q", s)
+	}
+	return s[:i], v, nil
+}
+
+// Registry guarda os schemas conhecidos por tipo e versão.
+type Registry struct {
+	mu      sync.RWMutex
+	schemas map[string]map[int]*Schema
+}
+
+// NewRegistry cria um registro vazio.
+func NewRegistry() *Registry {
+	return &Registry{schemas: map[string]map[int]*Schema{}}
+}
+
+// Schemas é o registro dos eventos publicados pelo serviço, preenchido em
+// payloads.go.
+var Schemas = NewRegistry()
+
+// Register adiciona um schema. Como prometheus.MustRegister, entra em pânico
+// com definições inválidas, que são erro de programação.
+func (r *Registry) Register(s Schema) {
+	if s.Type == "" || s.Version <= 0 || s.Payload == nil {
+		panic(fmt.Sprintf("events: schema inválido para %q", s.Name()))
+	}
+	if s.Version > 1 && s.Downgrade == nil {
+		panic(fmt.Sprintf("events: %s precisa de Downgrade", s.Name()))
+	}
+	s.jsonSchema = jsonSchemaOf(reflect.TypeOf(s.Payload))
+	s.jsonSchema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	s.jsonSchema["$id"] = "urn:smartcity:event:" + s.Name()
+	s.jsonSchema["title"] = s.Name()
+	if s.Description != "" {
+		s.jsonSchema["description"] = s.Description
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	versions := r.schemas[s.Type]
+	if versions == nil {
+		versions = map[int]*Schema{}
+		r.schemas[s.Type] = versions
+	}
+	if _, dup := versions[s.Version]; dup {
+		panic(fmt.Sprintf("events: %s registrado duas vezes", s.Name()))
+	}
+	versions[s.Version] = &s
+}
+
+// Lookup retorna o schema do tipo na versão informada.
+func (r *Registry) Lookup(eventType string, version int) (*Schema, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	s, ok := r.schemas[eventType][version]
+	return s, ok
+}
+
+// Current retorna a versão mais recente do tipo, emitida pelos produtores; 0
+// se o tipo não tem schema.
+func (r *Registry) Current(eventType string) int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	current := 0
+	for v := range r.schemas[eventType] {
+		current = max(current, v)
+	}
+	return current
+}
+
+// List retorna todos os schemas, ordenados por tipo e versão.
+func (r *Registry) List() []*Schema {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var out []*Schema
+	for _, versions := range r.schemas {
+		for _, s := range versions {
+			out = append(out, s)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Type != out[j].Type {
+			return out[i].Type < out[j].Type
+		}
+		return out[i].Version < out[j].Version
+	})
+	return out
+}
+
+// Validate confere o payload do evento com o schema do seu tipo e versão.
+func (r *Registry) Validate(e Event) error {
+	s, ok := r.Lookup(e.Type, e.Version)
+	if !ok {
+		return fmt.Errorf("evento %s sem schema registrado", VersionedType(e.Type, e.Version))
+	}
+	raw, err := e.DataJSON()
+	if err != nil {
+		return fmt.Errorf("evento %s: payload não serializável: %w", s.Name(), err)
+	}
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return err
+	}
+	if err := validateValue(s.jsonSchema, v, "data"); err != nil {
+		return fmt.Errorf("evento %s: %w", s.Name(), err)
+	}
+	return nil
+}
+
+// Convert rebaixa o evento até version aplicando os Downgrade de cada
+// versão. version 0 ou igual à do evento devolve o evento como está; não há
+// conversão para versões mais novas.
+func (r *Registry) Convert(e Event, version int) (Event, error) {
+	if version == 0 || version == e.Version {
+		return e, nil
+	}
+	if version > e.Version {
+		return e, fmt.Errorf("evento %s não pode ser convertido para v%d", VersionedType(e.Type, e.Version), version)
+	}
+	data := e.Data
+	for v := e.Version; v > version; v-- {
+		s, ok := r.Lookup(e.Type, v)
+		if !ok || s.Downgrade == nil {
+			return e, fmt.Errorf("sem conversão de %s para v%d", VersionedType(e.Type, v), v-1)
+		}
+		var raw []byte
+		var err error
+		if v == e.Version {
+			raw, err = e.DataJSON()
+		} else {
+			raw, err = marshal(data)
+		}
+		if err != nil {
+			return e, err
+		}
+		if data, err = s.Downgrade(raw); err != nil {
+			return e, fmt.Errorf("falha ao converter %s para v%d: %w", VersionedType(e.Type, v), v-1, err)
+		}
+	}
+	// O evento convertido tem payload próprio e, portanto, o seu cache.
+	e.Data, e.Version = data, version
+	if e.enc != nil {
+		e.enc = &encoding{}
+	}
+	return e, nil
+}
+
+// Pins fixa, por tipo de evento, a versão que um assinante recebe.
+type Pins map[string]int
+
+// ParsePins interpreta uma lista de tipos versionados ("agent.updated.v1")
+// conferindo que cada versão existe no registro.
+func (r *Registry) ParsePins(list []string) (Pins, error) {
+	pins := Pins{}
+	for _, s := range list {
+		t, v, err := ParseVersionedType(s)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := r.Lookup(t, v); !ok {
+			return nil, fmt.Errorf("unknown event version %q", s)
+		}
+		if _, dup := pins[t]; dup {
+			return nil, fmt.Errorf("event type %q pinned twice", t)
+		}
+		pins[t] = v
+	}
+	return pins, nil
+}
+
+// Apply converte o evento para a versão fixada em pins, se houver uma
+// versão fixada mais antiga que a do evento.
+func (r *Registry) Apply(pins Pins, e Event) (Event, error) {
+	if v, ok := pins[e.Type]; ok && v < e.Version {
+		return r.Convert(e, v)
+	}
+	return e, nil
+}
+
+// jsonSchemaOf gera o JSON Schema de um tipo Go seguindo as regras de
+// encoding/json.
+func jsonSchemaOf(t reflect.Type) map[string]interface{} {
+	if t.Kind() == reflect.Pointer {
+		s := jsonSchemaOf(t.Elem())
+		if typ, ok := s["type"].(string); ok {
+			s["type"] = []string{typ, "null"}
+		}
+		return s
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	if t == reflect.TypeOf(json.RawMessage{}) {
+		return map[string]interface{}{}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": []string{"array", "null"}, "items": jsonSchemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": []string{"object", "null"}, "additionalProperties": jsonSchemaOf(t.Elem())}
+	case reflect.Struct:
+		props := map[string]interface{}{}
+		required := []string{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = jsonSchemaOf(f.Type)
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+			}
+		}
+		return map[string]interface{}{
+			"type":                 "object",
+			"properties":           props,
+			"required":             required,
+			"additionalProperties": false,
+		}
+	}
+	// interface{} e demais tipos aceitam qualquer valor.
+	return map[string]interface{}{}
+}
+
+// validateValue valida um valor decodificado de JSON contra o subconjunto
+// de JSON Schema gerado por jsonSchemaOf.
+func validateValue(schema map[string]interface{}, v interface{}, path string) error {
+	if t, ok := schema["type"]; ok {
+		var types []string
+		switch t := t.(type) {
+		case string:
+			types = []string{t}
+		case []string:
+			types = t
+		}
+		matched := false
+		for _, typ := range types {
+			if jsonTypeMatches(typ, v) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s: esperado %s, recebido %s", path, strings.Join(types, " ou "), jsonTypeOf(v))
+		}
+	}
+	if schema["format"] == "date-time" {
+		if s, ok := v.(string); ok {
+			if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+				return fmt.Errorf("%s: data-hora inválida %q", path, s)
+			}
+		}
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		props, _ := schema["properties"].(map[string]interface{})
+		if required, ok := schema["required"].([]string); ok {
+			for _, name := range required {
+				if _, ok := v[name]; !ok {
+					return fmt.Errorf("%s: campo obrigatório %q ausente", path, name)
+				}
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if p, ok := props[k].(map[string]interface{}); ok {
+				if err := validateValue(p, v[k], path+"."+k); err != nil {
+					return err
+				}
+				continue
+			}
+			switch extra := schema["additionalProperties"].(type) {
+			case bool:
+				if !extra {
+					return fmt.Errorf("%s: campo desconhecido %q", path, k)
+				}
+			case map[string]interface{}:
+				if err := validateValue(extra, v[k], path+"."+k); err != nil {
+					return err
+				}
+			}
+		}
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				if err := validateValue(items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func jsonTypeMatches(typ string, v interface{}) bool {
+	switch typ {
+	case "null":
+		return v == nil
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	case "integer":
+		f, ok := v.(float64)
+		return ok && f == math.Trunc(f)
+	case "array":
+		_, ok := v.([]interface{})
+		return ok
+	case "object":
+		_, ok := v.(map[string]interface{})
+		return ok
+	}
+	return false
+}
+
+func jsonTypeOf(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case []interface{}:
+		return "array"
+	}
+	return "object"
+}
+
+// Pinned envolve o handler de um assinante para que ele receba os tipos
+// fixados em pins na versão fixada. Eventos que não podem ser convertidos
+// são descartados para esse assinante, com log, em vez de chegarem numa
+// versão que ele não entende.
+func (r *Registry) Pinned(pins Pins, h Handler) Handler {
+	if len(pins) == 0 {
+		return h
+	}
+	return func(ctx context.Context, e Event) {
+		converted, err := r.Apply(pins, e)
+		if err != nil {
+			logging.FromContext(ctx).WithError(err).WithField("event_type", e.VersionedType()).
+				Error("Evento não entregue: sem conversão para a versão fixada")
+			return
+		}
+		h(ctx, converted)
+	}
+}
@@ -0,0 +1,59 @@
+package events
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Message é um evento já serializado a caminho de um sistema externo.
+type Message struct {
+	// ID identifica o evento e serve de chave de deduplicação: é gerado uma
+	// única vez, ao entrar no outbox, e se repete em todo reenvio.
+	ID         string
+	Topic      string
+	Type       string
+	OccurredAt time.Time
+	// Subject é o tópico Kafka ou subject NATS de destino.
+	Subject string
+	// Payload é o evento em JSON, no mesmo formato entregue aos webhooks.
+	Payload []byte
+}
+
+// EventSink exporta eventos para um sistema externo. Send entrega o lote
+// inteiro ou retorna erro; o lote é reenviado em caso de erro, então o
+// destino pode receber duplicatas e deve deduplicar por Message.ID.
+type EventSink interface {
+	Name() string
+	Send(ctx context.Context, batch []Message) error
+	Close() error
+}
+
+// Route sobrepõe o destino dos eventos de um tipo. EventType aceita o tipo
+// exato ("agent.created") ou um prefixo terminado em ".*" ("simulation.*").
+type Route struct {
+	EventType string
+	Subject   string
+}
+
+// Namer resolve o destino de cada evento: a primeira rota que casar com o
+// tipo ou, sem rota, o template com {topic} e {type} substituídos.
+type Namer struct {
+	template string
+	routes   []Route
+}
+
+// NewNamer cria o Namer. As rotas são avaliadas na ordem dada.
+func NewNamer(template string, routes []Route) *Namer {
+	return &Namer{template: template, routes: routes}
+}
+
+// Subject retorna o destino de um evento.
+func (n *Namer) Subject(topic, eventType string) string {
+	for _, r := range n.routes {
+		if r.EventType == eventType || (strings.HasSuffix(r.EventType, ".*") && strings.HasPrefix(eventType, strings.TrimSuffix(r.EventType, "*"))) {
+			return r.Subject
+		}
+	}
+	return strings.NewReplacer("{topic}", topic, "{type}", eventType).Replace(n.template)
+}
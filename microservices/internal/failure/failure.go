@@ -0,0 +1,175 @@
+// Package failure guarda o relatório de falha de uma simulação: a cadeia
+// de erros, o tick, o agente, o comportamento e a etapa do tick
+// envolvidos, os últimos eventos da simulação, o estado do processo no
+// momento e a pilha, cortada. O relatório fica em simulation_failures,
+// ligado à simulação, e sai em GET /simulations/:id/failure; o resumo vai
+// no evento simulation.failed e, por ele, nas notificações.
+//
+// Os pânicos no tick de uma simulação (agentmsg.Clock) viram relatórios
+// em PanicHandler: a simulação passa a failed e o processo segue. As
+// simulações que falham por outro caminho, que só publica simulation.failed
+// com reason, ganham o relatório ao chegar o evento.
+//
+// Os eventos recentes são os vistos pelo barramento desta réplica, que é a
+// que roda os ticks quando a falha vem deles. Relatórios acima de
+// failures.max_report_bytes vão para o armazenamento de objetos, e a
+// resposta traz um link assinado.
+package failure
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ErrNotFound indica uma simulação sem relatório de falha.
+var ErrNotFound = errors.New("failure report not found")
+
+// Scope é o que estava envolvido na falha; os campos vazios não se
+// aplicam.
+type Scope struct {
+	AgentID  string `json:"agent_id,omitempty"`
+	Behavior string `json:"behavior,omitempty"`
+	// Hook é a etapa do tick, como "messages" ou "behavior.(*Runner).Tick".
+	Hook string `json:"hook,omitempty"`
+}
+
+// ScopedError anota um erro com o Scope; o relatório o acha em qualquer
+// ponto da cadeia, inclusive como valor de um pânico.
+type ScopedError struct {
+	Scope
+	Err error
+}
+
+func (e *ScopedError) Error() string { return e.Err.Error() }
+
+func (e *ScopedError) Unwrap() error { return e.Err }
+
+// WithScope anota err com o escopo da falha.
+func WithScope(err error, s Scope) error {
+	if err == nil {
+		return nil
+	}
+	return &ScopedError{Scope: s, Err: err}
+}
+
+// Config configura os relatórios.
+type Config struct {
+	// RecentEvents são os últimos eventos guardados por simulação.
+	RecentEvents int
+	// MaxEventBytes limita os dados de cada evento recente; acima, o evento
+	// entra sem os dados.
+	MaxEventBytes int
+	// MaxStackBytes corta a pilha.
+	MaxStackBytes int
+	// MaxReportBytes é o tamanho máximo do relatório no PostgreSQL; acima,
+	// ele vai para o armazenamento de objetos.
+	MaxReportBytes int
+	// LinkExpiry é a validade do link de um relatório no armazenamento.
+	LinkExpiry time.Duration
+}
+
+// Report é o relatório de falha de uma simulação.
+type Report struct {
+	SimulationID string `json:"simulation_id"`
+	ProjectID    string `json:"project_id,omitempty"`
+	Error        string `json:"error"`
+	// Chain são as mensagens da cadeia de erros, da mais externa à causa.
+	Chain []string `json:"chain"`
+	// Tick é o tick da falha, quando conhecido.
+	Tick *int64 `json:"tick,omitempty"`
+	Scope
+	// Panic indica um pânico recuperado no tick.
+	Panic bool `json:"panic"`
+	// Reason é o motivo publicado em simulation.failed, na falha vinda de
+	// outro caminho.
+	Reason       string        `json:"reason,omitempty"`
+	RecentEvents []RecentEvent `json:"recent_events"`
+	Runtime      Runtime       `json:"runtime"`
+	Stack        string        `json:"stack,omitempty"`
+	// StackTruncated indica que a pilha passou de failures.max_stack_bytes.
+	StackTruncated bool      `json:"stack_truncated,omitempty"`
+	FailedAt       time.Time `json:"failed_at"`
+}
+
+// RecentEvent é um evento da simulação anterior à falha.
+type RecentEvent struct {
+	Type       string          `json:"type"`
+	Version    int             `json:"version,omitempty"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Data       json.RawMessage `json:"data,omitempty"`
+	// Truncated indica que os dados passaram de failures.max_event_bytes e
+	// ficaram de fora.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// Runtime é o estado do processo no momento da falha.
+type Runtime struct {
+	Instance       string  `json:"instance"`
+	Version        string  `json:"version"`
+	GoVersion      string  `json:"go_version"`
+	Goroutines     int     `json:"goroutines"`
+	HeapAllocBytes uint64  `json:"heap_alloc_bytes"`
+	HeapObjects    uint64  `json:"heap_objects"`
+	SysBytes       uint64  `json:"sys_bytes"`
+	NumGC          uint32  `json:"num_gc"`
+	UptimeSeconds  float64 `json:"uptime_seconds"`
+}
+
+// Summary é o resumo do relatório, gravado ao lado dele.
+type Summary struct {
+	SimulationID string    `json:"simulation_id"`
+	Error        string    `json:"error"`
+	Tick         *int64    `json:"tick,omitempty"`
+	AgentID      string    `json:"agent_id,omitempty"`
+	Behavior     string    `json:"behavior,omitempty"`
+	Hook         string    `json:"hook,omitempty"`
+	Panic        bool      `json:"panic"`
+	FailedAt     time.Time `json:"failed_at"`
+	// Size é o tamanho do relatório em JSON; Stored diz onde ele ficou
+	// (database ou object_storage).
+	Size   int    `json:"size"`
+	Stored string `json:"stored"`
+}
+
+// Onde o relatório ficou.
+const (
+	StoredDatabase = "database"
+	StoredObject   = "object_storage"
+)
+
+func (r *Report) summary(size int, stored string) Summary {
+	return Summary{
+		SimulationID: r.SimulationID,
+		Error:        r.Error,
+		Tick:         r.Tick,
+		AgentID:      r.AgentID,
+		Behavior:     r.Behavior,
+		Hook:         r.Hook,
+		Panic:        r.Panic,
+		FailedAt:     r.FailedAt,
+		Size:         size,
+		Stored:       stored,
+	}
+}
+
+// chain lista as mensagens da cadeia de err; erros unidos entram em
+// profundidade, na ordem.
+func chain(err error) []string {
+	var out []string
+	var walk func(error)
+	walk = func(err error) {
+		for err != nil {
+			out = append(out, err.Error())
+			if multi, ok := err.(interface{ Unwrap() []error }); ok {
+				for _, e := range multi.Unwrap() {
+					walk(e)
+				}
+				return
+			}
+			err = errors.Unwrap(err)
+		}
+	}
+	walk(err)
+	return out
+}
@@ -0,0 +1,88 @@
+package failure
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/logging"
+	"smart-city-microservices/internal/storage"
+)
+
+// SimulationGetter busca a simulação do relatório; agent.Service a
+// implementa.
+type SimulationGetter interface {
+	GetSimulation(ctx context.Context, id string) (*agent.Simulation, error)
+}
+
+// Handler serve os relatórios de falha.
+type Handler struct {
+	repo   *Repository
+	agents SimulationGetter
+	store  storage.Store
+	cfg    Config
+}
+
+// NewHandler cria o handler dos relatórios de falha.
+func NewHandler(repo *Repository, agents SimulationGetter, store storage.Store, cfg Config) *Handler {
+	return &Handler{repo: repo, agents: agents, store: store, cfg: cfg}
+}
+
+// Response é o corpo de GET /simulations/:id/failure: o resumo e o
+// relatório, ou, para o que ficou no armazenamento de objetos, um link
+// assinado para ele.
+type Response struct {
+	Summary    Summary         `json:"summary"`
+	Report     json.RawMessage `json:"report,omitempty"`
+	ReportLink *storage.Link   `json:"report_link,omitempty"`
+	RecordedAt time.Time       `json:"recorded_at"`
+}
+
+// Get responde GET /simulations/:id/failure.
+func (h *Handler) Get(c *gin.Context) {
+	ctx := c.Request.Context()
+	sim, err := h.agents.GetSimulation(ctx, c.Param("id"))
+	if errors.Is(err, agent.ErrNotFound) || (err == nil && !auth.FromGin(c).InProject(sim.ProjectID)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "simulation not found"})
+		return
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	rec, err := h.repo.Get(ctx, sim.ID)
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": ErrNotFound.Error()})
+		return
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	resp := Response{Summary: rec.Summary, Report: rec.Report, RecordedAt: rec.CreatedAt}
+	if rec.ObjectKey != "" {
+		u, err := h.store.Presign(ctx, rec.ObjectKey, h.cfg.LinkExpiry)
+		if err != nil {
+			h.internalError(c, err)
+			return
+		}
+		resp.ReportLink = &storage.Link{
+			DownloadURL: u,
+			ExpiresAt:   time.Now().Add(h.cfg.LinkExpiry).UTC(),
+			Size:        int64(rec.Summary.Size),
+			ContentType: "application/json",
+		}
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (h *Handler) internalError(c *gin.Context, err error) {
+	logging.FromContext(c.Request.Context()).WithError(err).Error("Erro no handler de relatórios de falha")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
@@ -0,0 +1,308 @@
+package failure
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/agentmsg"
+	"smart-city-microservices/internal/buildinfo"
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/logging"
+	"smart-city-microservices/internal/storage"
+	"smart-city-microservices/internal/supervisor"
+)
+
+var reports = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "agent_service",
+	Name:      "simulation_failure_reports_total",
+	Help:      "Relatórios de falha de simulações gravados, por origem (panic, event) e destino (database, object_storage).",
+}, []string{"source", "stored"})
+
+// bufferIdle é quanto os eventos recentes de uma simulação sem eventos
+// novos ficam na memória; as que terminam saem no evento de fim.
+const bufferIdle = 30 * time.Minute
+
+// started marca o início do processo, para Runtime.UptimeSeconds.
+var started = time.Now()
+
+// Recorder monta e grava os relatórios de falha. Handle guarda os eventos
+// recentes de cada simulação; Panic recebe os pânicos do agentmsg.Clock.
+type Recorder struct {
+	repo      *Repository
+	store     storage.Store
+	publisher events.Publisher
+	instance  string
+	cfg       Config
+
+	mu     sync.Mutex
+	recent map[string]*buffer
+	swept  time.Time
+}
+
+// buffer são os últimos eventos de uma simulação, em anel.
+type buffer struct {
+	events []RecentEvent
+	next   int
+	seen   time.Time
+}
+
+// NewRecorder cria o gravador. store recebe os relatórios grandes;
+// instance identifica a réplica em Runtime.
+func NewRecorder(repo *Repository, store storage.Store, publisher events.Publisher, instance string, cfg Config) *Recorder {
+	return &Recorder{repo: repo, store: store, publisher: publisher, instance: instance, cfg: cfg, recent: map[string]*buffer{}}
+}
+
+// Handle guarda os eventos de cada simulação e descarta os dela quando ela
+// termina. Num simulation.failed sem failure, a falha veio de outro caminho
+// e o relatório sai do motivo publicado; a gravação roda no Publish, como a
+// mudança de estado é rara.
+func (r *Recorder) Handle(ctx context.Context, e events.Event) {
+	id := e.SimulationID()
+	if id == "" {
+		return
+	}
+	switch e.Type {
+	case "simulation.stopped", "simulation.completed", "simulation.auto_stopped":
+		r.forget(id)
+		return
+	case "simulation.failed":
+		var sim events.SimulationV1
+		if err := e.Decode(&sim); err != nil || sim.Failure != nil {
+			r.forget(id)
+			return
+		}
+		reason := sim.Reason
+		if reason == "" {
+			reason = "simulation failed"
+		}
+		rep := r.report(id, sim.ProjectID, errors.New(reason))
+		rep.Reason = sim.Reason
+		if _, _, err := r.save(ctx, rep, false, "event"); err != nil {
+			logging.FromContext(ctx).WithError(err).WithField("simulation_id", id).Error("Falha ao gravar o relatório de falha da simulação")
+		}
+		r.forget(id)
+		return
+	}
+	r.remember(id, e)
+}
+
+// Panic converte o pânico de um tick no relatório de falha: a simulação
+// passa a failed e simulation.failed sai com o resumo. O pânico pode trazer
+// o escopo num *ScopedError; sem ele, o agente e o comportamento ficam
+// vazios e a etapa é a do tick.
+func (r *Recorder) Panic(ctx context.Context, p agentmsg.TickPanic) {
+	err, ok := p.Value.(error)
+	if !ok {
+		err = fmt.Errorf("panic: %v", p.Value)
+	}
+	rep := r.report(p.SimulationID, p.ProjectID, err)
+	rep.Panic = true
+	if p.Tick >= 0 {
+		tick := p.Tick
+		rep.Tick = &tick
+	}
+	if rep.Hook == "" {
+		rep.Hook = p.Hook
+	}
+	rep.Stack, rep.StackTruncated = truncate(p.Stack, r.cfg.MaxStackBytes)
+	if err := r.fail(ctx, rep); err != nil {
+		logging.FromContext(ctx).WithError(err).WithField("simulation_id", p.SimulationID).Error("Falha ao gravar o relatório de falha da simulação")
+	}
+}
+
+// fail grava o relatório de uma simulação em execução, passa ela a failed
+// e publica simulation.failed com o resumo. A simulação que já não está em
+// execução fica como está, sem relatório.
+func (r *Recorder) fail(ctx context.Context, rep *Report) error {
+	sim, summary, err := r.save(ctx, rep, true, "panic")
+	if err != nil || sim == nil {
+		return err
+	}
+	logging.FromContext(ctx).WithFields(logrus.Fields{
+		"simulation_id": rep.SimulationID,
+		"hook":          rep.Hook,
+		"panic":         rep.Panic,
+	}).Warn("Simulação encerrada com relatório de falha")
+	r.publisher.Publish(ctx, events.New(events.TopicSimulations, "simulation.failed", events.SimulationV1{
+		ID:        sim.ID,
+		ProjectID: sim.ProjectID,
+		Name:      sim.Name,
+		Status:    sim.Status,
+		StartedAt: sim.StartedAt,
+		EndedAt:   sim.EndedAt,
+		Reason:    rep.Error,
+		Failure: &events.SimulationFailureV1{
+			Error:     summary.Error,
+			Tick:      summary.Tick,
+			AgentID:   summary.AgentID,
+			Behavior:  summary.Behavior,
+			Hook:      summary.Hook,
+			Panic:     summary.Panic,
+			ReportURL: ReportURL(rep.SimulationID),
+		},
+	}))
+	return nil
+}
+
+// ReportURL é o caminho do relatório de falha da simulação na API.
+func ReportURL(simulationID string) string {
+	return "/api/v1/simulations/" + simulationID + "/failure"
+}
+
+// report monta o relatório com a cadeia de err, o escopo achado nela, os
+// eventos recentes e o estado do processo.
+func (r *Recorder) report(simulationID, projectID string, err error) *Report {
+	rep := &Report{
+		SimulationID: simulationID,
+		ProjectID:    projectID,
+		Error:        err.Error(),
+		Chain:        chain(err),
+		RecentEvents: r.snapshot(simulationID),
+		Runtime:      r.runtime(),
+		FailedAt:     time.Now().UTC(),
+	}
+	var scoped *ScopedError
+	if errors.As(err, &scoped) {
+		rep.Scope = scoped.Scope
+	}
+	return rep
+}
+
+func (r *Recorder) runtime() Runtime {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return Runtime{
+		Instance:       r.instance,
+		Version:        buildinfo.Version,
+		GoVersion:      runtime.Version(),
+		Goroutines:     runtime.NumGoroutine(),
+		HeapAllocBytes: m.HeapAlloc,
+		HeapObjects:    m.HeapObjects,
+		SysBytes:       m.Sys,
+		NumGC:          m.NumGC,
+		UptimeSeconds:  time.Since(started).Seconds(),
+	}
+}
+
+// save grava o relatório: no PostgreSQL até failures.max_report_bytes e no
+// armazenamento de objetos acima. Se o armazenamento falha, o relatório vai
+// inteiro para o PostgreSQL. Com mark, a simulação passa a failed e sim
+// volta nil se ela já não estava em execução; sem mark, sim volta nil.
+func (r *Recorder) save(ctx context.Context, rep *Report, mark bool, source string) (sim *Simulation, summary Summary, err error) {
+	body, err := json.Marshal(rep)
+	if err != nil {
+		return nil, Summary{}, err
+	}
+	stored, key := StoredDatabase, ""
+	if len(body) > r.cfg.MaxReportBytes && r.store != nil {
+		key = fmt.Sprintf("failures/%s/%d.json", rep.SimulationID, rep.FailedAt.UnixNano())
+		if err := r.store.Put(ctx, key, bytes.NewReader(body), int64(len(body)), "application/json"); err != nil {
+			logging.FromContext(ctx).WithError(err).WithField("simulation_id", rep.SimulationID).Warn("Falha ao gravar o relatório de falha no armazenamento; ele fica no banco")
+			key = ""
+		} else {
+			stored = StoredObject
+		}
+	}
+	summary = rep.summary(len(body), stored)
+	rec := Record{SimulationID: rep.SimulationID, ProjectID: rep.ProjectID, Summary: summary, ObjectKey: key}
+	if key == "" {
+		rec.Report = body
+	}
+	sim, previous, saved, err := r.repo.Save(ctx, rec, mark)
+	if err != nil || !saved {
+		r.discard(ctx, key)
+		return nil, summary, err
+	}
+	if previous != "" && previous != key {
+		r.discard(ctx, previous)
+	}
+	reports.WithLabelValues(source, stored).Inc()
+	return sim, summary, nil
+}
+
+// discard remove um relatório que ficou sem registro no armazenamento.
+func (r *Recorder) discard(ctx context.Context, key string) {
+	if key == "" {
+		return
+	}
+	ctx, cancel := supervisor.Cleanup(ctx)
+	defer cancel()
+	if err := r.store.Delete(ctx, key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		logging.FromContext(ctx).WithError(err).WithField("key", key).Warn("Falha ao remover relatório de falha do armazenamento")
+	}
+}
+
+func (r *Recorder) remember(simulationID string, e events.Event) {
+	if r.cfg.RecentEvents <= 0 {
+		return
+	}
+	ev := RecentEvent{Type: e.Type, Version: e.Version, OccurredAt: e.OccurredAt}
+	if data, err := e.DataJSON(); err == nil {
+		if len(data) > r.cfg.MaxEventBytes {
+			ev.Truncated = true
+		} else {
+			ev.Data = data
+		}
+	}
+
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if now.Sub(r.swept) > bufferIdle/2 {
+		for id, b := range r.recent {
+			if now.Sub(b.seen) > bufferIdle {
+				delete(r.recent, id)
+			}
+		}
+		r.swept = now
+	}
+	b := r.recent[simulationID]
+	if b == nil {
+		b = &buffer{}
+		r.recent[simulationID] = b
+	}
+	b.seen = now
+	if len(b.events) < r.cfg.RecentEvents {
+		b.events = append(b.events, ev)
+		return
+	}
+	b.events[b.next] = ev
+	b.next = (b.next + 1) % len(b.events)
+}
+
+// snapshot copia os eventos recentes da simulação, do mais antigo ao mais
+// novo.
+func (r *Recorder) snapshot(simulationID string) []RecentEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := []RecentEvent{}
+	if b := r.recent[simulationID]; b != nil {
+		out = append(out, b.events[b.next:]...)
+		out = append(out, b.events[:b.next]...)
+	}
+	return out
+}
+
+func (r *Recorder) forget(simulationID string) {
+	r.mu.Lock()
+	delete(r.recent, simulationID)
+	r.mu.Unlock()
+}
+
+// truncate corta a pilha em max bytes.
+func truncate(stack []byte, max int) (string, bool) {
+	if len(stack) <= max {
+		return string(stack), false
+	}
+	return string(stack[:max]), true
+}
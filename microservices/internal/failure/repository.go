@@ -0,0 +1,131 @@
+package failure
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"smart-city-microservices/internal/instrument"
+)
+
+// Repository grava os relatórios de falha no PostgreSQL.
+type Repository struct {
+	db *instrument.DB
+}
+
+// NewRepository cria o repositório.
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: instrument.NewDB(db)}
+}
+
+// Record é o relatório gravado: Report no banco ou, com ObjectKey, no
+// armazenamento de objetos.
+type Record struct {
+	SimulationID string
+	ProjectID    string
+	Summary      Summary
+	Report       json.RawMessage
+	ObjectKey    string
+	CreatedAt    time.Time
+}
+
+// Simulation é a simulação passada a failed por Save.
+type Simulation struct {
+	ID        string
+	ProjectID string
+	Name      string
+	Status    string
+	StartedAt *time.Time
+	EndedAt   *time.Time
+}
+
+// Save grava o relatório da simulação, no lugar do anterior. Com mark, a
+// simulação em execução passa a failed na mesma transação e volta em sim;
+// a que já não está em execução não ganha o relatório, e saved volta false.
+// previous é a chave do relatório anterior no armazenamento, se houver.
+func (r *Repository) Save(ctx context.Context, rec Record, mark bool) (sim *Simulation, previous string, saved bool, err error) {
+	span := instrument.StartQuery(ctx, "failure.save")
+	defer func() { span.End(-1, err) }()
+
+	summary, err := json.Marshal(rec.Summary)
+	if err != nil {
+		return nil, "", false, err
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, "", false, err
+	}
+	defer tx.Rollback()
+
+	if mark {
+		var s Simulation
+		var projectID sql.NullString
+		err = tx.QueryRowContext(ctx, `
+			UPDATE simulations SET status = 'failed', ended_at = COALESCE(ended_at, CURRENT_TIMESTAMP)
+			WHERE id = $1 AND status = 'running'
+			RETURNING id, project_id, name, status, started_at, ended_at`, rec.SimulationID).
+			Scan(&s.ID, &projectID, &s.Name, &s.Status, &s.StartedAt, &s.EndedAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, "", false, nil
+		}
+		if err != nil {
+			return nil, "", false, err
+		}
+		s.ProjectID = projectID.String
+		sim = &s
+	}
+
+	var key sql.NullString
+	err = tx.QueryRowContext(ctx, `SELECT object_key FROM simulation_failures WHERE simulation_id = $1 FOR UPDATE`, rec.SimulationID).Scan(&key)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, "", false, err
+	}
+	var report, objectKey interface{}
+	if rec.ObjectKey != "" {
+		objectKey = rec.ObjectKey
+	} else {
+		report = string(rec.Report)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO simulation_failures (simulation_id, project_id, summary, report, object_key)
+		VALUES ($1, NULLIF($2, ''), $3, $4, $5)
+		ON CONFLICT (simulation_id) DO UPDATE SET
+			project_id = EXCLUDED.project_id, summary = EXCLUDED.summary, report = EXCLUDED.report,
+			object_key = EXCLUDED.object_key, created_at = CURRENT_TIMESTAMP`,
+		rec.SimulationID, rec.ProjectID, string(summary), report, objectKey); err != nil {
+		return nil, "", false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, "", false, err
+	}
+	return sim, key.String, true, nil
+}
+
+// Get retorna o relatório da simulação; ErrNotFound se ela não tem um.
+func (r *Repository) Get(ctx context.Context, simulationID string) (*Record, error) {
+	var rec Record
+	var summary []byte
+	var report, key sql.NullString
+	var projectID sql.NullString
+	err := r.db.QueryRow(ctx, "failure.get", `
+		SELECT simulation_id, project_id, summary, report, object_key, created_at
+		FROM simulation_failures WHERE simulation_id = $1`, simulationID).
+		Scan(&rec.SimulationID, &projectID, &summary, &report, &key, &rec.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(summary, &rec.Summary); err != nil {
+		return nil, err
+	}
+	rec.ProjectID = projectID.String
+	if report.Valid {
+		rec.Report = json.RawMessage(report.String)
+	}
+	rec.ObjectKey = key.String
+	return &rec, nil
+}
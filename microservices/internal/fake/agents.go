@@ -0,0 +1,420 @@
+// Package fake tem substitutos em memória das dependências externas do
+// serviço, para testes sem Postgres nem Redis: o repositório de agentes e
+// simulações (Agents) e um Redis de verdade rodando no processo (Redis).
+package fake
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"smart-city-microservices/internal/agent"
+)
+
+// Valores padrão do repositório, os mesmos das colunas em database/init.sql.
+const (
+	defaultPageSize         = 20
+	defaultAgentStatus      = "active"
+	defaultSimulationStatus = "created"
+)
+
+// ErrUnavailable é um erro de conexão, para Agents.Err.
+var ErrUnavailable = errors.New("fake: connection refused")
+
+// Agents é o repositório de agentes e simulações em memória. Implementa o
+// subconjunto de agent.Service usado pelos handlers (ListAgents, GetAgent,
+// GetAgents, CreateAgent, UpdateAgent, DeleteAgent e as operações de
+// simulação), com a mesma semântica:
+//
+//   - ListAgents filtra por igualdade em SimulationID, ProjectID, Type e
+//     Status e exige todas as Tags; ordena por criação e id, e Page e
+//     PageSize menores que 1 valem 1 e 20;
+//   - UpdateAgent mescla State e Metadata e substitui os demais campos
+//     informados;
+//   - agentes exigem uma simulação existente, e apagar a simulação não é
+//     suportado;
+//   - os erros envolvem agent.ErrNotFound e agent.ErrValidation.
+//
+// Os valores retornados são cópias: alterá-los não altera o repositório.
+type Agents struct {
+	mu          sync.RWMutex
+	agents      map[string]agent.Agent
+	simulations map[string]agent.Simulation
+	now         func() time.Time
+
+	// Err, quando não nil, é retornado por todas as operações, para
+	// simular o banco fora do ar.
+	Err error
+}
+
+// NewAgents cria o repositório vazio.
+func NewAgents() *Agents {
+	return &Agents{
+		agents:      map[string]agent.Agent{},
+		simulations: map[string]agent.Simulation{},
+		now:         func() time.Time { return time.Now().UTC() },
+	}
+}
+
+// SetClock troca o relógio usado em CreatedAt, UpdatedAt e nas datas das
+// simulações.
+func (r *Agents) SetClock(now func() time.Time) {
+	r.mu.Lock()
+	r.now = now
+	r.mu.Unlock()
+}
+
+// PutSimulation grava a simulação como está, sem validação; sem ID, um é
+// gerado. É o jeito de montar o estado inicial de um teste.
+func (r *Agents) PutSimulation(s agent.Simulation) *agent.Simulation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if s.ID == "" {
+		s.ID = uuid.NewString()
+	}
+	if s.Status == "" {
+		s.Status = defaultSimulationStatus
+	}
+	if s.CreatedAt.IsZero() {
+		s.CreatedAt = r.now()
+	}
+	r.simulations[s.ID] = s
+	return copySimulation(s)
+}
+
+// Put grava o agente como está, sem validação; sem ID, um é gerado.
+func (r *Agents) Put(a agent.Agent) *agent.Agent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if a.ID == "" {
+		a.ID = uuid.NewString()
+	}
+	if a.Status == "" {
+		a.Status = defaultAgentStatus
+	}
+	if a.CreatedAt.IsZero() {
+		a.CreatedAt = r.now()
+	}
+	if a.UpdatedAt.IsZero() {
+		a.UpdatedAt = a.CreatedAt
+	}
+	r.agents[a.ID] = a
+	return copyAgent(a)
+}
+
+// Len retorna o número de agentes gravados.
+func (r *Agents) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.agents)
+}
+
+// GetAgent retorna o agente.
+func (r *Agents) GetAgent(_ context.Context, id string) (*agent.Agent, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	a, ok := r.agents[id]
+	if !ok {
+		return nil, fmt.Errorf("agent %s: %w", id, agent.ErrNotFound)
+	}
+	return copyAgent(a), nil
+}
+
+// GetAgents retorna os agentes que existem entre ids, sem ordem garantida,
+// como a busca em lote do repositório.
+func (r *Agents) GetAgents(_ context.Context, ids []string) ([]agent.Agent, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	out := make([]agent.Agent, 0, len(ids))
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if a, ok := r.agents[id]; ok && !seen[id] {
+			seen[id] = true
+			out = append(out, *copyAgent(a))
+		}
+	}
+	return out, nil
+}
+
+// ListAgents retorna a página pedida dos agentes que passam no filtro e o
+// total deles.
+func (r *Agents) ListAgents(_ context.Context, f agent.Filter) ([]agent.Agent, int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.Err != nil {
+		return nil, 0, r.Err
+	}
+	var matched []agent.Agent
+	for _, a := range r.agents {
+		if matches(a, f) {
+			matched = append(matched, a)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		if !matched[i].CreatedAt.Equal(matched[j].CreatedAt) {
+			return matched[i].CreatedAt.Before(matched[j].CreatedAt)
+		}
+		return matched[i].ID < matched[j].ID
+	})
+	page, size := max(f.Page, 1), f.PageSize
+	if size < 1 {
+		size = defaultPageSize
+	}
+	start := min((page-1)*size, len(matched))
+	end := min(start+size, len(matched))
+	out := make([]agent.Agent, 0, end-start)
+	for _, a := range matched[start:end] {
+		out = append(out, *copyAgent(a))
+	}
+	return out, len(matched), nil
+}
+
+func matches(a agent.Agent, f agent.Filter) bool {
+	if f.SimulationID != "" && a.SimulationID != f.SimulationID ||
+		f.ProjectID != "" && a.ProjectID != f.ProjectID ||
+		f.Type != "" && a.Type != f.Type ||
+		f.Status != "" && a.Status != f.Status {
+		return false
+	}
+	for _, t := range f.Tags {
+		if !slices.Contains(a.Tags, t) {
+			return false
+		}
+	}
+	return true
+}
+
+// CreateAgent cria o agente na simulação do pedido.
+func (r *Agents) CreateAgent(_ context.Context, req agent.CreateAgentRequest) (*agent.Agent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	switch {
+	case req.Name == "":
+		return nil, fmt.Errorf("%w: name is required", agent.ErrValidation)
+	case req.Type == "":
+		return nil, fmt.Errorf("%w: type is required", agent.ErrValidation)
+	}
+	sim, ok := r.simulations[req.SimulationID]
+	if !ok {
+		return nil, fmt.Errorf("%w: simulation %q does not exist", agent.ErrValidation, req.SimulationID)
+	}
+	projectID := req.ProjectID
+	if projectID == "" {
+		projectID = sim.ProjectID
+	}
+	now := r.now()
+	a := agent.Agent{
+		ID:           uuid.NewString(),
+		SimulationID: req.SimulationID,
+		ProjectID:    projectID,
+		Type:         req.Type,
+		Name:         req.Name,
+		Status:       defaultAgentStatus,
+		Position:     req.Position,
+		Energy:       100,
+		State:        mergeMap(nil, req.State),
+		Metadata:     mergeMap(nil, req.Metadata),
+		Tags:         slices.Clone(req.Tags),
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	r.agents[a.ID] = a
+	return copyAgent(a), nil
+}
+
+// UpdateAgent aplica os campos informados em req.
+func (r *Agents) UpdateAgent(_ context.Context, id string, req agent.UpdateAgentRequest) (*agent.Agent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	a, ok := r.agents[id]
+	if !ok {
+		return nil, fmt.Errorf("agent %s: %w", id, agent.ErrNotFound)
+	}
+	if req.Name != nil {
+		if *req.Name == "" {
+			return nil, fmt.Errorf("%w: name must not be empty", agent.ErrValidation)
+		}
+		a.Name = *req.Name
+	}
+	if req.Status != nil {
+		a.Status = *req.Status
+	}
+	if req.Position != nil {
+		a.Position = *req.Position
+	}
+	if req.State != nil {
+		a.State = mergeMap(a.State, req.State)
+	}
+	if req.Metadata != nil {
+		a.Metadata = mergeMap(a.Metadata, req.Metadata)
+	}
+	if req.Tags != nil {
+		a.Tags = slices.Clone(req.Tags)
+	}
+	a.UpdatedAt = r.now()
+	r.agents[id] = a
+	return copyAgent(a), nil
+}
+
+// DeleteAgent apaga o agente.
+func (r *Agents) DeleteAgent(_ context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.Err != nil {
+		return r.Err
+	}
+	if _, ok := r.agents[id]; !ok {
+		return fmt.Errorf("agent %s: %w", id, agent.ErrNotFound)
+	}
+	delete(r.agents, id)
+	return nil
+}
+
+// GetSimulation retorna a simulação.
+func (r *Agents) GetSimulation(_ context.Context, id string) (*agent.Simulation, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	s, ok := r.simulations[id]
+	if !ok {
+		return nil, fmt.Errorf("simulation %s: %w", id, agent.ErrNotFound)
+	}
+	return copySimulation(s), nil
+}
+
+// ListSimulations retorna a página pedida das simulações, da mais nova à
+// mais antiga, e o total delas.
+func (r *Agents) ListSimulations(_ context.Context, page, pageSize int) ([]agent.Simulation, int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.Err != nil {
+		return nil, 0, r.Err
+	}
+	all := make([]agent.Simulation, 0, len(r.simulations))
+	for _, s := range r.simulations {
+		all = append(all, s)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if !all[i].CreatedAt.Equal(all[j].CreatedAt) {
+			return all[i].CreatedAt.After(all[j].CreatedAt)
+		}
+		return all[i].ID < all[j].ID
+	})
+	page = max(page, 1)
+	if pageSize < 1 {
+		pageSize = defaultPageSize
+	}
+	start := min((page-1)*pageSize, len(all))
+	end := min(start+pageSize, len(all))
+	out := make([]agent.Simulation, 0, end-start)
+	for _, s := range all[start:end] {
+		out = append(out, *copySimulation(s))
+	}
+	return out, len(all), nil
+}
+
+// CreateSimulation cria a simulação no estado created.
+func (r *Agents) CreateSimulation(_ context.Context, req agent.CreateSimulationRequest) (*agent.Simulation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	if req.Name == "" {
+		return nil, fmt.Errorf("%w: name is required", agent.ErrValidation)
+	}
+	s := agent.Simulation{
+		ID:          uuid.NewString(),
+		ProjectID:   req.ProjectID,
+		Name:        req.Name,
+		Description: req.Description,
+		Status:      defaultSimulationStatus,
+		Config:      mergeMap(map[string]interface{}{}, req.Config),
+		CreatedAt:   r.now(),
+	}
+	r.simulations[s.ID] = s
+	return copySimulation(s), nil
+}
+
+// StartSimulation põe a simulação em running.
+func (r *Agents) StartSimulation(_ context.Context, id string) (*agent.Simulation, error) {
+	return r.transition(id, "running")
+}
+
+// StopSimulation põe a simulação em stopped.
+func (r *Agents) StopSimulation(_ context.Context, id string) (*agent.Simulation, error) {
+	return r.transition(id, "stopped")
+}
+
+func (r *Agents) transition(id, status string) (*agent.Simulation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	s, ok := r.simulations[id]
+	if !ok {
+		return nil, fmt.Errorf("simulation %s: %w", id, agent.ErrNotFound)
+	}
+	now := r.now()
+	switch {
+	case status == "running" && s.Status == "running":
+		return nil, fmt.Errorf("%w: simulation %s is already running", agent.ErrValidation, id)
+	case status == "stopped" && s.Status != "running":
+		return nil, fmt.Errorf("%w: simulation %s is not running", agent.ErrValidation, id)
+	case status == "running":
+		s.StartedAt, s.EndedAt = &now, nil
+	default:
+		s.EndedAt = &now
+	}
+	s.Status = status
+	r.simulations[id] = s
+	return copySimulation(s), nil
+}
+
+func copyAgent(a agent.Agent) *agent.Agent {
+	a.State = mergeMap(nil, a.State)
+	a.Metadata = mergeMap(nil, a.Metadata)
+	a.Tags = slices.Clone(a.Tags)
+	return &a
+}
+
+func copySimulation(s agent.Simulation) *agent.Simulation {
+	s.Config = mergeMap(nil, s.Config)
+	return &s
+}
+
+// mergeMap retorna dst com as chaves de src; nil quando ambos são nil.
+func mergeMap(dst, src map[string]interface{}) map[string]interface{} {
+	if dst == nil && src == nil {
+		return nil
+	}
+	out := make(map[string]interface{}, len(dst)+len(src))
+	for k, v := range dst {
+		out[k] = v
+	}
+	for k, v := range src {
+		out[k] = v
+	}
+	return out
+}
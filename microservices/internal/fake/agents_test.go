@@ -0,0 +1,175 @@
+package fake
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"smart-city-microservices/internal/agent"
+)
+
+func newAgents(t *testing.T) *Agents {
+	t.Helper()
+	r := NewAgents()
+	at := time.Date(2026, 3, 4, 5, 0, 0, 0, time.UTC)
+	r.SetClock(func() time.Time { at = at.Add(time.Second); return at })
+	r.PutSimulation(agent.Simulation{ID: "sim-a", ProjectID: "proj-1", Name: "Centro"})
+	ctx := context.Background()
+	for _, req := range []agent.CreateAgentRequest{
+		{SimulationID: "sim-a", Type: "bus", Name: "Ônibus 1", Tags: []string{"linha-101"}},
+		{SimulationID: "sim-a", Type: "bus", Name: "Ônibus 2"},
+		{SimulationID: "sim-a", Type: "sensor", Name: "Sensor 1", Tags: []string{"linha-101", "noturno"}},
+	} {
+		if _, err := r.CreateAgent(ctx, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return r
+}
+
+func names(agents []agent.Agent) string {
+	out := make([]string, len(agents))
+	for i, a := range agents {
+		out[i] = a.Name
+	}
+	return strings.Join(out, ",")
+}
+
+func TestListAgents(t *testing.T) {
+	tests := []struct {
+		name  string
+		f     agent.Filter
+		want  string
+		total int
+	}{
+		{"all", agent.Filter{}, "Ônibus 1,Ônibus 2,Sensor 1", 3},
+		{"type", agent.Filter{Type: "bus"}, "Ônibus 1,Ônibus 2", 2},
+		{"project from simulation", agent.Filter{ProjectID: "proj-1"}, "Ônibus 1,Ônibus 2,Sensor 1", 3},
+		{"other simulation", agent.Filter{SimulationID: "sim-b"}, "", 0},
+		{"all tags", agent.Filter{Tags: []string{"linha-101", "noturno"}}, "Sensor 1", 1},
+		{"page", agent.Filter{Page: 2, PageSize: 2}, "Sensor 1", 3},
+		{"page past the end", agent.Filter{Page: 5, PageSize: 2}, "", 3},
+		{"page zero is the first", agent.Filter{PageSize: 1}, "Ônibus 1", 3},
+	}
+	r := newAgents(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, total, err := r.ListAgents(context.Background(), tt.f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if names(got) != tt.want || total != tt.total {
+				t.Errorf("%q (total %d), want %q (total %d)", names(got), total, tt.want, tt.total)
+			}
+		})
+	}
+}
+
+func TestAgentErrors(t *testing.T) {
+	ctx := context.Background()
+	empty := ""
+	tests := []struct {
+		name string
+		op   func(r *Agents) error
+		want error
+	}{
+		{"create without name", func(r *Agents) error {
+			_, err := r.CreateAgent(ctx, agent.CreateAgentRequest{SimulationID: "sim-a", Type: "bus"})
+			return err
+		}, agent.ErrValidation},
+		{"create in unknown simulation", func(r *Agents) error {
+			_, err := r.CreateAgent(ctx, agent.CreateAgentRequest{SimulationID: "sim-x", Type: "bus", Name: "x"})
+			return err
+		}, agent.ErrValidation},
+		{"get unknown", func(r *Agents) error { _, err := r.GetAgent(ctx, "x"); return err }, agent.ErrNotFound},
+		{"update unknown", func(r *Agents) error {
+			_, err := r.UpdateAgent(ctx, "x", agent.UpdateAgentRequest{})
+			return err
+		}, agent.ErrNotFound},
+		{"update with empty name", func(r *Agents) error {
+			a := r.Put(agent.Agent{SimulationID: "sim-a", Type: "bus", Name: "y"})
+			_, err := r.UpdateAgent(ctx, a.ID, agent.UpdateAgentRequest{Name: &empty})
+			return err
+		}, agent.ErrValidation},
+		{"delete unknown", func(r *Agents) error { return r.DeleteAgent(ctx, "x") }, agent.ErrNotFound},
+		{"stop a simulation that is not running", func(r *Agents) error {
+			_, err := r.StopSimulation(ctx, "sim-a")
+			return err
+		}, agent.ErrValidation},
+		{"unavailable", func(r *Agents) error {
+			r.Err = ErrUnavailable
+			_, _, err := r.ListAgents(ctx, agent.Filter{})
+			return err
+		}, ErrUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.op(newAgents(t)); !errors.Is(err, tt.want) {
+				t.Errorf("erro %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateAgentMergesMaps(t *testing.T) {
+	r := newAgents(t)
+	ctx := context.Background()
+	list, _, _ := r.ListAgents(ctx, agent.Filter{Type: "sensor"})
+	id := list[0].ID
+	status := "idle"
+	if _, err := r.UpdateAgent(ctx, id, agent.UpdateAgentRequest{State: map[string]interface{}{"a": 1, "b": 1}}); err != nil {
+		t.Fatal(err)
+	}
+	a, err := r.UpdateAgent(ctx, id, agent.UpdateAgentRequest{Status: &status, State: map[string]interface{}{"b": 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Status != "idle" || a.State["a"] != 1 || a.State["b"] != 2 || len(a.Tags) != 2 || !a.UpdatedAt.After(a.CreatedAt) {
+		t.Errorf("agente depois das alterações: %+v", a)
+	}
+	// O retorno é uma cópia.
+	a.State["a"] = 99
+	again, _ := r.GetAgent(ctx, id)
+	if again.State["a"] != 1 {
+		t.Error("alteração no valor retornado chegou ao repositório")
+	}
+}
+
+func TestSimulationLifecycle(t *testing.T) {
+	r := NewAgents()
+	ctx := context.Background()
+	sim, err := r.CreateSimulation(ctx, agent.CreateSimulationRequest{Name: "Centro"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sim.Status != "created" {
+		t.Errorf("status inicial %q", sim.Status)
+	}
+	if sim, err = r.StartSimulation(ctx, sim.ID); err != nil || sim.Status != "running" || sim.StartedAt == nil {
+		t.Fatalf("start: %+v, %v", sim, err)
+	}
+	if _, err := r.StartSimulation(ctx, sim.ID); !errors.Is(err, agent.ErrValidation) {
+		t.Errorf("start de simulação em execução: %v", err)
+	}
+	if sim, err = r.StopSimulation(ctx, sim.ID); err != nil || sim.Status != "stopped" || sim.EndedAt == nil {
+		t.Fatalf("stop: %+v, %v", sim, err)
+	}
+	list, total, _ := r.ListSimulations(ctx, 1, 10)
+	if total != 1 || len(list) != 1 || list[0].ID != sim.ID {
+		t.Errorf("ListSimulations = %v (total %d)", list, total)
+	}
+}
+
+func TestRedis(t *testing.T) {
+	client, srv := Redis(t)
+	ctx := context.Background()
+	if err := client.Set(ctx, "k", "v", time.Minute).Err(); err != nil {
+		t.Fatal(err)
+	}
+	srv.FastForward(2 * time.Minute)
+	if n, _ := client.Exists(ctx, "k").Result(); n != 0 {
+		t.Error("chave não expirou com FastForward")
+	}
+}
@@ -0,0 +1,22 @@
+package fake
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis sobe um Redis em memória (miniredis) para o teste e retorna um
+// cliente conectado a ele; ambos são encerrados no fim do teste. Os
+// pacotes recebem redis.UniversalClient, então o código testado é o mesmo
+// de produção, inclusive pipelines, scripts Lua e pub/sub. O servidor é
+// retornado para avançar o relógio das expirações (FastForward) e
+// inspecionar ou preparar chaves.
+func Redis(tb testing.TB) (redis.UniversalClient, *miniredis.Miniredis) {
+	tb.Helper()
+	srv := miniredis.RunT(tb)
+	client := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	tb.Cleanup(func() { client.Close() })
+	return client, srv
+}
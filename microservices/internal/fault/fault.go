@@ -0,0 +1,143 @@
+// Package fault injeta falhas temporárias numa simulação para testar como
+// o painel da cidade lida com dados degradados: descarte de parte da
+// telemetria dos agentes, atraso na entrega dos eventos, agentes forçados
+// a offline e ruído nas posições entregues.
+//
+// As falhas ficam na tabela simulation_faults e valem em todas as réplicas,
+// que as releem a cada refresh_interval. O descarte de telemetria roda nos
+// endpoints de reporte e na ponte MQTT; o atraso e o ruído, na entrega dos
+// eventos ao websocket, ao stream de posições e aos webhooks, sem alterar o
+// que fica gravado; os agentes offline são escolhidos no primeiro tick da
+// simulação depois da injeção e voltam ao status anterior quando a falha
+// termina. A injeção e o fim de cada falha ficam no log de eventos da
+// simulação (tabela events) e são publicados em events.TopicSimulations.
+package fault
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Tipos de falha.
+const (
+	// KindTelemetryDrop descarta Percent% dos reportes dos agentes.
+	KindTelemetryDrop = "telemetry_drop"
+	// KindEventLatency atrasa em LatencyMs a entrega dos eventos.
+	KindEventLatency = "event_latency"
+	// KindAgentsOffline passa Agents agentes escolhidos ao acaso a offline
+	// e descarta os reportes deles.
+	KindAgentsOffline = "agents_offline"
+	// KindPositionJitter desloca as posições entregues em até JitterM
+	// metros.
+	KindPositionJitter = "position_jitter"
+)
+
+// Kinds lista os tipos de falha aceitos.
+var Kinds = []string{KindTelemetryDrop, KindEventLatency, KindAgentsOffline, KindPositionJitter}
+
+// Situações de uma falha.
+const (
+	StatusActive    = "active"
+	StatusExpired   = "expired"
+	StatusCancelled = "cancelled"
+)
+
+// Eventos publicados em events.TopicSimulations.
+const (
+	EventInjected = "simulation.fault_injected"
+	EventEnded    = "simulation.fault_ended"
+)
+
+// Tipos dos registros gravados no log de eventos da simulação.
+const (
+	logInjected = "fault.injected"
+	logEnded    = "fault.ended"
+)
+
+var (
+	// ErrNotFound indica uma falha que não existe na simulação.
+	ErrNotFound = errors.New("fault not found")
+	// ErrNotActive indica uma falha que já terminou.
+	ErrNotActive = errors.New("fault already ended")
+	// ErrProduction indica a injeção numa simulação de produção por quem
+	// não é administrador.
+	ErrProduction = errors.New("fault injection on a production simulation requires the admin role")
+)
+
+// Fault é uma falha injetada numa simulação.
+type Fault struct {
+	ID           string  `json:"id"`
+	SimulationID string  `json:"simulation_id"`
+	ProjectID    string  `json:"project_id,omitempty"`
+	Kind         string  `json:"kind"`
+	Percent      float64 `json:"percent,omitempty"`
+	LatencyMs    int     `json:"latency_ms,omitempty"`
+	Agents       int     `json:"agents,omitempty"`
+	JitterM      float64 `json:"jitter_m,omitempty"`
+	// Affected são os agentes postos offline, com o status de antes.
+	Affected  map[string]string `json:"affected_agents,omitempty"`
+	Status    string            `json:"status"`
+	CreatedBy string            `json:"created_by,omitempty"`
+	StartedAt time.Time         `json:"started_at"`
+	ExpiresAt time.Time         `json:"expires_at"`
+	EndedAt   *time.Time        `json:"ended_at,omitempty"`
+}
+
+// activeAt indica se a falha vale em now.
+func (f *Fault) activeAt(now time.Time) bool {
+	return f.Status == StatusActive && now.Before(f.ExpiresAt)
+}
+
+// CreateRequest é o corpo de POST /simulations/:id/faults. Só o parâmetro
+// do tipo informado é lido.
+type CreateRequest struct {
+	Kind            string  `json:"kind" binding:"required"`
+	DurationSeconds int     `json:"duration_seconds" binding:"required"`
+	Percent         float64 `json:"percent"`
+	LatencyMs       int     `json:"latency_ms"`
+	Agents          int     `json:"agents"`
+	JitterM         float64 `json:"jitter_m"`
+}
+
+// Limits limita os parâmetros das falhas.
+type Limits struct {
+	MaxDuration time.Duration
+	MaxLatency  time.Duration
+	MaxAgents   int
+	MaxJitterM  float64
+}
+
+// build confere o pedido e monta a falha, sem ids nem datas.
+func (r CreateRequest) build(l Limits) (*Fault, error) {
+	d := time.Duration(r.DurationSeconds) * time.Second
+	if d <= 0 || d > l.MaxDuration {
+		return nil, fmt.Errorf("duration_seconds must be between 1 and %d", int(l.MaxDuration.Seconds()))
+	}
+	f := &Fault{Kind: r.Kind, ExpiresAt: time.Now().Add(d)}
+	switch r.Kind {
+	case KindTelemetryDrop:
+		if r.Percent <= 0 || r.Percent > 100 {
+			return nil, errors.New("percent must be greater than 0 and at most 100")
+		}
+		f.Percent = r.Percent
+	case KindEventLatency:
+		if r.LatencyMs <= 0 || time.Duration(r.LatencyMs)*time.Millisecond > l.MaxLatency {
+			return nil, fmt.Errorf("latency_ms must be between 1 and %d", l.MaxLatency.Milliseconds())
+		}
+		f.LatencyMs = r.LatencyMs
+	case KindAgentsOffline:
+		if r.Agents <= 0 || r.Agents > l.MaxAgents {
+			return nil, fmt.Errorf("agents must be between 1 and %d", l.MaxAgents)
+		}
+		f.Agents = r.Agents
+	case KindPositionJitter:
+		if r.JitterM <= 0 || r.JitterM > l.MaxJitterM {
+			return nil, fmt.Errorf("jitter_m must be greater than 0 and at most %g", l.MaxJitterM)
+		}
+		f.JitterM = r.JitterM
+	default:
+		return nil, fmt.Errorf("unknown fault kind: %s", r.Kind)
+	}
+	return f, nil
+}
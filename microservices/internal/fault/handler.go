@@ -0,0 +1,177 @@
+package fault
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/audit"
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/i18n"
+	"smart-city-microservices/internal/logging"
+)
+
+// SimulationGetter é o subconjunto de agent.Service usado pelo handler.
+type SimulationGetter interface {
+	GetSimulation(ctx context.Context, id string) (*agent.Simulation, error)
+}
+
+// Handler expõe as falhas injetadas de uma simulação.
+type Handler struct {
+	injector    *Injector
+	repo        *Repository
+	simulations SimulationGetter
+	limits      Limits
+}
+
+// NewHandler cria o handler de falhas.
+func NewHandler(injector *Injector, repo *Repository, simulations SimulationGetter, limits Limits) *Handler {
+	return &Handler{injector: injector, repo: repo, simulations: simulations, limits: limits}
+}
+
+// Production indica se a simulação está marcada como de produção, com
+// config.production: true.
+func Production(sim *agent.Simulation) bool {
+	on, _ := sim.Config["production"].(bool)
+	return on
+}
+
+// List responde GET /simulations/:id/faults com as falhas mais recentes,
+// ativas e encerradas.
+func (h *Handler) List(c *gin.Context) {
+	sim := h.simulation(c)
+	if sim == nil {
+		return
+	}
+	list, err := h.repo.List(c.Request.Context(), sim.ID)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	now := time.Now()
+	for _, f := range list {
+		present(f, now)
+	}
+	c.JSON(http.StatusOK, gin.H{"data": list})
+}
+
+// Get responde GET /simulations/:id/faults/:fault_id.
+func (h *Handler) Get(c *gin.Context) {
+	sim := h.simulation(c)
+	if sim == nil {
+		return
+	}
+	f, err := h.repo.Get(c.Request.Context(), sim.ID, c.Param("fault_id"))
+	if err != nil {
+		h.serviceError(c, err)
+		return
+	}
+	present(f, time.Now())
+	c.JSON(http.StatusOK, f)
+}
+
+// present mostra como expired a falha que passou do prazo e ainda espera a
+// releitura que a encerra.
+func present(f *Fault, now time.Time) {
+	if f.Status == StatusActive && !f.activeAt(now) {
+		f.Status = StatusExpired
+	}
+}
+
+// Create responde POST /simulations/:id/faults. Numa simulação de produção
+// só administradores injetam falhas.
+func (h *Handler) Create(c *gin.Context) {
+	var req CreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		i18n.BindError(c, err)
+		return
+	}
+	sim := h.simulation(c)
+	if sim == nil {
+		return
+	}
+	p := auth.FromGin(c)
+	if Production(sim) && !p.HasRole(auth.RoleAdmin) {
+		c.JSON(http.StatusForbidden, gin.H{"error": ErrProduction.Error()})
+		return
+	}
+	if sim.EndedAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "simulation has ended"})
+		return
+	}
+	f, err := req.build(h.limits)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	f.SimulationID, f.ProjectID = sim.ID, sim.ProjectID
+	if p != nil {
+		f.CreatedBy = p.Subject
+	}
+	ctx := c.Request.Context()
+	if err := h.injector.Create(ctx, f); err != nil {
+		h.internalError(c, err)
+		return
+	}
+	audit.Record(ctx, "fault.injected", logrus.Fields{
+		"simulation_id": sim.ID, "fault_id": f.ID, "kind": f.Kind, "expires_at": f.ExpiresAt, "production": Production(sim),
+	})
+	c.Header("Location", "/api/v1/simulations/"+sim.ID+"/faults/"+f.ID)
+	c.JSON(http.StatusCreated, f)
+}
+
+// Cancel responde DELETE /simulations/:id/faults/:fault_id: encerra a
+// falha antes do prazo e desfaz os efeitos dela. 409 se já terminou.
+func (h *Handler) Cancel(c *gin.Context) {
+	sim := h.simulation(c)
+	if sim == nil {
+		return
+	}
+	ctx := c.Request.Context()
+	f, err := h.repo.Get(ctx, sim.ID, c.Param("fault_id"))
+	if err == nil {
+		err = h.injector.Cancel(ctx, f)
+	}
+	if err != nil {
+		h.serviceError(c, err)
+		return
+	}
+	audit.Record(ctx, "fault.cancelled", logrus.Fields{"simulation_id": sim.ID, "fault_id": f.ID, "kind": f.Kind})
+	c.JSON(http.StatusOK, f)
+}
+
+// simulation busca a simulação de :id e confere o projeto do principal.
+// Responde e retorna nil se ela não existe ou é de outro projeto.
+func (h *Handler) simulation(c *gin.Context) *agent.Simulation {
+	sim, err := h.simulations.GetSimulation(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, agent.ErrNotFound) || (err == nil && !auth.FromGin(c).InProject(sim.ProjectID)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "simulation not found"})
+		return nil
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return nil
+	}
+	return sim
+}
+
+func (h *Handler) serviceError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrNotActive):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		h.internalError(c, err)
+	}
+}
+
+func (h *Handler) internalError(c *gin.Context, err error) {
+	logging.FromContext(c.Request.Context()).WithError(err).Error("Erro no handler de falhas injetadas")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
@@ -0,0 +1,472 @@
+package fault
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/logging"
+)
+
+const listPageSize = 500
+
+// metersPerDegree é o comprimento de um grau de latitude.
+const metersPerDegree = 111320.0
+
+var applied = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "agent_service",
+	Name:      "faults_applied_total",
+	Help:      "Efeitos das falhas injetadas, por tipo de falha (reportes descartados, eventos atrasados, posições deslocadas, agentes postos offline).",
+}, []string{"kind"})
+
+// AgentService é o subconjunto de agent.Service usado pelo injetor.
+type AgentService interface {
+	GetAgent(ctx context.Context, id string) (*agent.Agent, error)
+	UpdateAgent(ctx context.Context, id string, req agent.UpdateAgentRequest) (*agent.Agent, error)
+	ListAgents(ctx context.Context, f agent.Filter) ([]agent.Agent, int, error)
+}
+
+// Config configura o injetor.
+type Config struct {
+	// RefreshInterval é o intervalo entre as releituras das falhas ativas,
+	// que também encerram as expiradas.
+	RefreshInterval time.Duration
+	// OfflineStatus é o status dos agentes de agents_offline.
+	OfflineStatus string
+}
+
+// Injector aplica as falhas ativas nos pontos do serviço em que elas
+// atuam. As falhas ficam em memória, por simulação, entre as releituras.
+type Injector struct {
+	repo      *Repository
+	agents    AgentService
+	publisher events.Publisher
+	cfg       Config
+
+	mu     sync.RWMutex
+	active map[string][]*Fault
+}
+
+// NewInjector cria o injetor; as falhas passam a valer depois do primeiro
+// Refresh, feito por Run.
+func NewInjector(repo *Repository, agents AgentService, publisher events.Publisher, cfg Config) *Injector {
+	return &Injector{repo: repo, agents: agents, publisher: publisher, cfg: cfg, active: map[string][]*Fault{}}
+}
+
+// Run relê as falhas a cada intervalo até ctx ser cancelado. Roda em todas
+// as réplicas; o encerramento das expiradas é gravado por uma só.
+func (i *Injector) Run(ctx context.Context) error {
+	ctx = logging.Background(ctx, "fault-injector")
+	if err := i.Refresh(ctx); err != nil {
+		logging.FromContext(ctx).WithError(err).Warn("Falha ao ler as falhas injetadas")
+	}
+	ticker := time.NewTicker(i.cfg.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := i.Refresh(ctx); err != nil && ctx.Err() == nil {
+				logging.FromContext(ctx).WithError(err).Warn("Falha ao ler as falhas injetadas")
+			}
+		}
+	}
+}
+
+// Refresh relê as falhas ativas e encerra as que passaram de expires_at.
+func (i *Injector) Refresh(ctx context.Context) error {
+	list, err := i.repo.Active(ctx)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	active := map[string][]*Fault{}
+	for _, f := range list {
+		if !f.activeAt(now) {
+			i.end(ctx, f, StatusExpired)
+			continue
+		}
+		active[f.SimulationID] = append(active[f.SimulationID], f)
+	}
+	i.mu.Lock()
+	i.active = active
+	i.mu.Unlock()
+	return nil
+}
+
+// faults retorna as falhas em vigor na simulação.
+func (i *Injector) faults(simulationID string) []*Fault {
+	i.mu.RLock()
+	list := i.active[simulationID]
+	i.mu.RUnlock()
+	now := time.Now()
+	var out []*Fault
+	for _, f := range list {
+		if f.activeAt(now) {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+func (i *Injector) empty() bool {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return len(i.active) == 0
+}
+
+// add passa a aplicar nesta réplica a falha recém-criada.
+func (i *Injector) add(f *Fault) {
+	i.mu.Lock()
+	list := i.active[f.SimulationID]
+	i.active[f.SimulationID] = append(list[:len(list):len(list)], f)
+	i.mu.Unlock()
+}
+
+// replace troca a falha em memória por g, com o mesmo id. As falhas e as
+// listas em memória não são alteradas depois de entrar, porque os hooks as
+// leem sem o lock.
+func (i *Injector) replace(g *Fault) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	list := append([]*Fault(nil), i.active[g.SimulationID]...)
+	for k, f := range list {
+		if f.ID == g.ID {
+			list[k] = g
+		}
+	}
+	i.active[g.SimulationID] = list
+}
+
+// remove deixa de aplicar a falha nesta réplica.
+func (i *Injector) remove(f *Fault) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	list := i.active[f.SimulationID]
+	kept := make([]*Fault, 0, len(list))
+	for _, g := range list {
+		if g.ID != f.ID {
+			kept = append(kept, g)
+		}
+	}
+	if len(kept) == 0 {
+		delete(i.active, f.SimulationID)
+		return
+	}
+	i.active[f.SimulationID] = kept
+}
+
+// Create grava a falha, passa a aplicá-la nesta réplica e publica
+// EventInjected; as demais réplicas a aplicam depois da próxima releitura.
+func (i *Injector) Create(ctx context.Context, f *Fault) error {
+	if err := i.repo.Create(ctx, f); err != nil {
+		return err
+	}
+	i.add(f)
+	logging.FromContext(ctx).WithFields(logrus.Fields{
+		"simulation_id": f.SimulationID, "fault_id": f.ID, "kind": f.Kind, "expires_at": f.ExpiresAt,
+	}).Warn("Falha injetada na simulação")
+	i.publish(ctx, EventInjected, f, "")
+	return nil
+}
+
+// Cancel encerra a falha antes de expires_at.
+func (i *Injector) Cancel(ctx context.Context, f *Fault) error {
+	if f.Status != StatusActive {
+		return ErrNotActive
+	}
+	if err := i.repo.End(ctx, f, StatusCancelled); err != nil {
+		return err
+	}
+	i.finish(ctx, f)
+	return nil
+}
+
+// end encerra a falha, se nenhuma outra réplica o fez antes.
+func (i *Injector) end(ctx context.Context, f *Fault, status string) {
+	err := i.repo.End(ctx, f, status)
+	if errors.Is(err, ErrNotActive) {
+		i.remove(f)
+		return
+	}
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).WithField("fault_id", f.ID).Warn("Falha ao encerrar a falha injetada")
+		return
+	}
+	i.finish(ctx, f)
+}
+
+// finish desfaz os efeitos da falha encerrada e publica EventEnded.
+func (i *Injector) finish(ctx context.Context, f *Fault) {
+	i.remove(f)
+	i.restore(ctx, f.Affected)
+	logging.FromContext(ctx).WithFields(logrus.Fields{
+		"simulation_id": f.SimulationID, "fault_id": f.ID, "kind": f.Kind, "status": f.Status,
+	}).Info("Falha injetada encerrada")
+	i.publish(ctx, EventEnded, f, f.Status)
+}
+
+func (i *Injector) publish(ctx context.Context, eventType string, f *Fault, reason string) {
+	payload := events.SimulationFaultV1{
+		ID:           f.ID,
+		SimulationID: f.SimulationID,
+		ProjectID:    f.ProjectID,
+		Kind:         f.Kind,
+		Percent:      f.Percent,
+		LatencyMs:    f.LatencyMs,
+		Agents:       f.Agents,
+		JitterM:      f.JitterM,
+		StartedAt:    f.StartedAt.UTC(),
+		ExpiresAt:    f.ExpiresAt.UTC(),
+		CreatedBy:    f.CreatedBy,
+		Reason:       reason,
+	}
+	for id := range f.Affected {
+		payload.AffectedAgents = append(payload.AffectedAgents, id)
+	}
+	sort.Strings(payload.AffectedAgents)
+	i.publisher.Publish(ctx, events.New(events.TopicSimulations, eventType, payload))
+}
+
+// Tick põe offline, no primeiro tick depois da injeção, os agentes das
+// falhas agents_offline da simulação. Registrado no relógio das
+// simulações, que roda numa réplica por vez.
+func (i *Injector) Tick(ctx context.Context, simulationID string, tick int64) {
+	for _, f := range i.faults(simulationID) {
+		if f.Kind != KindAgentsOffline || f.Affected != nil {
+			continue
+		}
+		if err := i.takeOffline(ctx, f); err != nil {
+			logging.FromContext(ctx).WithError(err).WithFields(logrus.Fields{
+				"simulation_id": simulationID, "fault_id": f.ID, "tick": tick,
+			}).Warn("Falha ao pôr offline os agentes da falha injetada")
+		}
+	}
+}
+
+// takeOffline sorteia os agentes da falha entre os que não estão offline e
+// troca o status deles, guardando o anterior.
+func (i *Injector) takeOffline(ctx context.Context, f *Fault) error {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	var chosen []agent.Agent
+	seen := 0
+	for page := 1; ; page++ {
+		list, total, err := i.agents.ListAgents(ctx, agent.Filter{SimulationID: f.SimulationID, Page: page, PageSize: listPageSize})
+		if err != nil {
+			return err
+		}
+		// Amostragem de reservatório: cada agente tem a mesma chance.
+		for _, ag := range list {
+			if ag.Status == i.cfg.OfflineStatus {
+				continue
+			}
+			seen++
+			if len(chosen) < f.Agents {
+				chosen = append(chosen, ag)
+			} else if k := r.Intn(seen); k < f.Agents {
+				chosen[k] = ag
+			}
+		}
+		if len(list) < listPageSize || page*listPageSize >= total {
+			break
+		}
+	}
+
+	affected := make(map[string]string, len(chosen))
+	status := i.cfg.OfflineStatus
+	for _, ag := range chosen {
+		if _, err := i.agents.UpdateAgent(ctx, ag.ID, agent.UpdateAgentRequest{Status: &status}); err != nil {
+			i.restore(ctx, affected)
+			return err
+		}
+		affected[ag.ID] = ag.Status
+	}
+	if err := i.repo.SetAffected(ctx, f.ID, affected); err != nil {
+		// A falha terminou enquanto os agentes eram escolhidos, ou não foi
+		// possível guardá-los: não fica ninguém offline por ela.
+		i.restore(ctx, affected)
+		return err
+	}
+	g := *f
+	g.Affected = affected
+	i.replace(&g)
+	applied.WithLabelValues(KindAgentsOffline).Add(float64(len(affected)))
+	return nil
+}
+
+// restore devolve o status anterior aos agentes que continuam offline.
+func (i *Injector) restore(ctx context.Context, affected map[string]string) {
+	for id, previous := range affected {
+		ag, err := i.agents.GetAgent(ctx, id)
+		if errors.Is(err, agent.ErrNotFound) {
+			continue
+		}
+		if err == nil && ag.Status != i.cfg.OfflineStatus {
+			// O status mudou por outro caminho depois da falha.
+			continue
+		}
+		if err == nil {
+			previous := previous
+			_, err = i.agents.UpdateAgent(ctx, id, agent.UpdateAgentRequest{Status: &previous})
+		}
+		if err != nil {
+			logging.FromContext(ctx).WithError(err).WithField("agent_id", id).Warn("Falha ao devolver o status do agente após a falha injetada")
+		}
+	}
+}
+
+// drops indica se o reporte do agente deve ser descartado: ele está entre
+// os postos offline, ou caiu na porcentagem de uma falha telemetry_drop.
+func (i *Injector) drops(ag *agent.Agent) (*Fault, bool) {
+	for _, f := range i.faults(ag.SimulationID) {
+		switch f.Kind {
+		case KindAgentsOffline:
+			if _, ok := f.Affected[ag.ID]; ok {
+				return f, true
+			}
+		case KindTelemetryDrop:
+			if rand.Float64()*100 < f.Percent {
+				return f, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Middleware descarta os reportes do agente de :id atingidos por uma
+// falha: a resposta é 202 e o handler não roda, como na amostragem do
+// limite de reportes. Falhas ao buscar o agente deixam o reporte passar.
+func (i *Injector) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if i.empty() {
+			c.Next()
+			return
+		}
+		ag, err := i.agents.GetAgent(c.Request.Context(), c.Param("id"))
+		if err != nil || ag == nil {
+			c.Next()
+			return
+		}
+		if f, ok := i.drops(ag); ok {
+			applied.WithLabelValues(f.Kind).Inc()
+			c.AbortWithStatusJSON(http.StatusAccepted, gin.H{"agent_id": ag.ID, "dropped_by_fault": f.ID})
+			return
+		}
+		c.Next()
+	}
+}
+
+// Telemetry envolve agents de modo que as atualizações atingidas por uma
+// falha sejam descartadas sem erro, para a ponte MQTT. Precisa ficar por
+// fora do registro de presença, para que o reporte descartado não conte.
+func (i *Injector) Telemetry(agents TelemetryService) TelemetryService {
+	return telemetry{TelemetryService: agents, injector: i}
+}
+
+// TelemetryService é o subconjunto de agent.Service usado por quem recebe
+// telemetria, como a ponte MQTT.
+type TelemetryService interface {
+	GetAgent(ctx context.Context, id string) (*agent.Agent, error)
+	UpdateAgent(ctx context.Context, id string, req agent.UpdateAgentRequest) (*agent.Agent, error)
+}
+
+type telemetry struct {
+	TelemetryService
+	injector *Injector
+}
+
+func (t telemetry) UpdateAgent(ctx context.Context, id string, req agent.UpdateAgentRequest) (*agent.Agent, error) {
+	if t.injector.empty() {
+		return t.TelemetryService.UpdateAgent(ctx, id, req)
+	}
+	ag, err := t.GetAgent(ctx, id)
+	if err != nil || ag == nil {
+		return t.TelemetryService.UpdateAgent(ctx, id, req)
+	}
+	if f, ok := t.injector.drops(ag); ok {
+		applied.WithLabelValues(f.Kind).Inc()
+		return ag, nil
+	}
+	return t.TelemetryService.UpdateAgent(ctx, id, req)
+}
+
+// Deliver envolve um assinante dos eventos de modo que os eventos das
+// simulações com falhas event_latency cheguem atrasados e as posições
+// dos agentes, com o ruído das falhas position_jitter. Os eventos das
+// próprias falhas passam sem alteração.
+func (i *Injector) Deliver(h events.Handler) events.Handler {
+	return func(ctx context.Context, e events.Event) {
+		if i.empty() || strings.HasPrefix(e.Type, "simulation.fault_") {
+			h(ctx, e)
+			return
+		}
+		faults := i.faults(e.SimulationID())
+		var delay time.Duration
+		for _, f := range faults {
+			switch f.Kind {
+			case KindEventLatency:
+				delay = max(delay, time.Duration(f.LatencyMs)*time.Millisecond)
+			case KindPositionJitter:
+				if jittered, ok := jitter(e, f.JitterM); ok {
+					e = jittered
+					applied.WithLabelValues(KindPositionJitter).Inc()
+				}
+			}
+		}
+		if delay <= 0 {
+			h(ctx, e)
+			return
+		}
+		applied.WithLabelValues(KindEventLatency).Inc()
+		ctx = context.WithoutCancel(ctx)
+		time.AfterFunc(delay, func() { h(ctx, e) })
+	}
+}
+
+// jitter retorna uma cópia do evento com position deslocada em até
+// meters, numa direção ao acaso; false se o evento não traz posição.
+func jitter(e events.Event, meters float64) (events.Event, bool) {
+	if e.Topic != events.TopicAgents {
+		return e, false
+	}
+	var data map[string]interface{}
+	if err := e.Decode(&data); err != nil {
+		return e, false
+	}
+	pos, _ := data["position"].(map[string]interface{})
+	lat, okLat := pos["lat"].(float64)
+	lon, okLon := pos["lon"].(float64)
+	if !okLat || !okLon {
+		return e, false
+	}
+	// A raiz deixa os pontos uniformes no disco, e não concentrados no
+	// centro.
+	d := meters * math.Sqrt(rand.Float64())
+	angle := rand.Float64() * 2 * math.Pi
+	pos["lat"] = lat + d*math.Cos(angle)/metersPerDegree
+	pos["lon"] = lon + d*math.Sin(angle)/(metersPerDegree*math.Max(math.Cos(lat*math.Pi/180), 0.01))
+	// Um evento novo, sem a serialização já feita do original.
+	return events.Event{
+		Type:       e.Type,
+		Version:    e.Version,
+		Topic:      e.Topic,
+		Data:       data,
+		OccurredAt: e.OccurredAt,
+		Producer:   e.Producer,
+		Trace:      e.Trace,
+	}, true
+}
@@ -0,0 +1,190 @@
+package fault
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"smart-city-microservices/internal/instrument"
+)
+
+// Repository persiste as falhas e os registros delas no log de eventos.
+type Repository struct {
+	db *instrument.DB
+}
+
+// NewRepository cria o repositório.
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: instrument.NewDB(db)}
+}
+
+// listLimit limita as falhas retornadas por simulação.
+const listLimit = 100
+
+const faultColumns = `f.id, f.simulation_id, COALESCE(s.project_id, ''), f.kind, f.percent, f.latency_ms, f.agents,
+	f.jitter_m, f.affected, f.status, COALESCE(f.created_by, ''), f.started_at, f.expires_at, f.ended_at`
+
+const faultFrom = ` FROM simulation_faults f JOIN simulations s ON s.id = f.simulation_id`
+
+func scanFault(row interface{ Scan(...interface{}) error }) (*Fault, error) {
+	var f Fault
+	var affected []byte
+	err := row.Scan(&f.ID, &f.SimulationID, &f.ProjectID, &f.Kind, &f.Percent, &f.LatencyMs, &f.Agents,
+		&f.JitterM, &affected, &f.Status, &f.CreatedBy, &f.StartedAt, &f.ExpiresAt, &f.EndedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := decodeAffected(affected, &f); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func scanFaults(rows *sql.Rows) ([]*Fault, error) {
+	defer rows.Close()
+	out := []*Fault{}
+	for rows.Next() {
+		f, err := scanFault(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, f)
+	}
+	return out, rows.Err()
+}
+
+// Create grava a falha e o registro fault.injected no log de eventos da
+// simulação, numa só transação, e preenche id e started_at.
+func (r *Repository) Create(ctx context.Context, f *Fault) (err error) {
+	span := instrument.StartQuery(ctx, "fault.create")
+	defer func() { span.End(1, err) }()
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO simulation_faults (simulation_id, kind, percent, latency_ms, agents, jitter_m, created_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), $8)
+		RETURNING id, status, started_at`,
+		f.SimulationID, f.Kind, f.Percent, f.LatencyMs, f.Agents, f.JitterM, f.CreatedBy, f.ExpiresAt,
+	).Scan(&f.ID, &f.Status, &f.StartedAt)
+	if err != nil {
+		return err
+	}
+	if err = logEvent(ctx, tx.Tx, f, logInjected, "warning", "Falha "+f.Kind+" injetada"); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// List retorna as falhas mais recentes da simulação.
+func (r *Repository) List(ctx context.Context, simulationID string) ([]*Fault, error) {
+	rows, err := r.db.Query(ctx, "fault.list",
+		`SELECT `+faultColumns+faultFrom+` WHERE f.simulation_id = $1 ORDER BY f.started_at DESC LIMIT $2`,
+		simulationID, listLimit)
+	if err != nil {
+		return nil, err
+	}
+	return scanFaults(rows)
+}
+
+// Get busca a falha id da simulação.
+func (r *Repository) Get(ctx context.Context, simulationID, id string) (*Fault, error) {
+	return scanFault(r.db.QueryRow(ctx, "fault.get",
+		`SELECT `+faultColumns+faultFrom+` WHERE f.simulation_id = $1 AND f.id = $2`, simulationID, id))
+}
+
+// Active retorna as falhas ainda não encerradas, inclusive as que passaram
+// de expires_at e aguardam o registro do fim.
+func (r *Repository) Active(ctx context.Context) ([]*Fault, error) {
+	rows, err := r.db.Query(ctx, "fault.active",
+		`SELECT `+faultColumns+faultFrom+` WHERE f.status = 'active' ORDER BY f.started_at`)
+	if err != nil {
+		return nil, err
+	}
+	return scanFaults(rows)
+}
+
+// SetAffected grava os agentes postos offline pela falha. Se a falha já
+// terminou, ou os agentes já foram escolhidos, nada é gravado e o erro é
+// ErrNotActive.
+func (r *Repository) SetAffected(ctx context.Context, id string, affected map[string]string) error {
+	raw, err := json.Marshal(affected)
+	if err != nil {
+		return err
+	}
+	res, err := r.db.Exec(ctx, "fault.set_affected",
+		`UPDATE simulation_faults SET affected = $2 WHERE id = $1 AND status = 'active' AND affected IS NULL`, id, raw)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotActive
+	}
+	return nil
+}
+
+// End encerra a falha com status (StatusExpired ou StatusCancelled) e grava
+// fault.ended no log de eventos. Só uma chamada encerra cada falha; as
+// demais retornam ErrNotActive.
+func (r *Repository) End(ctx context.Context, f *Fault, status string) (err error) {
+	span := instrument.StartQuery(ctx, "fault.end")
+	defer func() { span.End(1, err) }()
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var affected []byte
+	err = tx.QueryRowContext(ctx, `
+		UPDATE simulation_faults SET status = $2, ended_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status = 'active'
+		RETURNING affected, ended_at`, f.ID, status).Scan(&affected, &f.EndedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotActive
+	}
+	if err != nil {
+		return err
+	}
+	// O que outra réplica gravou vale mais que a cópia em memória.
+	if err = decodeAffected(affected, f); err != nil {
+		return err
+	}
+	f.Status = status
+	if err = logEvent(ctx, tx.Tx, f, logEnded, "info", "Falha "+f.Kind+" encerrada ("+status+")"); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// decodeAffected lê a coluna affected, nula até a escolha dos agentes.
+func decodeAffected(raw []byte, f *Fault) error {
+	if raw == nil {
+		f.Affected = nil
+		return nil
+	}
+	return json.Unmarshal(raw, &f.Affected)
+}
+
+func logEvent(ctx context.Context, tx *sql.Tx, f *Fault, eventType, severity, description string) error {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO events (simulation_id, event_type, description, data, severity, source)
+		VALUES ($1, $2, $3, $4, $5, 'fault')`,
+		f.SimulationID, eventType, description, string(data), severity)
+	return err
+}
@@ -0,0 +1,87 @@
+// Package featureflag liga e desliga rotas arriscadas por ambiente ou por
+// projeto, para que endpoints novos possam entrar no ar desligados.
+//
+// Os flags são definidos no código com um valor padrão, que a
+// configuração do ambiente pode trocar (feature_flags.defaults). Por cima
+// dele valem os valores gravados em feature_flag_overrides: o do ambiente
+// inteiro e o de cada projeto, que tem precedência. Um valor ligado pode se
+// restringir a alguns papéis. Cada réplica guarda os valores em memória e
+// os relê quando outra os altera, avisada por um canal Redis pub/sub, ou a
+// cada intervalo, se o aviso se perder; avaliar um flag não sai da memória.
+package featureflag
+
+import (
+	"errors"
+	"time"
+)
+
+// Flags definidos. Os nomes não têm pontos, que separam as chaves da
+// configuração: o padrão de agent_transfer no ambiente é
+// feature_flags.defaults.agent_transfer.
+const (
+	AgentTransfer    = "agent_transfer"
+	SimulationExport = "simulation_export"
+)
+
+// Definition é um flag definido no código.
+type Definition struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Default     bool   `json:"default"`
+}
+
+// Definitions são os flags conhecidos.
+var Definitions = []Definition{
+	{AgentTransfer, "POST /api/v1/agents/:id/transfer e /transfer-project", false},
+	{SimulationExport, "POST /api/v1/simulations/:id/export", false},
+}
+
+// Lookup retorna a definição do flag name.
+func Lookup(name string) (Definition, bool) {
+	for _, d := range Definitions {
+		if d.Name == name {
+			return d, true
+		}
+	}
+	return Definition{}, false
+}
+
+// ErrUnknown indica um flag que não está em Definitions.
+var ErrUnknown = errors.New("unknown feature flag")
+
+// Override é um valor gravado que substitui o padrão do flag: do ambiente
+// inteiro, com ProjectID vazio, ou de um projeto. Com Roles, o flag ligado
+// vale só para os principais com um desses papéis.
+type Override struct {
+	Flag      string    `json:"flag"`
+	ProjectID string    `json:"project_id,omitempty"`
+	Enabled   bool      `json:"enabled"`
+	Roles     []string  `json:"roles"`
+	UpdatedBy string    `json:"updated_by,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Decision é o resultado da avaliação de um flag para uma requisição.
+type Decision int
+
+const (
+	// Off esconde a rota: 404.
+	Off Decision = iota
+	// Forbidden é o flag ligado para papéis que o principal não tem: 403.
+	Forbidden
+	// On libera a rota.
+	On
+)
+
+// State é um flag como esta réplica o vê, para GET /admin/flags.
+type State struct {
+	Definition
+	// Default aqui é o padrão do ambiente: o do código ou o da
+	// configuração.
+	Environment *Override  `json:"environment,omitempty"`
+	Projects    []Override `json:"projects"`
+	// Enabled é o valor do ambiente para quem não tem valor de projeto.
+	Enabled bool `json:"enabled"`
+	// ProjectEnabled é o valor do projeto pedido em ?project_id=.
+	ProjectEnabled *bool `json:"project_enabled,omitempty"`
+}
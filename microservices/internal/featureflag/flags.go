@@ -0,0 +1,272 @@
+package featureflag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/logging"
+)
+
+// Config configura a avaliação dos flags.
+type Config struct {
+	// Channel é o canal Redis em que as alterações são avisadas.
+	Channel string
+	// RefreshInterval é o intervalo da releitura completa, que cobre os
+	// avisos perdidos.
+	RefreshInterval time.Duration
+	// Defaults troca o padrão do código dos flags neste ambiente.
+	Defaults map[string]bool
+}
+
+// Flags avalia os flags com os valores gravados em memória.
+type Flags struct {
+	repo  *Repository
+	redis redis.UniversalClient
+	cfg   Config
+
+	mu sync.RWMutex
+	// overrides são os valores gravados por flag e projeto; o do ambiente
+	// fica no projeto vazio.
+	overrides map[string]map[string]Override
+}
+
+// New cria os flags, só com os padrões até a primeira leitura de Run.
+func New(repo *Repository, client redis.UniversalClient, cfg Config) *Flags {
+	return &Flags{repo: repo, redis: client, cfg: cfg, overrides: map[string]map[string]Override{}}
+}
+
+// Evaluate avalia o flag name para um principal no projeto projectID,
+// vazio quando a requisição não tem projeto. Vale o valor do projeto, se
+// houver, senão o do ambiente e por fim o padrão.
+func (f *Flags) Evaluate(name, projectID string, p *auth.Principal) Decision {
+	f.mu.RLock()
+	scopes := f.overrides[name]
+	o, ok := scopes[projectID]
+	if !ok {
+		o, ok = scopes[""]
+	}
+	f.mu.RUnlock()
+	if !ok {
+		if f.fallback(name) {
+			return On
+		}
+		return Off
+	}
+	if !o.Enabled {
+		return Off
+	}
+	if len(o.Roles) == 0 {
+		return On
+	}
+	for _, role := range o.Roles {
+		if p.HasRole(role) {
+			return On
+		}
+	}
+	return Forbidden
+}
+
+// fallback é o padrão do flag neste ambiente.
+func (f *Flags) fallback(name string) bool {
+	if on, ok := f.cfg.Defaults[name]; ok {
+		return on
+	}
+	d, _ := Lookup(name)
+	return d.Default
+}
+
+// Guard é o middleware das rotas do flag name: com o flag desligado a rota
+// responde 404, como se não existisse; ligado para outros papéis, 403. O
+// projeto é o de X-Project-ID ou ?project_id=, se o principal tem acesso a
+// ele, ou o único projeto do principal.
+func (f *Flags) Guard(name string) gin.HandlerFunc {
+	if _, ok := Lookup(name); !ok {
+		panic(fmt.Sprintf("featureflag: flag %q não definido", name))
+	}
+	return func(c *gin.Context) {
+		p := auth.FromGin(c)
+		switch f.Evaluate(name, requestProject(c, p), p) {
+		case Off:
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "not found"})
+		case Forbidden:
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "feature " + name + " is not enabled for this principal"})
+		default:
+			c.Next()
+		}
+	}
+}
+
+// EnabledFor retorna os flags ligados para a requisição, no projeto e com
+// o principal que Guard usaria.
+func (f *Flags) EnabledFor(c *gin.Context) []string {
+	p := auth.FromGin(c)
+	project := requestProject(c, p)
+	out := []string{}
+	for _, d := range Definitions {
+		if f.Evaluate(d.Name, project, p) == On {
+			out = append(out, d.Name)
+		}
+	}
+	return out
+}
+
+// EnabledInEnvironment retorna os flags ligados no ambiente, sem contar os
+// valores dos projetos.
+func (f *Flags) EnabledInEnvironment() []string {
+	out := []string{}
+	for _, s := range f.States("") {
+		if s.Enabled {
+			out = append(out, s.Name)
+		}
+	}
+	return out
+}
+
+func requestProject(c *gin.Context, p *auth.Principal) string {
+	id := c.GetHeader(logging.HeaderProjectID)
+	if id == "" {
+		id = c.Query("project_id")
+	}
+	if id != "" && p.InProject(id) {
+		return id
+	}
+	if p != nil && len(p.Projects) == 1 {
+		return p.Projects[0]
+	}
+	return ""
+}
+
+// Set grava o valor o, aplica nesta réplica e avisa as demais.
+func (f *Flags) Set(ctx context.Context, o *Override) error {
+	if _, ok := Lookup(o.Flag); !ok {
+		return ErrUnknown
+	}
+	if o.Roles == nil {
+		o.Roles = []string{}
+	}
+	if err := f.repo.Upsert(ctx, o); err != nil {
+		return err
+	}
+	return f.changed(ctx, o.Flag)
+}
+
+// Reset remove o valor do flag no escopo do projeto, vazio para o do
+// ambiente; retorna se havia um.
+func (f *Flags) Reset(ctx context.Context, name, projectID string) (bool, error) {
+	if _, ok := Lookup(name); !ok {
+		return false, ErrUnknown
+	}
+	found, err := f.repo.Delete(ctx, name, projectID)
+	if err != nil || !found {
+		return found, err
+	}
+	return true, f.changed(ctx, name)
+}
+
+// changed relê os valores e avisa as outras réplicas. Sem o aviso, elas
+// veem a alteração na próxima releitura completa.
+func (f *Flags) changed(ctx context.Context, name string) error {
+	if err := f.Refresh(ctx); err != nil {
+		return err
+	}
+	if err := f.redis.Publish(ctx, f.cfg.Channel, name).Err(); err != nil {
+		logging.FromContext(ctx).WithError(err).Warn("Falha ao avisar as réplicas da alteração do feature flag")
+	}
+	return nil
+}
+
+// States retorna os flags em ordem de definição. Com projectID, inclui o
+// valor efetivo nele.
+func (f *Flags) States(projectID string) []State {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	out := make([]State, 0, len(Definitions))
+	for _, d := range Definitions {
+		s := State{Definition: d, Projects: []Override{}}
+		s.Default = f.fallback(d.Name)
+		s.Enabled = s.Default
+		for project, o := range f.overrides[d.Name] {
+			if project == "" {
+				o := o
+				s.Environment = &o
+				s.Enabled = o.Enabled
+				continue
+			}
+			s.Projects = append(s.Projects, o)
+		}
+		sortOverrides(s.Projects)
+		if projectID != "" {
+			on := s.Enabled
+			if o, ok := f.overrides[d.Name][projectID]; ok {
+				on = o.Enabled
+			}
+			s.ProjectEnabled = &on
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+func sortOverrides(list []Override) {
+	sort.Slice(list, func(i, j int) bool { return list[i].ProjectID < list[j].ProjectID })
+}
+
+// Refresh relê os valores gravados.
+func (f *Flags) Refresh(ctx context.Context) error {
+	list, err := f.repo.List(ctx)
+	if err != nil {
+		return err
+	}
+	overrides := map[string]map[string]Override{}
+	for _, o := range list {
+		if overrides[o.Flag] == nil {
+			overrides[o.Flag] = map[string]Override{}
+		}
+		overrides[o.Flag][o.ProjectID] = o
+	}
+	f.mu.Lock()
+	f.overrides = overrides
+	f.mu.Unlock()
+	return nil
+}
+
+// Run lê os valores e os relê a cada aviso de alteração e a cada
+// RefreshInterval, até ctx ser cancelado. Uma leitura que falha mantém os
+// últimos valores conhecidos.
+func (f *Flags) Run(ctx context.Context) error {
+	ctx = logging.Background(ctx, "feature-flags")
+	log := logging.FromContext(ctx)
+	sub := f.redis.Subscribe(ctx, f.cfg.Channel)
+	defer sub.Close()
+	refresh := func() {
+		if err := f.Refresh(ctx); err != nil && ctx.Err() == nil {
+			log.WithError(err).Warn("Falha ao ler os feature flags")
+		}
+	}
+	refresh()
+	messages := sub.Channel()
+	ticker := time.NewTicker(f.cfg.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			refresh()
+		case _, ok := <-messages:
+			if !ok {
+				return errors.New("featureflag: inscrição encerrada")
+			}
+			refresh()
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package featureflag
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/audit"
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/i18n"
+	"smart-city-microservices/internal/logging"
+)
+
+// roles são os papéis aceitos em SetRequest.Roles.
+var roles = []string{auth.RoleAdmin, auth.RoleOperator, auth.RoleViewer}
+
+// Handler expõe os flags em /admin/flags. As rotas devem ser registradas
+// atrás de auth.RequireRole(auth.RoleAdmin).
+type Handler struct {
+	flags *Flags
+}
+
+// NewHandler cria o handler dos flags.
+func NewHandler(flags *Flags) *Handler {
+	return &Handler{flags: flags}
+}
+
+// SetRequest é o corpo de PUT /admin/flags/:name. Sem project_id, o valor
+// vale para o ambiente inteiro.
+type SetRequest struct {
+	Enabled   *bool    `json:"enabled" binding:"required"`
+	ProjectID string   `json:"project_id"`
+	Roles     []string `json:"roles"`
+}
+
+// List responde GET /admin/flags com os flags como esta réplica os vê; com
+// ?project_id=, inclui o valor efetivo no projeto.
+func (h *Handler) List(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"data": h.flags.States(c.Query("project_id"))})
+}
+
+// Set responde PUT /admin/flags/:name.
+func (h *Handler) Set(c *gin.Context) {
+	var req SetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		i18n.BindError(c, err)
+		return
+	}
+	for _, r := range req.Roles {
+		if !slices.Contains(roles, r) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid role %q (use admin, operator or viewer)", r)})
+			return
+		}
+	}
+	o := &Override{Flag: c.Param("name"), ProjectID: req.ProjectID, Enabled: *req.Enabled, Roles: req.Roles}
+	if p := auth.FromGin(c); p != nil {
+		o.UpdatedBy = p.Subject
+	}
+	ctx := c.Request.Context()
+	if err := h.flags.Set(ctx, o); err != nil {
+		h.serviceError(c, err)
+		return
+	}
+	audit.Record(ctx, "feature_flag.changed", logrus.Fields{
+		"flag": o.Flag, "project_id": o.ProjectID, "enabled": o.Enabled, "roles": o.Roles,
+	})
+	c.JSON(http.StatusOK, o)
+}
+
+// Reset responde DELETE /admin/flags/:name?project_id=: remove o valor do
+// projeto, ou o do ambiente sem project_id, e o flag volta ao padrão.
+func (h *Handler) Reset(c *gin.Context) {
+	ctx := c.Request.Context()
+	name, projectID := c.Param("name"), c.Query("project_id")
+	found, err := h.flags.Reset(ctx, name, projectID)
+	if err != nil {
+		h.serviceError(c, err)
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "feature flag override not found"})
+		return
+	}
+	audit.Record(ctx, "feature_flag.reset", logrus.Fields{"flag": name, "project_id": projectID})
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) serviceError(c *gin.Context, err error) {
+	if errors.Is(err, ErrUnknown) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	logging.FromContext(c.Request.Context()).WithError(err).Error("Erro no handler de feature flags")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
@@ -0,0 +1,67 @@
+package featureflag
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/lib/pq"
+
+	"smart-city-microservices/internal/instrument"
+)
+
+// Repository persiste os valores dos flags em feature_flag_overrides.
+type Repository struct {
+	db *instrument.DB
+}
+
+// NewRepository cria o repositório de flags.
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: instrument.NewDB(db)}
+}
+
+// List retorna todos os valores gravados.
+func (r *Repository) List(ctx context.Context) ([]Override, error) {
+	rows, err := r.db.Query(ctx, "featureflag.list", `
+		SELECT flag, project_id, enabled, roles, COALESCE(updated_by, ''), updated_at
+		FROM feature_flag_overrides ORDER BY flag, project_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Override
+	for rows.Next() {
+		var o Override
+		if err := rows.Scan(&o.Flag, &o.ProjectID, &o.Enabled, pq.Array(&o.Roles), &o.UpdatedBy, &o.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if o.Roles == nil {
+			o.Roles = []string{}
+		}
+		out = append(out, o)
+	}
+	return out, rows.Err()
+}
+
+// Upsert grava o valor do flag no escopo de o.ProjectID e preenche
+// o.UpdatedAt.
+func (r *Repository) Upsert(ctx context.Context, o *Override) error {
+	return r.db.QueryRow(ctx, "featureflag.upsert", `
+		INSERT INTO feature_flag_overrides (flag, project_id, enabled, roles, updated_by, updated_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), CURRENT_TIMESTAMP)
+		ON CONFLICT (flag, project_id) DO UPDATE SET
+			enabled = EXCLUDED.enabled, roles = EXCLUDED.roles,
+			updated_by = EXCLUDED.updated_by, updated_at = EXCLUDED.updated_at
+		RETURNING updated_at`,
+		o.Flag, o.ProjectID, o.Enabled, pq.Array(o.Roles), o.UpdatedBy).Scan(&o.UpdatedAt)
+}
+
+// Delete remove o valor do flag no escopo do projeto; retorna se havia um.
+func (r *Repository) Delete(ctx context.Context, flag, projectID string) (bool, error) {
+	res, err := r.db.Exec(ctx, "featureflag.delete",
+		`DELETE FROM feature_flag_overrides WHERE flag = $1 AND project_id = $2`, flag, projectID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,82 @@
+// Package geo serve as posições dos agentes para a visualização em mapa:
+// GeoJSON (RFC 7946) negociado nas listagens e a busca por proximidade.
+package geo
+
+import (
+	"math"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/jsonstream"
+)
+
+// MediaType é o tipo de conteúdo GeoJSON.
+const MediaType = "application/geo+json"
+
+// Wants indica se o cliente pediu GeoJSON, via ?format=geojson ou Accept.
+func Wants(c *gin.Context) bool {
+	if c.Query("format") == "geojson" {
+		return true
+	}
+	for _, part := range strings.Split(c.GetHeader("Accept"), ",") {
+		if mediaType, _, _ := strings.Cut(strings.TrimSpace(part), ";"); mediaType == MediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// feature é um agente como Feature com geometria Point. As coordenadas
+// seguem a ordem do GeoJSON: longitude, latitude.
+type feature struct {
+	Type       string            `json:"type"`
+	ID         string            `json:"id"`
+	Geometry   point             `json:"geometry"`
+	Properties featureProperties `json:"properties"`
+}
+
+type point struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+type featureProperties struct {
+	ID        string   `json:"id"`
+	Type      string   `json:"type"`
+	Status    string   `json:"status"`
+	Heading   float64  `json:"heading"`
+	DistanceM *float64 `json:"distance_m,omitempty"`
+}
+
+func newFeature(a *agent.Agent) feature {
+	return feature{
+		Type:     "Feature",
+		ID:       a.ID,
+		Geometry: point{Type: "Point", Coordinates: [2]float64{a.Position.Lon, a.Position.Lat}},
+		Properties: featureProperties{
+			ID:      a.ID,
+			Type:    a.Type,
+			Status:  a.Status,
+			Heading: a.Position.Heading,
+		},
+	}
+}
+
+// newCollection começa uma FeatureCollection, escrita feature a feature.
+// Os membros extras (total, página) vão antes de "features", conforme
+// permitido pela RFC 7946.
+func newCollection(c *gin.Context, members ...jsonstream.Member) *jsonstream.Writer {
+	return jsonstream.Start(c, MediaType, "features", append([]jsonstream.Member{{Key: "type", Value: "FeatureCollection"}}, members...)...)
+}
+
+// distanceMeters é a distância de grande círculo (haversine) entre dois pontos.
+func distanceMeters(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadius = 6371000.0
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
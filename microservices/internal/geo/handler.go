@@ -0,0 +1,284 @@
+package geo
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/jsonstream"
+	"smart-city-microservices/internal/logging"
+)
+
+// Limites das listagens, os mesmos da API REST.
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// Limites da busca por proximidade.
+const (
+	defaultRadiusM     = 1000.0
+	maxRadiusM         = 50000.0
+	defaultNearbyLimit = 100
+	maxNearbyLimit     = 1000
+	// maxNearbyScan limita quantos agentes uma busca percorre; acima disso a
+	// resposta sai com "truncated": true.
+	maxNearbyScan = 50000
+)
+
+// scanPageSize é o tamanho das páginas ao percorrer todos os agentes.
+const scanPageSize = 500
+
+// AgentService é o subconjunto de agent.Service usado aqui.
+type AgentService interface {
+	ListAgents(ctx context.Context, f agent.Filter) ([]agent.Agent, int, error)
+	GetSimulation(ctx context.Context, id string) (*agent.Simulation, error)
+}
+
+// Handler serve as posições dos agentes em GeoJSON e a busca por proximidade.
+type Handler struct {
+	agents AgentService
+}
+
+// NewHandler cria o handler de posições.
+func NewHandler(agents AgentService) *Handler {
+	return &Handler{agents: agents}
+}
+
+// ListAgents responde GET /agents em GeoJSON quando o cliente pede (ver
+// Wants), com os mesmos filtros e paginação da listagem JSON; nos demais
+// casos segue para o próximo handler.
+func (h *Handler) ListAgents(c *gin.Context) {
+	if !Wants(c) {
+		c.Next()
+		return
+	}
+	c.Abort()
+
+	f := filterFrom(c)
+	f.Page, f.PageSize = 1, defaultPageSize
+	if !intQuery(c, "page", &f.Page, math.MaxInt32) || !intQuery(c, "page_size", &f.PageSize, maxPageSize) {
+		return
+	}
+	agents, total, err := h.agents.ListAgents(c.Request.Context(), f)
+	if err != nil {
+		h.serviceError(c, err)
+		return
+	}
+
+	fc := newCollection(c,
+		jsonstream.Member{Key: "total", Value: total},
+		jsonstream.Member{Key: "page", Value: f.Page},
+		jsonstream.Member{Key: "page_size", Value: f.PageSize},
+	)
+	for i := range agents {
+		fc.Add(newFeature(&agents[i]))
+	}
+	h.finish(c, fc)
+}
+
+// nearbyAgent é um item da resposta JSON de GET /agents/nearby.
+type nearbyAgent struct {
+	agent.Agent
+	DistanceM float64 `json:"distance_m"`
+}
+
+// Nearby responde GET /agents/nearby: os agentes a até radius_m metros de
+// (lat, lon), do mais próximo ao mais distante. Aceita os filtros da
+// listagem e responde em JSON ou GeoJSON.
+func (h *Handler) Nearby(c *gin.Context) {
+	lat, ok := floatQuery(c, "lat", -90, 90)
+	if !ok {
+		return
+	}
+	lon, ok := floatQuery(c, "lon", -180, 180)
+	if !ok {
+		return
+	}
+	radius := defaultRadiusM
+	if c.Query("radius_m") != "" {
+		if radius, ok = floatQuery(c, "radius_m", 0, maxRadiusM); !ok {
+			return
+		}
+	}
+	limit := defaultNearbyLimit
+	if !intQuery(c, "limit", &limit, maxNearbyLimit) {
+		return
+	}
+	f := filterFrom(c)
+
+	// O repositório não tem índice espacial: as páginas são percorridas e
+	// filtradas pela distância aqui.
+	var found []nearbyAgent
+	scanned, truncated := 0, false
+	f.PageSize = scanPageSize
+	for f.Page = 1; ; f.Page++ {
+		agents, total, err := h.agents.ListAgents(c.Request.Context(), f)
+		if err != nil {
+			h.serviceError(c, err)
+			return
+		}
+		for _, a := range agents {
+			if d := distanceMeters(lat, lon, a.Position.Lat, a.Position.Lon); d <= radius {
+				found = append(found, nearbyAgent{Agent: a, DistanceM: d})
+			}
+		}
+		scanned += len(agents)
+		if len(agents) < f.PageSize || scanned >= total {
+			break
+		}
+		if scanned >= maxNearbyScan {
+			truncated = true
+			break
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool { return found[i].DistanceM < found[j].DistanceM })
+	total := len(found)
+	if len(found) > limit {
+		found = found[:limit]
+	}
+
+	if !Wants(c) {
+		if found == nil {
+			found = []nearbyAgent{}
+		}
+		c.JSON(http.StatusOK, gin.H{"data": found, "total": total, "truncated": truncated})
+		return
+	}
+	fc := newCollection(c,
+		jsonstream.Member{Key: "total", Value: total},
+		jsonstream.Member{Key: "truncated", Value: truncated},
+	)
+	for i := range found {
+		feat := newFeature(&found[i].Agent)
+		feat.Properties.DistanceM = &found[i].DistanceM
+		fc.Add(feat)
+	}
+	h.finish(c, fc)
+}
+
+// SimulationAgents responde GET /simulations/:id/agents.geojson com a posição
+// atual de todos os agentes da simulação, sem paginação. As páginas são lidas
+// à medida que a resposta é escrita.
+func (h *Handler) SimulationAgents(c *gin.Context) {
+	ctx := c.Request.Context()
+	sim, err := h.agents.GetSimulation(ctx, c.Param("id"))
+	if err != nil {
+		h.serviceError(c, err)
+		return
+	}
+
+	f := agent.Filter{SimulationID: sim.ID, Page: 1, PageSize: scanPageSize}
+	agents, total, err := h.agents.ListAgents(ctx, f)
+	if err != nil {
+		h.serviceError(c, err)
+		return
+	}
+
+	fc := newCollection(c,
+		jsonstream.Member{Key: "simulation_id", Value: sim.ID},
+		jsonstream.Member{Key: "generated_at", Value: time.Now().UTC()},
+		jsonstream.Member{Key: "total", Value: total},
+	)
+	for written := 0; ; {
+		for i := range agents {
+			fc.Add(newFeature(&agents[i]))
+		}
+		written += len(agents)
+		if fc.Err() != nil || len(agents) < f.PageSize || written >= total {
+			break
+		}
+		f.Page++
+		if agents, _, err = h.agents.ListAgents(ctx, f); err != nil {
+			// O cabeçalho já foi enviado: o documento sai marcado como
+			// incompleto.
+			logging.FromContext(ctx).WithError(err).WithField("simulation_id", sim.ID).Error("Erro ao listar agentes da simulação em GeoJSON")
+			h.report(c, fc, fc.Abort("internal error"))
+			return
+		}
+	}
+	h.finish(c, fc)
+}
+
+// finish fecha a coleção como completa.
+func (h *Handler) finish(c *gin.Context, fc *jsonstream.Writer) {
+	h.report(c, fc, fc.Close())
+}
+
+// report registra uma falha de escrita (cliente desconectado) ou um item
+// que não pôde ser codificado; ela não chega ao cliente como erro HTTP,
+// pois a resposta já começou.
+func (h *Handler) report(c *gin.Context, fc *jsonstream.Writer, err error) {
+	if err != nil {
+		logging.FromContext(c.Request.Context()).WithError(err).WithField("features", fc.Count()).Warn("GeoJSON interrompido")
+	}
+}
+
+func (h *Handler) serviceError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, agent.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, agent.ErrValidation):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		logging.FromContext(c.Request.Context()).WithError(err).Error("Erro no handler de posições")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+	}
+}
+
+// filterFrom lê os filtros da listagem de agentes (type, status,
+// simulation_id, project_id, tags).
+func filterFrom(c *gin.Context) agent.Filter {
+	f := agent.Filter{
+		Type:         c.Query("type"),
+		Status:       c.Query("status"),
+		SimulationID: c.Query("simulation_id"),
+		ProjectID:    c.Query("project_id"),
+	}
+	if tags := c.Query("tags"); tags != "" {
+		for _, t := range strings.Split(tags, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				f.Tags = append(f.Tags, t)
+			}
+		}
+	}
+	return f
+}
+
+// intQuery lê um inteiro positivo opcional, limitado a limit.
+func intQuery(c *gin.Context, name string, dst *int, limit int) bool {
+	v := c.Query(name)
+	if v == "" {
+		return true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + name + ": " + v})
+		return false
+	}
+	*dst = min(n, limit)
+	return true
+}
+
+// floatQuery lê um número obrigatório no intervalo [lo, hi].
+func floatQuery(c *gin.Context, name string, lo, hi float64) (float64, bool) {
+	v := c.Query(name)
+	if v == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": name + " is required"})
+		return 0, false
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(n) || n < lo || n > hi {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + name + ": " + v})
+		return 0, false
+	}
+	return n, true
+}
@@ -0,0 +1,5 @@
+// Package graph expõe o endpoint GraphQL do agent-service. generated.go e
+// models_gen.go são gerados pelo gqlgen a partir de schema.graphqls.
+package graph
+
+//go:generate go run github.com/99designs/gqlgen generate
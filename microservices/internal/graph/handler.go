@@ -0,0 +1,92 @@
+package graph
+
+import (
+	"context"
+	"errors"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/extension"
+	"github.com/99designs/gqlgen/graphql/handler/lru"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/gin-gonic/gin"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/logging"
+)
+
+// Options configura o endpoint GraphQL.
+type Options struct {
+	Agents AgentService
+	Events EventSource
+	// MaxDepth limita o aninhamento de campos de uma operação.
+	MaxDepth int
+	// MaxComplexity limita o custo estimado de uma operação; listas custam
+	// o custo dos filhos vezes o tamanho da página.
+	MaxComplexity int
+	// Introspection habilita __schema e __type (GraphiQL, geração de tipos).
+	Introspection bool
+}
+
+// Handler cria o endpoint GraphQL, servido em GET e POST.
+func Handler(opts Options) gin.HandlerFunc {
+	cfg := Config{Resolvers: &Resolver{agents: opts.Agents, events: opts.Events}}
+	cfg.Complexity.Query.Agents = func(child int, _, _, _, _ *string, _ []string, _, pageSize *int) int {
+		return listComplexity(child, pageSize, defaultPageSize, maxPageSize)
+	}
+	cfg.Complexity.Query.Simulations = func(child int, _, pageSize *int) int {
+		return listComplexity(child, pageSize, defaultPageSize, maxPageSize)
+	}
+	cfg.Complexity.Query.Events = func(child int, _, _ *string, limit *int) int {
+		return listComplexity(child, limit, 50, maxEvents)
+	}
+	cfg.Complexity.Simulation.Agents = func(child int, _, _ *string, _, pageSize *int) int {
+		return listComplexity(child, pageSize, defaultPageSize, maxPageSize)
+	}
+
+	srv := handler.New(NewExecutableSchema(cfg))
+	srv.AddTransport(transport.GET{})
+	srv.AddTransport(transport.POST{})
+	srv.SetQueryCache(lru.New(1000))
+	if opts.Introspection {
+		srv.Use(extension.Introspection{})
+	}
+	srv.Use(depthLimit(opts.MaxDepth))
+	srv.Use(extension.FixedComplexityLimit(opts.MaxComplexity))
+	srv.SetRecoverFunc(func(ctx context.Context, r interface{}) error {
+		logging.FromContext(ctx).WithField("panic", r).Error("Panic recuperado em resolver GraphQL")
+		return gqlerror.Errorf("internal error")
+	})
+
+	return func(c *gin.Context) {
+		ctx := withLoaders(c.Request.Context(), opts.Agents)
+		srv.ServeHTTP(c.Writer, c.Request.WithContext(ctx))
+	}
+}
+
+// toError traduz os erros do serviço com os mesmos critérios das respostas
+// HTTP (404 → NOT_FOUND, 400 → BAD_USER_INPUT) e oculta os erros internos,
+// que são registrados no log.
+func toError(ctx context.Context, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, agent.ErrNotFound):
+		return withCode(ctx, err.Error(), "NOT_FOUND")
+	case errors.Is(err, agent.ErrValidation):
+		return withCode(ctx, err.Error(), "BAD_USER_INPUT")
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return err
+	}
+	logging.FromContext(ctx).WithError(err).WithField("graphql_path", graphql.GetPath(ctx).String()).Error("Erro interno em resolver GraphQL")
+	return withCode(ctx, "internal error", "INTERNAL")
+}
+
+func withCode(ctx context.Context, message, code string) *gqlerror.Error {
+	return &gqlerror.Error{
+		Message:    message,
+		Path:       graphql.GetPath(ctx),
+		Extensions: map[string]interface{}{"code": code},
+	}
+}
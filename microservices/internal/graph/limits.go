@@ -0,0 +1,65 @@
+package graph
+
+import (
+	"context"
+	"strings"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+// depthLimit rejeita operações cuja seleção aninha mais campos que o limite.
+// Campos de introspecção (__schema, __type) não contam: a própria consulta de
+// introspecção é profunda, mas limitada pelo esquema.
+type depthLimit int
+
+var _ interface {
+	graphql.HandlerExtension
+	graphql.OperationContextMutator
+} = depthLimit(0)
+
+func (depthLimit) ExtensionName() string { return "DepthLimit" }
+
+func (depthLimit) Validate(graphql.ExecutableSchema) error { return nil }
+
+func (d depthLimit) MutateOperationContext(_ context.Context, rc *graphql.OperationContext) *gqlerror.Error {
+	if depth := selectionDepth(rc.Operation.SelectionSet); depth > int(d) {
+		err := gqlerror.Errorf("query depth %d exceeds the limit of %d", depth, int(d))
+		err.Extensions = map[string]interface{}{"code": "DEPTH_LIMIT_EXCEEDED"}
+		return err
+	}
+	return nil
+}
+
+func selectionDepth(set ast.SelectionSet) int {
+	depth := 0
+	for _, sel := range set {
+		var d int
+		switch s := sel.(type) {
+		case *ast.Field:
+			if strings.HasPrefix(s.Name, "__") {
+				continue
+			}
+			d = 1 + selectionDepth(s.SelectionSet)
+		case *ast.InlineFragment:
+			d = selectionDepth(s.SelectionSet)
+		case *ast.FragmentSpread:
+			if s.Definition != nil {
+				d = selectionDepth(s.Definition.SelectionSet)
+			}
+		}
+		depth = max(depth, d)
+	}
+	return depth
+}
+
+// listComplexity multiplica o custo dos filhos pelo tamanho da página pedida,
+// para que o limite de complexidade reflita o número de itens retornados.
+func listComplexity(childComplexity int, size *int, fallback, limit int) int {
+	n := fallback
+	if size != nil && *size > 0 {
+		n = min(*size, limit)
+	}
+	return 1 + childComplexity*n
+}
@@ -0,0 +1,79 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/graph-gophers/dataloader/v7"
+
+	"smart-city-microservices/internal/agent"
+)
+
+// loaderWait é quanto cada loader espera para juntar as chaves pedidas pelos
+// resolvers de uma mesma lista antes de buscar.
+const loaderWait = 2 * time.Millisecond
+
+// fetchParallelism limita as buscas simultâneas de um lote.
+const fetchParallelism = 8
+
+type loadersKey struct{}
+
+// loaders agrupa as buscas feitas pelos resolvers de campo de uma requisição.
+// Cada id é buscado uma única vez por requisição, por mais que apareça em
+// várias linhas: uma lista de agentes da mesma simulação resolve
+// Agent.simulation com uma só busca, em vez de uma por agente.
+type loaders struct {
+	simulations *dataloader.Loader[string, *agent.Simulation]
+	performance *dataloader.Loader[string, *agent.Performance]
+}
+
+// withLoaders associa ao contexto loaders novos, válidos só nesta requisição.
+func withLoaders(ctx context.Context, svc AgentService) context.Context {
+	return context.WithValue(ctx, loadersKey{}, &loaders{
+		simulations: dataloader.NewBatchedLoader(
+			batch(func(ctx context.Context, id string) (*agent.Simulation, error) {
+				sim, err := svc.GetSimulation(ctx, id)
+				if errors.Is(err, agent.ErrNotFound) {
+					return nil, nil
+				}
+				return sim, err
+			}),
+			dataloader.WithWait[string, *agent.Simulation](loaderWait),
+		),
+		performance: dataloader.NewBatchedLoader(
+			batch(svc.GetPerformance),
+			dataloader.WithWait[string, *agent.Performance](loaderWait),
+		),
+	})
+}
+
+func loadersFrom(ctx context.Context) *loaders {
+	return ctx.Value(loadersKey{}).(*loaders)
+}
+
+// batch adapta uma busca por id à função de lote do dataloader. O serviço não
+// tem busca por vários ids; as chaves do lote, já sem repetição, são buscadas
+// em paralelo.
+func batch[V any](fetch func(ctx context.Context, id string) (V, error)) dataloader.BatchFunc[string, V] {
+	return func(ctx context.Context, keys []string) []*dataloader.Result[V] {
+		results := make([]*dataloader.Result[V], len(keys))
+		sem := make(chan struct{}, fetchParallelism)
+		var wg sync.WaitGroup
+		for i, key := range keys {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(i int, key string) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				v, err := fetch(ctx, key)
+				results[i] = &dataloader.Result[V]{Data: v, Error: err}
+			}(i, key)
+		}
+		wg.Wait()
+		return results
+	}
+}
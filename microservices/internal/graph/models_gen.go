@@ -0,0 +1,26 @@
+// Code generated by github.com/99designs/gqlgen, DO NOT EDIT.
+
+package graph
+
+import (
+	"smart-city-microservices/internal/agent"
+)
+
+type AgentConnection struct {
+	Nodes    []agent.Agent `json:"nodes"`
+	Total    int           `json:"total"`
+	Page     int           `json:"page"`
+	PageSize int           `json:"pageSize"`
+}
+
+type PerformanceSample struct {
+	Metric string  `json:"metric"`
+	Value  float64 `json:"value"`
+}
+
+type SimulationConnection struct {
+	Nodes    []agent.Simulation `json:"nodes"`
+	Total    int                `json:"total"`
+	Page     int                `json:"page"`
+	PageSize int                `json:"pageSize"`
+}
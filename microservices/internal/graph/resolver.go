@@ -0,0 +1,66 @@
+package graph
+
+import (
+	"context"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/events"
+)
+
+// Limites de paginação, os mesmos da API REST.
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+	maxEvents       = 500
+)
+
+// AgentService é o subconjunto de agent.Service usado pelos resolvers.
+type AgentService interface {
+	ListAgents(ctx context.Context, f agent.Filter) ([]agent.Agent, int, error)
+	GetAgent(ctx context.Context, id string) (*agent.Agent, error)
+	CreateAgent(ctx context.Context, req agent.CreateAgentRequest) (*agent.Agent, error)
+	UpdateAgent(ctx context.Context, id string, req agent.UpdateAgentRequest) (*agent.Agent, error)
+	DeleteAgent(ctx context.Context, id string) error
+	GetPerformance(ctx context.Context, id string) (*agent.Performance, error)
+	ListSimulations(ctx context.Context, page, pageSize int) ([]agent.Simulation, int, error)
+	GetSimulation(ctx context.Context, id string) (*agent.Simulation, error)
+}
+
+// EventSource fornece os eventos recentes consultados em Query.events.
+type EventSource interface {
+	List(topic, eventType string, limit int) []events.Event
+}
+
+// Resolver é a raiz dos resolvers. Os loaders de cada requisição ficam no
+// contexto (ver withLoaders).
+type Resolver struct {
+	agents AgentService
+	events EventSource
+}
+
+func pagination(page, pageSize *int) (int, int) {
+	p, size := 1, defaultPageSize
+	if page != nil && *page > 0 {
+		p = *page
+	}
+	if pageSize != nil && *pageSize > 0 {
+		size = min(*pageSize, maxPageSize)
+	}
+	return p, size
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func (r *Resolver) listAgents(ctx context.Context, f agent.Filter, page, pageSize *int) (*AgentConnection, error) {
+	f.Page, f.PageSize = pagination(page, pageSize)
+	agents, total, err := r.agents.ListAgents(ctx, f)
+	if err != nil {
+		return nil, toError(ctx, err)
+	}
+	return &AgentConnection{Nodes: agents, Total: total, Page: f.Page, PageSize: f.PageSize}, nil
+}
@@ -0,0 +1,131 @@
+package graph
+
+// This file will be automatically regenerated based on the schema, any resolver implementations
+// will be copied through when generating and any unknown code will be moved to the end.
+// Code generated by github.com/99designs/gqlgen version v0.17.40
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/events"
+)
+
+// Simulation is the resolver for the simulation field.
+func (r *agentResolver) Simulation(ctx context.Context, obj *agent.Agent) (*agent.Simulation, error) {
+	if obj.SimulationID == "" {
+		return nil, nil
+	}
+	sim, err := loadersFrom(ctx).simulations.Load(ctx, obj.SimulationID)()
+	return sim, toError(ctx, err)
+}
+
+// Performance is the resolver for the performance field.
+func (r *agentResolver) Performance(ctx context.Context, obj *agent.Agent) ([]PerformanceSample, error) {
+	perf, err := loadersFrom(ctx).performance.Load(ctx, obj.ID)()
+	if err != nil {
+		return nil, toError(ctx, err)
+	}
+	samples := make([]PerformanceSample, 0, len(perf.Metrics))
+	for name, value := range perf.Metrics {
+		samples = append(samples, PerformanceSample{Metric: name, Value: value})
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].Metric < samples[j].Metric })
+	return samples, nil
+}
+
+// CreateAgent is the resolver for the createAgent field.
+func (r *mutationResolver) CreateAgent(ctx context.Context, input agent.CreateAgentRequest) (*agent.Agent, error) {
+	a, err := r.agents.CreateAgent(ctx, input)
+	return a, toError(ctx, err)
+}
+
+// UpdateAgent is the resolver for the updateAgent field.
+func (r *mutationResolver) UpdateAgent(ctx context.Context, id string, input agent.UpdateAgentRequest) (*agent.Agent, error) {
+	a, err := r.agents.UpdateAgent(ctx, id, input)
+	return a, toError(ctx, err)
+}
+
+// DeleteAgent is the resolver for the deleteAgent field.
+func (r *mutationResolver) DeleteAgent(ctx context.Context, id string) (bool, error) {
+	if err := r.agents.DeleteAgent(ctx, id); err != nil {
+		return false, toError(ctx, err)
+	}
+	return true, nil
+}
+
+// Agent is the resolver for the agent field.
+func (r *queryResolver) Agent(ctx context.Context, id string) (*agent.Agent, error) {
+	a, err := r.agents.GetAgent(ctx, id)
+	if errors.Is(err, agent.ErrNotFound) {
+		return nil, nil
+	}
+	return a, toError(ctx, err)
+}
+
+// Agents is the resolver for the agents field.
+func (r *queryResolver) Agents(ctx context.Context, typeArg *string, status *string, simulationID *string, projectID *string, tags []string, page *int, pageSize *int) (*AgentConnection, error) {
+	return r.listAgents(ctx, agent.Filter{
+		Type:         deref(typeArg),
+		Status:       deref(status),
+		SimulationID: deref(simulationID),
+		ProjectID:    deref(projectID),
+		Tags:         tags,
+	}, page, pageSize)
+}
+
+// Simulation is the resolver for the simulation field.
+func (r *queryResolver) Simulation(ctx context.Context, id string) (*agent.Simulation, error) {
+	sim, err := r.agents.GetSimulation(ctx, id)
+	if errors.Is(err, agent.ErrNotFound) {
+		return nil, nil
+	}
+	return sim, toError(ctx, err)
+}
+
+// Simulations is the resolver for the simulations field.
+func (r *queryResolver) Simulations(ctx context.Context, page *int, pageSize *int) (*SimulationConnection, error) {
+	p, size := pagination(page, pageSize)
+	sims, total, err := r.agents.ListSimulations(ctx, p, size)
+	if err != nil {
+		return nil, toError(ctx, err)
+	}
+	return &SimulationConnection{Nodes: sims, Total: total, Page: p, PageSize: size}, nil
+}
+
+// Events is the resolver for the events field.
+func (r *queryResolver) Events(ctx context.Context, topic *string, typeArg *string, limit *int) ([]events.Event, error) {
+	n := 50
+	if limit != nil && *limit > 0 {
+		n = min(*limit, maxEvents)
+	}
+	return r.events.List(deref(topic), deref(typeArg), n), nil
+}
+
+// Agents is the resolver for the agents field.
+func (r *simulationResolver) Agents(ctx context.Context, obj *agent.Simulation, typeArg *string, status *string, page *int, pageSize *int) (*AgentConnection, error) {
+	return r.listAgents(ctx, agent.Filter{
+		Type:         deref(typeArg),
+		Status:       deref(status),
+		SimulationID: obj.ID,
+	}, page, pageSize)
+}
+
+// Agent returns AgentResolver implementation.
+func (r *Resolver) Agent() AgentResolver { return &agentResolver{r} }
+
+// Mutation returns MutationResolver implementation.
+func (r *Resolver) Mutation() MutationResolver { return &mutationResolver{r} }
+
+// Query returns QueryResolver implementation.
+func (r *Resolver) Query() QueryResolver { return &queryResolver{r} }
+
+// Simulation returns SimulationResolver implementation.
+func (r *Resolver) Simulation() SimulationResolver { return &simulationResolver{r} }
+
+type agentResolver struct{ *Resolver }
+type mutationResolver struct{ *Resolver }
+type queryResolver struct{ *Resolver }
+type simulationResolver struct{ *Resolver }
@@ -0,0 +1,102 @@
+// Package group mantém grupos de agentes de um projeto, ex.: a frota do
+// turno da noite, e as operações sobre todos os membros de uma vez: iniciar,
+// parar e executar uma ação, esta pelo mesmo caminho de
+// POST /agents/actions/batch.
+package group
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrNotFound indica que o grupo não existe.
+	ErrNotFound = errors.New("group not found")
+	// ErrNameTaken indica que o projeto já tem um grupo com o nome.
+	ErrNameTaken = errors.New("group name already in use in the project")
+)
+
+// Eventos publicados no tópico do grupo (events.GroupTopic).
+const (
+	EventMembersAdded   = "group.members_added"
+	EventMembersRemoved = "group.members_removed"
+	EventDispatched     = "group.action_dispatched"
+)
+
+// Operações sobre os membros, em group.action_dispatched.
+const (
+	OperationStart  = "start"
+	OperationStop   = "stop"
+	OperationAction = "action"
+)
+
+// Group é um grupo de agentes de um projeto.
+type Group struct {
+	ID          string    `json:"id"`
+	ProjectID   string    `json:"project_id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Members     int       `json:"members"`
+	CreatedBy   string    `json:"created_by,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Member é um agente do grupo.
+type Member struct {
+	AgentID string    `json:"agent_id"`
+	AddedBy string    `json:"added_by,omitempty"`
+	AddedAt time.Time `json:"added_at"`
+}
+
+// CreateRequest é o corpo de POST /groups.
+type CreateRequest struct {
+	ProjectID   string `json:"project_id" binding:"required"`
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description"`
+}
+
+// UpdateRequest é o corpo de PUT /groups/:id; campos ausentes não mudam. O
+// projeto não muda: os membros são todos dele.
+type UpdateRequest struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+}
+
+// MembersRequest é o corpo de POST /groups/:id/members e de
+// DELETE /groups/:id/members.
+type MembersRequest struct {
+	AgentIDs []string `json:"agent_ids" binding:"required"`
+}
+
+func validName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return errors.New("name is required")
+	}
+	if len(name) > 255 {
+		return errors.New("name must have at most 255 characters")
+	}
+	return nil
+}
+
+// validIDs confere os ids e remove os repetidos, mantendo a ordem.
+func validIDs(ids []string) ([]string, error) {
+	if len(ids) == 0 {
+		return nil, errors.New("agent_ids must not be empty")
+	}
+	seen := make(map[string]bool, len(ids))
+	out := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if _, err := uuid.Parse(id); err != nil {
+			return nil, errors.New("invalid agent id: " + id)
+		}
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out, nil
+}
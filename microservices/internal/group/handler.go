@@ -0,0 +1,522 @@
+package group
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/action"
+	"smart-city-microservices/internal/actionbatch"
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/audit"
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/dryrun"
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/i18n"
+	"smart-city-microservices/internal/logging"
+)
+
+// AgentService é o subconjunto de agent.Service usado pelos grupos.
+type AgentService interface {
+	GetAgent(ctx context.Context, id string) (*agent.Agent, error)
+	UpdateAgent(ctx context.Context, id string, req agent.UpdateAgentRequest) (*agent.Agent, error)
+}
+
+// BatchSubmitter aceita e ensaia lotes de ações; *actionbatch.Runner o
+// implementa.
+type BatchSubmitter interface {
+	Submit(ctx context.Context, req actionbatch.Request) (*actionbatch.Batch, error)
+	Plan(ctx context.Context, req actionbatch.Request) (*actionbatch.Plan, error)
+}
+
+// Config configura os grupos.
+type Config struct {
+	// MaxMembers limita os agentes de um grupo.
+	MaxMembers int
+	// StartStatus e StopStatus são os status dados aos membros ao iniciar e
+	// ao parar o grupo.
+	StartStatus string
+	StopStatus  string
+}
+
+// Handler expõe o CRUD de grupos, os membros e as operações sobre eles.
+// Todo acesso exige que o grupo seja de um projeto do principal. A remoção,
+// os membros e as operações aceitam X-Dry-Run: true (ver dryrun).
+type Handler struct {
+	repo      *Repository
+	agents    AgentService
+	batches   BatchSubmitter
+	publisher events.Publisher
+	cfg       Config
+}
+
+// NewHandler cria o handler de grupos.
+func NewHandler(repo *Repository, agents AgentService, batches BatchSubmitter, publisher events.Publisher, cfg Config) *Handler {
+	return &Handler{repo: repo, agents: agents, batches: batches, publisher: publisher, cfg: cfg}
+}
+
+// ActionRequest é o corpo de POST /groups/:id/actions: a ação executada em
+// cada membro, como em POST /agents/actions/batch.
+type ActionRequest struct {
+	Action   string                 `json:"action" binding:"required"`
+	Params   map[string]interface{} `json:"params"`
+	Priority string                 `json:"priority"`
+}
+
+// List retorna os grupos dos projetos do principal, opcionalmente só os de
+// ?project_id=.
+func (h *Handler) List(c *gin.Context) {
+	p := auth.FromGin(c)
+	var projects []string
+	if p != nil && len(p.Projects) > 0 && !p.HasRole(auth.RoleAdmin) {
+		projects = p.Projects
+	}
+	if id := c.Query("project_id"); id != "" {
+		if !p.InProject(id) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "project " + id + " is not accessible"})
+			return
+		}
+		projects = []string{id}
+	}
+	list, err := h.repo.List(c.Request.Context(), projects)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": list})
+}
+
+// Get retorna um grupo.
+func (h *Handler) Get(c *gin.Context) {
+	if g := h.load(c); g != nil {
+		c.JSON(http.StatusOK, g)
+	}
+}
+
+// Create cadastra um grupo vazio num projeto do principal.
+func (h *Handler) Create(c *gin.Context) {
+	var req CreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		i18n.BindError(c, err)
+		return
+	}
+	if err := validName(req.Name); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	p := auth.FromGin(c)
+	if !p.InProject(req.ProjectID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "project " + req.ProjectID + " is not accessible"})
+		return
+	}
+	g := &Group{ProjectID: req.ProjectID, Name: req.Name, Description: req.Description}
+	if p != nil {
+		g.CreatedBy = p.Subject
+	}
+	if err := h.repo.Create(c.Request.Context(), g); err != nil {
+		h.serviceError(c, err)
+		return
+	}
+	audit.Record(c.Request.Context(), "group.created", logrus.Fields{"group_id": g.ID, "project_id": g.ProjectID, "name": g.Name})
+	c.Header("Location", "/api/v1/groups/"+g.ID)
+	c.JSON(http.StatusCreated, g)
+}
+
+// Update altera o nome e a descrição do grupo.
+func (h *Handler) Update(c *gin.Context) {
+	var req UpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		i18n.BindError(c, err)
+		return
+	}
+	g := h.load(c)
+	if g == nil {
+		return
+	}
+	if req.Name != nil {
+		if err := validName(*req.Name); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		g.Name = *req.Name
+	}
+	if req.Description != nil {
+		g.Description = *req.Description
+	}
+	if err := h.repo.Update(c.Request.Context(), g); err != nil {
+		h.serviceError(c, err)
+		return
+	}
+	audit.Record(c.Request.Context(), "group.updated", logrus.Fields{"group_id": g.ID, "name": g.Name})
+	c.JSON(http.StatusOK, g)
+}
+
+// Delete remove o grupo; os agentes continuam existindo.
+func (h *Handler) Delete(c *gin.Context) {
+	g := h.load(c)
+	if g == nil {
+		return
+	}
+	ctx := c.Request.Context()
+	if dryrun.Enabled(ctx) {
+		members, err := h.repo.Members(ctx, g.ID)
+		if err != nil {
+			h.internalError(c, err)
+			return
+		}
+		ids := make([]string, len(members))
+		for i, m := range members {
+			ids[i] = m.AgentID
+		}
+		if err := h.repo.Delete(ctx, g.ID); err != nil {
+			h.serviceError(c, err)
+			return
+		}
+		dryrun.JSON(c, gin.H{"group_id": g.ID, "deleted": g, "members_removed": ids})
+		return
+	}
+	if err := h.repo.Delete(ctx, g.ID); err != nil {
+		h.serviceError(c, err)
+		return
+	}
+	audit.Record(c.Request.Context(), "group.deleted", logrus.Fields{"group_id": g.ID, "members": g.Members})
+	c.Status(http.StatusNoContent)
+}
+
+// Members lista os membros do grupo.
+func (h *Handler) Members(c *gin.Context) {
+	g := h.load(c)
+	if g == nil {
+		return
+	}
+	members, err := h.repo.Members(c.Request.Context(), g.ID)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"group_id": g.ID, "data": members})
+}
+
+// AddMembers inclui agentes no grupo. Todos precisam existir e ser do
+// projeto do grupo; do contrário, nenhum é incluído e a resposta 422 traz
+// os recusados. Os que já eram membros são ignorados.
+func (h *Handler) AddMembers(c *gin.Context) {
+	var req MembersRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		i18n.BindError(c, err)
+		return
+	}
+	ids, err := validIDs(req.AgentIDs)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(ids) > h.cfg.MaxMembers {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "a group has at most " + strconv.Itoa(h.cfg.MaxMembers) + " members"})
+		return
+	}
+	g := h.load(c)
+	if g == nil {
+		return
+	}
+	ctx := c.Request.Context()
+	if _, ok := h.resolve(c, g, ids, http.StatusUnprocessableEntity); !ok {
+		return
+	}
+	addedBy := ""
+	if p := auth.FromGin(c); p != nil {
+		addedBy = p.Subject
+	}
+	added, full, err := h.repo.AddMembers(ctx, g.ID, ids, addedBy, h.cfg.MaxMembers)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	if full {
+		c.JSON(http.StatusConflict, gin.H{"error": "a group has at most " + strconv.Itoa(h.cfg.MaxMembers) + " members"})
+		return
+	}
+	if dryrun.Enabled(ctx) {
+		dryrun.JSON(c, gin.H{"group_id": g.ID, "added": added})
+		return
+	}
+	if len(added) > 0 {
+		h.publish(ctx, g, EventMembersAdded, events.GroupMembersV1{GroupID: g.ID, ProjectID: g.ProjectID, AgentIDs: added})
+		audit.Record(ctx, "group.members_added", logrus.Fields{"group_id": g.ID, "agent_ids": added})
+	}
+	c.JSON(http.StatusOK, gin.H{"group_id": g.ID, "added": added})
+}
+
+// RemoveMember tira um agente do grupo.
+func (h *Handler) RemoveMember(c *gin.Context) {
+	g := h.load(c)
+	if g == nil {
+		return
+	}
+	ctx := c.Request.Context()
+	ids, err := validIDs([]string{c.Param("agent_id")})
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "member not found"})
+		return
+	}
+	removed, err := h.repo.RemoveMembers(ctx, g.ID, ids)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	if len(removed) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "member not found"})
+		return
+	}
+	if dryrun.Enabled(ctx) {
+		dryrun.JSON(c, gin.H{"group_id": g.ID, "removed": removed})
+		return
+	}
+	h.publish(ctx, g, EventMembersRemoved, events.GroupMembersV1{GroupID: g.ID, ProjectID: g.ProjectID, AgentIDs: removed})
+	audit.Record(ctx, "group.members_removed", logrus.Fields{"group_id": g.ID, "agent_ids": removed})
+	c.Status(http.StatusNoContent)
+}
+
+// Start dá a todos os membros o status de início.
+func (h *Handler) Start(c *gin.Context) {
+	h.setStatus(c, OperationStart, h.cfg.StartStatus)
+}
+
+// Stop dá a todos os membros o status de parada.
+func (h *Handler) Stop(c *gin.Context) {
+	h.setStatus(c, OperationStop, h.cfg.StopStatus)
+}
+
+// setStatus muda o status de todos os membros, ou de nenhum: se uma
+// alteração falha, as já feitas voltam ao status anterior. No ensaio,
+// nenhum membro é alterado e a resposta lista as mudanças.
+func (h *Handler) setStatus(c *gin.Context, operation, status string) {
+	g := h.load(c)
+	if g == nil {
+		return
+	}
+	ctx := c.Request.Context()
+	members, ok := h.members(c, g)
+	if !ok {
+		return
+	}
+	if dryrun.Enabled(ctx) {
+		changes := []gin.H{}
+		for _, a := range members {
+			if a.Status != status {
+				changes = append(changes, gin.H{"agent_id": a.ID, "from": a.Status, "to": status})
+			}
+		}
+		dryrun.JSON(c, gin.H{
+			"group_id": g.ID, "operation": operation, "status": status, "agents": len(members), "changed": len(changes), "changes": changes,
+		})
+		return
+	}
+	var done []statusChange
+	for _, a := range members {
+		if a.Status == status {
+			continue
+		}
+		_, err := h.agents.UpdateAgent(ctx, a.ID, agent.UpdateAgentRequest{Status: &status})
+		if err == nil {
+			done = append(done, statusChange{agentID: a.ID, previous: a.Status})
+			continue
+		}
+		h.rollback(ctx, g, done)
+		switch {
+		case errors.Is(err, agent.ErrNotFound):
+			c.JSON(http.StatusConflict, gin.H{"error": "member " + a.ID + " no longer exists; no member was changed"})
+		case errors.Is(err, agent.ErrValidation):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "member " + a.ID + ": " + err.Error() + "; no member was changed"})
+		default:
+			h.internalError(c, err)
+		}
+		return
+	}
+	h.publish(ctx, g, EventDispatched, events.GroupActionV1{
+		GroupID: g.ID, ProjectID: g.ProjectID, Operation: operation, Status: status, Agents: len(members),
+	})
+	audit.Record(ctx, "group."+operation, logrus.Fields{"group_id": g.ID, "status": status, "changed": len(done)})
+	c.JSON(http.StatusOK, gin.H{"group_id": g.ID, "operation": operation, "status": status, "agents": len(members), "changed": len(done)})
+}
+
+type statusChange struct{ agentID, previous string }
+
+// rollback devolve os membros já alterados ao status anterior, mesmo com a
+// requisição cancelada; as falhas ficam no log.
+func (h *Handler) rollback(ctx context.Context, g *Group, done []statusChange) {
+	ctx = context.WithoutCancel(ctx)
+	for _, ch := range done {
+		previous := ch.previous
+		if _, err := h.agents.UpdateAgent(ctx, ch.agentID, agent.UpdateAgentRequest{Status: &previous}); err != nil {
+			logging.FromContext(ctx).WithError(err).WithFields(logrus.Fields{"group_id": g.ID, "agent_id": ch.agentID}).
+				Error("Falha ao desfazer a mudança de status de membro do grupo")
+		}
+	}
+}
+
+// Action executa a ação em todos os membros como um lote de ações; a
+// resposta 202 traz o lote, acompanhado em /action-batches/:id.
+func (h *Handler) Action(c *gin.Context) {
+	var req ActionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		i18n.BindError(c, err)
+		return
+	}
+	g := h.load(c)
+	if g == nil {
+		return
+	}
+	ctx := c.Request.Context()
+	members, ok := h.members(c, g)
+	if !ok {
+		return
+	}
+	ids := make([]string, len(members))
+	for i, a := range members {
+		ids[i] = a.ID
+	}
+	if dryrun.Enabled(ctx) {
+		h.planAction(c, g, actionbatch.Request{AgentIDs: ids, Action: req.Action, Params: req.Params, Priority: req.Priority})
+		return
+	}
+	b, err := h.batches.Submit(ctx, actionbatch.Request{AgentIDs: ids, Action: req.Action, Params: req.Params, Priority: req.Priority})
+	var invalid *actionbatch.ValidationError
+	var forbidden *action.ForbiddenError
+	switch {
+	case errors.As(err, &invalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.As(err, &forbidden):
+		c.JSON(http.StatusForbidden, action.ForbiddenBody(forbidden))
+		return
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	h.publish(ctx, g, EventDispatched, events.GroupActionV1{
+		GroupID: g.ID, ProjectID: g.ProjectID, Operation: OperationAction, Action: b.Action, BatchID: b.ID, Agents: b.Total,
+	})
+	audit.Record(ctx, "group.action_dispatched", logrus.Fields{
+		"group_id": g.ID, "batch_id": b.ID, "action": b.Action, "priority": b.Priority, "total": b.Total,
+	})
+	c.Header("Location", "/api/v1/action-batches/"+b.ID)
+	c.JSON(http.StatusAccepted, b)
+}
+
+// planAction responde o ensaio de Action: o lote que seria criado.
+func (h *Handler) planAction(c *gin.Context, g *Group, req actionbatch.Request) {
+	p, err := h.batches.Plan(c.Request.Context(), req)
+	var invalid *actionbatch.ValidationError
+	var forbidden *action.ForbiddenError
+	switch {
+	case errors.As(err, &invalid):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.As(err, &forbidden):
+		c.JSON(http.StatusForbidden, action.ForbiddenBody(forbidden))
+		return
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	dryrun.JSON(c, struct {
+		GroupID string `json:"group_id"`
+		*actionbatch.Plan
+	}{g.ID, p})
+}
+
+// load busca o grupo de :id e confere o projeto do principal. Responde e
+// retorna nil se o grupo não existe ou é de outro projeto.
+func (h *Handler) load(c *gin.Context) *Group {
+	g, err := h.repo.Get(c.Request.Context(), c.Param("id"))
+	if err == nil && !auth.FromGin(c).InProject(g.ProjectID) {
+		err = ErrNotFound
+	}
+	if err != nil {
+		h.serviceError(c, err)
+		return nil
+	}
+	return g
+}
+
+// members busca os agentes membros do grupo. Responde 409 e retorna false
+// se o grupo está vazio ou algum membro saiu do projeto do grupo: a
+// operação vale para todos ou para nenhum.
+func (h *Handler) members(c *gin.Context, g *Group) ([]agent.Agent, bool) {
+	list, err := h.repo.Members(c.Request.Context(), g.ID)
+	if err != nil {
+		h.internalError(c, err)
+		return nil, false
+	}
+	if len(list) == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "group has no members"})
+		return nil, false
+	}
+	ids := make([]string, len(list))
+	for i, m := range list {
+		ids[i] = m.AgentID
+	}
+	return h.resolve(c, g, ids, http.StatusConflict)
+}
+
+// resolve busca os agentes e confere que todos são do projeto do grupo. Se
+// algum não existe ou é de outro projeto, responde status com os ids
+// recusados e retorna false.
+func (h *Handler) resolve(c *gin.Context, g *Group, ids []string, status int) ([]agent.Agent, bool) {
+	ctx := c.Request.Context()
+	agents := make([]agent.Agent, 0, len(ids))
+	var missing, foreign []string
+	for _, id := range ids {
+		a, err := h.agents.GetAgent(ctx, id)
+		switch {
+		case errors.Is(err, agent.ErrNotFound) || (err == nil && a == nil):
+			missing = append(missing, id)
+		case err != nil:
+			h.internalError(c, err)
+			return nil, false
+		case a.ProjectID != g.ProjectID:
+			foreign = append(foreign, id)
+		default:
+			agents = append(agents, *a)
+		}
+	}
+	if len(missing) > 0 || len(foreign) > 0 {
+		body := gin.H{"error": "all agents must exist and belong to project " + g.ProjectID}
+		if len(missing) > 0 {
+			body["missing"] = missing
+		}
+		if len(foreign) > 0 {
+			body["other_project"] = foreign
+		}
+		c.JSON(status, body)
+		return nil, false
+	}
+	return agents, true
+}
+
+func (h *Handler) publish(ctx context.Context, g *Group, eventType string, data interface{}) {
+	h.publisher.Publish(ctx, events.New(events.GroupTopic(g.ID), eventType, data))
+}
+
+func (h *Handler) serviceError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrNameTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		h.internalError(c, err)
+	}
+}
+
+func (h *Handler) internalError(c *gin.Context, err error) {
+	logging.FromContext(c.Request.Context()).WithError(err).Error("Erro no handler de grupos")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
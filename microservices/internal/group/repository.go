@@ -0,0 +1,179 @@
+package group
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"smart-city-microservices/internal/instrument"
+)
+
+// Repository persiste os grupos e os membros no PostgreSQL.
+type Repository struct {
+	db *instrument.DB
+}
+
+// NewRepository cria o repositório.
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: instrument.NewDB(db)}
+}
+
+const groupColumns = `g.id, g.project_id, g.name, COALESCE(g.description, ''), COALESCE(g.created_by, ''),
+	g.created_at, g.updated_at, (SELECT COUNT(*) FROM group_memberships m WHERE m.group_id = g.id)`
+
+func scanGroup(row interface{ Scan(...interface{}) error }) (*Group, error) {
+	var g Group
+	err := row.Scan(&g.ID, &g.ProjectID, &g.Name, &g.Description, &g.CreatedBy, &g.CreatedAt, &g.UpdatedAt, &g.Members)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return &g, err
+}
+
+// Create insere o grupo e preenche id e datas.
+func (r *Repository) Create(ctx context.Context, g *Group) error {
+	err := r.db.QueryRow(ctx, "group.create", `
+		INSERT INTO groups (project_id, name, description, created_by)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''))
+		RETURNING id, created_at, updated_at`,
+		g.ProjectID, g.Name, g.Description, g.CreatedBy,
+	).Scan(&g.ID, &g.CreatedAt, &g.UpdatedAt)
+	return nameTaken(err)
+}
+
+// Get busca um grupo pelo id.
+func (r *Repository) Get(ctx context.Context, id string) (*Group, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrNotFound
+	}
+	return scanGroup(r.db.QueryRow(ctx, "group.get",
+		`SELECT `+groupColumns+` FROM groups g WHERE g.id = $1`, id))
+}
+
+// List retorna os grupos dos projetos informados, ou de todos com nil.
+func (r *Repository) List(ctx context.Context, projects []string) ([]*Group, error) {
+	rows, err := r.db.Query(ctx, "group.list", `
+		SELECT `+groupColumns+` FROM groups g
+		WHERE $1::text[] IS NULL OR g.project_id = ANY($1)
+		ORDER BY g.project_id, g.name`, pq.Array(projects))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []*Group{}
+	for rows.Next() {
+		g, err := scanGroup(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, g)
+	}
+	return out, rows.Err()
+}
+
+// Update grava nome e descrição.
+func (r *Repository) Update(ctx context.Context, g *Group) error {
+	err := r.db.QueryRow(ctx, "group.update", `
+		UPDATE groups SET name = $2, description = NULLIF($3, ''), updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING updated_at`, g.ID, g.Name, g.Description,
+	).Scan(&g.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return nameTaken(err)
+}
+
+// Delete remove o grupo e os vínculos com os membros; os agentes ficam.
+func (r *Repository) Delete(ctx context.Context, id string) error {
+	res, err := r.db.Exec(ctx, "group.delete", `DELETE FROM groups WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Members retorna os membros do grupo na ordem em que entraram.
+func (r *Repository) Members(ctx context.Context, groupID string) ([]Member, error) {
+	rows, err := r.db.Query(ctx, "group.members", `
+		SELECT agent_id, COALESCE(added_by, ''), added_at FROM group_memberships
+		WHERE group_id = $1
+		ORDER BY added_at, agent_id`, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []Member{}
+	for rows.Next() {
+		var m Member
+		if err := rows.Scan(&m.AgentID, &m.AddedBy, &m.AddedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+// AddMembers inclui os agentes no grupo num só comando e retorna os que
+// ainda não eram membros. Se o grupo passaria de max membros, nada é
+// incluído e full é true.
+func (r *Repository) AddMembers(ctx context.Context, groupID string, agentIDs []string, addedBy string, max int) (added []string, full bool, err error) {
+	var fresh int
+	err = r.db.QueryRow(ctx, "group.add_members", `
+		WITH fresh AS (
+			SELECT a.agent_id::uuid AS agent_id FROM unnest($2::text[]) AS a(agent_id)
+			WHERE NOT EXISTS (
+				SELECT 1 FROM group_memberships m WHERE m.group_id = $1 AND m.agent_id = a.agent_id::uuid
+			)
+		), inserted AS (
+			INSERT INTO group_memberships (group_id, agent_id, added_by)
+			SELECT $1, agent_id, NULLIF($3, '') FROM fresh
+			WHERE (SELECT COUNT(*) FROM group_memberships WHERE group_id = $1) + (SELECT COUNT(*) FROM fresh) <= $4
+			ON CONFLICT DO NOTHING
+			RETURNING agent_id
+		)
+		SELECT (SELECT COUNT(*) FROM fresh), COALESCE((SELECT array_agg(agent_id::text) FROM inserted), '{}')`,
+		groupID, pq.Array(agentIDs), addedBy, max,
+	).Scan(&fresh, (*pq.StringArray)(&added))
+	if err != nil {
+		return nil, false, err
+	}
+	return added, fresh > 0 && len(added) == 0, nil
+}
+
+// RemoveMembers tira os agentes do grupo e retorna os que eram membros.
+func (r *Repository) RemoveMembers(ctx context.Context, groupID string, agentIDs []string) ([]string, error) {
+	rows, err := r.db.Query(ctx, "group.remove_members", `
+		DELETE FROM group_memberships
+		WHERE group_id = $1 AND agent_id = ANY($2::uuid[])
+		RETURNING agent_id`, groupID, pq.Array(agentIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	removed := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		removed = append(removed, id)
+	}
+	return removed, rows.Err()
+}
+
+func nameTaken(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrNameTaken
+	}
+	return err
+}
@@ -0,0 +1,259 @@
+package grpcapi
+
+import (
+	"context"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/events"
+	agentv1 "smart-city-microservices/proto/agent/v1"
+)
+
+// Limites de paginação das listagens.
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+type agentServer struct {
+	agentv1.UnimplementedAgentServiceServer
+	svc      AgentService
+	events   Subscriber
+	onAction func(ctx context.Context, agentID string, req agent.ActionRequest)
+	done     <-chan struct{}
+}
+
+func (s *agentServer) ListAgents(ctx context.Context, req *agentv1.ListAgentsRequest) (*agentv1.ListAgentsResponse, error) {
+	page, pageSize := pagination(req.GetPage(), req.GetPageSize())
+	agents, total, err := s.svc.ListAgents(ctx, agent.Filter{
+		Type:         req.GetType(),
+		Status:       req.GetStatus(),
+		SimulationID: req.GetSimulationId(),
+		ProjectID:    req.GetProjectId(),
+		Tags:         req.GetTags(),
+		Page:         page,
+		PageSize:     pageSize,
+	})
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+
+	resp, err := ToListAgentsResponse(agents, total, page, pageSize)
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return resp, nil
+}
+
+func (s *agentServer) GetAgent(ctx context.Context, req *agentv1.GetAgentRequest) (*agentv1.Agent, error) {
+	if req.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "id é obrigatório")
+	}
+	a, err := s.svc.GetAgent(ctx, req.GetId())
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return s.reply(ctx, a)
+}
+
+func (s *agentServer) CreateAgent(ctx context.Context, req *agentv1.CreateAgentRequest) (*agentv1.Agent, error) {
+	a, err := s.svc.CreateAgent(ctx, agent.CreateAgentRequest{
+		SimulationID: req.GetSimulationId(),
+		ProjectID:    req.GetProjectId(),
+		Type:         req.GetType(),
+		Name:         req.GetName(),
+		Position:     fromPosition(req.GetPosition()),
+		State:        fromStruct(req.GetState()),
+		Metadata:     fromStruct(req.GetMetadata()),
+		Tags:         req.GetTags(),
+	})
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return s.reply(ctx, a)
+}
+
+func (s *agentServer) UpdateAgent(ctx context.Context, req *agentv1.UpdateAgentRequest) (*agentv1.Agent, error) {
+	if req.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "id é obrigatório")
+	}
+	update := agent.UpdateAgentRequest{
+		Name:     req.Name,
+		Status:   req.Status,
+		State:    fromStruct(req.GetState()),
+		Metadata: fromStruct(req.GetMetadata()),
+		Tags:     req.GetTags(),
+	}
+	if req.GetPosition() != nil {
+		p := fromPosition(req.GetPosition())
+		update.Position = &p
+	}
+	a, err := s.svc.UpdateAgent(ctx, req.GetId(), update)
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return s.reply(ctx, a)
+}
+
+func (s *agentServer) DeleteAgent(ctx context.Context, req *agentv1.DeleteAgentRequest) (*agentv1.DeleteAgentResponse, error) {
+	if req.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "id é obrigatório")
+	}
+	if err := s.svc.DeleteAgent(ctx, req.GetId()); err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return &agentv1.DeleteAgentResponse{}, nil
+}
+
+func (s *agentServer) ExecuteAction(ctx context.Context, req *agentv1.ExecuteActionRequest) (*agentv1.ActionResult, error) {
+	if req.GetId() == "" || req.GetAction() == "" {
+		return nil, status.Error(codes.InvalidArgument, "id e action são obrigatórios")
+	}
+	action := agent.ActionRequest{
+		Action: req.GetAction(),
+		Params: fromStruct(req.GetParams()),
+	}
+	result, err := s.svc.ExecuteAction(ctx, req.GetId(), action)
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	if s.onAction != nil {
+		s.onAction(ctx, req.GetId(), action)
+	}
+	data, err := toStruct(result.Result)
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return &agentv1.ActionResult{ActionId: result.ActionID, Status: result.Status, Result: data}, nil
+}
+
+func (s *agentServer) GetPerformance(ctx context.Context, req *agentv1.GetPerformanceRequest) (*agentv1.Performance, error) {
+	if req.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "id é obrigatório")
+	}
+	perf, err := s.svc.GetPerformance(ctx, req.GetId())
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return &agentv1.Performance{AgentId: perf.AgentID, Metrics: perf.Metrics}, nil
+}
+
+func (s *agentServer) reply(ctx context.Context, a *agent.Agent) (*agentv1.Agent, error) {
+	out, err := ToAgent(a)
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return out, nil
+}
+
+// watchBuffer é quantos eventos um stream pode acumular antes de ser
+// considerado lento e encerrado, para não segurar o Publish do barramento.
+const watchBuffer = 256
+
+func (s *agentServer) WatchAgentEvents(req *agentv1.WatchAgentEventsRequest, stream agentv1.AgentService_WatchAgentEventsServer) error {
+	if s.events == nil {
+		return status.Error(codes.Unavailable, "feed de eventos indisponível")
+	}
+	filter := newWatchFilter(req)
+	ctx := stream.Context()
+
+	ch := make(chan events.Event, watchBuffer)
+	overflow := make(chan struct{})
+	var overflowOnce sync.Once
+	unsubscribe := s.events.Subscribe(func(_ context.Context, e events.Event) {
+		if !filter.topic(e.Topic) {
+			return
+		}
+		select {
+		case ch <- e:
+		default:
+			overflowOnce.Do(func() { close(overflow) })
+		}
+	})
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.done:
+			return status.Error(codes.Unavailable, "servidor encerrando")
+		case <-overflow:
+			return status.Error(codes.ResourceExhausted, "cliente lento; eventos descartados")
+		case e := <-ch:
+			msg, err := ToAgentEvent(e)
+			if err != nil {
+				return toStatus(ctx, err)
+			}
+			if !filter.match(e.Type, msg.GetData()) {
+				continue
+			}
+			if err := stream.Send(msg); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+type watchFilter struct {
+	topics       map[string]bool
+	types        map[string]bool
+	agentID      string
+	simulationID string
+}
+
+func newWatchFilter(req *agentv1.WatchAgentEventsRequest) watchFilter {
+	f := watchFilter{
+		topics:       map[string]bool{},
+		types:        map[string]bool{},
+		agentID:      req.GetAgentId(),
+		simulationID: req.GetSimulationId(),
+	}
+	topics := req.GetTopics()
+	if len(topics) == 0 {
+		topics = []string{events.TopicAgents, events.TopicSimulations}
+	}
+	for _, t := range topics {
+		f.topics[t] = true
+	}
+	for _, t := range req.GetTypes() {
+		f.types[t] = true
+	}
+	return f
+}
+
+func (f watchFilter) topic(topic string) bool {
+	return f.topics[topic]
+}
+
+func (f watchFilter) match(eventType string, data *structpb.Struct) bool {
+	if len(f.types) > 0 && !f.types[eventType] {
+		return false
+	}
+	if f.agentID == "" && f.simulationID == "" {
+		return true
+	}
+	fields := data.AsMap()
+	if f.agentID != "" && fields["agent_id"] != f.agentID && fields["id"] != f.agentID {
+		return false
+	}
+	if f.simulationID != "" && fields["simulation_id"] != f.simulationID {
+		return false
+	}
+	return true
+}
+
+func pagination(page, pageSize int32) (int, int) {
+	p, size := int(page), int(pageSize)
+	if p < 1 {
+		p = 1
+	}
+	if size < 1 {
+		size = defaultPageSize
+	}
+	return p, min(size, maxPageSize)
+}
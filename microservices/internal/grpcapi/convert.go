@@ -0,0 +1,144 @@
+package grpcapi
+
+import (
+	"encoding/json"
+	"time"
+
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/events"
+	agentv1 "smart-city-microservices/proto/agent/v1"
+)
+
+// toStruct converte mapas e valores arbitrários (ex.: payloads de eventos)
+// em Struct. Valores que não são objetos ficam sob a chave "value".
+func toStruct(v interface{}) (*structpb.Struct, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if m, ok := v.(map[string]interface{}); ok {
+		return structpb.NewStruct(m)
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		return nil, err
+	}
+	m, ok := decoded.(map[string]interface{})
+	if !ok {
+		m = map[string]interface{}{"value": decoded}
+	}
+	return structpb.NewStruct(m)
+}
+
+func fromStruct(s *structpb.Struct) map[string]interface{} {
+	if s == nil {
+		return nil
+	}
+	return s.AsMap()
+}
+
+func toTimestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+func toTimestampPtr(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return toTimestamp(*t)
+}
+
+func toPosition(p agent.Position) *agentv1.Position {
+	return &agentv1.Position{Lat: p.Lat, Lon: p.Lon, Heading: p.Heading, Speed: p.Speed}
+}
+
+func fromPosition(p *agentv1.Position) agent.Position {
+	return agent.Position{Lat: p.GetLat(), Lon: p.GetLon(), Heading: p.GetHeading(), Speed: p.GetSpeed()}
+}
+
+// ToAgent converte um agente para a mensagem protobuf. É a mesma conversão
+// das respostas REST em application/x-protobuf.
+func ToAgent(a *agent.Agent) (*agentv1.Agent, error) {
+	state, err := toStruct(a.State)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := toStruct(a.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	return &agentv1.Agent{
+		Id:           a.ID,
+		SimulationId: a.SimulationID,
+		ProjectId:    a.ProjectID,
+		Type:         a.Type,
+		Name:         a.Name,
+		Status:       a.Status,
+		Position:     toPosition(a.Position),
+		Energy:       a.Energy,
+		State:        state,
+		Metadata:     metadata,
+		Tags:         a.Tags,
+		CreatedAt:    toTimestamp(a.CreatedAt),
+		UpdatedAt:    toTimestamp(a.UpdatedAt),
+	}, nil
+}
+
+// ToListAgentsResponse monta a página de agentes em protobuf.
+func ToListAgentsResponse(agents []agent.Agent, total, page, pageSize int) (*agentv1.ListAgentsResponse, error) {
+	resp := &agentv1.ListAgentsResponse{
+		Agents:   make([]*agentv1.Agent, 0, len(agents)),
+		Total:    int32(total),
+		Page:     int32(page),
+		PageSize: int32(pageSize),
+	}
+	for i := range agents {
+		a, err := ToAgent(&agents[i])
+		if err != nil {
+			return nil, err
+		}
+		resp.Agents = append(resp.Agents, a)
+	}
+	return resp, nil
+}
+
+// ToAgentEvent converte um evento do barramento para a mensagem protobuf.
+func ToAgentEvent(e events.Event) (*agentv1.AgentEvent, error) {
+	data, err := toStruct(e.Data)
+	if err != nil {
+		return nil, err
+	}
+	return &agentv1.AgentEvent{
+		Type:       e.Type,
+		Topic:      e.Topic,
+		Data:       data,
+		OccurredAt: toTimestamp(e.OccurredAt),
+	}, nil
+}
+
+func toSimulation(s *agent.Simulation) (*agentv1.Simulation, error) {
+	config, err := toStruct(s.Config)
+	if err != nil {
+		return nil, err
+	}
+	return &agentv1.Simulation{
+		Id:          s.ID,
+		ProjectId:   s.ProjectID,
+		Name:        s.Name,
+		Description: s.Description,
+		Status:      s.Status,
+		Config:      config,
+		CreatedAt:   toTimestamp(s.CreatedAt),
+		StartedAt:   toTimestampPtr(s.StartedAt),
+		EndedAt:     toTimestampPtr(s.EndedAt),
+	}, nil
+}
@@ -0,0 +1,191 @@
+// Package grpcapi expõe as operações de agentes e simulações via gRPC,
+// compartilhando o mesmo agent.Service usado pelas rotas REST.
+package grpcapi
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+
+	"smart-city-microservices/internal/action"
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/instrument"
+	"smart-city-microservices/internal/logging"
+	"smart-city-microservices/internal/maintenance"
+	agentv1 "smart-city-microservices/proto/agent/v1"
+)
+
+// AgentService é o subconjunto de agent.Service usado pela API de agentes.
+type AgentService interface {
+	ListAgents(ctx context.Context, f agent.Filter) ([]agent.Agent, int, error)
+	GetAgent(ctx context.Context, id string) (*agent.Agent, error)
+	CreateAgent(ctx context.Context, req agent.CreateAgentRequest) (*agent.Agent, error)
+	UpdateAgent(ctx context.Context, id string, req agent.UpdateAgentRequest) (*agent.Agent, error)
+	DeleteAgent(ctx context.Context, id string) error
+	ExecuteAction(ctx context.Context, id string, req agent.ActionRequest) (*agent.ActionResult, error)
+	GetPerformance(ctx context.Context, id string) (*agent.Performance, error)
+}
+
+// SimulationService é o subconjunto de agent.Service usado pela API de simulações.
+type SimulationService interface {
+	ListSimulations(ctx context.Context, page, pageSize int) ([]agent.Simulation, int, error)
+	CreateSimulation(ctx context.Context, req agent.CreateSimulationRequest) (*agent.Simulation, error)
+	GetSimulation(ctx context.Context, id string) (*agent.Simulation, error)
+	StartSimulation(ctx context.Context, id string) (*agent.Simulation, error)
+	StopSimulation(ctx context.Context, id string) (*agent.Simulation, error)
+}
+
+// Subscriber é a parte do barramento de eventos usada por WatchAgentEvents.
+type Subscriber interface {
+	Subscribe(h events.Handler) (unsubscribe func())
+}
+
+// Options configura o servidor gRPC.
+type Options struct {
+	Agents      AgentService
+	Simulations SimulationService
+	Events      Subscriber
+	Auth        auth.GRPCOptions
+	// TLSConfig habilita TLS (e mTLS, conforme ClientAuth) com a mesma
+	// configuração do servidor HTTP. Nil serve em texto puro.
+	TLSConfig *tls.Config
+	// Reflection registra o serviço de reflexão (grpcurl, grpcui).
+	Reflection bool
+	// OnAction, se definido, é chamado após cada ExecuteAction bem-sucedido.
+	OnAction func(ctx context.Context, agentID string, req agent.ActionRequest)
+	// Maintenance, se definido, recusa as chamadas bloqueadas pelo modo de
+	// manutenção.
+	Maintenance *maintenance.Switch
+}
+
+// Server envolve o grpc.Server com o encerramento coordenado dos streams.
+type Server struct {
+	grpc      *grpc.Server
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewServer cria o servidor com os interceptors equivalentes ao middleware
+// HTTP: recovery, correlação/log, métricas, autenticação e modo de
+// manutenção, nessa ordem.
+func NewServer(opts Options) *Server {
+	unary := []grpc.UnaryServerInterceptor{
+		recoveryUnary,
+		logging.UnaryServerInterceptor(),
+		instrument.UnaryServerInterceptor(),
+		auth.UnaryServerInterceptor(opts.Auth),
+	}
+	stream := []grpc.StreamServerInterceptor{
+		recoveryStream,
+		logging.StreamServerInterceptor(),
+		instrument.StreamServerInterceptor(),
+		auth.StreamServerInterceptor(opts.Auth),
+	}
+	if opts.Maintenance != nil {
+		unary = append(unary, opts.Maintenance.UnaryServerInterceptor())
+		stream = append(stream, opts.Maintenance.StreamServerInterceptor())
+	}
+	serverOpts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(unary...),
+		grpc.ChainStreamInterceptor(stream...),
+	}
+	if opts.TLSConfig != nil {
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(opts.TLSConfig)))
+	}
+
+	s := &Server{grpc: grpc.NewServer(serverOpts...), done: make(chan struct{})}
+	agentv1.RegisterAgentServiceServer(s.grpc, &agentServer{svc: opts.Agents, events: opts.Events, onAction: opts.OnAction, done: s.done})
+	agentv1.RegisterSimulationServiceServer(s.grpc, &simulationServer{svc: opts.Simulations})
+	if opts.Reflection {
+		reflection.Register(s.grpc)
+	}
+	return s
+}
+
+// Serve atende conexões no listener até Shutdown.
+func (s *Server) Serve(ln net.Listener) error {
+	err := s.grpc.Serve(ln)
+	if errors.Is(err, grpc.ErrServerStopped) {
+		return nil
+	}
+	return err
+}
+
+// Shutdown encerra os streams de eventos e aguarda as chamadas em andamento.
+// Se o contexto expirar antes, as conexões restantes são fechadas.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.closeOnce.Do(func() { close(s.done) })
+
+	stopped := make(chan struct{})
+	go func() {
+		s.grpc.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		s.grpc.Stop()
+		return ctx.Err()
+	}
+}
+
+// toStatus traduz erros do serviço em status gRPC com os mesmos critérios
+// das respostas HTTP (404 → NotFound, 400 → InvalidArgument, 403 →
+// PermissionDenied).
+func toStatus(ctx context.Context, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, agent.ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, agent.ErrValidation):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, action.ErrForbidden):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	logging.FromContext(ctx).WithError(err).Error("Erro interno na chamada gRPC")
+	return status.Error(codes.Internal, "internal error")
+}
+
+func recoveryUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = recovered(ctx, info.FullMethod, r)
+		}
+	}()
+	return handler(ctx, req)
+}
+
+func recoveryStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = recovered(ss.Context(), info.FullMethod, r)
+		}
+	}()
+	return handler(srv, ss)
+}
+
+func recovered(ctx context.Context, method string, r interface{}) error {
+	logging.FromContext(ctx).WithFields(logrus.Fields{
+		"grpc_method": method,
+		"panic":       r,
+	}).Error("Panic recuperado em chamada gRPC")
+	return status.Error(codes.Internal, "internal error")
+}
@@ -0,0 +1,93 @@
+package grpcapi
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"smart-city-microservices/internal/agent"
+	agentv1 "smart-city-microservices/proto/agent/v1"
+)
+
+type simulationServer struct {
+	agentv1.UnimplementedSimulationServiceServer
+	svc SimulationService
+}
+
+func (s *simulationServer) ListSimulations(ctx context.Context, req *agentv1.ListSimulationsRequest) (*agentv1.ListSimulationsResponse, error) {
+	page, pageSize := pagination(req.GetPage(), req.GetPageSize())
+	sims, total, err := s.svc.ListSimulations(ctx, page, pageSize)
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+
+	resp := &agentv1.ListSimulationsResponse{
+		Simulations: make([]*agentv1.Simulation, 0, len(sims)),
+		Total:       int32(total),
+		Page:        int32(page),
+		PageSize:    int32(pageSize),
+	}
+	for i := range sims {
+		sim, err := toSimulation(&sims[i])
+		if err != nil {
+			return nil, toStatus(ctx, err)
+		}
+		resp.Simulations = append(resp.Simulations, sim)
+	}
+	return resp, nil
+}
+
+func (s *simulationServer) GetSimulation(ctx context.Context, req *agentv1.GetSimulationRequest) (*agentv1.Simulation, error) {
+	if req.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "id é obrigatório")
+	}
+	sim, err := s.svc.GetSimulation(ctx, req.GetId())
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return s.reply(ctx, sim)
+}
+
+func (s *simulationServer) CreateSimulation(ctx context.Context, req *agentv1.CreateSimulationRequest) (*agentv1.Simulation, error) {
+	sim, err := s.svc.CreateSimulation(ctx, agent.CreateSimulationRequest{
+		ProjectID:   req.GetProjectId(),
+		Name:        req.GetName(),
+		Description: req.GetDescription(),
+		Config:      fromStruct(req.GetConfig()),
+	})
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return s.reply(ctx, sim)
+}
+
+func (s *simulationServer) StartSimulation(ctx context.Context, req *agentv1.StartSimulationRequest) (*agentv1.Simulation, error) {
+	if req.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "id é obrigatório")
+	}
+	sim, err := s.svc.StartSimulation(ctx, req.GetId())
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return s.reply(ctx, sim)
+}
+
+func (s *simulationServer) StopSimulation(ctx context.Context, req *agentv1.StopSimulationRequest) (*agentv1.Simulation, error) {
+	if req.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "id é obrigatório")
+	}
+	sim, err := s.svc.StopSimulation(ctx, req.GetId())
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return s.reply(ctx, sim)
+}
+
+func (s *simulationServer) reply(ctx context.Context, sim *agent.Simulation) (*agentv1.Simulation, error) {
+	out, err := toSimulation(sim)
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return out, nil
+}
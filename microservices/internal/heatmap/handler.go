@@ -0,0 +1,267 @@
+package heatmap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/logging"
+	"smart-city-microservices/internal/trajectory"
+)
+
+const cachePrefix = "heatmap:"
+
+// Limites da leitura do estado atual, como na busca por proximidade.
+const (
+	scanPageSize = 500
+	maxScan      = 50000
+)
+
+var requests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "agent_service",
+	Name:      "heatmap_requests_total",
+	Help:      "Mapas de calor pedidos, por origem (live, trajectories) e cache (hit, miss).",
+}, []string{"source", "cache"})
+
+// AgentService é o subconjunto de agent.Service usado pelo handler.
+type AgentService interface {
+	ListAgents(ctx context.Context, f agent.Filter) ([]agent.Agent, int, error)
+	GetSimulation(ctx context.Context, id string) (*agent.Simulation, error)
+}
+
+// History dá as posições gravadas num tick; *trajectory.Repository a
+// implementa.
+type History interface {
+	PositionsAt(ctx context.Context, simulationID string, tick int64, minLat, minLon, maxLat, maxLon float64) ([]trajectory.Sample, error)
+}
+
+// Config limita as grades e o cache.
+type Config struct {
+	// MaxCells limita as células de uma grade (linhas × colunas).
+	MaxCells int
+	// DefaultCellSize é o lado da célula, em metros, sem cell_size.
+	DefaultCellSize float64
+	// LiveTTL é a validade no cache das grades do estado atual e de um
+	// tick ainda não concluído; HistoryTTL, a das de um tick passado, que
+	// não mudam.
+	LiveTTL    time.Duration
+	HistoryTTL time.Duration
+}
+
+// Handler expõe os mapas de calor das simulações.
+type Handler struct {
+	agents  AgentService
+	history History
+	ticks   trajectory.TickSource
+	redis   redis.UniversalClient
+	cfg     Config
+}
+
+// NewHandler cria o handler de mapas de calor.
+func NewHandler(agents AgentService, history History, ticks trajectory.TickSource, client redis.UniversalClient, cfg Config) *Handler {
+	return &Handler{agents: agents, history: history, ticks: ticks, redis: client, cfg: cfg}
+}
+
+// Response é o corpo de GET /simulations/:id/heatmap.
+type Response struct {
+	SimulationID string `json:"simulation_id"`
+	*Grid
+	Metric string `json:"metric"`
+	// Tick é o tick pedido; ausente no estado atual.
+	Tick *int64 `json:"tick,omitempty"`
+	// Source é live (estado atual dos agentes) ou trajectories.
+	Source string `json:"source"`
+	// Agents conta os agentes dentro do retângulo.
+	Agents int `json:"agents"`
+	// Truncated indica que o estado atual passou de 50000 agentes e só
+	// os primeiros entraram na grade.
+	Truncated   bool      `json:"truncated"`
+	Cells       []Cell    `json:"cells"`
+	GeneratedAt time.Time `json:"generated_at"`
+	// Cached indica que a grade veio do cache.
+	Cached bool `json:"cached"`
+}
+
+// Get responde GET /simulations/:id/heatmap?bbox=min_lon,min_lat,max_lon,max_lat:
+// as células com agentes da grade de cell_size metros sobre o retângulo,
+// com a contagem (metric=count) ou a velocidade média (metric=avg_speed).
+// Com ?tick=N, as posições são as gravadas pelas trajetórias até o tick.
+func (h *Handler) Get(c *gin.Context) {
+	if c.Query("bbox") == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bbox is required"})
+		return
+	}
+	bbox, err := ParseBBox(c.Query("bbox"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	cellSize := h.cfg.DefaultCellSize
+	if v := c.Query("cell_size"); v != "" {
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil || math.IsNaN(n) || math.IsInf(n, 0) || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cell_size: " + v})
+			return
+		}
+		cellSize = n
+	}
+	metric := c.DefaultQuery("metric", MetricCount)
+	if metric != MetricCount && metric != MetricAvgSpeed {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid metric: use count or avg_speed"})
+		return
+	}
+	var tick *int64
+	if v := c.Query("tick"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tick: " + v})
+			return
+		}
+		tick = &n
+	}
+	grid, err := NewGrid(bbox, cellSize, h.cfg.MaxCells)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sim := h.simulation(c)
+	if sim == nil {
+		return
+	}
+
+	ctx := c.Request.Context()
+	source, ttl := "live", h.cfg.LiveTTL
+	if tick != nil {
+		current, err := h.ticks.CurrentTick(ctx, sim.ID)
+		if err != nil {
+			h.internalError(c, err)
+			return
+		}
+		if *tick > current {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("tick %d is ahead of the simulation (current tick %d)", *tick, current)})
+			return
+		}
+		source = "trajectories"
+		if *tick < current {
+			ttl = h.cfg.HistoryTTL
+		}
+	}
+	key := cacheKey(sim.ID, tick, metric, cellSize, bbox)
+	if res := h.cached(ctx, key); res != nil {
+		requests.WithLabelValues(source, "hit").Inc()
+		c.JSON(http.StatusOK, res)
+		return
+	}
+	requests.WithLabelValues(source, "miss").Inc()
+
+	res := &Response{SimulationID: sim.ID, Grid: grid, Metric: metric, Tick: tick, Source: source}
+	binner := grid.NewBinner()
+	if tick != nil {
+		err = h.binHistory(ctx, sim.ID, *tick, binner)
+	} else {
+		res.Truncated, err = h.binLive(ctx, sim.ID, binner)
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	res.Agents, res.Cells, res.GeneratedAt = binner.Agents, binner.Cells(metric), time.Now().UTC()
+	if raw, err := json.Marshal(res); err == nil {
+		if err := h.redis.Set(ctx, key, raw, ttl).Err(); err != nil {
+			logging.FromContext(ctx).WithError(err).Warn("Falha ao gravar o mapa de calor no cache")
+		}
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// binLive percorre as páginas dos agentes da simulação, até maxScan.
+func (h *Handler) binLive(ctx context.Context, simulationID string, b *Binner) (truncated bool, err error) {
+	f := agent.Filter{SimulationID: simulationID, PageSize: scanPageSize}
+	scanned := 0
+	for f.Page = 1; ; f.Page++ {
+		agents, total, err := h.agents.ListAgents(ctx, f)
+		if err != nil {
+			return false, err
+		}
+		for _, a := range agents {
+			b.Add(a.Position.Lat, a.Position.Lon, a.Position.Speed)
+		}
+		scanned += len(agents)
+		if len(agents) < f.PageSize || scanned >= total {
+			return false, nil
+		}
+		if scanned >= maxScan {
+			return true, nil
+		}
+	}
+}
+
+func (h *Handler) binHistory(ctx context.Context, simulationID string, tick int64, b *Binner) error {
+	bb := b.grid.BBox
+	samples, err := h.history.PositionsAt(ctx, simulationID, tick, bb.MinLat, bb.MinLon, bb.MaxLat, bb.MaxLon)
+	if err != nil {
+		return err
+	}
+	for _, s := range samples {
+		b.Add(s.Lat, s.Lon, s.Speed)
+	}
+	return nil
+}
+
+// cached lê a grade do cache; nil se não está lá ou o Redis falhou.
+func (h *Handler) cached(ctx context.Context, key string) *Response {
+	raw, err := h.redis.Get(ctx, key).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			logging.FromContext(ctx).WithError(err).Warn("Falha ao ler o mapa de calor do cache")
+		}
+		return nil
+	}
+	var res Response
+	if err := json.Unmarshal(raw, &res); err != nil {
+		return nil
+	}
+	res.Cached = true
+	return &res
+}
+
+// cacheKey identifica a grade pelos parâmetros do pedido.
+func cacheKey(simulationID string, tick *int64, metric string, cellSize float64, b BBox) string {
+	at := "live"
+	if tick != nil {
+		at = strconv.FormatInt(*tick, 10)
+	}
+	return fmt.Sprintf("%s%s:%s:%s:%g:%s", cachePrefix, simulationID, at, metric, cellSize, b.key())
+}
+
+// simulation busca a simulação de :id e confere o projeto do principal.
+// Responde e retorna nil se ela não existe ou é de outro projeto.
+func (h *Handler) simulation(c *gin.Context) *agent.Simulation {
+	sim, err := h.agents.GetSimulation(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, agent.ErrNotFound) || (err == nil && !auth.FromGin(c).InProject(sim.ProjectID)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "simulation not found"})
+		return nil
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return nil
+	}
+	return sim
+}
+
+func (h *Handler) internalError(c *gin.Context, err error) {
+	logging.FromContext(c.Request.Context()).WithError(err).Error("Erro no handler de mapas de calor")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
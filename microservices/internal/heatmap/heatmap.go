@@ -0,0 +1,170 @@
+// Package heatmap agrega as posições dos agentes de uma simulação numa
+// grade de densidade, para o mapa em zoom baixo, no lugar de um marcador
+// por agente. A grade cobre um retângulo com células de lado fixo em
+// metros; cada célula com agentes traz o centro e o valor da métrica. As
+// posições vêm do estado atual dos agentes ou, para um tick passado, das
+// trajetórias gravadas, e as grades recentes ficam em cache no Redis.
+package heatmap
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Métricas de uma célula.
+const (
+	MetricCount    = "count"
+	MetricAvgSpeed = "avg_speed"
+)
+
+// metersPerDegree é o comprimento de um grau de latitude.
+const metersPerDegree = 111320.0
+
+// BBox é um retângulo em graus, sem cruzar o antimeridiano.
+type BBox struct {
+	MinLon float64 `json:"min_lon"`
+	MinLat float64 `json:"min_lat"`
+	MaxLon float64 `json:"max_lon"`
+	MaxLat float64 `json:"max_lat"`
+}
+
+// ParseBBox lê "min_lon,min_lat,max_lon,max_lat", a ordem do GeoJSON.
+func ParseBBox(s string) (BBox, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return BBox{}, errors.New("bbox must be min_lon,min_lat,max_lon,max_lat")
+	}
+	var v [4]float64
+	for i, p := range parts {
+		n, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+			return BBox{}, fmt.Errorf("invalid bbox coordinate %q", p)
+		}
+		v[i] = n
+	}
+	b := BBox{MinLon: v[0], MinLat: v[1], MaxLon: v[2], MaxLat: v[3]}
+	switch {
+	case b.MinLon < -180 || b.MaxLon > 180 || b.MinLat < -90 || b.MaxLat > 90:
+		return BBox{}, errors.New("bbox is out of range")
+	case b.MinLon >= b.MaxLon || b.MinLat >= b.MaxLat:
+		return BBox{}, errors.New("bbox must have min_lon < max_lon and min_lat < max_lat")
+	}
+	return b, nil
+}
+
+// Contains informa se o ponto está no retângulo, bordas incluídas.
+func (b BBox) Contains(lat, lon float64) bool {
+	return lat >= b.MinLat && lat <= b.MaxLat && lon >= b.MinLon && lon <= b.MaxLon
+}
+
+// key é a forma do retângulo na chave de cache.
+func (b BBox) key() string {
+	return fmt.Sprintf("%.6f,%.6f,%.6f,%.6f", b.MinLon, b.MinLat, b.MaxLon, b.MaxLat)
+}
+
+// Cell é uma célula com agentes.
+type Cell struct {
+	Row int `json:"row"`
+	Col int `json:"col"`
+	// Lat e Lon são o centro da célula.
+	Lat   float64 `json:"lat"`
+	Lon   float64 `json:"lon"`
+	Count int     `json:"count"`
+	// Value é a métrica pedida: Count em count, a velocidade média em
+	// avg_speed.
+	Value float64 `json:"value"`
+}
+
+// Grid divide o retângulo em células de CellSize metros. A largura em
+// graus de longitude é a da latitude central do retângulo.
+type Grid struct {
+	BBox      BBox    `json:"bbox"`
+	CellSizeM float64 `json:"cell_size_m"`
+	Rows      int     `json:"rows"`
+	Cols      int     `json:"cols"`
+	dLat      float64
+	dLon      float64
+}
+
+// NewGrid monta a grade. Retorna erro se ela passaria de maxCells células.
+func NewGrid(b BBox, cellSizeM float64, maxCells int) (*Grid, error) {
+	g := &Grid{BBox: b, CellSizeM: cellSizeM, dLat: cellSizeM / metersPerDegree}
+	g.dLon = cellSizeM / (metersPerDegree * math.Max(math.Cos((b.MinLat+b.MaxLat)/2*math.Pi/180), 1e-6))
+	rows := math.Ceil((b.MaxLat - b.MinLat) / g.dLat)
+	cols := math.Ceil((b.MaxLon - b.MinLon) / g.dLon)
+	if cells := rows * cols; cells > float64(maxCells) {
+		return nil, fmt.Errorf("bbox and cell_size yield %.0f cells, more than the maximum of %d; increase cell_size or narrow bbox", cells, maxCells)
+	}
+	g.Rows, g.Cols = int(rows), int(cols)
+	return g, nil
+}
+
+// accumulator soma os agentes de uma célula.
+type accumulator struct {
+	count int
+	speed float64
+}
+
+// Binner distribui as posições pelas células da grade.
+type Binner struct {
+	grid  *Grid
+	cells map[int]*accumulator
+	// Agents conta as posições dentro do retângulo.
+	Agents int
+}
+
+// NewBinner cria o acumulador da grade.
+func (g *Grid) NewBinner() *Binner {
+	return &Binner{grid: g, cells: map[int]*accumulator{}}
+}
+
+// Add soma a posição à sua célula; fora do retângulo é ignorada.
+func (b *Binner) Add(lat, lon, speed float64) {
+	g := b.grid
+	if !g.BBox.Contains(lat, lon) {
+		return
+	}
+	row := min(int((lat-g.BBox.MinLat)/g.dLat), g.Rows-1)
+	col := min(int((lon-g.BBox.MinLon)/g.dLon), g.Cols-1)
+	a := b.cells[row*g.Cols+col]
+	if a == nil {
+		a = &accumulator{}
+		b.cells[row*g.Cols+col] = a
+	}
+	a.count++
+	a.speed += speed
+	b.Agents++
+}
+
+// Cells retorna as células com agentes, por linha e coluna, com o valor da
+// métrica.
+func (b *Binner) Cells(metric string) []Cell {
+	g := b.grid
+	out := make([]Cell, 0, len(b.cells))
+	for i, a := range b.cells {
+		row, col := i/g.Cols, i%g.Cols
+		c := Cell{
+			Row:   row,
+			Col:   col,
+			Lat:   math.Min(g.BBox.MinLat+(float64(row)+0.5)*g.dLat, g.BBox.MaxLat),
+			Lon:   math.Min(g.BBox.MinLon+(float64(col)+0.5)*g.dLon, g.BBox.MaxLon),
+			Count: a.count,
+			Value: float64(a.count),
+		}
+		if metric == MetricAvgSpeed {
+			c.Value = a.speed / float64(a.count)
+		}
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Row != out[j].Row {
+			return out[i].Row < out[j].Row
+		}
+		return out[i].Col < out[j].Col
+	})
+	return out
+}
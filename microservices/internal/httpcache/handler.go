@@ -0,0 +1,79 @@
+package httpcache
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/audit"
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/i18n"
+	"smart-city-microservices/internal/logging"
+)
+
+// SimulationKey é a chave substituta das respostas com os resultados de
+// uma simulação.
+func SimulationKey(id string) string {
+	return "simulation:" + id
+}
+
+// simulationEvents são os eventos que mudam os resultados de uma
+// simulação.
+var simulationEvents = map[string]bool{
+	"simulation.started":      true,
+	"simulation.stopped":      true,
+	"simulation.completed":    true,
+	"simulation.failed":       true,
+	"simulation.auto_stopped": true,
+}
+
+// Handle limpa as respostas de uma simulação quando ela muda de estado.
+// As mudanças são raras e a limpeza roda no Publish.
+func (ca *Cache) Handle(ctx context.Context, e events.Event) {
+	if !ca.cfg.Enabled || !simulationEvents[e.Type] {
+		return
+	}
+	var data struct {
+		ID           string `json:"id"`
+		SimulationID string `json:"simulation_id"`
+	}
+	if err := e.Decode(&data); err != nil {
+		return
+	}
+	id := data.SimulationID
+	if id == "" {
+		id = data.ID
+	}
+	if id == "" {
+		return
+	}
+	if _, err := ca.PurgeKeys(ctx, SimulationKey(id)); err != nil {
+		logging.FromContext(ctx).WithError(err).WithField("simulation_id", id).Warn("Falha ao limpar o cache de respostas da simulação")
+	}
+}
+
+// PurgeRequest é o corpo de POST /admin/cache/purge.
+type PurgeRequest struct {
+	Keys []string `json:"keys" binding:"required,min=1,dive,required"`
+}
+
+// PurgeHandler responde POST /admin/cache/purge: remove as respostas com
+// alguma das chaves substitutas.
+func (ca *Cache) PurgeHandler(c *gin.Context) {
+	var req PurgeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		i18n.BindError(c, err)
+		return
+	}
+	ctx := c.Request.Context()
+	n, err := ca.PurgeKeys(ctx, req.Keys...)
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).Error("Erro no handler do cache de respostas")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+	audit.Record(ctx, "http_cache.purged", logrus.Fields{"keys": req.Keys, "responses": n})
+	c.JSON(http.StatusOK, gin.H{"keys": req.Keys, "purged": n})
+}
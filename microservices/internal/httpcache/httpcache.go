@@ -0,0 +1,407 @@
+// Package httpcache guarda no Redis as respostas inteiras das rotas de
+// leitura que mudam pouco (registro de tipos de agente, comportamentos,
+// resultados de simulações encerradas).
+//
+// O cache é opcional por rota: só as rotas registradas com Route entram, e
+// a Route diz a validade, o max-age para os clientes e as chaves substitutas
+// (surrogate keys) das entidades da resposta, como "agent-type:{type}"; o
+// handler pode acrescentar chaves com Tag ou recusar a resposta com Skip.
+// A chave da resposta é a do caminho, da query ordenada, do Accept, dos
+// projetos do principal e da versão do serviço. As rotas de escrita registram Purge com as chaves
+// das entidades que alteram, e os eventos de simulações limpam as chaves
+// "simulation:<id>".
+//
+// Respostas personalizadas não entram: as que o handler recusa com Skip, as
+// com Set-Cookie e as com Cache-Control private ou no-store do handler.
+package httpcache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/buildinfo"
+	"smart-city-microservices/internal/logging"
+)
+
+// Chaves no Redis: a resposta, o índice de cada chave substituta e a marca
+// de limpeza recente de cada uma.
+const (
+	responsePrefix = "agent-service:http-cache:response:"
+	indexPrefix    = "agent-service:http-cache:key:"
+	purgedPrefix   = "agent-service:http-cache:purged:"
+)
+
+// maxTTL limita a validade das respostas; os índices vivem isso, para
+// nenhuma resposta sobreviver ao índice que a limpa.
+const maxTTL = 24 * time.Hour
+
+// purgedTTL é quanto dura a marca de limpeza: mais que qualquer requisição
+// em andamento durante a limpeza.
+const purgedTTL = time.Minute
+
+// Cabeçalhos das respostas das rotas cacheadas.
+const (
+	HeaderCache        = "X-Cache"
+	HeaderSurrogateKey = "Surrogate-Key"
+)
+
+const (
+	tagsKey = "httpcache.tags"
+	skipKey = "httpcache.skip"
+)
+
+var (
+	requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "http_cache_requests_total",
+		Help:      "Requisições das rotas cacheadas, por rota e resultado (hit, miss, skip, bypass).",
+	}, []string{"route", "result"})
+	purged = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "http_cache_purged_total",
+		Help:      "Respostas removidas do cache pelas chaves substitutas.",
+	})
+)
+
+// Config configura o cache de respostas.
+type Config struct {
+	Enabled bool
+	// DefaultTTL é a validade no Redis e DefaultMaxAge o max-age do
+	// Cache-Control das rotas que não informam os seus.
+	DefaultTTL    time.Duration
+	DefaultMaxAge time.Duration
+	// MaxBodyBytes limita o corpo guardado; respostas maiores não entram.
+	MaxBodyBytes int
+}
+
+// Route são os metadados de cache de uma rota.
+type Route struct {
+	// TTL é a validade no Redis, até 24h; MaxAge, a dos clientes.
+	TTL    time.Duration
+	MaxAge time.Duration
+	// Keys são as chaves substitutas das respostas; {param} vira o
+	// parâmetro da rota.
+	Keys []string
+}
+
+// entry é uma resposta guardada.
+type entry struct {
+	ContentType string   `json:"content_type"`
+	Body        []byte   `json:"body"`
+	Keys        []string `json:"keys"`
+}
+
+// Cache guarda as respostas das rotas registradas com Route.
+type Cache struct {
+	redis redis.UniversalClient
+	cfg   Config
+}
+
+// New cria o cache de respostas; desabilitado, Route e Purge só seguem a
+// cadeia.
+func New(client redis.UniversalClient, cfg Config) *Cache {
+	return &Cache{redis: client, cfg: cfg}
+}
+
+// Tag acrescenta chaves substitutas das entidades que só o handler conhece:
+// numa rota com Route, às da resposta; numa com Purge, às limpas. Fora
+// delas, não tem efeito.
+func Tag(c *gin.Context, keys ...string) {
+	tags := c.GetStringSlice(tagsKey)
+	c.Set(tagsKey, append(tags, keys...))
+}
+
+// Skip tira a resposta do cache, por ser personalizada ou ainda mudar.
+func Skip(c *gin.Context) {
+	c.Set(skipKey, true)
+	c.Header("Cache-Control", "no-store")
+}
+
+// Route serve as leituras GET da rota pelo cache e guarda as respostas 200.
+// Cache-Control: no-cache no pedido ignora o guardado e gera de novo.
+func (ca *Cache) Route(r Route) gin.HandlerFunc {
+	ttl, maxAge := r.TTL, r.MaxAge
+	if ttl <= 0 {
+		ttl = ca.cfg.DefaultTTL
+	}
+	ttl = min(ttl, maxTTL)
+	if maxAge <= 0 {
+		maxAge = ca.cfg.DefaultMaxAge
+	}
+	return func(c *gin.Context) {
+		if !ca.cfg.Enabled || c.Request.Method != http.MethodGet {
+			c.Next()
+			return
+		}
+		ctx := c.Request.Context()
+		route := c.FullPath()
+		key := responseKey(c)
+		control := cacheControl(c, maxAge)
+
+		if strings.Contains(c.GetHeader("Cache-Control"), "no-cache") {
+			requests.WithLabelValues(route, "bypass").Inc()
+		} else if e := ca.load(ctx, key); e != nil {
+			requests.WithLabelValues(route, "hit").Inc()
+			c.Header("Cache-Control", control)
+			c.Header(HeaderCache, "HIT")
+			c.Header(HeaderSurrogateKey, strings.Join(e.Keys, " "))
+			c.Data(http.StatusOK, e.ContentType, e.Body)
+			c.Abort()
+			return
+		}
+
+		c.Header("Cache-Control", control)
+		c.Header(HeaderCache, "MISS")
+		start := time.Now()
+		w := &recorder{ResponseWriter: c.Writer, c: c, keys: r.Keys, limit: ca.cfg.MaxBodyBytes}
+		c.Writer = w
+		c.Next()
+		c.Writer = w.ResponseWriter
+
+		if !w.storable(control) {
+			requests.WithLabelValues(route, "skip").Inc()
+			return
+		}
+		requests.WithLabelValues(route, "miss").Inc()
+		e := &entry{ContentType: w.Header().Get("Content-Type"), Body: w.body, Keys: w.resolved}
+		if err := ca.store(ctx, key, e, ttl, start); err != nil {
+			logging.FromContext(ctx).WithError(err).WithField("route", route).Warn("Falha ao gravar a resposta no cache")
+		}
+	}
+}
+
+// Purge limpa, depois de uma escrita bem-sucedida da rota, as respostas
+// com as chaves substitutas keys ({param} vira o parâmetro da rota) e com
+// as acrescentadas pelo handler com Tag.
+func (ca *Cache) Purge(keys ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		if !ca.cfg.Enabled || c.Writer.Status() >= http.StatusBadRequest {
+			return
+		}
+		resolved := make([]string, 0, len(keys))
+		for _, k := range keys {
+			resolved = append(resolved, expand(c, k))
+		}
+		resolved = append(resolved, c.GetStringSlice(tagsKey)...)
+		ctx := c.Request.Context()
+		if _, err := ca.PurgeKeys(ctx, resolved...); err != nil {
+			logging.FromContext(ctx).WithError(err).WithField("keys", resolved).Warn("Falha ao limpar o cache de respostas")
+		}
+	}
+}
+
+// PurgeKeys remove as respostas com alguma das chaves substitutas e retorna
+// quantas removeu. As respostas em geração durante a limpeza não são
+// guardadas.
+func (ca *Cache) PurgeKeys(ctx context.Context, keys ...string) (int, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	now := strconv.FormatInt(time.Now().UnixNano(), 10)
+	pipe := ca.redis.Pipeline()
+	members := make([]*redis.StringSliceCmd, len(keys))
+	for i, k := range keys {
+		pipe.Set(ctx, purgedPrefix+k, now, purgedTTL)
+		members[i] = pipe.SMembers(ctx, indexPrefix+k)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	var entries []string
+	for _, m := range members {
+		entries = append(entries, m.Val()...)
+	}
+	pipe = ca.redis.Pipeline()
+	for _, k := range keys {
+		pipe.Del(ctx, indexPrefix+k)
+	}
+	var deleted []*redis.IntCmd
+	for _, e := range entries {
+		deleted = append(deleted, pipe.Del(ctx, e))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, d := range deleted {
+		n += int(d.Val())
+	}
+	purged.Add(float64(n))
+	return n, nil
+}
+
+func (ca *Cache) load(ctx context.Context, key string) *entry {
+	raw, err := ca.redis.Get(ctx, key).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			logging.FromContext(ctx).WithError(err).Warn("Falha ao ler a resposta do cache")
+		}
+		return nil
+	}
+	var e entry
+	if err := json.Unmarshal(raw, &e); err != nil {
+		return nil
+	}
+	return &e
+}
+
+// store guarda a resposta e a acrescenta aos índices das chaves, a menos
+// que alguma delas tenha sido limpa depois de start: a resposta pode ter
+// sido gerada com os dados de antes da escrita.
+func (ca *Cache) store(ctx context.Context, key string, e *entry, ttl time.Duration, start time.Time) error {
+	if len(e.Keys) > 0 {
+		marks := make([]string, len(e.Keys))
+		for i, k := range e.Keys {
+			marks[i] = purgedPrefix + k
+		}
+		at, err := ca.redis.MGet(ctx, marks...).Result()
+		if err != nil {
+			return err
+		}
+		for _, v := range at {
+			if s, ok := v.(string); ok {
+				if n, err := strconv.ParseInt(s, 10, 64); err == nil && n >= start.UnixNano() {
+					return nil
+				}
+			}
+		}
+	}
+	raw, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	pipe := ca.redis.Pipeline()
+	pipe.Set(ctx, key, raw, ttl)
+	for _, k := range e.Keys {
+		pipe.SAdd(ctx, indexPrefix+k, key)
+		pipe.Expire(ctx, indexPrefix+k, maxTTL)
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// responseKey identifica a resposta pelo caminho, pela query ordenada, pelo
+// Accept e pelos projetos do principal. A versão do serviço também entra:
+// durante uma implantação, as réplicas de versões diferentes não trocam
+// respostas.
+func responseKey(c *gin.Context) string {
+	h := sha256.New()
+	for _, part := range []string{buildinfo.Version, c.Request.URL.Path, c.Request.URL.Query().Encode(), c.GetHeader("Accept"), scope(auth.FromGin(c))} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return responsePrefix + hex.EncodeToString(h.Sum(nil))
+}
+
+// scope são os projetos visíveis ao principal; "*" sem restrição.
+func scope(p *auth.Principal) string {
+	if p == nil || len(p.Projects) == 0 || p.HasRole(auth.RoleAdmin) {
+		return "*"
+	}
+	projects := append([]string(nil), p.Projects...)
+	sort.Strings(projects)
+	return strings.Join(projects, ",")
+}
+
+// cacheControl é o Cache-Control das respostas: private para os
+// principais autenticados, que os caches compartilhados não podem servir a
+// outros.
+func cacheControl(c *gin.Context, maxAge time.Duration) string {
+	visibility := "public"
+	if p := auth.FromGin(c); p != nil && p.Subject != "" {
+		visibility = "private"
+	}
+	return visibility + ", max-age=" + strconv.Itoa(int(maxAge.Seconds()))
+}
+
+// expand troca os {param} de key pelos parâmetros da rota.
+func expand(c *gin.Context, key string) string {
+	for {
+		i := strings.IndexByte(key, '{')
+		j := strings.IndexByte(key, '}')
+		if i < 0 || j < i {
+			return key
+		}
+		key = key[:i] + c.Param(key[i+1:j]) + key[j+1:]
+	}
+}
+
+// recorder copia o corpo da resposta enquanto ela vai ao cliente e grava o
+// Surrogate-Key antes do primeiro byte, quando as chaves do handler já
+// foram acrescentadas.
+type recorder struct {
+	gin.ResponseWriter
+	c        *gin.Context
+	keys     []string
+	resolved []string
+	limit    int
+	body     []byte
+	overflow bool
+	started  bool
+}
+
+func (w *recorder) start() {
+	if w.started {
+		return
+	}
+	w.started = true
+	for _, k := range w.keys {
+		w.resolved = append(w.resolved, expand(w.c, k))
+	}
+	w.resolved = append(w.resolved, w.c.GetStringSlice(tagsKey)...)
+	if len(w.resolved) > 0 {
+		w.Header().Set(HeaderSurrogateKey, strings.Join(w.resolved, " "))
+	}
+}
+
+func (w *recorder) WriteHeaderNow() {
+	w.start()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *recorder) Write(b []byte) (int, error) {
+	w.start()
+	if !w.overflow {
+		if len(w.body)+len(b) > w.limit {
+			w.overflow, w.body = true, nil
+		} else {
+			w.body = append(w.body, b...)
+		}
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recorder) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// storable diz se a resposta pode ir ao cache: 200, inteira, recusada nem
+// pelo handler nem pelos cabeçalhos de resposta personalizada.
+func (w *recorder) storable(control string) bool {
+	if w.Status() != http.StatusOK || w.overflow || !w.started || w.c.GetBool(skipKey) {
+		return false
+	}
+	h := w.Header()
+	if h.Get("Set-Cookie") != "" {
+		return false
+	}
+	if cc := h.Get("Cache-Control"); cc != control && (strings.Contains(cc, "private") || strings.Contains(cc, "no-store")) {
+		return false
+	}
+	return true
+}
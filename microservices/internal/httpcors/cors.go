@@ -0,0 +1,42 @@
+// Package httpcors mantém a política CORS do router atualizável em tempo de
+// execução, sem recriar o router.
+package httpcors
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+)
+
+// Policy guarda o middleware CORS ativo.
+type Policy struct {
+	current atomic.Value // gin.HandlerFunc
+}
+
+// New cria a política com as origens informadas.
+func New(origins []string) *Policy {
+	p := &Policy{}
+	p.Update(origins)
+	return p
+}
+
+// Update substitui as origens permitidas.
+func (p *Policy) Update(origins []string) {
+	p.current.Store(cors.New(cors.Config{
+		AllowOrigins:     origins,
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
+		ExposeHeaders:    []string{"Content-Length"},
+		AllowCredentials: true,
+		MaxAge:           12 * time.Hour,
+	}))
+}
+
+// Handler retorna o middleware que delega para a política ativa.
+func (p *Policy) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p.current.Load().(gin.HandlerFunc)(c)
+	}
+}
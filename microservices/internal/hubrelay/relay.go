@@ -0,0 +1,318 @@
+// Package hubrelay repassa entre as réplicas do agent-service os eventos
+// destinados ao hub websocket, para que um cliente conectado a qualquer
+// réplica veja os eventos de todas.
+//
+// Cada réplica entrega ao próprio hub o que o seu barramento produz e
+// publica a mesma mensagem num canal Redis pub/sub, marcada com o id da
+// instância de origem; as demais a entregam aos seus hubs e a réplica de
+// origem a ignora, sem eco. Uma única goroutine publica as mensagens na
+// ordem em que foram produzidas e o Redis as entrega na mesma ordem a cada
+// inscrito, o que preserva a ordem dos eventos de cada tópico. Enquanto
+// esta é a única instância registrada nada é publicado.
+package hubrelay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+
+	"smart-city-microservices/internal/deadletter"
+	"smart-city-microservices/internal/instance"
+	"smart-city-microservices/internal/logging"
+	"smart-city-microservices/internal/supervisor"
+)
+
+var (
+	relayedMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "hub_relay_messages_total",
+		Help:      "Mensagens do hub repassadas entre réplicas, por direção (sent, received).",
+	}, []string{"direction"})
+	droppedMessages = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "hub_relay_dropped_total",
+		Help:      "Mensagens do hub não repassadas às outras réplicas por fila cheia ou falha no Redis.",
+	})
+)
+
+// maxBatch limita as mensagens enviadas num só pipeline.
+const maxBatch = 256
+
+// retryBackoff é a espera antes de repetir um pipeline que falhou,
+// multiplicada pela tentativa.
+const retryBackoff = 100 * time.Millisecond
+
+// DeadLetterConsumer identifica as mensagens no dead-letter; o destino da
+// entrada é o canal.
+const DeadLetterConsumer = "hub_relay"
+
+// DeadLetter grava as mensagens que não chegaram às outras réplicas;
+// *deadletter.Queue o implementa.
+type DeadLetter interface {
+	Add(ctx context.Context, e *deadletter.Entry) error
+}
+
+// Broadcaster é o hub que recebe as mensagens; *websocket.Hub o implementa.
+type Broadcaster interface {
+	BroadcastToTopic(topic string, payload interface{})
+}
+
+// Registry lista as instâncias vivas; *instance.Heartbeat o implementa.
+type Registry interface {
+	List(ctx context.Context) ([]instance.Info, error)
+}
+
+// Config configura o repasse.
+type Config struct {
+	// Channel é o canal Redis compartilhado pelas réplicas.
+	Channel string
+	// QueueSize limita as mensagens à espera de publicação.
+	QueueSize int
+	// PeerRefresh é o intervalo da releitura das instâncias registradas.
+	PeerRefresh time.Duration
+	// MaxAttempts é o número de tentativas de publicar um lote antes de
+	// descartá-lo ou gravá-lo no dead-letter.
+	MaxAttempts int
+}
+
+// message é o que trafega no canal.
+type message struct {
+	Origin string          `json:"origin"`
+	Topic  string          `json:"topic"`
+	Body   json.RawMessage `json:"body"`
+}
+
+// Relay entrega as mensagens ao hub local e as repassa às outras réplicas.
+type Relay struct {
+	redis    redis.UniversalClient
+	hub      Broadcaster
+	registry Registry
+	cfg      Config
+	self     string
+	queue    chan message
+	// deadLetter recebe os lotes que esgotaram as tentativas; nil os
+	// descarta.
+	deadLetter DeadLetter
+	// peers é o número de outras instâncias vivas na última releitura.
+	peers atomic.Int64
+}
+
+// New cria o repasse da instância self. Até a primeira releitura das
+// instâncias registradas as mensagens são publicadas.
+func New(client redis.UniversalClient, hub Broadcaster, registry Registry, cfg Config, self string) *Relay {
+	r := &Relay{
+		redis:    client,
+		hub:      hub,
+		registry: registry,
+		cfg:      cfg,
+		self:     self,
+		queue:    make(chan message, cfg.QueueSize),
+	}
+	r.peers.Store(1)
+	return r
+}
+
+// SetDeadLetter passa a gravar em q as mensagens que não puderam ser
+// repassadas. Deve ser chamado antes de Run.
+func (r *Relay) SetDeadLetter(q DeadLetter) {
+	r.deadLetter = q
+}
+
+// Replay republica para as outras réplicas uma mensagem do dead-letter; o
+// hub local já a recebeu quando foi produzida.
+func (r *Relay) Replay(ctx context.Context, e deadletter.Entry) error {
+	return r.publish(ctx, []message{{Origin: r.self, Topic: e.Topic, Body: e.Payload}})
+}
+
+// Broadcast entrega body, o envelope já serializado de um evento de topic,
+// ao hub local e o enfileira para as outras réplicas. Não bloqueia: com a
+// fila cheia a mensagem só chega aos clientes desta réplica.
+func (r *Relay) Broadcast(topic string, body []byte) {
+	r.hub.BroadcastToTopic(topic, json.RawMessage(body))
+	if r.peers.Load() == 0 {
+		return
+	}
+	select {
+	case r.queue <- message{Origin: r.self, Topic: topic, Body: body}:
+	default:
+		droppedMessages.Inc()
+	}
+}
+
+// Run publica a fila, entrega ao hub as mensagens das outras réplicas e
+// relê as instâncias registradas até ctx ser cancelado.
+func (r *Relay) Run(ctx context.Context) error {
+	sub := r.redis.Subscribe(ctx, r.cfg.Channel)
+	defer sub.Close()
+	// Confirma a inscrição antes de seguir; sem ela o canal não recebe nada.
+	if _, err := sub.Receive(ctx); err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return fmt.Errorf("hubrelay: inscrever em %s: %w", r.cfg.Channel, err)
+	}
+
+	g, _ := supervisor.NewGroup(ctx)
+	g.Go(r.send)
+	g.Go(func(ctx context.Context) error { return r.receive(ctx, sub.Channel()) })
+	g.Go(r.watchPeers)
+	return g.Wait()
+}
+
+func (r *Relay) send(ctx context.Context) error {
+	log := logging.FromContext(logging.Background(ctx, "hub-relay"))
+	batch := make([]message, 0, maxBatch)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case m := <-r.queue:
+			batch = append(batch[:0], m)
+		}
+		// Junta o que já está na fila num só pipeline, na mesma ordem.
+	drain:
+		for len(batch) < maxBatch {
+			select {
+			case m := <-r.queue:
+				batch = append(batch, m)
+			default:
+				break drain
+			}
+		}
+		attempts, err := r.publishWithRetry(ctx, batch)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			droppedMessages.Add(float64(len(batch)))
+			log.WithError(err).WithField("messages", len(batch)).Warn("Falha ao repassar mensagens do hub às outras réplicas")
+			r.deadLettered(ctx, batch, attempts, err)
+			continue
+		}
+		relayedMessages.WithLabelValues("sent").Add(float64(len(batch)))
+	}
+}
+
+// publishWithRetry publica o lote em até MaxAttempts tentativas e retorna
+// quantas fez.
+func (r *Relay) publishWithRetry(ctx context.Context, batch []message) (int, error) {
+	attempt := 1
+	for ; ; attempt++ {
+		err := r.publish(ctx, batch)
+		if err == nil || attempt >= r.cfg.MaxAttempts {
+			return attempt, err
+		}
+		select {
+		case <-ctx.Done():
+			return attempt, err
+		case <-time.After(retryBackoff * time.Duration(attempt)):
+		}
+	}
+}
+
+// deadLettered grava no dead-letter as mensagens do lote, que esgotou as
+// tentativas com o erro cause. O Redis do dead-letter costuma ser o mesmo
+// do canal; falhas aqui só são registradas no log.
+func (r *Relay) deadLettered(ctx context.Context, batch []message, attempts int, cause error) {
+	if r.deadLetter == nil {
+		return
+	}
+	for _, m := range batch {
+		// Como nos webhooks, o tipo sem versão.
+		var head struct {
+			Type string `json:"type"`
+		}
+		_ = json.Unmarshal(m.Body, &head)
+		entry := &deadletter.Entry{
+			Consumer:  DeadLetterConsumer,
+			Target:    r.cfg.Channel,
+			EventType: head.Type,
+			Topic:     m.Topic,
+			Payload:   m.Body,
+			Error:     cause.Error(),
+			Attempts:  attempts,
+		}
+		if err := r.deadLetter.Add(ctx, entry); err != nil {
+			logging.FromContext(ctx).WithError(err).Warn("Falha ao gravar mensagem do hub no dead-letter")
+			return
+		}
+	}
+}
+
+func (r *Relay) publish(ctx context.Context, batch []message) error {
+	_, err := r.redis.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, m := range batch {
+			raw, err := json.Marshal(m)
+			if err != nil {
+				return err
+			}
+			pipe.Publish(ctx, r.cfg.Channel, raw)
+		}
+		return nil
+	})
+	return err
+}
+
+func (r *Relay) receive(ctx context.Context, ch <-chan *redis.Message) error {
+	log := logging.FromContext(logging.Background(ctx, "hub-relay"))
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-ch:
+			if !ok {
+				return errors.New("hubrelay: inscrição encerrada")
+			}
+			var m message
+			if err := json.Unmarshal([]byte(msg.Payload), &m); err != nil {
+				log.WithError(err).Warn("Mensagem inválida no canal do hub")
+				continue
+			}
+			if m.Origin == r.self {
+				continue
+			}
+			r.hub.BroadcastToTopic(m.Topic, m.Body)
+			relayedMessages.WithLabelValues("received").Inc()
+		}
+	}
+}
+
+// watchPeers relê as instâncias registradas. Se a leitura falha, vale a
+// contagem anterior.
+func (r *Relay) watchPeers(ctx context.Context) error {
+	log := logging.FromContext(logging.Background(ctx, "hub-relay"))
+	ticker := time.NewTicker(r.cfg.PeerRefresh)
+	defer ticker.Stop()
+	for {
+		if err := r.refreshPeers(ctx); err != nil && ctx.Err() == nil {
+			log.WithError(err).Warn("Falha ao listar as instâncias registradas")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Relay) refreshPeers(ctx context.Context) error {
+	list, err := r.registry.List(ctx)
+	if err != nil {
+		return err
+	}
+	var peers int64
+	for _, info := range list {
+		if info.ID != r.self {
+			peers++
+		}
+	}
+	r.peers.Store(peers)
+	return nil
+}
@@ -0,0 +1,217 @@
+package hubrelay
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"smart-city-microservices/internal/deadletter"
+	"smart-city-microservices/internal/instance"
+)
+
+const channel = "test:hub"
+
+// hub grava as mensagens entregues, na ordem.
+type hub struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (h *hub) BroadcastToTopic(topic string, payload interface{}) {
+	body, _ := json.Marshal(payload)
+	h.mu.Lock()
+	h.msgs = append(h.msgs, topic+" "+string(body))
+	h.mu.Unlock()
+}
+
+func (h *hub) messages() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string(nil), h.msgs...)
+}
+
+// registry são as instâncias vivas, fixas.
+type registry []string
+
+func (r registry) List(context.Context) ([]instance.Info, error) {
+	out := make([]instance.Info, len(r))
+	for i, id := range r {
+		out[i] = instance.Info{ID: id}
+	}
+	return out, nil
+}
+
+// deadLetters grava as entradas do dead-letter.
+type deadLetters struct {
+	mu      sync.Mutex
+	entries []deadletter.Entry
+}
+
+func (d *deadLetters) Add(_ context.Context, e *deadletter.Entry) error {
+	d.mu.Lock()
+	d.entries = append(d.entries, *e)
+	d.mu.Unlock()
+	return nil
+}
+
+func (d *deadLetters) list() []deadletter.Entry {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]deadletter.Entry(nil), d.entries...)
+}
+
+func newClient(t *testing.T, mr *miniredis.Miniredis) redis.UniversalClient {
+	t.Helper()
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func newRelay(t *testing.T, mr *miniredis.Miniredis, self string, peers registry) (*Relay, *hub) {
+	t.Helper()
+	h := &hub{}
+	r := New(newClient(t, mr), h, peers, Config{Channel: channel, QueueSize: 1024, PeerRefresh: time.Hour, MaxAttempts: 2}, self)
+	return r, h
+}
+
+// run roda as réplicas até o fim do teste e espera que todas estejam
+// inscritas no canal.
+func run(t *testing.T, mr *miniredis.Miniredis, relays ...*Relay) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for _, r := range relays {
+		wg.Add(1)
+		go func(r *Relay) {
+			defer wg.Done()
+			if err := r.Run(ctx); err != nil {
+				t.Errorf("Run: %v", err)
+			}
+		}(r)
+	}
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+	})
+	eventually(t, func() bool { return mr.PubSubNumSub(channel)[channel] == len(relays) })
+}
+
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condição não atingida em 5s")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestRelayBetweenReplicas confere que o que uma réplica produz chega ao
+// hub da outra na ordem em que foi produzido e que a réplica de origem não
+// recebe o eco.
+func TestRelayBetweenReplicas(t *testing.T) {
+	mr := miniredis.RunT(t)
+	peers := registry{"a", "b"}
+	a, hubA := newRelay(t, mr, "a", peers)
+	b, hubB := newRelay(t, mr, "b", peers)
+	run(t, mr, a, b)
+
+	var want []string
+	for i := 0; i < 300; i++ {
+		topic := []string{"agents", "alerts", "simulations"}[i%3]
+		body := fmt.Sprintf(`{"type":"agent.updated","seq":%d}`, i)
+		a.Broadcast(topic, []byte(body))
+		want = append(want, topic+" "+body)
+	}
+	eventually(t, func() bool { return len(hubB.messages()) == len(want) })
+	for i, got := range hubB.messages() {
+		if got != want[i] {
+			t.Fatalf("mensagem %d em b: %s, want %s", i, got, want[i])
+		}
+	}
+
+	b.Broadcast("agents", []byte(`{"type":"agent.created"}`))
+	eventually(t, func() bool { return len(hubA.messages()) == len(want)+1 })
+	// Sem eco: a tem as suas 300 e a de b, uma vez cada.
+	time.Sleep(50 * time.Millisecond)
+	if got := hubA.messages(); len(got) != len(want)+1 || got[len(want)] != `agents {"type":"agent.created"}` {
+		t.Errorf("a recebeu %d mensagens, última %q", len(got), got[len(got)-1])
+	}
+	if got := hubB.messages(); len(got) != len(want)+1 {
+		t.Errorf("b recebeu %d mensagens, want %d", len(got), len(want)+1)
+	}
+}
+
+// TestSingleInstanceDoesNotPublish confere que, sozinha no registro, a
+// réplica só entrega ao próprio hub.
+func TestSingleInstanceDoesNotPublish(t *testing.T) {
+	mr := miniredis.RunT(t)
+	a, hubA := newRelay(t, mr, "a", registry{"a"})
+	// b escuta o canal, mas não está no registro de a.
+	b, hubB := newRelay(t, mr, "b", registry{"a", "b"})
+	run(t, mr, b)
+	if err := a.refreshPeers(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	a.Broadcast("agents", []byte(`{"type":"agent.updated"}`))
+	if len(hubA.messages()) != 1 {
+		t.Errorf("hub local recebeu %v", hubA.messages())
+	}
+	if len(a.queue) != 0 {
+		t.Errorf("%d mensagens enfileiradas para publicação", len(a.queue))
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := hubB.messages(); len(got) != 0 {
+		t.Errorf("b recebeu %v", got)
+	}
+}
+
+// TestDeadLetterAndReplay derruba o Redis durante a publicação e confere
+// que o lote vai ao dead-letter e que Replay o entrega à outra réplica.
+func TestDeadLetterAndReplay(t *testing.T) {
+	down := miniredis.RunT(t)
+	a, hubA := newRelay(t, down, "a", registry{"a", "b"})
+	dl := &deadLetters{}
+	a.SetDeadLetter(dl)
+	down.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func(a *Relay) {
+		defer close(done)
+		a.send(ctx)
+	}(a)
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	a.Broadcast("agents", []byte(`{"type":"agent.updated","seq":1}`))
+	eventually(t, func() bool { return len(dl.list()) == 1 })
+	e := dl.list()[0]
+	if e.Consumer != DeadLetterConsumer || e.Target != channel || e.EventType != "agent.updated" || e.Topic != "agents" || e.Attempts != 2 {
+		t.Errorf("entrada %+v", e)
+	}
+	if len(hubA.messages()) != 1 {
+		t.Errorf("hub local recebeu %v", hubA.messages())
+	}
+
+	// Com o Redis de volta, o reprocessamento por a chega a b.
+	mr := miniredis.RunT(t)
+	a, _ = newRelay(t, mr, "a", registry{"a", "b"})
+	b, hubB := newRelay(t, mr, "b", registry{"a", "b"})
+	run(t, mr, b)
+	if err := a.Replay(context.Background(), e); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, func() bool { return len(hubB.messages()) == 1 })
+	if got := hubB.messages()[0]; got != `agents {"type":"agent.updated","seq":1}` {
+		t.Errorf("b recebeu %s", got)
+	}
+}
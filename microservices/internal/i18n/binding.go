@@ -0,0 +1,99 @@
+package i18n
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// UseJSONFieldNames faz o validador do gin nomear os campos pela tag json
+// (ou form, nas consultas), para que as mensagens de BindError usem o nome
+// visto pelo cliente. Deve ser chamado uma vez, antes de servir.
+func UseJSONFieldNames() {
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return
+	}
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		for _, tag := range []string{"json", "form"} {
+			name, _, _ := strings.Cut(f.Tag.Get(tag), ",")
+			if name == "-" {
+				return ""
+			}
+			if name != "" {
+				return name
+			}
+		}
+		return ""
+	})
+}
+
+// BindError responde 400 para uma falha de ShouldBind*. Os erros do
+// validador viram códigos validation.* com o campo; fields traz todos, e
+// error e code são os do primeiro. Um corpo que não é JSON válido responde
+// validation.body com a causa, em inglês, em detail.
+func BindError(c *gin.Context, err error) {
+	locale := Locale(c)
+	var invalid validator.ValidationErrors
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &invalid) && len(invalid) > 0:
+		fields := make([]gin.H, len(invalid))
+		for i, fe := range invalid {
+			code, params := fieldError(fe)
+			fields[i] = gin.H{"field": params["field"], "code": code, "error": Format(code, locale, params)}
+		}
+		c.Header("Content-Language", locale)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error":  fields[0]["error"],
+			"code":   fields[0]["code"],
+			"fields": fields,
+		})
+	case errors.As(err, &typeErr):
+		Error(c, http.StatusBadRequest, "validation.type", Params{"field": typeErr.Field, "param": typeErr.Type.String()})
+	default:
+		c.Header("Content-Language", locale)
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error":  Format("validation.body", locale, nil),
+			"code":   "validation.body",
+			"detail": err.Error(),
+		})
+	}
+}
+
+// fieldError traduz uma regra do validador em código e parâmetros. O campo
+// é o caminho a partir da raiz do corpo (items[0].name).
+func fieldError(fe validator.FieldError) (string, Params) {
+	field := fe.Namespace()
+	if _, rest, ok := strings.Cut(field, "."); ok {
+		field = rest
+	}
+	params := Params{"field": field, "param": fe.Param()}
+	switch fe.Tag() {
+	case "required", "required_if", "required_unless", "required_with", "required_without":
+		return "validation.required", params
+	case "min", "gte":
+		return "validation.min", params
+	case "max", "lte":
+		return "validation.max", params
+	case "gt":
+		return "validation.gt", params
+	case "lt":
+		return "validation.lt", params
+	case "len":
+		return "validation.len", params
+	case "oneof":
+		params["param"] = strings.Join(strings.Fields(fe.Param()), ", ")
+		return "validation.oneof", params
+	case "email", "url", "uri", "uuid", "uuid4", "ip", "cidr", "hostname", "datetime", "hexadecimal", "alphanum":
+		params["param"] = fe.Tag()
+		return "validation.format", params
+	}
+	return "validation.failed", params
+}
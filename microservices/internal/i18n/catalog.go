@@ -0,0 +1,175 @@
+package i18n
+
+import "net/http"
+
+// catalog são as mensagens da API. O texto em inglês precisa ser idêntico
+// ao respondido pelos handlers, com {nome} no lugar das partes variáveis;
+// os modelos com marcadores são testados nesta ordem, então os específicos
+// vêm antes dos genéricos. Os códigos são parte da API: não os renomeie.
+var catalog = []message{
+	{"internal", "internal error", "erro interno"},
+
+	// Autenticação e acesso
+	{"auth.required", "authentication required", "autenticação necessária"},
+	{"auth.admin_required", "admin credentials required", "credenciais de administrador necessárias"},
+	{"auth.role_required", "role {role} required", "papel {role} necessário"},
+	{"auth.invalid_api_key", "invalid api key", "chave de API inválida"},
+	{"auth.invalid_signature", "invalid signature", "assinatura inválida"},
+	{"auth.link_expired", "link expired", "link expirado"},
+	{"auth.invalid_token", "invalid token", "token inválido"},
+	{"auth.invalid_refresh_token", "invalid refresh token", "refresh token inválido"},
+	{"auth.invalid_id_token", "invalid id token", "ID token inválido"},
+	{"auth.no_role", "no role is mapped to the user's groups", "nenhum papel está mapeado para os grupos do usuário"},
+	{"auth.login_state", "unknown or expired login state", "estado de login desconhecido ou expirado"},
+	{"auth.login_params", "code and state are required", "code e state são obrigatórios"},
+	{"auth.login_failed", "login failed: {cause}", "falha no login: {cause}"},
+	{"auth.provider_rejected", "identity provider rejected the request; log in again", "o provedor de identidade recusou a requisição; faça login novamente"},
+	{"auth.provider_unavailable", "identity provider is unavailable", "o provedor de identidade está indisponível"},
+	{"project.not_accessible", "project {project} is not accessible", "o projeto {project} não está acessível"},
+	{"maintenance.active", "service is in maintenance ({mode})", "serviço em manutenção ({mode})"},
+	{"dry_run.invalid_header", "invalid X-Dry-Run header: must be true or false", "cabeçalho X-Dry-Run inválido: use true ou false"},
+	{"dry_run.unsupported", "dry run is not supported for this operation", "esta operação não aceita ensaio (dry run)"},
+
+	// Agentes e simulações
+	{"agent.not_found", "agent not found", "agente não encontrado"},
+	{"agent.not_found", "agent: not found", "agente não encontrado"},
+	{"agent.validation", "agent: validation failed", "agente inválido"},
+	{"agent.id_not_found", "agent {agent} not found", "agente {agent} não encontrado"},
+	{"agent.invalid_id", `invalid agent id "{agent}"`, `id de agente inválido "{agent}"`},
+	{"agent.not_in_simulation", "agent is not in a simulation", "o agente não está em uma simulação"},
+	{"agent.not_in_given_simulation", "agent {agent} is not in simulation {simulation}", "o agente {agent} não está na simulação {simulation}"},
+	{"agent.already_in_simulation", "agent is already in simulation {simulation}", "o agente já está na simulação {simulation}"},
+	{"agent.changes_since_required", "since is required (use the cursor from /agents/changes/snapshot)", "since é obrigatório (use o cursor de /agents/changes/snapshot)"},
+	{"agent.changes_invalid_cursor", "invalid cursor: {cursor}", "cursor inválido: {cursor}"},
+	{"agent.changes_resync_required", "cursor is outside the retention window; full resync required", "o cursor está fora da janela de retenção; é preciso refazer a carga completa"},
+	{"agent.rate_limited", "agent {agent} exceeded its telemetry rate limit", "o agente {agent} passou do limite de reportes de telemetria"},
+	{"agent.ingestion_limit_negative", "limit must not be negative", "limit não pode ser negativo"},
+	{"agent.must_pause", "agent is {status}; pause it first or set force", "o agente está {status}; pause-o antes ou use force"},
+	{"simulation.not_found", "simulation not found", "simulação não encontrada"},
+	{"simulation.unknown", "unknown simulation_id: {simulation}", "simulation_id desconhecido: {simulation}"},
+	{"simulation.ended", "simulation has ended", "a simulação já terminou"},
+	{"fault.not_found", "fault not found", "falha não encontrada"},
+	{"fault.ended", "fault already ended", "a falha já terminou"},
+	{"fault.production", "fault injection on a production simulation requires the admin role", "injetar falhas numa simulação de produção exige o papel admin"},
+	{"fault.unknown_kind", "unknown fault kind: {kind}", "tipo de falha desconhecido: {kind}"},
+	{"fault.duration", "duration_seconds must be between 1 and {max}", "duration_seconds deve estar entre 1 e {max}"},
+	{"fault.percent", "percent must be greater than 0 and at most 100", "percent deve ser maior que 0 e no máximo 100"},
+	{"fault.latency", "latency_ms must be between 1 and {max}", "latency_ms deve estar entre 1 e {max}"},
+	{"fault.agents", "agents must be between 1 and {max}", "agents deve estar entre 1 e {max}"},
+	{"fault.jitter", "jitter_m must be greater than 0 and at most {max}", "jitter_m deve ser maior que 0 e no máximo {max}"},
+	{"simulation.not_quiesced", "simulation clock did not pause in time; try again", "o relógio da simulação não pausou a tempo; tente de novo"},
+
+	// Ações, agendamentos e lotes
+	{"action.not_found", "action not found", "ação não encontrada"},
+	{"action.queue_full", "action queue is full", "a fila de ações está cheia"},
+	{"action.finished", "action already {status}", "ação já {status}"},
+	{"action.unsupported", `action "{action}" is not supported by agent type "{type}"`, `a ação "{action}" não é suportada pelo tipo de agente "{type}"`},
+	{"action.invalid_params", `invalid params for action "{action}": {cause}`, `parâmetros inválidos para a ação "{action}": {cause}`},
+	{"action.invalid_priority", "invalid priority: {priority} (use critical, high, normal or low)", "prioridade inválida: {priority} (use critical, high, normal ou low)"},
+	{"action_batch.not_found", "action batch not found", "lote de ações não encontrado"},
+	{"action_batch.finished", "action batch already {status}", "lote de ações já {status}"},
+	{"schedule.not_found", "scheduled action not found", "agendamento não encontrado"},
+	{"schedule.run_at_past", "run_at must be in the future", "run_at deve estar no futuro"},
+	{"schedule.cron_never", "cron expression {cron} never matches", "a expressão cron {cron} nunca ocorre"},
+
+	// Grupos, transferências, mensagens e dependências
+	{"group.not_found", "group not found", "grupo não encontrado"},
+	{"group.name_taken", "group name already in use in the project", "nome de grupo já usado no projeto"},
+	{"agent_type.not_found", "agent type not found", "tipo de agente não encontrado"},
+	{"agent_type.name_taken", "agent type name already in use", "nome de tipo de agente já usado"},
+	{"agent_type.unknown", "unknown agent type: {type}", "tipo de agente desconhecido: {type}"},
+	{"agent_type.in_use", "agent type {type} is used by {agents} agents", "o tipo de agente {type} é usado por {agents} agentes"},
+	{"seed.unknown_types", "unknown agent types: {types}", "tipos de agente desconhecidos: {types}"},
+	{"seed.agents_per_type", "agents_per_type must be between 1 and {max}", "agents_per_type deve estar entre 1 e {max}"},
+	{"group.no_members", "group has no members", "o grupo não tem membros"},
+	{"group.member_not_found", "member not found", "membro não encontrado"},
+	{"group.too_many_members", "a group has at most {max} members", "um grupo tem no máximo {max} membros"},
+	{"group.member_gone", "member {agent} no longer exists; no member was changed", "o membro {agent} não existe mais; nenhum membro foi alterado"},
+	{"group.member_failed", "member {agent}: {cause}; no member was changed", "membro {agent}: {cause}; nenhum membro foi alterado"},
+	{"group.foreign_agents", "all agents must exist and belong to project {project}", "todos os agentes devem existir e pertencer ao projeto {project}"},
+	{"transfer.target_ended", "target simulation has ended", "a simulação de destino já terminou"},
+	{"transfer.other_project", "target simulation belongs to another project", "a simulação de destino é de outro projeto"},
+	{"transfer.moved", "agent or target simulation changed during the transfer", "o agente ou a simulação de destino mudou durante a transferência"},
+	{"transfer.target_full", "target simulation already has {agents} agents, the limit is {limit}", "a simulação de destino já tem {agents} agentes, o limite é {limit}"},
+	{"message.self", "an agent cannot message itself", "um agente não pode enviar mensagem a si mesmo"},
+	{"message.queue_full", "message queue full", "fila de mensagens cheia"},
+	{"dependency.self", "an agent cannot depend on itself", "um agente não pode depender de si mesmo"},
+	{"dependency.cycle", "dependency cycle: {path}", "ciclo de dependências: {path}"},
+	{"behavior.not_assigned", "agent has no behavior", "o agente não tem comportamento"},
+	{"behavior.unknown", `unknown behavior "{behavior}"`, `comportamento desconhecido "{behavior}"`},
+
+	// Projetos: cotas, backups e hooks
+	{"quota.exceeded", "project {project} exceeded its {resource} quota ({used} of {limit})", "o projeto {project} atingiu a cota de {resource} ({used} de {limit})"},
+	{"quota.no_override", "project has no quota override", "o projeto não tem cota própria"},
+	{"quota.negative", "quota limits must not be negative", "os limites de cota não podem ser negativos"},
+	{"backup.not_found", "backup not found", "backup não encontrado"},
+	{"backup.file_not_found", "backup file not found", "arquivo do backup não encontrado"},
+	{"backup.active_simulations", "project {project} has {count} active simulations; stop them or set force", "o projeto {project} tem {count} simulações em execução; pare-as ou use force"},
+	{"backup.restore_failed", "restore failed at {kind}", "a restauração falhou em {kind}"},
+	{"sync_hook.not_found", "sync hook not found", "hook síncrono não encontrado"},
+	{"sync_hook.rejected", "operation rejected by sync hook: {reason}", "operação recusada pelo hook síncrono: {reason}"},
+	{"sync_hook.unavailable", "sync hook is unavailable", "hook síncrono indisponível"},
+
+	// Demais recursos
+	{"webhook.not_found", "webhook not found", "webhook não encontrado"},
+	{"webhook.short_secret", "secret must have at least 16 characters", "o segredo deve ter pelo menos 16 caracteres"},
+	{"alert.not_found", "alert rule not found", "regra de alerta não encontrada"},
+	{"api_key.not_found", "api key not found", "chave de API não encontrada"},
+	{"notification.not_found", "notification settings not found", "configuração de notificações não encontrada"},
+	{"event_schema.not_found", "event schema not found", "schema de evento não encontrado"},
+	{"object.not_found", "object not found", "objeto não encontrado"},
+	{"device.not_registered", "device not registered", "dispositivo não registrado"},
+
+	// Parâmetros de consulta e intervalos
+	{"query.invalid_cursor", "invalid cursor", "cursor inválido"},
+	{"query.from_after_to", "from must be before to", "from deve ser anterior a to"},
+	{"query.from_after_to", "from must not be after to", "from não pode ser posterior a to"},
+	{"query.range_too_long", "time range exceeds {window}", "o intervalo excede {window}"},
+	{"query.range_too_long", "time range must be at most 366 days", "o intervalo deve ter no máximo 366 dias"},
+	{"query.too_many_samples", "time range has more than {max} samples; narrow from and to", "o intervalo tem mais de {max} amostras; reduza from e to"},
+	{"query.too_many_buckets", "time range has more than {max} buckets; use a larger bucket", "o intervalo tem mais de {max} buckets; use um bucket maior"},
+	{"query.invalid_timestamp", "invalid {field}: expected RFC 3339 timestamp", "{field} inválido: esperado timestamp RFC 3339"},
+	{"query.invalid_date", "invalid {field}: expected YYYY-MM-DD", "{field} inválido: esperado AAAA-MM-DD"},
+
+	// Validação do corpo e dos parâmetros; também as mensagens de BindError
+	{"validation.body", "invalid request body", "corpo da requisição inválido"},
+	{"validation.required", "{field} is required", "{field} é obrigatório"},
+	{"validation.min", "{field} must be at least {param}", "{field} deve ser no mínimo {param}"},
+	{"validation.max", "{field} must be at most {param}", "{field} deve ser no máximo {param}"},
+	{"validation.negative", "{field} must not be negative", "{field} não pode ser negativo"},
+	{"validation.empty", "{field} must not be empty", "{field} não pode ser vazio"},
+	{"validation.gt", "{field} must be greater than {param}", "{field} deve ser maior que {param}"},
+	{"validation.lt", "{field} must be less than {param}", "{field} deve ser menor que {param}"},
+	{"validation.len", "{field} must have length {param}", "{field} deve ter tamanho {param}"},
+	{"validation.oneof", "{field} must be one of {param}", "{field} deve ser um de {param}"},
+	{"validation.format", "{field} must be a valid {param}", "{field} deve ser um {param} válido"},
+	{"validation.type", "{field} must be of type {param}", "{field} deve ser do tipo {param}"},
+	{"validation.invalid", "invalid {field}: {value}", "{field} inválido: {value}"},
+	{"validation.failed", "{field} is invalid", "{field} é inválido"},
+}
+
+// statusCodes são os códigos das mensagens fora do catálogo.
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusConflict:              "conflict",
+	http.StatusRequestEntityTooLarge: "payload_too_large",
+	http.StatusUnprocessableEntity:   "unprocessable",
+	http.StatusTooManyRequests:       "too_many_requests",
+	http.StatusInternalServerError:   "internal",
+	http.StatusNotImplemented:        "not_implemented",
+	http.StatusServiceUnavailable:    "unavailable",
+}
+
+// statusCode é o código de uma mensagem fora do catálogo.
+func statusCode(status int) string {
+	if code, ok := statusCodes[status]; ok {
+		return code
+	}
+	if status >= 500 {
+		return "internal"
+	}
+	return "error"
+}
@@ -0,0 +1,195 @@
+// Package i18n traduz as mensagens de erro da API. Cada mensagem do
+// catálogo tem um código estável, devolvido em "code" independentemente do
+// idioma, e o texto em inglês e em português; o idioma da resposta vem do
+// Accept-Language, com i18n.default_locale quando o cabeçalho não informa
+// um idioma suportado.
+//
+// Os handlers continuam respondendo a mensagem em inglês: o Middleware a
+// reconhece pelo modelo em inglês do catálogo e a reescreve no idioma da
+// requisição. Mensagens fora do catálogo seguem em inglês, com um código
+// derivado do status HTTP.
+package i18n
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Idiomas suportados.
+const (
+	LocaleEN   = "en"
+	LocalePTBR = "pt-BR"
+)
+
+// Locales são os idiomas aceitos em i18n.default_locale.
+var Locales = []string{LocaleEN, LocalePTBR}
+
+// localeKey guarda no contexto do gin o idioma escolhido pelo Middleware.
+const localeKey = "i18n.locale"
+
+// Params são os valores dos marcadores {nome} de uma mensagem.
+type Params map[string]string
+
+// message é uma entrada do catálogo. en e pt usam os mesmos marcadores
+// {nome}; pt vazio cai para en. Um código pode ter mais de um texto em
+// inglês; Format usa o primeiro.
+type message struct {
+	code string
+	en   string
+	pt   string
+}
+
+// pattern reconhece uma mensagem em inglês com marcadores.
+type pattern struct {
+	msg   *message
+	re    *regexp.Regexp
+	names []string
+}
+
+var (
+	byCode   = map[string]*message{}
+	byText   = map[string]*message{}
+	patterns []pattern
+)
+
+var placeholder = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+func init() {
+	for i := range catalog {
+		m := &catalog[i]
+		if _, ok := byCode[m.code]; !ok {
+			byCode[m.code] = m
+		}
+		if !strings.Contains(m.en, "{") {
+			byText[m.en] = m
+			continue
+		}
+		p := pattern{msg: m}
+		var expr strings.Builder
+		expr.WriteString("^")
+		last := 0
+		for _, loc := range placeholder.FindAllStringSubmatchIndex(m.en, -1) {
+			expr.WriteString(regexp.QuoteMeta(m.en[last:loc[0]]))
+			expr.WriteString("(.+?)")
+			p.names = append(p.names, m.en[loc[2]:loc[3]])
+			last = loc[1]
+		}
+		expr.WriteString(regexp.QuoteMeta(m.en[last:]))
+		expr.WriteString("$")
+		p.re = regexp.MustCompile(expr.String())
+		patterns = append(patterns, p)
+	}
+}
+
+// lookup acha a entrada e os parâmetros de uma mensagem em inglês. Os
+// modelos são testados na ordem do catálogo, do mais específico ao mais
+// genérico.
+func lookup(text string) (*message, Params) {
+	if m, ok := byText[text]; ok {
+		return m, nil
+	}
+	for _, p := range patterns {
+		match := p.re.FindStringSubmatch(text)
+		if match == nil {
+			continue
+		}
+		params := Params{}
+		for i, name := range p.names {
+			params[name] = match[i+1]
+		}
+		return p.msg, params
+	}
+	return nil, nil
+}
+
+// Format monta a mensagem do código no idioma. Um código sem tradução cai
+// para o inglês; um código desconhecido retorna o próprio código.
+func Format(code, locale string, params Params) string {
+	m, ok := byCode[code]
+	if !ok {
+		return code
+	}
+	return m.format(locale, params)
+}
+
+func (m *message) format(locale string, params Params) string {
+	text := m.en
+	if locale == LocalePTBR && m.pt != "" {
+		text = m.pt
+	}
+	return placeholder.ReplaceAllStringFunc(text, func(s string) string {
+		if v, ok := params[s[1:len(s)-1]]; ok {
+			return v
+		}
+		return s
+	})
+}
+
+// Negotiate escolhe o idioma suportado de maior peso no Accept-Language:
+// "pt", "pt-BR" e "pt-PT" dão pt-BR, qualquer "en" dá en. Sem idioma
+// suportado, retorna fallback.
+func Negotiate(header, fallback string) string {
+	type candidate struct {
+		locale string
+		q      float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(header, ",") {
+		tag, q := strings.TrimSpace(part), 1.0
+		if i := strings.Index(tag, ";"); i >= 0 {
+			for _, param := range strings.Split(tag[i+1:], ";") {
+				if v, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+					if f, err := strconv.ParseFloat(v, 64); err == nil {
+						q = f
+					}
+				}
+			}
+			tag = strings.TrimSpace(tag[:i])
+		}
+		primary, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		var locale string
+		switch primary {
+		case "pt":
+			locale = LocalePTBR
+		case "en":
+			locale = LocaleEN
+		case "*":
+			locale = fallback
+		default:
+			continue
+		}
+		if q > 0 {
+			candidates = append(candidates, candidate{locale, q})
+		}
+	}
+	if len(candidates) == 0 {
+		return fallback
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+	return candidates[0].locale
+}
+
+// Locale retorna o idioma da requisição, escolhido pelo Middleware; fora
+// dele, negocia o Accept-Language com inglês como padrão.
+func Locale(c *gin.Context) string {
+	if v, ok := c.Get(localeKey); ok {
+		return v.(string)
+	}
+	return Negotiate(c.GetHeader("Accept-Language"), LocaleEN)
+}
+
+// Message monta a mensagem do código no idioma da requisição.
+func Message(c *gin.Context, code string, params Params) string {
+	return Format(code, Locale(c), params)
+}
+
+// Error responde {"error", "code"} com a mensagem do código no idioma da
+// requisição e interrompe a cadeia.
+func Error(c *gin.Context, status int, code string, params Params) {
+	c.Header("Content-Language", Locale(c))
+	c.AbortWithStatusJSON(status, gin.H{"error": Message(c, code, params), "code": code})
+}
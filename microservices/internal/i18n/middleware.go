@@ -0,0 +1,93 @@
+package i18n
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Middleware escolhe o idioma da requisição pelo Accept-Language, com
+// fallback quando o cabeçalho não traz um idioma suportado, e reescreve as
+// respostas de erro em JSON: uma mensagem reconhecida no catálogo ganha o
+// código e a tradução; as demais ganham o código do status e seguem em
+// inglês. Respostas que já trazem "code" (as de Error e BindError) e as
+// fora de JSON passam inalteradas. Deve vir antes dos middlewares que
+// respondem erros.
+func Middleware(fallback string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		locale := Negotiate(c.GetHeader("Accept-Language"), fallback)
+		c.Set(localeKey, locale)
+		w := &errorWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+		if w.buf != nil {
+			w.flush(locale)
+		}
+	}
+}
+
+// errorWriter retém o corpo das respostas de erro em JSON até o fim da
+// requisição; as demais, inclusive streams e websockets, vão direto ao
+// cliente.
+type errorWriter struct {
+	gin.ResponseWriter
+	decided bool
+	buf     *bytes.Buffer
+}
+
+func (w *errorWriter) Write(b []byte) (int, error) {
+	if !w.decided {
+		w.decided = true
+		if w.Status() >= 400 && strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+			w.buf = &bytes.Buffer{}
+		}
+	}
+	if w.buf != nil {
+		return w.buf.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *errorWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *errorWriter) Written() bool {
+	return w.buf != nil || w.ResponseWriter.Written()
+}
+
+// Unwrap dá a http.ResponseController o writer de baixo, para os handlers
+// que estendem o prazo de escrita, como o long-poll.
+func (w *errorWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *errorWriter) Size() int {
+	if w.buf != nil {
+		return w.buf.Len()
+	}
+	return w.ResponseWriter.Size()
+}
+
+// flush grava o corpo retido, reescrito com o código e a tradução.
+func (w *errorWriter) flush(locale string) {
+	body := w.buf.Bytes()
+	var obj map[string]json.RawMessage
+	var text string
+	if json.Unmarshal(body, &obj) == nil && obj["code"] == nil && json.Unmarshal(obj["error"], &text) == nil {
+		code, lang := statusCode(w.Status()), LocaleEN
+		if m, params := lookup(text); m != nil {
+			code, text, lang = m.code, m.format(locale, params), locale
+		}
+		obj["code"], _ = json.Marshal(code)
+		obj["error"], _ = json.Marshal(text)
+		if rewritten, err := json.Marshal(obj); err == nil {
+			body = rewritten
+			w.Header().Set("Content-Language", lang)
+		}
+	}
+	_, _ = w.ResponseWriter.Write(body)
+}
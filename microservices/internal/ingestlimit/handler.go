@@ -0,0 +1,132 @@
+package ingestlimit
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/audit"
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/i18n"
+	"smart-city-microservices/internal/logging"
+)
+
+// Handler expõe o limite de reportes de cada agente.
+type Handler struct {
+	limiter *Limiter
+}
+
+// NewHandler cria o handler de limites.
+func NewHandler(limiter *Limiter) *Handler {
+	return &Handler{limiter: limiter}
+}
+
+// SetRequest é o corpo de PUT /agents/:id/ingestion-limit. Limit é o
+// máximo de reportes por janela; zero deixa o agente sem limite.
+type SetRequest struct {
+	Limit *int `json:"limit" binding:"required"`
+}
+
+// View é a resposta das rotas de limite: o limite efetivo e os reportes
+// já contados na janela atual.
+type View struct {
+	AgentID   string `json:"agent_id"`
+	AgentType string `json:"agent_type"`
+	Limit
+	Reports int64 `json:"reports"`
+}
+
+// Get responde GET /agents/:id/ingestion-limit.
+func (h *Handler) Get(c *gin.Context) {
+	if ag, ok := h.agent(c); ok {
+		h.respond(c, ag)
+	}
+}
+
+// Set responde PUT /agents/:id/ingestion-limit: troca o limite do tipo por
+// um próprio do agente.
+func (h *Handler) Set(c *gin.Context) {
+	var req SetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		i18n.BindError(c, err)
+		return
+	}
+	if *req.Limit < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must not be negative"})
+		return
+	}
+	ag, ok := h.agent(c)
+	if !ok {
+		return
+	}
+	var by string
+	if p := auth.FromGin(c); p != nil {
+		by = p.Subject
+	}
+	ctx := c.Request.Context()
+	if err := h.limiter.repo.Set(ctx, ag.ID, *req.Limit, by); err != nil {
+		h.internalError(c, err)
+		return
+	}
+	h.limiter.invalidate()
+	audit.Record(ctx, "agent.ingestion_limit_set", logrus.Fields{"agent_id": ag.ID, "limit": *req.Limit})
+	h.respond(c, ag)
+}
+
+// Reset responde DELETE /agents/:id/ingestion-limit: o agente volta ao
+// limite do tipo.
+func (h *Handler) Reset(c *gin.Context) {
+	ag, ok := h.agent(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	had, err := h.limiter.repo.Delete(ctx, ag.ID)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	h.limiter.invalidate()
+	if had {
+		audit.Record(ctx, "agent.ingestion_limit_reset", logrus.Fields{"agent_id": ag.ID})
+	}
+	h.respond(c, ag)
+}
+
+func (h *Handler) respond(c *gin.Context, ag *agent.Agent) {
+	ctx := c.Request.Context()
+	lim, err := h.limiter.Effective(ctx, ag)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	n, err := h.limiter.Reports(ctx, ag.ID)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, View{AgentID: ag.ID, AgentType: ag.Type, Limit: lim, Reports: n})
+}
+
+// agent busca o agente de :id. Responde ao cliente e retorna false se ele
+// não existe ou a busca falhou.
+func (h *Handler) agent(c *gin.Context) (*agent.Agent, bool) {
+	ag, err := h.limiter.agents.GetAgent(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, agent.ErrNotFound) || (err == nil && ag == nil) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "agent not found"})
+		return nil, false
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return nil, false
+	}
+	return ag, true
+}
+
+func (h *Handler) internalError(c *gin.Context, err error) {
+	logging.FromContext(c.Request.Context()).WithError(err).Error("Erro no handler de limites de reporte")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
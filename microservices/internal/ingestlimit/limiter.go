@@ -0,0 +1,291 @@
+// Package ingestlimit limita a taxa de reportes de cada agente nos
+// endpoints de telemetria (PUT /agents/:id, amostras de métricas e estado
+// reportado do gêmeo digital), para que um sensor mal configurado não infle
+// as tabelas de eventos.
+//
+// O limite é de reportes por janela fixa, contados no Redis para valer
+// entre réplicas. Vem do tipo do agente (ou do padrão) e pode ser trocado
+// por agente. Acima dele, o reporte é recusado com 429 ou, nos tipos que
+// toleram perdas, amostrado: só 1 a cada SampleOneIn passa. O primeiro
+// excesso de cada janela publica EventRateLimited e penaliza a nota de
+// saúde do agente até o fim da janela.
+package ingestlimit
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/logging"
+)
+
+// EventRateLimited é publicado em events.TopicAgents quando um agente passa
+// do limite, uma vez por janela.
+const EventRateLimited = "agent.rate_limited"
+
+// Modos de tratar os reportes acima do limite.
+const (
+	ModeReject = "reject"
+	ModeSample = "sample"
+)
+
+// Origens do limite efetivo.
+const (
+	SourceAgent   = "agent"
+	SourceType    = "type"
+	SourceDefault = "default"
+)
+
+const keyPrefix = "agent-service:agents:"
+
+// cacheTTL limita por quanto tempo os limites por agente são reaproveitados.
+const cacheTTL = 30 * time.Second
+
+var limited = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "agent_service",
+	Name:      "telemetry_rate_limited_total",
+	Help:      "Reportes de telemetria acima do limite do agente, por tipo de agente e desfecho (rejected, sampled_out, sampled_in).",
+}, []string{"agent_type", "outcome"})
+
+// TypeLimit é o limite de um tipo de agente. Limit zero usa o padrão.
+// LossyTolerant amostra os reportes acima do limite em vez de recusá-los.
+type TypeLimit struct {
+	AgentType     string
+	Limit         int
+	LossyTolerant bool
+}
+
+// Config configura o limitador.
+type Config struct {
+	// Window é a janela da contagem.
+	Window time.Duration
+	// DefaultLimit vale para os tipos sem limite próprio; zero não limita.
+	DefaultLimit int
+	// SampleOneIn é o N de "1 a cada N" na amostragem.
+	SampleOneIn int
+}
+
+// AgentGetter é o subconjunto de agent.Service usado pelo limitador.
+type AgentGetter interface {
+	GetAgent(ctx context.Context, id string) (*agent.Agent, error)
+}
+
+// HealthReporter recebe os excessos de limite; *agenthealth.Tracker o
+// implementa.
+type HealthReporter interface {
+	RateLimited(ctx context.Context, ag *agent.Agent, until time.Time)
+}
+
+// Limit é o limite efetivo de um agente. Limit zero não limita.
+type Limit struct {
+	Limit         int    `json:"limit"`
+	WindowSeconds int    `json:"window_seconds"`
+	Mode          string `json:"mode"`
+	SampleOneIn   int    `json:"sample_one_in,omitempty"`
+	Source        string `json:"source"`
+}
+
+// Decision é o desfecho de um reporte.
+type Decision int
+
+// Desfechos de Check.
+const (
+	Allow Decision = iota
+	Reject
+	SampleOut
+)
+
+// Limiter conta os reportes dos agentes e aplica os limites.
+type Limiter struct {
+	redis     redis.UniversalClient
+	repo      *Repository
+	agents    AgentGetter
+	publisher events.Publisher
+	health    HealthReporter
+	types     map[string]TypeLimit
+	cfg       Config
+
+	mu       sync.Mutex
+	cache    map[string]int
+	cachedAt time.Time
+}
+
+// New cria o limitador com os limites por tipo de agente.
+func New(client redis.UniversalClient, repo *Repository, agents AgentGetter, publisher events.Publisher, health HealthReporter, types []TypeLimit, cfg Config) *Limiter {
+	l := &Limiter{
+		redis:     client,
+		repo:      repo,
+		agents:    agents,
+		publisher: publisher,
+		health:    health,
+		types:     make(map[string]TypeLimit, len(types)),
+		cfg:       cfg,
+	}
+	for _, t := range types {
+		l.types[t.AgentType] = t
+	}
+	return l
+}
+
+// Effective retorna o limite efetivo do agente.
+func (l *Limiter) Effective(ctx context.Context, ag *agent.Agent) (Limit, error) {
+	overrides, err := l.overrides(ctx)
+	if err != nil {
+		return Limit{}, err
+	}
+	t := l.types[ag.Type]
+	lim := Limit{Limit: l.cfg.DefaultLimit, WindowSeconds: int(l.cfg.Window / time.Second), Mode: ModeReject, Source: SourceDefault}
+	if t.Limit > 0 {
+		lim.Limit, lim.Source = t.Limit, SourceType
+	}
+	if n, ok := overrides[ag.ID]; ok {
+		lim.Limit, lim.Source = n, SourceAgent
+	}
+	if t.LossyTolerant {
+		lim.Mode, lim.SampleOneIn = ModeSample, l.cfg.SampleOneIn
+	}
+	return lim, nil
+}
+
+// Check conta um reporte do agente e decide se ele passa. Com Reject,
+// retryAfter é o que falta para a janela acabar.
+func (l *Limiter) Check(ctx context.Context, ag *agent.Agent) (d Decision, retryAfter time.Duration, err error) {
+	lim, err := l.Effective(ctx, ag)
+	if err != nil || lim.Limit <= 0 {
+		return Allow, 0, err
+	}
+	now := time.Now()
+	key, end := l.window(ag.ID, now)
+	var incr *redis.IntCmd
+	_, err = l.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, key)
+		pipe.ExpireAt(ctx, key, end.Add(time.Second))
+		return nil
+	})
+	if err != nil {
+		return Allow, 0, err
+	}
+	over := incr.Val() - int64(lim.Limit)
+	if over <= 0 {
+		return Allow, 0, nil
+	}
+	if over == 1 {
+		l.breached(ctx, ag, lim, end)
+	}
+	if lim.Mode == ModeSample {
+		if over%int64(lim.SampleOneIn) == 0 {
+			limited.WithLabelValues(ag.Type, "sampled_in").Inc()
+			return Allow, 0, nil
+		}
+		limited.WithLabelValues(ag.Type, "sampled_out").Inc()
+		return SampleOut, 0, nil
+	}
+	limited.WithLabelValues(ag.Type, "rejected").Inc()
+	return Reject, end.Sub(now), nil
+}
+
+// Reports retorna os reportes do agente já contados na janela atual.
+func (l *Limiter) Reports(ctx context.Context, agentID string) (int64, error) {
+	key, _ := l.window(agentID, time.Now())
+	n, err := l.redis.Get(ctx, key).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return n, err
+}
+
+// window retorna a chave do contador do agente na janela de now e o fim
+// dela.
+func (l *Limiter) window(agentID string, now time.Time) (string, time.Time) {
+	w := now.UnixNano() / int64(l.cfg.Window)
+	return keyPrefix + agentID + ":ingest:" + strconv.FormatInt(w, 10), time.Unix(0, (w+1)*int64(l.cfg.Window))
+}
+
+// breached registra o primeiro excesso da janela: só a réplica que contou
+// o reporte logo acima do limite chega aqui.
+func (l *Limiter) breached(ctx context.Context, ag *agent.Agent, lim Limit, until time.Time) {
+	logging.FromContext(ctx).WithFields(logrus.Fields{
+		"agent_id": ag.ID, "agent_type": ag.Type, "limit": lim.Limit, "mode": lim.Mode,
+	}).Warn("Agente passou do limite de reportes de telemetria")
+	l.publisher.Publish(ctx, events.New(events.TopicAgents, EventRateLimited, events.AgentRateLimitedV1{
+		AgentID:       ag.ID,
+		AgentType:     ag.Type,
+		ProjectID:     ag.ProjectID,
+		Limit:         lim.Limit,
+		WindowSeconds: lim.WindowSeconds,
+		Mode:          lim.Mode,
+		Source:        lim.Source,
+		Until:         until.UTC(),
+	}))
+	if l.health != nil {
+		l.health.RateLimited(ctx, ag, until)
+	}
+}
+
+// Middleware aplica o limite aos reportes do agente de :id. Recusados
+// recebem 429 com Retry-After; descartados pela amostragem, 202 sem que o
+// handler rode. Agentes que não existem seguem para o handler, que
+// responde 404. Falhas do Redis ou do banco deixam o reporte passar.
+func (l *Limiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		ag, err := l.agents.GetAgent(ctx, c.Param("id"))
+		if errors.Is(err, agent.ErrNotFound) || (err == nil && ag == nil) {
+			c.Next()
+			return
+		}
+		var d Decision
+		var retryAfter time.Duration
+		if err == nil {
+			d, retryAfter, err = l.Check(ctx, ag)
+		}
+		if err != nil {
+			logging.FromContext(ctx).WithError(err).WithField("agent_id", c.Param("id")).Warn("Falha ao conferir o limite de reportes do agente")
+			c.Next()
+			return
+		}
+		switch d {
+		case Reject:
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "agent " + ag.ID + " exceeded its telemetry rate limit"})
+		case SampleOut:
+			c.AbortWithStatusJSON(http.StatusAccepted, gin.H{"agent_id": ag.ID, "sampled_out": true})
+		default:
+			c.Next()
+		}
+	}
+}
+
+// overrides retorna os limites por agente, relidos do banco a cada
+// cacheTTL ou depois de uma alteração nesta réplica.
+func (l *Limiter) overrides(ctx context.Context) (map[string]int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.cache != nil && time.Since(l.cachedAt) < cacheTTL {
+		return l.cache, nil
+	}
+	m, err := l.repo.All(ctx)
+	if err != nil {
+		return nil, err
+	}
+	l.cache, l.cachedAt = m, time.Now()
+	return m, nil
+}
+
+func (l *Limiter) invalidate() {
+	l.mu.Lock()
+	l.cache = nil
+	l.mu.Unlock()
+}
@@ -0,0 +1,64 @@
+package ingestlimit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"smart-city-microservices/internal/instrument"
+)
+
+// Repository persiste os limites por agente no PostgreSQL.
+type Repository struct {
+	db *instrument.DB
+}
+
+// NewRepository cria o repositório.
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: instrument.NewDB(db)}
+}
+
+// All retorna o limite de cada agente que tem um próprio.
+func (r *Repository) All(ctx context.Context) (map[string]int, error) {
+	rows, err := r.db.Query(ctx, "ingest_limit.all",
+		`SELECT agent_id, report_limit FROM agent_ingestion_limits`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string]int{}
+	for rows.Next() {
+		var id string
+		var n int
+		if err := rows.Scan(&id, &n); err != nil {
+			return nil, err
+		}
+		out[id] = n
+	}
+	return out, rows.Err()
+}
+
+// Set grava o limite próprio do agente.
+func (r *Repository) Set(ctx context.Context, agentID string, limit int, updatedBy string) error {
+	_, err := r.db.Exec(ctx, "ingest_limit.set", `
+		INSERT INTO agent_ingestion_limits (agent_id, report_limit, updated_by)
+		VALUES ($1, $2, NULLIF($3, ''))
+		ON CONFLICT (agent_id) DO UPDATE SET
+			report_limit = EXCLUDED.report_limit,
+			updated_by = EXCLUDED.updated_by,
+			updated_at = CURRENT_TIMESTAMP`,
+		agentID, limit, updatedBy)
+	return err
+}
+
+// Delete remove o limite próprio do agente; ok é falso se ele não tinha.
+func (r *Repository) Delete(ctx context.Context, agentID string) (ok bool, err error) {
+	var id string
+	err = r.db.QueryRow(ctx, "ingest_limit.delete",
+		`DELETE FROM agent_ingestion_limits WHERE agent_id = $1 RETURNING agent_id`, agentID,
+	).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,30 @@
+package instance
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Handler expõe a listagem de instâncias vivas.
+type Handler struct {
+	heartbeat *Heartbeat
+}
+
+// NewHandler cria o handler de instâncias.
+func NewHandler(heartbeat *Heartbeat) *Handler {
+	return &Handler{heartbeat: heartbeat}
+}
+
+// ListInstances retorna as instâncias registradas e vivas.
+func (h *Handler) ListInstances(c *gin.Context) {
+	instances, err := h.heartbeat.List(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list instances"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"self":      h.heartbeat.ID(),
+		"instances": instances,
+	})
+}
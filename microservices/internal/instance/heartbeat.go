@@ -0,0 +1,212 @@
+// Package instance registra esta instância do agent-service no Redis para que
+// o API gateway descubra as réplicas vivas.
+package instance
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/logging"
+)
+
+const (
+	keyPrefix = "agent-service:instance:"
+	indexKey  = "agent-service:instances"
+)
+
+// Tipos de evento emitidos pelo heartbeat.
+const (
+	EventInstanceLost = "instance.lost"
+)
+
+// Info descreve uma instância registrada.
+type Info struct {
+	ID           string    `json:"id"`
+	Host         string    `json:"host"`
+	Port         string    `json:"port"`
+	Version      string    `json:"version"`
+	StartedAt    time.Time `json:"started_at"`
+	Capabilities []string  `json:"capabilities"`
+	LastSeen     time.Time `json:"last_seen"`
+}
+
+// Config controla a frequência do heartbeat e a validade do registro.
+type Config struct {
+	Interval time.Duration
+	TTL      time.Duration
+}
+
+// Heartbeat mantém o registro desta instância e detecta instâncias perdidas.
+type Heartbeat struct {
+	client    redis.UniversalClient
+	cfg       Config
+	info      Info
+	publisher events.Publisher
+	done      chan struct{}
+	stopped   chan struct{}
+}
+
+// NewInfo monta a descrição desta instância.
+func NewInfo(port, version string, capabilities []string) Info {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return Info{
+		ID:           uuid.NewString(),
+		Host:         host,
+		Port:         port,
+		Version:      version,
+		StartedAt:    time.Now().UTC(),
+		Capabilities: capabilities,
+	}
+}
+
+// NewHeartbeat cria o heartbeat. O TTL deve ser maior que o intervalo.
+func NewHeartbeat(client redis.UniversalClient, cfg Config, info Info, publisher events.Publisher) *Heartbeat {
+	if cfg.TTL <= cfg.Interval {
+		cfg.TTL = 3 * cfg.Interval
+	}
+	return &Heartbeat{
+		client:    client,
+		cfg:       cfg,
+		info:      info,
+		publisher: publisher,
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+}
+
+// ID retorna o id desta instância.
+func (h *Heartbeat) ID() string {
+	return h.info.ID
+}
+
+// Start registra a instância e inicia a renovação periódica.
+func (h *Heartbeat) Start(ctx context.Context) error {
+	if err := h.beat(ctx); err != nil {
+		return fmt.Errorf("registrar instância: %w", err)
+	}
+	go h.loop()
+	return nil
+}
+
+// Stop interrompe o heartbeat e remove o registro explicitamente.
+func (h *Heartbeat) Stop(ctx context.Context) error {
+	close(h.done)
+	<-h.stopped
+	pipe := h.client.TxPipeline()
+	pipe.Del(ctx, keyPrefix+h.info.ID)
+	pipe.SRem(ctx, indexKey, h.info.ID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (h *Heartbeat) loop() {
+	defer close(h.stopped)
+	ctx := logging.Background(context.Background(), "instance-heartbeat")
+	log := logging.FromContext(ctx).WithField("instance_id", h.info.ID)
+
+	ticker := time.NewTicker(h.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.done:
+			return
+		case <-ticker.C:
+			if err := h.beat(ctx); err != nil {
+				log.WithError(err).Warn("Falha ao renovar registro da instância")
+				continue
+			}
+			if err := h.reapLost(ctx); err != nil {
+				log.WithError(err).Warn("Falha ao verificar instâncias perdidas")
+			}
+		}
+	}
+}
+
+func (h *Heartbeat) beat(ctx context.Context) error {
+	h.info.LastSeen = time.Now().UTC()
+	payload, err := json.Marshal(h.info)
+	if err != nil {
+		return err
+	}
+	pipe := h.client.TxPipeline()
+	pipe.Set(ctx, keyPrefix+h.info.ID, payload, h.cfg.TTL)
+	pipe.SAdd(ctx, indexKey, h.info.ID)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// reapLost remove do índice as instâncias cujo registro expirou. Apenas a
+// réplica que efetivamente remove o id emite o evento, evitando duplicatas.
+func (h *Heartbeat) reapLost(ctx context.Context) error {
+	ids, err := h.client.SMembers(ctx, indexKey).Result()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		exists, err := h.client.Exists(ctx, keyPrefix+id).Result()
+		if err != nil {
+			return err
+		}
+		if exists > 0 {
+			continue
+		}
+		removed, err := h.client.SRem(ctx, indexKey, id).Result()
+		if err != nil {
+			return err
+		}
+		if removed > 0 {
+			logging.FromContext(ctx).WithField("lost_instance_id", id).Warn("Instância perdida (registro expirou)")
+			if h.publisher != nil {
+				h.publisher.Publish(ctx, events.New(events.TopicAdmin, EventInstanceLost, events.InstanceLostV1{ID: id}))
+			}
+		}
+	}
+	return nil
+}
+
+// List retorna as instâncias com registro válido, ordenadas por início.
+func (h *Heartbeat) List(ctx context.Context) ([]Info, error) {
+	ids, err := h.client.SMembers(ctx, indexKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return []Info{}, nil
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = keyPrefix + id
+	}
+	values, err := h.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	instances := make([]Info, 0, len(values))
+	for _, v := range values {
+		raw, ok := v.(string)
+		if !ok {
+			continue
+		}
+		var info Info
+		if err := json.Unmarshal([]byte(raw), &info); err != nil {
+			logrus.WithError(err).Warn("Registro de instância inválido no Redis")
+			continue
+		}
+		instances = append(instances, info)
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].StartedAt.Before(instances[j].StartedAt) })
+	return instances, nil
+}
@@ -0,0 +1,65 @@
+package instrument
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+
+	"smart-city-microservices/internal/logging"
+)
+
+var (
+	grpcRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "grpc_requests_total",
+		Help:      "Chamadas gRPC concluídas por método e código de status.",
+	}, []string{"method", "code"})
+
+	grpcDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "agent_service",
+		Name:      "grpc_request_duration_seconds",
+		Help:      "Duração das chamadas gRPC unárias por método.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+)
+
+// UnaryServerInterceptor é o equivalente gRPC de Middleware: conta as
+// chamadas por código, mede a duração e avisa quando o limite de lentidão
+// de requisições é excedido.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+
+		elapsed := time.Since(start)
+		code := status.Code(err)
+		grpcRequests.WithLabelValues(info.FullMethod, code.String()).Inc()
+		grpcDuration.WithLabelValues(info.FullMethod).Observe(elapsed.Seconds())
+
+		if _, threshold := Thresholds(); elapsed >= threshold {
+			slowRequests.WithLabelValues("GRPC", info.FullMethod).Inc()
+			logging.FromContext(ctx).WithFields(logrus.Fields{
+				"grpc_method":  info.FullMethod,
+				"grpc_code":    code.String(),
+				"duration_ms":  elapsed.Milliseconds(),
+				"threshold_ms": threshold.Milliseconds(),
+			}).Warn("Requisição lenta")
+		}
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor conta os streams encerrados por código. Streams são
+// longos por natureza e não entram no histograma nem no aviso de lentidão.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		err := handler(srv, ss)
+		grpcRequests.WithLabelValues(info.FullMethod, status.Code(err).String()).Inc()
+		return err
+	}
+}
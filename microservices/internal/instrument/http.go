@@ -0,0 +1,101 @@
+package instrument
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/logging"
+)
+
+// Cabeçalhos das estatísticas por requisição: com HeaderDebug: true e papel
+// admin, a resposta traz HeaderDebugStats.
+const (
+	HeaderDebug      = "X-Debug"
+	HeaderDebugStats = "X-Debug-Stats"
+)
+
+// Middleware conta o trabalho de cada requisição (ver Stats), registra os
+// contadores no log em nível debug e um aviso para requisições acima do
+// limite de lentidão.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		ctx, stats := WithStats(c.Request.Context())
+		c.Request = c.Request.WithContext(ctx)
+		if strings.EqualFold(c.GetHeader(HeaderDebug), "true") {
+			c.Writer = &debugWriter{ResponseWriter: c.Writer, c: c, stats: stats}
+		}
+		c.Next()
+
+		elapsed := time.Since(start)
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		requestQueries.WithLabelValues(route).Observe(float64(stats.Queries()))
+		fields := stats.Fields()
+		fields["method"] = c.Request.Method
+		fields["route"] = route
+		fields["status"] = c.Writer.Status()
+		fields["duration_ms"] = elapsed.Milliseconds()
+		log := logging.FromContext(c.Request.Context())
+
+		_, threshold := Thresholds()
+		if elapsed < threshold {
+			log.WithFields(fields).Debug("Estatísticas da requisição")
+			return
+		}
+		slowRequests.WithLabelValues(c.Request.Method, route).Inc()
+		fields["threshold_ms"] = threshold.Milliseconds()
+		log.WithFields(fields).Warn("Requisição lenta")
+	}
+}
+
+// debugWriter grava HeaderDebugStats antes do primeiro byte da resposta,
+// quando o principal já foi autenticado. Os contadores são os do momento da
+// escrita: trabalho feito depois dela, como num streaming, fica de fora.
+type debugWriter struct {
+	gin.ResponseWriter
+	c     *gin.Context
+	stats *Stats
+	done  bool
+}
+
+func (w *debugWriter) inject() {
+	if w.done {
+		return
+	}
+	w.done = true
+	if auth.FromGin(w.c).HasRole(auth.RoleAdmin) {
+		w.Header().Set(HeaderDebugStats, w.stats.String())
+	}
+}
+
+func (w *debugWriter) WriteHeaderNow() {
+	w.inject()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *debugWriter) Write(b []byte) (int, error) {
+	w.inject()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *debugWriter) WriteString(s string) (int, error) {
+	w.inject()
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *debugWriter) Flush() {
+	w.inject()
+	w.ResponseWriter.Flush()
+}
+
+// Unwrap dá a http.ResponseController o writer de baixo.
+func (w *debugWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
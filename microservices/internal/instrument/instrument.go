@@ -0,0 +1,69 @@
+// Package instrument mede a duração de consultas ao banco e de requisições
+// HTTP, registrando avisos estruturados e métricas quando limites são excedidos.
+package instrument
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Limites padrão para considerar uma operação lenta.
+const (
+	DefaultSlowQueryThreshold   = 200 * time.Millisecond
+	DefaultSlowRequestThreshold = 2 * time.Second
+)
+
+var (
+	slowQueryThreshold   atomic.Int64
+	slowRequestThreshold atomic.Int64
+)
+
+func init() {
+	SetThresholds(DefaultSlowQueryThreshold, DefaultSlowRequestThreshold)
+}
+
+var (
+	slowQueries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "slow_queries_total",
+		Help:      "Consultas ao banco que excederam o limite de lentidão.",
+	}, []string{"query"})
+
+	slowRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "slow_requests_total",
+		Help:      "Requisições HTTP que excederam o limite de lentidão.",
+	}, []string{"method", "route"})
+
+	queryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "agent_service",
+		Name:      "query_duration_seconds",
+		Help:      "Duração das consultas ao banco por nome de statement.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"query"})
+
+	requestQueries = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "agent_service",
+		Name:      "request_db_queries",
+		Help:      "Consultas ao banco feitas por requisição HTTP, por rota.",
+		Buckets:   []float64{0, 1, 2, 5, 10, 20, 50, 100},
+	}, []string{"route"})
+)
+
+// SetThresholds altera os limites de lentidão. Valores <= 0 mantêm o atual.
+func SetThresholds(query, request time.Duration) {
+	if query > 0 {
+		slowQueryThreshold.Store(int64(query))
+	}
+	if request > 0 {
+		slowRequestThreshold.Store(int64(request))
+	}
+}
+
+// Thresholds retorna os limites de lentidão ativos.
+func Thresholds() (query, request time.Duration) {
+	return time.Duration(slowQueryThreshold.Load()), time.Duration(slowRequestThreshold.Load())
+}
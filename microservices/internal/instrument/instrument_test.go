@@ -0,0 +1,194 @@
+package instrument
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"smart-city-microservices/internal/auth"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+	sql.Register("instrumenttest", testDriver{})
+}
+
+// testDriver é um banco em que toda consulta devolve uma linha com o nome
+// do agente e todo statement afeta uma linha.
+type testDriver struct{}
+
+func (testDriver) Open(string) (driver.Conn, error) { return testConn{}, nil }
+
+type testConn struct{}
+
+func (testConn) Prepare(string) (driver.Stmt, error) { return testStmt{}, nil }
+func (testConn) Close() error                        { return nil }
+func (testConn) Begin() (driver.Tx, error)           { return testConn{}, nil }
+func (testConn) Commit() error                       { return nil }
+func (testConn) Rollback() error                     { return nil }
+
+type testStmt struct{}
+
+func (testStmt) Close() error                               { return nil }
+func (testStmt) NumInput() int                              { return -1 }
+func (testStmt) Exec([]driver.Value) (driver.Result, error) { return driver.RowsAffected(1), nil }
+func (testStmt) Query([]driver.Value) (driver.Rows, error)  { return &testRows{}, nil }
+
+type testRows struct{ done bool }
+
+func (*testRows) Columns() []string { return []string{"name"} }
+func (*testRows) Close() error      { return nil }
+func (r *testRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = "Ônibus 1"
+	return nil
+}
+
+// agentReader lê o nome do agente com cache no Redis, como o GetAgent do
+// serviço: um acerto responde do Redis; uma falta consulta o banco e grava
+// o cache.
+type agentReader struct {
+	db    *DB
+	cache redis.UniversalClient
+}
+
+func (r agentReader) name(ctx context.Context, id string) (string, error) {
+	cached, err := r.cache.Get(ctx, "agent:"+id).Result()
+	if err == nil {
+		CacheHit(ctx)
+		return cached, nil
+	}
+	if !errors.Is(err, redis.Nil) {
+		return "", err
+	}
+	CacheMiss(ctx)
+	var name string
+	if err := r.db.QueryRow(ctx, "agent.get", "SELECT name FROM agents WHERE id = $1", id).Scan(&name); err != nil {
+		return "", err
+	}
+	return name, r.cache.Set(ctx, "agent:"+id, name, 0).Err()
+}
+
+func newReader(t *testing.T) (agentReader, *miniredis.Miniredis) {
+	t.Helper()
+	db, err := sql.Open("instrumenttest", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	srv := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	client.AddHook(RedisHook{})
+	t.Cleanup(func() { client.Close() })
+	return agentReader{db: NewDB(db), cache: client}, srv
+}
+
+func newRouter(r agentReader) *gin.Engine {
+	router := gin.New()
+	router.Use(Middleware())
+	router.Use(auth.StaticToken("secret"))
+	router.GET("/agents/:id", func(c *gin.Context) {
+		name, err := r.name(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"name": name})
+	})
+	return router
+}
+
+// TestGetAgentStats confere os contadores da leitura de um agente: com o
+// cache frio, uma consulta ao banco e o GET e o SET no Redis; com ele
+// quente, nenhuma consulta e só o GET.
+func TestGetAgentStats(t *testing.T) {
+	tests := []struct {
+		name string
+		warm bool
+		want string
+	}{
+		{"cold", false, "db_queries=1; db_rows=1; cache_hits=0; cache_misses=1; redis_commands=2"},
+		{"warm", true, "db_queries=0; db_rows=0; cache_hits=1; cache_misses=0; redis_commands=1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader, srv := newReader(t)
+			if tt.warm {
+				srv.Set("agent:agent-1", "Ônibus 1")
+			}
+			ctx, stats := WithStats(context.Background())
+			name, err := reader.name(ctx, "agent-1")
+			if err != nil || name != "Ônibus 1" {
+				t.Fatalf("name = %q, %v", name, err)
+			}
+			if got := stats.String(); got != tt.want {
+				t.Errorf("stats %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDebugStatsHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    string
+	}{
+		{"admin", map[string]string{HeaderDebug: "true", "X-Admin-Token": "secret"}, "db_queries=1; db_rows=1; cache_hits=0; cache_misses=1; redis_commands=2"},
+		{"not admin", map[string]string{HeaderDebug: "true"}, ""},
+		{"not requested", map[string]string{"X-Admin-Token": "secret"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader, _ := newReader(t)
+			req := httptest.NewRequest(http.MethodGet, "/agents/agent-1", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			newRouter(reader).ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", w.Code, w.Body.String())
+			}
+			if got := w.Header().Get(HeaderDebugStats); got != tt.want {
+				t.Errorf("%s = %q, want %q", HeaderDebugStats, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedisHookCountsPipelines(t *testing.T) {
+	reader, _ := newReader(t)
+	ctx, stats := WithStats(context.Background())
+	_, err := reader.cache.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for _, k := range []string{"a", "b", "c"} {
+			p.Set(ctx, k, 1, 0)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader.cache.Get(ctx, "a")
+	// Fora de uma requisição nada é contado.
+	reader.cache.Get(context.Background(), "a")
+	if got := stats.Fields()["redis_commands"]; got != int64(4) {
+		t.Errorf("redis_commands = %v, want 4", got)
+	}
+	if !strings.Contains(stats.String(), "redis_commands=4") {
+		t.Errorf("String() = %q", stats.String())
+	}
+}
@@ -0,0 +1,161 @@
+package instrument
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/logging"
+)
+
+// QuerySpan mede uma chamada ao repositório.
+type QuerySpan struct {
+	ctx    context.Context
+	name   string
+	params []string
+	start  time.Time
+}
+
+// StartQuery inicia a medição de um statement identificado por name. params
+// lista apenas os nomes dos parâmetros/filtros usados, nunca os valores.
+func StartQuery(ctx context.Context, name string, params ...string) *QuerySpan {
+	return &QuerySpan{ctx: ctx, name: name, params: params, start: time.Now()}
+}
+
+// End encerra a medição. rows < 0 indica contagem desconhecida.
+func (s *QuerySpan) End(rows int64, err error) {
+	elapsed := time.Since(s.start)
+	queryDuration.WithLabelValues(s.name).Observe(elapsed.Seconds())
+	if stats := StatsFrom(s.ctx); stats != nil {
+		stats.query(rows)
+	}
+
+	threshold, _ := Thresholds()
+	if elapsed < threshold {
+		return
+	}
+	slowQueries.WithLabelValues(s.name).Inc()
+
+	fields := logrus.Fields{
+		"query":        s.name,
+		"params":       s.params,
+		"duration_ms":  elapsed.Milliseconds(),
+		"threshold_ms": threshold.Milliseconds(),
+	}
+	if rows >= 0 {
+		fields["rows"] = rows
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		fields["error"] = err.Error()
+	}
+	logging.FromContext(s.ctx).WithFields(fields).Warn("Consulta lenta")
+}
+
+// DB envolve um *sql.DB medindo cada statement pelo nome informado. Com um
+// contexto de WithTx, os statements rodam na transação dele.
+type DB struct {
+	*sql.DB
+}
+
+type txKey struct{}
+
+// WithTx faz os statements de DB com o contexto retornado rodarem em tx,
+// inclusive os dos repositórios que não sabem dela. É o que permite ao
+// modo de ensaio (dry run) executar uma operação inteira numa transação
+// que depois é desfeita.
+func WithTx(ctx context.Context, tx *sql.Tx) context.Context {
+	return context.WithValue(ctx, txKey{}, tx)
+}
+
+// TxFrom retorna a transação de WithTx, ou nil.
+func TxFrom(ctx context.Context) *sql.Tx {
+	tx, _ := ctx.Value(txKey{}).(*sql.Tx)
+	return tx
+}
+
+type conn interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func (db *DB) conn(ctx context.Context) conn {
+	if tx := TxFrom(ctx); tx != nil {
+		return tx
+	}
+	return db.DB
+}
+
+// Tx é uma transação aberta por Begin.
+type Tx struct {
+	*sql.Tx
+	outer bool
+}
+
+// Begin abre uma transação. Num contexto de WithTx, devolve a transação
+// dele: Commit e Rollback não fazem nada e o desfecho fica com quem a abriu.
+func (db *DB) Begin(ctx context.Context) (*Tx, error) {
+	if tx := TxFrom(ctx); tx != nil {
+		return &Tx{Tx: tx, outer: true}, nil
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{Tx: tx}, nil
+}
+
+// Commit confirma a transação, se ela não é a de WithTx.
+func (tx *Tx) Commit() error {
+	if tx.outer {
+		return nil
+	}
+	return tx.Tx.Commit()
+}
+
+// Rollback desfaz a transação, se ela não é a de WithTx.
+func (tx *Tx) Rollback() error {
+	if tx.outer {
+		return nil
+	}
+	return tx.Tx.Rollback()
+}
+
+// NewDB cria o wrapper instrumentado.
+func NewDB(db *sql.DB) *DB {
+	return &DB{DB: db}
+}
+
+// Exec executa um statement nomeado.
+func (db *DB) Exec(ctx context.Context, name, query string, args ...interface{}) (sql.Result, error) {
+	span := StartQuery(ctx, name)
+	res, err := db.conn(ctx).ExecContext(ctx, query, args...)
+	rows := int64(-1)
+	if err == nil {
+		if n, rerr := res.RowsAffected(); rerr == nil {
+			rows = n
+		}
+	}
+	span.End(rows, err)
+	return res, err
+}
+
+// Query executa uma consulta nomeada. A contagem de linhas não é conhecida
+// aqui; use StartQuery diretamente quando ela for relevante.
+func (db *DB) Query(ctx context.Context, name, query string, args ...interface{}) (*sql.Rows, error) {
+	span := StartQuery(ctx, name)
+	rows, err := db.conn(ctx).QueryContext(ctx, query, args...)
+	span.End(-1, err)
+	return rows, err
+}
+
+// QueryRow executa uma consulta nomeada de linha única.
+func (db *DB) QueryRow(ctx context.Context, name, query string, args ...interface{}) *sql.Row {
+	span := StartQuery(ctx, name)
+	row := db.conn(ctx).QueryRowContext(ctx, query, args...)
+	span.End(1, row.Err())
+	return row
+}
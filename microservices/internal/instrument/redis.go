@@ -0,0 +1,35 @@
+package instrument
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisHook conta os comandos Redis de cada requisição. Registre com
+// client.AddHook(instrument.RedisHook{}); um pipeline conta um comando por
+// item.
+type RedisHook struct{}
+
+// DialHook implementa redis.Hook.
+func (RedisHook) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+// ProcessHook implementa redis.Hook.
+func (RedisHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if s := StatsFrom(ctx); s != nil {
+			s.redisCommands.Add(1)
+		}
+		return next(ctx, cmd)
+	}
+}
+
+// ProcessPipelineHook implementa redis.Hook.
+func (RedisHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		if s := StatsFrom(ctx); s != nil {
+			s.redisCommands.Add(int64(len(cmds)))
+		}
+		return next(ctx, cmds)
+	}
+}
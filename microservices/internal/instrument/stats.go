@@ -0,0 +1,77 @@
+package instrument
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Stats conta o trabalho feito por uma requisição: consultas ao banco,
+// linhas conhecidas, acertos e faltas de cache e comandos Redis. É criado
+// pelo Middleware e alimentado por DB, StartQuery, RedisHook, CacheHit e
+// CacheMiss com o contexto da requisição.
+type Stats struct {
+	queries       atomic.Int64
+	rows          atomic.Int64
+	cacheHits     atomic.Int64
+	cacheMisses   atomic.Int64
+	redisCommands atomic.Int64
+}
+
+type statsKey struct{}
+
+// WithStats associa contadores novos ao contexto.
+func WithStats(ctx context.Context) (context.Context, *Stats) {
+	s := &Stats{}
+	return context.WithValue(ctx, statsKey{}, s), s
+}
+
+// StatsFrom retorna os contadores do contexto, ou nil fora de uma requisição.
+func StatsFrom(ctx context.Context) *Stats {
+	s, _ := ctx.Value(statsKey{}).(*Stats)
+	return s
+}
+
+// CacheHit conta um acerto de cache na requisição de ctx.
+func CacheHit(ctx context.Context) {
+	if s := StatsFrom(ctx); s != nil {
+		s.cacheHits.Add(1)
+	}
+}
+
+// CacheMiss conta uma falta de cache na requisição de ctx.
+func CacheMiss(ctx context.Context) {
+	if s := StatsFrom(ctx); s != nil {
+		s.cacheMisses.Add(1)
+	}
+}
+
+func (s *Stats) query(rows int64) {
+	s.queries.Add(1)
+	if rows > 0 {
+		s.rows.Add(rows)
+	}
+}
+
+// Queries retorna as consultas feitas até agora.
+func (s *Stats) Queries() int64 { return s.queries.Load() }
+
+// Fields retorna os contadores como campos de log.
+func (s *Stats) Fields() logrus.Fields {
+	return logrus.Fields{
+		"db_queries":     s.queries.Load(),
+		"db_rows":        s.rows.Load(),
+		"cache_hits":     s.cacheHits.Load(),
+		"cache_misses":   s.cacheMisses.Load(),
+		"redis_commands": s.redisCommands.Load(),
+	}
+}
+
+// String é o valor de X-Debug-Stats. db_rows soma só as linhas de contagem
+// conhecida (Exec, QueryRow e StartQuery com rows), por isso é uma estimativa.
+func (s *Stats) String() string {
+	return fmt.Sprintf("db_queries=%d; db_rows=%d; cache_hits=%d; cache_misses=%d; redis_commands=%d",
+		s.queries.Load(), s.rows.Load(), s.cacheHits.Load(), s.cacheMisses.Load(), s.redisCommands.Load())
+}
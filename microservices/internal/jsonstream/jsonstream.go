@@ -0,0 +1,175 @@
+// Package jsonstream escreve respostas JSON com um array grande item a
+// item, sem montar o documento em memória: cada item é codificado direto
+// no buffer da resposta, enviado ao cliente a cada FlushEvery itens. Como o
+// status 200 já saiu quando o array começa, uma falha no meio não vira um
+// erro HTTP: o documento é fechado com "error" e "complete": false no
+// lugar de "complete": true. Um item que não pode ser codificado (um NaN,
+// por exemplo) é uma dessas falhas: o array para nele e o documento é
+// fechado como incompleto.
+package jsonstream
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FlushEvery é a cada quantos itens a resposta é enviada ao cliente.
+const FlushEvery = 500
+
+// bufferSize é o buffer de escrita; um item maior que ele é escrito direto.
+const bufferSize = 32 << 10
+
+// Member é um membro do objeto escrito antes do array.
+type Member struct {
+	Key   string
+	Value interface{}
+}
+
+// Writer escreve o objeto {<members>, "<key>": [<itens>], "count": n,
+// "complete": bool}. Depois de um erro de escrita (cliente desconectado) ou
+// de um item que não pôde ser codificado, os próximos itens são ignorados
+// e Err retorna o erro.
+type Writer struct {
+	w *bufio.Writer
+	// Cada valor é codificado em buf antes de ir para w, para que um que
+	// falhe não deixe meio item na resposta.
+	buf     bytes.Buffer
+	enc     *json.Encoder
+	flusher http.Flusher
+	count   int
+	// err é o erro de escrita, depois do qual nada mais sai; invalid é o
+	// do item que não pôde ser codificado, depois do qual o fechamento
+	// ainda é escrito.
+	err     error
+	invalid error
+}
+
+// Start envia o cabeçalho com contentType e status 200 e abre o objeto até
+// o array key.
+func Start(c *gin.Context, contentType, key string, members ...Member) *Writer {
+	c.Header("Content-Type", contentType)
+	c.Status(http.StatusOK)
+
+	sw := &Writer{w: bufio.NewWriterSize(c.Writer, bufferSize), flusher: c.Writer}
+	sw.enc = json.NewEncoder(&sw.buf)
+	sw.writeString("{")
+	for _, m := range members {
+		sw.member(m.Key, m.Value)
+		sw.writeString(",")
+	}
+	sw.encode(key)
+	sw.writeString(":[")
+	return sw
+}
+
+// Add escreve um item do array.
+func (sw *Writer) Add(v interface{}) {
+	if sw.Err() != nil {
+		return
+	}
+	if err := sw.enc.Encode(v); err != nil {
+		sw.buf.Reset()
+		sw.invalid = err
+		return
+	}
+	if sw.count > 0 {
+		sw.writeString(",")
+	}
+	sw.writeBuffer()
+	sw.count++
+	if sw.count%FlushEvery == 0 {
+		sw.flush()
+	}
+}
+
+// Count retorna os itens escritos.
+func (sw *Writer) Count() int { return sw.count }
+
+// Err retorna o erro de escrita ou o do item que não pôde ser codificado,
+// se houve.
+func (sw *Writer) Err() error {
+	if sw.err != nil {
+		return sw.err
+	}
+	return sw.invalid
+}
+
+// Close fecha o documento como completo ou, se um item não pôde ser
+// codificado, como incompleto com "internal error"; o erro do item é o
+// retorno.
+func (sw *Writer) Close() error {
+	if sw.invalid != nil {
+		return sw.close(false, "internal error")
+	}
+	return sw.close(true, "")
+}
+
+// Abort fecha o documento como incompleto, com message em "error". message
+// vai para o cliente; a causa deve ser registrada por quem chama.
+func (sw *Writer) Abort(message string) error {
+	return sw.close(false, message)
+}
+
+// close escreve o fechamento, que sai mesmo depois de um item inválido;
+// só um erro de escrita o impede.
+func (sw *Writer) close(complete bool, message string) error {
+	sw.writeString("],")
+	sw.member("count", sw.count)
+	if !complete {
+		sw.writeString(",")
+		sw.member("error", message)
+	}
+	sw.writeString(",")
+	sw.member("complete", complete)
+	sw.writeString("}")
+	sw.flush()
+	return sw.Err()
+}
+
+// member escreve "key":value, sem vírgula.
+func (sw *Writer) member(key string, value interface{}) {
+	sw.encode(key)
+	sw.writeString(":")
+	sw.encode(value)
+}
+
+// encode escreve v em JSON, ou null se v não pode ser codificado. O
+// Encoder e buf são reaproveitados entre os valores; a quebra de linha que
+// o Encoder acrescenta é espaço válido em JSON.
+func (sw *Writer) encode(v interface{}) {
+	if err := sw.enc.Encode(v); err != nil {
+		sw.buf.Reset()
+		sw.invalid = err
+		sw.writeString("null")
+		return
+	}
+	sw.writeBuffer()
+}
+
+// writeBuffer escreve o valor codificado em buf e o esvazia.
+func (sw *Writer) writeBuffer() {
+	if sw.err == nil {
+		_, sw.err = sw.w.Write(sw.buf.Bytes())
+	}
+	sw.buf.Reset()
+}
+
+func (sw *Writer) flush() {
+	if sw.err == nil {
+		sw.err = sw.w.Flush()
+	}
+	if sw.err == nil {
+		sw.flusher.Flush()
+	}
+}
+
+func (sw *Writer) writeString(s string) {
+	if sw.err == nil {
+		_, sw.err = io.WriteString(sw.w, s)
+	}
+}
@@ -0,0 +1,249 @@
+package jsonstream
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() { gin.SetMode(gin.TestMode) }
+
+type item struct {
+	ID       string  `json:"id"`
+	Name     string  `json:"name"`
+	Lat, Lon float64 `json:"-"`
+	Energy   float64 `json:"energy"`
+}
+
+var sample = item{ID: "agent-00001", Name: "Ônibus 1", Energy: 87.5}
+
+// discard é uma resposta que descarta o corpo, para medir só o que o
+// Writer aloca e não o corpo acumulado pelo httptest.ResponseRecorder.
+type discard struct {
+	header http.Header
+	failAt int
+	n      int
+}
+
+func (d *discard) Header() http.Header { return d.header }
+func (d *discard) WriteHeader(int)     {}
+func (d *discard) Flush()              {}
+func (d *discard) Write(p []byte) (int, error) {
+	d.n += len(p)
+	if d.failAt > 0 && d.n >= d.failAt {
+		return 0, errors.New("connection reset")
+	}
+	return len(p), nil
+}
+
+func newContext(w http.ResponseWriter) *gin.Context {
+	c, _ := gin.CreateTestContext(w)
+	return c
+}
+
+func TestDocument(t *testing.T) {
+	tests := []struct {
+		name  string
+		items int
+		abort string
+		want  map[string]interface{}
+	}{
+		{name: "empty", want: map[string]interface{}{"count": 0.0, "complete": true}},
+		{name: "complete", items: FlushEvery + 3, want: map[string]interface{}{"count": float64(FlushEvery + 3), "complete": true}},
+		{name: "aborted", items: 2, abort: "database unavailable", want: map[string]interface{}{"count": 2.0, "complete": false, "error": "database unavailable"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			sw := Start(newContext(w), "application/json", "data", Member{Key: "simulation_id", Value: "sim-1"})
+			for i := 0; i < tt.items; i++ {
+				sw.Add(sample)
+			}
+			var err error
+			if tt.abort != "" {
+				err = sw.Abort(tt.abort)
+			} else {
+				err = sw.Close()
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var doc map[string]interface{}
+			if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+				t.Fatalf("JSON inválido: %v\n%s", err, w.Body.String())
+			}
+			if doc["simulation_id"] != "sim-1" {
+				t.Errorf("simulation_id = %v", doc["simulation_id"])
+			}
+			if data, _ := doc["data"].([]interface{}); len(data) != tt.items {
+				t.Errorf("data tem %d itens, want %d", len(data), tt.items)
+			}
+			for k, v := range tt.want {
+				if doc[k] != v {
+					t.Errorf("%s = %v, want %v", k, doc[k], v)
+				}
+			}
+		})
+	}
+}
+
+// TestInvalidItem confere que um item que não pode ser codificado para o
+// array e que o documento ainda sai válido, fechado como incompleto.
+func TestInvalidItem(t *testing.T) {
+	bad := item{ID: "agent-nan", Energy: math.NaN()}
+	tests := []struct {
+		name    string
+		members []Member
+		items   []item
+		abort   string
+		count   int
+		err     string
+	}{
+		{name: "close", items: []item{sample, sample, bad, sample}, count: 2, err: "internal error"},
+		{name: "abort", items: []item{sample, bad, sample}, abort: "database unavailable", count: 1, err: "database unavailable"},
+		{name: "first item", items: []item{bad, sample}, count: 0, err: "internal error"},
+		{name: "member", members: []Member{{Key: "energy", Value: math.Inf(1)}}, items: []item{sample}, count: 0, err: "internal error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			sw := Start(newContext(w), "application/json", "data", tt.members...)
+			for _, it := range tt.items {
+				sw.Add(it)
+			}
+			var unsupported *json.UnsupportedValueError
+			if !errors.As(sw.Err(), &unsupported) {
+				t.Fatalf("Err = %v, want *json.UnsupportedValueError", sw.Err())
+			}
+			var err error
+			if tt.abort != "" {
+				err = sw.Abort(tt.abort)
+			} else {
+				err = sw.Close()
+			}
+			if !errors.As(err, &unsupported) {
+				t.Errorf("fechamento retornou %v", err)
+			}
+
+			var doc struct {
+				Data     []item  `json:"data"`
+				Count    int     `json:"count"`
+				Complete *bool   `json:"complete"`
+				Error    string  `json:"error"`
+				Energy   *string `json:"energy"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+				t.Fatalf("JSON inválido: %v\n%s", err, w.Body.String())
+			}
+			if len(doc.Data) != tt.count || doc.Count != tt.count || doc.Complete == nil || *doc.Complete || doc.Error != tt.err {
+				t.Errorf("documento %s", w.Body.String())
+			}
+			if doc.Energy != nil {
+				t.Errorf("membro inválido escrito como %q, want null", *doc.Energy)
+			}
+		})
+	}
+}
+
+func TestWriteErrorStopsStream(t *testing.T) {
+	d := &discard{header: http.Header{}, failAt: bufferSize * 2}
+	sw := Start(newContext(d), "application/json", "data")
+	for i := 0; i < 50000; i++ {
+		sw.Add(sample)
+	}
+	if sw.Err() == nil {
+		t.Fatal("erro de escrita não registrado")
+	}
+	if sw.Count() >= 50000 {
+		t.Errorf("Count = %d: itens contados depois do erro", sw.Count())
+	}
+	if err := sw.Close(); err == nil {
+		t.Error("Close depois do erro retornou nil")
+	}
+}
+
+// maxItemAllocs são as alocações de cada item: a conversão do valor para
+// interface{} e a do encoding/json. Nada fica retido entre os itens.
+const maxItemAllocs = 2
+
+// TestAddAllocs garante que cada item escrito não aloca além da própria
+// codificação: a memória do stream não cresce com o número de itens.
+func TestAddAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("o detector de corridas muda as alocações")
+	}
+	sw := Start(newContext(&discard{header: http.Header{}}), "application/json", "data")
+	sw.Add(sample)
+	if allocs := testing.AllocsPerRun(10000, func() { sw.Add(sample) }); allocs > maxItemAllocs {
+		t.Errorf("Add aloca %.1f vezes por item, want no máximo %d", allocs, maxItemAllocs)
+	}
+}
+
+// TestDocumentAllocsFlat compara as alocações de documentos de 1 mil e 50
+// mil itens: o custo fixo (buffer, encoder) não pode crescer com o tamanho.
+func TestDocumentAllocsFlat(t *testing.T) {
+	if raceEnabled {
+		t.Skip("o detector de corridas muda as alocações")
+	}
+	perDoc := func(items int) float64 {
+		return testing.AllocsPerRun(5, func() {
+			sw := Start(newContext(&discard{header: http.Header{}}), "application/json", "data")
+			for i := 0; i < items; i++ {
+				sw.Add(sample)
+			}
+			sw.Close()
+		})
+	}
+	small, large := perDoc(1000), perDoc(50000)
+	// AllocsPerRun arredonda para baixo o total por execução; a margem
+	// cobre o resto das divisões.
+	perItem := (large - small) / 49000
+	fixed := small - 1000*perItem
+	if perItem > maxItemAllocs+0.01 {
+		t.Errorf("%.2f alocações por item, want no máximo %d", perItem, maxItemAllocs)
+	}
+	if fixed > 50 {
+		t.Errorf("%.0f alocações fixas por documento, want no máximo 50", fixed)
+	}
+}
+
+func BenchmarkWriter(b *testing.B) {
+	for _, n := range []int{1000, 50000} {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				sw := Start(newContext(&discard{header: http.Header{}}), "application/json", "data")
+				for j := 0; j < n; j++ {
+					sw.Add(sample)
+				}
+				sw.Close()
+			}
+		})
+	}
+}
+
+// BenchmarkMarshalSlice é a resposta montada em memória que o stream
+// substitui, para comparação.
+func BenchmarkMarshalSlice(b *testing.B) {
+	for _, n := range []int{1000, 50000} {
+		items := make([]item, n)
+		for i := range items {
+			items[i] = sample
+		}
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := json.Marshal(map[string]interface{}{"data": items, "count": n}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
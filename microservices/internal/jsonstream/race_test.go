@@ -0,0 +1,7 @@
+//go:build race
+
+package jsonstream
+
+// raceEnabled indica o teste com -race, cujas alocações não são as de
+// produção.
+const raceEnabled = true
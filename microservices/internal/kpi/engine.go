@@ -0,0 +1,598 @@
+package kpi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/agentmetric"
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/lease"
+	"smart-city-microservices/internal/logging"
+	"smart-city-microservices/internal/supervisor"
+)
+
+// leaderKey guarda a réplica que avalia os KPIs.
+const leaderKey = "agent-service:kpi:evaluator-leader"
+
+// flushBatch limita as amostras e eventos somados de uma vez.
+const flushBatch = 512
+
+var (
+	droppedSamples = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "kpi_samples_dropped_total",
+		Help:      "Amostras e eventos referenciados por KPIs descartados porque o buffer estava cheio.",
+	})
+
+	evaluations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "kpi_evaluations_total",
+		Help:      "Avaliações de KPIs por resultado (value, no_value, error).",
+	}, []string{"result"})
+)
+
+// accumulate soma as operações no hash da simulação. ARGV[1] é o TTL em
+// ms; seguem trios (kind, name, value): "m" é uma amostra de métrica, "e"
+// um evento.
+var accumulate = redis.NewScript(`
+local key = KEYS[1]
+for i = 2, #ARGV, 3 do
+	local kind, name, raw = ARGV[i], ARGV[i + 1], ARGV[i + 2]
+	if kind == "e" then
+		redis.call("HINCRBY", key, "e:" .. name, 1)
+	else
+		local p = "m:" .. name .. ":"
+		local v = tonumber(raw)
+		redis.call("HINCRBYFLOAT", key, p .. "sum", raw)
+		redis.call("HINCRBY", key, p .. "count", 1)
+		local lo = tonumber(redis.call("HGET", key, p .. "min"))
+		if not lo or v < lo then redis.call("HSET", key, p .. "min", raw) end
+		local hi = tonumber(redis.call("HGET", key, p .. "max"))
+		if not hi or v > hi then redis.call("HSET", key, p .. "max", raw) end
+		redis.call("HSET", key, p .. "last", raw)
+	end
+end
+redis.call("PEXPIRE", key, ARGV[1])
+return 1
+`)
+
+func aggKey(simulationID string) string { return "agent-service:kpi:" + simulationID + ":agg" }
+
+// warmupAggKey guarda os agregados das amostras e eventos do aquecimento,
+// fora dos do resumo.
+func warmupAggKey(simulationID string) string {
+	return "agent-service:kpi:" + simulationID + ":warmup:agg"
+}
+
+func historyKey(simulationID, name string) string {
+	return "agent-service:kpi:" + simulationID + ":history:" + name
+}
+
+// allHistoryKey guarda as avaliações com o aquecimento incluído, para
+// include_warmup=true; só existe nas simulações com aquecimento.
+func allHistoryKey(simulationID, name string) string {
+	return historyKey(simulationID, name) + ":all"
+}
+
+// Warmup diz se a simulação ainda está no aquecimento (internal/warmup).
+type Warmup interface {
+	InWarmup(ctx context.Context, simulationID string) bool
+}
+
+// Config configura a avaliação.
+type Config struct {
+	// Interval é o intervalo da avaliação e da releitura das definições.
+	Interval time.Duration
+	// HistoryPoints é o tamanho do histórico de cada KPI.
+	HistoryPoints int
+	// Retention é por quanto tempo os agregados e o histórico ficam no
+	// Redis depois da última escrita.
+	Retention  time.Duration
+	BufferSize int
+}
+
+type op struct {
+	simulation, kind, name string
+	value                  float64
+	warmup                 bool
+}
+
+// refs são as referências dos KPIs de uma simulação.
+type refs struct {
+	metrics map[string]bool
+	events  map[string]bool
+}
+
+// Engine soma as referências dos KPIs e os avalia. Implementa
+// agentmetric.Observer e events.Handler.
+type Engine struct {
+	repo      *Repository
+	redis     redis.UniversalClient
+	leader    *lease.Lease
+	publisher events.Publisher
+	catalog   Catalog
+	cfg       Config
+	warmup    Warmup
+
+	ops chan op
+
+	mu   sync.RWMutex
+	refs map[string]refs
+	// parsed guarda as expressões interpretadas, pelo texto.
+	parsed map[string]*Expr
+}
+
+// NewEngine cria o motor dos KPIs; id identifica a réplica na disputa pela
+// avaliação, que roda em Run.
+func NewEngine(repo *Repository, client redis.UniversalClient, publisher events.Publisher, catalog Catalog, cfg Config, id string) *Engine {
+	return &Engine{
+		repo:      repo,
+		redis:     client,
+		leader:    lease.New(client, leaderKey, id),
+		publisher: publisher,
+		catalog:   catalog,
+		cfg:       cfg,
+		ops:       make(chan op, cfg.BufferSize),
+		refs:      map[string]refs{},
+		parsed:    map[string]*Expr{},
+	}
+}
+
+// SetWarmup faz o motor separar as amostras e eventos do aquecimento das
+// simulações. Deve ser chamado antes de Run.
+func (e *Engine) SetWarmup(w Warmup) {
+	e.warmup = w
+}
+
+func (e *Engine) inWarmup(ctx context.Context, simulationID string) bool {
+	return e.warmup != nil && e.warmup.InWarmup(ctx, simulationID)
+}
+
+// Parse interpreta a expressão com o catálogo do motor.
+func (e *Engine) Parse(expression string) (*Expr, error) {
+	return Parse(expression, e.catalog)
+}
+
+func (e *Engine) enqueue(o op) {
+	select {
+	case e.ops <- o:
+	default:
+		droppedSamples.Inc()
+	}
+}
+
+// Observe implementa agentmetric.Observer: soma as amostras das métricas
+// referenciadas pelos KPIs da simulação do agente.
+func (e *Engine) Observe(ctx context.Context, ag *agent.Agent, samples []agentmetric.Sample) {
+	if ag.SimulationID == "" {
+		return
+	}
+	e.mu.RLock()
+	r, ok := e.refs[ag.SimulationID]
+	e.mu.RUnlock()
+	if !ok {
+		return
+	}
+	warmup, checked := false, false
+	for _, s := range samples {
+		if r.metrics[s.Name] && !math.IsNaN(s.Value) && !math.IsInf(s.Value, 0) {
+			if !checked {
+				warmup, checked = e.inWarmup(ctx, ag.SimulationID), true
+			}
+			e.enqueue(op{simulation: ag.SimulationID, kind: "m", name: s.Name, value: s.Value, warmup: warmup})
+		}
+	}
+}
+
+// Handle implementa events.Handler: conta os eventos dos tipos
+// referenciados pelos KPIs da simulação.
+func (e *Engine) Handle(ctx context.Context, ev events.Event) {
+	e.mu.RLock()
+	if len(e.refs) == 0 {
+		// Sem KPIs em andamento não vale decodificar o evento.
+		e.mu.RUnlock()
+		return
+	}
+	sim := ev.SimulationID()
+	r, ok := e.refs[sim]
+	e.mu.RUnlock()
+	if ok && r.events[ev.Type] {
+		e.enqueue(op{simulation: sim, kind: "e", name: ev.Type, warmup: e.inWarmup(ctx, sim)})
+	}
+}
+
+// Changed relê as definições depois de uma alteração nesta réplica e, se
+// a expressão mudou, descarta o histórico do KPI. As outras réplicas veem
+// a alteração na próxima releitura.
+func (e *Engine) Changed(ctx context.Context, simulationID, name string, expressionChanged bool) {
+	log := logging.FromContext(ctx)
+	if expressionChanged {
+		if err := e.redis.Del(ctx, historyKey(simulationID, name), allHistoryKey(simulationID, name)).Err(); err != nil {
+			log.WithError(err).Warn("Falha ao descartar o histórico do KPI")
+		}
+	}
+	if _, err := e.refresh(ctx); err != nil {
+		log.WithError(err).Warn("Falha ao reler as definições de KPIs")
+	}
+}
+
+// Run soma as referências e, a cada intervalo, relê as definições e, se
+// esta réplica tem a vez, avalia os KPIs; até ctx ser cancelado.
+func (e *Engine) Run(ctx context.Context) error {
+	g, _ := supervisor.NewGroup(ctx)
+	g.Go(e.flush)
+	g.Go(e.evaluate)
+	return g.Wait()
+}
+
+func (e *Engine) flush(ctx context.Context) error {
+	work := logging.Background(context.WithoutCancel(ctx), "kpi-accumulator")
+	log := logging.FromContext(work)
+	batch := make([]op, 0, flushBatch)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case o := <-e.ops:
+			batch = append(batch[:0], o)
+		}
+	drain:
+		for len(batch) < flushBatch {
+			select {
+			case o := <-e.ops:
+				batch = append(batch, o)
+			default:
+				break drain
+			}
+		}
+		if err := e.write(work, batch); err != nil {
+			log.WithError(err).WithField("operations", len(batch)).Warn("Falha ao somar as referências dos KPIs")
+		}
+	}
+}
+
+func (e *Engine) write(ctx context.Context, batch []op) error {
+	ttl := strconv.FormatInt(e.cfg.Retention.Milliseconds(), 10)
+	// As operações vão para o hash do resumo ou para o do aquecimento.
+	args := map[string][]interface{}{}
+	for _, o := range batch {
+		key := aggKey(o.simulation)
+		if o.warmup {
+			key = warmupAggKey(o.simulation)
+		}
+		if args[key] == nil {
+			args[key] = []interface{}{ttl}
+		}
+		args[key] = append(args[key], o.kind, o.name, strconv.FormatFloat(o.value, 'g', -1, 64))
+	}
+	var first error
+	for key, a := range args {
+		if err := accumulate.Run(ctx, e.redis, []string{key}, a...).Err(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (e *Engine) evaluate(ctx context.Context) error {
+	work := logging.Background(context.WithoutCancel(ctx), "kpi-evaluator")
+	log := logging.FromContext(work)
+	defer e.release(work)
+	ticker := time.NewTicker(e.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		defs, err := e.refresh(work)
+		if err != nil {
+			log.WithError(err).Warn("Falha ao reler as definições de KPIs")
+		} else if len(defs) > 0 {
+			if leader, err := e.lead(work); err != nil {
+				log.WithError(err).Warn("Falha ao disputar a avaliação dos KPIs")
+			} else if leader {
+				e.cycle(work, defs)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// refresh relê os KPIs das simulações em andamento e atualiza as
+// referências somadas por esta réplica.
+func (e *Engine) refresh(ctx context.Context) ([]*Definition, error) {
+	defs, err := e.repo.ListRunning(ctx)
+	if err != nil {
+		return nil, err
+	}
+	next := map[string]refs{}
+	parsed := map[string]*Expr{}
+	e.mu.RLock()
+	for _, d := range defs {
+		x, ok := e.parsed[d.Expression]
+		if !ok {
+			// Uma expressão gravada pode deixar de valer se a configuração
+			// mudar as métricas declaradas; ela fica sem valor até ser
+			// corrigida.
+			if x, err = e.Parse(d.Expression); err != nil {
+				logging.FromContext(ctx).WithError(err).WithField("kpi", d.Name).Warn("Expressão de KPI inválida ignorada")
+				continue
+			}
+		}
+		parsed[d.Expression] = x
+		r, ok := next[d.SimulationID]
+		if !ok {
+			r = refs{metrics: map[string]bool{}, events: map[string]bool{}}
+			next[d.SimulationID] = r
+		}
+		for _, m := range x.Metrics {
+			r.metrics[m] = true
+		}
+		for _, t := range x.Events {
+			r.events[t] = true
+		}
+	}
+	e.mu.RUnlock()
+	e.mu.Lock()
+	e.refs, e.parsed = next, parsed
+	e.mu.Unlock()
+	return defs, nil
+}
+
+// lead obtém ou renova a vez desta réplica; a chave expira em três ciclos.
+func (e *Engine) lead(ctx context.Context) (bool, error) {
+	return e.leader.Acquire(ctx, 3*e.cfg.Interval)
+}
+
+// release remove a chave só se ainda for desta réplica.
+func (e *Engine) release(ctx context.Context) {
+	ctx, cancel := supervisor.Cleanup(ctx)
+	defer cancel()
+	e.leader.Release(ctx)
+}
+
+// cycle avalia os KPIs, uma simulação por vez.
+func (e *Engine) cycle(ctx context.Context, defs []*Definition) {
+	log := logging.FromContext(ctx)
+	bySim := map[string][]*Definition{}
+	var order []string
+	for _, d := range defs {
+		if bySim[d.SimulationID] == nil {
+			order = append(order, d.SimulationID)
+		}
+		bySim[d.SimulationID] = append(bySim[d.SimulationID], d)
+	}
+	now := time.Now().UTC()
+	for _, sim := range order {
+		if err := e.evaluateSimulation(ctx, sim, bySim[sim], now); err != nil {
+			evaluations.WithLabelValues("error").Inc()
+			log.WithError(err).WithField("simulation_id", sim).Warn("Falha ao avaliar os KPIs da simulação")
+		}
+	}
+}
+
+// evaluateSimulation avalia os KPIs sem o aquecimento, que entram no
+// histórico e em kpi.updated. Se a simulação teve aquecimento, também os
+// avalia com ele incluído, num histórico à parte.
+func (e *Engine) evaluateSimulation(ctx context.Context, sim string, defs []*Definition, now time.Time) error {
+	pipe := e.redis.Pipeline()
+	steadyCmd := pipe.HGetAll(ctx, aggKey(sim))
+	warmupCmd := pipe.HGetAll(ctx, warmupAggKey(sim))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	values := decodeValues(steadyCmd.Val())
+	var all *Values
+	inWarmup := false
+	if raw := warmupCmd.Val(); len(raw) > 0 {
+		merged := mergeValues(decodeValues(raw), values)
+		all, inWarmup = &merged, e.inWarmup(ctx, sim)
+		// O hash do aquecimento deixa de ser escrito quando ele acaba, mas
+		// vale enquanto houver avaliações.
+		e.redis.PExpire(ctx, warmupAggKey(sim), e.cfg.Retention)
+	}
+	for _, d := range defs {
+		e.mu.RLock()
+		x := e.parsed[d.Expression]
+		e.mu.RUnlock()
+		if x == nil {
+			continue
+		}
+		if all != nil {
+			if v, ok := x.Eval(*all); ok {
+				if err := e.push(ctx, allHistoryKey(sim, d.Name), Point{At: now, Value: v, Warmup: inWarmup}); err != nil {
+					return err
+				}
+			}
+		}
+		v, ok := x.Eval(values)
+		if !ok {
+			evaluations.WithLabelValues("no_value").Inc()
+			continue
+		}
+		evaluations.WithLabelValues("value").Inc()
+		prev, err := e.last(ctx, sim, d.Name)
+		if err != nil {
+			return err
+		}
+		if err := e.push(ctx, historyKey(sim, d.Name), Point{At: now, Value: v}); err != nil {
+			return err
+		}
+		if prev == nil || prev.Value != v {
+			e.publisher.Publish(ctx, events.New(events.TopicSimulations, EventUpdated, updated(d, v, now)))
+		}
+	}
+	return nil
+}
+
+// push acrescenta a avaliação ao histórico.
+func (e *Engine) push(ctx context.Context, key string, p Point) error {
+	point, _ := json.Marshal(p)
+	pipe := e.redis.TxPipeline()
+	pipe.LPush(ctx, key, point)
+	pipe.LTrim(ctx, key, 0, int64(e.cfg.HistoryPoints-1))
+	pipe.PExpire(ctx, key, e.cfg.Retention)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// mergeValues junta os agregados do aquecimento aos do resumo.
+func mergeValues(warmup, steady Values) Values {
+	out := Values{Metrics: map[string]Aggregate{}, Events: map[string]float64{}}
+	for name, a := range warmup.Metrics {
+		out.Metrics[name] = a
+	}
+	for name, a := range steady.Metrics {
+		w, ok := out.Metrics[name]
+		if !ok || w.Count == 0 {
+			out.Metrics[name] = a
+			continue
+		}
+		if a.Count == 0 {
+			continue
+		}
+		out.Metrics[name] = Aggregate{
+			Sum:   w.Sum + a.Sum,
+			Count: w.Count + a.Count,
+			Min:   math.Min(w.Min, a.Min),
+			Max:   math.Max(w.Max, a.Max),
+			Last:  a.Last,
+		}
+	}
+	for t, n := range warmup.Events {
+		out.Events[t] = n
+	}
+	for t, n := range steady.Events {
+		out.Events[t] += n
+	}
+	return out
+}
+
+func updated(d *Definition, v float64, now time.Time) events.KPIUpdatedV1 {
+	p := events.KPIUpdatedV1{
+		SimulationID: d.SimulationID,
+		ProjectID:    d.ProjectID,
+		Name:         d.Name,
+		Expression:   d.Expression,
+		Value:        v,
+		Target:       d.Target,
+		Direction:    d.Direction,
+		Met:          Met(v, d.Target, d.Direction),
+		EvaluatedAt:  now,
+	}
+	if a, ok := Attainment(v, d.Target, d.Direction); ok {
+		p.Attainment = &a
+	}
+	return p
+}
+
+// last retorna a avaliação mais recente, ou nil sem histórico.
+func (e *Engine) last(ctx context.Context, sim, name string) (*Point, error) {
+	raw, err := e.redis.LIndex(ctx, historyKey(sim, name), 0).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var p Point
+	if json.Unmarshal([]byte(raw), &p) != nil {
+		return nil, nil
+	}
+	return &p, nil
+}
+
+// States junta às definições o valor atual e o histórico. Com
+// includeWarmup, usa as avaliações com o aquecimento incluído; sem elas (a
+// simulação não teve aquecimento), as do resumo.
+func (e *Engine) States(ctx context.Context, defs []*Definition, includeWarmup bool) ([]State, error) {
+	pipe := e.redis.Pipeline()
+	cmds := make([]*redis.StringSliceCmd, len(defs))
+	allCmds := make([]*redis.StringSliceCmd, len(defs))
+	for i, d := range defs {
+		cmds[i] = pipe.LRange(ctx, historyKey(d.SimulationID, d.Name), 0, int64(e.cfg.HistoryPoints-1))
+		if includeWarmup {
+			allCmds[i] = pipe.LRange(ctx, allHistoryKey(d.SimulationID, d.Name), 0, int64(e.cfg.HistoryPoints-1))
+		}
+	}
+	if len(defs) > 0 {
+		if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+			return nil, err
+		}
+	}
+	out := make([]State, 0, len(defs))
+	for i, d := range defs {
+		s := State{Definition: *d, History: []Point{}}
+		raw := cmds[i].Val()
+		if includeWarmup && len(allCmds[i].Val()) > 0 {
+			raw = allCmds[i].Val()
+		}
+		for j := len(raw) - 1; j >= 0; j-- {
+			var p Point
+			if json.Unmarshal([]byte(raw[j]), &p) == nil {
+				s.History = append(s.History, p)
+			}
+		}
+		if n := len(s.History); n > 0 {
+			p := s.History[n-1]
+			met := Met(p.Value, d.Target, d.Direction)
+			s.Value, s.Met, s.EvaluatedAt = &p.Value, &met, &p.At
+			if a, ok := Attainment(p.Value, d.Target, d.Direction); ok {
+				s.Attainment = &a
+			}
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+// decodeValues lê o hash dos agregados: m:<métrica>:<campo> e e:<tipo>.
+func decodeValues(raw map[string]string) Values {
+	v := Values{Metrics: map[string]Aggregate{}, Events: map[string]float64{}}
+	for field, s := range raw {
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			continue
+		}
+		if t, ok := strings.CutPrefix(field, "e:"); ok {
+			v.Events[t] = n
+			continue
+		}
+		rest, ok := strings.CutPrefix(field, "m:")
+		if !ok {
+			continue
+		}
+		i := strings.LastIndexByte(rest, ':')
+		if i < 0 {
+			continue
+		}
+		name, part := rest[:i], rest[i+1:]
+		a := v.Metrics[name]
+		switch part {
+		case "sum":
+			a.Sum = n
+		case "count":
+			a.Count = n
+		case "min":
+			a.Min = n
+		case "max":
+			a.Max = n
+		case "last":
+			a.Last = n
+		}
+		v.Metrics[name] = a
+	}
+	return v
+}
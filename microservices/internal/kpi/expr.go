@@ -0,0 +1,412 @@
+package kpi
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"smart-city-microservices/internal/events"
+)
+
+// Funções de agregação das métricas de agentes nas expressões.
+const (
+	FuncAvg   = "avg"
+	FuncSum   = "sum"
+	FuncMin   = "min"
+	FuncMax   = "max"
+	FuncCount = "count"
+	FuncLast  = "last"
+	// FuncEvents conta os eventos de um tipo publicados na simulação.
+	FuncEvents = "events"
+)
+
+// Limites de uma expressão. A árvore é avaliada por recursão: sem eles,
+// uma expressão longa ou aninhada demais estoura a pilha do processo.
+const (
+	maxLength = 1000
+	maxDepth  = 32
+)
+
+var metricFuncs = map[string]bool{FuncAvg: true, FuncSum: true, FuncMin: true, FuncMax: true, FuncCount: true, FuncLast: true}
+
+// Catalog diz quais métricas e eventos as expressões podem referenciar.
+type Catalog interface {
+	// KnownMetric informa se algum tipo de agente aceita a métrica.
+	KnownMetric(name string) bool
+	// KnownEvent informa se o tipo de evento (sem versão) existe.
+	KnownEvent(eventType string) bool
+}
+
+// NewCatalog cria o catálogo a partir das métricas declaradas pelos tipos
+// de agente e dos esquemas de eventos registrados.
+func NewCatalog(metrics interface{ Known(name string) bool }, schemas *events.Registry) Catalog {
+	return catalog{metrics: metrics, schemas: schemas}
+}
+
+type catalog struct {
+	metrics interface{ Known(name string) bool }
+	schemas *events.Registry
+}
+
+func (c catalog) KnownMetric(name string) bool     { return c.metrics.Known(name) }
+func (c catalog) KnownEvent(eventType string) bool { return c.schemas.Current(eventType) > 0 }
+
+// Expr é uma expressão de KPI já interpretada. A sintaxe é aritmética
+// (+ - * / e parênteses) sobre números e referências:
+//
+//	avg(wait_time)           média das amostras da métrica de agentes na
+//	                         simulação; também sum, min, max, count e last
+//	events("agent.offline")  eventos do tipo publicados na simulação
+//
+// Por exemplo, avg(wait_time) / 60 ou
+// events("agent.action.failed") / count(route_completion). Sem amostras, ou
+// com divisão por zero, a expressão não tem valor. A expressão tem no
+// máximo 1000 bytes e 32 níveis de parênteses e sinais de menos.
+type Expr struct {
+	root node
+	// Metrics e Events são as referências, sem repetição e em ordem.
+	Metrics []string
+	Events  []string
+}
+
+// Values são os agregados de uma simulação usados na avaliação.
+type Values struct {
+	// Metrics traz os agregados por métrica; métricas sem amostras ficam
+	// de fora.
+	Metrics map[string]Aggregate
+	Events  map[string]float64
+}
+
+// Aggregate resume as amostras de uma métrica.
+type Aggregate struct {
+	Sum, Count, Min, Max, Last float64
+}
+
+// Parse interpreta a expressão e confere as referências no catálogo.
+func Parse(s string, catalog Catalog) (*Expr, error) {
+	if len(s) > maxLength {
+		return nil, fmt.Errorf("invalid expression: longer than %d bytes", maxLength)
+	}
+	p := &parser{s: s}
+	root, err := p.expr()
+	if err == nil && p.skipSpace() < len(p.s) {
+		err = p.errorf("unexpected %q", p.s[p.pos])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", s, err)
+	}
+	e := &Expr{root: root}
+	metrics, evs := map[string]bool{}, map[string]bool{}
+	var unknown []string
+	root.walk(func(r *ref) {
+		if r.fn == FuncEvents {
+			if !evs[r.name] && !catalog.KnownEvent(r.name) {
+				unknown = append(unknown, fmt.Sprintf("unknown event type %q", r.name))
+			}
+			evs[r.name] = true
+			return
+		}
+		if !metrics[r.name] && !catalog.KnownMetric(r.name) {
+			unknown = append(unknown, fmt.Sprintf("unknown metric %q (no agent type declares it)", r.name))
+		}
+		metrics[r.name] = true
+	})
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("invalid expression %q: %s", s, strings.Join(unknown, "; "))
+	}
+	e.Metrics, e.Events = keys(metrics), keys(evs)
+	return e, nil
+}
+
+func keys(m map[string]bool) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Eval avalia a expressão; ok é false quando ela não tem valor.
+func (e *Expr) Eval(v Values) (value float64, ok bool) {
+	value, ok = e.root.eval(v)
+	if !ok || math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, false
+	}
+	return value, true
+}
+
+type node interface {
+	eval(v Values) (float64, bool)
+	walk(fn func(*ref))
+}
+
+type number float64
+
+func (n number) eval(Values) (float64, bool) { return float64(n), true }
+func (n number) walk(func(*ref))             {}
+
+type neg struct{ x node }
+
+func (n neg) eval(v Values) (float64, bool) {
+	x, ok := n.x.eval(v)
+	return -x, ok
+}
+func (n neg) walk(fn func(*ref)) { n.x.walk(fn) }
+
+type binary struct {
+	op   byte
+	l, r node
+}
+
+func (b binary) eval(v Values) (float64, bool) {
+	l, ok := b.l.eval(v)
+	if !ok {
+		return 0, false
+	}
+	r, ok := b.r.eval(v)
+	if !ok {
+		return 0, false
+	}
+	switch b.op {
+	case '+':
+		return l + r, true
+	case '-':
+		return l - r, true
+	case '*':
+		return l * r, true
+	}
+	if r == 0 {
+		return 0, false
+	}
+	return l / r, true
+}
+
+func (b binary) walk(fn func(*ref)) {
+	b.l.walk(fn)
+	b.r.walk(fn)
+}
+
+type ref struct {
+	fn, name string
+}
+
+func (r *ref) eval(v Values) (float64, bool) {
+	if r.fn == FuncEvents {
+		// Sem eventos a contagem é zero, não a falta de valor.
+		return v.Events[r.name], true
+	}
+	a, ok := v.Metrics[r.name]
+	if !ok || a.Count == 0 {
+		if r.fn == FuncCount || r.fn == FuncSum {
+			return 0, true
+		}
+		return 0, false
+	}
+	switch r.fn {
+	case FuncAvg:
+		return a.Sum / a.Count, true
+	case FuncSum:
+		return a.Sum, true
+	case FuncMin:
+		return a.Min, true
+	case FuncMax:
+		return a.Max, true
+	case FuncCount:
+		return a.Count, true
+	}
+	return a.Last, true
+}
+
+func (r *ref) walk(fn func(*ref)) { fn(r) }
+
+// parser é um descendente recursivo para a gramática de Expr.
+type parser struct {
+	s   string
+	pos int
+	// depth conta os parênteses e sinais de menos abertos.
+	depth int
+}
+
+// expr := term (('+' | '-') term)*
+func (p *parser) expr() (node, error) {
+	l, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		if op != '+' && op != '-' {
+			return l, nil
+		}
+		p.pos++
+		r, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		l = binary{op: op, l: l, r: r}
+	}
+}
+
+// term := unary (('*' | '/') unary)*
+func (p *parser) term() (node, error) {
+	l, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		if op != '*' && op != '/' {
+			return l, nil
+		}
+		p.pos++
+		r, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l = binary{op: op, l: l, r: r}
+	}
+}
+
+// unary := '-' unary | number | '(' expr ')' | call
+func (p *parser) unary() (node, error) {
+	c := p.peek()
+	if c == '-' || c == '(' {
+		if p.depth++; p.depth > maxDepth {
+			return nil, p.errorf("nested deeper than %d levels", maxDepth)
+		}
+		defer func() { p.depth-- }()
+	}
+	switch {
+	case c == '-':
+		p.pos++
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return neg{x}, nil
+	case c == '(':
+		p.pos++
+		x, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(')'); err != nil {
+			return nil, err
+		}
+		return x, nil
+	case c == '.' || (c >= '0' && c <= '9'):
+		return p.number()
+	case c == 0:
+		return nil, p.errorf("unexpected end of expression")
+	}
+	return p.call()
+}
+
+func (p *parser) number() (node, error) {
+	start := p.pos
+	for p.pos < len(p.s) && (p.s[p.pos] == '.' || (p.s[p.pos] >= '0' && p.s[p.pos] <= '9')) {
+		p.pos++
+	}
+	v, err := strconv.ParseFloat(p.s[start:p.pos], 64)
+	if err != nil {
+		p.pos = start
+		return nil, p.errorf("invalid number")
+	}
+	return number(v), nil
+}
+
+// call := func '(' metric ')' | 'events' '(' quoted ')'
+func (p *parser) call() (node, error) {
+	start := p.pos
+	fn, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	if fn != FuncEvents && !metricFuncs[fn] {
+		p.pos = start
+		return nil, p.errorf("unknown function %q (use avg, sum, min, max, count, last or events)", fn)
+	}
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+	r := &ref{fn: fn}
+	if fn == FuncEvents {
+		if r.name, err = p.quoted(); err != nil {
+			return nil, err
+		}
+	} else if r.name, err = p.ident(); err != nil {
+		return nil, err
+	}
+	if err := p.expect(')'); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// skipSpace pula os espaços e retorna a posição.
+func (p *parser) skipSpace() int {
+	for p.pos < len(p.s) && unicode.IsSpace(rune(p.s[p.pos])) {
+		p.pos++
+	}
+	return p.pos
+}
+
+func (p *parser) peek() byte {
+	if p.skipSpace() >= len(p.s) {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *parser) expect(c byte) error {
+	if p.peek() != c {
+		return p.errorf("expected %q", c)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *parser) ident() (string, error) {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		if c != '_' && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (p.pos == start || c < '0' || c > '9') {
+			break
+		}
+		p.pos++
+	}
+	if p.pos == start {
+		return "", p.errorf("expected identifier")
+	}
+	return p.s[start:p.pos], nil
+}
+
+func (p *parser) quoted() (string, error) {
+	if p.peek() != '"' {
+		return "", p.errorf("expected quoted event type")
+	}
+	start := p.pos
+	for p.pos++; p.pos < len(p.s); p.pos++ {
+		switch p.s[p.pos] {
+		case '\\':
+			p.pos++
+		case '"':
+			p.pos++
+			v, err := strconv.Unquote(p.s[start:p.pos])
+			if err != nil {
+				return "", p.errorf("invalid quoted value")
+			}
+			return v, nil
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("at offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,104 @@
+package kpi
+
+import (
+	"strings"
+	"testing"
+)
+
+// testCatalog conhece as métricas e os eventos usados nos testes.
+type testCatalog struct{}
+
+func (testCatalog) KnownMetric(name string) bool {
+	return name == "wait_time" || name == "route_completion" || name == "speed_kmh"
+}
+
+func (testCatalog) KnownEvent(eventType string) bool {
+	return eventType == "agent.offline" || eventType == "agent.action.failed"
+}
+
+// testValues são os agregados de uma simulação com amostras de wait_time e
+// eventos agent.offline.
+var testValues = Values{
+	Metrics: map[string]Aggregate{
+		"wait_time": {Sum: 300, Count: 4, Min: 30, Max: 120, Last: 60},
+	},
+	Events: map[string]float64{"agent.offline": 3},
+}
+
+// FuzzParse confere que nenhuma entrada derruba Parse ou Eval e que as
+// longas demais são recusadas. Os casos em testdata/fuzz/FuzzParse são
+// expressões longas e aninhadas além dos limites; rode com
+// go test ./internal/kpi -fuzz FuzzParse.
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{
+		"avg(wait_time) / 60",
+		`events("agent.action.failed") / count(route_completion)`,
+		`-(sum(wait_time) - -min(wait_time)) * 2.5e3`,
+		`last(speed_kmh)+max(speed_kmh)*(1/0)`,
+		`events("agent.offline`,
+		`events("\"")`,
+		"avg(wait_time",
+		"avg()",
+		"1e309 * 0",
+		"((1))",
+		"--1",
+		"",
+		"ônibus(wait_time)",
+		strings.Repeat("(", maxDepth) + "1" + strings.Repeat(")", maxDepth),
+		strings.Repeat("1+", 499) + "1",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		e, err := Parse(s, testCatalog{})
+		if len(s) > maxLength && err == nil {
+			t.Fatalf("expressão de %d bytes aceita", len(s))
+		}
+		if err != nil {
+			return
+		}
+		e.Eval(testValues)
+		e.Eval(Values{})
+	})
+}
+
+// TestParseLimits confere os limites de tamanho e profundidade nas bordas:
+// no limite a expressão é aceita e avaliada, um byte ou nível além dele é
+// recusada.
+func TestParseLimits(t *testing.T) {
+	nested := func(open, close string, n int) string {
+		return strings.Repeat(open, n) + "avg(wait_time)" + strings.Repeat(close, n)
+	}
+	tests := []struct {
+		name  string
+		expr  string
+		value float64
+		err   string
+	}{
+		{name: "max length", expr: strings.Repeat("1+", 499) + " 1", value: 500},
+		{name: "past max length", expr: strings.Repeat("1+", 500) + "1", err: "longer than 1000 bytes"},
+		{name: "max parentheses", expr: nested("(", ")", maxDepth), value: 75},
+		{name: "past max parentheses", expr: nested("(", ")", maxDepth+1), err: "nested deeper than 32 levels"},
+		{name: "max unary minus", expr: nested("-", "", maxDepth), value: 75},
+		{name: "past max unary minus", expr: nested("-", "", maxDepth+1), err: "nested deeper than 32 levels"},
+		{name: "minus and parentheses add up", expr: nested("-(", ")", maxDepth/2+1), err: "nested deeper than 32 levels"},
+		{name: "siblings do not add up", expr: strings.Repeat(nested("(", ")", maxDepth-1)+"+", 3) + "0", value: 225},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := Parse(tt.expr, testCatalog{})
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("erro %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v, ok := e.Eval(testValues); !ok || v != tt.value {
+				t.Errorf("Eval = %v, %v; want %v", v, ok, tt.value)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package kpi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/audit"
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/i18n"
+	"smart-city-microservices/internal/logging"
+)
+
+var namePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,99}$`)
+
+// SimulationGetter é o subconjunto de agent.Service usado pelo handler.
+type SimulationGetter interface {
+	GetSimulation(ctx context.Context, id string) (*agent.Simulation, error)
+}
+
+// Handler expõe os KPIs de uma simulação.
+type Handler struct {
+	engine      *Engine
+	repo        *Repository
+	simulations SimulationGetter
+}
+
+// NewHandler cria o handler de KPIs.
+func NewHandler(engine *Engine, repo *Repository, simulations SimulationGetter) *Handler {
+	return &Handler{engine: engine, repo: repo, simulations: simulations}
+}
+
+// PutRequest é o corpo de PUT /simulations/:id/kpis/:name.
+type PutRequest struct {
+	Expression string   `json:"expression" binding:"required"`
+	Target     *float64 `json:"target" binding:"required"`
+	Direction  string   `json:"direction" binding:"omitempty,oneof=maximize minimize"`
+}
+
+// List responde GET /simulations/:id/kpis com o valor atual, o quanto da
+// meta foi atingido e o histórico de cada KPI, sem o aquecimento da
+// simulação; ?include_warmup=true o inclui.
+func (h *Handler) List(c *gin.Context) {
+	includeWarmup := false
+	if v := c.Query("include_warmup"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid include_warmup: must be true or false"})
+			return
+		}
+		includeWarmup = b
+	}
+	sim := h.simulation(c)
+	if sim == nil {
+		return
+	}
+	ctx := c.Request.Context()
+	defs, err := h.repo.List(ctx, sim.ID)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	states, err := h.engine.States(ctx, defs, includeWarmup)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": states})
+}
+
+// Put responde PUT /simulations/:id/kpis/:name: cria ou substitui o KPI.
+// A expressão é conferida aqui; uma métrica que nenhum tipo de agente
+// declara responde 400. Mudar a expressão descarta o histórico.
+func (h *Handler) Put(c *gin.Context) {
+	var req PutRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		i18n.BindError(c, err)
+		return
+	}
+	name := c.Param("name")
+	if !namePattern.MatchString(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid kpi name: " + name})
+		return
+	}
+	if _, err := h.engine.Parse(req.Expression); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	sim := h.simulation(c)
+	if sim == nil {
+		return
+	}
+	d := &Definition{
+		SimulationID: sim.ID,
+		ProjectID:    sim.ProjectID,
+		Name:         name,
+		Expression:   req.Expression,
+		Target:       *req.Target,
+		Direction:    req.Direction,
+	}
+	if d.Direction == "" {
+		d.Direction = DirectionMaximize
+	}
+	if p := auth.FromGin(c); p != nil {
+		d.CreatedBy = p.Subject
+	}
+	ctx := c.Request.Context()
+	changed, err := h.repo.Upsert(ctx, d)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	h.engine.Changed(ctx, sim.ID, name, changed)
+	audit.Record(ctx, "simulation.kpi_changed", logrus.Fields{
+		"simulation_id": sim.ID, "kpi": name, "expression": d.Expression, "target": d.Target, "direction": d.Direction,
+	})
+	states, err := h.engine.States(ctx, []*Definition{d}, false)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, states[0])
+}
+
+// Delete responde DELETE /simulations/:id/kpis/:name.
+func (h *Handler) Delete(c *gin.Context) {
+	sim := h.simulation(c)
+	if sim == nil {
+		return
+	}
+	ctx := c.Request.Context()
+	name := c.Param("name")
+	if err := h.repo.Delete(ctx, sim.ID, name); err != nil {
+		h.serviceError(c, err)
+		return
+	}
+	h.engine.Changed(ctx, sim.ID, name, true)
+	audit.Record(ctx, "simulation.kpi_deleted", logrus.Fields{"simulation_id": sim.ID, "kpi": name})
+	c.Status(http.StatusNoContent)
+}
+
+// simulation busca a simulação de :id e confere o projeto do principal.
+// Responde e retorna nil se ela não existe ou é de outro projeto.
+func (h *Handler) simulation(c *gin.Context) *agent.Simulation {
+	sim, err := h.simulations.GetSimulation(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, agent.ErrNotFound) || (err == nil && !auth.FromGin(c).InProject(sim.ProjectID)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "simulation not found"})
+		return nil
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return nil
+	}
+	return sim
+}
+
+func (h *Handler) serviceError(c *gin.Context, err error) {
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	h.internalError(c, err)
+}
+
+func (h *Handler) internalError(c *gin.Context, err error) {
+	logging.FromContext(c.Request.Context()).WithError(err).Error("Erro no handler de KPIs")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
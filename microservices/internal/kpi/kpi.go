@@ -0,0 +1,93 @@
+// Package kpi avalia os KPIs que cada simulação define: um nome, uma
+// expressão sobre as métricas de agentes e os eventos da simulação (ver
+// Expr), a meta e a direção (maximize ou minimize). O transporte acompanha
+// avg(passenger_wait); a energia, max(load).
+//
+// Cada réplica soma no Redis as amostras e os eventos que algum KPI de uma
+// simulação em andamento referencia, a partir do momento em que ele passa a
+// referenciá-los. Uma réplica por vez, eleita no Redis, avalia os KPIs a
+// cada intervalo, guarda o histórico recente para o sparkline e publica
+// kpi.updated quando o valor muda. As amostras e eventos do aquecimento da
+// simulação (internal/warmup) ficam fora desses valores; as avaliações com
+// eles incluídos vão para um histórico à parte, lido com include_warmup.
+package kpi
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// Direções de um KPI.
+const (
+	DirectionMaximize = "maximize"
+	DirectionMinimize = "minimize"
+)
+
+// ErrNotFound indica um KPI inexistente na simulação.
+var ErrNotFound = errors.New("kpi not found")
+
+// EventUpdated é publicado quando o valor de um KPI muda.
+const EventUpdated = "kpi.updated"
+
+// Definition é um KPI de uma simulação.
+type Definition struct {
+	SimulationID string    `json:"simulation_id"`
+	Name         string    `json:"name"`
+	Expression   string    `json:"expression"`
+	Target       float64   `json:"target"`
+	Direction    string    `json:"direction"`
+	CreatedBy    string    `json:"created_by,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+
+	// ProjectID é o da simulação, para os eventos.
+	ProjectID string `json:"-"`
+}
+
+// Point é uma avaliação do KPI. Warmup marca, com include_warmup=true, as
+// avaliações feitas durante o aquecimento da simulação.
+type Point struct {
+	At     time.Time `json:"t"`
+	Value  float64   `json:"v"`
+	Warmup bool      `json:"warmup,omitempty"`
+}
+
+// State é um KPI com o valor atual e o histórico, para
+// GET /simulations/:id/kpis.
+type State struct {
+	Definition
+	// Value é o da última avaliação; ausente antes da primeira com valor.
+	Value       *float64   `json:"value"`
+	Attainment  *float64   `json:"attainment"`
+	Met         *bool      `json:"met"`
+	EvaluatedAt *time.Time `json:"evaluated_at,omitempty"`
+	// History são as últimas avaliações, da mais antiga para a mais nova.
+	History []Point `json:"history"`
+}
+
+// Attainment é quanto da meta o valor atinge: value/target ao maximizar,
+// target/value ao minimizar; 1 ou mais é meta cumprida. Sem sentido com
+// meta ou valor zero.
+func Attainment(value, target float64, direction string) (float64, bool) {
+	num, den := value, target
+	if direction == DirectionMinimize {
+		num, den = target, value
+	}
+	if den == 0 {
+		return 0, false
+	}
+	a := num / den
+	if math.IsNaN(a) || math.IsInf(a, 0) {
+		return 0, false
+	}
+	return a, true
+}
+
+// Met indica se o valor cumpre a meta na direção do KPI.
+func Met(value, target float64, direction string) bool {
+	if direction == DirectionMinimize {
+		return value <= target
+	}
+	return value >= target
+}
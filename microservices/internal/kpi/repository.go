@@ -0,0 +1,87 @@
+package kpi
+
+import (
+	"context"
+	"database/sql"
+
+	"smart-city-microservices/internal/instrument"
+)
+
+// Repository persiste as definições em simulation_kpis.
+type Repository struct {
+	db *instrument.DB
+}
+
+// NewRepository cria o repositório de KPIs.
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: instrument.NewDB(db)}
+}
+
+const kpiColumns = `k.simulation_id, k.name, k.expression, k.target, k.direction,
+	COALESCE(k.created_by, ''), k.created_at, k.updated_at, COALESCE(s.project_id, '')`
+
+func (r *Repository) list(ctx context.Context, name, query string, args ...interface{}) ([]*Definition, error) {
+	rows, err := r.db.Query(ctx, name, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []*Definition
+	for rows.Next() {
+		d := &Definition{}
+		if err := rows.Scan(&d.SimulationID, &d.Name, &d.Expression, &d.Target, &d.Direction,
+			&d.CreatedBy, &d.CreatedAt, &d.UpdatedAt, &d.ProjectID); err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// List retorna os KPIs da simulação em ordem de nome.
+func (r *Repository) List(ctx context.Context, simulationID string) ([]*Definition, error) {
+	return r.list(ctx, "kpi.list", `
+		SELECT `+kpiColumns+`
+		FROM simulation_kpis k JOIN simulations s ON s.id = k.simulation_id
+		WHERE k.simulation_id = $1 ORDER BY k.name`, simulationID)
+}
+
+// ListRunning retorna os KPIs das simulações em andamento.
+func (r *Repository) ListRunning(ctx context.Context) ([]*Definition, error) {
+	return r.list(ctx, "kpi.list_running", `
+		SELECT `+kpiColumns+`
+		FROM simulation_kpis k JOIN simulations s ON s.id = k.simulation_id
+		WHERE s.status = 'running' ORDER BY k.simulation_id, k.name`)
+}
+
+// Upsert grava o KPI, preenche o autor original e as datas e indica se a
+// expressão mudou (ou se o KPI é novo).
+func (r *Repository) Upsert(ctx context.Context, d *Definition) (changed bool, err error) {
+	err = r.db.QueryRow(ctx, "kpi.upsert", `
+		WITH prev AS (
+			SELECT expression FROM simulation_kpis WHERE simulation_id = $1 AND name = $2
+		)
+		INSERT INTO simulation_kpis (simulation_id, name, expression, target, direction, created_by)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''))
+		ON CONFLICT (simulation_id, name) DO UPDATE SET
+			expression = EXCLUDED.expression, target = EXCLUDED.target,
+			direction = EXCLUDED.direction, updated_at = CURRENT_TIMESTAMP
+		RETURNING COALESCE(created_by, ''), created_at, updated_at,
+			COALESCE((SELECT expression FROM prev), '') IS DISTINCT FROM $3`,
+		d.SimulationID, d.Name, d.Expression, d.Target, d.Direction, d.CreatedBy,
+	).Scan(&d.CreatedBy, &d.CreatedAt, &d.UpdatedAt, &changed)
+	return changed, err
+}
+
+// Delete remove o KPI.
+func (r *Repository) Delete(ctx context.Context, simulationID, name string) error {
+	res, err := r.db.Exec(ctx, "kpi.delete",
+		`DELETE FROM simulation_kpis WHERE simulation_id = $1 AND name = $2`, simulationID, name)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+// Package lease guarda no Redis uma chave que só uma réplica tem por vez:
+// a liderança de um laço periódico ou a trava de uma tarefa. A chave
+// guarda o id de quem a tem, e a renovação e a liberação comparam esse id
+// no próprio Redis, num script Lua: uma chave que expirou e foi tomada por
+// outra réplica entre a leitura e a escrita não é renovada nem apagada.
+package lease
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// acquire toma a chave se está livre ou renova a do próprio id.
+var acquire = redis.NewScript(`
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return 1
+end
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+	return 1
+end
+return 0`)
+
+// release apaga a chave só se ainda for do id.
+var release = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// Lease é a chave key disputada pelo id de uma réplica.
+type Lease struct {
+	redis redis.UniversalClient
+	key   string
+	id    string
+}
+
+// New cria a disputa de key por id, que deve ser único entre as réplicas
+// (ou entre as tarefas, numa trava).
+func New(client redis.UniversalClient, key, id string) *Lease {
+	return &Lease{redis: client, key: key, id: id}
+}
+
+// Acquire toma a chave por ttl ou, se já é deste id, renova o ttl. Retorna
+// false se outro id a tem.
+func (l *Lease) Acquire(ctx context.Context, ttl time.Duration) (bool, error) {
+	n, err := acquire.Run(ctx, l.redis, []string{l.key}, l.id, ttl.Milliseconds()).Int()
+	return n == 1, err
+}
+
+// Claim toma a chave por ttl só se está livre: ao contrário de Acquire,
+// não renova a deste id. Serve às janelas que valem uma vez por ttl.
+func (l *Lease) Claim(ctx context.Context, ttl time.Duration) (bool, error) {
+	return l.redis.SetNX(ctx, l.key, l.id, ttl).Result()
+}
+
+// Held informa se a chave ainda é deste id.
+func (l *Lease) Held(ctx context.Context) (bool, error) {
+	v, err := l.redis.Get(ctx, l.key).Result()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	return v == l.id, err
+}
+
+// Release apaga a chave se ela ainda for deste id; retorna false se ela
+// expirou ou é de outro.
+func (l *Lease) Release(ctx context.Context) (bool, error) {
+	n, err := release.Run(ctx, l.redis, []string{l.key}, l.id).Int()
+	return n == 1, err
+}
@@ -0,0 +1,94 @@
+package lease
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"smart-city-microservices/internal/fake"
+)
+
+func TestAcquire(t *testing.T) {
+	client, srv := fake.Redis(t)
+	ctx := context.Background()
+	a, b := New(client, "test:leader", "a"), New(client, "test:leader", "b")
+
+	if ok, err := a.Acquire(ctx, 3*time.Second); err != nil || !ok {
+		t.Fatalf("a não tomou a chave livre: %v, %v", ok, err)
+	}
+	if ok, err := b.Acquire(ctx, 3*time.Second); err != nil || ok {
+		t.Fatalf("b tomou a chave de a: %v, %v", ok, err)
+	}
+
+	// A renovação de a estende o ttl.
+	srv.FastForward(2 * time.Second)
+	if ok, err := a.Acquire(ctx, 3*time.Second); err != nil || !ok {
+		t.Fatalf("a não renovou: %v, %v", ok, err)
+	}
+	if ttl := srv.TTL("test:leader"); ttl != 3*time.Second {
+		t.Errorf("ttl %v depois da renovação", ttl)
+	}
+
+	// Expirada, a chave é de quem chegar primeiro; a não a renova mais.
+	srv.FastForward(4 * time.Second)
+	if ok, err := b.Acquire(ctx, 3*time.Second); err != nil || !ok {
+		t.Fatalf("b não tomou a chave expirada: %v, %v", ok, err)
+	}
+	if ok, err := a.Acquire(ctx, 3*time.Second); err != nil || ok {
+		t.Fatalf("a renovou a chave de b: %v, %v", ok, err)
+	}
+	if v, _ := srv.Get("test:leader"); v != "b" {
+		t.Errorf("chave com %q, want b", v)
+	}
+}
+
+func TestClaim(t *testing.T) {
+	client, srv := fake.Redis(t)
+	ctx := context.Background()
+	a := New(client, "test:window", "a")
+
+	if ok, err := a.Claim(ctx, time.Minute); err != nil || !ok {
+		t.Fatalf("a não tomou a janela livre: %v, %v", ok, err)
+	}
+	// Claim não renova: a mesma réplica espera a próxima janela.
+	if ok, err := a.Claim(ctx, time.Minute); err != nil || ok {
+		t.Fatalf("a tomou de novo a janela em curso: %v, %v", ok, err)
+	}
+	srv.FastForward(time.Minute)
+	if ok, err := a.Claim(ctx, time.Minute); err != nil || !ok {
+		t.Fatalf("a não tomou a janela seguinte: %v, %v", ok, err)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	client, srv := fake.Redis(t)
+	ctx := context.Background()
+	a, b := New(client, "test:leader", "a"), New(client, "test:leader", "b")
+
+	a.Acquire(ctx, time.Second)
+	if held, err := a.Held(ctx); err != nil || !held {
+		t.Fatalf("Held de a = %v, %v", held, err)
+	}
+	// A chave de a expira e b a toma: a liberação tardia de a não a apaga.
+	srv.FastForward(2 * time.Second)
+	if held, err := a.Held(ctx); err != nil || held {
+		t.Fatalf("Held de a depois de expirar = %v, %v", held, err)
+	}
+	b.Acquire(ctx, time.Second)
+	if ok, err := a.Release(ctx); err != nil || ok {
+		t.Fatalf("a liberou a chave de b: %v, %v", ok, err)
+	}
+	if v, _ := srv.Get("test:leader"); v != "b" {
+		t.Fatalf("chave com %q, want b", v)
+	}
+
+	if ok, err := b.Release(ctx); err != nil || !ok {
+		t.Fatalf("b não liberou a própria chave: %v, %v", ok, err)
+	}
+	if srv.Exists("test:leader") {
+		t.Error("chave ainda existe")
+	}
+	if ok, err := b.Release(ctx); err != nil || ok {
+		t.Fatalf("liberou a chave inexistente: %v, %v", ok, err)
+	}
+}
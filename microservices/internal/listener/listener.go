@@ -0,0 +1,164 @@
+// Package listener abre os sockets de escuta do serviço (HTTP, gRPC,
+// diagnóstico), herdando-os quando possível (ativação por socket do systemd
+// ou handoff entre processos) para reinícios sem derrubar conexões.
+package listener
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Nomes dos listeners do serviço.
+const (
+	HTTP  = "http"
+	GRPC  = "grpc"
+	Debug = "debug"
+)
+
+// Variáveis de ambiente usadas na herança dos sockets.
+const (
+	// EnvInheritFD é o prefixo das variáveis definidas pelo processo antigo
+	// ao entregar os sockets ao novo, uma por listener:
+	// SMARTCITY_INHERIT_FD_HTTP=3, SMARTCITY_INHERIT_FD_GRPC=4. Sem sufixo,
+	// vale para o HTTP, como nas versões que só entregavam ele.
+	EnvInheritFD = "SMARTCITY_INHERIT_FD"
+	envListenPID = "LISTEN_PID"
+	envListenFDs = "LISTEN_FDS"
+	// envListenFDNames nomeia os fds passados pelo systemd
+	// (FileDescriptorName=); sem ela, o primeiro é o HTTP.
+	envListenFDNames = "LISTEN_FDNAMES"
+	// listenFDsStart é o primeiro fd passado pelo systemd (SD_LISTEN_FDS_START).
+	listenFDsStart = 3
+)
+
+// Options controla como o listener é aberto.
+type Options struct {
+	// Name identifica o listener na herança; vazio é HTTP.
+	Name      string
+	Addr      string
+	ReusePort bool
+}
+
+// Listen retorna o listener herdado com opts.Name, se houver, ou abre um
+// novo em opts.Addr. O booleano indica se o socket foi herdado.
+func Listen(opts Options) (net.Listener, bool, error) {
+	name := opts.Name
+	if name == "" {
+		name = HTTP
+	}
+	if l, err := Inherit(name); err != nil || l != nil {
+		return l, l != nil, err
+	}
+	lc := net.ListenConfig{}
+	if opts.ReusePort {
+		lc.Control = reusePortControl
+	}
+	l, err := lc.Listen(context.Background(), "tcp", opts.Addr)
+	return l, false, err
+}
+
+// Inherit retorna o listener com o nome entregue pelo processo anterior
+// ou pelo systemd, ou nil se não houver. Cada listener é herdado uma só
+// vez.
+func Inherit(name string) (net.Listener, error) {
+	key := envKey(name)
+	fd := os.Getenv(key)
+	if fd == "" && name == HTTP {
+		key, fd = EnvInheritFD, os.Getenv(EnvInheritFD)
+	}
+	if fd != "" {
+		os.Unsetenv(key)
+		n, err := strconv.Atoi(fd)
+		if err != nil {
+			return nil, fmt.Errorf("%s inválido: %q", key, fd)
+		}
+		return fileListener(uintptr(n), name)
+	}
+
+	if pid, _ := strconv.Atoi(os.Getenv(envListenPID)); pid != os.Getpid() {
+		return nil, nil
+	}
+	n, _ := strconv.Atoi(os.Getenv(envListenFDs))
+	names := strings.Split(os.Getenv(envListenFDNames), ":")
+	for i := 0; i < n; i++ {
+		fdName := HTTP
+		if i < len(names) && names[i] != "" {
+			fdName = names[i]
+		} else if i > 0 {
+			continue
+		}
+		if fdName == name {
+			return fileListener(uintptr(listenFDsStart+i), name)
+		}
+	}
+	return nil, nil
+}
+
+func envKey(name string) string {
+	return EnvInheritFD + "_" + strings.ToUpper(name)
+}
+
+func fileListener(fd uintptr, name string) (net.Listener, error) {
+	f := os.NewFile(fd, "inherited-"+name)
+	if f == nil {
+		return nil, fmt.Errorf("fd %d inválido", fd)
+	}
+	defer f.Close()
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("herdar listener %s do fd %d: %w", name, fd, err)
+	}
+	return l, nil
+}
+
+// Handoff inicia uma nova cópia do binário com os mesmos argumentos,
+// entregando-lhe todos os sockets de escuta, por nome. O processo atual
+// deve então drenar e sair; até lá, os dois aceitam conexões.
+func Handoff(listeners map[string]net.Listener) (*os.Process, error) {
+	names := make([]string, 0, len(listeners))
+	for name := range listeners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	files := make([]*os.File, 0, len(names))
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	env := os.Environ()
+	for i, name := range names {
+		tl, ok := listeners[name].(*net.TCPListener)
+		if !ok {
+			return nil, fmt.Errorf("handoff suportado apenas para listeners TCP (%s é %T)", name, listeners[name])
+		}
+		f, err := tl.File()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+		// ExtraFiles[i] vira o fd 3+i no processo filho.
+		env = append(env, envKey(name)+"="+strconv.Itoa(listenFDsStart+i))
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = env
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return cmd.Process, nil
+}
@@ -0,0 +1,219 @@
+//go:build linux || darwin || freebsd
+
+package listener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// envHelper faz o binário de teste agir como o serviço em
+// TestHelperServer; o valor é o arquivo onde os endereços são gravados.
+const envHelper = "LISTENER_TEST_HELPER"
+
+// TestHelperServer não é um teste: é o processo servidor de
+// TestRestartUnderLoad, como o main do serviço. Serve HTTP em dois
+// listeners nomeados, respondendo o próprio pid; com SIGUSR2 entrega os
+// sockets a uma nova cópia e drena, com SIGTERM só drena.
+func TestHelperServer(t *testing.T) {
+	addrFile := os.Getenv(envHelper)
+	if addrFile == "" {
+		t.Skip("processo auxiliar de TestRestartUnderLoad")
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond)
+		fmt.Fprint(w, os.Getpid())
+	})
+	listeners := map[string]net.Listener{}
+	var servers []*http.Server
+	var addrs []string
+	inheritedAll := true
+	for _, name := range []string{HTTP, Debug} {
+		l, inherited, err := Listen(Options{Name: name, Addr: "127.0.0.1:0"})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		inheritedAll = inheritedAll && inherited
+		listeners[name] = l
+		addrs = append(addrs, l.Addr().String())
+		srv := &http.Server{Handler: handler}
+		servers = append(servers, srv)
+		go srv.Serve(l)
+	}
+	if !inheritedAll {
+		tmp := addrFile + ".tmp"
+		if err := os.WriteFile(tmp, []byte(strings.Join(addrs, "\n")), 0o600); err != nil {
+			os.Exit(2)
+		}
+		os.Rename(tmp, addrFile)
+	}
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGTERM, syscall.SIGUSR2)
+	if sig := <-quit; sig == syscall.SIGUSR2 {
+		if _, err := Handoff(listeners); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		// Como o drain_delay do serviço: o novo processo começa a aceitar
+		// antes de este fechar os seus sockets.
+		time.Sleep(200 * time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, srv := range servers {
+		srv.Shutdown(ctx)
+	}
+	os.Exit(0)
+}
+
+func TestRestartUnderLoad(t *testing.T) {
+	if testing.Short() {
+		t.Skip("reinício com processos reais")
+	}
+	addrFile := filepath.Join(t.TempDir(), "addrs")
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHelperServer$")
+	cmd.Env = append(os.Environ(), envHelper+"="+addrFile)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	var pids sync.Map
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		pids.Range(func(k, _ interface{}) bool {
+			if p, err := os.FindProcess(k.(int)); err == nil {
+				p.Signal(syscall.SIGTERM)
+			}
+			return true
+		})
+	})
+
+	var addrs []string
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		if b, err := os.ReadFile(addrFile); err == nil {
+			addrs = strings.Split(string(b), "\n")
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("servidor auxiliar não abriu os listeners")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	var ok, failed atomic.Int64
+	var firstErr atomic.Value
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		addr := addrs[i%len(addrs)]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				pid, err := get(client, "http://"+addr+"/")
+				if err != nil {
+					failed.Add(1)
+					firstErr.CompareAndSwap(nil, err)
+					continue
+				}
+				ok.Add(1)
+				pids.Store(pid, true)
+			}
+		}()
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	if err := cmd.Process.Signal(syscall.SIGUSR2); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("processo antigo: %v", err)
+	}
+	// O processo antigo já saiu: tudo daqui em diante vai para o novo.
+	time.Sleep(300 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+
+	if n := failed.Load(); n > 0 {
+		t.Fatalf("%d de %d requisições falharam no reinício; primeira: %v", n, n+ok.Load(), firstErr.Load())
+	}
+	var served []int
+	pids.Range(func(k, _ interface{}) bool {
+		served = append(served, k.(int))
+		return true
+	})
+	if len(served) < 2 {
+		t.Fatalf("requisições atendidas por %v, esperado o processo antigo e o novo", served)
+	}
+	t.Logf("%d requisições sem falha, atendidas pelos processos %v", ok.Load(), served)
+}
+
+func get(client *http.Client, url string) (int, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, errors.New(resp.Status)
+	}
+	return strconv.Atoi(string(body))
+}
+
+func TestInheritByName(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	f, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	t.Setenv(envKey(GRPC), strconv.Itoa(int(f.Fd())))
+
+	if got, err := Inherit(HTTP); err != nil || got != nil {
+		t.Fatalf("Inherit(http) = %v, %v; want nil", got, err)
+	}
+	got, err := Inherit(GRPC)
+	if err != nil || got == nil {
+		t.Fatalf("Inherit(grpc) = %v, %v", got, err)
+	}
+	defer got.Close()
+	if got.Addr().String() != l.Addr().String() {
+		t.Errorf("Inherit(grpc) em %s, want %s", got.Addr(), l.Addr())
+	}
+	if again, _ := Inherit(GRPC); again != nil {
+		again.Close()
+		t.Error("listener herdado duas vezes")
+	}
+}
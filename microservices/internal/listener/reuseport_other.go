@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd
+
+package listener
+
+import "syscall"
+
+// SO_REUSEPORT não é suportado nesta plataforma; o listener abre normalmente.
+func reusePortControl(_, _ string, _ syscall.RawConn) error {
+	return nil
+}
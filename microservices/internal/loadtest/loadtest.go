@@ -0,0 +1,267 @@
+// Package loadtest gera carga contra um agent-service em execução: leituras
+// e escritas da API de agentes em paralelo, na proporção configurada, e
+// assinantes websocket, medindo a latência e os erros de cada operação.
+package loadtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Operações medidas.
+const (
+	OpList      = "list"
+	OpGet       = "get"
+	OpUpdate    = "update"
+	OpWebSocket = "websocket"
+)
+
+// seedPageSize é quantos agentes são lidos no início para as leituras e
+// escritas por id.
+const seedPageSize = 100
+
+// Config configura uma execução.
+type Config struct {
+	// Target é a URL base do serviço, ex.: http://localhost:8080.
+	Target string
+	// APIKey vai em X-API-Key; vazio não autentica.
+	APIKey      string
+	Duration    time.Duration
+	Concurrency int
+	// Reads e Writes são os pesos das leituras (listagem e busca por id, meio
+	// a meio) e das escritas (PUT /agents/:id) no total de requisições.
+	Reads  int
+	Writes int
+	// Subscribers é o número de conexões websocket abertas durante a carga.
+	Subscribers int
+	// SimulationID restringe os agentes usados; vazio usa quaisquer.
+	SimulationID string
+	Timeout      time.Duration
+}
+
+// seedAgent é o que a carga guarda de cada agente lido no início.
+type seedAgent struct {
+	ID    string                 `json:"id"`
+	State map[string]interface{} `json:"state"`
+}
+
+// Run gera a carga até cfg.Duration ou o cancelamento de ctx e retorna o
+// relatório. As escritas acrescentam state.loadtest_seq ao estado lido no
+// início, sobrescrevendo mudanças feitas depois dele: use fora de produção.
+func Run(ctx context.Context, cfg Config) (*Report, error) {
+	base, err := url.Parse(strings.TrimRight(cfg.Target, "/"))
+	if err != nil || base.Scheme == "" || base.Host == "" {
+		return nil, fmt.Errorf("alvo inválido %q: use uma URL absoluta", cfg.Target)
+	}
+	if cfg.Concurrency <= 0 || cfg.Duration <= 0 || cfg.Reads < 0 || cfg.Writes < 0 || cfg.Reads+cfg.Writes == 0 {
+		return nil, errors.New("concorrência, duração e ao menos um dos pesos precisam ser positivos")
+	}
+	r := &runner{
+		cfg:  cfg,
+		base: base,
+		client: &http.Client{
+			Timeout: cfg.Timeout,
+			Transport: &http.Transport{
+				MaxIdleConns:        cfg.Concurrency,
+				MaxIdleConnsPerHost: cfg.Concurrency,
+			},
+		},
+	}
+	defer r.client.CloseIdleConnections()
+
+	agents, err := r.seed(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(agents) == 0 {
+		return nil, errors.New("nenhum agente encontrado no alvo; crie agentes antes da carga")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Duration)
+	defer cancel()
+
+	var subs sync.WaitGroup
+	ws := newStats(OpWebSocket)
+	for i := 0; i < cfg.Subscribers; i++ {
+		subs.Add(1)
+		go func() {
+			defer subs.Done()
+			r.subscribe(ctx, ws)
+		}()
+	}
+
+	start := time.Now()
+	results := make([]map[string]*stats, cfg.Concurrency)
+	var workers sync.WaitGroup
+	var seq atomic.Int64
+	for i := range results {
+		results[i] = map[string]*stats{OpList: newStats(OpList), OpGet: newStats(OpGet), OpUpdate: newStats(OpUpdate)}
+		workers.Add(1)
+		go func(own map[string]*stats, rng *rand.Rand) {
+			defer workers.Done()
+			for ctx.Err() == nil {
+				r.request(ctx, own, rng, agents, &seq)
+			}
+		}(results[i], rand.New(rand.NewSource(time.Now().UnixNano()+int64(i))))
+	}
+	workers.Wait()
+	elapsed := time.Since(start)
+	subs.Wait()
+
+	merged := map[string]*stats{OpList: newStats(OpList), OpGet: newStats(OpGet), OpUpdate: newStats(OpUpdate)}
+	for _, own := range results {
+		for op, s := range own {
+			merged[op].merge(s)
+		}
+	}
+	return newReport(elapsed, []*stats{merged[OpList], merged[OpGet], merged[OpUpdate]}, ws, cfg.Subscribers), nil
+}
+
+type runner struct {
+	cfg    Config
+	base   *url.URL
+	client *http.Client
+}
+
+// seed lê a primeira página de agentes do alvo.
+func (r *runner) seed(ctx context.Context) ([]seedAgent, error) {
+	q := url.Values{"page_size": {fmt.Sprint(seedPageSize)}}
+	if r.cfg.SimulationID != "" {
+		q.Set("simulation_id", r.cfg.SimulationID)
+	}
+	req, err := r.newRequest(ctx, http.MethodGet, "/api/v1/agents?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("listar agentes do alvo: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("listar agentes do alvo: status %d", resp.StatusCode)
+	}
+	var page struct {
+		Data []seedAgent `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return nil, fmt.Errorf("decodificar agentes do alvo: %w", err)
+	}
+	return page.Data, nil
+}
+
+// request sorteia e faz uma operação, registrando-a em own.
+func (r *runner) request(ctx context.Context, own map[string]*stats, rng *rand.Rand, agents []seedAgent, seq *atomic.Int64) {
+	a := agents[rng.Intn(len(agents))]
+	op, method, path := OpGet, http.MethodGet, "/api/v1/agents/"+url.PathEscape(a.ID)
+	var body []byte
+	switch {
+	case rng.Intn(r.cfg.Reads+r.cfg.Writes) >= r.cfg.Reads:
+		state := make(map[string]interface{}, len(a.State)+1)
+		for k, v := range a.State {
+			state[k] = v
+		}
+		state["loadtest_seq"] = seq.Add(1)
+		body, _ = json.Marshal(map[string]interface{}{"state": state})
+		op, method = OpUpdate, http.MethodPut
+	case rng.Intn(2) == 0:
+		op, path = OpList, "/api/v1/agents?page_size=20"
+		if r.cfg.SimulationID != "" {
+			path += "&simulation_id=" + url.QueryEscape(r.cfg.SimulationID)
+		}
+	}
+
+	req, err := r.newRequest(ctx, method, path, body)
+	if err != nil {
+		own[op].fail(0)
+		return
+	}
+	start := time.Now()
+	resp, err := r.client.Do(req)
+	if err != nil {
+		// O fim da carga cancela as requisições em andamento; não são erros.
+		if ctx.Err() == nil {
+			own[op].fail(time.Since(start))
+		}
+		return
+	}
+	_, err = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	elapsed := time.Since(start)
+	switch {
+	case err != nil && ctx.Err() != nil:
+	case err != nil || resp.StatusCode >= 400:
+		own[op].fail(elapsed)
+	default:
+		own[op].ok(elapsed)
+	}
+}
+
+func (r *runner) newRequest(ctx context.Context, method, path string, body []byte) (*http.Request, error) {
+	var rd io.Reader
+	if body != nil {
+		rd = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, r.base.String()+path, rd)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if r.cfg.APIKey != "" {
+		req.Header.Set("X-API-Key", r.cfg.APIKey)
+	}
+	req.Header.Set("User-Agent", "smart-city-agent-service-loadtest")
+	return req, nil
+}
+
+// subscribe mantém uma conexão websocket e conta as mensagens recebidas. A
+// latência registrada é a da conexão.
+func (r *runner) subscribe(ctx context.Context, s *stats) {
+	u := *r.base
+	u.Scheme = map[string]string{"https": "wss"}[u.Scheme]
+	if u.Scheme == "" {
+		u.Scheme = "ws"
+	}
+	u.Path = strings.TrimRight(u.Path, "/") + "/ws"
+	header := http.Header{}
+	if r.cfg.APIKey != "" {
+		header.Set("X-API-Key", r.cfg.APIKey)
+	}
+
+	start := time.Now()
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, u.String(), header)
+	if err != nil {
+		if ctx.Err() == nil {
+			s.fail(time.Since(start))
+		}
+		return
+	}
+	s.ok(time.Since(start))
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	defer conn.Close()
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			if ctx.Err() == nil {
+				s.drop()
+			}
+			return
+		}
+		s.message()
+	}
+}
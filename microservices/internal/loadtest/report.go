@@ -0,0 +1,150 @@
+package loadtest
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// stats acumula as latências e os erros de uma operação.
+type stats struct {
+	op string
+
+	mu        sync.Mutex
+	requests  int
+	latencies []time.Duration
+	errors    int
+	// messages e drops só valem para os assinantes websocket: mensagens
+	// recebidas e conexões perdidas antes do fim da carga.
+	messages int
+	drops    int
+}
+
+func newStats(op string) *stats {
+	return &stats{op: op}
+}
+
+func (s *stats) ok(d time.Duration) {
+	s.mu.Lock()
+	s.requests++
+	s.latencies = append(s.latencies, d)
+	s.mu.Unlock()
+}
+
+// fail conta um erro; a latência entra nos percentis, pois o cliente
+// esperou por ela. Zero é a requisição que nem chegou a sair.
+func (s *stats) fail(d time.Duration) {
+	s.mu.Lock()
+	s.requests++
+	s.errors++
+	if d > 0 {
+		s.latencies = append(s.latencies, d)
+	}
+	s.mu.Unlock()
+}
+
+func (s *stats) message() {
+	s.mu.Lock()
+	s.messages++
+	s.mu.Unlock()
+}
+
+func (s *stats) drop() {
+	s.mu.Lock()
+	s.drops++
+	s.mu.Unlock()
+}
+
+func (s *stats) merge(o *stats) {
+	s.requests += o.requests
+	s.latencies = append(s.latencies, o.latencies...)
+	s.errors += o.errors
+}
+
+// OpReport é o resultado de uma operação.
+type OpReport struct {
+	Op       string
+	Requests int
+	Errors   int
+	P50      time.Duration
+	P90      time.Duration
+	P95      time.Duration
+	P99      time.Duration
+	Max      time.Duration
+}
+
+// ErrorRate é a fração das requisições que falharam.
+func (o OpReport) ErrorRate() float64 {
+	if o.Requests == 0 {
+		return 0
+	}
+	return float64(o.Errors) / float64(o.Requests)
+}
+
+// Report é o resultado de uma execução.
+type Report struct {
+	Elapsed time.Duration
+	Ops     []OpReport
+	// Subscribers são os assinantes pedidos; Connected, os que conectaram.
+	Subscribers int
+	Connected   int
+	Messages    int
+	Drops       int
+	Connect     OpReport
+}
+
+func newReport(elapsed time.Duration, ops []*stats, ws *stats, subscribers int) *Report {
+	r := &Report{Elapsed: elapsed, Subscribers: subscribers}
+	for _, s := range ops {
+		r.Ops = append(r.Ops, summarize(s))
+	}
+	r.Connect = summarize(ws)
+	r.Connected = r.Connect.Requests - r.Connect.Errors
+	r.Messages, r.Drops = ws.messages, ws.drops
+	return r
+}
+
+func summarize(s *stats) OpReport {
+	sort.Slice(s.latencies, func(i, j int) bool { return s.latencies[i] < s.latencies[j] })
+	o := OpReport{Op: s.op, Requests: s.requests, Errors: s.errors}
+	if n := len(s.latencies); n > 0 {
+		o.P50, o.P90, o.P95, o.P99 = percentile(s.latencies, 50), percentile(s.latencies, 90), percentile(s.latencies, 95), percentile(s.latencies, 99)
+		o.Max = s.latencies[n-1]
+	}
+	return o
+}
+
+// percentile usa o método nearest-rank sobre as latências ordenadas.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	i := (len(sorted)*p + 99) / 100
+	return sorted[max(i, 1)-1]
+}
+
+// Write escreve o relatório em colunas.
+func (r *Report) Write(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "operação\treq\treq/s\terros\tp50\tp90\tp95\tp99\tmax\t\n")
+	total := 0
+	for _, o := range r.Ops {
+		total += o.Requests
+		fmt.Fprintf(tw, "%s\t%d\t%.1f\t%.2f%%\t%s\t%s\t%s\t%s\t%s\t\n", o.Op, o.Requests,
+			float64(o.Requests)/r.Elapsed.Seconds(), 100*o.ErrorRate(),
+			ms(o.P50), ms(o.P90), ms(o.P95), ms(o.P99), ms(o.Max))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "\n%d requisições em %s (%.1f req/s)\n", total, r.Elapsed.Round(time.Millisecond), float64(total)/r.Elapsed.Seconds())
+	if r.Subscribers > 0 {
+		fmt.Fprintf(w, "websocket: %d de %d conectados (p99 da conexão %s), %d mensagens recebidas, %d conexões perdidas\n",
+			r.Connected, r.Subscribers, ms(r.Connect.P99), r.Messages, r.Drops)
+	}
+	return nil
+}
+
+func ms(d time.Duration) string {
+	return fmt.Sprintf("%.1fms", float64(d)/float64(time.Millisecond))
+}
@@ -0,0 +1,99 @@
+// Package logging centraliza o logger estruturado do serviço e a propagação
+// de campos de correlação (request_id, trace_id, principal, project) via context.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/auth"
+)
+
+// Nomes dos campos de correlação presentes em toda linha de log.
+const (
+	FieldRequestID = "request_id"
+	FieldTraceID   = "trace_id"
+	FieldPrincipal = "principal"
+	FieldProject   = "project"
+	FieldComponent = "component"
+)
+
+type loggerKey struct{}
+
+type correlation struct {
+	requestID string
+	traceID   string
+}
+
+type correlationKey struct{}
+
+// WithLogger retorna um contexto carregando a entry informada.
+func WithLogger(ctx context.Context, entry *logrus.Entry) context.Context {
+	return context.WithValue(ctx, loggerKey{}, entry)
+}
+
+// WithFields adiciona campos ao logger já associado ao contexto.
+func WithFields(ctx context.Context, fields logrus.Fields) context.Context {
+	return WithLogger(ctx, entryFrom(ctx).WithFields(fields))
+}
+
+// FromContext retorna o logger com os campos de correlação do contexto.
+// O principal é resolvido no momento da chamada, pois a autenticação pode
+// acontecer depois que o logger foi associado à requisição.
+func FromContext(ctx context.Context) *logrus.Entry {
+	entry := entryFrom(ctx)
+	if p := auth.FromContext(ctx); p != nil {
+		if _, ok := entry.Data[FieldPrincipal]; !ok {
+			entry = entry.WithField(FieldPrincipal, p.Subject)
+		}
+	}
+	return entry
+}
+
+// RequestID retorna o request_id associado ao contexto, se houver.
+func RequestID(ctx context.Context) string {
+	c, _ := ctx.Value(correlationKey{}).(correlation)
+	return c.requestID
+}
+
+// TraceID retorna o trace_id associado ao contexto, se houver.
+func TraceID(ctx context.Context) string {
+	c, _ := ctx.Value(correlationKey{}).(correlation)
+	return c.traceID
+}
+
+// WithCorrelation associa request_id e trace_id ao contexto e ao logger.
+func WithCorrelation(ctx context.Context, requestID, traceID string) context.Context {
+	ctx = context.WithValue(ctx, correlationKey{}, correlation{requestID: requestID, traceID: traceID})
+	return WithFields(ctx, logrus.Fields{
+		FieldRequestID: requestID,
+		FieldTraceID:   traceID,
+	})
+}
+
+// Background cria o contexto de um job em segundo plano (scheduler, relay do
+// outbox, etc.) com ids de correlação próprios, já que não há requisição de origem.
+func Background(ctx context.Context, component string) context.Context {
+	ctx = WithCorrelation(ctx, uuid.NewString(), NewTraceID())
+	return WithFields(ctx, logrus.Fields{FieldComponent: component})
+}
+
+// NewTraceID gera um trace id de 16 bytes no formato W3C Trace Context.
+func NewTraceID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return uuid.NewString()
+	}
+	return hex.EncodeToString(b[:])
+}
+
+func entryFrom(ctx context.Context) *logrus.Entry {
+	if entry, ok := ctx.Value(loggerKey{}).(*logrus.Entry); ok {
+		return entry
+	}
+	return logrus.NewEntry(logrus.StandardLogger())
+}
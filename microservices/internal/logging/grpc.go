@@ -0,0 +1,84 @@
+package logging
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryServerInterceptor é o equivalente gRPC de Middleware: associa
+// request_id, trace_id e project ao contexto a partir dos metadados, devolve
+// o request_id no cabeçalho da resposta e registra o resultado da chamada.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx = grpcCorrelation(ctx)
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		logCall(ctx, info.FullMethod, start, err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor é o equivalente de UnaryServerInterceptor para streams.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := grpcCorrelation(ss.Context())
+		start := time.Now()
+		err := handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+		logCall(ctx, info.FullMethod, start, err)
+		return err
+	}
+}
+
+func grpcCorrelation(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	get := func(key string) string {
+		if v := md.Get(key); len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+
+	requestID := get(HeaderRequestID)
+	if requestID == "" {
+		requestID = uuid.NewString()
+	}
+	grpc.SetHeader(ctx, metadata.Pairs(HeaderRequestID, requestID))
+
+	traceID := traceIDFromHeader(get(HeaderTraceParent))
+	if traceID == "" {
+		traceID = NewTraceID()
+	}
+
+	ctx = WithCorrelation(ctx, requestID, traceID)
+	if project := get(HeaderProjectID); project != "" {
+		ctx = WithFields(ctx, logrus.Fields{FieldProject: project})
+	}
+	return ctx
+}
+
+func logCall(ctx context.Context, method string, start time.Time, err error) {
+	code := status.Code(err)
+	entry := FromContext(ctx).WithFields(logrus.Fields{
+		"grpc_method": method,
+		"grpc_code":   code.String(),
+		"duration_ms": time.Since(start).Milliseconds(),
+	})
+	if err != nil {
+		entry = entry.WithError(err)
+	}
+	entry.Info("Chamada gRPC concluída")
+}
+
+// contextStream substitui o contexto de um grpc.ServerStream.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context { return s.ctx }
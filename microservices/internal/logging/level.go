@@ -0,0 +1,124 @@
+package logging
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Formatos de log suportados.
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// Configure aplica nível e formato ao logger padrão do logrus.
+func Configure(level, format string) error {
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		return fmt.Errorf("nível de log inválido %q: %w", level, err)
+	}
+	if err := SetFormat(format); err != nil {
+		return err
+	}
+	logrus.SetLevel(lvl)
+	return nil
+}
+
+// SetFormat troca o formatter do logger padrão.
+func SetFormat(format string) error {
+	switch format {
+	case FormatJSON, "":
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	case FormatText:
+		logrus.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	default:
+		return fmt.Errorf("formato de log inválido %q (use json ou text)", format)
+	}
+	return nil
+}
+
+// LevelState descreve o nível ativo e, se temporário, quando será revertido.
+type LevelState struct {
+	Level     string     `json:"level"`
+	Default   string     `json:"default"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// LevelController troca o nível de log em tempo de execução, com reversão
+// automática para o nível padrão após uma duração.
+type LevelController struct {
+	mu        sync.Mutex
+	base      logrus.Level
+	timer     *time.Timer
+	expiresAt *time.Time
+}
+
+// NewLevelController cria um controller cujo nível padrão é o atual do logrus.
+func NewLevelController() *LevelController {
+	return &LevelController{base: logrus.GetLevel()}
+}
+
+// SetDefault altera o nível padrão (usado ao recarregar configuração).
+// Um nível temporário ativo continua valendo até expirar.
+func (lc *LevelController) SetDefault(level logrus.Level) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	lc.base = level
+	if lc.timer == nil {
+		logrus.SetLevel(level)
+	}
+}
+
+// Set aplica o nível informado. Com duration > 0 o nível volta ao padrão
+// depois do prazo; com duration zero a troca vira o novo padrão.
+func (lc *LevelController) Set(level logrus.Level, duration time.Duration) LevelState {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	if lc.timer != nil {
+		lc.timer.Stop()
+		lc.timer = nil
+		lc.expiresAt = nil
+	}
+
+	logrus.SetLevel(level)
+	if duration <= 0 {
+		lc.base = level
+		return lc.stateLocked()
+	}
+
+	expires := time.Now().UTC().Add(duration)
+	lc.expiresAt = &expires
+	var timer *time.Timer
+	timer = time.AfterFunc(duration, func() {
+		lc.mu.Lock()
+		defer lc.mu.Unlock()
+		if lc.timer != timer {
+			return
+		}
+		logrus.SetLevel(lc.base)
+		lc.timer = nil
+		lc.expiresAt = nil
+		logrus.WithField("level", lc.base.String()).Info("Nível de log temporário expirou, revertido ao padrão")
+	})
+	lc.timer = timer
+	return lc.stateLocked()
+}
+
+// State retorna o nível ativo.
+func (lc *LevelController) State() LevelState {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	return lc.stateLocked()
+}
+
+func (lc *LevelController) stateLocked() LevelState {
+	return LevelState{
+		Level:     logrus.GetLevel().String(),
+		Default:   lc.base.String(),
+		ExpiresAt: lc.expiresAt,
+	}
+}
@@ -0,0 +1,64 @@
+package logging
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// Cabeçalhos usados para correlação entre serviços.
+const (
+	HeaderRequestID   = "X-Request-ID"
+	HeaderProjectID   = "X-Project-ID"
+	HeaderTraceParent = "traceparent"
+)
+
+// Middleware associa à requisição um logger com request_id, trace_id e
+// project. Deve ser registrado depois de middleware.RequestID para reaproveitar
+// o id já gerado.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetString("request_id")
+		if requestID == "" {
+			requestID = c.Writer.Header().Get(HeaderRequestID)
+		}
+		if requestID == "" {
+			requestID = c.GetHeader(HeaderRequestID)
+		}
+		if requestID == "" {
+			requestID = uuid.NewString()
+			c.Writer.Header().Set(HeaderRequestID, requestID)
+		}
+
+		traceID := traceIDFromHeader(c.GetHeader(HeaderTraceParent))
+		if traceID == "" {
+			traceID = NewTraceID()
+		}
+
+		ctx := WithCorrelation(c.Request.Context(), requestID, traceID)
+		if project := projectFrom(c); project != "" {
+			ctx = WithFields(ctx, logrus.Fields{FieldProject: project})
+		}
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// traceIDFromHeader extrai o trace-id de um cabeçalho traceparent
+// ("00-<trace-id>-<parent-id>-<flags>").
+func traceIDFromHeader(header string) string {
+	parts := strings.Split(header, "-")
+	if len(parts) != 4 || len(parts[1]) != 32 {
+		return ""
+	}
+	return parts[1]
+}
+
+func projectFrom(c *gin.Context) string {
+	if p := c.GetHeader(HeaderProjectID); p != "" {
+		return p
+	}
+	return c.Query("project_id")
+}
@@ -0,0 +1,251 @@
+// Package longpoll entrega os eventos do hub websocket por long-poll, para
+// clientes que não têm websocket nem SSE (GET /api/v1/events/poll).
+//
+// O Buffer fica entre o barramento e o hub: recebe as mesmas mensagens que
+// o hub, locais e repassadas pelas outras réplicas (ver hubrelay), e guarda
+// as últimas numa fila circular numerada. Um poll lê as mensagens depois do
+// cursor nos tópicos pedidos; sem nenhuma, inscreve-se nos tópicos e dorme
+// até uma mensagem chegar a um deles ou o contexto acabar, sem goroutine
+// extra nem espera ativa.
+//
+// O cursor é desta instância: vale "<época>:<sequência>", e a época muda a
+// cada início do serviço. Um cursor de outra época, ou mais antigo que a
+// fila, volta com reset, e o cliente recupera o que perdeu por GET
+// /api/v1/events, como depois de uma queda do websocket.
+package longpoll
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrInvalidCursor indica um since_id fora do formato.
+var ErrInvalidCursor = errors.New("invalid since_id")
+
+// Broadcaster é o hub que recebe as mensagens; *websocket.Hub o implementa.
+type Broadcaster interface {
+	BroadcastToTopic(topic string, payload interface{})
+}
+
+// entry é uma mensagem guardada, já serializada.
+type entry struct {
+	seq   uint64
+	topic string
+	body  json.RawMessage
+}
+
+// waiter é um poll dormindo à espera de mensagens nos seus tópicos.
+type waiter struct {
+	wake chan struct{}
+}
+
+// Buffer guarda as últimas mensagens do hub para os polls.
+type Buffer struct {
+	epoch string
+
+	mu      sync.Mutex
+	entries []entry
+	next    int
+	full    bool
+	seq     uint64
+	waiters map[string]map[*waiter]struct{}
+}
+
+// NewBuffer cria o buffer com capacidade para size mensagens.
+func NewBuffer(size int) *Buffer {
+	var b [4]byte
+	_, _ = rand.Read(b[:])
+	return &Buffer{
+		epoch:   hex.EncodeToString(b[:]),
+		entries: make([]entry, size),
+		waiters: map[string]map[*waiter]struct{}{},
+	}
+}
+
+// Tee retorna um Broadcaster que entrega cada mensagem ao hub e ao buffer.
+func (b *Buffer) Tee(hub Broadcaster) Broadcaster {
+	return tee{hub: hub, buffer: b}
+}
+
+type tee struct {
+	hub    Broadcaster
+	buffer *Buffer
+}
+
+func (t tee) BroadcastToTopic(topic string, payload interface{}) {
+	t.hub.BroadcastToTopic(topic, payload)
+	t.buffer.BroadcastToTopic(topic, payload)
+}
+
+// BroadcastToTopic guarda a mensagem, descartando a mais antiga com o
+// buffer cheio, e acorda os polls inscritos no tópico.
+func (b *Buffer) BroadcastToTopic(topic string, payload interface{}) {
+	var body json.RawMessage
+	switch v := payload.(type) {
+	case json.RawMessage:
+		body = v
+	case []byte:
+		body = v
+	default:
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return
+		}
+		body = raw
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.seq++
+	b.entries[b.next] = entry{seq: b.seq, topic: topic, body: body}
+	b.next = (b.next + 1) % len(b.entries)
+	if b.next == 0 {
+		b.full = true
+	}
+	for w := range b.waiters[topic] {
+		select {
+		case w.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Subscribers retorna quantos polls dormem à espera de mensagens do
+// tópico.
+func (b *Buffer) Subscribers(topic string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.waiters[topic])
+}
+
+// Batch é o resultado de um poll.
+type Batch struct {
+	Events []json.RawMessage
+	// Cursor é o since_id do próximo poll.
+	Cursor string
+	// Reset indica que mensagens depois do cursor pedido se perderam: ele
+	// é de outra época ou mais antigo que a fila.
+	Reset bool
+}
+
+// Poll retorna até limit mensagens dos tópicos depois de since, o cursor
+// de um poll anterior; vazio começa nas próximas mensagens. Sem nenhuma,
+// espera até uma chegar ou ctx acabar, e então retorna o lote vazio com o
+// cursor atualizado.
+func (b *Buffer) Poll(ctx context.Context, topics []string, since string, limit int) (*Batch, error) {
+	epoch, after, err := parseCursor(since)
+	if err != nil {
+		return nil, err
+	}
+	wanted := make(map[string]bool, len(topics))
+	for _, t := range topics {
+		wanted[t] = true
+	}
+
+	w := &waiter{wake: make(chan struct{}, 1)}
+	b.mu.Lock()
+	reset := false
+	if since == "" || epoch != b.epoch || after > b.seq {
+		reset = since != ""
+		after = b.seq
+	} else if oldest := b.oldest(); oldest > 0 && after+1 < oldest {
+		reset = true
+	}
+	for {
+		batch := b.read(wanted, after, limit)
+		if len(batch.Events) > 0 || ctx.Err() != nil {
+			b.unsubscribe(w, topics)
+			b.mu.Unlock()
+			batch.Reset = reset
+			return batch, nil
+		}
+		after = b.seq
+		b.subscribe(w, topics)
+		b.mu.Unlock()
+
+		select {
+		case <-w.wake:
+		case <-ctx.Done():
+		}
+		b.mu.Lock()
+	}
+}
+
+// read coleta, com o lock, até limit mensagens depois de after. O cursor é
+// o da última coletada ou, sem nenhuma, o da última guardada.
+func (b *Buffer) read(wanted map[string]bool, after uint64, limit int) *Batch {
+	batch := &Batch{Events: []json.RawMessage{}}
+	last := b.seq
+	n := b.next
+	if b.full {
+		n = len(b.entries)
+	}
+	for i := n; i >= 1; i-- {
+		e := b.entries[(b.next-i+len(b.entries))%len(b.entries)]
+		if e.seq <= after || !wanted[e.topic] {
+			continue
+		}
+		if len(batch.Events) == limit {
+			last = e.seq - 1
+			break
+		}
+		batch.Events = append(batch.Events, e.body)
+	}
+	batch.Cursor = b.epoch + ":" + strconv.FormatUint(last, 10)
+	return batch
+}
+
+// oldest é a sequência da mensagem mais antiga guardada; 0 sem nenhuma.
+func (b *Buffer) oldest() uint64 {
+	if b.full {
+		return b.entries[b.next].seq
+	}
+	if b.next == 0 {
+		return 0
+	}
+	return b.entries[0].seq
+}
+
+func (b *Buffer) subscribe(w *waiter, topics []string) {
+	for _, t := range topics {
+		set := b.waiters[t]
+		if set == nil {
+			set = map[*waiter]struct{}{}
+			b.waiters[t] = set
+		}
+		set[w] = struct{}{}
+	}
+}
+
+func (b *Buffer) unsubscribe(w *waiter, topics []string) {
+	for _, t := range topics {
+		if set := b.waiters[t]; set != nil {
+			delete(set, w)
+			if len(set) == 0 {
+				delete(b.waiters, t)
+			}
+		}
+	}
+}
+
+// parseCursor separa a época e a sequência de since; vazio é válido.
+func parseCursor(since string) (string, uint64, error) {
+	if since == "" {
+		return "", 0, nil
+	}
+	epoch, seq, ok := strings.Cut(since, ":")
+	if !ok || epoch == "" {
+		return "", 0, ErrInvalidCursor
+	}
+	n, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil {
+		return "", 0, ErrInvalidCursor
+	}
+	return epoch, n, nil
+}
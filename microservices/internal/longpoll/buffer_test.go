@@ -0,0 +1,53 @@
+package longpoll
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+// BenchmarkBroadcast1kClients mede a entrega de uma mensagem a mil polls
+// dormindo no tópico: a mensagem é guardada uma vez e cada poll acorda e
+// lê o mesmo corpo serializado. É o caminho de difusão do hub que está
+// neste repositório; o *websocket.Hub, do outro lado do Tee, não está.
+func BenchmarkBroadcast1kClients(b *testing.B) {
+	const clients = 1000
+	const topic = "simulation:sim-1:agents"
+	buf := NewBuffer(1024)
+	agents := make([]map[string]interface{}, 100)
+	for i := range agents {
+		agents[i] = map[string]interface{}{"id": fmt.Sprintf("bus-%d", i), "lat": -23.55, "lon": -46.63, "status": "moving"}
+	}
+	payload, err := json.Marshal(map[string]interface{}{"type": "agents.updated", "agents": agents})
+	if err != nil {
+		b.Fatal(err)
+	}
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		var wg sync.WaitGroup
+		wg.Add(clients)
+		for c := 0; c < clients; c++ {
+			go func() {
+				defer wg.Done()
+				batch, err := buf.Poll(ctx, []string{topic}, "", 10)
+				if err != nil {
+					b.Error(err)
+				} else if len(batch.Events) != 1 {
+					b.Errorf("poll com %d eventos, want 1", len(batch.Events))
+				}
+			}()
+		}
+		for buf.Subscribers(topic) < clients {
+			runtime.Gosched()
+		}
+		b.StartTimer()
+		buf.BroadcastToTopic(topic, json.RawMessage(payload))
+		wg.Wait()
+	}
+}
@@ -0,0 +1,179 @@
+package longpoll
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/logging"
+)
+
+var (
+	activePolls = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "agent_service",
+		Name:      "events_long_polls_active",
+		Help:      "Long-polls de eventos em espera.",
+	})
+	rejectedPolls = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "events_long_polls_rejected_total",
+		Help:      "Long-polls recusados por passar do limite de polls simultâneos do principal.",
+	})
+)
+
+const (
+	// maxTopics limita os tópicos de um poll.
+	maxTopics = 16
+	// writeMargin é o tempo, além da espera, dado à escrita da resposta.
+	writeMargin = 5 * time.Second
+	// fallbackTimeout é a espera máxima quando o prazo de escrita do
+	// servidor não pode ser estendido; fica abaixo do WriteTimeout dele.
+	fallbackTimeout = 10 * time.Second
+)
+
+// Config configura o Handler.
+type Config struct {
+	MaxEvents       int
+	DefaultTimeout  time.Duration
+	MaxTimeout      time.Duration
+	MaxPerPrincipal int
+}
+
+// Handler responde os long-polls.
+type Handler struct {
+	buffer *Buffer
+	cfg    Config
+
+	mu     sync.Mutex
+	active map[string]int
+}
+
+// NewHandler cria o handler de long-poll.
+func NewHandler(buffer *Buffer, cfg Config) *Handler {
+	return &Handler{buffer: buffer, cfg: cfg, active: map[string]int{}}
+}
+
+// Poll responde GET /events/poll?topic=agents,alerts&since_id=&timeout=25s
+// com até ?limit= envelopes, na forma entregue pelo websocket, e o
+// next_cursor. Espera até um evento chegar ou o timeout acabar; sem
+// eventos, responde a lista vazia. 429 se o principal já tem
+// MaxPerPrincipal polls abertos nesta instância. O tópico admin exige o
+// papel admin.
+func (h *Handler) Poll(c *gin.Context) {
+	p := auth.FromGin(c)
+	topics, err := h.topics(c.Query("topic"), p.HasRole(auth.RoleAdmin))
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, errAdminTopic) {
+			status = http.StatusForbidden
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	timeout := h.cfg.DefaultTimeout
+	if v := c.Query("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 || d > h.cfg.MaxTimeout {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid timeout: " + v + " (use a duration up to " + h.cfg.MaxTimeout.String() + ")"})
+			return
+		}
+		timeout = d
+	}
+	limit := h.cfg.MaxEvents
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit: " + v})
+			return
+		}
+		limit = min(n, h.cfg.MaxEvents)
+	}
+
+	key := c.ClientIP()
+	if p != nil && p.Subject != "" {
+		key = p.Subject
+	}
+	if !h.acquire(key) {
+		rejectedPolls.Inc()
+		c.Header("Retry-After", "1")
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many concurrent long-polls (limit " + strconv.Itoa(h.cfg.MaxPerPrincipal) + ")"})
+		return
+	}
+	defer h.release(key)
+
+	// A espera passa do WriteTimeout do servidor; sem como estendê-lo, ela
+	// fica abaixo dele.
+	rc := http.NewResponseController(c.Writer)
+	if err := rc.SetWriteDeadline(time.Now().Add(timeout + writeMargin)); err != nil {
+		logging.FromContext(c.Request.Context()).WithError(err).Debug("Prazo de escrita do long-poll não estendido")
+		timeout = min(timeout, fallbackTimeout)
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	defer cancel()
+	activePolls.Inc()
+	batch, err := h.buffer.Poll(ctx, topics, c.Query("since_id"), limit)
+	activePolls.Dec()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	resp := gin.H{"data": batch.Events, "next_cursor": batch.Cursor}
+	if batch.Reset {
+		resp["reset"] = true
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+var errAdminTopic = errors.New("role " + auth.RoleAdmin + " required for topic " + events.TopicAdmin)
+
+// topics lê a lista de tópicos de ?topic=, separados por vírgula.
+func (h *Handler) topics(v string, admin bool) ([]string, error) {
+	var out []string
+	seen := map[string]bool{}
+	for _, t := range strings.Split(v, ",") {
+		t = strings.TrimSpace(t)
+		if t == "" || seen[t] {
+			continue
+		}
+		if t == events.TopicAdmin && !admin {
+			return nil, errAdminTopic
+		}
+		seen[t] = true
+		out = append(out, t)
+	}
+	switch {
+	case len(out) == 0:
+		return nil, errors.New("topic is required")
+	case len(out) > maxTopics:
+		return nil, errors.New("too many topics (limit " + strconv.Itoa(maxTopics) + ")")
+	}
+	return out, nil
+}
+
+func (h *Handler) acquire(key string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.active[key] >= h.cfg.MaxPerPrincipal {
+		return false
+	}
+	h.active[key]++
+	return true
+}
+
+func (h *Handler) release(key string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.active[key]--; h.active[key] <= 0 {
+		delete(h.active, key)
+	}
+}
@@ -0,0 +1,54 @@
+package maintenance
+
+import (
+	"context"
+	"path"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryServerInterceptor é o equivalente de Middleware na API gRPC: recusa
+// com codes.Unavailable e o metadado retry-after as chamadas bloqueadas. Em
+// read_only passam as leituras (métodos List*, Get* e Watch*).
+func (s *Switch) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := s.check(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor é o equivalente de UnaryServerInterceptor para
+// streams.
+func (s *Switch) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := s.check(ss.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+func (s *Switch) check(ctx context.Context, fullMethod string) error {
+	state := s.State()
+	switch state.Mode {
+	case ModeFull:
+	case ModeReadOnly:
+		method := path.Base(fullMethod)
+		for _, prefix := range []string{"List", "Get", "Watch"} {
+			if strings.HasPrefix(method, prefix) {
+				return nil
+			}
+		}
+	default:
+		return nil
+	}
+	_ = grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.Itoa(state.RetryAfterSeconds)))
+	return status.Errorf(codes.Unavailable, "service is in maintenance (%s)", state.Mode)
+}
@@ -0,0 +1,84 @@
+package maintenance
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/audit"
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/i18n"
+	"smart-city-microservices/internal/logging"
+)
+
+// Handler expõe o interruptor do modo de manutenção. As rotas devem ser
+// registradas atrás de auth.RequireRole(auth.RoleAdmin) e isentas do
+// Middleware.
+type Handler struct {
+	sw        *Switch
+	publisher events.Publisher
+}
+
+// NewHandler cria o handler do modo de manutenção.
+func NewHandler(sw *Switch, publisher events.Publisher) *Handler {
+	return &Handler{sw: sw, publisher: publisher}
+}
+
+// SetRequest é o corpo de PUT /admin/maintenance.
+type SetRequest struct {
+	Mode              string `json:"mode" binding:"required"`
+	Reason            string `json:"reason"`
+	RetryAfterSeconds int    `json:"retry_after_seconds"`
+}
+
+// Get retorna o estado atual.
+func (h *Handler) Get(c *gin.Context) {
+	c.JSON(http.StatusOK, h.sw.State())
+}
+
+// Set altera o modo de manutenção de todas as réplicas.
+func (h *Handler) Set(c *gin.Context) {
+	var req SetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		i18n.BindError(c, err)
+		return
+	}
+	if !slices.Contains(Modes, req.Mode) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid mode %q (use off, read_only or full)", req.Mode)})
+		return
+	}
+	if req.RetryAfterSeconds < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "retry_after_seconds must not be negative"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	state := State{Mode: req.Mode, Reason: req.Reason, RetryAfterSeconds: req.RetryAfterSeconds}
+	if p := auth.FromGin(c); p != nil {
+		state.SetBy = p.Subject
+	}
+	previous, err := h.sw.Set(ctx, state)
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).Error("Erro no handler de manutenção")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+	state = h.sw.State()
+	audit.Record(ctx, EventChanged, logrus.Fields{
+		"from":   previous.Mode,
+		"to":     state.Mode,
+		"reason": state.Reason,
+	})
+	h.publisher.Publish(ctx, events.New(events.TopicAdmin, EventChanged, events.MaintenanceChangedV1{
+		From:              previous.Mode,
+		To:                state.Mode,
+		Reason:            state.Reason,
+		SetBy:             state.SetBy,
+		RetryAfterSeconds: state.RetryAfterSeconds,
+	}))
+	c.JSON(http.StatusOK, state)
+}
@@ -0,0 +1,229 @@
+// Package maintenance implementa o modo de manutenção do serviço: um
+// interruptor guardado no Redis, para que todas as réplicas convirjam, que
+// congela as escritas (read_only) ou toda a API (full).
+//
+// Enquanto o modo não é off, as requisições bloqueadas recebem 503 com
+// Retry-After e os ciclos em segundo plano que alteram estado (relógio das
+// simulações, agendamentos e relay do outbox) ficam pausados.
+package maintenance
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/logging"
+)
+
+// stateKey guarda o estado do modo de manutenção compartilhado pelas
+// réplicas.
+const stateKey = "agent-service:maintenance"
+
+// EventChanged é publicado quando o modo muda.
+const EventChanged = "maintenance.changed"
+
+// Modos de manutenção.
+const (
+	ModeOff      = "off"
+	ModeReadOnly = "read_only"
+	ModeFull     = "full"
+)
+
+// Modes são os modos aceitos em State.Mode.
+var Modes = []string{ModeOff, ModeReadOnly, ModeFull}
+
+var modeGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "agent_service",
+	Name:      "maintenance_mode",
+	Help:      "Modo de manutenção visto por esta réplica: 1 no modo ativo, 0 nos demais.",
+}, []string{"mode"})
+
+// State é o estado do modo de manutenção. RetryAfterSeconds é o valor do
+// cabeçalho Retry-After das requisições bloqueadas.
+type State struct {
+	Mode              string     `json:"mode"`
+	Reason            string     `json:"reason,omitempty"`
+	SetBy             string     `json:"set_by,omitempty"`
+	SetAt             *time.Time `json:"set_at,omitempty"`
+	RetryAfterSeconds int        `json:"retry_after_seconds,omitempty"`
+}
+
+// Active indica se o serviço está em manutenção.
+func (s State) Active() bool {
+	return s.Mode != ModeOff
+}
+
+// Config configura o interruptor.
+type Config struct {
+	// RefreshInterval é o intervalo entre as leituras do estado no Redis;
+	// limita o tempo até uma réplica ver a mudança feita em outra.
+	RefreshInterval time.Duration
+	// RetryAfter é o Retry-After padrão quando a mudança não informa um.
+	RetryAfter time.Duration
+}
+
+// Switch é o interruptor do modo de manutenção. O estado vem do Redis e é
+// mantido em memória entre as leituras, de modo que o middleware e as
+// pausas não consultam o Redis a cada uso.
+type Switch struct {
+	client redis.UniversalClient
+	cfg    Config
+
+	mu    sync.RWMutex
+	state State
+}
+
+// New cria o interruptor, inicialmente em off; as leituras do Redis rodam
+// em Run.
+func New(client redis.UniversalClient, cfg Config) *Switch {
+	s := &Switch{client: client, cfg: cfg, state: State{Mode: ModeOff}}
+	s.observe(s.state)
+	return s
+}
+
+// State retorna o estado visto por esta réplica.
+func (s *Switch) State() State {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.state
+}
+
+// Paused indica se os ciclos em segundo plano devem ficar parados; é a
+// função passada a PauseWhen do relógio, dos agendamentos e do relay.
+func (s *Switch) Paused() bool {
+	return s.State().Active()
+}
+
+// Set grava o novo estado no Redis e o aplica nesta réplica; as demais o
+// veem na próxima leitura. Retorna o estado anterior.
+func (s *Switch) Set(ctx context.Context, state State) (State, error) {
+	if state.RetryAfterSeconds <= 0 {
+		state.RetryAfterSeconds = int(s.cfg.RetryAfter.Seconds())
+	}
+	now := time.Now().UTC()
+	state.SetAt = &now
+	data, err := json.Marshal(state)
+	if err != nil {
+		return State{}, err
+	}
+	if err := s.client.Set(ctx, stateKey, data, 0).Err(); err != nil {
+		return State{}, err
+	}
+	return s.apply(state), nil
+}
+
+// Refresh relê o estado do Redis. Sem a chave, o modo é off.
+func (s *Switch) Refresh(ctx context.Context) error {
+	data, err := s.client.Get(ctx, stateKey).Bytes()
+	state := State{Mode: ModeOff}
+	switch {
+	case errors.Is(err, redis.Nil):
+	case err != nil:
+		return err
+	default:
+		if err := json.Unmarshal(data, &state); err != nil {
+			return err
+		}
+	}
+	previous := s.apply(state)
+	if previous.Mode != state.Mode {
+		logging.FromContext(ctx).WithFields(logrus.Fields{
+			"from":   previous.Mode,
+			"to":     state.Mode,
+			"set_by": state.SetBy,
+		}).Warn("Modo de manutenção alterado")
+	}
+	return nil
+}
+
+// Run relê o estado a cada RefreshInterval até ctx ser cancelado. Uma
+// leitura que falha mantém o último estado conhecido.
+func (s *Switch) Run(ctx context.Context) error {
+	ctx = logging.Background(ctx, "maintenance")
+	ticker := time.NewTicker(s.cfg.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := s.Refresh(ctx); err != nil && ctx.Err() == nil {
+				logging.FromContext(ctx).WithError(err).Warn("Falha ao ler o modo de manutenção")
+			}
+		}
+	}
+}
+
+func (s *Switch) apply(state State) State {
+	s.mu.Lock()
+	previous := s.state
+	s.state = state
+	s.mu.Unlock()
+	s.observe(state)
+	return previous
+}
+
+func (s *Switch) observe(state State) {
+	for _, m := range Modes {
+		v := 0.0
+		if m == state.Mode {
+			v = 1
+		}
+		modeGauge.WithLabelValues(m).Set(v)
+	}
+}
+
+// Middleware responde 503 com Retry-After às requisições bloqueadas pelo
+// modo atual: as escritas em read_only e todas em full. As rotas de
+// /health e as de exempt (o próprio interruptor, para que a manutenção
+// possa ser encerrada) sempre passam.
+func (s *Switch) Middleware(exempt ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		state := s.State()
+		if !blocks(state.Mode, c.Request) || exempted(c, exempt) {
+			c.Next()
+			return
+		}
+		c.Header("Retry-After", strconv.Itoa(state.RetryAfterSeconds))
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+			"error":               "service is in maintenance (" + state.Mode + ")",
+			"mode":                state.Mode,
+			"reason":              state.Reason,
+			"retry_after_seconds": state.RetryAfterSeconds,
+		})
+	}
+}
+
+func blocks(mode string, r *http.Request) bool {
+	switch mode {
+	case ModeFull:
+		return true
+	case ModeReadOnly:
+		return r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodOptions
+	}
+	return false
+}
+
+func exempted(c *gin.Context, exempt []string) bool {
+	path := c.Request.URL.Path
+	if path == "/health" || strings.HasPrefix(path, "/health/") {
+		return true
+	}
+	for _, p := range exempt {
+		if c.FullPath() == p {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,338 @@
+// Package mqttbridge liga os sensores de campo, que falam MQTT, aos agentes:
+// telemetria publicada pelos dispositivos atualiza o estado dos agentes e
+// ações executadas em agentes sensores viram comandos no broker.
+package mqttbridge
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/logging"
+)
+
+// Motivos registrados no dead-letter e na métrica correspondente.
+const (
+	ReasonMalformed     = "malformed"
+	ReasonUnknownDevice = "unknown_device"
+	ReasonUpdateFailed  = "update_failed"
+)
+
+var (
+	messages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "mqtt_messages_total",
+		Help:      "Mensagens de telemetria MQTT recebidas por resultado.",
+	}, []string{"result"})
+
+	deadLetters = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "mqtt_dead_letter_total",
+		Help:      "Mensagens MQTT enviadas ao dead-letter por motivo.",
+	}, []string{"reason"})
+
+	commands = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "mqtt_commands_total",
+		Help:      "Comandos publicados para agentes sensores por resultado.",
+	}, []string{"result"})
+
+	connected = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "agent_service",
+		Name:      "mqtt_connected",
+		Help:      "1 quando a ponte MQTT está conectada ao broker.",
+	})
+)
+
+// AgentService é o subconjunto de agent.Service usado pela ponte.
+type AgentService interface {
+	GetAgent(ctx context.Context, id string) (*agent.Agent, error)
+	UpdateAgent(ctx context.Context, id string, req agent.UpdateAgentRequest) (*agent.Agent, error)
+}
+
+// Config configura a ponte.
+type Config struct {
+	Brokers        []string
+	ClientID       string
+	Username       string
+	Password       string
+	QoS            byte
+	TelemetryTopic string
+	// SharedGroup, quando definido, assina via $share/<grupo>/ para que as
+	// réplicas dividam a telemetria em vez de processá-la em duplicidade.
+	SharedGroup string
+	// CommandTopic contém {agent_id}, substituído pelo id do agente.
+	CommandTopic     string
+	SensorAgentTypes []string
+	Workers          int
+	CleanSession     bool
+	KeepAlive        time.Duration
+	ConnectTimeout   time.Duration
+
+	AutoReconnect        bool
+	ConnectRetryInterval time.Duration
+	MaxReconnectInterval time.Duration
+
+	DeadLetterStream string
+	DeadLetterMaxLen int64
+
+	TLSConfig *tls.Config
+}
+
+// Telemetry é o payload esperado em TelemetryTopic. Ao menos um campo deve
+// estar presente; state é mesclado ao estado atual do agente.
+type Telemetry struct {
+	Timestamp *time.Time             `json:"timestamp"`
+	Position  *agent.Position        `json:"position"`
+	Status    *string                `json:"status"`
+	State     map[string]interface{} `json:"state"`
+}
+
+// Command é o payload publicado em CommandTopic.
+type Command struct {
+	Action    string                 `json:"action"`
+	Params    map[string]interface{} `json:"params,omitempty"`
+	RequestID string                 `json:"request_id,omitempty"`
+	IssuedAt  time.Time              `json:"issued_at"`
+}
+
+type message struct {
+	topic    string
+	deviceID string
+	payload  []byte
+}
+
+// Bridge mantém a conexão com o broker e processa a telemetria.
+type Bridge struct {
+	cfg       Config
+	agents    AgentService
+	registry  *Registry
+	redis     redis.UniversalClient
+	client    mqtt.Client
+	sensors   map[string]bool
+	devicePos int
+
+	mu      sync.RWMutex
+	stopped bool
+	queues  []chan message
+	wg      sync.WaitGroup
+}
+
+// New cria a ponte. Start conecta ao broker.
+func New(cfg Config, agents AgentService, registry *Registry, redisClient redis.UniversalClient) (*Bridge, error) {
+	devicePos := -1
+	for i, s := range strings.Split(cfg.TelemetryTopic, "/") {
+		if s == "+" {
+			devicePos = i
+			break
+		}
+	}
+	if devicePos < 0 {
+		return nil, fmt.Errorf("mqtt: telemetry_topic %q sem curinga + para o id do dispositivo", cfg.TelemetryTopic)
+	}
+
+	b := &Bridge{
+		cfg:       cfg,
+		agents:    agents,
+		registry:  registry,
+		redis:     redisClient,
+		sensors:   map[string]bool{},
+		devicePos: devicePos,
+		queues:    make([]chan message, cfg.Workers),
+	}
+	for _, t := range cfg.SensorAgentTypes {
+		b.sensors[t] = true
+	}
+	for i := range b.queues {
+		b.queues[i] = make(chan message, 256)
+	}
+
+	opts := mqtt.NewClientOptions().
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetCleanSession(cfg.CleanSession).
+		SetKeepAlive(cfg.KeepAlive).
+		SetConnectTimeout(cfg.ConnectTimeout).
+		SetAutoReconnect(cfg.AutoReconnect).
+		SetConnectRetry(cfg.AutoReconnect).
+		SetConnectRetryInterval(cfg.ConnectRetryInterval).
+		SetMaxReconnectInterval(cfg.MaxReconnectInterval).
+		SetOnConnectHandler(b.onConnect).
+		SetConnectionLostHandler(b.onConnectionLost)
+	for _, broker := range cfg.Brokers {
+		opts.AddBroker(broker)
+	}
+	if cfg.TLSConfig != nil {
+		opts.SetTLSConfig(cfg.TLSConfig)
+	}
+	b.client = mqtt.NewClient(opts)
+	return b, nil
+}
+
+// Start inicia os workers e conecta ao broker. Com reconexão habilitada, um
+// broker indisponível não impede o startup: a conexão segue sendo tentada
+// em segundo plano.
+func (b *Bridge) Start() error {
+	for _, q := range b.queues {
+		b.wg.Add(1)
+		go b.work(q)
+	}
+
+	token := b.client.Connect()
+	if !token.WaitTimeout(b.cfg.ConnectTimeout) {
+		if b.cfg.AutoReconnect {
+			logrus.Warn("Broker MQTT indisponível; conexão seguirá sendo tentada")
+			return nil
+		}
+		return errors.New("mqtt: tempo esgotado ao conectar")
+	}
+	return token.Error()
+}
+
+// Stop desconecta do broker e aguarda as mensagens já recebidas.
+func (b *Bridge) Stop(ctx context.Context) error {
+	b.client.Disconnect(250)
+	connected.Set(0)
+
+	b.mu.Lock()
+	b.stopped = true
+	for _, q := range b.queues {
+		close(q)
+	}
+	b.mu.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Inscreve a cada conexão para cobrir reconexões com sessão limpa.
+func (b *Bridge) onConnect(c mqtt.Client) {
+	connected.Set(1)
+	topic := b.cfg.TelemetryTopic
+	if b.cfg.SharedGroup != "" {
+		topic = "$share/" + b.cfg.SharedGroup + "/" + topic
+	}
+	logrus.WithField("topic", topic).Info("Ponte MQTT conectada")
+	token := c.Subscribe(topic, b.cfg.QoS, b.receive)
+	go func() {
+		if token.Wait() && token.Error() != nil {
+			logrus.WithError(token.Error()).Error("Falha ao assinar telemetria MQTT")
+		}
+	}()
+}
+
+func (b *Bridge) onConnectionLost(_ mqtt.Client, err error) {
+	connected.Set(0)
+	logrus.WithError(err).Warn("Conexão MQTT perdida")
+}
+
+// receive roda na goroutine de roteamento do paho, em ordem de chegada; só
+// enfileira a mensagem para o worker do dispositivo, preservando a ordem por
+// dispositivo.
+func (b *Bridge) receive(_ mqtt.Client, m mqtt.Message) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.stopped {
+		return
+	}
+
+	segments := strings.Split(m.Topic(), "/")
+	deviceID := ""
+	if b.devicePos < len(segments) {
+		deviceID = segments[b.devicePos]
+	}
+	h := fnv.New32a()
+	h.Write([]byte(deviceID))
+	b.queues[h.Sum32()%uint32(len(b.queues))] <- message{topic: m.Topic(), deviceID: deviceID, payload: m.Payload()}
+}
+
+func (b *Bridge) work(q <-chan message) {
+	defer b.wg.Done()
+	for m := range q {
+		ctx := logging.Background(context.Background(), "mqtt-bridge")
+		b.handle(ctx, m)
+	}
+}
+
+func (b *Bridge) handle(ctx context.Context, m message) {
+	log := logging.FromContext(ctx).WithFields(logrus.Fields{"topic": m.topic, "device_id": m.deviceID})
+
+	var t Telemetry
+	if err := json.Unmarshal(m.payload, &t); err != nil {
+		b.deadLetter(ctx, m, ReasonMalformed, err)
+		return
+	}
+	if t.Position == nil && t.Status == nil && len(t.State) == 0 {
+		b.deadLetter(ctx, m, ReasonMalformed, errors.New("payload sem position, status ou state"))
+		return
+	}
+
+	agentID, err := b.registry.AgentFor(ctx, m.deviceID)
+	if err != nil {
+		b.deadLetter(ctx, m, ReasonUnknownDevice, err)
+		return
+	}
+
+	state := t.State
+	if t.Timestamp != nil {
+		if state == nil {
+			state = map[string]interface{}{}
+		}
+		state["observed_at"] = t.Timestamp.UTC().Format(time.RFC3339Nano)
+	}
+	if _, err := b.agents.UpdateAgent(ctx, agentID, agent.UpdateAgentRequest{
+		Position: t.Position,
+		Status:   t.Status,
+		State:    state,
+	}); err != nil {
+		log.WithError(err).WithField("agent_id", agentID).Warn("Falha ao aplicar telemetria ao agente")
+		b.deadLetter(ctx, m, ReasonUpdateFailed, err)
+		return
+	}
+	messages.WithLabelValues("applied").Inc()
+}
+
+func (b *Bridge) deadLetter(ctx context.Context, m message, reason string, cause error) {
+	messages.WithLabelValues("rejected").Inc()
+	deadLetters.WithLabelValues(reason).Inc()
+
+	err := b.redis.XAdd(ctx, &redis.XAddArgs{
+		Stream: b.cfg.DeadLetterStream,
+		MaxLen: b.cfg.DeadLetterMaxLen,
+		Approx: true,
+		Values: map[string]interface{}{
+			"topic":       m.topic,
+			"device_id":   m.deviceID,
+			"payload":     m.payload,
+			"reason":      reason,
+			"error":       cause.Error(),
+			"received_at": time.Now().UTC().Format(time.RFC3339Nano),
+		},
+	}).Err()
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).WithField("reason", reason).Error("Falha ao gravar mensagem MQTT no dead-letter")
+	}
+}
@@ -0,0 +1,91 @@
+package mqttbridge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/logging"
+)
+
+// SendCommand publica a ação como comando quando o agente é de um tipo
+// sensor. Retorna false, sem erro, para agentes que não são sensores.
+func (b *Bridge) SendCommand(ctx context.Context, agentID string, req agent.ActionRequest) (bool, error) {
+	a, err := b.agents.GetAgent(ctx, agentID)
+	if err != nil {
+		return false, err
+	}
+	if !b.sensors[a.Type] {
+		return false, nil
+	}
+
+	payload, err := json.Marshal(Command{
+		Action:    req.Action,
+		Params:    req.Params,
+		RequestID: logging.RequestID(ctx),
+		IssuedAt:  time.Now().UTC(),
+	})
+	if err != nil {
+		return true, err
+	}
+	topic := strings.ReplaceAll(b.cfg.CommandTopic, "{agent_id}", agentID)
+	token := b.client.Publish(topic, b.cfg.QoS, false, payload)
+	if !token.WaitTimeout(b.cfg.ConnectTimeout) {
+		commands.WithLabelValues("timeout").Inc()
+		return true, fmt.Errorf("mqtt: tempo esgotado ao publicar em %s", topic)
+	}
+	if err := token.Error(); err != nil {
+		commands.WithLabelValues("error").Inc()
+		return true, err
+	}
+	commands.WithLabelValues("published").Inc()
+	return true, nil
+}
+
+// OnAction é o hook chamado depois de uma ação executada com sucesso (REST
+// ou gRPC). Falhas ao publicar o comando são registradas, não propagadas:
+// a ação já foi aceita pelo serviço.
+func (b *Bridge) OnAction(ctx context.Context, agentID string, req agent.ActionRequest) {
+	sent, err := b.SendCommand(ctx, agentID, req)
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).WithFields(logrus.Fields{
+			"agent_id": agentID,
+			"action":   req.Action,
+		}).Warn("Falha ao publicar comando MQTT para agente sensor")
+		return
+	}
+	if sent {
+		logging.FromContext(ctx).WithFields(logrus.Fields{"agent_id": agentID, "action": req.Action}).Debug("Comando MQTT publicado")
+	}
+}
+
+// ActionMiddleware deve envolver POST /agents/:id/actions: depois que o
+// handler responde 2xx, a ação é publicada como comando se o agente for sensor.
+func (b *Bridge) ActionMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.Next()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		c.Next()
+
+		if status := c.Writer.Status(); status < 200 || status > 299 {
+			return
+		}
+		var req agent.ActionRequest
+		if err := json.Unmarshal(body, &req); err != nil || req.Action == "" {
+			return
+		}
+		b.OnAction(c.Request.Context(), c.Param("id"), req)
+	}
+}
@@ -0,0 +1,121 @@
+package mqttbridge
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/audit"
+	"smart-city-microservices/internal/i18n"
+	"smart-city-microservices/internal/logging"
+)
+
+// devicesKey é o hash Redis device-id → agent-id.
+const devicesKey = "agent-service:mqtt:devices"
+
+// ErrUnknownDevice indica um dispositivo sem agente associado.
+var ErrUnknownDevice = errors.New("device not registered")
+
+// Registry mapeia ids de dispositivos físicos para ids de agentes. Fica no
+// Redis para ser compartilhado entre as réplicas.
+type Registry struct {
+	client redis.UniversalClient
+}
+
+// NewRegistry cria o registro de dispositivos.
+func NewRegistry(client redis.UniversalClient) *Registry {
+	return &Registry{client: client}
+}
+
+// AgentFor retorna o agente associado ao dispositivo.
+func (r *Registry) AgentFor(ctx context.Context, deviceID string) (string, error) {
+	agentID, err := r.client.HGet(ctx, devicesKey, deviceID).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrUnknownDevice
+	}
+	return agentID, err
+}
+
+// Register associa o dispositivo ao agente, substituindo associação anterior.
+func (r *Registry) Register(ctx context.Context, deviceID, agentID string) error {
+	return r.client.HSet(ctx, devicesKey, deviceID, agentID).Err()
+}
+
+// Unregister remove a associação do dispositivo.
+func (r *Registry) Unregister(ctx context.Context, deviceID string) error {
+	n, err := r.client.HDel(ctx, devicesKey, deviceID).Result()
+	if err == nil && n == 0 {
+		return ErrUnknownDevice
+	}
+	return err
+}
+
+// All retorna todas as associações.
+func (r *Registry) All(ctx context.Context) (map[string]string, error) {
+	return r.client.HGetAll(ctx, devicesKey).Result()
+}
+
+// Handler expõe a administração do registro de dispositivos. As rotas devem
+// ser registradas atrás de auth.RequireRole(auth.RoleAdmin).
+type Handler struct {
+	registry *Registry
+}
+
+// NewHandler cria o handler do registro.
+func NewHandler(registry *Registry) *Handler {
+	return &Handler{registry: registry}
+}
+
+// RegisterDeviceRequest é o corpo de PUT /admin/mqtt/devices/:device_id.
+type RegisterDeviceRequest struct {
+	AgentID string `json:"agent_id" binding:"required"`
+}
+
+// ListDevices retorna o mapa device-id → agent-id.
+func (h *Handler) ListDevices(c *gin.Context) {
+	devices, err := h.registry.All(c.Request.Context())
+	if err != nil {
+		logging.FromContext(c.Request.Context()).WithError(err).Error("Falha ao listar dispositivos MQTT")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list devices"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"devices": devices})
+}
+
+// RegisterDevice associa um dispositivo a um agente.
+func (h *Handler) RegisterDevice(c *gin.Context) {
+	var req RegisterDeviceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		i18n.BindError(c, err)
+		return
+	}
+	deviceID := c.Param("device_id")
+	if err := h.registry.Register(c.Request.Context(), deviceID, req.AgentID); err != nil {
+		logging.FromContext(c.Request.Context()).WithError(err).Error("Falha ao registrar dispositivo MQTT")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to register device"})
+		return
+	}
+	audit.Record(c.Request.Context(), "mqtt.device_registered", logrus.Fields{"device_id": deviceID, "agent_id": req.AgentID})
+	c.JSON(http.StatusOK, gin.H{"device_id": deviceID, "agent_id": req.AgentID})
+}
+
+// UnregisterDevice remove a associação de um dispositivo.
+func (h *Handler) UnregisterDevice(c *gin.Context) {
+	deviceID := c.Param("device_id")
+	err := h.registry.Unregister(c.Request.Context(), deviceID)
+	switch {
+	case errors.Is(err, ErrUnknownDevice):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		logging.FromContext(c.Request.Context()).WithError(err).Error("Falha ao remover dispositivo MQTT")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to unregister device"})
+		return
+	}
+	audit.Record(c.Request.Context(), "mqtt.device_unregistered", logrus.Fields{"device_id": deviceID})
+	c.Status(http.StatusNoContent)
+}
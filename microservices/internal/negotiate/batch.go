@@ -0,0 +1,161 @@
+package negotiate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/proto"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/grpcapi"
+	"smart-city-microservices/internal/i18n"
+)
+
+// lookupConcurrency limita as buscas simultâneas quando o serviço de
+// agentes não busca vários ids numa consulta só.
+const lookupConcurrency = 16
+
+// BatchGetter busca vários agentes numa consulta (WHERE id = ANY($1)),
+// sem ordem garantida e omitindo os que não existem. Quando o serviço de
+// agentes o implementa, a busca em lote o usa.
+type BatchGetter interface {
+	GetAgents(ctx context.Context, ids []string) ([]agent.Agent, error)
+}
+
+// batchRequest é o corpo de POST /agents/batch-get.
+type batchRequest struct {
+	IDs []string `json:"ids" binding:"required"`
+}
+
+// batchResult é a resposta da busca em lote em JSON e MessagePack: os
+// agentes na ordem pedida e os ids que não existem.
+type batchResult struct {
+	Data     []agent.Agent `json:"data"`
+	NotFound []string      `json:"not_found"`
+}
+
+// GetAgentsByID responde GET /agents?ids=id1,id2,... em qualquer formato;
+// sem ids segue para a listagem.
+func (h *Handler) GetAgentsByID(c *gin.Context) {
+	list := c.Query("ids")
+	if list == "" {
+		c.Next()
+		return
+	}
+	c.Abort()
+	h.batchGet(c, strings.Split(list, ","))
+}
+
+// BatchGet responde POST /agents/batch-get, a mesma busca de
+// GetAgentsByID com os ids no corpo, para listas longas demais para a URL.
+func (h *Handler) BatchGet(c *gin.Context) {
+	var req batchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		i18n.BindError(c, err)
+		return
+	}
+	h.batchGet(c, req.IDs)
+}
+
+// batchGet busca os agentes de ids e responde na ordem pedida. Ids repetidos
+// saem uma vez, na primeira posição. Em protobuf a resposta é um
+// ListAgentsResponse; os ids não encontrados são os que faltam nele.
+func (h *Handler) batchGet(c *gin.Context, ids []string) {
+	ids, err := h.batchIDs(ids)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	found, err := h.getAgents(c.Request.Context(), ids)
+	if err != nil {
+		h.serviceError(c, err)
+		return
+	}
+	byID := make(map[string]*agent.Agent, len(found))
+	for i := range found {
+		byID[found[i].ID] = &found[i]
+	}
+	body := batchResult{Data: make([]agent.Agent, 0, len(found)), NotFound: []string{}}
+	for _, id := range ids {
+		if a, ok := byID[id]; ok {
+			body.Data = append(body.Data, *a)
+		} else {
+			body.NotFound = append(body.NotFound, id)
+		}
+	}
+	h.render(c, Format(c), body, func() (proto.Message, error) {
+		return grpcapi.ToListAgentsResponse(body.Data, len(body.Data), 1, len(body.Data))
+	})
+}
+
+// batchIDs normaliza os ids pedidos: sem espaços e repetições, em ordem, e
+// no máximo cfg.BatchGetMax.
+func (h *Handler) batchIDs(ids []string) ([]string, error) {
+	out := make([]string, 0, len(ids))
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		id = strings.ToLower(strings.TrimSpace(id))
+		if id == "" || seen[id] {
+			continue
+		}
+		if _, err := uuid.Parse(id); err != nil {
+			return nil, fmt.Errorf("invalid id: %s", id)
+		}
+		seen[id] = true
+		out = append(out, id)
+	}
+	if len(out) == 0 {
+		return nil, errors.New("ids must not be empty")
+	}
+	if len(out) > h.cfg.BatchGetMax {
+		return nil, fmt.Errorf("at most %d ids per request, got %d", h.cfg.BatchGetMax, len(out))
+	}
+	return out, nil
+}
+
+// getAgents busca os agentes existentes de ids, numa consulta se o serviço
+// implementa BatchGetter e, senão, com até lookupConcurrency buscas
+// individuais simultâneas.
+func (h *Handler) getAgents(ctx context.Context, ids []string) ([]agent.Agent, error) {
+	if b, ok := h.agents.(BatchGetter); ok {
+		return b.GetAgents(ctx, ids)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		mu    sync.Mutex
+		found []agent.Agent
+		first error
+		wg    sync.WaitGroup
+	)
+	sem := make(chan struct{}, lookupConcurrency)
+	for _, id := range ids {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(id string) {
+			defer func() { <-sem; wg.Done() }()
+			a, err := h.agents.GetAgent(ctx, id)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case errors.Is(err, agent.ErrNotFound) || (err == nil && a == nil):
+			case err != nil:
+				if first == nil {
+					first = err
+					cancel()
+				}
+			default:
+				found = append(found, *a)
+			}
+		}(id)
+	}
+	wg.Wait()
+	return found, first
+}
@@ -0,0 +1,212 @@
+package negotiate
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/protobuf/proto"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/grpcapi"
+	"smart-city-microservices/internal/logging"
+	agentv1 "smart-city-microservices/proto/agent/v1"
+)
+
+// Limites de paginação, os mesmos da API REST.
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// Limites da listagem de eventos, os mesmos de Query.events no GraphQL.
+const (
+	defaultEventsLimit = 50
+	maxEventsLimit     = 500
+)
+
+// AgentService é o subconjunto de agent.Service usado aqui.
+type AgentService interface {
+	ListAgents(ctx context.Context, f agent.Filter) ([]agent.Agent, int, error)
+	GetAgent(ctx context.Context, id string) (*agent.Agent, error)
+}
+
+// EventSource fornece os eventos recentes do barramento.
+type EventSource interface {
+	ListFunc(match func(events.Event) bool, limit int) []events.Event
+}
+
+// Handler atende as rotas com representação binária. Em GET /agents e
+// GET /agents/:id fica à frente do handler JSON de agentes e só responde
+// quando o cliente pede protobuf ou MessagePack; a busca em lote
+// (GetAgentsByID e BatchGet) ele responde em todos os formatos.
+type Handler struct {
+	agents AgentService
+	events EventSource
+	cfg    Config
+}
+
+// Config limita a busca em lote.
+type Config struct {
+	// BatchGetMax é o máximo de ids distintos por busca em lote.
+	BatchGetMax int
+}
+
+// NewHandler cria o handler de negociação de conteúdo.
+func NewHandler(agents AgentService, events EventSource, cfg Config) *Handler {
+	return &Handler{agents: agents, events: events, cfg: cfg}
+}
+
+// agentList é o corpo da listagem de agentes em JSON e MessagePack.
+type agentList struct {
+	Data     []agent.Agent `json:"data"`
+	Total    int           `json:"total"`
+	Page     int           `json:"page"`
+	PageSize int           `json:"page_size"`
+}
+
+// ListAgents responde GET /agents em protobuf (ListAgentsResponse, a mesma
+// mensagem do gRPC) ou MessagePack; em JSON segue para o próximo handler.
+func (h *Handler) ListAgents(c *gin.Context) {
+	format := Format(c)
+	if format == MediaTypeJSON {
+		c.Next()
+		return
+	}
+	c.Abort()
+
+	f, err := listFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	agents, total, err := h.agents.ListAgents(c.Request.Context(), f)
+	if err != nil {
+		h.serviceError(c, err)
+		return
+	}
+	if agents == nil {
+		agents = []agent.Agent{}
+	}
+	body := agentList{Data: agents, Total: total, Page: f.Page, PageSize: f.PageSize}
+	h.render(c, format, body, func() (proto.Message, error) {
+		return grpcapi.ToListAgentsResponse(agents, total, f.Page, f.PageSize)
+	})
+}
+
+// GetAgent responde GET /agents/:id em protobuf (Agent) ou MessagePack; em
+// JSON segue para o próximo handler.
+func (h *Handler) GetAgent(c *gin.Context) {
+	format := Format(c)
+	if format == MediaTypeJSON {
+		c.Next()
+		return
+	}
+	c.Abort()
+
+	a, err := h.agents.GetAgent(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.serviceError(c, err)
+		return
+	}
+	h.render(c, format, a, func() (proto.Message, error) {
+		return grpcapi.ToAgent(a)
+	})
+}
+
+// ListEvents responde GET /events com os eventos recentes do barramento, do
+// mais novo ao mais antigo, filtrados por ?topic= e ?type=. O histórico é o
+// desta instância. Eventos do tópico admin só aparecem para administradores.
+func (h *Handler) ListEvents(c *gin.Context) {
+	topic, eventType := c.Query("topic"), c.Query("type")
+	admin := auth.FromGin(c).HasRole(auth.RoleAdmin)
+	if topic == events.TopicAdmin && !admin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "role " + auth.RoleAdmin + " required"})
+		return
+	}
+	limit := defaultEventsLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit: " + v})
+			return
+		}
+		limit = min(n, maxEventsLimit)
+	}
+
+	list := h.events.ListFunc(func(e events.Event) bool {
+		return (topic == "" || e.Topic == topic) && (eventType == "" || e.Type == eventType) &&
+			(admin || e.Topic != events.TopicAdmin)
+	}, limit)
+	h.render(c, Format(c), gin.H{"data": list}, func() (proto.Message, error) {
+		resp := &agentv1.ListAgentEventsResponse{Events: make([]*agentv1.AgentEvent, 0, len(list))}
+		for _, e := range list {
+			msg, err := grpcapi.ToAgentEvent(e)
+			if err != nil {
+				return nil, err
+			}
+			resp.Events = append(resp.Events, msg)
+		}
+		return resp, nil
+	})
+}
+
+func (h *Handler) render(c *gin.Context, format string, v interface{}, toProto func() (proto.Message, error)) {
+	if err := render(c, format, v, toProto); err != nil {
+		logging.FromContext(c.Request.Context()).WithError(err).WithField("format", format).Error("Erro ao serializar resposta")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+	}
+}
+
+func (h *Handler) serviceError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, agent.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, agent.ErrValidation):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		logging.FromContext(c.Request.Context()).WithError(err).Error("Erro no handler de negociação de conteúdo")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+	}
+}
+
+// listFilter lê os filtros e a paginação da listagem de agentes.
+func listFilter(c *gin.Context) (agent.Filter, error) {
+	f := agent.Filter{
+		Type:         c.Query("type"),
+		Status:       c.Query("status"),
+		SimulationID: c.Query("simulation_id"),
+		ProjectID:    c.Query("project_id"),
+		Page:         1,
+		PageSize:     defaultPageSize,
+	}
+	if tags := c.Query("tags"); tags != "" {
+		for _, t := range strings.Split(tags, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				f.Tags = append(f.Tags, t)
+			}
+		}
+	}
+	for _, p := range []struct {
+		name  string
+		dst   *int
+		limit int
+	}{{"page", &f.Page, math.MaxInt32}, {"page_size", &f.PageSize, maxPageSize}} {
+		v := c.Query(p.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return f, errors.New("invalid " + p.name + ": " + v)
+		}
+		*p.dst = min(n, p.limit)
+	}
+	return f, nil
+}
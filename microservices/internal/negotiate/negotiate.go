@@ -0,0 +1,104 @@
+// Package negotiate serializa as respostas REST mais volumosas (listagem e
+// busca de agentes, eventos recentes) em protobuf ou MessagePack quando o
+// cliente pede via Accept. Sem pedido explícito a resposta continua em JSON.
+package negotiate
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ugorji/go/codec"
+	"google.golang.org/protobuf/proto"
+)
+
+// Tipos de conteúdo suportados.
+const (
+	MediaTypeJSON     = "application/json"
+	MediaTypeProtobuf = "application/x-protobuf"
+	MediaTypeMsgpack  = "application/msgpack"
+)
+
+// MediaTypes são os tipos de conteúdo suportados, na ordem de preferência.
+var MediaTypes = []string{MediaTypeJSON, MediaTypeProtobuf, MediaTypeMsgpack}
+
+// aliases mapeia os tipos aceitos no Accept para o formato da resposta.
+var aliases = map[string]string{
+	MediaTypeJSON:             MediaTypeJSON,
+	"application/*":           MediaTypeJSON,
+	"*/*":                     MediaTypeJSON,
+	MediaTypeProtobuf:         MediaTypeProtobuf,
+	"application/protobuf":    MediaTypeProtobuf,
+	MediaTypeMsgpack:          MediaTypeMsgpack,
+	"application/x-msgpack":   MediaTypeMsgpack,
+	"application/vnd.msgpack": MediaTypeMsgpack,
+}
+
+// msgpackHandle usa as tags json dos tipos, de modo que os campos e nomes
+// são os mesmos da representação JSON. Datas vão como a extensão timestamp
+// do MessagePack.
+var msgpackHandle = func() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{WriteExt: true}
+	h.TypeInfos = codec.NewTypeInfos([]string{"json"})
+	return h
+}()
+
+// Format escolhe o formato da resposta pelo Accept, pela maior qualidade (q)
+// e, no empate, pela ordem do cabeçalho. Tipos não suportados são
+// ignorados; sem nenhum suportado, o formato é JSON.
+func Format(c *gin.Context) string {
+	best, bestQ := MediaTypeJSON, 0.0
+	for _, part := range strings.Split(c.GetHeader("Accept"), ",") {
+		mediaType, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		format, ok := aliases[strings.ToLower(strings.TrimSpace(mediaType))]
+		if !ok {
+			continue
+		}
+		if q := quality(params); q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+	return best
+}
+
+func quality(params string) float64 {
+	for _, p := range strings.Split(params, ";") {
+		name, value, _ := strings.Cut(strings.TrimSpace(p), "=")
+		if strings.EqualFold(name, "q") {
+			q, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return 0
+			}
+			return q
+		}
+	}
+	return 1
+}
+
+// render escreve v no formato negociado. toProto monta a mensagem protobuf
+// equivalente e só é chamado quando esse é o formato pedido.
+func render(c *gin.Context, format string, v interface{}, toProto func() (proto.Message, error)) error {
+	c.Header("Vary", "Accept")
+	switch format {
+	case MediaTypeProtobuf:
+		msg, err := toProto()
+		if err != nil {
+			return err
+		}
+		b, err := proto.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		c.Data(http.StatusOK, MediaTypeProtobuf, b)
+	case MediaTypeMsgpack:
+		var b []byte
+		if err := codec.NewEncoderBytes(&b, msgpackHandle).Encode(v); err != nil {
+			return err
+		}
+		c.Data(http.StatusOK, MediaTypeMsgpack, b)
+	default:
+		c.JSON(http.StatusOK, v)
+	}
+	return nil
+}
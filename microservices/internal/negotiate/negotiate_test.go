@@ -0,0 +1,308 @@
+package negotiate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ugorji/go/codec"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/grpcapi"
+	agentv1 "smart-city-microservices/proto/agent/v1"
+)
+
+func init() { gin.SetMode(gin.TestMode) }
+
+type fakeAgents struct{ agents []agent.Agent }
+
+func (f *fakeAgents) ListAgents(_ context.Context, filter agent.Filter) ([]agent.Agent, int, error) {
+	start := min((filter.Page-1)*filter.PageSize, len(f.agents))
+	end := min(start+filter.PageSize, len(f.agents))
+	return f.agents[start:end], len(f.agents), nil
+}
+
+func (f *fakeAgents) GetAgent(_ context.Context, id string) (*agent.Agent, error) {
+	for i := range f.agents {
+		if f.agents[i].ID == id {
+			return &f.agents[i], nil
+		}
+	}
+	return nil, agent.ErrNotFound
+}
+
+type fakeEvents []events.Event
+
+func (f fakeEvents) ListFunc(match func(events.Event) bool, limit int) []events.Event {
+	var out []events.Event
+	for _, e := range f {
+		if match(e) && len(out) < limit {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+var created = time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+
+func sampleAgent(i int) agent.Agent {
+	return agent.Agent{
+		ID:           fmt.Sprintf("agent-%05d", i),
+		SimulationID: "sim-1",
+		ProjectID:    "proj-1",
+		Type:         "vehicle",
+		Name:         fmt.Sprintf("Ônibus %d", i),
+		Status:       "active",
+		Position:     agent.Position{Lat: -23.55 + float64(i)/1e4, Lon: -46.63, Heading: 90, Speed: 12.5},
+		Energy:       87.5,
+		State:        map[string]interface{}{"route": "101", "stops": []interface{}{"a", "b"}},
+		Metadata:     map[string]interface{}{"plate": "ABC1D23", "capacity": float64(40)},
+		Tags:         []string{"bus", "zona-sul"},
+		CreatedAt:    created,
+		UpdatedAt:    created.Add(time.Minute),
+	}
+}
+
+func sampleAgents(n int) []agent.Agent {
+	out := make([]agent.Agent, n)
+	for i := range out {
+		out[i] = sampleAgent(i)
+	}
+	return out
+}
+
+func newRouter(h *Handler) *gin.Engine {
+	r := gin.New()
+	jsonFallback := func(c *gin.Context) { c.JSON(http.StatusTeapot, gin.H{"fallback": true}) }
+	r.GET("/agents", h.GetAgentsByID, h.ListAgents, jsonFallback)
+	r.GET("/agents/:id", h.GetAgent, jsonFallback)
+	r.GET("/events", h.ListEvents)
+	return r
+}
+
+func do(t testing.TB, r http.Handler, path, accept string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", MediaTypeJSON},
+		{"*/*", MediaTypeJSON},
+		{"text/html", MediaTypeJSON},
+		{"application/x-protobuf", MediaTypeProtobuf},
+		{"application/protobuf", MediaTypeProtobuf},
+		{"application/msgpack", MediaTypeMsgpack},
+		{"application/vnd.msgpack", MediaTypeMsgpack},
+		{"application/json, application/msgpack", MediaTypeJSON},
+		{"application/json;q=0.5, application/msgpack", MediaTypeMsgpack},
+		{"application/x-protobuf;q=0.9, application/msgpack;q=0.8", MediaTypeProtobuf},
+		{"application/x-protobuf;q=bad, application/json", MediaTypeJSON},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+			c.Request.Header.Set("Accept", tt.accept)
+			if got := Format(c); got != tt.want {
+				t.Errorf("Format(%q) = %s, want %s", tt.accept, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONFallsThrough(t *testing.T) {
+	r := newRouter(NewHandler(&fakeAgents{agents: sampleAgents(3)}, fakeEvents{}, Config{}))
+	for _, path := range []string{"/agents", "/agents/agent-00001"} {
+		if w := do(t, r, path, "application/json"); w.Code != http.StatusTeapot {
+			t.Errorf("GET %s em JSON: status %d, want o handler seguinte", path, w.Code)
+		}
+	}
+}
+
+// jsonMap é a representação JSON de v decodificada num mapa genérico, a
+// base de comparação dos formatos binários.
+func jsonMap(t *testing.T, v interface{}) map[string]interface{} {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// protoMap converte a mensagem para JSON com os nomes de campo do .proto,
+// que são os mesmos da API REST.
+func protoMap(t *testing.T, m proto.Message) map[string]interface{} {
+	t.Helper()
+	b, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out map[string]interface{}
+	if err := json.Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// msgpackMap decodifica o corpo MessagePack num mapa e o passa por JSON,
+// para as datas e os números terem a mesma forma da resposta JSON.
+func msgpackMap(t *testing.T, body []byte, into interface{}) map[string]interface{} {
+	t.Helper()
+	if err := codec.NewDecoderBytes(body, msgpackHandle).Decode(into); err != nil {
+		t.Fatal(err)
+	}
+	return jsonMap(t, into)
+}
+
+func TestGetAgentParity(t *testing.T) {
+	agents := sampleAgents(3)
+	r := newRouter(NewHandler(&fakeAgents{agents: agents}, fakeEvents{}, Config{}))
+	want := jsonMap(t, agents[1])
+
+	t.Run("msgpack", func(t *testing.T) {
+		w := do(t, r, "/agents/agent-00001", MediaTypeMsgpack)
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != MediaTypeMsgpack {
+			t.Fatalf("status %d, content-type %q", w.Code, w.Header().Get("Content-Type"))
+		}
+		if got := msgpackMap(t, w.Body.Bytes(), new(agent.Agent)); !reflect.DeepEqual(got, want) {
+			t.Errorf("msgpack difere do JSON:\n got %v\nwant %v", got, want)
+		}
+	})
+	t.Run("protobuf", func(t *testing.T) {
+		w := do(t, r, "/agents/agent-00001", MediaTypeProtobuf)
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != MediaTypeProtobuf {
+			t.Fatalf("status %d, content-type %q", w.Code, w.Header().Get("Content-Type"))
+		}
+		var msg agentv1.Agent
+		if err := proto.Unmarshal(w.Body.Bytes(), &msg); err != nil {
+			t.Fatal(err)
+		}
+		if got := protoMap(t, &msg); !reflect.DeepEqual(got, want) {
+			t.Errorf("protobuf difere do JSON:\n got %v\nwant %v", got, want)
+		}
+	})
+	t.Run("not found", func(t *testing.T) {
+		if w := do(t, r, "/agents/missing", MediaTypeProtobuf); w.Code != http.StatusNotFound {
+			t.Errorf("status %d, want 404", w.Code)
+		}
+	})
+}
+
+func TestListAgentsParity(t *testing.T) {
+	agents := sampleAgents(25)
+	r := newRouter(NewHandler(&fakeAgents{agents: agents}, fakeEvents{}, Config{}))
+	want := jsonMap(t, agentList{Data: agents[10:20], Total: 25, Page: 2, PageSize: 10})
+	path := "/agents?page=2&page_size=10"
+
+	t.Run("msgpack", func(t *testing.T) {
+		w := do(t, r, path, MediaTypeMsgpack)
+		if got := msgpackMap(t, w.Body.Bytes(), new(agentList)); !reflect.DeepEqual(got, want) {
+			t.Errorf("msgpack difere do JSON:\n got %v\nwant %v", got, want)
+		}
+	})
+	t.Run("protobuf", func(t *testing.T) {
+		w := do(t, r, path, MediaTypeProtobuf)
+		var msg agentv1.ListAgentsResponse
+		if err := proto.Unmarshal(w.Body.Bytes(), &msg); err != nil {
+			t.Fatal(err)
+		}
+		got := protoMap(t, &msg)
+		// A lista se chama agents na mensagem do gRPC e data no JSON.
+		got["data"] = got["agents"]
+		delete(got, "agents")
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("protobuf difere do JSON:\n got %v\nwant %v", got, want)
+		}
+	})
+	t.Run("invalid page_size", func(t *testing.T) {
+		if w := do(t, r, "/agents?page_size=0", MediaTypeMsgpack); w.Code != http.StatusBadRequest {
+			t.Errorf("status %d, want 400", w.Code)
+		}
+	})
+}
+
+func TestListEventsParity(t *testing.T) {
+	evs := fakeEvents{
+		{Type: "agent.updated", Topic: events.TopicAgents, Data: map[string]interface{}{"id": "agent-1", "energy": 42.0}, OccurredAt: created},
+		{Type: "simulation.started", Topic: "simulations", Data: map[string]interface{}{"id": "sim-1"}, OccurredAt: created.Add(time.Second)},
+		{Type: "config.reloaded", Topic: events.TopicAdmin, Data: map[string]interface{}{}, OccurredAt: created},
+	}
+	r := newRouter(NewHandler(&fakeAgents{}, evs, Config{}))
+	want := jsonMap(t, gin.H{"data": evs[:2]})
+
+	w := do(t, r, "/events", "")
+	if got := jsonMap(t, json.RawMessage(w.Body.Bytes())); !reflect.DeepEqual(got, want) {
+		t.Errorf("JSON sem admin:\n got %v\nwant %v", got, want)
+	}
+	w = do(t, r, "/events", MediaTypeMsgpack)
+	var decoded struct {
+		Data []events.Event `json:"data"`
+	}
+	if got := msgpackMap(t, w.Body.Bytes(), &decoded); !reflect.DeepEqual(got, want) {
+		t.Errorf("msgpack difere do JSON:\n got %v\nwant %v", got, want)
+	}
+	w = do(t, r, "/events", MediaTypeProtobuf)
+	var msg agentv1.ListAgentEventsResponse
+	if err := proto.Unmarshal(w.Body.Bytes(), &msg); err != nil {
+		t.Fatal(err)
+	}
+	got := protoMap(t, &msg)
+	got["data"] = got["events"]
+	delete(got, "events")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("protobuf difere do JSON:\n got %v\nwant %v", got, want)
+	}
+
+	if w := do(t, r, "/events?topic="+events.TopicAdmin, ""); w.Code != http.StatusForbidden {
+		t.Errorf("tópico admin sem o papel: status %d, want 403", w.Code)
+	}
+}
+
+// BenchmarkEncodeAgentPage serializa uma página de 10 mil agentes em cada
+// formato, como GET /agents com page_size sem o limite da API.
+func BenchmarkEncodeAgentPage(b *testing.B) {
+	agents := sampleAgents(10000)
+	body := agentList{Data: agents, Total: len(agents), Page: 1, PageSize: len(agents)}
+	toProto := func() (proto.Message, error) {
+		return grpcapi.ToListAgentsResponse(agents, len(agents), 1, len(agents))
+	}
+	for _, format := range MediaTypes {
+		b.Run(format, func(b *testing.B) {
+			b.ReportAllocs()
+			var size int
+			for i := 0; i < b.N; i++ {
+				w := httptest.NewRecorder()
+				c, _ := gin.CreateTestContext(w)
+				if err := render(c, format, body, toProto); err != nil {
+					b.Fatal(err)
+				}
+				size = w.Body.Len()
+			}
+			b.ReportMetric(float64(size), "bytes/page")
+		})
+	}
+}
@@ -0,0 +1,273 @@
+package notification
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/logging"
+	"smart-city-microservices/internal/supervisor"
+)
+
+// cacheTTL limita por quanto tempo as configurações são reaproveitadas.
+const cacheTTL = 30 * time.Second
+
+var (
+	deliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "notification_deliveries_total",
+		Help:      "Tentativas de envio de notificações por canal e resultado (success, retry, failure).",
+	}, []string{"channel", "result"})
+
+	droppedEvents = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "notification_events_dropped_total",
+		Help:      "Eventos descartados porque a fila do dispatcher de notificações estava cheia.",
+	})
+
+	suppressed = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "notification_suppressed_total",
+		Help:      "Notificações não enviadas por cair no horário de silêncio do projeto.",
+	})
+)
+
+// Config controla o envio das notificações.
+type Config struct {
+	Workers        int
+	QueueSize      int
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Timeout        time.Duration
+}
+
+type job struct {
+	channel Channel
+	message Message
+	attempt int
+}
+
+// Dispatcher consome os eventos de simulações e alertas do barramento e
+// envia as notificações dos projetos interessados, com novas tentativas
+// em backoff exponencial.
+type Dispatcher struct {
+	repo      *Repository
+	cfg       Config
+	notifiers map[string]Notifier
+
+	events chan events.Event
+	jobs   chan job
+	// done fecha quando Run termina de vez, para as novas tentativas
+	// agendadas.
+	done chan struct{}
+	stop sync.Once
+
+	mu       sync.Mutex
+	cache    map[string]*Settings
+	cachedAt time.Time
+}
+
+// NewDispatcher cria o dispatcher com os notificadores por tipo de canal.
+// O envio roda em Run.
+func NewDispatcher(repo *Repository, cfg Config, notifiers map[string]Notifier) *Dispatcher {
+	return &Dispatcher{
+		repo:      repo,
+		cfg:       cfg,
+		notifiers: notifiers,
+		events:    make(chan events.Event, cfg.QueueSize),
+		jobs:      make(chan job, cfg.QueueSize),
+		done:      make(chan struct{}),
+	}
+}
+
+// Handle recebe eventos do barramento sem bloquear o Publish; com a fila
+// cheia o evento é descartado e contado.
+func (d *Dispatcher) Handle(_ context.Context, e events.Event) {
+	if e.Topic != events.TopicSimulations && e.Topic != events.TopicAlerts {
+		return
+	}
+	// As mensagens entre agentes ficam no log da simulação; no volume de
+	// uma simulação, encheriam a fila das notificações.
+	if e.Type == "simulation.message_delivered" {
+		return
+	}
+	select {
+	case d.events <- e:
+	default:
+		droppedEvents.Inc()
+	}
+}
+
+// Run roda o fan-out de eventos e os workers de envio até ctx ser
+// cancelado. Eventos ainda na fila e novas tentativas agendadas são
+// descartados.
+func (d *Dispatcher) Run(ctx context.Context) error {
+	g, _ := supervisor.NewGroup(ctx)
+	g.Go(d.fanOut)
+	for i := 0; i < d.cfg.Workers; i++ {
+		g.Go(d.work)
+	}
+	err := g.Wait()
+	if ctx.Err() != nil {
+		d.stop.Do(func() { close(d.done) })
+	}
+	return err
+}
+
+// Invalidate descarta as configurações em cache após alterações.
+func (d *Dispatcher) Invalidate() {
+	d.mu.Lock()
+	d.cache = nil
+	d.mu.Unlock()
+}
+
+// Available indica se há notificador para o tipo de canal.
+func (d *Dispatcher) Available(channelType string) bool {
+	_, ok := d.notifiers[channelType]
+	return ok
+}
+
+// Validate confere o destino com o notificador do tipo de canal.
+func (d *Dispatcher) Validate(ch Channel) error {
+	n, ok := d.notifiers[ch.Type]
+	if !ok {
+		return fmt.Errorf("channel type %q is not available", ch.Type)
+	}
+	return n.Validate(ch)
+}
+
+// Send envia a mensagem uma única vez, sem fila nem novas tentativas. É
+// usado pelo teste de configuração.
+func (d *Dispatcher) Send(ctx context.Context, ch Channel, m Message) error {
+	n, ok := d.notifiers[ch.Type]
+	if !ok {
+		return fmt.Errorf("channel type %q is not available", ch.Type)
+	}
+	ctx, cancel := context.WithTimeout(ctx, d.cfg.Timeout)
+	defer cancel()
+	return n.Notify(ctx, ch, m)
+}
+
+func (d *Dispatcher) fanOut(ctx context.Context) error {
+	work := logging.Background(context.WithoutCancel(ctx), "notification-dispatcher")
+	log := logging.FromContext(work)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e := <-d.events:
+			project := e.ProjectID()
+			if project == "" {
+				continue
+			}
+			all, err := d.settings(work)
+			if err != nil {
+				log.WithError(err).Error("Falha ao carregar configurações de notificação; evento descartado")
+				continue
+			}
+			d.dispatch(ctx.Done(), e, all[project])
+		}
+	}
+}
+
+// dispatch enfileira os envios do evento; desiste se stop fechar.
+func (d *Dispatcher) dispatch(stop <-chan struct{}, e events.Event, s *Settings) {
+	if s == nil || !s.Enabled || !s.Accepts(e.Type) {
+		return
+	}
+	if s.QuietHours.Quiet(e.Type, e.OccurredAt) {
+		suppressed.Inc()
+		return
+	}
+	m := NewMessage(e)
+	m.ProjectID = s.ProjectID
+	for _, ch := range s.Channels {
+		select {
+		case d.jobs <- job{channel: ch, message: m, attempt: 1}:
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) settings(ctx context.Context) (map[string]*Settings, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.cache != nil && time.Since(d.cachedAt) < cacheTTL {
+		return d.cache, nil
+	}
+	list, err := d.repo.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byProject := make(map[string]*Settings, len(list))
+	for _, s := range list {
+		byProject[s.ProjectID] = s
+	}
+	d.cache, d.cachedAt = byProject, time.Now()
+	return byProject, nil
+}
+
+func (d *Dispatcher) work(ctx context.Context) error {
+	work := logging.Background(context.WithoutCancel(ctx), "notification-worker")
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case j := <-d.jobs:
+			d.deliver(work, j)
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, j job) {
+	log := logging.FromContext(ctx).WithFields(logrus.Fields{
+		"project_id": j.message.ProjectID,
+		"channel":    j.channel.Type,
+		"event_type": j.message.EventType,
+		"attempt":    j.attempt,
+	})
+
+	err := d.Send(ctx, j.channel, j.message)
+	switch {
+	case err == nil:
+		deliveries.WithLabelValues(j.channel.Type, "success").Inc()
+	case j.attempt < d.cfg.MaxAttempts:
+		deliveries.WithLabelValues(j.channel.Type, "retry").Inc()
+		delay := d.backoff(j.attempt)
+		log.WithError(err).WithField("retry_in", delay.String()).Warn("Envio de notificação falhou; nova tentativa agendada")
+		j.attempt++
+		time.AfterFunc(delay, func() {
+			select {
+			case d.jobs <- j:
+			case <-d.done:
+			}
+		})
+	default:
+		deliveries.WithLabelValues(j.channel.Type, "failure").Inc()
+		log.WithError(err).Error("Envio de notificação falhou; tentativas esgotadas")
+	}
+}
+
+// backoff retorna a espera antes da tentativa seguinte: InitialBackoff
+// dobrando a cada tentativa, limitado a MaxBackoff, com jitter de até 20%.
+func (d *Dispatcher) backoff(attempt int) time.Duration {
+	delay := d.cfg.InitialBackoff
+	for i := 1; i < attempt && delay < d.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+	delay = min(delay, d.cfg.MaxBackoff)
+	if jitter := int64(delay) / 5; jitter > 0 {
+		delay += time.Duration(rand.Int63n(jitter))
+	}
+	return delay
+}
@@ -0,0 +1,160 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Modos de TLS do SMTP.
+const (
+	SMTPStartTLS = "starttls"
+	SMTPTLS      = "tls"
+	SMTPNone     = "none"
+)
+
+// maxRecipients limita os destinatários de um canal de e-mail.
+const maxRecipients = 20
+
+// SMTPConfig configura o servidor de envio de e-mails.
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	// TLS é SMTPStartTLS (porta 587), SMTPTLS (465) ou SMTPNone, só para
+	// servidores locais de teste.
+	TLS       string
+	TLSConfig *tls.Config
+	Timeout   time.Duration
+}
+
+// Email envia as mensagens por SMTP, em texto simples.
+type Email struct {
+	cfg  SMTPConfig
+	from *mail.Address
+}
+
+// NewEmail cria o notificador de e-mail. From aceita "Nome <endereço>".
+func NewEmail(cfg SMTPConfig) (*Email, error) {
+	from, err := mail.ParseAddress(cfg.From)
+	if err != nil {
+		return nil, fmt.Errorf("notification: remetente %q: %w", cfg.From, err)
+	}
+	if cfg.TLSConfig == nil {
+		cfg.TLSConfig = &tls.Config{}
+	}
+	cfg.TLSConfig = cfg.TLSConfig.Clone()
+	if cfg.TLSConfig.ServerName == "" {
+		cfg.TLSConfig.ServerName = cfg.Host
+	}
+	return &Email{cfg: cfg, from: from}, nil
+}
+
+func (e *Email) Validate(ch Channel) error {
+	if len(ch.EmailTo) == 0 {
+		return errors.New("email_to must have at least one address")
+	}
+	if len(ch.EmailTo) > maxRecipients {
+		return fmt.Errorf("email_to accepts at most %d addresses", maxRecipients)
+	}
+	for _, to := range ch.EmailTo {
+		if a, err := mail.ParseAddress(to); err != nil || a.Name != "" {
+			return fmt.Errorf("invalid email address %q", to)
+		}
+	}
+	return nil
+}
+
+func (e *Email) Notify(ctx context.Context, ch Channel, m Message) error {
+	addr := net.JoinHostPort(e.cfg.Host, strconv.Itoa(e.cfg.Port))
+	dialer := &net.Dialer{Timeout: e.cfg.Timeout}
+	var conn net.Conn
+	var err error
+	if e.cfg.TLS == SMTPTLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: e.cfg.TLSConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(e.cfg.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	c, err := smtp.NewClient(conn, e.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if e.cfg.TLS == SMTPStartTLS {
+		if err := c.StartTLS(e.cfg.TLSConfig); err != nil {
+			return fmt.Errorf("smtp: starttls: %w", err)
+		}
+	}
+	if e.cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", e.cfg.Username, e.cfg.Password, e.cfg.Host)); err != nil {
+			return fmt.Errorf("smtp: auth: %w", err)
+		}
+	}
+	if err := c.Mail(e.from.Address); err != nil {
+		return err
+	}
+	for _, to := range ch.EmailTo {
+		if err := c.Rcpt(to); err != nil {
+			return fmt.Errorf("smtp: rcpt %s: %w", to, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(e.compose(ch.EmailTo, m)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func (e *Email) compose(to []string, m Message) []byte {
+	var b bytes.Buffer
+	id := make([]byte, 16)
+	rand.Read(id)
+	domain := e.from.Address[strings.LastIndex(e.from.Address, "@")+1:]
+
+	fmt.Fprintf(&b, "From: %s\r\n", e.from.String())
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Title))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), domain)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	fmt.Fprintf(&b, "X-Smart-City-Event: %s\r\n", m.EventType)
+	b.WriteString("\r\n")
+	for _, line := range strings.Split(m.Text, "\n") {
+		// O escape de linhas começando com "." fica com o net/smtp.
+		b.WriteString(strings.TrimRight(line, "\r"))
+		b.WriteString("\r\n")
+	}
+	return b.Bytes()
+}
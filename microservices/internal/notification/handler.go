@@ -0,0 +1,253 @@
+package notification
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/audit"
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/i18n"
+	"smart-city-microservices/internal/logging"
+)
+
+// maxChannels limita os destinos por projeto.
+const maxChannels = 10
+
+// Handler expõe as configurações de notificação e o envio de teste.
+type Handler struct {
+	repo       *Repository
+	dispatcher *Dispatcher
+}
+
+// NewHandler cria o handler de notificações.
+func NewHandler(repo *Repository, dispatcher *Dispatcher) *Handler {
+	return &Handler{repo: repo, dispatcher: dispatcher}
+}
+
+// SettingsRequest é o corpo de PUT /notifications/settings/:project_id,
+// que substitui as configurações inteiras. Uma slack_webhook_url igual à
+// versão mascarada devolvida pelo GET mantém a URL gravada.
+type SettingsRequest struct {
+	Enabled    *bool       `json:"enabled"`
+	EventTypes []string    `json:"event_types"`
+	Channels   []Channel   `json:"channels" binding:"required"`
+	QuietHours *QuietHours `json:"quiet_hours"`
+}
+
+// TestRequest é o corpo de POST /notifications/test: envia aos canais
+// configurados do projeto ou, com channel, só a esse destino.
+type TestRequest struct {
+	ProjectID string   `json:"project_id" binding:"required"`
+	Channel   *Channel `json:"channel"`
+}
+
+// TestResult é o resultado do teste num canal.
+type TestResult struct {
+	Type    string `json:"type"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// testPayload é o payload do evento notification.test.
+type testPayload struct {
+	ProjectID string `json:"project_id"`
+	Message   string `json:"message"`
+}
+
+// List retorna as configurações de todos os projetos.
+func (h *Handler) List(c *gin.Context) {
+	list, err := h.repo.List(c.Request.Context())
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	for _, s := range list {
+		redact(s)
+	}
+	if list == nil {
+		list = []*Settings{}
+	}
+	c.JSON(http.StatusOK, gin.H{"data": list})
+}
+
+// Get retorna as configurações do projeto com as URLs do Slack mascaradas.
+func (h *Handler) Get(c *gin.Context) {
+	s, err := h.repo.Get(c.Request.Context(), c.Param("project_id"))
+	if err != nil {
+		h.serviceError(c, err)
+		return
+	}
+	redact(s)
+	c.JSON(http.StatusOK, s)
+}
+
+// Put cria ou substitui as configurações do projeto.
+func (h *Handler) Put(c *gin.Context) {
+	var req SettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		i18n.BindError(c, err)
+		return
+	}
+	projectID := c.Param("project_id")
+	ctx := c.Request.Context()
+
+	current, err := h.repo.Get(ctx, projectID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		h.internalError(c, err)
+		return
+	}
+	if current != nil {
+		keepSlackURLs(req.Channels, current.Channels)
+	}
+	if err := h.validate(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	s := &Settings{
+		ProjectID:  projectID,
+		Enabled:    req.Enabled == nil || *req.Enabled,
+		EventTypes: req.EventTypes,
+		Channels:   req.Channels,
+		QuietHours: req.QuietHours,
+	}
+	if s.EventTypes == nil {
+		s.EventTypes = []string{}
+	}
+	if err := h.repo.Put(ctx, s); err != nil {
+		h.internalError(c, err)
+		return
+	}
+	h.dispatcher.Invalidate()
+	audit.Record(ctx, "notification.settings_updated", logrus.Fields{"project_id": projectID, "enabled": s.Enabled})
+	redact(s)
+	c.JSON(http.StatusOK, s)
+}
+
+// Delete remove as configurações; o projeto deixa de ser notificado.
+func (h *Handler) Delete(c *gin.Context) {
+	projectID := c.Param("project_id")
+	if err := h.repo.Delete(c.Request.Context(), projectID); err != nil {
+		h.serviceError(c, err)
+		return
+	}
+	h.dispatcher.Invalidate()
+	audit.Record(c.Request.Context(), "notification.settings_deleted", logrus.Fields{"project_id": projectID})
+	c.Status(http.StatusNoContent)
+}
+
+// Test envia uma notificação de teste na hora, sem fila nem novas
+// tentativas, e responde com o resultado de cada canal. Ignora enabled,
+// o filtro de eventos e o horário de silêncio.
+func (h *Handler) Test(c *gin.Context) {
+	var req TestRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		i18n.BindError(c, err)
+		return
+	}
+	ctx := c.Request.Context()
+
+	var channels []Channel
+	if req.Channel != nil {
+		if err := h.dispatcher.Validate(*req.Channel); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		channels = []Channel{*req.Channel}
+	} else {
+		s, err := h.repo.Get(ctx, req.ProjectID)
+		if err != nil {
+			h.serviceError(c, err)
+			return
+		}
+		channels = s.Channels
+	}
+
+	m := NewMessage(events.New("notifications", "notification.test", testPayload{
+		ProjectID: req.ProjectID,
+		Message:   "This is a test notification. Your channel is configured correctly.",
+	}))
+	m.Title = "Test notification for project " + req.ProjectID
+
+	results := make([]TestResult, 0, len(channels))
+	for _, ch := range channels {
+		r := TestResult{Type: ch.Type, Success: true}
+		if err := h.dispatcher.Send(ctx, ch, m); err != nil {
+			r.Success, r.Error = false, err.Error()
+		}
+		results = append(results, r)
+	}
+	audit.Record(ctx, "notification.tested", logrus.Fields{"project_id": req.ProjectID, "channels": len(channels)})
+	c.JSON(http.StatusOK, gin.H{"data": results})
+}
+
+func (h *Handler) validate(req *SettingsRequest) error {
+	if len(req.Channels) == 0 {
+		return errors.New("channels must have at least one channel")
+	}
+	if len(req.Channels) > maxChannels {
+		return fmt.Errorf("channels accepts at most %d channels", maxChannels)
+	}
+	for i, ch := range req.Channels {
+		if err := h.dispatcher.Validate(ch); err != nil {
+			return fmt.Errorf("channels[%d]: %w", i, err)
+		}
+	}
+	if req.QuietHours != nil {
+		return req.QuietHours.Validate()
+	}
+	return nil
+}
+
+func (h *Handler) serviceError(c *gin.Context, err error) {
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	h.internalError(c, err)
+}
+
+func (h *Handler) internalError(c *gin.Context, err error) {
+	logging.FromContext(c.Request.Context()).WithError(err).Error("Erro no handler de notificações")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
+
+// redact mascara o caminho das URLs do Slack, que funcionam como credencial.
+func redact(s *Settings) {
+	for i := range s.Channels {
+		if s.Channels[i].SlackWebhookURL != "" {
+			s.Channels[i].SlackWebhookURL = redactURL(s.Channels[i].SlackWebhookURL)
+		}
+	}
+}
+
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "***"
+	}
+	return u.Scheme + "://" + u.Host + "/***"
+}
+
+// keepSlackURLs troca as URLs mascaradas pelas gravadas correspondentes,
+// na ordem: cada URL gravada substitui no máximo uma mascarada.
+func keepSlackURLs(channels, current []Channel) {
+	used := make([]bool, len(current))
+	for i, ch := range channels {
+		if ch.Type != ChannelSlack {
+			continue
+		}
+		for j, cur := range current {
+			if !used[j] && cur.Type == ChannelSlack && cur.SlackWebhookURL != "" && ch.SlackWebhookURL == redactURL(cur.SlackWebhookURL) {
+				channels[i].SlackWebhookURL = cur.SlackWebhookURL
+				used[j] = true
+				break
+			}
+		}
+	}
+}
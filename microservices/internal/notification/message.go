@@ -0,0 +1,98 @@
+package notification
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"smart-city-microservices/internal/events"
+)
+
+// maxFieldLen corta valores longos no corpo da mensagem.
+const maxFieldLen = 300
+
+// titles são os títulos dos eventos conhecidos; os demais usam o tipo.
+var titles = map[string]string{
+	EventSimulationCompleted:   "Simulation completed",
+	EventSimulationFailed:      "Simulation failed",
+	EventSimulationAutoStopped: "Simulation auto-stopped",
+}
+
+// NewMessage monta a notificação de um evento: título pelo tipo e o nome
+// da simulação ou regra, e os campos do evento em "chave: valor".
+func NewMessage(e events.Event) Message {
+	fields := flatten(e)
+	title, ok := titles[e.Type]
+	if !ok {
+		title = e.Type
+		if strings.HasPrefix(e.Type, "alert.") {
+			title = "Alert " + strings.TrimPrefix(e.Type, "alert.")
+		}
+	}
+	for _, k := range []string{"name", "rule_name", "simulation_id", "id"} {
+		if v := fields[k]; v != "" {
+			title += ": " + v
+			break
+		}
+	}
+
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var text strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&text, "%s: %s\n", k, fields[k])
+	}
+	fmt.Fprintf(&text, "occurred_at: %s", e.OccurredAt.UTC().Format(time.RFC3339))
+
+	return Message{
+		EventType:  e.Type,
+		ProjectID:  fields["project_id"],
+		Title:      title,
+		Text:       text.String(),
+		OccurredAt: e.OccurredAt,
+	}
+}
+
+// expanded são os objetos aninhados que entram campo a campo, como
+// "failure.error", em vez de JSON.
+var expanded = map[string]bool{"failure": true}
+
+// flatten converte os dados do evento num mapa de texto de um nível;
+// objetos aninhados ficam como JSON, exceto os de expanded.
+func flatten(e events.Event) map[string]string {
+	out := map[string]string{}
+	var m map[string]json.RawMessage
+	if e.Decode(&m) != nil {
+		return out
+	}
+	for k, v := range m {
+		var nested map[string]json.RawMessage
+		if expanded[k] && json.Unmarshal(v, &nested) == nil {
+			for nk, nv := range nested {
+				put(out, k+"."+nk, nv)
+			}
+			continue
+		}
+		put(out, k, v)
+	}
+	return out
+}
+
+func put(out map[string]string, k string, v json.RawMessage) {
+	var s string
+	if json.Unmarshal(v, &s) != nil {
+		s = string(v)
+	}
+	if s == "" || s == "null" {
+		return
+	}
+	if len(s) > maxFieldLen {
+		s = strings.ToValidUTF8(s[:maxFieldLen], "") + "…"
+	}
+	out[k] = s
+}
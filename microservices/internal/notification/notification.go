@@ -0,0 +1,153 @@
+// Package notification avisa pessoas (Slack, e-mail) sobre eventos do ciclo
+// de vida das simulações e das regras de alerta, conforme as preferências
+// de cada projeto.
+package notification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrNotFound indica que o projeto não tem configuração de notificações.
+var ErrNotFound = errors.New("notification settings not found")
+
+// Tipos de evento do ciclo de vida das simulações publicados em
+// events.TopicSimulations.
+const (
+	EventSimulationCompleted   = "simulation.completed"
+	EventSimulationFailed      = "simulation.failed"
+	EventSimulationAutoStopped = "simulation.auto_stopped"
+)
+
+// DefaultEventTypes são os eventos notificados quando o projeto não escolhe
+// nenhum: o fim das simulações e qualquer alerta.
+var DefaultEventTypes = []string{
+	EventSimulationCompleted,
+	EventSimulationFailed,
+	EventSimulationAutoStopped,
+	"alert.*",
+}
+
+// Tipos de canal.
+const (
+	ChannelSlack = "slack"
+	ChannelEmail = "email"
+)
+
+// Settings são as preferências de notificação de um projeto.
+type Settings struct {
+	ProjectID string `json:"project_id"`
+	Enabled   bool   `json:"enabled"`
+	// EventTypes filtra os eventos notificados; "alert.*" aceita qualquer
+	// tipo com o prefixo. Vazio usa DefaultEventTypes.
+	EventTypes []string    `json:"event_types"`
+	Channels   []Channel   `json:"channels"`
+	QuietHours *QuietHours `json:"quiet_hours,omitempty"`
+	CreatedAt  time.Time   `json:"created_at"`
+	UpdatedAt  time.Time   `json:"updated_at"`
+}
+
+// Channel é um destino das notificações.
+type Channel struct {
+	Type string `json:"type"`
+	// SlackWebhookURL é a URL do incoming webhook do Slack (type=slack).
+	SlackWebhookURL string `json:"slack_webhook_url,omitempty"`
+	// EmailTo são os destinatários (type=email).
+	EmailTo []string `json:"email_to,omitempty"`
+}
+
+// QuietHours é o intervalo diário em que as notificações são suprimidas,
+// exceto os tipos em Except. Start e End são "HH:MM" no fuso Timezone; com
+// Start depois de End o intervalo atravessa a meia-noite.
+type QuietHours struct {
+	Start    string   `json:"start"`
+	End      string   `json:"end"`
+	Timezone string   `json:"timezone"`
+	Except   []string `json:"except,omitempty"`
+}
+
+// Accepts indica se o projeto quer ser notificado do tipo de evento.
+func (s *Settings) Accepts(eventType string) bool {
+	types := s.EventTypes
+	if len(types) == 0 {
+		types = DefaultEventTypes
+	}
+	return matchAny(types, eventType)
+}
+
+// Quiet indica se t cai no horário de silêncio para o tipo de evento.
+func (q *QuietHours) Quiet(eventType string, t time.Time) bool {
+	if q == nil || matchAny(q.Except, eventType) {
+		return false
+	}
+	loc, err := time.LoadLocation(q.Timezone)
+	if err != nil {
+		return false
+	}
+	start, err1 := parseClock(q.Start)
+	end, err2 := parseClock(q.End)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	t = t.In(loc)
+	now := t.Hour()*60 + t.Minute()
+	if start <= end {
+		return now >= start && now < end
+	}
+	return now >= start || now < end
+}
+
+// Validate confere o intervalo e o fuso.
+func (q *QuietHours) Validate() error {
+	if _, err := parseClock(q.Start); err != nil {
+		return fmt.Errorf("invalid quiet_hours.start %q: use HH:MM", q.Start)
+	}
+	if _, err := parseClock(q.End); err != nil {
+		return fmt.Errorf("invalid quiet_hours.end %q: use HH:MM", q.End)
+	}
+	if q.Start == q.End {
+		return errors.New("quiet_hours.start and quiet_hours.end must differ")
+	}
+	if _, err := time.LoadLocation(q.Timezone); err != nil || q.Timezone == "" {
+		return fmt.Errorf("invalid quiet_hours.timezone %q", q.Timezone)
+	}
+	return nil
+}
+
+// parseClock converte "HH:MM" em minutos desde a meia-noite.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func matchAny(patterns []string, eventType string) bool {
+	for _, p := range patterns {
+		if p == eventType || (strings.HasSuffix(p, ".*") && strings.HasPrefix(eventType, strings.TrimSuffix(p, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// Message é uma notificação pronta para envio.
+type Message struct {
+	EventType  string
+	ProjectID  string
+	Title      string
+	Text       string
+	OccurredAt time.Time
+}
+
+// Notifier envia mensagens por um tipo de canal.
+type Notifier interface {
+	// Validate confere o destino antes de gravá-lo nas configurações.
+	Validate(ch Channel) error
+	// Notify envia a mensagem ao destino.
+	Notify(ctx context.Context, ch Channel, m Message) error
+}
@@ -0,0 +1,113 @@
+package notification
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/lib/pq"
+
+	"smart-city-microservices/internal/instrument"
+)
+
+// Repository persiste as configurações de notificação no PostgreSQL.
+type Repository struct {
+	db *instrument.DB
+}
+
+// NewRepository cria o repositório.
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: instrument.NewDB(db)}
+}
+
+const settingsColumns = `project_id, enabled, event_types, channels, quiet_hours, created_at, updated_at`
+
+func scanSettings(row interface{ Scan(...interface{}) error }) (*Settings, error) {
+	var s Settings
+	var channels, quiet []byte
+	err := row.Scan(&s.ProjectID, &s.Enabled, pq.Array(&s.EventTypes), &channels, &quiet, &s.CreatedAt, &s.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(channels, &s.Channels); err != nil {
+		return nil, err
+	}
+	if len(quiet) > 0 {
+		if err := json.Unmarshal(quiet, &s.QuietHours); err != nil {
+			return nil, err
+		}
+	}
+	return &s, nil
+}
+
+// Get busca as configurações do projeto.
+func (r *Repository) Get(ctx context.Context, projectID string) (*Settings, error) {
+	return scanSettings(r.db.QueryRow(ctx, "notification.get",
+		`SELECT `+settingsColumns+` FROM notification_settings WHERE project_id = $1`, projectID))
+}
+
+// List retorna as configurações de todos os projetos.
+func (r *Repository) List(ctx context.Context) ([]*Settings, error) {
+	rows, err := r.db.Query(ctx, "notification.list",
+		`SELECT `+settingsColumns+` FROM notification_settings ORDER BY project_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*Settings
+	for rows.Next() {
+		s, err := scanSettings(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// Put cria ou substitui as configurações do projeto e preenche as datas.
+func (r *Repository) Put(ctx context.Context, s *Settings) error {
+	channels, err := json.Marshal(s.Channels)
+	if err != nil {
+		return err
+	}
+	var quiet []byte
+	if s.QuietHours != nil {
+		if quiet, err = json.Marshal(s.QuietHours); err != nil {
+			return err
+		}
+	}
+	return r.db.QueryRow(ctx, "notification.put", `
+		INSERT INTO notification_settings (project_id, enabled, event_types, channels, quiet_hours)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (project_id) DO UPDATE SET enabled = EXCLUDED.enabled, event_types = EXCLUDED.event_types,
+			channels = EXCLUDED.channels, quiet_hours = EXCLUDED.quiet_hours
+		RETURNING created_at, updated_at`,
+		s.ProjectID, s.Enabled, pq.Array(s.EventTypes), string(channels), nullJSON(quiet),
+	).Scan(&s.CreatedAt, &s.UpdatedAt)
+}
+
+// Delete remove as configurações do projeto.
+func (r *Repository) Delete(ctx context.Context, projectID string) error {
+	res, err := r.db.Exec(ctx, "notification.delete",
+		`DELETE FROM notification_settings WHERE project_id = $1`, projectID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func nullJSON(b []byte) interface{} {
+	if b == nil {
+		return nil
+	}
+	return string(b)
+}
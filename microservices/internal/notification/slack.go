@@ -0,0 +1,69 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"smart-city-microservices/internal/outbound"
+)
+
+// Slack envia as mensagens a incoming webhooks do Slack (ou serviços com a
+// mesma API, como o Mattermost).
+type Slack struct {
+	client *http.Client
+}
+
+// NewSlack cria o notificador sobre o pool de saída. Sem allowPrivate,
+// destinos em loopback e redes privadas são recusados, como nos webhooks.
+func NewSlack(pool *outbound.Pool, timeout time.Duration, allowPrivate bool) *Slack {
+	return &Slack{client: pool.Client(outbound.Options{
+		Name:                 "slack",
+		Timeout:              timeout,
+		AllowPrivateNetworks: allowPrivate,
+		NoRedirects:          true,
+	})}
+}
+
+func (s *Slack) Validate(ch Channel) error {
+	u, err := url.Parse(ch.SlackWebhookURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("invalid slack_webhook_url: use an absolute https URL")
+	}
+	return nil
+}
+
+func (s *Slack) Notify(ctx context.Context, ch Channel, m Message) error {
+	body, err := json.Marshal(map[string]string{"text": "*" + m.Title + "*\n" + m.Text})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ch.SlackWebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "smart-city-agent-service-notifications")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		// A URL do webhook é uma credencial; o erro do cliente a repete.
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			return uerr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("slack: unexpected status %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return nil
+}
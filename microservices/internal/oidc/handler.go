@@ -0,0 +1,388 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/audit"
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/i18n"
+	"smart-city-microservices/internal/logging"
+)
+
+const (
+	// loginPrefix guarda o state de um login em andamento, com o nonce e o
+	// verificador PKCE, por loginTTL.
+	loginPrefix = "agent-service:oidc:login:"
+	loginTTL    = 10 * time.Minute
+	// sessionPrefix guarda a sessão de um refresh token, pelo hash dele.
+	sessionPrefix = "agent-service:oidc:session:"
+)
+
+// login é o estado de um login entre /auth/login e /auth/callback.
+type login struct {
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+// session é o que o refresh token do serviço guarda: as claims do último ID
+// token e o refresh token do provedor.
+type session struct {
+	Claims          Claims `json:"claims"`
+	ProviderRefresh string `json:"provider_refresh,omitempty"`
+}
+
+// TokenResponse é a resposta do callback e da renovação.
+type TokenResponse struct {
+	AccessToken  string          `json:"access_token"`
+	TokenType    string          `json:"token_type"`
+	ExpiresIn    int             `json:"expires_in"`
+	RefreshToken string          `json:"refresh_token"`
+	Principal    *auth.Principal `json:"principal"`
+}
+
+// Handler expõe o login OIDC em /auth.
+type Handler struct {
+	verifier *Verifier
+	redis    redis.UniversalClient
+	client   *http.Client
+}
+
+// NewHandler cria o handler do login.
+func NewHandler(verifier *Verifier, client redis.UniversalClient, httpClient *http.Client) *Handler {
+	return &Handler{verifier: verifier, redis: client, client: httpClient}
+}
+
+// Login responde GET /auth/login redirecionando ao provedor.
+func (h *Handler) Login(c *gin.Context) {
+	ctx := c.Request.Context()
+	meta, err := h.verifier.provider.metadata(ctx)
+	if err != nil {
+		h.unavailable(c, err)
+		return
+	}
+	state, l := randomString(), login{Nonce: randomString(), Verifier: randomString()}
+	data, _ := json.Marshal(l)
+	if err := h.redis.Set(ctx, loginPrefix+state, data, loginTTL).Err(); err != nil {
+		h.internalError(c, err)
+		return
+	}
+	cfg := h.verifier.cfg
+	challenge := sha256.Sum256([]byte(l.Verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {cfg.ClientID},
+		"redirect_uri":          {cfg.RedirectURL},
+		"scope":                 {strings.Join(cfg.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {l.Nonce},
+		"code_challenge":        {b64.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	c.Redirect(http.StatusFound, meta.AuthorizationEndpoint+sep+q.Encode())
+}
+
+// Callback responde GET /auth/callback: troca o código pelos tokens do
+// provedor, confere o ID token e emite a sessão do serviço.
+func (h *Handler) Callback(c *gin.Context) {
+	ctx := c.Request.Context()
+	if e := c.Query("error"); e != "" {
+		msg := "login failed: " + e
+		if d := c.Query("error_description"); d != "" {
+			msg += ": " + d
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": msg})
+		return
+	}
+	code, state := c.Query("code"), c.Query("state")
+	if code == "" || state == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code and state are required"})
+		return
+	}
+	data, err := h.redis.GetDel(ctx, loginPrefix+state).Bytes()
+	if errors.Is(err, redis.Nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown or expired login state"})
+		return
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	var l login
+	if err := json.Unmarshal(data, &l); err != nil {
+		h.internalError(c, err)
+		return
+	}
+
+	cfg := h.verifier.cfg
+	tokens, err := h.exchange(ctx, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {cfg.RedirectURL},
+		"code_verifier": {l.Verifier},
+	})
+	if err != nil {
+		h.providerError(c, err)
+		return
+	}
+	claims, err := h.verifier.verifyIDToken(ctx, tokens.IDToken, l.Nonce)
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).Warn("ID token recusado no login OIDC")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid id token"})
+		return
+	}
+	resp, ok := h.start(c, session{Claims: claims, ProviderRefresh: tokens.RefreshToken})
+	if !ok {
+		return
+	}
+	audit.Record(ctx, "auth.login", logrus.Fields{
+		"subject":  resp.Principal.Subject,
+		"roles":    resp.Principal.Roles,
+		"projects": resp.Principal.Projects,
+	})
+	if cfg.FrontendURL != "" {
+		fragment := url.Values{
+			"access_token":  {resp.AccessToken},
+			"token_type":    {resp.TokenType},
+			"expires_in":    {fmt.Sprint(resp.ExpiresIn)},
+			"refresh_token": {resp.RefreshToken},
+		}
+		c.Redirect(http.StatusFound, cfg.FrontendURL+"#"+fragment.Encode())
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// RefreshRequest é o corpo de POST /auth/refresh.
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// Refresh responde POST /auth/refresh: renova a sessão no provedor, remapeia
+// os grupos e emite um novo par de tokens. O refresh token usado deixa de
+// valer.
+func (h *Handler) Refresh(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		i18n.BindError(c, err)
+		return
+	}
+	ctx := c.Request.Context()
+	data, err := h.redis.GetDel(ctx, sessionKey(req.RefreshToken)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
+		return
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	var s session
+	if err := json.Unmarshal(data, &s); err != nil {
+		h.internalError(c, err)
+		return
+	}
+
+	// Sem refresh token do provedor (offline_access não concedido), a
+	// sessão é renovada com as claims do login até RefreshTTL.
+	if s.ProviderRefresh != "" {
+		tokens, err := h.exchange(ctx, url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {s.ProviderRefresh},
+		})
+		if err != nil {
+			h.providerError(c, err)
+			return
+		}
+		if tokens.RefreshToken != "" {
+			s.ProviderRefresh = tokens.RefreshToken
+		}
+		if tokens.IDToken != "" {
+			claims, err := h.verifier.verifyIDToken(ctx, tokens.IDToken, "")
+			if err != nil {
+				logging.FromContext(ctx).WithError(err).Warn("ID token recusado na renovação OIDC")
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid id token"})
+				return
+			}
+			s.Claims = claims
+		}
+	}
+	resp, ok := h.start(c, s)
+	if !ok {
+		return
+	}
+	audit.Record(ctx, "auth.refreshed", logrus.Fields{"subject": resp.Principal.Subject, "roles": resp.Principal.Roles})
+	c.JSON(http.StatusOK, resp)
+}
+
+// start mapeia as claims, emite o JWT e grava a sessão do novo refresh
+// token.
+func (h *Handler) start(c *gin.Context, s session) (*TokenResponse, bool) {
+	ctx := c.Request.Context()
+	p, err := h.verifier.principal(s.Claims)
+	if errors.Is(err, ErrNoRole) || errors.Is(err, ErrNoProject) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).Warn("Claims do provedor OIDC sem subject")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid id token"})
+		return nil, false
+	}
+	access, exp, err := h.verifier.issue(p)
+	if err != nil {
+		h.internalError(c, err)
+		return nil, false
+	}
+	refresh := randomString()
+	data, err := json.Marshal(s)
+	if err != nil {
+		h.internalError(c, err)
+		return nil, false
+	}
+	if err := h.redis.Set(ctx, sessionKey(refresh), data, h.verifier.cfg.RefreshTTL).Err(); err != nil {
+		h.internalError(c, err)
+		return nil, false
+	}
+	return &TokenResponse{
+		AccessToken:  access,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(time.Until(exp).Seconds()),
+		RefreshToken: refresh,
+		Principal:    p,
+	}, true
+}
+
+// providerTokens é a resposta do token endpoint.
+type providerTokens struct {
+	IDToken      string `json:"id_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// rejectedError é a recusa do provedor (código inválido, sessão encerrada).
+type rejectedError struct {
+	status int
+	code   string
+}
+
+func (e *rejectedError) Error() string {
+	return fmt.Sprintf("provedor OIDC recusou a troca (status %d): %s", e.status, e.code)
+}
+
+// exchange chama o token endpoint com as credenciais do client.
+func (h *Handler) exchange(ctx context.Context, form url.Values) (*providerTokens, error) {
+	meta, err := h.verifier.provider.metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cfg := h.verifier.cfg
+	form.Set("client_id", cfg.ClientID)
+	form.Set("client_secret", cfg.ClientSecret)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnauthorized {
+		var e struct {
+			Error string `json:"error"`
+		}
+		_ = json.Unmarshal(body, &e)
+		return nil, &rejectedError{status: resp.StatusCode, code: e.Error}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint: status %d", resp.StatusCode)
+	}
+	var t providerTokens
+	if err := json.Unmarshal(body, &t); err != nil {
+		return nil, fmt.Errorf("token endpoint: %w", err)
+	}
+	if t.IDToken == "" && form.Get("grant_type") == "authorization_code" {
+		return nil, errors.New("token endpoint: resposta sem id_token (scope openid ausente?)")
+	}
+	return &t, nil
+}
+
+// providerError responde 401 para a recusa do provedor e 502 para as
+// demais falhas na troca.
+func (h *Handler) providerError(c *gin.Context, err error) {
+	var rejected *rejectedError
+	if errors.As(err, &rejected) {
+		logging.FromContext(c.Request.Context()).WithError(err).Info("Troca de tokens OIDC recusada")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "identity provider rejected the request; log in again"})
+		return
+	}
+	h.unavailable(c, err)
+}
+
+func (h *Handler) unavailable(c *gin.Context, err error) {
+	logging.FromContext(c.Request.Context()).WithError(err).Error("Provedor OIDC indisponível")
+	c.JSON(http.StatusBadGateway, gin.H{"error": "identity provider is unavailable"})
+}
+
+func (h *Handler) internalError(c *gin.Context, err error) {
+	logging.FromContext(c.Request.Context()).WithError(err).Error("Erro no handler de login OIDC")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
+
+// Middleware autentica as requisições com Authorization: Bearer <JWT>,
+// emitido pelo serviço ou pelo provedor. Um JWT inválido responde 401;
+// requisições sem JWT ou já autenticadas seguem adiante.
+func Middleware(v *Verifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		raw, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || strings.Count(raw, ".") != 2 || auth.FromGin(c) != nil {
+			c.Next()
+			return
+		}
+		p, err := v.Verify(c.Request.Context(), raw)
+		if err != nil {
+			logging.FromContext(c.Request.Context()).WithError(err).Debug("JWT recusado")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+			return
+		}
+		auth.SetPrincipal(c, p)
+		c.Next()
+	}
+}
+
+func sessionKey(refresh string) string {
+	sum := sha256.Sum256([]byte(refresh))
+	return sessionPrefix + hex.EncodeToString(sum[:])
+}
+
+func randomString() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b64.EncodeToString(b)
+}
@@ -0,0 +1,155 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// keysTTL é a validade das chaves do provedor em memória.
+	keysTTL = time.Hour
+	// keysMinRefresh limita as releituras provocadas por um kid
+	// desconhecido, que um token forjado poderia disparar à vontade.
+	keysMinRefresh = time.Minute
+)
+
+// jwk é uma chave do JWKS; só RSA e EC (P-256, P-384) são usadas.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := b64.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := b64.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("curva %q não suportada", k.Crv)
+		}
+		x, err := b64.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := b64.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("tipo de chave %q não suportado", k.Kty)
+}
+
+// keySet guarda as chaves públicas do provedor, relidas a cada keysTTL ou
+// quando aparece um kid desconhecido (rotação de chaves).
+type keySet struct {
+	provider *Provider
+	client   *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]interface{}
+	fetchedAt time.Time
+}
+
+// key retorna a chave do kid. Um token sem kid usa a única chave do
+// conjunto, se houver só uma.
+func (s *keySet) key(ctx context.Context, kid string) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	age := time.Since(s.fetchedAt)
+	if k, ok := s.find(kid); ok && age < keysTTL {
+		return k, nil
+	}
+	if s.keys == nil || age >= keysMinRefresh {
+		if err := s.fetch(ctx); err != nil {
+			// Com o provedor fora do ar, as chaves já conhecidas continuam
+			// valendo.
+			if k, ok := s.find(kid); ok {
+				return k, nil
+			}
+			return nil, err
+		}
+	}
+	if k, ok := s.find(kid); ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("chave de assinatura %q desconhecida", kid)
+}
+
+func (s *keySet) find(kid string) (interface{}, bool) {
+	if kid == "" && len(s.keys) == 1 {
+		for _, k := range s.keys {
+			return k, true
+		}
+	}
+	k, ok := s.keys[kid]
+	return k, ok
+}
+
+func (s *keySet) fetch(ctx context.Context) error {
+	meta, err := s.provider.metadata(ctx)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, meta.JWKSURI, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("jwks: status %d", resp.StatusCode)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("jwks: %w", err)
+	}
+	keys := map[string]interface{}{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if pub, err := k.publicKey(); err == nil {
+			keys[k.Kid] = pub
+		}
+	}
+	if len(keys) == 0 {
+		return errors.New("jwks: nenhuma chave de assinatura utilizável")
+	}
+	s.keys, s.fetchedAt = keys, time.Now()
+	return nil
+}
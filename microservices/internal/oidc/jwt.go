@@ -0,0 +1,214 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// leeway tolera a diferença de relógio com o provedor em exp, nbf e iat.
+const leeway = time.Minute
+
+// Claims são as claims de um JWT.
+type Claims map[string]interface{}
+
+// String retorna a claim de texto; ausente ou de outro tipo, "".
+func (c Claims) String(name string) string {
+	s, _ := c.lookup(name).(string)
+	return s
+}
+
+// Strings retorna a claim como lista de textos; uma claim de texto vira
+// uma lista de um item. name aceita caminhos com ponto
+// (realm_access.roles).
+func (c Claims) Strings(name string) []string {
+	switch v := c.lookup(name).(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// Time retorna a claim numérica como instante (exp, iat, nbf).
+func (c Claims) Time(name string) (time.Time, bool) {
+	f, ok := c.lookup(name).(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(f), 0), true
+}
+
+// lookup segue o caminho com ponto pelos objetos aninhados; uma claim cujo
+// nome tem ponto é encontrada antes.
+func (c Claims) lookup(name string) interface{} {
+	if v, ok := c[name]; ok {
+		return v
+	}
+	var cur interface{} = map[string]interface{}(c)
+	for _, part := range strings.Split(name, ".") {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		cur = m[part]
+	}
+	return cur
+}
+
+// checkTimes confere exp, nbf e iat com a tolerância de leeway.
+func (c Claims) checkTimes(now time.Time) error {
+	exp, ok := c.Time("exp")
+	if !ok {
+		return errors.New("token sem exp")
+	}
+	if now.After(exp.Add(leeway)) {
+		return errors.New("token expirado")
+	}
+	if nbf, ok := c.Time("nbf"); ok && now.Add(leeway).Before(nbf) {
+		return errors.New("token ainda não é válido (nbf)")
+	}
+	if iat, ok := c.Time("iat"); ok && now.Add(leeway).Before(iat) {
+		return errors.New("token emitido no futuro (iat)")
+	}
+	return nil
+}
+
+// hasAudience indica se aud, texto ou lista, contém o valor.
+func (c Claims) hasAudience(aud string) bool {
+	for _, a := range c.Strings("aud") {
+		if a == aud {
+			return true
+		}
+	}
+	return false
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// token é um JWS compacto decodificado, ainda sem a assinatura conferida.
+type token struct {
+	header    header
+	claims    Claims
+	signed    []byte
+	signature []byte
+}
+
+var b64 = base64.RawURLEncoding
+
+func parse(raw string) (*token, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("token malformado")
+	}
+	var t token
+	h, err := b64.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(h, &t.header) != nil {
+		return nil, errors.New("cabeçalho do token malformado")
+	}
+	p, err := b64.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(p, &t.claims) != nil {
+		return nil, errors.New("claims do token malformadas")
+	}
+	if t.signature, err = b64.DecodeString(parts[2]); err != nil {
+		return nil, errors.New("assinatura do token malformada")
+	}
+	t.signed = []byte(parts[0] + "." + parts[1])
+	return &t, nil
+}
+
+// verify confere a assinatura com a chave: []byte para HS256,
+// *rsa.PublicKey para RS256/384/512 e *ecdsa.PublicKey para ES256/384.
+func (t *token) verify(key interface{}) error {
+	switch t.header.Alg {
+	case "HS256":
+		k, ok := key.([]byte)
+		if !ok {
+			break
+		}
+		mac := hmac.New(sha256.New, k)
+		mac.Write(t.signed)
+		if !hmac.Equal(mac.Sum(nil), t.signature) {
+			return errors.New("assinatura do token inválida")
+		}
+		return nil
+	case "RS256", "RS384", "RS512":
+		k, ok := key.(*rsa.PublicKey)
+		if !ok {
+			break
+		}
+		h, digest := hashFor(t.header.Alg, t.signed)
+		if rsa.VerifyPKCS1v15(k, h, digest, t.signature) != nil {
+			return errors.New("assinatura do token inválida")
+		}
+		return nil
+	case "ES256", "ES384":
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			break
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(t.signature) != 2*size {
+			return errors.New("assinatura do token inválida")
+		}
+		_, digest := hashFor(t.header.Alg, t.signed)
+		r := new(big.Int).SetBytes(t.signature[:size])
+		s := new(big.Int).SetBytes(t.signature[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return errors.New("assinatura do token inválida")
+		}
+		return nil
+	default:
+		return fmt.Errorf("algoritmo de token %q não suportado", t.header.Alg)
+	}
+	return fmt.Errorf("chave incompatível com o algoritmo %q do token", t.header.Alg)
+}
+
+func hashFor(alg string, data []byte) (crypto.Hash, []byte) {
+	switch alg[2:] {
+	case "384":
+		sum := sha512.Sum384(data)
+		return crypto.SHA384, sum[:]
+	case "512":
+		sum := sha512.Sum512(data)
+		return crypto.SHA512, sum[:]
+	}
+	sum := sha256.Sum256(data)
+	return crypto.SHA256, sum[:]
+}
+
+// signHS256 emite um JWT HS256 com as claims.
+func signHS256(key []byte, claims Claims) (string, error) {
+	h, err := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	p, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := b64.EncodeToString(h) + "." + b64.EncodeToString(p)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signed))
+	return signed + "." + b64.EncodeToString(mac.Sum(nil)), nil
+}
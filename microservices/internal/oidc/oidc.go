@@ -0,0 +1,296 @@
+// Package oidc autentica administradores e operadores por OpenID Connect:
+// o login segue o fluxo authorization code (com PKCE) no provedor
+// configurado, os grupos do ID token viram papéis e o serviço emite o
+// próprio JWT de curta duração, renovado com um refresh token opaco que
+// guarda o refresh token do provedor no Redis.
+//
+// O Middleware aceita tanto os JWTs emitidos pelo serviço quanto os emitidos
+// diretamente pelo provedor para o client configurado, de modo que clientes
+// que já têm um token do provedor não precisam passar pelo login.
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"smart-city-microservices/internal/auth"
+)
+
+// Issuer é o iss (e aud) dos JWTs emitidos pelo serviço.
+const Issuer = "agent-service"
+
+// SubjectPrefix antecede o subject dos principais autenticados por OIDC.
+const SubjectPrefix = "oidc:"
+
+// ErrNoRole indica que nenhum grupo do usuário está mapeado para um papel.
+var ErrNoRole = errors.New("no role is mapped to the user's groups")
+
+// ErrNoProject indica que ProjectsClaim está configurado e o token não traz
+// projetos. O principal sem projetos não teria restrição de projeto
+// (auth.Principal.InProject), então o token é recusado; administradores não
+// têm restrição de projeto e passam.
+var ErrNoProject = errors.New("token has no projects claim")
+
+// RoleMapping associa um grupo do provedor a um papel do serviço.
+type RoleMapping struct {
+	Group string
+	Role  string
+}
+
+// Config configura o provedor, o mapeamento de papéis e as sessões.
+type Config struct {
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL é a URL pública de /auth/callback registrada no provedor.
+	RedirectURL string
+	Scopes      []string
+	// SubjectClaim identifica o usuário; GroupsClaim e ProjectsClaim aceitam
+	// caminhos com ponto (realm_access.roles). ProjectsClaim vazio não
+	// restringe projetos; configurado, tokens sem projetos são recusados,
+	// exceto os de administradores.
+	SubjectClaim  string
+	GroupsClaim   string
+	ProjectsClaim string
+	RoleMappings  []RoleMapping
+	// DefaultRoles valem para todo usuário autenticado, além dos mapeados.
+	DefaultRoles []string
+	// FrontendURL, se definido, recebe o redirecionamento do callback com os
+	// tokens no fragmento; vazio, o callback responde JSON.
+	FrontendURL string
+	// SigningKey assina os JWTs do serviço (HS256), válidos por SessionTTL;
+	// o refresh token vale por RefreshTTL.
+	SigningKey []byte
+	SessionTTL time.Duration
+	RefreshTTL time.Duration
+}
+
+// metadata é o documento de descoberta do provedor.
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider lê o documento de descoberta do provedor na primeira vez que é
+// preciso, para que o serviço suba com o provedor fora do ar.
+type Provider struct {
+	issuer string
+	client *http.Client
+
+	mu   sync.Mutex
+	meta *metadata
+}
+
+// NewProvider cria o provedor do issuer.
+func NewProvider(issuerURL string, client *http.Client) *Provider {
+	return &Provider{issuer: strings.TrimSuffix(issuerURL, "/"), client: client}
+}
+
+func (p *Provider) metadata(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.meta != nil {
+		return p.meta, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("descoberta OIDC: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("descoberta OIDC: status %d", resp.StatusCode)
+	}
+	var m metadata
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		return nil, fmt.Errorf("descoberta OIDC: %w", err)
+	}
+	if strings.TrimSuffix(m.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("descoberta OIDC: issuer %q difere do configurado %q", m.Issuer, p.issuer)
+	}
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return nil, errors.New("descoberta OIDC: documento sem authorization_endpoint, token_endpoint ou jwks_uri")
+	}
+	p.meta = &m
+	return p.meta, nil
+}
+
+// Verifier confere os JWTs aceitos pelo serviço e resolve o principal.
+type Verifier struct {
+	cfg      Config
+	provider *Provider
+	keys     *keySet
+	now      func() time.Time
+}
+
+// NewVerifier cria o verificador sobre o provedor.
+func NewVerifier(cfg Config, provider *Provider) *Verifier {
+	return &Verifier{
+		cfg:      cfg,
+		provider: provider,
+		keys:     &keySet{provider: provider, client: provider.client},
+		now:      time.Now,
+	}
+}
+
+// Verify confere um JWT do serviço ou do provedor e retorna o principal.
+func (v *Verifier) Verify(ctx context.Context, raw string) (*auth.Principal, error) {
+	t, err := parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if t.claims.String("iss") == Issuer {
+		return v.verifySession(t)
+	}
+	if err := v.verifyProvider(ctx, t); err != nil {
+		return nil, err
+	}
+	// Access tokens do provedor nem sempre trazem o client em aud; azp
+	// identifica para quem foram emitidos.
+	if !t.claims.hasAudience(v.cfg.ClientID) && t.claims.String("azp") != v.cfg.ClientID {
+		return nil, errors.New("token não foi emitido para este client")
+	}
+	return v.principal(t.claims)
+}
+
+// verifyIDToken confere o ID token recebido do provedor no login ou na
+// renovação. nonce vazio não é conferido (renovação).
+func (v *Verifier) verifyIDToken(ctx context.Context, raw, nonce string) (Claims, error) {
+	t, err := parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if err := v.verifyProvider(ctx, t); err != nil {
+		return nil, err
+	}
+	if !t.claims.hasAudience(v.cfg.ClientID) {
+		return nil, errors.New("ID token não foi emitido para este client")
+	}
+	if nonce != "" && t.claims.String("nonce") != nonce {
+		return nil, errors.New("nonce do ID token não confere")
+	}
+	return t.claims, nil
+}
+
+func (v *Verifier) verifyProvider(ctx context.Context, t *token) error {
+	if strings.TrimSuffix(t.claims.String("iss"), "/") != v.provider.issuer {
+		return errors.New("issuer desconhecido")
+	}
+	if t.header.Alg == "HS256" || t.header.Alg == "none" {
+		return fmt.Errorf("algoritmo %q não aceito para tokens do provedor", t.header.Alg)
+	}
+	key, err := v.keys.key(ctx, t.header.Kid)
+	if err != nil {
+		return err
+	}
+	if err := t.verify(key); err != nil {
+		return err
+	}
+	return t.claims.checkTimes(v.now())
+}
+
+func (v *Verifier) verifySession(t *token) (*auth.Principal, error) {
+	if t.header.Alg != "HS256" {
+		return nil, fmt.Errorf("algoritmo %q não aceito para tokens do serviço", t.header.Alg)
+	}
+	if err := t.verify(v.cfg.SigningKey); err != nil {
+		return nil, err
+	}
+	if err := t.claims.checkTimes(v.now()); err != nil {
+		return nil, err
+	}
+	if !t.claims.hasAudience(Issuer) {
+		return nil, errors.New("token do serviço sem aud")
+	}
+	p := &auth.Principal{
+		Subject:  t.claims.String("sub"),
+		Roles:    t.claims.Strings("roles"),
+		Projects: t.claims.Strings("projects"),
+	}
+	if err := v.checkProjects(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// principal mapeia as claims do provedor para o principal: os grupos
+// mapeados dão os papéis, somados a DefaultRoles.
+func (v *Verifier) principal(claims Claims) (*auth.Principal, error) {
+	sub := claims.String(v.cfg.SubjectClaim)
+	if sub == "" {
+		return nil, fmt.Errorf("claim %s ausente", v.cfg.SubjectClaim)
+	}
+	var roles []string
+	add := func(role string) {
+		for _, r := range roles {
+			if r == role {
+				return
+			}
+		}
+		roles = append(roles, role)
+	}
+	for _, group := range claims.Strings(v.cfg.GroupsClaim) {
+		for _, m := range v.cfg.RoleMappings {
+			if m.Group == group {
+				add(m.Role)
+			}
+		}
+	}
+	for _, role := range v.cfg.DefaultRoles {
+		add(role)
+	}
+	if len(roles) == 0 {
+		return nil, ErrNoRole
+	}
+	p := &auth.Principal{Subject: SubjectPrefix + sub, Roles: roles}
+	if v.cfg.ProjectsClaim != "" {
+		for _, id := range claims.Strings(v.cfg.ProjectsClaim) {
+			if id != "" {
+				p.Projects = append(p.Projects, id)
+			}
+		}
+	}
+	if err := v.checkProjects(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// checkProjects recusa, com ProjectsClaim configurado, o principal que não
+// é administrador e não tem projetos.
+func (v *Verifier) checkProjects(p *auth.Principal) error {
+	if v.cfg.ProjectsClaim != "" && len(p.Projects) == 0 && !p.HasRole(auth.RoleAdmin) {
+		return ErrNoProject
+	}
+	return nil
+}
+
+// issue emite o JWT do serviço para o principal.
+func (v *Verifier) issue(p *auth.Principal) (string, time.Time, error) {
+	now := v.now()
+	exp := now.Add(v.cfg.SessionTTL)
+	claims := Claims{
+		"iss":   Issuer,
+		"aud":   Issuer,
+		"sub":   p.Subject,
+		"roles": p.Roles,
+		"iat":   now.Unix(),
+		"exp":   exp.Unix(),
+	}
+	if len(p.Projects) > 0 {
+		claims["projects"] = p.Projects
+	}
+	token, err := signHS256(v.cfg.SigningKey, claims)
+	return token, exp, err
+}
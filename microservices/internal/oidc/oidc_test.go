@@ -0,0 +1,193 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"smart-city-microservices/internal/auth"
+)
+
+func init() { gin.SetMode(gin.TestMode) }
+
+const testClient = "agent-service-test"
+
+// provider é um provedor OIDC com descoberta e JWKS de uma chave RSA.
+type provider struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+}
+
+func newProvider(t *testing.T) *provider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &provider{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, _ *http.Request) {
+		json.NewEncoder(w).Encode(metadata{
+			Issuer:                p.URL,
+			AuthorizationEndpoint: p.URL + "/authorize",
+			TokenEndpoint:         p.URL + "/token",
+			JWKSURI:               p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, _ *http.Request) {
+		json.NewEncoder(w).Encode(map[string][]jwk{"keys": {{
+			Kty: "RSA", Kid: "k1", Use: "sig",
+			N: b64.EncodeToString(key.N.Bytes()),
+			E: b64.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// sign emite um access token RS256 do provedor para testClient com claims
+// somadas às padrão.
+func (p *provider) sign(t *testing.T, claims Claims) string {
+	t.Helper()
+	now := time.Now()
+	all := Claims{"iss": p.URL, "aud": testClient, "sub": "maria", "iat": now.Unix(), "exp": now.Add(time.Hour).Unix()}
+	for k, v := range claims {
+		all[k] = v
+	}
+	h, _ := json.Marshal(header{Alg: "RS256", Kid: "k1"})
+	c, _ := json.Marshal(all)
+	signed := b64.EncodeToString(h) + "." + b64.EncodeToString(c)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + b64.EncodeToString(sig)
+}
+
+func newVerifier(p *provider, projectsClaim string) *Verifier {
+	return NewVerifier(Config{
+		IssuerURL:     p.URL,
+		ClientID:      testClient,
+		SubjectClaim:  "sub",
+		GroupsClaim:   "groups",
+		ProjectsClaim: projectsClaim,
+		RoleMappings: []RoleMapping{
+			{Group: "city-ops", Role: auth.RoleOperator},
+			{Group: "city-admins", Role: auth.RoleAdmin},
+		},
+		SigningKey: []byte("test-signing-key"),
+		SessionTTL: time.Hour,
+	}, NewProvider(p.URL, p.Client()))
+}
+
+func TestVerifyProjects(t *testing.T) {
+	tests := []struct {
+		name          string
+		projectsClaim string
+		claims        Claims
+		want          []string
+		err           error
+	}{
+		{name: "projects", projectsClaim: "projects", claims: Claims{"groups": []string{"city-ops"}, "projects": []string{"proj-1", "proj-2"}}, want: []string{"proj-1", "proj-2"}},
+		{name: "single project", projectsClaim: "projects", claims: Claims{"groups": []string{"city-ops"}, "projects": "proj-1"}, want: []string{"proj-1"}},
+		{name: "nested claim", projectsClaim: "org.projects", claims: Claims{"groups": []string{"city-ops"}, "org": map[string]interface{}{"projects": []string{"proj-1"}}}, want: []string{"proj-1"}},
+		{name: "missing claim", projectsClaim: "projects", claims: Claims{"groups": []string{"city-ops"}}, err: ErrNoProject},
+		{name: "empty list", projectsClaim: "projects", claims: Claims{"groups": []string{"city-ops"}, "projects": []string{}}, err: ErrNoProject},
+		{name: "empty string", projectsClaim: "projects", claims: Claims{"groups": []string{"city-ops"}, "projects": ""}, err: ErrNoProject},
+		{name: "admin without claim", projectsClaim: "projects", claims: Claims{"groups": []string{"city-admins"}}},
+		{name: "claim not configured", claims: Claims{"groups": []string{"city-ops"}}},
+		{name: "no role", projectsClaim: "projects", claims: Claims{"groups": []string{"visitors"}, "projects": "proj-1"}, err: ErrNoRole},
+	}
+	p := newProvider(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newVerifier(p, tt.projectsClaim).Verify(context.Background(), p.sign(t, tt.claims))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("erro %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if !slices.Equal(got.Projects, tt.want) {
+				t.Errorf("projects = %v, want %v", got.Projects, tt.want)
+			}
+		})
+	}
+}
+
+// TestSessionWithoutProjects confere que um JWT do serviço sem projetos,
+// como os emitidos antes de projects_claim ser configurado, também é
+// recusado.
+func TestSessionWithoutProjects(t *testing.T) {
+	p := newProvider(t)
+	v := newVerifier(p, "projects")
+	tests := []struct {
+		name      string
+		principal *auth.Principal
+		err       error
+	}{
+		{"operator with projects", &auth.Principal{Subject: "oidc:maria", Roles: []string{auth.RoleOperator}, Projects: []string{"proj-1"}}, nil},
+		{"operator without projects", &auth.Principal{Subject: "oidc:maria", Roles: []string{auth.RoleOperator}}, ErrNoProject},
+		{"admin without projects", &auth.Principal{Subject: "oidc:ana", Roles: []string{auth.RoleAdmin}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, _, err := v.issue(tt.principal)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := v.Verify(context.Background(), token); !errors.Is(err, tt.err) {
+				t.Errorf("erro %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+// TestMiddlewareRejectsTokenWithoutProjects confere que o token sem a claim
+// de projetos não chega às rotas com um principal sem restrição de projeto.
+func TestMiddlewareRejectsTokenWithoutProjects(t *testing.T) {
+	p := newProvider(t)
+	r := gin.New()
+	r.Use(Middleware(newVerifier(p, "projects")))
+	r.GET("/projects/:id", func(c *gin.Context) {
+		if !auth.FromGin(c).InProject(c.Param("id")) {
+			c.Status(http.StatusForbidden)
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+	tests := []struct {
+		name   string
+		claims Claims
+		status int
+	}{
+		{"own project", Claims{"groups": []string{"city-ops"}, "projects": []string{"proj-1"}}, http.StatusOK},
+		{"other project", Claims{"groups": []string{"city-ops"}, "projects": []string{"proj-2"}}, http.StatusForbidden},
+		{"without claim", Claims{"groups": []string{"city-ops"}}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/projects/proj-1", nil)
+			req.Header.Set("Authorization", "Bearer "+p.sign(t, tt.claims))
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Errorf("status %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+// Package openapi mantém a especificação OpenAPI 3 da API REST e serve o
+// documento e o Swagger UI. A especificação é mantida à mão em openapi.yaml;
+// Undocumented aponta rotas registradas que ainda não estão nela.
+package openapi
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+
+	"smart-city-microservices/internal/buildinfo"
+)
+
+//go:embed openapi.yaml
+var source []byte
+
+var (
+	once    sync.Once
+	doc     map[string]interface{}
+	docJSON []byte
+	docErr  error
+)
+
+func load() {
+	if docErr = yaml.Unmarshal(source, &doc); docErr != nil {
+		docErr = fmt.Errorf("openapi.yaml inválido: %w", docErr)
+		return
+	}
+	if info, ok := doc["info"].(map[string]interface{}); ok {
+		info["version"] = buildinfo.Version
+	}
+	docJSON, docErr = json.Marshal(doc)
+}
+
+// JSON retorna a especificação em JSON, com a versão do build em info.version.
+func JSON() ([]byte, error) {
+	once.Do(load)
+	return docJSON, docErr
+}
+
+// Handler serve a especificação em JSON.
+func Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, err := JSON()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+	}
+}
+
+// Undocumented retorna as rotas registradas no router que não constam na
+// especificação, no formato "MÉTODO /caminho".
+func Undocumented(routes gin.RoutesInfo) ([]string, error) {
+	if _, err := JSON(); err != nil {
+		return nil, err
+	}
+	paths, _ := doc["paths"].(map[string]interface{})
+
+	var missing []string
+	for _, r := range routes {
+		item, _ := paths[specPath(r.Path)].(map[string]interface{})
+		if _, ok := item[strings.ToLower(r.Method)]; !ok {
+			missing = append(missing, r.Method+" "+r.Path)
+		}
+	}
+	sort.Strings(missing)
+	return missing, nil
+}
+
+// specPath converte parâmetros do Gin (":id", "*path") para o formato OpenAPI ("{id}").
+func specPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if strings.HasPrefix(s, ":") || strings.HasPrefix(s, "*") {
+			segments[i] = "{" + s[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
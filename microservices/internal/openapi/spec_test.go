@@ -0,0 +1,152 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+var update = flag.Bool("update", false, "regrava testdata/openapi.json")
+
+// snapshot é a especificação servida, com info.version fixa para não
+// depender do build.
+func snapshot(t *testing.T) []byte {
+	t.Helper()
+	body, err := JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var spec map[string]interface{}
+	if err := json.Unmarshal(body, &spec); err != nil {
+		t.Fatal(err)
+	}
+	spec["info"].(map[string]interface{})["version"] = "snapshot"
+	out, err := json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(out, '\n')
+}
+
+// TestSpecSnapshot falha quando openapi.yaml muda sem que o snapshot
+// seja regravado (go test ./internal/openapi -update), para que a mudança
+// do contrato apareça no diff.
+func TestSpecSnapshot(t *testing.T) {
+	got := snapshot(t)
+	path := filepath.Join("testdata", "openapi.json")
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("especificação difere de testdata/openapi.json; rode go test ./internal/openapi -update e revise o diff")
+	}
+}
+
+// TestServiceRoutesDocumented lê as rotas registradas em
+// agent-service/main.go e falha se alguma não estiver na especificação.
+func TestServiceRoutesDocumented(t *testing.T) {
+	routes := sourceRoutes(t, filepath.Join("..", "..", "agent-service", "main.go"))
+	if len(routes) < 50 {
+		t.Fatalf("só %d rotas encontradas em main.go; o registro de rotas mudou de forma?", len(routes))
+	}
+	missing, err := Undocumented(routes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range missing {
+		t.Errorf("rota sem documentação: %s", r)
+	}
+}
+
+func TestUndocumented(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	noop := func(*gin.Context) {}
+	router.GET("/health", noop)
+	router.GET("/api/v1/agents/:id", noop)
+	router.GET("/api/v1/not-in-spec", noop)
+	router.DELETE("/api/v1/openapi.json", noop)
+
+	missing, err := Undocumented(router.Routes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"DELETE /api/v1/openapi.json", "GET /api/v1/not-in-spec"}
+	if strings.Join(missing, ",") != strings.Join(want, ",") {
+		t.Errorf("Undocumented = %v, want %v", missing, want)
+	}
+}
+
+var routeMethods = map[string]bool{"GET": true, "POST": true, "PUT": true, "PATCH": true, "DELETE": true}
+
+// sourceRoutes extrai do arquivo as chamadas x.GET("/caminho", ...) e
+// afins, resolvendo os prefixos de x := y.Group("/prefixo"). O router
+// raiz é qualquer receptor que não seja um grupo conhecido.
+func sourceRoutes(t *testing.T, file string) gin.RoutesInfo {
+	t.Helper()
+	f, err := parser.ParseFile(token.NewFileSet(), file, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefixes := map[string]string{}
+	var routes gin.RoutesInfo
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.AssignStmt:
+			if len(n.Lhs) != 1 || len(n.Rhs) != 1 {
+				return true
+			}
+			name, ok := n.Lhs[0].(*ast.Ident)
+			if !ok {
+				return true
+			}
+			if recv, method, path, ok := routeCall(n.Rhs[0]); ok && method == "Group" {
+				prefixes[name.Name] = prefixes[recv] + path
+			}
+		case *ast.CallExpr:
+			if recv, method, path, ok := routeCall(n); ok && routeMethods[method] {
+				routes = append(routes, gin.RouteInfo{Method: method, Path: prefixes[recv] + path})
+			}
+		}
+		return true
+	})
+	return routes
+}
+
+// routeCall reconhece recv.Method("literal", ...).
+func routeCall(e ast.Expr) (recv, method, path string, ok bool) {
+	call, isCall := e.(*ast.CallExpr)
+	if !isCall || len(call.Args) == 0 {
+		return "", "", "", false
+	}
+	sel, isSel := call.Fun.(*ast.SelectorExpr)
+	if !isSel {
+		return "", "", "", false
+	}
+	id, isIdent := sel.X.(*ast.Ident)
+	lit, isLit := call.Args[0].(*ast.BasicLit)
+	if !isIdent || !isLit || lit.Kind != token.STRING {
+		return "", "", "", false
+	}
+	path, err := strconv.Unquote(lit.Value)
+	if err != nil {
+		return "", "", "", false
+	}
+	return id.Name, sel.Sel.Name, path, true
+}
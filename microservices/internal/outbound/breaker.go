@@ -0,0 +1,89 @@
+package outbound
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen é o erro das chamadas recusadas com o circuito do destino
+// aberto.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// breakers guarda o circuito de cada destino (host:porta). Só destinos com
+// falhas recentes têm entrada: o primeiro sucesso a remove, para que o mapa
+// não cresça com os destinos saudáveis.
+type breakers struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*breaker
+}
+
+type breaker struct {
+	failures  int
+	openUntil time.Time
+	// probing marca a chamada de teste depois do cooldown; as demais
+	// continuam recusadas até ela terminar.
+	probing bool
+}
+
+func newBreakers(threshold int, cooldown time.Duration) *breakers {
+	return &breakers{threshold: threshold, cooldown: cooldown, hosts: make(map[string]*breaker)}
+}
+
+// allow diz se uma chamada ao destino pode seguir. Passado o cooldown, uma
+// única chamada passa como teste.
+func (b *breakers) allow(host string) bool {
+	if b.threshold <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	br, ok := b.hosts[host]
+	if !ok || br.failures < b.threshold {
+		return true
+	}
+	if br.probing || time.Now().Before(br.openUntil) {
+		return false
+	}
+	br.probing = true
+	return true
+}
+
+// record registra o resultado de uma chamada e diz se ele abriu o circuito.
+func (b *breakers) record(host string, ok bool) (opened bool) {
+	if b.threshold <= 0 {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ok {
+		delete(b.hosts, host)
+		return false
+	}
+	br := b.hosts[host]
+	if br == nil {
+		br = &breaker{}
+		b.hosts[host] = br
+	}
+	br.failures++
+	wasProbe := br.probing
+	br.probing = false
+	if br.failures < b.threshold {
+		return false
+	}
+	br.openUntil = time.Now().Add(b.cooldown)
+	return br.failures == b.threshold || wasProbe
+}
+
+// release libera a chamada de teste sem resultado (cancelada pelo
+// chamador), para que a próxima chamada teste o destino.
+func (b *breakers) release(host string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if br, ok := b.hosts[host]; ok {
+		br.probing = false
+	}
+}
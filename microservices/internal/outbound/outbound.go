@@ -0,0 +1,138 @@
+// Package outbound é o cliente HTTP das chamadas de saída do serviço
+// (webhooks, notificações, Vault): um pool de conexões compartilhado, com
+// novas tentativas para requisições idempotentes, circuit breaker por
+// destino, métricas e propagação do trace.
+package outbound
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// Config configura o pool e a proteção das chamadas.
+type Config struct {
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost limita as conexões abertas por destino; zero não limita.
+	MaxConnsPerHost       int
+	IdleConnTimeout       time.Duration
+	DialTimeout           time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+	// ProxyURL encaminha as chamadas pelo proxy; vazio conecta direto. Com
+	// proxy, a recusa de redes privadas vale para o endereço dele.
+	ProxyURL string
+
+	// MaxAttempts é o total de tentativas de uma requisição idempotente,
+	// com espera de RetryBackoff dobrando até RetryMaxBackoff.
+	MaxAttempts     int
+	RetryBackoff    time.Duration
+	RetryMaxBackoff time.Duration
+
+	// BreakerFailures é o número de falhas seguidas de um destino que abre o
+	// circuito; aberto, as chamadas a ele falham na hora por BreakerCooldown.
+	BreakerFailures int
+	BreakerCooldown time.Duration
+}
+
+// Options configura um cliente do pool.
+type Options struct {
+	// Name identifica o cliente nas métricas.
+	Name string
+	// Timeout limita a chamada inteira, incluindo as novas tentativas.
+	Timeout time.Duration
+	// AllowPrivateNetworks permite destinos em loopback e redes privadas;
+	// sem ele, são recusados na conexão.
+	AllowPrivateNetworks bool
+	// NoRedirects devolve a resposta de redirecionamento em vez de segui-la.
+	NoRedirects bool
+}
+
+// Pool guarda os transports compartilhados pelos clientes: um para destinos
+// quaisquer e outro que recusa redes privadas. Os breakers são por destino e
+// valem para todos os clientes.
+type Pool struct {
+	cfg        Config
+	open       *http.Transport
+	restricted *http.Transport
+	breakers   *breakers
+}
+
+// NewPool cria o pool.
+func NewPool(cfg Config) (*Pool, error) {
+	var proxy func(*http.Request) (*url.URL, error)
+	if cfg.ProxyURL != "" {
+		u, err := url.Parse(cfg.ProxyURL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("proxy inválido %q", cfg.ProxyURL)
+		}
+		proxy = http.ProxyURL(u)
+	}
+	return &Pool{
+		cfg:        cfg,
+		open:       newTransport(cfg, proxy, nil),
+		restricted: newTransport(cfg, proxy, RejectPrivate),
+		breakers:   newBreakers(cfg.BreakerFailures, cfg.BreakerCooldown),
+	}, nil
+}
+
+func newTransport(cfg Config, proxy func(*http.Request) (*url.URL, error), control func(string, string, syscall.RawConn) error) *http.Transport {
+	dialer := &net.Dialer{Timeout: cfg.DialTimeout, KeepAlive: 30 * time.Second, Control: control}
+	return &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       cfg.MaxConnsPerHost,
+		IdleConnTimeout:       cfg.IdleConnTimeout,
+		TLSHandshakeTimeout:   cfg.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: cfg.ResponseHeaderTimeout,
+		ExpectContinueTimeout: time.Second,
+	}
+}
+
+// Client retorna um cliente sobre o pool. Clientes são baratos: cada
+// chamador cria o seu com as próprias opções.
+func (p *Pool) Client(opts Options) *http.Client {
+	base := p.open
+	if !opts.AllowPrivateNetworks {
+		base = p.restricted
+	}
+	c := &http.Client{
+		Timeout:   opts.Timeout,
+		Transport: &transport{pool: p, base: base, name: opts.Name},
+	}
+	if opts.NoRedirects {
+		c.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	}
+	return c
+}
+
+// CloseIdle fecha as conexões ociosas do pool.
+func (p *Pool) CloseIdle() {
+	p.open.CloseIdleConnections()
+	p.restricted.CloseIdleConnections()
+}
+
+// ErrPrivateDestination é o erro das conexões recusadas por RejectPrivate.
+var ErrPrivateDestination = errors.New("destination is not allowed")
+
+// RejectPrivate impede conexões a loopback, redes privadas e link-local,
+// evitando que destinos cadastrados por usuários alcancem a rede interna.
+func RejectPrivate(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("%w: %s", ErrPrivateDestination, host)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package outbound
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"smart-city-microservices/internal/logging"
+)
+
+// HeaderIdempotencyKey marca uma requisição não idempotente (POST) como
+// segura para repetir.
+const HeaderIdempotencyKey = "Idempotency-Key"
+
+var (
+	requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "outbound_requests_total",
+		Help:      "Tentativas de chamadas HTTP de saída, por cliente e resultado (2xx, 3xx, 4xx, 5xx, error, circuit_open).",
+	}, []string{"client", "result"})
+
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "agent_service",
+		Name:      "outbound_request_duration_seconds",
+		Help:      "Duração de cada tentativa de chamada HTTP de saída, por cliente.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"client"})
+
+	retries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "outbound_retries_total",
+		Help:      "Novas tentativas de chamadas HTTP de saída idempotentes, por cliente.",
+	}, []string{"client"})
+
+	circuitOpens = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "outbound_circuit_opens_total",
+		Help:      "Aberturas do circuito de um destino após falhas seguidas, pelo cliente da chamada que o abriu.",
+	}, []string{"client"})
+)
+
+// transport aplica o breaker, as novas tentativas, as métricas e o trace
+// sobre o transport compartilhado.
+type transport struct {
+	pool *Pool
+	base *http.Transport
+	name string
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	req = withTrace(req)
+	host := req.URL.Host
+	cfg := t.pool.cfg
+
+	attempts := 1
+	if retryable(req) {
+		attempts = max(cfg.MaxAttempts, 1)
+	}
+	backoff := cfg.RetryBackoff
+	for attempt := 1; ; attempt++ {
+		resp, err := t.once(req, host)
+		if attempt >= attempts || !shouldRetry(resp, err) || ctx.Err() != nil {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+		if req.Body != nil && req.Body != http.NoBody {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(ctx)
+			req.Body = body
+		}
+		retries.WithLabelValues(t.name).Inc()
+		if err := sleep(ctx, jitter(backoff)); err != nil {
+			return nil, err
+		}
+		backoff = min(2*backoff, cfg.RetryMaxBackoff)
+	}
+}
+
+// once faz uma tentativa, se o circuito do destino permitir.
+func (t *transport) once(req *http.Request, host string) (*http.Response, error) {
+	breakers := t.pool.breakers
+	if !breakers.allow(host) {
+		requests.WithLabelValues(t.name, "circuit_open").Inc()
+		return nil, fmt.Errorf("%w: %s", ErrCircuitOpen, host)
+	}
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	requestDuration.WithLabelValues(t.name).Observe(time.Since(start).Seconds())
+	if err != nil {
+		requests.WithLabelValues(t.name, "error").Inc()
+		if errors.Is(req.Context().Err(), context.Canceled) || errors.Is(err, ErrPrivateDestination) {
+			// Cancelada pelo chamador ou recusada aqui: não diz nada sobre o
+			// destino. O prazo vencido conta como falha.
+			breakers.release(host)
+			return nil, err
+		}
+	} else {
+		requests.WithLabelValues(t.name, strconv.Itoa(resp.StatusCode/100)+"xx").Inc()
+	}
+	if breakers.record(host, err == nil && resp.StatusCode < 500) {
+		circuitOpens.WithLabelValues(t.name).Inc()
+	}
+	return resp, err
+}
+
+// retryable diz se a requisição pode ser repetida: métodos idempotentes ou
+// com Idempotency-Key, e com corpo que possa ser lido de novo.
+func retryable(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+	default:
+		if req.Header.Get(HeaderIdempotencyKey) == "" {
+			return false
+		}
+	}
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// shouldRetry repete falhas de conexão e respostas de indisponibilidade.
+// O circuito aberto não é repetido, porque só fecha depois do cooldown, nem
+// o destino recusado.
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, ErrCircuitOpen) && !errors.Is(err, ErrPrivateDestination)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// withTrace propaga o trace_id e o request_id do contexto, sem sobrescrever
+// cabeçalhos definidos pelo chamador. Cada chamada é um novo span do trace.
+func withTrace(req *http.Request) *http.Request {
+	ctx := req.Context()
+	traceID, requestID := logging.TraceID(ctx), logging.RequestID(ctx)
+	setTrace := traceID != "" && req.Header.Get(logging.HeaderTraceParent) == ""
+	setRequest := requestID != "" && req.Header.Get(logging.HeaderRequestID) == ""
+	if !setTrace && !setRequest {
+		return req
+	}
+	// RoundTrip não pode alterar a requisição recebida.
+	req = req.Clone(ctx)
+	if setTrace {
+		// O parent-id é a metade de um id novo, com os 16 dígitos exigidos.
+		req.Header.Set(logging.HeaderTraceParent, "00-"+traceID+"-"+logging.NewTraceID()[:16]+"-01")
+	}
+	if setRequest {
+		req.Header.Set(logging.HeaderRequestID, requestID)
+	}
+	return req
+}
+
+// jitter soma até 20% à espera.
+func jitter(d time.Duration) time.Duration {
+	if j := int64(d) / 5; j > 0 {
+		d += time.Duration(rand.Int63n(j))
+	}
+	return d
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
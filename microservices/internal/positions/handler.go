@@ -0,0 +1,207 @@
+package positions
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/logging"
+)
+
+// Limites da conexão: o cliente só manda mensagens de controle, e um ping
+// a cada pingPeriod detecta quem sumiu sem fechar.
+const (
+	writeWait      = 10 * time.Second
+	pongWait       = 60 * time.Second
+	pingPeriod     = pongWait * 9 / 10
+	maxControlSize = 1024
+)
+
+// upgrader aceita qualquer Origin: o middleware CORS do router já recusou
+// as origens não permitidas antes do handler.
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  maxControlSize,
+	WriteBufferSize: 4096,
+	CheckOrigin:     func(*http.Request) bool { return true },
+}
+
+// control é uma mensagem do cliente. Versions e Features só valem no
+// hello; Action, Topic e Fields, na inscrição com máscara de campos.
+type control struct {
+	Type     string   `json:"type"`
+	Versions []int    `json:"versions"`
+	Features []string `json:"features"`
+	Action   string   `json:"action"`
+	Topic    string   `json:"topic"`
+	Fields   []string `json:"fields"`
+}
+
+// Stream responde GET /simulations/:id/positions/stream: abre o websocket
+// do tópico simulation:<id>:positions, envia o snapshot e, depois, deltas e
+// keyframes. {"type":"resync"} pede um snapshot novo, e
+// {"action":"subscribe"} restringe os campos dos agentes (ver mask.go). A
+// versão do protocolo é negociada por ?protocol ou pelo hello (ver
+// MinVersion).
+func (s *Streamer) Stream(c *gin.Context) {
+	ctx := c.Request.Context()
+	simulationID := c.Param("id")
+	if _, err := s.agents.GetSimulation(ctx, simulationID); err != nil {
+		if errors.Is(err, agent.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "simulation not found"})
+			return
+		}
+		logging.FromContext(ctx).WithError(err).Error("Erro no streaming de posições")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+	enc, enabled, byQuery, negotiateErr := fromQuery(c.Query("protocol"), c.Query("features"))
+	// Em caso de erro o Upgrade já respondeu ao cliente.
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+	if negotiateErr != nil {
+		reject(conn, negotiateErr)
+		conn.Close()
+		return
+	}
+
+	sub := &subscriber{conn: conn, out: make(chan outgoing, s.cfg.SendBuffer), done: make(chan struct{}), enc: enc}
+	if byQuery {
+		negotiated.WithLabelValues(strconv.Itoa(enc.version)).Inc()
+		if enc.version > 1 {
+			sub.send(FrameWelcome, websocket.TextMessage, enc.welcome(enabled))
+		}
+	}
+	s.subscribe(ctx, simulationID, sub)
+	defer s.unsubscribe(simulationID, sub)
+	go sub.write()
+
+	conn.SetReadLimit(maxControlSize)
+	_ = conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	log := logging.FromContext(ctx).WithField("simulation_id", simulationID)
+	// O hello só vale como primeira mensagem e sem ?protocol.
+	first := !byQuery
+	defer func() {
+		if first {
+			negotiated.WithLabelValues("1").Inc()
+		}
+	}()
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			sub.close()
+			return
+		}
+		var m control
+		err = json.Unmarshal(msg, &m)
+		hello := first && err == nil && m.Type == "hello"
+		if first && !hello {
+			negotiated.WithLabelValues("1").Inc()
+		}
+		first = false
+		switch {
+		case hello:
+			enc, enabled, err := negotiate(m.Versions, m.Features)
+			if err != nil {
+				negotiated.WithLabelValues("rejected").Inc()
+				reject(conn, err)
+				sub.close()
+				return
+			}
+			negotiated.WithLabelValues(strconv.Itoa(enc.version)).Inc()
+			s.switchEncoding(sub, enc, enabled)
+		case err == nil && m.Type == "resync":
+			s.requestResync(sub)
+		case err == nil && m.Action == "subscribe":
+			s.setMask(sub, simulationID, m.Topic, m.Fields)
+		default:
+			log.WithField("message", string(msg)).Debug("Mensagem de controle ignorada no streaming de posições")
+		}
+	}
+}
+
+// reject fecha a conexão com 1002 (erro de protocolo) e o motivo, que o
+// websocket limita a 123 bytes.
+func reject(conn *websocket.Conn, err error) {
+	reason := err.Error()
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	_ = conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseProtocolError, reason), time.Now().Add(writeWait))
+}
+
+// subscriber é uma conexão inscrita. resync, enc e mask são protegidos
+// pelo mutex do Streamer: com resync, o próximo flush manda um snapshot em
+// vez do delta; enc é a codificação dos quadros do inscrito e mask, os
+// campos dos agentes que ele pediu.
+type subscriber struct {
+	conn   *websocket.Conn
+	out    chan outgoing
+	resync bool
+	enc    encoding
+	mask   fieldMask
+
+	once sync.Once
+	done chan struct{}
+}
+
+// outgoing é uma mensagem à espera de envio.
+type outgoing struct {
+	messageType int
+	body        []byte
+}
+
+// send enfileira um quadro sem bloquear. Com a fila cheia o quadro é
+// descartado, retornando false, e o inscrito recebe um snapshot no
+// próximo flush, em vez de deltas que não se aplicariam.
+func (sub *subscriber) send(kind string, messageType int, body []byte) bool {
+	select {
+	case sub.out <- outgoing{messageType: messageType, body: body}:
+		framesSent.WithLabelValues(kind).Inc()
+		return true
+	default:
+		dropped.WithLabelValues("frame").Inc()
+		sub.resync = true
+		return false
+	}
+}
+
+func (sub *subscriber) close() {
+	sub.once.Do(func() { close(sub.done) })
+}
+
+// write envia os quadros e os pings até a conexão ser fechada.
+func (sub *subscriber) write() {
+	ping := time.NewTicker(pingPeriod)
+	defer ping.Stop()
+	defer sub.conn.Close()
+	for {
+		select {
+		case <-sub.done:
+			_ = sub.conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(writeWait))
+			return
+		case m := <-sub.out:
+			_ = sub.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := sub.conn.WriteMessage(m.messageType, m.body); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := sub.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package positions
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Máscaras de campos. O cliente que só precisa de parte de cada agente
+// (um mapa com id, lat, lon e heading) manda
+// {"action":"subscribe","topic":"simulation:<id>:positions","fields":["id","lat","lon","heading"]}
+// e os agentes dos quadros seguintes trazem só esses campos; o id sempre
+// vai, para os deltas continuarem aplicáveis. A inscrição é confirmada com
+// um quadro Subscribed, que lista os campos na ordem das listas compactas
+// da versão 2, seguido de um snapshot novo; fields vazio volta ao agente
+// inteiro. Um campo desconhecido, ou outro tópico, responde um
+// SubscriptionError com os campos válidos e mantém a máscara anterior.
+// Os dois quadros vão sempre em JSON, como o Welcome.
+
+// agentFields são os campos de AgentPosition, na ordem das listas compactas
+// da versão 2.
+var agentFields = []string{"id", "status", "lat", "lon", "heading", "speed"}
+
+// Tipos dos quadros de resposta à inscrição.
+const (
+	FrameSubscribed        = "subscribed"
+	FrameSubscriptionError = "error"
+)
+
+// fieldMask marca os campos pedidos, um bit por posição de agentFields; 0 é
+// o agente inteiro. É comparável e entra na chave dos quadros serializados,
+// que são reaproveitados por todos os inscritos com a mesma máscara.
+type fieldMask uint8
+
+// Subscribed confirma a máscara de uma inscrição.
+type Subscribed struct {
+	Type   string   `json:"type"`
+	Topic  string   `json:"topic"`
+	Fields []string `json:"fields"`
+}
+
+// SubscriptionError recusa uma inscrição.
+type SubscriptionError struct {
+	Type          string   `json:"type"`
+	Topic         string   `json:"topic"`
+	Error         string   `json:"error"`
+	InvalidFields []string `json:"invalid_fields,omitempty"`
+	ValidFields   []string `json:"valid_fields"`
+}
+
+// parseMask monta a máscara dos campos pedidos; invalid lista os
+// desconhecidos.
+func parseMask(fields []string) (m fieldMask, invalid []string) {
+	for _, f := range fields {
+		i := fieldIndex(f)
+		if i < 0 {
+			invalid = append(invalid, f)
+			continue
+		}
+		m |= 1 << i
+	}
+	if len(invalid) > 0 {
+		return 0, invalid
+	}
+	if m != 0 {
+		m |= 1 << fieldIndex("id")
+	}
+	return m, nil
+}
+
+func fieldIndex(name string) int {
+	for i, f := range agentFields {
+		if f == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// has diz se o campo i de agentFields entra na máscara.
+func (m fieldMask) has(i int) bool {
+	return m == 0 || m&(1<<i) != 0
+}
+
+// names são os campos da máscara, na ordem de agentFields.
+func (m fieldMask) names() []string {
+	out := make([]string, 0, len(agentFields))
+	for i, f := range agentFields {
+		if m.has(i) {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// values são os campos de p na máscara, na ordem de agentFields.
+func (m fieldMask) values(p AgentPosition) []interface{} {
+	all := [...]interface{}{p.ID, p.Status, p.Lat, p.Lon, p.Heading, p.Speed}
+	out := make([]interface{}, 0, len(all))
+	for i, v := range all {
+		if m.has(i) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// object é p só com os campos da máscara, para a versão 1.
+func (m fieldMask) object(p AgentPosition) map[string]interface{} {
+	names, values := m.names(), m.values(p)
+	out := make(map[string]interface{}, len(names))
+	for i, name := range names {
+		out[name] = values[i]
+	}
+	return out
+}
+
+// maskedFrame é o Frame da versão 1 com os agentes mascarados.
+type maskedFrame struct {
+	Type    string                   `json:"type"`
+	Topic   string                   `json:"topic"`
+	Seq     uint64                   `json:"seq"`
+	Time    time.Time                `json:"time"`
+	Agents  []map[string]interface{} `json:"agents"`
+	Removed []string                 `json:"removed,omitempty"`
+}
+
+func subscribed(topic string, m fieldMask) []byte {
+	body, _ := json.Marshal(Subscribed{Type: FrameSubscribed, Topic: topic, Fields: m.names()})
+	return body
+}
+
+func subscriptionError(topic, reason string, invalid []string) []byte {
+	body, _ := json.Marshal(SubscriptionError{Type: FrameSubscriptionError, Topic: topic, Error: reason,
+		InvalidFields: invalid, ValidFields: agentFields})
+	return body
+}
+
+// invalidFieldsReason é a mensagem do SubscriptionError de campos
+// desconhecidos.
+func invalidFieldsReason(invalid []string) string {
+	return "unknown fields in mask: " + strings.Join(invalid, ", ") + " (valid fields: " + strings.Join(agentFields, ", ") + ")"
+}
@@ -0,0 +1,462 @@
+package positions
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/ugorji/go/codec"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/events"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+const benchSimulation = "sim-bench"
+
+// benchStreamer monta o streaming com uma simulação já carregada com
+// agents agentes e subs inscritos, todos em dia com as posições.
+func benchStreamer(agents, subs int, enc encoding) (*Streamer, *simulation) {
+	s := NewStreamer(nil, Config{
+		DistanceThreshold: 1,
+		HeadingThreshold:  5,
+		FlushInterval:     time.Second,
+		KeyframeInterval:  time.Minute,
+		QueueSize:         agents,
+		SendBuffer:        4,
+	})
+	sim := &simulation{
+		id:      benchSimulation,
+		current: map[string]AgentPosition{},
+		subs:    map[*subscriber]struct{}{},
+		loaded:  true,
+	}
+	for i := 0; i < agents; i++ {
+		id := fmt.Sprintf("agent-%05d", i)
+		sim.current[id] = AgentPosition{ID: id, Status: "active", Lat: -23.55, Lon: -46.63 + float64(i)*1e-4, Heading: 90, Speed: 10}
+	}
+	sim.sent = copyPositions(sim.current)
+	for i := 0; i < subs; i++ {
+		sim.subs[&subscriber{out: make(chan outgoing, 4), done: make(chan struct{}), enc: enc}] = struct{}{}
+	}
+	s.sims[benchSimulation] = sim
+	return s, sim
+}
+
+// drain esvazia as filas dos inscritos, o papel de sub.write.
+func drain(sim *simulation) (frames int) {
+	for sub := range sim.subs {
+		for {
+			select {
+			case <-sub.out:
+				frames++
+				continue
+			default:
+			}
+			break
+		}
+	}
+	return frames
+}
+
+// moved são os eventos agent.updated do tick-ésimo tick: cada agente está
+// tick*11 m ao norte da posição inicial, o bastante para entrar no delta.
+func moved(sim *simulation, tick int) []events.Event {
+	out := make([]events.Event, 0, len(sim.current))
+	at := time.Unix(int64(tick), 0).UTC()
+	for id, p := range sim.current {
+		out = append(out, events.Event{
+			Type:  "agent.updated",
+			Topic: events.TopicAgents,
+			Data: events.AgentV1{
+				ID: id, SimulationID: benchSimulation, Status: p.Status,
+				Position:  events.PositionV1{Lat: p.Lat + float64(tick)*1e-4, Lon: p.Lon, Heading: p.Heading, Speed: p.Speed},
+				UpdatedAt: at,
+			},
+		})
+	}
+	return out
+}
+
+// BenchmarkStreamerTick10kAgents é um tick do streamer numa simulação com
+// 10 mil agentes: os eventos agent.updated de todos eles aplicados e o
+// delta enviado. O tick dos comportamentos é medido em behavior.
+func BenchmarkStreamerTick10kAgents(b *testing.B) {
+	s, sim := benchStreamer(10000, 1, legacy)
+	ctx := context.Background()
+	ticks := make([][]events.Event, 0, b.N)
+	for i := 0; i < b.N; i++ {
+		ticks = append(ticks, moved(sim, i+1))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, e := range ticks[i] {
+			if err := s.apply(e); err != nil {
+				b.Fatal(err)
+			}
+		}
+		s.flush(ctx)
+		if drain(sim) != 1 {
+			b.Fatal("delta não enviado")
+		}
+	}
+}
+
+// BenchmarkStreamerFlush1kSubscribers envia o delta de 10 mil agentes a
+// mil inscritos do streamer, metade em JSON v1 e metade em MessagePack v2:
+// cada forma é serializada uma vez e compartilhada.
+func BenchmarkStreamerFlush1kSubscribers(b *testing.B) {
+	for _, tc := range []struct {
+		name  string
+		mixed bool
+	}{{"json", false}, {"mixed", true}} {
+		b.Run(tc.name, func(b *testing.B) {
+			s, sim := benchStreamer(10000, 1000, legacy)
+			if tc.mixed {
+				n := 0
+				for sub := range sim.subs {
+					if n%2 == 0 {
+						sub.enc = encoding{version: MaxVersion, msgpack: true}
+					}
+					n++
+				}
+			}
+			ctx := context.Background()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				for id, p := range sim.current {
+					p.Lat += 1e-4
+					sim.current[id] = p
+				}
+				b.StartTimer()
+				s.flush(ctx)
+				if frames := drain(sim); frames != len(sim.subs) {
+					b.Fatalf("%d quadros para %d inscritos", frames, len(sim.subs))
+				}
+			}
+		})
+	}
+}
+
+// v1Frame é um delta como a versão 1 sempre o serializou: é o formato dos
+// clientes que não negociam, e não pode mudar.
+const v1Frame = `{"type":"delta","topic":"simulation:sim-1:positions","seq":7,"time":"2026-02-10T14:30:00Z",` +
+	`"agents":[{"id":"bus-1","status":"moving","lat":-23.5505,"lon":-46.6333,"heading":90,"speed":12.5}],"removed":["bus-9"]}`
+
+// TestVersion1Frame confere os bytes dos quadros da versão 1, inclusive
+// quando o mesmo quadro também é serializado para inscritos da versão 2.
+func TestVersion1Frame(t *testing.T) {
+	f := &Frame{
+		Type: FrameDelta, Topic: Topic("sim-1"), Seq: 7, Time: time.Date(2026, 2, 10, 14, 30, 0, 0, time.UTC),
+		Agents:  []AgentPosition{{ID: "bus-1", Status: "moving", Lat: -23.5505, Lon: -46.6333, Heading: 90, Speed: 12.5}},
+		Removed: []string{"bus-9"},
+	}
+	fb := newFrameBodies(f)
+	for _, enc := range []encoding{{version: 2}, {version: 2, msgpack: true}} {
+		if _, err := fb.body(enc, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	body, err := fb.body(legacy, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != v1Frame {
+		t.Errorf("quadro v1\n%s\nwant\n%s", body, v1Frame)
+	}
+	if legacy.messageType() != websocket.TextMessage {
+		t.Errorf("tipo de mensagem %d na versão 1", legacy.messageType())
+	}
+
+	m, _ := parseMask([]string{"lat", "lon"})
+	body, err = fb.body(legacy, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"type":"delta","topic":"simulation:sim-1:positions","seq":7,"time":"2026-02-10T14:30:00Z",` +
+		`"agents":[{"id":"bus-1","lat":-23.5505,"lon":-46.6333}],"removed":["bus-9"]}`
+	if string(body) != want {
+		t.Errorf("quadro v1 mascarado\n%s\nwant\n%s", body, want)
+	}
+}
+
+// TestNegotiate confere a escolha da versão pela query e pelo hello: sem
+// negociação, ou oferecendo só a 1, o cliente fica na versão 1 e sem
+// recursos.
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    []string
+		versions []int
+		features []string
+		want     encoding
+		enabled  []string
+		err      string
+	}{
+		{name: "no query", query: []string{"", ""}, want: legacy},
+		{name: "query 1", query: []string{"1", ""}, want: legacy, enabled: []string{}},
+		{name: "query 1 with msgpack", query: []string{"1", "msgpack"}, want: legacy, enabled: []string{}},
+		{name: "query 2 with msgpack", query: []string{"2", " msgpack,,zstd"}, want: encoding{version: 2, msgpack: true}, enabled: []string{"msgpack"}},
+		{name: "query 0", query: []string{"0", ""}, err: `unsupported protocol version "0" (supported versions 1-2)`},
+		{name: "query not a number", query: []string{"v1", ""}, err: `unsupported protocol version "v1"`},
+		{name: "hello 1", versions: []int{1}, want: legacy, enabled: []string{}},
+		{name: "hello 1 with msgpack", versions: []int{1}, features: []string{"msgpack"}, want: legacy, enabled: []string{}},
+		{name: "hello 1 and 3", versions: []int{3, 1}, want: legacy, enabled: []string{}},
+		{name: "hello 1 and 2", versions: []int{1, 2}, features: []string{"msgpack", "msgpack"}, want: encoding{version: 2, msgpack: true}, enabled: []string{"msgpack"}},
+		{name: "hello without versions", err: "no supported protocol version offered in [] (supported versions 1-2)"},
+		{name: "hello 3", versions: []int{3}, err: "no supported protocol version offered in [3]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				enc     encoding
+				enabled []string
+				err     error
+			)
+			if tt.query != nil {
+				enc, enabled, _, err = fromQuery(tt.query[0], tt.query[1])
+			} else {
+				enc, enabled, err = negotiate(tt.versions, tt.features)
+			}
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("erro %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if enc != tt.want || !reflect.DeepEqual(enabled, tt.enabled) {
+				t.Errorf("negociado %+v %v, want %+v %v", enc, enabled, tt.want, tt.enabled)
+			}
+		})
+	}
+}
+
+// fakeAgents é uma simulação sim-1 com dois agentes.
+type fakeAgents struct{}
+
+func (fakeAgents) ListAgents(context.Context, agent.Filter) ([]agent.Agent, int, error) {
+	agents := []agent.Agent{
+		{ID: "bus-1", Status: "moving", Position: agent.Position{Lat: -23.5505, Lon: -46.6333, Heading: 90, Speed: 12.5}},
+		{ID: "bus-2", Status: "active", Position: agent.Position{Lat: -23.56, Lon: -46.64}},
+	}
+	return agents, len(agents), nil
+}
+
+func (fakeAgents) GetSimulation(_ context.Context, id string) (*agent.Simulation, error) {
+	if id != "sim-1" {
+		return nil, agent.ErrNotFound
+	}
+	return &agent.Simulation{ID: id}, nil
+}
+
+// dial abre o streaming de sim-1 com a query dada.
+func dial(t *testing.T, query string) *websocket.Conn {
+	t.Helper()
+	s := NewStreamer(fakeAgents{}, Config{
+		DistanceThreshold: 1,
+		HeadingThreshold:  5,
+		FlushInterval:     10 * time.Millisecond,
+		KeyframeInterval:  time.Hour,
+		QueueSize:         16,
+		SendBuffer:        16,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	go s.Run(ctx)
+	r := gin.New()
+	// Sem gin.Recovery, o pânico no handler chegaria ao net/http, que o
+	// registra e segue com a conexão sequestrada aberta.
+	r.Use(func(c *gin.Context) {
+		defer func() {
+			if p := recover(); p != nil {
+				t.Errorf("pânico no streaming: %v\n%s", p, debug.Stack())
+			}
+		}()
+		c.Next()
+	})
+	r.GET("/simulations/:id/positions/stream", s.Stream)
+	srv := httptest.NewServer(r)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/simulations/sim-1/positions/stream?"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		cancel()
+		srv.Close()
+	})
+	return conn
+}
+
+// TestVersion1Compatibility conecta clientes que ficam na versão 1 (sem
+// negociar, com ?protocol=1 ou com um hello que só oferece a 1) e confere
+// que todos os quadros que eles recebem têm a forma da versão 1: texto,
+// sem o campo v e com os agentes como objetos. O hello fora da primeira
+// mensagem é ignorado.
+func TestVersion1Compatibility(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		send  []string
+		// welcome é o Welcome esperado; nil, nenhum.
+		welcome *Welcome
+	}{
+		{name: "without negotiation"},
+		{name: "query", query: "protocol=1"},
+		{name: "query with msgpack", query: "protocol=1&features=msgpack"},
+		{
+			name: "hello", send: []string{`{"type":"hello","versions":[1],"features":["msgpack"]}`},
+			welcome: &Welcome{Type: FrameWelcome, Version: 1, Features: []string{}, Supported: [2]int{1, 2}},
+		},
+		{name: "hello after another message", send: []string{`{"type":"resync"}`, `{"type":"hello","versions":[2],"features":["msgpack"]}`, `{"type":"resync"}`}},
+		{name: "hello after query", query: "protocol=1", send: []string{`{"type":"hello","versions":[2]}`, `{"type":"resync"}`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := dial(t, tt.query)
+			for _, m := range tt.send {
+				if err := conn.WriteMessage(websocket.TextMessage, []byte(m)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var welcome *Welcome
+			snapshots := 0
+			// Os quadros chegam até o streaming ficar quieto.
+			for {
+				_ = conn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+				kind, msg, err := conn.ReadMessage()
+				if err != nil {
+					break
+				}
+				if kind != websocket.TextMessage {
+					t.Fatalf("mensagem de tipo %d: %x", kind, msg)
+				}
+				var frame map[string]json.RawMessage
+				if err := json.Unmarshal(msg, &frame); err != nil {
+					t.Fatalf("quadro %s: %v", msg, err)
+				}
+				if string(frame["type"]) == `"welcome"` {
+					if welcome != nil {
+						t.Fatalf("segundo Welcome: %s", msg)
+					}
+					welcome = new(Welcome)
+					json.Unmarshal(msg, welcome)
+					snapshots = 0
+					continue
+				}
+				checkV1(t, msg)
+				if string(frame["type"]) == `"snapshot"` {
+					snapshots++
+				}
+			}
+			if !reflect.DeepEqual(welcome, tt.welcome) {
+				t.Errorf("Welcome %+v, want %+v", welcome, tt.welcome)
+			}
+			if snapshots == 0 {
+				t.Error("nenhum snapshot depois da negociação")
+			}
+		})
+	}
+}
+
+// checkV1 confere que msg é um quadro da versão 1 com os agentes de
+// fakeAgents inteiros.
+func checkV1(t *testing.T, msg []byte) {
+	t.Helper()
+	var frame map[string]json.RawMessage
+	if err := json.Unmarshal(msg, &frame); err != nil {
+		t.Fatal(err)
+	}
+	keys := make([]string, 0, len(frame))
+	for k := range frame {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	if want := []string{"agents", "seq", "time", "topic", "type"}; !slices.Equal(keys, want) {
+		t.Errorf("campos do quadro %v, want %v: %s", keys, want, msg)
+	}
+	var agents []AgentPosition
+	if err := json.Unmarshal(frame["agents"], &agents); err != nil {
+		t.Fatalf("agentes %s: %v", frame["agents"], err)
+	}
+	slices.SortFunc(agents, func(a, b AgentPosition) int { return strings.Compare(a.ID, b.ID) })
+	want := []AgentPosition{
+		{ID: "bus-1", Status: "moving", Lat: -23.5505, Lon: -46.6333, Heading: 90, Speed: 12.5},
+		{ID: "bus-2", Status: "active", Lat: -23.56, Lon: -46.64},
+	}
+	if !reflect.DeepEqual(agents, want) {
+		t.Errorf("agentes %+v, want %+v", agents, want)
+	}
+}
+
+// FuzzStream manda ao streaming duas mensagens de controle arbitrárias
+// (hello, resync, inscrição com máscara ou lixo), depois da negociação
+// por query, e fecha a conexão. O handler não pode entrar em pânico, os
+// quadros que ele manda têm de ser legíveis e a conexão tem de terminar
+// com um fechamento: o eco do cliente, o 1001 de sub.write, o 1002 da
+// negociação recusada ou o 1009 da mensagem acima de maxControlSize.
+func FuzzStream(f *testing.F) {
+	for _, seed := range []struct{ query, first, second string }{
+		{"", `{"type":"hello","versions":[2],"features":["msgpack"]}`, `{"type":"resync"}`},
+		{"", `{"type":"hello","versions":[1,2,3]}`, `{"action":"subscribe","topic":"simulation:sim-1:positions","fields":["lat","lon"]}`},
+		{"", `{"type":"hello","versions":[]}`, ``},
+		{"", `{"type":"hello","versions":[2],"features":["gzip"]}`, ``},
+		{"protocol=2", `{"action":"subscribe","topic":"simulation:sim-1:positions","fields":["id","nope"]}`, `{"action":"subscribe","topic":"simulation:sim-2:positions","fields":[]}`},
+		{"protocol=2&features=msgpack", `{"action":"subscribe","topic":"simulation:sim-1:positions","fields":null}`, `{"type":"resync"}`},
+		{"protocol=9", `{"type":"resync"}`, ``},
+		{"protocol=x&features=,,", `null`, `[]`},
+		{"", `{"type":"hello","versions":[-1,9223372036854775807]}`, `{"type":"hello"}`},
+		{"", strings.Repeat("x", maxControlSize+1), ``},
+	} {
+		f.Add(seed.query, []byte(seed.first), []byte(seed.second))
+	}
+	f.Fuzz(func(t *testing.T, query string, first, second []byte) {
+		conn := dial(t, url.PathEscape(query))
+		for _, msg := range [][]byte{first, second} {
+			// Depois de uma recusa a escrita falha; o fechamento é lido abaixo.
+			_ = conn.WriteMessage(websocket.TextMessage, msg)
+		}
+		_ = conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for {
+			kind, msg, err := conn.ReadMessage()
+			if err != nil {
+				if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway,
+					websocket.CloseProtocolError, websocket.CloseMessageTooBig) {
+					t.Fatalf("conexão terminou sem fechamento: %v", err)
+				}
+				return
+			}
+			switch kind {
+			case websocket.TextMessage:
+				if !json.Valid(msg) {
+					t.Fatalf("quadro de texto inválido: %q", msg)
+				}
+			case websocket.BinaryMessage:
+				var frame map[string]interface{}
+				if err := codec.NewDecoderBytes(msg, msgpackHandle).Decode(&frame); err != nil {
+					t.Fatalf("quadro binário %x: %v", msg, err)
+				}
+			}
+		}
+	})
+}
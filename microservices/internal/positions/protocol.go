@@ -0,0 +1,209 @@
+package positions
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/ugorji/go/codec"
+)
+
+// Versões do protocolo. A 1 é a original: quadros Frame em JSON, sem
+// negociação. A 2 começa com um quadro Welcome e manda os agentes como
+// listas compactas [id, status, lat, lon, heading, speed].
+//
+// O cliente escolhe a versão com ?protocol=N (e os recursos com
+// ?features=a,b) ou com uma primeira mensagem
+// {"type":"hello","versions":[1,2],"features":["msgpack"]}, respondida com
+// o Welcome e um snapshot novo na versão escolhida; os quadros anteriores
+// ao Welcome são da versão 1. Sem nada disso, a conexão segue na 1. Uma
+// versão fora de [MinVersion, MaxVersion] fecha a conexão com o código
+// 1002 e as versões suportadas.
+const (
+	MinVersion = 1
+	MaxVersion = 2
+)
+
+// FeatureMsgpack troca os quadros por mensagens binárias em MessagePack, a
+// partir da versão 2. O Welcome sempre vai em JSON.
+const FeatureMsgpack = "msgpack"
+
+// features são os recursos negociáveis e a versão mínima de cada um.
+// Pedidos desconhecidos são ignorados; o Welcome lista os ligados.
+var features = map[string]int{FeatureMsgpack: 2}
+
+// FrameWelcome é o tipo do quadro Welcome.
+const FrameWelcome = "welcome"
+
+var negotiated = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "agent_service",
+	Name:      "position_stream_protocol_total",
+	Help:      "Conexões ao streaming de posições por versão do protocolo negociada (rejected para as recusadas).",
+}, []string{"version"})
+
+// msgpackHandle segue as tags json, como em internal/negotiate.
+var msgpackHandle = func() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{WriteExt: true}
+	h.TypeInfos = codec.NewTypeInfos([]string{"json"})
+	return h
+}()
+
+// Welcome responde a negociação.
+type Welcome struct {
+	Type     string   `json:"type"`
+	Version  int      `json:"version"`
+	Features []string `json:"features"`
+	// Supported é o intervalo de versões aceito pelo servidor.
+	Supported [2]int `json:"supported"`
+}
+
+// encoding é a forma dos quadros de um inscrito.
+type encoding struct {
+	version int
+	msgpack bool
+}
+
+// legacy é a codificação da versão 1.
+var legacy = encoding{version: 1}
+
+// versionError recusa uma versão; a mensagem vai no quadro de fechamento.
+type versionError struct {
+	reason string
+}
+
+func (e *versionError) Error() string { return e.reason }
+
+func unsupported(format string, args ...interface{}) error {
+	return &versionError{reason: fmt.Sprintf(format, args...) + fmt.Sprintf(" (supported versions %d-%d)", MinVersion, MaxVersion)}
+}
+
+// negotiate escolhe a maior versão oferecida que o servidor suporta e liga
+// os recursos pedidos que ela tem.
+func negotiate(versions []int, wanted []string) (encoding, []string, error) {
+	enc := encoding{}
+	for _, v := range versions {
+		if v >= MinVersion && v <= MaxVersion && v > enc.version {
+			enc.version = v
+		}
+	}
+	if enc.version == 0 {
+		return encoding{}, nil, unsupported("no supported protocol version offered in %v", versions)
+	}
+	enabled := []string{}
+	for _, f := range wanted {
+		if min, ok := features[f]; !ok || enc.version < min || slices.Contains(enabled, f) {
+			continue
+		}
+		enabled = append(enabled, f)
+		if f == FeatureMsgpack {
+			enc.msgpack = true
+		}
+	}
+	return enc, enabled, nil
+}
+
+// fromQuery negocia por ?protocol e ?features; ok é false sem ?protocol.
+func fromQuery(protocol, featureList string) (enc encoding, enabled []string, ok bool, err error) {
+	if protocol == "" {
+		return legacy, nil, false, nil
+	}
+	v, err := strconv.Atoi(protocol)
+	if err != nil || v < MinVersion || v > MaxVersion {
+		return encoding{}, nil, true, unsupported("unsupported protocol version %q", protocol)
+	}
+	var wanted []string
+	for _, f := range strings.Split(featureList, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			wanted = append(wanted, f)
+		}
+	}
+	enc, enabled, err = negotiate([]int{v}, wanted)
+	return enc, enabled, true, err
+}
+
+func (e encoding) welcome(enabled []string) []byte {
+	body, _ := json.Marshal(Welcome{Type: FrameWelcome, Version: e.version, Features: enabled, Supported: [2]int{MinVersion, MaxVersion}})
+	return body
+}
+
+// messageType é o tipo das mensagens websocket dos quadros.
+func (e encoding) messageType() int {
+	if e.msgpack {
+		return websocket.BinaryMessage
+	}
+	return websocket.TextMessage
+}
+
+// frameV2 é o Frame da versão 2, com os agentes compactos.
+type frameV2 struct {
+	Type    string          `json:"type"`
+	Version int             `json:"v"`
+	Topic   string          `json:"topic"`
+	Seq     uint64          `json:"seq"`
+	Time    time.Time       `json:"time"`
+	Agents  [][]interface{} `json:"agents"`
+	Removed []string        `json:"removed,omitempty"`
+}
+
+// encode serializa f com os agentes reduzidos a m. Sem máscara, a versão
+// 1 é o Frame tal como é.
+func (e encoding) encode(f *Frame, m fieldMask) ([]byte, error) {
+	if e.version == 1 && m == 0 {
+		return json.Marshal(f)
+	}
+	if e.version == 1 {
+		masked := maskedFrame{Type: f.Type, Topic: f.Topic, Seq: f.Seq, Time: f.Time, Removed: f.Removed,
+			Agents: make([]map[string]interface{}, len(f.Agents))}
+		for i, p := range f.Agents {
+			masked.Agents[i] = m.object(p)
+		}
+		return json.Marshal(masked)
+	}
+	v2 := frameV2{Type: f.Type, Version: 2, Topic: f.Topic, Seq: f.Seq, Time: f.Time, Removed: f.Removed,
+		Agents: make([][]interface{}, len(f.Agents))}
+	for i, p := range f.Agents {
+		v2.Agents[i] = m.values(p)
+	}
+	if !e.msgpack {
+		return json.Marshal(v2)
+	}
+	var b []byte
+	err := codec.NewEncoderBytes(&b, msgpackHandle).Encode(v2)
+	return b, err
+}
+
+// bodyKey é uma forma de serializar um quadro.
+type bodyKey struct {
+	enc  encoding
+	mask fieldMask
+}
+
+// frameBodies serializa um quadro uma vez por codificação e máscara dos
+// inscritos.
+type frameBodies struct {
+	frame  *Frame
+	bodies map[bodyKey][]byte
+}
+
+func newFrameBodies(f *Frame) *frameBodies {
+	return &frameBodies{frame: f, bodies: map[bodyKey][]byte{}}
+}
+
+func (b *frameBodies) body(e encoding, m fieldMask) ([]byte, error) {
+	key := bodyKey{enc: e, mask: m}
+	if body, ok := b.bodies[key]; ok {
+		return body, nil
+	}
+	body, err := e.encode(b.frame, m)
+	if err != nil {
+		return nil, err
+	}
+	b.bodies[key] = body
+	return body, nil
+}
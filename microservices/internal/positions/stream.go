@@ -0,0 +1,518 @@
+// Package positions transmite as posições dos agentes de uma simulação aos
+// mapas no tópico simulation:<id>:positions com um protocolo de snapshot e
+// deltas: quem se inscreve recebe todos os agentes num snapshot, e os
+// quadros seguintes trazem só os agentes que se moveram além dos limites
+// ou trocaram de status. Um keyframe periódico relê os agentes do serviço e
+// ressincroniza todos os inscritos, inclusive das alterações feitas em
+// outras réplicas, que não passam pelo barramento local.
+//
+// Cada quadro tem um número de sequência. Deltas e keyframes avançam a
+// sequência da simulação; o snapshot de um inscrito leva a sequência
+// atual. O cliente aplica o delta seq+1 e, se houver um buraco, pede um
+// snapshot novo com a mensagem de controle {"type":"resync"}. A forma dos
+// quadros depende da versão do protocolo negociada (ver protocol.go) e dos
+// campos pedidos pelo inscrito (ver mask.go).
+package positions
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/logging"
+)
+
+var (
+	framesSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "position_stream_frames_total",
+		Help:      "Quadros de posição entregues aos inscritos, por tipo (snapshot, keyframe, delta, welcome, subscribed, error).",
+	}, []string{"type"})
+	dropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "position_stream_dropped_total",
+		Help:      "Descartes do streaming de posições (event com a fila de eventos cheia, frame com o inscrito lento).",
+	}, []string{"kind"})
+	subscribers = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "agent_service",
+		Name:      "position_stream_subscribers",
+		Help:      "Conexões inscritas no streaming de posições.",
+	})
+)
+
+// Tipos de quadro.
+const (
+	FrameSnapshot = "snapshot"
+	FrameKeyframe = "keyframe"
+	FrameDelta    = "delta"
+)
+
+// listPageSize é o tamanho das páginas lidas ao carregar uma simulação.
+const listPageSize = 500
+
+// Topic é o tópico das posições de uma simulação.
+func Topic(simulationID string) string {
+	return "simulation:" + simulationID + ":positions"
+}
+
+// AgentLister é o subconjunto de agent.Service usado pelo streaming.
+type AgentLister interface {
+	ListAgents(ctx context.Context, f agent.Filter) ([]agent.Agent, int, error)
+	GetSimulation(ctx context.Context, id string) (*agent.Simulation, error)
+}
+
+// Config configura o streaming.
+type Config struct {
+	// DistanceThreshold é o deslocamento mínimo, em metros, para um agente
+	// entrar num delta; HeadingThreshold, a mudança mínima de rumo em
+	// graus. Uma troca de status sempre entra.
+	DistanceThreshold float64
+	HeadingThreshold  float64
+	// FlushInterval é o intervalo entre deltas; KeyframeInterval, entre
+	// keyframes.
+	FlushInterval    time.Duration
+	KeyframeInterval time.Duration
+	// QueueSize limita os eventos à espera; SendBuffer, os quadros à espera
+	// de cada inscrito.
+	QueueSize  int
+	SendBuffer int
+}
+
+// AgentPosition é um agente num quadro.
+type AgentPosition struct {
+	ID      string  `json:"id"`
+	Status  string  `json:"status"`
+	Lat     float64 `json:"lat"`
+	Lon     float64 `json:"lon"`
+	Heading float64 `json:"heading"`
+	Speed   float64 `json:"speed"`
+
+	updatedAt time.Time
+}
+
+// Frame é uma mensagem do servidor. Snapshots e keyframes trazem todos os
+// agentes da simulação em Agents; deltas, só os alterados, e em Removed os
+// removidos.
+type Frame struct {
+	Type    string          `json:"type"`
+	Topic   string          `json:"topic"`
+	Seq     uint64          `json:"seq"`
+	Time    time.Time       `json:"time"`
+	Agents  []AgentPosition `json:"agents"`
+	Removed []string        `json:"removed,omitempty"`
+}
+
+// Streamer mantém as posições das simulações com inscritos e distribui os
+// quadros. Simulações sem inscritos não são acompanhadas.
+type Streamer struct {
+	agents AgentLister
+	cfg    Config
+
+	mu   sync.Mutex
+	sims map[string]*simulation
+
+	events chan events.Event
+}
+
+// simulation é o estado de uma simulação acompanhada: current são as
+// posições conhecidas; sent, as que os inscritos já têm, base dos deltas.
+type simulation struct {
+	id      string
+	seq     uint64
+	current map[string]AgentPosition
+	sent    map[string]AgentPosition
+	subs    map[*subscriber]struct{}
+	// loaded fica falso até a primeira leitura do serviço; loading evita
+	// leituras simultâneas.
+	loaded  bool
+	loading bool
+}
+
+// NewStreamer cria o streaming; o consumo roda em Run.
+func NewStreamer(agents AgentLister, cfg Config) *Streamer {
+	return &Streamer{
+		agents: agents,
+		cfg:    cfg,
+		sims:   map[string]*simulation{},
+		events: make(chan events.Event, cfg.QueueSize),
+	}
+}
+
+// Handle recebe eventos do barramento sem bloquear o Publish; com a fila
+// cheia o evento é descartado e contado, e o próximo keyframe corrige.
+func (s *Streamer) Handle(_ context.Context, e events.Event) {
+	switch e.Type {
+	case "agent.created", "agent.updated", "agent.deleted":
+	default:
+		return
+	}
+	select {
+	case s.events <- e:
+	default:
+		dropped.WithLabelValues("event").Inc()
+	}
+}
+
+// Run aplica os eventos e envia deltas e keyframes até ctx ser cancelado.
+func (s *Streamer) Run(ctx context.Context) error {
+	work := logging.Background(ctx, "position-stream")
+	flush := time.NewTicker(s.cfg.FlushInterval)
+	defer flush.Stop()
+	keyframe := time.NewTicker(s.cfg.KeyframeInterval)
+	defer keyframe.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// Conexões sequestradas do servidor HTTP não fecham no shutdown
+			// dele; o cliente reconecta em outra réplica.
+			s.mu.Lock()
+			for _, sim := range s.sims {
+				for sub := range sim.subs {
+					sub.close()
+				}
+			}
+			s.mu.Unlock()
+			return nil
+		case e := <-s.events:
+			if err := s.apply(e); err != nil {
+				logging.FromContext(work).WithError(err).WithField("event_type", e.Type).Warn("Falha ao aplicar evento ao streaming de posições")
+			}
+		case <-flush.C:
+			s.flush(work)
+		case <-keyframe.C:
+			s.mu.Lock()
+			for _, sim := range s.sims {
+				if sim.loaded && !sim.loading {
+					sim.loading = true
+					go s.reload(work, sim, true)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// apply atualiza as posições conhecidas com o agente do evento, se a
+// simulação dele é acompanhada.
+func (s *Streamer) apply(e events.Event) error {
+	var data events.AgentV1
+	if err := e.Decode(&data); err != nil || data.ID == "" {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e.Type == "agent.deleted" {
+		// agent.deleted pode vir sem simulation_id.
+		for id, sim := range s.sims {
+			if data.SimulationID == "" || data.SimulationID == id {
+				delete(sim.current, data.ID)
+			}
+		}
+		return nil
+	}
+	sim := s.sims[data.SimulationID]
+	if sim == nil {
+		return nil
+	}
+	p := AgentPosition{
+		ID:        data.ID,
+		Status:    data.Status,
+		Lat:       data.Position.Lat,
+		Lon:       data.Position.Lon,
+		Heading:   data.Position.Heading,
+		Speed:     data.Position.Speed,
+		updatedAt: data.UpdatedAt,
+	}
+	if prev, ok := sim.current[data.ID]; !ok || !p.updatedAt.Before(prev.updatedAt) {
+		sim.current[data.ID] = p
+	}
+	return nil
+}
+
+// reload relê os agentes da simulação e, com keyframe, envia um keyframe a
+// todos os inscritos. Uma falha na leitura mantém as posições conhecidas.
+func (s *Streamer) reload(ctx context.Context, sim *simulation, keyframe bool) {
+	start := time.Now()
+	loaded, err := s.list(ctx, sim.id)
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).WithField("simulation_id", sim.id).Error("Falha ao ler agentes para o streaming de posições")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sim.loading = false
+	if err == nil {
+		// Eventos aplicados durante a leitura são mais novos que ela: o
+		// agente lido só substitui o conhecido se não for mais antigo, e só
+		// sai quem não mudou depois do início da leitura.
+		for id, p := range sim.current {
+			if _, ok := loaded[id]; !ok && p.updatedAt.Before(start) {
+				delete(sim.current, id)
+			}
+		}
+		for id, p := range loaded {
+			if prev, ok := sim.current[id]; !ok || !p.updatedAt.Before(prev.updatedAt) {
+				sim.current[id] = p
+			}
+		}
+	}
+	if !sim.loaded && err != nil {
+		// Sem a primeira leitura não há o que enviar: os inscritos são
+		// desconectados e a próxima inscrição tenta de novo.
+		if len(sim.subs) == 0 {
+			delete(s.sims, sim.id)
+		}
+		for sub := range sim.subs {
+			sub.close()
+		}
+		return
+	}
+	if !sim.loaded {
+		// Todos os inscritos até aqui esperam o snapshot, que parte das
+		// posições lidas, e não um delta delas.
+		sim.loaded = true
+		sim.sent = copyPositions(sim.current)
+	}
+	if !keyframe {
+		return
+	}
+	sim.seq++
+	fb := newFrameBodies(s.frame(sim, FrameKeyframe, sim.current, nil))
+	sim.sent = copyPositions(sim.current)
+	for sub := range sim.subs {
+		sub.resync = false
+		s.sendFrame(ctx, sub, fb)
+	}
+}
+
+func (s *Streamer) list(ctx context.Context, simulationID string) (map[string]AgentPosition, error) {
+	out := map[string]AgentPosition{}
+	f := agent.Filter{SimulationID: simulationID, PageSize: listPageSize}
+	for f.Page = 1; ; f.Page++ {
+		page, total, err := s.agents.ListAgents(ctx, f)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range page {
+			out[a.ID] = AgentPosition{
+				ID:        a.ID,
+				Status:    a.Status,
+				Lat:       a.Position.Lat,
+				Lon:       a.Position.Lon,
+				Heading:   a.Position.Heading,
+				Speed:     a.Position.Speed,
+				updatedAt: a.UpdatedAt,
+			}
+		}
+		if len(page) < listPageSize || f.Page*listPageSize >= total {
+			return out, nil
+		}
+	}
+}
+
+// flush envia a cada simulação o delta desde o último quadro e, a quem
+// pediu, um snapshot.
+func (s *Streamer) flush(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sim := range s.sims {
+		if !sim.loaded {
+			continue
+		}
+		changed, removed := s.diff(sim)
+		if len(changed) > 0 || len(removed) > 0 {
+			sim.seq++
+			delta := newFrameBodies(s.frame(sim, FrameDelta, changed, removed))
+			for id, p := range changed {
+				sim.sent[id] = p
+			}
+			for _, id := range removed {
+				delete(sim.sent, id)
+			}
+			for sub := range sim.subs {
+				if !sub.resync {
+					s.sendFrame(ctx, sub, delta)
+				}
+			}
+		}
+
+		// O snapshot reflete as posições atuais, que já incluem o delta;
+		// o próximo delta, seq+1, aplica-se sobre ele.
+		var snapshot *frameBodies
+		for sub := range sim.subs {
+			if !sub.resync {
+				continue
+			}
+			if snapshot == nil {
+				snapshot = newFrameBodies(s.frame(sim, FrameSnapshot, sim.current, nil))
+			}
+			sub.resync = false
+			s.sendFrame(ctx, sub, snapshot)
+		}
+	}
+}
+
+// diff compara as posições atuais com as já enviadas.
+func (s *Streamer) diff(sim *simulation) (map[string]AgentPosition, []string) {
+	changed := map[string]AgentPosition{}
+	for id, p := range sim.current {
+		prev, ok := sim.sent[id]
+		if !ok || prev.Status != p.Status ||
+			distanceMeters(prev.Lat, prev.Lon, p.Lat, p.Lon) > s.cfg.DistanceThreshold ||
+			headingDelta(prev.Heading, p.Heading) > s.cfg.HeadingThreshold {
+			changed[id] = p
+		}
+	}
+	var removed []string
+	for id := range sim.sent {
+		if _, ok := sim.current[id]; !ok {
+			removed = append(removed, id)
+		}
+	}
+	return changed, removed
+}
+
+func (s *Streamer) frame(sim *simulation, kind string, agents map[string]AgentPosition, removed []string) *Frame {
+	f := &Frame{
+		Type:    kind,
+		Topic:   Topic(sim.id),
+		Seq:     sim.seq,
+		Time:    time.Now().UTC(),
+		Agents:  make([]AgentPosition, 0, len(agents)),
+		Removed: removed,
+	}
+	for _, p := range agents {
+		f.Agents = append(f.Agents, p)
+	}
+	return f
+}
+
+// sendFrame envia o quadro a sub na codificação dele. Chamado com s.mu.
+func (s *Streamer) sendFrame(ctx context.Context, sub *subscriber, fb *frameBodies) {
+	body, err := fb.body(sub.enc, sub.mask)
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).WithField("topic", fb.frame.Topic).WithField("frame", fb.frame.Type).
+			Error("Falha ao serializar quadro de posições")
+		return
+	}
+	sub.send(fb.frame.Type, sub.enc.messageType(), body)
+}
+
+// subscribe inscreve sub na simulação; a primeira inscrição carrega os
+// agentes do serviço antes do snapshot.
+func (s *Streamer) subscribe(ctx context.Context, simulationID string, sub *subscriber) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sim := s.sims[simulationID]
+	if sim == nil {
+		sim = &simulation{
+			id:      simulationID,
+			current: map[string]AgentPosition{},
+			sent:    map[string]AgentPosition{},
+			subs:    map[*subscriber]struct{}{},
+		}
+		s.sims[simulationID] = sim
+	}
+	sub.resync = true
+	sim.subs[sub] = struct{}{}
+	subscribers.Inc()
+	if !sim.loaded && !sim.loading {
+		sim.loading = true
+		go s.reload(logging.Background(context.WithoutCancel(ctx), "position-stream"), sim, false)
+	}
+}
+
+// unsubscribe remove sub; a simulação sem inscritos deixa de ser
+// acompanhada.
+func (s *Streamer) unsubscribe(simulationID string, sub *subscriber) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sim := s.sims[simulationID]
+	if sim == nil {
+		return
+	}
+	if _, ok := sim.subs[sub]; !ok {
+		return
+	}
+	delete(sim.subs, sub)
+	subscribers.Dec()
+	if len(sim.subs) == 0 {
+		delete(s.sims, simulationID)
+	}
+}
+
+// switchEncoding passa sub à codificação negociada pelo hello. O Welcome
+// vai antes de qualquer quadro novo, seguido de um snapshot na nova
+// codificação; sem espaço na fila para o Welcome, a conexão é fechada e
+// o cliente reconecta.
+func (s *Streamer) switchEncoding(sub *subscriber, enc encoding, enabled []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub.enc = enc
+	if !sub.send(FrameWelcome, websocket.TextMessage, enc.welcome(enabled)) {
+		sub.close()
+		return
+	}
+	sub.resync = true
+}
+
+// setMask troca a máscara de campos de sub, pedida para topic, e o
+// re-sincroniza com um snapshot mascarado. O tópico de outra simulação ou um
+// campo desconhecido recebem um SubscriptionError e mantêm a máscara.
+func (s *Streamer) setMask(sub *subscriber, simulationID, topic string, fields []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if want := Topic(simulationID); topic != want {
+		sub.send(FrameSubscriptionError, websocket.TextMessage, subscriptionError(topic, "unknown topic: this stream serves "+want, nil))
+		return
+	}
+	m, invalid := parseMask(fields)
+	if invalid != nil {
+		sub.send(FrameSubscriptionError, websocket.TextMessage, subscriptionError(topic, invalidFieldsReason(invalid), invalid))
+		return
+	}
+	sub.mask = m
+	if !sub.send(FrameSubscribed, websocket.TextMessage, subscribed(topic, m)) {
+		sub.close()
+		return
+	}
+	sub.resync = true
+}
+
+// requestResync marca sub para receber um snapshot no próximo flush.
+func (s *Streamer) requestResync(sub *subscriber) {
+	s.mu.Lock()
+	sub.resync = true
+	s.mu.Unlock()
+}
+
+func copyPositions(m map[string]AgentPosition) map[string]AgentPosition {
+	out := make(map[string]AgentPosition, len(m))
+	for id, p := range m {
+		out[id] = p
+	}
+	return out
+}
+
+// distanceMeters é a distância de grande círculo (haversine) entre dois pontos.
+func distanceMeters(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadius = 6371000.0
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// headingDelta é a menor diferença entre dois rumos, em graus.
+func headingDelta(a, b float64) float64 {
+	d := math.Mod(math.Abs(a-b), 360)
+	return math.Min(d, 360-d)
+}
@@ -0,0 +1,249 @@
+// Package presence acompanha quando cada agente reportou pela última vez
+// (telemetria MQTT, amostras de métricas e alterações por PUT /agents/:id)
+// e detecta os que ficaram em silêncio: o Reaper passa a offline os agentes
+// sem reporte há mais que o offline_after do tipo, e o próximo reporte
+// devolve o status de antes. Agentes que nunca reportaram, como os
+// simulados, ficam de fora.
+package presence
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/agentmetric"
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/logging"
+)
+
+// Eventos publicados em events.TopicAgents.
+const (
+	EventOffline = "agent.offline"
+	EventOnline  = "agent.online"
+)
+
+// O estado de cada agente fica num hash ao lado do seu estado ao vivo, com
+// last_seen e agent_type e, enquanto offline, offline_since,
+// previous_status, simulation_id e project_id (instantes em
+// nanossegundos). seenPrefix<tipo> ordena os agentes online do tipo pelo
+// último reporte, em segundos, para o Reaper; offlineKey guarda os offline.
+const (
+	agentKeyPrefix = "agent-service:agents:"
+	seenPrefix     = "agent-service:presence:seen:"
+	offlineKey     = "agent-service:presence:offline"
+	reaperKey      = "agent-service:presence:reaper-leader"
+)
+
+func agentKey(agentID string) string  { return agentKeyPrefix + agentID + ":presence" }
+func seenKey(agentType string) string { return seenPrefix + agentType }
+
+// AgentService é o subconjunto de agent.Service usado pela presença.
+type AgentService interface {
+	GetAgent(ctx context.Context, id string) (*agent.Agent, error)
+	UpdateAgent(ctx context.Context, id string, req agent.UpdateAgentRequest) (*agent.Agent, error)
+}
+
+// Info é a presença de um agente, em GET /agents. Ambos são nulos para
+// quem nunca reportou; OfflineSince, para quem está online.
+type Info struct {
+	LastSeenAt   *time.Time `json:"last_seen_at"`
+	OfflineSince *time.Time `json:"offline_since"`
+}
+
+// Tracker registra os reportes dos agentes.
+type Tracker struct {
+	redis         redis.UniversalClient
+	agents        AgentService
+	publisher     events.Publisher
+	offlineStatus string
+}
+
+// NewTracker cria o registro. offlineStatus é o status dado pelo Reaper,
+// que o próximo reporte troca pelo anterior.
+func NewTracker(client redis.UniversalClient, agents AgentService, publisher events.Publisher, offlineStatus string) *Tracker {
+	return &Tracker{redis: client, agents: agents, publisher: publisher, offlineStatus: offlineStatus}
+}
+
+// Seen registra um reporte do agente, já com o estado depois dele. Se o
+// agente estava offline, volta ao status anterior (a menos que o reporte
+// tenha trocado o status) e EventOnline é publicado.
+func (t *Tracker) Seen(ctx context.Context, ag *agent.Agent) error {
+	now := time.Now()
+	key := agentKey(ag.ID)
+	var state *redis.SliceCmd
+	_, err := t.redis.Pipelined(ctx, func(p redis.Pipeliner) error {
+		p.HSet(ctx, key, "last_seen", now.UnixNano(), "agent_type", ag.Type)
+		p.ZAdd(ctx, seenKey(ag.Type), redis.Z{Score: float64(now.Unix()), Member: ag.ID})
+		state = p.HMGet(ctx, key, "offline_since", "previous_status")
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	vals := state.Val()
+	since, ok := parseNanos(vals[0])
+	if !ok {
+		return nil
+	}
+	// Só quem remove a marca devolve o status, para que dois reportes
+	// simultâneos não publiquem a volta duas vezes.
+	n, err := t.redis.HDel(ctx, key, "offline_since", "previous_status", "simulation_id", "project_id").Result()
+	if err != nil || n == 0 {
+		return err
+	}
+	if err := t.redis.SRem(ctx, offlineKey, ag.ID).Err(); err != nil {
+		return err
+	}
+	previous, _ := vals[1].(string)
+	status := ag.Status
+	if ag.Status == t.offlineStatus && previous != "" {
+		if _, err := t.agents.UpdateAgent(ctx, ag.ID, agent.UpdateAgentRequest{Status: &previous}); err != nil {
+			return err
+		}
+		status = previous
+	}
+	transitions.WithLabelValues("online").Inc()
+	t.publisher.Publish(ctx, events.New(events.TopicAgents, EventOnline, events.AgentPresenceV1{
+		AgentID:        ag.ID,
+		AgentType:      ag.Type,
+		SimulationID:   ag.SimulationID,
+		ProjectID:      ag.ProjectID,
+		Status:         status,
+		PreviousStatus: t.offlineStatus,
+		LastSeenAt:     now.UTC(),
+		OfflineSince:   since.UTC(),
+		OfflineSeconds: now.Sub(since).Seconds(),
+	}))
+	return nil
+}
+
+// Observe registra as amostras de métricas do agente como um reporte.
+// Implementa agentmetric.Observer.
+func (t *Tracker) Observe(ctx context.Context, ag *agent.Agent, _ []agentmetric.Sample) {
+	if err := t.Seen(ctx, ag); err != nil {
+		logging.FromContext(ctx).WithError(err).WithField("agent_id", ag.ID).Warn("Falha ao registrar o reporte do agente")
+	}
+}
+
+// Middleware registra como reporte as alterações bem-sucedidas do agente de
+// :id, ex.: PUT /agents/:id.
+func (t *Tracker) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		if c.Writer.Status() >= 300 {
+			return
+		}
+		ctx := c.Request.Context()
+		ag, err := t.agents.GetAgent(ctx, c.Param("id"))
+		if err == nil {
+			err = t.Seen(ctx, ag)
+		}
+		if err != nil {
+			logging.FromContext(ctx).WithError(err).WithField("agent_id", c.Param("id")).Warn("Falha ao registrar o reporte do agente")
+		}
+	}
+}
+
+// Reporting envolve agents de modo que cada UpdateAgent bem-sucedido conte
+// como reporte, para quem recebe telemetria, como a ponte MQTT.
+func (t *Tracker) Reporting(agents AgentService) AgentService {
+	return reporting{AgentService: agents, tracker: t}
+}
+
+type reporting struct {
+	AgentService
+	tracker *Tracker
+}
+
+func (r reporting) UpdateAgent(ctx context.Context, id string, req agent.UpdateAgentRequest) (*agent.Agent, error) {
+	ag, err := r.AgentService.UpdateAgent(ctx, id, req)
+	if err == nil {
+		if err := r.tracker.Seen(ctx, ag); err != nil {
+			logging.FromContext(ctx).WithError(err).WithField("agent_id", id).Warn("Falha ao registrar o reporte do agente")
+		}
+	}
+	return ag, err
+}
+
+// ForAgents retorna a presença de cada agente que já reportou.
+func (t *Tracker) ForAgents(ctx context.Context, agents []agent.Agent) (map[string]Info, error) {
+	cmds := make([]*redis.SliceCmd, len(agents))
+	_, err := t.redis.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, a := range agents {
+			cmds[i] = p.HMGet(ctx, agentKey(a.ID), "last_seen", "offline_since")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]Info, len(agents))
+	for i, a := range agents {
+		vals := cmds[i].Val()
+		var info Info
+		if seen, ok := parseNanos(vals[0]); ok {
+			info.LastSeenAt = &seen
+		}
+		if since, ok := parseNanos(vals[1]); ok {
+			info.OfflineSince = &since
+		}
+		if info.LastSeenAt != nil {
+			out[a.ID] = info
+		}
+	}
+	return out, nil
+}
+
+// CountOffline conta os agentes offline há pelo menos longerThan; os
+// filtros vazios não restringem. Implementa alert.OfflineCounter.
+func (t *Tracker) CountOffline(ctx context.Context, agentType, simulationID, projectID string, longerThan time.Duration) (int, error) {
+	ids, err := t.redis.SMembers(ctx, offlineKey).Result()
+	if err != nil {
+		return 0, err
+	}
+	cmds := make([]*redis.SliceCmd, len(ids))
+	_, err = t.redis.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, id := range ids {
+			cmds[i] = p.HMGet(ctx, agentKey(id), "offline_since", "agent_type", "simulation_id", "project_id")
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	cutoff := time.Now().Add(-longerThan)
+	n := 0
+	for _, cmd := range cmds {
+		vals := cmd.Val()
+		since, ok := parseNanos(vals[0])
+		if !ok || since.After(cutoff) {
+			continue
+		}
+		if !matches(vals[1], agentType) || !matches(vals[2], simulationID) || !matches(vals[3], projectID) {
+			continue
+		}
+		n++
+	}
+	return n, nil
+}
+
+func matches(v interface{}, want string) bool {
+	s, _ := v.(string)
+	return want == "" || s == want
+}
+
+func parseNanos(v interface{}) (time.Time, bool) {
+	s, ok := v.(string)
+	if !ok {
+		return time.Time{}, false
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, n), true
+}
@@ -0,0 +1,216 @@
+package presence
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/lease"
+	"smart-city-microservices/internal/logging"
+	"smart-city-microservices/internal/supervisor"
+)
+
+var transitions = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "agent_service",
+	Name:      "agent_presence_transitions_total",
+	Help:      "Agentes que passaram a offline por silêncio ou voltaram a online ao reportar.",
+}, []string{"to"})
+
+// ReaperConfig configura a detecção de agentes offline.
+type ReaperConfig struct {
+	// OfflineAfter é o silêncio, por tipo, depois do qual o agente passa a
+	// OfflineStatus; tipos fora do mapa não são verificados.
+	OfflineAfter  map[string]time.Duration
+	OfflineStatus string
+	Interval      time.Duration
+	// MaxPerCycle limita as transições de um ciclo; as demais ficam para o
+	// seguinte.
+	MaxPerCycle int
+}
+
+// Reaper passa a offline os agentes em silêncio. Só uma réplica verifica
+// por vez, para que uma transição não seja feita e publicada duas vezes.
+type Reaper struct {
+	redis     redis.UniversalClient
+	leader    *lease.Lease
+	agents    AgentService
+	publisher events.Publisher
+	cfg       ReaperConfig
+}
+
+// NewReaper cria a verificação. id identifica a réplica na disputa pela
+// verificação; o ciclo roda em Run.
+func NewReaper(client redis.UniversalClient, agents AgentService, publisher events.Publisher, cfg ReaperConfig, id string) *Reaper {
+	return &Reaper{
+		redis:     client,
+		leader:    lease.New(client, reaperKey, id),
+		agents:    agents,
+		publisher: publisher,
+		cfg:       cfg,
+	}
+}
+
+// Run verifica os agentes a cada intervalo até ctx ser cancelado e, ao
+// terminar, libera a verificação para outra réplica.
+func (r *Reaper) Run(ctx context.Context) error {
+	work := logging.Background(context.WithoutCancel(ctx), "presence-reaper")
+	defer r.release(work)
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			r.cycle(work)
+		}
+	}
+}
+
+// release remove a chave só se ainda for desta réplica.
+func (r *Reaper) release(ctx context.Context) {
+	ctx, cancel := supervisor.Cleanup(ctx)
+	defer cancel()
+	r.leader.Release(ctx)
+}
+
+func (r *Reaper) cycle(ctx context.Context) {
+	log := logging.FromContext(ctx)
+	leader, err := r.lead(ctx)
+	if err != nil {
+		log.WithError(err).Warn("Falha ao disputar a detecção de agentes offline")
+		return
+	}
+	if !leader {
+		return
+	}
+	now := time.Now()
+	budget := r.cfg.MaxPerCycle
+	for agentType, window := range r.cfg.OfflineAfter {
+		if budget <= 0 {
+			return
+		}
+		ids, err := r.redis.ZRangeByScore(ctx, seenKey(agentType), &redis.ZRangeBy{
+			Min:   "-inf",
+			Max:   strconv.FormatInt(now.Add(-window).Unix(), 10),
+			Count: int64(budget),
+		}).Result()
+		if err != nil {
+			log.WithError(err).WithField("agent_type", agentType).Warn("Falha ao buscar agentes em silêncio")
+			continue
+		}
+		for _, id := range ids {
+			ok, err := r.reap(ctx, id, agentType, now.Add(-window))
+			if err != nil {
+				log.WithError(err).WithField("agent_id", id).Warn("Falha ao passar agente a offline")
+				continue
+			}
+			if ok {
+				budget--
+			}
+		}
+	}
+}
+
+// reap passa o agente a offline se ele continua sem reportar desde cutoff.
+// O hash do agente é vigiado, para que um reporte no meio cancele a
+// transição.
+func (r *Reaper) reap(ctx context.Context, id, agentType string, cutoff time.Time) (bool, error) {
+	key := agentKey(id)
+	var ag *agent.Agent
+	var lastSeen time.Time
+	at := time.Now()
+	err := r.redis.Watch(ctx, func(tx *redis.Tx) error {
+		vals, err := tx.HMGet(ctx, key, "last_seen", "offline_since", "agent_type").Result()
+		if err != nil {
+			return err
+		}
+		seen, ok := parseNanos(vals[0])
+		if _, offline := parseNanos(vals[1]); offline || !ok || vals[2] != agentType {
+			// Entrada velha do índice: o agente já está offline ou mudou de tipo.
+			return tx.ZRem(ctx, seenKey(agentType), id).Err()
+		}
+		if seen.After(cutoff) {
+			return nil
+		}
+		a, err := r.agents.GetAgent(ctx, id)
+		if errors.Is(err, agent.ErrNotFound) {
+			_, err = tx.TxPipelined(ctx, func(p redis.Pipeliner) error {
+				p.Del(ctx, key)
+				p.ZRem(ctx, seenKey(agentType), id)
+				return nil
+			})
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if a.Status == r.cfg.OfflineStatus {
+			// Posto offline por outro caminho: não há transição nem status
+			// para devolver.
+			return tx.ZRem(ctx, seenKey(agentType), id).Err()
+		}
+		_, err = tx.TxPipelined(ctx, func(p redis.Pipeliner) error {
+			p.HSet(ctx, key,
+				"offline_since", at.UnixNano(),
+				"previous_status", a.Status,
+				"simulation_id", a.SimulationID,
+				"project_id", a.ProjectID,
+			)
+			p.ZRem(ctx, seenKey(agentType), id)
+			p.SAdd(ctx, offlineKey, id)
+			return nil
+		})
+		ag, lastSeen = a, seen
+		return err
+	}, key)
+	if errors.Is(err, redis.TxFailedErr) {
+		// O agente reportou durante a verificação.
+		return false, nil
+	}
+	if err != nil || ag == nil {
+		return false, err
+	}
+
+	status := r.cfg.OfflineStatus
+	if _, err := r.agents.UpdateAgent(ctx, id, agent.UpdateAgentRequest{Status: &status}); err != nil {
+		// Desfaz a marca, para que o próximo ciclo tente de novo.
+		r.redis.Pipelined(ctx, func(p redis.Pipeliner) error {
+			p.HDel(ctx, key, "offline_since", "previous_status", "simulation_id", "project_id")
+			p.SRem(ctx, offlineKey, id)
+			p.ZAdd(ctx, seenKey(agentType), redis.Z{Score: float64(lastSeen.Unix()), Member: id})
+			return nil
+		})
+		return false, err
+	}
+	transitions.WithLabelValues("offline").Inc()
+	logging.FromContext(ctx).WithFields(logrus.Fields{
+		"agent_id": id, "agent_type": agentType, "silence": at.Sub(lastSeen).Round(time.Second).String(),
+	}).Info("Agente passou a offline por falta de reportes")
+	r.publisher.Publish(ctx, events.New(events.TopicAgents, EventOffline, events.AgentPresenceV1{
+		AgentID:        id,
+		AgentType:      ag.Type,
+		SimulationID:   ag.SimulationID,
+		ProjectID:      ag.ProjectID,
+		Status:         r.cfg.OfflineStatus,
+		PreviousStatus: ag.Status,
+		LastSeenAt:     lastSeen.UTC(),
+		OfflineSince:   at.UTC(),
+		SilenceSeconds: at.Sub(lastSeen).Seconds(),
+	}))
+	return true, nil
+}
+
+// lead disputa a verificação. A chave expira em três intervalos, para que
+// outra réplica assuma se esta cair.
+func (r *Reaper) lead(ctx context.Context) (bool, error) {
+	return r.leader.Acquire(ctx, 3*r.cfg.Interval)
+}
@@ -0,0 +1,98 @@
+package projectsettings
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/audit"
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/i18n"
+	"smart-city-microservices/internal/logging"
+)
+
+// Handler expõe os limites dos projetos.
+type Handler struct {
+	settings *Settings
+}
+
+// NewHandler cria o handler dos limites dos projetos.
+func NewHandler(settings *Settings) *Handler {
+	return &Handler{settings: settings}
+}
+
+// Effective responde GET /projects/:id/settings/effective com os limites
+// efetivos do projeto e, para cada um, o padrão e se vale o valor gravado.
+func (h *Handler) Effective(c *gin.Context) {
+	projectID := c.Param("id")
+	if !auth.FromGin(c).InProject(projectID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "project " + projectID + " is not accessible"})
+		return
+	}
+	c.JSON(http.StatusOK, h.settings.Effective(projectID))
+}
+
+// Put responde PUT /projects/:id/settings, só para admins: grava os
+// limites do projeto. Campos nulos ou ausentes usam o padrão; 0 é sem
+// limite. action_roles restringe cada ação listada aos papéis dela. A rota
+// deve ficar atrás de auth.RequireRole(auth.RoleAdmin).
+func (h *Handler) Put(c *gin.Context) {
+	var o Override
+	if err := c.ShouldBindJSON(&o); err != nil {
+		i18n.BindError(c, err)
+		return
+	}
+	if err := validate(&o); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if p := auth.FromGin(c); p != nil {
+		o.UpdatedBy = p.Subject
+	}
+	ctx := c.Request.Context()
+	projectID := c.Param("id")
+	if err := h.settings.Put(ctx, projectID, &o); err != nil {
+		logging.FromContext(ctx).WithError(err).Error("Erro no handler de limites dos projetos")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+	audit.Record(ctx, "project.settings_updated", logrus.Fields{
+		"project_id": projectID,
+		"settings":   h.settings.For(projectID),
+	})
+	c.JSON(http.StatusOK, h.settings.Effective(projectID))
+}
+
+func validate(o *Override) error {
+	if (o.RequestsPerMinute != nil && *o.RequestsPerMinute < 0) ||
+		(o.RequestTimeoutMS != nil && *o.RequestTimeoutMS < 0) ||
+		(o.ExportMaxBytes != nil && *o.ExportMaxBytes < 0) {
+		return errors.New("project settings must not be negative")
+	}
+	for _, origin := range o.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" {
+			return fmt.Errorf("invalid origin %q: use scheme://host[:port] or *", origin)
+		}
+	}
+	for name, roles := range o.ActionRoles {
+		if name == "" {
+			return errors.New("action_roles: action name must not be empty")
+		}
+		for _, role := range roles {
+			switch role {
+			case auth.RoleAdmin, auth.RoleOperator, auth.RoleViewer:
+			default:
+				return fmt.Errorf("action_roles.%s: invalid role %q (use admin, operator or viewer)", name, role)
+			}
+		}
+	}
+	return nil
+}
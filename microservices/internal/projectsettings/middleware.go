@@ -0,0 +1,152 @@
+package projectsettings
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/logging"
+)
+
+// ratePrefix é o prefixo da contagem das requisições por projeto e minuto.
+const ratePrefix = "agent-service:project-rate:"
+
+var rejections = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "agent_service",
+	Name:      "project_limit_rejections_total",
+	Help:      "Requisições recusadas pelos limites do projeto, por limite (project, origin, rate, timeout).",
+}, []string{"limit"})
+
+// Middleware aplica os limites do projeto da requisição, na ordem: origem
+// do navegador (403), requisições por minuto (429) e tempo máximo (503,
+// se o handler não respondeu a tempo). Deve vir depois da autenticação. As
+// requisições sem projeto seguem os limites padrão
+// (project_limits.defaults), contadas por principal ou, sem ele, pelo IP
+// do cliente. As atualizações para websocket, que duram a conexão, não têm
+// tempo máximo.
+func (s *Settings) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p := auth.FromGin(c)
+		projectID, err := requestProject(c, p)
+		switch {
+		case errors.Is(err, errAnonymous):
+			rejections.WithLabelValues("project").Inc()
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": fmt.Sprintf("authentication required for project %s", projectID)})
+			return
+		case errors.Is(err, errForeign):
+			rejections.WithLabelValues("project").Inc()
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("project %s is not accessible", projectID)})
+			return
+		}
+		v, scope, key := s.Defaults(), "requests without a project", unscopedKey(c, p)
+		if projectID != "" {
+			v, scope, key = s.For(projectID), "project "+projectID, projectID
+		}
+		if origin := c.GetHeader("Origin"); origin != "" && len(v.AllowedOrigins) > 0 &&
+			!slices.Contains(v.AllowedOrigins, "*") && !slices.Contains(v.AllowedOrigins, origin) {
+			rejections.WithLabelValues("origin").Inc()
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("origin %s is not allowed for %s", origin, scope)})
+			return
+		}
+		if v.RequestsPerMinute > 0 && !s.allowRate(c, key, scope, v.RequestsPerMinute) {
+			return
+		}
+		if v.RequestTimeoutMS <= 0 || strings.EqualFold(c.GetHeader("Upgrade"), "websocket") {
+			c.Next()
+			return
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), time.Duration(v.RequestTimeoutMS)*time.Millisecond)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) && !c.Writer.Written() {
+			rejections.WithLabelValues("timeout").Inc()
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "request exceeded the project's timeout"})
+		}
+	}
+}
+
+// allowRate conta a requisição na janela do minuto atual da chave key. Acima
+// do limite responde 429, em nome de scope, e retorna false. Com o Redis
+// fora, a requisição segue.
+func (s *Settings) allowRate(c *gin.Context, key, scope string, limit int) bool {
+	ctx := c.Request.Context()
+	now := time.Now()
+	window := now.Unix() / 60
+	key = ratePrefix + key + ":" + strconv.FormatInt(window, 10)
+	pipe := s.redis.TxPipeline()
+	incr := pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, 2*time.Minute)
+	if _, err := pipe.Exec(ctx); err != nil {
+		logging.FromContext(ctx).WithError(err).Warn("Falha ao contar as requisições do projeto; seguindo sem limite")
+		return true
+	}
+	if incr.Val() <= int64(limit) {
+		return true
+	}
+	rejections.WithLabelValues("rate").Inc()
+	retry := (window+1)*60 - now.Unix()
+	c.Header("Retry-After", strconv.FormatInt(retry, 10))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+		"error":               fmt.Sprintf("%s exceeded %d requests per minute", scope, limit),
+		"retry_after_seconds": retry,
+	})
+	return false
+}
+
+// Erros de requestProject.
+var (
+	errAnonymous = errors.New("projectsettings: projeto sem principal")
+	errForeign   = errors.New("projectsettings: projeto fora do principal")
+)
+
+// requestProject é o projeto cujos limites valem para a requisição: o do
+// recurso, em /projects/:id, ou o de X-Project-ID ou ?project_id=. Sem
+// principal, um projeto na requisição é recusado com errAnonymous, para que
+// ninguém gaste a cota de outro; com principal, um projeto a que ele não
+// tem acesso é recusado com errForeign. Sem projeto na requisição vale o
+// primeiro projeto do principal, e o principal sem projetos (o token
+// estático, um admin) e o anônimo ficam sem projeto. Com erro, o projeto
+// recusado também é retornado.
+func requestProject(c *gin.Context, p *auth.Principal) (string, error) {
+	id := ""
+	if strings.HasPrefix(c.FullPath(), "/api/v1/projects/:id") {
+		id = c.Param("id")
+	}
+	if id == "" {
+		id = c.GetHeader(logging.HeaderProjectID)
+	}
+	if id == "" {
+		id = c.Query("project_id")
+	}
+	switch {
+	case id != "" && p == nil:
+		return id, errAnonymous
+	case id != "" && !p.InProject(id):
+		return id, errForeign
+	case id != "":
+		return id, nil
+	case p != nil && len(p.Projects) > 0:
+		return p.Projects[0], nil
+	}
+	return "", nil
+}
+
+// unscopedKey é a chave da contagem das requisições sem projeto: o
+// principal ou, sem ele, o IP do cliente.
+func unscopedKey(c *gin.Context, p *auth.Principal) string {
+	if p != nil {
+		return "principal:" + p.Subject
+	}
+	return "ip:" + c.ClientIP()
+}
@@ -0,0 +1,89 @@
+package projectsettings
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+
+	"smart-city-microservices/internal/auth"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func intp(v int) *int { return &v }
+
+// TestMiddlewareProject confere de qual projeto vêm os limites: o padrão
+// aceita 3 requisições por minuto, proj-1, 1, e proj-2, 2. limit é quantas
+// passam antes do 429; com status, a primeira já é recusada.
+func TestMiddlewareProject(t *testing.T) {
+	member := &auth.Principal{Subject: "ana", Roles: []string{auth.RoleViewer}, Projects: []string{"proj-1", "proj-2"}}
+	static := &auth.Principal{Subject: auth.StaticTokenSubject, Roles: []string{auth.RoleAdmin}}
+	tests := []struct {
+		name      string
+		principal *auth.Principal
+		path      string
+		header    string
+		limit     int
+		status    int
+	}{
+		{name: "anonymous with header", path: "/api/v1/agents", header: "proj-1", status: http.StatusUnauthorized},
+		{name: "anonymous with query", path: "/api/v1/agents?project_id=proj-2", status: http.StatusUnauthorized},
+		{name: "anonymous with resource", path: "/api/v1/projects/proj-1/usage", status: http.StatusUnauthorized},
+		{name: "anonymous without project", path: "/api/v1/agents", limit: 3},
+		{name: "foreign header", principal: member, path: "/api/v1/agents", header: "proj-3", status: http.StatusForbidden},
+		{name: "foreign resource", principal: member, path: "/api/v1/projects/proj-3/usage", status: http.StatusForbidden},
+		{name: "principal's project", principal: member, path: "/api/v1/agents", limit: 1},
+		{name: "chosen project", principal: member, path: "/api/v1/agents", header: "proj-2", limit: 2},
+		{name: "resource over header", principal: member, path: "/api/v1/projects/proj-2/usage", header: "proj-1", limit: 2},
+		{name: "all projects with header", principal: static, path: "/api/v1/agents?project_id=proj-1", limit: 1},
+		{name: "all projects without project", principal: static, path: "/api/v1/agents", limit: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mr := miniredis.RunT(t)
+			client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+			t.Cleanup(func() { client.Close() })
+			s := New(nil, client, Config{Defaults: Values{RequestsPerMinute: 3}})
+			s.overrides["proj-1"] = &Override{RequestsPerMinute: intp(1)}
+			s.overrides["proj-2"] = &Override{RequestsPerMinute: intp(2)}
+
+			r := gin.New()
+			r.Use(func(c *gin.Context) {
+				if tt.principal != nil {
+					auth.SetPrincipal(c, tt.principal)
+				}
+			}, s.Middleware())
+			ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+			r.GET("/api/v1/agents", ok)
+			r.GET("/api/v1/projects/:id/usage", ok)
+
+			for i := 0; i <= tt.limit; i++ {
+				w := httptest.NewRecorder()
+				req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+				if tt.header != "" {
+					req.Header.Set("X-Project-ID", tt.header)
+				}
+				r.ServeHTTP(w, req)
+				want := http.StatusOK
+				switch {
+				case tt.status != 0:
+					want = tt.status
+				case i == tt.limit:
+					want = http.StatusTooManyRequests
+				}
+				if w.Code != want {
+					t.Fatalf("requisição %d: status %d, want %d: %s", i+1, w.Code, want, w.Body.String())
+				}
+				if tt.status != 0 {
+					break
+				}
+			}
+		})
+	}
+}
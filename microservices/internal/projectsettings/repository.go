@@ -0,0 +1,77 @@
+package projectsettings
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/lib/pq"
+
+	"smart-city-microservices/internal/instrument"
+)
+
+// Repository persiste os valores dos projetos em project_settings.
+type Repository struct {
+	db *instrument.DB
+}
+
+// NewRepository cria o repositório dos limites dos projetos.
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: instrument.NewDB(db)}
+}
+
+// List retorna os valores gravados por projeto.
+func (r *Repository) List(ctx context.Context) (map[string]*Override, error) {
+	rows, err := r.db.Query(ctx, "projectsettings.list", `
+		SELECT project_id, requests_per_minute, request_timeout_ms, export_max_bytes, allowed_origins, action_roles,
+			COALESCE(updated_by, ''), updated_at
+		FROM project_settings`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string]*Override{}
+	for rows.Next() {
+		var id string
+		var o Override
+		var actionRoles []byte
+		if err := rows.Scan(&id, &o.RequestsPerMinute, &o.RequestTimeoutMS, &o.ExportMaxBytes, pq.Array(&o.AllowedOrigins),
+			&actionRoles, &o.UpdatedBy, &o.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if actionRoles != nil {
+			if err := json.Unmarshal(actionRoles, &o.ActionRoles); err != nil {
+				return nil, err
+			}
+		}
+		out[id] = &o
+	}
+	return out, rows.Err()
+}
+
+// Put grava os valores do projeto e preenche o.UpdatedAt. AllowedOrigins
+// e ActionRoles nil gravam nulo.
+func (r *Repository) Put(ctx context.Context, projectID string, o *Override) error {
+	var actionRoles []byte
+	if o.ActionRoles != nil {
+		var err error
+		if actionRoles, err = json.Marshal(o.ActionRoles); err != nil {
+			return err
+		}
+	}
+	return r.db.QueryRow(ctx, "projectsettings.put", `
+		INSERT INTO project_settings (project_id, requests_per_minute, request_timeout_ms, export_max_bytes,
+			allowed_origins, action_roles, updated_by, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), CURRENT_TIMESTAMP)
+		ON CONFLICT (project_id) DO UPDATE SET
+			requests_per_minute = EXCLUDED.requests_per_minute,
+			request_timeout_ms = EXCLUDED.request_timeout_ms,
+			export_max_bytes = EXCLUDED.export_max_bytes,
+			allowed_origins = EXCLUDED.allowed_origins,
+			action_roles = EXCLUDED.action_roles,
+			updated_by = EXCLUDED.updated_by,
+			updated_at = EXCLUDED.updated_at
+		RETURNING updated_at`,
+		projectID, o.RequestsPerMinute, o.RequestTimeoutMS, o.ExportMaxBytes, pq.Array(o.AllowedOrigins), actionRoles, o.UpdatedBy).
+		Scan(&o.UpdatedAt)
+}
@@ -0,0 +1,257 @@
+// Package projectsettings aplica às requisições de cada projeto limites
+// próprios: requisições por minuto, tempo máximo de uma requisição,
+// tamanho das exportações e origens aceitas dos navegadores.
+//
+// Além dos limites, um projeto pode restringir ações de agentes a papéis
+// (action_roles), conferidos pela política das ações em internal/action.
+//
+// Os padrões vêm da configuração (project_limits.defaults); os valores
+// gravados em project_settings os substituem campo a campo, e um campo
+// nulo volta ao padrão. Cada réplica guarda os valores em memória e os
+// relê quando outra os altera, avisada por um canal Redis pub/sub, ou a
+// cada intervalo, como os feature flags; aplicar um limite não sai da
+// memória, exceto a contagem das requisições por minuto, que fica no Redis
+// para valer entre as réplicas.
+package projectsettings
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"smart-city-microservices/internal/logging"
+)
+
+// Values são os limites de um projeto. Em todos os campos, 0 é sem limite;
+// AllowedOrigins vazio aceita as origens de server.cors. ActionRoles
+// restringe cada ação listada aos papéis dela.
+type Values struct {
+	RequestsPerMinute int                 `json:"requests_per_minute"`
+	RequestTimeoutMS  int64               `json:"request_timeout_ms"`
+	ExportMaxBytes    int64               `json:"export_max_bytes"`
+	AllowedOrigins    []string            `json:"allowed_origins"`
+	ActionRoles       map[string][]string `json:"action_roles"`
+}
+
+// Override são os valores gravados de um projeto; os campos nulos usam o
+// padrão.
+type Override struct {
+	RequestsPerMinute *int     `json:"requests_per_minute"`
+	RequestTimeoutMS  *int64   `json:"request_timeout_ms"`
+	ExportMaxBytes    *int64   `json:"export_max_bytes"`
+	AllowedOrigins    []string `json:"allowed_origins"`
+	// ActionRoles substitui por inteiro as restrições padrão.
+	ActionRoles map[string][]string `json:"action_roles"`
+	UpdatedBy   string              `json:"updated_by,omitempty"`
+	// UpdatedAt é preenchido pelo repositório.
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// apply sobrepõe os valores gravados aos padrões.
+func (o *Override) apply(v Values) Values {
+	if o == nil {
+		return v
+	}
+	if o.RequestsPerMinute != nil {
+		v.RequestsPerMinute = *o.RequestsPerMinute
+	}
+	if o.RequestTimeoutMS != nil {
+		v.RequestTimeoutMS = *o.RequestTimeoutMS
+	}
+	if o.ExportMaxBytes != nil {
+		v.ExportMaxBytes = *o.ExportMaxBytes
+	}
+	if o.AllowedOrigins != nil {
+		v.AllowedOrigins = o.AllowedOrigins
+	}
+	if o.ActionRoles != nil {
+		v.ActionRoles = o.ActionRoles
+	}
+	return v
+}
+
+// Origem de um valor efetivo.
+const (
+	SourceDefault  = "default"
+	SourceOverride = "override"
+)
+
+// Setting é um valor efetivo, o padrão e de onde ele veio.
+type Setting struct {
+	Value   interface{} `json:"value"`
+	Default interface{} `json:"default"`
+	Source  string      `json:"source"`
+}
+
+// Effective são os valores efetivos de um projeto, para GET
+// /projects/:id/settings/effective.
+type Effective struct {
+	ProjectID string             `json:"project_id"`
+	Settings  map[string]Setting `json:"settings"`
+	UpdatedBy string             `json:"updated_by,omitempty"`
+	UpdatedAt *time.Time         `json:"updated_at"`
+}
+
+// Config configura os limites dos projetos.
+type Config struct {
+	// Channel é o canal Redis em que as alterações são avisadas.
+	Channel string
+	// RefreshInterval é o intervalo da releitura completa, que cobre os
+	// avisos perdidos.
+	RefreshInterval time.Duration
+	// Defaults são os limites dos projetos sem valores gravados; SetDefaults
+	// os troca na recarga da configuração.
+	Defaults Values
+}
+
+// Settings guarda em memória os valores gravados dos projetos.
+type Settings struct {
+	repo  *Repository
+	redis redis.UniversalClient
+	cfg   Config
+
+	mu        sync.RWMutex
+	defaults  Values
+	overrides map[string]*Override
+}
+
+// New cria os limites, só com os padrões até a primeira leitura de Run.
+func New(repo *Repository, client redis.UniversalClient, cfg Config) *Settings {
+	return &Settings{repo: repo, redis: client, cfg: cfg, defaults: cfg.Defaults, overrides: map[string]*Override{}}
+}
+
+// SetDefaults troca os limites dos projetos sem valores gravados.
+func (s *Settings) SetDefaults(v Values) {
+	s.mu.Lock()
+	s.defaults = v
+	s.mu.Unlock()
+}
+
+// Defaults retorna os limites padrão, que valem para as requisições sem
+// projeto.
+func (s *Settings) Defaults() Values {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.defaults
+}
+
+// For retorna os limites efetivos do projeto.
+func (s *Settings) For(projectID string) Values {
+	s.mu.RLock()
+	o, d := s.overrides[projectID], s.defaults
+	s.mu.RUnlock()
+	return o.apply(d)
+}
+
+// ExportMaxBytes é o tamanho máximo das exportações do projeto, para
+// internal/snapshot.
+func (s *Settings) ExportMaxBytes(projectID string) int64 {
+	return s.For(projectID).ExportMaxBytes
+}
+
+// ActionRoles retorna os papéis aos quais o projeto restringe a ação, para
+// a política das ações.
+func (s *Settings) ActionRoles(projectID, action string) []string {
+	return s.For(projectID).ActionRoles[action]
+}
+
+// Effective descreve os limites do projeto campo a campo.
+func (s *Settings) Effective(projectID string) Effective {
+	s.mu.RLock()
+	o, d := s.overrides[projectID], s.defaults
+	s.mu.RUnlock()
+	v := o.apply(d)
+	out := Effective{ProjectID: projectID, Settings: map[string]Setting{}}
+	if o != nil {
+		updatedAt := o.UpdatedAt
+		out.UpdatedBy, out.UpdatedAt = o.UpdatedBy, &updatedAt
+	}
+	set := func(name string, value, def interface{}, overridden bool) {
+		source := SourceDefault
+		if overridden {
+			source = SourceOverride
+		}
+		out.Settings[name] = Setting{Value: value, Default: def, Source: source}
+	}
+	set("requests_per_minute", v.RequestsPerMinute, d.RequestsPerMinute, o != nil && o.RequestsPerMinute != nil)
+	set("request_timeout_ms", v.RequestTimeoutMS, d.RequestTimeoutMS, o != nil && o.RequestTimeoutMS != nil)
+	set("export_max_bytes", v.ExportMaxBytes, d.ExportMaxBytes, o != nil && o.ExportMaxBytes != nil)
+	origins, defOrigins := v.AllowedOrigins, d.AllowedOrigins
+	if origins == nil {
+		origins = []string{}
+	}
+	if defOrigins == nil {
+		defOrigins = []string{}
+	}
+	set("allowed_origins", origins, defOrigins, o != nil && o.AllowedOrigins != nil)
+	actionRoles, defActionRoles := v.ActionRoles, d.ActionRoles
+	if actionRoles == nil {
+		actionRoles = map[string][]string{}
+	}
+	if defActionRoles == nil {
+		defActionRoles = map[string][]string{}
+	}
+	set("action_roles", actionRoles, defActionRoles, o != nil && o.ActionRoles != nil)
+	return out
+}
+
+// Put grava os valores do projeto, aplica nesta réplica e avisa as demais.
+func (s *Settings) Put(ctx context.Context, projectID string, o *Override) error {
+	if err := s.repo.Put(ctx, projectID, o); err != nil {
+		return err
+	}
+	if err := s.Refresh(ctx); err != nil {
+		return err
+	}
+	if err := s.redis.Publish(ctx, s.cfg.Channel, projectID).Err(); err != nil {
+		logging.FromContext(ctx).WithError(err).Warn("Falha ao avisar as réplicas da alteração dos limites do projeto")
+	}
+	return nil
+}
+
+// Refresh relê os valores gravados.
+func (s *Settings) Refresh(ctx context.Context) error {
+	overrides, err := s.repo.List(ctx)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.overrides = overrides
+	s.mu.Unlock()
+	return nil
+}
+
+// Run lê os valores e os relê a cada aviso de alteração e a cada
+// RefreshInterval, até ctx ser cancelado. Uma leitura que falha mantém os
+// últimos valores conhecidos.
+func (s *Settings) Run(ctx context.Context) error {
+	ctx = logging.Background(ctx, "project-settings")
+	log := logging.FromContext(ctx)
+	sub := s.redis.Subscribe(ctx, s.cfg.Channel)
+	defer sub.Close()
+	refresh := func() {
+		if err := s.Refresh(ctx); err != nil && ctx.Err() == nil {
+			log.WithError(err).Warn("Falha ao ler os limites dos projetos")
+		}
+	}
+	refresh()
+	messages := sub.Channel()
+	ticker := time.NewTicker(s.cfg.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			refresh()
+		case _, ok := <-messages:
+			if !ok {
+				return errors.New("projectsettings: inscrição encerrada")
+			}
+			refresh()
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package proximity
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/logging"
+)
+
+// EventProximity é publicado quando dois agentes ficam próximos.
+const EventProximity = "agent.proximity"
+
+const (
+	listPageSize = 500
+	// settingsTTL é por quanto tempo a configuração de uma simulação vale
+	// antes de ser relida.
+	settingsTTL = time.Minute
+	// evictAfter descarta a grade de uma simulação sem ticks nesse tempo.
+	evictAfter = 5 * time.Minute
+)
+
+var (
+	proximityEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "agent_proximity_events_total",
+		Help:      "Eventos agent.proximity por resultado (emitted, dropped acima do limite do tick).",
+	}, []string{"result"})
+	checkDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "agent_service",
+		Name:      "agent_proximity_check_duration_seconds",
+		Help:      "Duração da detecção de proximidade de uma simulação num tick, sem a listagem dos agentes.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 12),
+	})
+)
+
+// AgentService é o subconjunto de agent.Service usado pelo detector.
+type AgentService interface {
+	ListAgents(ctx context.Context, f agent.Filter) ([]agent.Agent, int, error)
+	GetSimulation(ctx context.Context, id string) (*agent.Simulation, error)
+}
+
+// Config configura o detector.
+type Config struct {
+	// Thresholds são os limiares, em metros, por tipo de agente; tipos sem
+	// limiar ficam fora da detecção.
+	Thresholds map[string]float64
+	// MaxEvents limita os eventos de uma simulação num tick.
+	MaxEvents int
+}
+
+// Detector procura, a cada tick de uma simulação, os pares de agentes
+// próximos e publica agent.proximity para os que acabaram de se aproximar;
+// um par fica sem novo evento enquanto continuar próximo. Roda no relógio
+// das simulações, de uma goroutine só.
+type Detector struct {
+	agents    AgentService
+	publisher events.Publisher
+	cfg       Config
+	sims      map[string]*simulation
+}
+
+// simulation é o estado do detector para uma simulação.
+type simulation struct {
+	projectID  string
+	enabled    bool
+	thresholds map[string]float64
+	checked    time.Time
+	lastTick   time.Time
+	index      *Index
+	active     map[[2]string]struct{}
+}
+
+// NewDetector cria o detector; Tick precisa ser registrado no relógio das
+// simulações.
+func NewDetector(agents AgentService, publisher events.Publisher, cfg Config) *Detector {
+	return &Detector{agents: agents, publisher: publisher, cfg: cfg, sims: map[string]*simulation{}}
+}
+
+// Tick atualiza a grade da simulação com as posições atuais dos agentes e
+// publica os pares que ficaram próximos.
+func (d *Detector) Tick(ctx context.Context, simulationID string, tick int64) {
+	log := logging.FromContext(ctx).WithFields(logrus.Fields{"simulation_id": simulationID, "tick": tick})
+	now := time.Now()
+	d.evict(now)
+	s := d.sims[simulationID]
+	if s == nil {
+		s = &simulation{}
+		d.sims[simulationID] = s
+	}
+	s.lastTick = now
+	if now.Sub(s.checked) >= settingsTTL {
+		if err := d.load(ctx, simulationID, s); err != nil {
+			log.WithError(err).Warn("Falha ao ler a configuração de proximidade da simulação")
+			if s.checked.IsZero() {
+				return
+			}
+		} else {
+			s.checked = now
+		}
+	}
+	if !s.enabled {
+		s.index, s.active = nil, nil
+		return
+	}
+	size := 0.0
+	for _, t := range s.thresholds {
+		size = math.Max(size, t)
+	}
+	if size == 0 {
+		return
+	}
+	if s.index == nil || s.index.Size() != size {
+		s.index = NewIndex(size)
+	}
+
+	s.index.Begin()
+	f := agent.Filter{SimulationID: simulationID, PageSize: listPageSize}
+	scanned := 0
+	for f.Page = 1; ; f.Page++ {
+		page, total, err := d.agents.ListAgents(ctx, f)
+		if err != nil {
+			// Sem a lista completa, a grade ficaria sem os agentes que
+			// faltaram; o tick é pulado.
+			log.WithError(err).Error("Falha ao listar agentes para a detecção de proximidade")
+			return
+		}
+		for _, a := range page {
+			if t := s.thresholds[a.Type]; t > 0 {
+				s.index.Upsert(Member{ID: a.ID, Type: a.Type}, t, a.Position.Lat, a.Position.Lon)
+			}
+		}
+		scanned += len(page)
+		if len(page) == 0 || scanned >= total {
+			break
+		}
+	}
+
+	started := time.Now()
+	s.index.Sweep()
+	pairs := s.index.Pairs()
+	checkDuration.Observe(time.Since(started).Seconds())
+
+	active := make(map[[2]string]struct{}, len(pairs))
+	emitted, dropped := 0, 0
+	for _, p := range pairs {
+		key := [2]string{p.A.ID, p.B.ID}
+		if _, ok := s.active[key]; ok {
+			active[key] = struct{}{}
+			continue
+		}
+		// O par descartado não entra nos ativos, para ser publicado num
+		// tick com folga.
+		if emitted >= d.cfg.MaxEvents {
+			dropped++
+			continue
+		}
+		active[key] = struct{}{}
+		emitted++
+		d.publisher.Publish(ctx, events.New(events.TopicAgents, EventProximity, events.AgentProximityV1{
+			SimulationID: simulationID,
+			ProjectID:    s.projectID,
+			Tick:         tick,
+			Agents: [2]events.ProximityAgentV1{
+				{ID: p.A.ID, Type: p.A.Type},
+				{ID: p.B.ID, Type: p.B.Type},
+			},
+			DistanceM:  p.Distance,
+			ThresholdM: p.Threshold,
+		}))
+	}
+	s.active = active
+	proximityEvents.WithLabelValues("emitted").Add(float64(emitted))
+	if dropped > 0 {
+		proximityEvents.WithLabelValues("dropped").Add(float64(dropped))
+		log.WithField("dropped", dropped).Warn("Eventos de proximidade descartados acima do limite do tick")
+	}
+}
+
+// load lê da simulação o projeto e a configuração de proximidade, em
+// config.proximity: {"enabled": false} desliga a detecção e "thresholds"
+// substitui os limiares por tipo (0 tira o tipo).
+func (d *Detector) load(ctx context.Context, simulationID string, s *simulation) error {
+	sim, err := d.agents.GetSimulation(ctx, simulationID)
+	if err != nil {
+		return err
+	}
+	s.projectID, s.enabled = sim.ProjectID, true
+	s.thresholds = d.cfg.Thresholds
+	cfg, _ := sim.Config["proximity"].(map[string]interface{})
+	if on, ok := cfg["enabled"].(bool); ok {
+		s.enabled = on
+	}
+	if override, ok := cfg["thresholds"].(map[string]interface{}); ok {
+		s.thresholds = make(map[string]float64, len(d.cfg.Thresholds)+len(override))
+		for t, v := range d.cfg.Thresholds {
+			s.thresholds[t] = v
+		}
+		for t, v := range override {
+			if n, ok := v.(float64); ok && n >= 0 {
+				s.thresholds[t] = n
+			}
+		}
+	}
+	return nil
+}
+
+// evict descarta o estado das simulações que pararam de receber ticks.
+func (d *Detector) evict(now time.Time) {
+	for id, s := range d.sims {
+		if now.Sub(s.lastTick) > evictAfter {
+			delete(d.sims, id)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+// Package proximity detecta, a cada tick de uma simulação, os pares de
+// agentes que ficaram a menos do limiar de proximidade um do outro.
+//
+// As posições ficam numa grade uniforme por simulação, com células do lado
+// do maior limiar: os dois agentes de um par próximo estão na mesma célula
+// ou em células vizinhas, e cada célula só é comparada com ela mesma e com
+// quatro das oito vizinhas, para não contar o par duas vezes.
+package proximity
+
+import (
+	"math"
+	"sort"
+)
+
+const earthRadius = 6371000.0
+
+// Pair é um par de agentes próximos; A é o de menor ID.
+type Pair struct {
+	A, B Member
+	// Distance é a distância entre os dois, em metros.
+	Distance float64
+	// Threshold é o maior dos limiares dos dois tipos.
+	Threshold float64
+}
+
+// Member é um agente de um par.
+type Member struct {
+	ID   string
+	Type string
+}
+
+type cell struct{ x, y int32 }
+
+// neighbours são as células comparadas com cada célula além dela mesma.
+var neighbours = [...]cell{{1, -1}, {1, 0}, {1, 1}, {0, 1}}
+
+type entry struct {
+	Member
+	threshold float64
+	lat, lon  float64
+	x, y      float64
+	cell      cell
+	slot      int
+	seen      uint64
+}
+
+// Index é a grade de posições de uma simulação. Usa uma projeção
+// equirretangular em torno da latitude do primeiro agente, que basta para
+// as distâncias de proximidade dentro de uma cidade; perto dos polos e do
+// antimeridiano os pares podem escapar. Não é seguro para uso concorrente.
+type Index struct {
+	size   float64
+	cos    float64
+	placed bool
+	gen    uint64
+	agents map[string]*entry
+	cells  map[cell][]*entry
+}
+
+// NewIndex cria uma grade com células de size metros, que precisa ser ao
+// menos o maior limiar.
+func NewIndex(size float64) *Index {
+	return &Index{
+		size:   size,
+		agents: map[string]*entry{},
+		cells:  map[cell][]*entry{},
+	}
+}
+
+// Size é o lado das células, em metros.
+func (ix *Index) Size() float64 { return ix.size }
+
+// Len é o número de agentes na grade.
+func (ix *Index) Len() int { return len(ix.agents) }
+
+// Begin inicia uma rodada de atualizações; os agentes que não forem
+// atualizados até Sweep saem da grade.
+func (ix *Index) Begin() { ix.gen++ }
+
+// Upsert põe o agente na grade, ou move-o para a nova posição.
+func (ix *Index) Upsert(m Member, threshold, lat, lon float64) {
+	if !ix.placed {
+		ix.cos, ix.placed = math.Cos(lat*math.Pi/180), true
+	}
+	x := lon * math.Pi / 180 * earthRadius * ix.cos
+	y := lat * math.Pi / 180 * earthRadius
+	c := cell{int32(math.Floor(x / ix.size)), int32(math.Floor(y / ix.size))}
+
+	e, ok := ix.agents[m.ID]
+	if !ok {
+		e = &entry{Member: m}
+		ix.agents[m.ID] = e
+		ix.place(e, c)
+	} else if e.cell != c {
+		ix.unplace(e)
+		ix.place(e, c)
+	}
+	e.Type, e.threshold = m.Type, threshold
+	e.lat, e.lon, e.x, e.y = lat, lon, x, y
+	e.seen = ix.gen
+}
+
+// Sweep tira da grade os agentes não atualizados desde Begin.
+func (ix *Index) Sweep() {
+	for id, e := range ix.agents {
+		if e.seen != ix.gen {
+			ix.unplace(e)
+			delete(ix.agents, id)
+		}
+	}
+}
+
+func (ix *Index) place(e *entry, c cell) {
+	e.cell, e.slot = c, len(ix.cells[c])
+	ix.cells[c] = append(ix.cells[c], e)
+}
+
+// unplace tira o agente da célula trocando-o pelo último dela.
+func (ix *Index) unplace(e *entry) {
+	list := ix.cells[e.cell]
+	last := list[len(list)-1]
+	list[e.slot], last.slot = last, e.slot
+	list[len(list)-1] = nil
+	if list = list[:len(list)-1]; len(list) == 0 {
+		delete(ix.cells, e.cell)
+	} else {
+		ix.cells[e.cell] = list
+	}
+}
+
+// Pairs retorna os pares próximos, ordenados pelos IDs. Dois agentes estão
+// próximos quando a distância entre eles não passa do maior dos seus
+// limiares.
+func (ix *Index) Pairs() []Pair {
+	var out []Pair
+	for c, list := range ix.cells {
+		for i, a := range list {
+			for _, b := range list[i+1:] {
+				out = ix.check(out, a, b)
+			}
+		}
+		for _, d := range neighbours {
+			other := ix.cells[cell{c.x + d.x, c.y + d.y}]
+			if len(other) == 0 {
+				continue
+			}
+			for _, a := range list {
+				for _, b := range other {
+					out = ix.check(out, a, b)
+				}
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].A.ID != out[j].A.ID {
+			return out[i].A.ID < out[j].A.ID
+		}
+		return out[i].B.ID < out[j].B.ID
+	})
+	return out
+}
+
+func (ix *Index) check(out []Pair, a, b *entry) []Pair {
+	t := math.Max(a.threshold, b.threshold)
+	dx, dy := a.x-b.x, a.y-b.y
+	// A projeção só filtra; a distância reportada é a do grande círculo.
+	if dx*dx+dy*dy > t*t*1.01 {
+		return out
+	}
+	d := haversine(a.lat, a.lon, b.lat, b.lon)
+	if d > t {
+		return out
+	}
+	if a.ID > b.ID {
+		a, b = b, a
+	}
+	return append(out, Pair{A: a.Member, B: b.Member, Distance: d, Threshold: t})
+}
+
+func haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	const rad = math.Pi / 180
+	dLat, dLon := (lat2-lat1)*rad, (lon2-lon1)*rad
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
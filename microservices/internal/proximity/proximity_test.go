@@ -0,0 +1,170 @@
+package proximity
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/events"
+)
+
+// thresholds são os limiares por tipo dos testes, em metros.
+var thresholds = map[string]float64{"car": 15, "bus": 40, "truck": 25}
+
+// city espalha n agentes numa área de uns 10 km de lado no centro de São
+// Paulo, com os tipos de thresholds.
+func city(n int, seed int64) []agent.Agent {
+	r := rand.New(rand.NewSource(seed))
+	types := []string{"car", "car", "car", "bus", "truck"}
+	out := make([]agent.Agent, n)
+	for i := range out {
+		out[i] = agent.Agent{
+			ID:   fmt.Sprintf("a-%05d", i),
+			Type: types[r.Intn(len(types))],
+			Position: agent.Position{
+				Lat: -23.55 + (r.Float64()-0.5)*0.09,
+				Lon: -46.63 + (r.Float64()-0.5)*0.1,
+			},
+		}
+	}
+	return out
+}
+
+// move desloca cada agente até uns 30 m, como entre dois ticks.
+func move(agents []agent.Agent, r *rand.Rand) {
+	for i := range agents {
+		agents[i].Position.Lat += (r.Float64() - 0.5) * 0.0005
+		agents[i].Position.Lon += (r.Float64() - 0.5) * 0.0005
+	}
+}
+
+// bruteForce compara todos os pares, sem a grade.
+func bruteForce(agents []agent.Agent) []Pair {
+	var out []Pair
+	for i, a := range agents {
+		for _, b := range agents[i+1:] {
+			t := math.Max(thresholds[a.Type], thresholds[b.Type])
+			d := haversine(a.Position.Lat, a.Position.Lon, b.Position.Lat, b.Position.Lon)
+			if d > t {
+				continue
+			}
+			p := Pair{A: Member{ID: a.ID, Type: a.Type}, B: Member{ID: b.ID, Type: b.Type}, Distance: d, Threshold: t}
+			if p.A.ID > p.B.ID {
+				p.A, p.B = p.B, p.A
+			}
+			out = append(out, p)
+		}
+	}
+	// Os IDs de city crescem com o índice: a ordem já é a de Pairs.
+	return out
+}
+
+func update(ix *Index, agents []agent.Agent) {
+	ix.Begin()
+	for _, a := range agents {
+		ix.Upsert(Member{ID: a.ID, Type: a.Type}, thresholds[a.Type], a.Position.Lat, a.Position.Lon)
+	}
+	ix.Sweep()
+}
+
+// TestPairsMatchBruteForce confere a grade contra a comparação de todos os
+// pares, também depois de os agentes se moverem e de parte deles sair.
+func TestPairsMatchBruteForce(t *testing.T) {
+	for seed := int64(1); seed <= 2; seed++ {
+		t.Run(fmt.Sprint("seed ", seed), func(t *testing.T) {
+			r := rand.New(rand.NewSource(seed))
+			agents := city(1500, seed)
+			ix := NewIndex(40)
+			for round := 0; round < 3; round++ {
+				update(ix, agents)
+				got, want := ix.Pairs(), bruteForce(agents)
+				if len(want) == 0 {
+					t.Fatal("nenhum par próximo: a densidade do teste não exercita a grade")
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Fatalf("rodada %d: %d pares, want %d", round, len(got), len(want))
+				}
+				if ix.Len() != len(agents) {
+					t.Fatalf("rodada %d: %d agentes na grade, want %d", round, ix.Len(), len(agents))
+				}
+				move(agents, r)
+				agents = agents[:len(agents)-100]
+			}
+		})
+	}
+}
+
+// TestPairsAcrossCells confere pares em células vizinhas, inclusive nas
+// diagonais, com o maior limiar do par.
+func TestPairsAcrossCells(t *testing.T) {
+	// 1e-4 grau de latitude são uns 11,1 m.
+	agents := []agent.Agent{
+		{ID: "a", Type: "bus", Position: agent.Position{Lat: -23.5500, Lon: -46.6300}},
+		{ID: "b", Type: "car", Position: agent.Position{Lat: -23.5503, Lon: -46.6300}},
+		{ID: "c", Type: "car", Position: agent.Position{Lat: -23.5498, Lon: -46.6298}},
+		{ID: "d", Type: "car", Position: agent.Position{Lat: -23.5510, Lon: -46.6300}},
+	}
+	ix := NewIndex(40)
+	update(ix, agents)
+	var got []string
+	for _, p := range ix.Pairs() {
+		got = append(got, p.A.ID+p.B.ID)
+	}
+	// a-b (33 m) e a-c (30 m, na diagonal) valem pelo limiar do ônibus;
+	// b-c estão a uns 58 m, e d a uns 111 m de a.
+	if want := []string{"ab", "ac"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pares %v, want %v", got, want)
+	}
+}
+
+// simulationAgents lista os agentes de uma simulação em páginas, como
+// agent.Service.
+type simulationAgents struct {
+	agents []agent.Agent
+}
+
+func (s *simulationAgents) ListAgents(_ context.Context, f agent.Filter) ([]agent.Agent, int, error) {
+	from := (f.Page - 1) * f.PageSize
+	if from >= len(s.agents) {
+		return nil, len(s.agents), nil
+	}
+	to := min(from+f.PageSize, len(s.agents))
+	return s.agents[from:to], len(s.agents), nil
+}
+
+func (s *simulationAgents) GetSimulation(_ context.Context, id string) (*agent.Simulation, error) {
+	return &agent.Simulation{ID: id, ProjectID: "proj-centro"}, nil
+}
+
+type countPublisher struct{ n int }
+
+func (p *countPublisher) Publish(context.Context, events.Event) { p.n++ }
+
+// BenchmarkDetect20kAgents mede um tick do detector com 20 mil agentes que
+// se movem entre os ticks: a listagem, a atualização da grade, a busca dos
+// pares e a publicação dos novos.
+func BenchmarkDetect20kAgents(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	src := &simulationAgents{agents: city(20000, 1)}
+	pub := &countPublisher{}
+	d := NewDetector(src, pub, Config{Thresholds: thresholds, MaxEvents: 20000})
+	ctx := context.Background()
+	d.Tick(ctx, "sim-1", 0)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		move(src.agents, r)
+		b.StartTimer()
+		d.Tick(ctx, "sim-1", int64(i+1))
+	}
+	b.StopTimer()
+	if pub.n == 0 {
+		b.Fatal("nenhum evento de proximidade")
+	}
+	b.ReportMetric(float64(len(d.sims["sim-1"].active)), "pairs")
+}
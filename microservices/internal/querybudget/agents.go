@@ -0,0 +1,89 @@
+package querybudget
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Limites da listagem de agentes, os mesmos de internal/agentlist.
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+	maxScan         = 50000
+)
+
+// liveFilters são os filtros que a listagem aplica fora do repositório de
+// agentes, percorrendo as páginas dele até maxScan agentes.
+var liveFilters = []string{"health", "impaired", "capability", "offline_for"}
+
+// Agents confere GET /agents antes de qualquer um dos handlers da
+// listagem (JSON, GeoJSON, protobuf). A paginação por página lê as linhas
+// puladas; os filtros de saúde, prejuízo, capacidade e silêncio leem todos
+// os agentes dos demais filtros, estimados pelo EXPLAIN.
+func (g *Guard) Agents() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if g == nil {
+			c.Next()
+			return
+		}
+		page := intQuery(c, "page", 1)
+		size := min(intQuery(c, "page_size", defaultPageSize), maxPageSize)
+		cost := Cost{
+			Offset:     int64(page-1) * int64(size),
+			Suggestion: "narrow the filter with simulation_id, project_id or type, or read the whole simulation with GET /api/v1/simulations/{id}/agents or its export",
+		}
+		cost.Rows = cost.Offset + int64(size)
+		if live(c) {
+			cost.Offset = 0
+			if rows, ok := g.agentRows(c); ok {
+				cost.Rows = min(rows, maxScan)
+			}
+			cost.Suggestion = "health, impaired, capability and offline_for scan every agent matching the other filters; narrow them with simulation_id, project_id or type, or use GET /api/v1/simulations/{id}/agents"
+		}
+		if !g.Allow(c, "list_agents", cost) {
+			return
+		}
+		c.Next()
+	}
+}
+
+func live(c *gin.Context) bool {
+	for _, name := range liveFilters {
+		if c.Query(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// agentRows estima os agentes dos filtros que o repositório aplica. status
+// e tags ficam de fora, de modo que a estimativa é um teto.
+func (g *Guard) agentRows(c *gin.Context) (int64, bool) {
+	var where []string
+	var args []interface{}
+	join := ""
+	add := func(cond string, v interface{}) {
+		args = append(args, v)
+		where = append(where, strings.ReplaceAll(cond, "?", "$"+strconv.Itoa(len(args))))
+	}
+	if v := c.Query("type"); v != "" {
+		add("a.agent_type = ?", v)
+	}
+	if v := c.Query("simulation_id"); v != "" {
+		if _, err := uuid.Parse(v); err == nil {
+			add("a.simulation_id = ?::uuid", v)
+		}
+	}
+	if v := c.Query("project_id"); v != "" {
+		join = " JOIN simulations s ON s.id = a.simulation_id"
+		add("s.project_id = ?", v)
+	}
+	q := "SELECT 1 FROM agents a" + join
+	if len(where) > 0 {
+		q += " WHERE " + strings.Join(where, " AND ")
+	}
+	return g.Rows(c.Request.Context(), "querybudget.agents", q, args...)
+}
@@ -0,0 +1,162 @@
+// Package querybudget recusa, antes de executá-las, as consultas de
+// listagem que passariam do orçamento: as que leriam mais linhas do que
+// MaxRows, pela estimativa do planejador (EXPLAIN), ou que pulariam mais
+// de MaxOffset linhas na paginação por página. A recusa responde 422 com
+// uma sugestão (estreitar o filtro, usar a exportação). Um administrador
+// pode ignorar o orçamento com o cabeçalho OverrideHeader; cada uso fica
+// na auditoria.
+package querybudget
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/audit"
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/instrument"
+	"smart-city-microservices/internal/logging"
+)
+
+// OverrideHeader pede, com "true", que o orçamento seja ignorado; só vale
+// para o papel admin.
+const OverrideHeader = "X-Query-Budget-Override"
+
+// explainTimeout limita a estimativa; sem ela a consulta segue.
+const explainTimeout = 2 * time.Second
+
+var (
+	rejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "query_budget_rejections_total",
+		Help:      "Consultas recusadas por passar do orçamento, por endpoint.",
+	}, []string{"endpoint"})
+
+	overrides = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "query_budget_overrides_total",
+		Help:      "Consultas acima do orçamento executadas com OverrideHeader, por endpoint.",
+	}, []string{"endpoint"})
+)
+
+// Config é o orçamento de cada consulta.
+type Config struct {
+	// MaxRows limita as linhas que a consulta leria.
+	MaxRows int64
+	// MaxOffset limita as linhas puladas na paginação por página.
+	MaxOffset int64
+}
+
+// Cost é o custo estimado de uma consulta.
+type Cost struct {
+	// Rows são as linhas que a consulta leria; Offset, as puladas antes da
+	// página pedida.
+	Rows   int64
+	Offset int64
+	// Suggestion vai na recusa, em inglês, como as mensagens de erro da API.
+	Suggestion string
+}
+
+// Guard confere as consultas contra o orçamento. Um *Guard nil deixa
+// todas passarem.
+type Guard struct {
+	db  *instrument.DB
+	cfg Config
+}
+
+// New cria o guard; as estimativas usam db.
+func New(db *sql.DB, cfg Config) *Guard {
+	return &Guard{db: instrument.NewDB(db), cfg: cfg}
+}
+
+// Allow informa se a consulta de endpoint pode ser executada. Acima do
+// orçamento, responde 422 e retorna false, a menos que um administrador
+// tenha pedido para ignorá-lo.
+func (g *Guard) Allow(c *gin.Context, endpoint string, cost Cost) bool {
+	if g == nil {
+		return true
+	}
+	over := g.cfg.MaxRows > 0 && cost.Rows > g.cfg.MaxRows
+	if g.cfg.MaxOffset > 0 && cost.Offset > g.cfg.MaxOffset {
+		over = true
+	}
+	if !over {
+		return true
+	}
+	ctx := c.Request.Context()
+	fields := logrus.Fields{"endpoint": endpoint, "estimated_rows": cost.Rows, "offset": cost.Offset}
+	if c.GetHeader(OverrideHeader) == "true" && auth.FromGin(c).HasRole(auth.RoleAdmin) {
+		overrides.WithLabelValues(endpoint).Inc()
+		audit.Record(ctx, "query_budget.overridden", fields)
+		return true
+	}
+	rejections.WithLabelValues(endpoint).Inc()
+	logging.FromContext(ctx).WithFields(fields).Info("Consulta recusada por passar do orçamento")
+	body := gin.H{
+		"error":          "query exceeds budget",
+		"estimated_rows": cost.Rows,
+		"max_rows":       g.cfg.MaxRows,
+		"suggestion":     cost.Suggestion,
+	}
+	if cost.Offset > 0 {
+		body["offset"], body["max_offset"] = cost.Offset, g.cfg.MaxOffset
+	}
+	c.AbortWithStatusJSON(http.StatusUnprocessableEntity, body)
+	return false
+}
+
+// Rows estima pelo planejador as linhas que query retornaria, sem
+// executá-la. Com ok false (o banco falhou ou demorou), a consulta segue
+// sem estimativa.
+func (g *Guard) Rows(ctx context.Context, name, query string, args ...interface{}) (rows int64, ok bool) {
+	if g == nil {
+		return 0, false
+	}
+	rows, err := ExplainRows(ctx, g.db, name, query, args...)
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).WithField("query", name).Warn("Falha ao estimar o custo da consulta")
+		return 0, false
+	}
+	return rows, true
+}
+
+// ExplainRows retorna as linhas estimadas pelo EXPLAIN de query, para os
+// repositórios que conhecem as próprias consultas.
+func ExplainRows(ctx context.Context, db *instrument.DB, name, query string, args ...interface{}) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, explainTimeout)
+	defer cancel()
+	var raw []byte
+	if err := db.QueryRow(ctx, name, "EXPLAIN (FORMAT JSON) "+query, args...).Scan(&raw); err != nil {
+		return 0, err
+	}
+	var plan []struct {
+		Plan struct {
+			Rows float64 `json:"Plan Rows"`
+		} `json:"Plan"`
+	}
+	if err := json.Unmarshal(raw, &plan); err != nil {
+		return 0, err
+	}
+	if len(plan) == 0 {
+		return 0, nil
+	}
+	return int64(plan[0].Plan.Rows), nil
+}
+
+// intQuery lê um inteiro positivo da query; ausente ou inválido, def (o
+// handler da listagem responde o 400).
+func intQuery(c *gin.Context, name string, def int) int {
+	n, err := strconv.Atoi(c.Query(name))
+	if err != nil || n < 1 {
+		return def
+	}
+	return n
+}
@@ -0,0 +1,224 @@
+package querybudget
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/logging"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+	sql.Register("querybudgettest", planDriver{})
+}
+
+// plans são os planos por DSN: as linhas estimadas ou a falha do EXPLAIN, e
+// as consultas recebidas.
+var (
+	plansMu sync.Mutex
+	plans   = map[string]*plan{}
+)
+
+type plan struct {
+	rows    int64
+	fail    bool
+	queries []string
+}
+
+func (p *plan) record(query string, args []driver.Value) {
+	plansMu.Lock()
+	defer plansMu.Unlock()
+	p.queries = append(p.queries, fmt.Sprintf("%s %v", query, args))
+}
+
+// planDriver responde a um EXPLAIN (FORMAT JSON) com o plano do DSN.
+type planDriver struct{}
+
+func (planDriver) Open(dsn string) (driver.Conn, error) {
+	plansMu.Lock()
+	defer plansMu.Unlock()
+	return planConn{plans[dsn]}, nil
+}
+
+type planConn struct{ p *plan }
+
+func (c planConn) Prepare(query string) (driver.Stmt, error) { return planStmt{c.p, query}, nil }
+func (planConn) Close() error                                { return nil }
+func (planConn) Begin() (driver.Tx, error)                   { return nil, errors.New("sem transações") }
+
+type planStmt struct {
+	p     *plan
+	query string
+}
+
+func (planStmt) Close() error  { return nil }
+func (planStmt) NumInput() int { return -1 }
+func (planStmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, errors.New("só consultas")
+}
+func (s planStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.p.record(s.query, args)
+	if s.p.fail {
+		return nil, errors.New("statement timeout")
+	}
+	return &planRows{raw: fmt.Sprintf(`[{"Plan":{"Node Type":"Seq Scan","Plan Rows":%d}}]`, s.p.rows)}, nil
+}
+
+type planRows struct {
+	raw  string
+	done bool
+}
+
+func (*planRows) Columns() []string { return []string{"QUERY PLAN"} }
+func (*planRows) Close() error      { return nil }
+func (r *planRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = []byte(r.raw)
+	return nil
+}
+
+func newGuard(t *testing.T, p *plan) *Guard {
+	t.Helper()
+	plansMu.Lock()
+	plans[t.Name()] = p
+	plansMu.Unlock()
+	db, err := sql.Open("querybudgettest", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return New(db, Config{MaxRows: 10000, MaxOffset: 5000})
+}
+
+// newRouter monta GET /agents com o guard antes da listagem, que só marca
+// que foi chamada, e com os logs da requisição no hook retornado.
+func newRouter(g *Guard) (*gin.Engine, *bool, *test.Hook) {
+	logger, hook := test.NewNullLogger()
+	listed := new(bool)
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Request = c.Request.WithContext(logging.WithLogger(c.Request.Context(), logrus.NewEntry(logger)))
+		c.Next()
+	})
+	r.Use(auth.StaticToken("secret"))
+	r.GET("/agents", g.Agents(), func(c *gin.Context) {
+		*listed = true
+		c.Status(http.StatusOK)
+	})
+	return r, listed, hook
+}
+
+func TestAgents(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		plan    plan
+		headers map[string]string
+		status  int
+		// explain é a consulta estimada, com os argumentos; vazia, nenhuma.
+		explain string
+		rows    int64
+		audited bool
+	}{
+		{name: "first page", query: "page_size=100", status: http.StatusOK},
+		{name: "offset within budget", query: "page=50&page_size=100", status: http.StatusOK},
+		{name: "offset over budget", query: "page=52&page_size=100", status: http.StatusUnprocessableEntity, rows: 5200},
+		{name: "page size capped", query: "page=60&page_size=1000", status: http.StatusUnprocessableEntity, rows: 6000},
+		{
+			name: "live filter within budget", query: "health=healthy&type=bus", plan: plan{rows: 900}, status: http.StatusOK,
+			explain: "EXPLAIN (FORMAT JSON) SELECT 1 FROM agents a WHERE a.agent_type = $1 [bus]",
+		},
+		{
+			name: "live filter over budget", query: "capability=gps&project_id=proj-1&simulation_id=not-a-uuid", plan: plan{rows: 80000},
+			status:  http.StatusUnprocessableEntity,
+			explain: "EXPLAIN (FORMAT JSON) SELECT 1 FROM agents a JOIN simulations s ON s.id = a.simulation_id WHERE s.project_id = $1 [proj-1]",
+			rows:    maxScan,
+		},
+		{
+			name: "live filter on a simulation", query: "offline_for=5m&simulation_id=6f1c2f3e-8a4b-4c1d-9e2f-0a1b2c3d4e5f&page=900", plan: plan{rows: 40},
+			status:  http.StatusOK,
+			explain: "EXPLAIN (FORMAT JSON) SELECT 1 FROM agents a WHERE a.simulation_id = $1::uuid [6f1c2f3e-8a4b-4c1d-9e2f-0a1b2c3d4e5f]",
+		},
+		{
+			name: "estimate failed", query: "impaired=true", plan: plan{fail: true}, status: http.StatusOK,
+			explain: "EXPLAIN (FORMAT JSON) SELECT 1 FROM agents a []",
+		},
+		{
+			name: "admin override", query: "page=300", headers: map[string]string{OverrideHeader: "true", "X-Admin-Token": "secret"},
+			status: http.StatusOK, audited: true,
+		},
+		{name: "override without admin", query: "page=300", headers: map[string]string{OverrideHeader: "true"}, status: http.StatusUnprocessableEntity, rows: 6000},
+		{name: "admin without override", query: "page=300", headers: map[string]string{"X-Admin-Token": "secret"}, status: http.StatusUnprocessableEntity, rows: 6000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.plan
+			r, listed, hook := newRouter(newGuard(t, &p))
+			req := httptest.NewRequest(http.MethodGet, "/agents?"+tt.query, nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			if *listed != (tt.status == http.StatusOK) {
+				t.Errorf("listagem chamada = %v", *listed)
+			}
+			if got := strings.Join(p.queries, "\n"); got != tt.explain {
+				t.Errorf("EXPLAIN %q, want %q", got, tt.explain)
+			}
+			audited := false
+			for _, e := range hook.AllEntries() {
+				if e.Data["action"] == "query_budget.overridden" {
+					audited = true
+				}
+			}
+			if audited != tt.audited {
+				t.Errorf("auditado = %v, want %v", audited, tt.audited)
+			}
+			if tt.status != http.StatusUnprocessableEntity {
+				return
+			}
+			var body struct {
+				Error         string `json:"error"`
+				EstimatedRows int64  `json:"estimated_rows"`
+				MaxRows       int64  `json:"max_rows"`
+				Suggestion    string `json:"suggestion"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Error != "query exceeds budget" || body.EstimatedRows != tt.rows || body.MaxRows != 10000 || body.Suggestion == "" {
+				t.Errorf("corpo %+v", body)
+			}
+		})
+	}
+}
+
+func TestNilGuard(t *testing.T) {
+	r, listed, _ := newRouter(nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/agents?page=100000&health=healthy", nil))
+	if w.Code != http.StatusOK || !*listed {
+		t.Errorf("status %d, listagem chamada = %v", w.Code, *listed)
+	}
+}
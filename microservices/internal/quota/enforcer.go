@@ -0,0 +1,113 @@
+package quota
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/audit"
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/logging"
+)
+
+var rejections = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "agent_service",
+	Name:      "quota_rejections_total",
+	Help:      "Operações recusadas por estouro de cota, por recurso.",
+}, []string{"resource"})
+
+// Enforcer confere o uso dos projetos contra as cotas.
+type Enforcer struct {
+	repo      *Repository
+	defaults  Amounts
+	publisher events.Publisher
+}
+
+// NewEnforcer cria a verificação. defaults são os limites dos projetos sem
+// cota própria; 0 é sem limite.
+func NewEnforcer(repo *Repository, defaults Amounts, publisher events.Publisher) *Enforcer {
+	return &Enforcer{repo: repo, defaults: defaults, publisher: publisher}
+}
+
+// Limits retorna os limites do projeto e a cota própria, se houver.
+func (e *Enforcer) Limits(ctx context.Context, projectID string) (Amounts, *Override, error) {
+	o, err := e.repo.Override(ctx, projectID)
+	if err != nil {
+		return Amounts{}, nil, err
+	}
+	return o.apply(e.defaults), o, nil
+}
+
+// Allow confere se o projeto comporta add nos recursos informados e
+// retorna *ExceededError no primeiro estourado. Um recurso sem acréscimo
+// em add fica bloqueado quando o uso atinge o limite. Projeto vazio não
+// tem cota.
+func (e *Enforcer) Allow(ctx context.Context, projectID string, add Amounts, resources ...string) error {
+	if projectID == "" {
+		return nil
+	}
+	limits, _, err := e.Limits(ctx, projectID)
+	if err != nil {
+		return err
+	}
+	u, err := e.repo.Usage(ctx, projectID)
+	if err != nil {
+		return err
+	}
+	for _, r := range resources {
+		if limit := limits.Get(r); over(u.Get(r), add.Get(r), limit) {
+			rejections.WithLabelValues(r).Inc()
+			e.exceeded(ctx, u, r, limit)
+			return &ExceededError{ProjectID: projectID, Resource: r, Limit: limit, Used: u.Get(r)}
+		}
+	}
+	return nil
+}
+
+// Evaluate atualiza os estouros registrados do projeto para o uso u: os
+// novos geram evento e auditoria, e os recursos que voltaram ao limite
+// deixam de constar.
+func (e *Enforcer) Evaluate(ctx context.Context, u *Usage, limits Amounts) {
+	marked := map[string]bool{}
+	for _, r := range u.Exceeded {
+		marked[r] = true
+	}
+	for _, r := range Resources {
+		limit := limits.Get(r)
+		switch {
+		case over(u.Get(r), 0, limit):
+			e.exceeded(ctx, u, r, limit)
+		case marked[r]:
+			if err := e.repo.ClearExceeded(ctx, u.ProjectID, r); err != nil {
+				logging.FromContext(ctx).WithError(err).WithField("project_id", u.ProjectID).Warn("Falha ao limpar estouro de cota")
+			}
+		}
+	}
+}
+
+// exceeded registra o estouro e, se ele é novo, o audita e publica
+// EventQuotaExceeded.
+func (e *Enforcer) exceeded(ctx context.Context, u *Usage, resource string, limit int64) {
+	fresh, err := e.repo.MarkExceeded(ctx, u.ProjectID, resource)
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).WithField("project_id", u.ProjectID).Warn("Falha ao registrar estouro de cota")
+		return
+	}
+	if !fresh {
+		return
+	}
+	audit.Record(ctx, EventQuotaExceeded, logrus.Fields{
+		"project_id": u.ProjectID,
+		"resource":   resource,
+		"limit":      limit,
+		"used":       u.Get(resource),
+	})
+	e.publisher.Publish(ctx, events.New(events.TopicAdmin, EventQuotaExceeded, events.QuotaExceededV1{
+		ProjectID: u.ProjectID,
+		Resource:  resource,
+		Limit:     limit,
+		Used:      u.Get(resource),
+	}))
+}
@@ -0,0 +1,257 @@
+package quota
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/audit"
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/i18n"
+	"smart-city-microservices/internal/logging"
+)
+
+// SimulationGetter é o subconjunto de agent.Service usado para achar o
+// projeto de um agente novo pela simulação.
+type SimulationGetter interface {
+	GetSimulation(ctx context.Context, id string) (*agent.Simulation, error)
+}
+
+// Handler expõe o uso e as cotas dos projetos e aplica as cotas nas rotas
+// de criação.
+type Handler struct {
+	enforcer    *Enforcer
+	simulations SimulationGetter
+	publisher   events.Publisher
+}
+
+// NewHandler cria o handler de cotas.
+func NewHandler(enforcer *Enforcer, simulations SimulationGetter, publisher events.Publisher) *Handler {
+	return &Handler{enforcer: enforcer, simulations: simulations, publisher: publisher}
+}
+
+// usageResponse é a resposta de GET /projects/:id/usage. Exceeded são os
+// recursos que atingiram o limite e bloqueiam as criações.
+type usageResponse struct {
+	*Usage
+	Limits   Amounts   `json:"limits"`
+	Override *Override `json:"override"`
+	Exceeded []string  `json:"exceeded"`
+}
+
+// Usage responde GET /projects/:id/usage com o uso, os limites efetivos (0
+// é sem limite) e a cota própria do projeto, se houver.
+func (h *Handler) Usage(c *gin.Context) {
+	ctx := c.Request.Context()
+	projectID := c.Param("id")
+	if !auth.FromGin(c).InProject(projectID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "project " + projectID + " is not accessible"})
+		return
+	}
+	u, err := h.enforcer.repo.Usage(ctx, projectID)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	limits, o, err := h.enforcer.Limits(ctx, projectID)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, h.response(u, limits, o))
+}
+
+func (h *Handler) response(u *Usage, limits Amounts, o *Override) usageResponse {
+	resp := usageResponse{Usage: u, Limits: limits, Override: o, Exceeded: []string{}}
+	for _, r := range Resources {
+		if over(u.Get(r), 0, limits.Get(r)) {
+			resp.Exceeded = append(resp.Exceeded, r)
+		}
+	}
+	return resp
+}
+
+// PutQuota responde PUT /admin/projects/:id/quota: grava a cota própria do
+// projeto. Campos nulos ou ausentes usam o padrão; 0 é sem limite.
+func (h *Handler) PutQuota(c *gin.Context) {
+	var o Override
+	if err := c.ShouldBindJSON(&o); err != nil {
+		i18n.BindError(c, err)
+		return
+	}
+	for _, v := range []*int64{o.Agents, o.EventRows, o.CheckpointBytes, o.ArchiveBytes} {
+		if v != nil && *v < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "quota limits must not be negative"})
+			return
+		}
+	}
+	ctx := c.Request.Context()
+	projectID := c.Param("id")
+	subject := ""
+	if p := auth.FromGin(c); p != nil {
+		subject = p.Subject
+	}
+	if err := h.enforcer.repo.PutOverride(ctx, projectID, o, subject); err != nil {
+		h.internalError(c, err)
+		return
+	}
+	h.updated(c, projectID, true)
+}
+
+// DeleteQuota responde DELETE /admin/projects/:id/quota: o projeto volta à
+// cota padrão.
+func (h *Handler) DeleteQuota(c *gin.Context) {
+	ctx := c.Request.Context()
+	projectID := c.Param("id")
+	found, err := h.enforcer.repo.DeleteOverride(ctx, projectID)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "project has no quota override"})
+		return
+	}
+	h.updated(c, projectID, false)
+}
+
+// updated audita e publica a mudança de cota, reavalia os estouros com os
+// limites novos e responde o uso.
+func (h *Handler) updated(c *gin.Context, projectID string, override bool) {
+	ctx := c.Request.Context()
+	limits, o, err := h.enforcer.Limits(ctx, projectID)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	u, err := h.enforcer.repo.Usage(ctx, projectID)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	audit.Record(ctx, EventQuotaUpdated, logrus.Fields{
+		"project_id": projectID,
+		"override":   override,
+		"limits":     limits,
+	})
+	h.publisher.Publish(ctx, events.New(events.TopicAdmin, EventQuotaUpdated, events.QuotaUpdatedV1{
+		ProjectID: projectID,
+		Override:  override,
+		Limits: events.QuotaLimitsV1{
+			Agents:          limits.Agents,
+			EventRows:       limits.EventRows,
+			CheckpointBytes: limits.CheckpointBytes,
+			ArchiveBytes:    limits.ArchiveBytes,
+		},
+	}))
+	h.enforcer.Evaluate(ctx, u, limits)
+	c.JSON(http.StatusOK, h.response(u, limits, o))
+}
+
+// Reconcile responde POST /admin/quotas/reconcile: recalcula o uso de
+// todos os projetos na hora, fora da janela periódica.
+func (h *Handler) Reconcile(c *gin.Context) {
+	ctx := c.Request.Context()
+	usage, err := h.enforcer.Reconcile(ctx)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	audit.Record(ctx, "project.usage_reconciled", logrus.Fields{"projects": len(usage)})
+	if usage == nil {
+		usage = []*Usage{}
+	}
+	c.JSON(http.StatusOK, gin.H{"data": usage})
+}
+
+// CreateSimulation deve vir antes de POST /simulations: recusa com 403 a
+// simulação de um projeto que atingiu a cota de linhas de eventos, de
+// checkpoints ou de arquivos.
+func (h *Handler) CreateSimulation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			ProjectID string `json:"project_id"`
+		}
+		if !peekJSON(c, &req) {
+			c.Next()
+			return
+		}
+		h.enforce(c, req.ProjectID, Amounts{}, ResourceEventRows, ResourceCheckpointBytes, ResourceArchiveBytes)
+	}
+}
+
+// CreateAgent deve vir antes de POST /agents: recusa com 403 o agente de um
+// projeto que atingiu a cota de agentes. Sem project_id, vale o projeto da
+// simulação.
+func (h *Handler) CreateAgent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			ProjectID    string `json:"project_id"`
+			SimulationID string `json:"simulation_id"`
+		}
+		if !peekJSON(c, &req) {
+			c.Next()
+			return
+		}
+		if req.ProjectID == "" && req.SimulationID != "" {
+			sim, err := h.simulations.GetSimulation(c.Request.Context(), req.SimulationID)
+			switch {
+			case errors.Is(err, agent.ErrNotFound):
+				// O handler de criação responde pela simulação inexistente.
+				c.Next()
+				return
+			case err != nil:
+				h.internalError(c, err)
+				c.Abort()
+				return
+			}
+			req.ProjectID = sim.ProjectID
+		}
+		h.enforce(c, req.ProjectID, Amounts{Agents: 1}, ResourceAgents)
+	}
+}
+
+// enforce segue adiante se o projeto comporta add, ou responde 403 com o
+// recurso estourado.
+func (h *Handler) enforce(c *gin.Context, projectID string, add Amounts, resources ...string) {
+	err := h.enforcer.Allow(c.Request.Context(), projectID, add, resources...)
+	var ex *ExceededError
+	switch {
+	case errors.As(err, &ex):
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"error":    ex.Error(),
+			"resource": ex.Resource,
+			"limit":    ex.Limit,
+			"usage":    ex.Used,
+		})
+	case err != nil:
+		h.internalError(c, err)
+		c.Abort()
+	default:
+		c.Next()
+	}
+}
+
+// peekJSON decodifica o corpo em v e o devolve à requisição para o próximo
+// handler. Um corpo ilegível fica para ele recusar.
+func peekJSON(c *gin.Context, v interface{}) bool {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return false
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	return json.Unmarshal(body, v) == nil
+}
+
+func (h *Handler) internalError(c *gin.Context, err error) {
+	logging.FromContext(c.Request.Context()).WithError(err).Error("Erro no handler de cotas")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
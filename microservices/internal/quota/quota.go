@@ -0,0 +1,130 @@
+// Package quota acompanha o uso de armazenamento de cada projeto (agentes,
+// linhas de eventos, bytes de checkpoints e de arquivos) e aplica as cotas:
+// com uma cota estourada, as operações que geram muitos dados (criar
+// simulações e agentes) respondem 403, enquanto as leituras continuam.
+//
+// O uso fica em project_usage. O Tracker o mantém a partir dos eventos do
+// barramento e o arquivamento, a partir do que move; o que outros serviços
+// gravam direto no banco, como as linhas de events da engine de simulação,
+// só entra na reconciliação periódica, que recalcula tudo a partir das
+// tabelas.
+package quota
+
+import (
+	"fmt"
+	"time"
+)
+
+// Recursos com cota.
+const (
+	ResourceAgents          = "agents"
+	ResourceEventRows       = "event_rows"
+	ResourceCheckpointBytes = "checkpoint_bytes"
+	ResourceArchiveBytes    = "archive_bytes"
+)
+
+// Resources são os recursos com cota, na ordem das respostas.
+var Resources = []string{ResourceAgents, ResourceEventRows, ResourceCheckpointBytes, ResourceArchiveBytes}
+
+// Eventos publicados em events.TopicAdmin.
+const (
+	EventQuotaUpdated  = "project.quota_updated"
+	EventQuotaExceeded = "project.quota_exceeded"
+)
+
+// Amounts são quantidades por recurso: o uso, um acréscimo ou os limites.
+type Amounts struct {
+	Agents          int64 `json:"agents"`
+	EventRows       int64 `json:"event_rows"`
+	CheckpointBytes int64 `json:"checkpoint_bytes"`
+	ArchiveBytes    int64 `json:"archive_bytes"`
+}
+
+// Get retorna a quantidade de um recurso.
+func (a Amounts) Get(resource string) int64 {
+	switch resource {
+	case ResourceAgents:
+		return a.Agents
+	case ResourceEventRows:
+		return a.EventRows
+	case ResourceCheckpointBytes:
+		return a.CheckpointBytes
+	case ResourceArchiveBytes:
+		return a.ArchiveBytes
+	}
+	return 0
+}
+
+func (a Amounts) add(b Amounts) Amounts {
+	return Amounts{
+		Agents:          a.Agents + b.Agents,
+		EventRows:       a.EventRows + b.EventRows,
+		CheckpointBytes: a.CheckpointBytes + b.CheckpointBytes,
+		ArchiveBytes:    a.ArchiveBytes + b.ArchiveBytes,
+	}
+}
+
+func (a Amounts) zero() bool { return a == Amounts{} }
+
+// Usage é o uso de um projeto. Exceeded são os recursos cujo estouro já
+// foi registrado, para que cada estouro gere um único evento.
+type Usage struct {
+	ProjectID    string `json:"project_id"`
+	Amounts      `json:"usage"`
+	Exceeded     []string   `json:"-"`
+	UpdatedAt    *time.Time `json:"updated_at"`
+	ReconciledAt *time.Time `json:"reconciled_at"`
+}
+
+// Override é a cota própria de um projeto; campos nulos usam o padrão.
+type Override struct {
+	Agents          *int64 `json:"agents"`
+	EventRows       *int64 `json:"event_rows"`
+	CheckpointBytes *int64 `json:"checkpoint_bytes"`
+	ArchiveBytes    *int64 `json:"archive_bytes"`
+}
+
+// apply sobrepõe a cota do projeto aos limites padrão.
+func (o *Override) apply(l Amounts) Amounts {
+	if o == nil {
+		return l
+	}
+	for _, f := range []struct {
+		v   *int64
+		dst *int64
+	}{
+		{o.Agents, &l.Agents},
+		{o.EventRows, &l.EventRows},
+		{o.CheckpointBytes, &l.CheckpointBytes},
+		{o.ArchiveBytes, &l.ArchiveBytes},
+	} {
+		if f.v != nil {
+			*f.dst = *f.v
+		}
+	}
+	return l
+}
+
+// over indica se o uso mais add passa do limite; 0 é sem limite. Sem
+// acréscimo conhecido (add 0), atingir o limite já basta.
+func over(used, add, limit int64) bool {
+	if limit <= 0 {
+		return false
+	}
+	if add == 0 {
+		return used >= limit
+	}
+	return used+add > limit
+}
+
+// ExceededError é a operação recusada por estourar a cota de um recurso.
+type ExceededError struct {
+	ProjectID string
+	Resource  string
+	Limit     int64
+	Used      int64
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("project %s exceeded its %s quota (%d of %d)", e.ProjectID, e.Resource, e.Used, e.Limit)
+}
@@ -0,0 +1,193 @@
+package quota
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+
+	"smart-city-microservices/internal/instrument"
+)
+
+// Repository persiste o uso e as cotas dos projetos no PostgreSQL.
+type Repository struct {
+	db *instrument.DB
+}
+
+// NewRepository cria o repositório.
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: instrument.NewDB(db)}
+}
+
+const usageColumns = `project_id, agents, event_rows, checkpoint_bytes, archive_bytes, exceeded, updated_at, reconciled_at`
+
+func scanUsage(row interface{ Scan(...interface{}) error }) (*Usage, error) {
+	var u Usage
+	var updated sql.NullTime
+	err := row.Scan(&u.ProjectID, &u.Agents, &u.EventRows, &u.CheckpointBytes, &u.ArchiveBytes,
+		pq.Array(&u.Exceeded), &updated, &u.ReconciledAt)
+	if updated.Valid {
+		u.UpdatedAt = &updated.Time
+	}
+	return &u, err
+}
+
+// Usage retorna o uso do projeto; um projeto sem registro tem uso zero.
+func (r *Repository) Usage(ctx context.Context, projectID string) (*Usage, error) {
+	u, err := scanUsage(r.db.QueryRow(ctx, "quota.usage",
+		`SELECT `+usageColumns+` FROM project_usage WHERE project_id = $1`, projectID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return &Usage{ProjectID: projectID}, nil
+	}
+	return u, err
+}
+
+// Add soma d ao uso do projeto. Nenhum contador fica negativo: a
+// reconciliação corrige o que se perder com isso.
+func (r *Repository) Add(ctx context.Context, projectID string, d Amounts) error {
+	_, err := r.db.Exec(ctx, "quota.add", `
+		INSERT INTO project_usage AS u (project_id, agents, event_rows, checkpoint_bytes, archive_bytes)
+		VALUES ($1, GREATEST($2, 0), GREATEST($3, 0), GREATEST($4, 0), GREATEST($5, 0))
+		ON CONFLICT (project_id) DO UPDATE SET
+			agents = GREATEST(u.agents + $2, 0),
+			event_rows = GREATEST(u.event_rows + $3, 0),
+			checkpoint_bytes = GREATEST(u.checkpoint_bytes + $4, 0),
+			archive_bytes = GREATEST(u.archive_bytes + $5, 0),
+			updated_at = CURRENT_TIMESTAMP`,
+		projectID, d.Agents, d.EventRows, d.CheckpointBytes, d.ArchiveBytes)
+	return err
+}
+
+// MarkExceeded registra o estouro de resource no projeto e indica se ele é
+// novo; só a primeira réplica a registrá-lo recebe true.
+func (r *Repository) MarkExceeded(ctx context.Context, projectID, resource string) (bool, error) {
+	res, err := r.db.Exec(ctx, "quota.mark_exceeded", `
+		INSERT INTO project_usage AS u (project_id, exceeded) VALUES ($1, ARRAY[$2::text])
+		ON CONFLICT (project_id) DO UPDATE SET exceeded = array_append(u.exceeded, $2::text)
+		WHERE NOT $2::text = ANY(u.exceeded)`, projectID, resource)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// ClearExceeded retira resource dos estouros registrados do projeto.
+func (r *Repository) ClearExceeded(ctx context.Context, projectID, resource string) error {
+	_, err := r.db.Exec(ctx, "quota.clear_exceeded", `
+		UPDATE project_usage SET exceeded = array_remove(exceeded, $2::text)
+		WHERE project_id = $1 AND $2::text = ANY(exceeded)`, projectID, resource)
+	return err
+}
+
+// Override retorna a cota própria do projeto, ou nil se ele usa o padrão.
+func (r *Repository) Override(ctx context.Context, projectID string) (*Override, error) {
+	var o Override
+	err := r.db.QueryRow(ctx, "quota.override", `
+		SELECT agents, event_rows, checkpoint_bytes, archive_bytes FROM project_quotas WHERE project_id = $1`,
+		projectID).Scan(&o.Agents, &o.EventRows, &o.CheckpointBytes, &o.ArchiveBytes)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// Overrides retorna as cotas próprias de todos os projetos que as têm.
+func (r *Repository) Overrides(ctx context.Context) (map[string]*Override, error) {
+	rows, err := r.db.Query(ctx, "quota.overrides",
+		`SELECT project_id, agents, event_rows, checkpoint_bytes, archive_bytes FROM project_quotas`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string]*Override{}
+	for rows.Next() {
+		var id string
+		var o Override
+		if err := rows.Scan(&id, &o.Agents, &o.EventRows, &o.CheckpointBytes, &o.ArchiveBytes); err != nil {
+			return nil, err
+		}
+		out[id] = &o
+	}
+	return out, rows.Err()
+}
+
+// PutOverride grava a cota própria do projeto.
+func (r *Repository) PutOverride(ctx context.Context, projectID string, o Override, updatedBy string) error {
+	_, err := r.db.Exec(ctx, "quota.put_override", `
+		INSERT INTO project_quotas (project_id, agents, event_rows, checkpoint_bytes, archive_bytes, updated_by)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''))
+		ON CONFLICT (project_id) DO UPDATE SET
+			agents = EXCLUDED.agents,
+			event_rows = EXCLUDED.event_rows,
+			checkpoint_bytes = EXCLUDED.checkpoint_bytes,
+			archive_bytes = EXCLUDED.archive_bytes,
+			updated_by = EXCLUDED.updated_by,
+			updated_at = CURRENT_TIMESTAMP`,
+		projectID, o.Agents, o.EventRows, o.CheckpointBytes, o.ArchiveBytes, updatedBy)
+	return err
+}
+
+// DeleteOverride volta o projeto à cota padrão e indica se ele tinha uma
+// própria.
+func (r *Repository) DeleteOverride(ctx context.Context, projectID string) (bool, error) {
+	res, err := r.db.Exec(ctx, "quota.delete_override", `DELETE FROM project_quotas WHERE project_id = $1`, projectID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Reconcile recalcula o uso de todos os projetos a partir das tabelas e
+// retorna o resultado. Agentes e linhas de eventos contam pelo projeto da
+// simulação; os bytes de arquivos, por simulation_archives. Os bytes de
+// checkpoints não têm tabela de origem e ficam como estão. Acréscimos
+// gravados durante a contagem podem ser sobrescritos por ela e voltam na
+// próxima.
+func (r *Repository) Reconcile(ctx context.Context) ([]*Usage, error) {
+	rows, err := r.db.Query(ctx, "quota.reconcile", `
+		WITH agent_counts AS (
+			SELECT s.project_id, COUNT(*) AS n FROM agents a JOIN simulations s ON s.id = a.simulation_id
+			WHERE s.project_id IS NOT NULL GROUP BY s.project_id
+		), event_counts AS (
+			SELECT s.project_id, COUNT(*) AS n FROM events e JOIN simulations s ON s.id = e.simulation_id
+			WHERE s.project_id IS NOT NULL GROUP BY s.project_id
+		), archive_sizes AS (
+			SELECT project_id, SUM(size_bytes) AS n FROM simulation_archives
+			WHERE project_id IS NOT NULL GROUP BY project_id
+		), projects AS (
+			SELECT project_id FROM agent_counts UNION SELECT project_id FROM event_counts
+			UNION SELECT project_id FROM archive_sizes UNION SELECT project_id FROM project_usage
+		)
+		INSERT INTO project_usage AS u (project_id, agents, event_rows, archive_bytes, reconciled_at)
+		SELECT p.project_id, COALESCE(a.n, 0), COALESCE(e.n, 0), COALESCE(s.n, 0), CURRENT_TIMESTAMP
+		FROM projects p
+		LEFT JOIN agent_counts a ON a.project_id = p.project_id
+		LEFT JOIN event_counts e ON e.project_id = p.project_id
+		LEFT JOIN archive_sizes s ON s.project_id = p.project_id
+		ON CONFLICT (project_id) DO UPDATE SET
+			agents = EXCLUDED.agents,
+			event_rows = EXCLUDED.event_rows,
+			archive_bytes = EXCLUDED.archive_bytes,
+			updated_at = CURRENT_TIMESTAMP,
+			reconciled_at = EXCLUDED.reconciled_at
+		RETURNING `+usageColumns)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []*Usage
+	for rows.Next() {
+		u, err := scanUsage(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, u)
+	}
+	return out, rows.Err()
+}
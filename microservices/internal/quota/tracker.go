@@ -0,0 +1,186 @@
+package quota
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/lease"
+	"smart-city-microservices/internal/logging"
+	"smart-city-microservices/internal/supervisor"
+)
+
+// reconcileKey marca a janela de reconciliação em curso: a réplica que a
+// cria reconcilia, e a chave expira com a janela, sem ser liberada.
+const reconcileKey = "agent-service:quotas:reconciled"
+
+var (
+	trackedEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "quota_tracked_events_total",
+		Help:      "Eventos de agentes contados no uso dos projetos, por resultado (counted, dropped com a fila cheia).",
+	}, []string{"result"})
+	reconciles = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "quota_reconciles_total",
+		Help:      "Reconciliações do uso dos projetos, por resultado (ok, error).",
+	}, []string{"result"})
+)
+
+// TrackerConfig configura a contagem do uso.
+type TrackerConfig struct {
+	// FlushInterval grava os acréscimos acumulados nesse intervalo.
+	FlushInterval time.Duration
+	// QueueSize limita os eventos à espera.
+	QueueSize int
+	// ReconcileInterval é a janela entre duas reconciliações, feitas por
+	// uma réplica só; 0 desliga.
+	ReconcileInterval time.Duration
+}
+
+// Tracker mantém o uso dos projetos a partir de agent.created e
+// agent.deleted e reconcilia tudo a cada ReconcileInterval. Cada réplica
+// conta os eventos que publica.
+type Tracker struct {
+	repo     *Repository
+	enforcer *Enforcer
+	window   *lease.Lease
+	cfg      TrackerConfig
+	pending  map[string]Amounts
+
+	events chan events.Event
+}
+
+// NewTracker cria a contagem; id identifica a réplica na disputa pela
+// reconciliação. O consumo roda em Run.
+func NewTracker(repo *Repository, enforcer *Enforcer, client redis.UniversalClient, cfg TrackerConfig, id string) *Tracker {
+	return &Tracker{
+		repo:     repo,
+		enforcer: enforcer,
+		window:   lease.New(client, reconcileKey, id),
+		cfg:      cfg,
+		pending:  map[string]Amounts{},
+		events:   make(chan events.Event, cfg.QueueSize),
+	}
+}
+
+// Handle recebe eventos do barramento sem bloquear o Publish; com a fila
+// cheia o evento é descartado e contado, e a reconciliação corrige.
+func (t *Tracker) Handle(_ context.Context, e events.Event) {
+	switch e.Type {
+	case "agent.created", "agent.deleted":
+	default:
+		return
+	}
+	select {
+	case t.events <- e:
+	default:
+		trackedEvents.WithLabelValues("dropped").Inc()
+	}
+}
+
+// Run consome os eventos e grava os acréscimos até ctx ser cancelado e, ao
+// terminar, grava os que faltam. A reconciliação é tentada logo e a cada
+// hora, e roda se a janela anterior já passou.
+func (t *Tracker) Run(ctx context.Context) error {
+	work := logging.Background(context.WithoutCancel(ctx), "quota-tracker")
+	flush := time.NewTicker(t.cfg.FlushInterval)
+	defer flush.Stop()
+	var reconcile <-chan time.Time
+	if t.cfg.ReconcileInterval > 0 {
+		t.maybeReconcile(work)
+		ticker := time.NewTicker(min(t.cfg.ReconcileInterval, time.Hour))
+		defer ticker.Stop()
+		reconcile = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			work, cancel := supervisor.Cleanup(work)
+			t.flush(work)
+			cancel()
+			return nil
+		case e := <-t.events:
+			t.count(e)
+		case <-flush.C:
+			t.flush(work)
+		case <-reconcile:
+			t.maybeReconcile(work)
+		}
+	}
+}
+
+// count acumula o acréscimo do evento no projeto do agente.
+func (t *Tracker) count(e events.Event) {
+	var data struct {
+		ProjectID string `json:"project_id"`
+	}
+	if err := e.Decode(&data); err != nil || data.ProjectID == "" {
+		return
+	}
+	d := Amounts{Agents: 1}
+	if e.Type == "agent.deleted" {
+		d.Agents = -1
+	}
+	t.pending[data.ProjectID] = t.pending[data.ProjectID].add(d)
+	trackedEvents.WithLabelValues("counted").Inc()
+}
+
+// flush grava os acréscimos; os que falham ficam para o próximo ciclo.
+func (t *Tracker) flush(ctx context.Context) {
+	for project, d := range t.pending {
+		if d.zero() {
+			delete(t.pending, project)
+			continue
+		}
+		if err := t.repo.Add(ctx, project, d); err != nil {
+			logging.FromContext(ctx).WithError(err).WithField("project_id", project).Warn("Falha ao gravar o uso do projeto")
+			continue
+		}
+		delete(t.pending, project)
+	}
+}
+
+// maybeReconcile reconcilia se esta réplica obtiver a janela atual.
+func (t *Tracker) maybeReconcile(ctx context.Context) {
+	ok, err := t.window.Claim(ctx, t.cfg.ReconcileInterval)
+	if err != nil {
+		logging.FromContext(ctx).WithError(err).Warn("Falha ao disputar a reconciliação do uso dos projetos")
+		return
+	}
+	if !ok {
+		return
+	}
+	if _, err := t.enforcer.Reconcile(ctx); err != nil {
+		logging.FromContext(ctx).WithError(err).Error("Falha ao reconciliar o uso dos projetos")
+		// A janela é liberada para que outra tentativa não espere por ela;
+		// se já expirou e é de outra réplica, fica com ela.
+		t.window.Release(ctx)
+	}
+}
+
+// Reconcile recalcula o uso de todos os projetos e atualiza os estouros
+// registrados.
+func (e *Enforcer) Reconcile(ctx context.Context) ([]*Usage, error) {
+	usage, err := e.repo.Reconcile(ctx)
+	if err != nil {
+		reconciles.WithLabelValues("error").Inc()
+		return nil, err
+	}
+	overrides, err := e.repo.Overrides(ctx)
+	if err != nil {
+		reconciles.WithLabelValues("error").Inc()
+		return usage, fmt.Errorf("quota: uso reconciliado, mas falha ao ler as cotas: %w", err)
+	}
+	for _, u := range usage {
+		e.Evaluate(ctx, u, overrides[u.ProjectID].apply(e.defaults))
+	}
+	reconciles.WithLabelValues("ok").Inc()
+	logging.FromContext(ctx).WithField("projects", len(usage)).Info("Uso dos projetos reconciliado")
+	return usage, nil
+}
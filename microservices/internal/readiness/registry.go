@@ -0,0 +1,190 @@
+// Package readiness acompanha as fases de inicialização do serviço, responde
+// ao probe de prontidão e encerra os componentes na ordem inversa.
+package readiness
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// StopFunc encerra um componente durante o shutdown.
+type StopFunc func(ctx context.Context) error
+
+// Component representa uma fase/componente que reporta prontidão.
+type Component struct {
+	name     string
+	registry *Registry
+	stop     StopFunc
+
+	mu      sync.Mutex
+	ready   bool
+	lastErr error
+	since   time.Time
+}
+
+// ComponentStatus é a visão serializável de um componente.
+type ComponentStatus struct {
+	Name  string    `json:"name"`
+	Ready bool      `json:"ready"`
+	Error string    `json:"error,omitempty"`
+	Since time.Time `json:"since"`
+}
+
+// Registry mantém os componentes na ordem em que foram registrados.
+type Registry struct {
+	mu         sync.RWMutex
+	components []*Component
+	draining   bool
+}
+
+// NewRegistry cria um registro vazio.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adiciona um componente, inicialmente não pronto. stop pode ser nil.
+func (r *Registry) Register(name string, stop StopFunc) *Component {
+	c := &Component{name: name, registry: r, stop: stop, since: time.Now().UTC()}
+	r.mu.Lock()
+	r.components = append(r.components, c)
+	r.mu.Unlock()
+	return c
+}
+
+// SetReady marca o componente como pronto.
+func (c *Component) SetReady() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.ready {
+		c.since = time.Now().UTC()
+		logrus.WithField("component", c.name).Info("Componente pronto")
+	}
+	c.ready = true
+	c.lastErr = nil
+}
+
+// SetNotReady marca o componente como indisponível pelo motivo informado.
+func (c *Component) SetNotReady(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ready {
+		c.since = time.Now().UTC()
+		logrus.WithField("component", c.name).WithError(err).Warn("Componente ficou indisponível")
+	}
+	c.ready = false
+	c.lastErr = err
+}
+
+// Watch executa check periodicamente até ctx ser cancelado, alternando a
+// prontidão do componente conforme o resultado.
+func (c *Component) Watch(ctx context.Context, interval time.Duration, check func(ctx context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			checkCtx, cancel := context.WithTimeout(ctx, interval)
+			err := check(checkCtx)
+			cancel()
+			if err != nil {
+				c.SetNotReady(err)
+			} else {
+				c.SetReady()
+			}
+		}
+	}
+}
+
+func (c *Component) status() ComponentStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := ComponentStatus{Name: c.name, Ready: c.ready, Since: c.since}
+	if c.lastErr != nil {
+		s.Error = c.lastErr.Error()
+	}
+	return s
+}
+
+// Ready indica se todos os componentes estão prontos e o serviço não está drenando.
+func (r *Registry) Ready() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.draining {
+		return false
+	}
+	for _, c := range r.components {
+		if !c.status().Ready {
+			return false
+		}
+	}
+	return true
+}
+
+// Status retorna o estado de cada componente na ordem de registro.
+func (r *Registry) Status() []ComponentStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]ComponentStatus, 0, len(r.components))
+	for _, c := range r.components {
+		out = append(out, c.status())
+	}
+	return out
+}
+
+// Handler responde ao probe de prontidão: 200 quando tudo está pronto, 503 caso contrário.
+func (r *Registry) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		status := http.StatusOK
+		state := "ready"
+		if !r.Ready() {
+			status = http.StatusServiceUnavailable
+			state = "not_ready"
+		}
+		c.JSON(status, gin.H{
+			"status":     state,
+			"components": r.Status(),
+		})
+	}
+}
+
+// Drain faz o probe de prontidão falhar sem encerrar nada, para que o load
+// balancer pare de enviar tráfego antes do shutdown.
+func (r *Registry) Drain() {
+	r.mu.Lock()
+	r.draining = true
+	r.mu.Unlock()
+	logrus.Info("Instância drenando: probe de prontidão desativado")
+}
+
+// Shutdown marca o serviço como não pronto e encerra os componentes na ordem
+// inversa do registro, agregando os erros.
+func (r *Registry) Shutdown(ctx context.Context) error {
+	r.mu.Lock()
+	r.draining = true
+	components := make([]*Component, len(r.components))
+	copy(components, r.components)
+	r.mu.Unlock()
+
+	var errs []error
+	for i := len(components) - 1; i >= 0; i-- {
+		c := components[i]
+		if c.stop == nil {
+			continue
+		}
+		logrus.WithField("component", c.name).Info("Encerrando componente")
+		if err := c.stop(ctx); err != nil {
+			logrus.WithField("component", c.name).WithError(err).Error("Erro ao encerrar componente")
+			errs = append(errs, err)
+		}
+		c.SetNotReady(errors.New("encerrado"))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,223 @@
+package revision
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/audit"
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/logging"
+	"smart-city-microservices/internal/twin"
+)
+
+const (
+	defaultListLimit = 20
+	maxListLimit     = 100
+)
+
+// AgentService é o subconjunto de agent.Service usado pelo handler; o
+// rollback passa pela mesma atualização e validação de PUT /agents/:id.
+type AgentService interface {
+	GetAgent(ctx context.Context, id string) (*agent.Agent, error)
+	UpdateAgent(ctx context.Context, id string, req agent.UpdateAgentRequest) (*agent.Agent, error)
+}
+
+// Handler expõe as revisões da configuração dos agentes.
+type Handler struct {
+	repo   *Repository
+	agents AgentService
+}
+
+// NewHandler cria o handler de revisões.
+func NewHandler(repo *Repository, agents AgentService) *Handler {
+	return &Handler{repo: repo, agents: agents}
+}
+
+// DiffResponse é a resposta de GET /agents/:id/revisions/:n/diff: as
+// mudanças de against para revision. Sem against, a revisão comparada é a
+// anterior, ou um documento vazio na primeira.
+type DiffResponse struct {
+	AgentID  string        `json:"agent_id"`
+	Revision int64         `json:"revision"`
+	Against  *int64        `json:"against"`
+	Changes  []twin.Change `json:"changes"`
+}
+
+// List responde GET /agents/:id/revisions, da mais nova à mais antiga
+// (?limit=, padrão 20; ?cursor= com o next_cursor da página anterior).
+func (h *Handler) List(c *gin.Context) {
+	limit := defaultListLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit: " + v})
+			return
+		}
+		limit = min(n, maxListLimit)
+	}
+	var before int64
+	if v := c.Query("cursor"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+			return
+		}
+		before = n
+	}
+	ag, ok := h.agent(c)
+	if !ok {
+		return
+	}
+	list, err := h.repo.List(c.Request.Context(), ag.ID, before, limit)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	resp := gin.H{"data": list}
+	if len(list) == limit && list[len(list)-1].Number > 1 {
+		resp["next_cursor"] = strconv.FormatInt(list[len(list)-1].Number, 10)
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// Diff responde GET /agents/:id/revisions/:n/diff (?against=, padrão a
+// revisão anterior).
+func (h *Handler) Diff(c *gin.Context) {
+	n, ok := revisionParam(c, c.Param("n"))
+	if !ok {
+		return
+	}
+	var against *int64
+	if v := c.Query("against"); v != "" {
+		m, ok := revisionParam(c, v)
+		if !ok {
+			return
+		}
+		against = &m
+	} else if n > 1 {
+		prev := n - 1
+		against = &prev
+	}
+	ag, ok := h.agent(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	rev, err := h.repo.Get(ctx, ag.ID, n)
+	if err != nil {
+		h.serviceError(c, err)
+		return
+	}
+	var from *Document
+	if against != nil {
+		base, err := h.repo.Get(ctx, ag.ID, *against)
+		if err != nil {
+			h.serviceError(c, err)
+			return
+		}
+		from = &base.Document
+	}
+	changes := Diff(from, rev.Document)
+	if changes == nil {
+		changes = []twin.Change{}
+	}
+	c.JSON(http.StatusOK, DiffResponse{AgentID: ag.ID, Revision: n, Against: against, Changes: changes})
+}
+
+// Rollback responde POST /agents/:id/revisions/:n/rollback: aplica o
+// documento da revisão n como uma atualização do agente, validada como as
+// demais, que vira uma revisão nova. Os metadados ausentes na revisão vão
+// como null, o que os remove.
+func (h *Handler) Rollback(c *gin.Context) {
+	n, ok := revisionParam(c, c.Param("n"))
+	if !ok {
+		return
+	}
+	ag, ok := h.agent(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	rev, err := h.repo.Get(ctx, ag.ID, n)
+	if err != nil {
+		h.serviceError(c, err)
+		return
+	}
+
+	doc := rev.Document
+	metadata := make(map[string]interface{}, len(doc.Metadata)+len(ag.Metadata))
+	for k := range ag.Metadata {
+		metadata[k] = nil
+	}
+	for k, v := range doc.Metadata {
+		metadata[k] = v
+	}
+	name := doc.Name
+	tags := append([]string{}, doc.Tags...)
+	updated, err := h.agents.UpdateAgent(withRollback(ctx, n), ag.ID, agent.UpdateAgentRequest{
+		Name:     &name,
+		Metadata: metadata,
+		Tags:     tags,
+	})
+	if err != nil {
+		h.serviceError(c, err)
+		return
+	}
+	latest, err := h.repo.List(ctx, ag.ID, 0, 1)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	audit.Record(ctx, "agent.config_rolled_back", logrus.Fields{"agent_id": ag.ID, "revision": n})
+	resp := gin.H{"agent": updated}
+	if len(latest) > 0 {
+		resp["revision"] = latest[0]
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func revisionParam(c *gin.Context, v string) (int64, bool) {
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid revision: " + v})
+		return 0, false
+	}
+	return n, true
+}
+
+// agent busca o agente de :id. Responde ao cliente e retorna false se ele
+// não existe, é de outro projeto ou a busca falhou.
+func (h *Handler) agent(c *gin.Context) (*agent.Agent, bool) {
+	ag, err := h.agents.GetAgent(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, agent.ErrNotFound) || (err == nil && !auth.FromGin(c).InProject(ag.ProjectID)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "agent not found"})
+		return nil, false
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return nil, false
+	}
+	return ag, true
+}
+
+func (h *Handler) serviceError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound), errors.Is(err, agent.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, agent.ErrValidation):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		h.internalError(c, err)
+	}
+}
+
+func (h *Handler) internalError(c *gin.Context, err error) {
+	logging.FromContext(c.Request.Context()).WithError(err).Error("Erro no handler de revisões")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
@@ -0,0 +1,113 @@
+package revision
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/logging"
+)
+
+var recorded = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: "agent_service",
+	Name:      "agent_config_revisions_total",
+	Help:      "Revisões da configuração de agentes gravadas.",
+})
+
+const (
+	// digestKeyPrefix guarda o hash do último documento gravado de cada
+	// agente, para a telemetria não consultar o banco.
+	digestKeyPrefix = "agent-service:agents:"
+	digestTTL       = 24 * time.Hour
+)
+
+func digestKey(agentID string) string { return digestKeyPrefix + agentID + ":config-digest" }
+
+// Recorder grava as revisões a partir dos eventos dos agentes.
+type Recorder struct {
+	repo        *Repository
+	redis       redis.UniversalClient
+	maxPerAgent int
+}
+
+// NewRecorder cria o gravador; cada agente guarda no máximo maxPerAgent
+// revisões.
+func NewRecorder(repo *Repository, client redis.UniversalClient, maxPerAgent int) *Recorder {
+	return &Recorder{repo: repo, redis: client, maxPerAgent: maxPerAgent}
+}
+
+// Handle grava uma revisão quando agent.created ou agent.updated traz um
+// documento diferente do último gravado. Roda no Publish, com o contexto da
+// requisição, de onde vem o autor.
+func (r *Recorder) Handle(ctx context.Context, e events.Event) {
+	switch e.Type {
+	case "agent.created", "agent.updated":
+	case "agent.deleted":
+		var v events.AgentDeletedV1
+		if e.Decode(&v) == nil && v.ID != "" {
+			r.redis.Del(ctx, digestKey(v.ID))
+		}
+		return
+	default:
+		return
+	}
+	var a events.AgentV1
+	if err := e.Decode(&a); err != nil || a.ID == "" {
+		return
+	}
+	doc := Document{Name: a.Name, Metadata: a.Metadata, Tags: a.Tags}.normalize()
+	if _, err := r.record(ctx, a.ID, doc); err != nil {
+		logging.FromContext(ctx).WithError(err).WithField("agent_id", a.ID).Error("Falha ao gravar a revisão da configuração do agente")
+	}
+}
+
+// record grava doc como revisão do agente, se ele mudou, e retorna a
+// última revisão; nil se o documento é o mesmo já visto pelo hash no Redis.
+func (r *Recorder) record(ctx context.Context, agentID string, doc Document) (*Revision, error) {
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(raw)
+	digest := hex.EncodeToString(sum[:])
+	rollback, isRollback := rollbackOf(ctx)
+	if !isRollback {
+		if cur, err := r.redis.Get(ctx, digestKey(agentID)).Result(); err == nil && cur == digest {
+			return nil, nil
+		}
+	}
+
+	var author string
+	if p := auth.FromContext(ctx); p != nil {
+		author = p.Subject
+	}
+	var of *int64
+	if isRollback {
+		of = &rollback
+	}
+	rev, created, err := r.repo.Append(ctx, agentID, doc, author, of, r.maxPerAgent)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.redis.Set(ctx, digestKey(agentID), digest, digestTTL).Err(); err != nil {
+		logging.FromContext(ctx).WithError(err).WithField("agent_id", agentID).Warn("Falha ao guardar o hash da configuração do agente")
+	}
+	if created {
+		recorded.Inc()
+		logging.FromContext(ctx).WithFields(logrus.Fields{
+			"agent_id": agentID,
+			"revision": rev.Number,
+			"author":   author,
+		}).Debug("Revisão da configuração do agente gravada")
+	}
+	return rev, nil
+}
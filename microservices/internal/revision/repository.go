@@ -0,0 +1,144 @@
+package revision
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"smart-city-microservices/internal/instrument"
+)
+
+// Repository grava as revisões no PostgreSQL.
+type Repository struct {
+	db *instrument.DB
+}
+
+// NewRepository cria o repositório.
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: instrument.NewDB(db)}
+}
+
+// Append grava doc como a próxima revisão do agente se ele difere da
+// última, com o resumo do diff, e remove as revisões além das maxPerAgent
+// mais recentes. rollback é a revisão reaplicada, se for o caso. created é
+// falso se o documento não mudou; rev é então a última revisão.
+func (r *Repository) Append(ctx context.Context, agentID string, doc Document, author string, rollback *int64, maxPerAgent int) (rev *Revision, created bool, err error) {
+	span := instrument.StartQuery(ctx, "revision.append")
+	defer func() { span.End(-1, err) }()
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	// Serializa as revisões do agente entre as réplicas
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('agent_config_revisions:' || $1))`, agentID); err != nil {
+		return nil, false, err
+	}
+	last, err := scanRevision(tx.QueryRowContext(ctx, `
+		SELECT agent_id, revision, document, COALESCE(author, ''), summary, rollback_of, created_at
+		FROM agent_config_revisions WHERE agent_id = $1
+		ORDER BY revision DESC LIMIT 1`, agentID))
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, false, err
+	}
+	changes := Diff(nil, doc)
+	next := int64(1)
+	if last != nil {
+		changes = Diff(&last.Document, doc)
+		if len(changes) == 0 {
+			return last, false, nil
+		}
+		next = last.Number + 1
+	}
+
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return nil, false, err
+	}
+	rev = &Revision{AgentID: agentID, Number: next, Document: doc, Author: author, Summary: summarize(changes), RollbackOf: rollback}
+	switch {
+	case rollback != nil:
+		rev.Summary = "rollback to revision " + strconv.FormatInt(*rollback, 10) + ": " + rev.Summary
+	case last == nil:
+		rev.Summary = "created"
+	}
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO agent_config_revisions (agent_id, revision, document, author, summary, rollback_of)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6)
+		RETURNING created_at`,
+		agentID, next, string(body), author, rev.Summary, rollback).Scan(&rev.CreatedAt)
+	if err != nil {
+		return nil, false, err
+	}
+	if maxPerAgent > 0 {
+		if _, err := tx.ExecContext(ctx, `
+			DELETE FROM agent_config_revisions WHERE agent_id = $1 AND revision <= $2`,
+			agentID, next-int64(maxPerAgent)); err != nil {
+			return nil, false, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, false, err
+	}
+	return rev, true, nil
+}
+
+// List retorna até limit revisões do agente, da mais nova à mais antiga,
+// anteriores a before (0 para começar da última).
+func (r *Repository) List(ctx context.Context, agentID string, before int64, limit int) ([]Revision, error) {
+	rows, err := r.db.Query(ctx, "revision.list", `
+		SELECT agent_id, revision, document, COALESCE(author, ''), summary, rollback_of, created_at
+		FROM agent_config_revisions
+		WHERE agent_id = $1 AND ($2 = 0 OR revision < $2)
+		ORDER BY revision DESC LIMIT $3`, agentID, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Revision{}
+	for rows.Next() {
+		rev, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *rev)
+	}
+	return out, rows.Err()
+}
+
+// Get retorna a revisão n do agente; ErrNotFound se ela não existe ou foi
+// removida.
+func (r *Repository) Get(ctx context.Context, agentID string, n int64) (*Revision, error) {
+	return scanRevision(r.db.QueryRow(ctx, "revision.get", `
+		SELECT agent_id, revision, document, COALESCE(author, ''), summary, rollback_of, created_at
+		FROM agent_config_revisions WHERE agent_id = $1 AND revision = $2`, agentID, n))
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanRevision(s scanner) (*Revision, error) {
+	var rev Revision
+	var doc []byte
+	var rollback sql.NullInt64
+	err := s.Scan(&rev.AgentID, &rev.Number, &doc, &rev.Author, &rev.Summary, &rollback, &rev.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(doc, &rev.Document); err != nil {
+		return nil, err
+	}
+	rev.Document = rev.Document.normalize()
+	if rollback.Valid {
+		rev.RollbackOf = &rollback.Int64
+	}
+	return &rev, nil
+}
@@ -0,0 +1,123 @@
+// Package revision guarda o histórico da configuração dos agentes: cada
+// mudança do nome, dos metadados ou das tags vira uma revisão em
+// agent_config_revisions, com o documento inteiro, o autor, o horário e um
+// resumo da mudança. As revisões saem em GET /agents/:id/revisions, com o
+// diff entre duas delas, e POST /agents/:id/revisions/:n/rollback aplica o
+// documento de uma revisão antiga pelo caminho normal de atualização, o
+// que gera uma revisão nova.
+//
+// As revisões são gravadas a partir de agent.created e agent.updated, que
+// saem de todas as atualizações (REST, gRPC, GraphQL e a ponte MQTT); as
+// que não mudam o documento, como a telemetria, não geram revisão. O autor
+// é o principal da requisição, vazio nas atualizações do próprio serviço.
+// Cada agente guarda no máximo revisions.max_per_agent revisões; as mais
+// antigas são removidas, e a mais antiga restante continua com o documento
+// inteiro.
+package revision
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"smart-city-microservices/internal/twin"
+)
+
+// ErrNotFound indica uma revisão inexistente ou já removida pela retenção.
+var ErrNotFound = errors.New("revision not found")
+
+// Document é a configuração do agente guardada em cada revisão.
+type Document struct {
+	Name     string                 `json:"name"`
+	Metadata map[string]interface{} `json:"metadata"`
+	Tags     []string               `json:"tags"`
+}
+
+// normalize deixa o documento na forma gravada: metadados e tags nunca
+// nulos e sem chaves de metadados com valor nulo, que as atualizações
+// tratam como ausentes.
+func (d Document) normalize() Document {
+	meta := make(map[string]interface{}, len(d.Metadata))
+	for k, v := range d.Metadata {
+		if v != nil {
+			meta[k] = v
+		}
+	}
+	d.Metadata = meta
+	if d.Tags == nil {
+		d.Tags = []string{}
+	}
+	return d
+}
+
+// object converte o documento num objeto JSON genérico, para o diff.
+func (d Document) object() map[string]interface{} {
+	raw, err := json.Marshal(d)
+	if err != nil {
+		return map[string]interface{}{}
+	}
+	var out map[string]interface{}
+	if json.Unmarshal(raw, &out) != nil {
+		return map[string]interface{}{}
+	}
+	return out
+}
+
+// Diff compara os documentos como twin.Diff: metadados chave a chave, tags
+// e nome inteiros. prev nil compara com um documento vazio.
+func Diff(prev *Document, next Document) []twin.Change {
+	from := map[string]interface{}{}
+	if prev != nil {
+		from = prev.object()
+	}
+	return twin.Diff(from, next.object())
+}
+
+// Revision é uma versão da configuração de um agente.
+type Revision struct {
+	AgentID  string   `json:"agent_id"`
+	Number   int64    `json:"revision"`
+	Document Document `json:"document"`
+	Author   string   `json:"author,omitempty"`
+	Summary  string   `json:"summary"`
+	// RollbackOf é a revisão cujo documento foi reaplicado, numa revisão
+	// criada por rollback.
+	RollbackOf *int64    `json:"rollback_of,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// maxSummaryPaths é quantos caminhos alterados entram no resumo.
+const maxSummaryPaths = 5
+
+// summarize descreve as mudanças, como "changed /name, added
+// /metadata/color".
+func summarize(changes []twin.Change) string {
+	if len(changes) == 0 {
+		return "no changes"
+	}
+	parts := make([]string, 0, maxSummaryPaths+1)
+	for i, ch := range changes {
+		if i == maxSummaryPaths {
+			parts = append(parts, fmt.Sprintf("and %d more", len(changes)-i))
+			break
+		}
+		parts = append(parts, ch.Op+" "+ch.Path)
+	}
+	return strings.Join(parts, ", ")
+}
+
+type rollbackKey struct{}
+
+// withRollback marca em ctx que a atualização reaplica a revisão n, para o
+// Recorder registrar a origem da revisão nova.
+func withRollback(ctx context.Context, n int64) context.Context {
+	return context.WithValue(ctx, rollbackKey{}, n)
+}
+
+func rollbackOf(ctx context.Context) (int64, bool) {
+	n, ok := ctx.Value(rollbackKey{}).(int64)
+	return n, ok
+}
@@ -0,0 +1,205 @@
+package rollup
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/audit"
+	"smart-city-microservices/internal/httpcache"
+	"smart-city-microservices/internal/logging"
+)
+
+// Limites do resumo diário.
+const (
+	defaultDays = 30
+	maxDays     = 366
+)
+
+// CacheKey é a chave do cache de respostas dos agregados, limpa quando as
+// views são atualizadas sob demanda.
+const CacheKey = "rollups"
+
+// Origem dos agregados na resposta.
+const (
+	SourceView = "view"
+	SourceLive = "live"
+)
+
+var reads = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "agent_service",
+	Name:      "rollup_reads_total",
+	Help:      "Leituras dos agregados do painel, por view e origem (view, live).",
+}, []string{"view", "source"})
+
+// AgentService é o subconjunto de agent.Service usado aqui.
+type AgentService interface {
+	GetSimulation(ctx context.Context, id string) (*agent.Simulation, error)
+}
+
+// HandlerConfig configura a leitura dos agregados.
+type HandlerConfig struct {
+	// MaxStaleness é a idade máxima de uma view para ser lida; acima dela os
+	// agregados são calculados na hora. Zero sempre calcula na hora.
+	MaxStaleness time.Duration
+}
+
+// Handler serve os agregados do painel e a atualização sob demanda.
+type Handler struct {
+	repo   *Repository
+	agents AgentService
+	cfg    HandlerConfig
+}
+
+// NewHandler cria o handler dos agregados.
+func NewHandler(repo *Repository, agents AgentService, cfg HandlerConfig) *Handler {
+	return &Handler{repo: repo, agents: agents, cfg: cfg}
+}
+
+// freshness diz de onde vieram os agregados e a partir de quando valem.
+type freshness struct {
+	Source string    `json:"source"`
+	AsOf   time.Time `json:"as_of"`
+}
+
+// Districts responde GET /simulations/:id/stats/districts: os agentes da
+// simulação por distrito e tipo, filtráveis por agent_type.
+func (h *Handler) Districts(c *gin.Context) {
+	ctx := c.Request.Context()
+	sim, ok := h.simulation(c)
+	if !ok {
+		return
+	}
+	table, fresh := h.source(ctx, DistrictTypesView)
+	data, err := h.repo.DistrictTypes(ctx, table, sim.ID, c.Query("agent_type"))
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"simulation_id": sim.ID, "data": data, "source": fresh.Source, "as_of": fresh.AsOf})
+}
+
+// Daily responde GET /simulations/:id/stats/daily: o resumo de cada dia
+// (UTC) de from a to, inclusive. Sem datas, são os últimos 30 dias até o
+// fim da simulação ou hoje.
+func (h *Handler) Daily(c *gin.Context) {
+	ctx := c.Request.Context()
+	sim, ok := h.simulation(c)
+	if !ok {
+		return
+	}
+	defTo := time.Now().UTC()
+	if sim.EndedAt != nil {
+		defTo = sim.EndedAt.UTC()
+	}
+	to, ok := dateParam(c, "to", defTo)
+	if !ok {
+		return
+	}
+	from, ok := dateParam(c, "from", to.AddDate(0, 0, 1-defaultDays))
+	if !ok {
+		return
+	}
+	if from.After(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must not be after to"})
+		return
+	}
+	if to.Sub(from) >= maxDays*24*time.Hour {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "time range must be at most 366 days"})
+		return
+	}
+
+	table, fresh := h.source(ctx, DailyView)
+	data, err := h.repo.Daily(ctx, table, sim.ID, from, to)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"simulation_id": sim.ID,
+		"from":          from.Format(time.DateOnly),
+		"to":            to.Format(time.DateOnly),
+		"data":          data,
+		"source":        fresh.Source,
+		"as_of":         fresh.AsOf,
+	})
+}
+
+// Refresh responde POST /admin/refresh-views: atualiza todas as views na
+// hora, nesta réplica, e responde com a atualização de cada uma.
+func (h *Handler) Refresh(c *gin.Context) {
+	ctx := c.Request.Context()
+	done, err := RefreshAll(ctx, h.repo)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	names := make([]string, len(done))
+	for i, r := range done {
+		names[i] = r.View
+	}
+	audit.Record(ctx, "rollups.refreshed", logrus.Fields{"views": names})
+	c.JSON(http.StatusOK, gin.H{"data": done})
+}
+
+// source escolhe a view materializada, se foi atualizada há no máximo
+// MaxStaleness, ou o cálculo na hora.
+func (h *Handler) source(ctx context.Context, v View) (string, freshness) {
+	if h.cfg.MaxStaleness > 0 {
+		at, ok, err := h.repo.RefreshedAt(ctx, v)
+		if err != nil {
+			logging.FromContext(ctx).WithError(err).WithField("view", v.Name).Warn("Falha ao ler a atualização da view; calculando na hora")
+		}
+		if ok && time.Since(at) <= h.cfg.MaxStaleness {
+			reads.WithLabelValues(v.Name, SourceView).Inc()
+			return v.Name, freshness{Source: SourceView, AsOf: at.UTC()}
+		}
+	}
+	reads.WithLabelValues(v.Name, SourceLive).Inc()
+	return v.Live, freshness{Source: SourceLive, AsOf: time.Now().UTC()}
+}
+
+// simulation busca a simulação de :id e responde com o erro se falhar. Os
+// agregados de uma simulação em andamento ainda mudam e ficam fora do
+// cache de respostas.
+func (h *Handler) simulation(c *gin.Context) (*agent.Simulation, bool) {
+	sim, err := h.agents.GetSimulation(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, agent.ErrNotFound) || (err == nil && sim == nil) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "simulation not found"})
+		return nil, false
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return nil, false
+	}
+	if sim.EndedAt == nil {
+		httpcache.Skip(c)
+	}
+	return sim, true
+}
+
+func (h *Handler) internalError(c *gin.Context, err error) {
+	logging.FromContext(c.Request.Context()).WithError(err).Error("Erro no handler de agregados")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
+
+// dateParam lê uma data opcional AAAA-MM-DD; sem ela, usa o dia de def.
+func dateParam(c *gin.Context, name string, def time.Time) (time.Time, bool) {
+	v := c.Query(name)
+	if v == "" {
+		return time.Date(def.Year(), def.Month(), def.Day(), 0, 0, 0, 0, time.UTC), true
+	}
+	t, err := time.Parse(time.DateOnly, v)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + name + ": expected YYYY-MM-DD"})
+		return time.Time{}, false
+	}
+	return t, true
+}
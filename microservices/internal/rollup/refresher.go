@@ -0,0 +1,124 @@
+package rollup
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+
+	"smart-city-microservices/internal/lease"
+	"smart-city-microservices/internal/logging"
+	"smart-city-microservices/internal/supervisor"
+)
+
+// refreshLeaderKey guarda a réplica que atualiza as views. Só uma atualiza
+// por vez, para não repetir o mesmo cálculo em todas.
+const refreshLeaderKey = "agent-service:rollups:refresh-leader"
+
+var (
+	refreshes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "rollup_refreshes_total",
+		Help:      "Atualizações das views materializadas dos agregados, por view e resultado (ok, error).",
+	}, []string{"view", "result"})
+
+	refreshDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "agent_service",
+		Name:      "rollup_refresh_duration_seconds",
+		Help:      "Duração das atualizações das views materializadas dos agregados.",
+		Buckets:   []float64{0.1, 0.5, 1, 5, 15, 30, 60, 120, 300},
+	}, []string{"view"})
+)
+
+// Refresher atualiza as views materializadas a cada intervalo, numa réplica
+// por vez.
+type Refresher struct {
+	repo     *Repository
+	leader   *lease.Lease
+	interval time.Duration
+}
+
+// NewRefresher cria a atualização periódica. id identifica a réplica na
+// disputa pela atualização; o ciclo roda em Run.
+func NewRefresher(repo *Repository, client redis.UniversalClient, interval time.Duration, id string) *Refresher {
+	return &Refresher{
+		repo:     repo,
+		leader:   lease.New(client, refreshLeaderKey, id),
+		interval: interval,
+	}
+}
+
+// Run atualiza as views a cada intervalo até ctx ser cancelado e, ao
+// terminar, libera a atualização para outra réplica. O primeiro ciclo roda
+// logo, para que as views tenham uma atualização registrada e as leituras
+// deixem de calcular na hora.
+func (r *Refresher) Run(ctx context.Context) error {
+	work := logging.Background(context.WithoutCancel(ctx), "rollup-refresher")
+	defer r.release(work)
+	r.cycle(work)
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			r.cycle(work)
+		}
+	}
+}
+
+// release remove a chave só se ainda for desta réplica.
+func (r *Refresher) release(ctx context.Context) {
+	ctx, cancel := supervisor.Cleanup(ctx)
+	defer cancel()
+	r.leader.Release(ctx)
+}
+
+func (r *Refresher) cycle(ctx context.Context) {
+	log := logging.FromContext(ctx)
+	leader, err := r.lead(ctx)
+	if err != nil {
+		log.WithError(err).Warn("Falha ao disputar a atualização dos agregados")
+		return
+	}
+	if !leader {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.interval)
+	defer cancel()
+	if _, err := RefreshAll(ctx, r.repo); err != nil {
+		log.WithError(err).Error("Falha ao atualizar os agregados")
+	}
+}
+
+// lead obtém ou renova a vez desta réplica. A chave expira em três ciclos,
+// para que outra réplica assuma se esta parar sem liberá-la.
+func (r *Refresher) lead(ctx context.Context) (bool, error) {
+	return r.leader.Acquire(ctx, 3*r.interval)
+}
+
+// RefreshAll atualiza todas as views, uma de cada vez. A falha numa não
+// impede as seguintes; o erro retornado é o da primeira que falhou.
+func RefreshAll(ctx context.Context, repo *Repository) ([]Refresh, error) {
+	log := logging.FromContext(ctx)
+	var done []Refresh
+	var first error
+	for _, v := range views {
+		ref, err := repo.Refresh(ctx, v)
+		if err != nil {
+			refreshes.WithLabelValues(v.Name, "error").Inc()
+			log.WithError(err).WithField("view", v.Name).Warn("Falha ao atualizar view materializada")
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+		refreshes.WithLabelValues(v.Name, "ok").Inc()
+		refreshDuration.WithLabelValues(v.Name).Observe(float64(ref.DurationMS) / 1000)
+		done = append(done, ref)
+	}
+	return done, first
+}
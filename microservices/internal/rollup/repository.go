@@ -0,0 +1,137 @@
+package rollup
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"smart-city-microservices/internal/instrument"
+)
+
+// View é uma view materializada dos agregados e a view comum que faz o
+// mesmo cálculo na hora (init.sql).
+type View struct {
+	Name string
+	Live string
+}
+
+// As views dos agregados do painel, na ordem em que são atualizadas.
+var (
+	DistrictTypesView = View{Name: "agents_by_district_type", Live: "agents_by_district_type_live"}
+	DailyView         = View{Name: "simulation_daily_summary", Live: "simulation_daily_summary_live"}
+
+	views = []View{DistrictTypesView, DailyView}
+)
+
+// DistrictType é a contagem de agentes de um tipo num distrito.
+type DistrictType struct {
+	District     string   `json:"district"`
+	AgentType    string   `json:"agent_type"`
+	TotalAgents  int64    `json:"total_agents"`
+	ActiveAgents int64    `json:"active_agents"`
+	AvgEnergy    *float64 `json:"avg_energy"`
+}
+
+// DailySummary é o resumo de um dia (UTC) de uma simulação.
+type DailySummary struct {
+	Day                    string   `json:"day"`
+	TotalEvents            int64    `json:"total_events"`
+	TotalInteractions      int64    `json:"total_interactions"`
+	SuccessfulInteractions int64    `json:"successful_interactions"`
+	AvgMetricValue         *float64 `json:"avg_metric_value"`
+}
+
+// Refresh é o registro de uma atualização de view.
+type Refresh struct {
+	View        string    `json:"view"`
+	RefreshedAt time.Time `json:"refreshed_at"`
+	DurationMS  int64     `json:"duration_ms"`
+}
+
+// Repository lê os agregados e atualiza as views materializadas.
+type Repository struct {
+	db *instrument.DB
+}
+
+// NewRepository cria o repositório.
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: instrument.NewDB(db)}
+}
+
+// Refresh atualiza a view sem bloquear as leituras e registra a
+// atualização em rollup_refreshes. O horário registrado é o do início: a
+// view reflete os dados a partir dele.
+func (r *Repository) Refresh(ctx context.Context, v View) (Refresh, error) {
+	start := time.Now().UTC()
+	if _, err := r.db.Exec(ctx, "rollup.refresh", "REFRESH MATERIALIZED VIEW CONCURRENTLY "+v.Name); err != nil {
+		return Refresh{}, err
+	}
+	ref := Refresh{View: v.Name, RefreshedAt: start, DurationMS: time.Since(start).Milliseconds()}
+	_, err := r.db.Exec(ctx, "rollup.record_refresh", `
+		INSERT INTO rollup_refreshes (view_name, refreshed_at, duration_ms)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (view_name) DO UPDATE
+		SET refreshed_at = EXCLUDED.refreshed_at, duration_ms = EXCLUDED.duration_ms`,
+		ref.View, ref.RefreshedAt, ref.DurationMS)
+	return ref, err
+}
+
+// RefreshedAt retorna quando a view foi atualizada pela última vez; ok é
+// falso se nunca foi.
+func (r *Repository) RefreshedAt(ctx context.Context, v View) (at time.Time, ok bool, err error) {
+	err = r.db.QueryRow(ctx, "rollup.refreshed_at",
+		`SELECT refreshed_at FROM rollup_refreshes WHERE view_name = $1`, v.Name).Scan(&at)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, false, nil
+	}
+	return at, err == nil, err
+}
+
+// DistrictTypes lê as contagens da simulação em table (v.Name ou v.Live de
+// DistrictTypesView), por distrito e tipo; agentType vazio não filtra.
+func (r *Repository) DistrictTypes(ctx context.Context, table, simulationID, agentType string) ([]DistrictType, error) {
+	rows, err := r.db.Query(ctx, "rollup.district_types", `
+		SELECT district, agent_type, total_agents, active_agents, avg_energy
+		FROM `+table+`
+		WHERE simulation_id = $1 AND ($2 = '' OR agent_type = $2)
+		ORDER BY district, agent_type`, simulationID, agentType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []DistrictType{}
+	for rows.Next() {
+		var d DistrictType
+		if err := rows.Scan(&d.District, &d.AgentType, &d.TotalAgents, &d.ActiveAgents, &d.AvgEnergy); err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// Daily lê os resumos diários da simulação em table (v.Name ou v.Live de
+// DailyView) de from a to, inclusive, em ordem.
+func (r *Repository) Daily(ctx context.Context, table, simulationID string, from, to time.Time) ([]DailySummary, error) {
+	rows, err := r.db.Query(ctx, "rollup.daily", `
+		SELECT to_char(day, 'YYYY-MM-DD'), total_events, total_interactions, successful_interactions, avg_metric_value
+		FROM `+table+`
+		WHERE simulation_id = $1 AND day BETWEEN $2::date AND $3::date
+		ORDER BY day`, simulationID, from.Format(time.DateOnly), to.Format(time.DateOnly))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []DailySummary{}
+	for rows.Next() {
+		var d DailySummary
+		if err := rows.Scan(&d.Day, &d.TotalEvents, &d.TotalInteractions, &d.SuccessfulInteractions, &d.AvgMetricValue); err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
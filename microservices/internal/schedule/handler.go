@@ -0,0 +1,253 @@
+package schedule
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/action"
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/audit"
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/i18n"
+	"smart-city-microservices/internal/logging"
+)
+
+// Handler expõe o CRUD dos agendamentos de um agente.
+type Handler struct {
+	repo      *Repository
+	submitter Submitter
+}
+
+// NewHandler cria o handler de agendamentos.
+func NewHandler(repo *Repository, submitter Submitter) *Handler {
+	return &Handler{repo: repo, submitter: submitter}
+}
+
+// CreateRequest é o corpo de POST /agents/:id/scheduled-actions. Exige
+// run_at (futuro) ou cron; timezone padrão é UTC e vale só para cron;
+// priority padrão é normal.
+type CreateRequest struct {
+	Action   string                 `json:"action" binding:"required"`
+	Params   map[string]interface{} `json:"params"`
+	Priority string                 `json:"priority"`
+	RunAt    *time.Time             `json:"run_at"`
+	Cron     string                 `json:"cron"`
+	Timezone string                 `json:"timezone"`
+	Enabled  *bool                  `json:"enabled"`
+}
+
+// UpdateRequest é o corpo de PUT /agents/:id/scheduled-actions/:schedule_id;
+// campos ausentes não mudam. Informar run_at apaga cron e vice-versa. A
+// próxima ocorrência é recalculada a partir de agora.
+type UpdateRequest struct {
+	Action   *string                 `json:"action"`
+	Params   *map[string]interface{} `json:"params"`
+	Priority *string                 `json:"priority"`
+	RunAt    *time.Time              `json:"run_at"`
+	Cron     *string                 `json:"cron"`
+	Timezone *string                 `json:"timezone"`
+	Enabled  *bool                   `json:"enabled"`
+}
+
+// List retorna os agendamentos do agente.
+func (h *Handler) List(c *gin.Context) {
+	list, err := h.repo.List(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	if list == nil {
+		list = []*ScheduledAction{}
+	}
+	c.JSON(http.StatusOK, gin.H{"data": list})
+}
+
+// Get retorna um agendamento.
+func (h *Handler) Get(c *gin.Context) {
+	s, err := h.repo.Get(c.Request.Context(), c.Param("id"), c.Param("schedule_id"))
+	if err != nil {
+		h.serviceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, s)
+}
+
+// Create cadastra um agendamento.
+func (h *Handler) Create(c *gin.Context) {
+	var req CreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		i18n.BindError(c, err)
+		return
+	}
+	ctx := c.Request.Context()
+	s := &ScheduledAction{
+		AgentID:  c.Param("id"),
+		Action:   req.Action,
+		Params:   req.Params,
+		Priority: req.Priority,
+		RunAt:    req.RunAt,
+		Cron:     req.Cron,
+		Timezone: req.Timezone,
+		Enabled:  req.Enabled == nil || *req.Enabled,
+	}
+	if s.Params == nil {
+		s.Params = map[string]interface{}{}
+	}
+	if s.Priority == "" {
+		s.Priority = action.PriorityNormal
+	}
+	if s.Timezone == "" {
+		s.Timezone = "UTC"
+	}
+	if p := auth.FromContext(ctx); p != nil {
+		s.CreatedBy, s.CreatorRoles, s.CreatorScopes = p.Subject, p.Roles, p.Scopes
+	}
+	if !h.prepare(c, s, true) {
+		return
+	}
+	if err := h.repo.Create(ctx, s); err != nil {
+		h.internalError(c, err)
+		return
+	}
+	audit.Record(ctx, "scheduled_action.created", logrus.Fields{
+		"schedule_id": s.ID, "agent_id": s.AgentID, "action": s.Action, "run_at": s.RunAt, "cron": s.Cron,
+	})
+	c.JSON(http.StatusCreated, s)
+}
+
+// Update altera os campos informados do agendamento.
+func (h *Handler) Update(c *gin.Context) {
+	var req UpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		i18n.BindError(c, err)
+		return
+	}
+	ctx := c.Request.Context()
+	s, err := h.repo.Get(ctx, c.Param("id"), c.Param("schedule_id"))
+	if err != nil {
+		h.serviceError(c, err)
+		return
+	}
+
+	for _, f := range []struct {
+		src *string
+		dst *string
+	}{
+		{req.Action, &s.Action}, {req.Priority, &s.Priority}, {req.Cron, &s.Cron}, {req.Timezone, &s.Timezone},
+	} {
+		if f.src != nil {
+			*f.dst = *f.src
+		}
+	}
+	if req.Params != nil {
+		s.Params = *req.Params
+		if s.Params == nil {
+			s.Params = map[string]interface{}{}
+		}
+	}
+	if req.RunAt != nil {
+		s.RunAt = req.RunAt
+		if req.Cron == nil {
+			s.Cron = ""
+		}
+	} else if req.Cron != nil && *req.Cron != "" {
+		s.RunAt = nil
+	}
+	if req.Enabled != nil {
+		s.Enabled = *req.Enabled
+	}
+	if !h.prepare(c, s, req.RunAt != nil) {
+		return
+	}
+
+	if err := h.repo.Update(ctx, s); err != nil {
+		h.serviceError(c, err)
+		return
+	}
+	audit.Record(ctx, "scheduled_action.updated", logrus.Fields{
+		"schedule_id": s.ID, "agent_id": s.AgentID, "enabled": s.Enabled,
+	})
+	updated, err := h.repo.Get(ctx, s.AgentID, s.ID)
+	if err != nil {
+		h.serviceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+// Delete remove o agendamento. Execuções já submetidas seguem na fila.
+func (h *Handler) Delete(c *gin.Context) {
+	agentID, id := c.Param("id"), c.Param("schedule_id")
+	if err := h.repo.Delete(c.Request.Context(), agentID, id); err != nil {
+		h.serviceError(c, err)
+		return
+	}
+	audit.Record(c.Request.Context(), "scheduled_action.deleted", logrus.Fields{"schedule_id": id, "agent_id": agentID})
+	c.Status(http.StatusNoContent)
+}
+
+// prepare valida o agendamento, confere a ação contra o agente e contra a
+// política das ações, com o principal da requisição, como a submissão
+// fará e calcula a próxima ocorrência a partir de agora. Com
+// checkRunAt, um run_at que já passou é recusado. Responde ao cliente e
+// retorna false quando o agendamento é inválido.
+func (h *Handler) prepare(c *gin.Context, s *ScheduledAction, checkRunAt bool) bool {
+	if err := s.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	now := time.Now()
+	if checkRunAt && s.RunAt != nil && !s.RunAt.After(now) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "run_at must be in the future"})
+		return false
+	}
+	s.NextRunAt = s.next(now)
+	if s.Cron != "" && s.NextRunAt == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cron expression " + s.Cron + " never matches"})
+		return false
+	}
+
+	_, err := h.submitter.Check(c.Request.Context(), s.AgentID, agent.ActionRequest{Action: s.Action, Params: s.Params})
+	var unsupported *action.UnsupportedError
+	var invalid *action.InvalidParamsError
+	var missing *action.MissingCapabilitiesError
+	var forbidden *action.ForbiddenError
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, agent.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "agent not found"})
+	case errors.As(err, &forbidden):
+		c.JSON(http.StatusForbidden, action.ForbiddenBody(forbidden))
+	case errors.As(err, &unsupported):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":             err.Error(),
+			"agent_type":        unsupported.AgentType,
+			"supported_actions": unsupported.Supported,
+		})
+	case errors.As(err, &invalid):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	case errors.As(err, &missing):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "missing_capabilities": missing.Missing})
+	default:
+		h.internalError(c, err)
+	}
+	return false
+}
+
+func (h *Handler) serviceError(c *gin.Context, err error) {
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	h.internalError(c, err)
+}
+
+func (h *Handler) internalError(c *gin.Context, err error) {
+	logging.FromContext(c.Request.Context()).WithError(err).Error("Erro no handler de agendamentos")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
@@ -0,0 +1,228 @@
+package schedule
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"smart-city-microservices/internal/action"
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/events"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+	sql.Register("scheduletest", scheduleDriver{})
+}
+
+// recorded é o último disparo gravado por DSN: status e last_error.
+var (
+	recordedMu sync.Mutex
+	recorded   = map[string]string{}
+)
+
+// scheduleDriver aceita toda reivindicação e anota o disparo gravado.
+type scheduleDriver struct{}
+
+func (scheduleDriver) Open(dsn string) (driver.Conn, error) { return scheduleConn{dsn}, nil }
+
+type scheduleConn struct{ dsn string }
+
+func (c scheduleConn) Prepare(query string) (driver.Stmt, error) {
+	return scheduleStmt{c.dsn, query}, nil
+}
+func (scheduleConn) Close() error              { return nil }
+func (scheduleConn) Begin() (driver.Tx, error) { return nil, errors.New("sem transações") }
+
+type scheduleStmt struct {
+	dsn   string
+	query string
+}
+
+func (scheduleStmt) Close() error  { return nil }
+func (scheduleStmt) NumInput() int { return -1 }
+
+func (s scheduleStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if strings.Contains(s.query, "last_status = $4") {
+		item := fmt.Sprint(args[3])
+		if args[4] != "" {
+			item += ": " + fmt.Sprint(args[4])
+		}
+		recordedMu.Lock()
+		recorded[s.dsn] = item
+		recordedMu.Unlock()
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (scheduleStmt) Query([]driver.Value) (driver.Rows, error) {
+	return nil, errors.New("só comandos")
+}
+
+// testProjects restringe ações por projeto: projeto, ação e papéis.
+type testProjects map[string]map[string][]string
+
+func (p testProjects) ActionRoles(projectID, action string) []string {
+	return p[projectID][action]
+}
+
+// fakeAgents conhece bus-1, de proj-centro, e bus-2, de proj-restrito.
+type fakeAgents struct{}
+
+func (fakeAgents) GetAgent(_ context.Context, id string) (*agent.Agent, error) {
+	switch id {
+	case "bus-1":
+		return &agent.Agent{ID: id, Type: "bus", ProjectID: "proj-centro"}, nil
+	case "bus-2":
+		return &agent.Agent{ID: id, Type: "bus", ProjectID: "proj-restrito"}, nil
+	}
+	return nil, agent.ErrNotFound
+}
+
+// checkSubmitter confere a ação como *action.Submitter e, aceita, a dá
+// por submetida sem fila.
+type checkSubmitter struct{ *action.Submitter }
+
+func (s checkSubmitter) Submit(ctx context.Context, agentID string, req agent.ActionRequest, _ string) (action.Action, error) {
+	if _, err := s.Check(ctx, agentID, req); err != nil {
+		return action.Action{}, err
+	}
+	return action.Action{ID: "act-1"}, nil
+}
+
+func newSubmitter() checkSubmitter {
+	registry := action.NewRegistry([]action.Definition{
+		{Name: "emergency_stop", Roles: []string{auth.RoleAdmin}},
+		{Name: "calibrate_sensor", Roles: []string{auth.RoleOperator}, Scopes: []string{"maintenance"}},
+		{Name: "reroute"},
+	}, action.Options{Projects: testProjects{"proj-restrito": {"reroute": {auth.RoleOperator}}}})
+	return checkSubmitter{action.NewSubmitter(registry, nil, fakeAgents{})}
+}
+
+type nopPublisher struct{}
+
+func (nopPublisher) Publish(context.Context, events.Event) {}
+
+var (
+	viewer      = &auth.Principal{Subject: "oidc:viewer", Roles: []string{auth.RoleViewer}}
+	operator    = &auth.Principal{Subject: "oidc:operator", Roles: []string{auth.RoleOperator}}
+	admin       = &auth.Principal{Subject: "oidc:admin", Roles: []string{auth.RoleAdmin}}
+	maintenance = &auth.Principal{Subject: "apikey:k1", Scopes: []string{"maintenance"}}
+)
+
+// policyCase vale para a criação e para o disparo: ok é a ação aceita;
+// recusada, err é o motivo.
+type policyCase struct {
+	name      string
+	principal *auth.Principal
+	agentID   string
+	action    string
+	ok        bool
+	err       string
+}
+
+var policyCases = []policyCase{
+	{name: "free action", principal: viewer, agentID: "bus-1", action: "reroute", ok: true},
+	{name: "admin action by viewer", principal: viewer, agentID: "bus-1", action: "emergency_stop", err: `action "emergency_stop" requires role admin`},
+	{name: "admin action by admin", principal: admin, agentID: "bus-1", action: "emergency_stop", ok: true},
+	{name: "admin action without principal", agentID: "bus-1", action: "emergency_stop", err: `action "emergency_stop" requires role admin`},
+	{name: "role or scope action by viewer", principal: viewer, agentID: "bus-1", action: "calibrate_sensor", err: `action "calibrate_sensor" requires role operator or scope maintenance`},
+	{name: "role or scope action by scoped key", principal: maintenance, agentID: "bus-1", action: "calibrate_sensor", ok: true},
+	{name: "project restriction by viewer", principal: viewer, agentID: "bus-2", action: "reroute", err: `action "reroute" requires role operator in project proj-restrito`},
+	{name: "project restriction by scoped key", principal: maintenance, agentID: "bus-2", action: "reroute", err: `action "reroute" requires role operator in project proj-restrito`},
+	{name: "project restriction by operator", principal: operator, agentID: "bus-2", action: "reroute", ok: true},
+}
+
+// TestPreparePolicy confere a política na criação e na alteração dos
+// agendamentos, com o principal da requisição.
+func TestPreparePolicy(t *testing.T) {
+	for _, tt := range policyCases {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHandler(nil, newSubmitter())
+			r := gin.New()
+			r.POST("/agents/:id/schedules", func(c *gin.Context) {
+				if tt.principal != nil {
+					auth.SetPrincipal(c, tt.principal)
+				}
+			}, func(c *gin.Context) {
+				s := &ScheduledAction{
+					AgentID: c.Param("id"), Action: tt.action, Params: map[string]interface{}{},
+					Priority: action.PriorityNormal, Cron: "*/5 * * * *", Timezone: "UTC", Enabled: true,
+				}
+				if h.prepare(c, s, true) {
+					c.Status(http.StatusNoContent)
+				}
+			})
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/agents/"+tt.agentID+"/schedules", nil))
+			if tt.ok {
+				if w.Code != http.StatusNoContent {
+					t.Fatalf("status %d: %s", w.Code, w.Body.String())
+				}
+				return
+			}
+			var got struct {
+				Error string `json:"error"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &got)
+			if w.Code != http.StatusForbidden || got.Error != tt.err {
+				t.Errorf("status %d, erro %q; want 403 %q", w.Code, got.Error, tt.err)
+			}
+		})
+	}
+}
+
+// TestFirePolicy confere que cada disparo é conferido de novo com os
+// papéis e escopos gravados do autor, e que uma recusa fica em last_error.
+func TestFirePolicy(t *testing.T) {
+	tests := append(policyCases[:len(policyCases):len(policyCases)], policyCase{
+		// Agendamento de antes da política: o autor ficou sem papéis.
+		name: "creator without recorded roles", principal: &auth.Principal{Subject: admin.Subject}, agentID: "bus-1",
+		action: "emergency_stop", err: `action "emergency_stop" requires role admin`,
+	})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := sql.Open("scheduletest", t.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { db.Close() })
+			s := NewScheduler(NewRepository(db), newSubmitter(), nil, nopPublisher{}, Config{Interval: time.Minute, Grace: time.Hour}, "r1")
+
+			now := time.Now().UTC()
+			due := now.Add(-time.Minute).Truncate(time.Minute)
+			sa := &ScheduledAction{
+				ID: "s-1", AgentID: tt.agentID, Action: tt.action, Params: map[string]interface{}{},
+				Priority: action.PriorityNormal, Cron: "* * * * *", Timezone: "UTC", Enabled: true, NextRunAt: &due,
+			}
+			if tt.principal != nil {
+				sa.CreatedBy, sa.CreatorRoles, sa.CreatorScopes = tt.principal.Subject, tt.principal.Roles, tt.principal.Scopes
+			}
+			s.fire(context.Background(), sa, now)
+
+			recordedMu.Lock()
+			got := recorded[t.Name()]
+			recordedMu.Unlock()
+			want := LastSubmitted
+			if !tt.ok {
+				want = LastFailed + ": " + tt.err
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("disparo %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package schedule
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"smart-city-microservices/internal/instrument"
+)
+
+// Repository persiste os agendamentos no PostgreSQL, onde as réplicas
+// disputam cada ocorrência.
+type Repository struct {
+	db *instrument.DB
+}
+
+// NewRepository cria o repositório.
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: instrument.NewDB(db)}
+}
+
+const scheduleColumns = `id, agent_id, action, params, priority, run_at, COALESCE(cron, ''), timezone, enabled,
+	next_run_at, last_run_at, COALESCE(last_action_id, ''), COALESCE(last_status, ''), COALESCE(last_error, ''),
+	COALESCE(created_by, ''), created_by_roles, created_by_scopes, created_at, updated_at`
+
+func scanSchedule(row interface{ Scan(...interface{}) error }) (*ScheduledAction, error) {
+	var s ScheduledAction
+	var params []byte
+	var runAt, next, last sql.NullTime
+	err := row.Scan(&s.ID, &s.AgentID, &s.Action, &params, &s.Priority, &runAt, &s.Cron, &s.Timezone, &s.Enabled,
+		&next, &last, &s.LastActionID, &s.LastStatus, &s.LastError, &s.CreatedBy,
+		pq.Array(&s.CreatorRoles), pq.Array(&s.CreatorScopes), &s.CreatedAt, &s.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(params, &s.Params); err != nil {
+		return nil, err
+	}
+	s.RunAt, s.NextRunAt, s.LastRunAt = timePtr(runAt), timePtr(next), timePtr(last)
+	return &s, nil
+}
+
+// Create insere o agendamento e preenche id e datas.
+func (r *Repository) Create(ctx context.Context, s *ScheduledAction) error {
+	params, err := json.Marshal(s.Params)
+	if err != nil {
+		return err
+	}
+	return r.db.QueryRow(ctx, "schedule.create", `
+		INSERT INTO scheduled_actions (agent_id, action, params, priority, run_at, cron, timezone, enabled,
+			next_run_at, created_by, created_by_roles, created_by_scopes)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, $8, $9, NULLIF($10, ''), $11, $12)
+		RETURNING id, created_at, updated_at`,
+		s.AgentID, s.Action, params, s.Priority, s.RunAt, s.Cron, s.Timezone, s.Enabled, s.NextRunAt, s.CreatedBy,
+		pq.Array(s.CreatorRoles), pq.Array(s.CreatorScopes),
+	).Scan(&s.ID, &s.CreatedAt, &s.UpdatedAt)
+}
+
+// Get busca um agendamento do agente.
+func (r *Repository) Get(ctx context.Context, agentID, id string) (*ScheduledAction, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrNotFound
+	}
+	return scanSchedule(r.db.QueryRow(ctx, "schedule.get",
+		`SELECT `+scheduleColumns+` FROM scheduled_actions WHERE id = $1 AND agent_id::text = $2`, id, agentID))
+}
+
+// List retorna os agendamentos do agente, dos mais antigos para os mais
+// novos.
+func (r *Repository) List(ctx context.Context, agentID string) ([]*ScheduledAction, error) {
+	if _, err := uuid.Parse(agentID); err != nil {
+		return nil, nil
+	}
+	return r.list(ctx, "schedule.list", `
+		SELECT `+scheduleColumns+` FROM scheduled_actions
+		WHERE agent_id = $1
+		ORDER BY created_at`, agentID)
+}
+
+// Due retorna até limit agendamentos habilitados com ocorrência até now,
+// dos mais atrasados para os menos.
+func (r *Repository) Due(ctx context.Context, now time.Time, limit int) ([]*ScheduledAction, error) {
+	return r.list(ctx, "schedule.due", `
+		SELECT `+scheduleColumns+` FROM scheduled_actions
+		WHERE enabled AND next_run_at <= $1
+		ORDER BY next_run_at
+		LIMIT $2`, now, limit)
+}
+
+func (r *Repository) list(ctx context.Context, name, query string, args ...interface{}) ([]*ScheduledAction, error) {
+	rows, err := r.db.Query(ctx, name, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*ScheduledAction
+	for rows.Next() {
+		s, err := scanSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// Update grava o agendamento inteiro, inclusive a próxima ocorrência
+// recalculada.
+func (r *Repository) Update(ctx context.Context, s *ScheduledAction) error {
+	params, err := json.Marshal(s.Params)
+	if err != nil {
+		return err
+	}
+	res, err := r.db.Exec(ctx, "schedule.update", `
+		UPDATE scheduled_actions SET action = $3, params = $4, priority = $5, run_at = $6, cron = NULLIF($7, ''),
+			timezone = $8, enabled = $9, next_run_at = $10, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND agent_id = $2`,
+		s.ID, s.AgentID, s.Action, params, s.Priority, s.RunAt, s.Cron, s.Timezone, s.Enabled, s.NextRunAt)
+	return affected(res, err)
+}
+
+// Delete remove o agendamento do agente.
+func (r *Repository) Delete(ctx context.Context, agentID, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrNotFound
+	}
+	res, err := r.db.Exec(ctx, "schedule.delete",
+		`DELETE FROM scheduled_actions WHERE id = $1 AND agent_id::text = $2`, id, agentID)
+	return affected(res, err)
+}
+
+// Claim avança a ocorrência due do agendamento para next (nil quando não
+// há mais nenhuma). Retorna false se outra réplica ou uma alteração do
+// agendamento chegou antes: só quem avança dispara.
+func (r *Repository) Claim(ctx context.Context, id string, due time.Time, next *time.Time) (bool, error) {
+	res, err := r.db.Exec(ctx, "schedule.claim", `
+		UPDATE scheduled_actions SET next_run_at = $3
+		WHERE id = $1 AND next_run_at = $2 AND enabled`, id, due, next)
+	if err := affected(res, err); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// Record grava o resultado do disparo de uma ocorrência.
+func (r *Repository) Record(ctx context.Context, id string, at time.Time, actionID, status, errMsg string) error {
+	_, err := r.db.Exec(ctx, "schedule.record", `
+		UPDATE scheduled_actions SET last_run_at = $2, last_action_id = NULLIF($3, ''), last_status = $4,
+			last_error = NULLIF($5, '')
+		WHERE id = $1`, id, at, actionID, status, errMsg)
+	return err
+}
+
+func timePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+func affected(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+// Package schedule guarda ações de agentes agendadas para um instante
+// (run_at) ou recorrentes (cron) e as submete ao runner de ações na hora
+// certa, com uma só réplica disparando por vez.
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"smart-city-microservices/internal/action"
+)
+
+// ErrNotFound indica que o agendamento não existe.
+var ErrNotFound = errors.New("scheduled action not found")
+
+// Eventos publicados em events.TopicAgents.
+const (
+	EventFired   = "agent.scheduled_action.fired"
+	EventSkipped = "agent.scheduled_action.skipped"
+)
+
+// Estados da última execução de um agendamento.
+const (
+	LastSubmitted = "submitted"
+	LastFailed    = "failed"
+	LastSkipped   = "skipped"
+)
+
+// ScheduledAction é uma ação agendada de um agente: uma vez em RunAt ou a
+// cada ocorrência de Cron, no fuso Timezone. NextRunAt é a próxima
+// ocorrência ainda não disparada; nula depois do disparo de um RunAt.
+type ScheduledAction struct {
+	ID           string                 `json:"id"`
+	AgentID      string                 `json:"agent_id"`
+	Action       string                 `json:"action"`
+	Params       map[string]interface{} `json:"params"`
+	Priority     string                 `json:"priority"`
+	RunAt        *time.Time             `json:"run_at,omitempty"`
+	Cron         string                 `json:"cron,omitempty"`
+	Timezone     string                 `json:"timezone"`
+	Enabled      bool                   `json:"enabled"`
+	NextRunAt    *time.Time             `json:"next_run_at,omitempty"`
+	LastRunAt    *time.Time             `json:"last_run_at,omitempty"`
+	LastActionID string                 `json:"last_action_id,omitempty"`
+	LastStatus   string                 `json:"last_status,omitempty"`
+	LastError    string                 `json:"last_error,omitempty"`
+	CreatedBy    string                 `json:"created_by,omitempty"`
+	CreatedAt    time.Time              `json:"created_at"`
+	UpdatedAt    time.Time              `json:"updated_at"`
+	// CreatorRoles e CreatorScopes são os do autor na criação: cada
+	// disparo é conferido contra a política das ações como se ele o
+	// pedisse.
+	CreatorRoles  []string `json:"-"`
+	CreatorScopes []string `json:"-"`
+}
+
+// Validate confere a ação, a prioridade, o fuso e que há exatamente um de
+// run_at e cron.
+func (s *ScheduledAction) Validate() error {
+	if s.Action == "" {
+		return errors.New("action is required")
+	}
+	if !action.ValidPriority(s.Priority) {
+		return fmt.Errorf("invalid priority: %s (use critical, high, normal or low)", s.Priority)
+	}
+	if _, err := time.LoadLocation(s.Timezone); err != nil {
+		return fmt.Errorf("invalid timezone %q", s.Timezone)
+	}
+	switch {
+	case s.RunAt == nil && s.Cron == "":
+		return errors.New("one of run_at or cron is required")
+	case s.RunAt != nil && s.Cron != "":
+		return errors.New("run_at and cron are mutually exclusive")
+	case s.Cron != "":
+		if _, err := ParseCron(s.Cron); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// next retorna a primeira ocorrência depois de after, ou nil se não há
+// mais nenhuma. Supõe o agendamento válido.
+func (s *ScheduledAction) next(after time.Time) *time.Time {
+	if s.RunAt != nil {
+		if s.RunAt.After(after) {
+			t := *s.RunAt
+			return &t
+		}
+		return nil
+	}
+	c, err := ParseCron(s.Cron)
+	if err != nil {
+		return nil
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return nil
+	}
+	t := c.Next(after, loc)
+	if t.IsZero() {
+		return nil
+	}
+	t = t.UTC()
+	return &t
+}
+
+// latest retorna a última ocorrência entre from (inclusive) e now, ou
+// false se não há nenhuma.
+func (s *ScheduledAction) latest(from, now time.Time) (time.Time, bool) {
+	var last time.Time
+	t := s.next(from.Add(-time.Nanosecond))
+	for t != nil && !t.After(now) {
+		last = *t
+		t = s.next(last)
+	}
+	return last, !last.IsZero()
+}
@@ -0,0 +1,217 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/action"
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/lease"
+	"smart-city-microservices/internal/logging"
+	"smart-city-microservices/internal/supervisor"
+)
+
+// leaderKey guarda a réplica que dispara os agendamentos. Só uma dispara
+// por vez; a reivindicação no banco cobre a troca de líder no meio de um
+// ciclo.
+const leaderKey = "agent-service:schedules:scheduler"
+
+// dueBatch limita os agendamentos disparados por ciclo; o restante fica
+// para o próximo.
+const dueBatch = 100
+
+var fired = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "agent_service",
+	Name:      "scheduled_actions_total",
+	Help:      "Ocorrências de agendamentos por resultado (submitted, failed, skipped).",
+}, []string{"result"})
+
+// Submitter coloca uma ação na fila do runner; *action.Submitter o
+// implementa.
+type Submitter interface {
+	Check(ctx context.Context, agentID string, req agent.ActionRequest) (*agent.Agent, error)
+	Submit(ctx context.Context, agentID string, req agent.ActionRequest, priority string) (action.Action, error)
+}
+
+// Config configura o disparo dos agendamentos.
+type Config struct {
+	// Interval é o intervalo entre as varreduras.
+	Interval time.Duration
+	// Grace é o atraso máximo de uma ocorrência perdida (serviço fora do
+	// ar, por exemplo) para que ainda seja disparada; além dele ela é
+	// pulada com agent.scheduled_action.skipped.
+	Grace time.Duration
+}
+
+// Scheduler dispara os agendamentos vencidos a cada Interval. Ocorrências
+// perdidas são recuperadas uma só vez: das que caem dentro da tolerância,
+// só a mais recente dispara.
+type Scheduler struct {
+	repo      *Repository
+	submitter Submitter
+	leader    *lease.Lease
+	publisher events.Publisher
+	cfg       Config
+	paused    func() bool
+}
+
+// NewScheduler cria o disparador. id identifica a réplica na disputa pelo
+// disparo; o ciclo roda em Run.
+func NewScheduler(repo *Repository, submitter Submitter, client redis.UniversalClient, publisher events.Publisher, cfg Config, id string) *Scheduler {
+	return &Scheduler{
+		repo:      repo,
+		submitter: submitter,
+		leader:    lease.New(client, leaderKey, id),
+		publisher: publisher,
+		cfg:       cfg,
+	}
+}
+
+// PauseWhen suspende os disparos enquanto paused retornar true (modo de
+// manutenção). As ocorrências vencidas na pausa seguem a tolerância de
+// Grace ao retomar. Precisa ser chamado antes de Run.
+func (s *Scheduler) PauseWhen(paused func() bool) {
+	s.paused = paused
+}
+
+// Run dispara os agendamentos vencidos a cada intervalo até ctx ser
+// cancelado e, ao terminar, libera o disparo para outra réplica.
+func (s *Scheduler) Run(ctx context.Context) error {
+	work := logging.Background(context.WithoutCancel(ctx), "action-scheduler")
+	defer s.release(work)
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			s.cycle(work)
+		}
+	}
+}
+
+// release remove a chave só se ainda for desta réplica.
+func (s *Scheduler) release(ctx context.Context) {
+	ctx, cancel := supervisor.Cleanup(ctx)
+	defer cancel()
+	s.leader.Release(ctx)
+}
+
+func (s *Scheduler) cycle(ctx context.Context) {
+	if s.paused != nil && s.paused() {
+		return
+	}
+	log := logging.FromContext(ctx)
+	leader, err := s.lead(ctx)
+	if err != nil {
+		log.WithError(err).Warn("Falha ao disputar o disparo de agendamentos")
+		return
+	}
+	if !leader {
+		return
+	}
+	now := time.Now()
+	due, err := s.repo.Due(ctx, now, dueBatch)
+	if err != nil {
+		log.WithError(err).Error("Falha ao listar agendamentos vencidos")
+		return
+	}
+	for _, sa := range due {
+		s.fire(ctx, sa, now)
+	}
+}
+
+// fire trata a ocorrência vencida de sa: avança next_run_at e, se a
+// reivindicação é desta réplica, submete a ação ou registra que foi pulada.
+func (s *Scheduler) fire(ctx context.Context, sa *ScheduledAction, now time.Time) {
+	log := logging.FromContext(ctx).WithFields(logrus.Fields{"schedule_id": sa.ID, "agent_id": sa.AgentID, "action": sa.Action})
+	due := *sa.NextRunAt
+	// Das ocorrências perdidas, só a mais recente dentro da tolerância
+	// dispara; as anteriores a ela são puladas juntas.
+	cutoff := now.Add(-s.cfg.Grace)
+	missed := due.Before(cutoff)
+	from := due
+	if missed {
+		from = cutoff
+	}
+	run, ok := sa.latest(from, now)
+	claimed, err := s.repo.Claim(ctx, sa.ID, due, sa.next(now))
+	if err != nil {
+		log.WithError(err).Error("Falha ao reivindicar agendamento")
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	if missed {
+		fired.WithLabelValues(LastSkipped).Inc()
+		log.WithField("scheduled_for", due).Warn("Ocorrência de agendamento pulada: atraso além da tolerância")
+		s.publish(ctx, EventSkipped, sa, due, "", "missed by more than "+s.cfg.Grace.String())
+		if !ok {
+			if err := s.repo.Record(ctx, sa.ID, now, "", LastSkipped, ""); err != nil {
+				log.WithError(err).Error("Falha ao registrar agendamento pulado")
+			}
+			return
+		}
+	}
+
+	actx := ctx
+	if sa.CreatedBy != "" {
+		actx = auth.WithPrincipal(ctx, &auth.Principal{Subject: sa.CreatedBy, Roles: sa.CreatorRoles, Scopes: sa.CreatorScopes})
+	}
+	a, err := s.submitter.Submit(actx, sa.AgentID, agent.ActionRequest{Action: sa.Action, Params: sa.Params}, sa.Priority)
+	status, msg := LastSubmitted, ""
+	if err != nil {
+		status, msg = LastFailed, submitError(err)
+		log.WithError(err).Warn("Falha ao submeter ação agendada")
+	} else {
+		s.publish(ctx, EventFired, sa, run, a.ID, "")
+	}
+	fired.WithLabelValues(status).Inc()
+	if err := s.repo.Record(ctx, sa.ID, now, a.ID, status, msg); err != nil {
+		log.WithError(err).Error("Falha ao registrar disparo de agendamento")
+	}
+}
+
+// submitError é o last_error de uma submissão recusada; erros internos
+// não vão para a API.
+func submitError(err error) string {
+	var unsupported *action.UnsupportedError
+	var invalid *action.InvalidParamsError
+	var missing *action.MissingCapabilitiesError
+	switch {
+	case errors.Is(err, agent.ErrNotFound):
+		return "agent not found"
+	case errors.Is(err, action.ErrQueueFull), errors.Is(err, action.ErrForbidden),
+		errors.As(err, &unsupported), errors.As(err, &invalid), errors.As(err, &missing):
+		return err.Error()
+	}
+	return "action submission failed"
+}
+
+func (s *Scheduler) publish(ctx context.Context, eventType string, sa *ScheduledAction, at time.Time, actionID, reason string) {
+	s.publisher.Publish(ctx, events.New(events.TopicAgents, eventType, events.ScheduledActionV1{
+		ID:           sa.ID,
+		AgentID:      sa.AgentID,
+		Action:       sa.Action,
+		ScheduledFor: at.UTC(),
+		ActionID:     actionID,
+		Reason:       reason,
+	}))
+}
+
+// lead disputa o disparo. A chave expira em três intervalos, para que
+// outra réplica assuma se esta cair.
+func (s *Scheduler) lead(ctx context.Context) (bool, error) {
+	return s.leader.Acquire(ctx, 3*s.cfg.Interval)
+}
@@ -0,0 +1,160 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"smart-city-microservices/internal/logging"
+)
+
+// Sufixo e prefixo que indicam indireção de segredos na configuração:
+//
+//	database.password_file: /run/secrets/db_password
+//	redis.password: vault:smart-city/redis#password
+const (
+	FileSuffix  = "_file"
+	VaultPrefix = "vault:"
+)
+
+// ChangeFunc é chamada quando um segredo rotaciona. Recebe apenas a chave,
+// nunca o valor.
+type ChangeFunc func(ctx context.Context, key string)
+
+type source struct {
+	provider Provider
+	ref      string
+}
+
+// Manager resolve e mantém atualizados os segredos referenciados na configuração.
+type Manager struct {
+	vault Provider
+
+	mu       sync.Mutex
+	sources  map[string]source
+	values   map[string]string
+	handlers []ChangeFunc
+}
+
+// NewManager cria o manager. vault pode ser nil quando não configurado.
+func NewManager(vault Provider) *Manager {
+	return &Manager{
+		vault:   vault,
+		sources: map[string]source{},
+		values:  map[string]string{},
+	}
+}
+
+// OnChange registra um callback de rotação.
+func (m *Manager) OnChange(fn ChangeFunc) {
+	m.mu.Lock()
+	m.handlers = append(m.handlers, fn)
+	m.mu.Unlock()
+}
+
+// Resolve substitui em v todas as referências a segredos pelos valores reais.
+// Pode ser aplicado tanto à configuração ativa quanto a candidatas de recarga.
+func (m *Manager) Resolve(ctx context.Context, v *viper.Viper) error {
+	found := m.discover(v)
+
+	var errs []error
+	for key, src := range found {
+		value, err := src.provider.Fetch(ctx, src.ref)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("segredo %s (%s): %w", key, src.provider.Name(), err))
+			continue
+		}
+		v.Set(key, value)
+		m.mu.Lock()
+		m.sources[key] = src
+		m.values[key] = value
+		m.mu.Unlock()
+	}
+	return errors.Join(errs...)
+}
+
+func (m *Manager) discover(v *viper.Viper) map[string]source {
+	found := map[string]source{}
+	for _, key := range v.AllKeys() {
+		raw := v.GetString(key)
+		if raw == "" {
+			continue
+		}
+		switch {
+		case strings.HasSuffix(key, FileSuffix):
+			found[strings.TrimSuffix(key, FileSuffix)] = source{provider: FileProvider{}, ref: raw}
+		case strings.HasPrefix(raw, VaultPrefix) && m.vault != nil:
+			found[key] = source{provider: m.vault, ref: strings.TrimPrefix(raw, VaultPrefix)}
+		}
+	}
+	return found
+}
+
+// Start busca os segredos periodicamente e aplica rotações em v até ctx ser cancelado.
+func (m *Manager) Start(ctx context.Context, v *viper.Viper, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ctx = logging.Background(ctx, "secrets-refresh")
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				m.refresh(ctx, v)
+			}
+		}
+	}()
+}
+
+func (m *Manager) refresh(ctx context.Context, v *viper.Viper) {
+	log := logging.FromContext(ctx)
+
+	m.mu.Lock()
+	sources := make(map[string]source, len(m.sources))
+	for k, s := range m.sources {
+		sources[k] = s
+	}
+	m.mu.Unlock()
+
+	for key, src := range sources {
+		value, err := src.provider.Fetch(ctx, src.ref)
+		if err != nil {
+			log.WithError(err).WithField("key", key).Warn("Falha ao renovar segredo; mantendo valor atual")
+			continue
+		}
+
+		m.mu.Lock()
+		changed := m.values[key] != value
+		if changed {
+			m.values[key] = value
+		}
+		handlers := m.handlers
+		m.mu.Unlock()
+
+		if !changed {
+			continue
+		}
+		v.Set(key, value)
+		log.WithField("key", key).Info("Segredo rotacionado")
+		for _, h := range handlers {
+			h(ctx, key)
+		}
+	}
+}
+
+// Value retorna o valor atual de um segredo resolvido.
+func (m *Manager) Value(key string) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, ok := m.values[key]
+	return v, ok
+}
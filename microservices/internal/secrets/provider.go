@@ -0,0 +1,109 @@
+// Package secrets resolve valores sensíveis da configuração a partir de
+// arquivos (secrets Docker/Kubernetes) ou de um cofre externo, com recarga
+// periódica para rotação de credenciais sem reinício.
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"smart-city-microservices/internal/outbound"
+)
+
+// Provider busca o valor de um segredo a partir de uma referência.
+type Provider interface {
+	Name() string
+	Fetch(ctx context.Context, ref string) (string, error)
+}
+
+// FileProvider lê segredos de arquivos, removendo a quebra de linha final.
+type FileProvider struct{}
+
+// Name implementa Provider.
+func (FileProvider) Name() string { return "file" }
+
+// Fetch implementa Provider; ref é o caminho do arquivo.
+func (FileProvider) Fetch(_ context.Context, ref string) (string, error) {
+	b, err := os.ReadFile(ref)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// VaultConfig configura o acesso ao HashiCorp Vault (KV v2).
+type VaultConfig struct {
+	Address string
+	Token   string
+	Mount   string
+	Timeout time.Duration
+}
+
+// VaultProvider lê segredos de um engine KV v2 do HashiCorp Vault.
+// Referências têm o formato "caminho/do/segredo#campo".
+type VaultProvider struct {
+	cfg    VaultConfig
+	client *http.Client
+}
+
+// NewVaultProvider cria o provider do Vault sobre o pool de saída. As
+// leituras (GET) são repetidas pelo cliente em falhas transitórias.
+func NewVaultProvider(cfg VaultConfig, pool *outbound.Pool) *VaultProvider {
+	if cfg.Mount == "" {
+		cfg.Mount = "secret"
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	return &VaultProvider{cfg: cfg, client: pool.Client(outbound.Options{
+		Name:                 "vault",
+		Timeout:              cfg.Timeout,
+		AllowPrivateNetworks: true,
+	})}
+}
+
+// Name implementa Provider.
+func (p *VaultProvider) Name() string { return "vault" }
+
+// Fetch implementa Provider.
+func (p *VaultProvider) Fetch(ctx context.Context, ref string) (string, error) {
+	path, field, ok := strings.Cut(ref, "#")
+	if !ok || path == "" || field == "" {
+		return "", fmt.Errorf("referência vault inválida (esperado caminho#campo)")
+	}
+
+	url := strings.TrimRight(p.cfg.Address, "/") + "/v1/" + p.cfg.Mount + "/data/" + strings.TrimLeft(path, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", p.cfg.Token)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("consultar vault: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault respondeu %d para %s", resp.StatusCode, path)
+	}
+
+	var body struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("decodificar resposta do vault: %w", err)
+	}
+	value, ok := body.Data.Data[field]
+	if !ok {
+		return "", fmt.Errorf("campo %q ausente em %s", field, path)
+	}
+	return fmt.Sprint(value), nil
+}
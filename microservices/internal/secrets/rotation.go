@@ -0,0 +1,52 @@
+package secrets
+
+import (
+	"context"
+	"database/sql/driver"
+
+	"github.com/lib/pq"
+)
+
+// RedisCredentials retorna uma função compatível com
+// redis.Options.CredentialsProvider que sempre usa a senha atual.
+func (m *Manager) RedisCredentials(key, username, fallback string) func() (string, string) {
+	return func() (string, string) {
+		if v, ok := m.Value(key); ok {
+			return username, v
+		}
+		return username, fallback
+	}
+}
+
+// PostgresConnector cria conexões com a senha atual a cada nova conexão do
+// pool, de modo que uma rotação vale sem reabrir o *sql.DB (use com sql.OpenDB).
+type PostgresConnector struct {
+	manager  *Manager
+	key      string
+	dsn      func(password string) string
+	fallback string
+}
+
+// NewPostgresConnector cria o connector; dsn monta a string de conexão a
+// partir da senha vigente.
+func (m *Manager) NewPostgresConnector(key, fallback string, dsn func(password string) string) *PostgresConnector {
+	return &PostgresConnector{manager: m, key: key, dsn: dsn, fallback: fallback}
+}
+
+// Connect implementa driver.Connector.
+func (c *PostgresConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	password := c.fallback
+	if v, ok := c.manager.Value(c.key); ok {
+		password = v
+	}
+	connector, err := pq.NewConnector(c.dsn(password))
+	if err != nil {
+		return nil, err
+	}
+	return connector.Connect(ctx)
+}
+
+// Driver implementa driver.Connector.
+func (c *PostgresConnector) Driver() driver.Driver {
+	return &pq.Driver{}
+}
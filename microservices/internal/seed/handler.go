@@ -0,0 +1,69 @@
+package seed
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/audit"
+	"smart-city-microservices/internal/i18n"
+	"smart-city-microservices/internal/logging"
+)
+
+// MaxAgentsPerType limita os agentes por tipo de uma execução pela API.
+const MaxAgentsPerType = 100000
+
+// Handler expõe POST /admin/seed.
+type Handler struct {
+	seeder   *Seeder
+	defaults Options
+}
+
+// NewHandler cria o handler; defaults vale para o que o corpo não informa.
+func NewHandler(seeder *Seeder, defaults Options) *Handler {
+	return &Handler{seeder: seeder, defaults: defaults}
+}
+
+// Request é o corpo, opcional, de POST /admin/seed.
+type Request struct {
+	AgentsPerType *int     `json:"agents_per_type"`
+	Types         []string `json:"types"`
+}
+
+// Seed responde POST /admin/seed com o resumo da execução.
+func (h *Handler) Seed(c *gin.Context) {
+	var req Request
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		i18n.BindError(c, err)
+		return
+	}
+	opts := h.defaults
+	if req.AgentsPerType != nil {
+		opts.AgentsPerType = *req.AgentsPerType
+	}
+	if opts.AgentsPerType < 1 || opts.AgentsPerType > MaxAgentsPerType {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "agents_per_type must be between 1 and " + strconv.Itoa(MaxAgentsPerType)})
+		return
+	}
+	if len(req.Types) > 0 {
+		opts.Types = req.Types
+	}
+	ctx := c.Request.Context()
+	res, err := h.seeder.Run(ctx, opts)
+	var unknown *UnknownTypesError
+	switch {
+	case errors.As(err, &unknown):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "agent_types": unknown.Known})
+		return
+	case err != nil:
+		logging.FromContext(ctx).WithError(err).Error("Erro no handler de seed")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+	audit.Record(ctx, "seed.applied", logrus.Fields{"project_id": res.ProjectID, "agents": res.Agents, "events": res.Events})
+	c.JSON(http.StatusOK, res)
+}
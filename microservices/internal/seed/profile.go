@@ -0,0 +1,103 @@
+package seed
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+
+	"smart-city-microservices/internal/agenttype"
+)
+
+// districts são os distritos de state.district, usados nos agregados por
+// distrito.
+var districts = []string{"centro", "norte", "sul", "leste", "oeste"}
+
+// profile descreve como gerar agentes plausíveis de um tipo.
+type profile struct {
+	// name é o rótulo dos nomes gerados; vazio usa o display_name.
+	name string
+	// speed é a velocidade máxima, em km/h; 0 para agentes fixos.
+	speed    float64
+	statuses []string
+	state    func(r *rand.Rand) map[string]interface{}
+	// metrics são os valores típicos das métricas conhecidas do tipo.
+	metrics map[string]float64
+}
+
+func (p profile) label(t *agenttype.Type) string {
+	if p.name != "" {
+		return p.name
+	}
+	if t.DisplayName != "" {
+		return t.DisplayName
+	}
+	return strings.ReplaceAll(t.Name, "_", " ")
+}
+
+// metricBase retorna o valor típico da métrica; as não conhecidas ficam
+// em torno de 50.
+func (p profile) metricBase(name string) float64 {
+	if v, ok := p.metrics[name]; ok {
+		return v
+	}
+	return 50
+}
+
+var profiles = map[string]profile{
+	"vehicle": {
+		name:     "Veículo",
+		speed:    60,
+		statuses: []string{"active", "active", "active", "idle"},
+		state: func(r *rand.Rand) map[string]interface{} {
+			return map[string]interface{}{
+				"speed":            math.Round(r.Float64() * 60),
+				"heading":          math.Round(r.Float64() * 360),
+				"route_completion": math.Round(r.Float64()*1000) / 10,
+			}
+		},
+		metrics: map[string]float64{"route_completion": 60, "speed": 35, "energy_wh": 12000},
+	},
+	"bus": {
+		name:     "Ônibus",
+		speed:    50,
+		statuses: []string{"active", "active", "idle"},
+		state: func(r *rand.Rand) map[string]interface{} {
+			return map[string]interface{}{
+				"line":       fmt.Sprintf("L-%d", 10+r.Intn(90)),
+				"passengers": r.Intn(80),
+				"speed":      math.Round(r.Float64() * 50),
+				"heading":    math.Round(r.Float64() * 360),
+			}
+		},
+		metrics: map[string]float64{"route_completion": 55, "passengers": 40, "delay": 240},
+	},
+	"sensor": {
+		name:     "Sensor",
+		statuses: []string{"active", "active", "active", "active", "idle"},
+		state: func(r *rand.Rand) map[string]interface{} {
+			return map[string]interface{}{
+				"pm25":        math.Round((5+r.Float64()*45)*10) / 10,
+				"temperature": math.Round((15+r.Float64()*15)*10) / 10,
+				"battery":     math.Round(20 + r.Float64()*80),
+			}
+		},
+		metrics: map[string]float64{"uptime": 96, "readings": 120},
+	},
+}
+
+// generic vale para os tipos sem perfil próprio.
+var generic = profile{
+	speed:    20,
+	statuses: []string{"active", "idle"},
+	state: func(r *rand.Rand) map[string]interface{} {
+		return map[string]interface{}{"load": math.Round(r.Float64() * 100)}
+	},
+}
+
+func profileFor(agentType string) profile {
+	if p, ok := profiles[agentType]; ok {
+		return p
+	}
+	return generic
+}
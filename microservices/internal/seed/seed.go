@@ -0,0 +1,494 @@
+// Package seed gera dados de demonstração: agentes de cada tipo do registro
+// espalhados numa área, dois cenários, uma simulação concluída com
+// histórico de eventos e métricas e uma simulação em execução.
+//
+// Os ids são UUIDs determinísticos (v5) derivados do papel de cada linha,
+// ex.: o 42º agente do tipo bus, e os valores vêm de um gerador semeado
+// pelo id. Rodar de novo atualiza as mesmas linhas em vez de duplicá-las;
+// diminuir a quantidade não remove os agentes que sobraram da anterior. As
+// linhas entram em lotes por unnest, numa só transação.
+package seed
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	"smart-city-microservices/internal/agentmetric"
+	"smart-city-microservices/internal/agenttype"
+	"smart-city-microservices/internal/instrument"
+)
+
+// Source é a origem das linhas geradas, em events.source e metrics.source.
+const Source = "seed"
+
+// batchSize é o número de linhas de cada INSERT.
+const batchSize = 2000
+
+// completedShare é a fração dos agentes de cada tipo que fica na simulação
+// concluída; os demais ficam na em execução.
+const completedShare = 5
+
+// namespace é a raiz dos UUIDs determinísticos.
+var namespace = uuid.NewSHA1(uuid.NameSpaceURL, []byte("smart-city-microservices/seed"))
+
+// BBox é um retângulo em graus.
+type BBox struct {
+	MinLat float64 `json:"min_lat"`
+	MinLon float64 `json:"min_lon"`
+	MaxLat float64 `json:"max_lat"`
+	MaxLon float64 `json:"max_lon"`
+}
+
+// Options configura uma execução.
+type Options struct {
+	// AgentsPerType são os agentes gerados de cada tipo.
+	AgentsPerType int
+	// Types são os tipos gerados; vazio gera todos os do registro.
+	Types     []string
+	ProjectID string
+	// History é a duração da simulação concluída.
+	History time.Duration
+	BBox    BBox
+}
+
+// Result resume uma execução.
+type Result struct {
+	ProjectID           string         `json:"project_id"`
+	CompletedSimulation string         `json:"completed_simulation_id"`
+	RunningSimulation   string         `json:"running_simulation_id"`
+	Scenarios           []string       `json:"scenario_ids"`
+	Agents              map[string]int `json:"agents"`
+	Events              int            `json:"events"`
+	Metrics             int            `json:"metrics"`
+	MetricSamples       int            `json:"metric_samples"`
+	DurationMs          int64          `json:"duration_ms"`
+}
+
+// TypeLister é o subconjunto de *agenttype.Repository usado pelo seed.
+type TypeLister interface {
+	List(ctx context.Context) ([]*agenttype.Type, error)
+}
+
+// UnknownTypesError indica tipos pedidos que não estão no registro.
+type UnknownTypesError struct {
+	Types []string
+	Known []string
+}
+
+func (e *UnknownTypesError) Error() string {
+	return "unknown agent types: " + strings.Join(e.Types, ", ")
+}
+
+// Seeder grava os dados de demonstração.
+type Seeder struct {
+	db    *instrument.DB
+	types TypeLister
+}
+
+// New cria o seeder.
+func New(db *sql.DB, types TypeLister) *Seeder {
+	return &Seeder{db: instrument.NewDB(db), types: types}
+}
+
+// ID retorna o UUID determinístico do papel, ex.: "agent/bus/42".
+func ID(role string) string {
+	return uuid.NewSHA1(namespace, []byte(role)).String()
+}
+
+// Run grava os dados de demonstração.
+func (s *Seeder) Run(ctx context.Context, opts Options) (res *Result, err error) {
+	started := time.Now()
+	types, err := s.resolve(ctx, opts.Types)
+	if err != nil {
+		return nil, err
+	}
+	span := instrument.StartQuery(ctx, "seed.run")
+	defer func() {
+		var rows int64
+		if res != nil {
+			rows = int64(res.Events + res.Metrics + res.MetricSamples)
+			for _, n := range res.Agents {
+				rows += int64(n)
+			}
+		}
+		span.End(rows, err)
+	}()
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// As datas partem da hora cheia, para que execuções próximas gravem o
+	// mesmo histórico.
+	anchor := started.UTC().Truncate(time.Hour)
+	res = &Result{
+		ProjectID:           opts.ProjectID,
+		CompletedSimulation: ID("simulation/completed"),
+		RunningSimulation:   ID("simulation/running"),
+		Agents:              map[string]int{},
+	}
+	completedFrom, completedTo := anchor.Add(-opts.History-time.Hour), anchor.Add(-time.Hour)
+	if err := s.simulations(ctx, tx.Tx, res, opts, completedFrom, completedTo, anchor.Add(-30*time.Minute)); err != nil {
+		return nil, fmt.Errorf("simulações: %w", err)
+	}
+	if res.Scenarios, err = s.scenarios(ctx, tx.Tx, res.CompletedSimulation, completedFrom, completedTo); err != nil {
+		return nil, fmt.Errorf("cenários: %w", err)
+	}
+
+	var history []agentRow
+	for _, t := range types {
+		rows := generateAgents(t, opts, res)
+		for start := 0; start < len(rows); start += batchSize {
+			if err := insertAgents(ctx, tx.Tx, rows[start:min(start+batchSize, len(rows))]); err != nil {
+				return nil, fmt.Errorf("agentes do tipo %s: %w", t.Name, err)
+			}
+		}
+		res.Agents[t.Name] = len(rows)
+		for _, r := range rows {
+			if r.simulationID == res.CompletedSimulation {
+				history = append(history, r)
+			}
+		}
+	}
+	if res.Events, err = s.events(ctx, tx.Tx, history, completedFrom, completedTo); err != nil {
+		return nil, fmt.Errorf("eventos: %w", err)
+	}
+	if res.MetricSamples, err = s.samples(ctx, tx.Tx, history, completedFrom, completedTo); err != nil {
+		return nil, fmt.Errorf("amostras de métricas: %w", err)
+	}
+	if res.Metrics, err = s.metrics(ctx, tx.Tx, res.CompletedSimulation, len(history), completedFrom, completedTo); err != nil {
+		return nil, fmt.Errorf("métricas: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	res.DurationMs = time.Since(started).Milliseconds()
+	return res, nil
+}
+
+// resolve retorna os tipos pedidos, ou todos os do registro.
+func (s *Seeder) resolve(ctx context.Context, names []string) ([]*agenttype.Type, error) {
+	all, err := s.types.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return all, nil
+	}
+	byName := make(map[string]*agenttype.Type, len(all))
+	known := make([]string, 0, len(all))
+	for _, t := range all {
+		byName[t.Name] = t
+		known = append(known, t.Name)
+	}
+	var out []*agenttype.Type
+	var unknown []string
+	seen := map[string]bool{}
+	for _, n := range names {
+		if seen[n] {
+			continue
+		}
+		seen[n] = true
+		if t, ok := byName[n]; ok {
+			out = append(out, t)
+		} else {
+			unknown = append(unknown, n)
+		}
+	}
+	if len(unknown) > 0 {
+		return nil, &UnknownTypesError{Types: unknown, Known: known}
+	}
+	return out, nil
+}
+
+func (s *Seeder) simulations(ctx context.Context, tx *sql.Tx, res *Result, opts Options, from, to, runningFrom time.Time) error {
+	cfg, _ := json.Marshal(map[string]interface{}{"seeded": true, "bbox": opts.BBox})
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO simulations (id, name, description, config, status, project_id, started_at, ended_at, created_by)
+		VALUES
+			($1, 'Demonstração: dia completo', 'Simulação concluída gerada pelo seed, com histórico de eventos e métricas', $3, 'completed', $4, $5, $6, 'seed'),
+			($2, 'Demonstração: ao vivo', 'Simulação em execução gerada pelo seed', $3, 'running', $4, $7, NULL, 'seed')
+		ON CONFLICT (id) DO UPDATE SET
+			name = EXCLUDED.name, description = EXCLUDED.description, config = EXCLUDED.config,
+			status = EXCLUDED.status, project_id = EXCLUDED.project_id,
+			started_at = EXCLUDED.started_at, ended_at = EXCLUDED.ended_at`,
+		res.CompletedSimulation, res.RunningSimulation, cfg, opts.ProjectID, from, to, runningFrom)
+	return err
+}
+
+func (s *Seeder) scenarios(ctx context.Context, tx *sql.Tx, completedSim string, from, to time.Time) ([]string, error) {
+	rush, outage := ID("scenario/rush-hour"), ID("scenario/sensor-outage")
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO scenarios (id, name, description, scenario_type, config, expected_outcomes, created_by)
+		VALUES
+			($1, 'Hora do rush', 'Demanda de transporte dobrada entre 17h e 19h', 'traffic',
+				'{"demand_multiplier": 2.0, "window": {"from": "17:00", "to": "19:00"}}',
+				'{"max_avg_delay_s": 600, "min_route_completion": 0.9}', 'seed'),
+			($2, 'Queda de sensores', 'Um quarto dos sensores fica sem energia por uma hora', 'infrastructure',
+				'{"failure_ratio": 0.25, "duration": "1h"}',
+				'{"max_offline_ratio": 0.3}', 'seed')
+		ON CONFLICT (id) DO UPDATE SET
+			name = EXCLUDED.name, description = EXCLUDED.description, scenario_type = EXCLUDED.scenario_type,
+			config = EXCLUDED.config, expected_outcomes = EXCLUDED.expected_outcomes`, rush, outage)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO scenario_executions (id, scenario_id, simulation_id, status, started_at, ended_at, results)
+		VALUES ($1, $2, $3, 'completed', $4, $5, '{"avg_delay_s": 412, "route_completion": 0.94}')
+		ON CONFLICT (id) DO UPDATE SET
+			status = EXCLUDED.status, started_at = EXCLUDED.started_at, ended_at = EXCLUDED.ended_at,
+			results = EXCLUDED.results`,
+		ID("scenario_execution/rush-hour/completed"), rush, completedSim, from, to)
+	return []string{rush, outage}, err
+}
+
+// agentRow é um agente gerado.
+type agentRow struct {
+	id, simulationID, agentType, name string
+	lat, lon, energy                  float64
+	state                             []byte
+	active                            bool
+	metrics                           []agentmetric.Definition
+	profile                           profile
+}
+
+func generateAgents(t *agenttype.Type, opts Options, res *Result) []agentRow {
+	p := profileFor(t.Name)
+	metrics := append([]agentmetric.Definition(nil), t.Metrics...)
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].Name < metrics[j].Name })
+	rows := make([]agentRow, opts.AgentsPerType)
+	for i := range rows {
+		id := ID(fmt.Sprintf("agent/%s/%d", t.Name, i))
+		r := rng(id)
+		row := agentRow{
+			id:           id,
+			simulationID: res.RunningSimulation,
+			agentType:    t.Name,
+			name:         fmt.Sprintf("%s %04d", p.label(t), i+1),
+			lat:          opts.BBox.MinLat + r.Float64()*(opts.BBox.MaxLat-opts.BBox.MinLat),
+			lon:          opts.BBox.MinLon + r.Float64()*(opts.BBox.MaxLon-opts.BBox.MinLon),
+			energy:       math.Round((40+r.Float64()*60)*100) / 100,
+			active:       true,
+			metrics:      metrics,
+			profile:      p,
+		}
+		status := p.statuses[r.Intn(len(p.statuses))]
+		if i%completedShare == 0 {
+			row.simulationID, row.active, status = res.CompletedSimulation, false, "stopped"
+		}
+		state := p.state(r)
+		state["status"] = status
+		state["district"] = districts[r.Intn(len(districts))]
+		state["seeded"] = true
+		row.state, _ = json.Marshal(state)
+		rows[i] = row
+	}
+	return rows
+}
+
+func insertAgents(ctx context.Context, tx *sql.Tx, rows []agentRow) error {
+	n := len(rows)
+	ids, sims, types, names, states := make([]string, n), make([]string, n), make([]string, n), make([]string, n), make([]string, n)
+	lats, lons, energies := make([]float64, n), make([]float64, n), make([]float64, n)
+	active := make([]bool, n)
+	for i, r := range rows {
+		ids[i], sims[i], types[i], names[i], states[i] = r.id, r.simulationID, r.agentType, r.name, string(r.state)
+		lats[i], lons[i], energies[i], active[i] = r.lat, r.lon, r.energy, r.active
+	}
+	// position guarda (lon, lat), x e y do ponto.
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO agents (id, simulation_id, agent_type, name, state, position, energy, is_active, updated_at)
+		SELECT a.id, a.simulation_id, a.agent_type, a.name, a.state::jsonb, point(a.lon, a.lat), a.energy, a.active,
+			CURRENT_TIMESTAMP
+		FROM unnest($1::uuid[], $2::uuid[], $3::text[], $4::text[], $5::text[], $6::float8[], $7::float8[],
+			$8::float8[], $9::bool[]) AS a(id, simulation_id, agent_type, name, state, lat, lon, energy, active)
+		ON CONFLICT (id) DO UPDATE SET
+			simulation_id = EXCLUDED.simulation_id, agent_type = EXCLUDED.agent_type, name = EXCLUDED.name,
+			state = EXCLUDED.state, position = EXCLUDED.position, energy = EXCLUDED.energy,
+			is_active = EXCLUDED.is_active, updated_at = CURRENT_TIMESTAMP`,
+		pq.StringArray(ids), pq.StringArray(sims), pq.StringArray(types), pq.StringArray(names),
+		pq.StringArray(states), pq.Float64Array(lats), pq.Float64Array(lons), pq.Float64Array(energies),
+		pq.BoolArray(active))
+	return err
+}
+
+// eventsPerAgent são os eventos gerados para cada agente da simulação
+// concluída: criação, movimentos e o fim.
+const eventsPerAgent = 8
+
+func (s *Seeder) events(ctx context.Context, tx *sql.Tx, agents []agentRow, from, to time.Time) (int, error) {
+	var ids, sims, agentIDs, types, descs, data, severities, ats []string
+	flush := func() error {
+		if len(ids) == 0 {
+			return nil
+		}
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO events (id, simulation_id, agent_id, event_type, description, data, timestamp, severity, source)
+			SELECT e.id, e.simulation_id, e.agent_id, e.event_type, e.description, e.data::jsonb, e.at, e.severity, $9
+			FROM unnest($1::uuid[], $2::uuid[], $3::uuid[], $4::text[], $5::text[], $6::text[], $7::timestamptz[],
+				$8::text[]) AS e(id, simulation_id, agent_id, event_type, description, data, at, severity)
+			ON CONFLICT (id) DO UPDATE SET
+				event_type = EXCLUDED.event_type, description = EXCLUDED.description, data = EXCLUDED.data,
+				timestamp = EXCLUDED.timestamp, severity = EXCLUDED.severity`,
+			pq.StringArray(ids), pq.StringArray(sims), pq.StringArray(agentIDs), pq.StringArray(types),
+			pq.StringArray(descs), pq.StringArray(data), pq.StringArray(ats), pq.StringArray(severities), Source)
+		ids, sims, agentIDs, types, descs, data, severities, ats = ids[:0], sims[:0], agentIDs[:0], types[:0], descs[:0], data[:0], severities[:0], ats[:0]
+		return err
+	}
+	total := 0
+	span := to.Sub(from)
+	for _, a := range agents {
+		r := rng(a.id + "/events")
+		lat, lon := a.lat, a.lon
+		for k := 0; k < eventsPerAgent; k++ {
+			at := from.Add(time.Duration(float64(span) * float64(k) / eventsPerAgent)).Add(time.Duration(r.Int63n(int64(time.Minute))))
+			eventType, desc, severity := "agent.moved", "Agente se deslocou", "info"
+			payload := map[string]interface{}{}
+			switch {
+			case k == 0:
+				eventType, desc = "agent.created", "Agente criado"
+				payload["agent_type"] = a.agentType
+			case k == eventsPerAgent-1:
+				eventType, desc = "agent.stopped", "Agente parado no fim da simulação"
+			case r.Intn(10) == 0:
+				eventType, desc, severity = "agent.degraded", "Nota de saúde abaixo do limite", "warning"
+				payload["health_score"] = math.Round(30 + r.Float64()*40)
+			default:
+				lat += (r.Float64() - 0.5) * 0.004
+				lon += (r.Float64() - 0.5) * 0.004
+				payload["lat"], payload["lon"] = lat, lon
+				payload["speed"] = math.Round(a.profile.speed * r.Float64())
+			}
+			raw, _ := json.Marshal(payload)
+			ids = append(ids, ID(fmt.Sprintf("event/%s/%d", a.id, k)))
+			sims = append(sims, a.simulationID)
+			agentIDs = append(agentIDs, a.id)
+			types = append(types, eventType)
+			descs = append(descs, desc)
+			data = append(data, string(raw))
+			severities = append(severities, severity)
+			ats = append(ats, at.Format(time.RFC3339Nano))
+			total++
+			if len(ids) == batchSize {
+				if err := flush(); err != nil {
+					return total, err
+				}
+			}
+		}
+	}
+	return total, flush()
+}
+
+// samplesPerMetric são as amostras de cada métrica declarada do tipo, por
+// agente da simulação concluída.
+const samplesPerMetric = 12
+
+// samples grava as amostras das métricas declaradas dos agentes da
+// simulação concluída. agent_metric_samples não tem chave: as amostras
+// anteriores desses agentes saem antes.
+func (s *Seeder) samples(ctx context.Context, tx *sql.Tx, agents []agentRow, from, to time.Time) (int, error) {
+	ids := make([]string, len(agents))
+	for i, a := range agents {
+		ids[i] = a.id
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM agent_metric_samples WHERE agent_id = ANY($1::uuid[])`, pq.StringArray(ids)); err != nil {
+		return 0, err
+	}
+	var agentIDs, names, ats []string
+	var values []float64
+	flush := func() error {
+		if len(agentIDs) == 0 {
+			return nil
+		}
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO agent_metric_samples (agent_id, name, value, recorded_at)
+			SELECT * FROM unnest($1::uuid[], $2::text[], $3::float8[], $4::timestamptz[])`,
+			pq.StringArray(agentIDs), pq.StringArray(names), pq.Float64Array(values), pq.StringArray(ats))
+		agentIDs, names, values, ats = agentIDs[:0], names[:0], values[:0], ats[:0]
+		return err
+	}
+	total := 0
+	step := to.Sub(from) / samplesPerMetric
+	for _, a := range agents {
+		r := rng(a.id + "/samples")
+		for _, m := range a.metrics {
+			base := a.profile.metricBase(m.Name)
+			for k := 0; k < samplesPerMetric; k++ {
+				v := base * (0.8 + 0.4*r.Float64())
+				if m.Unit == "%" {
+					v = math.Min(v, 100)
+				}
+				agentIDs = append(agentIDs, a.id)
+				names = append(names, m.Name)
+				values = append(values, math.Round(v*100)/100)
+				ats = append(ats, from.Add(step*time.Duration(k)).Format(time.RFC3339Nano))
+				total++
+			}
+			if len(agentIDs) >= batchSize {
+				if err := flush(); err != nil {
+					return total, err
+				}
+			}
+		}
+	}
+	return total, flush()
+}
+
+// metricInterval é o intervalo das métricas da simulação concluída.
+const metricInterval = 10 * time.Minute
+
+// metrics grava as métricas agregadas da simulação concluída, com a curva
+// de um dia: pico de manhã e no fim da tarde.
+func (s *Seeder) metrics(ctx context.Context, tx *sql.Tx, simulationID string, agents int, from, to time.Time) (int, error) {
+	var ids, names, units, ats []string
+	var values []float64
+	r := rng(simulationID + "/metrics")
+	for at := from; at.Before(to); at = at.Add(metricInterval) {
+		hour := float64(at.Hour()) + float64(at.Minute())/60
+		load := 0.3 + 0.7*math.Max(math.Exp(-math.Pow(hour-8, 2)/2), math.Exp(-math.Pow(hour-18, 2)/2))
+		for _, m := range []struct {
+			name, unit string
+			value      float64
+		}{
+			{"active_agents", "", math.Round(float64(agents) * (0.5 + 0.5*load))},
+			{"avg_speed", "km/h", 45 - 25*load + r.Float64()*3},
+			{"congestion_index", "", load + r.Float64()*0.05},
+			{"energy_consumption", "kWh", float64(agents) * 0.2 * load * (0.9 + 0.2*r.Float64())},
+		} {
+			ids = append(ids, ID(fmt.Sprintf("metric/%s/%s/%d", simulationID, m.name, at.Sub(from)/metricInterval)))
+			names = append(names, m.name)
+			units = append(units, m.unit)
+			values = append(values, math.Round(m.value*1000)/1000)
+			ats = append(ats, at.Format(time.RFC3339Nano))
+		}
+	}
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO metrics (id, simulation_id, metric_name, value, unit, timestamp, source)
+		SELECT m.id, $2, m.name, m.value, NULLIF(m.unit, ''), m.at, $7
+		FROM unnest($1::uuid[], $3::text[], $4::float8[], $5::text[], $6::timestamptz[]) AS m(id, name, value, unit, at)
+		ON CONFLICT (id) DO UPDATE SET value = EXCLUDED.value, unit = EXCLUDED.unit, timestamp = EXCLUDED.timestamp`,
+		pq.StringArray(ids), simulationID, pq.StringArray(names), pq.Float64Array(values), pq.StringArray(units),
+		pq.StringArray(ats), Source)
+	return len(ids), err
+}
+
+// rng retorna um gerador semeado pela chave, para que cada linha receba
+// os mesmos valores a cada execução.
+func rng(key string) *rand.Rand {
+	sum := uuid.NewSHA1(namespace, []byte(key))
+	return rand.New(rand.NewSource(int64(binary.BigEndian.Uint64(sum[:8]))))
+}
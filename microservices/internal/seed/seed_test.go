@@ -0,0 +1,72 @@
+package seed
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"smart-city-microservices/internal/agenttype"
+	"smart-city-microservices/internal/containers"
+)
+
+var benchOpts = Options{
+	AgentsPerType: 10000,
+	History:       time.Hour,
+	BBox:          BBox{MinLat: -23.65, MinLon: -46.75, MaxLat: -23.45, MaxLon: -46.55},
+}
+
+func TestGenerateAgentsDeterministic(t *testing.T) {
+	res := &Result{CompletedSimulation: ID("simulation/completed"), RunningSimulation: ID("simulation/running")}
+	typ := &agenttype.Type{Name: "bus"}
+	a, b := generateAgents(typ, benchOpts, res), generateAgents(typ, benchOpts, res)
+	if len(a) != benchOpts.AgentsPerType {
+		t.Fatalf("%d agentes, want %d", len(a), benchOpts.AgentsPerType)
+	}
+	completed := 0
+	for i := range a {
+		if a[i].id != b[i].id || a[i].lat != b[i].lat || a[i].lon != b[i].lon || string(a[i].state) != string(b[i].state) {
+			t.Fatalf("agente %d difere entre execuções", i)
+		}
+		if a[i].lat < benchOpts.BBox.MinLat || a[i].lat > benchOpts.BBox.MaxLat || a[i].lon < benchOpts.BBox.MinLon || a[i].lon > benchOpts.BBox.MaxLon {
+			t.Errorf("agente %d fora da área: %.4f,%.4f", i, a[i].lat, a[i].lon)
+		}
+		if a[i].simulationID == res.CompletedSimulation {
+			completed++
+		}
+	}
+	if want := benchOpts.AgentsPerType / completedShare; completed != want {
+		t.Errorf("%d agentes na simulação concluída, want %d", completed, want)
+	}
+}
+
+// BenchmarkInsertAgents grava 10 mil agentes em lotes de batchSize, como o
+// Run, numa transação desfeita a cada iteração.
+func BenchmarkInsertAgents(b *testing.B) {
+	db := containers.Postgres(b)
+	s := New(db, nil)
+	ctx := context.Background()
+	res := &Result{CompletedSimulation: ID("simulation/completed"), RunningSimulation: ID("simulation/running")}
+	rows := generateAgents(&agenttype.Type{Name: "bus"}, benchOpts, res)
+	now := time.Now().UTC()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		tx, err := s.db.Begin(ctx)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := s.simulations(ctx, tx.Tx, res, benchOpts, now.Add(-2*time.Hour), now.Add(-time.Hour), now); err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+		for start := 0; start < len(rows); start += batchSize {
+			if err := insertAgents(ctx, tx.Tx, rows[start:min(start+batchSize, len(rows))]); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.StopTimer()
+		tx.Rollback()
+		b.StartTimer()
+	}
+}
@@ -0,0 +1,376 @@
+// Package simmetrics exporta as métricas de cada simulação como séries
+// rotuladas do Prometheus: ticks do relógio, eventos, mensagens à espera,
+// agentes ativos e os agregados das métricas de agentes escolhidas.
+//
+// Os nomes e rótulos das séries são parte da interface com os painéis: não
+// os renomeie. Para limitar a cardinalidade, só MaxSimulations simulações
+// têm séries próprias; as demais somam os contadores na simulação
+// OtherLabel, sem os gauges.
+//
+// As amostras do aquecimento da simulação (internal/warmup) ficam fora de
+// agent_metric_sum e agent_metric_samples_total e vão para as séries
+// agent_metric_warmup_*.
+package simmetrics
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/agentmetric"
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/logging"
+)
+
+// OtherLabel é o valor dos rótulos simulation e project das simulações
+// além de MaxSimulations.
+const OtherLabel = "_other"
+
+// idleAfter é o tempo sem ticks nem eventos depois do qual uma simulação
+// deixa de ser exportada por esta réplica.
+const idleAfter = 10 * time.Minute
+
+// Os descritores das séries exportadas.
+var (
+	simLabels    = []string{"simulation", "project"}
+	metricLabels = []string{"simulation", "project", "metric"}
+
+	ticksDesc = prometheus.NewDesc("agent_service_simulation_ticks_total",
+		"Ticks avançados por esta réplica, por simulação.", simLabels, nil)
+	tickSecondsDesc = prometheus.NewDesc("agent_service_simulation_tick_duration_seconds_total",
+		"Tempo gasto nos ticks (entrega das mensagens e comportamentos), por simulação.", simLabels, nil)
+	lastTickDesc = prometheus.NewDesc("agent_service_simulation_last_tick_duration_seconds",
+		"Duração do último tick da simulação.", simLabels, nil)
+	tickDesc = prometheus.NewDesc("agent_service_simulation_tick",
+		"Tick atual da simulação.", simLabels, nil)
+	eventsDesc = prometheus.NewDesc("agent_service_simulation_events_total",
+		"Eventos publicados por esta réplica, por simulação.", simLabels, nil)
+	agentsActiveDesc = prometheus.NewDesc("agent_service_simulation_agents_active",
+		"Agentes da simulação em um dos status ativos.", simLabels, nil)
+	pendingDesc = prometheus.NewDesc("agent_service_simulation_pending_messages",
+		"Mensagens entre agentes à espera do próximo tick.", simLabels, nil)
+	// A soma não é um counter: as amostras podem ser negativas. A média no
+	// intervalo é rate(..._sum) / rate(..._samples_total).
+	metricSumDesc = prometheus.NewDesc("agent_service_simulation_agent_metric_sum",
+		"Soma das amostras da métrica de agentes, por simulação.", metricLabels, nil)
+	metricCountDesc = prometheus.NewDesc("agent_service_simulation_agent_metric_samples_total",
+		"Amostras recebidas da métrica de agentes, por simulação.", metricLabels, nil)
+	warmupSumDesc = prometheus.NewDesc("agent_service_simulation_agent_metric_warmup_sum",
+		"Soma das amostras da métrica de agentes recebidas no aquecimento, por simulação.", metricLabels, nil)
+	warmupCountDesc = prometheus.NewDesc("agent_service_simulation_agent_metric_warmup_samples_total",
+		"Amostras da métrica de agentes recebidas no aquecimento, por simulação.", metricLabels, nil)
+	simulationsDesc = prometheus.NewDesc("agent_service_simulation_metrics_simulations",
+		"Simulações acompanhadas, com séries próprias (exported) ou somadas em _other (aggregated).", []string{"bucket"}, nil)
+)
+
+// AgentCounter é o subconjunto de agent.Service usado para contar os
+// agentes ativos.
+type AgentCounter interface {
+	ListAgents(ctx context.Context, f agent.Filter) ([]agent.Agent, int, error)
+}
+
+// PendingCounter dá as mensagens à espera do tick (agentmsg.Bus).
+type PendingCounter interface {
+	Pending(ctx context.Context, simulationID string) (int64, error)
+}
+
+// Warmup diz se a simulação ainda está no aquecimento (internal/warmup).
+type Warmup interface {
+	InWarmup(ctx context.Context, simulationID string) bool
+}
+
+// Config configura o exportador.
+type Config struct {
+	MaxSimulations  int
+	RefreshInterval time.Duration
+	// ActiveStatuses são os status contados em agents_active.
+	ActiveStatuses []string
+	// AgentMetrics são as métricas de agentes agregadas por simulação.
+	AgentMetrics []string
+}
+
+// counters são os contadores de uma simulação ou do balde OtherLabel.
+type counters struct {
+	ticks       float64
+	tickSeconds float64
+	events      float64
+	metricSum   map[string]float64
+	metricCount map[string]float64
+	warmupSum   map[string]float64
+	warmupCount map[string]float64
+}
+
+// simulation é o estado exportado de uma simulação.
+type simulation struct {
+	counters
+	project  string
+	seen     time.Time
+	tickedAt time.Time
+	tick     int64
+	lastTick time.Duration
+	// gauges indica que agentsActive e pending foram lidos desde o último
+	// tick desta réplica; a réplica que não avança o relógio não os exporta.
+	gauges       bool
+	agentsActive float64
+	pending      float64
+}
+
+// Exporter acompanha as simulações e implementa prometheus.Collector.
+type Exporter struct {
+	agents  AgentCounter
+	pending PendingCounter
+	cfg     Config
+	metrics map[string]bool
+	warmup  Warmup
+
+	mu       sync.Mutex
+	sims     map[string]*simulation
+	other    counters
+	overflow map[string]time.Time
+}
+
+// NewExporter cria o exportador; os gauges são lidos em Run.
+func NewExporter(agents AgentCounter, pending PendingCounter, cfg Config) *Exporter {
+	metrics := make(map[string]bool, len(cfg.AgentMetrics))
+	for _, name := range cfg.AgentMetrics {
+		metrics[name] = true
+	}
+	return &Exporter{
+		agents:   agents,
+		pending:  pending,
+		cfg:      cfg,
+		metrics:  metrics,
+		sims:     map[string]*simulation{},
+		other:    newCounters(),
+		overflow: map[string]time.Time{},
+	}
+}
+
+func newCounters() counters {
+	return counters{
+		metricSum:   map[string]float64{},
+		metricCount: map[string]float64{},
+		warmupSum:   map[string]float64{},
+		warmupCount: map[string]float64{},
+	}
+}
+
+// SetWarmup separa as amostras do aquecimento das simulações. Deve ser
+// chamado antes de registrar o exportador como observador.
+func (e *Exporter) SetWarmup(w Warmup) {
+	e.warmup = w
+}
+
+// track retorna o estado da simulação, ou nil se ela foi para o balde
+// OtherLabel, e marca a atividade. Chamado com mu travado.
+func (e *Exporter) track(id, project string, now time.Time) *simulation {
+	if s, ok := e.sims[id]; ok {
+		s.seen = now
+		if s.project == "" {
+			s.project = project
+		}
+		return s
+	}
+	if _, ok := e.overflow[id]; ok || len(e.sims) >= e.cfg.MaxSimulations {
+		e.overflow[id] = now
+		return nil
+	}
+	s := &simulation{counters: newCounters(), project: project, seen: now}
+	e.sims[id] = s
+	return s
+}
+
+// ObserveTick implementa agentmsg.TickObserver.
+func (e *Exporter) ObserveTick(simulationID, projectID string, tick int64, took time.Duration) {
+	now := time.Now()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	s := e.track(simulationID, projectID, now)
+	if s == nil {
+		e.other.ticks++
+		e.other.tickSeconds += took.Seconds()
+		return
+	}
+	s.ticks++
+	s.tickSeconds += took.Seconds()
+	s.tick, s.lastTick, s.tickedAt = tick, took, now
+}
+
+// Handle conta os eventos por simulação e deixa de exportar as simulações
+// que terminam.
+func (e *Exporter) Handle(_ context.Context, ev events.Event) {
+	id := ev.SimulationID()
+	if id == "" {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if s := e.track(id, ev.ProjectID(), time.Now()); s != nil {
+		s.events++
+	} else {
+		e.other.events++
+	}
+	if ev.Topic == events.TopicSimulations {
+		switch ev.Type {
+		case "simulation.stopped", "simulation.completed", "simulation.failed", "simulation.auto_stopped":
+			delete(e.sims, id)
+			delete(e.overflow, id)
+		}
+	}
+}
+
+// Observe implementa agentmetric.Observer para as métricas de
+// Config.AgentMetrics.
+func (e *Exporter) Observe(ctx context.Context, ag *agent.Agent, samples []agentmetric.Sample) {
+	if ag.SimulationID == "" || len(e.metrics) == 0 {
+		return
+	}
+	warmup := false
+	if e.warmup != nil {
+		for _, sample := range samples {
+			if e.metrics[sample.Name] {
+				warmup = e.warmup.InWarmup(ctx, ag.SimulationID)
+				break
+			}
+		}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var c *counters
+	for _, sample := range samples {
+		if !e.metrics[sample.Name] {
+			continue
+		}
+		if c == nil {
+			c = &e.other
+			if s := e.track(ag.SimulationID, ag.ProjectID, time.Now()); s != nil {
+				c = &s.counters
+			}
+		}
+		if warmup {
+			c.warmupSum[sample.Name] += sample.Value
+			c.warmupCount[sample.Name]++
+			continue
+		}
+		c.metricSum[sample.Name] += sample.Value
+		c.metricCount[sample.Name]++
+	}
+}
+
+// Run lê os gauges a cada intervalo até ctx ser cancelado.
+func (e *Exporter) Run(ctx context.Context) error {
+	work := logging.Background(context.WithoutCancel(ctx), "simulation-metrics")
+	ticker := time.NewTicker(e.cfg.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			e.refresh(work)
+		}
+	}
+}
+
+// refresh descarta as simulações sem atividade e lê os gauges das que esta
+// réplica avançou desde o último ciclo.
+func (e *Exporter) refresh(ctx context.Context) {
+	now := time.Now()
+	e.mu.Lock()
+	var ids []string
+	for id, s := range e.sims {
+		switch {
+		case now.Sub(s.seen) >= idleAfter:
+			delete(e.sims, id)
+		case now.Sub(s.tickedAt) < 2*e.cfg.RefreshInterval:
+			ids = append(ids, id)
+		default:
+			s.gauges = false
+		}
+	}
+	for id, seen := range e.overflow {
+		if now.Sub(seen) >= idleAfter {
+			delete(e.overflow, id)
+		}
+	}
+	e.mu.Unlock()
+
+	sort.Strings(ids)
+	log := logging.FromContext(ctx)
+	for _, id := range ids {
+		pending, err := e.pending.Pending(ctx, id)
+		if err != nil {
+			log.WithError(err).WithField("simulation_id", id).Warn("Falha ao ler as mensagens à espera da simulação")
+			continue
+		}
+		var active int
+		for _, status := range e.cfg.ActiveStatuses {
+			_, n, err := e.agents.ListAgents(ctx, agent.Filter{SimulationID: id, Status: status, Page: 1, PageSize: 1})
+			if err != nil {
+				log.WithError(err).WithField("simulation_id", id).Warn("Falha ao contar os agentes ativos da simulação")
+				active = -1
+				break
+			}
+			active += n
+		}
+		if active < 0 {
+			continue
+		}
+		e.mu.Lock()
+		if s, ok := e.sims[id]; ok {
+			s.pending, s.agentsActive, s.gauges = float64(pending), float64(active), true
+		}
+		e.mu.Unlock()
+	}
+}
+
+// Describe implementa prometheus.Collector.
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{
+		ticksDesc, tickSecondsDesc, lastTickDesc, tickDesc, eventsDesc,
+		agentsActiveDesc, pendingDesc, metricSumDesc, metricCountDesc, warmupSumDesc, warmupCountDesc, simulationsDesc,
+	} {
+		ch <- d
+	}
+}
+
+// Collect implementa prometheus.Collector.
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for id, s := range e.sims {
+		project := s.project
+		e.collectCounters(ch, &s.counters, id, project)
+		if !s.tickedAt.IsZero() {
+			ch <- prometheus.MustNewConstMetric(tickDesc, prometheus.GaugeValue, float64(s.tick), id, project)
+			ch <- prometheus.MustNewConstMetric(lastTickDesc, prometheus.GaugeValue, s.lastTick.Seconds(), id, project)
+		}
+		if s.gauges {
+			ch <- prometheus.MustNewConstMetric(agentsActiveDesc, prometheus.GaugeValue, s.agentsActive, id, project)
+			ch <- prometheus.MustNewConstMetric(pendingDesc, prometheus.GaugeValue, s.pending, id, project)
+		}
+	}
+	if len(e.overflow) > 0 {
+		e.collectCounters(ch, &e.other, OtherLabel, OtherLabel)
+	}
+	ch <- prometheus.MustNewConstMetric(simulationsDesc, prometheus.GaugeValue, float64(len(e.sims)), "exported")
+	ch <- prometheus.MustNewConstMetric(simulationsDesc, prometheus.GaugeValue, float64(len(e.overflow)), "aggregated")
+}
+
+func (e *Exporter) collectCounters(ch chan<- prometheus.Metric, c *counters, id, project string) {
+	ch <- prometheus.MustNewConstMetric(ticksDesc, prometheus.CounterValue, c.ticks, id, project)
+	ch <- prometheus.MustNewConstMetric(tickSecondsDesc, prometheus.CounterValue, c.tickSeconds, id, project)
+	ch <- prometheus.MustNewConstMetric(eventsDesc, prometheus.CounterValue, c.events, id, project)
+	for name, sum := range c.metricSum {
+		ch <- prometheus.MustNewConstMetric(metricSumDesc, prometheus.UntypedValue, sum, id, project, name)
+		ch <- prometheus.MustNewConstMetric(metricCountDesc, prometheus.CounterValue, c.metricCount[name], id, project, name)
+	}
+	for name, sum := range c.warmupSum {
+		ch <- prometheus.MustNewConstMetric(warmupSumDesc, prometheus.UntypedValue, sum, id, project, name)
+		ch <- prometheus.MustNewConstMetric(warmupCountDesc, prometheus.CounterValue, c.warmupCount[name], id, project, name)
+	}
+}
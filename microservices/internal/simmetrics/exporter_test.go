@@ -0,0 +1,239 @@
+package simmetrics
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/agentmetric"
+	"smart-city-microservices/internal/events"
+)
+
+var update = flag.Bool("update", false, "regrava testdata/metrics.txt")
+
+// agentCounter conta os agentes por simulação e status.
+type agentCounter map[string]map[string]int
+
+func (c agentCounter) ListAgents(_ context.Context, f agent.Filter) ([]agent.Agent, int, error) {
+	if f.SimulationID == "sim-broken" {
+		return nil, 0, errors.New("banco indisponível")
+	}
+	return nil, c[f.SimulationID][f.Status], nil
+}
+
+// pendingCounter dá as mensagens à espera por simulação.
+type pendingCounter map[string]int64
+
+func (c pendingCounter) Pending(_ context.Context, simulationID string) (int64, error) {
+	return c[simulationID], nil
+}
+
+// warmupSims diz que as simulações do conjunto estão no aquecimento.
+type warmupSims map[string]bool
+
+func (w warmupSims) InWarmup(_ context.Context, simulationID string) bool {
+	return w[simulationID]
+}
+
+func newExporter(maxSimulations int) *Exporter {
+	e := NewExporter(
+		agentCounter{"sim-a": {"active": 3, "moving": 2}, "sim-b": {"active": 1}},
+		pendingCounter{"sim-a": 7, "sim-b": 0},
+		Config{
+			MaxSimulations:  maxSimulations,
+			RefreshInterval: time.Hour,
+			ActiveStatuses:  []string{"active", "moving"},
+			AgentMetrics:    []string{"speed_kmh", "occupancy"},
+		},
+	)
+	e.SetWarmup(warmupSims{"sim-b": true})
+	return e
+}
+
+func simulationEvent(typ, id, project string) events.Event {
+	return events.Event{Type: typ, Topic: events.TopicSimulations, Data: map[string]interface{}{"id": id, "project_id": project}}
+}
+
+func samples(values map[string]float64) []agentmetric.Sample {
+	var out []agentmetric.Sample
+	for name, v := range values {
+		out = append(out, agentmetric.Sample{Name: name, Value: v})
+	}
+	return out
+}
+
+// series são as séries coletadas do exportador, uma por linha no formato
+// nome{rótulos} tipo valor, em ordem.
+func series(t *testing.T, e *Exporter) []string {
+	t.Helper()
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(e)
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			var labels []string
+			for _, l := range m.GetLabel() {
+				labels = append(labels, fmt.Sprintf("%s=%q", l.GetName(), l.GetValue()))
+			}
+			var value float64
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				value = m.GetCounter().GetValue()
+			case dto.MetricType_GAUGE:
+				value = m.GetGauge().GetValue()
+			case dto.MetricType_UNTYPED:
+				value = m.GetUntyped().GetValue()
+			}
+			lines = append(lines, fmt.Sprintf("%s{%s} %s %g", mf.GetName(), strings.Join(labels, ","), strings.ToLower(mf.GetType().String()), value))
+		}
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// TestMetricsSnapshot falha quando os nomes, tipos ou rótulos das séries
+// mudam sem que testdata/metrics.txt seja regravado (go test
+// ./internal/simmetrics -update). As séries são a interface com os painéis.
+func TestMetricsSnapshot(t *testing.T) {
+	e := newExporter(2)
+	ctx := context.Background()
+	e.ObserveTick("sim-a", "proj-1", 41, 20*time.Millisecond)
+	e.ObserveTick("sim-a", "proj-1", 42, 30*time.Millisecond)
+	e.Handle(ctx, simulationEvent("simulation.started", "sim-a", "proj-1"))
+	e.Observe(ctx, &agent.Agent{SimulationID: "sim-a", ProjectID: "proj-1"}, samples(map[string]float64{"speed_kmh": 40, "battery": 0.5}))
+	e.Observe(ctx, &agent.Agent{SimulationID: "sim-a", ProjectID: "proj-1"}, samples(map[string]float64{"speed_kmh": 20}))
+	e.ObserveTick("sim-b", "proj-2", 1, 10*time.Millisecond)
+	e.Observe(ctx, &agent.Agent{SimulationID: "sim-b", ProjectID: "proj-2"}, samples(map[string]float64{"occupancy": 12}))
+	// Além de MaxSimulations: vai para _other.
+	e.ObserveTick("sim-c", "proj-3", 9, 50*time.Millisecond)
+	e.Handle(ctx, simulationEvent("simulation.started", "sim-c", "proj-3"))
+	e.Observe(ctx, &agent.Agent{SimulationID: "sim-c", ProjectID: "proj-3"}, samples(map[string]float64{"occupancy": 3}))
+	e.refresh(ctx)
+
+	got := []byte(strings.Join(series(t, e), "\n") + "\n")
+	path := filepath.Join("testdata", "metrics.txt")
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("séries diferem de testdata/metrics.txt; rode go test ./internal/simmetrics -update e revise o diff\n%s", got)
+	}
+}
+
+func TestExporter(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		run     func(e *Exporter)
+		want    []string
+		notWant []string
+	}{
+		{
+			name: "overflow aggregated in other",
+			run: func(e *Exporter) {
+				e.ObserveTick("sim-a", "proj-1", 1, time.Second)
+				e.ObserveTick("sim-b", "proj-2", 1, time.Second)
+				e.ObserveTick("sim-c", "proj-3", 1, time.Second)
+			},
+			want: []string{
+				`agent_service_simulation_ticks_total{project="_other",simulation="_other"} counter 2`,
+				`agent_service_simulation_metrics_simulations{bucket="aggregated"} gauge 2`,
+				`agent_service_simulation_metrics_simulations{bucket="exported"} gauge 1`,
+			},
+			notWant: []string{`simulation="sim-b"`, `simulation="sim-c"`},
+		},
+		{
+			name: "stopped simulation frees its slot",
+			run: func(e *Exporter) {
+				e.ObserveTick("sim-a", "proj-1", 1, time.Second)
+				e.Handle(ctx, simulationEvent("simulation.stopped", "sim-a", "proj-1"))
+				e.ObserveTick("sim-b", "proj-2", 1, time.Second)
+			},
+			want: []string{
+				`agent_service_simulation_ticks_total{project="proj-2",simulation="sim-b"} counter 1`,
+				`agent_service_simulation_metrics_simulations{bucket="aggregated"} gauge 0`,
+			},
+			notWant: []string{`simulation="sim-a"`, `simulation="_other"`},
+		},
+		{
+			name: "warmup samples kept apart",
+			run: func(e *Exporter) {
+				e.Observe(ctx, &agent.Agent{SimulationID: "sim-b", ProjectID: "proj-2"}, samples(map[string]float64{"occupancy": 5}))
+			},
+			want: []string{
+				`agent_service_simulation_agent_metric_warmup_sum{metric="occupancy",project="proj-2",simulation="sim-b"} untyped 5`,
+				`agent_service_simulation_agent_metric_warmup_samples_total{metric="occupancy",project="proj-2",simulation="sim-b"} counter 1`,
+			},
+			notWant: []string{"agent_service_simulation_agent_metric_sum{"},
+		},
+		{
+			name: "gauges only after a tick",
+			run: func(e *Exporter) {
+				e.Handle(ctx, simulationEvent("simulation.started", "sim-a", "proj-1"))
+				e.refresh(ctx)
+			},
+			want:    []string{`agent_service_simulation_events_total{project="proj-1",simulation="sim-a"} counter 1`},
+			notWant: []string{"agents_active", "pending_messages", "agent_service_simulation_tick{"},
+		},
+		{
+			name: "gauges after refresh",
+			run: func(e *Exporter) {
+				e.ObserveTick("sim-a", "proj-1", 3, time.Second)
+				e.refresh(ctx)
+			},
+			want: []string{
+				`agent_service_simulation_agents_active{project="proj-1",simulation="sim-a"} gauge 5`,
+				`agent_service_simulation_pending_messages{project="proj-1",simulation="sim-a"} gauge 7`,
+				`agent_service_simulation_tick{project="proj-1",simulation="sim-a"} gauge 3`,
+			},
+		},
+		{
+			name: "gauges skipped when counting fails",
+			run: func(e *Exporter) {
+				e.ObserveTick("sim-broken", "proj-1", 3, time.Second)
+				e.refresh(ctx)
+			},
+			want:    []string{`agent_service_simulation_tick{project="proj-1",simulation="sim-broken"} gauge 3`},
+			notWant: []string{"agents_active"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newExporter(1)
+			tt.run(e)
+			got := strings.Join(series(t, e), "\n")
+			for _, w := range tt.want {
+				if !strings.Contains(got, w) {
+					t.Errorf("falta %s em\n%s", w, got)
+				}
+			}
+			for _, w := range tt.notWant {
+				if strings.Contains(got, w) {
+					t.Errorf("%s não deveria aparecer em\n%s", w, got)
+				}
+			}
+		})
+	}
+}
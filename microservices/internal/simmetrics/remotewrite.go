@@ -0,0 +1,199 @@
+package simmetrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"smart-city-microservices/internal/logging"
+)
+
+var pushes = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "agent_service",
+	Name:      "simulation_metrics_remote_write_total",
+	Help:      "Envios de remote write das métricas de simulação, por resultado (success, failure).",
+}, []string{"result"})
+
+// RemoteWriteConfig configura o envio das séries por Prometheus remote
+// write (protocolo 1.0), para ambientes onde os pods não são raspados.
+type RemoteWriteConfig struct {
+	URL      string
+	Interval time.Duration
+	// Username e Password usam basic auth; BearerToken, Authorization:
+	// Bearer. Vazios, o envio vai sem credenciais.
+	Username    string
+	Password    string
+	BearerToken string
+	// Labels são acrescentados a todas as séries (ex.: instance, cluster).
+	Labels map[string]string
+}
+
+// ParseLabels lê os rótulos no formato nome=valor.
+func ParseLabels(list []string) (map[string]string, error) {
+	labels := make(map[string]string, len(list))
+	for _, item := range list {
+		name, value, ok := strings.Cut(item, "=")
+		if !ok || !validLabelName(name) {
+			return nil, fmt.Errorf("rótulo inválido %q (use nome=valor)", item)
+		}
+		labels[name] = value
+	}
+	return labels, nil
+}
+
+// validLabelName indica se name é um nome de rótulo do Prometheus que não
+// é reservado (__*).
+func validLabelName(name string) bool {
+	if name == "" || strings.HasPrefix(name, "__") {
+		return false
+	}
+	for i, r := range name {
+		if r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || i > 0 && r >= '0' && r <= '9' {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// Pusher envia periodicamente as séries de um Gatherer.
+type Pusher struct {
+	gatherer prometheus.Gatherer
+	client   *http.Client
+	cfg      RemoteWriteConfig
+}
+
+// NewPusher cria o envio das séries de gatherer; os envios rodam em Run.
+func NewPusher(gatherer prometheus.Gatherer, client *http.Client, cfg RemoteWriteConfig) *Pusher {
+	return &Pusher{gatherer: gatherer, client: client, cfg: cfg}
+}
+
+// Run envia as séries a cada intervalo até ctx ser cancelado. Um envio que
+// falha não é repetido: o seguinte leva os valores atuais dos contadores.
+func (p *Pusher) Run(ctx context.Context) error {
+	work := logging.Background(context.WithoutCancel(ctx), "simulation-metrics-remote-write")
+	ticker := time.NewTicker(p.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := p.push(work); err != nil {
+				pushes.WithLabelValues("failure").Inc()
+				logging.FromContext(work).WithError(err).Warn("Falha no remote write das métricas de simulação")
+				continue
+			}
+			pushes.WithLabelValues("success").Inc()
+		}
+	}
+}
+
+func (p *Pusher) push(ctx context.Context) error {
+	families, err := p.gatherer.Gather()
+	if err != nil {
+		return err
+	}
+	body := snappy.Encode(nil, encodeWriteRequest(families, p.cfg.Labels, time.Now()))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	switch {
+	case p.cfg.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+p.cfg.BearerToken)
+	case p.cfg.Username != "":
+		req.SetBasicAuth(p.cfg.Username, p.cfg.Password)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("remote write: status %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// encodeWriteRequest codifica as famílias como prometheus.WriteRequest:
+//
+//	WriteRequest { repeated TimeSeries timeseries = 1; }
+//	TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	Label        { string name = 1; string value = 2; }
+//	Sample       { double value = 1; int64 timestamp = 2; }
+//
+// Só counters, gauges e untyped são exportados pelo Exporter; as demais
+// famílias são ignoradas.
+func encodeWriteRequest(families []*dto.MetricFamily, extra map[string]string, now time.Time) []byte {
+	ts := now.UnixMilli()
+	var out []byte
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			var value float64
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				value = m.GetCounter().GetValue()
+			case dto.MetricType_GAUGE:
+				value = m.GetGauge().GetValue()
+			case dto.MetricType_UNTYPED:
+				value = m.GetUntyped().GetValue()
+			default:
+				continue
+			}
+			labels := map[string]string{"__name__": mf.GetName()}
+			for name, v := range extra {
+				labels[name] = v
+			}
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			out = protowire.AppendTag(out, 1, protowire.BytesType)
+			out = protowire.AppendBytes(out, encodeSeries(labels, value, ts))
+		}
+	}
+	return out
+}
+
+// encodeSeries codifica uma TimeSeries com os rótulos em ordem, como o
+// remote write exige, e uma amostra.
+func encodeSeries(labels map[string]string, value float64, ts int64) []byte {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var series []byte
+	for _, name := range names {
+		var label []byte
+		label = protowire.AppendTag(label, 1, protowire.BytesType)
+		label = protowire.AppendString(label, name)
+		label = protowire.AppendTag(label, 2, protowire.BytesType)
+		label = protowire.AppendString(label, labels[name])
+		series = protowire.AppendTag(series, 1, protowire.BytesType)
+		series = protowire.AppendBytes(series, label)
+	}
+	var sample []byte
+	sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+	sample = protowire.AppendFixed64(sample, math.Float64bits(value))
+	sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+	sample = protowire.AppendVarint(sample, uint64(ts))
+	series = protowire.AppendTag(series, 2, protowire.BytesType)
+	return protowire.AppendBytes(series, sample)
+}
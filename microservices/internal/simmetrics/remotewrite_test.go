@@ -0,0 +1,194 @@
+package simmetrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestParseLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		list    []string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "labels", list: []string{"cluster=sp-1", "instance=pod-2"}, want: map[string]string{"cluster": "sp-1", "instance": "pod-2"}},
+		{name: "empty value", list: []string{"env="}, want: map[string]string{"env": ""}},
+		{name: "without equals", list: []string{"cluster"}, wantErr: true},
+		{name: "reserved", list: []string{"__name__=x"}, wantErr: true},
+		{name: "starts with digit", list: []string{"1a=x"}, wantErr: true},
+		{name: "invalid char", list: []string{"a-b=x"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLabels(tt.list)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("erro %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("labels = %v, want %v", got, tt.want)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("%s = %q, want %q", k, got[k], v)
+				}
+			}
+		})
+	}
+}
+
+// decodeWriteRequest lê um WriteRequest como linhas rótulo=valor,...
+// valor@timestamp, na ordem dos rótulos de cada série.
+func decodeWriteRequest(t *testing.T, b []byte) []string {
+	t.Helper()
+	fields := func(b []byte, each func(num protowire.Number, typ protowire.Type, b []byte) int) {
+		for len(b) > 0 {
+			num, typ, n := protowire.ConsumeTag(b)
+			if n < 0 {
+				t.Fatal(protowire.ParseError(n))
+			}
+			b = b[n:]
+			n = each(num, typ, b)
+			if n < 0 {
+				t.Fatal(protowire.ParseError(n))
+			}
+			b = b[n:]
+		}
+	}
+	var out []string
+	fields(b, func(_ protowire.Number, _ protowire.Type, b []byte) int {
+		series, n := protowire.ConsumeBytes(b)
+		var labels []string
+		var sample string
+		fields(series, func(num protowire.Number, _ protowire.Type, b []byte) int {
+			msg, n := protowire.ConsumeBytes(b)
+			var parts []string
+			var value float64
+			var ts uint64
+			fields(msg, func(num protowire.Number, typ protowire.Type, b []byte) int {
+				switch typ {
+				case protowire.BytesType:
+					s, n := protowire.ConsumeString(b)
+					parts = append(parts, s)
+					return n
+				case protowire.Fixed64Type:
+					v, n := protowire.ConsumeFixed64(b)
+					value = math.Float64frombits(v)
+					return n
+				default:
+					v, n := protowire.ConsumeVarint(b)
+					ts = v
+					return n
+				}
+			})
+			if num == 1 {
+				labels = append(labels, strings.Join(parts, "="))
+			} else {
+				sample = fmt.Sprintf("%g@%d", value, ts)
+			}
+			return n
+		})
+		out = append(out, strings.Join(labels, ",")+" "+sample)
+		return n
+	})
+	sort.Strings(out)
+	return out
+}
+
+func TestEncodeWriteRequest(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "ticks_total", Help: "h"}, []string{"simulation"})
+	counter.WithLabelValues("sim-a").Add(3)
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "tick", Help: "h"})
+	gauge.Set(42)
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "ignored_seconds", Help: "h"})
+	histogram.Observe(1)
+	reg.MustRegister(counter, gauge, histogram)
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.UnixMilli(1_700_000_000_123)
+	got := decodeWriteRequest(t, encodeWriteRequest(families, map[string]string{"cluster": "sp-1"}, now))
+	want := []string{
+		"__name__=tick,cluster=sp-1 42@1700000000123",
+		"__name__=ticks_total,cluster=sp-1,simulation=sim-a 3@1700000000123",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("séries:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestPush(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     RemoteWriteConfig
+		status  int
+		auth    string
+		wantErr bool
+	}{
+		{name: "bearer", cfg: RemoteWriteConfig{BearerToken: "t0k"}, status: http.StatusNoContent, auth: "Bearer t0k"},
+		{name: "basic", cfg: RemoteWriteConfig{Username: "ana", Password: "s3nha"}, status: http.StatusOK, auth: "Basic YW5hOnMzbmhh"},
+		{name: "no credentials", status: http.StatusOK},
+		{name: "rejected", status: http.StatusBadRequest, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *http.Request
+			var body []byte
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r
+				body, _ = io.ReadAll(r.Body)
+				w.WriteHeader(tt.status)
+				io.WriteString(w, "out of order sample\n")
+			}))
+			defer srv.Close()
+
+			reg := prometheus.NewRegistry()
+			gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "tick", Help: "h"})
+			gauge.Set(7)
+			reg.MustRegister(gauge)
+			cfg := tt.cfg
+			cfg.URL = srv.URL
+			err := NewPusher(reg, srv.Client(), cfg).push(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("erro %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if !strings.Contains(err.Error(), "out of order sample") {
+					t.Errorf("erro %q sem a mensagem do servidor", err)
+				}
+				return
+			}
+			for header, want := range map[string]string{
+				"Content-Type":                      "application/x-protobuf",
+				"Content-Encoding":                  "snappy",
+				"X-Prometheus-Remote-Write-Version": "0.1.0",
+				"Authorization":                     tt.auth,
+			} {
+				if v := got.Header.Get(header); v != want {
+					t.Errorf("%s = %q, want %q", header, v, want)
+				}
+			}
+			decoded, err := snappy.Decode(nil, body)
+			if err != nil {
+				t.Fatalf("corpo não é snappy: %v", err)
+			}
+			if series := decodeWriteRequest(t, decoded); len(series) != 1 || !strings.HasPrefix(series[0], "__name__=tick 7@") {
+				t.Errorf("séries = %v", series)
+			}
+		})
+	}
+}
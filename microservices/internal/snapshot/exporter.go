@@ -0,0 +1,378 @@
+package snapshot
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+
+	"smart-city-microservices/internal/instrument"
+	"smart-city-microservices/internal/lease"
+	"smart-city-microservices/internal/storage"
+	"smart-city-microservices/internal/supervisor"
+)
+
+// Quiescer pausa os ticks de uma simulação; *agentmsg.Clock o implementa.
+type Quiescer interface {
+	Quiesce(ctx context.Context, simulationID string, timeout time.Duration) (resume func(), err error)
+}
+
+// Ticks lê o estado da simulação no barramento; *agentmsg.Bus o implementa.
+type Ticks interface {
+	CurrentTick(ctx context.Context, simulationID string) (int64, error)
+	Pending(ctx context.Context, simulationID string) (int64, error)
+}
+
+// Flusher grava o estado ao vivo pendente dos agentes da simulação;
+// *coalesce.Coalescer o implementa.
+type Flusher interface {
+	FlushSimulation(ctx context.Context, simulationID string) error
+}
+
+// SizeLimiter dá o tamanho máximo, em bytes, das exportações de um
+// projeto, 0 sem limite; *projectsettings.Settings o implementa.
+type SizeLimiter interface {
+	ExportMaxBytes(projectID string) int64
+}
+
+// Config configura as exportações.
+type Config struct {
+	// QuiesceTimeout é a espera máxima pela pausa dos ticks e o tempo que
+	// ela dura no pior caso.
+	QuiesceTimeout time.Duration
+	// Timeout limita uma exportação inteira.
+	Timeout time.Duration
+	// LinkExpiry é a validade do link de download.
+	LinkExpiry time.Duration
+}
+
+// Exportações em andamento: a trava de cada simulação guarda o id da
+// exportação e o resultado fica um minuto em result, para as réplicas que
+// esperavam por ela.
+const (
+	lockPrefix   = "agent-service:exports:simulation:"
+	resultPrefix = "agent-service:exports:result:"
+	resultTTL    = time.Minute
+)
+
+// Exporter exporta simulações. Pedidos simultâneos da mesma simulação,
+// nesta réplica ou em outras, esperam a exportação em andamento e
+// recebem o mesmo arquivo.
+type Exporter struct {
+	db      *instrument.DB
+	store   storage.Store
+	clock   Quiescer
+	ticks   Ticks
+	flusher Flusher
+	redis   redis.UniversalClient
+	cfg     Config
+	limits  SizeLimiter
+
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// call é uma exportação em andamento nesta réplica.
+type call struct {
+	done   chan struct{}
+	export *Export
+	err    error
+}
+
+// New cria o exportador. flusher pode ser nil, quando o estado ao vivo vai
+// direto para o banco.
+func New(db *sql.DB, store storage.Store, clock Quiescer, ticks Ticks, flusher Flusher, client redis.UniversalClient, cfg Config) *Exporter {
+	return &Exporter{
+		db:      instrument.NewDB(db),
+		store:   store,
+		clock:   clock,
+		ticks:   ticks,
+		flusher: flusher,
+		redis:   client,
+		cfg:     cfg,
+		calls:   map[string]*call{},
+	}
+}
+
+// LimitSize passa a recusar as exportações maiores que o limite do
+// projeto; deve ser chamado antes da primeira exportação.
+func (e *Exporter) LimitSize(l SizeLimiter) {
+	e.limits = l
+}
+
+// Export exporta a simulação, ou espera a exportação dela já em andamento.
+// A exportação segue mesmo que ctx seja cancelado, para quem espera por
+// ela; só a espera deste chamador termina.
+func (e *Exporter) Export(ctx context.Context, simulationID string) (*Export, error) {
+	e.mu.Lock()
+	c, ok := e.calls[simulationID]
+	if !ok {
+		c = &call{done: make(chan struct{})}
+		e.calls[simulationID] = c
+		go func() {
+			work, cancel := context.WithTimeout(context.WithoutCancel(ctx), e.cfg.Timeout)
+			defer cancel()
+			c.export, c.err = e.coordinate(work, simulationID)
+			e.mu.Lock()
+			delete(e.calls, simulationID)
+			e.mu.Unlock()
+			close(c.done)
+		}()
+	}
+	e.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.done:
+	}
+	if c.err != nil {
+		return nil, c.err
+	}
+	out := *c.export
+	out.Coalesced = out.Coalesced || ok
+	return &out, nil
+}
+
+// coordinate disputa a trava da simulação entre as réplicas: quem a obtém
+// exporta; as outras esperam o resultado e, se a trava some sem ele (a
+// exportação falhou ou a réplica caiu), disputam de novo.
+func (e *Exporter) coordinate(ctx context.Context, simulationID string) (*Export, error) {
+	for {
+		id := uuid.NewString()
+		lock := lease.New(e.redis, lockPrefix+simulationID, id)
+		ok, err := lock.Claim(ctx, e.cfg.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return e.lead(ctx, simulationID, id, lock)
+		}
+		x, err := e.await(ctx, simulationID)
+		if err != nil || x != nil {
+			return x, err
+		}
+	}
+}
+
+// lead faz a exportação id, publica o resultado e solta a trava, se
+// ainda for dela.
+func (e *Exporter) lead(ctx context.Context, simulationID, id string, lock *lease.Lease) (*Export, error) {
+	defer func() {
+		ctx, cancel := supervisor.Cleanup(ctx)
+		defer cancel()
+		lock.Release(ctx)
+	}()
+	x, err := e.export(ctx, simulationID, id)
+	if err != nil {
+		return nil, err
+	}
+	if raw, err := json.Marshal(x); err == nil {
+		e.redis.Set(ctx, resultPrefix+id, raw, resultTTL)
+	}
+	return x, nil
+}
+
+// await espera a exportação de outra réplica. Retorna nil, nil se a trava
+// sumiu sem resultado.
+func (e *Exporter) await(ctx context.Context, simulationID string) (*Export, error) {
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		id, err := e.redis.Get(ctx, lockPrefix+simulationID).Result()
+		if errors.Is(err, redis.Nil) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		raw, err := e.redis.Get(ctx, resultPrefix+id).Bytes()
+		if err == nil {
+			var x Export
+			if err := json.Unmarshal(raw, &x); err != nil {
+				return nil, err
+			}
+			x.Coalesced = true
+			return &x, nil
+		}
+		if !errors.Is(err, redis.Nil) {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// export fixa o retrato da simulação e grava o zip dele.
+func (e *Exporter) export(ctx context.Context, simulationID, id string) (*Export, error) {
+	m, tx, err := e.capture(ctx, simulationID)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	m.ExportID = id
+
+	key := Key(simulationID, id)
+	sum := sha256.New()
+	var size counter
+	if e.limits != nil {
+		size.max = e.limits.ExportMaxBytes(m.ProjectID)
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(e.write(instrument.WithTx(ctx, tx), io.MultiWriter(&size, pw, sum), m))
+	}()
+	if err := e.store.Put(ctx, key, pr, -1, "application/zip"); err != nil {
+		pr.CloseWithError(err)
+		if size.exceeded() {
+			e.discard(ctx, key)
+			return nil, &TooLargeError{Limit: size.max}
+		}
+		return nil, fmt.Errorf("snapshot: falha ao gravar %s: %w", key, err)
+	}
+	m.SHA256 = hex.EncodeToString(sum.Sum(nil))
+	m.Size = size.n
+
+	url, err := e.store.Presign(ctx, key, e.cfg.LinkExpiry)
+	if err != nil {
+		return nil, err
+	}
+	return &Export{
+		ID:           id,
+		SimulationID: simulationID,
+		Key:          key,
+		DownloadURL:  url,
+		ExpiresAt:    time.Now().Add(e.cfg.LinkExpiry).UTC(),
+		Manifest:     m,
+	}, nil
+}
+
+// discard remove o zip incompleto de uma exportação recusada.
+func (e *Exporter) discard(ctx context.Context, key string) {
+	ctx, cancel := supervisor.Cleanup(ctx)
+	defer cancel()
+	_ = e.store.Delete(ctx, key)
+}
+
+// capture pausa os ticks, grava o estado ao vivo pendente e abre a
+// transação cujo snapshot o arquivo lê. Os ticks voltam assim que o
+// snapshot está fixado; ele é fixado pela primeira consulta da transação.
+func (e *Exporter) capture(ctx context.Context, simulationID string) (*Manifest, *sql.Tx, error) {
+	resume, err := e.clock.Quiesce(ctx, simulationID, e.cfg.QuiesceTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resume()
+	if e.flusher != nil {
+		if err := e.flusher.FlushSimulation(ctx, simulationID); err != nil {
+			return nil, nil, fmt.Errorf("snapshot: falha ao gravar o estado ao vivo: %w", err)
+		}
+	}
+
+	tx, err := e.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, nil, err
+	}
+	m := &Manifest{Format: Format, Version: Version, SimulationID: simulationID, Files: []File{}}
+	err = e.db.QueryRow(instrument.WithTx(ctx, tx), "snapshot.simulation",
+		`SELECT COALESCE(project_id, ''), status FROM simulations WHERE id = $1`, simulationID).Scan(&m.ProjectID, &m.Status)
+	if err == nil {
+		m.CapturedAt = time.Now().UTC()
+		m.Tick, err = e.ticks.CurrentTick(ctx, simulationID)
+	}
+	if err == nil {
+		m.PendingMessages, err = e.ticks.Pending(ctx, simulationID)
+	}
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, ErrNotFound
+		}
+		return nil, nil, err
+	}
+	return m, tx, nil
+}
+
+// write escreve em w o zip com os conjuntos e, por último, o manifest,
+// preenchendo m.Files.
+func (e *Exporter) write(ctx context.Context, w io.Writer, m *Manifest) error {
+	zw := zip.NewWriter(w)
+	for _, d := range datasets {
+		f, err := e.dump(ctx, zw, d, m.SimulationID)
+		if err != nil {
+			return fmt.Errorf("snapshot: falha ao ler %s: %w", d.name, err)
+		}
+		m.Files = append(m.Files, f)
+	}
+	mw, err := zw.Create("manifest.json")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(mw)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(m); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func (e *Exporter) dump(ctx context.Context, zw *zip.Writer, d dataset, simulationID string) (File, error) {
+	f := File{Name: d.name}
+	fw, err := zw.Create(d.name)
+	if err != nil {
+		return f, err
+	}
+	sum := sha256.New()
+	w := io.MultiWriter(fw, sum)
+	rows, err := e.db.Query(ctx, "snapshot.dump_"+strings.TrimSuffix(d.name, ".jsonl"), d.query, simulationID)
+	if err != nil {
+		return f, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var raw []byte
+		if err := rows.Scan(&raw); err != nil {
+			return f, err
+		}
+		if _, err := w.Write(append(raw, '\n')); err != nil {
+			return f, err
+		}
+		f.Rows++
+	}
+	if err := rows.Err(); err != nil {
+		return f, err
+	}
+	f.SHA256 = hex.EncodeToString(sum.Sum(nil))
+	return f, nil
+}
+
+// counter conta os bytes escritos; com max, falha ao passar dele.
+type counter struct {
+	n, max int64
+}
+
+func (c *counter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	if c.exceeded() {
+		return 0, errTooLarge
+	}
+	return len(p), nil
+}
+
+func (c *counter) exceeded() bool {
+	return c.max > 0 && c.n > c.max
+}
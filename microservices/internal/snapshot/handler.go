@@ -0,0 +1,73 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/agentmsg"
+	"smart-city-microservices/internal/audit"
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/logging"
+)
+
+// SimulationGetter é o subconjunto de agent.Service usado pelo handler.
+type SimulationGetter interface {
+	GetSimulation(ctx context.Context, id string) (*agent.Simulation, error)
+}
+
+// Handler expõe a exportação de simulações.
+type Handler struct {
+	exporter    *Exporter
+	simulations SimulationGetter
+}
+
+// NewHandler cria o handler de exportações.
+func NewHandler(exporter *Exporter, simulations SimulationGetter) *Handler {
+	return &Handler{exporter: exporter, simulations: simulations}
+}
+
+// Export responde POST /simulations/:id/export com o link do zip e o
+// manifest dele. 503 se os ticks da simulação não pausaram a tempo; 413
+// se o zip passou do limite do projeto.
+func (h *Handler) Export(c *gin.Context) {
+	ctx := c.Request.Context()
+	sim, err := h.simulations.GetSimulation(ctx, c.Param("id"))
+	if errors.Is(err, agent.ErrNotFound) || (err == nil && !auth.FromGin(c).InProject(sim.ProjectID)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": ErrNotFound.Error()})
+		return
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	x, err := h.exporter.Export(ctx, sim.ID)
+	var tooLarge *TooLargeError
+	switch {
+	case errors.As(err, &tooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error(), "limit_bytes": tooLarge.Limit})
+		return
+	case errors.Is(err, ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, agentmsg.ErrNotQuiesced):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		h.internalError(c, err)
+		return
+	}
+	audit.Record(ctx, "simulation.exported", logrus.Fields{
+		"simulation_id": sim.ID, "export_id": x.ID, "key": x.Key, "size": x.Manifest.Size, "coalesced": x.Coalesced,
+	})
+	c.JSON(http.StatusCreated, x)
+}
+
+func (h *Handler) internalError(c *gin.Context, err error) {
+	logging.FromContext(c.Request.Context()).WithError(err).Error("Erro no handler de exportação de simulações")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
@@ -0,0 +1,116 @@
+// Package snapshot exporta o retrato de uma simulação num instante: a
+// simulação, os agentes e o estado ao vivo deles, o ambiente (cenários,
+// falhas ativas e dependências), as ações pendentes e as métricas.
+//
+// Para que o retrato seja consistente, o relógio pausa os ticks da
+// simulação, o estado ao vivo pendente no Redis é gravado e uma transação
+// REPEATABLE READ fixa o snapshot do banco; a partir daí os ticks voltam e
+// o arquivo é lido todo desse snapshot. O arquivo é um zip com um JSONL por
+// conjunto e um manifest.json, gravado em streaming no armazenamento de
+// objetos, sem passar inteiro pela memória.
+package snapshot
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Format identifica o manifest das exportações.
+const (
+	Format  = "smartcity.simulation-snapshot"
+	Version = 1
+)
+
+// ErrNotFound indica uma simulação que não existe.
+var ErrNotFound = errors.New("simulation not found")
+
+// errTooLarge interrompe a escrita do zip que passou do limite do projeto.
+var errTooLarge = errors.New("snapshot: exportação maior que o limite do projeto")
+
+// TooLargeError recusa a exportação que passou de Limit bytes, o limite do
+// projeto (project_settings).
+type TooLargeError struct {
+	Limit int64
+}
+
+func (e *TooLargeError) Error() string {
+	return fmt.Sprintf("export exceeds the project's limit of %d bytes", e.Limit)
+}
+
+// Manifest descreve uma exportação. O zip traz o mesmo manifest, sem
+// SHA256 e Size, que só se conhecem depois de ele fechado.
+type Manifest struct {
+	Format       string `json:"format"`
+	Version      int    `json:"version"`
+	ExportID     string `json:"export_id"`
+	SimulationID string `json:"simulation_id"`
+	ProjectID    string `json:"project_id,omitempty"`
+	Status       string `json:"status"`
+	// Tick é o tick da simulação no instante do retrato.
+	Tick int64 `json:"tick"`
+	// PendingMessages são as mensagens entre agentes à espera do próximo
+	// tick, que ficam no Redis e não entram no arquivo.
+	PendingMessages int64     `json:"pending_messages"`
+	CapturedAt      time.Time `json:"captured_at"`
+	Files           []File    `json:"files"`
+	// SHA256 é o hash do zip inteiro, em hexadecimal.
+	SHA256 string `json:"sha256,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+}
+
+// File é um JSONL do zip.
+type File struct {
+	Name string `json:"name"`
+	Rows int64  `json:"rows"`
+	// SHA256 é o hash do conteúdo descompactado.
+	SHA256 string `json:"sha256"`
+}
+
+// Export é uma exportação concluída.
+type Export struct {
+	ID           string    `json:"id"`
+	SimulationID string    `json:"simulation_id"`
+	Key          string    `json:"key"`
+	DownloadURL  string    `json:"download_url"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	// Coalesced indica que o pedido aproveitou uma exportação da mesma
+	// simulação que já estava em andamento.
+	Coalesced bool      `json:"coalesced"`
+	Manifest  *Manifest `json:"manifest"`
+}
+
+// Key é a chave do zip de uma exportação no armazenamento.
+func Key(simulationID, exportID string) string {
+	return "exports/simulations/" + simulationID + "/" + exportID + ".zip"
+}
+
+// dataset é um JSONL do zip e a consulta que lê as linhas dele da
+// simulação $1.
+type dataset struct {
+	name  string
+	query string
+}
+
+// datasets são os conjuntos exportados, na ordem do zip.
+var datasets = []dataset{
+	{"simulation.jsonl", `SELECT row_to_json(s) FROM simulations s WHERE s.id = $1`},
+	{"agents.jsonl", `SELECT row_to_json(a) FROM agents a WHERE a.simulation_id = $1 ORDER BY a.id`},
+	{"agent_twins.jsonl", `SELECT row_to_json(t) FROM agent_twins t JOIN agents a ON a.id = t.agent_id
+		WHERE a.simulation_id = $1 ORDER BY t.agent_id`},
+	{"scenario_executions.jsonl", `SELECT row_to_json(e) FROM scenario_executions e WHERE e.simulation_id = $1 ORDER BY e.started_at, e.id`},
+	{"simulation_faults.jsonl", `SELECT row_to_json(f) FROM simulation_faults f
+		WHERE f.simulation_id = $1 AND f.status = 'active' ORDER BY f.started_at, f.id`},
+	{"agent_dependencies.jsonl", `SELECT row_to_json(d) FROM agent_dependencies d JOIN agents a ON a.id = d.agent_id
+		WHERE a.simulation_id = $1 ORDER BY d.agent_id, d.depends_on`},
+	{"agent_actions.jsonl", `SELECT row_to_json(x) FROM agent_actions x JOIN agents a ON a.id = x.agent_id
+		WHERE a.simulation_id = $1 AND x.status IN ('queued', 'running') ORDER BY x.created_at, x.id`},
+	{"scheduled_actions.jsonl", `SELECT row_to_json(sa) FROM scheduled_actions sa JOIN agents a ON a.id = sa.agent_id
+		WHERE a.simulation_id = $1 AND sa.enabled ORDER BY sa.created_at, sa.id`},
+	{"metrics.jsonl", `SELECT row_to_json(m) FROM metrics m WHERE m.simulation_id = $1 ORDER BY m.timestamp, m.id`},
+	{"agent_metric_samples.jsonl", `SELECT row_to_json(ms) FROM agent_metric_samples ms JOIN agents a ON a.id = ms.agent_id
+		WHERE a.simulation_id = $1 ORDER BY ms.recorded_at, ms.agent_id, ms.name`},
+	{"simulation_consumption.jsonl", `SELECT row_to_json(c) FROM simulation_consumption c
+		WHERE c.simulation_id = $1 ORDER BY c.bucket, c.agent_type`},
+	{"agent_consumption.jsonl", `SELECT row_to_json(c) FROM agent_consumption c WHERE c.simulation_id = $1 ORDER BY c.agent_id`},
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"smart-city-microservices/internal/logging"
+)
+
+// Delivery entrega objetos às rotas de download (exportações, arquivos).
+// Objetos até Threshold bytes passam pela API; os maiores recebem uma URL
+// assinada, para não ocupar o servidor com a transferência.
+type Delivery struct {
+	Store     Store
+	Threshold int64
+	Expiry    time.Duration
+}
+
+// Link é o corpo da resposta quando o download é por URL assinada.
+type Link struct {
+	DownloadURL string    `json:"download_url"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type"`
+}
+
+// Respond responde com o conteúdo do objeto ou, acima do limite, com
+// 303 See Other para a URL assinada e o Link no corpo.
+func (d Delivery) Respond(c *gin.Context, key string) {
+	ctx := c.Request.Context()
+	obj, err := d.Store.Stat(ctx, key)
+	if err != nil {
+		d.fail(c, key, err)
+		return
+	}
+
+	if obj.Size > d.Threshold {
+		u, err := d.Store.Presign(ctx, key, d.Expiry)
+		if err != nil {
+			d.fail(c, key, err)
+			return
+		}
+		c.Header("Location", u)
+		c.JSON(http.StatusSeeOther, Link{
+			DownloadURL: u,
+			ExpiresAt:   time.Now().Add(d.Expiry).UTC(),
+			Size:        obj.Size,
+			ContentType: obj.ContentType,
+		})
+		return
+	}
+
+	r, obj, err := d.Store.Get(ctx, key)
+	if err != nil {
+		d.fail(c, key, err)
+		return
+	}
+	defer r.Close()
+	c.DataFromReader(http.StatusOK, obj.Size, obj.ContentType, r, map[string]string{
+		"Content-Disposition": mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(key)}),
+	})
+}
+
+func (d Delivery) fail(c *gin.Context, key string, err error) {
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "object not found"})
+		return
+	}
+	logging.FromContext(c.Request.Context()).WithError(err).WithField("key", key).Error("Erro ao entregar objeto")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
@@ -0,0 +1,232 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"smart-city-microservices/internal/logging"
+)
+
+// FileConfig configura o armazenamento em sistema de arquivos.
+type FileConfig struct {
+	// Root é o diretório base dos objetos.
+	Root string
+	// SigningKey assina as URLs de Presign. Instâncias que servem os mesmos
+	// links precisam da mesma chave.
+	SigningKey []byte
+	// BaseURL é o prefixo das URLs de Presign, onde Handler está montado
+	// (ex.: "https://api.example.com/storage" ou só "/storage").
+	BaseURL string
+}
+
+// FileStore guarda os objetos como arquivos sob Root. O tipo de conteúdo
+// não é persistido: é deduzido da extensão da chave.
+type FileStore struct {
+	root    string
+	key     []byte
+	baseURL string
+}
+
+// NewFileStore cria o armazenamento, criando Root se necessário.
+func NewFileStore(cfg FileConfig) (*FileStore, error) {
+	if len(cfg.SigningKey) == 0 {
+		return nil, errors.New("storage: chave de assinatura vazia")
+	}
+	if err := os.MkdirAll(cfg.Root, 0o750); err != nil {
+		return nil, fmt.Errorf("storage: criar %s: %w", cfg.Root, err)
+	}
+	return &FileStore{
+		root:    cfg.Root,
+		key:     cfg.SigningKey,
+		baseURL: strings.TrimSuffix(cfg.BaseURL, "/"),
+	}, nil
+}
+
+func (s *FileStore) path(key string) (string, error) {
+	if err := ValidKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
+
+// Put grava num arquivo temporário no mesmo diretório e renomeia, para que
+// leitores nunca vejam um objeto pela metade.
+func (s *FileStore) Put(ctx context.Context, key string, r io.Reader, size int64, _ string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o750); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, &ctxReader{ctx: ctx, r: r})
+	if err == nil && size >= 0 && n != size {
+		err = fmt.Errorf("storage: %s: esperados %d bytes, recebidos %d", key, size, n)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+func (s *FileStore) Get(_ context.Context, key string) (io.ReadCloser, Object, error) {
+	return s.open(key)
+}
+
+func (s *FileStore) open(key string) (*os.File, Object, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, Object{}, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, Object{}, notFound(err)
+	}
+	info, err := f.Stat()
+	if err == nil && info.IsDir() {
+		err = ErrNotFound
+	}
+	if err != nil {
+		f.Close()
+		return nil, Object{}, err
+	}
+	return f, fileObject(key, info), nil
+}
+
+func (s *FileStore) Stat(_ context.Context, key string) (Object, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return Object{}, err
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		return Object{}, notFound(err)
+	}
+	if info.IsDir() {
+		return Object{}, ErrNotFound
+	}
+	return fileObject(key, info), nil
+}
+
+func (s *FileStore) Delete(_ context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Presign gera um link para Handler com validade e assinatura HMAC.
+func (s *FileStore) Presign(_ context.Context, key string, expiry time.Duration) (string, error) {
+	if err := ValidKey(key); err != nil {
+		return "", err
+	}
+	expires := strconv.FormatInt(time.Now().Add(expiry).Unix(), 10)
+	q := url.Values{"expires": {expires}, "signature": {s.sign(key, expires)}}
+	return s.baseURL + "/" + (&url.URL{Path: key}).EscapedPath() + "?" + q.Encode(), nil
+}
+
+func (s *FileStore) Ping(context.Context) error {
+	_, err := os.Stat(s.root)
+	return err
+}
+
+func (s *FileStore) sign(key, expires string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(key + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Handler serve os links gerados por Presign. A rota deve ter o parâmetro
+// curinga *key (ex.: GET /storage/*key) e não exige autenticação: a
+// assinatura é a autorização. Suporta Range.
+func (s *FileStore) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := strings.TrimPrefix(c.Param("key"), "/")
+		expires := c.Query("expires")
+		unix, err := strconv.ParseInt(expires, 10, 64)
+		if err != nil || !hmac.Equal([]byte(c.Query("signature")), []byte(s.sign(key, expires))) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "invalid signature"})
+			return
+		}
+		if time.Now().Unix() > unix {
+			c.JSON(http.StatusForbidden, gin.H{"error": "link expired"})
+			return
+		}
+
+		f, obj, err := s.open(key)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "object not found"})
+				return
+			}
+			logging.FromContext(c.Request.Context()).WithError(err).WithField("key", key).Error("Erro ao abrir objeto")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+			return
+		}
+		defer f.Close()
+		c.Header("Content-Type", obj.ContentType)
+		c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(key)}))
+		http.ServeContent(c.Writer, c.Request, path.Base(key), obj.ModTime, f)
+	}
+}
+
+func fileObject(key string, info fs.FileInfo) Object {
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return Object{Key: key, Size: info.Size(), ContentType: contentType, ModTime: info.ModTime()}
+}
+
+func notFound(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// ctxReader interrompe a cópia quando o contexto é cancelado.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Config configura o armazenamento S3 compatível.
+type S3Config struct {
+	// Endpoint é host[:porta], sem esquema (ex.: "s3.amazonaws.com",
+	// "minio:9000").
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PathStyle usa URLs endpoint/bucket/chave em vez de bucket.endpoint,
+	// como exigem MinIO e a maioria dos serviços fora da AWS.
+	PathStyle bool
+	// CreateBucket cria o bucket na inicialização se ele não existir.
+	CreateBucket bool
+	// TLSConfig habilita HTTPS; nil usa HTTP.
+	TLSConfig *tls.Config
+}
+
+// S3Store guarda os objetos num bucket S3 compatível.
+type S3Store struct {
+	client *minio.Client
+	bucket string
+}
+
+// NewS3Store conecta ao endpoint e confere (ou cria) o bucket.
+func NewS3Store(ctx context.Context, cfg S3Config) (*S3Store, error) {
+	opts := &minio.Options{
+		Creds:        credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure:       cfg.TLSConfig != nil,
+		Region:       cfg.Region,
+		BucketLookup: minio.BucketLookupAuto,
+	}
+	if cfg.PathStyle {
+		opts.BucketLookup = minio.BucketLookupPath
+	}
+	if cfg.TLSConfig != nil {
+		transport, err := minio.DefaultTransport(true)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = cfg.TLSConfig
+		opts.Transport = transport
+	}
+	client, err := minio.New(cfg.Endpoint, opts)
+	if err != nil {
+		return nil, fmt.Errorf("storage: cliente S3: %w", err)
+	}
+
+	s := &S3Store{client: client, bucket: cfg.Bucket}
+	exists, err := client.BucketExists(ctx, cfg.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("storage: verificar bucket %s: %w", cfg.Bucket, err)
+	}
+	if !exists {
+		if !cfg.CreateBucket {
+			return nil, fmt.Errorf("storage: bucket %s não existe", cfg.Bucket)
+		}
+		if err := client.MakeBucket(ctx, cfg.Bucket, minio.MakeBucketOptions{Region: cfg.Region}); err != nil {
+			return nil, fmt.Errorf("storage: criar bucket %s: %w", cfg.Bucket, err)
+		}
+	}
+	return s, nil
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	if err := ValidKey(key); err != nil {
+		return err
+	}
+	_, err := s.client.PutObject(ctx, s.bucket, key, r, size, minio.PutObjectOptions{ContentType: contentType})
+	return err
+}
+
+// Get abre o objeto. O GetObject do SDK é preguiçoso; o Stat em seguida
+// antecipa o erro de objeto inexistente.
+func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, Object, error) {
+	if err := ValidKey(key); err != nil {
+		return nil, Object{}, err
+	}
+	obj, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, Object{}, s3Error(err)
+	}
+	info, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		return nil, Object{}, s3Error(err)
+	}
+	return obj, s3Object(info), nil
+}
+
+func (s *S3Store) Stat(ctx context.Context, key string) (Object, error) {
+	if err := ValidKey(key); err != nil {
+		return Object{}, err
+	}
+	info, err := s.client.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return Object{}, s3Error(err)
+	}
+	return s3Object(info), nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	if err := ValidKey(key); err != nil {
+		return err
+	}
+	return s3Error(s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{}))
+}
+
+func (s *S3Store) Presign(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	if err := ValidKey(key); err != nil {
+		return "", err
+	}
+	u, err := s.client.PresignedGetObject(ctx, s.bucket, key, expiry, nil)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+func (s *S3Store) Ping(ctx context.Context) error {
+	_, err := s.client.BucketExists(ctx, s.bucket)
+	return err
+}
+
+func s3Object(info minio.ObjectInfo) Object {
+	return Object{Key: info.Key, Size: info.Size, ContentType: info.ContentType, ModTime: info.LastModified}
+}
+
+func s3Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,61 @@
+// Package storage guarda objetos grandes (checkpoints, arquivos de
+// simulações, exportações) fora do PostgreSQL, que fica só com os
+// metadados. Há duas implementações: sistema de arquivos local e
+// S3 compatível (AWS, MinIO, Ceph).
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// ErrNotFound indica que o objeto não existe.
+var ErrNotFound = errors.New("storage: object not found")
+
+// Object descreve um objeto guardado.
+type Object struct {
+	Key         string
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+}
+
+// Store é um armazenamento de objetos. As chaves são caminhos relativos
+// separados por "/", como "exports/<id>.jsonl.gz" (ver ValidKey).
+type Store interface {
+	// Put grava o objeto, substituindo um existente. size é o tamanho em
+	// bytes ou -1 se desconhecido.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get abre o objeto para leitura; o chamador fecha o reader.
+	Get(ctx context.Context, key string) (io.ReadCloser, Object, error)
+	// Stat retorna os metadados sem abrir o conteúdo.
+	Stat(ctx context.Context, key string) (Object, error)
+	// Delete remove o objeto. Remover um objeto inexistente não é erro.
+	Delete(ctx context.Context, key string) error
+	// Presign gera uma URL de download válida por expiry, que dispensa
+	// as credenciais da API.
+	Presign(ctx context.Context, key string, expiry time.Duration) (string, error)
+	// Ping verifica se o armazenamento está acessível.
+	Ping(ctx context.Context) error
+}
+
+// ValidKey rejeita chaves vazias, absolutas ou com segmentos vazios, "." ou
+// "..", que escapariam da raiz no sistema de arquivos.
+func ValidKey(key string) error {
+	if key == "" || len(key) > 1024 {
+		return fmt.Errorf("invalid object key %q", key)
+	}
+	if strings.ContainsAny(key, "\\\x00") {
+		return fmt.Errorf("invalid object key %q", key)
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return fmt.Errorf("invalid object key %q", key)
+		}
+	}
+	return nil
+}
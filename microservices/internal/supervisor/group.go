@@ -0,0 +1,45 @@
+package supervisor
+
+import (
+	"context"
+	"sync"
+)
+
+// Group roda as goroutines de um componente com vários workers. A primeira
+// que falha ou entra em pânico cancela o contexto das demais, e o erro dela é
+// o retorno de Wait; com ele o RunFunc do componente retorna e o supervisor
+// reinicia o componente inteiro.
+type Group struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	once   sync.Once
+	err    error
+}
+
+// NewGroup cria um grupo ligado a ctx e retorna o contexto das goroutines.
+func NewGroup(ctx context.Context) (*Group, context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	return &Group{ctx: ctx, cancel: cancel}, ctx
+}
+
+// Go roda fn no grupo.
+func (g *Group) Go(fn RunFunc) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		if err := protect(g.ctx, fn); err != nil {
+			g.once.Do(func() {
+				g.err = err
+				g.cancel()
+			})
+		}
+	}()
+}
+
+// Wait espera todas as goroutines e retorna o erro da primeira que falhou.
+func (g *Group) Wait() error {
+	g.wg.Wait()
+	g.cancel()
+	return g.err
+}
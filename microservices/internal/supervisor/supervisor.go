@@ -0,0 +1,296 @@
+// Package supervisor roda os componentes de fundo do serviço: reinicia com
+// espera crescente os que falham ou entram em pânico, expõe o estado de cada
+// um e garante que todos terminem no encerramento.
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+)
+
+// Estados de um componente.
+const (
+	StateRunning = "running"
+	// StateBackoff é a espera entre uma falha e o reinício.
+	StateBackoff = "backoff"
+	// StateExited é o de um componente que terminou sozinho, sem erro; ele
+	// não é reiniciado.
+	StateExited  = "exited"
+	StateStopped = "stopped"
+)
+
+var (
+	restarts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "component_restarts_total",
+		Help:      "Reinícios de componentes de fundo após falha ou pânico.",
+	}, []string{"component"})
+
+	up = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "agent_service",
+		Name:      "component_up",
+		Help:      "1 enquanto o componente de fundo está rodando, 0 na espera para reiniciar ou depois de terminar.",
+	}, []string{"component"})
+)
+
+// RunFunc é o corpo de um componente: roda até ctx ser cancelado e retorna
+// nil. Um erro ou pânico antes disso é uma falha, e o componente é reiniciado.
+type RunFunc func(ctx context.Context) error
+
+// Config configura os reinícios.
+type Config struct {
+	// InitialBackoff é a espera antes do primeiro reinício; dobra a cada
+	// falha seguida, até MaxBackoff.
+	InitialBackoff time.Duration
+	// MaxBackoff limita a espera. Um componente que rodou ao menos esse tempo
+	// antes de falhar volta a esperar InitialBackoff.
+	MaxBackoff time.Duration
+}
+
+// Status é a visão serializável de um componente.
+type Status struct {
+	Name          string     `json:"name"`
+	State         string     `json:"state"`
+	Restarts      int        `json:"restarts"`
+	StartedAt     time.Time  `json:"started_at"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastFailureAt *time.Time `json:"last_failure_at,omitempty"`
+}
+
+// Supervisor mantém os componentes na ordem em que foram iniciados.
+type Supervisor struct {
+	cfg Config
+
+	mu         sync.Mutex
+	components []*component
+	stopped    bool
+}
+
+// New cria um supervisor sem componentes.
+func New(cfg Config) *Supervisor {
+	return &Supervisor{cfg: cfg}
+}
+
+type component struct {
+	name   string
+	run    RunFunc
+	cancel context.CancelFunc
+	exited chan struct{}
+	stop   *stopDeadline
+
+	mu     sync.Mutex
+	status Status
+}
+
+// Go inicia run sob supervisão e retorna a função que o encerra: cancela o
+// contexto de run e espera que retorne, até o prazo do contexto recebido.
+// A função tem a assinatura de readiness.StopFunc, para que o componente
+// seja registrado e encerrado na ordem dos demais.
+func (s *Supervisor) Go(name string, run RunFunc) func(ctx context.Context) error {
+	stop := &stopDeadline{}
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), stopKey{}, stop))
+	c := &component{
+		name:   name,
+		run:    run,
+		cancel: cancel,
+		exited: make(chan struct{}),
+		stop:   stop,
+		status: Status{Name: name, State: StateRunning, StartedAt: time.Now().UTC()},
+	}
+
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		cancel()
+		logrus.WithField("component", name).Warn("Componente iniciado depois do encerramento do supervisor; ignorado")
+		return func(context.Context) error { return nil }
+	}
+	s.components = append(s.components, c)
+	s.mu.Unlock()
+
+	go s.supervise(ctx, c)
+	return c.shutdown
+}
+
+func (s *Supervisor) supervise(ctx context.Context, c *component) {
+	defer close(c.exited)
+	defer up.WithLabelValues(c.name).Set(0)
+	log := logrus.WithField("component", c.name)
+	backoff := s.cfg.InitialBackoff
+	for {
+		began := time.Now()
+		c.running(began)
+		up.WithLabelValues(c.name).Set(1)
+		err := protect(ctx, c.run)
+		up.WithLabelValues(c.name).Set(0)
+		if ctx.Err() != nil {
+			if err != nil {
+				log.WithError(err).Warn("Componente terminou com erro no encerramento")
+			}
+			c.finish(StateStopped)
+			return
+		}
+		if err == nil {
+			log.Warn("Componente de fundo terminou sozinho; não será reiniciado")
+			c.finish(StateExited)
+			return
+		}
+
+		if time.Since(began) >= s.cfg.MaxBackoff {
+			backoff = s.cfg.InitialBackoff
+		}
+		restarts.WithLabelValues(c.name).Inc()
+		c.failed(err)
+		log.WithError(err).WithField("backoff", backoff.String()).Error("Componente de fundo falhou; reiniciando")
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			c.finish(StateStopped)
+			return
+		case <-timer.C:
+		}
+		backoff = min(2*backoff, s.cfg.MaxBackoff)
+	}
+}
+
+// protect roda run convertendo um pânico em erro, com a pilha no log.
+func protect(ctx context.Context, run RunFunc) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logrus.WithField("stack", string(debug.Stack())).Errorf("Pânico em componente de fundo: %v", r)
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return run(ctx)
+}
+
+func (c *component) shutdown(ctx context.Context) error {
+	c.stop.set(ctx)
+	c.cancel()
+	select {
+	case <-c.exited:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("componente %s não terminou: %w", c.name, ctx.Err())
+	}
+}
+
+func (c *component) running(at time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.status.State = StateRunning
+	c.status.StartedAt = at.UTC()
+}
+
+func (c *component) failed(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now().UTC()
+	c.status.State = StateBackoff
+	c.status.Restarts++
+	c.status.LastError = err.Error()
+	c.status.LastFailureAt = &now
+}
+
+func (c *component) finish(state string) {
+	c.mu.Lock()
+	c.status.State = state
+	c.mu.Unlock()
+}
+
+// Status retorna o estado de cada componente na ordem de início.
+func (s *Supervisor) Status() []Status {
+	s.mu.Lock()
+	components := make([]*component, len(s.components))
+	copy(components, s.components)
+	s.mu.Unlock()
+
+	out := make([]Status, 0, len(components))
+	for _, c := range components {
+		c.mu.Lock()
+		out = append(out, c.status)
+		c.mu.Unlock()
+	}
+	return out
+}
+
+// Handler responde GET /admin/components com o estado dos componentes.
+func (s *Supervisor) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"data": s.Status()})
+	}
+}
+
+// Stop encerra os componentes que ainda rodam, ao mesmo tempo, e espera
+// todos até o prazo de ctx. Registrado antes dos componentes, roda por
+// último no encerramento e só encontra os que não foram encerrados pela
+// própria função de Go.
+func (s *Supervisor) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	s.stopped = true
+	components := make([]*component, len(s.components))
+	copy(components, s.components)
+	s.mu.Unlock()
+
+	errs := make([]error, len(components))
+	var wg sync.WaitGroup
+	for i, c := range components {
+		wg.Add(1)
+		go func(i int, c *component) {
+			defer wg.Done()
+			errs[i] = c.shutdown(ctx)
+		}(i, c)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// stopKey guarda no contexto de RunFunc o prazo do encerramento.
+type stopKey struct{}
+
+// stopDeadline é o prazo do contexto recebido pela função de encerramento,
+// gravado antes de o contexto de RunFunc ser cancelado.
+type stopDeadline struct {
+	mu       sync.Mutex
+	deadline time.Time
+}
+
+func (d *stopDeadline) set(ctx context.Context) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if t, ok := ctx.Deadline(); ok && (d.deadline.IsZero() || t.Before(d.deadline)) {
+		d.deadline = t
+	}
+}
+
+func (d *stopDeadline) get() (time.Time, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.deadline, !d.deadline.IsZero()
+}
+
+// Cleanup retorna o contexto para a limpeza de um componente depois que o
+// contexto de RunFunc é cancelado (gravar pendências, liberar uma chave de
+// liderança): não é cancelado junto com ele e vence no prazo do
+// encerramento, se houver.
+func Cleanup(ctx context.Context) (context.Context, context.CancelFunc) {
+	base := context.WithoutCancel(ctx)
+	if d, ok := ctx.Value(stopKey{}).(*stopDeadline); ok {
+		if t, ok := d.get(); ok {
+			return context.WithDeadline(base, t)
+		}
+	}
+	return context.WithCancel(base)
+}
@@ -0,0 +1,218 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/goleak"
+
+	"smart-city-microservices/internal/readiness"
+)
+
+func TestMain(m *testing.M) {
+	goleak.VerifyTestMain(m)
+}
+
+var fastBackoff = Config{InitialBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatal("condição não atingida a tempo")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func status(s *Supervisor, name string) Status {
+	for _, st := range s.Status() {
+		if st.Name == name {
+			return st
+		}
+	}
+	return Status{}
+}
+
+func stop(t *testing.T, s *Supervisor) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRestarts(t *testing.T) {
+	tests := []struct {
+		name string
+		fail func(attempt int32) error
+	}{
+		{name: "error", fail: func(n int32) error { return errors.New("connection refused") }},
+		{name: "panic", fail: func(n int32) error { panic("nil map") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(fastBackoff)
+			var attempts atomic.Int32
+			s.Go("worker", func(ctx context.Context) error {
+				if n := attempts.Add(1); n <= 2 {
+					return tt.fail(n)
+				}
+				<-ctx.Done()
+				return nil
+			})
+			eventually(t, func() bool { return attempts.Load() == 3 })
+			eventually(t, func() bool { return status(s, "worker").State == StateRunning })
+			st := status(s, "worker")
+			if st.Restarts != 2 || st.LastError == "" || st.LastFailureAt == nil {
+				t.Errorf("status depois de duas falhas: %+v", st)
+			}
+			stop(t, s)
+			if st := status(s, "worker"); st.State != StateStopped {
+				t.Errorf("estado depois do Stop: %s", st.State)
+			}
+		})
+	}
+}
+
+func TestExitedIsNotRestarted(t *testing.T) {
+	s := New(fastBackoff)
+	var runs atomic.Int32
+	s.Go("once", func(context.Context) error {
+		runs.Add(1)
+		return nil
+	})
+	eventually(t, func() bool { return status(s, "once").State == StateExited })
+	time.Sleep(20 * time.Millisecond)
+	if n := runs.Load(); n != 1 {
+		t.Errorf("componente que terminou sem erro rodou %d vezes", n)
+	}
+	stop(t, s)
+}
+
+func TestGoAfterStop(t *testing.T) {
+	s := New(fastBackoff)
+	stop(t, s)
+	var ran atomic.Bool
+	shutdown := s.Go("late", func(context.Context) error {
+		ran.Store(true)
+		return nil
+	})
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if ran.Load() || len(s.Status()) != 0 {
+		t.Error("componente iniciado depois do Stop")
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	s := New(fastBackoff)
+	release := make(chan struct{})
+	var cleanupDeadline atomic.Value
+	shutdown := s.Go("stuck", func(ctx context.Context) error {
+		<-ctx.Done()
+		cleanup, cancel := Cleanup(ctx)
+		defer cancel()
+		if d, ok := cleanup.Deadline(); ok {
+			cleanupDeadline.Store(d)
+		}
+		<-release
+		return nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	want, _ := ctx.Deadline()
+	if err := shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("shutdown de componente preso = %v, want DeadlineExceeded", err)
+	}
+	if got, _ := cleanupDeadline.Load().(time.Time); !got.Equal(want) {
+		t.Errorf("prazo da limpeza = %v, want o do encerramento %v", got, want)
+	}
+	close(release)
+	stop(t, s)
+}
+
+func TestGroupCancelsOnFirstFailure(t *testing.T) {
+	g, ctx := NewGroup(context.Background())
+	boom := errors.New("boom")
+	for i := 0; i < 3; i++ {
+		g.Go(func(ctx context.Context) error {
+			<-ctx.Done()
+			return nil
+		})
+	}
+	g.Go(func(context.Context) error { return boom })
+	if err := g.Wait(); !errors.Is(err, boom) {
+		t.Errorf("Wait = %v, want %v", err, boom)
+	}
+	if ctx.Err() == nil {
+		t.Error("contexto do grupo não cancelado")
+	}
+}
+
+// TestStartStopNoLeak monta os componentes como o main do serviço
+// (supervisor registrado primeiro na prontidão, cada componente com a
+// função de Go como encerramento, checagens periódicas de prontidão e um
+// componente com vários workers) e confere que o encerramento não deixa
+// goroutines para trás.
+func TestStartStopNoLeak(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	ready := readiness.NewRegistry()
+	s := New(fastBackoff)
+	ready.Register("supervisor", s.Stop).SetReady()
+
+	db := ready.Register("database", nil)
+	db.SetReady()
+	ready.Register("database_check", s.Go("database_check", func(ctx context.Context) error {
+		db.Watch(ctx, time.Millisecond, func(context.Context) error { return nil })
+		return nil
+	})).SetReady()
+
+	var restarts atomic.Int32
+	ready.Register("workers", s.Go("workers", func(ctx context.Context) error {
+		g, ctx := NewGroup(ctx)
+		for i := 0; i < 4; i++ {
+			g.Go(func(ctx context.Context) error {
+				ticker := time.NewTicker(time.Millisecond)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-ctx.Done():
+						return nil
+					case <-ticker.C:
+					}
+				}
+			})
+		}
+		if restarts.Add(1) == 1 {
+			g.Go(func(context.Context) error { return errors.New("falha do primeiro worker") })
+		}
+		return g.Wait()
+	})).SetReady()
+
+	// Um componente que não é encerrado pela própria função: Stop do
+	// supervisor o encontra no fim.
+	s.Go("orphan", func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	})
+
+	eventually(t, func() bool { return restarts.Load() >= 2 })
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := ready.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, st := range s.Status() {
+		if st.State != StateStopped {
+			t.Errorf("%s: estado %s depois do encerramento", st.Name, st.State)
+		}
+	}
+}
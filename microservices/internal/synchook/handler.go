@@ -0,0 +1,372 @@
+package synchook
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/audit"
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/i18n"
+	"smart-city-microservices/internal/logging"
+)
+
+// AgentService é o subconjunto de agent.Service usado para achar o projeto
+// da operação.
+type AgentService interface {
+	GetAgent(ctx context.Context, id string) (*agent.Agent, error)
+	GetSimulation(ctx context.Context, id string) (*agent.Simulation, error)
+}
+
+// Handler expõe o CRUD dos hooks síncronos e os aplica nas rotas de
+// agentes.
+type Handler struct {
+	repo    *Repository
+	invoker *Invoker
+	agents  AgentService
+}
+
+// NewHandler cria o handler de hooks síncronos.
+func NewHandler(repo *Repository, invoker *Invoker, agents AgentService) *Handler {
+	return &Handler{repo: repo, invoker: invoker, agents: agents}
+}
+
+// CreateRequest é o corpo de POST /sync-hooks. Sem secret, um é gerado;
+// sem operations, o hook trata todas.
+type CreateRequest struct {
+	ProjectID  string   `json:"project_id" binding:"required"`
+	URL        string   `json:"url" binding:"required"`
+	Secret     string   `json:"secret"`
+	Operations []string `json:"operations"`
+	TimeoutMs  int      `json:"timeout_ms"`
+	FailMode   string   `json:"fail_mode"`
+	Active     *bool    `json:"active"`
+}
+
+// UpdateRequest é o corpo de PUT /sync-hooks/:id; campos ausentes não
+// mudam.
+type UpdateRequest struct {
+	URL        *string   `json:"url"`
+	Operations *[]string `json:"operations"`
+	TimeoutMs  *int      `json:"timeout_ms"`
+	FailMode   *string   `json:"fail_mode"`
+	Active     *bool     `json:"active"`
+}
+
+// List retorna os hooks, opcionalmente filtrados por ?project_id=.
+func (h *Handler) List(c *gin.Context) {
+	projectID := c.Query("project_id")
+	if projectID != "" && !h.accessible(c, projectID) {
+		return
+	}
+	hooks, err := h.repo.List(c.Request.Context(), projectID)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	p := auth.FromGin(c)
+	out := []*Hook{}
+	for _, hook := range hooks {
+		if p.InProject(hook.ProjectID) {
+			hook.Secret = ""
+			out = append(out, hook)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"data": out})
+}
+
+// Get retorna um hook sem o segredo.
+func (h *Handler) Get(c *gin.Context) {
+	hook, ok := h.load(c)
+	if !ok {
+		return
+	}
+	hook.Secret = ""
+	c.JSON(http.StatusOK, hook)
+}
+
+// Create cadastra um hook. A resposta é a única que inclui o segredo.
+func (h *Handler) Create(c *gin.Context) {
+	var req CreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		i18n.BindError(c, err)
+		return
+	}
+	if !h.accessible(c, req.ProjectID) {
+		return
+	}
+	if req.Secret == "" {
+		req.Secret = newSecret()
+	} else if len(req.Secret) < 16 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "secret must have at least 16 characters"})
+		return
+	}
+	hook := &Hook{
+		ProjectID:  req.ProjectID,
+		URL:        req.URL,
+		Secret:     req.Secret,
+		Operations: req.Operations,
+		TimeoutMs:  req.TimeoutMs,
+		FailMode:   req.FailMode,
+		Active:     req.Active == nil || *req.Active,
+	}
+	if len(hook.Operations) == 0 {
+		hook.Operations = slices.Clone(Operations)
+	}
+	if hook.FailMode == "" {
+		hook.FailMode = FailClosed
+	}
+	if err := h.validate(hook); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.repo.Create(c.Request.Context(), hook); err != nil {
+		h.internalError(c, err)
+		return
+	}
+	h.invoker.Invalidate()
+	audit.Record(c.Request.Context(), "sync_hook.created", logrus.Fields{
+		"sync_hook_id": hook.ID, "project_id": hook.ProjectID, "url": hook.URL, "fail_mode": hook.FailMode,
+	})
+	c.JSON(http.StatusCreated, hook)
+}
+
+// Update altera url, operações, timeout, modo de falha ou estado do hook.
+func (h *Handler) Update(c *gin.Context) {
+	var req UpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		i18n.BindError(c, err)
+		return
+	}
+	hook, ok := h.load(c)
+	if !ok {
+		return
+	}
+	if req.URL != nil {
+		hook.URL = *req.URL
+	}
+	if req.Operations != nil {
+		hook.Operations = *req.Operations
+	}
+	if req.TimeoutMs != nil {
+		hook.TimeoutMs = *req.TimeoutMs
+	}
+	if req.FailMode != nil {
+		hook.FailMode = *req.FailMode
+	}
+	if req.Active != nil {
+		hook.Active = *req.Active
+	}
+	if err := h.validate(hook); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.repo.Update(c.Request.Context(), hook); err != nil {
+		h.serviceError(c, err)
+		return
+	}
+	h.invoker.Invalidate()
+	audit.Record(c.Request.Context(), "sync_hook.updated", logrus.Fields{
+		"sync_hook_id": hook.ID, "active": hook.Active, "fail_mode": hook.FailMode,
+	})
+	updated, err := h.repo.Get(c.Request.Context(), hook.ID)
+	if err != nil {
+		h.serviceError(c, err)
+		return
+	}
+	updated.Secret = ""
+	c.JSON(http.StatusOK, updated)
+}
+
+// Delete remove o hook.
+func (h *Handler) Delete(c *gin.Context) {
+	hook, ok := h.load(c)
+	if !ok {
+		return
+	}
+	if err := h.repo.Delete(c.Request.Context(), hook.ID); err != nil {
+		h.serviceError(c, err)
+		return
+	}
+	h.invoker.Invalidate()
+	audit.Record(c.Request.Context(), "sync_hook.deleted", logrus.Fields{"sync_hook_id": hook.ID, "project_id": hook.ProjectID})
+	c.Status(http.StatusNoContent)
+}
+
+// CreateAgent deve vir antes de POST /agents: consulta os hooks do projeto
+// do agente, que vem de project_id ou da simulação, com o corpo da
+// criação.
+func (h *Handler) CreateAgent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.Next()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		var req struct {
+			ProjectID    string `json:"project_id"`
+			SimulationID string `json:"simulation_id"`
+		}
+		if json.Unmarshal(body, &req) != nil {
+			// O handler de criação responde pelo corpo inválido.
+			c.Next()
+			return
+		}
+		if req.ProjectID == "" && req.SimulationID != "" {
+			sim, err := h.agents.GetSimulation(c.Request.Context(), req.SimulationID)
+			switch {
+			case errors.Is(err, agent.ErrNotFound):
+				c.Next()
+				return
+			case err != nil:
+				h.internalError(c, err)
+				c.Abort()
+				return
+			}
+			req.ProjectID = sim.ProjectID
+		}
+		h.enforce(c, Request{
+			Operation: OpAgentCreate,
+			ProjectID: req.ProjectID,
+			Agent:     json.RawMessage(body),
+		})
+	}
+}
+
+// DeleteAgent deve vir antes de DELETE /agents/:id: consulta os hooks do
+// projeto com o agente a remover.
+func (h *Handler) DeleteAgent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ag, err := h.agents.GetAgent(c.Request.Context(), c.Param("id"))
+		switch {
+		case errors.Is(err, agent.ErrNotFound):
+			c.Next()
+			return
+		case err != nil:
+			h.internalError(c, err)
+			c.Abort()
+			return
+		}
+		h.enforce(c, Request{
+			Operation: OpAgentDelete,
+			ProjectID: ag.ProjectID,
+			AgentID:   ag.ID,
+			Agent:     ag,
+		})
+	}
+}
+
+// enforce segue adiante se os hooks aprovam a operação. O veto responde
+// 422 com o motivo do hook; um hook fail-closed indisponível, 503.
+func (h *Handler) enforce(c *gin.Context, req Request) {
+	if req.ProjectID == "" {
+		c.Next()
+		return
+	}
+	if p := auth.FromGin(c); p != nil {
+		req.Subject = p.Subject
+	}
+	err := h.invoker.Invoke(c.Request.Context(), req)
+	var veto *VetoError
+	var unavailable *UnavailableError
+	switch {
+	case errors.As(err, &veto):
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
+			"error":        "operation rejected by sync hook: " + veto.Reason,
+			"reason":       veto.Reason,
+			"sync_hook_id": veto.HookID,
+			"hook_status":  veto.Status,
+		})
+	case errors.As(err, &unavailable):
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+			"error":        "sync hook is unavailable",
+			"sync_hook_id": unavailable.HookID,
+		})
+	case err != nil:
+		h.internalError(c, err)
+		c.Abort()
+	default:
+		c.Next()
+	}
+}
+
+func (h *Handler) validate(hook *Hook) error {
+	u, err := url.Parse(hook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid url %q: use an absolute http(s) URL", hook.URL)
+	}
+	if len(hook.Operations) == 0 {
+		return errors.New("operations must not be empty")
+	}
+	for _, op := range hook.Operations {
+		if !slices.Contains(Operations, op) {
+			return fmt.Errorf("invalid operation %q (use agent.create or agent.delete)", op)
+		}
+	}
+	if hook.TimeoutMs < 0 {
+		return errors.New("timeout_ms must not be negative")
+	}
+	if limit := h.invoker.cfg.MaxTimeout.Milliseconds(); int64(hook.TimeoutMs) > limit {
+		return fmt.Errorf("timeout_ms must be at most %d", limit)
+	}
+	if hook.FailMode != FailOpen && hook.FailMode != FailClosed {
+		return fmt.Errorf("invalid fail_mode %q (use open or closed)", hook.FailMode)
+	}
+	return nil
+}
+
+// load busca o hook de :id; um hook de projeto inacessível responde 404.
+func (h *Handler) load(c *gin.Context) (*Hook, bool) {
+	hook, err := h.repo.Get(c.Request.Context(), c.Param("id"))
+	if err == nil && !auth.FromGin(c).InProject(hook.ProjectID) {
+		err = ErrNotFound
+	}
+	if err != nil {
+		h.serviceError(c, err)
+		return nil, false
+	}
+	return hook, true
+}
+
+// accessible responde 403 se o projeto não é acessível à credencial.
+func (h *Handler) accessible(c *gin.Context, projectID string) bool {
+	if auth.FromGin(c).InProject(projectID) {
+		return true
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "project " + projectID + " is not accessible"})
+	return false
+}
+
+func (h *Handler) serviceError(c *gin.Context, err error) {
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	h.internalError(c, err)
+}
+
+func (h *Handler) internalError(c *gin.Context, err error) {
+	logging.FromContext(c.Request.Context()).WithError(err).Error("Erro no handler de hooks síncronos")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
+
+func newSecret() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,254 @@
+package synchook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/logging"
+	"smart-city-microservices/internal/outbound"
+	"smart-city-microservices/internal/webhook"
+)
+
+// Cabeçalhos enviados em cada chamada. A assinatura é a dos webhooks:
+// "sha256=" + hex(HMAC-SHA256(secret, timestamp + "." + corpo)).
+const (
+	HeaderSignature = "X-Sync-Hook-Signature"
+	HeaderTimestamp = "X-Sync-Hook-Timestamp"
+	HeaderOperation = "X-Sync-Hook-Operation"
+	HeaderRequest   = "X-Sync-Hook-Request"
+)
+
+// cacheTTL limita por quanto tempo a lista de hooks ativos é reaproveitada.
+const cacheTTL = 30 * time.Second
+
+// maxReason limita o corpo lido da resposta de veto.
+const maxReason = 4 << 10
+
+// Resultados de uma chamada, nas métricas.
+const (
+	resultAllowed      = "allowed"
+	resultVetoed       = "vetoed"
+	resultFailedOpen   = "failed_open"
+	resultFailedClosed = "failed_closed"
+)
+
+var callDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "agent_service",
+	Name:      "sync_hook_duration_seconds",
+	Help:      "Duração das chamadas aos hooks síncronos, por operação e resultado (allowed, vetoed, failed_open, failed_closed).",
+	Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+}, []string{"operation", "result"})
+
+// Config configura as chamadas aos hooks.
+type Config struct {
+	// DefaultTimeout vale para os hooks sem timeout_ms; MaxTimeout limita
+	// o de qualquer hook.
+	DefaultTimeout time.Duration
+	MaxTimeout     time.Duration
+	// AllowPrivateNetworks permite destinos em loopback e redes privadas.
+	AllowPrivateNetworks bool
+}
+
+// Invoker chama os hooks ativos do projeto antes de uma operação.
+type Invoker struct {
+	repo   *Repository
+	cfg    Config
+	client *http.Client
+
+	mu       sync.Mutex
+	cache    []*Hook
+	cachedAt time.Time
+}
+
+// NewInvoker cria o Invoker sobre o pool de saída. As chamadas são POST e
+// não são repetidas: um hook que não responde a tempo segue o modo de
+// falha.
+func NewInvoker(repo *Repository, cfg Config, pool *outbound.Pool) *Invoker {
+	return &Invoker{
+		repo: repo,
+		cfg:  cfg,
+		client: pool.Client(outbound.Options{
+			Name:                 "sync_hooks",
+			Timeout:              cfg.MaxTimeout,
+			AllowPrivateNetworks: cfg.AllowPrivateNetworks,
+			NoRedirects:          true,
+		}),
+	}
+}
+
+// Invalidate descarta a lista de hooks ativos em cache após alterações.
+func (inv *Invoker) Invalidate() {
+	inv.mu.Lock()
+	inv.cache = nil
+	inv.mu.Unlock()
+}
+
+// timeout é o timeout efetivo do hook.
+func (inv *Invoker) timeout(h *Hook) time.Duration {
+	d := inv.cfg.DefaultTimeout
+	if h.TimeoutMs > 0 {
+		d = time.Duration(h.TimeoutMs) * time.Millisecond
+	}
+	return min(d, inv.cfg.MaxTimeout)
+}
+
+type outcome struct {
+	hook   *Hook
+	status int
+	reason string
+	err    error
+}
+
+// Invoke chama, em paralelo, os hooks do projeto que tratam a operação, de
+// modo que a espera é a do hook mais lento e nunca passa de MaxTimeout.
+// Retorna *VetoError se algum recusou e *UnavailableError se um hook
+// fail-closed falhou; na ordem de cadastro, vale o primeiro.
+func (inv *Invoker) Invoke(ctx context.Context, req Request) error {
+	hooks, err := inv.active(ctx)
+	if err != nil {
+		return err
+	}
+	var matched []*Hook
+	for _, h := range hooks {
+		if h.ProjectID == req.ProjectID && h.Handles(req.Operation) {
+			matched = append(matched, h)
+		}
+	}
+	if len(matched) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	requestID := uuid.NewString()
+	results := make([]outcome, len(matched))
+	var wg sync.WaitGroup
+	for i, h := range matched {
+		wg.Add(1)
+		go func(i int, h *Hook) {
+			defer wg.Done()
+			results[i] = inv.call(ctx, h, req.Operation, requestID, body)
+		}(i, h)
+	}
+	wg.Wait()
+
+	for _, r := range results {
+		log := logging.FromContext(ctx).WithFields(logrus.Fields{
+			"sync_hook_id": r.hook.ID,
+			"operation":    req.Operation,
+			"project_id":   req.ProjectID,
+		})
+		switch {
+		case r.err == nil && r.status == 0:
+		case r.err == nil:
+			log.WithFields(logrus.Fields{"status": r.status, "reason": r.reason}).Info("Operação vetada por hook síncrono")
+			return &VetoError{HookID: r.hook.ID, Status: r.status, Reason: r.reason}
+		case r.hook.FailMode == FailClosed:
+			log.WithError(r.err).Warn("Hook síncrono indisponível; operação recusada (fail-closed)")
+			return &UnavailableError{HookID: r.hook.ID, Err: r.err}
+		default:
+			log.WithError(r.err).Warn("Hook síncrono indisponível; operação liberada (fail-open)")
+		}
+	}
+	return nil
+}
+
+// call chama o hook. status fica 0 quando o hook aprova; um status fora de
+// 2xx vem com o motivo. err são as falhas de rede e o timeout.
+func (inv *Invoker) call(ctx context.Context, h *Hook, op, requestID string, body []byte) (out outcome) {
+	out.hook = h
+	start := time.Now()
+	defer func() {
+		result := resultAllowed
+		switch {
+		case out.err != nil && h.FailMode == FailClosed:
+			result = resultFailedClosed
+		case out.err != nil:
+			result = resultFailedOpen
+		case out.status != 0:
+			result = resultVetoed
+		}
+		callDuration.WithLabelValues(op, result).Observe(time.Since(start).Seconds())
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, inv.timeout(h))
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		out.err = err
+		return out
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "smart-city-agent-service-sync-hooks")
+	req.Header.Set(HeaderOperation, op)
+	req.Header.Set(HeaderRequest, requestID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, webhook.Sign(h.Secret, timestamp, body))
+
+	resp, err := inv.client.Do(req)
+	if err != nil {
+		out.err = err
+		return out
+	}
+	defer resp.Body.Close()
+	// Um corpo cortado pelo timeout ainda serve de motivo.
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, maxReason))
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return out
+	}
+	out.status, out.reason = resp.StatusCode, reason(resp.StatusCode, data)
+	return out
+}
+
+// reason extrai o motivo do veto: o campo reason, error ou message de um
+// corpo JSON, ou o corpo em texto.
+func reason(status int, body []byte) string {
+	var v struct {
+		Reason  string `json:"reason"`
+		Error   string `json:"error"`
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(body, &v) == nil {
+		for _, s := range []string{v.Reason, v.Error, v.Message} {
+			if s != "" {
+				return s
+			}
+		}
+	}
+	if s := strings.TrimSpace(string(body)); s != "" && !strings.HasPrefix(s, "{") {
+		return s
+	}
+	return http.StatusText(status)
+}
+
+func (inv *Invoker) active(ctx context.Context) ([]*Hook, error) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	if inv.cache != nil && time.Since(inv.cachedAt) < cacheTTL {
+		return inv.cache, nil
+	}
+	hooks, err := inv.repo.ListActive(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if hooks == nil {
+		hooks = []*Hook{}
+	}
+	inv.cache, inv.cachedAt = hooks, time.Now()
+	return hooks, nil
+}
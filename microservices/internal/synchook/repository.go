@@ -0,0 +1,112 @@
+package synchook
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+
+	"smart-city-microservices/internal/instrument"
+)
+
+// Repository persiste os hooks síncronos no PostgreSQL.
+type Repository struct {
+	db *instrument.DB
+}
+
+// NewRepository cria o repositório.
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: instrument.NewDB(db)}
+}
+
+const hookColumns = `id, project_id, url, secret, operations, timeout_ms, fail_mode, active, created_at, updated_at`
+
+func scanHook(row interface{ Scan(...interface{}) error }) (*Hook, error) {
+	var h Hook
+	err := row.Scan(&h.ID, &h.ProjectID, &h.URL, &h.Secret, pq.Array(&h.Operations), &h.TimeoutMs, &h.FailMode,
+		&h.Active, &h.CreatedAt, &h.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return &h, err
+}
+
+// Create insere o hook e preenche id e datas.
+func (r *Repository) Create(ctx context.Context, h *Hook) error {
+	return r.db.QueryRow(ctx, "synchook.create", `
+		INSERT INTO sync_hooks (project_id, url, secret, operations, timeout_ms, fail_mode, active)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at, updated_at`,
+		h.ProjectID, h.URL, h.Secret, pq.Array(h.Operations), h.TimeoutMs, h.FailMode, h.Active,
+	).Scan(&h.ID, &h.CreatedAt, &h.UpdatedAt)
+}
+
+// Get busca um hook pelo id.
+func (r *Repository) Get(ctx context.Context, id string) (*Hook, error) {
+	return scanHook(r.db.QueryRow(ctx, "synchook.get",
+		`SELECT `+hookColumns+` FROM sync_hooks WHERE id = $1`, id))
+}
+
+// List retorna os hooks, filtrando por projeto quando informado.
+func (r *Repository) List(ctx context.Context, projectID string) ([]*Hook, error) {
+	return r.list(ctx, "synchook.list", `
+		SELECT `+hookColumns+` FROM sync_hooks
+		WHERE $1 = '' OR project_id = $1
+		ORDER BY created_at`, projectID)
+}
+
+// ListActive retorna os hooks ativos de todos os projetos, usados pelo
+// Invoker.
+func (r *Repository) ListActive(ctx context.Context) ([]*Hook, error) {
+	return r.list(ctx, "synchook.list_active",
+		`SELECT `+hookColumns+` FROM sync_hooks WHERE active ORDER BY created_at`)
+}
+
+func (r *Repository) list(ctx context.Context, name, query string, args ...interface{}) ([]*Hook, error) {
+	rows, err := r.db.Query(ctx, name, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*Hook
+	for rows.Next() {
+		h, err := scanHook(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, h)
+	}
+	return out, rows.Err()
+}
+
+// Update grava url, operações, timeout, modo de falha e estado.
+func (r *Repository) Update(ctx context.Context, h *Hook) error {
+	res, err := r.db.Exec(ctx, "synchook.update", `
+		UPDATE sync_hooks SET url = $2, operations = $3, timeout_ms = $4, fail_mode = $5, active = $6,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1`,
+		h.ID, h.URL, pq.Array(h.Operations), h.TimeoutMs, h.FailMode, h.Active)
+	return affected(res, err)
+}
+
+// Delete remove o hook.
+func (r *Repository) Delete(ctx context.Context, id string) error {
+	res, err := r.db.Exec(ctx, "synchook.delete", `DELETE FROM sync_hooks WHERE id = $1`, id)
+	return affected(res, err)
+}
+
+func affected(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+// Package synchook consulta sistemas externos (gestão de ativos,
+// provisionamento) de forma síncrona antes de criar ou remover agentes: cada
+// projeto cadastra hooks HTTP que recebem a operação e podem vetá-la.
+//
+// Ao contrário dos webhooks, que avisam depois do fato, o hook é chamado
+// antes de a operação seguir para o serviço de agentes: uma resposta fora de
+// 2xx a recusa com 422 e o motivo devolvido pelo hook. Falhas de rede e
+// timeouts seguem o modo do hook: fail-open deixa a operação passar e
+// fail-closed a recusa com 503. O hook aprova a operação pedida; o resultado
+// dela chega pelos webhooks (agent.created, agent.deleted).
+package synchook
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNotFound indica que o hook não existe.
+var ErrNotFound = errors.New("sync hook not found")
+
+// Operações que passam pelos hooks.
+const (
+	OpAgentCreate = "agent.create"
+	OpAgentDelete = "agent.delete"
+)
+
+// Operations são as operações aceitas em Hook.Operations.
+var Operations = []string{OpAgentCreate, OpAgentDelete}
+
+// Modos de falha de um hook inacessível ou que estoura o timeout.
+const (
+	FailOpen   = "open"
+	FailClosed = "closed"
+)
+
+// Hook é um hook síncrono de um projeto. O segredo só é devolvido na
+// criação. TimeoutMs 0 usa sync_hooks.default_timeout.
+type Hook struct {
+	ID         string    `json:"id"`
+	ProjectID  string    `json:"project_id"`
+	URL        string    `json:"url"`
+	Secret     string    `json:"secret,omitempty"`
+	Operations []string  `json:"operations"`
+	TimeoutMs  int       `json:"timeout_ms"`
+	FailMode   string    `json:"fail_mode"`
+	Active     bool      `json:"active"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Handles indica se o hook é chamado na operação.
+func (h *Hook) Handles(op string) bool {
+	for _, o := range h.Operations {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// Request é o corpo enviado ao hook. Agent é o corpo da criação ou o
+// agente a remover.
+type Request struct {
+	Operation string      `json:"operation"`
+	ProjectID string      `json:"project_id"`
+	AgentID   string      `json:"agent_id,omitempty"`
+	Agent     interface{} `json:"agent"`
+	Subject   string      `json:"subject,omitempty"`
+}
+
+// VetoError é a operação recusada por um hook.
+type VetoError struct {
+	HookID string
+	Status int
+	Reason string
+}
+
+func (e *VetoError) Error() string {
+	return fmt.Sprintf("rejected by sync hook %s (status %d): %s", e.HookID, e.Status, e.Reason)
+}
+
+// UnavailableError é a operação recusada por um hook fail-closed que não
+// respondeu a tempo ou estava inacessível.
+type UnavailableError struct {
+	HookID string
+	Err    error
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("sync hook %s is unavailable: %v", e.HookID, e.Err)
+}
+
+func (e *UnavailableError) Unwrap() error { return e.Err }
@@ -0,0 +1,50 @@
+package tiles
+
+// build codifica os agentes no tile; nil quando não há nenhum. Com cluster,
+// os agentes de cada célula de cellPx viram uma feature só, no centro
+// deles, com cluster=true e point_count; a célula com um agente só mantém o
+// agente.
+func build(t Tile, points []point, cluster bool, cellPx int) []byte {
+	if len(points) == 0 {
+		return nil
+	}
+	l := newLayer(Layer)
+	if !cluster {
+		for _, p := range points {
+			x, y := t.pixel(p.lon, p.lat)
+			l.point(x, y, p.attrs)
+		}
+		return l.encode()
+	}
+
+	type cell struct {
+		first      point
+		n          int
+		sumX, sumY int
+	}
+	size := max(1, cellPx*extent/256)
+	cells := map[[2]int]*cell{}
+	var order [][2]int
+	for _, p := range points {
+		x, y := t.pixel(p.lon, p.lat)
+		k := [2]int{x / size, y / size}
+		c := cells[k]
+		if c == nil {
+			c = &cell{first: p}
+			cells[k] = c
+			order = append(order, k)
+		}
+		c.n++
+		c.sumX += x
+		c.sumY += y
+	}
+	for _, k := range order {
+		c := cells[k]
+		if c.n == 1 {
+			l.point(c.sumX, c.sumY, c.first.attrs)
+			continue
+		}
+		l.point(c.sumX/c.n, c.sumY/c.n, []attr{{key: "cluster", value: true}, {key: "point_count", value: int64(c.n)}})
+	}
+	return l.encode()
+}
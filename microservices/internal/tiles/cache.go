@@ -0,0 +1,222 @@
+package tiles
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/logging"
+)
+
+// Chaves no Redis: o tile gerado, a versão de cada tile e a última posição
+// conhecida de cada agente, para invalidar o tile de onde ele saiu.
+const (
+	cachePrefix   = "agent-service:tiles:agents:"
+	versionPrefix = "agent-service:tiles:version:"
+	lastKey       = "agent-service:tiles:last"
+)
+
+var (
+	invalidated = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "tiles_invalidated_total",
+		Help:      "Versões de tiles avançadas pelos eventos de agentes.",
+	})
+	droppedEvents = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "tiles_dropped_events_total",
+		Help:      "Eventos de agentes descartados com a fila da invalidação dos tiles cheia.",
+	})
+)
+
+func tileKey(simulationID string, t Tile) string {
+	return fmt.Sprintf("%s:%d/%d/%d", simulationID, t.Z, t.X, t.Y)
+}
+
+// fingerprint resume a configuração que muda o conteúdo dos tiles; entra
+// na chave para as réplicas com configurações diferentes não trocarem
+// tiles entre si.
+func fingerprint(cfg Config) string {
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%s|%d|%s|%d|%d|%d", strings.Join(cfg.Attributes, ","), cfg.ThinBelowZoom, cfg.Thinning,
+		cfg.ClusterCellPx, cfg.SampleLimit, cfg.MaxFeatures)
+	return strconv.FormatUint(uint64(h.Sum32()), 36)
+}
+
+// Cache guarda os tiles gerados no Redis.
+type Cache struct {
+	redis redis.UniversalClient
+	ttl   time.Duration
+	fp    string
+}
+
+// NewCache cria o cache dos tiles.
+func NewCache(client redis.UniversalClient, cfg Config) *Cache {
+	return &Cache{redis: client, ttl: cfg.CacheTTL, fp: fingerprint(cfg)}
+}
+
+// key é a chave do tile na versão atual dele.
+func (c *Cache) key(ctx context.Context, simulationID string, t Tile) (string, error) {
+	k := tileKey(simulationID, t)
+	version, err := c.redis.Get(ctx, versionPrefix+k).Int64()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return "", err
+	}
+	return cachePrefix + k + ":" + c.fp + ":" + strconv.FormatInt(version, 10), nil
+}
+
+// Get retorna o tile guardado e se ele estava no cache; um tile vazio volta
+// como um corpo vazio.
+func (c *Cache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	body, err := c.redis.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return body, true, nil
+}
+
+// Set guarda o tile; body vazio guarda o tile vazio.
+func (c *Cache) Set(ctx context.Context, key string, body []byte) error {
+	return c.redis.Set(ctx, key, body, c.ttl).Err()
+}
+
+// position é a posição de um agente num evento; nil para o removido.
+type position struct {
+	simulationID string
+	lon, lat     float64
+}
+
+func (p position) String() string {
+	return p.simulationID + "," + strconv.FormatFloat(p.lon, 'f', -1, 64) + "," + strconv.FormatFloat(p.lat, 'f', -1, 64)
+}
+
+func parsePosition(s string) (position, bool) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 3 {
+		return position{}, false
+	}
+	lon, err1 := strconv.ParseFloat(parts[1], 64)
+	lat, err2 := strconv.ParseFloat(parts[2], 64)
+	return position{simulationID: parts[0], lon: lon, lat: lat}, err1 == nil && err2 == nil
+}
+
+// Invalidator avança a versão dos tiles afetados pelos eventos de agentes.
+type Invalidator struct {
+	redis  redis.UniversalClient
+	cfg    Config
+	events chan events.Event
+}
+
+// NewInvalidator cria a invalidação; o consumo roda em Run.
+func NewInvalidator(client redis.UniversalClient, cfg Config) *Invalidator {
+	return &Invalidator{redis: client, cfg: cfg, events: make(chan events.Event, cfg.QueueSize)}
+}
+
+// Handle recebe eventos do barramento sem bloquear o Publish; com a fila
+// cheia o evento é descartado e o tile vence com o TTL.
+func (inv *Invalidator) Handle(_ context.Context, e events.Event) {
+	switch e.Type {
+	case "agent.created", "agent.updated", "agent.deleted":
+	default:
+		return
+	}
+	select {
+	case inv.events <- e:
+	default:
+		droppedEvents.Inc()
+	}
+}
+
+// Run acumula os eventos e invalida os tiles a cada FlushInterval, até ctx
+// ser cancelado.
+func (inv *Invalidator) Run(ctx context.Context) error {
+	work := logging.Background(ctx, "tile-invalidation")
+	ticker := time.NewTicker(inv.cfg.FlushInterval)
+	defer ticker.Stop()
+	pending := map[string]*position{}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e := <-inv.events:
+			var data events.AgentV1
+			if err := e.Decode(&data); err != nil || data.ID == "" {
+				continue
+			}
+			if e.Type == "agent.deleted" {
+				pending[data.ID] = nil
+				continue
+			}
+			pending[data.ID] = &position{simulationID: data.SimulationID, lon: data.Position.Lon, lat: data.Position.Lat}
+		case <-ticker.C:
+			if len(pending) == 0 {
+				continue
+			}
+			if err := inv.flush(work, pending); err != nil {
+				logging.FromContext(work).WithError(err).WithField("agents", len(pending)).Warn("Falha ao invalidar os tiles dos agentes")
+			}
+			pending = map[string]*position{}
+		}
+	}
+}
+
+// flush avança, em todos os zooms cacheados, a versão do tile da posição
+// anterior e do da nova de cada agente, e grava as novas posições.
+func (inv *Invalidator) flush(ctx context.Context, pending map[string]*position) error {
+	ids := make([]string, 0, len(pending))
+	for id := range pending {
+		ids = append(ids, id)
+	}
+	previous, err := inv.redis.HMGet(ctx, lastKey, ids...).Result()
+	if err != nil {
+		return err
+	}
+
+	dirty := map[string]struct{}{}
+	mark := func(p position) {
+		for z := 0; z <= inv.cfg.CacheMaxZoom; z++ {
+			dirty[tileKey(p.simulationID, At(z, p.lon, p.lat))] = struct{}{}
+		}
+	}
+	pipe := inv.redis.Pipeline()
+	for i, id := range ids {
+		if s, ok := previous[i].(string); ok {
+			if prev, ok := parsePosition(s); ok {
+				mark(prev)
+			}
+		}
+		p := pending[id]
+		if p == nil {
+			pipe.HDel(ctx, lastKey, id)
+			continue
+		}
+		if p.simulationID == "" {
+			continue
+		}
+		mark(*p)
+		pipe.HSet(ctx, lastKey, id, p.String())
+	}
+	for k := range dirty {
+		pipe.Incr(ctx, versionPrefix+k)
+		// A versão vive mais que os tiles dela: uma versão perdida antes
+		// deles voltaria a apontar para um tile velho.
+		pipe.Expire(ctx, versionPrefix+k, 2*inv.cfg.CacheTTL)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	invalidated.Add(float64(len(dirty)))
+	return nil
+}
@@ -0,0 +1,145 @@
+package tiles
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/logging"
+)
+
+var requests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "agent_service",
+	Name:      "tiles_requests_total",
+	Help:      "Tiles de agentes pedidos, por resultado (hit, miss, empty, uncached).",
+}, []string{"result"})
+
+// SimulationGetter busca a simulação do tile; agent.Service a implementa.
+type SimulationGetter interface {
+	GetSimulation(ctx context.Context, id string) (*agent.Simulation, error)
+}
+
+// Handler serve os tiles dos agentes.
+type Handler struct {
+	repo   *Repository
+	agents SimulationGetter
+	cache  *Cache
+	cfg    Config
+}
+
+// NewHandler cria o handler dos tiles.
+func NewHandler(repo *Repository, agents SimulationGetter, client redis.UniversalClient, cfg Config) *Handler {
+	return &Handler{repo: repo, agents: agents, cache: NewCache(client, cfg), cfg: cfg}
+}
+
+// Agents responde GET /tiles/agents/:z/:x/:y.mvt?simulation_id=...: os
+// agentes da simulação no tile, na camada agents; 204 sem nenhum.
+func (h *Handler) Agents(c *gin.Context) {
+	t, ok := h.parseTile(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid tile coordinates"})
+		return
+	}
+	simulationID := c.Query("simulation_id")
+	if simulationID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "simulation_id is required"})
+		return
+	}
+	ctx := c.Request.Context()
+	sim, err := h.agents.GetSimulation(ctx, simulationID)
+	if errors.Is(err, agent.ErrNotFound) || (err == nil && !auth.FromGin(c).InProject(sim.ProjectID)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "simulation not found"})
+		return
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+
+	// A chave sai da versão lida antes dos agentes: uma invalidação durante
+	// a leitura deixa o tile gerado na versão velha, que ninguém mais lê.
+	key := ""
+	if t.Z <= h.cfg.CacheMaxZoom {
+		if key, err = h.cache.key(ctx, sim.ID, t); err != nil {
+			logging.FromContext(ctx).WithError(err).Warn("Falha ao ler a versão do tile no cache")
+			key = ""
+		}
+	}
+	if key != "" {
+		body, ok, err := h.cache.Get(ctx, key)
+		if err != nil {
+			logging.FromContext(ctx).WithError(err).Warn("Falha ao ler o tile do cache")
+		}
+		if ok {
+			requests.WithLabelValues("hit").Inc()
+			h.write(c, body)
+			return
+		}
+	}
+
+	thin := t.Z < h.cfg.ThinBelowZoom
+	sample := thin && h.cfg.Thinning == ThinSample
+	limit := h.cfg.MaxFeatures
+	if sample {
+		limit = h.cfg.SampleLimit
+	}
+	points, err := h.repo.Points(ctx, sim.ID, t, h.cfg.Attributes, limit, sample)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	body := build(t, points, thin && h.cfg.Thinning == ThinCluster, h.cfg.ClusterCellPx)
+	switch {
+	case key == "":
+		requests.WithLabelValues("uncached").Inc()
+	case len(body) == 0:
+		requests.WithLabelValues("empty").Inc()
+	default:
+		requests.WithLabelValues("miss").Inc()
+	}
+	if key != "" {
+		if err := h.cache.Set(ctx, key, body); err != nil {
+			logging.FromContext(ctx).WithError(err).Warn("Falha ao gravar o tile no cache")
+		}
+	}
+	h.write(c, body)
+}
+
+// parseTile lê z, x e y da rota; y termina em .mvt.
+func (h *Handler) parseTile(c *gin.Context) (Tile, bool) {
+	y, ok := strings.CutSuffix(c.Param("y"), ".mvt")
+	if !ok {
+		return Tile{}, false
+	}
+	var t Tile
+	var err1, err2, err3 error
+	t.Z, err1 = strconv.Atoi(c.Param("z"))
+	t.X, err2 = strconv.Atoi(c.Param("x"))
+	t.Y, err3 = strconv.Atoi(y)
+	if err1 != nil || err2 != nil || err3 != nil || t.Z > h.cfg.MaxZoom {
+		return Tile{}, false
+	}
+	return t, t.Valid()
+}
+
+func (h *Handler) write(c *gin.Context, body []byte) {
+	if len(body) == 0 {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	c.Data(http.StatusOK, MediaType, body)
+}
+
+func (h *Handler) internalError(c *gin.Context, err error) {
+	logging.FromContext(c.Request.Context()).WithError(err).Error("Erro no handler de tiles")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
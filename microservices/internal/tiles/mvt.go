@@ -0,0 +1,139 @@
+package tiles
+
+import (
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Campos do vector_tile.proto (MVT 2.1) usados aqui.
+const (
+	tileLayers = 3
+
+	layerName     = 1
+	layerFeatures = 2
+	layerKeys     = 3
+	layerValues   = 4
+	layerExtent   = 5
+	layerVersion  = 15
+
+	featureTags     = 2
+	featureType     = 3
+	featureGeometry = 4
+
+	valueString = 1
+	valueDouble = 3
+	valueInt    = 4
+	valueBool   = 7
+
+	geomPoint = 1
+	cmdMoveTo = 1
+)
+
+// attr é um atributo de uma feature: string, float64, int64 ou bool.
+type attr struct {
+	key   string
+	value interface{}
+}
+
+// layer monta uma camada de pontos, com as chaves e os valores repetidos
+// gravados uma vez só.
+type layer struct {
+	name     string
+	keys     []string
+	keyIdx   map[string]uint64
+	values   [][]byte
+	valueIdx map[interface{}]uint64
+	features [][]byte
+}
+
+func newLayer(name string) *layer {
+	return &layer{name: name, keyIdx: map[string]uint64{}, valueIdx: map[interface{}]uint64{}}
+}
+
+func (l *layer) key(k string) uint64 {
+	i, ok := l.keyIdx[k]
+	if !ok {
+		i = uint64(len(l.keys))
+		l.keys = append(l.keys, k)
+		l.keyIdx[k] = i
+	}
+	return i
+}
+
+// value grava o valor, distinguindo os tipos: "1" e 1 são valores
+// diferentes.
+func (l *layer) value(v interface{}) uint64 {
+	i, ok := l.valueIdx[v]
+	if ok {
+		return i
+	}
+	var b []byte
+	switch v := v.(type) {
+	case string:
+		b = protowire.AppendTag(b, valueString, protowire.BytesType)
+		b = protowire.AppendString(b, v)
+	case float64:
+		b = protowire.AppendTag(b, valueDouble, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(v))
+	case int64:
+		b = protowire.AppendTag(b, valueInt, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(v))
+	case bool:
+		b = protowire.AppendTag(b, valueBool, protowire.VarintType)
+		b = protowire.AppendVarint(b, protowire.EncodeBool(v))
+	}
+	i = uint64(len(l.values))
+	l.values = append(l.values, b)
+	l.valueIdx[v] = i
+	return i
+}
+
+// point acrescenta uma feature de ponto em (x, y), nas coordenadas do tile.
+func (l *layer) point(x, y int, attrs []attr) {
+	var tags []byte
+	for _, a := range attrs {
+		tags = protowire.AppendVarint(tags, l.key(a.key))
+		tags = protowire.AppendVarint(tags, l.value(a.value))
+	}
+	var geom []byte
+	geom = protowire.AppendVarint(geom, cmdMoveTo|1<<3)
+	geom = protowire.AppendVarint(geom, protowire.EncodeZigZag(int64(x)))
+	geom = protowire.AppendVarint(geom, protowire.EncodeZigZag(int64(y)))
+
+	var f []byte
+	f = protowire.AppendTag(f, featureTags, protowire.BytesType)
+	f = protowire.AppendBytes(f, tags)
+	f = protowire.AppendTag(f, featureType, protowire.VarintType)
+	f = protowire.AppendVarint(f, geomPoint)
+	f = protowire.AppendTag(f, featureGeometry, protowire.BytesType)
+	f = protowire.AppendBytes(f, geom)
+	l.features = append(l.features, f)
+}
+
+// encode serializa a camada como um Tile de uma camada só.
+func (l *layer) encode() []byte {
+	var b []byte
+	b = protowire.AppendTag(b, layerVersion, protowire.VarintType)
+	b = protowire.AppendVarint(b, 2)
+	b = protowire.AppendTag(b, layerName, protowire.BytesType)
+	b = protowire.AppendString(b, l.name)
+	for _, f := range l.features {
+		b = protowire.AppendTag(b, layerFeatures, protowire.BytesType)
+		b = protowire.AppendBytes(b, f)
+	}
+	for _, k := range l.keys {
+		b = protowire.AppendTag(b, layerKeys, protowire.BytesType)
+		b = protowire.AppendString(b, k)
+	}
+	for _, v := range l.values {
+		b = protowire.AppendTag(b, layerValues, protowire.BytesType)
+		b = protowire.AppendBytes(b, v)
+	}
+	b = protowire.AppendTag(b, layerExtent, protowire.VarintType)
+	b = protowire.AppendVarint(b, extent)
+
+	var tile []byte
+	tile = protowire.AppendTag(tile, tileLayers, protowire.BytesType)
+	return protowire.AppendBytes(tile, b)
+}
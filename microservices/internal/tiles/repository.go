@@ -0,0 +1,98 @@
+package tiles
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"smart-city-microservices/internal/instrument"
+)
+
+// attributeColumns são os atributos aceitos em tiles.attributes e a coluna
+// de cada um. energy é número; os demais, texto.
+var attributeColumns = map[string]string{
+	"id":            "a.id::text",
+	"type":          "a.agent_type",
+	"name":          "COALESCE(a.name, '')",
+	"status":        "COALESCE(a.state->>'status', '')",
+	"energy":        "COALESCE(a.energy, 0)::float8",
+	"simulation_id": "a.simulation_id::text",
+}
+
+// AttributeNames são os atributos aceitos em tiles.attributes.
+var AttributeNames = []string{"id", "type", "name", "status", "energy", "simulation_id"}
+
+// point é um agente lido para um tile.
+type point struct {
+	lon, lat float64
+	attrs    []attr
+}
+
+// Repository lê os agentes dos tiles direto de agents, pelo índice GIST de
+// position, que guarda (lon, lat).
+type Repository struct {
+	db *instrument.DB
+}
+
+// NewRepository cria o repositório dos tiles.
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: instrument.NewDB(db)}
+}
+
+// Points lê até limit agentes da simulação no retângulo, com os atributos
+// attrs. Com sample, os agentes mantidos são os de menor hash do id, os
+// mesmos em todos os tiles e zooms.
+func (r *Repository) Points(ctx context.Context, simulationID string, t Tile, attrs []string, limit int, sample bool) ([]point, error) {
+	cols := make([]string, len(attrs))
+	for i, a := range attrs {
+		col, ok := attributeColumns[a]
+		if !ok {
+			return nil, fmt.Errorf("tiles: atributo desconhecido %q", a)
+		}
+		cols[i] = col
+	}
+	query := `SELECT a.position[0], a.position[1]`
+	if len(cols) > 0 {
+		query += ", " + strings.Join(cols, ", ")
+	}
+	query += ` FROM agents a
+		WHERE a.simulation_id = $1 AND a.position <@ box(point($2, $3), point($4, $5))`
+	if sample {
+		query += ` ORDER BY hashtext(a.id::text)`
+	}
+	query += ` LIMIT $6`
+	minLon, minLat, maxLon, maxLat := t.Bounds()
+	rows, err := r.db.Query(ctx, "tiles.points", query, simulationID, minLon, minLat, maxLon, maxLat, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []point
+	for rows.Next() {
+		p := point{attrs: make([]attr, len(attrs))}
+		dest := make([]interface{}, 2+len(attrs))
+		dest[0], dest[1] = &p.lon, &p.lat
+		texts := make([]string, len(attrs))
+		numbers := make([]float64, len(attrs))
+		for i, a := range attrs {
+			if a == "energy" {
+				dest[2+i] = &numbers[i]
+			} else {
+				dest[2+i] = &texts[i]
+			}
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		for i, a := range attrs {
+			p.attrs[i] = attr{key: a, value: texts[i]}
+			if a == "energy" {
+				p.attrs[i].value = numbers[i]
+			}
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,122 @@
+// Package tiles serve as posições dos agentes de uma simulação como vector
+// tiles do Mapbox (MVT 2.1), para os mapas com dezenas de milhares de
+// agentes, que o GeoJSON não comporta no navegador.
+//
+// Cada tile (GET /api/v1/tiles/agents/{z}/{x}/{y}.mvt) lê só os agentes no
+// retângulo dele, pelo índice GIST de agents.position, e os codifica na
+// camada agents com os atributos de tiles.attributes. Abaixo de
+// tiles.thin_below_zoom os agentes são agrupados numa grade (cluster, com
+// point_count) ou amostrados de forma estável (sample), para o tile não
+// crescer com o zoom afastado. Tiles vazios respondem 204.
+//
+// Os tiles até tiles.cache_max_zoom ficam no Redis. Cada tile tem uma
+// versão, que entra na chave do cache; os eventos de agentes avançam a
+// versão dos tiles onde o agente estava e onde está, em todos os zooms
+// cacheados, e a leitura seguinte gera o tile de novo. As alterações que não
+// passam pelo barramento desta réplica vencem com tiles.cache_ttl.
+package tiles
+
+import (
+	"math"
+	"time"
+)
+
+// Layer é o nome da camada dos agentes.
+const Layer = "agents"
+
+// MediaType é o tipo de conteúdo dos tiles.
+const MediaType = "application/vnd.mapbox-vector-tile"
+
+// extent é a resolução das coordenadas dentro do tile (o padrão do MVT).
+const extent = 4096
+
+// maxLat é a latitude limite da projeção Web Mercator.
+const maxLat = 85.05112878
+
+// Modos de desbaste dos zooms afastados.
+const (
+	ThinCluster = "cluster"
+	ThinSample  = "sample"
+)
+
+// Config configura os tiles.
+type Config struct {
+	// MaxZoom é o maior zoom servido.
+	MaxZoom int
+	// CacheMaxZoom é o maior zoom guardado no Redis; os tiles acima leem
+	// poucos agentes e não entram no cache.
+	CacheMaxZoom int
+	// ThinBelowZoom é o zoom abaixo do qual os agentes são desbastados com
+	// Thinning (ThinCluster ou ThinSample).
+	ThinBelowZoom int
+	Thinning      string
+	// ClusterCellPx é o lado da célula do agrupamento, em pixels de um tile
+	// de 256.
+	ClusterCellPx int
+	// SampleLimit são os agentes mantidos num tile amostrado.
+	SampleLimit int
+	// MaxFeatures limita os agentes lidos por tile.
+	MaxFeatures int
+	// Attributes são os atributos dos agentes nos tiles (ver AttributeNames).
+	Attributes []string
+	// CacheTTL é a validade de um tile no cache.
+	CacheTTL time.Duration
+	// FlushInterval é o intervalo em que os eventos acumulados invalidam os
+	// tiles; QueueSize limita os eventos à espera.
+	FlushInterval time.Duration
+	QueueSize     int
+}
+
+// Tile é um tile z/x/y do esquema XYZ (origem no noroeste).
+type Tile struct {
+	Z, X, Y int
+}
+
+// Valid diz se x e y existem no zoom z.
+func (t Tile) Valid() bool {
+	if t.Z < 0 || t.Z > 30 {
+		return false
+	}
+	n := 1 << t.Z
+	return t.X >= 0 && t.X < n && t.Y >= 0 && t.Y < n
+}
+
+// Bounds é o retângulo do tile em graus.
+func (t Tile) Bounds() (minLon, minLat, maxLon, maxLat float64) {
+	n := float64(int(1) << t.Z)
+	minLon = float64(t.X)/n*360 - 180
+	maxLon = float64(t.X+1)/n*360 - 180
+	maxLat = tileLat(float64(t.Y), n)
+	minLat = tileLat(float64(t.Y+1), n)
+	return minLon, minLat, maxLon, maxLat
+}
+
+func tileLat(y, n float64) float64 {
+	return math.Atan(math.Sinh(math.Pi*(1-2*y/n))) * 180 / math.Pi
+}
+
+// world é a posição, em tiles do zoom z, de (lon, lat) na Web Mercator.
+func world(z int, lon, lat float64) (float64, float64) {
+	n := float64(int(1) << z)
+	lat = math.Max(-maxLat, math.Min(maxLat, lat))
+	rad := lat * math.Pi / 180
+	x := (lon + 180) / 360 * n
+	y := (1 - math.Log(math.Tan(rad)+1/math.Cos(rad))/math.Pi) / 2 * n
+	return x, y
+}
+
+// At é o tile do zoom z que contém (lon, lat).
+func At(z int, lon, lat float64) Tile {
+	x, y := world(z, lon, lat)
+	n := 1 << z
+	clamp := func(v float64) int {
+		return max(0, min(n-1, int(math.Floor(v))))
+	}
+	return Tile{Z: z, X: clamp(x), Y: clamp(y)}
+}
+
+// pixel é a posição de (lon, lat) nas coordenadas do tile, de 0 a extent.
+func (t Tile) pixel(lon, lat float64) (int, int) {
+	x, y := world(t.Z, lon, lat)
+	return int(math.Round((x - float64(t.X)) * extent)), int(math.Round((y - float64(t.Y)) * extent))
+}
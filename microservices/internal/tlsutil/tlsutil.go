@@ -0,0 +1,197 @@
+// Package tlsutil monta as configurações TLS do servidor HTTP (com recarga de
+// certificado e mTLS opcional) e das conexões de saída.
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+)
+
+// Modos de verificação de certificado de cliente.
+const (
+	ClientAuthNone    = "none"
+	ClientAuthRequest = "request"
+	ClientAuthRequire = "require"
+)
+
+// ServerOptions configura o TLS do servidor.
+type ServerOptions struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+	ClientAuth   string
+}
+
+// CertReloader mantém o par certificado/chave atual e o recarrega quando os
+// arquivos mudam (rotação pelo cert-manager), preservando o anterior em caso de erro.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	watcher *fsnotify.Watcher
+}
+
+// NewCertReloader carrega o certificado inicial.
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *CertReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("carregar certificado TLS: %w", err)
+	}
+	r.mu.Lock()
+	r.cert = &cert
+	r.mu.Unlock()
+	return nil
+}
+
+// GetCertificate implementa tls.Config.GetCertificate.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Watch observa os diretórios dos arquivos (o cert-manager troca symlinks,
+// então observar o arquivo em si perde eventos).
+func (r *CertReloader) Watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	dirs := map[string]struct{}{filepath.Dir(r.certFile): {}, filepath.Dir(r.keyFile): {}}
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return err
+		}
+	}
+	r.watcher = watcher
+
+	go func() {
+		for {
+			select {
+			case ev, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if ev.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+					continue
+				}
+				if err := r.reload(); err != nil {
+					logrus.WithError(err).Warn("Falha ao recarregar certificado TLS; mantendo o anterior")
+					continue
+				}
+				logrus.WithField("cert_file", r.certFile).Info("Certificado TLS recarregado")
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logrus.WithError(err).Warn("Erro ao observar certificado TLS")
+			}
+		}
+	}()
+	return nil
+}
+
+// Close encerra a observação dos arquivos.
+func (r *CertReloader) Close() error {
+	if r.watcher == nil {
+		return nil
+	}
+	return r.watcher.Close()
+}
+
+// ServerConfig cria o tls.Config do servidor com recarga de certificado.
+func ServerConfig(opts ServerOptions) (*tls.Config, *CertReloader, error) {
+	reloader, err := NewCertReloader(opts.CertFile, opts.KeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+
+	switch opts.ClientAuth {
+	case "", ClientAuthNone:
+		cfg.ClientAuth = tls.NoClientCert
+	case ClientAuthRequest:
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	case ClientAuthRequire:
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, nil, fmt.Errorf("client_auth inválido: %q", opts.ClientAuth)
+	}
+	if cfg.ClientAuth != tls.NoClientCert {
+		pool, err := LoadCertPool(opts.ClientCAFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		cfg.ClientCAs = pool
+	}
+	return cfg, reloader, nil
+}
+
+// ClientOptions configura o TLS de uma conexão de saída.
+type ClientOptions struct {
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	ServerName         string
+	InsecureSkipVerify bool
+}
+
+// ClientConfig cria o tls.Config de uma conexão de saída (Redis, serviços irmãos).
+func ClientConfig(opts ClientOptions) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         opts.ServerName,
+		InsecureSkipVerify: opts.InsecureSkipVerify, //nolint:gosec // opt-in explícito para ambientes de teste
+	}
+	if opts.CAFile != "" {
+		pool, err := LoadCertPool(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("carregar certificado de cliente: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// LoadCertPool lê um bundle PEM de CAs.
+func LoadCertPool(file string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("ler CA %s: %w", file, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("nenhum certificado válido em %s", file)
+	}
+	return pool, nil
+}
@@ -0,0 +1,365 @@
+package trajectory
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/lease"
+	"smart-city-microservices/internal/logging"
+	"smart-city-microservices/internal/supervisor"
+)
+
+// compactionLeaderKey guarda a réplica que compacta as trajetórias; só
+// uma compacta por vez.
+const compactionLeaderKey = "agent-service:trajectories:compaction"
+
+var compactedSamples = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "agent_service",
+	Name:      "trajectory_compaction_samples_total",
+	Help:      "Amostras de posição lidas (read) e gravadas (written) pela compactação das trajetórias.",
+}, []string{"result"})
+
+// Métodos de compactação.
+const (
+	// MethodInterval mantém a primeira amostra de cada intervalo de
+	// Resolution.
+	MethodInterval = "interval"
+	// MethodRDP simplifica o caminho com Douglas-Peucker, sem afastar
+	// nenhum ponto descartado mais que ToleranceM metros do que resta.
+	MethodRDP = "rdp"
+)
+
+// Situações de um dia na compactação.
+const (
+	CompactionRunning = "running"
+	CompactionDone    = "done"
+)
+
+// CompactionConfig configura a compactação das trajetórias.
+type CompactionConfig struct {
+	// Interval é o intervalo entre os ciclos.
+	Interval time.Duration
+	// Age é a idade a partir da qual um dia é compactado: todo o dia tem
+	// de ser mais antigo que ela.
+	Age         time.Duration
+	Method      string
+	Resolution  time.Duration
+	ToleranceM  float64
+	ChunkAgents int
+	// Retention é a janela de agent_positions_compacted; zero mantém tudo.
+	Retention time.Duration
+	// SampleInterval é a resolução das amostras ainda não compactadas,
+	// mostrada no progresso.
+	SampleInterval time.Duration
+}
+
+// CompactionDay é o progresso da compactação de um dia (UTC).
+type CompactionDay struct {
+	Day          string     `json:"day"`
+	Method       string     `json:"method"`
+	ResolutionMs int64      `json:"resolution_ms,omitempty"`
+	ToleranceM   float64    `json:"tolerance_m,omitempty"`
+	Status       string     `json:"status"`
+	LastAgentID  string     `json:"last_agent_id,omitempty"`
+	Agents       int64      `json:"agents"`
+	SamplesIn    int64      `json:"samples_in"`
+	SamplesOut   int64      `json:"samples_out"`
+	StartedAt    time.Time  `json:"started_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty"`
+}
+
+// reduce é a redução do caminho de um agente com o método do dia.
+func (d *CompactionDay) reduce(path []Point) []Point {
+	if d.Method == MethodRDP {
+		return SimplifyWithin(path, d.ToleranceM)
+	}
+	return Downsample(path, time.Duration(d.ResolutionMs)*time.Millisecond)
+}
+
+// Downsample mantém a primeira amostra de cada intervalo de resolution,
+// contado a partir da meia-noite UTC.
+func Downsample(points []Point, resolution time.Duration) []Point {
+	if resolution <= 0 || len(points) == 0 {
+		return points
+	}
+	out := []Point{points[0]}
+	last := points[0].RecordedAt.Truncate(resolution)
+	for _, p := range points[1:] {
+		if bucket := p.RecordedAt.Truncate(resolution); bucket.After(last) {
+			out = append(out, p)
+			last = bucket
+		}
+	}
+	return out
+}
+
+// Range é um intervalo de tempo em que as trajetórias têm a mesma
+// resolução. Method é raw para as amostras ainda não compactadas, o método
+// usado para as compactadas ou partial para o dia em compactação, em que
+// parte dos agentes já foi compactada.
+type Range struct {
+	From         time.Time `json:"from"`
+	To           time.Time `json:"to"`
+	Method       string    `json:"method"`
+	ResolutionMs int64     `json:"resolution_ms,omitempty"`
+	ToleranceM   float64   `json:"tolerance_m,omitempty"`
+}
+
+// CompactionStatus é o progresso exposto em GET /admin/jobs/compaction.
+type CompactionStatus struct {
+	Method       string  `json:"method"`
+	ResolutionMs int64   `json:"resolution_ms,omitempty"`
+	ToleranceM   float64 `json:"tolerance_m,omitempty"`
+	AgeSeconds   int64   `json:"age_seconds"`
+	// Leader é a réplica que compacta, se alguma está compactando.
+	Leader string `json:"leader,omitempty"`
+	// PendingDays são os dias já com idade para compactar que ainda não
+	// terminaram.
+	PendingDays []string         `json:"pending_days"`
+	Days        []*CompactionDay `json:"days"`
+	Ranges      []Range          `json:"ranges"`
+}
+
+// Compactor reamostra os dias mais antigos de agent_positions para
+// agent_positions_compacted, um lote de agentes por transação, e apaga as
+// amostras originais. O cursor de cada dia fica em trajectory_compactions,
+// e a compactação interrompida continua de onde parou.
+type Compactor struct {
+	repo   *Repository
+	redis  redis.UniversalClient
+	leader *lease.Lease
+	cfg    CompactionConfig
+}
+
+// NewCompactor cria a compactação. id identifica a réplica na disputa
+// pela compactação; o ciclo roda em Run.
+func NewCompactor(repo *Repository, client redis.UniversalClient, cfg CompactionConfig, id string) *Compactor {
+	return &Compactor{repo: repo, redis: client, leader: lease.New(client, compactionLeaderKey, id), cfg: cfg}
+}
+
+// Run compacta a cada intervalo até ctx ser cancelado. O cancelamento
+// interrompe o ciclo entre dois lotes, e o lote em andamento termina.
+func (c *Compactor) Run(ctx context.Context) error {
+	work := logging.Background(context.WithoutCancel(ctx), "trajectory-compaction")
+	defer c.release(work)
+	ticker := time.NewTicker(c.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		c.cycle(ctx, work)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// release remove a chave só se ainda for desta réplica.
+func (c *Compactor) release(ctx context.Context) {
+	ctx, cancel := supervisor.Cleanup(ctx)
+	defer cancel()
+	c.leader.Release(ctx)
+}
+
+// lead disputa a compactação, ou a renova se já é desta réplica.
+func (c *Compactor) lead(ctx context.Context) (bool, error) {
+	return c.leader.Acquire(ctx, 3*c.cfg.Interval)
+}
+
+func (c *Compactor) cycle(ctx, work context.Context) {
+	log := logging.FromContext(work)
+	if leader, err := c.lead(work); err != nil {
+		log.WithError(err).Warn("Falha ao disputar a compactação das trajetórias")
+		return
+	} else if !leader {
+		return
+	}
+	now := time.Now().UTC()
+	if c.cfg.Retention > 0 {
+		if err := c.repo.PruneCompactions(work, now.Add(-c.cfg.Retention)); err != nil {
+			log.WithError(err).Warn("Falha ao remover o progresso de dias fora da retenção")
+		}
+	}
+	days, err := c.pending(work, now)
+	if err != nil {
+		log.WithError(err).Error("Falha ao listar os dias a compactar")
+		return
+	}
+	for _, day := range days {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := c.compactDay(ctx, work, day); err != nil {
+			log.WithError(err).WithField("day", day.Format("2006-01-02")).Error("Falha ao compactar as trajetórias do dia")
+			return
+		}
+	}
+}
+
+// pending retorna os dias de agent_positions já com idade para compactar
+// e que ainda não terminaram, do mais antigo para o mais novo.
+func (c *Compactor) pending(ctx context.Context, now time.Time) ([]time.Time, error) {
+	raw, err := c.repo.RawDays(ctx)
+	if err != nil {
+		return nil, err
+	}
+	list, err := c.repo.Compactions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.pendingDays(raw, list, now), nil
+}
+
+func (c *Compactor) pendingDays(raw []time.Time, list []*CompactionDay, now time.Time) []time.Time {
+	done := map[string]bool{}
+	for _, d := range list {
+		done[d.Day] = d.Status == CompactionDone
+	}
+	var out []time.Time
+	for _, day := range raw {
+		if day.Add(24 * time.Hour).After(now.Add(-c.cfg.Age)) {
+			continue
+		}
+		if !done[day.Format("2006-01-02")] {
+			out = append(out, day)
+		}
+	}
+	return out
+}
+
+// compactDay compacta o dia a partir do cursor gravado, um lote de
+// agentes por vez, renovando a compactação entre os lotes.
+func (c *Compactor) compactDay(ctx, work context.Context, day time.Time) error {
+	d, err := c.repo.StartCompaction(work, day, CompactionDay{
+		Method:       c.cfg.Method,
+		ResolutionMs: c.cfg.Resolution.Milliseconds(),
+		ToleranceM:   c.cfg.ToleranceM,
+	})
+	if err != nil {
+		return err
+	}
+	log := logging.FromContext(work).WithFields(logrus.Fields{"day": d.Day, "method": d.Method})
+	if d.LastAgentID != "" {
+		log.WithField("after_agent_id", d.LastAgentID).Info("Retomando a compactação das trajetórias do dia")
+	}
+	cursor := d.LastAgentID
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if leader, err := c.lead(work); err != nil || !leader {
+			return err
+		}
+		agents, err := c.repo.NextAgents(work, day, cursor, c.cfg.ChunkAgents)
+		if err != nil {
+			return err
+		}
+		if len(agents) == 0 {
+			break
+		}
+		in, out, err := c.repo.CompactChunk(work, day, agents, d.reduce)
+		if err != nil {
+			return err
+		}
+		compactedSamples.WithLabelValues("read").Add(float64(in))
+		compactedSamples.WithLabelValues("written").Add(float64(out))
+		d.Agents += int64(len(agents))
+		d.SamplesIn += int64(in)
+		d.SamplesOut += int64(out)
+		cursor = agents[len(agents)-1]
+	}
+	if err := c.repo.FinishCompaction(work, day); err != nil {
+		return err
+	}
+	log.WithFields(logrus.Fields{"agents": d.Agents, "samples_in": d.SamplesIn, "samples_out": d.SamplesOut}).
+		Info("Trajetórias do dia compactadas")
+	return nil
+}
+
+// Status monta o progresso da compactação e a resolução efetiva das
+// trajetórias por intervalo de tempo.
+func (c *Compactor) Status(ctx context.Context) (*CompactionStatus, error) {
+	now := time.Now().UTC()
+	raw, err := c.repo.RawDays(ctx)
+	if err != nil {
+		return nil, err
+	}
+	days, err := c.repo.Compactions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	st := &CompactionStatus{
+		Method:      c.cfg.Method,
+		AgeSeconds:  int64(c.cfg.Age.Seconds()),
+		PendingDays: []string{},
+		Days:        days,
+	}
+	if c.cfg.Method == MethodRDP {
+		st.ToleranceM = c.cfg.ToleranceM
+	} else {
+		st.ResolutionMs = c.cfg.Resolution.Milliseconds()
+	}
+	if leader, err := c.redis.Get(ctx, compactionLeaderKey).Result(); err == nil {
+		st.Leader = leader
+	} else if !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+	for _, day := range c.pendingDays(raw, days, now) {
+		st.PendingDays = append(st.PendingDays, day.Format("2006-01-02"))
+	}
+	st.Ranges = ranges(raw, days, c.cfg.SampleInterval, now)
+	return st, nil
+}
+
+// ranges junta os dias com a mesma resolução em intervalos contínuos. Os
+// dias de agent_positions sem progresso são raw; os criados à frente de now
+// ficam de fora.
+func ranges(raw []time.Time, days []*CompactionDay, sample time.Duration, now time.Time) []Range {
+	byDay := map[time.Time]Range{}
+	for _, day := range raw {
+		if day.After(now) {
+			continue
+		}
+		byDay[day] = Range{From: day, To: day.Add(24 * time.Hour), Method: "raw", ResolutionMs: sample.Milliseconds()}
+	}
+	for _, d := range days {
+		day, err := time.Parse("2006-01-02", d.Day)
+		if err != nil {
+			continue
+		}
+		r := Range{From: day, To: day.Add(24 * time.Hour), Method: d.Method, ResolutionMs: d.ResolutionMs, ToleranceM: d.ToleranceM}
+		if r.Method == MethodRDP {
+			r.ResolutionMs = 0
+		} else {
+			r.ToleranceM = 0
+		}
+		if d.Status != CompactionDone {
+			r.Method = "partial"
+		}
+		byDay[day] = r
+	}
+	sorted := make([]Range, 0, len(byDay))
+	for _, r := range byDay {
+		sorted = append(sorted, r)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].From.Before(sorted[j].From) })
+
+	out := []Range{}
+	for _, r := range sorted {
+		if n := len(out); n > 0 && out[n-1].To.Equal(r.From) && out[n-1].Method == r.Method &&
+			out[n-1].ResolutionMs == r.ResolutionMs && out[n-1].ToleranceM == r.ToleranceM {
+			out[n-1].To = r.To
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
@@ -0,0 +1,207 @@
+package trajectory
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/geo"
+	"smart-city-microservices/internal/logging"
+	"smart-city-microservices/internal/querybudget"
+)
+
+// AgentGetter é o subconjunto de agent.Service usado pelo handler.
+type AgentGetter interface {
+	GetAgent(ctx context.Context, id string) (*agent.Agent, error)
+}
+
+// HandlerConfig limita as consultas de trajetória.
+type HandlerConfig struct {
+	// DefaultWindow é o intervalo consultado quando from não é informado.
+	DefaultWindow time.Duration
+	MaxWindow     time.Duration
+	// MaxPoints limita as amostras lidas de uma vez, antes da
+	// simplificação.
+	MaxPoints int
+}
+
+// Handler expõe as trajetórias dos agentes.
+type Handler struct {
+	repo   *Repository
+	agents AgentGetter
+	cfg    HandlerConfig
+	budget *querybudget.Guard
+}
+
+// NewHandler cria o handler de trajetórias. budget, se informado, recusa
+// antes da leitura os intervalos com amostras demais.
+func NewHandler(repo *Repository, agents AgentGetter, cfg HandlerConfig, budget *querybudget.Guard) *Handler {
+	return &Handler{repo: repo, agents: agents, cfg: cfg, budget: budget}
+}
+
+// Get responde GET /agents/:id/trajectory com o caminho do agente em
+// [from, to) (RFC 3339; sem from, o intervalo padrão até to, que por
+// padrão é agora). ?simplify=N reduz o caminho a no máximo N pontos. Com
+// ?format=geojson ou Accept: application/geo+json, responde uma Feature
+// LineString.
+func (h *Handler) Get(c *gin.Context) {
+	var ok bool
+	var from, to time.Time
+	if to, ok = timeParam(c, "to", time.Now()); !ok {
+		return
+	}
+	if from, ok = timeParam(c, "from", to.Add(-h.cfg.DefaultWindow)); !ok {
+		return
+	}
+	switch {
+	case !from.Before(to):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return
+	case to.Sub(from) > h.cfg.MaxWindow:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "time range exceeds " + h.cfg.MaxWindow.String()})
+		return
+	}
+	simplify := 0
+	if v := c.Query("simplify"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 2 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid simplify: must be an integer >= 2"})
+			return
+		}
+		simplify = n
+	}
+
+	ctx := c.Request.Context()
+	ag, err := h.agents.GetAgent(ctx, c.Param("id"))
+	if errors.Is(err, agent.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "agent not found"})
+		return
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	if h.budget != nil {
+		rows, err := h.repo.EstimatePath(ctx, ag.ID, from, to)
+		if err != nil {
+			// Sem estimativa vale o limite de MaxPoints abaixo.
+			logging.FromContext(ctx).WithError(err).Warn("Falha ao estimar as amostras da trajetória")
+		} else if !h.budget.Allow(c, "agent_trajectory", querybudget.Cost{
+			Rows:       rows,
+			Suggestion: "narrow from and to, or use the simulation export for the full history",
+		}) {
+			return
+		}
+	}
+	points, err := h.repo.Path(ctx, ag.ID, from, to, h.cfg.MaxPoints+1)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	if len(points) > h.cfg.MaxPoints {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "time range has more than " + strconv.Itoa(h.cfg.MaxPoints) + " samples; narrow from and to"})
+		return
+	}
+	total := len(points)
+	if simplify > 0 {
+		points = Simplify(points, simplify)
+	}
+
+	if geo.Wants(c) {
+		c.Header("Content-Type", geo.MediaType)
+		c.JSON(http.StatusOK, lineString(ag.ID, from, to, total, points))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"agent_id": ag.ID,
+		"from":     from.UTC(),
+		"to":       to.UTC(),
+		"total":    total,
+		"data":     points,
+	})
+}
+
+// lineFeature é o caminho como Feature LineString. Com menos de dois
+// pontos não há linha, e a geometria é nula.
+type lineFeature struct {
+	Type       string                 `json:"type"`
+	ID         string                 `json:"id"`
+	Geometry   *lineGeometry          `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type lineGeometry struct {
+	Type        string       `json:"type"`
+	Coordinates [][2]float64 `json:"coordinates"`
+}
+
+func lineString(agentID string, from, to time.Time, total int, points []Point) lineFeature {
+	f := lineFeature{Type: "Feature", ID: agentID}
+	times := make([]time.Time, len(points))
+	ticks := make([]int64, len(points))
+	coords := make([][2]float64, len(points))
+	for i, p := range points {
+		coords[i] = [2]float64{p.Lon, p.Lat}
+		times[i], ticks[i] = p.RecordedAt, p.Tick
+	}
+	if len(points) >= 2 {
+		f.Geometry = &lineGeometry{Type: "LineString", Coordinates: coords}
+	}
+	f.Properties = map[string]interface{}{
+		"agent_id": agentID,
+		"from":     from.UTC(),
+		"to":       to.UTC(),
+		"total":    total,
+		"points":   len(points),
+		"times":    times,
+		"ticks":    ticks,
+	}
+	return f
+}
+
+// timeParam lê um parâmetro RFC 3339 da query, ou retorna def se ausente.
+// Responde 400 e retorna false se o valor é inválido.
+func timeParam(c *gin.Context, name string, def time.Time) (time.Time, bool) {
+	v := c.Query(name)
+	if v == "" {
+		return def, true
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + name + ": expected RFC 3339 timestamp"})
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+func (h *Handler) internalError(c *gin.Context, err error) {
+	logging.FromContext(c.Request.Context()).WithError(err).Error("Erro no handler de trajetórias")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
+
+// CompactionHandler expõe o progresso da compactação das trajetórias.
+type CompactionHandler struct {
+	compactor *Compactor
+}
+
+// NewCompactionHandler cria o handler de progresso da compactação.
+func NewCompactionHandler(compactor *Compactor) *CompactionHandler {
+	return &CompactionHandler{compactor: compactor}
+}
+
+// Get responde GET /admin/jobs/compaction com o progresso de cada dia e a
+// resolução efetiva das trajetórias por intervalo de tempo.
+func (h *CompactionHandler) Get(c *gin.Context) {
+	st, err := h.compactor.Status(c.Request.Context())
+	if err != nil {
+		logging.FromContext(c.Request.Context()).WithError(err).Error("Erro no handler de compactação das trajetórias")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+	c.JSON(http.StatusOK, st)
+}
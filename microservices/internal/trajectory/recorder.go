@@ -0,0 +1,155 @@
+package trajectory
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/logging"
+	"smart-city-microservices/internal/supervisor"
+)
+
+var samples = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "agent_service",
+	Name:      "agent_position_samples_total",
+	Help:      "Amostras de posição por resultado (recorded, dropped com a fila cheia, failed na gravação).",
+}, []string{"result"})
+
+// TickSource dá o tick atual de uma simulação; *agentmsg.Bus a
+// implementa.
+type TickSource interface {
+	CurrentTick(ctx context.Context, simulationID string) (int64, error)
+}
+
+// RecorderConfig configura o gravador.
+type RecorderConfig struct {
+	// SampleInterval é o intervalo mínimo entre duas amostras do mesmo
+	// agente; alterações no meio são ignoradas.
+	SampleInterval time.Duration
+	// BatchSize grava o lote assim que ele chega a esse tamanho.
+	BatchSize int
+	// FlushInterval grava o lote incompleto depois desse tempo.
+	FlushInterval time.Duration
+	// QueueSize limita os eventos à espera.
+	QueueSize int
+}
+
+// Recorder grava as posições dos agentes a partir dos eventos agent.created
+// e agent.updated. Cada réplica grava as alterações que publica.
+type Recorder struct {
+	repo  *Repository
+	ticks TickSource
+	cfg   RecorderConfig
+	last  map[string]Point
+	batch []Sample
+
+	events chan events.Event
+}
+
+// NewRecorder cria o gravador; o consumo roda em Run.
+func NewRecorder(repo *Repository, ticks TickSource, cfg RecorderConfig) *Recorder {
+	return &Recorder{
+		repo:   repo,
+		ticks:  ticks,
+		cfg:    cfg,
+		last:   map[string]Point{},
+		events: make(chan events.Event, cfg.QueueSize),
+	}
+}
+
+// Handle recebe eventos do barramento sem bloquear o Publish; com a fila
+// cheia o evento é descartado e contado.
+func (r *Recorder) Handle(_ context.Context, e events.Event) {
+	switch e.Type {
+	case "agent.created", "agent.updated", "agent.deleted":
+	default:
+		return
+	}
+	select {
+	case r.events <- e:
+	default:
+		samples.WithLabelValues("dropped").Inc()
+	}
+}
+
+// Run consome os eventos até ctx ser cancelado e, ao terminar, grava o
+// lote em andamento; eventos ainda na fila são descartados.
+func (r *Recorder) Run(ctx context.Context) error {
+	work := logging.Background(context.WithoutCancel(ctx), "trajectory-recorder")
+	ticker := time.NewTicker(r.cfg.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			work, cancel := supervisor.Cleanup(work)
+			r.flush(work)
+			cancel()
+			return nil
+		case <-ticker.C:
+			r.flush(work)
+		case e := <-r.events:
+			if err := r.sample(work, e); err != nil {
+				logging.FromContext(work).WithError(err).WithField("event_type", e.Type).Warn("Falha ao amostrar posição de agente")
+			}
+			if len(r.batch) >= r.cfg.BatchSize {
+				r.flush(work)
+			}
+		}
+	}
+}
+
+// sample põe a posição do agente do evento no lote, se já passou o
+// intervalo de amostragem desde a última e ela mudou.
+func (r *Recorder) sample(ctx context.Context, e events.Event) error {
+	var data events.AgentV1
+	err := e.Decode(&data)
+	if err != nil || data.ID == "" {
+		return err
+	}
+	if e.Type == "agent.deleted" {
+		delete(r.last, data.ID)
+		return nil
+	}
+
+	p := Point{
+		SimulationID: data.SimulationID,
+		Lat:          data.Position.Lat,
+		Lon:          data.Position.Lon,
+		Heading:      data.Position.Heading,
+		Speed:        data.Position.Speed,
+		RecordedAt:   e.OccurredAt,
+	}
+	if prev, ok := r.last[data.ID]; ok {
+		moved := prev.Lat != p.Lat || prev.Lon != p.Lon || prev.Heading != p.Heading || prev.Speed != p.Speed
+		if !moved || p.RecordedAt.Sub(prev.RecordedAt) < r.cfg.SampleInterval {
+			return nil
+		}
+	}
+	if p.SimulationID != "" {
+		if p.Tick, err = r.ticks.CurrentTick(ctx, p.SimulationID); err != nil {
+			return err
+		}
+	}
+	r.last[data.ID] = p
+	r.batch = append(r.batch, Sample{AgentID: data.ID, Point: p})
+	return nil
+}
+
+// flush grava o lote. Um lote que falhou é descartado, para não acumular
+// enquanto o banco está fora.
+func (r *Recorder) flush(ctx context.Context) {
+	if len(r.batch) == 0 {
+		return
+	}
+	n := float64(len(r.batch))
+	if err := r.repo.Insert(ctx, r.batch); err != nil {
+		samples.WithLabelValues("failed").Add(n)
+		logging.FromContext(ctx).WithError(err).WithField("samples", len(r.batch)).Error("Falha ao gravar amostras de posição")
+	} else {
+		samples.WithLabelValues("recorded").Add(n)
+	}
+	r.batch = r.batch[:0]
+}
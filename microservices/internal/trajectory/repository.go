@@ -0,0 +1,317 @@
+package trajectory
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"smart-city-microservices/internal/instrument"
+	"smart-city-microservices/internal/querybudget"
+)
+
+// Repository persiste as amostras em agent_positions.
+type Repository struct {
+	db *instrument.DB
+}
+
+// NewRepository cria o repositório.
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: instrument.NewDB(db)}
+}
+
+// Insert grava as amostras num só comando.
+func (r *Repository) Insert(ctx context.Context, samples []Sample) error {
+	return r.insert(ctx, "trajectory.insert", "agent_positions", samples)
+}
+
+func (r *Repository) insert(ctx context.Context, name, table string, samples []Sample) error {
+	n := len(samples)
+	agents, sims, ats := make([]string, n), make([]string, n), make([]string, n)
+	ticks := make([]int64, n)
+	lats, lons, headings, speeds := make([]float64, n), make([]float64, n), make([]float64, n), make([]float64, n)
+	for i, s := range samples {
+		agents[i], sims[i], ats[i], ticks[i] = s.AgentID, s.SimulationID, s.RecordedAt.UTC().Format(time.RFC3339Nano), s.Tick
+		lats[i], lons[i], headings[i], speeds[i] = s.Lat, s.Lon, s.Heading, s.Speed
+	}
+	_, err := r.db.Exec(ctx, name, `
+		INSERT INTO `+table+` (agent_id, simulation_id, tick, lat, lon, heading, speed, recorded_at)
+		SELECT s.agent_id, NULLIF(s.simulation_id, '')::uuid, s.tick, s.lat, s.lon, s.heading, s.speed, s.recorded_at
+		FROM unnest($1::uuid[], $2::text[], $3::bigint[], $4::float8[], $5::float8[], $6::float8[], $7::float8[],
+			$8::timestamptz[]) AS s(agent_id, simulation_id, tick, lat, lon, heading, speed, recorded_at)`,
+		pq.StringArray(agents), pq.StringArray(sims), pq.Int64Array(ticks), pq.Float64Array(lats),
+		pq.Float64Array(lons), pq.Float64Array(headings), pq.Float64Array(speeds), pq.StringArray(ats))
+	return err
+}
+
+// Path retorna, em ordem, as amostras do agente em [from, to), até limit,
+// juntando as de agent_positions e as já compactadas. Cada agente de um dia
+// está todo numa das tabelas, e nenhuma amostra aparece duas vezes.
+func (r *Repository) Path(ctx context.Context, agentID string, from, to time.Time, limit int) ([]Point, error) {
+	rows, err := r.db.Query(ctx, "trajectory.path", `
+		SELECT COALESCE(simulation_id::text, ''), tick, lat, lon, heading, speed, recorded_at FROM (
+			SELECT simulation_id, tick, lat, lon, heading, speed, recorded_at
+			FROM agent_positions
+			WHERE agent_id = $1 AND recorded_at >= $2 AND recorded_at < $3
+			UNION ALL
+			SELECT simulation_id, tick, lat, lon, heading, speed, recorded_at
+			FROM agent_positions_compacted
+			WHERE agent_id = $1 AND recorded_at >= $2 AND recorded_at < $3
+		) p
+		ORDER BY recorded_at, tick
+		LIMIT $4`, agentID, from, to, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []Point{}
+	for rows.Next() {
+		var p Point
+		if err := rows.Scan(&p.SimulationID, &p.Tick, &p.Lat, &p.Lon, &p.Heading, &p.Speed, &p.RecordedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// PositionsAt retorna a posição de cada agente da simulação no tick: a
+// última amostra dele até o tick, de agent_positions ou das já
+// compactadas, só as dentro do retângulo.
+func (r *Repository) PositionsAt(ctx context.Context, simulationID string, tick int64, minLat, minLon, maxLat, maxLon float64) ([]Sample, error) {
+	rows, err := r.db.Query(ctx, "trajectory.positions_at", `
+		SELECT agent_id::text, tick, lat, lon, heading, speed, recorded_at FROM (
+			SELECT DISTINCT ON (agent_id) agent_id, tick, lat, lon, heading, speed, recorded_at FROM (
+				SELECT agent_id, tick, lat, lon, heading, speed, recorded_at
+				FROM agent_positions WHERE simulation_id = $1::uuid AND tick <= $2
+				UNION ALL
+				SELECT agent_id, tick, lat, lon, heading, speed, recorded_at
+				FROM agent_positions_compacted WHERE simulation_id = $1::uuid AND tick <= $2
+			) p
+			ORDER BY agent_id, tick DESC, recorded_at DESC
+		) latest
+		WHERE lat BETWEEN $3 AND $5 AND lon BETWEEN $4 AND $6`,
+		simulationID, tick, minLat, minLon, maxLat, maxLon)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Sample
+	for rows.Next() {
+		s := Sample{Point: Point{SimulationID: simulationID}}
+		if err := rows.Scan(&s.AgentID, &s.Tick, &s.Lat, &s.Lon, &s.Heading, &s.Speed, &s.RecordedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// EstimatePath estima pelo planejador as amostras do agente em [from, to),
+// sem lê-las.
+func (r *Repository) EstimatePath(ctx context.Context, agentID string, from, to time.Time) (int64, error) {
+	return querybudget.ExplainRows(ctx, r.db, "trajectory.estimate_path", `
+		SELECT 1 FROM agent_positions WHERE agent_id = $1 AND recorded_at >= $2 AND recorded_at < $3
+		UNION ALL
+		SELECT 1 FROM agent_positions_compacted WHERE agent_id = $1 AND recorded_at >= $2 AND recorded_at < $3`,
+		agentID, from, to)
+}
+
+// rawPartition é a partição diária de agent_positions do dia.
+func rawPartition(day time.Time) string {
+	return pq.QuoteIdentifier("agent_positions_p" + day.Format("20060102"))
+}
+
+// RawDays lista os dias (UTC) das partições de agent_positions, do mais
+// antigo para o mais novo, inclusive os já criados à frente.
+func (r *Repository) RawDays(ctx context.Context) ([]time.Time, error) {
+	rows, err := r.db.Query(ctx, "trajectory.raw_days", `
+		SELECT c.relname FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = 'agent_positions'::regclass
+		ORDER BY c.relname`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []time.Time
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		// Tabelas anexadas com outro nome são ignoradas, como na retenção.
+		suffix, ok := strings.CutPrefix(name, "agent_positions_p")
+		day, err := time.Parse("20060102", suffix)
+		if !ok || err != nil {
+			continue
+		}
+		out = append(out, day)
+	}
+	return out, rows.Err()
+}
+
+const compactionColumns = `to_char(day, 'YYYY-MM-DD'), method, resolution_ms, tolerance_m, status,
+	COALESCE(last_agent_id::text, ''), agents, samples_in, samples_out, started_at, updated_at, finished_at`
+
+func scanCompaction(row interface{ Scan(...interface{}) error }) (*CompactionDay, error) {
+	var d CompactionDay
+	err := row.Scan(&d.Day, &d.Method, &d.ResolutionMs, &d.ToleranceM, &d.Status,
+		&d.LastAgentID, &d.Agents, &d.SamplesIn, &d.SamplesOut, &d.StartedAt, &d.UpdatedAt, &d.FinishedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// Compactions retorna o progresso de todos os dias já compactados ou em
+// compactação, do mais antigo para o mais novo.
+func (r *Repository) Compactions(ctx context.Context) ([]*CompactionDay, error) {
+	rows, err := r.db.Query(ctx, "trajectory.compactions", `SELECT `+compactionColumns+` FROM trajectory_compactions ORDER BY day`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []*CompactionDay{}
+	for rows.Next() {
+		d, err := scanCompaction(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// StartCompaction registra o início da compactação do dia com o método e
+// os parâmetros de d, se ainda não começou, garante a partição compactada
+// do dia e retorna o progresso gravado, que numa retomada traz o método do
+// início.
+func (r *Repository) StartCompaction(ctx context.Context, day time.Time, d CompactionDay) (*CompactionDay, error) {
+	if _, err := r.db.Exec(ctx, "trajectory.compacted_partition",
+		`SELECT create_agent_positions_compacted_partitions($1::date, 1)`, day.Format("2006-01-02")); err != nil {
+		return nil, err
+	}
+	if _, err := r.db.Exec(ctx, "trajectory.start_compaction", `
+		INSERT INTO trajectory_compactions (day, method, resolution_ms, tolerance_m)
+		VALUES ($1::date, $2, $3, $4)
+		ON CONFLICT (day) DO NOTHING`, day.Format("2006-01-02"), d.Method, d.ResolutionMs, d.ToleranceM); err != nil {
+		return nil, err
+	}
+	return scanCompaction(r.db.QueryRow(ctx, "trajectory.compaction",
+		`SELECT `+compactionColumns+` FROM trajectory_compactions WHERE day = $1::date`, day.Format("2006-01-02")))
+}
+
+// NextAgents retorna até limit agentes do dia depois de after, em ordem de
+// id; after vazio começa do primeiro.
+func (r *Repository) NextAgents(ctx context.Context, day time.Time, after string, limit int) ([]string, error) {
+	rows, err := r.db.Query(ctx, "trajectory.next_agents", `
+		SELECT DISTINCT agent_id::text FROM `+rawPartition(day)+`
+		WHERE agent_id > COALESCE(NULLIF($1, '')::uuid, '00000000-0000-0000-0000-000000000000'::uuid)
+		ORDER BY 1
+		LIMIT $2`, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		out = append(out, id)
+	}
+	return out, rows.Err()
+}
+
+// CompactChunk troca, numa só transação, as amostras brutas dos agentes no
+// dia pelas devolvidas por reduce, chamada uma vez por agente com o
+// caminho em ordem, e avança o cursor do dia até o último agente.
+func (r *Repository) CompactChunk(ctx context.Context, day time.Time, agentIDs []string, reduce func([]Point) []Point) (in, out int, err error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+	ctx = instrument.WithTx(ctx, tx.Tx)
+
+	rows, err := r.db.Query(ctx, "trajectory.chunk_samples", `
+		SELECT agent_id::text, COALESCE(simulation_id::text, ''), tick, lat, lon, heading, speed, recorded_at
+		FROM `+rawPartition(day)+`
+		WHERE agent_id = ANY($1::uuid[])
+		ORDER BY agent_id, recorded_at, tick`, pq.StringArray(agentIDs))
+	if err != nil {
+		return 0, 0, err
+	}
+	var compacted []Sample
+	var agent string
+	var path []Point
+	flush := func() {
+		for _, p := range reduce(path) {
+			compacted = append(compacted, Sample{AgentID: agent, Point: p})
+		}
+		path = path[:0]
+	}
+	for rows.Next() {
+		var id string
+		var p Point
+		if err = rows.Scan(&id, &p.SimulationID, &p.Tick, &p.Lat, &p.Lon, &p.Heading, &p.Speed, &p.RecordedAt); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		if id != agent && len(path) > 0 {
+			flush()
+		}
+		agent = id
+		path = append(path, p)
+		in++
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, 0, err
+	}
+	if len(path) > 0 {
+		flush()
+	}
+
+	if len(compacted) > 0 {
+		if err = r.insert(ctx, "trajectory.insert_compacted", "agent_positions_compacted", compacted); err != nil {
+			return 0, 0, err
+		}
+	}
+	if _, err = r.db.Exec(ctx, "trajectory.delete_compacted",
+		`DELETE FROM `+rawPartition(day)+` WHERE agent_id = ANY($1::uuid[])`, pq.StringArray(agentIDs)); err != nil {
+		return 0, 0, err
+	}
+	if _, err = r.db.Exec(ctx, "trajectory.advance_compaction", `
+		UPDATE trajectory_compactions SET last_agent_id = $2, agents = agents + $3, samples_in = samples_in + $4,
+			samples_out = samples_out + $5, updated_at = CURRENT_TIMESTAMP
+		WHERE day = $1::date`,
+		day.Format("2006-01-02"), agentIDs[len(agentIDs)-1], len(agentIDs), in, len(compacted)); err != nil {
+		return 0, 0, err
+	}
+	return in, len(compacted), tx.Commit()
+}
+
+// FinishCompaction marca o dia como compactado.
+func (r *Repository) FinishCompaction(ctx context.Context, day time.Time) error {
+	_, err := r.db.Exec(ctx, "trajectory.finish_compaction", `
+		UPDATE trajectory_compactions SET status = 'done', updated_at = CURRENT_TIMESTAMP, finished_at = CURRENT_TIMESTAMP
+		WHERE day = $1::date`, day.Format("2006-01-02"))
+	return err
+}
+
+// PruneCompactions remove o progresso dos dias anteriores a before, cujas
+// amostras a retenção já descartou.
+func (r *Repository) PruneCompactions(ctx context.Context, before time.Time) error {
+	_, err := r.db.Exec(ctx, "trajectory.prune_compactions",
+		`DELETE FROM trajectory_compactions WHERE day < $1::date`, before.Format("2006-01-02"))
+	return err
+}
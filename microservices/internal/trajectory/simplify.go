@@ -0,0 +1,115 @@
+package trajectory
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+)
+
+const earthRadius = 6371000.0
+
+// Simplify reduz o caminho a no máximo n pontos com Douglas-Peucker. Em vez
+// de uma tolerância, os trechos são divididos no ponto mais afastado, do
+// maior afastamento para o menor, até chegar a n pontos; o primeiro e o
+// último sempre ficam. n abaixo de 2 vale 2.
+func Simplify(points []Point, n int) []Point {
+	n = max(n, 2)
+	if len(points) <= n {
+		return points
+	}
+	return split(points, func(keep int, s segment) bool { return keep < n })
+}
+
+// SimplifyWithin reduz o caminho com Douglas-Peucker de modo que nenhum
+// ponto descartado fique a mais de tolerance metros do caminho que resta;
+// o primeiro e o último sempre ficam.
+func SimplifyWithin(points []Point, tolerance float64) []Point {
+	if len(points) <= 2 {
+		return points
+	}
+	return split(points, func(_ int, s segment) bool { return s.dist > tolerance })
+}
+
+// split divide os trechos do caminho no ponto mais afastado, do maior
+// afastamento para o menor, enquanto more aceitar o próximo trecho, e
+// retorna os pontos mantidos em ordem.
+func split(points []Point, more func(keep int, s segment) bool) []Point {
+	// Projeção equirretangular em torno da latitude média: basta para
+	// comparar afastamentos dentro de um trajeto.
+	var latSum float64
+	for _, p := range points {
+		latSum += p.Lat
+	}
+	cos := math.Cos(latSum / float64(len(points)) * math.Pi / 180)
+	xy := make([][2]float64, len(points))
+	for i, p := range points {
+		xy[i] = [2]float64{p.Lon * math.Pi / 180 * earthRadius * cos, p.Lat * math.Pi / 180 * earthRadius}
+	}
+
+	keep := []int{0, len(points) - 1}
+	h := &segments{}
+	if s, ok := farthest(xy, 0, len(points)-1); ok {
+		heap.Push(h, s)
+	}
+	for h.Len() > 0 && more(len(keep), (*h)[0]) {
+		s := heap.Pop(h).(segment)
+		keep = append(keep, s.split)
+		for _, part := range [][2]int{{s.first, s.split}, {s.split, s.last}} {
+			if next, ok := farthest(xy, part[0], part[1]); ok {
+				heap.Push(h, next)
+			}
+		}
+	}
+	sort.Ints(keep)
+	out := make([]Point, len(keep))
+	for i, k := range keep {
+		out[i] = points[k]
+	}
+	return out
+}
+
+// segment é um trecho do caminho e o ponto dele mais afastado da reta
+// entre as pontas.
+type segment struct {
+	first, last, split int
+	dist               float64
+}
+
+// farthest acha o ponto de (first, last) mais afastado da reta entre as
+// pontas; false se o trecho não tem pontos internos.
+func farthest(xy [][2]float64, first, last int) (segment, bool) {
+	if last-first < 2 {
+		return segment{}, false
+	}
+	s := segment{first: first, last: last, split: first + 1, dist: -1}
+	for i := first + 1; i < last; i++ {
+		if d := lineDistance(xy[i], xy[first], xy[last]); d > s.dist {
+			s.split, s.dist = i, d
+		}
+	}
+	return s, true
+}
+
+// lineDistance é a distância de p ao segmento ab.
+func lineDistance(p, a, b [2]float64) float64 {
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	t := 0.0
+	if l := dx*dx + dy*dy; l > 0 {
+		t = math.Max(0, math.Min(1, ((p[0]-a[0])*dx+(p[1]-a[1])*dy)/l))
+	}
+	return math.Hypot(p[0]-(a[0]+t*dx), p[1]-(a[1]+t*dy))
+}
+
+// segments é uma heap de trechos pelo maior afastamento.
+type segments []segment
+
+func (s segments) Len() int            { return len(s) }
+func (s segments) Less(i, j int) bool  { return s[i].dist > s[j].dist }
+func (s segments) Swap(i, j int)       { s[i], s[j] = s[j], s[i] }
+func (s *segments) Push(x interface{}) { *s = append(*s, x.(segment)) }
+func (s *segments) Pop() interface{} {
+	old := *s
+	x := old[len(old)-1]
+	*s = old[:len(old)-1]
+	return x
+}
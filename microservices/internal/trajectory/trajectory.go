@@ -0,0 +1,30 @@
+// Package trajectory guarda por onde os agentes passaram. O gravador
+// acompanha as alterações dos agentes pelo barramento e grava amostras de
+// posição em lote em agent_positions, no máximo uma por agente a cada
+// intervalo de amostragem; o handler devolve o caminho de um agente num
+// intervalo, opcionalmente simplificado, em JSON ou GeoJSON. A
+// compactação, opcional, move os dias antigos para
+// agent_positions_compacted numa resolução menor, e as consultas juntam as
+// duas tabelas.
+package trajectory
+
+import (
+	"time"
+)
+
+// Point é uma amostra da posição de um agente.
+type Point struct {
+	SimulationID string    `json:"simulation_id,omitempty"`
+	Tick         int64     `json:"tick"`
+	Lat          float64   `json:"lat"`
+	Lon          float64   `json:"lon"`
+	Heading      float64   `json:"heading"`
+	Speed        float64   `json:"speed"`
+	RecordedAt   time.Time `json:"recorded_at"`
+}
+
+// Sample é um ponto à espera de gravação.
+type Sample struct {
+	AgentID string
+	Point
+}
@@ -0,0 +1,192 @@
+package transfer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/audit"
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/i18n"
+	"smart-city-microservices/internal/logging"
+)
+
+// AgentService é o subconjunto de agent.Service usado pela transferência.
+type AgentService interface {
+	GetAgent(ctx context.Context, id string) (*agent.Agent, error)
+	UpdateAgent(ctx context.Context, id string, req agent.UpdateAgentRequest) (*agent.Agent, error)
+	GetSimulation(ctx context.Context, id string) (*agent.Simulation, error)
+}
+
+// InboxCleaner descarta as mensagens recebidas pelo agente na simulação de
+// origem; *agentmsg.Bus o implementa.
+type InboxCleaner interface {
+	ClearInbox(ctx context.Context, agentID string) error
+}
+
+// Config configura as transferências.
+type Config struct {
+	// RunningStatuses são os status em que o agente precisa ser pausado
+	// antes; com force, ele recebe PauseStatus.
+	RunningStatuses []string
+	PauseStatus     string
+	// DefaultMaxAgents limita os agentes do destino sem config.max_agents.
+	DefaultMaxAgents int
+	// ProjectExpiry é o prazo para a aprovação das transferências entre
+	// projetos.
+	ProjectExpiry time.Duration
+}
+
+// Handler expõe a transferência de agentes entre simulações e entre
+// projetos.
+type Handler struct {
+	repo      *Repository
+	agents    AgentService
+	inboxes   InboxCleaner
+	publisher events.Publisher
+	cfg       Config
+}
+
+// NewHandler cria o handler de transferências.
+func NewHandler(repo *Repository, agents AgentService, inboxes InboxCleaner, publisher events.Publisher, cfg Config) *Handler {
+	return &Handler{repo: repo, agents: agents, inboxes: inboxes, publisher: publisher, cfg: cfg}
+}
+
+// Request é o corpo de POST /agents/:id/transfer.
+type Request struct {
+	SimulationID string `json:"simulation_id" binding:"required"`
+	// Force pausa o agente em execução como parte da transferência.
+	Force bool `json:"force"`
+}
+
+// Transfer responde POST /agents/:id/transfer: move o agente para a
+// simulação simulation_id, do mesmo projeto. Um agente em execução precisa
+// ser pausado antes (senão 409), ou é pausado aqui com force. O estado de
+// execução do agente (state e a caixa de mensagens) é descartado; o
+// restante, como tipo, metadata e tags, segue com ele.
+func (h *Handler) Transfer(c *gin.Context) {
+	var req Request
+	if err := c.ShouldBindJSON(&req); err != nil {
+		i18n.BindError(c, err)
+		return
+	}
+	ctx := c.Request.Context()
+	ag, err := h.agents.GetAgent(ctx, c.Param("id"))
+	if errors.Is(err, agent.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "agent not found"})
+		return
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	if req.SimulationID == ag.SimulationID {
+		c.JSON(http.StatusConflict, gin.H{"error": "agent is already in simulation " + req.SimulationID})
+		return
+	}
+	target, err := h.agents.GetSimulation(ctx, req.SimulationID)
+	if errors.Is(err, agent.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "simulation not found"})
+		return
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	if target.ProjectID != ag.ProjectID {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "target simulation belongs to another project"})
+		return
+	}
+	if target.EndedAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "target simulation has ended"})
+		return
+	}
+
+	prevStatus := ag.Status
+	paused := false
+	if slices.Contains(h.cfg.RunningStatuses, ag.Status) {
+		if !req.Force {
+			c.JSON(http.StatusConflict, gin.H{"error": "agent is " + ag.Status + "; pause it first or set force", "status": ag.Status})
+			return
+		}
+		status := h.cfg.PauseStatus
+		if _, err := h.agents.UpdateAgent(ctx, ag.ID, agent.UpdateAgentRequest{Status: &status}); err != nil {
+			h.internalError(c, err)
+			return
+		}
+		ag.Status, paused = status, true
+	}
+
+	var by string
+	if p := auth.FromGin(c); p != nil {
+		by = p.Subject
+	}
+	err = h.repo.Move(ctx, Move{
+		AgentID:   ag.ID,
+		From:      ag.SimulationID,
+		To:        target.ID,
+		MaxAgents: MaxAgents(target, h.cfg.DefaultMaxAgents),
+		Paused:    paused,
+		By:        by,
+	})
+	if err != nil {
+		if paused {
+			h.restore(ctx, ag.ID, prevStatus)
+		}
+		var quota *QuotaError
+		switch {
+		case errors.As(err, &quota):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "max_agents": quota.Limit, "agents": quota.Agents})
+		case errors.Is(err, ErrMoved):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			h.internalError(c, err)
+		}
+		return
+	}
+
+	source := ag.SimulationID
+	ag.SimulationID, ag.State = target.ID, map[string]interface{}{}
+	if err := h.inboxes.ClearInbox(ctx, ag.ID); err != nil {
+		logging.FromContext(ctx).WithError(err).WithField("agent_id", ag.ID).Warn("Falha ao descartar a caixa de mensagens do agente transferido")
+	}
+	audit.Record(ctx, "agent.transferred", logrus.Fields{
+		"agent_id": ag.ID, "source_simulation_id": source, "target_simulation_id": target.ID, "paused": paused,
+	})
+	h.publisher.Publish(ctx, events.New(events.TopicAgents, EventTransferred, events.AgentTransferredV1{
+		AgentID:            ag.ID,
+		AgentType:          ag.Type,
+		ProjectID:          ag.ProjectID,
+		SourceSimulationID: source,
+		TargetSimulationID: target.ID,
+		Status:             ag.Status,
+		Paused:             paused,
+		TransferredBy:      by,
+	}))
+	c.JSON(http.StatusOK, gin.H{
+		"agent":                ag,
+		"source_simulation_id": source,
+		"target_simulation_id": target.ID,
+		"paused":               paused,
+	})
+}
+
+// restore devolve ao agente o status de antes da pausa, quando a
+// transferência falha depois dela.
+func (h *Handler) restore(ctx context.Context, agentID, status string) {
+	if _, err := h.agents.UpdateAgent(ctx, agentID, agent.UpdateAgentRequest{Status: &status}); err != nil {
+		logging.FromContext(ctx).WithError(err).WithField("agent_id", agentID).Warn("Falha ao devolver o status do agente após a transferência recusada")
+	}
+}
+
+func (h *Handler) internalError(c *gin.Context, err error) {
+	logging.FromContext(c.Request.Context()).WithError(err).Error("Erro no handler de transferências")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
@@ -0,0 +1,385 @@
+package transfer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/audit"
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/i18n"
+	"smart-city-microservices/internal/logging"
+)
+
+// Transferências entre projetos. Um dispositivo que muda de departamento
+// troca de projeto sem perder o histórico: o pedido (POST
+// /agents/:id/transfer-project) fica pendente até um administrador do
+// projeto de destino, que não seja o autor do pedido, aprovar ou recusar,
+// e vence depois de transfers.project_expiry. A aprovação move o agente
+// para a simulação de destino numa só transação, como Move, e tira-o dos
+// grupos do projeto de origem; o comportamento, as capacidades e o gêmeo
+// digital seguem com ele pelo agent_id, e os tipos de agente não pertencem
+// a projetos. O histórico (ações, amostras, eventos) continua onde estava,
+// com o projeto de origem, e a transferência registra os dois projetos.
+
+// Eventos das transferências entre projetos.
+const (
+	EventProjectRequested   = "agent.project_transfer_requested"
+	EventProjectTransferred = "agent.project_transferred"
+	EventProjectRejected    = "agent.project_transfer_rejected"
+)
+
+// Tipos dos registros das transferências entre projetos no log de eventos.
+const (
+	logProjectOut = "agent.project_transferred_out"
+	logProjectIn  = "agent.project_transferred_in"
+)
+
+// Situações de uma transferência entre projetos. Uma pendente que passou de
+// ExpiresAt é lida como ProjectExpired.
+const (
+	ProjectPending  = "pending"
+	ProjectApproved = "approved"
+	ProjectRejected = "rejected"
+	ProjectExpired  = "expired"
+)
+
+// projectStatuses são os valores aceitos em ?status.
+var projectStatuses = []string{ProjectPending, ProjectApproved, ProjectRejected, ProjectExpired}
+
+// ErrNotFound indica que o agente não tem transferências entre projetos.
+var ErrNotFound = errors.New("project transfer not found")
+
+// ErrPending indica que o agente já tem uma transferência entre projetos
+// pendente.
+var ErrPending = errors.New("agent already has a pending project transfer")
+
+// ErrDecided indica que a transferência deixou de estar pendente: foi
+// decidida por outro pedido ou venceu.
+var ErrDecided = errors.New("project transfer is no longer pending")
+
+// ProjectTransfer é uma transferência de agente entre projetos.
+type ProjectTransfer struct {
+	ID                 string     `json:"id"`
+	AgentID            string     `json:"agent_id"`
+	AgentType          string     `json:"agent_type"`
+	SourceProjectID    string     `json:"source_project_id"`
+	TargetProjectID    string     `json:"target_project_id"`
+	SourceSimulationID string     `json:"source_simulation_id"`
+	TargetSimulationID string     `json:"target_simulation_id"`
+	Status             string     `json:"status"`
+	Force              bool       `json:"force"`
+	Paused             bool       `json:"paused"`
+	RequestedBy        string     `json:"requested_by,omitempty"`
+	DecidedBy          string     `json:"decided_by,omitempty"`
+	Reason             string     `json:"reason,omitempty"`
+	CreatedAt          time.Time  `json:"created_at"`
+	ExpiresAt          time.Time  `json:"expires_at"`
+	DecidedAt          *time.Time `json:"decided_at,omitempty"`
+}
+
+// ProjectRequest é o corpo de POST /agents/:id/transfer-project.
+type ProjectRequest struct {
+	ProjectID    string `json:"project_id" binding:"required"`
+	SimulationID string `json:"simulation_id" binding:"required"`
+	// Force pausa na aprovação o agente que estiver em execução.
+	Force bool `json:"force"`
+}
+
+// RejectRequest é o corpo, opcional, de PUT
+// /agents/:id/transfer-project/reject.
+type RejectRequest struct {
+	Reason string `json:"reason" binding:"max=1000"`
+}
+
+// RequestProject responde POST /agents/:id/transfer-project: registra o
+// pedido de transferência do agente para a simulação simulation_id do
+// projeto project_id, com 202. A mudança só acontece na aprovação.
+func (h *Handler) RequestProject(c *gin.Context) {
+	var req ProjectRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		i18n.BindError(c, err)
+		return
+	}
+	ctx := c.Request.Context()
+	p := auth.FromGin(c)
+	ag, err := h.agents.GetAgent(ctx, c.Param("id"))
+	if errors.Is(err, agent.ErrNotFound) || (err == nil && !p.InProject(ag.ProjectID)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "agent not found"})
+		return
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	if req.ProjectID == ag.ProjectID {
+		c.JSON(http.StatusConflict, gin.H{"error": "agent already belongs to project " + req.ProjectID + "; use POST /api/v1/agents/:id/transfer"})
+		return
+	}
+	target, err := h.agents.GetSimulation(ctx, req.SimulationID)
+	if errors.Is(err, agent.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "simulation not found"})
+		return
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	if target.ProjectID != req.ProjectID {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "target simulation does not belong to project " + req.ProjectID})
+		return
+	}
+	if target.EndedAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "target simulation has ended"})
+		return
+	}
+
+	t := &ProjectTransfer{
+		AgentID:            ag.ID,
+		AgentType:          ag.Type,
+		SourceProjectID:    ag.ProjectID,
+		TargetProjectID:    target.ProjectID,
+		SourceSimulationID: ag.SimulationID,
+		TargetSimulationID: target.ID,
+		Force:              req.Force,
+		ExpiresAt:          time.Now().Add(h.cfg.ProjectExpiry),
+	}
+	if p != nil {
+		t.RequestedBy = p.Subject
+	}
+	if err := h.repo.CreateProject(ctx, t); err != nil {
+		if errors.Is(err, ErrPending) {
+			h.pendingConflict(c, ag.ID)
+			return
+		}
+		h.internalError(c, err)
+		return
+	}
+	audit.Record(ctx, "agent.project_transfer_requested", logrus.Fields{
+		"transfer_id": t.ID, "agent_id": t.AgentID, "source_project_id": t.SourceProjectID, "target_project_id": t.TargetProjectID,
+	})
+	h.publishProject(ctx, EventProjectRequested, t)
+	c.Header("Location", "/api/v1/agents/"+ag.ID+"/transfer-project")
+	c.JSON(http.StatusAccepted, t)
+}
+
+// pendingConflict responde 409 com a transferência que já está pendente.
+func (h *Handler) pendingConflict(c *gin.Context, agentID string) {
+	current, err := h.repo.LatestProject(c.Request.Context(), agentID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		h.internalError(c, err)
+		return
+	}
+	c.JSON(http.StatusConflict, gin.H{"error": ErrPending.Error(), "transfer": current})
+}
+
+// GetProject responde GET /agents/:id/transfer-project com a transferência
+// mais recente do agente, visível nos dois projetos.
+func (h *Handler) GetProject(c *gin.Context) {
+	t := h.latestProject(c)
+	if t == nil {
+		return
+	}
+	c.JSON(http.StatusOK, t)
+}
+
+// latestProject lê a transferência mais recente do agente, ou responde 404
+// se não há nenhuma visível ao principal.
+func (h *Handler) latestProject(c *gin.Context) *ProjectTransfer {
+	t, err := h.repo.LatestProject(c.Request.Context(), c.Param("id"))
+	p := auth.FromGin(c)
+	if errors.Is(err, ErrNotFound) || (err == nil && !p.InProject(t.SourceProjectID) && !p.InProject(t.TargetProjectID)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "project transfer not found"})
+		return nil
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return nil
+	}
+	return t
+}
+
+// pendingProject lê a transferência que o principal vai decidir: pendente,
+// não vencida e para um projeto em que ele é administrador. Quem pediu a
+// transferência não a decide, mesmo sendo administrador do destino.
+func (h *Handler) pendingProject(c *gin.Context) *ProjectTransfer {
+	t := h.latestProject(c)
+	if t == nil {
+		return nil
+	}
+	p := auth.FromGin(c)
+	if !p.HasProjectRole(t.TargetProjectID, auth.RoleAdmin) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only an admin of project " + t.TargetProjectID + " can decide this transfer"})
+		return nil
+	}
+	if t.RequestedBy != "" && p.Subject == t.RequestedBy {
+		c.JSON(http.StatusForbidden, gin.H{"error": "project transfer cannot be decided by its requester"})
+		return nil
+	}
+	if t.Status != ProjectPending {
+		c.JSON(http.StatusConflict, gin.H{"error": "project transfer is " + t.Status, "transfer": t})
+		return nil
+	}
+	return t
+}
+
+// Approve responde PUT /agents/:id/transfer-project/approve: move o agente
+// para a simulação de destino. O agente em execução é pausado se o pedido
+// veio com force; senão, responde 409 até ele ser pausado.
+func (h *Handler) Approve(c *gin.Context) {
+	t := h.pendingProject(c)
+	if t == nil {
+		return
+	}
+	ctx := c.Request.Context()
+	ag, err := h.agents.GetAgent(ctx, t.AgentID)
+	if errors.Is(err, agent.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "agent not found"})
+		return
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	target, err := h.agents.GetSimulation(ctx, t.TargetSimulationID)
+	if errors.Is(err, agent.ErrNotFound) {
+		c.JSON(http.StatusConflict, gin.H{"error": "target simulation no longer exists"})
+		return
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	if target.EndedAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "target simulation has ended"})
+		return
+	}
+
+	prevStatus := ag.Status
+	paused := false
+	if slices.Contains(h.cfg.RunningStatuses, ag.Status) {
+		if !t.Force {
+			c.JSON(http.StatusConflict, gin.H{"error": "agent is " + ag.Status + "; pause it first or request the transfer with force", "status": ag.Status})
+			return
+		}
+		status := h.cfg.PauseStatus
+		if _, err := h.agents.UpdateAgent(ctx, ag.ID, agent.UpdateAgentRequest{Status: &status}); err != nil {
+			h.internalError(c, err)
+			return
+		}
+		ag.Status, paused = status, true
+	}
+
+	var by string
+	if p := auth.FromGin(c); p != nil {
+		by = p.Subject
+	}
+	err = h.repo.ApproveProject(ctx, t, MaxAgents(target, h.cfg.DefaultMaxAgents), paused, by)
+	if err != nil {
+		if paused {
+			h.restore(ctx, ag.ID, prevStatus)
+		}
+		var quota *QuotaError
+		switch {
+		case errors.As(err, &quota):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "max_agents": quota.Limit, "agents": quota.Agents})
+		case errors.Is(err, ErrMoved), errors.Is(err, ErrDecided):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			h.internalError(c, err)
+		}
+		return
+	}
+
+	ag.SimulationID, ag.ProjectID, ag.State = target.ID, target.ProjectID, map[string]interface{}{}
+	if err := h.inboxes.ClearInbox(ctx, ag.ID); err != nil {
+		logging.FromContext(ctx).WithError(err).WithField("agent_id", ag.ID).Warn("Falha ao descartar a caixa de mensagens do agente transferido")
+	}
+	audit.Record(ctx, "agent.project_transferred", logrus.Fields{
+		"transfer_id": t.ID, "agent_id": ag.ID, "source_project_id": t.SourceProjectID, "target_project_id": t.TargetProjectID,
+		"source_simulation_id": t.SourceSimulationID, "target_simulation_id": t.TargetSimulationID, "paused": paused,
+	})
+	h.publishProject(ctx, EventProjectTransferred, t)
+	c.JSON(http.StatusOK, gin.H{"transfer": t, "agent": ag})
+}
+
+// Reject responde PUT /agents/:id/transfer-project/reject; o agente fica
+// onde está.
+func (h *Handler) Reject(c *gin.Context) {
+	var req RejectRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			i18n.BindError(c, err)
+			return
+		}
+	}
+	t := h.pendingProject(c)
+	if t == nil {
+		return
+	}
+	ctx := c.Request.Context()
+	var by string
+	if p := auth.FromGin(c); p != nil {
+		by = p.Subject
+	}
+	if err := h.repo.RejectProject(ctx, t, by, req.Reason); err != nil {
+		if errors.Is(err, ErrDecided) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		h.internalError(c, err)
+		return
+	}
+	audit.Record(ctx, "agent.project_transfer_rejected", logrus.Fields{
+		"transfer_id": t.ID, "agent_id": t.AgentID, "target_project_id": t.TargetProjectID, "reason": t.Reason,
+	})
+	h.publishProject(ctx, EventProjectRejected, t)
+	c.JSON(http.StatusOK, t)
+}
+
+// ListProject responde GET /projects/:id/agent-transfers: as transferências
+// que saem do projeto ou chegam a ele, das mais novas para as mais antigas,
+// filtradas por ?status.
+func (h *Handler) ListProject(c *gin.Context) {
+	projectID := c.Param("id")
+	if !auth.FromGin(c).InProject(projectID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "project " + projectID + " is not accessible"})
+		return
+	}
+	status := c.Query("status")
+	if status != "" && !slices.Contains(projectStatuses, status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid status: must be one of pending, approved, rejected, expired"})
+		return
+	}
+	list, err := h.repo.ListProject(c.Request.Context(), projectID, status)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": list})
+}
+
+func (h *Handler) publishProject(ctx context.Context, eventType string, t *ProjectTransfer) {
+	h.publisher.Publish(ctx, events.New(events.TopicAgents, eventType, events.AgentProjectTransferV1{
+		TransferID:         t.ID,
+		AgentID:            t.AgentID,
+		AgentType:          t.AgentType,
+		SourceProjectID:    t.SourceProjectID,
+		TargetProjectID:    t.TargetProjectID,
+		SourceSimulationID: t.SourceSimulationID,
+		TargetSimulationID: t.TargetSimulationID,
+		Status:             t.Status,
+		Paused:             t.Paused,
+		RequestedBy:        t.RequestedBy,
+		DecidedBy:          t.DecidedBy,
+		Reason:             t.Reason,
+		ExpiresAt:          t.ExpiresAt.UTC(),
+	}))
+}
@@ -0,0 +1,264 @@
+package transfer
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/events"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+	sql.Register("transfertest", transferDriver{})
+}
+
+// pending são as transferências pendentes por DSN, com os UPDATE recebidos.
+var (
+	pendingMu sync.Mutex
+	pending   = map[string]*pendingTransfer{}
+)
+
+type pendingTransfer struct {
+	t       ProjectTransfer
+	updates []string
+}
+
+// transferDriver responde à leitura da transferência mais recente com a
+// pendente do DSN e à recusa com a linha atualizada.
+type transferDriver struct{}
+
+func (transferDriver) Open(dsn string) (driver.Conn, error) {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	return transferConn{pending[dsn]}, nil
+}
+
+type transferConn struct{ p *pendingTransfer }
+
+func (c transferConn) Prepare(query string) (driver.Stmt, error) {
+	return transferStmt{c.p, query}, nil
+}
+func (transferConn) Close() error              { return nil }
+func (transferConn) Begin() (driver.Tx, error) { return nil, errors.New("sem transações") }
+
+type transferStmt struct {
+	p     *pendingTransfer
+	query string
+}
+
+func (transferStmt) Close() error  { return nil }
+func (transferStmt) NumInput() int { return -1 }
+func (transferStmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, errors.New("só consultas")
+}
+
+func (s transferStmt) Query(args []driver.Value) (driver.Rows, error) {
+	t := s.p.t
+	if strings.Contains(s.query, "UPDATE agent_project_transfers") {
+		pendingMu.Lock()
+		s.p.updates = append(s.p.updates, s.query)
+		pendingMu.Unlock()
+		return &transferRows{values: []driver.Value{ProjectRejected, args[2], args[3], time.Now()}}, nil
+	}
+	return &transferRows{values: []driver.Value{
+		t.ID, t.AgentID, t.AgentType, t.SourceProjectID, t.TargetProjectID, t.SourceSimulationID, t.TargetSimulationID,
+		t.Status, t.Force, t.Paused, t.RequestedBy, "", "", t.CreatedAt, t.ExpiresAt, nil,
+	}}, nil
+}
+
+type transferRows struct {
+	values []driver.Value
+	done   bool
+}
+
+func (r *transferRows) Columns() []string { return make([]string, len(r.values)) }
+func (*transferRows) Close() error        { return nil }
+func (r *transferRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	copy(dest, r.values)
+	return nil
+}
+
+// fakeAgents não conhece nenhum agente; Approve autorizado responde 404
+// ao procurá-lo, e calls conta as chamadas.
+type fakeAgents struct{ calls int }
+
+func (f *fakeAgents) GetAgent(context.Context, string) (*agent.Agent, error) {
+	f.calls++
+	return nil, agent.ErrNotFound
+}
+
+func (f *fakeAgents) UpdateAgent(context.Context, string, agent.UpdateAgentRequest) (*agent.Agent, error) {
+	f.calls++
+	return nil, agent.ErrNotFound
+}
+
+func (f *fakeAgents) GetSimulation(context.Context, string) (*agent.Simulation, error) {
+	f.calls++
+	return nil, agent.ErrNotFound
+}
+
+type nopPublisher struct{}
+
+func (nopPublisher) Publish(context.Context, events.Event) {}
+
+// newProjectRouter monta as rotas de decisão como em main.go, com o
+// principal p, sobre a transferência de proj-origem para proj-destino
+// pedida por requestedBy.
+func newProjectRouter(t *testing.T, p *auth.Principal, requestedBy string) (*gin.Engine, *pendingTransfer, *fakeAgents) {
+	t.Helper()
+	pt := &pendingTransfer{t: ProjectTransfer{
+		ID: "7d2b8f0e-1c3a-4e5f-9a6b-2c4d6e8f0a1b", AgentID: "agent-1", AgentType: "bus",
+		SourceProjectID: "proj-origem", TargetProjectID: "proj-destino",
+		SourceSimulationID: "sim-origem", TargetSimulationID: "sim-destino",
+		Status: ProjectPending, RequestedBy: requestedBy,
+		CreatedAt: time.Now().Add(-time.Hour), ExpiresAt: time.Now().Add(time.Hour),
+	}}
+	pendingMu.Lock()
+	pending[t.Name()] = pt
+	pendingMu.Unlock()
+	db, err := sql.Open("transfertest", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	agents := &fakeAgents{}
+	h := NewHandler(NewRepository(db), agents, nil, nopPublisher{}, Config{})
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		if p != nil {
+			auth.SetPrincipal(c, p)
+		}
+		c.Next()
+	})
+	r.PUT("/agents/:id/transfer-project/approve", auth.RequireRole(auth.RoleAdmin), h.Approve)
+	r.PUT("/agents/:id/transfer-project/reject", auth.RequireRole(auth.RoleAdmin), h.Reject)
+	return r, pt, agents
+}
+
+// TestDecideProject confere quem decide uma transferência entre projetos:
+// só um administrador do projeto de destino, e nunca quem a pediu. Approve
+// autorizado segue até procurar o agente, que o fake não conhece (404).
+func TestDecideProject(t *testing.T) {
+	tests := []struct {
+		name        string
+		principal   *auth.Principal
+		requestedBy string
+		// approve e reject são os status esperados de cada rota.
+		approve int
+		reject  int
+		err     string
+	}{
+		{
+			name:        "target project admin",
+			principal:   &auth.Principal{Subject: "oidc:ana", Roles: []string{auth.RoleAdmin}, Projects: []string{"proj-destino"}},
+			requestedBy: "oidc:bruno",
+			approve:     http.StatusNotFound, reject: http.StatusOK,
+		},
+		{
+			name:        "admin of both projects",
+			principal:   &auth.Principal{Subject: "oidc:ana", Roles: []string{auth.RoleAdmin}, Projects: []string{"proj-origem", "proj-destino"}},
+			requestedBy: "oidc:bruno",
+			approve:     http.StatusNotFound, reject: http.StatusOK,
+		},
+		{
+			name:        "admin without projects",
+			principal:   &auth.Principal{Subject: auth.StaticTokenSubject, Roles: []string{auth.RoleAdmin}},
+			requestedBy: "oidc:bruno",
+			approve:     http.StatusNotFound, reject: http.StatusOK,
+		},
+		{
+			name:        "request without principal",
+			principal:   &auth.Principal{Subject: "oidc:ana", Roles: []string{auth.RoleAdmin}, Projects: []string{"proj-destino"}},
+			requestedBy: "",
+			approve:     http.StatusNotFound, reject: http.StatusOK,
+		},
+		{
+			name:        "source project admin",
+			principal:   &auth.Principal{Subject: "oidc:bruno", Roles: []string{auth.RoleAdmin}, Projects: []string{"proj-origem"}},
+			requestedBy: "oidc:carla",
+			approve:     http.StatusForbidden, reject: http.StatusForbidden,
+			err: "only an admin of project proj-destino can decide this transfer",
+		},
+		{
+			name:        "target project operator",
+			principal:   &auth.Principal{Subject: "oidc:ana", Roles: []string{auth.RoleOperator}, Projects: []string{"proj-destino"}},
+			requestedBy: "oidc:bruno",
+			approve:     http.StatusForbidden, reject: http.StatusForbidden,
+			err: "role admin required",
+		},
+		{
+			name:        "requester is target project admin",
+			principal:   &auth.Principal{Subject: "oidc:ana", Roles: []string{auth.RoleAdmin}, Projects: []string{"proj-origem", "proj-destino"}},
+			requestedBy: "oidc:ana",
+			approve:     http.StatusForbidden, reject: http.StatusForbidden,
+			err: "project transfer cannot be decided by its requester",
+		},
+		{
+			name:        "requester is admin without projects",
+			principal:   &auth.Principal{Subject: auth.StaticTokenSubject, Roles: []string{auth.RoleAdmin}},
+			requestedBy: auth.StaticTokenSubject,
+			approve:     http.StatusForbidden, reject: http.StatusForbidden,
+			err: "project transfer cannot be decided by its requester",
+		},
+		{
+			name:        "unauthenticated",
+			requestedBy: "oidc:bruno",
+			approve:     http.StatusUnauthorized, reject: http.StatusUnauthorized,
+			err: "authentication required",
+		},
+	}
+	for _, tt := range tests {
+		for _, route := range []struct {
+			action string
+			status int
+		}{{"approve", tt.approve}, {"reject", tt.reject}} {
+			t.Run(tt.name+"/"+route.action, func(t *testing.T) {
+				r, pt, agents := newProjectRouter(t, tt.principal, tt.requestedBy)
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/agents/agent-1/transfer-project/"+route.action, nil))
+				if w.Code != route.status {
+					t.Fatalf("status %d, want %d: %s", w.Code, route.status, w.Body.String())
+				}
+				decided := agents.calls > 0 || len(pt.updates) > 0
+				if authorized := route.status < http.StatusUnauthorized || route.status == http.StatusNotFound; decided != authorized {
+					t.Errorf("transferência decidida = %v, want %v", decided, authorized)
+				}
+				if tt.err != "" {
+					var body struct {
+						Error string `json:"error"`
+					}
+					json.Unmarshal(w.Body.Bytes(), &body)
+					if body.Error != tt.err {
+						t.Errorf("erro %q, want %q", body.Error, tt.err)
+					}
+				}
+				if route.action == "reject" && route.status == http.StatusOK {
+					var got ProjectTransfer
+					json.Unmarshal(w.Body.Bytes(), &got)
+					if got.Status != ProjectRejected || got.DecidedBy != tt.principal.Subject {
+						t.Errorf("transferência %+v", got)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package transfer
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"smart-city-microservices/internal/instrument"
+)
+
+// Repository grava as transferências no PostgreSQL.
+type Repository struct {
+	db *instrument.DB
+}
+
+// NewRepository cria o repositório.
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: instrument.NewDB(db)}
+}
+
+// Move é uma transferência: o agente sai de From e entra em To.
+type Move struct {
+	AgentID string
+	From    string
+	To      string
+	// MaxAgents limita os agentes de To; 0 não limita.
+	MaxAgents int
+	// Paused indica que o agente foi pausado pela transferência.
+	Paused bool
+	By     string
+}
+
+// Move transfere o agente numa transação: confere o limite de agentes do
+// destino (*QuotaError), troca a simulação do agente, limpa o seu estado de
+// execução e registra a transferência no log de eventos das duas
+// simulações. A linha da simulação de destino fica bloqueada até o fim,
+// para que transferências simultâneas não passem juntas do limite. Retorna
+// ErrMoved se o agente já não está em From ou To não existe.
+func (r *Repository) Move(ctx context.Context, m Move) (err error) {
+	span := instrument.StartQuery(ctx, "transfer.move")
+	defer func() { span.End(-1, err) }()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var locked int
+	err = tx.QueryRowContext(ctx, `SELECT 1 FROM simulations WHERE id = $1 FOR UPDATE`, m.To).Scan(&locked)
+	if err == sql.ErrNoRows {
+		return ErrMoved
+	}
+	if err != nil {
+		return err
+	}
+	if m.MaxAgents > 0 {
+		var n int
+		if err := tx.QueryRowContext(ctx, `SELECT count(*) FROM agents WHERE simulation_id = $1`, m.To).Scan(&n); err != nil {
+			return err
+		}
+		if n >= m.MaxAgents {
+			return &QuotaError{SimulationID: m.To, Limit: m.MaxAgents, Agents: n}
+		}
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE agents SET simulation_id = $2, state = '{}', updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND simulation_id = $3`, m.AgentID, m.To, m.From)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrMoved
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"source_simulation_id": m.From,
+		"target_simulation_id": m.To,
+		"paused":               m.Paused,
+		"transferred_by":       m.By,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO events (simulation_id, agent_id, event_type, data, source)
+		VALUES ($1, $3, $4, $5, 'transfer'), ($2, $3, $6, $5, 'transfer')`,
+		m.From, m.To, m.AgentID, logTransferredOut, string(data), logTransferredIn); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// projectListLimit limita as transferências listadas por projeto.
+const projectListLimit = 500
+
+// projectColumns lê como expired a transferência pendente que venceu.
+const projectColumns = `id, agent_id, agent_type, source_project_id, target_project_id, source_simulation_id, target_simulation_id,
+	CASE WHEN status = 'pending' AND expires_at <= CURRENT_TIMESTAMP THEN 'expired' ELSE status END,
+	force, paused, COALESCE(requested_by, ''), COALESCE(decided_by, ''), COALESCE(reason, ''), created_at, expires_at, decided_at`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanProject(row scanner) (*ProjectTransfer, error) {
+	var t ProjectTransfer
+	err := row.Scan(&t.ID, &t.AgentID, &t.AgentType, &t.SourceProjectID, &t.TargetProjectID, &t.SourceSimulationID,
+		&t.TargetSimulationID, &t.Status, &t.Force, &t.Paused, &t.RequestedBy, &t.DecidedBy, &t.Reason,
+		&t.CreatedAt, &t.ExpiresAt, &t.DecidedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// CreateProject grava o pedido de transferência entre projetos e preenche
+// ID, Status e CreatedAt. A pendente vencida do agente é encerrada antes;
+// ErrPending indica outra ainda pendente.
+func (r *Repository) CreateProject(ctx context.Context, t *ProjectTransfer) (err error) {
+	span := instrument.StartQuery(ctx, "transfer.create_project")
+	defer func() { span.End(-1, err) }()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE agent_project_transfers SET status = $2
+		WHERE agent_id = $1 AND status = $3 AND expires_at <= CURRENT_TIMESTAMP`, t.AgentID, ProjectExpired, ProjectPending); err != nil {
+		return err
+	}
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO agent_project_transfers (agent_id, agent_type, source_project_id, target_project_id,
+			source_simulation_id, target_simulation_id, force, requested_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), $9)
+		ON CONFLICT (agent_id) WHERE status = 'pending' DO NOTHING
+		RETURNING id, status, created_at`,
+		t.AgentID, t.AgentType, t.SourceProjectID, t.TargetProjectID, t.SourceSimulationID, t.TargetSimulationID,
+		t.Force, t.RequestedBy, t.ExpiresAt).Scan(&t.ID, &t.Status, &t.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrPending
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// LatestProject retorna a transferência entre projetos mais recente do
+// agente, ou ErrNotFound.
+func (r *Repository) LatestProject(ctx context.Context, agentID string) (*ProjectTransfer, error) {
+	return scanProject(r.db.QueryRow(ctx, "transfer.latest_project", `
+		SELECT `+projectColumns+` FROM agent_project_transfers
+		WHERE agent_id::text = $1 ORDER BY created_at DESC LIMIT 1`, agentID))
+}
+
+// ListProject lista as transferências que saem do projeto ou chegam a
+// ele, das mais novas para as mais antigas; status vazio não filtra.
+func (r *Repository) ListProject(ctx context.Context, projectID, status string) ([]*ProjectTransfer, error) {
+	rows, err := r.db.Query(ctx, "transfer.list_project", `
+		SELECT `+projectColumns+` FROM agent_project_transfers
+		WHERE (source_project_id = $1 OR target_project_id = $1)
+			AND ($2 = '' OR CASE WHEN status = 'pending' AND expires_at <= CURRENT_TIMESTAMP THEN 'expired' ELSE status END = $2)
+		ORDER BY created_at DESC LIMIT $3`, projectID, status, projectListLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []*ProjectTransfer{}
+	for rows.Next() {
+		t, err := scanProject(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// ApproveProject aprova a transferência numa transação: confere que ela
+// ainda está pendente (ErrDecided), que a simulação de destino ainda é do
+// projeto de destino e que o agente está na de origem (ErrMoved), e o
+// limite de agentes (*QuotaError); troca a simulação do agente, limpa o
+// seu estado de execução, tira-o dos grupos dos outros projetos e registra
+// a transferência, com os dois projetos, no log de eventos das duas
+// simulações. Preenche Status, Paused, DecidedBy e DecidedAt.
+func (r *Repository) ApproveProject(ctx context.Context, t *ProjectTransfer, maxAgents int, paused bool, by string) (err error) {
+	span := instrument.StartQuery(ctx, "transfer.approve_project")
+	defer func() { span.End(-1, err) }()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var locked int
+	err = tx.QueryRowContext(ctx, `
+		SELECT 1 FROM agent_project_transfers
+		WHERE id = $1 AND status = $2 AND expires_at > CURRENT_TIMESTAMP FOR UPDATE`, t.ID, ProjectPending).Scan(&locked)
+	if err == sql.ErrNoRows {
+		return ErrDecided
+	}
+	if err != nil {
+		return err
+	}
+	var project string
+	err = tx.QueryRowContext(ctx, `SELECT COALESCE(project_id, '') FROM simulations WHERE id = $1 FOR UPDATE`, t.TargetSimulationID).Scan(&project)
+	if err == sql.ErrNoRows || (err == nil && project != t.TargetProjectID) {
+		return ErrMoved
+	}
+	if err != nil {
+		return err
+	}
+	if maxAgents > 0 {
+		var n int
+		if err := tx.QueryRowContext(ctx, `SELECT count(*) FROM agents WHERE simulation_id = $1`, t.TargetSimulationID).Scan(&n); err != nil {
+			return err
+		}
+		if n >= maxAgents {
+			return &QuotaError{SimulationID: t.TargetSimulationID, Limit: maxAgents, Agents: n}
+		}
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE agents SET simulation_id = $2, state = '{}', updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND simulation_id = $3`, t.AgentID, t.TargetSimulationID, t.SourceSimulationID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrMoved
+	}
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM group_memberships
+		WHERE agent_id = $1 AND group_id IN (SELECT id FROM groups WHERE project_id <> $2)`, t.AgentID, t.TargetProjectID); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"transfer_id":          t.ID,
+		"source_project_id":    t.SourceProjectID,
+		"target_project_id":    t.TargetProjectID,
+		"source_simulation_id": t.SourceSimulationID,
+		"target_simulation_id": t.TargetSimulationID,
+		"paused":               paused,
+		"requested_by":         t.RequestedBy,
+		"approved_by":          by,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO events (simulation_id, agent_id, event_type, data, source)
+		VALUES ($1, $3, $4, $5, 'transfer'), ($2, $3, $6, $5, 'transfer')`,
+		t.SourceSimulationID, t.TargetSimulationID, t.AgentID, logProjectOut, string(data), logProjectIn); err != nil {
+		return err
+	}
+	if err := tx.QueryRowContext(ctx, `
+		UPDATE agent_project_transfers
+		SET status = $2, paused = $3, decided_by = NULLIF($4, ''), decided_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING status, paused, COALESCE(decided_by, ''), decided_at`, t.ID, ProjectApproved, paused, by).
+		Scan(&t.Status, &t.Paused, &t.DecidedBy, &t.DecidedAt); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RejectProject recusa a transferência pendente, ou retorna ErrDecided.
+// Preenche Status, DecidedBy, Reason e DecidedAt.
+func (r *Repository) RejectProject(ctx context.Context, t *ProjectTransfer, by, reason string) error {
+	err := r.db.QueryRow(ctx, "transfer.reject_project", `
+		UPDATE agent_project_transfers
+		SET status = $2, decided_by = NULLIF($3, ''), reason = NULLIF($4, ''), decided_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status = $5 AND expires_at > CURRENT_TIMESTAMP
+		RETURNING status, COALESCE(decided_by, ''), COALESCE(reason, ''), decided_at`, t.ID, ProjectRejected, by, reason, ProjectPending).
+		Scan(&t.Status, &t.DecidedBy, &t.Reason, &t.DecidedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrDecided
+	}
+	return err
+}
@@ -0,0 +1,57 @@
+// Package transfer move agentes entre simulações do mesmo projeto, ex.: de
+// uma simulação de testes, onde foram ajustados, para a de produção. A
+// troca de simulação, a limpeza do estado de execução e os registros no
+// log de eventos das duas simulações acontecem numa só transação; a
+// simulação de destino limita os agentes que recebe. Entre projetos, a
+// transferência depende da aprovação do projeto de destino (project.go).
+package transfer
+
+import (
+	"errors"
+	"fmt"
+
+	"smart-city-microservices/internal/agent"
+)
+
+// EventTransferred é publicado quando um agente muda de simulação.
+const EventTransferred = "agent.transferred"
+
+// Tipos dos registros gravados no log de eventos (tabela events) da
+// simulação de origem e da de destino.
+const (
+	logTransferredOut = "agent.transferred_out"
+	logTransferredIn  = "agent.transferred_in"
+)
+
+// ErrMoved indica que o agente deixou a simulação de origem, ou a de
+// destino deixou de existir, durante a transferência.
+var ErrMoved = errors.New("agent or target simulation changed during the transfer")
+
+// QuotaError indica que a simulação de destino já tem o máximo de agentes.
+type QuotaError struct {
+	SimulationID string
+	Limit        int
+	Agents       int
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("target simulation already has %d agents, the limit is %d", e.Agents, e.Limit)
+}
+
+func (e *QuotaError) Unwrap() error { return agent.ErrValidation }
+
+// MaxAgents retorna o limite de agentes da simulação: config.max_agents,
+// se declarado, ou def; 0 não limita.
+func MaxAgents(sim *agent.Simulation, def int) int {
+	switch v := sim.Config["max_agents"].(type) {
+	case float64:
+		if v >= 0 {
+			return int(v)
+		}
+	case int:
+		if v >= 0 {
+			return v
+		}
+	}
+	return def
+}
@@ -0,0 +1,218 @@
+package twin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/audit"
+	"smart-city-microservices/internal/auth"
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/i18n"
+	"smart-city-microservices/internal/logging"
+)
+
+// AgentGetter é o subconjunto de agent.Service usado pelo handler.
+type AgentGetter interface {
+	GetAgent(ctx context.Context, id string) (*agent.Agent, error)
+}
+
+// Config limita os documentos aceitos.
+type Config struct {
+	MaxDocumentBytes int
+	MaxDepth         int
+}
+
+// Handler expõe os gêmeos digitais dos agentes.
+type Handler struct {
+	repo      *Repository
+	agents    AgentGetter
+	publisher events.Publisher
+	cfg       Config
+}
+
+// NewHandler cria o handler de gêmeos digitais.
+func NewHandler(repo *Repository, agents AgentGetter, publisher events.Publisher, cfg Config) *Handler {
+	return &Handler{repo: repo, agents: agents, publisher: publisher, cfg: cfg}
+}
+
+// ReportRequest é o corpo de PUT /agents/:id/twin: o estado completo do
+// dispositivo. Com version, o envio só é aceito se a versão reportada ainda
+// for essa.
+type ReportRequest struct {
+	Reported map[string]interface{} `json:"reported" binding:"required"`
+	Version  *int64                 `json:"version"`
+}
+
+// ReportResponse é a resposta de PUT /agents/:id/twin: as mudanças do envio
+// e o estado desejado, para o gateway reconciliar o dispositivo.
+type ReportResponse struct {
+	AgentID         string                 `json:"agent_id"`
+	ReportedVersion int64                  `json:"reported_version"`
+	Changes         []Change               `json:"changes"`
+	Desired         map[string]interface{} `json:"desired"`
+	DesiredVersion  int64                  `json:"desired_version"`
+}
+
+// DesiredPatchRequest é o corpo de PATCH /agents/:id/twin/desired: um JSON
+// Merge Patch sobre o estado desejado na versão version.
+type DesiredPatchRequest struct {
+	Desired map[string]interface{} `json:"desired" binding:"required"`
+	Version *int64                 `json:"version" binding:"required"`
+}
+
+// Get responde GET /agents/:id/twin.
+func (h *Handler) Get(c *gin.Context) {
+	ag, ok := h.agent(c)
+	if !ok {
+		return
+	}
+	t, err := h.repo.Get(c.Request.Context(), ag.ID)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, t)
+}
+
+// Report responde PUT /agents/:id/twin: grava o estado reportado e, se algo
+// mudou, publica EventReported só com os caminhos alterados.
+func (h *Handler) Report(c *gin.Context) {
+	var req ReportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		i18n.BindError(c, err)
+		return
+	}
+	if !h.checkDocument(c, "reported", req.Reported) {
+		return
+	}
+	ag, ok := h.agent(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	t, changes, err := h.repo.Report(ctx, ag.ID, req.Reported, req.Version)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	if len(changes) > 0 {
+		h.publisher.Publish(ctx, events.New(events.TopicAgents, EventReported, events.AgentTwinV1{
+			AgentID:      ag.ID,
+			AgentType:    ag.Type,
+			SimulationID: ag.SimulationID,
+			ProjectID:    ag.ProjectID,
+			Version:      t.ReportedVersion,
+			Changes:      eventChanges(changes),
+		}))
+	}
+	if changes == nil {
+		changes = []Change{}
+	}
+	c.JSON(http.StatusOK, ReportResponse{
+		AgentID:         ag.ID,
+		ReportedVersion: t.ReportedVersion,
+		Changes:         changes,
+		Desired:         t.Desired,
+		DesiredVersion:  t.DesiredVersion,
+	})
+}
+
+// PatchDesired responde PATCH /agents/:id/twin/desired: aplica o patch ao
+// estado desejado, se a versão enviada ainda for a atual (senão 409 com a
+// atual), e publica EventDesiredChanged se algo mudou.
+func (h *Handler) PatchDesired(c *gin.Context) {
+	var req DesiredPatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		i18n.BindError(c, err)
+		return
+	}
+	if !h.checkDocument(c, "desired", req.Desired) {
+		return
+	}
+	ag, ok := h.agent(c)
+	if !ok {
+		return
+	}
+	var by string
+	if p := auth.FromGin(c); p != nil {
+		by = p.Subject
+	}
+	ctx := c.Request.Context()
+	t, changes, err := h.repo.PatchDesired(ctx, ag.ID, req.Desired, *req.Version, by)
+	if err != nil {
+		h.writeError(c, err)
+		return
+	}
+	if len(changes) > 0 {
+		audit.Record(ctx, "agent.twin_desired_changed", logrus.Fields{"agent_id": ag.ID, "version": t.DesiredVersion, "changes": len(changes)})
+		h.publisher.Publish(ctx, events.New(events.TopicAgents, EventDesiredChanged, events.AgentTwinV1{
+			AgentID:      ag.ID,
+			AgentType:    ag.Type,
+			SimulationID: ag.SimulationID,
+			ProjectID:    ag.ProjectID,
+			Version:      t.DesiredVersion,
+			Changes:      eventChanges(changes),
+			Desired:      t.Desired,
+			UpdatedBy:    by,
+		}))
+	}
+	c.JSON(http.StatusOK, t)
+}
+
+// checkDocument aplica os limites de tamanho e aninhamento ao documento.
+// Responde ao cliente e retorna false se ele passa de algum.
+func (h *Handler) checkDocument(c *gin.Context, field string, doc map[string]interface{}) bool {
+	if raw, err := json.Marshal(doc); err != nil || len(raw) > h.cfg.MaxDocumentBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("%s must be at most %d bytes", field, h.cfg.MaxDocumentBytes)})
+		return false
+	}
+	if d := Depth(doc); d > h.cfg.MaxDepth {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("%s is nested %d levels deep, the limit is %d", field, d, h.cfg.MaxDepth)})
+		return false
+	}
+	return true
+}
+
+func (h *Handler) writeError(c *gin.Context, err error) {
+	var conflict *VersionError
+	if errors.As(err, &conflict) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "side": conflict.Side, "current_version": conflict.Current})
+		return
+	}
+	h.internalError(c, err)
+}
+
+// agent busca o agente de :id. Responde ao cliente e retorna false se ele
+// não existe ou a busca falhou.
+func (h *Handler) agent(c *gin.Context) (*agent.Agent, bool) {
+	ag, err := h.agents.GetAgent(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, agent.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "agent not found"})
+		return nil, false
+	}
+	if err != nil {
+		h.internalError(c, err)
+		return nil, false
+	}
+	return ag, true
+}
+
+func eventChanges(changes []Change) []events.TwinChangeV1 {
+	out := make([]events.TwinChangeV1, len(changes))
+	for i, ch := range changes {
+		out[i] = events.TwinChangeV1{Path: ch.Path, Op: ch.Op, Old: ch.Old, New: ch.New}
+	}
+	return out
+}
+
+func (h *Handler) internalError(c *gin.Context, err error) {
+	logging.FromContext(c.Request.Context()).WithError(err).Error("Erro no handler de gêmeos digitais")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
@@ -0,0 +1,146 @@
+package twin
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"smart-city-microservices/internal/instrument"
+)
+
+// Repository persiste os gêmeos digitais no PostgreSQL.
+type Repository struct {
+	db *instrument.DB
+}
+
+// NewRepository cria o repositório.
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: instrument.NewDB(db)}
+}
+
+const twinColumns = `reported, reported_version, reported_at, desired, desired_version, desired_at, COALESCE(desired_by, '')`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanTwin(row scanner, agentID string) (*Twin, error) {
+	t := &Twin{AgentID: agentID}
+	var reported, desired []byte
+	var reportedAt, desiredAt sql.NullTime
+	if err := row.Scan(&reported, &t.ReportedVersion, &reportedAt, &desired, &t.DesiredVersion, &desiredAt, &t.DesiredBy); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(reported, &t.Reported); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(desired, &t.Desired); err != nil {
+		return nil, err
+	}
+	if reportedAt.Valid {
+		t.ReportedAt = &reportedAt.Time
+	}
+	if desiredAt.Valid {
+		t.DesiredAt = &desiredAt.Time
+	}
+	return t, nil
+}
+
+// Get retorna o gêmeo do agente, vazio se nada foi reportado nem desejado.
+func (r *Repository) Get(ctx context.Context, agentID string) (*Twin, error) {
+	t, err := scanTwin(r.db.QueryRow(ctx, "twin.get",
+		`SELECT `+twinColumns+` FROM agent_twins WHERE agent_id = $1`, agentID), agentID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return &Twin{AgentID: agentID, Reported: map[string]interface{}{}, Desired: map[string]interface{}{}}, nil
+	}
+	return t, err
+}
+
+// Report grava o documento reportado e retorna o gêmeo e as mudanças em
+// relação ao anterior. A versão reportada só avança se houve mudança. Com
+// expected, o documento só é gravado se a versão reportada ainda for essa
+// (senão *VersionError).
+func (r *Repository) Report(ctx context.Context, agentID string, doc map[string]interface{}, expected *int64) (*Twin, []Change, error) {
+	var changes []Change
+	t, err := r.update(ctx, "twin.report", agentID, func(t *Twin) (bool, error) {
+		if expected != nil && *expected != t.ReportedVersion {
+			return false, &VersionError{Side: SideReported, Expected: *expected, Current: t.ReportedVersion}
+		}
+		changes = Diff(t.Reported, doc)
+		now := time.Now()
+		t.ReportedAt = &now
+		if len(changes) > 0 {
+			t.Reported = doc
+			t.ReportedVersion++
+		}
+		return true, nil
+	})
+	return t, changes, err
+}
+
+// PatchDesired aplica patch (JSON Merge Patch) ao documento desejado, se a
+// versão desejada ainda for expected (senão *VersionError), e retorna o
+// gêmeo e as mudanças. A versão só avança se houve mudança.
+func (r *Repository) PatchDesired(ctx context.Context, agentID string, patch map[string]interface{}, expected int64, by string) (*Twin, []Change, error) {
+	var changes []Change
+	t, err := r.update(ctx, "twin.patch_desired", agentID, func(t *Twin) (bool, error) {
+		if expected != t.DesiredVersion {
+			return false, &VersionError{Side: SideDesired, Expected: expected, Current: t.DesiredVersion}
+		}
+		next := Merge(t.Desired, patch)
+		changes = Diff(t.Desired, next)
+		if len(changes) == 0 {
+			return false, nil
+		}
+		now := time.Now()
+		t.Desired, t.DesiredVersion, t.DesiredAt, t.DesiredBy = next, t.DesiredVersion+1, &now, by
+		return true, nil
+	})
+	return t, changes, err
+}
+
+// update lê o gêmeo com a linha bloqueada, aplica fn e grava o resultado se
+// fn pedir; assim envios simultâneos do mesmo agente são comparados um
+// depois do outro.
+func (r *Repository) update(ctx context.Context, name, agentID string, fn func(*Twin) (bool, error)) (t *Twin, err error) {
+	span := instrument.StartQuery(ctx, name)
+	defer func() { span.End(-1, err) }()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `INSERT INTO agent_twins (agent_id) VALUES ($1) ON CONFLICT (agent_id) DO NOTHING`, agentID); err != nil {
+		return nil, err
+	}
+	t, err = scanTwin(tx.QueryRowContext(ctx,
+		`SELECT `+twinColumns+` FROM agent_twins WHERE agent_id = $1 FOR UPDATE`, agentID), agentID)
+	if err != nil {
+		return nil, err
+	}
+	write, err := fn(t)
+	if err != nil || !write {
+		return t, err
+	}
+	reported, err := json.Marshal(t.Reported)
+	if err != nil {
+		return nil, err
+	}
+	desired, err := json.Marshal(t.Desired)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE agent_twins SET reported = $2, reported_version = $3, reported_at = $4,
+			desired = $5, desired_version = $6, desired_at = $7, desired_by = NULLIF($8, '')
+		WHERE agent_id = $1`,
+		agentID, string(reported), t.ReportedVersion, t.ReportedAt,
+		string(desired), t.DesiredVersion, t.DesiredAt, t.DesiredBy); err != nil {
+		return nil, err
+	}
+	return t, tx.Commit()
+}
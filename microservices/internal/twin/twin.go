@@ -0,0 +1,150 @@
+// Package twin mantém o gêmeo digital de cada agente: o estado reportado
+// pelo dispositivo, enviado inteiro pelos gateways de campo, e o estado
+// desejado, editado pelos operadores. A cada envio o serviço calcula a
+// diferença estrutural contra o reportado anterior e publica só os
+// caminhos alterados. Os dois lados têm versão própria, para que uma
+// escrita baseada numa versão velha seja recusada em vez de sobrescrever a
+// outra.
+package twin
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Eventos publicados em events.TopicAgents.
+const (
+	EventReported       = "agent.twin_reported"
+	EventDesiredChanged = "agent.twin_desired_changed"
+)
+
+// Operações de Change.
+const (
+	OpAdded   = "added"
+	OpRemoved = "removed"
+	OpChanged = "changed"
+)
+
+// Lados do gêmeo, em VersionError.
+const (
+	SideReported = "reported"
+	SideDesired  = "desired"
+)
+
+// Twin é o gêmeo digital de um agente. Um agente sem gêmeo tem os dois
+// documentos vazios na versão 0.
+type Twin struct {
+	AgentID         string                 `json:"agent_id"`
+	Reported        map[string]interface{} `json:"reported"`
+	ReportedVersion int64                  `json:"reported_version"`
+	ReportedAt      *time.Time             `json:"reported_at"`
+	Desired         map[string]interface{} `json:"desired"`
+	DesiredVersion  int64                  `json:"desired_version"`
+	DesiredAt       *time.Time             `json:"desired_at"`
+	DesiredBy       string                 `json:"desired_by,omitempty"`
+}
+
+// Change é um caminho alterado entre dois documentos. Path é um JSON
+// Pointer (RFC 6901); Old não vem em OpAdded nem New em OpRemoved.
+type Change struct {
+	Path string      `json:"path"`
+	Op   string      `json:"op"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+// VersionError indica que a escrita partiu de uma versão que não é mais a
+// atual do lado Side.
+type VersionError struct {
+	Side     string
+	Expected int64
+	Current  int64
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("%s version is %d, not %d", e.Side, e.Current, e.Expected)
+}
+
+// Diff compara os documentos recursivamente pelos objetos; arrays e
+// valores escalares são comparados inteiros. As mudanças saem ordenadas
+// pelo caminho.
+func Diff(prev, next map[string]interface{}) []Change {
+	var out []Change
+	diff("", prev, next, &out)
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out
+}
+
+func diff(prefix string, prev, next map[string]interface{}, out *[]Change) {
+	for k, old := range prev {
+		path := prefix + "/" + escape(k)
+		nv, ok := next[k]
+		if !ok {
+			*out = append(*out, Change{Path: path, Op: OpRemoved, Old: old})
+			continue
+		}
+		om, oIsMap := old.(map[string]interface{})
+		nm, nIsMap := nv.(map[string]interface{})
+		if oIsMap && nIsMap {
+			diff(path, om, nm, out)
+			continue
+		}
+		if !reflect.DeepEqual(old, nv) {
+			*out = append(*out, Change{Path: path, Op: OpChanged, Old: old, New: nv})
+		}
+	}
+	for k, nv := range next {
+		if _, ok := prev[k]; !ok {
+			*out = append(*out, Change{Path: prefix + "/" + escape(k), Op: OpAdded, New: nv})
+		}
+	}
+}
+
+func escape(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
+
+// Merge aplica patch sobre doc como um JSON Merge Patch (RFC 7386): null
+// remove a chave e objetos se combinam recursivamente. doc não é alterado.
+func Merge(doc, patch map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(doc)+len(patch))
+	for k, v := range doc {
+		out[k] = v
+	}
+	for k, v := range patch {
+		if v == nil {
+			delete(out, k)
+			continue
+		}
+		pm, ok := v.(map[string]interface{})
+		if !ok {
+			out[k] = v
+			continue
+		}
+		cur, _ := out[k].(map[string]interface{})
+		out[k] = Merge(cur, pm)
+	}
+	return out
+}
+
+// Depth retorna o aninhamento de objetos e arrays do valor; um documento
+// sem aninhamento tem profundidade 1.
+func Depth(v interface{}) int {
+	d := 0
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for _, e := range t {
+			d = max(d, Depth(e))
+		}
+	case []interface{}:
+		for _, e := range t {
+			d = max(d, Depth(e))
+		}
+	default:
+		return 0
+	}
+	return d + 1
+}
@@ -0,0 +1,279 @@
+// Package warmup acompanha o aquecimento das simulações: os primeiros
+// config.warmup_ticks ticks de uma execução, em que a simulação roda
+// normalmente mas as amostras são transitórias. Os agregados dos KPIs e das
+// métricas por simulação as marcam como de aquecimento e as deixam fora dos
+// resumos, exceto com include_warmup=true.
+//
+// O número de ticks é fixado na criação da simulação (CreateSimulation, que
+// o move do topo do corpo para a configuração) e lido uma vez por
+// simulação. O fim do aquecimento publica simulation.warmup_completed uma
+// única vez, mesmo com a troca da réplica que avança o relógio.
+package warmup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/agent"
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/logging"
+)
+
+// ConfigKey é a chave do número de ticks de aquecimento em
+// Simulation.Config.
+const ConfigKey = "warmup_ticks"
+
+// EventCompleted é publicado no primeiro tick depois do aquecimento.
+const EventCompleted = "simulation.warmup_completed"
+
+// completedPrefix marca no Redis as simulações cujo fim do aquecimento já
+// foi publicado.
+const completedPrefix = "agent-service:warmup:completed:"
+
+// completedTTL é por quanto tempo a marca do fim do aquecimento fica no
+// Redis.
+const completedTTL = 7 * 24 * time.Hour
+
+// tickCacheFor é por quanto tempo o tick lido do Redis vale em InWarmup.
+const tickCacheFor = time.Second
+
+// idleAfter é o tempo sem consultas depois do qual o estado de uma
+// simulação é descartado.
+const idleAfter = 10 * time.Minute
+
+// SimulationSource é o subconjunto de agent.Service usado pelo
+// acompanhamento.
+type SimulationSource interface {
+	GetSimulation(ctx context.Context, id string) (*agent.Simulation, error)
+}
+
+// TickSource dá o tick atual da simulação (agentmsg.Bus).
+type TickSource interface {
+	CurrentTick(ctx context.Context, simulationID string) (int64, error)
+}
+
+// Ticks retorna os ticks de aquecimento declarados na simulação, ou 0.
+func Ticks(sim *agent.Simulation) int64 {
+	switch v := sim.Config[ConfigKey].(type) {
+	case float64:
+		if v > 0 {
+			return int64(v)
+		}
+	case int:
+		if v > 0 {
+			return int64(v)
+		}
+	case int64:
+		if v > 0 {
+			return v
+		}
+	}
+	return 0
+}
+
+// state é o aquecimento conhecido de uma simulação.
+type state struct {
+	ticks int64
+	// done fica true no fim do aquecimento e não volta.
+	done     bool
+	tick     int64
+	tickedAt time.Time
+	seen     time.Time
+}
+
+// Tracker diz se uma simulação está no aquecimento e publica o fim dele.
+// Implementa agentmsg.TickObserver.
+type Tracker struct {
+	simulations SimulationSource
+	ticks       TickSource
+	redis       redis.UniversalClient
+	publisher   events.Publisher
+
+	mu     sync.Mutex
+	states map[string]*state
+}
+
+// NewTracker cria o acompanhamento do aquecimento.
+func NewTracker(simulations SimulationSource, ticks TickSource, client redis.UniversalClient, publisher events.Publisher) *Tracker {
+	return &Tracker{simulations: simulations, ticks: ticks, redis: client, publisher: publisher, states: map[string]*state{}}
+}
+
+// load retorna o estado da simulação, lendo os ticks de aquecimento na
+// primeira vez. Uma simulação que não pôde ser lida é relida na próxima
+// consulta.
+func (t *Tracker) load(ctx context.Context, simulationID string) (*state, error) {
+	t.mu.Lock()
+	s, ok := t.states[simulationID]
+	t.mu.Unlock()
+	if ok {
+		return s, nil
+	}
+	sim, err := t.simulations.GetSimulation(ctx, simulationID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if s, ok = t.states[simulationID]; !ok {
+		s = &state{ticks: Ticks(sim), seen: now}
+		s.done = s.ticks == 0
+		t.states[simulationID] = s
+		t.prune(now)
+	}
+	return s, nil
+}
+
+// prune descarta os estados sem consultas. Chamado com mu travado.
+func (t *Tracker) prune(now time.Time) {
+	for id, s := range t.states {
+		if now.Sub(s.seen) >= idleAfter {
+			delete(t.states, id)
+		}
+	}
+}
+
+// InWarmup diz se a simulação ainda está no aquecimento: o tick atual não
+// passou de config.warmup_ticks. O tick é relido do Redis no máximo uma vez
+// por segundo; com o Redis ou o banco fora, a amostra conta como fora do
+// aquecimento.
+func (t *Tracker) InWarmup(ctx context.Context, simulationID string) bool {
+	s, err := t.load(ctx, simulationID)
+	if err != nil {
+		return false
+	}
+	now := time.Now()
+	t.mu.Lock()
+	s.seen = now
+	done, fresh := s.done, now.Sub(s.tickedAt) < tickCacheFor
+	tick := s.tick
+	t.mu.Unlock()
+	if done {
+		return false
+	}
+	if !fresh {
+		n, err := t.ticks.CurrentTick(ctx, simulationID)
+		if err != nil {
+			return false
+		}
+		t.mu.Lock()
+		if n > s.tick {
+			s.tick = n
+		}
+		s.tickedAt, tick = now, s.tick
+		t.mu.Unlock()
+	}
+	return tick <= s.ticks
+}
+
+// ObserveTick implementa agentmsg.TickObserver: no primeiro tick depois do
+// aquecimento publica EventCompleted, se nenhuma réplica o publicou antes.
+func (t *Tracker) ObserveTick(simulationID, projectID string, tick int64, _ time.Duration) {
+	ctx := logging.Background(context.Background(), "simulation-warmup")
+	log := logging.FromContext(ctx).WithField("simulation_id", simulationID)
+	s, err := t.load(ctx, simulationID)
+	if err != nil {
+		if !errors.Is(err, agent.ErrNotFound) {
+			log.WithError(err).Warn("Falha ao ler o aquecimento da simulação")
+		}
+		return
+	}
+	now := time.Now()
+	t.mu.Lock()
+	s.seen = now
+	if tick > s.tick {
+		s.tick, s.tickedAt = tick, now
+	}
+	if s.done || tick <= s.ticks {
+		t.mu.Unlock()
+		return
+	}
+	s.done = true
+	warmupTicks := s.ticks
+	t.mu.Unlock()
+
+	first, err := t.redis.SetNX(ctx, completedPrefix+simulationID, tick, completedTTL).Result()
+	if err != nil {
+		log.WithError(err).Warn("Falha ao marcar o fim do aquecimento da simulação; publicando mesmo assim")
+		first = true
+	}
+	if !first {
+		return
+	}
+	t.publisher.Publish(ctx, events.New(events.TopicSimulations, EventCompleted, events.SimulationWarmupCompletedV1{
+		SimulationID: simulationID,
+		ProjectID:    projectID,
+		WarmupTicks:  warmupTicks,
+		Tick:         tick,
+		CompletedAt:  now.UTC(),
+	}))
+	log.WithFields(logrus.Fields{"warmup_ticks": warmupTicks, "tick": tick}).Info("Aquecimento da simulação concluído")
+}
+
+// CreateSimulation deve vir antes de POST /simulations: move warmup_ticks
+// do topo do corpo para config.warmup_ticks e recusa com 400 um valor que
+// não seja um inteiro não negativo. Um corpo ilegível fica para o handler
+// recusar.
+func CreateSimulation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.Next()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		var req map[string]json.RawMessage
+		if json.Unmarshal(body, &req) != nil {
+			c.Next()
+			return
+		}
+		var cfg map[string]interface{}
+		raw, ok := req["config"]
+		if ok && json.Unmarshal(raw, &cfg) != nil {
+			c.Next()
+			return
+		}
+		var ticks interface{}
+		top, moved := req[ConfigKey]
+		if moved {
+			if json.Unmarshal(top, &ticks) != nil {
+				ticks = nil
+			}
+		} else if ticks, ok = cfg[ConfigKey]; !ok || ticks == nil {
+			c.Next()
+			return
+		}
+		n, isNum := ticks.(float64)
+		if !isNum || n < 0 || n != math.Trunc(n) || n > math.MaxInt32 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": ConfigKey + " must be a non-negative integer"})
+			return
+		}
+		if !moved {
+			c.Next()
+			return
+		}
+		if cfg == nil {
+			cfg = map[string]interface{}{}
+		}
+		cfg[ConfigKey] = n
+		delete(req, ConfigKey)
+		if req["config"], err = json.Marshal(cfg); err == nil {
+			if body, err = json.Marshal(req); err == nil {
+				c.Request.Body = io.NopCloser(bytes.NewReader(body))
+				c.Request.ContentLength = int64(len(body))
+			}
+		}
+		c.Next()
+	}
+}
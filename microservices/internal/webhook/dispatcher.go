@@ -0,0 +1,475 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/deadletter"
+	"smart-city-microservices/internal/events"
+	"smart-city-microservices/internal/logging"
+	"smart-city-microservices/internal/outbound"
+	"smart-city-microservices/internal/supervisor"
+)
+
+// Cabeçalhos enviados em cada entrega. A assinatura é
+// "sha256=" + hex(HMAC-SHA256(secret, timestamp + "." + corpo)).
+const (
+	HeaderSignature = "X-Webhook-Signature"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+)
+
+// EventWebhookDisabled é publicado quando um webhook é desativado por falhas.
+const EventWebhookDisabled = "webhook.disabled"
+
+// DeadLetterConsumer identifica as entregas no dead-letter; o destino da
+// entrada é o id do webhook.
+const DeadLetterConsumer = "webhook"
+
+// cacheTTL limita por quanto tempo a lista de webhooks ativos é reaproveitada.
+const cacheTTL = 30 * time.Second
+
+var (
+	deliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "webhook_deliveries_total",
+		Help:      "Tentativas de entrega de webhooks por resultado (success, retry, failure).",
+	}, []string{"result"})
+
+	droppedEvents = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "webhook_events_dropped_total",
+		Help:      "Eventos descartados porque a fila do dispatcher de webhooks estava cheia.",
+	})
+
+	disabledWebhooks = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "agent_service",
+		Name:      "webhook_disabled_total",
+		Help:      "Webhooks desativados automaticamente após falhas consecutivas.",
+	})
+)
+
+// Config controla a entrega dos webhooks.
+type Config struct {
+	Workers        int
+	QueueSize      int
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Timeout        time.Duration
+	// DisableAfter é o número de entregas consecutivas que esgotaram as
+	// tentativas após o qual o webhook é desativado.
+	DisableAfter int
+	// AllowPrivateNetworks permite destinos em loopback e redes privadas.
+	AllowPrivateNetworks bool
+}
+
+// Payload é o corpo JSON enviado aos assinantes: o envelope do evento, na
+// versão fixada pelo webhook em event_versions ou na atual.
+type Payload = events.Envelope
+
+type job struct {
+	webhook      *Webhook
+	deliveryID   string
+	eventType    string
+	body         []byte
+	attempt      int
+	redeliveryOf string
+}
+
+// DeadLetter grava as entregas que esgotaram as tentativas;
+// *deadletter.Queue o implementa.
+type DeadLetter interface {
+	Add(ctx context.Context, e *deadletter.Entry) error
+}
+
+// Dispatcher consome os eventos de agentes e simulações do barramento e os
+// entrega aos webhooks ativos, com novas tentativas em backoff exponencial.
+type Dispatcher struct {
+	repo      *Repository
+	cfg       Config
+	client    *http.Client
+	publisher events.Publisher
+	// deadLetter recebe as entregas que esgotaram as tentativas; nil as
+	// descarta.
+	deadLetter DeadLetter
+
+	events chan events.Event
+	jobs   chan job
+	// done fecha quando Run termina de vez, para as novas tentativas
+	// agendadas.
+	done chan struct{}
+	stop sync.Once
+
+	mu       sync.Mutex
+	cache    []*Webhook
+	cachedAt time.Time
+}
+
+// NewDispatcher cria o dispatcher sobre o pool de saída; a entrega roda em
+// Run. As entregas não são repetidas pelo cliente: as novas tentativas são as
+// do dispatcher, com backoff próprio.
+func NewDispatcher(repo *Repository, cfg Config, pool *outbound.Pool, publisher events.Publisher) *Dispatcher {
+	return &Dispatcher{
+		repo: repo,
+		cfg:  cfg,
+		client: pool.Client(outbound.Options{
+			Name:                 "webhooks",
+			Timeout:              cfg.Timeout,
+			AllowPrivateNetworks: cfg.AllowPrivateNetworks,
+			// Redirecionamentos não são seguidos: o destino é o cadastrado.
+			NoRedirects: true,
+		}),
+		publisher: publisher,
+		events:    make(chan events.Event, cfg.QueueSize),
+		jobs:      make(chan job, cfg.QueueSize),
+		done:      make(chan struct{}),
+	}
+}
+
+// Handle recebe eventos do barramento sem bloquear o Publish; com a fila
+// cheia o evento é descartado e contado.
+func (d *Dispatcher) Handle(_ context.Context, e events.Event) {
+	if e.Topic != events.TopicAgents && e.Topic != events.TopicSimulations {
+		return
+	}
+	select {
+	case d.events <- e:
+	default:
+		droppedEvents.Inc()
+	}
+}
+
+// Run roda o fan-out de eventos e os workers de entrega até ctx ser
+// cancelado. Eventos ainda na fila e novas tentativas agendadas são
+// descartados; o histórico mostra a última tentativa feita.
+func (d *Dispatcher) Run(ctx context.Context) error {
+	g, _ := supervisor.NewGroup(ctx)
+	g.Go(d.fanOut)
+	for i := 0; i < d.cfg.Workers; i++ {
+		g.Go(d.work)
+	}
+	err := g.Wait()
+	if ctx.Err() != nil {
+		d.stop.Do(func() { close(d.done) })
+	}
+	return err
+}
+
+// SetDeadLetter passa a gravar em q as entregas que esgotam as tentativas.
+// Deve ser chamado antes de Run.
+func (d *Dispatcher) SetDeadLetter(q DeadLetter) {
+	d.deadLetter = q
+}
+
+// Replay reenvia ao webhook uma entrega do dead-letter, na hora, e registra
+// a tentativa no histórico como reentrega da original. Webhooks removidos
+// ou desativados recusam o reenvio.
+func (d *Dispatcher) Replay(ctx context.Context, e deadletter.Entry) error {
+	w, err := d.repo.Get(ctx, e.Target)
+	if err != nil {
+		return err
+	}
+	if !w.Active {
+		return errors.New("webhook is disabled")
+	}
+	j := job{webhook: w, deliveryID: uuid.NewString(), eventType: e.EventType, body: e.Payload, attempt: 1, redeliveryOf: e.Meta["delivery_id"]}
+	attempt := Attempt{WebhookID: w.ID, DeliveryID: j.deliveryID, EventType: j.eventType, Attempt: 1, RedeliveryOf: j.redeliveryOf}
+	start := time.Now()
+	status, err := d.post(ctx, j)
+	attempt.DurationMs = time.Since(start).Milliseconds()
+	attempt.StatusCode = status
+	attempt.Success = err == nil
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+	if rerr := d.repo.InsertAttempt(ctx, &attempt, j.body); rerr != nil {
+		logging.FromContext(ctx).WithError(rerr).WithField("webhook_id", w.ID).Warn("Falha ao registrar tentativa de entrega")
+	}
+	if err != nil {
+		return err
+	}
+	if w.ConsecutiveFailures > 0 {
+		d.recordResult(ctx, w, true)
+		d.Invalidate()
+	}
+	return nil
+}
+
+// Invalidate descarta a lista de webhooks ativos em cache após alterações.
+func (d *Dispatcher) Invalidate() {
+	d.mu.Lock()
+	d.cache = nil
+	d.mu.Unlock()
+}
+
+// Redeliver reenvia o corpo de uma entrega anterior com um novo delivery id.
+func (d *Dispatcher) Redeliver(ctx context.Context, w *Webhook, deliveryID string) (string, error) {
+	eventType, body, err := d.repo.Payload(ctx, w.ID, deliveryID)
+	if err != nil {
+		return "", err
+	}
+	j := job{webhook: w, deliveryID: uuid.NewString(), eventType: eventType, body: body, attempt: 1, redeliveryOf: deliveryID}
+	select {
+	case d.jobs <- j:
+		return j.deliveryID, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func (d *Dispatcher) fanOut(ctx context.Context) error {
+	work := logging.Background(context.WithoutCancel(ctx), "webhook-dispatcher")
+	log := logging.FromContext(work)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e := <-d.events:
+			hooks, err := d.active(work)
+			if err != nil {
+				log.WithError(err).Error("Falha ao carregar webhooks ativos; evento descartado")
+				continue
+			}
+			d.dispatch(work, ctx.Done(), e, hooks)
+		}
+	}
+}
+
+// dispatch enfileira as entregas do evento; desiste se stop fechar.
+func (d *Dispatcher) dispatch(ctx context.Context, stop <-chan struct{}, e events.Event, hooks []*Webhook) {
+	// O id do evento é o mesmo em todas as versões; o corpo é serializado uma
+	// vez por versão entregue.
+	id := uuid.NewString()
+	bodies := map[int][]byte{}
+	project := e.ProjectID()
+	for _, w := range hooks {
+		if !w.Accepts(e.Type, project) {
+			continue
+		}
+		log := logging.FromContext(ctx).WithFields(logrus.Fields{"webhook_id": w.ID, "event_type": e.VersionedType()})
+		pins, err := w.Pins()
+		if err != nil {
+			log.WithError(err).Error("Versões fixadas inválidas no webhook; evento não entregue")
+			continue
+		}
+		pinned, err := events.Schemas.Apply(pins, e)
+		if err != nil {
+			log.WithError(err).Error("Evento não entregue: sem conversão para a versão fixada")
+			continue
+		}
+		body, ok := bodies[pinned.Version]
+		if !ok {
+			body, err = events.EncodeEnvelope(id, pinned)
+			if err != nil {
+				log.WithError(err).Error("Falha ao serializar evento para webhook")
+				return
+			}
+			bodies[pinned.Version] = body
+		}
+		select {
+		case d.jobs <- job{webhook: w, deliveryID: uuid.NewString(), eventType: e.Type, body: body, attempt: 1}:
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) active(ctx context.Context) ([]*Webhook, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.cache != nil && time.Since(d.cachedAt) < cacheTTL {
+		return d.cache, nil
+	}
+	hooks, err := d.repo.ListActive(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if hooks == nil {
+		hooks = []*Webhook{}
+	}
+	d.cache, d.cachedAt = hooks, time.Now()
+	return hooks, nil
+}
+
+func (d *Dispatcher) work(ctx context.Context) error {
+	work := logging.Background(context.WithoutCancel(ctx), "webhook-worker")
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case j := <-d.jobs:
+			d.deliver(work, j)
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, j job) {
+	log := logging.FromContext(ctx).WithFields(logrus.Fields{
+		"webhook_id":  j.webhook.ID,
+		"delivery_id": j.deliveryID,
+		"event_type":  j.eventType,
+		"attempt":     j.attempt,
+	})
+
+	attempt := Attempt{
+		WebhookID:    j.webhook.ID,
+		DeliveryID:   j.deliveryID,
+		EventType:    j.eventType,
+		Attempt:      j.attempt,
+		RedeliveryOf: j.redeliveryOf,
+	}
+	start := time.Now()
+	status, err := d.post(ctx, j)
+	attempt.DurationMs = time.Since(start).Milliseconds()
+	attempt.StatusCode = status
+	attempt.Success = err == nil
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+	if rerr := d.repo.InsertAttempt(ctx, &attempt, j.body); rerr != nil {
+		log.WithError(rerr).Warn("Falha ao registrar tentativa de entrega")
+	}
+
+	switch {
+	case err == nil:
+		deliveries.WithLabelValues("success").Inc()
+		// O webhook em cache é compartilhado entre workers e não é alterado;
+		// o contador volta a zero no banco e o cache é recarregado.
+		if j.webhook.ConsecutiveFailures > 0 {
+			d.recordResult(ctx, j.webhook, true)
+			d.Invalidate()
+		}
+	case j.attempt < d.cfg.MaxAttempts:
+		deliveries.WithLabelValues("retry").Inc()
+		delay := d.backoff(j.attempt)
+		log.WithError(err).WithField("retry_in", delay.String()).Warn("Entrega de webhook falhou; nova tentativa agendada")
+		j.attempt++
+		time.AfterFunc(delay, func() {
+			select {
+			case d.jobs <- j:
+			case <-d.done:
+			}
+		})
+	default:
+		deliveries.WithLabelValues("failure").Inc()
+		log.WithError(err).Warn("Entrega de webhook falhou; tentativas esgotadas")
+		d.recordResult(ctx, j.webhook, false)
+		d.deadLettered(ctx, j, err)
+	}
+}
+
+// deadLettered grava no dead-letter a entrega j, que esgotou as tentativas
+// com o erro cause.
+func (d *Dispatcher) deadLettered(ctx context.Context, j job, cause error) {
+	if d.deadLetter == nil {
+		return
+	}
+	entry := &deadletter.Entry{
+		Consumer:  DeadLetterConsumer,
+		Target:    j.webhook.ID,
+		EventType: j.eventType,
+		Payload:   j.body,
+		Error:     cause.Error(),
+		Attempts:  j.attempt,
+		Meta:      map[string]string{"delivery_id": j.deliveryID},
+	}
+	if err := d.deadLetter.Add(ctx, entry); err != nil {
+		logging.FromContext(ctx).WithError(err).WithField("webhook_id", j.webhook.ID).Error("Falha ao gravar entrega no dead-letter")
+	}
+}
+
+func (d *Dispatcher) post(ctx context.Context, j job) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.cfg.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, j.webhook.URL, bytes.NewReader(j.body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "smart-city-agent-service-webhooks")
+	req.Header.Set(HeaderEvent, j.eventType)
+	req.Header.Set(HeaderDelivery, j.deliveryID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(j.webhook.Secret, timestamp, j.body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+func (d *Dispatcher) recordResult(ctx context.Context, w *Webhook, success bool) {
+	log := logging.FromContext(ctx).WithField("webhook_id", w.ID)
+	failures, err := d.repo.RecordResult(ctx, w.ID, success)
+	if err != nil {
+		log.WithError(err).Warn("Falha ao atualizar contador de falhas do webhook")
+		return
+	}
+	if success || d.cfg.DisableAfter <= 0 || failures < d.cfg.DisableAfter {
+		return
+	}
+
+	reason := fmt.Sprintf("%d entregas consecutivas falharam", failures)
+	if err := d.repo.Disable(ctx, w.ID, reason); err != nil {
+		log.WithError(err).Error("Falha ao desativar webhook")
+		return
+	}
+	d.Invalidate()
+	disabledWebhooks.Inc()
+	log.WithField("failures", failures).Warn("Webhook desativado após falhas consecutivas")
+	d.publisher.Publish(ctx, events.New(events.TopicAdmin, EventWebhookDisabled, events.WebhookDisabledV1{
+		WebhookID: w.ID,
+		URL:       w.URL,
+		Reason:    reason,
+	}))
+}
+
+// backoff retorna a espera antes da tentativa seguinte: InitialBackoff
+// dobrando a cada tentativa, limitado a MaxBackoff, com jitter de até 20%.
+func (d *Dispatcher) backoff(attempt int) time.Duration {
+	delay := d.cfg.InitialBackoff
+	for i := 1; i < attempt && delay < d.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+	delay = min(delay, d.cfg.MaxBackoff)
+	if jitter := int64(delay) / 5; jitter > 0 {
+		delay += time.Duration(rand.Int63n(jitter))
+	}
+	return delay
+}
+
+// Sign calcula a assinatura enviada em HeaderSignature.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
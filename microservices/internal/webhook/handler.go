@@ -0,0 +1,273 @@
+package webhook
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+
+	"smart-city-microservices/internal/audit"
+	"smart-city-microservices/internal/i18n"
+	"smart-city-microservices/internal/logging"
+)
+
+// Limites da listagem de tentativas de entrega.
+const (
+	defaultAttemptsLimit = 50
+	maxAttemptsLimit     = 500
+)
+
+// Handler expõe o CRUD de webhooks e o histórico de entregas.
+type Handler struct {
+	repo       *Repository
+	dispatcher *Dispatcher
+}
+
+// NewHandler cria o handler de webhooks.
+func NewHandler(repo *Repository, dispatcher *Dispatcher) *Handler {
+	return &Handler{repo: repo, dispatcher: dispatcher}
+}
+
+// CreateRequest é o corpo de POST /webhooks. Sem secret, um é gerado.
+type CreateRequest struct {
+	URL           string   `json:"url" binding:"required"`
+	Secret        string   `json:"secret"`
+	EventTypes    []string `json:"event_types"`
+	EventVersions []string `json:"event_versions"`
+	ProjectID     string   `json:"project_id"`
+	Active        *bool    `json:"active"`
+}
+
+// UpdateRequest é o corpo de PUT /webhooks/:id; campos ausentes não mudam.
+// Reativar um webhook desativado zera o contador de falhas.
+type UpdateRequest struct {
+	URL           *string   `json:"url"`
+	EventTypes    *[]string `json:"event_types"`
+	EventVersions *[]string `json:"event_versions"`
+	ProjectID     *string   `json:"project_id"`
+	Active        *bool     `json:"active"`
+}
+
+// List retorna os webhooks, opcionalmente filtrados por ?project_id=.
+func (h *Handler) List(c *gin.Context) {
+	hooks, err := h.repo.List(c.Request.Context(), c.Query("project_id"))
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	for _, w := range hooks {
+		w.Secret = ""
+	}
+	if hooks == nil {
+		hooks = []*Webhook{}
+	}
+	c.JSON(http.StatusOK, gin.H{"data": hooks})
+}
+
+// Get retorna um webhook sem o segredo.
+func (h *Handler) Get(c *gin.Context) {
+	w, ok := h.load(c)
+	if !ok {
+		return
+	}
+	w.Secret = ""
+	c.JSON(http.StatusOK, w)
+}
+
+// Create cadastra um webhook. A resposta é a única que inclui o segredo.
+func (h *Handler) Create(c *gin.Context) {
+	var req CreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		i18n.BindError(c, err)
+		return
+	}
+	if err := validateURL(req.URL); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Secret == "" {
+		req.Secret = newSecret()
+	} else if len(req.Secret) < 16 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "secret must have at least 16 characters"})
+		return
+	}
+
+	w := &Webhook{
+		URL:           req.URL,
+		Secret:        req.Secret,
+		EventTypes:    req.EventTypes,
+		EventVersions: req.EventVersions,
+		ProjectID:     req.ProjectID,
+		Active:        req.Active == nil || *req.Active,
+	}
+	if w.EventTypes == nil {
+		w.EventTypes = []string{}
+	}
+	if w.EventVersions == nil {
+		w.EventVersions = []string{}
+	}
+	if _, err := w.Pins(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.repo.Create(c.Request.Context(), w); err != nil {
+		h.internalError(c, err)
+		return
+	}
+	h.dispatcher.Invalidate()
+	audit.Record(c.Request.Context(), "webhook.created", logrus.Fields{"webhook_id": w.ID, "url": w.URL})
+	c.JSON(http.StatusCreated, w)
+}
+
+// Update altera url, filtros, projeto ou estado do webhook.
+func (h *Handler) Update(c *gin.Context) {
+	var req UpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		i18n.BindError(c, err)
+		return
+	}
+	w, ok := h.load(c)
+	if !ok {
+		return
+	}
+
+	if req.URL != nil {
+		if err := validateURL(*req.URL); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		w.URL = *req.URL
+	}
+	if req.EventTypes != nil {
+		w.EventTypes = *req.EventTypes
+	}
+	if req.EventVersions != nil {
+		w.EventVersions = *req.EventVersions
+		if w.EventVersions == nil {
+			w.EventVersions = []string{}
+		}
+		if _, err := w.Pins(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.ProjectID != nil {
+		w.ProjectID = *req.ProjectID
+	}
+	if req.Active != nil {
+		w.Active = *req.Active
+	}
+
+	if err := h.repo.Update(c.Request.Context(), w); err != nil {
+		h.serviceError(c, err)
+		return
+	}
+	h.dispatcher.Invalidate()
+	audit.Record(c.Request.Context(), "webhook.updated", logrus.Fields{"webhook_id": w.ID, "active": w.Active})
+
+	updated, err := h.repo.Get(c.Request.Context(), w.ID)
+	if err != nil {
+		h.serviceError(c, err)
+		return
+	}
+	updated.Secret = ""
+	c.JSON(http.StatusOK, updated)
+}
+
+// Delete remove o webhook e seu histórico de entregas.
+func (h *Handler) Delete(c *gin.Context) {
+	id := c.Param("id")
+	if err := h.repo.Delete(c.Request.Context(), id); err != nil {
+		h.serviceError(c, err)
+		return
+	}
+	h.dispatcher.Invalidate()
+	audit.Record(c.Request.Context(), "webhook.deleted", logrus.Fields{"webhook_id": id})
+	c.Status(http.StatusNoContent)
+}
+
+// ListDeliveries retorna as tentativas mais recentes (?limit=, padrão 50).
+func (h *Handler) ListDeliveries(c *gin.Context) {
+	limit := defaultAttemptsLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit: " + v})
+			return
+		}
+		limit = min(n, maxAttemptsLimit)
+	}
+	w, ok := h.load(c)
+	if !ok {
+		return
+	}
+	attempts, err := h.repo.ListAttempts(c.Request.Context(), w.ID, limit)
+	if err != nil {
+		h.internalError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": attempts})
+}
+
+// Redeliver reenfileira o corpo de uma entrega anterior.
+func (h *Handler) Redeliver(c *gin.Context) {
+	w, ok := h.load(c)
+	if !ok {
+		return
+	}
+	deliveryID, err := h.dispatcher.Redeliver(c.Request.Context(), w, c.Param("delivery_id"))
+	if err != nil {
+		h.serviceError(c, err)
+		return
+	}
+	audit.Record(c.Request.Context(), "webhook.redelivered", logrus.Fields{
+		"webhook_id":    w.ID,
+		"redelivery_of": c.Param("delivery_id"),
+		"delivery_id":   deliveryID,
+	})
+	c.JSON(http.StatusAccepted, gin.H{"delivery_id": deliveryID})
+}
+
+func (h *Handler) load(c *gin.Context) (*Webhook, bool) {
+	w, err := h.repo.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		h.serviceError(c, err)
+		return nil, false
+	}
+	return w, true
+}
+
+func (h *Handler) serviceError(c *gin.Context, err error) {
+	if errors.Is(err, ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	h.internalError(c, err)
+}
+
+func (h *Handler) internalError(c *gin.Context, err error) {
+	logging.FromContext(c.Request.Context()).WithError(err).Error("Erro no handler de webhooks")
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+}
+
+func validateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid url %q: use an absolute http(s) URL", raw)
+	}
+	return nil
+}
+
+func newSecret() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,184 @@
+package webhook
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/lib/pq"
+
+	"smart-city-microservices/internal/instrument"
+)
+
+// Repository persiste webhooks e tentativas de entrega no PostgreSQL.
+type Repository struct {
+	db *instrument.DB
+}
+
+// NewRepository cria o repositório.
+func NewRepository(db *sql.DB) *Repository {
+	return &Repository{db: instrument.NewDB(db)}
+}
+
+const webhookColumns = `id, url, secret, event_types, event_versions, COALESCE(project_id, ''), active,
+	consecutive_failures, COALESCE(disabled_reason, ''), created_at, updated_at`
+
+func scanWebhook(row interface{ Scan(...interface{}) error }) (*Webhook, error) {
+	var w Webhook
+	err := row.Scan(&w.ID, &w.URL, &w.Secret, pq.Array(&w.EventTypes), pq.Array(&w.EventVersions), &w.ProjectID, &w.Active,
+		&w.ConsecutiveFailures, &w.DisabledReason, &w.CreatedAt, &w.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return &w, err
+}
+
+// Create insere o webhook e preenche id e datas.
+func (r *Repository) Create(ctx context.Context, w *Webhook) error {
+	return r.db.QueryRow(ctx, "webhook.create", `
+		INSERT INTO webhooks (url, secret, event_types, event_versions, project_id, active)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6)
+		RETURNING id, created_at, updated_at`,
+		w.URL, w.Secret, pq.Array(w.EventTypes), pq.Array(w.EventVersions), w.ProjectID, w.Active,
+	).Scan(&w.ID, &w.CreatedAt, &w.UpdatedAt)
+}
+
+// Get busca um webhook pelo id.
+func (r *Repository) Get(ctx context.Context, id string) (*Webhook, error) {
+	return scanWebhook(r.db.QueryRow(ctx, "webhook.get",
+		`SELECT `+webhookColumns+` FROM webhooks WHERE id = $1`, id))
+}
+
+// List retorna os webhooks, filtrando por projeto quando informado.
+func (r *Repository) List(ctx context.Context, projectID string) ([]*Webhook, error) {
+	return r.list(ctx, "webhook.list", `
+		SELECT `+webhookColumns+` FROM webhooks
+		WHERE $1 = '' OR project_id = $1
+		ORDER BY created_at`, projectID)
+}
+
+// ListActive retorna os webhooks ativos, usados pelo dispatcher.
+func (r *Repository) ListActive(ctx context.Context) ([]*Webhook, error) {
+	return r.list(ctx, "webhook.list_active",
+		`SELECT `+webhookColumns+` FROM webhooks WHERE active ORDER BY created_at`)
+}
+
+func (r *Repository) list(ctx context.Context, name, query string, args ...interface{}) ([]*Webhook, error) {
+	rows, err := r.db.Query(ctx, name, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*Webhook
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, w)
+	}
+	return out, rows.Err()
+}
+
+// Update grava url, filtros, projeto e estado. Reativar zera as falhas.
+func (r *Repository) Update(ctx context.Context, w *Webhook) error {
+	res, err := r.db.Exec(ctx, "webhook.update", `
+		UPDATE webhooks SET url = $2, event_types = $3, event_versions = $4, project_id = NULLIF($5, ''), active = $6,
+			consecutive_failures = CASE WHEN $6 AND NOT active THEN 0 ELSE consecutive_failures END,
+			disabled_reason = CASE WHEN $6 THEN NULL ELSE disabled_reason END
+		WHERE id = $1`,
+		w.ID, w.URL, pq.Array(w.EventTypes), pq.Array(w.EventVersions), w.ProjectID, w.Active)
+	return affected(res, err)
+}
+
+// Delete remove o webhook e suas tentativas.
+func (r *Repository) Delete(ctx context.Context, id string) error {
+	res, err := r.db.Exec(ctx, "webhook.delete", `DELETE FROM webhooks WHERE id = $1`, id)
+	return affected(res, err)
+}
+
+// RecordResult atualiza o contador de falhas consecutivas após uma entrega
+// concluída (com sucesso ou esgotadas as tentativas) e retorna o novo valor.
+func (r *Repository) RecordResult(ctx context.Context, id string, success bool) (int, error) {
+	var failures int
+	err := r.db.QueryRow(ctx, "webhook.record_result", `
+		UPDATE webhooks SET consecutive_failures = CASE WHEN $2 THEN 0 ELSE consecutive_failures + 1 END
+		WHERE id = $1
+		RETURNING consecutive_failures`, id, success).Scan(&failures)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	return failures, err
+}
+
+// Disable desativa o webhook informando o motivo.
+func (r *Repository) Disable(ctx context.Context, id, reason string) error {
+	res, err := r.db.Exec(ctx, "webhook.disable",
+		`UPDATE webhooks SET active = false, disabled_reason = $2 WHERE id = $1`, id, reason)
+	return affected(res, err)
+}
+
+// InsertAttempt registra uma tentativa de entrega com o corpo enviado.
+func (r *Repository) InsertAttempt(ctx context.Context, a *Attempt, payload []byte) error {
+	return r.db.QueryRow(ctx, "webhook.insert_attempt", `
+		INSERT INTO webhook_deliveries
+			(webhook_id, delivery_id, event_type, payload, attempt, status_code, error, duration_ms, success, redelivery_of)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, 0), NULLIF($7, ''), $8, $9, NULLIF($10, '')::uuid)
+		RETURNING id, created_at`,
+		a.WebhookID, a.DeliveryID, a.EventType, string(payload), a.Attempt, a.StatusCode, a.Error,
+		a.DurationMs, a.Success, a.RedeliveryOf,
+	).Scan(&a.ID, &a.CreatedAt)
+}
+
+// ListAttempts retorna as tentativas mais recentes do webhook.
+func (r *Repository) ListAttempts(ctx context.Context, webhookID string, limit int) ([]Attempt, error) {
+	rows, err := r.db.Query(ctx, "webhook.list_attempts", `
+		SELECT id, webhook_id, delivery_id, event_type, attempt, COALESCE(status_code, 0),
+			COALESCE(error, ''), COALESCE(duration_ms, 0), success, COALESCE(redelivery_of::text, ''), created_at
+		FROM webhook_deliveries
+		WHERE webhook_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2`, webhookID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []Attempt{}
+	for rows.Next() {
+		var a Attempt
+		if err := rows.Scan(&a.ID, &a.WebhookID, &a.DeliveryID, &a.EventType, &a.Attempt, &a.StatusCode,
+			&a.Error, &a.DurationMs, &a.Success, &a.RedeliveryOf, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+// Payload retorna o tipo e o corpo enviados numa entrega anterior.
+func (r *Repository) Payload(ctx context.Context, webhookID, deliveryID string) (string, json.RawMessage, error) {
+	var eventType string
+	var payload json.RawMessage
+	err := r.db.QueryRow(ctx, "webhook.payload", `
+		SELECT event_type, payload FROM webhook_deliveries
+		WHERE webhook_id = $1 AND delivery_id = $2
+		ORDER BY attempt
+		LIMIT 1`, webhookID, deliveryID).Scan(&eventType, &payload)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil, ErrNotFound
+	}
+	return eventType, payload, err
+}
+
+func affected(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
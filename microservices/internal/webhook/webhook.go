@@ -0,0 +1,68 @@
+// Package webhook mantém assinaturas de webhooks e entrega os eventos de
+// agentes e simulações a sistemas externos via POST assinado com HMAC-SHA256.
+package webhook
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"smart-city-microservices/internal/events"
+)
+
+// ErrNotFound indica que o webhook ou a entrega não existe.
+var ErrNotFound = errors.New("webhook not found")
+
+// Webhook é uma assinatura de eventos. O segredo só é devolvido na criação.
+type Webhook struct {
+	ID         string   `json:"id"`
+	URL        string   `json:"url"`
+	Secret     string   `json:"secret,omitempty"`
+	EventTypes []string `json:"event_types"`
+	// EventVersions fixa a versão do payload por tipo ("agent.updated.v1");
+	// tipos sem versão fixada recebem a versão atual.
+	EventVersions       []string  `json:"event_versions"`
+	ProjectID           string    `json:"project_id,omitempty"`
+	Active              bool      `json:"active"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	DisabledReason      string    `json:"disabled_reason,omitempty"`
+	CreatedAt           time.Time `json:"created_at"`
+	UpdatedAt           time.Time `json:"updated_at"`
+}
+
+// Accepts indica se o webhook assina o tipo de evento e o projeto informados.
+// Filtros vazios aceitam tudo; "agent.*" aceita qualquer tipo com o prefixo.
+func (w *Webhook) Accepts(eventType, projectID string) bool {
+	if w.ProjectID != "" && w.ProjectID != projectID {
+		return false
+	}
+	if len(w.EventTypes) == 0 {
+		return true
+	}
+	for _, t := range w.EventTypes {
+		if t == eventType || (strings.HasSuffix(t, ".*") && strings.HasPrefix(eventType, strings.TrimSuffix(t, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// Pins interpreta EventVersions.
+func (w *Webhook) Pins() (events.Pins, error) {
+	return events.Schemas.ParsePins(w.EventVersions)
+}
+
+// Attempt é uma tentativa de entrega registrada.
+type Attempt struct {
+	ID           string    `json:"id"`
+	WebhookID    string    `json:"webhook_id"`
+	DeliveryID   string    `json:"delivery_id"`
+	EventType    string    `json:"event_type"`
+	Attempt      int       `json:"attempt"`
+	StatusCode   int       `json:"status_code,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	DurationMs   int64     `json:"duration_ms"`
+	Success      bool      `json:"success"`
+	RedeliveryOf string    `json:"redelivery_of,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
@@ -0,0 +1,212 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Position é a posição de um agente.
+type Position struct {
+	Lat     float64 `json:"lat"`
+	Lon     float64 `json:"lon"`
+	Heading float64 `json:"heading"`
+	Speed   float64 `json:"speed"`
+}
+
+// Agent é um agente da API.
+type Agent struct {
+	ID           string                 `json:"id"`
+	SimulationID string                 `json:"simulation_id,omitempty"`
+	ProjectID    string                 `json:"project_id,omitempty"`
+	Type         string                 `json:"type"`
+	Name         string                 `json:"name"`
+	Status       string                 `json:"status"`
+	Position     Position               `json:"position"`
+	Energy       float64                `json:"energy"`
+	State        map[string]interface{} `json:"state,omitempty"`
+	Metadata     map[string]interface{} `json:"metadata,omitempty"`
+	Tags         []string               `json:"tags,omitempty"`
+	CreatedAt    time.Time              `json:"created_at"`
+	UpdatedAt    time.Time              `json:"updated_at"`
+}
+
+// CreateAgentRequest é o corpo de POST /api/v1/agents.
+type CreateAgentRequest struct {
+	SimulationID string                 `json:"simulation_id,omitempty"`
+	ProjectID    string                 `json:"project_id,omitempty"`
+	Type         string                 `json:"type"`
+	Name         string                 `json:"name"`
+	Position     *Position              `json:"position,omitempty"`
+	State        map[string]interface{} `json:"state,omitempty"`
+	Metadata     map[string]interface{} `json:"metadata,omitempty"`
+	Tags         []string               `json:"tags,omitempty"`
+}
+
+// UpdateAgentRequest é o corpo de PUT /api/v1/agents/:id; só os campos não
+// nulos são alterados.
+type UpdateAgentRequest struct {
+	Name     *string                `json:"name,omitempty"`
+	Status   *string                `json:"status,omitempty"`
+	Position *Position              `json:"position,omitempty"`
+	State    map[string]interface{} `json:"state,omitempty"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+	Tags     []string               `json:"tags,omitempty"`
+}
+
+// ListAgentsOptions são os filtros de GET /api/v1/agents.
+type ListAgentsOptions struct {
+	Type         string
+	Status       string
+	SimulationID string
+	ProjectID    string
+	Tags         []string
+	// PageSize é o tamanho de cada página lida; zero usa o padrão da API.
+	// A API limita a 100.
+	PageSize int
+}
+
+func (o ListAgentsOptions) query() url.Values {
+	q := url.Values{}
+	for name, v := range map[string]string{
+		"type":          o.Type,
+		"status":        o.Status,
+		"simulation_id": o.SimulationID,
+		"project_id":    o.ProjectID,
+		"tags":          strings.Join(o.Tags, ","),
+	} {
+		if v != "" {
+			q.Set(name, v)
+		}
+	}
+	if o.PageSize > 0 {
+		q.Set("page_size", strconv.Itoa(o.PageSize))
+	}
+	return q
+}
+
+// AgentsService reúne as operações de /api/v1/agents.
+type AgentsService struct {
+	c *Client
+}
+
+// List retorna um iterador sobre todos os agentes que passam nos filtros;
+// as páginas são lidas sob demanda, durante Next.
+func (s *AgentsService) List(ctx context.Context, opts ListAgentsOptions) *AgentIterator {
+	return &AgentIterator{ctx: ctx, c: s.c, query: opts.query()}
+}
+
+// Get busca um agente pelo id.
+func (s *AgentsService) Get(ctx context.Context, id string) (*Agent, error) {
+	var a Agent
+	if err := s.c.do(ctx, http.MethodGet, "/api/v1/agents/"+url.PathEscape(id), nil, nil, &a); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// Create cria um agente.
+func (s *AgentsService) Create(ctx context.Context, req CreateAgentRequest) (*Agent, error) {
+	var a Agent
+	if err := s.c.do(ctx, http.MethodPost, "/api/v1/agents", nil, req, &a); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// Update altera um agente.
+func (s *AgentsService) Update(ctx context.Context, id string, req UpdateAgentRequest) (*Agent, error) {
+	var a Agent
+	if err := s.c.do(ctx, http.MethodPut, "/api/v1/agents/"+url.PathEscape(id), nil, req, &a); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// Delete remove um agente.
+func (s *AgentsService) Delete(ctx context.Context, id string) error {
+	return s.c.do(ctx, http.MethodDelete, "/api/v1/agents/"+url.PathEscape(id), nil, nil, nil)
+}
+
+// AgentIterator percorre as páginas de GET /api/v1/agents com a paginação
+// que o serviço anuncia em /api/v1/version: por cursor, se houver, ou por
+// offset, em que agentes criados ou removidos durante a iteração podem
+// deslocar as páginas seguintes e aparecer duas vezes ou ser pulados.
+type AgentIterator struct {
+	ctx   context.Context
+	c     *Client
+	query url.Values
+
+	mode   string
+	page   []Agent
+	pos    int
+	next   int
+	cursor string
+	read   int
+	total  int
+	done   bool
+	err    error
+}
+
+// Next avança para o próximo agente, lendo a próxima página quando a atual
+// acaba. Retorna false no fim ou após um erro, que fica em Err.
+func (it *AgentIterator) Next() bool {
+	if it.pos+1 < len(it.page) {
+		it.pos++
+		return true
+	}
+	if it.done {
+		it.page, it.pos = nil, 0
+		return false
+	}
+	if it.mode == "" {
+		it.mode = PaginationOffset
+		if info, err := it.c.Version(it.ctx); err == nil {
+			it.mode = info.PaginationOf("agents")
+		}
+	}
+	q := url.Values{}
+	for k, v := range it.query {
+		q[k] = v
+	}
+	if it.mode == PaginationCursor {
+		if it.cursor != "" {
+			q.Set("cursor", it.cursor)
+		}
+	} else {
+		it.next++
+		q.Set("page", strconv.Itoa(it.next))
+	}
+	var resp struct {
+		Data       []Agent `json:"data"`
+		Total      int     `json:"total"`
+		PageSize   int     `json:"page_size"`
+		NextCursor string  `json:"next_cursor"`
+	}
+	if err := it.c.do(it.ctx, http.MethodGet, "/api/v1/agents", q, nil, &resp); err != nil {
+		it.err, it.done, it.page = err, true, nil
+		return false
+	}
+	it.read += len(resp.Data)
+	it.total = resp.Total
+	if it.mode == PaginationCursor {
+		it.cursor = resp.NextCursor
+		it.done = len(resp.Data) == 0 || resp.NextCursor == ""
+	} else if len(resp.Data) == 0 || len(resp.Data) < resp.PageSize || it.read >= resp.Total {
+		it.done = true
+	}
+	it.page, it.pos = resp.Data, 0
+	return len(it.page) > 0
+}
+
+// Agent retorna o agente corrente; só vale depois de um Next verdadeiro.
+func (it *AgentIterator) Agent() Agent { return it.page[it.pos] }
+
+// Total retorna o total de agentes informado pela última página lida.
+func (it *AgentIterator) Total() int { return it.total }
+
+// Err retorna o erro que interrompeu a iteração, se houve.
+func (it *AgentIterator) Err() error { return it.err }
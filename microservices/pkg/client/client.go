@@ -0,0 +1,226 @@
+// Package client é o cliente Go tipado da API do agent-service, para os
+// serviços internos que hoje montam as requisições à mão: paginação por
+// iterador, erros da API decodificados em *APIError e novas tentativas nas
+// respostas 429 e 503, respeitando Retry-After. O cliente lê GET
+// /api/v1/version uma vez (Client.Version) e se adapta ao que o serviço
+// anuncia, como a paginação de cada listagem.
+//
+//	c, err := client.NewClient("http://agent-service:8080", client.Options{APIKey: key})
+//	it := c.Agents.List(ctx, client.ListAgentsOptions{SimulationID: sim})
+//	for it.Next() {
+//		a := it.Agent()
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Valores padrão das Options.
+const (
+	DefaultTimeout      = 30 * time.Second
+	DefaultMaxRetries   = 3
+	DefaultMaxRetryWait = 30 * time.Second
+	defaultUserAgent    = "smart-city-agent-service-client"
+)
+
+// baseBackoff é a primeira espera de uma nova tentativa sem Retry-After;
+// dobra a cada tentativa.
+const baseBackoff = 500 * time.Millisecond
+
+// maxErrorBody limita o corpo lido de uma resposta de erro.
+const maxErrorBody = 64 << 10
+
+// Options configura o cliente.
+type Options struct {
+	// HTTPClient faz as requisições; nil usa um com DefaultTimeout.
+	HTTPClient *http.Client
+	// APIKey vai em X-API-Key; BearerToken, em Authorization: Bearer (ex.:
+	// o access token de /auth/callback). Vazios, o cliente não autentica.
+	APIKey      string
+	BearerToken string
+	UserAgent   string
+	// AcceptLanguage escolhe o idioma das mensagens de erro (en, pt-BR).
+	AcceptLanguage string
+	// MaxRetries é o número de novas tentativas após 429 ou 503; negativo
+	// desliga, zero usa DefaultMaxRetries.
+	MaxRetries int
+	// MaxRetryWait é a maior espera aceita: um Retry-After maior devolve o
+	// erro na hora, em vez de segurar a chamada. Zero usa
+	// DefaultMaxRetryWait.
+	MaxRetryWait time.Duration
+}
+
+// Client é o cliente da API. É seguro para uso concorrente.
+type Client struct {
+	base *url.URL
+	http *http.Client
+	opts Options
+
+	versionMu sync.Mutex
+	version   *VersionInfo
+
+	Agents      *AgentsService
+	Simulations *SimulationsService
+	Events      *EventsService
+}
+
+// NewClient cria o cliente da API em baseURL, ex.: http://localhost:8080.
+func NewClient(baseURL string, opts Options) (*Client, error) {
+	base, err := url.Parse(strings.TrimRight(baseURL, "/"))
+	if err != nil || base.Scheme == "" || base.Host == "" {
+		return nil, fmt.Errorf("client: URL base inválida %q: use uma URL absoluta", baseURL)
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("client: esquema %q não suportado (use http ou https)", base.Scheme)
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{Timeout: DefaultTimeout}
+	}
+	if opts.UserAgent == "" {
+		opts.UserAgent = defaultUserAgent
+	}
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = DefaultMaxRetries
+	}
+	if opts.MaxRetryWait <= 0 {
+		opts.MaxRetryWait = DefaultMaxRetryWait
+	}
+	c := &Client{base: base, http: opts.HTTPClient, opts: opts}
+	c.Agents = &AgentsService{c: c}
+	c.Simulations = &SimulationsService{c: c}
+	c.Events = &EventsService{c: c}
+	return c, nil
+}
+
+// do envia a requisição e decodifica a resposta 2xx em out (nil ignora o
+// corpo). 429 e 503 são repetidos com o mesmo corpo: o serviço responde
+// esses status antes de agir (limite de taxa, manutenção, hook síncrono
+// indisponível), então a repetição é segura também num POST.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("client: serializar corpo: %w", err)
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, method, path, query, payload)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode/100 == 2 {
+			return decodeBody(resp, out)
+		}
+		apiErr := readError(resp)
+		wait, retry := c.retryWait(apiErr, attempt)
+		if !retry {
+			return apiErr
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (c *Client) send(ctx context.Context, method, path string, query url.Values, payload []byte) (*http.Response, error) {
+	u := c.base.String() + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var rd io.Reader
+	if payload != nil {
+		rd = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, rd)
+	if err != nil {
+		return nil, err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	c.authorize(req.Header)
+	return c.http.Do(req)
+}
+
+// authorize acrescenta as credenciais e os cabeçalhos fixos do cliente.
+func (c *Client) authorize(h http.Header) {
+	if c.opts.APIKey != "" {
+		h.Set("X-API-Key", c.opts.APIKey)
+	}
+	if c.opts.BearerToken != "" {
+		h.Set("Authorization", "Bearer "+c.opts.BearerToken)
+	}
+	if c.opts.AcceptLanguage != "" {
+		h.Set("Accept-Language", c.opts.AcceptLanguage)
+	}
+	h.Set("User-Agent", c.opts.UserAgent)
+}
+
+// retryWait indica se a resposta deve ser repetida e quanto esperar antes.
+func (c *Client) retryWait(e *APIError, attempt int) (time.Duration, bool) {
+	if e.StatusCode != http.StatusTooManyRequests && e.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+	if c.opts.MaxRetries < 0 || attempt >= c.opts.MaxRetries {
+		return 0, false
+	}
+	wait := e.RetryAfter
+	if wait == 0 {
+		wait = baseBackoff << attempt
+		wait += time.Duration(rand.Int63n(int64(wait) / 2))
+	}
+	if wait > c.opts.MaxRetryWait {
+		return 0, false
+	}
+	return wait, true
+}
+
+func decodeBody(resp *http.Response, out interface{}) error {
+	defer resp.Body.Close()
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("client: decodificar resposta: %w", err)
+	}
+	return nil
+}
+
+// parseRetryAfter lê Retry-After em segundos ou como data HTTP.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,349 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// exchange é uma requisição gravada da API e a resposta que ela recebeu.
+type exchange struct {
+	Request struct {
+		Method string          `json:"method"`
+		URI    string          `json:"uri"`
+		Body   json.RawMessage `json:"body"`
+	} `json:"request"`
+	Response struct {
+		Status  int               `json:"status"`
+		Headers map[string]string `json:"headers"`
+		Body    json.RawMessage   `json:"body"`
+		// Text é um corpo que não é JSON, como o de um proxy.
+		Text string `json:"text"`
+	} `json:"response"`
+}
+
+// replay sobe um servidor que responde, em ordem, as trocas gravadas em
+// testdata/name.json e falha o teste numa requisição diferente da gravada
+// ou se alguma troca não for feita.
+func replay(t *testing.T, name string) *httptest.Server {
+	t.Helper()
+	raw, err := os.ReadFile(filepath.Join("testdata", name+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var exchanges []exchange
+	if err := json.Unmarshal(raw, &exchanges); err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	next := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if next >= len(exchanges) {
+			t.Errorf("requisição não gravada: %s %s", r.Method, r.URL.RequestURI())
+			http.Error(w, "unexpected", http.StatusTeapot)
+			return
+		}
+		ex := exchanges[next]
+		next++
+		if r.Method != ex.Request.Method || r.URL.RequestURI() != ex.Request.URI {
+			t.Errorf("requisição %d: %s %s, gravada %s %s", next, r.Method, r.URL.RequestURI(), ex.Request.Method, ex.Request.URI)
+		}
+		if r.Header.Get("X-API-Key") != "key-1" {
+			t.Errorf("X-API-Key = %q", r.Header.Get("X-API-Key"))
+		}
+		if ex.Request.Body != nil {
+			body, _ := io.ReadAll(r.Body)
+			if !jsonEqual(t, body, ex.Request.Body) {
+				t.Errorf("corpo da requisição %d: %s, gravado %s", next, body, ex.Request.Body)
+			}
+		}
+		for k, v := range ex.Response.Headers {
+			w.Header().Set(k, v)
+		}
+		if ex.Response.Text != "" {
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(ex.Response.Status)
+			io.WriteString(w, ex.Response.Text)
+			return
+		}
+		if ex.Response.Body != nil {
+			w.Header().Set("Content-Type", "application/json")
+		}
+		w.WriteHeader(ex.Response.Status)
+		w.Write(ex.Response.Body)
+	}))
+	t.Cleanup(func() {
+		srv.Close()
+		if next != len(exchanges) {
+			t.Errorf("%d de %d trocas gravadas feitas", next, len(exchanges))
+		}
+	})
+	return srv
+}
+
+func jsonEqual(t *testing.T, a, b []byte) bool {
+	t.Helper()
+	var va, vb interface{}
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return false
+	}
+	ja, _ := json.Marshal(va)
+	jb, _ := json.Marshal(vb)
+	return bytes.Equal(ja, jb)
+}
+
+func newClient(t *testing.T, srv *httptest.Server) *Client {
+	t.Helper()
+	c, err := NewClient(srv.URL, Options{HTTPClient: srv.Client(), APIKey: "key-1", MaxRetryWait: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestNewClient(t *testing.T) {
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{"http://agent-service:8080", false},
+		{"https://agents.example.com/base/", false},
+		{"agent-service:8080", true},
+		{"/api", true},
+		{"ftp://agent-service", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			if _, err := NewClient(tt.url, Options{}); (err != nil) != tt.wantErr {
+				t.Errorf("erro %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAgentsList(t *testing.T) {
+	tests := []struct {
+		name      string
+		recording string
+		opts      ListAgentsOptions
+		want      string
+		total     int
+		code      string
+	}{
+		{name: "offset", recording: "list_offset", opts: ListAgentsOptions{SimulationID: "sim-1", PageSize: 2}, want: "a1,a2,a3", total: 3},
+		{name: "cursor", recording: "list_cursor", opts: ListAgentsOptions{Type: "bus", PageSize: 2}, want: "a1,a2,a4", total: 3},
+		{name: "error", recording: "list_error", opts: ListAgentsOptions{Status: "dancing"}, code: "agent.invalid_status"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newClient(t, replay(t, tt.recording))
+			it := c.Agents.List(context.Background(), tt.opts)
+			var ids []string
+			for it.Next() {
+				ids = append(ids, it.Agent().ID)
+			}
+			if got := strings.Join(ids, ","); got != tt.want {
+				t.Errorf("ids %q, want %q", got, tt.want)
+			}
+			if tt.code == "" {
+				if it.Err() != nil || it.Total() != tt.total {
+					t.Errorf("err %v, total %d", it.Err(), it.Total())
+				}
+				return
+			}
+			var apiErr *APIError
+			if !errors.As(it.Err(), &apiErr) || apiErr.Code != tt.code {
+				t.Fatalf("erro %v, want código %s", it.Err(), tt.code)
+			}
+			var allowed []string
+			if !apiErr.Detail("allowed", &allowed) || len(allowed) != 4 {
+				t.Errorf("allowed = %v", allowed)
+			}
+			if it.Next() {
+				t.Error("Next depois do erro")
+			}
+		})
+	}
+}
+
+func TestSimulationsStart(t *testing.T) {
+	tests := []struct {
+		name      string
+		recording string
+		status    string
+		check     func(t *testing.T, err error)
+	}{
+		{name: "retried after 503", recording: "start_retry", status: "running"},
+		{
+			name: "conflict", recording: "start_conflict",
+			check: func(t *testing.T, err error) {
+				var e *APIError
+				var status string
+				if !IsConflict(err) || !errors.As(err, &e) || e.Code != "simulation.invalid_transition" || !e.Detail("status", &status) || status != "running" {
+					t.Errorf("erro %#v", err)
+				}
+			},
+		},
+		{
+			name: "retry after above max wait", recording: "start_retry_after_too_long",
+			check: func(t *testing.T, err error) {
+				var e *APIError
+				if !errors.As(err, &e) || e.StatusCode != http.StatusTooManyRequests || e.RetryAfter != 2*time.Minute {
+					t.Errorf("erro %#v", err)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sim, err := newClient(t, replay(t, tt.recording)).Simulations.Start(context.Background(), "sim-1")
+			if tt.check != nil {
+				tt.check(t, err)
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sim.Status != tt.status || sim.StartedAt == nil {
+				t.Errorf("simulação %+v", sim)
+			}
+		})
+	}
+}
+
+func TestAgentsCreateDelete(t *testing.T) {
+	c := newClient(t, replay(t, "create_agent"))
+	ctx := context.Background()
+	a, err := c.Agents.Create(ctx, CreateAgentRequest{
+		SimulationID: "sim-1", Type: "bus", Name: "Ônibus 101",
+		Position: &Position{Lat: -23.55, Lon: -46.63}, Tags: []string{"linha-101"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.ID != "a1" || a.Status != "active" || a.CreatedAt.IsZero() {
+		t.Errorf("agente %+v", a)
+	}
+	if err := c.Agents.Delete(ctx, a.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Agents.Get(ctx, a.ID); !IsNotFound(err) {
+		t.Errorf("erro %v, want 404", err)
+	}
+}
+
+// TestErrorFromProxy confere que um corpo que não é o envelope da API vira
+// a mensagem do erro.
+func TestErrorFromProxy(t *testing.T) {
+	_, err := newClient(t, replay(t, "get_bad_gateway")).Agents.Get(context.Background(), "a1")
+	var e *APIError
+	if !errors.As(err, &e) || e.StatusCode != http.StatusBadGateway || e.Message != "<html>502 Bad Gateway</html>" || e.Code != "" {
+		t.Errorf("erro %#v", err)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 3, 4, 5, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"3", 3 * time.Second},
+		{"-1", 0},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0},
+		{"amanhã", 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+// TestEventsStream replica no websocket os envelopes gravados de
+// testdata/events.jsonl e confere os filtros do cliente.
+func TestEventsStream(t *testing.T) {
+	raw, err := os.ReadFile(filepath.Join("testdata", "events.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ws" || r.Header.Get("X-API-Key") != "key-1" {
+			http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for _, line := range bytes.Split(bytes.TrimSpace(raw), []byte("\n")) {
+			if err := conn.WriteMessage(websocket.TextMessage, line); err != nil {
+				return
+			}
+		}
+		conn.ReadMessage()
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	st, err := newClient(t, srv).Events.Stream(ctx, StreamOptions{Topics: []string{"agents"}, Types: []string{"agent.updated"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for i := 0; i < 2; i++ {
+		e, err := st.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var data struct {
+			ID string `json:"id"`
+		}
+		if err := e.Decode(&data); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, data.ID)
+	}
+	if got := strings.Join(ids, ","); got != "a1,a2" {
+		t.Errorf("agentes %s, want a1,a2", got)
+	}
+	if err := st.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Next(); !errors.Is(err, ErrStreamClosed) {
+		t.Errorf("Next depois de Close: %v", err)
+	}
+}
+
+func TestEventsStreamUnauthorized(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, `{"error":"invalid API key","code":"auth.invalid_key"}`)
+	}))
+	defer srv.Close()
+	_, err := newClient(t, srv).Events.Stream(context.Background(), StreamOptions{})
+	var e *APIError
+	if !errors.As(err, &e) || e.StatusCode != http.StatusUnauthorized || e.Code != "auth.invalid_key" {
+		t.Errorf("erro %#v", err)
+	}
+}
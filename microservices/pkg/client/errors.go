@@ -0,0 +1,80 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// APIError é uma resposta de erro da API: o envelope {"error", "code",
+// ...} com o status. Os membros além de error e code (ex.: resource e
+// limit de uma cota, resync_required do feed de mudanças) ficam em
+// Details.
+type APIError struct {
+	StatusCode int
+	// Code é o código estável da mensagem (ex.: agent.not_found), o mesmo
+	// em qualquer idioma; compare por ele, não por Message.
+	Code    string
+	Message string
+	// RetryAfter é o Retry-After da resposta, se houver.
+	RetryAfter time.Duration
+	Details    map[string]json.RawMessage
+}
+
+func (e *APIError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("agent-service: status %d: %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("agent-service: status %d (%s): %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Detail decodifica o membro name do envelope em v e indica se ele existe.
+func (e *APIError) Detail(name string, v interface{}) bool {
+	raw, ok := e.Details[name]
+	return ok && json.Unmarshal(raw, v) == nil
+}
+
+// IsNotFound indica se err é um *APIError com status 404.
+func IsNotFound(err error) bool { return hasStatus(err, http.StatusNotFound) }
+
+// IsConflict indica se err é um *APIError com status 409.
+func IsConflict(err error) bool { return hasStatus(err, http.StatusConflict) }
+
+func hasStatus(err error, status int) bool {
+	var e *APIError
+	return errors.As(err, &e) && e.StatusCode == status
+}
+
+// readError lê o envelope de erro da resposta. Um corpo que não é o
+// envelope (ex.: de um proxy) vira a mensagem.
+func readError(resp *http.Response) *APIError {
+	defer resp.Body.Close()
+	e := &APIError{
+		StatusCode: resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(body, &fields) != nil {
+		e.Message = strings.TrimSpace(string(body))
+		if e.Message == "" {
+			e.Message = http.StatusText(resp.StatusCode)
+		}
+		return e
+	}
+	_ = json.Unmarshal(fields["error"], &e.Message)
+	_ = json.Unmarshal(fields["code"], &e.Code)
+	delete(fields, "error")
+	delete(fields, "code")
+	if len(fields) > 0 {
+		e.Details = fields
+	}
+	if e.Message == "" {
+		e.Message = http.StatusText(resp.StatusCode)
+	}
+	return e
+}
@@ -0,0 +1,163 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Event é o envelope de um evento recebido por /ws.
+type Event struct {
+	ID string `json:"id,omitempty"`
+	// EventType é o tipo versionado (agent.updated.v1); Type, o tipo sem
+	// versão.
+	EventType  string          `json:"event_type"`
+	Type       string          `json:"type"`
+	Version    int             `json:"version"`
+	Topic      string          `json:"topic"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Producer   string          `json:"producer,omitempty"`
+	Trace      *EventTrace     `json:"trace,omitempty"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// EventTrace identifica a requisição que originou o evento.
+type EventTrace struct {
+	TraceID   string `json:"trace_id,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// Decode decodifica o payload do evento em v.
+func (e Event) Decode(v interface{}) error {
+	return json.Unmarshal(e.Data, v)
+}
+
+// StreamOptions filtra os eventos de Stream. Os filtros são aplicados no
+// cliente, sobre o que o serviço envia à conexão; vazios aceitam todos.
+type StreamOptions struct {
+	// Topics são os tópicos aceitos (agents, simulations, alerts, admin).
+	Topics []string
+	// Types são os tipos aceitos, sem versão (agent.updated).
+	Types []string
+}
+
+// ErrStreamClosed indica que o stream foi fechado por Close.
+var ErrStreamClosed = errors.New("client: stream de eventos fechado")
+
+// EventsService reúne o feed de eventos em tempo real.
+type EventsService struct {
+	c *Client
+}
+
+// Stream abre o websocket /ws e entrega os envelopes por Next. O cancelamento
+// de ctx fecha a conexão. A conexão não é refeita depois de uma queda: o
+// chamador abre outra e, se precisar de todos os eventos, recupera os
+// perdidos por GET /api/v1/events ou pelo feed de mudanças.
+func (s *EventsService) Stream(ctx context.Context, opts StreamOptions) (*EventStream, error) {
+	u := *s.c.base
+	u.Scheme = map[string]string{"https": "wss", "http": "ws"}[u.Scheme]
+	u.Path = strings.TrimRight(u.Path, "/") + "/ws"
+	header := http.Header{}
+	s.c.authorize(header)
+	dialer := websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: s.c.http.Timeout,
+	}
+	for attempt := 0; ; attempt++ {
+		conn, resp, err := dialer.DialContext(ctx, u.String(), header)
+		if err == nil {
+			return newEventStream(ctx, conn, opts), nil
+		}
+		if resp == nil {
+			return nil, fmt.Errorf("client: conectar ao websocket: %w", err)
+		}
+		apiErr := readError(resp)
+		wait, retry := s.c.retryWait(apiErr, attempt)
+		if !retry {
+			return nil, apiErr
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// EventStream é uma conexão aberta por Stream. Next não deve ser chamado
+// de mais de uma goroutine ao mesmo tempo; Close pode.
+type EventStream struct {
+	ctx    context.Context
+	conn   *websocket.Conn
+	topics map[string]bool
+	types  map[string]bool
+	stop   func() bool
+	once   sync.Once
+	closed chan struct{}
+}
+
+func newEventStream(ctx context.Context, conn *websocket.Conn, opts StreamOptions) *EventStream {
+	st := &EventStream{ctx: ctx, conn: conn, topics: set(opts.Topics), types: set(opts.Types), closed: make(chan struct{})}
+	st.stop = context.AfterFunc(ctx, func() { conn.Close() })
+	return st
+}
+
+// Next bloqueia até o próximo evento aceito pelos filtros. Retorna o erro
+// de ctx depois do cancelamento, ErrStreamClosed depois de Close e o erro
+// da conexão se ela cair.
+func (st *EventStream) Next() (Event, error) {
+	for {
+		_, msg, err := st.conn.ReadMessage()
+		if err != nil {
+			select {
+			case <-st.closed:
+				return Event{}, ErrStreamClosed
+			default:
+			}
+			if st.ctx.Err() != nil {
+				return Event{}, st.ctx.Err()
+			}
+			return Event{}, fmt.Errorf("client: ler evento: %w", err)
+		}
+		var e Event
+		if err := json.Unmarshal(msg, &e); err != nil {
+			return Event{}, fmt.Errorf("client: decodificar evento: %w", err)
+		}
+		if (st.topics == nil || st.topics[e.Topic]) && (st.types == nil || st.types[e.Type]) {
+			return e, nil
+		}
+	}
+}
+
+// Close fecha a conexão, avisando o serviço.
+func (st *EventStream) Close() error {
+	var err error
+	st.once.Do(func() {
+		close(st.closed)
+		st.stop()
+		_ = st.conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		err = st.conn.Close()
+	})
+	return err
+}
+
+func set(list []string) map[string]bool {
+	if len(list) == 0 {
+		return nil
+	}
+	m := make(map[string]bool, len(list))
+	for _, v := range list {
+		m[v] = true
+	}
+	return m
+}
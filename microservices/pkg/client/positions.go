@@ -0,0 +1,413 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Tipos dos quadros do streaming de posições.
+const (
+	PositionSnapshot = "snapshot"
+	PositionKeyframe = "keyframe"
+	PositionDelta    = "delta"
+)
+
+// Esperas entre as reconexões do streaming de posições, que dobram a cada
+// tentativa até PositionsOptions.MaxReconnectWait.
+const (
+	positionsReconnectWait    = 100 * time.Millisecond
+	defaultMaxReconnectWait   = 5 * time.Second
+	positionsControlWriteWait = 5 * time.Second
+)
+
+// AgentPosition é um agente num quadro de posições.
+type AgentPosition struct {
+	ID      string  `json:"id"`
+	Status  string  `json:"status"`
+	Lat     float64 `json:"lat"`
+	Lon     float64 `json:"lon"`
+	Heading float64 `json:"heading"`
+	Speed   float64 `json:"speed"`
+}
+
+// PositionFrame é um quadro aplicado por PositionStream.Next. Snapshots e
+// keyframes trazem todos os agentes; deltas, os alterados e os removidos.
+type PositionFrame struct {
+	Type string
+	// Version é a versão do protocolo em que o quadro chegou.
+	Version int
+	Seq     uint64
+	Time    time.Time
+	Agents  []AgentPosition
+	Removed []string
+}
+
+// PositionsOptions configura Positions.
+type PositionsOptions struct {
+	// Versions são as versões do protocolo oferecidas no hello; vazio
+	// oferece [2, 1]. Só com a versão 1 o hello não é enviado.
+	Versions []int
+	// MaxReconnectWait é a maior espera entre reconexões; zero usa 5s.
+	MaxReconnectWait time.Duration
+}
+
+// Positions abre o streaming de posições da simulação
+// (/api/v1/simulations/:id/positions/stream) e mantém a visão dos agentes
+// pelos quadros: snapshots e keyframes a substituem e deltas a atualizam.
+// Diferente de Events.Stream, o stream se recupera sozinho: um delta fora
+// de sequência pede um snapshot novo com {"type":"resync"}, e uma conexão
+// perdida é refeita, com um snapshot novo. Um servidor que recusa as
+// versões oferecidas (fechamento 1002) ou que ignora o hello faz o stream
+// seguir na versão 1.
+func (s *SimulationsService) Positions(ctx context.Context, simulationID string, opts PositionsOptions) (*PositionStream, error) {
+	u := *s.c.base
+	u.Scheme = map[string]string{"https": "wss", "http": "ws"}[u.Scheme]
+	u.Path = strings.TrimRight(u.Path, "/") + "/api/v1/simulations/" + url.PathEscape(simulationID) + "/positions/stream"
+	if len(opts.Versions) == 0 {
+		opts.Versions = []int{2, 1}
+	}
+	if opts.MaxReconnectWait <= 0 {
+		opts.MaxReconnectWait = defaultMaxReconnectWait
+	}
+	header := http.Header{}
+	s.c.authorize(header)
+	st := &PositionStream{
+		ctx:      ctx,
+		c:        s.c,
+		url:      u.String(),
+		header:   header,
+		opts:     opts,
+		versions: opts.Versions,
+		view:     map[string]AgentPosition{},
+		closed:   make(chan struct{}),
+	}
+	if err := st.connect(); err != nil {
+		return nil, err
+	}
+	st.stop = context.AfterFunc(ctx, func() {
+		if conn := st.current(); conn != nil {
+			conn.Close()
+		}
+	})
+	return st, nil
+}
+
+// PositionStream é uma inscrição aberta por Positions. Next não deve ser
+// chamado de mais de uma goroutine ao mesmo tempo; View, Version e Close
+// podem.
+type PositionStream struct {
+	ctx    context.Context
+	c      *Client
+	url    string
+	header http.Header
+	opts   PositionsOptions
+	stop   func() bool
+
+	mu   sync.Mutex
+	conn *websocket.Conn
+	// versions são as versões oferecidas na próxima conexão; viram [1]
+	// depois de uma recusa.
+	versions []int
+	version  int
+	// synced indica que a visão partiu de um snapshot desta conexão e que
+	// os deltas seguintes se aplicam a ela.
+	synced     bool
+	seq        uint64
+	view       map[string]AgentPosition
+	reconnects int
+
+	once   sync.Once
+	closed chan struct{}
+}
+
+// Next bloqueia até o próximo quadro aplicado à visão, refazendo a conexão
+// e pedindo snapshots quando preciso. Retorna o erro de ctx depois do
+// cancelamento, ErrStreamClosed depois de Close e o erro da reconexão se
+// ela não for possível (ex.: a simulação foi removida).
+func (st *PositionStream) Next() (PositionFrame, error) {
+	for {
+		_, msg, err := st.current().ReadMessage()
+		if err != nil {
+			select {
+			case <-st.closed:
+				return PositionFrame{}, ErrStreamClosed
+			default:
+			}
+			if st.ctx.Err() != nil {
+				return PositionFrame{}, st.ctx.Err()
+			}
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) && closeErr.Code == websocket.CloseProtocolError {
+				st.mu.Lock()
+				st.versions = []int{1}
+				st.mu.Unlock()
+			}
+			if err := st.reconnect(); err != nil {
+				return PositionFrame{}, err
+			}
+			continue
+		}
+		f, ok, err := st.apply(msg)
+		if err != nil {
+			return PositionFrame{}, err
+		}
+		if ok {
+			return f, nil
+		}
+	}
+}
+
+// View retorna uma cópia da visão atual dos agentes, por id.
+func (st *PositionStream) View() map[string]AgentPosition {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	out := make(map[string]AgentPosition, len(st.view))
+	for id, p := range st.view {
+		out[id] = p
+	}
+	return out
+}
+
+// Seq é a sequência do último quadro aplicado.
+func (st *PositionStream) Seq() uint64 {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.seq
+}
+
+// Version é a versão do protocolo da conexão atual.
+func (st *PositionStream) Version() int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.version
+}
+
+// Reconnects é o número de conexões refeitas desde Positions.
+func (st *PositionStream) Reconnects() int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.reconnects
+}
+
+// Close fecha a conexão, avisando o serviço.
+func (st *PositionStream) Close() error {
+	var err error
+	st.once.Do(func() {
+		close(st.closed)
+		st.stop()
+		conn := st.current()
+		_ = conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		err = conn.Close()
+	})
+	return err
+}
+
+func (st *PositionStream) current() *websocket.Conn {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.conn
+}
+
+// connect abre a conexão e manda o hello, se há versões além da 1 a
+// oferecer. Respostas 429 e 503 são repetidas como nas demais chamadas.
+func (st *PositionStream) connect() error {
+	dialer := websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: st.c.http.Timeout,
+	}
+	for attempt := 0; ; attempt++ {
+		conn, resp, err := dialer.DialContext(st.ctx, st.url, st.header)
+		if err == nil {
+			return st.start(conn)
+		}
+		if resp == nil {
+			return fmt.Errorf("client: conectar ao streaming de posições: %w", err)
+		}
+		apiErr := readError(resp)
+		wait, retry := st.c.retryWait(apiErr, attempt)
+		if !retry {
+			return apiErr
+		}
+		if err := st.sleep(wait); err != nil {
+			return err
+		}
+	}
+}
+
+func (st *PositionStream) start(conn *websocket.Conn) error {
+	st.mu.Lock()
+	old := st.conn
+	st.conn, st.version, st.synced = conn, 1, false
+	versions := st.versions
+	st.mu.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	// O fechamento de Close pode ter ocorrido durante a conexão.
+	select {
+	case <-st.closed:
+		conn.Close()
+		return ErrStreamClosed
+	default:
+	}
+	if len(versions) == 1 && versions[0] == 1 {
+		return nil
+	}
+	hello, _ := json.Marshal(map[string]interface{}{"type": "hello", "versions": versions})
+	return st.write(conn, hello)
+}
+
+// reconnect refaz a conexão, esperando entre as tentativas. Erros de rede
+// repetem; uma resposta de erro da API que não se repete encerra o stream.
+func (st *PositionStream) reconnect() error {
+	for attempt := 0; ; attempt++ {
+		wait := positionsReconnectWait << min(attempt, 16)
+		if wait > st.opts.MaxReconnectWait {
+			wait = st.opts.MaxReconnectWait
+		}
+		if err := st.sleep(wait); err != nil {
+			return err
+		}
+		err := st.connect()
+		if err == nil {
+			st.mu.Lock()
+			st.reconnects++
+			st.mu.Unlock()
+			return nil
+		}
+		var apiErr *APIError
+		if errors.As(err, &apiErr) || errors.Is(err, ErrStreamClosed) || st.ctx.Err() != nil {
+			return err
+		}
+	}
+}
+
+func (st *PositionStream) sleep(d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-st.ctx.Done():
+		return st.ctx.Err()
+	case <-st.closed:
+		return ErrStreamClosed
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (st *PositionStream) write(conn *websocket.Conn, msg []byte) error {
+	_ = conn.SetWriteDeadline(time.Now().Add(positionsControlWriteWait))
+	if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+		// A leitura seguinte falha e reconecta.
+		conn.Close()
+	}
+	return nil
+}
+
+// positionMessage é qualquer mensagem do servidor: quadros nas duas
+// versões (a 2 traz v e agentes em listas) e o welcome.
+type positionMessage struct {
+	Type     string          `json:"type"`
+	V        int             `json:"v"`
+	Seq      uint64          `json:"seq"`
+	Time     time.Time       `json:"time"`
+	Agents   json.RawMessage `json:"agents"`
+	Removed  []string        `json:"removed"`
+	Version  int             `json:"version"`
+	Features []string        `json:"features"`
+}
+
+// apply aplica a mensagem à visão; ok indica um quadro aplicado. Quadros
+// que não se aplicam (deltas antes do snapshot, fora de sequência) são
+// ignorados, e o fora de sequência pede um snapshot novo.
+func (st *PositionStream) apply(msg []byte) (PositionFrame, bool, error) {
+	var m positionMessage
+	if err := json.Unmarshal(msg, &m); err != nil {
+		return PositionFrame{}, false, fmt.Errorf("client: decodificar quadro de posições: %w", err)
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	switch m.Type {
+	case "welcome":
+		// O servidor manda um snapshot novo, na versão escolhida.
+		st.version, st.synced = m.Version, false
+		return PositionFrame{}, false, nil
+	case PositionSnapshot, PositionKeyframe, PositionDelta:
+	default:
+		return PositionFrame{}, false, nil
+	}
+	version := 1
+	if m.V > 1 {
+		version = m.V
+	}
+	agents, err := decodeAgents(m.Agents, version)
+	if err != nil {
+		return PositionFrame{}, false, err
+	}
+	f := PositionFrame{Type: m.Type, Version: version, Seq: m.Seq, Time: m.Time, Agents: agents, Removed: m.Removed}
+	if m.Type != PositionDelta {
+		st.view = make(map[string]AgentPosition, len(agents))
+		for _, p := range agents {
+			st.view[p.ID] = p
+		}
+		st.seq, st.synced = m.Seq, true
+		return f, true, nil
+	}
+	if !st.synced {
+		return PositionFrame{}, false, nil
+	}
+	if m.Seq != st.seq+1 {
+		st.synced = false
+		return PositionFrame{}, false, st.write(st.conn, []byte(`{"type":"resync"}`))
+	}
+	for _, p := range agents {
+		st.view[p.ID] = p
+	}
+	for _, id := range m.Removed {
+		delete(st.view, id)
+	}
+	st.seq = m.Seq
+	return f, true, nil
+}
+
+// decodeAgents lê os agentes da versão 1 (objetos) ou 2 (listas [id,
+// status, lat, lon, heading, speed]).
+func decodeAgents(raw json.RawMessage, version int) ([]AgentPosition, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	if version < 2 {
+		var agents []AgentPosition
+		if err := json.Unmarshal(raw, &agents); err != nil {
+			return nil, fmt.Errorf("client: decodificar agentes: %w", err)
+		}
+		return agents, nil
+	}
+	var rows [][]json.RawMessage
+	if err := json.Unmarshal(raw, &rows); err != nil {
+		return nil, fmt.Errorf("client: decodificar agentes: %w", err)
+	}
+	agents := make([]AgentPosition, len(rows))
+	for i, row := range rows {
+		if len(row) != 6 {
+			return nil, fmt.Errorf("client: agente com %d campos, esperados 6", len(row))
+		}
+		p := &agents[i]
+		for j, dst := range []interface{}{&p.ID, &p.Status, &p.Lat, &p.Lon, &p.Heading, &p.Speed} {
+			if err := json.Unmarshal(row[j], dst); err != nil {
+				return nil, fmt.Errorf("client: decodificar agente: %w", err)
+			}
+		}
+	}
+	return agents, nil
+}
@@ -0,0 +1,63 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Simulation é uma simulação da API.
+type Simulation struct {
+	ID          string                 `json:"id"`
+	ProjectID   string                 `json:"project_id,omitempty"`
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Status      string                 `json:"status"`
+	Config      map[string]interface{} `json:"config,omitempty"`
+	CreatedAt   time.Time              `json:"created_at"`
+	StartedAt   *time.Time             `json:"started_at,omitempty"`
+	EndedAt     *time.Time             `json:"ended_at,omitempty"`
+}
+
+// CreateSimulationRequest é o corpo de POST /api/v1/simulations.
+type CreateSimulationRequest struct {
+	ProjectID   string                 `json:"project_id,omitempty"`
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Config      map[string]interface{} `json:"config,omitempty"`
+}
+
+// SimulationsService reúne as operações de /api/v1/simulations.
+type SimulationsService struct {
+	c *Client
+}
+
+// Get busca uma simulação pelo id.
+func (s *SimulationsService) Get(ctx context.Context, id string) (*Simulation, error) {
+	return s.simulation(ctx, http.MethodGet, "/api/v1/simulations/"+url.PathEscape(id), nil)
+}
+
+// Create cria uma simulação.
+func (s *SimulationsService) Create(ctx context.Context, req CreateSimulationRequest) (*Simulation, error) {
+	return s.simulation(ctx, http.MethodPost, "/api/v1/simulations", req)
+}
+
+// Start inicia a simulação. Uma simulação que não pode ser iniciada no
+// estado atual retorna um *APIError com status 409 (IsConflict).
+func (s *SimulationsService) Start(ctx context.Context, id string) (*Simulation, error) {
+	return s.simulation(ctx, http.MethodPut, "/api/v1/simulations/"+url.PathEscape(id)+"/start", nil)
+}
+
+// Stop encerra a simulação.
+func (s *SimulationsService) Stop(ctx context.Context, id string) (*Simulation, error) {
+	return s.simulation(ctx, http.MethodPut, "/api/v1/simulations/"+url.PathEscape(id)+"/stop", nil)
+}
+
+func (s *SimulationsService) simulation(ctx context.Context, method, path string, body interface{}) (*Simulation, error) {
+	var sim Simulation
+	if err := s.c.do(ctx, method, path, nil, body, &sim); err != nil {
+		return nil, err
+	}
+	return &sim, nil
+}
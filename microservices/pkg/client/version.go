@@ -0,0 +1,68 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+)
+
+// Modos de paginação de VersionInfo.Pagination.
+const (
+	PaginationOffset = "offset"
+	PaginationCursor = "cursor"
+)
+
+// VersionInfo é a descrição da implantação, de GET /api/v1/version.
+type VersionInfo struct {
+	Version    string `json:"version"`
+	Commit     string `json:"commit"`
+	BuildTime  string `json:"build_time"`
+	GoVersion  string `json:"go_version"`
+	APIVersion string `json:"api_version"`
+	// FeatureFlags são os flags ligados para as credenciais do cliente.
+	FeatureFlags []string `json:"feature_flags"`
+	// EventSchemas são as versões de payload de cada tipo de evento.
+	EventSchemas map[string][]int `json:"event_schemas"`
+	ContentTypes []string         `json:"content_types"`
+	// Pagination é o modo de paginação de cada listagem (agents,
+	// agent_actions, ...).
+	Pagination map[string]string `json:"pagination"`
+}
+
+// HasFeature indica se o flag name está ligado.
+func (v *VersionInfo) HasFeature(name string) bool {
+	return slices.Contains(v.FeatureFlags, name)
+}
+
+// PaginationOf retorna a paginação da listagem name; sem informação, a
+// por offset, a única dos serviços anteriores ao endpoint.
+func (v *VersionInfo) PaginationOf(name string) string {
+	if mode := v.Pagination[name]; mode != "" {
+		return mode
+	}
+	return PaginationOffset
+}
+
+// Version retorna a descrição da implantação. A primeira leitura bem
+// sucedida fica guardada e vale para o resto da vida do cliente; um
+// serviço sem o endpoint (404) resulta numa VersionInfo vazia, com os
+// comportamentos anteriores a ele.
+func (c *Client) Version(ctx context.Context) (*VersionInfo, error) {
+	c.versionMu.Lock()
+	defer c.versionMu.Unlock()
+	if c.version != nil {
+		return c.version, nil
+	}
+	var info VersionInfo
+	err := c.do(ctx, http.MethodGet, "/api/v1/version", nil, nil, &info)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		info, err = VersionInfo{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.version = &info
+	return c.version, nil
+}
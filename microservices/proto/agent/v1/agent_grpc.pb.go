@@ -0,0 +1,641 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: agent/v1/agent.proto
+
+// API gRPC do agent-service. Espelha as rotas REST de /api/v1 (agentes e
+// simulações) e expõe o mesmo feed em tempo real do websocket.
+
+package agentv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	AgentService_ListAgents_FullMethodName       = "/smartcity.agent.v1.AgentService/ListAgents"
+	AgentService_GetAgent_FullMethodName         = "/smartcity.agent.v1.AgentService/GetAgent"
+	AgentService_CreateAgent_FullMethodName      = "/smartcity.agent.v1.AgentService/CreateAgent"
+	AgentService_UpdateAgent_FullMethodName      = "/smartcity.agent.v1.AgentService/UpdateAgent"
+	AgentService_DeleteAgent_FullMethodName      = "/smartcity.agent.v1.AgentService/DeleteAgent"
+	AgentService_ExecuteAction_FullMethodName    = "/smartcity.agent.v1.AgentService/ExecuteAction"
+	AgentService_GetPerformance_FullMethodName   = "/smartcity.agent.v1.AgentService/GetPerformance"
+	AgentService_WatchAgentEvents_FullMethodName = "/smartcity.agent.v1.AgentService/WatchAgentEvents"
+)
+
+// AgentServiceClient is the client API for AgentService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AgentServiceClient interface {
+	ListAgents(ctx context.Context, in *ListAgentsRequest, opts ...grpc.CallOption) (*ListAgentsResponse, error)
+	GetAgent(ctx context.Context, in *GetAgentRequest, opts ...grpc.CallOption) (*Agent, error)
+	CreateAgent(ctx context.Context, in *CreateAgentRequest, opts ...grpc.CallOption) (*Agent, error)
+	UpdateAgent(ctx context.Context, in *UpdateAgentRequest, opts ...grpc.CallOption) (*Agent, error)
+	DeleteAgent(ctx context.Context, in *DeleteAgentRequest, opts ...grpc.CallOption) (*DeleteAgentResponse, error)
+	ExecuteAction(ctx context.Context, in *ExecuteActionRequest, opts ...grpc.CallOption) (*ActionResult, error)
+	GetPerformance(ctx context.Context, in *GetPerformanceRequest, opts ...grpc.CallOption) (*Performance, error)
+	// WatchAgentEvents transmite os eventos de agentes e simulações à medida
+	// que são publicados, com os mesmos filtros de tópico do websocket.
+	WatchAgentEvents(ctx context.Context, in *WatchAgentEventsRequest, opts ...grpc.CallOption) (AgentService_WatchAgentEventsClient, error)
+}
+
+type agentServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAgentServiceClient(cc grpc.ClientConnInterface) AgentServiceClient {
+	return &agentServiceClient{cc}
+}
+
+func (c *agentServiceClient) ListAgents(ctx context.Context, in *ListAgentsRequest, opts ...grpc.CallOption) (*ListAgentsResponse, error) {
+	out := new(ListAgentsResponse)
+	err := c.cc.Invoke(ctx, AgentService_ListAgents_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentServiceClient) GetAgent(ctx context.Context, in *GetAgentRequest, opts ...grpc.CallOption) (*Agent, error) {
+	out := new(Agent)
+	err := c.cc.Invoke(ctx, AgentService_GetAgent_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentServiceClient) CreateAgent(ctx context.Context, in *CreateAgentRequest, opts ...grpc.CallOption) (*Agent, error) {
+	out := new(Agent)
+	err := c.cc.Invoke(ctx, AgentService_CreateAgent_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentServiceClient) UpdateAgent(ctx context.Context, in *UpdateAgentRequest, opts ...grpc.CallOption) (*Agent, error) {
+	out := new(Agent)
+	err := c.cc.Invoke(ctx, AgentService_UpdateAgent_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentServiceClient) DeleteAgent(ctx context.Context, in *DeleteAgentRequest, opts ...grpc.CallOption) (*DeleteAgentResponse, error) {
+	out := new(DeleteAgentResponse)
+	err := c.cc.Invoke(ctx, AgentService_DeleteAgent_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentServiceClient) ExecuteAction(ctx context.Context, in *ExecuteActionRequest, opts ...grpc.CallOption) (*ActionResult, error) {
+	out := new(ActionResult)
+	err := c.cc.Invoke(ctx, AgentService_ExecuteAction_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentServiceClient) GetPerformance(ctx context.Context, in *GetPerformanceRequest, opts ...grpc.CallOption) (*Performance, error) {
+	out := new(Performance)
+	err := c.cc.Invoke(ctx, AgentService_GetPerformance_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentServiceClient) WatchAgentEvents(ctx context.Context, in *WatchAgentEventsRequest, opts ...grpc.CallOption) (AgentService_WatchAgentEventsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &AgentService_ServiceDesc.Streams[0], AgentService_WatchAgentEvents_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &agentServiceWatchAgentEventsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type AgentService_WatchAgentEventsClient interface {
+	Recv() (*AgentEvent, error)
+	grpc.ClientStream
+}
+
+type agentServiceWatchAgentEventsClient struct {
+	grpc.ClientStream
+}
+
+func (x *agentServiceWatchAgentEventsClient) Recv() (*AgentEvent, error) {
+	m := new(AgentEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// AgentServiceServer is the server API for AgentService service.
+// All implementations must embed UnimplementedAgentServiceServer
+// for forward compatibility
+type AgentServiceServer interface {
+	ListAgents(context.Context, *ListAgentsRequest) (*ListAgentsResponse, error)
+	GetAgent(context.Context, *GetAgentRequest) (*Agent, error)
+	CreateAgent(context.Context, *CreateAgentRequest) (*Agent, error)
+	UpdateAgent(context.Context, *UpdateAgentRequest) (*Agent, error)
+	DeleteAgent(context.Context, *DeleteAgentRequest) (*DeleteAgentResponse, error)
+	ExecuteAction(context.Context, *ExecuteActionRequest) (*ActionResult, error)
+	GetPerformance(context.Context, *GetPerformanceRequest) (*Performance, error)
+	// WatchAgentEvents transmite os eventos de agentes e simulações à medida
+	// que são publicados, com os mesmos filtros de tópico do websocket.
+	WatchAgentEvents(*WatchAgentEventsRequest, AgentService_WatchAgentEventsServer) error
+	mustEmbedUnimplementedAgentServiceServer()
+}
+
+// UnimplementedAgentServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedAgentServiceServer struct {
+}
+
+func (UnimplementedAgentServiceServer) ListAgents(context.Context, *ListAgentsRequest) (*ListAgentsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListAgents not implemented")
+}
+func (UnimplementedAgentServiceServer) GetAgent(context.Context, *GetAgentRequest) (*Agent, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetAgent not implemented")
+}
+func (UnimplementedAgentServiceServer) CreateAgent(context.Context, *CreateAgentRequest) (*Agent, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateAgent not implemented")
+}
+func (UnimplementedAgentServiceServer) UpdateAgent(context.Context, *UpdateAgentRequest) (*Agent, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateAgent not implemented")
+}
+func (UnimplementedAgentServiceServer) DeleteAgent(context.Context, *DeleteAgentRequest) (*DeleteAgentResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteAgent not implemented")
+}
+func (UnimplementedAgentServiceServer) ExecuteAction(context.Context, *ExecuteActionRequest) (*ActionResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ExecuteAction not implemented")
+}
+func (UnimplementedAgentServiceServer) GetPerformance(context.Context, *GetPerformanceRequest) (*Performance, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetPerformance not implemented")
+}
+func (UnimplementedAgentServiceServer) WatchAgentEvents(*WatchAgentEventsRequest, AgentService_WatchAgentEventsServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchAgentEvents not implemented")
+}
+func (UnimplementedAgentServiceServer) mustEmbedUnimplementedAgentServiceServer() {}
+
+// UnsafeAgentServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AgentServiceServer will
+// result in compilation errors.
+type UnsafeAgentServiceServer interface {
+	mustEmbedUnimplementedAgentServiceServer()
+}
+
+func RegisterAgentServiceServer(s grpc.ServiceRegistrar, srv AgentServiceServer) {
+	s.RegisterService(&AgentService_ServiceDesc, srv)
+}
+
+func _AgentService_ListAgents_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListAgentsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServiceServer).ListAgents(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AgentService_ListAgents_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServiceServer).ListAgents(ctx, req.(*ListAgentsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AgentService_GetAgent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetAgentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServiceServer).GetAgent(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AgentService_GetAgent_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServiceServer).GetAgent(ctx, req.(*GetAgentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AgentService_CreateAgent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateAgentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServiceServer).CreateAgent(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AgentService_CreateAgent_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServiceServer).CreateAgent(ctx, req.(*CreateAgentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AgentService_UpdateAgent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateAgentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServiceServer).UpdateAgent(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AgentService_UpdateAgent_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServiceServer).UpdateAgent(ctx, req.(*UpdateAgentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AgentService_DeleteAgent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteAgentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServiceServer).DeleteAgent(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AgentService_DeleteAgent_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServiceServer).DeleteAgent(ctx, req.(*DeleteAgentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AgentService_ExecuteAction_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExecuteActionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServiceServer).ExecuteAction(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AgentService_ExecuteAction_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServiceServer).ExecuteAction(ctx, req.(*ExecuteActionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AgentService_GetPerformance_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPerformanceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServiceServer).GetPerformance(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: AgentService_GetPerformance_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServiceServer).GetPerformance(ctx, req.(*GetPerformanceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AgentService_WatchAgentEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchAgentEventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AgentServiceServer).WatchAgentEvents(m, &agentServiceWatchAgentEventsServer{stream})
+}
+
+type AgentService_WatchAgentEventsServer interface {
+	Send(*AgentEvent) error
+	grpc.ServerStream
+}
+
+type agentServiceWatchAgentEventsServer struct {
+	grpc.ServerStream
+}
+
+func (x *agentServiceWatchAgentEventsServer) Send(m *AgentEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// AgentService_ServiceDesc is the grpc.ServiceDesc for AgentService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var AgentService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "smartcity.agent.v1.AgentService",
+	HandlerType: (*AgentServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListAgents",
+			Handler:    _AgentService_ListAgents_Handler,
+		},
+		{
+			MethodName: "GetAgent",
+			Handler:    _AgentService_GetAgent_Handler,
+		},
+		{
+			MethodName: "CreateAgent",
+			Handler:    _AgentService_CreateAgent_Handler,
+		},
+		{
+			MethodName: "UpdateAgent",
+			Handler:    _AgentService_UpdateAgent_Handler,
+		},
+		{
+			MethodName: "DeleteAgent",
+			Handler:    _AgentService_DeleteAgent_Handler,
+		},
+		{
+			MethodName: "ExecuteAction",
+			Handler:    _AgentService_ExecuteAction_Handler,
+		},
+		{
+			MethodName: "GetPerformance",
+			Handler:    _AgentService_GetPerformance_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchAgentEvents",
+			Handler:       _AgentService_WatchAgentEvents_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "agent/v1/agent.proto",
+}
+
+const (
+	SimulationService_ListSimulations_FullMethodName  = "/smartcity.agent.v1.SimulationService/ListSimulations"
+	SimulationService_GetSimulation_FullMethodName    = "/smartcity.agent.v1.SimulationService/GetSimulation"
+	SimulationService_CreateSimulation_FullMethodName = "/smartcity.agent.v1.SimulationService/CreateSimulation"
+	SimulationService_StartSimulation_FullMethodName  = "/smartcity.agent.v1.SimulationService/StartSimulation"
+	SimulationService_StopSimulation_FullMethodName   = "/smartcity.agent.v1.SimulationService/StopSimulation"
+)
+
+// SimulationServiceClient is the client API for SimulationService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type SimulationServiceClient interface {
+	ListSimulations(ctx context.Context, in *ListSimulationsRequest, opts ...grpc.CallOption) (*ListSimulationsResponse, error)
+	GetSimulation(ctx context.Context, in *GetSimulationRequest, opts ...grpc.CallOption) (*Simulation, error)
+	CreateSimulation(ctx context.Context, in *CreateSimulationRequest, opts ...grpc.CallOption) (*Simulation, error)
+	StartSimulation(ctx context.Context, in *StartSimulationRequest, opts ...grpc.CallOption) (*Simulation, error)
+	StopSimulation(ctx context.Context, in *StopSimulationRequest, opts ...grpc.CallOption) (*Simulation, error)
+}
+
+type simulationServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewSimulationServiceClient(cc grpc.ClientConnInterface) SimulationServiceClient {
+	return &simulationServiceClient{cc}
+}
+
+func (c *simulationServiceClient) ListSimulations(ctx context.Context, in *ListSimulationsRequest, opts ...grpc.CallOption) (*ListSimulationsResponse, error) {
+	out := new(ListSimulationsResponse)
+	err := c.cc.Invoke(ctx, SimulationService_ListSimulations_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *simulationServiceClient) GetSimulation(ctx context.Context, in *GetSimulationRequest, opts ...grpc.CallOption) (*Simulation, error) {
+	out := new(Simulation)
+	err := c.cc.Invoke(ctx, SimulationService_GetSimulation_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *simulationServiceClient) CreateSimulation(ctx context.Context, in *CreateSimulationRequest, opts ...grpc.CallOption) (*Simulation, error) {
+	out := new(Simulation)
+	err := c.cc.Invoke(ctx, SimulationService_CreateSimulation_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *simulationServiceClient) StartSimulation(ctx context.Context, in *StartSimulationRequest, opts ...grpc.CallOption) (*Simulation, error) {
+	out := new(Simulation)
+	err := c.cc.Invoke(ctx, SimulationService_StartSimulation_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *simulationServiceClient) StopSimulation(ctx context.Context, in *StopSimulationRequest, opts ...grpc.CallOption) (*Simulation, error) {
+	out := new(Simulation)
+	err := c.cc.Invoke(ctx, SimulationService_StopSimulation_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SimulationServiceServer is the server API for SimulationService service.
+// All implementations must embed UnimplementedSimulationServiceServer
+// for forward compatibility
+type SimulationServiceServer interface {
+	ListSimulations(context.Context, *ListSimulationsRequest) (*ListSimulationsResponse, error)
+	GetSimulation(context.Context, *GetSimulationRequest) (*Simulation, error)
+	CreateSimulation(context.Context, *CreateSimulationRequest) (*Simulation, error)
+	StartSimulation(context.Context, *StartSimulationRequest) (*Simulation, error)
+	StopSimulation(context.Context, *StopSimulationRequest) (*Simulation, error)
+	mustEmbedUnimplementedSimulationServiceServer()
+}
+
+// UnimplementedSimulationServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedSimulationServiceServer struct {
+}
+
+func (UnimplementedSimulationServiceServer) ListSimulations(context.Context, *ListSimulationsRequest) (*ListSimulationsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSimulations not implemented")
+}
+func (UnimplementedSimulationServiceServer) GetSimulation(context.Context, *GetSimulationRequest) (*Simulation, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetSimulation not implemented")
+}
+func (UnimplementedSimulationServiceServer) CreateSimulation(context.Context, *CreateSimulationRequest) (*Simulation, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateSimulation not implemented")
+}
+func (UnimplementedSimulationServiceServer) StartSimulation(context.Context, *StartSimulationRequest) (*Simulation, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StartSimulation not implemented")
+}
+func (UnimplementedSimulationServiceServer) StopSimulation(context.Context, *StopSimulationRequest) (*Simulation, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StopSimulation not implemented")
+}
+func (UnimplementedSimulationServiceServer) mustEmbedUnimplementedSimulationServiceServer() {}
+
+// UnsafeSimulationServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to SimulationServiceServer will
+// result in compilation errors.
+type UnsafeSimulationServiceServer interface {
+	mustEmbedUnimplementedSimulationServiceServer()
+}
+
+func RegisterSimulationServiceServer(s grpc.ServiceRegistrar, srv SimulationServiceServer) {
+	s.RegisterService(&SimulationService_ServiceDesc, srv)
+}
+
+func _SimulationService_ListSimulations_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListSimulationsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SimulationServiceServer).ListSimulations(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SimulationService_ListSimulations_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SimulationServiceServer).ListSimulations(ctx, req.(*ListSimulationsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SimulationService_GetSimulation_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetSimulationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SimulationServiceServer).GetSimulation(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SimulationService_GetSimulation_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SimulationServiceServer).GetSimulation(ctx, req.(*GetSimulationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SimulationService_CreateSimulation_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateSimulationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SimulationServiceServer).CreateSimulation(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SimulationService_CreateSimulation_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SimulationServiceServer).CreateSimulation(ctx, req.(*CreateSimulationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SimulationService_StartSimulation_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StartSimulationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SimulationServiceServer).StartSimulation(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SimulationService_StartSimulation_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SimulationServiceServer).StartSimulation(ctx, req.(*StartSimulationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _SimulationService_StopSimulation_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StopSimulationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SimulationServiceServer).StopSimulation(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: SimulationService_StopSimulation_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SimulationServiceServer).StopSimulation(ctx, req.(*StopSimulationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// SimulationService_ServiceDesc is the grpc.ServiceDesc for SimulationService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var SimulationService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "smartcity.agent.v1.SimulationService",
+	HandlerType: (*SimulationServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListSimulations",
+			Handler:    _SimulationService_ListSimulations_Handler,
+		},
+		{
+			MethodName: "GetSimulation",
+			Handler:    _SimulationService_GetSimulation_Handler,
+		},
+		{
+			MethodName: "CreateSimulation",
+			Handler:    _SimulationService_CreateSimulation_Handler,
+		},
+		{
+			MethodName: "StartSimulation",
+			Handler:    _SimulationService_StartSimulation_Handler,
+		},
+		{
+			MethodName: "StopSimulation",
+			Handler:    _SimulationService_StopSimulation_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "agent/v1/agent.proto",
+}
@@ -0,0 +1,4 @@
+// Package agentv1 contém o código gerado a partir de agent.proto.
+package agentv1
+
+//go:generate protoc -I ../.. --go_out=../.. --go_opt=paths=source_relative --go-grpc_out=../.. --go-grpc_opt=paths=source_relative agent/v1/agent.proto